@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/andyfusniak/squishy-mailer-lite/entity"
+)
+
+func apiKeyCreate(ctx context.Context, args []string) error {
+	fs, g := newFlagSet("api-key create", "--project <id>[,<id>...] --role <role> [flags]")
+	projects := fs.String("project", "", "comma separated ids of the projects the key is scoped to")
+	role := fs.String("role", "", "admin, sender or read_only")
+	name := fs.String("name", "", "a name to recognise the key by")
+	if err := parseFlags(fs, args, "project", "role"); err != nil {
+		return err
+	}
+
+	svc, _, err := g.openService()
+	if err != nil {
+		return err
+	}
+	defer svc.Close()
+
+	var projectIDs []string
+	for _, id := range strings.Split(*projects, ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			projectIDs = append(projectIDs, id)
+		}
+	}
+	k, err := svc.CreateAPIKey(ctx, entity.CreateAPIKeyParams{
+		Name:       *name,
+		Role:       entity.APIKeyRole(*role),
+		ProjectIDs: projectIDs,
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("created api key %s\n", k.ID)
+	fmt.Printf("key: %s\n", k.Key)
+	fmt.Println("the key is not shown again")
+	return nil
+}
+
+func apiKeyList(ctx context.Context, args []string) error {
+	fs, g := newFlagSet("api-key list", "[flags]")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	svc, _, err := g.openService()
+	if err != nil {
+		return err
+	}
+	defer svc.Close()
+
+	keys, err := svc.ListAPIKeys(ctx)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tNAME\tROLE\tPROJECTS\tCREATED")
+	for _, k := range keys {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", k.ID, k.Name, k.Role,
+			strings.Join(k.ProjectIDs, ","), formatTime(k.CreatedAt))
+	}
+	return w.Flush()
+}
+
+func apiKeyRevoke(ctx context.Context, args []string) error {
+	fs, g := newFlagSet("api-key revoke", "--id <id> [flags]")
+	id := fs.String("id", "", "api key id")
+	if err := parseFlags(fs, args, "id"); err != nil {
+		return err
+	}
+
+	svc, _, err := g.openService()
+	if err != nil {
+		return err
+	}
+	defer svc.Close()
+
+	if err := svc.RevokeAPIKey(ctx, *id); err != nil {
+		return err
+	}
+	fmt.Printf("revoked api key %s\n", *id)
+	return nil
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"context"
+	"fmt"
+)
+
+func backup(ctx context.Context, args []string) error {
+	fs, g := newFlagSet("backup", "--file <path> [flags]")
+	file := fs.String("file", "", "path to write the copy of the database to")
+	if err := parseFlags(fs, args, "file"); err != nil {
+		return err
+	}
+
+	svc, _, err := g.openService()
+	if err != nil {
+		return err
+	}
+	defer svc.Close()
+
+	if err := svc.Backup(ctx, *file); err != nil {
+		return err
+	}
+	fmt.Printf("backed up database to %s\n", *file)
+	return nil
+}
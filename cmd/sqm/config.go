@@ -0,0 +1,171 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/andyfusniak/squishy-mailer-lite/service"
+)
+
+// config holds the settings shared by the commands: the service settings
+// read by service.LoadConfig and those used only by sqm. They are read
+// from the YAML or TOML config file, then the environment, then the command
+// line, each overriding the last.
+type config struct {
+	// DB (env SQM_DB), EncryptionKey (env SQM_ENCRYPTION_KEY) and the
+	// encryption passphrase (env SQM_ENCRYPTION_PASSPHRASE) are among the
+	// service settings.
+	service.Config `yaml:",inline"`
+
+	// APIKeys are the bearer tokens accepted by sqm serve. Environment:
+	// SQM_API_KEYS, comma separated.
+	APIKeys []string `yaml:"api_keys" toml:"api_keys"`
+
+	// Addr is the address sqm serve listens on.
+	Addr string `yaml:"addr" toml:"addr"`
+
+	// SMTP configures the SMTP listener of sqm serve.
+	SMTP smtpConfig `yaml:"smtp" toml:"smtp"`
+}
+
+// smtpConfig configures the SMTP listener that accepts email from
+// applications that can only send using SMTP. It is off unless Addr is set.
+type smtpConfig struct {
+	// Addr is the address the listener listens on, for example :587.
+	Addr string `yaml:"addr" toml:"addr"`
+
+	// Hostname is the name the listener greets clients with. It defaults
+	// to the host name of the machine.
+	Hostname string `yaml:"hostname" toml:"hostname"`
+
+	// TLSCertFile and TLSKeyFile are the PEM encoded certificate and key
+	// used for STARTTLS. If they are set clients must use STARTTLS before
+	// authenticating.
+	TLSCertFile string `yaml:"tls_cert_file" toml:"tls_cert_file"`
+	TLSKeyFile  string `yaml:"tls_key_file" toml:"tls_key_file"`
+
+	// MaxMessageBytes is the largest message accepted, 32 MiB if zero.
+	MaxMessageBytes int64 `yaml:"max_message_bytes" toml:"max_message_bytes"`
+}
+
+// globalFlags are the flags accepted by every command.
+type globalFlags struct {
+	configPath string
+	db         string
+}
+
+// newFlagSet returns the flag set of a command with the global flags
+// registered. synopsis follows the command name in the usage message.
+func newFlagSet(name, synopsis string) (*flag.FlagSet, *globalFlags) {
+	var g globalFlags
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.StringVar(&g.configPath, "config", "", "path of the YAML or TOML config file (env SQM_CONFIG)")
+	fs.StringVar(&g.db, "db", "", "path of the SQLite3 database (env SQM_DB, default mailer.db)")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: sqm %s %s\n\nflags:\n", name, synopsis)
+		fs.PrintDefaults()
+	}
+	return fs, &g
+}
+
+// load reads the config file, if any, and applies the environment and the
+// global flags to it.
+func (g *globalFlags) load() (*config, error) {
+	var cfg config
+	path := g.configPath
+	if path == "" {
+		path = os.Getenv("SQM_CONFIG")
+	}
+	if path != "" {
+		if err := service.DecodeConfigFile(path, &cfg); err != nil {
+			return nil, err
+		}
+	}
+
+	if v := os.Getenv("SQM_DB"); v != "" {
+		cfg.DB = v
+	}
+	if v := os.Getenv("SQM_ENCRYPTION_KEY"); v != "" {
+		cfg.EncryptionKey = v
+		cfg.EncryptionKeyFile = ""
+		cfg.EncryptionKeyEnv = ""
+		cfg.EncryptionPassphraseFile = ""
+		cfg.EncryptionPassphraseEnv = ""
+	} else if os.Getenv("SQM_ENCRYPTION_PASSPHRASE") != "" {
+		cfg.EncryptionKey = ""
+		cfg.EncryptionKeyFile = ""
+		cfg.EncryptionKeyEnv = ""
+		cfg.EncryptionPassphraseFile = ""
+		cfg.EncryptionPassphraseEnv = "SQM_ENCRYPTION_PASSPHRASE"
+	}
+	if v := os.Getenv("SQM_API_KEYS"); v != "" {
+		cfg.APIKeys = strings.Split(v, ",")
+	}
+	if g.db != "" {
+		cfg.DB = g.db
+	}
+	return &cfg, nil
+}
+
+// openService loads the config and opens the email service.
+func (g *globalFlags) openService(extra ...service.Option) (*service.Service, *config, error) {
+	cfg, err := g.load()
+	if err != nil {
+		return nil, nil, err
+	}
+	if cfg.EncryptionKey == "" && cfg.EncryptionKeyFile == "" && cfg.EncryptionKeyEnv == "" &&
+		cfg.EncryptionPassphraseFile == "" && cfg.EncryptionPassphraseEnv == "" && cfg.KMS.Provider == "" {
+		return nil, nil, usagef("no encryption key: set SQM_ENCRYPTION_KEY, SQM_ENCRYPTION_PASSPHRASE or " +
+			"encryption_key, encryption_key_file, encryption_key_env, encryption_passphrase_file, " +
+			"encryption_passphrase_env or kms in the config file")
+	}
+	opts, err := cfg.Options()
+	if err != nil {
+		return nil, nil, err
+	}
+	svc, err := service.NewEmailService(append(opts, extra...)...)
+	if err != nil {
+		return nil, nil, err
+	}
+	return svc, cfg, nil
+}
+
+// parseFlags parses args and checks that the named flags were given a
+// value.
+func parseFlags(fs *flag.FlagSet, args []string, required ...string) error {
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return usagef("unexpected argument %q", fs.Arg(0))
+	}
+	for _, name := range required {
+		if f := fs.Lookup(name); f != nil && f.Value.String() == "" {
+			return usagef("sqm %s: --%s is required", fs.Name(), name)
+		}
+	}
+	return nil
+}
+
+// flagSet reports whether the named flag was given on the command line.
+func flagSet(fs *flag.FlagSet, name string) bool {
+	var set bool
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
+// stringList is a flag that may be repeated, each use appending a value.
+type stringList []string
+
+func (l *stringList) String() string { return strings.Join(*l, ",") }
+
+func (l *stringList) Set(v string) error {
+	*l = append(*l, v)
+	return nil
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/andyfusniak/squishy-mailer-lite/entity"
+)
+
+func contactsImport(ctx context.Context, args []string) error {
+	fs, g := newFlagSet("contacts import", "--project <id> --list <id> --file <path> [flags]")
+	project := fs.String("project", "", "project id")
+	list := fs.String("list", "", "contact list id, created if it does not exist")
+	name := fs.String("name", "", "name of the contact list if it is created")
+	file := fs.String("file", "", "CSV file with a header row and an email column")
+	if err := parseFlags(fs, args, "project", "list", "file"); err != nil {
+		return err
+	}
+
+	f, err := os.Open(*file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	svc, _, err := g.openService()
+	if err != nil {
+		return err
+	}
+	defer svc.Close()
+
+	if _, err := svc.GetContactList(ctx, *project, *list); err != nil {
+		var serr *entity.ServiceError
+		if !errors.As(err, &serr) || serr.Code != entity.ErrContactListNotFoundCode {
+			return err
+		}
+		if _, err := svc.CreateContactList(ctx, entity.CreateContactListParams{
+			ID:        *list,
+			ProjectID: *project,
+			Name:      *name,
+		}); err != nil {
+			return err
+		}
+		fmt.Printf("created contact list %s\n", *list)
+	}
+	n, err := svc.ImportContactsCSV(ctx, *project, *list, f)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("imported %d contacts into %s\n", n, *list)
+	return nil
+}
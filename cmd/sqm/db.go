@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"fmt"
+)
+
+func dbStatus(ctx context.Context, args []string) error {
+	fs, g := newFlagSet("db status", "[flags]")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	svc, _, err := g.openService()
+	if err != nil {
+		return err
+	}
+	defer svc.Close()
+
+	v, err := svc.SchemaVersion(ctx)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("version: %d\n", v.Version)
+	fmt.Printf("latest:  %d\n", v.Latest)
+	switch {
+	case v.Dirty:
+		fmt.Printf("state:   dirty, repair migration %d by hand then run sqm db force\n", v.Version)
+	case v.Version < v.Latest:
+		fmt.Printf("state:   %d migrations pending, run sqm db migrate\n", v.Latest-v.Version)
+	case v.Version > v.Latest:
+		fmt.Println("state:   newer than this version of sqm")
+	default:
+		fmt.Println("state:   up to date")
+	}
+	return nil
+}
+
+func dbMigrate(ctx context.Context, args []string) error {
+	fs, g := newFlagSet("db migrate", "[--to <version>] [flags]")
+	to := fs.Uint("to", 0, "version to migrate up or down to; 0 removes every migration (default the latest)")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	svc, _, err := g.openService()
+	if err != nil {
+		return err
+	}
+	defer svc.Close()
+
+	if !flagSet(fs, "to") {
+		v, err := svc.SchemaVersion(ctx)
+		if err != nil {
+			return err
+		}
+		*to = v.Latest
+	}
+	if err := svc.MigrateTo(ctx, *to); err != nil {
+		return err
+	}
+	fmt.Printf("schema at version %d\n", *to)
+	return nil
+}
+
+func dbRollback(ctx context.Context, args []string) error {
+	fs, g := newFlagSet("db rollback", "[--steps <n>] [flags]")
+	steps := fs.Uint("steps", 1, "number of migrations to roll back")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	svc, _, err := g.openService()
+	if err != nil {
+		return err
+	}
+	defer svc.Close()
+
+	v, err := svc.SchemaVersion(ctx)
+	if err != nil {
+		return err
+	}
+	if *steps > v.Version {
+		return usagef("--steps %d is more than the %d migrations applied", *steps, v.Version)
+	}
+	// migration versions are consecutive
+	target := v.Version - *steps
+	if err := svc.MigrateTo(ctx, target); err != nil {
+		return err
+	}
+	fmt.Printf("schema at version %d\n", target)
+	return nil
+}
+
+func dbForce(ctx context.Context, args []string) error {
+	fs, g := newFlagSet("db force", "--version <version> [flags]")
+	version := fs.Uint("version", 0, "version to record as applied, clearing the dirty flag")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if !flagSet(fs, "version") {
+		return usagef("sqm db force: --version is required")
+	}
+
+	svc, _, err := g.openService()
+	if err != nil {
+		return err
+	}
+	defer svc.Close()
+
+	if err := svc.ForceSchemaVersion(ctx, *version); err != nil {
+		return err
+	}
+	fmt.Printf("schema version set to %d\n", *version)
+	return nil
+}
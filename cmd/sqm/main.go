@@ -3,113 +3,187 @@ package main
 import (
 	"context"
 	"errors"
+	"flag"
 	"fmt"
 	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/andyfusniak/squishy-mailer-lite/entity"
+)
 
-	"github.com/andyfusniak/squishy-mailer-lite/service"
+var (
+	version   = "dev"
+	gitCommit = "unknown"
 )
 
-func main() {
-	if err := run(); err != nil {
-		fmt.Fprintf(os.Stderr, "%+v\n", err)
-		os.Exit(1)
-	}
+// command is a node in the sqm command tree. Commands either run or
+// dispatch to their subcommands.
+type command struct {
+	name        string
+	summary     string
+	run         func(ctx context.Context, args []string) error
+	subcommands []*command
 }
 
-func run() error {
-	const fakeKey string = "a0bf305856098eba7e4bff506021648b"
-	svc, err := service.NewEmailService(
-		service.WithHexEncodedEncryptionKey(fakeKey),
-	)
+var root = &command{
+	name: "sqm",
+	subcommands: []*command{
+		{
+			name:    "project",
+			summary: "manage projects",
+			subcommands: []*command{
+				{name: "create", summary: "create a project", run: projectCreate},
+				{name: "list", summary: "list projects", run: projectList},
+				{name: "vars", summary: "list, set or unset the variables shared by a project's templates", run: projectVars},
+				{name: "export", summary: "write a project and its transports and templates to a file", run: projectExport},
+				{name: "import", summary: "create a project from a file written by project export", run: projectImport},
+			},
+		},
+		{
+			name:    "transport",
+			summary: "manage transports",
+			subcommands: []*command{
+				{name: "create", summary: "create an SMTP transport", run: transportCreate},
+				{name: "verify", summary: "check a transport can connect and authenticate", run: transportVerify},
+			},
+		},
+		{
+			name:    "template",
+			summary: "manage templates",
+			subcommands: []*command{
+				{name: "push", summary: "create or update templates from a directory", run: templatePush},
+				{name: "pull", summary: "write the templates of a project to a directory", run: templatePull},
+				{name: "lint", summary: "check a template for constructs that break in email clients", run: templateLint},
+				{name: "search", summary: "list the templates that contain a phrase or param", run: templateSearch},
+				{name: "render", summary: "print a template rendered with params or a fixture", run: templateRender},
+				{name: "fixture", summary: "set or delete a named set of sample params of a template", run: templateFixture},
+				{name: "fixtures", summary: "list the fixtures of a template", run: templateFixtures},
+			},
+		},
+		{
+			name:    "queue",
+			summary: "inspect and manage the mail queue",
+			subcommands: []*command{
+				{name: "list", summary: "list emails in the mail queue", run: queueList},
+				{name: "show", summary: "show an email and its delivery attempts", run: queueShow},
+				{name: "retry", summary: "requeue a failed email", run: queueRetry},
+				{name: "requeue", summary: "requeue a dead letter with its attempts reset", run: queueRequeue},
+				{name: "cancel", summary: "cancel a queued email", run: queueCancel},
+				{name: "prune", summary: "archive and delete emails that finished delivery", run: queuePrune},
+			},
+		},
+		{
+			name:    "contacts",
+			summary: "manage contact lists",
+			subcommands: []*command{
+				{name: "import", summary: "add contacts to a list from a CSV file", run: contactsImport},
+			},
+		},
+		{
+			name:    "api-key",
+			summary: "manage project scoped API keys",
+			subcommands: []*command{
+				{name: "create", summary: "create an API key", run: apiKeyCreate},
+				{name: "list", summary: "list API keys", run: apiKeyList},
+				{name: "revoke", summary: "revoke an API key", run: apiKeyRevoke},
+			},
+		},
+		{
+			name:    "db",
+			summary: "manage the database schema",
+			subcommands: []*command{
+				{name: "status", summary: "show the schema version and pending migrations", run: dbStatus},
+				{name: "migrate", summary: "apply migrations up or down to a version", run: dbMigrate},
+				{name: "rollback", summary: "undo the most recent migrations", run: dbRollback},
+				{name: "force", summary: "set the schema version after repairing a failed migration", run: dbForce},
+			},
+		},
+		{name: "send", summary: "send an email", run: send},
+		{name: "send-bulk", summary: "queue a personalised email per row of a CSV file", run: sendBulk},
+		{name: "backup", summary: "copy the database while it is in use", run: backup},
+		{name: "serve", summary: "run the JSON REST API server", run: serve},
+		{name: "version", summary: "print the version", run: printVersion},
+	},
+}
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	err := root.execute(ctx, os.Args[1:])
+	stop()
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	}
+	var uerr usageError
+	if errors.As(err, &uerr) {
+		fmt.Fprintf(os.Stderr, "sqm: %s\n", uerr.msg)
+		os.Exit(2)
+	}
 	if err != nil {
-		return err
+		fmt.Fprintf(os.Stderr, "sqm: %s\n", errorMessage(err))
+		os.Exit(1)
 	}
+}
 
-	// create a new project to test the system
-	// if the project already exists then get the existing project
-	ctx := context.Background()
-	project, err := svc.CreateProject(ctx,
-		"the-cloud-project",
-		"The Cloud Project",
-		"The Cloud Company transactional emails.")
-	if err != nil {
-		var e *entity.ServiceError
-		if errors.As(err, &e) {
-			if e.Code == entity.ErrProjectAlreadyExistsCode {
-				project, err = svc.GetProject(ctx, "the-cloud-project")
-				if err != nil {
-					return err
-				}
-			}
-		} else {
-			return err
+// errorMessage returns the message printed for err. Service errors only
+// describe their code, so the underlying cause is added unless it says the
+// same thing.
+func errorMessage(err error) string {
+	var serr *entity.ServiceError
+	if errors.As(err, &serr) {
+		if cause := errors.Unwrap(serr); cause != nil && cause.Error() != serr.Error() {
+			return fmt.Sprintf("%v: %v", serr, cause)
 		}
+		return serr.Error()
 	}
+	return err.Error()
+}
 
-	// _, err = svc.CreateSMTPTransport(ctx, entity.CreateSMTPTransport{
-	// 	ID:            "the-cloud-transport",
-	// 	ProjectID:     project.ID,
-	// 	Name:          "Squishy Mailer Lite Transport",
-	// 	Host:          "email-smtp.us-east-1.amazonaws.com",
-	// 	Port:          587,
-	// 	Username:      "<username>",
-	// 	Password:      os.Getenv("SQUISHY_MAILER_LITE_SMTP_PASSWORD"),
-	// 	EmailFrom:     "support@ravenmailer.com",
-	// 	EmailFromName: "Raven Mailer Support",
-	// 	EmailReplyTo:  []string{"support@ravenmailer.com"},
-	// })
-	// if err != nil {
-	// 	return err
-	// }
+// usageError is returned for a command line that cannot be run. It is
+// reported without a stack trace.
+type usageError struct {
+	msg string
+}
 
-	// group, err := svc.CreateGroup(ctx, "g1", project.ID, "Group One")
-	// if err != nil {
-	// 	return err
-	// }
+func (e usageError) Error() string { return e.msg }
 
-	// template, err := svc.CreateTemplate(ctx, entity.CreateTemplate{
-	// 	ID:        "t1",
-	// 	ProjectID: project.ID,
-	// 	GroupID:   group.ID,
-	// 	HTML:      "<h1>Welcome {{.firstname}}, to the Cloud</h1>",
-	// 	Text:      "Welcome {{.firstname}}, to the Cloud",
-	// })
-	// if err != nil {
-	// 	return err
-	// }
+func usagef(format string, args ...any) error {
+	return usageError{msg: fmt.Sprintf(format, args...)}
+}
 
-	template, err := svc.SetTemplateFromFiles(ctx, entity.CreateTemplateFromFiles{
-		ID:        "t1",
-		ProjectID: project.ID,
-		GroupID:   "g1",
-		HTMLFilenames: []string{
-			"./service/testdata/email/templates/layout.html",
-			"./service/testdata/email/templates/welcome.html",
-		},
-		TxtFilenames: []string{
-			"./service/testdata/email/templates/layout.txt",
-			"./service/testdata/email/templates/welcome.txt",
-		},
-	})
-	if err != nil {
-		return err
+func (c *command) execute(ctx context.Context, args []string) error {
+	return c.executePath(ctx, c.name, args)
+}
+
+func (c *command) executePath(ctx context.Context, path string, args []string) error {
+	if c.run != nil {
+		return c.run(ctx, args)
+	}
+	if len(args) == 0 || args[0] == "-h" || args[0] == "-help" || args[0] == "--help" || args[0] == "help" {
+		c.printUsage(path)
+		if len(args) == 0 {
+			return usagef("missing command")
+		}
+		return flag.ErrHelp
+	}
+	for _, sub := range c.subcommands {
+		if sub.name == args[0] {
+			return sub.executePath(ctx, path+" "+sub.name, args[1:])
+		}
 	}
+	c.printUsage(path)
+	return usagef("unknown command %q", args[0])
+}
 
-	// send a test email
-	if err := svc.SendEmail(ctx, entity.SendEmailParams{
-		TemplateID:  template.ID,
-		ProjectID:   project.ID,
-		TransportID: "the-cloud-transport",
-		To:          []string{"andy@andyfusniak.com"},
-		Subject:     "My test subject line",
-		TemplateParams: map[string]string{
-			"firstname": "Andy",
-		},
-	}); err != nil {
-		return err
+func (c *command) printUsage(path string) {
+	fmt.Fprintf(os.Stderr, "usage: %s <command> [flags]\n\ncommands:\n", path)
+	for _, sub := range c.subcommands {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", sub.name, sub.summary)
 	}
+	fmt.Fprintf(os.Stderr, "\nRun %s <command> -h for help on a command.\n", path)
+}
 
+func printVersion(ctx context.Context, args []string) error {
+	fmt.Printf("sqm %s (%s)\n", version, gitCommit)
 	return nil
 }
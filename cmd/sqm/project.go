@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/andyfusniak/squishy-mailer-lite/entity"
+)
+
+func projectCreate(ctx context.Context, args []string) error {
+	fs, g := newFlagSet("project create", "--id <id> --name <name> [flags]")
+	id := fs.String("id", "", "project id")
+	name := fs.String("name", "", "project name")
+	description := fs.String("description", "", "project description")
+	if err := parseFlags(fs, args, "id", "name"); err != nil {
+		return err
+	}
+
+	svc, _, err := g.openService()
+	if err != nil {
+		return err
+	}
+	defer svc.Close()
+
+	p, err := svc.CreateProject(ctx, *id, *name, *description)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("created project %s\n", p.ID)
+	return nil
+}
+
+func projectList(ctx context.Context, args []string) error {
+	fs, g := newFlagSet("project list", "[flags]")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	svc, _, err := g.openService()
+	if err != nil {
+		return err
+	}
+	defer svc.Close()
+
+	projects, err := svc.ListProjects(ctx, entity.ListProjectsParams{})
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tNAME\tDEFAULT TRANSPORT\tCREATED")
+	for _, p := range projects {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", p.ID, p.Name, p.DefaultTransportID,
+			formatTime(p.CreatedAt))
+	}
+	return w.Flush()
+}
+
+// formatTime formats a time for tabular output.
+func formatTime(t entity.ISOTime) string {
+	return time.Time(t).UTC().Format("2006-01-02 15:04:05")
+}
+
+// formatOptionalTime formats a time for tabular output, or returns an
+// empty string for the zero time.
+func formatOptionalTime(t entity.ISOTime) string {
+	if time.Time(t).IsZero() {
+		return ""
+	}
+	return formatTime(t)
+}
+
+// projectVars prints the variables of a project after applying any
+// --set and --unset flags. Templates use them as {{.Project.name}}.
+func projectVars(ctx context.Context, args []string) error {
+	fs, g := newFlagSet("project vars", "--project <id> [--set name=value] [--unset name]")
+	projectID := fs.String("project", "", "project id")
+	var set, unset stringList
+	fs.Var(&set, "set", "variable to set as name=value (repeatable)")
+	fs.Var(&unset, "unset", "variable to remove (repeatable)")
+	if err := parseFlags(fs, args, "project"); err != nil {
+		return err
+	}
+
+	svc, _, err := g.openService()
+	if err != nil {
+		return err
+	}
+	defer svc.Close()
+
+	p, err := svc.GetProject(ctx, *projectID)
+	if err != nil {
+		return err
+	}
+	if len(set) > 0 || len(unset) > 0 {
+		vars := maps.Clone(p.Variables)
+		if vars == nil {
+			vars = make(map[string]string)
+		}
+		for _, kv := range set {
+			name, value, ok := strings.Cut(kv, "=")
+			if !ok || name == "" {
+				return usagef("sqm project vars: --set %q is not name=value", kv)
+			}
+			vars[name] = value
+		}
+		for _, name := range unset {
+			delete(vars, name)
+		}
+		if p, err = svc.SetProjectVariables(ctx, *projectID, vars); err != nil {
+			return err
+		}
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tVALUE")
+	names := make([]string, 0, len(p.Variables))
+	for name := range p.Variables {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		fmt.Fprintf(w, "%s\t%s\n", name, p.Variables[name])
+	}
+	return w.Flush()
+}
+
+func projectExport(ctx context.Context, args []string) error {
+	fs, g := newFlagSet("project export", "--id <id> --file <path> [flags]")
+	id := fs.String("id", "", "project id")
+	file := fs.String("file", "", "file to write the export to")
+	if err := parseFlags(fs, args, "id", "file"); err != nil {
+		return err
+	}
+
+	svc, _, err := g.openService()
+	if err != nil {
+		return err
+	}
+	defer svc.Close()
+
+	// the export holds the transport secrets in plain text
+	f, err := os.OpenFile(*file, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	if err := svc.ExportProject(ctx, *id, f); err != nil {
+		f.Close()
+		os.Remove(*file)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	fmt.Printf("exported project %s to %s\n", *id, *file)
+	return nil
+}
+
+func projectImport(ctx context.Context, args []string) error {
+	fs, g := newFlagSet("project import", "--file <path> [flags]")
+	file := fs.String("file", "", "file written by project export")
+	if err := parseFlags(fs, args, "file"); err != nil {
+		return err
+	}
+
+	f, err := os.Open(*file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	svc, _, err := g.openService()
+	if err != nil {
+		return err
+	}
+	defer svc.Close()
+
+	p, err := svc.ImportProject(ctx, f)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("imported project %s\n", p.ID)
+	return nil
+}
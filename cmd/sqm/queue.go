@@ -0,0 +1,232 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/andyfusniak/squishy-mailer-lite/entity"
+	"github.com/andyfusniak/squishy-mailer-lite/service"
+)
+
+func queueList(ctx context.Context, args []string) error {
+	fs, g := newFlagSet("queue list", "--project <id> [flags]")
+	projectID := fs.String("project", "", "project id")
+	state := fs.String("state", "", "only list emails in this state, for example queued, failed or sent")
+	after := fs.String("after", "", "list the emails queued before this mail queue id")
+	limit := fs.Int("limit", 50, "maximum number of emails to list; 0 lists all")
+	if err := parseFlags(fs, args, "project"); err != nil {
+		return err
+	}
+
+	svc, _, err := g.openService()
+	if err != nil {
+		return err
+	}
+	defer svc.Close()
+
+	list, err := svc.ListMailQueue(ctx, entity.ListMailQueueParams{
+		ProjectID: *projectID,
+		State:     entity.MailState(*state),
+		After:     *after,
+		Limit:     *limit,
+	})
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tSTATE\tTEMPLATE\tTO\tATTEMPTS\tCREATED")
+	for _, mq := range list {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%s\n", mq.ID, mq.State, mq.TemplateID,
+			strings.Join(mq.To, ","), mq.Attempts, formatTime(mq.CreatedAt))
+	}
+	return w.Flush()
+}
+
+// queueShow prints the metadata, rendered body and delivery attempts of
+// an email.
+func queueShow(ctx context.Context, args []string) error {
+	fs, g := newFlagSet("queue show", "--project <id> --id <id> [flags]")
+	projectID := fs.String("project", "", "project id")
+	id := fs.String("id", "", "mail queue id")
+	if err := parseFlags(fs, args, "project", "id"); err != nil {
+		return err
+	}
+
+	svc, _, err := g.openService()
+	if err != nil {
+		return err
+	}
+	defer svc.Close()
+
+	mq, err := svc.GetMailQueue(ctx, *projectID, *id)
+	if err != nil {
+		return err
+	}
+	attempts, err := svc.ListMailQueueAttempts(ctx, *projectID, *id)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fields := []struct{ name, value string }{
+		{"ID", mq.ID},
+		{"State", string(mq.State)},
+		{"Template", mq.TemplateID},
+		{"Transport", mq.TransportID},
+		{"To", strings.Join(mq.To, ", ")},
+		{"Cc", strings.Join(mq.Cc, ", ")},
+		{"Bcc", strings.Join(mq.Bcc, ", ")},
+		{"Subject", mq.Subject},
+		{"Attempts", fmt.Sprint(mq.Attempts)},
+		{"Last error", mq.LastError},
+		{"Deferral reason", mq.DeferralReason},
+		{"Send at", formatTime(mq.SendAt)},
+		{"Next attempt at", formatTime(mq.NextAttemptAt)},
+		{"Sent", formatOptionalTime(mq.SentAt)},
+		{"Created", formatTime(mq.CreatedAt)},
+		{"Modified", formatTime(mq.ModifiedAt)},
+	}
+	for _, f := range fields {
+		if f.value != "" {
+			fmt.Fprintf(w, "%s:\t%s\n", f.name, f.value)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if mq.Redacted {
+		fmt.Println("\nThe body has been redacted by the retention policy.")
+	}
+	if mq.Text != "" {
+		fmt.Printf("\n--- text ---\n%s\n", mq.Text)
+	}
+	if mq.HTML != "" {
+		fmt.Printf("\n--- html ---\n%s\n", mq.HTML)
+	}
+
+	if len(attempts) == 0 {
+		return nil
+	}
+	fmt.Println("\n--- attempts ---")
+	w = tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ATTEMPT\tSTATE\tSTARTED\tDURATION\tCODE\tERROR")
+	for _, a := range attempts {
+		code := "-"
+		if a.ResponseCode != 0 {
+			code = fmt.Sprint(a.ResponseCode)
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\n", a.Attempt, a.State,
+			time.Time(a.StartedAt).UTC().Format(time.RFC3339),
+			time.Time(a.CreatedAt).Sub(time.Time(a.StartedAt)).Round(time.Millisecond),
+			code, a.Error)
+	}
+	return w.Flush()
+}
+
+func queueRetry(ctx context.Context, args []string) error {
+	fs, g := newFlagSet("queue retry", "--project <id> --id <id> [flags]")
+	projectID := fs.String("project", "", "project id")
+	id := fs.String("id", "", "mail queue id of a failed, dead_letter, blocked or bounced email")
+	if err := parseFlags(fs, args, "project", "id"); err != nil {
+		return err
+	}
+
+	svc, _, err := g.openService()
+	if err != nil {
+		return err
+	}
+	defer svc.Close()
+
+	mq, err := svc.RetryMailQueue(ctx, *projectID, *id)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("email %s queued\n", mq.ID)
+	return nil
+}
+
+func queueCancel(ctx context.Context, args []string) error {
+	fs, g := newFlagSet("queue cancel", "--project <id> --id <id> [flags]")
+	projectID := fs.String("project", "", "project id")
+	id := fs.String("id", "", "mail queue id of a queued email")
+	if err := parseFlags(fs, args, "project", "id"); err != nil {
+		return err
+	}
+
+	svc, _, err := g.openService()
+	if err != nil {
+		return err
+	}
+	defer svc.Close()
+
+	mq, err := svc.CancelMailQueue(ctx, *projectID, *id)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("email %s cancelled\n", mq.ID)
+	return nil
+}
+
+// queueRequeue returns a dead letter to the queue with its attempts reset,
+// optionally delivering it through a different transport.
+func queueRequeue(ctx context.Context, args []string) error {
+	fs, g := newFlagSet("queue requeue", "--project <id> --id <id> [flags]")
+	projectID := fs.String("project", "", "project id")
+	id := fs.String("id", "", "mail queue id of a dead_letter email")
+	transportID := fs.String("transport", "", "transport id to deliver the email with (default its current transport)")
+	if err := parseFlags(fs, args, "project", "id"); err != nil {
+		return err
+	}
+
+	svc, _, err := g.openService()
+	if err != nil {
+		return err
+	}
+	defer svc.Close()
+
+	mq, err := svc.RequeueDeadLetter(ctx, entity.RequeueDeadLetterParams{
+		ProjectID:   *projectID,
+		MailQueueID: *id,
+		TransportID: *transportID,
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("email %s queued using transport %s\n", mq.ID, mq.TransportID)
+	return nil
+}
+
+// queuePrune deletes the emails whose delivery finished before the given
+// age, archiving them first if an archive directory is set.
+func queuePrune(ctx context.Context, args []string) error {
+	fs, g := newFlagSet("queue prune", "--older-than <duration> [flags]")
+	olderThan := fs.Duration("older-than", 0, "prune emails that finished delivery longer ago than this, for example 720h")
+	archiveDir := fs.String("archive-dir", "", "directory to archive the emails to as gzip NDJSON (default maintenance.archive_dir)")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *olderThan <= 0 {
+		return usagef("--older-than must be a positive duration")
+	}
+
+	var opts []service.Option
+	if *archiveDir != "" {
+		opts = append(opts, service.WithMailArchiver(service.NewDirArchiver(*archiveDir)))
+	}
+	svc, _, err := g.openService(opts...)
+	if err != nil {
+		return err
+	}
+	defer svc.Close()
+
+	n, err := svc.PruneMailQueue(ctx, time.Now().Add(-*olderThan))
+	if err != nil {
+		return err
+	}
+	fmt.Printf("pruned %d emails\n", n)
+	return nil
+}
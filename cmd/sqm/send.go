@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/andyfusniak/squishy-mailer-lite/entity"
+	"github.com/andyfusniak/squishy-mailer-lite/service"
+	"github.com/pkg/errors"
+)
+
+// send queues an email and prints its mail queue id. With --wait it
+// processes the mail queue until the email is delivered or fails.
+func send(ctx context.Context, args []string) error {
+	fs, g := newFlagSet("send", "--project <id> --template <id> --to <address> [flags]")
+	projectID := fs.String("project", "", "project id")
+	templateID := fs.String("template", "", "template id")
+	transportID := fs.String("transport", "", "transport id (default the project's default transport)")
+	subject := fs.String("subject", "", "subject line (default the template's subject)")
+	params := fs.String("params", "", `template params as a JSON object, for example {"name":"Andy"}`)
+	paramsFile := fs.String("params-file", "", "file holding the template params as a JSON object, or - for stdin")
+	locale := fs.String("locale", "", "locale used to choose the template variant")
+	wait := fs.Bool("wait", false, "wait until the email is delivered or fails")
+	timeout := fs.Duration("timeout", 2*time.Minute, "how long --wait waits")
+	var to, cc, bcc, param, attach stringList
+	fs.Var(&to, "to", "recipient (repeatable)")
+	fs.Var(&cc, "cc", "cc recipient (repeatable)")
+	fs.Var(&bcc, "bcc", "bcc recipient (repeatable)")
+	fs.Var(&param, "param", "template param as name=value, overriding --params (repeatable)")
+	fs.Var(&attach, "attach", "file to attach (repeatable)")
+	if err := parseFlags(fs, args, "project", "template", "to"); err != nil {
+		return err
+	}
+
+	templateParams, err := sendParams(*params, *paramsFile, param)
+	if err != nil {
+		return err
+	}
+	var attachments []entity.EmailAttachment
+	for _, path := range attach {
+		attachments = append(attachments, entity.EmailAttachment{Path: path})
+	}
+
+	svc, _, err := g.openService()
+	if err != nil {
+		return err
+	}
+	defer svc.Close()
+
+	mq, err := svc.SendEmailAsync(ctx, entity.SendEmailParams{
+		TemplateID:     *templateID,
+		ProjectID:      *projectID,
+		TransportID:    *transportID,
+		To:             to,
+		Cc:             cc,
+		Bcc:            bcc,
+		Subject:        *subject,
+		TemplateParams: templateParams,
+		Locale:         *locale,
+		Attachments:    attachments,
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Println(mq.ID)
+	if !*wait {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, *timeout)
+	defer cancel()
+	mq, err = waitForDelivery(ctx, svc, mq)
+	if err != nil {
+		return err
+	}
+	if mq.State != entity.MailStateSent {
+		return errors.Errorf("email %s %s: %s", mq.ID, mq.State, mq.LastError)
+	}
+	fmt.Fprintf(os.Stderr, "email %s sent\n", mq.ID)
+	return nil
+}
+
+// sendParams merges the template params given as a JSON object, a JSON
+// file and name=value pairs, in that order.
+func sendParams(params, paramsFile string, pairs []string) (map[string]any, error) {
+	m := map[string]any{}
+	if paramsFile != "" {
+		var b []byte
+		var err error
+		if paramsFile == "-" {
+			b, err = io.ReadAll(os.Stdin)
+		} else {
+			b, err = os.ReadFile(paramsFile)
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "read params file failed")
+		}
+		if err := json.Unmarshal(b, &m); err != nil {
+			return nil, usagef("sqm send: --params-file is not a JSON object: %v", err)
+		}
+	}
+	if params != "" {
+		if err := json.Unmarshal([]byte(params), &m); err != nil {
+			return nil, usagef("sqm send: --params is not a JSON object: %v", err)
+		}
+	}
+	for _, p := range pairs {
+		name, value, ok := strings.Cut(p, "=")
+		if !ok || name == "" {
+			return nil, usagef("sqm send: --param %q is not name=value", p)
+		}
+		m[name] = value
+	}
+	return m, nil
+}
+
+// waitForDelivery processes the mail queue until mq leaves the queue. An
+// email that fails and is queued for a retry is waited for again.
+func waitForDelivery(ctx context.Context, svc *service.Service, mq *entity.MailQueue) (*entity.MailQueue, error) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		if _, err := svc.ProcessMailQueue(ctx); err != nil {
+			return nil, err
+		}
+		cur, err := svc.GetMailQueue(ctx, mq.ProjectID, mq.ID)
+		if err != nil {
+			return nil, err
+		}
+		switch cur.State {
+		case entity.MailStateQueued, entity.MailStateSending, entity.MailStateRateLimited:
+		default:
+			return cur, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, errors.Errorf("timed out waiting for email %s (%s)", mq.ID, cur.State)
+		case <-ticker.C:
+		}
+	}
+}
+
+// sendBulk queues one personalised email per row of a CSV file and prints
+// a summary of the emails queued, blocked and rejected.
+func sendBulk(ctx context.Context, args []string) error {
+	fs, g := newFlagSet("send-bulk", "--project <id> --template <id> --csv <path> [flags]")
+	projectID := fs.String("project", "", "project id")
+	templateID := fs.String("template", "", "template id")
+	transportID := fs.String("transport", "", "transport id (default the project's default transport)")
+	subject := fs.String("subject", "", "subject line (default the template's subject)")
+	csvFile := fs.String("csv", "", "CSV file with a header row, an email column and a column per template param, or - for stdin")
+	params := fs.String("params", "", "template params shared by every row as a JSON object")
+	var param stringList
+	fs.Var(&param, "param", "template param shared by every row as name=value (repeatable)")
+	if err := parseFlags(fs, args, "project", "template", "csv"); err != nil {
+		return err
+	}
+
+	templateParams, err := sendParams(*params, "", param)
+	if err != nil {
+		return err
+	}
+	r := io.Reader(os.Stdin)
+	if *csvFile != "-" {
+		f, err := os.Open(*csvFile)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	svc, _, err := g.openService()
+	if err != nil {
+		return err
+	}
+	defer svc.Close()
+
+	results, err := svc.SendEmailToManyCSVAsync(ctx, entity.SendEmailToManyParams{
+		TemplateID:     *templateID,
+		ProjectID:      *projectID,
+		TransportID:    *transportID,
+		Subject:        *subject,
+		TemplateParams: templateParams,
+	}, r)
+	if err != nil {
+		return err
+	}
+
+	var queued, blocked, rejected int
+	for i, res := range results {
+		switch {
+		case res.Err != nil:
+			rejected++
+			fmt.Fprintf(os.Stderr, "row %d: %s\n", i+1, errorMessage(res.Err))
+		case res.MailQueue.State == entity.MailStateBlocked:
+			blocked++
+			fmt.Fprintf(os.Stderr, "row %d: %s blocked: %s\n", i+1, strings.Join(res.MailQueue.To, ","),
+				res.MailQueue.LastError)
+		default:
+			queued++
+		}
+	}
+	fmt.Printf("%d rows: %d queued, %d blocked, %d rejected\n", len(results), queued, blocked, rejected)
+	if rejected > 0 {
+		return errors.Errorf("%d of %d emails could not be queued", rejected, len(results))
+	}
+	return nil
+}
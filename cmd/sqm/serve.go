@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/andyfusniak/squishy-mailer-lite/internal/httpapi"
+	"github.com/andyfusniak/squishy-mailer-lite/internal/smtpd"
+	"github.com/andyfusniak/squishy-mailer-lite/service"
+)
+
+// serve runs the REST API, and the SMTP listener if it is configured,
+// until the context is cancelled by SIGINT or SIGTERM.
+func serve(ctx context.Context, args []string) error {
+	fs, g := newFlagSet("serve", "[flags]")
+	addr := fs.String("addr", "", "address to listen on (default :8080)")
+	smtpAddr := fs.String("smtp-addr", "", "address the SMTP listener listens on, off if empty (default smtp.addr)")
+	poll := fs.Duration("poll-interval", 5*time.Second,
+		"how often the mail queue is processed; 0 disables the queue worker (default worker.poll_interval)")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	logger := slog.New(slog.NewTextHandler(os.Stderr, nil))
+	svc, cfg, err := g.openService(service.WithLogger(logger))
+	if err != nil {
+		return err
+	}
+	defer svc.Close()
+
+	var apiKeys []string
+	for _, k := range cfg.APIKeys {
+		if k = strings.TrimSpace(k); k != "" {
+			apiKeys = append(apiKeys, k)
+		}
+	}
+	if len(apiKeys) == 0 {
+		// minted keys alone are enough, though they cannot manage keys
+		minted, err := svc.ListAPIKeys(ctx)
+		if err != nil {
+			return err
+		}
+		if len(minted) == 0 {
+			return usagef("no API keys: set SQM_API_KEYS or api_keys in the config file, or create one using sqm api-key create")
+		}
+	}
+	if *addr == "" {
+		*addr = cfg.Addr
+	}
+	if cfg.Worker.PollInterval > 0 && !flagSet(fs, "poll-interval") {
+		*poll = time.Duration(cfg.Worker.PollInterval)
+	}
+	if *addr == "" {
+		*addr = ":8080"
+	}
+	if *smtpAddr == "" {
+		*smtpAddr = cfg.SMTP.Addr
+	}
+
+	var smtpSrv *smtpd.Server
+	if *smtpAddr != "" {
+		smtpSrv = smtpd.New(svc, apiKeys, logger)
+		smtpSrv.Hostname = cfg.SMTP.Hostname
+		smtpSrv.MaxMessageBytes = cfg.SMTP.MaxMessageBytes
+		if cfg.SMTP.TLSCertFile != "" || cfg.SMTP.TLSKeyFile != "" {
+			cert, err := tls.LoadX509KeyPair(cfg.SMTP.TLSCertFile, cfg.SMTP.TLSKeyFile)
+			if err != nil {
+				return err
+			}
+			smtpSrv.TLSConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+		}
+	}
+
+	srv := &http.Server{
+		Addr:              *addr,
+		Handler:           httpapi.New(svc, apiKeys, logger),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	errc := make(chan error, 2)
+	go func() {
+		logger.Info("listening", "version", version, "addr", *addr)
+		errc <- srv.ListenAndServe()
+	}()
+	if smtpSrv != nil {
+		go func() {
+			logger.Info("listening for SMTP", "version", version, "addr", *smtpAddr)
+			errc <- smtpSrv.ListenAndServe(*smtpAddr)
+		}()
+	}
+	if *poll > 0 {
+		go processMailQueue(ctx, svc, logger, *poll)
+	}
+
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+	}
+
+	logger.Info("shutting down")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		return err
+	}
+	if smtpSrv != nil {
+		if err := smtpSrv.Shutdown(shutdownCtx); err != nil {
+			return err
+		}
+	}
+	// wait for the emails being sent by the worker
+	return svc.Shutdown(shutdownCtx)
+}
+
+// processMailQueue delivers queued emails and pending webhook events, and
+// runs any database maintenance that is due, every interval until ctx is
+// done. Work in progress when ctx is done is left to finish until the
+// service is shut down.
+func processMailQueue(ctx context.Context, svc *service.Service, logger *slog.Logger, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	workCtx := context.WithoutCancel(ctx)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if _, err := svc.ProcessMailQueue(workCtx); err != nil && ctx.Err() == nil {
+			logger.Error("process mail queue failed", "error", err)
+		}
+		if _, err := svc.ProcessWebhookDeliveries(workCtx); err != nil && ctx.Err() == nil {
+			logger.Error("process webhook deliveries failed", "error", err)
+		}
+		if err := svc.RunMaintenance(workCtx); err != nil && ctx.Err() == nil {
+			logger.Error("run maintenance failed", "error", err)
+		}
+	}
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/andyfusniak/squishy-mailer-lite/entity"
+	"github.com/pkg/errors"
+)
+
+func templatePush(ctx context.Context, args []string) error {
+	fs, g := newFlagSet("template push", "--project <id> --dir <dir> [flags]")
+	projectID := fs.String("project", "", "project id")
+	dir := fs.String("dir", "", "template directory laid out as {group id}/{template id}.{txt,html,subject}")
+	if err := parseFlags(fs, args, "project", "dir"); err != nil {
+		return err
+	}
+
+	svc, _, err := g.openService()
+	if err != nil {
+		return err
+	}
+	defer svc.Close()
+
+	report, err := svc.SyncTemplatesFromDir(ctx, *projectID, *dir)
+	if err != nil {
+		return err
+	}
+	for _, id := range report.GroupsCreated {
+		fmt.Printf("created group %s\n", id)
+	}
+	for _, id := range report.TemplatesCreated {
+		fmt.Printf("created template %s\n", id)
+	}
+	for _, id := range report.TemplatesUpdated {
+		fmt.Printf("updated template %s\n", id)
+	}
+	fmt.Printf("%d templates unchanged\n", len(report.TemplatesUnchanged))
+	return nil
+}
+
+// templatePull writes the templates of a project in the layout read by
+// template push. MJML and Markdown templates are written as their
+// compiled text and HTML.
+func templatePull(ctx context.Context, args []string) error {
+	fs, g := newFlagSet("template pull", "--project <id> --dir <dir> [flags]")
+	projectID := fs.String("project", "", "project id")
+	dir := fs.String("dir", "", "directory to write the templates to")
+	groupID := fs.String("group", "", "only pull the templates in this group")
+	if err := parseFlags(fs, args, "project", "dir"); err != nil {
+		return err
+	}
+
+	svc, _, err := g.openService()
+	if err != nil {
+		return err
+	}
+	defer svc.Close()
+
+	templates, err := svc.ListTemplates(ctx, entity.ListTemplatesParams{
+		ProjectID: *projectID,
+		GroupID:   *groupID,
+	})
+	if err != nil {
+		return err
+	}
+	for _, t := range templates {
+		groupDir := filepath.Join(*dir, t.GroupID)
+		if err := os.MkdirAll(groupDir, 0o755); err != nil {
+			return errors.Wrapf(err, "create directory %s failed", groupDir)
+		}
+		files := map[string]string{
+			".txt":  t.Text,
+			".html": t.HTML,
+		}
+		if t.Subject != "" {
+			files[".subject"] = t.Subject
+		}
+		for ext, content := range files {
+			name := filepath.Join(groupDir, t.ID+ext)
+			if err := os.WriteFile(name, []byte(content), 0o644); err != nil {
+				return errors.Wrapf(err, "write %s failed", name)
+			}
+		}
+		fmt.Printf("pulled template %s/%s\n", t.GroupID, t.ID)
+	}
+	return nil
+}
+
+// templateLint prints the constructs in a template's HTML that are known to
+// break in email clients. It fails if there are any, so that it can be run
+// before template push.
+func templateLint(ctx context.Context, args []string) error {
+	fs, g := newFlagSet("template lint", "--file <file> [flags]")
+	file := fs.String("file", "", "HTML, MJML (.mjml) or Markdown (.md) template file")
+	if err := parseFlags(fs, args, "file"); err != nil {
+		return err
+	}
+	b, err := os.ReadFile(*file)
+	if err != nil {
+		return errors.Wrapf(err, "read %s failed", *file)
+	}
+	params := entity.LintTemplateParams{HTML: string(b)}
+	switch strings.ToLower(filepath.Ext(*file)) {
+	case ".mjml":
+		params = entity.LintTemplateParams{SourceType: entity.TemplateSourceMJML, Source: string(b)}
+	case ".md":
+		params = entity.LintTemplateParams{SourceType: entity.TemplateSourceMarkdown, Source: string(b)}
+	}
+
+	svc, _, err := g.openService()
+	if err != nil {
+		return err
+	}
+	defer svc.Close()
+
+	warnings, err := svc.LintTemplate(ctx, params)
+	if err != nil {
+		return err
+	}
+	for _, w := range warnings {
+		fmt.Printf("%s:%d: %s: %s\n", *file, w.Line, w.Rule, w.Message)
+	}
+	if len(warnings) > 0 {
+		return errors.Errorf("%d warnings", len(warnings))
+	}
+	return nil
+}
+
+// templateSearch lists the templates of a project whose subject, text or
+// HTML contain the query.
+func templateSearch(ctx context.Context, args []string) error {
+	fs, g := newFlagSet("template search", "--project <id> --query <text> [flags]")
+	projectID := fs.String("project", "", "project id")
+	query := fs.String("query", "", "phrase or template param to search for, for example .first_name")
+	if err := parseFlags(fs, args, "project", "query"); err != nil {
+		return err
+	}
+
+	svc, _, err := g.openService()
+	if err != nil {
+		return err
+	}
+	defer svc.Close()
+
+	templates, err := svc.SearchTemplates(ctx, *projectID, *query)
+	if err != nil {
+		return err
+	}
+	for _, t := range templates {
+		fmt.Printf("%s/%s\n", t.GroupID, t.ID)
+	}
+	return nil
+}
+
+// templateRender prints a template rendered with the given params or the
+// params of one of its fixtures, without sending it.
+func templateRender(ctx context.Context, args []string) error {
+	fs, g := newFlagSet("template render", "--project <id> --template <id> [flags]")
+	projectID := fs.String("project", "", "project id")
+	templateID := fs.String("template", "", "template id")
+	fixture := fs.String("fixture", "", "name of the template fixture whose params are used")
+	params := fs.String("params", "", `template params as a JSON object, for example {"name":"Andy"}`)
+	paramsFile := fs.String("params-file", "", "file holding the template params as a JSON object, or - for stdin")
+	locale := fs.String("locale", "", "locale used to choose the template variant")
+	strict := fs.Bool("strict", false, "fail if a param used by the template is missing")
+	html := fs.Bool("html", false, "print the HTML body instead of the subject and text body")
+	var param stringList
+	fs.Var(&param, "param", "template param as name=value, overriding --params (repeatable)")
+	if err := parseFlags(fs, args, "project", "template"); err != nil {
+		return err
+	}
+	if *fixture != "" && (*params != "" || *paramsFile != "" || len(param) > 0) {
+		return usagef("sqm template render: --fixture cannot be used with --params, --params-file or --param")
+	}
+
+	templateParams, err := sendParams(*params, *paramsFile, param)
+	if err != nil {
+		return err
+	}
+
+	svc, _, err := g.openService()
+	if err != nil {
+		return err
+	}
+	defer svc.Close()
+
+	rt, err := svc.RenderTemplate(ctx, entity.RenderTemplateParams{
+		ProjectID:      *projectID,
+		TemplateID:     *templateID,
+		Locale:         *locale,
+		TemplateParams: templateParams,
+		Fixture:        *fixture,
+		StrictParams:   *strict,
+	})
+	if err != nil {
+		return err
+	}
+	if *html {
+		fmt.Println(rt.HTML)
+		return nil
+	}
+	fmt.Printf("Subject: %s\n\n%s\n", rt.Subject, rt.Text)
+	return nil
+}
+
+// templateFixture creates or replaces a named set of sample params of a
+// template from a JSON file, or deletes it.
+func templateFixture(ctx context.Context, args []string) error {
+	fs, g := newFlagSet("template fixture", "--project <id> --template <id> --name <name> (--params-file <file> | --delete)")
+	projectID := fs.String("project", "", "project id")
+	templateID := fs.String("template", "", "template id")
+	name := fs.String("name", "", "fixture name")
+	params := fs.String("params", "", `template params as a JSON object, for example {"name":"Andy"}`)
+	paramsFile := fs.String("params-file", "", "file holding the template params as a JSON object, or - for stdin")
+	del := fs.Bool("delete", false, "delete the fixture")
+	var param stringList
+	fs.Var(&param, "param", "template param as name=value, overriding --params (repeatable)")
+	if err := parseFlags(fs, args, "project", "template", "name"); err != nil {
+		return err
+	}
+
+	templateParams, err := sendParams(*params, *paramsFile, param)
+	if err != nil {
+		return err
+	}
+
+	svc, _, err := g.openService()
+	if err != nil {
+		return err
+	}
+	defer svc.Close()
+
+	if *del {
+		return svc.DeleteTemplateFixture(ctx, *projectID, *templateID, *name)
+	}
+	_, err = svc.SetTemplateFixture(ctx, entity.SetTemplateFixtureParams{
+		TemplateID: *templateID,
+		ProjectID:  *projectID,
+		Name:       *name,
+		Params:     templateParams,
+	})
+	return err
+}
+
+// templateFixtures lists the fixtures of a template.
+func templateFixtures(ctx context.Context, args []string) error {
+	fs, g := newFlagSet("template fixtures", "--project <id> --template <id>")
+	projectID := fs.String("project", "", "project id")
+	templateID := fs.String("template", "", "template id")
+	if err := parseFlags(fs, args, "project", "template"); err != nil {
+		return err
+	}
+
+	svc, _, err := g.openService()
+	if err != nil {
+		return err
+	}
+	defer svc.Close()
+
+	fixtures, err := svc.ListTemplateFixtures(ctx, *projectID, *templateID)
+	if err != nil {
+		return err
+	}
+	for _, f := range fixtures {
+		fmt.Println(f.Name)
+	}
+	return nil
+}
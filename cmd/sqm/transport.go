@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/andyfusniak/squishy-mailer-lite/entity"
+)
+
+func transportCreate(ctx context.Context, args []string) error {
+	fs, g := newFlagSet("transport create", "--project <id> --id <id> --host <host> [flags]")
+	projectID := fs.String("project", "", "project id")
+	id := fs.String("id", "", "transport id")
+	name := fs.String("name", "", "transport name (default the id)")
+	host := fs.String("host", "", "SMTP server host")
+	port := fs.Int("port", 587, "SMTP server port")
+	username := fs.String("username", "", "SMTP username")
+	from := fs.String("from", "", "from email address")
+	fromName := fs.String("from-name", "", "from display name")
+	tlsMode := fs.String("tls", "", "TLS mode: starttls, tls or none (default opportunistic STARTTLS)")
+	connectTimeout := fs.Duration("connect-timeout", 0, "timeout connecting to the server (default 30s)")
+	sendTimeout := fs.Duration("send-timeout", 0, "timeout sending an email (default 2m)")
+	var replyTo stringList
+	fs.Var(&replyTo, "reply-to", "reply-to email address (repeatable)")
+	if err := parseFlags(fs, args, "project", "id", "host", "from"); err != nil {
+		return err
+	}
+	if *name == "" {
+		*name = *id
+	}
+
+	svc, _, err := g.openService()
+	if err != nil {
+		return err
+	}
+	defer svc.Close()
+
+	// the password is read from the environment so that it does not
+	// appear in the process list or shell history
+	t, err := svc.CreateSMTPTransport(ctx, entity.CreateSMTPTransport{
+		ID:            *id,
+		ProjectID:     *projectID,
+		Name:          *name,
+		Host:          *host,
+		Port:          *port,
+		Username:      *username,
+		Password:      os.Getenv("SQM_SMTP_PASSWORD"),
+		EmailFrom:     *from,
+		EmailFromName: *fromName,
+		EmailReplyTo:  replyTo,
+		TLS:           entity.SMTPTLSOptions{Mode: entity.SMTPTLSMode(*tlsMode)},
+		Timeouts:      entity.SMTPTimeouts{Connect: *connectTimeout, Send: *sendTimeout},
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("created transport %s\n", t.ID)
+	return nil
+}
+
+func transportVerify(ctx context.Context, args []string) error {
+	fs, g := newFlagSet("transport verify", "--project <id> --id <id> [flags]")
+	projectID := fs.String("project", "", "project id")
+	id := fs.String("id", "", "transport id")
+	if err := parseFlags(fs, args, "project", "id"); err != nil {
+		return err
+	}
+
+	svc, _, err := g.openService()
+	if err != nil {
+		return err
+	}
+	defer svc.Close()
+
+	if err := svc.VerifyTransport(ctx, *projectID, *id); err != nil {
+		return err
+	}
+	fmt.Printf("transport %s ok\n", *id)
+	return nil
+}
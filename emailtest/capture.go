@@ -0,0 +1,191 @@
+// Package emailtest provides a transport that captures emails in memory so
+// that test suites can send through the service and inspect the rendered
+// emails without an SMTP server or API account.
+//
+//	capture := emailtest.NewCapture()
+//	service.RegisterTransportFactory("smtp", capture.Factory)
+//	...
+//	msg := capture.AssertSentTo(t, "to@example.com")
+//	msg.AssertSubject(t, "Welcome")
+//	msg.AssertTextContains(t, "Hello Andy")
+package emailtest
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/andyfusniak/squishy-mailer-lite/entity"
+	"github.com/andyfusniak/squishy-mailer-lite/service"
+)
+
+// Message is an email recorded by a Capture.
+type Message struct {
+	entity.OutgoingEmail
+
+	// TransportID is the id of the transport the email was sent through.
+	// It is empty for emails passed to Capture.SendEmail directly.
+	TransportID string
+}
+
+// Recipients returns the To, Cc and Bcc addresses of the email.
+func (m Message) Recipients() []string {
+	return slices.Concat(m.To, m.Cc, m.Bcc)
+}
+
+// Header returns the value of an extra header of the email, ignoring the
+// case of its name.
+func (m Message) Header(name string) string {
+	for k, v := range m.Headers {
+		if strings.EqualFold(k, name) {
+			return v
+		}
+	}
+	return ""
+}
+
+// AssertSubject reports a test error if the subject is not want.
+func (m Message) AssertSubject(t testing.TB, want string) {
+	t.Helper()
+	if m.Subject != want {
+		t.Errorf("email subject is %q, want %q", m.Subject, want)
+	}
+}
+
+// AssertTextContains reports a test error if the text body does not
+// contain substr.
+func (m Message) AssertTextContains(t testing.TB, substr string) {
+	t.Helper()
+	if !strings.Contains(m.Text, substr) {
+		t.Errorf("email text body does not contain %q:\n%s", substr, m.Text)
+	}
+}
+
+// AssertHTMLContains reports a test error if the HTML body does not
+// contain substr.
+func (m Message) AssertHTMLContains(t testing.TB, substr string) {
+	t.Helper()
+	if !strings.Contains(m.HTML, substr) {
+		t.Errorf("email HTML body does not contain %q:\n%s", substr, m.HTML)
+	}
+}
+
+// AssertHeader reports a test error if the extra header name is not want.
+func (m Message) AssertHeader(t testing.TB, name, want string) {
+	t.Helper()
+	if got := m.Header(name); got != want {
+		t.Errorf("email header %s is %q, want %q", name, got, want)
+	}
+}
+
+// Capture is a transport that records every email sent through it. It is
+// safe for concurrent use.
+type Capture struct {
+	mu   sync.Mutex
+	msgs []Message
+}
+
+// NewCapture returns an empty Capture.
+func NewCapture() *Capture {
+	return &Capture{}
+}
+
+// Factory is a service.TransportFactory returning senders that record
+// into the capture. Register it under a custom transport type, or under a
+// built-in type such as "smtp" to capture the emails of existing
+// transports. Emails that do not override the sender address are
+// recorded with the transport's address.
+func (c *Capture) Factory(_ context.Context, cfg service.TransportConfig) (service.Sender, error) {
+	return &captureSender{capture: c, cfg: cfg}, nil
+}
+
+// SendEmail records the email.
+func (c *Capture) SendEmail(_ context.Context, msg *entity.OutgoingEmail) error {
+	c.record(Message{OutgoingEmail: *msg})
+	return nil
+}
+
+func (c *Capture) record(m Message) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.msgs = append(c.msgs, m)
+}
+
+// Messages returns the recorded emails in the order they were sent.
+func (c *Capture) Messages() []Message {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return slices.Clone(c.msgs)
+}
+
+// Last returns the most recently recorded email, or false if there are
+// none.
+func (c *Capture) Last() (Message, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.msgs) == 0 {
+		return Message{}, false
+	}
+	return c.msgs[len(c.msgs)-1], true
+}
+
+// SentTo returns the recorded emails that have address as a To, Cc or Bcc
+// recipient.
+func (c *Capture) SentTo(address string) []Message {
+	var out []Message
+	for _, m := range c.Messages() {
+		if slices.ContainsFunc(m.Recipients(), func(r string) bool {
+			return strings.EqualFold(r, address)
+		}) {
+			out = append(out, m)
+		}
+	}
+	return out
+}
+
+// Reset forgets the recorded emails.
+func (c *Capture) Reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.msgs = nil
+}
+
+// AssertCount reports a test error if the number of recorded emails is
+// not n.
+func (c *Capture) AssertCount(t testing.TB, n int) {
+	t.Helper()
+	if got := len(c.Messages()); got != n {
+		t.Errorf("captured %d emails, want %d", got, n)
+	}
+}
+
+// AssertSentTo fails the test if no email was sent to address and
+// otherwise returns the most recent one.
+func (c *Capture) AssertSentTo(t testing.TB, address string) Message {
+	t.Helper()
+	msgs := c.SentTo(address)
+	if len(msgs) == 0 {
+		t.Fatalf("no email was sent to %s", address)
+	}
+	return msgs[len(msgs)-1]
+}
+
+// captureSender records emails sent through one transport.
+type captureSender struct {
+	capture *Capture
+	cfg     service.TransportConfig
+}
+
+func (s *captureSender) SendEmail(_ context.Context, msg *entity.OutgoingEmail) error {
+	m := Message{OutgoingEmail: *msg, TransportID: s.cfg.ID}
+	if m.From == "" {
+		m.From = s.cfg.EmailFrom
+	}
+	if m.FromName == "" {
+		m.FromName = s.cfg.EmailFromName
+	}
+	s.capture.record(m)
+	return nil
+}
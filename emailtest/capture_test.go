@@ -0,0 +1,86 @@
+package emailtest_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/andyfusniak/squishy-mailer-lite/emailtest"
+	"github.com/andyfusniak/squishy-mailer-lite/entity"
+	"github.com/andyfusniak/squishy-mailer-lite/service"
+)
+
+func TestCapture(t *testing.T) {
+	capture := emailtest.NewCapture()
+	service.RegisterTransportFactory(service.TransportTypeSMTP, capture.Factory)
+
+	svc, err := service.NewEmailService(
+		service.WithInMemoryStore(),
+		service.WithHexEncodedEncryptionKey("a0bf305856098eba7e4bff506021648b"),
+		service.WithUnsubscribeURL("https://mail.example.com/v1/unsubscribe"),
+	)
+	if err != nil {
+		t.Fatalf("service.NewEmailService failed: %+v", err)
+	}
+	defer svc.Close()
+
+	ctx := context.Background()
+	if _, err := svc.CreateProject(ctx, "p1", "Project One", ""); err != nil {
+		t.Fatalf("svc.CreateProject failed: %+v", err)
+	}
+	if _, err := svc.CreateSMTPTransport(ctx, entity.CreateSMTPTransport{
+		ID:            "tr1",
+		ProjectID:     "p1",
+		Name:          "Transport One",
+		Host:          "smtp.example.com",
+		Port:          587,
+		Username:      "user",
+		Password:      "secret",
+		EmailFrom:     "from@example.com",
+		EmailFromName: "Example",
+	}); err != nil {
+		t.Fatalf("svc.CreateSMTPTransport failed: %+v", err)
+	}
+	if _, err := svc.CreateGroup(ctx, "g1", "p1", "Group One"); err != nil {
+		t.Fatalf("svc.CreateGroup failed: %+v", err)
+	}
+	if _, err := svc.CreateTemplate(ctx, entity.CreateTemplate{
+		ID:        "t1",
+		ProjectID: "p1",
+		GroupID:   "g1",
+		Text:      `{{define "layout"}}Hello {{.name}}{{end}}`,
+		HTML:      `{{define "layout"}}<p>Hello {{.name}}</p>{{end}}`,
+	}); err != nil {
+		t.Fatalf("svc.CreateTemplate failed: %+v", err)
+	}
+
+	if err := svc.SendEmail(ctx, entity.SendEmailParams{
+		TemplateID:     "t1",
+		ProjectID:      "p1",
+		TransportID:    "tr1",
+		To:             []string{"to@example.com"},
+		Bcc:            []string{"audit@example.com"},
+		Subject:        "Welcome",
+		TemplateParams: map[string]string{"name": "Andy"},
+		Unsubscribe:    true,
+	}); err != nil {
+		t.Fatalf("svc.SendEmail failed: %+v", err)
+	}
+
+	capture.AssertCount(t, 1)
+	msg := capture.AssertSentTo(t, "audit@example.com")
+	msg.AssertSubject(t, "Welcome")
+	msg.AssertTextContains(t, "Hello Andy")
+	msg.AssertHTMLContains(t, "<p>Hello Andy</p>")
+	msg.AssertHeader(t, "list-unsubscribe-post", "List-Unsubscribe=One-Click")
+	if msg.TransportID != "tr1" || msg.From != "from@example.com" {
+		t.Errorf("got transport %q from %q", msg.TransportID, msg.From)
+	}
+	if got := capture.SentTo("other@example.com"); len(got) != 0 {
+		t.Errorf("SentTo(other@example.com) returned %d emails", len(got))
+	}
+
+	capture.Reset()
+	if _, ok := capture.Last(); ok {
+		t.Error("Last returned an email after Reset")
+	}
+}
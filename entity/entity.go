@@ -2,6 +2,8 @@ package entity
 
 import (
 	"fmt"
+	"reflect"
+	"strings"
 	"time"
 )
 
@@ -26,13 +28,171 @@ type ErrCode string
 
 // create a list of error codes
 const (
-	ErrProjectAlreadyExistsCode = "project_already_exists"
-	ErrProjectNotFoundCode      = "project_not_found"
+	ErrProjectAlreadyExistsCode    = "project_already_exists"
+	ErrProjectNotFoundCode         = "project_not_found"
+	ErrTransportNotFoundCode       = "transport_not_found"
+	ErrGroupNotFoundCode           = "group_not_found"
+	ErrTemplateNotFoundCode        = "template_not_found"
+	ErrProjectScopeViolationCode   = "project_scope_violation"
+	ErrMailQueueNotFoundCode       = "mail_queue_not_found"
+	ErrInvalidIDCode               = "invalid_id"
+	ErrRecipientBlockedCode        = "recipient_blocked"
+	ErrInvalidSendWindowCode       = "invalid_send_window"
+	ErrSendWindowNotFoundCode      = "send_window_not_found"
+	ErrInvalidWarmupScheduleCode   = "invalid_warmup_schedule"
+	ErrNoDefaultTransportCode      = "no_default_transport"
+	ErrNoDefaultGroupCode          = "no_default_group"
+	ErrInvalidCatalogCode          = "invalid_message_catalog"
+	ErrCatalogNotFoundCode         = "message_catalog_not_found"
+	ErrAttachmentNotFoundCode      = "attachment_not_found"
+	ErrAssetNotFoundCode           = "asset_not_found"
+	ErrInvalidAssetModeCode        = "invalid_asset_mode"
+	ErrInvalidSnapshotCode         = "invalid_snapshot"
+	ErrSnapshotKeyMismatchCode     = "snapshot_key_mismatch"
+	ErrStoreNotEmptyCode           = "store_not_empty"
+	ErrInvalidAttachmentCode       = "invalid_attachment"
+	ErrTransportIDInUseCode        = "transport_id_in_use"
+	ErrInvalidTransportCode        = "invalid_transport"
+	ErrTransportInUseCode          = "transport_in_use"
+	ErrMissingTemplateParamsCode   = "missing_template_params"
+	ErrInvalidTemplateParamsCode   = "invalid_template_params"
+	ErrPartialNotFoundCode         = "partial_not_found"
+	ErrInvalidPartialCode          = "invalid_partial"
+	ErrInvalidTemplateCode         = "invalid_template"
+	ErrVariantNotFoundCode         = "template_variant_not_found"
+	ErrFixtureNotFoundCode         = "template_fixture_not_found"
+	ErrGroupNotEmptyCode           = "group_not_empty"
+	ErrMailQueueStateCode          = "mail_queue_invalid_state"
+	ErrInvalidRateLimitCode        = "invalid_rate_limit"
+	ErrRateLimitNotFoundCode       = "rate_limit_not_found"
+	ErrInvalidWebhookCode          = "invalid_webhook"
+	ErrWebhookNotFoundCode         = "webhook_not_found"
+	ErrWebhookAlreadyExistsCode    = "webhook_already_exists"
+	ErrRecipientSuppressedCode     = "recipient_suppressed"
+	ErrInvalidSuppressionCode      = "invalid_suppression"
+	ErrSuppressionNotFoundCode     = "suppression_not_found"
+	ErrInvalidNotificationCode     = "invalid_notification"
+	ErrInvalidUnsubscribeCode      = "invalid_unsubscribe_token"
+	ErrNoUnsubscribeURLCode        = "no_unsubscribe_url"
+	ErrInvalidHeadersCode          = "invalid_headers"
+	ErrInvalidMessageIDCode        = "invalid_message_id"
+	ErrFromNotAllowedCode          = "from_not_allowed"
+	ErrInvalidSenderAllowListCode  = "invalid_sender_allow_list"
+	ErrSenderAllowListNotFoundCode = "sender_allow_list_not_found"
+	ErrNoTrackingURLCode           = "no_tracking_url"
+	ErrTrackOpensNotQueuedCode     = "track_opens_not_queued"
+	ErrInvalidTrackingTokenCode    = "invalid_tracking_token"
+	ErrInvalidStatsRangeCode       = "invalid_stats_range"
+	ErrInvalidAPIKeyCode           = "invalid_api_key"
+	ErrAPIKeyNotFoundCode          = "api_key_not_found"
+	ErrInvalidQuotaCode            = "invalid_quota"
+	ErrQuotaNotFoundCode           = "quota_not_found"
+	ErrQuotaExceededCode           = "quota_exceeded"
+	ErrInvalidAddressCode          = "invalid_address"
+	ErrInvalidTransportChainCode   = "invalid_transport_chain"
+	ErrInvalidCampaignCode         = "invalid_campaign"
+	ErrCampaignNotFoundCode        = "campaign_not_found"
+	ErrCampaignAlreadyExistsCode   = "campaign_already_exists"
+	ErrInvalidContactsCode         = "invalid_contacts"
+	ErrContactListNotFoundCode     = "contact_list_not_found"
+	ErrContactListExistsCode       = "contact_list_already_exists"
+	ErrContactNotFoundCode         = "contact_not_found"
+	ErrInvalidProjectExportCode    = "invalid_project_export"
+	ErrBackupNotSupportedCode      = "backup_not_supported"
+	ErrMigrationsNotSupportedCode  = "migrations_not_supported"
+	ErrSchemaDirtyCode             = "schema_dirty"
+	ErrSchemaVersionNotFoundCode   = "schema_version_not_found"
+	ErrServiceClosedCode           = "service_closed"
+	ErrInvalidEventFilterCode      = "invalid_event_filter"
+	ErrInvalidMessageCode          = "invalid_message"
+	ErrInvalidLabelsCode           = "invalid_labels"
+	ErrInvalidSearchQueryCode      = "invalid_search_query"
+	ErrInvalidCalendarEventCode    = "invalid_calendar_event"
+	ErrInvalidProjectVariablesCode = "invalid_project_variables"
 )
 
 var mapErrCodeToMessage = map[ErrCode]string{
-	ErrProjectAlreadyExistsCode: "project already exists",
-	ErrProjectNotFoundCode:      "project not found",
+	ErrProjectAlreadyExistsCode:    "project already exists",
+	ErrProjectNotFoundCode:         "project not found",
+	ErrTransportNotFoundCode:       "transport not found",
+	ErrGroupNotFoundCode:           "group not found",
+	ErrTemplateNotFoundCode:        "template not found",
+	ErrProjectScopeViolationCode:   "resource does not belong to the requested project",
+	ErrMailQueueNotFoundCode:       "mail queue entry not found",
+	ErrInvalidIDCode:               "id does not satisfy the id policy",
+	ErrRecipientBlockedCode:        "recipient domain is not on the project allow-list",
+	ErrInvalidSendWindowCode:       "invalid send window",
+	ErrSendWindowNotFoundCode:      "send window not found",
+	ErrInvalidWarmupScheduleCode:   "invalid warm-up schedule",
+	ErrNoDefaultTransportCode:      "no transport specified and the project has no default transport",
+	ErrNoDefaultGroupCode:          "no group specified and the project has no default group",
+	ErrInvalidCatalogCode:          "invalid message catalog",
+	ErrCatalogNotFoundCode:         "message catalog not found",
+	ErrAttachmentNotFoundCode:      "attachment not found",
+	ErrAssetNotFoundCode:           "asset not found",
+	ErrInvalidAssetModeCode:        "invalid asset mode",
+	ErrInvalidSnapshotCode:         "invalid snapshot",
+	ErrSnapshotKeyMismatchCode:     "snapshot was taken with a different encryption key",
+	ErrStoreNotEmptyCode:           "store is not empty",
+	ErrInvalidAttachmentCode:       "invalid attachment",
+	ErrTransportIDInUseCode:        "transport id is already used by another transport in the project",
+	ErrInvalidTransportCode:        "invalid transport configuration",
+	ErrTransportInUseCode:          "transport is referenced by queued emails",
+	ErrMissingTemplateParamsCode:   "template params are missing",
+	ErrInvalidTemplateParamsCode:   "template params must be a map or a struct",
+	ErrPartialNotFoundCode:         "partial not found",
+	ErrInvalidPartialCode:          "invalid partial",
+	ErrInvalidTemplateCode:         "invalid template",
+	ErrVariantNotFoundCode:         "template variant not found",
+	ErrFixtureNotFoundCode:         "template fixture not found",
+	ErrGroupNotEmptyCode:           "group has templates",
+	ErrMailQueueStateCode:          "mail queue entry is not in a valid state for the change",
+	ErrInvalidRateLimitCode:        "invalid rate limit",
+	ErrRateLimitNotFoundCode:       "rate limit not found",
+	ErrInvalidWebhookCode:          "invalid webhook",
+	ErrWebhookNotFoundCode:         "webhook not found",
+	ErrWebhookAlreadyExistsCode:    "webhook already exists",
+	ErrRecipientSuppressedCode:     "recipient is on the project suppression list",
+	ErrInvalidSuppressionCode:      "invalid suppression",
+	ErrSuppressionNotFoundCode:     "suppression not found",
+	ErrInvalidNotificationCode:     "invalid bounce or complaint notification",
+	ErrInvalidUnsubscribeCode:      "invalid unsubscribe token",
+	ErrNoUnsubscribeURLCode:        "unsubscribe headers require an unsubscribe URL",
+	ErrInvalidHeadersCode:          "invalid email headers",
+	ErrInvalidMessageIDCode:        "invalid message id",
+	ErrFromNotAllowedCode:          "from address is not allowed for the transport",
+	ErrInvalidSenderAllowListCode:  "invalid sender allow-list",
+	ErrSenderAllowListNotFoundCode: "sender allow-list not found",
+	ErrNoTrackingURLCode:           "open tracking requires a tracking URL",
+	ErrTrackOpensNotQueuedCode:     "open tracking is only available for queued emails",
+	ErrInvalidTrackingTokenCode:    "invalid tracking token",
+	ErrInvalidStatsRangeCode:       "invalid stats time range",
+	ErrInvalidAPIKeyCode:           "invalid api key",
+	ErrAPIKeyNotFoundCode:          "api key not found",
+	ErrInvalidQuotaCode:            "invalid project quota",
+	ErrQuotaNotFoundCode:           "project quota not found",
+	ErrQuotaExceededCode:           "project send quota exceeded",
+	ErrInvalidAddressCode:          "invalid email address",
+	ErrInvalidTransportChainCode:   "invalid transport chain",
+	ErrInvalidCampaignCode:         "invalid campaign",
+	ErrCampaignNotFoundCode:        "campaign not found",
+	ErrCampaignAlreadyExistsCode:   "campaign already exists",
+	ErrInvalidContactsCode:         "invalid contacts",
+	ErrContactListNotFoundCode:     "contact list not found",
+	ErrContactListExistsCode:       "contact list already exists",
+	ErrContactNotFoundCode:         "contact not found",
+	ErrInvalidProjectExportCode:    "invalid project export",
+	ErrBackupNotSupportedCode:      "store does not support backups",
+	ErrMigrationsNotSupportedCode:  "store does not support schema migrations",
+	ErrSchemaDirtyCode:             "schema is dirty",
+	ErrSchemaVersionNotFoundCode:   "schema version not found",
+	ErrServiceClosedCode:           "service is shut down",
+	ErrInvalidEventFilterCode:      "invalid mail event filter",
+	ErrInvalidMessageCode:          "invalid email message",
+	ErrInvalidLabelsCode:           "invalid labels",
+	ErrInvalidSearchQueryCode:      "invalid template search query",
+	ErrInvalidCalendarEventCode:    "invalid calendar event",
+	ErrInvalidProjectVariablesCode: "invalid project variables",
 }
 
 // ServiceError is a custom error type.
@@ -66,7 +226,48 @@ type Project struct {
 	ID          string
 	Name        string
 	Description string
-	CreatedAt   ISOTime
+
+	// AllowedRecipientDomains restricts the domains emails can be sent
+	// to. If empty, emails can be sent to any domain.
+	AllowedRecipientDomains []string
+
+	// DefaultTransportID is the transport used to send emails when
+	// SendEmailParams.TransportID is empty.
+	DefaultTransportID string
+
+	// DefaultGroupID is the group templates are placed in when they are
+	// created without one.
+	DefaultGroupID string
+
+	// TransportChain is the ordered list of transports emails fall back
+	// through. An email sent through a transport in the chain that fails
+	// with a transient error is retried through the transports after it.
+	TransportChain []string
+
+	// Labels are free-form key/value pairs used to organise and filter
+	// projects, for example team=billing.
+	Labels map[string]string
+
+	// Variables are shared by every template of the project, which can
+	// use them as {{.Project.name}}, for example brand colours and
+	// footer addresses.
+	Variables map[string]string
+	CreatedAt ISOTime
+}
+
+// ListProjectsParams is the input parameters for the ListProjects method.
+type ListProjectsParams struct {
+	// Labels, if set, lists only the projects that have all of the
+	// labels.
+	Labels map[string]string
+
+	// After is the id of the last project of the previous page. The list
+	// starts at the beginning if it is empty.
+	After string
+
+	// Limit is the maximum number of projects to list. Zero means no
+	// limit.
+	Limit int
 }
 
 //
@@ -84,8 +285,79 @@ type SMTPTransport struct {
 	EmailFrom     string
 	EmailFromName string
 	EmailReplyTo  []string
-	CreatedAt     ISOTime
-	ModifiedAt    ISOTime
+
+	// WarmupSchedule is the maximum number of emails the transport may
+	// send on each day of its warm-up, starting from WarmupStartedAt.
+	// An empty schedule means the transport is not warming up.
+	WarmupSchedule  []int
+	WarmupStartedAt ISOTime
+	CreatedAt       ISOTime
+	ModifiedAt      ISOTime
+
+	TLS      SMTPTLSOptions
+	Timeouts SMTPTimeouts
+}
+
+// SMTPTLSMode selects how an SMTP transport secures its connection.
+type SMTPTLSMode string
+
+// SMTP TLS modes
+const (
+	// SMTPTLSModeOpportunistic upgrades the connection using STARTTLS
+	// when the server offers it. This is the default.
+	SMTPTLSModeOpportunistic SMTPTLSMode = ""
+
+	// SMTPTLSModeNone never upgrades the connection. Credentials are only
+	// sent over it to servers on localhost.
+	SMTPTLSModeNone SMTPTLSMode = "none"
+
+	// SMTPTLSModeStartTLS requires the server to offer STARTTLS, usually
+	// on port 587.
+	SMTPTLSModeStartTLS SMTPTLSMode = "starttls"
+
+	// SMTPTLSModeTLS connects using implicit TLS, usually on port 465.
+	SMTPTLSModeTLS SMTPTLSMode = "tls"
+)
+
+// SMTPTLSOptions configures the TLS connection of an SMTP transport.
+type SMTPTLSOptions struct {
+	Mode SMTPTLSMode
+
+	// InsecureSkipVerify disables verification of the server's
+	// certificate. Only use it for testing.
+	InsecureSkipVerify bool
+
+	// CABundle is an optional PEM encoded bundle of CA certificates used
+	// to verify the server instead of the system roots, for example for
+	// an internal relay with a private CA.
+	CABundle string
+}
+
+// SMTPTimeouts bound how long an SMTP transport waits on its server. A
+// zero timeout uses the service default.
+type SMTPTimeouts struct {
+	// Connect bounds connecting to the server, including the TLS
+	// handshake and authentication.
+	Connect time.Duration
+
+	// Send bounds sending one email over an established connection.
+	Send time.Duration
+}
+
+// SetSMTPTransportWarmupParams is the input parameters for the
+// SetSMTPTransportWarmup method.
+type SetSMTPTransportWarmupParams struct {
+	TransportID string
+	ProjectID   string
+
+	// Schedule is the daily sending limit for each day of the warm-up,
+	// e.g. []int{50, 100, 200}. Once the schedule is exhausted the
+	// transport is no longer throttled.
+	Schedule []int
+
+	// StartedAt is the first day of the warm-up. Days are counted in UTC.
+	// If zero, the current time is used.
+	StartedAt time.Time
 }
 
 // CreateSMTPTransport is the input parameters for the CreateSMTPTransport method.
@@ -100,6 +372,328 @@ type CreateSMTPTransport struct {
 	EmailFrom     string
 	EmailFromName string
 	EmailReplyTo  []string
+	TLS           SMTPTLSOptions
+	Timeouts      SMTPTimeouts
+}
+
+// UpdateSMTPTransportParams is the input parameters for the
+// UpdateSMTPTransport method. All fields replace the transport's current
+// values, so callers changing a single field should start from the values
+// returned by GetSMTPTransport.
+type UpdateSMTPTransportParams struct {
+	TransportID   string
+	ProjectID     string
+	Name          string
+	Host          string
+	Port          int
+	Username      string
+	EmailFrom     string
+	EmailFromName string
+	EmailReplyTo  []string
+	TLS           SMTPTLSOptions
+	Timeouts      SMTPTimeouts
+}
+
+// DeleteSMTPTransportParams is the input parameters for the
+// DeleteSMTPTransport method.
+type DeleteSMTPTransportParams struct {
+	TransportID string
+	ProjectID   string
+
+	// Force deletes the transport even if queued emails still reference
+	// it. Those emails are marked as failed.
+	Force bool
+}
+
+//
+// API transports
+//
+
+// APITransportProvider identifies the email provider an API transport
+// sends through, or the type of a custom transport.
+type APITransportProvider string
+
+// API transport providers
+const (
+	APITransportProviderMailgun  APITransportProvider = "mailgun"
+	APITransportProviderSES      APITransportProvider = "ses"
+	APITransportProviderPostmark APITransportProvider = "postmark"
+	APITransportProviderWebhook  APITransportProvider = "webhook"
+
+	// APITransportProviderSMTPOAuth2 transports send over SMTP,
+	// authenticating with an OAuth2 access token using XOAUTH2.
+	APITransportProviderSMTPOAuth2 APITransportProvider = "smtp_oauth2"
+
+	// APITransportProviderFile transports write each email to a file
+	// instead of sending it.
+	APITransportProviderFile APITransportProvider = "file"
+)
+
+// APITransport represents a transport that delivers emails using an email
+// provider's HTTP API rather than SMTP. The API key is never returned.
+type APITransport struct {
+	ID        string
+	ProjectID string
+	Name      string
+	Provider  APITransportProvider
+
+	// Config holds the provider specific settings, for example the
+	// Mailgun domain.
+	Config        map[string]string
+	EmailFrom     string
+	EmailFromName string
+	EmailReplyTo  []string
+	CreatedAt     ISOTime
+	ModifiedAt    ISOTime
+}
+
+// CreateMailgunTransport is the input parameters for the
+// CreateMailgunTransport method.
+type CreateMailgunTransport struct {
+	ID        string
+	ProjectID string
+	Name      string
+
+	// Domain is the sending domain configured in Mailgun.
+	Domain string
+	APIKey string
+
+	// BaseURL is optional and defaults to https://api.mailgun.net. Domains
+	// in the EU region use https://api.eu.mailgun.net.
+	BaseURL string
+
+	EmailFrom     string
+	EmailFromName string
+	EmailReplyTo  []string
+}
+
+// CreatePostmarkTransport is the input parameters for the
+// CreatePostmarkTransport method.
+type CreatePostmarkTransport struct {
+	ID          string
+	ProjectID   string
+	Name        string
+	ServerToken string
+
+	// MessageStream is the stream emails are sent on unless
+	// SendEmailParams.MessageStream is set. Defaults to outbound, the
+	// default transactional stream.
+	MessageStream string
+
+	// BaseURL is optional and defaults to https://api.postmarkapp.com.
+	BaseURL string
+
+	EmailFrom     string
+	EmailFromName string
+	EmailReplyTo  []string
+}
+
+// CreateWebhookTransport is the input parameters for the
+// CreateWebhookTransport method.
+type CreateWebhookTransport struct {
+	ID        string
+	ProjectID string
+	Name      string
+
+	// URL is the endpoint the rendered emails are POSTed to. It must use
+	// https unless the host is a loopback address.
+	URL string
+
+	// SigningSecret is the key used to sign each request with
+	// HMAC-SHA256. See service.VerifyWebhookSignature.
+	SigningSecret string
+
+	EmailFrom     string
+	EmailFromName string
+	EmailReplyTo  []string
+}
+
+// CreateFileTransport is the input parameters for the CreateFileTransport
+// method.
+type CreateFileTransport struct {
+	ID        string
+	ProjectID string
+	Name      string
+
+	// Dir is the directory each email is written to as a .eml file. If
+	// it is empty the emails are written to the writer given by
+	// service.WithFileTransportWriter.
+	Dir string
+
+	EmailFrom     string
+	EmailFromName string
+	EmailReplyTo  []string
+}
+
+// OAuth2Provider selects the preset SMTP server, token URL and scope of
+// an SMTP OAuth2 transport.
+type OAuth2Provider string
+
+// OAuth2 providers.
+const (
+	// OAuth2ProviderGoogle sends through smtp.gmail.com.
+	OAuth2ProviderGoogle OAuth2Provider = "google"
+
+	// OAuth2ProviderMicrosoft sends through smtp.office365.com.
+	OAuth2ProviderMicrosoft OAuth2Provider = "microsoft"
+)
+
+// CreateSMTPOAuth2Transport is the input parameters for the
+// CreateSMTPOAuth2Transport method.
+type CreateSMTPOAuth2Transport struct {
+	ID        string
+	ProjectID string
+	Name      string
+
+	// Provider optionally fills in the Host, Port, TokenURL and Scope
+	// for Gmail or Office 365. Fields that are set are kept.
+	Provider OAuth2Provider
+
+	// Tenant is the Microsoft Entra tenant id used in the token URL of
+	// the microsoft provider. Defaults to common.
+	Tenant string
+
+	Host string
+	Port int
+
+	// Username is the mailbox the access token was issued for.
+	Username string
+
+	// TokenURL is the OAuth2 token endpoint used to refresh the access
+	// token.
+	TokenURL string
+	ClientID string
+	Scope    string
+
+	// ClientSecret and RefreshToken are encrypted before they are
+	// stored.
+	ClientSecret string
+	RefreshToken string
+
+	// TLSMode defaults to SMTPTLSModeStartTLS for the google and
+	// microsoft providers. The access token is only sent over an
+	// encrypted connection unless the host is localhost.
+	TLSMode  SMTPTLSMode
+	Timeouts SMTPTimeouts
+
+	EmailFrom     string
+	EmailFromName string
+	EmailReplyTo  []string
+}
+
+// CreateTransport is the input parameters for the CreateTransport method,
+// which creates a transport of a custom type registered with
+// service.RegisterTransportFactory.
+type CreateTransport struct {
+	ID        string
+	ProjectID string
+	Name      string
+
+	// Type is the name the transport factory was registered under.
+	Type string
+
+	// Config holds the type specific settings passed to the factory.
+	Config map[string]string
+
+	// Secret is an optional credential, such as an API key. It is
+	// encrypted before it is stored and decrypted before it is passed
+	// to the factory.
+	Secret string
+
+	EmailFrom     string
+	EmailFromName string
+	EmailReplyTo  []string
+}
+
+// OutgoingEmail is a rendered email handed to a custom transport for
+// delivery.
+type OutgoingEmail struct {
+	Subject string
+	Text    string
+	HTML    string
+
+	// From, FromName and ReplyTo optionally override the transport's
+	// defaults.
+	From     string
+	FromName string
+	ReplyTo  string
+
+	To  []string
+	Cc  []string
+	Bcc []string
+
+	Attachments []OutgoingAttachment
+
+	// MessageStream optionally selects the provider's message stream.
+	MessageStream string
+
+	// MessageID is the Message-ID the email should be sent with, without
+	// the angle brackets.
+	MessageID string
+
+	// Headers are extra headers to add to the email, for example
+	// List-Unsubscribe.
+	Headers map[string]string
+}
+
+// OutgoingAttachment is a file attached to an OutgoingEmail. If ContentID
+// is set the attachment is inline and referenced by the HTML body using a
+// cid: URL.
+type OutgoingAttachment struct {
+	Filename    string
+	ContentType string
+	Content     []byte
+	ContentID   string
+}
+
+// SESCredentials selects where an SES transport gets its AWS credentials.
+type SESCredentials string
+
+// SES credential sources
+const (
+	// SESCredentialsDefault uses the default AWS credential chain:
+	// environment variables, the shared config files, then the instance
+	// or task role.
+	SESCredentialsDefault SESCredentials = "default"
+
+	// SESCredentialsStatic uses the access key id and secret access key
+	// stored with the transport.
+	SESCredentialsStatic SESCredentials = "static"
+
+	// SESCredentialsProfile uses a named profile from the shared config
+	// files.
+	SESCredentialsProfile SESCredentials = "profile"
+)
+
+// CreateSESTransport is the input parameters for the CreateSESTransport
+// method.
+type CreateSESTransport struct {
+	ID        string
+	ProjectID string
+	Name      string
+	Region    string
+
+	// Credentials defaults to SESCredentialsDefault. AccessKeyID and
+	// SecretAccessKey are required for SESCredentialsStatic and Profile
+	// for SESCredentialsProfile.
+	Credentials     SESCredentials
+	AccessKeyID     string
+	SecretAccessKey string
+	Profile         string
+
+	// ConfigurationSet is the optional SES configuration set used to
+	// publish sending events.
+	ConfigurationSet string
+
+	// Tags are added to every email sent as SES message tags.
+	Tags map[string]string
+
+	// Endpoint optionally overrides the SES API endpoint.
+	Endpoint string
+
+	EmailFrom     string
+	EmailFromName string
+	EmailReplyTo  []string
 }
 
 //
@@ -115,6 +709,18 @@ type Group struct {
 	ModifiedAt ISOTime
 }
 
+// ListGroupsParams is the input parameters for the ListGroups method.
+type ListGroupsParams struct {
+	ProjectID string
+
+	// After is the id of the last group of the previous page. The list
+	// starts at the beginning if it is empty.
+	After string
+
+	// Limit is the maximum number of groups to list. Zero means no limit.
+	Limit int
+}
+
 //
 // templates
 //
@@ -128,6 +734,24 @@ type Template struct {
 	TextDigest string
 	HTML       string
 	HTMLDigest string
+
+	// Subject is the default subject line of emails sent using the
+	// template. It is a text template executed with the template params,
+	// for example "Welcome {{.name}}", and is used when a send does not
+	// specify its own subject.
+	Subject string
+
+	// SourceType is the language the template is written in. For
+	// TemplateSourceMJML templates Source holds the MJML and HTML holds the
+	// HTML compiled from it. For TemplateSourceMarkdown templates Source
+	// holds the Markdown and Text and HTML are rendered from it.
+	SourceType TemplateSource
+	Source     string
+	AssetMode  AssetMode
+
+	// Labels are free-form key/value pairs used to organise and filter
+	// templates.
+	Labels     map[string]string
 	CreatedAt  ISOTime
 	ModifiedAt ISOTime
 }
@@ -141,6 +765,19 @@ type CreateTemplate struct {
 	TextDigest string
 	HTML       string
 	HTMLDigest string
+	Subject    string
+
+	// SourceType defaults to TemplateSourceHTML. For TemplateSourceMJML
+	// templates HTML must be empty and Source holds the MJML, which is
+	// compiled to the template's HTML. For TemplateSourceMarkdown
+	// templates Text and HTML must be empty and Source holds the Markdown,
+	// which is rendered to both.
+	//
+	// TextDigest and HTMLDigest are optional. Empty digests are computed
+	// from the bodies, or for the HTML of MJML templates from the source,
+	// and a digest that is given must match the one computed.
+	SourceType TemplateSource
+	Source     string
 }
 
 // CreateTemplateFromFiles is the input parameters for the CreateTemplateFromFiles method.
@@ -152,6 +789,47 @@ type CreateTemplateFromFiles struct {
 	HTMLFilenames []string
 }
 
+// TemplateSource is the language a template's HTML body is written in.
+type TemplateSource string
+
+// template source types
+const (
+	// TemplateSourceHTML templates are written directly as HTML templates.
+	TemplateSourceHTML TemplateSource = "html"
+
+	// TemplateSourceMJML templates are written in MJML and compiled to
+	// responsive HTML by the service's MJML compiler.
+	TemplateSourceMJML TemplateSource = "mjml"
+
+	// TemplateSourceMarkdown templates are written as a single Markdown
+	// body that is rendered to both the text and the HTML bodies.
+	TemplateSourceMarkdown TemplateSource = "markdown"
+)
+
+// SetTemplateFromFSParams is the input parameters for the
+// SetTemplateFromFS method.
+type SetTemplateFromFSParams struct {
+	ID        string
+	GroupID   string
+	ProjectID string
+
+	// TxtPatterns and HTMLPatterns are fs.Glob patterns such as
+	// "welcome/*.txt". The matched files are concatenated in the order of
+	// the patterns, with the matches of each pattern in lexical order.
+	// Every pattern must match at least one file.
+	TxtPatterns  []string
+	HTMLPatterns []string
+}
+
+// SyncTemplatesReport lists what SyncTemplatesFromDir changed. Templates
+// are identified by id since template ids are unique within a project.
+type SyncTemplatesReport struct {
+	GroupsCreated      []string
+	TemplatesCreated   []string
+	TemplatesUpdated   []string
+	TemplatesUnchanged []string
+}
+
 // SetTemplateParams is the input parameters for the SetTemplateParams method.
 type SetTemplateParams struct {
 	ID         string
@@ -161,18 +839,1474 @@ type SetTemplateParams struct {
 	TextDigest string
 	HTML       string
 	HTMLDigest string
+	Subject    string
+
+	// SourceType and Source are as for CreateTemplate. MJML is only
+	// compiled when the template is created or its HTML digest differs
+	// from the stored one.
+	SourceType TemplateSource
+	Source     string
+
+	// ValidateFixtures, if set, renders the new template with the params
+	// of each of the template's fixtures, with strict params, and leaves
+	// the stored template unchanged if any of them fails.
+	ValidateFixtures bool
 }
 
-//
-// send email
-//
+// ListTemplatesParams is the input parameters for the ListTemplates method.
+type ListTemplatesParams struct {
+	ProjectID string
 
-// SendEmailParams is the input parameters for the SendEmail method.
-type SendEmailParams struct {
-	TemplateID     string
-	ProjectID      string
-	TransportID    string
-	To             []string
-	Subject        string
-	TemplateParams map[string]string
+	// GroupID, if set, lists only the templates in the group.
+	GroupID string
+
+	// Query, if set, lists only the templates whose id contains it.
+	Query string
+
+	// Labels, if set, lists only the templates that have all of the
+	// labels.
+	Labels map[string]string
+
+	// After is the id of the last template of the previous page. The
+	// list starts at the beginning if it is empty.
+	After string
+
+	// Limit is the maximum number of templates to list. Zero means no
+	// limit.
+	Limit int
+}
+
+// Partial is a named template shared by every template in a group. A
+// template includes a partial named footer using {{template "footer" .}}.
+// A template that defines a template with the same name overrides the
+// partial.
+type Partial struct {
+	Name       string
+	GroupID    string
+	ProjectID  string
+	Text       string
+	HTML       string
+	CreatedAt  ISOTime
+	ModifiedAt ISOTime
+}
+
+// SetPartialParams is the input parameters for the SetPartial method.
+type SetPartialParams struct {
+	Name      string
+	ProjectID string
+
+	// GroupID is optional if the project has a default group.
+	GroupID string
+
+	// Text and HTML are the bodies of the partial without a surrounding
+	// {{define}}. Either may be empty if the partial is only used by the
+	// other.
+	Text string
+	HTML string
+}
+
+// TemplateVariant is a translation of a template into a locale. Emails
+// sent with a SendEmailParams.Locale use the variant that best matches the
+// locale, so a variant for fr is used for fr-CA, and fall back to the
+// template's own bodies, which are in the default locale, if no variant
+// matches.
+type TemplateVariant struct {
+	TemplateID string
+	ProjectID  string
+
+	// Locale is the BCP 47 language tag of the variant, for example fr or
+	// en-GB.
+	Locale     string
+	Text       string
+	TextDigest string
+	HTML       string
+	HTMLDigest string
+
+	// Subject is the default subject of emails sent using the variant. If
+	// empty the template's subject is used.
+	Subject    string
+	CreatedAt  ISOTime
+	ModifiedAt ISOTime
+}
+
+// SetTemplateVariantParams is the input parameters for the
+// SetTemplateVariant method.
+type SetTemplateVariantParams struct {
+	TemplateID string
+	ProjectID  string
+	Locale     string
+	Text       string
+	HTML       string
+	Subject    string
+}
+
+// TemplateFixture is a named set of sample template params stored with a
+// template, for example "new-customer" or "gift-order". Fixtures are used
+// to render the template without sending it and to check changes to it.
+type TemplateFixture struct {
+	TemplateID string
+	ProjectID  string
+	Name       string
+	Params     map[string]any
+	CreatedAt  ISOTime
+	ModifiedAt ISOTime
+}
+
+// SetTemplateFixtureParams is the input parameters for the
+// SetTemplateFixture method.
+type SetTemplateFixtureParams struct {
+	TemplateID string
+	ProjectID  string
+	Name       string
+	Params     map[string]any
+}
+
+// RenderTemplateParams is the input parameters for the RenderTemplate
+// method.
+type RenderTemplateParams struct {
+	ProjectID  string
+	TemplateID string
+
+	// Locale is as for SendEmailParams.
+	Locale string
+
+	// TemplateParams are the params to render the template with. They
+	// are ignored if Fixture is set.
+	TemplateParams any
+
+	// Fixture, if set, names the template fixture whose params are used.
+	Fixture string
+
+	// StrictParams and PlainTextParams are as for SendEmailParams.
+	StrictParams    bool
+	PlainTextParams bool
+}
+
+// RenderedTemplate is a template rendered by RenderTemplate.
+type RenderedTemplate struct {
+	Subject string
+	Text    string
+	HTML    string
+}
+
+// LintTemplateParams is the input parameters for the LintTemplate method.
+// The HTML, SourceType and Source are as for CreateTemplate.
+type LintTemplateParams struct {
+	HTML       string
+	SourceType TemplateSource
+	Source     string
+}
+
+// TemplateLintRule names a check made by LintTemplate.
+type TemplateLintRule string
+
+// template lint rules
+const (
+	// TemplateLintExternalCSS flags stylesheets linked with <link> or
+	// @import, which Gmail and most webmail clients remove.
+	TemplateLintExternalCSS TemplateLintRule = "external_css"
+
+	// TemplateLintUnsupportedTag flags elements such as <script>, <form>,
+	// <iframe> and <video> that email clients remove or do not render.
+	TemplateLintUnsupportedTag TemplateLintRule = "unsupported_tag"
+
+	// TemplateLintMissingAlt flags images without an alt attribute, which
+	// are shown as nothing useful when images are blocked.
+	TemplateLintMissingAlt TemplateLintRule = "missing_alt"
+
+	// TemplateLintWideImage flags images wider than 600 pixels, the
+	// width most email layouts are designed for.
+	TemplateLintWideImage TemplateLintRule = "wide_image"
+)
+
+// TemplateLintWarning is a construct in a template's HTML that is known to
+// break in some email clients.
+type TemplateLintWarning struct {
+	Rule TemplateLintRule
+
+	// Line is the line of the HTML the construct starts on, counting
+	// from 1.
+	Line int
+
+	// Element is the name of the element, such as img.
+	Element string
+
+	Message string
+}
+
+// TemplateInspection describes the parameters referenced by a template.
+type TemplateInspection struct {
+	TemplateID string
+	ProjectID  string
+
+	// Params are the names of the template params referenced by the text
+	// and HTML templates, sorted and without duplicates.
+	Params []string
+}
+
+// Validate checks that params has a value for every parameter referenced
+// by the template. params is a map with string keys or a struct, as
+// accepted by SendEmailParams.TemplateParams. If any are missing it
+// returns a ServiceError with code ErrMissingTemplateParamsCode that wraps
+// a *MissingParamsError.
+func (ti *TemplateInspection) Validate(params any) error {
+	var missing []string
+	for _, name := range ti.Params {
+		if !hasParam(params, name) {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	return NewServiceError(ErrMissingTemplateParamsCode, &MissingParamsError{
+		TemplateID: ti.TemplateID,
+		Params:     missing,
+	})
+}
+
+// hasParam reports whether params has a map entry, struct field or method
+// with the given name.
+func hasParam(params any, name string) bool {
+	v := reflect.ValueOf(params)
+	for v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return false
+		}
+		v = v.Elem()
+	}
+	switch v.Kind() {
+	case reflect.Map:
+		kt := v.Type().Key()
+		if kt.Kind() != reflect.String {
+			return false
+		}
+		return v.MapIndex(reflect.ValueOf(name).Convert(kt)).IsValid()
+	case reflect.Struct:
+		if _, ok := v.Type().FieldByName(name); ok {
+			return true
+		}
+		_, ok := reflect.PointerTo(v.Type()).MethodByName(name)
+		return ok
+	}
+	return false
+}
+
+// MissingParamsError lists the template params missing from a send.
+type MissingParamsError struct {
+	TemplateID string
+	Params     []string
+}
+
+// Error returns the error message.
+func (e *MissingParamsError) Error() string {
+	return fmt.Sprintf("template %q is missing params %v", e.TemplateID, e.Params)
+}
+
+// InvalidAddress is an email address that failed validation.
+type InvalidAddress struct {
+	// Field is where the address was given: to, cc, bcc, from or
+	// reply_to.
+	Field   string
+	Address string
+	Reason  string
+}
+
+// InvalidAddressesError lists the addresses of an email or transport
+// that failed validation.
+type InvalidAddressesError struct {
+	Addresses []InvalidAddress
+}
+
+// Error returns the error message.
+func (e *InvalidAddressesError) Error() string {
+	var b strings.Builder
+	b.WriteString("invalid email addresses:")
+	for i, a := range e.Addresses {
+		if i > 0 {
+			b.WriteString(";")
+		}
+		fmt.Fprintf(&b, " %s %q: %s", a.Field, a.Address, a.Reason)
+	}
+	return b.String()
+}
+
+//
+// send email
+//
+
+// SendEmailParams is the input parameters for the SendEmail method.
+type SendEmailParams struct {
+	TemplateID string
+	ProjectID  string
+
+	// TransportID is optional if the project has a default transport.
+	TransportID string
+	To          []string
+	Subject     string
+
+	// TemplateParams is the data the template is executed with. It is
+	// usually a map[string]string or a map[string]any, which allows
+	// nested maps, slices, numbers and booleans to be used with range
+	// and if, but it may be any map with string keys or a struct. It must
+	// encode to a JSON object, which is how it is recorded in the mail
+	// queue.
+	TemplateParams any
+
+	// Cc and Bcc are optional additional recipients, for example an
+	// audit mailbox. Bcc recipients are not shown in the message headers.
+	Cc  []string
+	Bcc []string
+
+	// Timezone is the recipient's IANA time zone, for example
+	// Europe/London. If set, send windows are evaluated in the
+	// recipient's local time rather than the window's own time zone.
+	Timezone string
+
+	// Locale is the BCP 47 language tag, for example fr or en-GB, used to
+	// choose the template's variant and to translate messages in the
+	// template with the t function. If the template has no variant or the
+	// project has no catalog for the locale the closest match is used,
+	// falling back to the template's own bodies.
+	Locale string
+
+	// MessageStream selects the message stream for transports that
+	// support them, such as Postmark, so that transactional and broadcast
+	// traffic can be separated. It is ignored by other transports.
+	MessageStream string
+
+	// SendAt schedules an email queued with SendEmailAsync for delivery
+	// no earlier than the given time. If zero the email is delivered as
+	// soon as possible. SendEmail ignores SendAt.
+	SendAt time.Time
+
+	// Attachments are attached to this email only, in addition to any
+	// stored attachments referenced by the template.
+	Attachments []EmailAttachment
+
+	// StrictParams fails the send with ErrMissingTemplateParamsCode if
+	// the template references params missing from TemplateParams, rather
+	// than rendering them as "<no value>".
+	StrictParams bool
+
+	// PlainTextParams renders the text body and subject with the template
+	// params stripped of HTML and with their whitespace collapsed, for
+	// params that hold HTML for the HTML body or that come from users.
+	// The HTML body is rendered with the params unchanged.
+	PlainTextParams bool
+
+	// Unsubscribe adds List-Unsubscribe and List-Unsubscribe-Post
+	// headers for the To recipient so that mail clients can offer
+	// one-click unsubscribe. The headers are left out if there is more
+	// than one To recipient. It requires the service to be configured
+	// with WithUnsubscribeURL. Leave it unset for transactional email
+	// such as password resets.
+	Unsubscribe bool
+
+	// TrackOpens adds a tracking pixel to the HTML body so that opens are
+	// counted in the status of the email. It requires the service to be
+	// configured with WithTrackingURL and is only available for emails
+	// sent with SendEmailAsync. Opens are approximate: mail clients that
+	// block images never count and proxies that prefetch images count
+	// opens that did not happen.
+	TrackOpens bool
+
+	// Headers are extra headers added to the email, for example
+	// X-Campaign-ID, List-Id or Precedence. Headers set by the service,
+	// such as From, To, Subject and Message-ID, cannot be overridden and
+	// names or values containing line breaks are rejected with
+	// ErrInvalidHeadersCode.
+	Headers map[string]string
+
+	// MessageID is the Message-ID of the email, for example
+	// 1234@mail.example.com, with or without the angle brackets. It is
+	// optional and if empty one is generated using the domain of the
+	// transport's from address. Set it to send a synchronous email that
+	// later emails can reply to.
+	MessageID string
+
+	// InReplyTo and References are the Message-IDs of the email being
+	// replied to and of its thread so that recipients' clients show the
+	// reply in the same conversation. If only InReplyTo is set References
+	// defaults to it.
+	InReplyTo  string
+	References []string
+
+	// EmailFrom and EmailFromName optionally override the transport's
+	// sender address and name for this email. EmailFrom must be the
+	// transport's own address or on its sender allow-list, see
+	// SetSenderAllowList, otherwise the send fails with
+	// ErrFromNotAllowedCode.
+	EmailFrom     string
+	EmailFromName string
+
+	// ReplyTo optionally overrides the transport's reply-to addresses
+	// for this email, for example to direct replies to a support
+	// ticket's own address. If empty the transport's addresses are used.
+	ReplyTo []string
+
+	// Labels are free-form key/value pairs recorded with the queued
+	// email, for example feature=signup, so that emails can be filtered
+	// with ListMailQueueParams.Labels.
+	Labels map[string]string
+
+	// CalendarEvent, if set, makes the email a meeting invitation. The
+	// event is added as an iCalendar METHOD:REQUEST part alongside the
+	// text and HTML bodies so that Outlook and Gmail show it with
+	// accept and decline buttons.
+	CalendarEvent *CalendarEvent
+}
+
+// CalendarEvent is a meeting sent as an iCalendar invitation.
+type CalendarEvent struct {
+	// UID identifies the event. It is optional and defaults to the
+	// Message-ID of the email. Send an update or cancellation of an
+	// event with the same UID and a higher Sequence.
+	UID      string
+	Sequence int
+
+	Summary     string
+	Description string
+	Location    string
+
+	// Start and End are the times of the event. End must be after
+	// Start.
+	Start time.Time
+	End   time.Time
+
+	// Organizer is the address replies are sent to. It defaults to the
+	// sender address of the email.
+	Organizer     string
+	OrganizerName string
+
+	// Attendees default to the To and Cc recipients of the email.
+	Attendees []string
+}
+
+// RelayEmailParams is the input parameters for the RelayEmail method.
+type RelayEmailParams struct {
+	ProjectID string
+
+	// TransportID is optional if the project has a default transport.
+	TransportID string
+
+	// Recipients are the addresses the email is delivered to, the
+	// envelope recipients of an SMTP submission. Those that are not in
+	// the To or Cc header of the message are sent the email as Bcc.
+	Recipients []string
+
+	// Message is the email as an RFC 5322 message, headers and MIME body.
+	Message []byte
+}
+
+// SendEmailBatchResult is the outcome of sending or queuing one email of a
+// batch.
+type SendEmailBatchResult struct {
+	// MailQueue is the queued email. It is only set by
+	// SendEmailBatchAsync.
+	MailQueue *MailQueue
+
+	// Err is the reason the email could not be sent or queued, if any.
+	Err error
+}
+
+// SendEmailToManyParams is the input parameters for the SendEmailToMany
+// and SendEmailToManyAsync methods. Each recipient receives their own
+// individually rendered email.
+type SendEmailToManyParams struct {
+	TemplateID string
+	ProjectID  string
+
+	// TransportID is optional if the project has a default transport.
+	TransportID string
+	Subject     string
+
+	// TemplateParams are shared by all recipients. A recipient's own
+	// params take precedence. Both are merged as JSON objects.
+	TemplateParams any
+
+	Recipients []EmailRecipient
+
+	// ListID adds the contacts of a contact list to Recipients, after
+	// any given explicitly.
+	ListID string
+
+	MessageStream string
+	SendAt        time.Time
+
+	// Attachments are attached to every email.
+	Attachments []EmailAttachment
+
+	// Labels are recorded with every email.
+	Labels map[string]string
+}
+
+// TestSendParams is the input parameters for the TestSend method.
+type TestSendParams struct {
+	TemplateID string
+	ProjectID  string
+
+	// TransportID is optional if the project has a default transport.
+	TransportID string
+
+	// TemplateParams are shared by all seed addresses. The attributes of
+	// a seed contact take precedence, as for SendEmailToMany.
+	TemplateParams any
+
+	// SeedListID is the contact list holding the seed addresses, usually
+	// mailboxes at each of the major mailbox providers.
+	SeedListID string
+}
+
+// EmailRecipient is a single recipient of a SendEmailToMany call.
+type EmailRecipient struct {
+	Email          string
+	TemplateParams any
+	Locale         string
+	Timezone       string
+}
+
+// EmailAttachment is a file attached to a single email. Either Path or
+// Content must be set.
+type EmailAttachment struct {
+	// Path is a file on disk that is read when the email is sent or
+	// queued.
+	Path string
+
+	// Filename is optional if Path is set, in which case it defaults to
+	// the base name of Path.
+	Filename string
+
+	// ContentType is optional. If empty it is derived from the filename
+	// extension or by sniffing the content.
+	ContentType string
+	Content     []byte
+}
+
+//
+// mail queue
+//
+
+// MailState is the delivery state of an email in the mail queue.
+type MailState string
+
+// mail queue states
+const (
+	MailStateQueued  MailState = "queued"
+	MailStateSending MailState = "sending"
+	MailStateSent    MailState = "sent"
+	MailStateFailed  MailState = "failed"
+	MailStateBlocked MailState = "blocked"
+
+	// MailStateCancelled is a queued email that was cancelled before it
+	// was delivered.
+	MailStateCancelled MailState = "cancelled"
+
+	// MailStateRateLimited is an email held back because a rate limit
+	// was reached. The queue worker delivers it once NextAttemptAt has
+	// passed, see SetRateLimit.
+	MailStateRateLimited MailState = "rate_limited"
+
+	// MailStateBounced is a sent email that the recipient's mail server
+	// later reported as undeliverable, see MarkMailBounced.
+	MailStateBounced MailState = "bounced"
+
+	// MailStateDeadLetter is an email that failed permanently or ran
+	// out of delivery attempts under the service's retry policy.
+	MailStateDeadLetter MailState = "dead_letter"
+
+	// MailStatePaused is an email of a paused campaign. It is returned
+	// to the queue when the campaign is resumed, see PauseCampaign.
+	MailStatePaused MailState = "paused"
+)
+
+// MailEvent is a change in the state of an email in the mail queue,
+// received by the subscribers of Subscribe.
+type MailEvent struct {
+	MailQueueID string
+	ProjectID   string
+	TemplateID  string
+	TransportID string
+	CampaignID  string
+	State       MailState
+
+	// Error is the error of a failed delivery attempt, or the reason an
+	// email was blocked. It is empty for other events.
+	Error string
+	Time  ISOTime
+}
+
+// MailEventFilter selects the events received by a subscriber. An empty
+// ProjectID or States matches every project or state.
+type MailEventFilter struct {
+	ProjectID string
+	States    []MailState
+
+	// Buffer is the number of events held for a subscriber that is not
+	// receiving them, 64 if zero. Events are dropped while it is full.
+	Buffer int
+}
+
+// MailQueue represents an email in the mail queue. If the body has been
+// redacted by the service's retention policy, Text, HTML and
+// TemplateParams may be empty or truncated, but the digests of the
+// originally rendered bodies are always kept.
+type MailQueue struct {
+	ID             string
+	ProjectID      string
+	TemplateID     string
+	TransportID    string
+	State          MailState
+	To             []string
+	Cc             []string
+	Bcc            []string
+	Subject        string
+	EmailFrom      string
+	EmailFromName  string
+	ReplyTo        []string
+	MessageStream  string
+	Headers        map[string]string
+	Text           string
+	TextDigest     string
+	HTML           string
+	HTMLDigest     string
+	TemplateParams map[string]any
+	Redacted       bool
+	LastError      string
+
+	// TrackOpens is set if the HTML body has a tracking pixel.
+	TrackOpens bool
+
+	// Attempts is the number of delivery attempts made so far.
+	Attempts int
+
+	// SentAt is the time the email was delivered. It is zero for emails
+	// that have not been sent.
+	SentAt ISOTime
+
+	// SentTransportID is the transport that delivered the email. It
+	// differs from TransportID if the email was sent through a fallback
+	// transport of the project's TransportChain.
+	SentTransportID string
+
+	// CampaignID is the campaign the email was queued for, if any.
+	CampaignID string
+
+	// Test is set for emails queued by TestSend, which are left out of
+	// GetProjectStats.
+	Test bool
+
+	// Labels are the labels the email was queued with.
+	Labels map[string]string
+
+	// SendAt is the earliest time the email may be delivered.
+	SendAt ISOTime
+
+	// NextAttemptAt is the earliest time the worker will attempt delivery
+	// and DeferralReason records why delivery was last deferred.
+	NextAttemptAt  ISOTime
+	DeferralReason string
+
+	// MessageID is the Message-ID header of the email without the angle
+	// brackets. InReplyTo and References are the Message-IDs of the email
+	// it replies to and of its thread.
+	MessageID  string
+	InReplyTo  string
+	References []string
+	CreatedAt  ISOTime
+	ModifiedAt ISOTime
+}
+
+// ListMailQueueParams is the input parameters for the ListMailQueue
+// method.
+type ListMailQueueParams struct {
+	ProjectID string
+
+	// State only lists emails in the given state. All emails are listed
+	// if it is empty.
+	State MailState
+
+	// Labels, if set, lists only the emails that have all of the labels.
+	Labels map[string]string
+
+	// After is the id of the last email of the previous page. The list
+	// starts with the newest email if it is empty.
+	After string
+
+	// Limit is the maximum number of emails to list. Zero means no limit.
+	Limit int
+}
+
+// ListDeadLettersParams is the input parameters for the ListDeadLetters
+// method.
+type ListDeadLettersParams struct {
+	ProjectID string
+
+	// After is the id of the last email of the previous page. The list
+	// starts with the newest email if it is empty.
+	After string
+
+	// Limit is the maximum number of emails to list. Zero means no limit.
+	Limit int
+}
+
+// RequeueDeadLetterParams is the input parameters for the
+// RequeueDeadLetter method.
+type RequeueDeadLetterParams struct {
+	ProjectID   string
+	MailQueueID string
+
+	// TransportID, if set, is the transport used to deliver the email
+	// instead of the one it was queued with.
+	TransportID string
+}
+
+// MailQueueAttempt is the outcome of a single delivery attempt of an
+// email. State is sent or failed, and Error holds the reason a failed
+// attempt failed. ResponseCode and Response are the SMTP reply code and
+// text, or the HTTP status and body for API transports, when the transport
+// reported them. The attempt started at StartedAt and finished at
+// CreatedAt.
+type MailQueueAttempt struct {
+	Attempt      int
+	TransportID  string
+	State        MailState
+	Error        string
+	ResponseCode int
+	Response     string
+	StartedAt    ISOTime
+	CreatedAt    ISOTime
+}
+
+// MailStatus is the delivery status of an email without its body.
+type MailStatus struct {
+	ID          string
+	ProjectID   string
+	TemplateID  string
+	TransportID string
+	State       MailState
+	To          []string
+	Subject     string
+	LastError   string
+	Attempts    int
+
+	// QueuedAt is the time the email was queued, SentAt the time it was
+	// delivered (zero if it has not been) and UpdatedAt the time of the
+	// last change of state.
+	QueuedAt       ISOTime
+	SendAt         ISOTime
+	NextAttemptAt  ISOTime
+	DeferralReason string
+	SentAt         ISOTime
+	UpdatedAt      ISOTime
+
+	// SentTransportID is the transport that delivered the email, see
+	// MailQueue.SentTransportID.
+	SentTransportID string
+
+	// History lists the delivery attempts in the order they were made.
+	// It is only set by GetMailStatus.
+	History []*MailQueueAttempt
+
+	// Opens is the number of times the tracking pixel of an email sent
+	// with TrackOpens was loaded, and FirstOpenedAt and LastOpenedAt the
+	// times of the first and latest (zero if it has not been opened). They
+	// are only set by GetMailStatus.
+	Opens         int
+	FirstOpenedAt ISOTime
+	LastOpenedAt  ISOTime
+}
+
+// MailCounts counts emails by state. Queued counts every email queued,
+// whatever its state now, and the others count the emails by their current
+// state, so emails still waiting to be sent are counted by Queued alone.
+type MailCounts struct {
+	Queued    int
+	Sent      int
+	Failed    int
+	Bounced   int
+	Blocked   int
+	Cancelled int
+}
+
+// ProjectStats reports the emails of a project queued in the time range
+// From to To, in total and broken down by the UTC day they were queued,
+// their template and their transport. Days on which no emails were queued
+// are left out. The breakdowns are sorted by day or id.
+type ProjectStats struct {
+	ProjectID  string
+	From       ISOTime
+	To         ISOTime
+	Total      MailCounts
+	Days       []*DayMailCounts
+	Templates  []*TemplateMailCounts
+	Transports []*TransportMailCounts
+}
+
+// DayMailCounts counts the emails queued on a UTC day, formatted as
+// 2006-01-02.
+type DayMailCounts struct {
+	Day string
+	MailCounts
+}
+
+// TemplateMailCounts counts the emails rendered from a template.
+type TemplateMailCounts struct {
+	TemplateID string
+	MailCounts
+}
+
+// TransportMailCounts counts the emails sent through a transport.
+type TransportMailCounts struct {
+	TransportID string
+	MailCounts
+}
+
+// QueueStats reports the state of the mail queue of a project at CheckedAt,
+// for callers that hold back new emails while the queue is backed up.
+type QueueStats struct {
+	ProjectID string
+
+	// States counts the emails in the mail queue by state. States with no
+	// emails are left out.
+	States map[MailState]int
+
+	// Pending is the number of emails waiting to be sent: those queued,
+	// sending or rate limited. OldestPendingAt is the time the oldest of
+	// them was queued and OldestPendingAge how long ago that was, both
+	// zero if there are none.
+	Pending          int
+	OldestPendingAt  ISOTime
+	OldestPendingAge time.Duration
+
+	// Attempts and FailedAttempts count the delivery attempts made in the
+	// hour before CheckedAt, and FailureRate is the fraction of them that
+	// failed, or zero if there were none.
+	Attempts       int
+	FailedAttempts int
+	FailureRate    float64
+	CheckedAt      ISOTime
+}
+
+//
+// send windows
+//
+
+// SendWindow is a daily window during which emails can be delivered.
+// Outside of the window the queue worker holds emails until the window
+// next opens. StartTime and EndTime are in 24 hour HH:MM format. If
+// EndTime is before StartTime the window spans midnight.
+type SendWindow struct {
+	ProjectID string
+
+	// GroupID restricts the window to templates in a group. If empty
+	// the window applies to every group in the project that does not
+	// have a window of its own.
+	GroupID    string
+	StartTime  string
+	EndTime    string
+	Timezone   string
+	CreatedAt  ISOTime
+	ModifiedAt ISOTime
+}
+
+// SetSendWindowParams is the input parameters for the SetSendWindow method.
+type SetSendWindowParams struct {
+	ProjectID string
+	GroupID   string
+	StartTime string
+	EndTime   string
+	Timezone  string
+}
+
+//
+// webhooks
+//
+
+// WebhookEvent is the type of a mail lifecycle event sent to webhooks.
+type WebhookEvent string
+
+const (
+	// WebhookEventQueued is sent when an email is added to the mail
+	// queue.
+	WebhookEventQueued WebhookEvent = "mail.queued"
+
+	// WebhookEventSent is sent when an email is delivered.
+	WebhookEventSent WebhookEvent = "mail.sent"
+
+	// WebhookEventFailed is sent when an email leaves the mail queue
+	// without being delivered, in the failed or dead_letter state.
+	WebhookEventFailed WebhookEvent = "mail.failed"
+
+	// WebhookEventBounced is sent when a sent email is marked as
+	// bounced.
+	WebhookEventBounced WebhookEvent = "mail.bounced"
+)
+
+// Webhook is an endpoint that receives signed JSON events for the emails
+// of a project. The signing secret is never returned.
+type Webhook struct {
+	ID        string
+	ProjectID string
+	URL       string
+
+	// Events lists the events sent to the endpoint. If empty every event
+	// is sent.
+	Events     []WebhookEvent
+	CreatedAt  ISOTime
+	ModifiedAt ISOTime
+}
+
+// CreateWebhookParams is the input parameters for the CreateWebhook
+// method.
+type CreateWebhookParams struct {
+	ID        string
+	ProjectID string
+
+	// URL must use https, unless it is a loopback address.
+	URL string
+
+	// Secret signs each request with HMAC-SHA256. See
+	// service.VerifyWebhookSignature.
+	Secret string
+	Events []WebhookEvent
+}
+
+// WebhookDeliveryState is the state of a webhook delivery.
+type WebhookDeliveryState string
+
+const (
+	WebhookDeliveryStatePending   WebhookDeliveryState = "pending"
+	WebhookDeliveryStateSending   WebhookDeliveryState = "sending"
+	WebhookDeliveryStateDelivered WebhookDeliveryState = "delivered"
+	WebhookDeliveryStateFailed    WebhookDeliveryState = "failed"
+)
+
+// WebhookDelivery is a single event sent, or to be sent, to a webhook.
+// ResponseCode is the HTTP status of the last attempt, if the endpoint
+// responded.
+type WebhookDelivery struct {
+	ID            string
+	WebhookID     string
+	ProjectID     string
+	Event         WebhookEvent
+	State         WebhookDeliveryState
+	Attempts      int
+	ResponseCode  int
+	LastError     string
+	NextAttemptAt ISOTime
+	CreatedAt     ISOTime
+	ModifiedAt    ISOTime
+}
+
+//
+// rate limits
+//
+
+// RateLimit caps the number of emails the queue worker delivers for a
+// project, or for a single transport within a project. Each limit counts
+// the emails sent in the current second, minute or UTC day. A limit of
+// zero is unlimited.
+type RateLimit struct {
+	ProjectID string
+
+	// TransportID restricts the limit to one transport. If empty the
+	// limit applies to the emails sent by all of the project's
+	// transports together.
+	TransportID string
+	PerSecond   int
+	PerMinute   int
+	PerDay      int
+	CreatedAt   ISOTime
+	ModifiedAt  ISOTime
+}
+
+// SetRateLimitParams is the input parameters for the SetRateLimit method.
+type SetRateLimitParams struct {
+	ProjectID   string
+	TransportID string
+	PerSecond   int
+	PerMinute   int
+	PerDay      int
+}
+
+//
+// project quotas
+//
+
+// QuotaAction decides what happens to emails over a project's quota.
+type QuotaAction string
+
+// Quota actions.
+const (
+	// QuotaActionReject rejects emails over the quota with an error code
+	// of ErrQuotaExceededCode.
+	QuotaActionReject QuotaAction = "reject"
+
+	// QuotaActionDefer queues emails over the quota for delivery on the
+	// first day with room under the quota. Emails sent immediately with
+	// SendEmail are rejected.
+	QuotaActionDefer QuotaAction = "defer"
+)
+
+// ProjectQuota caps the number of emails a project may send per UTC day
+// and per calendar month. Unlike a RateLimit, which paces delivery, the
+// quota is counted when an email is accepted by SendEmail or
+// SendEmailAsync. A limit of zero is unlimited.
+type ProjectQuota struct {
+	ProjectID  string
+	PerDay     int
+	PerMonth   int
+	Action     QuotaAction
+	CreatedAt  ISOTime
+	ModifiedAt ISOTime
+}
+
+// SetProjectQuotaParams is the input parameters for the SetProjectQuota
+// method. Action defaults to QuotaActionReject.
+type SetProjectQuotaParams struct {
+	ProjectID string
+	PerDay    int
+	PerMonth  int
+	Action    QuotaAction
+}
+
+// ProjectQuotaUsage is the number of emails a project has sent on the
+// current UTC day and in the current month, with the limits of its quota.
+type ProjectQuotaUsage struct {
+	ProjectID string
+
+	// Day is the current day as YYYY-MM-DD and Month the current month
+	// as YYYY-MM.
+	Day       string
+	Month     string
+	DaySent   int
+	MonthSent int
+
+	// PerDay and PerMonth are the limits of the quota, or zero if the
+	// project has no quota.
+	PerDay   int
+	PerMonth int
+}
+
+//
+// sender allow-lists
+//
+
+// SenderAllowList is the verified sender addresses a transport may send
+// from in place of its own, using SendEmailParams.EmailFrom. Addresses
+// are compared without regard to case.
+type SenderAllowList struct {
+	ProjectID   string
+	TransportID string
+	Emails      []string
+	CreatedAt   ISOTime
+	ModifiedAt  ISOTime
+}
+
+// SetSenderAllowListParams is the input parameters for the
+// SetSenderAllowList method.
+type SetSenderAllowListParams struct {
+	ProjectID   string
+	TransportID string
+	Emails      []string
+}
+
+//
+// campaigns
+//
+
+// CampaignState is the state of a campaign.
+type CampaignState string
+
+const (
+	CampaignStateRunning CampaignState = "running"
+	CampaignStatePaused  CampaignState = "paused"
+)
+
+// Campaign is a bulk send of a template to a list of recipients. Every
+// email of the campaign is queued when it is created and carries the
+// campaign's ID. Recipients is the number of recipients it was created
+// with, including any whose email could not be queued.
+type Campaign struct {
+	ID          string
+	ProjectID   string
+	Name        string
+	TemplateID  string
+	TransportID string
+	ListID      string
+	State       CampaignState
+	Recipients  int
+	CreatedAt   ISOTime
+	ModifiedAt  ISOTime
+}
+
+// CreateCampaignParams is the input parameters for the CreateCampaign
+// method. Each recipient receives their own individually rendered email,
+// as with SendEmailToManyAsync.
+type CreateCampaignParams struct {
+	ID         string
+	ProjectID  string
+	Name       string
+	TemplateID string
+
+	// TransportID is optional if the project has a default transport.
+	TransportID string
+	Subject     string
+
+	// TemplateParams are shared by all recipients. A recipient's own
+	// params take precedence.
+	TemplateParams any
+	Recipients     []EmailRecipient
+
+	// ListID adds the contacts of a contact list to Recipients.
+	ListID string
+
+	MessageStream string
+	SendAt        time.Time
+	Unsubscribe   bool
+	TrackOpens    bool
+}
+
+// CampaignStats is the progress of a campaign. Failed includes dead
+// letters and blocked emails, and Remaining counts the emails still to be
+// delivered, including those held back by a pause. Rejected is the number
+// of recipients whose email could not be queued.
+type CampaignStats struct {
+	CampaignID string
+	ProjectID  string
+	State      CampaignState
+	Recipients int
+	Rejected   int
+	Sent       int
+	Failed     int
+	Bounced    int
+	Cancelled  int
+	Remaining  int
+}
+
+//
+// contact lists
+//
+
+// ContactList is a named list of recipients kept by the service, which
+// SendEmailToMany and CreateCampaign can reference by ID. Contacts is the
+// number of contacts on the list.
+type ContactList struct {
+	ID         string
+	ProjectID  string
+	Name       string
+	Contacts   int
+	CreatedAt  ISOTime
+	ModifiedAt ISOTime
+}
+
+// CreateContactListParams is the input parameters for the
+// CreateContactList method.
+type CreateContactListParams struct {
+	ID        string
+	ProjectID string
+	Name      string
+}
+
+// Contact is a recipient on a contact list. Attributes are passed to the
+// template as the recipient's params, and Locale and Timezone are used as
+// for an EmailRecipient.
+type Contact struct {
+	Email      string
+	Attributes map[string]string
+	Locale     string
+	Timezone   string
+	CreatedAt  ISOTime
+	ModifiedAt ISOTime
+}
+
+// ListContactsParams is the input parameters for the ListContacts method.
+type ListContactsParams struct {
+	ProjectID string
+	ListID    string
+
+	// After is the last address of the previous page. The list starts
+	// with the first address if it is empty.
+	After string
+
+	// Limit is the maximum number of contacts to list. Zero means no
+	// limit.
+	Limit int
+}
+
+//
+// suppressions
+//
+
+// SuppressionReason records why an address is on a suppression list.
+type SuppressionReason string
+
+const (
+	SuppressionReasonBounce      SuppressionReason = "bounce"
+	SuppressionReasonComplaint   SuppressionReason = "complaint"
+	SuppressionReasonManual      SuppressionReason = "manual"
+	SuppressionReasonUnsubscribe SuppressionReason = "unsubscribe"
+)
+
+// Suppression is a recipient address a project no longer sends email to.
+// Emails to a suppressed address are not delivered. Addresses are
+// compared without regard to case.
+type Suppression struct {
+	ProjectID string
+	Email     string
+	Reason    SuppressionReason
+
+	// Detail is the diagnostic from the bounce or complaint, if any.
+	Detail     string
+	CreatedAt  ISOTime
+	ModifiedAt ISOTime
+}
+
+// AddSuppressionParams is the input parameters for the AddSuppression
+// method. Reason defaults to SuppressionReasonManual.
+type AddSuppressionParams struct {
+	ProjectID string
+	Email     string
+	Reason    SuppressionReason
+	Detail    string
+}
+
+// ListSuppressionsParams is the input parameters for the ListSuppressions
+// method.
+type ListSuppressionsParams struct {
+	ProjectID string
+
+	// After is the address of the last suppression of the previous page.
+	// The list starts at the beginning if it is empty.
+	After string
+
+	// Limit is the maximum number of suppressions to list. Zero means no
+	// limit.
+	Limit int
+}
+
+//
+// message catalogs
+//
+
+// MessageCatalog holds the translated messages for a project and locale.
+// Templates call {{ t "welcome.heading" }} to translate a message, or
+// {{ t "inbox.unread" .count }} to choose a plural form.
+type MessageCatalog struct {
+	ProjectID string
+	Locale    string
+
+	// Messages is a JSON object in the go-i18n message file format, e.g.
+	// {"welcome": {"heading": "Welcome {{.name}}"},
+	//  "inbox": {"unread": {"one": "1 new message", "other": "{{.PluralCount}} new messages"}}}
+	Messages   []byte
+	CreatedAt  ISOTime
+	ModifiedAt ISOTime
+}
+
+// SetMessageCatalogParams is the input parameters for the
+// SetMessageCatalog method.
+type SetMessageCatalogParams struct {
+	ProjectID string
+	Locale    string
+	Messages  []byte
+}
+
+//
+// attachments
+//
+
+// Attachment is a file stored once per project that templates can
+// reference so that it is attached to every email they send, for example
+// a terms and conditions PDF. Content is not populated by ListAttachments.
+type Attachment struct {
+	ID          string
+	ProjectID   string
+	Filename    string
+	ContentType string
+	Content     []byte
+	Size        int
+	Checksum    string
+	CreatedAt   ISOTime
+	ModifiedAt  ISOTime
+}
+
+// SetAttachmentParams is the input parameters for the SetAttachment method.
+type SetAttachmentParams struct {
+	ID        string
+	ProjectID string
+	Filename  string
+
+	// ContentType is optional. If empty it is derived from the filename
+	// extension or by sniffing the content.
+	ContentType string
+	Content     []byte
+}
+
+//
+// assets
+//
+
+// AssetMode controls how {{asset "id"}} is rendered in a template's HTML.
+type AssetMode string
+
+// asset modes
+const (
+	// AssetModeCID embeds the asset in the email as an inline attachment
+	// and references it using a cid: URL.
+	AssetModeCID AssetMode = "cid"
+
+	// AssetModeURL references the hosted copy of the asset below the
+	// service's asset base URL.
+	AssetModeURL AssetMode = "url"
+)
+
+// Asset is an image stored once per project that templates reference
+// using {{asset "id"}}. Content is not populated by ListAssets.
+type Asset struct {
+	ID          string
+	ProjectID   string
+	Filename    string
+	ContentType string
+	Content     []byte
+	Size        int
+	Checksum    string
+
+	// ContentID is the Content-ID the asset is embedded with, so HTML
+	// built outside of a template can reference it as cid:{ContentID}.
+	// URL is the hosted copy of the asset, or empty if the service has
+	// no asset base URL.
+	ContentID  string
+	URL        string
+	CreatedAt  ISOTime
+	ModifiedAt ISOTime
+}
+
+// SetAssetParams is the input parameters for the SetAsset method.
+type SetAssetParams struct {
+	ID        string
+	ProjectID string
+	Filename  string
+
+	// ContentType is optional. If empty it is derived from the filename
+	// extension or by sniffing the content.
+	ContentType string
+	Content     []byte
+}
+
+//
+// api keys
+//
+
+// APIKeyRole is the access an API key grants to its projects. Each role
+// includes the access of the roles below it.
+type APIKeyRole string
+
+// api key roles
+const (
+	// APIKeyRoleAdmin manages the resources of its projects as well as
+	// sending email.
+	APIKeyRoleAdmin APIKeyRole = "admin"
+
+	// APIKeyRoleSender sends email and reads the resources of its
+	// projects.
+	APIKeyRoleSender APIKeyRole = "sender"
+
+	// APIKeyRoleReadOnly only reads the resources of its projects.
+	APIKeyRoleReadOnly APIKeyRole = "read_only"
+)
+
+var apiKeyRoleRank = map[APIKeyRole]int{
+	APIKeyRoleReadOnly: 1,
+	APIKeyRoleSender:   2,
+	APIKeyRoleAdmin:    3,
+}
+
+// Valid reports whether r is a known role.
+func (r APIKeyRole) Valid() bool {
+	return apiKeyRoleRank[r] > 0
+}
+
+// APIKey is a key for the REST API scoped to one or more projects. Only a
+// hash of the key is stored, so Key is populated by CreateAPIKey alone.
+type APIKey struct {
+	ID         string
+	Name       string
+	Role       APIKeyRole
+	ProjectIDs []string
+	Key        string
+	CreatedAt  ISOTime
+	ModifiedAt ISOTime
+}
+
+// Allows reports whether the key grants at least role on projectID.
+func (k *APIKey) Allows(projectID string, role APIKeyRole) bool {
+	if apiKeyRoleRank[k.Role] < apiKeyRoleRank[role] {
+		return false
+	}
+	for _, id := range k.ProjectIDs {
+		if id == projectID {
+			return true
+		}
+	}
+	return false
+}
+
+// CreateAPIKeyParams is the input parameters for the CreateAPIKey method.
+type CreateAPIKeyParams struct {
+	Name       string
+	Role       APIKeyRole
+	ProjectIDs []string
+}
+
+// SchemaVersion is the migration state of the database schema.
+type SchemaVersion struct {
+	// Version is the last migration applied, or 0 if none has been.
+	Version uint
+
+	// Dirty is set if the last migration failed part way through.
+	Dirty bool
+
+	// Latest is the version of the newest migration known to the
+	// service. The schema is up to date when Version equals Latest.
+	Latest uint
+}
+
+// Health is the state of the service reported by Service.Health.
+type Health struct {
+	// Healthy is set if the database can be reached, its schema is up
+	// to date and the encryption key can be used. Transport
+	// verifications do not affect it.
+	Healthy bool
+
+	Database      HealthCheck
+	Schema        HealthCheck
+	EncryptionKey HealthCheck
+
+	// SchemaVersion is the migration state of the database schema, or
+	// nil if the store has no schema or it could not be read.
+	SchemaVersion *SchemaVersion
+
+	// Transports holds the latest verification of every transport that
+	// has been verified, see Service.VerifyTransport.
+	Transports []*TransportHealth
+	CheckedAt  ISOTime
+}
+
+// HealthCheck is the outcome of one of the checks made by Service.Health.
+// Error is empty if the check passed.
+type HealthCheck struct {
+	OK    bool
+	Error string
+}
+
+// TransportHealth is the outcome of the latest verification of a
+// transport. Error is empty if the verification succeeded.
+type TransportHealth struct {
+	ProjectID   string
+	TransportID string
+	OK          bool
+	Error       string
+	VerifiedAt  ISOTime
 }
@@ -0,0 +1,64 @@
+package email
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// maxErrorBody is the number of bytes of an API error response kept in
+// the returned error.
+const maxErrorBody = 512
+
+// APIError is returned by the HTTP API transports when the provider
+// responds with a non 2xx status code.
+type APIError struct {
+	Provider   string
+	StatusCode int
+	Body       string
+}
+
+// Error returns the error message.
+func (e *APIError) Error() string {
+	return fmt.Sprintf("%s api returned status %d: %s", e.Provider, e.StatusCode, e.Body)
+}
+
+// Permanent reports whether retrying the request will not help. Client
+// errors are permanent except for timeouts and rate limiting.
+func (e *APIError) Permanent() bool {
+	switch e.StatusCode {
+	case http.StatusRequestTimeout, http.StatusTooManyRequests:
+		return false
+	}
+	return e.StatusCode >= 400 && e.StatusCode < 500
+}
+
+// formatAddress formats an email address with an optional display name.
+func formatAddress(name, address string) string {
+	if name == "" {
+		return address
+	}
+	return fmt.Sprintf("%s <%s>", name, address)
+}
+
+// doRequest sends an API request and returns an *APIError if the response
+// status is not 2xx.
+func doRequest(client *http.Client, req *http.Request, provider string) error {
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+	b, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+	return &APIError{
+		Provider:   provider,
+		StatusCode: resp.StatusCode,
+		Body:       strings.TrimSpace(string(b)),
+	}
+}
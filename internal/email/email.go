@@ -1,7 +1,25 @@
 package email
 
+import (
+	"bytes"
+	"context"
+	"io"
+	"sort"
+	"strings"
+
+	jemail "github.com/jordan-wright/email"
+)
+
+// Sender sends emails. SendEmail returns once ctx is done, with an error
+// wrapping ctx.Err() if the email may not have been sent.
 type Sender interface {
-	SendEmail(params EmailParams) error
+	SendEmail(ctx context.Context, params EmailParams) error
+}
+
+// Verifier is implemented by transports that can check their settings and
+// credentials without sending an email.
+type Verifier interface {
+	Verify(ctx context.Context) error
 }
 
 // EmailParams are the parameters for sending an email.
@@ -13,9 +31,21 @@ type EmailParams struct {
 	Text string
 	HTML string
 
-	// From optional override for default sender
-	From string
-	ReplyTo string
+	// WriteHTML, if set, writes the HTML body in place of HTML. The SMTP,
+	// SES and file transports call it as the message is written so that
+	// a large body is rendered straight into the message rather than
+	// held in memory. The other transports read it using HTMLBody. It
+	// may be called more than once.
+	WriteHTML func(w io.Writer) error
+
+	// From and FromName optionally override the transport's sender
+	// address and name. The caller is responsible for checking that the
+	// transport may send from the address.
+	From     string
+	FromName string
+
+	// ReplyTo optionally overrides the transport's reply-to addresses.
+	ReplyTo []string
 
 	// To, Cc, Bcc are the recipients of the email
 	To  []string
@@ -23,5 +53,96 @@ type EmailParams struct {
 	Bcc []string
 
 	// Attachments are the files to attach to the email
-	Attachments []string
+	Attachments []Attachment
+
+	// MessageStream optionally selects the provider's message stream,
+	// for transports that support them. It is ignored by the others.
+	MessageStream string
+
+	// MessageID, if set, is used as the Message-ID header, without the
+	// angle brackets. Transports whose provider assigns its own
+	// Message-ID ignore it.
+	MessageID string
+
+	// Headers are extra headers added to the email, for example
+	// List-Unsubscribe.
+	Headers map[string]string
+}
+
+// HTMLBody returns the HTML body of the email, calling WriteHTML if set.
+func (p EmailParams) HTMLBody() (string, error) {
+	if p.WriteHTML == nil {
+		return p.HTML, nil
+	}
+	var b strings.Builder
+	if err := p.WriteHTML(&b); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// fromOverride returns the sender name and address of an email, using
+// the overrides in params in place of the transport's name and address.
+func fromOverride(params EmailParams, name, address string) (string, string) {
+	if params.FromName != "" {
+		name = params.FromName
+	}
+	if params.From != "" {
+		address = params.From
+	}
+	return name, address
+}
+
+// replyToOverride returns the reply-to addresses of an email, using the
+// override in params in place of the transport's addresses.
+func replyToOverride(params EmailParams, replyTo []string) []string {
+	if len(params.ReplyTo) > 0 {
+		return params.ReplyTo
+	}
+	return replyTo
+}
+
+// sortedHeaders returns the names of the headers in a stable order.
+func sortedHeaders(headers map[string]string) []string {
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Attachment is a file attached to an email.
+type Attachment struct {
+	Filename    string
+	ContentType string
+	Content     []byte
+
+	// ContentID, if set, makes the attachment inline so that the HTML
+	// body can reference it using a cid: URL.
+	ContentID string
+}
+
+// setHeaders sets the Message-ID header of m, if params has one, and the
+// extra headers of params.
+func setHeaders(m *jemail.Email, params EmailParams) {
+	if params.MessageID != "" {
+		m.Headers.Set("Message-Id", "<"+params.MessageID+">")
+	}
+	for name, value := range params.Headers {
+		m.Headers.Set(name, value)
+	}
+}
+
+// attach adds an attachment to m.
+func attach(m *jemail.Email, a Attachment) error {
+	at, err := m.Attach(bytes.NewReader(a.Content), a.Filename, a.ContentType)
+	if err != nil {
+		return err
+	}
+	if a.ContentID != "" {
+		at.HTMLRelated = true
+		at.Header.Set("Content-ID", "<"+a.ContentID+">")
+	}
+	return nil
 }
@@ -0,0 +1,151 @@
+package email
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	jemail "github.com/jordan-wright/email"
+)
+
+// FileTransport writes each email as an RFC 5322 message instead of
+// sending it, so the whole pipeline can be exercised without network
+// access.
+type FileTransport struct {
+	dir      string
+	w        io.Writer
+	mu       *sync.Mutex
+	from     string
+	fromName string
+	replyTo  []string
+}
+
+// FileConfig configures a FileTransport. If Writer is set the messages are
+// written to it one after another, otherwise each message is written to a
+// new .eml file in Dir, which is created if needed.
+type FileConfig struct {
+	Dir    string
+	Writer io.Writer
+
+	// WriterMu, if set, serialises writes to Writer across transports
+	// sharing it.
+	WriterMu *sync.Mutex
+
+	From     string
+	FromName string
+	ReplyTo  []string
+}
+
+// NewFileTransport creates a new file transport.
+func NewFileTransport(cfg FileConfig) *FileTransport {
+	mu := cfg.WriterMu
+	if mu == nil {
+		mu = &sync.Mutex{}
+	}
+	return &FileTransport{
+		dir:      cfg.Dir,
+		w:        cfg.Writer,
+		mu:       mu,
+		from:     cfg.From,
+		fromName: cfg.FromName,
+		replyTo:  cfg.ReplyTo,
+	}
+}
+
+// SendEmail writes the email.
+func (t *FileTransport) SendEmail(ctx context.Context, params EmailParams) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m := jemail.NewEmail()
+	fromName, from := fromOverride(params, t.fromName, t.from)
+	m.From = formatAddress(fromName, from)
+	m.ReplyTo = replyToOverride(params, t.replyTo)
+	m.Subject = params.Subject
+	m.Text = []byte(params.Text)
+	if params.HTML != "" {
+		m.HTML = []byte(params.HTML)
+	}
+	m.To = params.To
+	m.Cc = params.Cc
+	m.Bcc = params.Bcc
+	setHeaders(m, params)
+	for _, a := range params.Attachments {
+		if err := attach(m, a); err != nil {
+			return err
+		}
+	}
+	mm, err := newMIMEMessage(m, params.WriteHTML)
+	if err != nil {
+		return err
+	}
+
+	if t.w != nil {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		bw := bufio.NewWriter(t.w)
+		if err := mm.writeTo(bw); err != nil {
+			return err
+		}
+		return bw.Flush()
+	}
+	return t.writeFile(mm)
+}
+
+// writeFile writes the message to a new file in the directory. The file
+// is renamed into place once written so readers never see a partial
+// message.
+func (t *FileTransport) writeFile(mm *mimeMessage) error {
+	if t.dir == "" {
+		return fmt.Errorf("file transport has no directory")
+	}
+	if err := os.MkdirAll(t.dir, 0o755); err != nil {
+		return err
+	}
+
+	b := make([]byte, 4)
+	if _, err := rand.Read(b); err != nil {
+		return err
+	}
+	name := time.Now().UTC().Format("20060102T150405.000000000Z") + "-" + hex.EncodeToString(b) + ".eml"
+
+	f, err := os.CreateTemp(t.dir, ".tmp-*.eml")
+	if err != nil {
+		return err
+	}
+	bw := bufio.NewWriter(f)
+	if err := mm.writeTo(bw); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := bw.Flush(); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), filepath.Join(t.dir, name))
+}
+
+// Verify checks that the directory can be created. Transports writing to
+// an io.Writer are always usable.
+func (t *FileTransport) Verify(_ context.Context) error {
+	if t.w != nil {
+		return nil
+	}
+	if t.dir == "" {
+		return fmt.Errorf("file transport has no directory")
+	}
+	return os.MkdirAll(t.dir, 0o755)
+}
@@ -1,6 +1,7 @@
 package email
 
 import (
+	"context"
 	"fmt"
 	"net/smtp"
 
@@ -9,7 +10,7 @@ import (
 
 const (
 	gmailSMTPAuthAddr = "smtp.gmail.com"
-	gmailSMTPPort     = "587"
+	gmailSMTPPort     = 587
 )
 
 // GmailSMTPTransport sends emails using Gmail.
@@ -29,10 +30,10 @@ func NewGmailSMTPTransport(name, fromEmailAddress, fromEmailPassword string) *Gm
 }
 
 // SendEmail sends an email using Gmail.
-func (s *GmailSMTPTransport) SendEmail(params EmailParams) error {
+func (s *GmailSMTPTransport) SendEmail(ctx context.Context, params EmailParams) error {
 	m := email.NewEmail()
 	m.From = fmt.Sprintf("%s <%s>", s.name, s.fromEmailAddress)
-	m.ReplyTo = []string{s.fromEmailAddress}
+	m.ReplyTo = replyToOverride(params, []string{s.fromEmailAddress})
 	m.Subject = params.Subject
 	m.Text = []byte(params.Text)
 	if params.HTML != "" {
@@ -41,11 +42,13 @@ func (s *GmailSMTPTransport) SendEmail(params EmailParams) error {
 	m.To = params.To
 	m.Cc = params.Cc
 	m.Bcc = params.Bcc
+	setHeaders(m, params)
 	for _, a := range params.Attachments {
-		m.AttachFile(a)
+		if err := attach(m, a); err != nil {
+			return err
+		}
 	}
 
 	auth := smtp.PlainAuth("", s.fromEmailAddress, s.fromEmailPassword, gmailSMTPAuthAddr)
-	addr := fmt.Sprintf("%s:%s", gmailSMTPAuthAddr, gmailSMTPPort)
-	return m.Send(addr, auth)
+	return sendSMTP(ctx, m, params.WriteHTML, gmailSMTPAuthAddr, gmailSMTPPort, auth, TLSOptions{Mode: TLSModeStartTLS}, Timeouts{})
 }
@@ -0,0 +1,143 @@
+package email
+
+import (
+	"bytes"
+	"context"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"strings"
+	"time"
+)
+
+// MailgunDefaultBaseURL is the base URL of the Mailgun API for domains in
+// the US region. Domains in the EU region use https://api.eu.mailgun.net.
+const MailgunDefaultBaseURL = "https://api.mailgun.net"
+
+// MailgunTransport sends emails using the Mailgun HTTP API.
+type MailgunTransport struct {
+	domain   string
+	apiKey   string
+	baseURL  string
+	from     string
+	fromName string
+	replyTo  []string
+	client   *http.Client
+}
+
+// MailgunConfig is the configuration for a MailgunTransport.
+type MailgunConfig struct {
+	Domain string
+	APIKey string
+
+	// BaseURL defaults to MailgunDefaultBaseURL.
+	BaseURL string
+
+	From     string
+	FromName string
+	ReplyTo  []string
+
+	// HTTPClient defaults to a client with a 30 second timeout.
+	HTTPClient *http.Client
+}
+
+// NewMailgunTransport creates a new Mailgun sender.
+func NewMailgunTransport(cfg MailgunConfig) *MailgunTransport {
+	baseURL := cfg.BaseURL
+	if baseURL == "" {
+		baseURL = MailgunDefaultBaseURL
+	}
+	client := cfg.HTTPClient
+	if client == nil {
+		client = &http.Client{Timeout: 30 * time.Second}
+	}
+	return &MailgunTransport{
+		domain:   cfg.Domain,
+		apiKey:   cfg.APIKey,
+		baseURL:  strings.TrimRight(baseURL, "/"),
+		from:     cfg.From,
+		fromName: cfg.FromName,
+		replyTo:  cfg.ReplyTo,
+		client:   client,
+	}
+}
+
+// SendEmail sends an email using the Mailgun messages API.
+func (s *MailgunTransport) SendEmail(ctx context.Context, params EmailParams) error {
+	html, err := params.HTMLBody()
+	if err != nil {
+		return err
+	}
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+
+	fields := [][2]string{
+		{"from", formatAddress(fromOverride(params, s.fromName, s.from))},
+		{"subject", params.Subject},
+		{"text", params.Text},
+	}
+	if html != "" {
+		fields = append(fields, [2]string{"html", html})
+	}
+	for _, to := range params.To {
+		fields = append(fields, [2]string{"to", to})
+	}
+	for _, cc := range params.Cc {
+		fields = append(fields, [2]string{"cc", cc})
+	}
+	for _, bcc := range params.Bcc {
+		fields = append(fields, [2]string{"bcc", bcc})
+	}
+	if replyTo := replyToOverride(params, s.replyTo); len(replyTo) > 0 {
+		fields = append(fields, [2]string{"h:Reply-To", strings.Join(replyTo, ", ")})
+	}
+	if params.MessageID != "" {
+		fields = append(fields, [2]string{"h:Message-Id", "<" + params.MessageID + ">"})
+	}
+	for _, name := range sortedHeaders(params.Headers) {
+		fields = append(fields, [2]string{"h:" + name, params.Headers[name]})
+	}
+	for _, f := range fields {
+		if err := w.WriteField(f[0], f[1]); err != nil {
+			return err
+		}
+	}
+
+	for _, a := range params.Attachments {
+		// Mailgun uses the filename of an inline attachment as its
+		// Content-ID so the filename is replaced with the content id
+		// the HTML body refers to
+		field, filename := "attachment", a.Filename
+		if a.ContentID != "" {
+			field, filename = "inline", a.ContentID
+		}
+		h := make(textproto.MIMEHeader)
+		h.Set("Content-Disposition", multipartDisposition(field, filename))
+		h.Set("Content-Type", a.ContentType)
+		part, err := w.CreatePart(h)
+		if err != nil {
+			return err
+		}
+		if _, err := part.Write(a.Content); err != nil {
+			return err
+		}
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.baseURL+"/v3/"+s.domain+"/messages", &body)
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth("api", s.apiKey)
+	req.Header.Set("Content-Type", w.FormDataContentType())
+	return doRequest(s.client, req, "mailgun")
+}
+
+var quoteEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+
+func multipartDisposition(field, filename string) string {
+	return `form-data; name="` + quoteEscaper.Replace(field) +
+		`"; filename="` + quoteEscaper.Replace(filename) + `"`
+}
@@ -0,0 +1,374 @@
+package email
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	jemail "github.com/jordan-wright/email"
+)
+
+// mimeMessage writes an email as a MIME message straight to a writer, such
+// as an SMTP DATA writer or a file, rather than building the whole message
+// in memory first as jemail.Email.Bytes does. The output is otherwise the
+// same. Anything that can fail other than writing, such as generating the
+// Message-ID, is done by newMIMEMessage, so that a message is never left
+// half written for a reason other than the writer failing or, for an
+// HTML body that is rendered as it is written, the render failing.
+type mimeMessage struct {
+	m      *jemail.Email
+	header textproto.MIMEHeader
+
+	// html writes the HTML body, if any
+	html func(w io.Writer) error
+
+	related, others []*jemail.Attachment
+
+	// calendar are the text/calendar attachments, which are written as
+	// alternatives of the text and HTML bodies so that mail clients show
+	// them as meeting invitations.
+	calendar []*jemail.Attachment
+}
+
+// newMIMEMessage returns the MIME message of m. If html is set it writes
+// the HTML body in place of m.HTML.
+func newMIMEMessage(m *jemail.Email, html func(w io.Writer) error) (*mimeMessage, error) {
+	header, err := mimeHeaders(m)
+	if err != nil {
+		return nil, err
+	}
+	if html == nil && len(m.HTML) > 0 {
+		html = writeBytes(m.HTML)
+	}
+	mm := &mimeMessage{m: m, header: header, html: html}
+	for _, a := range m.Attachments {
+		switch {
+		case a.HTMLRelated:
+			mm.related = append(mm.related, a)
+		case isCalendar(a.ContentType):
+			mm.calendar = append(mm.calendar, a)
+		default:
+			mm.others = append(mm.others, a)
+		}
+	}
+	if mm.html == nil && len(mm.related) > 0 {
+		return nil, errors.New("there are HTML attachments, but no HTML body")
+	}
+	return mm, nil
+}
+
+// encodeMessage returns the MIME message of m, for providers whose API
+// takes the raw message. If html is set it writes the HTML body in place
+// of m.HTML.
+func encodeMessage(m *jemail.Email, html func(w io.Writer) error) ([]byte, error) {
+	mm, err := newMIMEMessage(m, html)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := mm.writeTo(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// writeTo writes the message to w. It may be called more than once.
+func (mm *mimeMessage) writeTo(w io.Writer) error {
+	m := mm.m
+	var (
+		isMixed       = len(mm.others) > 0
+		isAlternative = len(m.Text) > 0 && mm.html != nil || len(mm.calendar) > 0
+		isRelated     = mm.html != nil && len(mm.related) > 0
+	)
+
+	header := make(textproto.MIMEHeader, len(mm.header)+2)
+	for k, v := range mm.header {
+		header[k] = v
+	}
+	var mw *multipart.Writer
+	if isMixed || isAlternative || isRelated {
+		mw = multipart.NewWriter(w)
+	}
+	switch {
+	case isMixed:
+		header.Set("Content-Type", "multipart/mixed;\r\n boundary="+mw.Boundary())
+	case isAlternative:
+		header.Set("Content-Type", "multipart/alternative;\r\n boundary="+mw.Boundary())
+	case isRelated:
+		header.Set("Content-Type", "multipart/related;\r\n boundary="+mw.Boundary())
+	case mm.html != nil:
+		header.Set("Content-Type", "text/html; charset=UTF-8")
+		header.Set("Content-Transfer-Encoding", "quoted-printable")
+	default:
+		header.Set("Content-Type", "text/plain; charset=UTF-8")
+		header.Set("Content-Transfer-Encoding", "quoted-printable")
+	}
+	if err := writeMIMEHeader(w, header); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, "\r\n"); err != nil {
+		return err
+	}
+
+	if len(m.Text) > 0 || mm.html != nil || len(mm.calendar) > 0 {
+		sub := mw
+		if isMixed && isAlternative {
+			sub = multipart.NewWriter(w)
+			if _, err := mw.CreatePart(textproto.MIMEHeader{
+				"Content-Type": {"multipart/alternative;\r\n boundary=" + sub.Boundary()},
+			}); err != nil {
+				return err
+			}
+		}
+		if len(m.Text) > 0 {
+			if err := writeMIMEBody(w, sub, writeBytes(m.Text), "text/plain", isMixed || isAlternative); err != nil {
+				return err
+			}
+		}
+		if mm.html != nil {
+			body, related := sub, (*multipart.Writer)(nil)
+			if (isMixed || isAlternative) && len(mm.related) > 0 {
+				related = multipart.NewWriter(w)
+				if _, err := sub.CreatePart(textproto.MIMEHeader{
+					"Content-Type": {"multipart/related;\r\n boundary=" + related.Boundary()},
+				}); err != nil {
+					return err
+				}
+				body = related
+			} else if isRelated {
+				related = mw
+			}
+			if err := writeMIMEBody(w, body, mm.html, "text/html", isMixed || isAlternative || isRelated); err != nil {
+				return err
+			}
+			for _, a := range mm.related {
+				if err := writeMIMEAttachment(related, a); err != nil {
+					return err
+				}
+			}
+			if related != nil && related != mw {
+				if err := related.Close(); err != nil {
+					return err
+				}
+			}
+		}
+		for _, a := range mm.calendar {
+			if err := writeMIMECalendar(sub, a); err != nil {
+				return err
+			}
+		}
+		if sub != mw {
+			if err := sub.Close(); err != nil {
+				return err
+			}
+		}
+	}
+	for _, a := range mm.others {
+		if err := writeMIMEAttachment(mw, a); err != nil {
+			return err
+		}
+	}
+	if mw != nil {
+		return mw.Close()
+	}
+	return nil
+}
+
+// writeMIMEBody writes a text or HTML body quoted-printable encoded, as a
+// part of mw if multipart is set. The body is written by the body function
+// so that it can be rendered straight into the part.
+func writeMIMEBody(w io.Writer, mw *multipart.Writer, body func(w io.Writer) error, mediaType string, multipart bool) error {
+	if multipart {
+		var err error
+		if w, err = mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {mediaType + "; charset=UTF-8"},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		}); err != nil {
+			return err
+		}
+	}
+	qp := quotedprintable.NewWriter(w)
+	if err := body(qp); err != nil {
+		return err
+	}
+	return qp.Close()
+}
+
+// writeBytes returns a body function that writes b.
+func writeBytes(b []byte) func(w io.Writer) error {
+	return func(w io.Writer) error {
+		_, err := w.Write(b)
+		return err
+	}
+}
+
+// isCalendar reports whether contentType is text/calendar.
+func isCalendar(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	return err == nil && mediaType == "text/calendar"
+}
+
+// writeMIMECalendar writes a text/calendar attachment quoted-printable
+// encoded as an alternative part of mw, keeping its content type and so
+// its method parameter.
+func writeMIMECalendar(mw *multipart.Writer, a *jemail.Attachment) error {
+	w, err := mw.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {a.ContentType},
+		"Content-Transfer-Encoding": {"quoted-printable"},
+	})
+	if err != nil {
+		return err
+	}
+	qp := quotedprintable.NewWriter(w)
+	if _, err := qp.Write(a.Content); err != nil {
+		return err
+	}
+	return qp.Close()
+}
+
+// writeMIMEAttachment writes an attachment base64 encoded as a part of mw.
+func writeMIMEAttachment(mw *multipart.Writer, a *jemail.Attachment) error {
+	header := make(textproto.MIMEHeader, len(a.Header)+4)
+	for k, v := range a.Header {
+		header[k] = v
+	}
+	contentType := "application/octet-stream"
+	if a.ContentType != "" {
+		contentType = a.ContentType
+	}
+	header.Set("Content-Type", contentType)
+	if header.Get("Content-Disposition") == "" {
+		disposition := "attachment"
+		if a.HTMLRelated {
+			disposition = "inline"
+		}
+		header.Set("Content-Disposition", fmt.Sprintf("%s;\r\n filename=\"%s\"", disposition, a.Filename))
+	}
+	if header.Get("Content-ID") == "" {
+		header.Set("Content-ID", "<"+a.Filename+">")
+	}
+	if header.Get("Content-Transfer-Encoding") == "" {
+		header.Set("Content-Transfer-Encoding", "base64")
+	}
+	w, err := mw.CreatePart(header)
+	if err != nil {
+		return err
+	}
+	return writeBase64Lines(w, a.Content)
+}
+
+// writeBase64Lines writes b base64 encoded in lines of 76 characters as
+// RFC 2045 requires.
+func writeBase64Lines(w io.Writer, b []byte) error {
+	// 57 raw bytes per 76 byte line
+	const maxRaw = 57
+	line := make([]byte, jemail.MaxLineLength+len("\r\n"))
+	for len(b) > 0 {
+		n := min(len(b), maxRaw)
+		out := line[:base64.StdEncoding.EncodedLen(n)]
+		base64.StdEncoding.Encode(out, b[:n])
+		out = append(out, "\r\n"...)
+		if _, err := w.Write(out); err != nil {
+			return err
+		}
+		b = b[n:]
+	}
+	return nil
+}
+
+// mimeHeaders returns the headers of the message, in the same way as
+// jemail.Email.Bytes. The fields of m are used unless m.Headers has them,
+// and the Message-ID, Date and MIME-Version headers are added if missing.
+func mimeHeaders(m *jemail.Email) (textproto.MIMEHeader, error) {
+	header := make(textproto.MIMEHeader, len(m.Headers)+8)
+	for k, v := range m.Headers {
+		header[textproto.CanonicalMIMEHeaderKey(k)] = v
+	}
+	setDefault := func(name, value string) {
+		if _, ok := header[name]; !ok && value != "" {
+			header.Set(name, value)
+		}
+	}
+	setDefault("Reply-To", strings.Join(m.ReplyTo, ", "))
+	setDefault("To", strings.Join(m.To, ", "))
+	setDefault("Cc", strings.Join(m.Cc, ", "))
+	setDefault("Subject", m.Subject)
+	if _, ok := header["Message-Id"]; !ok {
+		id, err := generateMessageID()
+		if err != nil {
+			return nil, err
+		}
+		header.Set("Message-Id", id)
+	}
+	if _, ok := header["From"]; !ok {
+		header.Set("From", m.From)
+	}
+	setDefault("Date", time.Now().Format(time.RFC1123Z))
+	setDefault("Mime-Version", "1.0")
+	return header, nil
+}
+
+// writeMIMEHeader writes the header fields in name order, encoding the
+// values that need it.
+func writeMIMEHeader(w io.Writer, header textproto.MIMEHeader) error {
+	names := make([]string, 0, len(header))
+	for name := range header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	for _, name := range names {
+		for _, v := range header[name] {
+			b.WriteString(name)
+			b.WriteString(": ")
+			switch name {
+			case "Content-Type", "Content-Disposition":
+				b.WriteString(v)
+			case "From", "To", "Cc", "Bcc":
+				participants := strings.Split(v, ",")
+				for i, p := range participants {
+					if addr, err := mail.ParseAddress(p); err == nil {
+						participants[i] = addr.String()
+					}
+				}
+				b.WriteString(strings.Join(participants, ", "))
+			default:
+				b.WriteString(mime.QEncoding.Encode("UTF-8", v))
+			}
+			b.WriteString("\r\n")
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+var maxBigInt = big.NewInt(math.MaxInt64)
+
+// generateMessageID returns a Message-ID made of the time, the process id,
+// a random number and the hostname, as jemail does.
+func generateMessageID() (string, error) {
+	n, err := rand.Int(rand.Reader, maxBigInt)
+	if err != nil {
+		return "", err
+	}
+	host, err := os.Hostname()
+	if err != nil {
+		host = "localhost.localdomain"
+	}
+	return fmt.Sprintf("<%d.%d.%d@%s>", time.Now().UnixNano(), os.Getpid(), n, host), nil
+}
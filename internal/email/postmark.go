@@ -0,0 +1,150 @@
+package email
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// PostmarkDefaultBaseURL is the base URL of the Postmark API.
+const PostmarkDefaultBaseURL = "https://api.postmarkapp.com"
+
+// PostmarkDefaultMessageStream is the transactional message stream every
+// Postmark server has.
+const PostmarkDefaultMessageStream = "outbound"
+
+// PostmarkTransport sends emails using the Postmark HTTP API.
+type PostmarkTransport struct {
+	serverToken   string
+	baseURL       string
+	messageStream string
+	from          string
+	fromName      string
+	replyTo       []string
+	client        *http.Client
+}
+
+// PostmarkConfig is the configuration for a PostmarkTransport.
+type PostmarkConfig struct {
+	ServerToken string
+
+	// BaseURL defaults to PostmarkDefaultBaseURL.
+	BaseURL string
+
+	// MessageStream is the stream used when EmailParams.MessageStream is
+	// empty. Defaults to PostmarkDefaultMessageStream.
+	MessageStream string
+
+	From     string
+	FromName string
+	ReplyTo  []string
+
+	// HTTPClient defaults to a client with a 30 second timeout.
+	HTTPClient *http.Client
+}
+
+// NewPostmarkTransport creates a new Postmark sender.
+func NewPostmarkTransport(cfg PostmarkConfig) *PostmarkTransport {
+	baseURL := cfg.BaseURL
+	if baseURL == "" {
+		baseURL = PostmarkDefaultBaseURL
+	}
+	stream := cfg.MessageStream
+	if stream == "" {
+		stream = PostmarkDefaultMessageStream
+	}
+	client := cfg.HTTPClient
+	if client == nil {
+		client = &http.Client{Timeout: 30 * time.Second}
+	}
+	return &PostmarkTransport{
+		serverToken:   cfg.ServerToken,
+		baseURL:       strings.TrimRight(baseURL, "/"),
+		messageStream: stream,
+		from:          cfg.From,
+		fromName:      cfg.FromName,
+		replyTo:       cfg.ReplyTo,
+		client:        client,
+	}
+}
+
+type postmarkEmail struct {
+	From          string               `json:"From"`
+	To            string               `json:"To"`
+	Cc            string               `json:"Cc,omitempty"`
+	Bcc           string               `json:"Bcc,omitempty"`
+	Subject       string               `json:"Subject"`
+	TextBody      string               `json:"TextBody,omitempty"`
+	HTMLBody      string               `json:"HtmlBody,omitempty"`
+	ReplyTo       string               `json:"ReplyTo,omitempty"`
+	MessageStream string               `json:"MessageStream"`
+	Headers       []postmarkHeader     `json:"Headers,omitempty"`
+	Attachments   []postmarkAttachment `json:"Attachments,omitempty"`
+}
+
+type postmarkHeader struct {
+	Name  string `json:"Name"`
+	Value string `json:"Value"`
+}
+
+type postmarkAttachment struct {
+	Name        string `json:"Name"`
+	Content     []byte `json:"Content"`
+	ContentType string `json:"ContentType"`
+	ContentID   string `json:"ContentID,omitempty"`
+}
+
+// SendEmail sends an email using the Postmark email API. The email is sent
+// on params.MessageStream if set, otherwise on the transport's default
+// message stream.
+func (s *PostmarkTransport) SendEmail(ctx context.Context, params EmailParams) error {
+	html, err := params.HTMLBody()
+	if err != nil {
+		return err
+	}
+	stream := params.MessageStream
+	if stream == "" {
+		stream = s.messageStream
+	}
+	m := postmarkEmail{
+		From:          formatAddress(fromOverride(params, s.fromName, s.from)),
+		To:            strings.Join(params.To, ","),
+		Cc:            strings.Join(params.Cc, ","),
+		Bcc:           strings.Join(params.Bcc, ","),
+		Subject:       params.Subject,
+		TextBody:      params.Text,
+		HTMLBody:      html,
+		ReplyTo:       strings.Join(replyToOverride(params, s.replyTo), ","),
+		MessageStream: stream,
+	}
+	for _, name := range sortedHeaders(params.Headers) {
+		m.Headers = append(m.Headers, postmarkHeader{Name: name, Value: params.Headers[name]})
+	}
+	for _, a := range params.Attachments {
+		pa := postmarkAttachment{
+			Name:        a.Filename,
+			Content:     a.Content,
+			ContentType: a.ContentType,
+		}
+		if a.ContentID != "" {
+			pa.ContentID = "cid:" + a.ContentID
+		}
+		m.Attachments = append(m.Attachments, pa)
+	}
+	body, err := json.Marshal(&m)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.baseURL+"/email", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Postmark-Server-Token", s.serverToken)
+	return doRequest(s.client, req, "postmark")
+}
@@ -1,6 +1,7 @@
 package email
 
 import (
+	"context"
 	"fmt"
 	"net/smtp"
 
@@ -16,6 +17,9 @@ type AWSSMTPTransport struct {
 	from     string
 	fromName string
 	replyTo  []string
+	tls      TLSOptions
+	smtpAuth smtp.Auth
+	timeouts Timeouts
 }
 
 type AWSConfig struct {
@@ -26,6 +30,13 @@ type AWSConfig struct {
 	From     string
 	FromName string
 	ReplyTo  []string
+	TLS      TLSOptions
+
+	// Auth optionally replaces the AUTH PLAIN authentication using
+	// Username and Password, for example with XOAuth2Auth.
+	Auth smtp.Auth
+
+	Timeouts Timeouts
 }
 
 // NewAWSSMTPTransport creates a new AWS sender.
@@ -37,14 +48,65 @@ func NewAWSSMTPTransport(cfg AWSConfig) *AWSSMTPTransport {
 		password: cfg.Password,
 		from:     cfg.From,
 		fromName: cfg.FromName,
+		replyTo:  cfg.ReplyTo,
+		tls:      cfg.TLS,
+		smtpAuth: cfg.Auth,
+		timeouts: cfg.Timeouts,
 	}
 }
 
 // SendEmail sends an email using AWS SES.
-func (s *AWSSMTPTransport) SendEmail(params EmailParams) error {
+func (s *AWSSMTPTransport) SendEmail(ctx context.Context, params EmailParams) error {
+	m, err := s.message(params)
+	if err != nil {
+		return err
+	}
+	return sendSMTP(ctx, m, params.WriteHTML, s.host, s.port, s.auth(), s.tls, s.timeouts)
+}
+
+// OpenSession connects to the SMTP server so that several emails can be
+// sent over the same connection. The connection is made when the first
+// email is sent.
+func (s *AWSSMTPTransport) OpenSession() (Session, error) {
+	if err := ValidateTLSOptions(s.tls); err != nil {
+		return nil, err
+	}
+	return &smtpSession{
+		dial: func(ctx context.Context) (*smtpConn, error) {
+			return dialSMTP(ctx, s.host, s.port, s.auth(), s.tls, s.timeouts.Connect)
+		},
+		build:    s.message,
+		timeouts: s.timeouts,
+	}, nil
+}
+
+// Verify connects to the SMTP server and authenticates without sending an
+// email.
+func (s *AWSSMTPTransport) Verify(ctx context.Context) error {
+	if err := ValidateTLSOptions(s.tls); err != nil {
+		return err
+	}
+	c, err := dialSMTP(ctx, s.host, s.port, s.auth(), s.tls, s.timeouts.Connect)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	return guard(ctx, c.conn, s.timeouts.Send, c.Quit)
+}
+
+func (s *AWSSMTPTransport) auth() smtp.Auth {
+	if s.smtpAuth != nil {
+		return s.smtpAuth
+	}
+	return smtp.PlainAuth("", s.username, s.password, s.host)
+}
+
+// message builds the email to send.
+func (s *AWSSMTPTransport) message(params EmailParams) (*jemail.Email, error) {
 	m := jemail.NewEmail()
-	m.From = fmt.Sprintf("%s <%s>", s.fromName, s.from)
-	m.ReplyTo = s.replyTo
+	fromName, from := fromOverride(params, s.fromName, s.from)
+	m.From = fmt.Sprintf("%s <%s>", fromName, from)
+	m.ReplyTo = replyToOverride(params, s.replyTo)
 	m.Subject = params.Subject
 	m.Text = []byte(params.Text)
 	if params.HTML != "" {
@@ -53,11 +115,11 @@ func (s *AWSSMTPTransport) SendEmail(params EmailParams) error {
 	m.To = params.To
 	m.Cc = params.Cc
 	m.Bcc = params.Bcc
+	setHeaders(m, params)
 	for _, a := range params.Attachments {
-		m.AttachFile(a)
+		if err := attach(m, a); err != nil {
+			return nil, err
+		}
 	}
-
-	auth := smtp.PlainAuth("", s.username, s.password, s.host)
-	addr := fmt.Sprintf("%s:%d", s.host, s.port)
-	return m.Send(addr, auth)
+	return m, nil
 }
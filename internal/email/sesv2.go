@@ -0,0 +1,119 @@
+package email
+
+import (
+	"context"
+	"sort"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/service/sesv2"
+	"github.com/aws/aws-sdk-go-v2/service/sesv2/types"
+	jemail "github.com/jordan-wright/email"
+	"github.com/pkg/errors"
+)
+
+// SESv2API is the subset of the SES v2 client used by SESv2Transport.
+type SESv2API interface {
+	SendEmail(ctx context.Context, params *sesv2.SendEmailInput, optFns ...func(*sesv2.Options)) (*sesv2.SendEmailOutput, error)
+}
+
+// SESv2Transport sends emails using the AWS SES v2 SendEmail API. Unlike
+// the SMTP interface it supports configuration sets and message tags.
+type SESv2Transport struct {
+	client           SESv2API
+	from             string
+	fromName         string
+	replyTo          []string
+	configurationSet string
+	tags             map[string]string
+}
+
+// SESv2Config is the configuration for an SESv2Transport.
+type SESv2Config struct {
+	Client   SESv2API
+	From     string
+	FromName string
+	ReplyTo  []string
+
+	// ConfigurationSet is the optional SES configuration set used to
+	// publish sending events.
+	ConfigurationSet string
+
+	// Tags are added to every email as SES message tags.
+	Tags map[string]string
+}
+
+// NewSESv2Transport creates a new SES v2 API sender.
+func NewSESv2Transport(cfg SESv2Config) *SESv2Transport {
+	return &SESv2Transport{
+		client:           cfg.Client,
+		from:             cfg.From,
+		fromName:         cfg.FromName,
+		replyTo:          cfg.ReplyTo,
+		configurationSet: cfg.ConfigurationSet,
+		tags:             cfg.Tags,
+	}
+}
+
+// SendEmail sends an email as a raw MIME message using the SES v2 API.
+func (s *SESv2Transport) SendEmail(ctx context.Context, params EmailParams) error {
+	m := jemail.NewEmail()
+	m.From = formatAddress(fromOverride(params, s.fromName, s.from))
+	m.ReplyTo = replyToOverride(params, s.replyTo)
+	m.Subject = params.Subject
+	m.Text = []byte(params.Text)
+	if params.HTML != "" {
+		m.HTML = []byte(params.HTML)
+	}
+	m.To = params.To
+	m.Cc = params.Cc
+	setHeaders(m, params)
+	for _, a := range params.Attachments {
+		if err := attach(m, a); err != nil {
+			return err
+		}
+	}
+	raw, err := encodeMessage(m, params.WriteHTML)
+	if err != nil {
+		return err
+	}
+
+	input := &sesv2.SendEmailInput{
+		FromEmailAddress: aws.String(m.From),
+		Destination: &types.Destination{
+			ToAddresses:  params.To,
+			CcAddresses:  params.Cc,
+			BccAddresses: params.Bcc,
+		},
+		Content: &types.EmailContent{
+			Raw: &types.RawMessage{Data: raw},
+		},
+	}
+	if s.configurationSet != "" {
+		input.ConfigurationSetName = aws.String(s.configurationSet)
+	}
+	names := make([]string, 0, len(s.tags))
+	for name := range s.tags {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		input.EmailTags = append(input.EmailTags, types.MessageTag{
+			Name:  aws.String(name),
+			Value: aws.String(s.tags[name]),
+		})
+	}
+
+	if _, err := s.client.SendEmail(ctx, input); err != nil {
+		var rerr *awshttp.ResponseError
+		if errors.As(err, &rerr) {
+			return &APIError{
+				Provider:   "ses",
+				StatusCode: rerr.HTTPStatusCode(),
+				Body:       err.Error(),
+			}
+		}
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,333 @@
+package email
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"os"
+	"time"
+
+	jemail "github.com/jordan-wright/email"
+)
+
+// SMTP TLS modes.
+const (
+	// TLSModeOpportunistic upgrades the connection using STARTTLS when
+	// the server offers it and otherwise sends in plaintext.
+	TLSModeOpportunistic = ""
+
+	// TLSModeNone never upgrades the connection. net/smtp refuses to send
+	// AUTH PLAIN credentials over it unless the server is on localhost.
+	TLSModeNone = "none"
+
+	// TLSModeStartTLS requires the server to offer STARTTLS.
+	TLSModeStartTLS = "starttls"
+
+	// TLSModeTLS connects using implicit TLS, usually on port 465.
+	TLSModeTLS = "tls"
+)
+
+// TLSOptions configures how an SMTP transport secures its connection.
+type TLSOptions struct {
+	Mode               string
+	InsecureSkipVerify bool
+
+	// CABundle is an optional PEM encoded bundle of CA certificates used
+	// to verify the server instead of the system roots.
+	CABundle string
+}
+
+// tlsConfig returns the tls.Config for a connection to host.
+func (o TLSOptions) tlsConfig(host string) (*tls.Config, error) {
+	cfg := &tls.Config{
+		ServerName:         host,
+		InsecureSkipVerify: o.InsecureSkipVerify,
+	}
+	if o.CABundle != "" {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM([]byte(o.CABundle)) {
+			return nil, fmt.Errorf("no certificates found in the CA bundle")
+		}
+		cfg.RootCAs = pool
+	}
+	return cfg, nil
+}
+
+// ValidateTLSOptions reports whether the TLS options are usable.
+func ValidateTLSOptions(o TLSOptions) error {
+	switch o.Mode {
+	case TLSModeOpportunistic, TLSModeNone, TLSModeStartTLS, TLSModeTLS:
+	default:
+		return fmt.Errorf("unknown tls mode %q", o.Mode)
+	}
+	_, err := o.tlsConfig("")
+	return err
+}
+
+// smtpMessage is an email ready to be sent over SMTP.
+type smtpMessage struct {
+	from string
+	to   []string
+	*mimeMessage
+}
+
+// envelope returns the envelope sender and recipients of m with its MIME
+// message. The message is only encoded as it is sent. If html is set it
+// writes the HTML body in place of m.HTML.
+func envelope(m *jemail.Email, html func(w io.Writer) error) (*smtpMessage, error) {
+	to := make([]string, 0, len(m.To)+len(m.Cc)+len(m.Bcc))
+	for _, list := range [][]string{m.To, m.Cc, m.Bcc} {
+		for _, s := range list {
+			addr, err := mail.ParseAddress(s)
+			if err != nil {
+				return nil, err
+			}
+			to = append(to, addr.Address)
+		}
+	}
+	from, err := mail.ParseAddress(m.From)
+	if err != nil {
+		return nil, err
+	}
+	mm, err := newMIMEMessage(m, html)
+	if err != nil {
+		return nil, err
+	}
+	return &smtpMessage{from: from.Address, to: to, mimeMessage: mm}, nil
+}
+
+// Timeouts bound how long an SMTP transport waits on the server. A zero
+// timeout leaves the wait bounded only by the context.
+type Timeouts struct {
+	// Connect bounds connecting to the server, including the TLS
+	// handshake and authentication.
+	Connect time.Duration
+
+	// Send bounds sending one email over an established connection.
+	Send time.Duration
+}
+
+// aLongTimeAgo is a deadline in the past used to interrupt pending I/O.
+var aLongTimeAgo = time.Unix(1, 0)
+
+// guard runs fn, which does I/O on conn, with a deadline of timeout from
+// now or the deadline of ctx if that is sooner. If ctx is done before fn
+// returns the pending I/O is interrupted and the error returned wraps
+// ctx.Err(). The connection must not be reused once ctx is done.
+func guard(ctx context.Context, conn net.Conn, timeout time.Duration, fn func() error) error {
+	var deadline time.Time
+	if timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
+	if d, ok := ctx.Deadline(); ok && (deadline.IsZero() || d.Before(deadline)) {
+		deadline = d
+	}
+	if err := conn.SetDeadline(deadline); err != nil {
+		return err
+	}
+	stop := context.AfterFunc(ctx, func() { conn.SetDeadline(aLongTimeAgo) })
+	err := fn()
+	if !stop() && err != nil {
+		return fmt.Errorf("%w: %v", ctx.Err(), err)
+	}
+	return err
+}
+
+// smtpConn is an SMTP client and its underlying connection.
+type smtpConn struct {
+	*smtp.Client
+	conn net.Conn
+}
+
+// dialSMTP connects to the SMTP server at host:port, secures the
+// connection according to the TLS options and authenticates, all within
+// timeout.
+func dialSMTP(ctx context.Context, host string, port int, auth smtp.Auth, opts TLSOptions, timeout time.Duration) (*smtpConn, error) {
+	tlsConfig, err := opts.tlsConfig(host)
+	if err != nil {
+		return nil, err
+	}
+	parent := ctx
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	addr := fmt.Sprintf("%s:%d", host, port)
+	timedOut := func(err error) error {
+		if timeout <= 0 || parent.Err() != nil {
+			return err
+		}
+		if d, ok := parent.Deadline(); ok && !time.Now().Before(d) {
+			return err
+		}
+		// the I/O deadline is that of ctx, so it can expire a moment
+		// before ctx reports that it is done
+		if ctx.Err() != nil || errors.Is(err, os.ErrDeadlineExceeded) {
+			return fmt.Errorf("smtp connect to %s timed out after %s: %w", addr, timeout, err)
+		}
+		return err
+	}
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, timedOut(err)
+	}
+	if opts.Mode == TLSModeTLS {
+		conn = tls.Client(conn, tlsConfig)
+	}
+
+	var c *smtp.Client
+	err = guard(ctx, conn, 0, func() error {
+		var err error
+		if c, err = smtp.NewClient(conn, host); err != nil {
+			return err
+		}
+		if err := c.Hello("localhost"); err != nil {
+			return err
+		}
+		if opts.Mode == TLSModeOpportunistic || opts.Mode == TLSModeStartTLS {
+			ok, _ := c.Extension("STARTTLS")
+			if !ok && opts.Mode == TLSModeStartTLS {
+				return fmt.Errorf("smtp server %s does not support STARTTLS", addr)
+			}
+			if ok {
+				if err := c.StartTLS(tlsConfig); err != nil {
+					return err
+				}
+			}
+		}
+		if auth != nil {
+			if ok, _ := c.Extension("AUTH"); ok {
+				if err := c.Auth(auth); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		conn.Close()
+		return nil, timedOut(err)
+	}
+	return &smtpConn{Client: c, conn: conn}, nil
+}
+
+// sendMessage sends a single message over an established connection.
+// The message is encoded straight into the DATA command so that large
+// bodies are not copied into a buffer first. If the message fails part
+// way through, for example because its HTML body fails to render, the
+// connection is closed without ending the DATA command so that the server
+// never receives a truncated message.
+func sendMessage(c *smtp.Client, m *smtpMessage) error {
+	if err := c.Mail(m.from); err != nil {
+		return err
+	}
+	for _, rcpt := range m.to {
+		if err := c.Rcpt(rcpt); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	bw := bufio.NewWriterSize(w, 32*1024)
+	if err := m.writeTo(bw); err != nil {
+		c.Close()
+		return err
+	}
+	if err := bw.Flush(); err != nil {
+		c.Close()
+		return err
+	}
+	return w.Close()
+}
+
+// sendSMTP sends m to the SMTP server at host:port over a new connection,
+// secured according to the TLS options.
+func sendSMTP(ctx context.Context, m *jemail.Email, html func(w io.Writer) error, host string, port int, auth smtp.Auth, opts TLSOptions, timeouts Timeouts) error {
+	// fail before connecting if the message cannot be encoded
+	msg, err := envelope(m, html)
+	if err != nil {
+		return err
+	}
+	c, err := dialSMTP(ctx, host, port, auth, opts, timeouts.Connect)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	return guard(ctx, c.conn, timeouts.Send, func() error {
+		if err := sendMessage(c.Client, msg); err != nil {
+			return err
+		}
+		return c.Quit()
+	})
+}
+
+// Session sends several emails over a single connection. It is not safe
+// for concurrent use.
+type Session interface {
+	Sender
+	Close() error
+}
+
+// SessionSender is implemented by transports that can reuse a connection
+// across several emails.
+type SessionSender interface {
+	Sender
+	OpenSession() (Session, error)
+}
+
+// smtpSession is a Session that keeps an SMTP connection open between
+// emails. If a message fails the transaction is reset; if the reset fails,
+// or the context of the message is done, the connection is dropped and
+// the next message reconnects.
+type smtpSession struct {
+	dial     func(ctx context.Context) (*smtpConn, error)
+	build    func(params EmailParams) (*jemail.Email, error)
+	timeouts Timeouts
+	c        *smtpConn
+}
+
+func (s *smtpSession) SendEmail(ctx context.Context, params EmailParams) error {
+	m, err := s.build(params)
+	if err != nil {
+		return err
+	}
+	msg, err := envelope(m, params.WriteHTML)
+	if err != nil {
+		return err
+	}
+	if s.c == nil {
+		if s.c, err = s.dial(ctx); err != nil {
+			return err
+		}
+	}
+	err = guard(ctx, s.c.conn, s.timeouts.Send, func() error {
+		return sendMessage(s.c.Client, msg)
+	})
+	if ctx.Err() != nil || (err != nil && s.c.Reset() != nil) {
+		s.c.Close()
+		s.c = nil
+	}
+	return err
+}
+
+func (s *smtpSession) Close() error {
+	if s.c == nil {
+		return nil
+	}
+	defer s.c.Close()
+	err := guard(context.Background(), s.c.conn, s.timeouts.Send, s.c.Quit)
+	s.c = nil
+	return err
+}
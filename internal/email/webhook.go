@@ -0,0 +1,145 @@
+package email
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Webhook request headers. The signature is the hex encoded HMAC-SHA256,
+// keyed with the signing secret, of the timestamp, a full stop and the
+// request body. Receivers should recompute it and reject requests with an
+// old timestamp to prevent replays.
+const (
+	WebhookSignatureHeader = "X-Squishy-Signature"
+	WebhookTimestampHeader = "X-Squishy-Timestamp"
+)
+
+// WebhookTransport sends emails by POSTing them as JSON to an HTTP
+// endpoint, for example an internal relay, which is responsible for
+// delivering them.
+type WebhookTransport struct {
+	url           string
+	signingSecret []byte
+	from          string
+	fromName      string
+	replyTo       []string
+	client        *http.Client
+}
+
+// WebhookConfig is the configuration for a WebhookTransport.
+type WebhookConfig struct {
+	URL           string
+	SigningSecret string
+
+	From     string
+	FromName string
+	ReplyTo  []string
+
+	// HTTPClient defaults to a client with a 30 second timeout.
+	HTTPClient *http.Client
+}
+
+// NewWebhookTransport creates a new webhook sender.
+func NewWebhookTransport(cfg WebhookConfig) *WebhookTransport {
+	client := cfg.HTTPClient
+	if client == nil {
+		client = &http.Client{Timeout: 30 * time.Second}
+	}
+	return &WebhookTransport{
+		url:           cfg.URL,
+		signingSecret: []byte(cfg.SigningSecret),
+		from:          cfg.From,
+		fromName:      cfg.FromName,
+		replyTo:       cfg.ReplyTo,
+		client:        client,
+	}
+}
+
+// WebhookEmail is the JSON document POSTed by a WebhookTransport.
+type WebhookEmail struct {
+	From          string              `json:"from"`
+	FromName      string              `json:"from_name,omitempty"`
+	ReplyTo       []string            `json:"reply_to,omitempty"`
+	To            []string            `json:"to"`
+	Cc            []string            `json:"cc,omitempty"`
+	Bcc           []string            `json:"bcc,omitempty"`
+	Subject       string              `json:"subject"`
+	Text          string              `json:"text"`
+	HTML          string              `json:"html,omitempty"`
+	MessageStream string              `json:"message_stream,omitempty"`
+	MessageID     string              `json:"message_id,omitempty"`
+	Headers       map[string]string   `json:"headers,omitempty"`
+	Attachments   []WebhookAttachment `json:"attachments,omitempty"`
+}
+
+// WebhookAttachment is an attachment of a WebhookEmail. Content is base64
+// encoded in the JSON document.
+type WebhookAttachment struct {
+	Filename    string `json:"filename"`
+	ContentType string `json:"content_type"`
+	Content     []byte `json:"content"`
+	ContentID   string `json:"content_id,omitempty"`
+}
+
+// SendEmail POSTs the email to the webhook endpoint. Any 2xx response is
+// treated as the relay having accepted the email.
+func (s *WebhookTransport) SendEmail(ctx context.Context, params EmailParams) error {
+	html, err := params.HTMLBody()
+	if err != nil {
+		return err
+	}
+	fromName, from := fromOverride(params, s.fromName, s.from)
+	m := WebhookEmail{
+		From:          from,
+		FromName:      fromName,
+		ReplyTo:       replyToOverride(params, s.replyTo),
+		To:            params.To,
+		Cc:            params.Cc,
+		Bcc:           params.Bcc,
+		Subject:       params.Subject,
+		Text:          params.Text,
+		HTML:          html,
+		MessageStream: params.MessageStream,
+		MessageID:     params.MessageID,
+		Headers:       params.Headers,
+	}
+	for _, a := range params.Attachments {
+		m.Attachments = append(m.Attachments, WebhookAttachment{
+			Filename:    a.Filename,
+			ContentType: a.ContentType,
+			Content:     a.Content,
+			ContentID:   a.ContentID,
+		})
+	}
+	body, err := json.Marshal(&m)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WebhookTimestampHeader, ts)
+	req.Header.Set(WebhookSignatureHeader, "sha256="+WebhookSignature(s.signingSecret, ts, body))
+	return doRequest(s.client, req, "webhook")
+}
+
+// WebhookSignature returns the hex encoded signature of a webhook request
+// with the given timestamp header and body.
+func WebhookSignature(secret []byte, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
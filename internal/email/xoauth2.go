@@ -0,0 +1,47 @@
+package email
+
+import (
+	"errors"
+	"net/smtp"
+)
+
+// xoauth2Auth implements the XOAUTH2 SASL mechanism used by Gmail and
+// Office 365 in place of passwords.
+type xoauth2Auth struct {
+	username string
+	token    func() (string, error)
+}
+
+// XOAuth2Auth returns an smtp.Auth that authenticates as username using
+// the XOAUTH2 mechanism. token is called each time a connection
+// authenticates and returns a current OAuth2 access token. Like
+// smtp.PlainAuth it refuses to send the token over an unencrypted
+// connection unless the server is on localhost.
+func XOAuth2Auth(username string, token func() (string, error)) smtp.Auth {
+	return &xoauth2Auth{username: username, token: token}
+}
+
+func (a *xoauth2Auth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	if !server.TLS && !isLocalhost(server.Name) {
+		return "", nil, errors.New("unencrypted connection")
+	}
+	accessToken, err := a.token()
+	if err != nil {
+		return "", nil, err
+	}
+	resp := "user=" + a.username + "\x01auth=Bearer " + accessToken + "\x01\x01"
+	return "XOAUTH2", []byte(resp), nil
+}
+
+func (a *xoauth2Auth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if more {
+		// the server sent a JSON error challenge, which is answered with
+		// an empty response to receive the final error reply
+		return []byte{}, nil
+	}
+	return nil, nil
+}
+
+func isLocalhost(name string) bool {
+	return name == "localhost" || name == "127.0.0.1" || name == "::1"
+}
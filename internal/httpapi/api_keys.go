@@ -0,0 +1,76 @@
+package httpapi
+
+import (
+	"net/http"
+
+	"github.com/andyfusniak/squishy-mailer-lite/entity"
+)
+
+type apiKey struct {
+	ID         string            `json:"id"`
+	Name       string            `json:"name"`
+	Role       entity.APIKeyRole `json:"role"`
+	ProjectIDs []string          `json:"project_ids"`
+	Key        string            `json:"key,omitempty"`
+	CreatedAt  entity.ISOTime    `json:"created_at"`
+	ModifiedAt entity.ISOTime    `json:"modified_at"`
+}
+
+func apiKeyResponse(k *entity.APIKey) apiKey {
+	return apiKey{
+		ID:         k.ID,
+		Name:       k.Name,
+		Role:       k.Role,
+		ProjectIDs: k.ProjectIDs,
+		Key:        k.Key,
+		CreatedAt:  k.CreatedAt,
+		ModifiedAt: k.ModifiedAt,
+	}
+}
+
+type createAPIKeyRequest struct {
+	Name       string            `json:"name"`
+	Role       entity.APIKeyRole `json:"role"`
+	ProjectIDs []string          `json:"project_ids"`
+}
+
+// createAPIKey mints an api key. The key is only included in this
+// response.
+func (h *Handler) createAPIKey(w http.ResponseWriter, r *http.Request) {
+	var req createAPIKeyRequest
+	if !h.decode(w, r, &req) {
+		return
+	}
+	k, err := h.svc.CreateAPIKey(r.Context(), entity.CreateAPIKeyParams{
+		Name:       req.Name,
+		Role:       req.Role,
+		ProjectIDs: req.ProjectIDs,
+	})
+	if err != nil {
+		h.writeServiceError(w, err)
+		return
+	}
+	h.writeJSON(w, http.StatusCreated, apiKeyResponse(k))
+}
+
+func (h *Handler) listAPIKeys(w http.ResponseWriter, r *http.Request) {
+	list, err := h.svc.ListAPIKeys(r.Context())
+	if err != nil {
+		h.writeServiceError(w, err)
+		return
+	}
+
+	keys := make([]apiKey, 0, len(list))
+	for _, k := range list {
+		keys = append(keys, apiKeyResponse(k))
+	}
+	h.writeJSON(w, http.StatusOK, map[string]any{"api_keys": keys})
+}
+
+func (h *Handler) revokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	if err := h.svc.RevokeAPIKey(r.Context(), r.PathValue("keyID")); err != nil {
+		h.writeServiceError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,96 @@
+package httpapi
+
+import (
+	"net/http"
+
+	"github.com/andyfusniak/squishy-mailer-lite/entity"
+)
+
+// asset is an asset. Content is only returned by getAsset, base64 encoded.
+type asset struct {
+	ID          string         `json:"id"`
+	ProjectID   string         `json:"project_id"`
+	Filename    string         `json:"filename"`
+	ContentType string         `json:"content_type"`
+	Content     []byte         `json:"content,omitempty"`
+	Size        int            `json:"size"`
+	Checksum    string         `json:"checksum"`
+	ContentID   string         `json:"content_id"`
+	URL         string         `json:"url,omitempty"`
+	CreatedAt   entity.ISOTime `json:"created_at"`
+	ModifiedAt  entity.ISOTime `json:"modified_at"`
+}
+
+func assetResponse(a *entity.Asset) asset {
+	return asset{
+		ID:          a.ID,
+		ProjectID:   a.ProjectID,
+		Filename:    a.Filename,
+		ContentType: a.ContentType,
+		Content:     a.Content,
+		Size:        a.Size,
+		Checksum:    a.Checksum,
+		ContentID:   a.ContentID,
+		URL:         a.URL,
+		CreatedAt:   a.CreatedAt,
+		ModifiedAt:  a.ModifiedAt,
+	}
+}
+
+type setAssetRequest struct {
+	Filename    string `json:"filename"`
+	ContentType string `json:"content_type"`
+	Content     []byte `json:"content"`
+}
+
+func (h *Handler) setAsset(w http.ResponseWriter, r *http.Request) {
+	var req setAssetRequest
+	if !h.decode(w, r, &req) {
+		return
+	}
+	a, err := h.svc.SetAsset(r.Context(), entity.SetAssetParams{
+		ID:          r.PathValue("assetID"),
+		ProjectID:   r.PathValue("projectID"),
+		Filename:    req.Filename,
+		ContentType: req.ContentType,
+		Content:     req.Content,
+	})
+	if err != nil {
+		h.writeServiceError(w, err)
+		return
+	}
+	resp := assetResponse(a)
+	resp.Content = nil
+	h.writeJSON(w, http.StatusOK, resp)
+}
+
+func (h *Handler) listAssets(w http.ResponseWriter, r *http.Request) {
+	list, err := h.svc.ListAssets(r.Context(), r.PathValue("projectID"))
+	if err != nil {
+		h.writeServiceError(w, err)
+		return
+	}
+
+	assets := make([]asset, 0, len(list))
+	for _, a := range list {
+		assets = append(assets, assetResponse(a))
+	}
+	h.writeJSON(w, http.StatusOK, map[string]any{"assets": assets})
+}
+
+func (h *Handler) getAsset(w http.ResponseWriter, r *http.Request) {
+	a, err := h.svc.GetAsset(r.Context(), r.PathValue("projectID"), r.PathValue("assetID"))
+	if err != nil {
+		h.writeServiceError(w, err)
+		return
+	}
+	h.writeJSON(w, http.StatusOK, assetResponse(a))
+}
+
+func (h *Handler) deleteAsset(w http.ResponseWriter, r *http.Request) {
+	if err := h.svc.DeleteAsset(r.Context(), r.PathValue("projectID"), r.PathValue("assetID")); err != nil {
+		h.writeServiceError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
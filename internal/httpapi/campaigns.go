@@ -0,0 +1,212 @@
+package httpapi
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/andyfusniak/squishy-mailer-lite/entity"
+)
+
+type campaign struct {
+	ID          string               `json:"id"`
+	ProjectID   string               `json:"project_id"`
+	Name        string               `json:"name"`
+	TemplateID  string               `json:"template_id"`
+	TransportID string               `json:"transport_id"`
+	ListID      string               `json:"list_id"`
+	State       entity.CampaignState `json:"state"`
+	Recipients  int                  `json:"recipients"`
+	CreatedAt   entity.ISOTime       `json:"created_at"`
+	ModifiedAt  entity.ISOTime       `json:"modified_at"`
+}
+
+func campaignResponse(c *entity.Campaign) campaign {
+	return campaign{
+		ID:          c.ID,
+		ProjectID:   c.ProjectID,
+		Name:        c.Name,
+		TemplateID:  c.TemplateID,
+		TransportID: c.TransportID,
+		ListID:      c.ListID,
+		State:       c.State,
+		Recipients:  c.Recipients,
+		CreatedAt:   c.CreatedAt,
+		ModifiedAt:  c.ModifiedAt,
+	}
+}
+
+type campaignRecipient struct {
+	Email          string         `json:"email"`
+	TemplateParams map[string]any `json:"template_params"`
+	Locale         string         `json:"locale"`
+	Timezone       string         `json:"timezone"`
+}
+
+type createCampaignRequest struct {
+	ID             string              `json:"id"`
+	Name           string              `json:"name"`
+	TemplateID     string              `json:"template_id"`
+	TransportID    string              `json:"transport_id"`
+	Subject        string              `json:"subject"`
+	TemplateParams map[string]any      `json:"template_params"`
+	Recipients     []campaignRecipient `json:"recipients"`
+	ListID         string              `json:"list_id"`
+	MessageStream  string              `json:"message_stream"`
+	SendAt         time.Time           `json:"send_at"`
+	Unsubscribe    bool                `json:"unsubscribe"`
+	TrackOpens     bool                `json:"track_opens"`
+}
+
+// campaignResult is the outcome of queuing the email of one recipient.
+// Exactly one of MailQueueID and Error is set. The results of the contacts
+// of a list follow those of the recipients in the request.
+type campaignResult struct {
+	Email       string     `json:"email,omitempty"`
+	MailQueueID string     `json:"mail_queue_id,omitempty"`
+	Error       *errorBody `json:"error,omitempty"`
+}
+
+func (h *Handler) createCampaign(w http.ResponseWriter, r *http.Request) {
+	var req createCampaignRequest
+	if !h.decode(w, r, &req) {
+		return
+	}
+	params := entity.CreateCampaignParams{
+		ID:             req.ID,
+		ProjectID:      r.PathValue("projectID"),
+		Name:           req.Name,
+		TemplateID:     req.TemplateID,
+		TransportID:    req.TransportID,
+		Subject:        req.Subject,
+		TemplateParams: req.TemplateParams,
+		ListID:         req.ListID,
+		MessageStream:  req.MessageStream,
+		SendAt:         req.SendAt,
+		Unsubscribe:    req.Unsubscribe,
+		TrackOpens:     req.TrackOpens,
+	}
+	if params.TemplateParams == nil {
+		params.TemplateParams = map[string]any{}
+	}
+	for _, rcpt := range req.Recipients {
+		params.Recipients = append(params.Recipients, entity.EmailRecipient{
+			Email:          rcpt.Email,
+			TemplateParams: rcpt.TemplateParams,
+			Locale:         rcpt.Locale,
+			Timezone:       rcpt.Timezone,
+		})
+	}
+
+	c, results, err := h.svc.CreateCampaign(r.Context(), params)
+	if err != nil {
+		h.writeServiceError(w, err)
+		return
+	}
+	list := make([]campaignResult, 0, len(results))
+	for i, res := range results {
+		var cr campaignResult
+		if i < len(req.Recipients) {
+			cr.Email = req.Recipients[i].Email
+		}
+		if res.Err != nil {
+			cr.Error = h.resultError(res.Err)
+		} else {
+			cr.MailQueueID = res.MailQueue.ID
+			if cr.Email == "" && len(res.MailQueue.To) > 0 {
+				cr.Email = res.MailQueue.To[0]
+			}
+		}
+		list = append(list, cr)
+	}
+	h.writeJSON(w, http.StatusCreated, map[string]any{
+		"campaign": campaignResponse(c),
+		"results":  list,
+	})
+}
+
+// resultError returns the error body of one failed item of a request that
+// succeeded as a whole. Errors other than service errors are logged and
+// reported as internal errors.
+func (h *Handler) resultError(err error) *errorBody {
+	var serr *entity.ServiceError
+	if !errors.As(err, &serr) {
+		h.logger.Error("request item failed", "error", err)
+		return &errorBody{Code: "internal_error", Message: "internal server error"}
+	}
+	return &errorBody{Code: string(serr.Code), Message: serr.Error()}
+}
+
+func (h *Handler) listCampaigns(w http.ResponseWriter, r *http.Request) {
+	list, err := h.svc.ListCampaigns(r.Context(), r.PathValue("projectID"))
+	if err != nil {
+		h.writeServiceError(w, err)
+		return
+	}
+
+	campaigns := make([]campaign, 0, len(list))
+	for _, c := range list {
+		campaigns = append(campaigns, campaignResponse(c))
+	}
+	h.writeJSON(w, http.StatusOK, map[string]any{"campaigns": campaigns})
+}
+
+func (h *Handler) getCampaign(w http.ResponseWriter, r *http.Request) {
+	c, err := h.svc.GetCampaign(r.Context(), r.PathValue("projectID"), r.PathValue("campaignID"))
+	if err != nil {
+		h.writeServiceError(w, err)
+		return
+	}
+	h.writeJSON(w, http.StatusOK, campaignResponse(c))
+}
+
+func (h *Handler) pauseCampaign(w http.ResponseWriter, r *http.Request) {
+	c, err := h.svc.PauseCampaign(r.Context(), r.PathValue("projectID"), r.PathValue("campaignID"))
+	if err != nil {
+		h.writeServiceError(w, err)
+		return
+	}
+	h.writeJSON(w, http.StatusOK, campaignResponse(c))
+}
+
+func (h *Handler) resumeCampaign(w http.ResponseWriter, r *http.Request) {
+	c, err := h.svc.ResumeCampaign(r.Context(), r.PathValue("projectID"), r.PathValue("campaignID"))
+	if err != nil {
+		h.writeServiceError(w, err)
+		return
+	}
+	h.writeJSON(w, http.StatusOK, campaignResponse(c))
+}
+
+type campaignStats struct {
+	CampaignID string               `json:"campaign_id"`
+	ProjectID  string               `json:"project_id"`
+	State      entity.CampaignState `json:"state"`
+	Recipients int                  `json:"recipients"`
+	Rejected   int                  `json:"rejected"`
+	Sent       int                  `json:"sent"`
+	Failed     int                  `json:"failed"`
+	Bounced    int                  `json:"bounced"`
+	Cancelled  int                  `json:"cancelled"`
+	Remaining  int                  `json:"remaining"`
+}
+
+func (h *Handler) getCampaignStats(w http.ResponseWriter, r *http.Request) {
+	st, err := h.svc.GetCampaignStats(r.Context(), r.PathValue("projectID"), r.PathValue("campaignID"))
+	if err != nil {
+		h.writeServiceError(w, err)
+		return
+	}
+	h.writeJSON(w, http.StatusOK, campaignStats{
+		CampaignID: st.CampaignID,
+		ProjectID:  st.ProjectID,
+		State:      st.State,
+		Recipients: st.Recipients,
+		Rejected:   st.Rejected,
+		Sent:       st.Sent,
+		Failed:     st.Failed,
+		Bounced:    st.Bounced,
+		Cancelled:  st.Cancelled,
+		Remaining:  st.Remaining,
+	})
+}
@@ -0,0 +1,175 @@
+package httpapi
+
+import (
+	"mime"
+	"net/http"
+
+	"github.com/andyfusniak/squishy-mailer-lite/entity"
+)
+
+type contactList struct {
+	ID         string         `json:"id"`
+	ProjectID  string         `json:"project_id"`
+	Name       string         `json:"name"`
+	Contacts   int            `json:"contacts"`
+	CreatedAt  entity.ISOTime `json:"created_at"`
+	ModifiedAt entity.ISOTime `json:"modified_at"`
+}
+
+func contactListResponse(l *entity.ContactList) contactList {
+	return contactList{
+		ID:         l.ID,
+		ProjectID:  l.ProjectID,
+		Name:       l.Name,
+		Contacts:   l.Contacts,
+		CreatedAt:  l.CreatedAt,
+		ModifiedAt: l.ModifiedAt,
+	}
+}
+
+type contact struct {
+	Email      string            `json:"email"`
+	Attributes map[string]string `json:"attributes"`
+	Locale     string            `json:"locale"`
+	Timezone   string            `json:"timezone"`
+	CreatedAt  entity.ISOTime    `json:"created_at"`
+	ModifiedAt entity.ISOTime    `json:"modified_at"`
+}
+
+type createContactListRequest struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+func (h *Handler) createContactList(w http.ResponseWriter, r *http.Request) {
+	var req createContactListRequest
+	if !h.decode(w, r, &req) {
+		return
+	}
+	l, err := h.svc.CreateContactList(r.Context(), entity.CreateContactListParams{
+		ID:        req.ID,
+		ProjectID: r.PathValue("projectID"),
+		Name:      req.Name,
+	})
+	if err != nil {
+		h.writeServiceError(w, err)
+		return
+	}
+	h.writeJSON(w, http.StatusCreated, contactListResponse(l))
+}
+
+func (h *Handler) listContactLists(w http.ResponseWriter, r *http.Request) {
+	list, err := h.svc.ListContactLists(r.Context(), r.PathValue("projectID"))
+	if err != nil {
+		h.writeServiceError(w, err)
+		return
+	}
+
+	lists := make([]contactList, 0, len(list))
+	for _, l := range list {
+		lists = append(lists, contactListResponse(l))
+	}
+	h.writeJSON(w, http.StatusOK, map[string]any{"contact_lists": lists})
+}
+
+func (h *Handler) getContactList(w http.ResponseWriter, r *http.Request) {
+	l, err := h.svc.GetContactList(r.Context(), r.PathValue("projectID"), r.PathValue("listID"))
+	if err != nil {
+		h.writeServiceError(w, err)
+		return
+	}
+	h.writeJSON(w, http.StatusOK, contactListResponse(l))
+}
+
+func (h *Handler) deleteContactList(w http.ResponseWriter, r *http.Request) {
+	if err := h.svc.DeleteContactList(r.Context(), r.PathValue("projectID"), r.PathValue("listID")); err != nil {
+		h.writeServiceError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type addContactsRequest struct {
+	Contacts []struct {
+		Email      string            `json:"email"`
+		Attributes map[string]string `json:"attributes"`
+		Locale     string            `json:"locale"`
+		Timezone   string            `json:"timezone"`
+	} `json:"contacts"`
+}
+
+// addContacts adds contacts to a list from a JSON body, or imports them
+// from CSV if the body has a text/csv content type.
+func (h *Handler) addContacts(w http.ResponseWriter, r *http.Request) {
+	projectID, listID := r.PathValue("projectID"), r.PathValue("listID")
+
+	var n int
+	var err error
+	if mt, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mt == "text/csv" {
+		n, err = h.svc.ImportContactsCSV(r.Context(), projectID, listID,
+			http.MaxBytesReader(w, r.Body, maxBodySize))
+	} else {
+		var req addContactsRequest
+		if !h.decode(w, r, &req) {
+			return
+		}
+		contacts := make([]entity.Contact, 0, len(req.Contacts))
+		for _, c := range req.Contacts {
+			contacts = append(contacts, entity.Contact{
+				Email:      c.Email,
+				Attributes: c.Attributes,
+				Locale:     c.Locale,
+				Timezone:   c.Timezone,
+			})
+		}
+		n, err = h.svc.AddContacts(r.Context(), projectID, listID, contacts)
+	}
+	if err != nil {
+		h.writeServiceError(w, err)
+		return
+	}
+	h.writeJSON(w, http.StatusOK, map[string]any{"added": n})
+}
+
+func (h *Handler) listContacts(w http.ResponseWriter, r *http.Request) {
+	limit, ok := h.queryInt(w, r, "limit")
+	if !ok {
+		return
+	}
+	list, err := h.svc.ListContacts(r.Context(), entity.ListContactsParams{
+		ProjectID: r.PathValue("projectID"),
+		ListID:    r.PathValue("listID"),
+		After:     r.URL.Query().Get("after"),
+		Limit:     limit,
+	})
+	if err != nil {
+		h.writeServiceError(w, err)
+		return
+	}
+
+	contacts := make([]contact, 0, len(list))
+	for _, c := range list {
+		attrs := c.Attributes
+		if attrs == nil {
+			attrs = map[string]string{}
+		}
+		contacts = append(contacts, contact{
+			Email:      c.Email,
+			Attributes: attrs,
+			Locale:     c.Locale,
+			Timezone:   c.Timezone,
+			CreatedAt:  c.CreatedAt,
+			ModifiedAt: c.ModifiedAt,
+		})
+	}
+	h.writeJSON(w, http.StatusOK, map[string]any{"contacts": contacts})
+}
+
+func (h *Handler) deleteContact(w http.ResponseWriter, r *http.Request) {
+	if err := h.svc.DeleteContact(r.Context(), r.PathValue("projectID"), r.PathValue("listID"),
+		r.PathValue("email")); err != nil {
+		h.writeServiceError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,507 @@
+package httpapi
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/andyfusniak/squishy-mailer-lite/entity"
+)
+
+// attachment is an attachment sent with a single email. Content is base64
+// encoded in JSON. Unlike entity.EmailAttachment there is no path, so
+// clients cannot read files from the server.
+type attachment struct {
+	Filename    string `json:"filename"`
+	ContentType string `json:"content_type"`
+	Content     []byte `json:"content"`
+}
+
+type sendEmailRequest struct {
+	TemplateID      string            `json:"template_id"`
+	TransportID     string            `json:"transport_id"`
+	To              []string          `json:"to"`
+	Cc              []string          `json:"cc"`
+	Bcc             []string          `json:"bcc"`
+	Subject         string            `json:"subject"`
+	TemplateParams  map[string]any    `json:"template_params"`
+	Timezone        string            `json:"timezone"`
+	Locale          string            `json:"locale"`
+	MessageStream   string            `json:"message_stream"`
+	SendAt          time.Time         `json:"send_at"`
+	Attachments     []attachment      `json:"attachments"`
+	StrictParams    bool              `json:"strict_params"`
+	PlainTextParams bool              `json:"plain_text_params"`
+	Unsubscribe     bool              `json:"unsubscribe"`
+	TrackOpens      bool              `json:"track_opens"`
+	Headers         map[string]string `json:"headers"`
+	MessageID       string            `json:"message_id"`
+	InReplyTo       string            `json:"in_reply_to"`
+	References      []string          `json:"references"`
+	EmailFrom       string            `json:"email_from"`
+	EmailFromName   string            `json:"email_from_name"`
+	ReplyTo         []string          `json:"reply_to"`
+	Labels          map[string]string `json:"labels"`
+	CalendarEvent   *calendarEvent    `json:"calendar_event"`
+}
+
+// calendarEvent is a meeting invitation sent with an email.
+type calendarEvent struct {
+	UID           string    `json:"uid"`
+	Sequence      int       `json:"sequence"`
+	Summary       string    `json:"summary"`
+	Description   string    `json:"description"`
+	Location      string    `json:"location"`
+	Start         time.Time `json:"start"`
+	End           time.Time `json:"end"`
+	Organizer     string    `json:"organizer"`
+	OrganizerName string    `json:"organizer_name"`
+	Attendees     []string  `json:"attendees"`
+}
+
+func (req *sendEmailRequest) params(projectID string) entity.SendEmailParams {
+	p := entity.SendEmailParams{
+		TemplateID:      req.TemplateID,
+		ProjectID:       projectID,
+		TransportID:     req.TransportID,
+		To:              req.To,
+		Subject:         req.Subject,
+		TemplateParams:  req.TemplateParams,
+		Cc:              req.Cc,
+		Bcc:             req.Bcc,
+		Timezone:        req.Timezone,
+		Locale:          req.Locale,
+		MessageStream:   req.MessageStream,
+		SendAt:          req.SendAt,
+		StrictParams:    req.StrictParams,
+		PlainTextParams: req.PlainTextParams,
+		Unsubscribe:     req.Unsubscribe,
+		TrackOpens:      req.TrackOpens,
+		Headers:         req.Headers,
+		MessageID:       req.MessageID,
+		InReplyTo:       req.InReplyTo,
+		References:      req.References,
+		EmailFrom:       req.EmailFrom,
+		EmailFromName:   req.EmailFromName,
+		ReplyTo:         req.ReplyTo,
+		Labels:          req.Labels,
+	}
+	if p.TemplateParams == nil {
+		p.TemplateParams = map[string]any{}
+	}
+	if e := req.CalendarEvent; e != nil {
+		p.CalendarEvent = &entity.CalendarEvent{
+			UID:           e.UID,
+			Sequence:      e.Sequence,
+			Summary:       e.Summary,
+			Description:   e.Description,
+			Location:      e.Location,
+			Start:         e.Start,
+			End:           e.End,
+			Organizer:     e.Organizer,
+			OrganizerName: e.OrganizerName,
+			Attendees:     e.Attendees,
+		}
+	}
+	for _, a := range req.Attachments {
+		p.Attachments = append(p.Attachments, entity.EmailAttachment{
+			Filename:    a.Filename,
+			ContentType: a.ContentType,
+			Content:     a.Content,
+		})
+	}
+	return p
+}
+
+type mailQueue struct {
+	ID              string            `json:"id"`
+	ProjectID       string            `json:"project_id"`
+	TemplateID      string            `json:"template_id"`
+	TransportID     string            `json:"transport_id"`
+	State           string            `json:"state"`
+	To              []string          `json:"to"`
+	Cc              []string          `json:"cc"`
+	Bcc             []string          `json:"bcc"`
+	Subject         string            `json:"subject"`
+	EmailFrom       string            `json:"email_from"`
+	EmailFromName   string            `json:"email_from_name"`
+	ReplyTo         []string          `json:"reply_to"`
+	MessageStream   string            `json:"message_stream"`
+	Headers         map[string]string `json:"headers"`
+	Text            string            `json:"text"`
+	TextDigest      string            `json:"text_digest"`
+	HTML            string            `json:"html"`
+	HTMLDigest      string            `json:"html_digest"`
+	TemplateParams  map[string]any    `json:"template_params"`
+	Redacted        bool              `json:"redacted"`
+	LastError       string            `json:"last_error"`
+	TrackOpens      bool              `json:"track_opens"`
+	Attempts        int               `json:"attempts"`
+	SentAt          *time.Time        `json:"sent_at"`
+	SentTransportID string            `json:"sent_transport_id"`
+	CampaignID      string            `json:"campaign_id"`
+	Test            bool              `json:"test"`
+	Labels          map[string]string `json:"labels"`
+	SendAt          entity.ISOTime    `json:"send_at"`
+	NextAttemptAt   entity.ISOTime    `json:"next_attempt_at"`
+	DeferralReason  string            `json:"deferral_reason"`
+	MessageID       string            `json:"message_id"`
+	InReplyTo       string            `json:"in_reply_to"`
+	References      []string          `json:"references"`
+	CreatedAt       entity.ISOTime    `json:"created_at"`
+	ModifiedAt      entity.ISOTime    `json:"modified_at"`
+}
+
+func mailQueueResponse(mq *entity.MailQueue) mailQueue {
+	headers := mq.Headers
+	if headers == nil {
+		headers = map[string]string{}
+	}
+	return mailQueue{
+		ID:              mq.ID,
+		ProjectID:       mq.ProjectID,
+		TemplateID:      mq.TemplateID,
+		TransportID:     mq.TransportID,
+		State:           string(mq.State),
+		To:              nonNil(mq.To),
+		Cc:              nonNil(mq.Cc),
+		Bcc:             nonNil(mq.Bcc),
+		Subject:         mq.Subject,
+		EmailFrom:       mq.EmailFrom,
+		EmailFromName:   mq.EmailFromName,
+		ReplyTo:         nonNil(mq.ReplyTo),
+		MessageStream:   mq.MessageStream,
+		Headers:         headers,
+		Text:            mq.Text,
+		TextDigest:      mq.TextDigest,
+		HTML:            mq.HTML,
+		HTMLDigest:      mq.HTMLDigest,
+		TemplateParams:  mq.TemplateParams,
+		Redacted:        mq.Redacted,
+		LastError:       mq.LastError,
+		TrackOpens:      mq.TrackOpens,
+		Attempts:        mq.Attempts,
+		SentAt:          optionalTime(mq.SentAt),
+		SentTransportID: mq.SentTransportID,
+		CampaignID:      mq.CampaignID,
+		Test:            mq.Test,
+		Labels:          nonNilMap(mq.Labels),
+		SendAt:          mq.SendAt,
+		NextAttemptAt:   mq.NextAttemptAt,
+		DeferralReason:  mq.DeferralReason,
+		MessageID:       mq.MessageID,
+		InReplyTo:       mq.InReplyTo,
+		References:      nonNil(mq.References),
+		CreatedAt:       mq.CreatedAt,
+		ModifiedAt:      mq.ModifiedAt,
+	}
+}
+
+// queueEmail adds an email to the mail queue for delivery by
+// ProcessMailQueue.
+func (h *Handler) queueEmail(w http.ResponseWriter, r *http.Request) {
+	var req sendEmailRequest
+	if !h.decode(w, r, &req) {
+		return
+	}
+	mq, err := h.svc.SendEmailAsync(r.Context(), req.params(r.PathValue("projectID")))
+	if err != nil {
+		h.writeServiceError(w, err)
+		return
+	}
+	h.writeJSON(w, http.StatusAccepted, mailQueueResponse(mq))
+}
+
+// sendEmail sends an email immediately, bypassing the mail queue.
+func (h *Handler) sendEmail(w http.ResponseWriter, r *http.Request) {
+	var req sendEmailRequest
+	if !h.decode(w, r, &req) {
+		return
+	}
+	if err := h.svc.SendEmail(r.Context(), req.params(r.PathValue("projectID"))); err != nil {
+		h.writeServiceError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type testSendRequest struct {
+	TemplateID     string         `json:"template_id"`
+	TransportID    string         `json:"transport_id"`
+	TemplateParams map[string]any `json:"template_params"`
+	SeedListID     string         `json:"seed_list_id"`
+}
+
+// testSend queues a test email of a template for each address of a seed
+// list.
+func (h *Handler) testSend(w http.ResponseWriter, r *http.Request) {
+	var req testSendRequest
+	if !h.decode(w, r, &req) {
+		return
+	}
+	params := entity.TestSendParams{
+		TemplateID:     req.TemplateID,
+		ProjectID:      r.PathValue("projectID"),
+		TransportID:    req.TransportID,
+		TemplateParams: req.TemplateParams,
+		SeedListID:     req.SeedListID,
+	}
+	if params.TemplateParams == nil {
+		params.TemplateParams = map[string]any{}
+	}
+	results, err := h.svc.TestSend(r.Context(), params)
+	if err != nil {
+		h.writeServiceError(w, err)
+		return
+	}
+	list := make([]campaignResult, 0, len(results))
+	for _, res := range results {
+		var cr campaignResult
+		if res.Err != nil {
+			cr.Error = h.resultError(res.Err)
+		} else {
+			cr.MailQueueID = res.MailQueue.ID
+			cr.Email = res.MailQueue.To[0]
+		}
+		list = append(list, cr)
+	}
+	h.writeJSON(w, http.StatusAccepted, map[string]any{"results": list})
+}
+
+func (h *Handler) getMailQueue(w http.ResponseWriter, r *http.Request) {
+	mq, err := h.svc.GetMailQueue(r.Context(), r.PathValue("projectID"), r.PathValue("mailQueueID"))
+	if err != nil {
+		h.writeServiceError(w, err)
+		return
+	}
+	h.writeJSON(w, http.StatusOK, mailQueueResponse(mq))
+}
+
+func (h *Handler) listMailQueue(w http.ResponseWriter, r *http.Request) {
+	limit, ok := h.queryInt(w, r, "limit")
+	if !ok {
+		return
+	}
+	labels, ok := h.queryLabels(w, r, "label")
+	if !ok {
+		return
+	}
+	list, err := h.svc.ListMailQueue(r.Context(), entity.ListMailQueueParams{
+		ProjectID: r.PathValue("projectID"),
+		State:     entity.MailState(r.URL.Query().Get("state")),
+		Labels:    labels,
+		After:     r.URL.Query().Get("after"),
+		Limit:     limit,
+	})
+	if err != nil {
+		h.writeServiceError(w, err)
+		return
+	}
+
+	emails := make([]mailQueue, 0, len(list))
+	for _, mq := range list {
+		emails = append(emails, mailQueueResponse(mq))
+	}
+	h.writeJSON(w, http.StatusOK, map[string]any{"mail_queue": emails})
+}
+
+type mailQueueAttempt struct {
+	Attempt      int            `json:"attempt"`
+	TransportID  string         `json:"transport_id"`
+	State        string         `json:"state"`
+	Error        string         `json:"error"`
+	ResponseCode int            `json:"response_code"`
+	Response     string         `json:"response"`
+	StartedAt    entity.ISOTime `json:"started_at"`
+	CreatedAt    entity.ISOTime `json:"created_at"`
+}
+
+func mailQueueAttemptResponse(a *entity.MailQueueAttempt) mailQueueAttempt {
+	return mailQueueAttempt{
+		Attempt:      a.Attempt,
+		TransportID:  a.TransportID,
+		State:        string(a.State),
+		Error:        a.Error,
+		ResponseCode: a.ResponseCode,
+		Response:     a.Response,
+		StartedAt:    a.StartedAt,
+		CreatedAt:    a.CreatedAt,
+	}
+}
+
+// optionalTime returns nil for the zero time so that it is written as
+// null.
+func optionalTime(t entity.ISOTime) *time.Time {
+	if time.Time(t).IsZero() {
+		return nil
+	}
+	v := time.Time(t)
+	return &v
+}
+
+func (h *Handler) listMailQueueAttempts(w http.ResponseWriter, r *http.Request) {
+	list, err := h.svc.ListMailQueueAttempts(r.Context(), r.PathValue("projectID"), r.PathValue("mailQueueID"))
+	if err != nil {
+		h.writeServiceError(w, err)
+		return
+	}
+
+	attempts := make([]mailQueueAttempt, 0, len(list))
+	for _, a := range list {
+		attempts = append(attempts, mailQueueAttemptResponse(a))
+	}
+	h.writeJSON(w, http.StatusOK, map[string]any{"attempts": attempts})
+}
+
+func (h *Handler) retryMailQueue(w http.ResponseWriter, r *http.Request) {
+	mq, err := h.svc.RetryMailQueue(r.Context(), r.PathValue("projectID"), r.PathValue("mailQueueID"))
+	if err != nil {
+		h.writeServiceError(w, err)
+		return
+	}
+	h.writeJSON(w, http.StatusOK, mailQueueResponse(mq))
+}
+
+func (h *Handler) cancelMailQueue(w http.ResponseWriter, r *http.Request) {
+	mq, err := h.svc.CancelMailQueue(r.Context(), r.PathValue("projectID"), r.PathValue("mailQueueID"))
+	if err != nil {
+		h.writeServiceError(w, err)
+		return
+	}
+	h.writeJSON(w, http.StatusOK, mailQueueResponse(mq))
+}
+
+func (h *Handler) listDeadLetters(w http.ResponseWriter, r *http.Request) {
+	limit, ok := h.queryInt(w, r, "limit")
+	if !ok {
+		return
+	}
+	list, err := h.svc.ListDeadLetters(r.Context(), entity.ListDeadLettersParams{
+		ProjectID: r.PathValue("projectID"),
+		After:     r.URL.Query().Get("after"),
+		Limit:     limit,
+	})
+	if err != nil {
+		h.writeServiceError(w, err)
+		return
+	}
+
+	emails := make([]mailQueue, 0, len(list))
+	for _, mq := range list {
+		emails = append(emails, mailQueueResponse(mq))
+	}
+	h.writeJSON(w, http.StatusOK, map[string]any{"dead_letters": emails})
+}
+
+type requeueDeadLetterRequest struct {
+	TransportID string `json:"transport_id"`
+}
+
+// requeueDeadLetter returns a dead letter to the queue. The request body
+// is optional.
+func (h *Handler) requeueDeadLetter(w http.ResponseWriter, r *http.Request) {
+	var req requeueDeadLetterRequest
+	if r.ContentLength != 0 && !h.decode(w, r, &req) {
+		return
+	}
+	mq, err := h.svc.RequeueDeadLetter(r.Context(), entity.RequeueDeadLetterParams{
+		ProjectID:   r.PathValue("projectID"),
+		MailQueueID: r.PathValue("mailQueueID"),
+		TransportID: req.TransportID,
+	})
+	if err != nil {
+		h.writeServiceError(w, err)
+		return
+	}
+	h.writeJSON(w, http.StatusOK, mailQueueResponse(mq))
+}
+
+type mailStatus struct {
+	ID              string             `json:"id"`
+	ProjectID       string             `json:"project_id"`
+	TemplateID      string             `json:"template_id"`
+	TransportID     string             `json:"transport_id"`
+	State           string             `json:"state"`
+	To              []string           `json:"to"`
+	Subject         string             `json:"subject"`
+	LastError       string             `json:"last_error"`
+	Attempts        int                `json:"attempts"`
+	QueuedAt        entity.ISOTime     `json:"queued_at"`
+	SendAt          entity.ISOTime     `json:"send_at"`
+	NextAttemptAt   entity.ISOTime     `json:"next_attempt_at"`
+	DeferralReason  string             `json:"deferral_reason"`
+	SentAt          *time.Time         `json:"sent_at"`
+	SentTransportID string             `json:"sent_transport_id"`
+	UpdatedAt       entity.ISOTime     `json:"updated_at"`
+	History         []mailQueueAttempt `json:"history,omitempty"`
+
+	// the open counts are only returned by getMailStatus
+	Opens         *int       `json:"opens,omitempty"`
+	FirstOpenedAt *time.Time `json:"first_opened_at,omitempty"`
+	LastOpenedAt  *time.Time `json:"last_opened_at,omitempty"`
+}
+
+func mailStatusResponse(st *entity.MailStatus) mailStatus {
+	resp := mailStatus{
+		ID:              st.ID,
+		ProjectID:       st.ProjectID,
+		TemplateID:      st.TemplateID,
+		TransportID:     st.TransportID,
+		State:           string(st.State),
+		To:              nonNil(st.To),
+		Subject:         st.Subject,
+		LastError:       st.LastError,
+		Attempts:        st.Attempts,
+		QueuedAt:        st.QueuedAt,
+		SendAt:          st.SendAt,
+		NextAttemptAt:   st.NextAttemptAt,
+		DeferralReason:  st.DeferralReason,
+		SentAt:          optionalTime(st.SentAt),
+		SentTransportID: st.SentTransportID,
+		UpdatedAt:       st.UpdatedAt,
+	}
+	for _, a := range st.History {
+		resp.History = append(resp.History, mailQueueAttemptResponse(a))
+	}
+	return resp
+}
+
+func (h *Handler) listMail(w http.ResponseWriter, r *http.Request) {
+	limit, ok := h.queryInt(w, r, "limit")
+	if !ok {
+		return
+	}
+	labels, ok := h.queryLabels(w, r, "label")
+	if !ok {
+		return
+	}
+	list, err := h.svc.ListMail(r.Context(), entity.ListMailQueueParams{
+		ProjectID: r.PathValue("projectID"),
+		State:     entity.MailState(r.URL.Query().Get("state")),
+		Labels:    labels,
+		After:     r.URL.Query().Get("after"),
+		Limit:     limit,
+	})
+	if err != nil {
+		h.writeServiceError(w, err)
+		return
+	}
+
+	statuses := make([]mailStatus, 0, len(list))
+	for _, st := range list {
+		statuses = append(statuses, mailStatusResponse(st))
+	}
+	h.writeJSON(w, http.StatusOK, map[string]any{"mail": statuses})
+}
+
+func (h *Handler) getMailStatus(w http.ResponseWriter, r *http.Request) {
+	st, err := h.svc.GetMailStatus(r.Context(), r.PathValue("projectID"), r.PathValue("mailQueueID"))
+	if err != nil {
+		h.writeServiceError(w, err)
+		return
+	}
+	resp := mailStatusResponse(st)
+	resp.Opens = &st.Opens
+	resp.FirstOpenedAt = optionalTime(st.FirstOpenedAt)
+	resp.LastOpenedAt = optionalTime(st.LastOpenedAt)
+	h.writeJSON(w, http.StatusOK, resp)
+}
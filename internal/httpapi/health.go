@@ -0,0 +1,112 @@
+package httpapi
+
+import (
+	"net/http"
+
+	"github.com/andyfusniak/squishy-mailer-lite/entity"
+)
+
+// healthzPath is the unauthenticated health check for load balancers.
+const healthzPath = "/healthz"
+
+type healthCheck struct {
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
+
+func healthCheckResponse(c entity.HealthCheck) healthCheck {
+	return healthCheck{OK: c.OK, Error: c.Error}
+}
+
+type schemaVersion struct {
+	Version uint `json:"version"`
+	Dirty   bool `json:"dirty"`
+	Latest  uint `json:"latest"`
+}
+
+type transportHealth struct {
+	ProjectID   string         `json:"project_id"`
+	TransportID string         `json:"transport_id"`
+	OK          bool           `json:"ok"`
+	Error       string         `json:"error,omitempty"`
+	VerifiedAt  entity.ISOTime `json:"verified_at"`
+}
+
+type health struct {
+	Status        string            `json:"status"`
+	Database      healthCheck       `json:"database"`
+	Schema        healthCheck       `json:"schema"`
+	EncryptionKey healthCheck       `json:"encryption_key"`
+	SchemaVersion *schemaVersion    `json:"schema_version,omitempty"`
+	Transports    []transportHealth `json:"transports"`
+	CheckedAt     entity.ISOTime    `json:"checked_at"`
+}
+
+func healthResponse(h *entity.Health) health {
+	resp := health{
+		Status:        healthStatus(h),
+		Database:      healthCheckResponse(h.Database),
+		Schema:        healthCheckResponse(h.Schema),
+		EncryptionKey: healthCheckResponse(h.EncryptionKey),
+		Transports:    make([]transportHealth, 0, len(h.Transports)),
+		CheckedAt:     h.CheckedAt,
+	}
+	if v := h.SchemaVersion; v != nil {
+		resp.SchemaVersion = &schemaVersion{Version: v.Version, Dirty: v.Dirty, Latest: v.Latest}
+	}
+	for _, t := range h.Transports {
+		resp.Transports = append(resp.Transports, transportHealth{
+			ProjectID:   t.ProjectID,
+			TransportID: t.TransportID,
+			OK:          t.OK,
+			Error:       t.Error,
+			VerifiedAt:  t.VerifiedAt,
+		})
+	}
+	return resp
+}
+
+func healthStatus(h *entity.Health) string {
+	if h.Healthy {
+		return "ok"
+	}
+	return "unhealthy"
+}
+
+func healthStatusCode(h *entity.Health) int {
+	if h.Healthy {
+		return http.StatusOK
+	}
+	return http.StatusServiceUnavailable
+}
+
+type healthzChecks struct {
+	Database      bool `json:"database"`
+	Schema        bool `json:"schema"`
+	EncryptionKey bool `json:"encryption_key"`
+}
+
+type healthz struct {
+	Status string        `json:"status"`
+	Checks healthzChecks `json:"checks"`
+}
+
+// healthz reports whether the service is healthy with a status of 200 or
+// 503. As it needs no api key it leaves out error messages and
+// transports, which are reported by getHealth.
+func (h *Handler) healthz(w http.ResponseWriter, r *http.Request) {
+	hl := h.svc.Health(r.Context())
+	h.writeJSON(w, healthStatusCode(hl), healthz{
+		Status: healthStatus(hl),
+		Checks: healthzChecks{
+			Database:      hl.Database.OK,
+			Schema:        hl.Schema.OK,
+			EncryptionKey: hl.EncryptionKey.OK,
+		},
+	})
+}
+
+func (h *Handler) getHealth(w http.ResponseWriter, r *http.Request) {
+	hl := h.svc.Health(r.Context())
+	h.writeJSON(w, healthStatusCode(hl), healthResponse(hl))
+}
@@ -0,0 +1,442 @@
+// Package httpapi exposes the email service as a JSON REST API. It is used
+// by sqm serve so that applications not written in Go can send email.
+package httpapi
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/andyfusniak/squishy-mailer-lite/entity"
+	"github.com/andyfusniak/squishy-mailer-lite/service"
+)
+
+// maxBodySize is the largest request body accepted. It leaves room for
+// base64 encoded attachments.
+const maxBodySize = 32 << 20
+
+// Handler serves the REST API.
+type Handler struct {
+	svc     *service.Service
+	apiKeys [][]byte
+	mux     *http.ServeMux
+	logger  *slog.Logger
+}
+
+// New returns a handler serving the REST API backed by svc. Every request
+// must present an api key as a bearer token in the Authorization header,
+// or as the password of HTTP basic authentication for callers such as
+// Amazon SNS that cannot set a bearer token. The static apiKeys grant
+// access to every route. Keys minted by Service.CreateAPIKey only grant
+// their role on the routes of their projects, see requiredRole. The
+// unsubscribe and open tracking routes carry a signed token instead, and
+// the /healthz load balancer check needs no authentication. Errors that
+// are not reported to the client are logged to logger, or discarded if it
+// is nil.
+func New(svc *service.Service, apiKeys []string, logger *slog.Logger) *Handler {
+	if logger == nil {
+		logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	}
+	h := &Handler{
+		svc:    svc,
+		mux:    http.NewServeMux(),
+		logger: logger,
+	}
+	for _, k := range apiKeys {
+		if k != "" {
+			h.apiKeys = append(h.apiKeys, []byte(k))
+		}
+	}
+
+	// projects
+	h.mux.HandleFunc("POST /v1/projects", h.createProject)
+	h.mux.HandleFunc("GET /v1/projects/{projectID}", h.getProject)
+	h.mux.HandleFunc("PATCH /v1/projects/{projectID}", h.updateProject)
+	h.mux.HandleFunc("DELETE /v1/projects/{projectID}", h.deleteProject)
+	h.mux.HandleFunc("PUT /v1/projects/{projectID}/transport-chain", h.setTransportChain)
+	h.mux.HandleFunc("PUT /v1/projects/{projectID}/labels", h.setProjectLabels)
+	h.mux.HandleFunc("PUT /v1/projects/{projectID}/variables", h.setProjectVariables)
+	h.mux.HandleFunc("PUT /v1/projects/{projectID}/quota", h.setQuota)
+	h.mux.HandleFunc("GET /v1/projects/{projectID}/quota", h.getQuota)
+	h.mux.HandleFunc("DELETE /v1/projects/{projectID}/quota", h.deleteQuota)
+	h.mux.HandleFunc("GET /v1/projects/{projectID}/quota/usage", h.getQuotaUsage)
+
+	// transports
+	h.mux.HandleFunc("POST /v1/projects/{projectID}/smtp-transports", h.createSMTPTransport)
+	h.mux.HandleFunc("GET /v1/projects/{projectID}/smtp-transports/{transportID}", h.getSMTPTransport)
+	h.mux.HandleFunc("PUT /v1/projects/{projectID}/smtp-transports/{transportID}", h.updateSMTPTransport)
+	h.mux.HandleFunc("DELETE /v1/projects/{projectID}/smtp-transports/{transportID}", h.deleteSMTPTransport)
+	h.mux.HandleFunc("POST /v1/projects/{projectID}/api-transports", h.createAPITransport)
+	h.mux.HandleFunc("GET /v1/projects/{projectID}/api-transports/{transportID}", h.getAPITransport)
+
+	// groups
+	h.mux.HandleFunc("POST /v1/projects/{projectID}/groups", h.createGroup)
+	h.mux.HandleFunc("GET /v1/projects/{projectID}/groups", h.listGroups)
+	h.mux.HandleFunc("GET /v1/projects/{projectID}/groups/{groupID}", h.getGroup)
+	h.mux.HandleFunc("PATCH /v1/projects/{projectID}/groups/{groupID}", h.updateGroup)
+	h.mux.HandleFunc("DELETE /v1/projects/{projectID}/groups/{groupID}", h.deleteGroup)
+
+	// templates
+	h.mux.HandleFunc("POST /v1/projects/{projectID}/templates", h.createTemplate)
+	h.mux.HandleFunc("GET /v1/projects/{projectID}/templates", h.listTemplates)
+	h.mux.HandleFunc("GET /v1/projects/{projectID}/templates/search", h.searchTemplates)
+	h.mux.HandleFunc("GET /v1/projects/{projectID}/templates/{templateID}", h.getTemplate)
+	h.mux.HandleFunc("PUT /v1/projects/{projectID}/templates/{templateID}", h.setTemplate)
+	h.mux.HandleFunc("DELETE /v1/projects/{projectID}/templates/{templateID}", h.deleteTemplate)
+	h.mux.HandleFunc("PUT /v1/projects/{projectID}/templates/{templateID}/labels", h.setTemplateLabels)
+	h.mux.HandleFunc("GET /v1/projects/{projectID}/templates/{templateID}/params", h.inspectTemplate)
+	h.mux.HandleFunc("POST /v1/projects/{projectID}/templates/{templateID}/render", h.renderTemplate)
+	h.mux.HandleFunc("GET /v1/projects/{projectID}/templates/{templateID}/fixtures", h.listTemplateFixtures)
+	h.mux.HandleFunc("GET /v1/projects/{projectID}/templates/{templateID}/fixtures/{name}", h.getTemplateFixture)
+	h.mux.HandleFunc("PUT /v1/projects/{projectID}/templates/{templateID}/fixtures/{name}", h.setTemplateFixture)
+	h.mux.HandleFunc("DELETE /v1/projects/{projectID}/templates/{templateID}/fixtures/{name}", h.deleteTemplateFixture)
+	h.mux.HandleFunc("POST /v1/projects/{projectID}/templates/lint", h.lintTemplate)
+
+	// assets
+	h.mux.HandleFunc("GET /v1/projects/{projectID}/assets", h.listAssets)
+	h.mux.HandleFunc("GET /v1/projects/{projectID}/assets/{assetID}", h.getAsset)
+	h.mux.HandleFunc("PUT /v1/projects/{projectID}/assets/{assetID}", h.setAsset)
+	h.mux.HandleFunc("DELETE /v1/projects/{projectID}/assets/{assetID}", h.deleteAsset)
+
+	// emails
+	h.mux.HandleFunc("POST /v1/projects/{projectID}/emails", h.queueEmail)
+	h.mux.HandleFunc("POST /v1/projects/{projectID}/emails/send", h.sendEmail)
+	h.mux.HandleFunc("POST /v1/projects/{projectID}/emails/test", h.testSend)
+	h.mux.HandleFunc("GET /v1/projects/{projectID}/mail-queue", h.listMailQueue)
+	h.mux.HandleFunc("GET /v1/projects/{projectID}/mail-queue/stats", h.getQueueStats)
+	h.mux.HandleFunc("GET /v1/projects/{projectID}/mail-queue/{mailQueueID}", h.getMailQueue)
+	h.mux.HandleFunc("GET /v1/projects/{projectID}/mail-queue/{mailQueueID}/attempts", h.listMailQueueAttempts)
+	h.mux.HandleFunc("GET /v1/projects/{projectID}/mail-queue/{mailQueueID}/status", h.getMailStatus)
+	h.mux.HandleFunc("GET /v1/projects/{projectID}/mail", h.listMail)
+	h.mux.HandleFunc("POST /v1/projects/{projectID}/mail-queue/{mailQueueID}/retry", h.retryMailQueue)
+	h.mux.HandleFunc("POST /v1/projects/{projectID}/mail-queue/{mailQueueID}/cancel", h.cancelMailQueue)
+	h.mux.HandleFunc("GET /v1/projects/{projectID}/dead-letters", h.listDeadLetters)
+	h.mux.HandleFunc("POST /v1/projects/{projectID}/dead-letters/{mailQueueID}/requeue", h.requeueDeadLetter)
+	h.mux.HandleFunc("GET /v1/projects/{projectID}/stats", h.getProjectStats)
+
+	// webhooks
+	h.mux.HandleFunc("POST /v1/projects/{projectID}/webhooks", h.createWebhook)
+	h.mux.HandleFunc("GET /v1/projects/{projectID}/webhooks", h.listWebhooks)
+	h.mux.HandleFunc("GET /v1/projects/{projectID}/webhooks/{webhookID}", h.getWebhook)
+	h.mux.HandleFunc("DELETE /v1/projects/{projectID}/webhooks/{webhookID}", h.deleteWebhook)
+	h.mux.HandleFunc("GET /v1/projects/{projectID}/webhooks/{webhookID}/deliveries", h.listWebhookDeliveries)
+
+	// campaigns
+	h.mux.HandleFunc("POST /v1/projects/{projectID}/campaigns", h.createCampaign)
+	h.mux.HandleFunc("GET /v1/projects/{projectID}/campaigns", h.listCampaigns)
+	h.mux.HandleFunc("GET /v1/projects/{projectID}/campaigns/{campaignID}", h.getCampaign)
+	h.mux.HandleFunc("GET /v1/projects/{projectID}/campaigns/{campaignID}/stats", h.getCampaignStats)
+	h.mux.HandleFunc("POST /v1/projects/{projectID}/campaigns/{campaignID}/pause", h.pauseCampaign)
+	h.mux.HandleFunc("POST /v1/projects/{projectID}/campaigns/{campaignID}/resume", h.resumeCampaign)
+
+	// contact lists
+	h.mux.HandleFunc("POST /v1/projects/{projectID}/contact-lists", h.createContactList)
+	h.mux.HandleFunc("GET /v1/projects/{projectID}/contact-lists", h.listContactLists)
+	h.mux.HandleFunc("GET /v1/projects/{projectID}/contact-lists/{listID}", h.getContactList)
+	h.mux.HandleFunc("DELETE /v1/projects/{projectID}/contact-lists/{listID}", h.deleteContactList)
+	h.mux.HandleFunc("POST /v1/projects/{projectID}/contact-lists/{listID}/contacts", h.addContacts)
+	h.mux.HandleFunc("GET /v1/projects/{projectID}/contact-lists/{listID}/contacts", h.listContacts)
+	h.mux.HandleFunc("DELETE /v1/projects/{projectID}/contact-lists/{listID}/contacts/{email}", h.deleteContact)
+
+	// bounces and suppressions
+	h.mux.HandleFunc("POST /v1/projects/{projectID}/ses-notifications", h.sesNotification)
+	h.mux.HandleFunc("POST /v1/projects/{projectID}/suppressions", h.addSuppression)
+	h.mux.HandleFunc("GET /v1/projects/{projectID}/suppressions", h.listSuppressions)
+	h.mux.HandleFunc("DELETE /v1/projects/{projectID}/suppressions/{email}", h.deleteSuppression)
+
+	// api keys, managed using the static keys only
+	h.mux.HandleFunc("POST /v1/api-keys", h.createAPIKey)
+	h.mux.HandleFunc("GET /v1/api-keys", h.listAPIKeys)
+	h.mux.HandleFunc("DELETE /v1/api-keys/{keyID}", h.revokeAPIKey)
+
+	// health, with details for static keys only
+	h.mux.HandleFunc("GET /v1/health", h.getHealth)
+
+	// unsubscribe links and tracking pixels loaded by recipients, see
+	// publicPrefixes
+	h.mux.HandleFunc("GET "+unsubscribePrefix+"{token}", h.unsubscribePage)
+	h.mux.HandleFunc("POST "+unsubscribePrefix+"{token}", h.unsubscribe)
+	h.mux.HandleFunc("GET "+openPrefix+"{token}", h.trackOpen)
+	h.mux.HandleFunc("GET "+healthzPath, h.healthz)
+
+	return h
+}
+
+const (
+	unsubscribePrefix = "/v1/unsubscribe/"
+	openPrefix        = "/v1/open/"
+)
+
+// publicPrefixes are the path prefixes of the routes followed by
+// recipients. They are authenticated by the signed token in the path
+// rather than an api key.
+var publicPrefixes = []string{unsubscribePrefix, openPrefix}
+
+// ServeHTTP authenticates and authorises the request and dispatches it to
+// its handler.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if isPublic(r.URL.Path) || r.URL.Path == healthzPath {
+		h.mux.ServeHTTP(w, r)
+		return
+	}
+
+	token, ok := apiKeyToken(r)
+	if !ok {
+		h.writeUnauthorized(w)
+		return
+	}
+	if h.isStaticKey(token) {
+		h.mux.ServeHTTP(w, r)
+		return
+	}
+
+	key, err := h.svc.AuthenticateAPIKey(r.Context(), token)
+	if err != nil {
+		var serr *entity.ServiceError
+		if errors.As(err, &serr) && serr.Code == entity.ErrAPIKeyNotFoundCode {
+			h.writeUnauthorized(w)
+			return
+		}
+		h.writeServiceError(w, err)
+		return
+	}
+	projectID, ok := projectFromPath(r.URL.Path)
+	if !ok || !key.Allows(projectID, requiredRole(r)) {
+		h.writeError(w, http.StatusForbidden, "forbidden", "api key does not grant access to the request")
+		return
+	}
+	h.mux.ServeHTTP(w, r)
+}
+
+func (h *Handler) writeUnauthorized(w http.ResponseWriter) {
+	w.Header().Set("WWW-Authenticate", `Bearer realm="sqm"`)
+	h.writeError(w, http.StatusUnauthorized, "unauthorized", "missing or invalid api key")
+}
+
+func isPublic(path string) bool {
+	for _, p := range publicPrefixes {
+		if strings.HasPrefix(path, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// apiKeyToken returns the api key presented by the request.
+func apiKeyToken(r *http.Request) (string, bool) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		_, token, ok = r.BasicAuth()
+	}
+	return token, ok && token != ""
+}
+
+func (h *Handler) isStaticKey(token string) bool {
+	// compare against every key so the time taken does not reveal which
+	// key matched
+	var match int
+	for _, k := range h.apiKeys {
+		match |= subtle.ConstantTimeCompare([]byte(token), k)
+	}
+	return match == 1
+}
+
+// projectFromPath returns the project id of a route below
+// /v1/projects/{projectID}. Other routes are not scoped to a project.
+func projectFromPath(path string) (string, bool) {
+	rest, ok := strings.CutPrefix(path, "/v1/projects/")
+	if !ok {
+		return "", false
+	}
+	id, _, _ := strings.Cut(rest, "/")
+	return id, id != ""
+}
+
+// requiredRole returns the role a project scoped api key needs for the
+// request. Reads, and linting or rendering a template which change
+// nothing, need read_only and sending email needs sender. All other changes need admin.
+func requiredRole(r *http.Request) entity.APIKeyRole {
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		return entity.APIKeyRoleReadOnly
+	}
+	if r.Method == http.MethodPost {
+		_, rest, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/v1/projects/"), "/")
+		if rest == "emails" || rest == "emails/send" || rest == "emails/test" {
+			return entity.APIKeyRoleSender
+		}
+		if rest == "templates/lint" ||
+			strings.HasPrefix(rest, "templates/") && strings.HasSuffix(rest, "/render") {
+			return entity.APIKeyRoleReadOnly
+		}
+	}
+	return entity.APIKeyRoleAdmin
+}
+
+type errorResponse struct {
+	Error errorBody `json:"error"`
+}
+
+type errorBody struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+
+	// Addresses lists the invalid addresses of an invalid_address error.
+	Addresses []invalidAddress `json:"addresses,omitempty"`
+}
+
+type invalidAddress struct {
+	Field   string `json:"field"`
+	Address string `json:"address"`
+	Reason  string `json:"reason"`
+}
+
+func (h *Handler) writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		h.logger.Warn("write response failed", "status", status, "error", err)
+	}
+}
+
+func (h *Handler) writeError(w http.ResponseWriter, status int, code, msg string) {
+	h.writeJSON(w, status, errorResponse{Error: errorBody{Code: code, Message: msg}})
+}
+
+// writeServiceError writes err as a JSON error. Service errors are
+// returned with their code and a matching status. Other errors are logged
+// and reported as an internal error without their details.
+func (h *Handler) writeServiceError(w http.ResponseWriter, err error) {
+	var serr *entity.ServiceError
+	if !errors.As(err, &serr) {
+		h.logger.Error("request failed", "error", err)
+		h.writeError(w, http.StatusInternalServerError, "internal_error", "internal server error")
+		return
+	}
+	body := errorBody{Code: string(serr.Code), Message: serr.Error()}
+	var aerr *entity.InvalidAddressesError
+	if errors.As(err, &aerr) {
+		for _, a := range aerr.Addresses {
+			body.Addresses = append(body.Addresses, invalidAddress{
+				Field:   a.Field,
+				Address: a.Address,
+				Reason:  a.Reason,
+			})
+		}
+	}
+	h.writeJSON(w, statusFromCode(serr.Code), errorResponse{Error: body})
+}
+
+// statusFromCode maps a service error code to an HTTP status.
+func statusFromCode(code entity.ErrCode) int {
+	c := string(code)
+	switch {
+	case c == entity.ErrProjectScopeViolationCode, strings.HasSuffix(c, "_not_found"):
+		return http.StatusNotFound
+	case strings.HasSuffix(c, "_already_exists"), strings.HasSuffix(c, "_in_use"),
+		strings.HasSuffix(c, "_not_empty"), strings.HasSuffix(c, "_invalid_state"):
+		return http.StatusConflict
+	case c == entity.ErrRecipientBlockedCode, c == entity.ErrRecipientSuppressedCode,
+		c == entity.ErrFromNotAllowedCode:
+		return http.StatusForbidden
+	case c == entity.ErrQuotaExceededCode:
+		return http.StatusTooManyRequests
+	}
+	return http.StatusBadRequest
+}
+
+// decode decodes the JSON request body into v. Unknown fields are
+// rejected so that misspelt fields are not silently ignored.
+func (h *Handler) decode(w http.ResponseWriter, r *http.Request, v any) bool {
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodySize))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		h.writeError(w, http.StatusBadRequest, "invalid_request",
+			fmt.Sprintf("invalid request body: %v", err))
+		return false
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		h.writeError(w, http.StatusBadRequest, "invalid_request",
+			"invalid request body: unexpected data after JSON value")
+		return false
+	}
+	return true
+}
+
+// queryInt returns the integer query parameter name or zero if it is not
+// set.
+func (h *Handler) queryInt(w http.ResponseWriter, r *http.Request, name string) (int, bool) {
+	s := r.URL.Query().Get(name)
+	if s == "" {
+		return 0, true
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 {
+		h.writeError(w, http.StatusBadRequest, "invalid_request",
+			fmt.Sprintf("invalid %s query parameter %q", name, s))
+		return 0, false
+	}
+	return n, true
+}
+
+// queryBool returns the boolean query parameter name or false if it is not
+// set.
+func (h *Handler) queryBool(w http.ResponseWriter, r *http.Request, name string) (bool, bool) {
+	s := r.URL.Query().Get(name)
+	if s == "" {
+		return false, true
+	}
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		h.writeError(w, http.StatusBadRequest, "invalid_request",
+			fmt.Sprintf("invalid %s query parameter %q", name, s))
+		return false, false
+	}
+	return b, true
+}
+
+// queryTime returns the time query parameter name, given as an RFC 3339
+// time or a 2006-01-02 date meaning midnight UTC, or the zero time if it is
+// not set.
+func (h *Handler) queryTime(w http.ResponseWriter, r *http.Request, name string) (time.Time, bool) {
+	s := r.URL.Query().Get(name)
+	if s == "" {
+		return time.Time{}, true
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		t, err = time.Parse(time.DateOnly, s)
+	}
+	if err != nil {
+		h.writeError(w, http.StatusBadRequest, "invalid_request",
+			fmt.Sprintf("invalid %s query parameter %q", name, s))
+		return time.Time{}, false
+	}
+	return t, true
+}
+
+// queryLabels returns the labels given by the repeated query parameter
+// name, each as key:value, or nil if there are none.
+func (h *Handler) queryLabels(w http.ResponseWriter, r *http.Request, name string) (map[string]string, bool) {
+	values := r.URL.Query()[name]
+	if len(values) == 0 {
+		return nil, true
+	}
+	labels := make(map[string]string, len(values))
+	for _, s := range values {
+		k, v, ok := strings.Cut(s, ":")
+		if !ok || k == "" {
+			h.writeError(w, http.StatusBadRequest, "invalid_request",
+				fmt.Sprintf("invalid %s query parameter %q, expected key:value", name, s))
+			return nil, false
+		}
+		labels[k] = v
+	}
+	return labels, true
+}
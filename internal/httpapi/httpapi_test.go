@@ -0,0 +1,391 @@
+package httpapi_test
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/andyfusniak/squishy-mailer-lite/internal/httpapi"
+	"github.com/andyfusniak/squishy-mailer-lite/service"
+	"github.com/stretchr/testify/assert"
+)
+
+const testAPIKey = "test-api-key"
+
+func newTestServer(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	svc, err := service.NewEmailService(
+		service.WithInMemoryStore(),
+		service.WithHexEncodedEncryptionKey("a0bf305856098eba7e4bff506021648b"),
+	)
+	if err != nil {
+		t.Fatalf("service.NewEmailService failed: %+v", err)
+	}
+	t.Cleanup(func() { svc.Close() })
+
+	ts := httptest.NewServer(httpapi.New(svc, []string{"other-key", testAPIKey}, nil))
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+// do sends a request with the test API key and decodes the JSON response
+// into a map. It returns the status code.
+func do(t *testing.T, ts *httptest.Server, method, path string, body any) (int, map[string]any) {
+	t.Helper()
+
+	var r bytes.Buffer
+	if body != nil {
+		if s, ok := body.(string); ok {
+			r.WriteString(s)
+		} else if err := json.NewEncoder(&r).Encode(body); err != nil {
+			t.Fatalf("json encode failed: %+v", err)
+		}
+	}
+	req, err := http.NewRequest(method, ts.URL+path, &r)
+	if err != nil {
+		t.Fatalf("http.NewRequest failed: %+v", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+testAPIKey)
+	resp, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatalf("http request failed: %+v", err)
+	}
+	defer resp.Body.Close()
+
+	var m map[string]any
+	if resp.StatusCode != http.StatusNoContent {
+		if err := json.NewDecoder(resp.Body).Decode(&m); err != nil {
+			t.Fatalf("json decode failed: %+v", err)
+		}
+	}
+	return resp.StatusCode, m
+}
+
+func errorCode(m map[string]any) any {
+	e, _ := m["error"].(map[string]any)
+	return e["code"]
+}
+
+func TestAuthentication(t *testing.T) {
+	ts := newTestServer(t)
+
+	for _, auth := range []string{"", "Bearer wrong-key", testAPIKey} {
+		req, _ := http.NewRequest(http.MethodGet, ts.URL+"/v1/projects/p1", nil)
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		resp, err := ts.Client().Do(req)
+		if err != nil {
+			t.Fatalf("http request failed: %+v", err)
+		}
+		resp.Body.Close()
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode, auth)
+	}
+
+	code, m := do(t, ts, http.MethodGet, "/v1/projects/p1", nil)
+	assert.Equal(t, http.StatusNotFound, code)
+	assert.Equal(t, "project_not_found", errorCode(m))
+
+	// the api key is also accepted as a basic auth password
+	for key, want := range map[string]int{
+		"wrong-key": http.StatusUnauthorized,
+		testAPIKey:  http.StatusNotFound,
+	} {
+		req, _ := http.NewRequest(http.MethodGet, ts.URL+"/v1/projects/p1", nil)
+		req.SetBasicAuth("sns", key)
+		resp, err := ts.Client().Do(req)
+		if err != nil {
+			t.Fatalf("http request failed: %+v", err)
+		}
+		resp.Body.Close()
+		assert.Equal(t, want, resp.StatusCode, key)
+	}
+
+	// unsubscribe links are authenticated by their token
+	for method, want := range map[string]int{
+		http.MethodGet:  http.StatusOK,
+		http.MethodPost: http.StatusBadRequest,
+	} {
+		req, _ := http.NewRequest(method, ts.URL+"/v1/unsubscribe/bad-token", nil)
+		resp, err := ts.Client().Do(req)
+		if err != nil {
+			t.Fatalf("http request failed: %+v", err)
+		}
+		resp.Body.Close()
+		assert.Equal(t, want, resp.StatusCode, method)
+		assert.Equal(t, "text/html; charset=utf-8", resp.Header.Get("Content-Type"))
+	}
+
+	// as are tracking pixels, which are served even if the token is bad
+	resp, err := ts.Client().Get(ts.URL + "/v1/open/bad-token")
+	if err != nil {
+		t.Fatalf("http request failed: %+v", err)
+	}
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "image/gif", resp.Header.Get("Content-Type"))
+}
+
+func TestAPI(t *testing.T) {
+	ts := newTestServer(t)
+
+	code, m := do(t, ts, http.MethodPost, "/v1/projects", map[string]any{
+		"id":   "p1",
+		"name": "Project One",
+	})
+	assert.Equal(t, http.StatusCreated, code)
+	assert.Equal(t, "p1", m["id"])
+	assert.Equal(t, []any{}, m["allowed_recipient_domains"])
+
+	code, m = do(t, ts, http.MethodPost, "/v1/projects", map[string]any{"id": "p1"})
+	assert.Equal(t, http.StatusConflict, code)
+	assert.Equal(t, "project_already_exists", errorCode(m))
+
+	code, m = do(t, ts, http.MethodPost, "/v1/projects", `{"id":"p2","nmae":"typo"}`)
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Equal(t, "invalid_request", errorCode(m))
+
+	code, m = do(t, ts, http.MethodPost, "/v1/projects/p1/smtp-transports", map[string]any{
+		"id":         "tr1",
+		"name":       "Transport One",
+		"host":       "localhost",
+		"port":       2525,
+		"password":   "secret",
+		"email_from": "from@example.com",
+	})
+	assert.Equal(t, http.StatusCreated, code)
+	assert.NotContains(t, m, "password")
+
+	code, _ = do(t, ts, http.MethodPost, "/v1/projects/p1/groups", map[string]any{
+		"id":   "g1",
+		"name": "Group One",
+	})
+	assert.Equal(t, http.StatusCreated, code)
+
+	code, m = do(t, ts, http.MethodPost, "/v1/projects/p1/templates", map[string]any{
+		"id":       "t1",
+		"group_id": "g1",
+		"text":     `{{define "layout"}}Hello {{.name}}{{end}}`,
+		"html":     `{{define "layout"}}<p>Hello {{.name}}</p>{{end}}`,
+		"subject":  "Welcome {{.name}}",
+	})
+	assert.Equal(t, http.StatusCreated, code)
+	assert.Equal(t, "g1", m["group_id"])
+
+	code, m = do(t, ts, http.MethodGet, "/v1/projects/p1/templates?group_id=g1", nil)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Len(t, m["templates"], 1)
+
+	code, m = do(t, ts, http.MethodPut, "/v1/projects/p1/templates/t1/labels", map[string]any{
+		"labels": map[string]string{"team": "billing"},
+	})
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, map[string]any{"team": "billing"}, m["labels"])
+
+	code, m = do(t, ts, http.MethodGet, "/v1/projects/p1/templates?label=team:billing", nil)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Len(t, m["templates"], 1)
+
+	code, m = do(t, ts, http.MethodGet, "/v1/projects/p1/templates?label=team:growth", nil)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Len(t, m["templates"], 0)
+
+	code, m = do(t, ts, http.MethodGet, "/v1/projects/p1/templates/search?q=welcome", nil)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Len(t, m["templates"], 1)
+
+	code, m = do(t, ts, http.MethodGet, "/v1/projects/p1/templates/search", nil)
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Equal(t, "invalid_search_query", errorCode(m))
+
+	code, m = do(t, ts, http.MethodGet, "/v1/projects/p1/templates?label=team", nil)
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Equal(t, "invalid_request", errorCode(m))
+
+	code, m = do(t, ts, http.MethodGet, "/v1/projects/p1/templates/t1/params", nil)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, []any{"name"}, m["params"])
+
+	code, m = do(t, ts, http.MethodPost, "/v1/projects/p1/templates/lint", map[string]any{
+		"html": `<p>Hello {{.name}}</p><img src="logo.png">`,
+	})
+	assert.Equal(t, http.StatusOK, code)
+	if warnings, ok := m["warnings"].([]any); assert.True(t, ok) && assert.Len(t, warnings, 1) {
+		assert.Equal(t, "missing_alt", warnings[0].(map[string]any)["rule"])
+	}
+
+	code, m = do(t, ts, http.MethodPut, "/v1/projects/p1/templates/t1/fixtures/andy", map[string]any{
+		"params": map[string]any{"name": "Andy"},
+	})
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "andy", m["name"])
+
+	code, m = do(t, ts, http.MethodPost, "/v1/projects/p1/templates/t1/render", map[string]any{
+		"fixture": "andy",
+	})
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "Welcome Andy", m["subject"])
+	assert.Equal(t, "Hello Andy", m["text"])
+
+	code, m = do(t, ts, http.MethodPost, "/v1/projects/p1/templates/t1/render", map[string]any{
+		"fixture": "missing",
+	})
+	assert.Equal(t, http.StatusNotFound, code)
+	assert.Equal(t, "template_fixture_not_found", errorCode(m))
+
+	code, m = do(t, ts, http.MethodGet, "/v1/projects/p1/templates/missing", nil)
+	assert.Equal(t, http.StatusNotFound, code)
+	assert.Equal(t, "template_not_found", errorCode(m))
+
+	code, m = do(t, ts, http.MethodPost, "/v1/projects/p1/emails", map[string]any{
+		"template_id":     "t1",
+		"transport_id":    "tr1",
+		"to":              []string{"to@example.com"},
+		"template_params": map[string]any{"name": "Andy"},
+		"attachments": []map[string]any{
+			{"filename": "hello.txt", "content": []byte("hello")},
+		},
+	})
+	assert.Equal(t, http.StatusAccepted, code)
+	assert.Equal(t, "queued", m["state"])
+	assert.Equal(t, "Hello Andy", m["text"])
+	assert.Equal(t, "Welcome Andy", m["subject"])
+
+	id, _ := m["id"].(string)
+	code, m = do(t, ts, http.MethodGet, "/v1/projects/p1/mail-queue/"+id, nil)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, id, m["id"])
+
+	// the template is in the group so it is only deleted with force
+	code, m = do(t, ts, http.MethodDelete, "/v1/projects/p1/groups/g1", nil)
+	assert.Equal(t, http.StatusConflict, code)
+	assert.Equal(t, "group_not_empty", errorCode(m))
+
+	code, _ = do(t, ts, http.MethodDelete, "/v1/projects/p1/groups/g1?force=true", nil)
+	assert.Equal(t, http.StatusNoContent, code)
+
+	code, m = do(t, ts, http.MethodGet, "/v1/projects/p1/groups", nil)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, []any{}, m["groups"])
+}
+
+func TestAssets(t *testing.T) {
+	ts := newTestServer(t)
+
+	code, _ := do(t, ts, http.MethodPost, "/v1/projects", map[string]any{"id": "p1"})
+	assert.Equal(t, http.StatusCreated, code)
+
+	png := []byte("\x89PNG\r\n\x1a\n")
+	code, m := do(t, ts, http.MethodPut, "/v1/projects/p1/assets/logo", map[string]any{
+		"filename":     "logo.png",
+		"content_type": "image/png",
+		"content":      png,
+	})
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "logo", m["id"])
+	assert.Equal(t, float64(len(png)), m["size"])
+	assert.Equal(t, "logo@p1", m["content_id"])
+	assert.NotContains(t, m, "content")
+
+	code, m = do(t, ts, http.MethodGet, "/v1/projects/p1/assets", nil)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Len(t, m["assets"], 1)
+
+	code, m = do(t, ts, http.MethodGet, "/v1/projects/p1/assets/logo", nil)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, base64.StdEncoding.EncodeToString(png), m["content"])
+
+	code, _ = do(t, ts, http.MethodDelete, "/v1/projects/p1/assets/logo", nil)
+	assert.Equal(t, http.StatusNoContent, code)
+
+	code, m = do(t, ts, http.MethodGet, "/v1/projects/p1/assets/logo", nil)
+	assert.Equal(t, http.StatusNotFound, code)
+	assert.Equal(t, "asset_not_found", errorCode(m))
+}
+
+func TestProjectAPIKeys(t *testing.T) {
+	ts := newTestServer(t)
+
+	for _, id := range []string{"p1", "p2"} {
+		code, _ := do(t, ts, http.MethodPost, "/v1/projects", map[string]any{"id": id})
+		assert.Equal(t, http.StatusCreated, code)
+	}
+
+	code, m := do(t, ts, http.MethodPost, "/v1/api-keys", map[string]any{
+		"name":        "billing",
+		"role":        "sender",
+		"project_ids": []string{"p1"},
+	})
+	assert.Equal(t, http.StatusCreated, code)
+	key, _ := m["key"].(string)
+	keyID, _ := m["id"].(string)
+
+	code, m = do(t, ts, http.MethodGet, "/v1/api-keys", nil)
+	assert.Equal(t, http.StatusOK, code)
+	if keys, _ := m["api_keys"].([]any); assert.Len(t, keys, 1) {
+		assert.NotContains(t, keys[0], "key")
+	}
+
+	send := func(method, path string, body any) int {
+		var r bytes.Buffer
+		if body != nil {
+			json.NewEncoder(&r).Encode(body)
+		}
+		req, _ := http.NewRequest(method, ts.URL+path, &r)
+		req.Header.Set("Authorization", "Bearer "+key)
+		resp, err := ts.Client().Do(req)
+		if err != nil {
+			t.Fatalf("http request failed: %+v", err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	// reads and sends are allowed in p1 only. The send reaches its handler
+	// which finds no template.
+	assert.Equal(t, http.StatusOK, send(http.MethodGet, "/v1/projects/p1", nil))
+	assert.Equal(t, http.StatusNotFound, send(http.MethodPost, "/v1/projects/p1/emails", map[string]any{"template_id": "t1"}))
+	assert.Equal(t, http.StatusForbidden, send(http.MethodGet, "/v1/projects/p2", nil))
+	assert.Equal(t, http.StatusForbidden, send(http.MethodPost, "/v1/projects/p1/groups", map[string]any{"id": "g1"}))
+	assert.Equal(t, http.StatusForbidden, send(http.MethodGet, "/v1/projects", nil))
+	assert.Equal(t, http.StatusForbidden, send(http.MethodGet, "/v1/api-keys", nil))
+
+	code, _ = do(t, ts, http.MethodDelete, "/v1/api-keys/"+keyID, nil)
+	assert.Equal(t, http.StatusNoContent, code)
+	assert.Equal(t, http.StatusUnauthorized, send(http.MethodGet, "/v1/projects/p1", nil))
+}
+
+func TestHealth(t *testing.T) {
+	ts := newTestServer(t)
+
+	// the load balancer check needs no api key
+	resp, err := ts.Client().Get(ts.URL + "/healthz")
+	if err != nil {
+		t.Fatalf("http request failed: %+v", err)
+	}
+	var m map[string]any
+	if err := json.NewDecoder(resp.Body).Decode(&m); err != nil {
+		t.Fatalf("json decode failed: %+v", err)
+	}
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "ok", m["status"])
+	assert.Equal(t, map[string]any{"database": true, "schema": true, "encryption_key": true}, m["checks"])
+
+	resp, err = ts.Client().Get(ts.URL + "/v1/health")
+	if err != nil {
+		t.Fatalf("http request failed: %+v", err)
+	}
+	resp.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	code, m := do(t, ts, http.MethodGet, "/v1/health", nil)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "ok", m["status"])
+	assert.Equal(t, map[string]any{"ok": true}, m["encryption_key"])
+	assert.Equal(t, []any{}, m["transports"])
+}
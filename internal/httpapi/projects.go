@@ -0,0 +1,250 @@
+package httpapi
+
+import (
+	"net/http"
+
+	"github.com/andyfusniak/squishy-mailer-lite/entity"
+)
+
+type project struct {
+	ID                      string            `json:"id"`
+	Name                    string            `json:"name"`
+	Description             string            `json:"description"`
+	AllowedRecipientDomains []string          `json:"allowed_recipient_domains"`
+	DefaultTransportID      string            `json:"default_transport_id"`
+	DefaultGroupID          string            `json:"default_group_id"`
+	TransportChain          []string          `json:"transport_chain"`
+	Labels                  map[string]string `json:"labels"`
+	Variables               map[string]string `json:"variables"`
+	CreatedAt               entity.ISOTime    `json:"created_at"`
+}
+
+func projectResponse(p *entity.Project) project {
+	return project{
+		ID:                      p.ID,
+		Name:                    p.Name,
+		Description:             p.Description,
+		AllowedRecipientDomains: nonNil(p.AllowedRecipientDomains),
+		DefaultTransportID:      p.DefaultTransportID,
+		DefaultGroupID:          p.DefaultGroupID,
+		TransportChain:          nonNil(p.TransportChain),
+		Labels:                  nonNilMap(p.Labels),
+		Variables:               nonNilMap(p.Variables),
+		CreatedAt:               p.CreatedAt,
+	}
+}
+
+type createProjectRequest struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+func (h *Handler) createProject(w http.ResponseWriter, r *http.Request) {
+	var req createProjectRequest
+	if !h.decode(w, r, &req) {
+		return
+	}
+	p, err := h.svc.CreateProject(r.Context(), req.ID, req.Name, req.Description)
+	if err != nil {
+		h.writeServiceError(w, err)
+		return
+	}
+	h.writeJSON(w, http.StatusCreated, projectResponse(p))
+}
+
+func (h *Handler) getProject(w http.ResponseWriter, r *http.Request) {
+	p, err := h.svc.GetProject(r.Context(), r.PathValue("projectID"))
+	if err != nil {
+		h.writeServiceError(w, err)
+		return
+	}
+	h.writeJSON(w, http.StatusOK, projectResponse(p))
+}
+
+type updateProjectRequest struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+func (h *Handler) updateProject(w http.ResponseWriter, r *http.Request) {
+	var req updateProjectRequest
+	if !h.decode(w, r, &req) {
+		return
+	}
+	p, err := h.svc.UpdateProject(r.Context(), r.PathValue("projectID"), req.Name, req.Description)
+	if err != nil {
+		h.writeServiceError(w, err)
+		return
+	}
+	h.writeJSON(w, http.StatusOK, projectResponse(p))
+}
+
+type setTransportChainRequest struct {
+	TransportIDs []string `json:"transport_ids"`
+}
+
+func (h *Handler) setTransportChain(w http.ResponseWriter, r *http.Request) {
+	var req setTransportChainRequest
+	if !h.decode(w, r, &req) {
+		return
+	}
+	p, err := h.svc.SetTransportChain(r.Context(), r.PathValue("projectID"), req.TransportIDs)
+	if err != nil {
+		h.writeServiceError(w, err)
+		return
+	}
+	h.writeJSON(w, http.StatusOK, projectResponse(p))
+}
+
+func (h *Handler) setProjectLabels(w http.ResponseWriter, r *http.Request) {
+	var req setLabelsRequest
+	if !h.decode(w, r, &req) {
+		return
+	}
+	p, err := h.svc.SetProjectLabels(r.Context(), r.PathValue("projectID"), req.Labels)
+	if err != nil {
+		h.writeServiceError(w, err)
+		return
+	}
+	h.writeJSON(w, http.StatusOK, projectResponse(p))
+}
+
+type setProjectVariablesRequest struct {
+	Variables map[string]string `json:"variables"`
+}
+
+func (h *Handler) setProjectVariables(w http.ResponseWriter, r *http.Request) {
+	var req setProjectVariablesRequest
+	if !h.decode(w, r, &req) {
+		return
+	}
+	p, err := h.svc.SetProjectVariables(r.Context(), r.PathValue("projectID"), req.Variables)
+	if err != nil {
+		h.writeServiceError(w, err)
+		return
+	}
+	h.writeJSON(w, http.StatusOK, projectResponse(p))
+}
+
+func (h *Handler) deleteProject(w http.ResponseWriter, r *http.Request) {
+	if err := h.svc.DeleteProject(r.Context(), r.PathValue("projectID")); err != nil {
+		h.writeServiceError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type group struct {
+	ID         string         `json:"id"`
+	ProjectID  string         `json:"project_id"`
+	Name       string         `json:"name"`
+	CreatedAt  entity.ISOTime `json:"created_at"`
+	ModifiedAt entity.ISOTime `json:"modified_at"`
+}
+
+func groupResponse(g *entity.Group) group {
+	return group{
+		ID:         g.ID,
+		ProjectID:  g.ProjectID,
+		Name:       g.Name,
+		CreatedAt:  g.CreatedAt,
+		ModifiedAt: g.ModifiedAt,
+	}
+}
+
+type createGroupRequest struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+func (h *Handler) createGroup(w http.ResponseWriter, r *http.Request) {
+	var req createGroupRequest
+	if !h.decode(w, r, &req) {
+		return
+	}
+	g, err := h.svc.CreateGroup(r.Context(), req.ID, r.PathValue("projectID"), req.Name)
+	if err != nil {
+		h.writeServiceError(w, err)
+		return
+	}
+	h.writeJSON(w, http.StatusCreated, groupResponse(g))
+}
+
+func (h *Handler) listGroups(w http.ResponseWriter, r *http.Request) {
+	limit, ok := h.queryInt(w, r, "limit")
+	if !ok {
+		return
+	}
+	list, err := h.svc.ListGroups(r.Context(), entity.ListGroupsParams{
+		ProjectID: r.PathValue("projectID"),
+		After:     r.URL.Query().Get("after"),
+		Limit:     limit,
+	})
+	if err != nil {
+		h.writeServiceError(w, err)
+		return
+	}
+
+	groups := make([]group, 0, len(list))
+	for _, g := range list {
+		groups = append(groups, groupResponse(g))
+	}
+	h.writeJSON(w, http.StatusOK, map[string]any{"groups": groups})
+}
+
+func (h *Handler) getGroup(w http.ResponseWriter, r *http.Request) {
+	g, err := h.svc.GetGroup(r.Context(), r.PathValue("projectID"), r.PathValue("groupID"))
+	if err != nil {
+		h.writeServiceError(w, err)
+		return
+	}
+	h.writeJSON(w, http.StatusOK, groupResponse(g))
+}
+
+type updateGroupRequest struct {
+	Name string `json:"name"`
+}
+
+func (h *Handler) updateGroup(w http.ResponseWriter, r *http.Request) {
+	var req updateGroupRequest
+	if !h.decode(w, r, &req) {
+		return
+	}
+	g, err := h.svc.UpdateGroup(r.Context(), r.PathValue("projectID"), r.PathValue("groupID"), req.Name)
+	if err != nil {
+		h.writeServiceError(w, err)
+		return
+	}
+	h.writeJSON(w, http.StatusOK, groupResponse(g))
+}
+
+func (h *Handler) deleteGroup(w http.ResponseWriter, r *http.Request) {
+	force, ok := h.queryBool(w, r, "force")
+	if !ok {
+		return
+	}
+	if err := h.svc.DeleteGroup(r.Context(), r.PathValue("projectID"), r.PathValue("groupID"), force); err != nil {
+		h.writeServiceError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// nonNil returns an empty slice in place of nil so that lists are encoded
+// as [] rather than null.
+func nonNil(s []string) []string {
+	if s == nil {
+		return []string{}
+	}
+	return s
+}
+
+// nonNilMap returns an empty map in place of nil so that maps are encoded
+// as {} rather than null.
+func nonNilMap[V any](m map[string]V) map[string]V {
+	if m == nil {
+		return map[string]V{}
+	}
+	return m
+}
@@ -0,0 +1,97 @@
+package httpapi
+
+import (
+	"net/http"
+
+	"github.com/andyfusniak/squishy-mailer-lite/entity"
+)
+
+type quota struct {
+	ProjectID  string             `json:"project_id"`
+	PerDay     int                `json:"per_day"`
+	PerMonth   int                `json:"per_month"`
+	Action     entity.QuotaAction `json:"action"`
+	CreatedAt  entity.ISOTime     `json:"created_at"`
+	ModifiedAt entity.ISOTime     `json:"modified_at"`
+}
+
+func quotaResponse(q *entity.ProjectQuota) quota {
+	return quota{
+		ProjectID:  q.ProjectID,
+		PerDay:     q.PerDay,
+		PerMonth:   q.PerMonth,
+		Action:     q.Action,
+		CreatedAt:  q.CreatedAt,
+		ModifiedAt: q.ModifiedAt,
+	}
+}
+
+type setQuotaRequest struct {
+	PerDay   int                `json:"per_day"`
+	PerMonth int                `json:"per_month"`
+	Action   entity.QuotaAction `json:"action"`
+}
+
+func (h *Handler) setQuota(w http.ResponseWriter, r *http.Request) {
+	var req setQuotaRequest
+	if !h.decode(w, r, &req) {
+		return
+	}
+	q, err := h.svc.SetProjectQuota(r.Context(), entity.SetProjectQuotaParams{
+		ProjectID: r.PathValue("projectID"),
+		PerDay:    req.PerDay,
+		PerMonth:  req.PerMonth,
+		Action:    req.Action,
+	})
+	if err != nil {
+		h.writeServiceError(w, err)
+		return
+	}
+	h.writeJSON(w, http.StatusOK, quotaResponse(q))
+}
+
+func (h *Handler) getQuota(w http.ResponseWriter, r *http.Request) {
+	q, err := h.svc.GetProjectQuota(r.Context(), r.PathValue("projectID"))
+	if err != nil {
+		h.writeServiceError(w, err)
+		return
+	}
+	h.writeJSON(w, http.StatusOK, quotaResponse(q))
+}
+
+func (h *Handler) deleteQuota(w http.ResponseWriter, r *http.Request) {
+	if err := h.svc.DeleteProjectQuota(r.Context(), r.PathValue("projectID")); err != nil {
+		h.writeServiceError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type quotaUsageResponse struct {
+	ProjectID string `json:"project_id"`
+	Day       string `json:"day"`
+	Month     string `json:"month"`
+	DaySent   int    `json:"day_sent"`
+	MonthSent int    `json:"month_sent"`
+	PerDay    int    `json:"per_day"`
+	PerMonth  int    `json:"per_month"`
+}
+
+// getQuotaUsage reports the emails the project has sent today and this
+// month (UTC) and the limits of its quota, zero if unlimited.
+func (h *Handler) getQuotaUsage(w http.ResponseWriter, r *http.Request) {
+	u, err := h.svc.GetProjectQuotaUsage(r.Context(), r.PathValue("projectID"))
+	if err != nil {
+		h.writeServiceError(w, err)
+		return
+	}
+	h.writeJSON(w, http.StatusOK, quotaUsageResponse{
+		ProjectID: u.ProjectID,
+		Day:       u.Day,
+		Month:     u.Month,
+		DaySent:   u.DaySent,
+		MonthSent: u.MonthSent,
+		PerDay:    u.PerDay,
+		PerMonth:  u.PerMonth,
+	})
+}
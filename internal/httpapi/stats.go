@@ -0,0 +1,145 @@
+package httpapi
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/andyfusniak/squishy-mailer-lite/entity"
+)
+
+// defaultStatsPeriod is the time range reported when no from time is given.
+const defaultStatsPeriod = 30 * 24 * time.Hour
+
+type mailCounts struct {
+	Queued    int `json:"queued"`
+	Sent      int `json:"sent"`
+	Failed    int `json:"failed"`
+	Bounced   int `json:"bounced"`
+	Blocked   int `json:"blocked"`
+	Cancelled int `json:"cancelled"`
+}
+
+func mailCountsResponse(c entity.MailCounts) mailCounts {
+	return mailCounts{
+		Queued:    c.Queued,
+		Sent:      c.Sent,
+		Failed:    c.Failed,
+		Bounced:   c.Bounced,
+		Blocked:   c.Blocked,
+		Cancelled: c.Cancelled,
+	}
+}
+
+type dayMailCounts struct {
+	Day string `json:"day"`
+	mailCounts
+}
+
+type templateMailCounts struct {
+	TemplateID string `json:"template_id"`
+	mailCounts
+}
+
+type transportMailCounts struct {
+	TransportID string `json:"transport_id"`
+	mailCounts
+}
+
+type projectStats struct {
+	ProjectID  string                `json:"project_id"`
+	From       entity.ISOTime        `json:"from"`
+	To         entity.ISOTime        `json:"to"`
+	Total      mailCounts            `json:"total"`
+	Days       []dayMailCounts       `json:"days"`
+	Templates  []templateMailCounts  `json:"templates"`
+	Transports []transportMailCounts `json:"transports"`
+}
+
+func projectStatsResponse(st *entity.ProjectStats) projectStats {
+	resp := projectStats{
+		ProjectID:  st.ProjectID,
+		From:       st.From,
+		To:         st.To,
+		Total:      mailCountsResponse(st.Total),
+		Days:       make([]dayMailCounts, 0, len(st.Days)),
+		Templates:  make([]templateMailCounts, 0, len(st.Templates)),
+		Transports: make([]transportMailCounts, 0, len(st.Transports)),
+	}
+	for _, d := range st.Days {
+		resp.Days = append(resp.Days, dayMailCounts{d.Day, mailCountsResponse(d.MailCounts)})
+	}
+	for _, t := range st.Templates {
+		resp.Templates = append(resp.Templates, templateMailCounts{t.TemplateID, mailCountsResponse(t.MailCounts)})
+	}
+	for _, t := range st.Transports {
+		resp.Transports = append(resp.Transports, transportMailCounts{t.TransportID, mailCountsResponse(t.MailCounts)})
+	}
+	return resp
+}
+
+// getProjectStats reports the emails of a project queued between the from
+// and to query parameters, by default the last 30 days.
+func (h *Handler) getProjectStats(w http.ResponseWriter, r *http.Request) {
+	from, ok := h.queryTime(w, r, "from")
+	if !ok {
+		return
+	}
+	to, ok := h.queryTime(w, r, "to")
+	if !ok {
+		return
+	}
+	if from.IsZero() {
+		end := to
+		if end.IsZero() {
+			end = time.Now()
+		}
+		from = end.Add(-defaultStatsPeriod)
+	}
+
+	st, err := h.svc.GetProjectStats(r.Context(), r.PathValue("projectID"), from, to)
+	if err != nil {
+		h.writeServiceError(w, err)
+		return
+	}
+	h.writeJSON(w, http.StatusOK, projectStatsResponse(st))
+}
+
+type queueStats struct {
+	ProjectID string         `json:"project_id"`
+	States    map[string]int `json:"states"`
+	Pending   int            `json:"pending"`
+
+	// OldestPendingAt is null and OldestPendingAgeSeconds zero if no
+	// emails are waiting to be sent.
+	OldestPendingAt         *time.Time     `json:"oldest_pending_at"`
+	OldestPendingAgeSeconds float64        `json:"oldest_pending_age_seconds"`
+	Attempts                int            `json:"attempts"`
+	FailedAttempts          int            `json:"failed_attempts"`
+	FailureRate             float64        `json:"failure_rate"`
+	CheckedAt               entity.ISOTime `json:"checked_at"`
+}
+
+// getQueueStats reports the state of the mail queue of a project.
+func (h *Handler) getQueueStats(w http.ResponseWriter, r *http.Request) {
+	st, err := h.svc.GetQueueStats(r.Context(), r.PathValue("projectID"))
+	if err != nil {
+		h.writeServiceError(w, err)
+		return
+	}
+
+	resp := queueStats{
+		ProjectID:               st.ProjectID,
+		States:                  make(map[string]int, len(st.States)),
+		Pending:                 st.Pending,
+		OldestPendingAt:         optionalTime(st.OldestPendingAt),
+		OldestPendingAgeSeconds: st.OldestPendingAge.Seconds(),
+		Attempts:                st.Attempts,
+		FailedAttempts:          st.FailedAttempts,
+		FailureRate:             st.FailureRate,
+		CheckedAt:               st.CheckedAt,
+	}
+	for mstate, n := range st.States {
+		resp.States[string(mstate)] = n
+	}
+	h.writeJSON(w, http.StatusOK, resp)
+}
@@ -0,0 +1,100 @@
+package httpapi
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/andyfusniak/squishy-mailer-lite/entity"
+)
+
+type suppression struct {
+	ProjectID  string                   `json:"project_id"`
+	Email      string                   `json:"email"`
+	Reason     entity.SuppressionReason `json:"reason"`
+	Detail     string                   `json:"detail"`
+	CreatedAt  entity.ISOTime           `json:"created_at"`
+	ModifiedAt entity.ISOTime           `json:"modified_at"`
+}
+
+func suppressionResponse(sp *entity.Suppression) suppression {
+	return suppression{
+		ProjectID:  sp.ProjectID,
+		Email:      sp.Email,
+		Reason:     sp.Reason,
+		Detail:     sp.Detail,
+		CreatedAt:  sp.CreatedAt,
+		ModifiedAt: sp.ModifiedAt,
+	}
+}
+
+// sesNotification receives Amazon SES bounce and complaint notifications
+// from an Amazon SNS HTTPS subscription. SNS sends the JSON document with
+// a text/plain content type so the body is read as is.
+func (h *Handler) sesNotification(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBodySize))
+	if err != nil {
+		h.writeError(w, http.StatusBadRequest, "invalid_request",
+			fmt.Sprintf("invalid request body: %v", err))
+		return
+	}
+	if err := h.svc.HandleSESNotification(r.Context(), r.PathValue("projectID"), body); err != nil {
+		h.writeServiceError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type addSuppressionRequest struct {
+	Email  string                   `json:"email"`
+	Reason entity.SuppressionReason `json:"reason"`
+	Detail string                   `json:"detail"`
+}
+
+func (h *Handler) addSuppression(w http.ResponseWriter, r *http.Request) {
+	var req addSuppressionRequest
+	if !h.decode(w, r, &req) {
+		return
+	}
+	sp, err := h.svc.AddSuppression(r.Context(), entity.AddSuppressionParams{
+		ProjectID: r.PathValue("projectID"),
+		Email:     req.Email,
+		Reason:    req.Reason,
+		Detail:    req.Detail,
+	})
+	if err != nil {
+		h.writeServiceError(w, err)
+		return
+	}
+	h.writeJSON(w, http.StatusCreated, suppressionResponse(sp))
+}
+
+func (h *Handler) listSuppressions(w http.ResponseWriter, r *http.Request) {
+	limit, ok := h.queryInt(w, r, "limit")
+	if !ok {
+		return
+	}
+	list, err := h.svc.ListSuppressions(r.Context(), entity.ListSuppressionsParams{
+		ProjectID: r.PathValue("projectID"),
+		After:     r.URL.Query().Get("after"),
+		Limit:     limit,
+	})
+	if err != nil {
+		h.writeServiceError(w, err)
+		return
+	}
+
+	suppressions := make([]suppression, 0, len(list))
+	for _, sp := range list {
+		suppressions = append(suppressions, suppressionResponse(sp))
+	}
+	h.writeJSON(w, http.StatusOK, map[string]any{"suppressions": suppressions})
+}
+
+func (h *Handler) deleteSuppression(w http.ResponseWriter, r *http.Request) {
+	if err := h.svc.DeleteSuppression(r.Context(), r.PathValue("projectID"), r.PathValue("email")); err != nil {
+		h.writeServiceError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,360 @@
+package httpapi
+
+import (
+	"net/http"
+
+	"github.com/andyfusniak/squishy-mailer-lite/entity"
+)
+
+type template struct {
+	ID         string            `json:"id"`
+	GroupID    string            `json:"group_id"`
+	ProjectID  string            `json:"project_id"`
+	Text       string            `json:"text"`
+	TextDigest string            `json:"text_digest"`
+	HTML       string            `json:"html"`
+	HTMLDigest string            `json:"html_digest"`
+	Subject    string            `json:"subject"`
+	SourceType string            `json:"source_type"`
+	Source     string            `json:"source,omitempty"`
+	AssetMode  string            `json:"asset_mode"`
+	Labels     map[string]string `json:"labels"`
+	CreatedAt  entity.ISOTime    `json:"created_at"`
+	ModifiedAt entity.ISOTime    `json:"modified_at"`
+}
+
+func templateResponse(t *entity.Template) template {
+	return template{
+		ID:         t.ID,
+		GroupID:    t.GroupID,
+		ProjectID:  t.ProjectID,
+		Text:       t.Text,
+		TextDigest: t.TextDigest,
+		HTML:       t.HTML,
+		HTMLDigest: t.HTMLDigest,
+		Subject:    t.Subject,
+		SourceType: string(t.SourceType),
+		Source:     t.Source,
+		AssetMode:  string(t.AssetMode),
+		Labels:     nonNilMap(t.Labels),
+		CreatedAt:  t.CreatedAt,
+		ModifiedAt: t.ModifiedAt,
+	}
+}
+
+// templateRequest is the body used to create a template and to replace
+// one. The id is taken from the path when a template is replaced, and
+// validate_fixtures is only used then.
+type templateRequest struct {
+	ID               string `json:"id"`
+	GroupID          string `json:"group_id"`
+	Text             string `json:"text"`
+	HTML             string `json:"html"`
+	Subject          string `json:"subject"`
+	SourceType       string `json:"source_type"`
+	Source           string `json:"source"`
+	ValidateFixtures bool   `json:"validate_fixtures"`
+}
+
+func (h *Handler) createTemplate(w http.ResponseWriter, r *http.Request) {
+	var req templateRequest
+	if !h.decode(w, r, &req) {
+		return
+	}
+	t, err := h.svc.CreateTemplate(r.Context(), entity.CreateTemplate{
+		ID:         req.ID,
+		GroupID:    req.GroupID,
+		ProjectID:  r.PathValue("projectID"),
+		Text:       req.Text,
+		HTML:       req.HTML,
+		Subject:    req.Subject,
+		SourceType: entity.TemplateSource(req.SourceType),
+		Source:     req.Source,
+	})
+	if err != nil {
+		h.writeServiceError(w, err)
+		return
+	}
+	h.writeJSON(w, http.StatusCreated, templateResponse(t))
+}
+
+func (h *Handler) setTemplate(w http.ResponseWriter, r *http.Request) {
+	var req templateRequest
+	if !h.decode(w, r, &req) {
+		return
+	}
+	t, err := h.svc.SetTemplate(r.Context(), entity.SetTemplateParams{
+		ID:               r.PathValue("templateID"),
+		ProjectID:        r.PathValue("projectID"),
+		GroupID:          req.GroupID,
+		Text:             req.Text,
+		HTML:             req.HTML,
+		Subject:          req.Subject,
+		SourceType:       entity.TemplateSource(req.SourceType),
+		Source:           req.Source,
+		ValidateFixtures: req.ValidateFixtures,
+	})
+	if err != nil {
+		h.writeServiceError(w, err)
+		return
+	}
+	h.writeJSON(w, http.StatusOK, templateResponse(t))
+}
+
+func (h *Handler) getTemplate(w http.ResponseWriter, r *http.Request) {
+	t, err := h.svc.GetTemplate(r.Context(), r.PathValue("projectID"), r.PathValue("templateID"))
+	if err != nil {
+		h.writeServiceError(w, err)
+		return
+	}
+	h.writeJSON(w, http.StatusOK, templateResponse(t))
+}
+
+func (h *Handler) listTemplates(w http.ResponseWriter, r *http.Request) {
+	limit, ok := h.queryInt(w, r, "limit")
+	if !ok {
+		return
+	}
+	labels, ok := h.queryLabels(w, r, "label")
+	if !ok {
+		return
+	}
+	q := r.URL.Query()
+	list, err := h.svc.ListTemplates(r.Context(), entity.ListTemplatesParams{
+		ProjectID: r.PathValue("projectID"),
+		GroupID:   q.Get("group_id"),
+		Query:     q.Get("query"),
+		Labels:    labels,
+		After:     q.Get("after"),
+		Limit:     limit,
+	})
+	if err != nil {
+		h.writeServiceError(w, err)
+		return
+	}
+
+	templates := make([]template, 0, len(list))
+	for _, t := range list {
+		templates = append(templates, templateResponse(t))
+	}
+	h.writeJSON(w, http.StatusOK, map[string]any{"templates": templates})
+}
+
+func (h *Handler) searchTemplates(w http.ResponseWriter, r *http.Request) {
+	list, err := h.svc.SearchTemplates(r.Context(), r.PathValue("projectID"), r.URL.Query().Get("q"))
+	if err != nil {
+		h.writeServiceError(w, err)
+		return
+	}
+
+	templates := make([]template, 0, len(list))
+	for _, t := range list {
+		templates = append(templates, templateResponse(t))
+	}
+	h.writeJSON(w, http.StatusOK, map[string]any{"templates": templates})
+}
+
+type setLabelsRequest struct {
+	Labels map[string]string `json:"labels"`
+}
+
+func (h *Handler) setTemplateLabels(w http.ResponseWriter, r *http.Request) {
+	var req setLabelsRequest
+	if !h.decode(w, r, &req) {
+		return
+	}
+	t, err := h.svc.SetTemplateLabels(r.Context(), r.PathValue("projectID"), r.PathValue("templateID"), req.Labels)
+	if err != nil {
+		h.writeServiceError(w, err)
+		return
+	}
+	h.writeJSON(w, http.StatusOK, templateResponse(t))
+}
+
+func (h *Handler) deleteTemplate(w http.ResponseWriter, r *http.Request) {
+	if err := h.svc.DeleteTemplate(r.Context(), r.PathValue("projectID"), r.PathValue("templateID")); err != nil {
+		h.writeServiceError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type templateInspection struct {
+	TemplateID string   `json:"template_id"`
+	ProjectID  string   `json:"project_id"`
+	Params     []string `json:"params"`
+}
+
+func (h *Handler) inspectTemplate(w http.ResponseWriter, r *http.Request) {
+	ti, err := h.svc.InspectTemplate(r.Context(), r.PathValue("projectID"), r.PathValue("templateID"))
+	if err != nil {
+		h.writeServiceError(w, err)
+		return
+	}
+	h.writeJSON(w, http.StatusOK, templateInspection{
+		TemplateID: ti.TemplateID,
+		ProjectID:  ti.ProjectID,
+		Params:     nonNil(ti.Params),
+	})
+}
+
+// lintTemplateRequest is the body used to lint a template before it is
+// saved.
+type lintTemplateRequest struct {
+	HTML       string `json:"html"`
+	SourceType string `json:"source_type"`
+	Source     string `json:"source"`
+}
+
+type templateLintWarning struct {
+	Rule    string `json:"rule"`
+	Line    int    `json:"line"`
+	Element string `json:"element"`
+	Message string `json:"message"`
+}
+
+func (h *Handler) lintTemplate(w http.ResponseWriter, r *http.Request) {
+	var req lintTemplateRequest
+	if !h.decode(w, r, &req) {
+		return
+	}
+	list, err := h.svc.LintTemplate(r.Context(), entity.LintTemplateParams{
+		HTML:       req.HTML,
+		SourceType: entity.TemplateSource(req.SourceType),
+		Source:     req.Source,
+	})
+	if err != nil {
+		h.writeServiceError(w, err)
+		return
+	}
+
+	warnings := make([]templateLintWarning, 0, len(list))
+	for _, lw := range list {
+		warnings = append(warnings, templateLintWarning{
+			Rule:    string(lw.Rule),
+			Line:    lw.Line,
+			Element: lw.Element,
+			Message: lw.Message,
+		})
+	}
+	h.writeJSON(w, http.StatusOK, map[string]any{"warnings": warnings})
+}
+
+// renderTemplateRequest is the body used to render a template without
+// sending it. If fixture is set its params are used instead of
+// template_params.
+type renderTemplateRequest struct {
+	TemplateParams  map[string]any `json:"template_params"`
+	Fixture         string         `json:"fixture"`
+	Locale          string         `json:"locale"`
+	StrictParams    bool           `json:"strict_params"`
+	PlainTextParams bool           `json:"plain_text_params"`
+}
+
+type renderedTemplate struct {
+	Subject string `json:"subject"`
+	Text    string `json:"text"`
+	HTML    string `json:"html"`
+}
+
+func (h *Handler) renderTemplate(w http.ResponseWriter, r *http.Request) {
+	var req renderTemplateRequest
+	if !h.decode(w, r, &req) {
+		return
+	}
+	params := entity.RenderTemplateParams{
+		ProjectID:       r.PathValue("projectID"),
+		TemplateID:      r.PathValue("templateID"),
+		Locale:          req.Locale,
+		TemplateParams:  req.TemplateParams,
+		Fixture:         req.Fixture,
+		StrictParams:    req.StrictParams,
+		PlainTextParams: req.PlainTextParams,
+	}
+	if req.TemplateParams == nil {
+		params.TemplateParams = map[string]any{}
+	}
+	rt, err := h.svc.RenderTemplate(r.Context(), params)
+	if err != nil {
+		h.writeServiceError(w, err)
+		return
+	}
+	h.writeJSON(w, http.StatusOK, renderedTemplate{
+		Subject: rt.Subject,
+		Text:    rt.Text,
+		HTML:    rt.HTML,
+	})
+}
+
+type templateFixture struct {
+	TemplateID string         `json:"template_id"`
+	ProjectID  string         `json:"project_id"`
+	Name       string         `json:"name"`
+	Params     map[string]any `json:"params"`
+	CreatedAt  entity.ISOTime `json:"created_at"`
+	ModifiedAt entity.ISOTime `json:"modified_at"`
+}
+
+func templateFixtureResponse(f *entity.TemplateFixture) templateFixture {
+	return templateFixture{
+		TemplateID: f.TemplateID,
+		ProjectID:  f.ProjectID,
+		Name:       f.Name,
+		Params:     nonNilMap(f.Params),
+		CreatedAt:  f.CreatedAt,
+		ModifiedAt: f.ModifiedAt,
+	}
+}
+
+type setTemplateFixtureRequest struct {
+	Params map[string]any `json:"params"`
+}
+
+func (h *Handler) setTemplateFixture(w http.ResponseWriter, r *http.Request) {
+	var req setTemplateFixtureRequest
+	if !h.decode(w, r, &req) {
+		return
+	}
+	f, err := h.svc.SetTemplateFixture(r.Context(), entity.SetTemplateFixtureParams{
+		TemplateID: r.PathValue("templateID"),
+		ProjectID:  r.PathValue("projectID"),
+		Name:       r.PathValue("name"),
+		Params:     req.Params,
+	})
+	if err != nil {
+		h.writeServiceError(w, err)
+		return
+	}
+	h.writeJSON(w, http.StatusOK, templateFixtureResponse(f))
+}
+
+func (h *Handler) getTemplateFixture(w http.ResponseWriter, r *http.Request) {
+	f, err := h.svc.GetTemplateFixture(r.Context(), r.PathValue("projectID"), r.PathValue("templateID"), r.PathValue("name"))
+	if err != nil {
+		h.writeServiceError(w, err)
+		return
+	}
+	h.writeJSON(w, http.StatusOK, templateFixtureResponse(f))
+}
+
+func (h *Handler) listTemplateFixtures(w http.ResponseWriter, r *http.Request) {
+	list, err := h.svc.ListTemplateFixtures(r.Context(), r.PathValue("projectID"), r.PathValue("templateID"))
+	if err != nil {
+		h.writeServiceError(w, err)
+		return
+	}
+
+	fixtures := make([]templateFixture, 0, len(list))
+	for _, f := range list {
+		fixtures = append(fixtures, templateFixtureResponse(f))
+	}
+	h.writeJSON(w, http.StatusOK, map[string]any{"fixtures": fixtures})
+}
+
+func (h *Handler) deleteTemplateFixture(w http.ResponseWriter, r *http.Request) {
+	if err := h.svc.DeleteTemplateFixture(r.Context(), r.PathValue("projectID"), r.PathValue("templateID"), r.PathValue("name")); err != nil {
+		h.writeServiceError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
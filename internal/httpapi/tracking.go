@@ -0,0 +1,27 @@
+package httpapi
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/andyfusniak/squishy-mailer-lite/entity"
+)
+
+// pixelGIF is a transparent 1x1 GIF.
+var pixelGIF = []byte("GIF89a\x01\x00\x01\x00\x80\x00\x00\x00\x00\x00\x00\x00\x00" +
+	"!\xf9\x04\x01\x00\x00\x00\x00,\x00\x00\x00\x00\x01\x00\x01\x00\x00\x02\x02D\x01\x00;")
+
+// trackOpen counts an open of the email named by the token and serves the
+// tracking pixel. The pixel is served whatever the outcome so that mail
+// clients never show a broken image.
+func (h *Handler) trackOpen(w http.ResponseWriter, r *http.Request) {
+	if err := h.svc.RecordOpen(r.Context(), r.PathValue("token")); err != nil {
+		var serr *entity.ServiceError
+		if !errors.As(err, &serr) {
+			h.logger.Error("record open failed", "error", err)
+		}
+	}
+	w.Header().Set("Content-Type", "image/gif")
+	w.Header().Set("Cache-Control", "no-store, max-age=0")
+	w.Write(pixelGIF)
+}
@@ -0,0 +1,374 @@
+package httpapi
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/andyfusniak/squishy-mailer-lite/entity"
+)
+
+type tlsOptions struct {
+	Mode               entity.SMTPTLSMode `json:"mode"`
+	InsecureSkipVerify bool               `json:"insecure_skip_verify"`
+	CABundle           string             `json:"ca_bundle"`
+}
+
+func (o tlsOptions) entity() entity.SMTPTLSOptions {
+	return entity.SMTPTLSOptions{
+		Mode:               o.Mode,
+		InsecureSkipVerify: o.InsecureSkipVerify,
+		CABundle:           o.CABundle,
+	}
+}
+
+// smtpTimeouts are in milliseconds, with zero using the server default.
+type smtpTimeouts struct {
+	ConnectTimeoutMS int64 `json:"connect_timeout_ms"`
+	SendTimeoutMS    int64 `json:"send_timeout_ms"`
+}
+
+func (o smtpTimeouts) entity() entity.SMTPTimeouts {
+	return entity.SMTPTimeouts{
+		Connect: time.Duration(o.ConnectTimeoutMS) * time.Millisecond,
+		Send:    time.Duration(o.SendTimeoutMS) * time.Millisecond,
+	}
+}
+
+type smtpTransport struct {
+	ID              string         `json:"id"`
+	ProjectID       string         `json:"project_id"`
+	Name            string         `json:"name"`
+	Host            string         `json:"host"`
+	Port            int            `json:"port"`
+	Username        string         `json:"username"`
+	EmailFrom       string         `json:"email_from"`
+	EmailFromName   string         `json:"email_from_name"`
+	EmailReplyTo    []string       `json:"email_reply_to"`
+	TLS             tlsOptions     `json:"tls"`
+	Timeouts        smtpTimeouts   `json:"timeouts"`
+	WarmupSchedule  []int          `json:"warmup_schedule,omitempty"`
+	WarmupStartedAt entity.ISOTime `json:"warmup_started_at"`
+	CreatedAt       entity.ISOTime `json:"created_at"`
+	ModifiedAt      entity.ISOTime `json:"modified_at"`
+}
+
+func smtpTransportResponse(t *entity.SMTPTransport) smtpTransport {
+	return smtpTransport{
+		ID:            t.ID,
+		ProjectID:     t.ProjectID,
+		Name:          t.Name,
+		Host:          t.Host,
+		Port:          t.Port,
+		Username:      t.Username,
+		EmailFrom:     t.EmailFrom,
+		EmailFromName: t.EmailFromName,
+		EmailReplyTo:  nonNil(t.EmailReplyTo),
+		TLS: tlsOptions{
+			Mode:               t.TLS.Mode,
+			InsecureSkipVerify: t.TLS.InsecureSkipVerify,
+			CABundle:           t.TLS.CABundle,
+		},
+		Timeouts: smtpTimeouts{
+			ConnectTimeoutMS: t.Timeouts.Connect.Milliseconds(),
+			SendTimeoutMS:    t.Timeouts.Send.Milliseconds(),
+		},
+		WarmupSchedule:  t.WarmupSchedule,
+		WarmupStartedAt: t.WarmupStartedAt,
+		CreatedAt:       t.CreatedAt,
+		ModifiedAt:      t.ModifiedAt,
+	}
+}
+
+type createSMTPTransportRequest struct {
+	ID            string       `json:"id"`
+	Name          string       `json:"name"`
+	Host          string       `json:"host"`
+	Port          int          `json:"port"`
+	Username      string       `json:"username"`
+	Password      string       `json:"password"`
+	EmailFrom     string       `json:"email_from"`
+	EmailFromName string       `json:"email_from_name"`
+	EmailReplyTo  []string     `json:"email_reply_to"`
+	TLS           tlsOptions   `json:"tls"`
+	Timeouts      smtpTimeouts `json:"timeouts"`
+}
+
+func (h *Handler) createSMTPTransport(w http.ResponseWriter, r *http.Request) {
+	var req createSMTPTransportRequest
+	if !h.decode(w, r, &req) {
+		return
+	}
+	t, err := h.svc.CreateSMTPTransport(r.Context(), entity.CreateSMTPTransport{
+		ID:            req.ID,
+		ProjectID:     r.PathValue("projectID"),
+		Name:          req.Name,
+		Host:          req.Host,
+		Port:          req.Port,
+		Username:      req.Username,
+		Password:      req.Password,
+		EmailFrom:     req.EmailFrom,
+		EmailFromName: req.EmailFromName,
+		EmailReplyTo:  req.EmailReplyTo,
+		TLS:           req.TLS.entity(),
+		Timeouts:      req.Timeouts.entity(),
+	})
+	if err != nil {
+		h.writeServiceError(w, err)
+		return
+	}
+	h.writeJSON(w, http.StatusCreated, smtpTransportResponse(t))
+}
+
+func (h *Handler) getSMTPTransport(w http.ResponseWriter, r *http.Request) {
+	t, err := h.svc.GetSMTPTransport(r.Context(), r.PathValue("transportID"), r.PathValue("projectID"))
+	if err != nil {
+		h.writeServiceError(w, err)
+		return
+	}
+	h.writeJSON(w, http.StatusOK, smtpTransportResponse(t))
+}
+
+// updateSMTPTransportRequest replaces the settings of a transport. The
+// password is only changed if it is set.
+type updateSMTPTransportRequest struct {
+	Name          string       `json:"name"`
+	Host          string       `json:"host"`
+	Port          int          `json:"port"`
+	Username      string       `json:"username"`
+	Password      string       `json:"password"`
+	EmailFrom     string       `json:"email_from"`
+	EmailFromName string       `json:"email_from_name"`
+	EmailReplyTo  []string     `json:"email_reply_to"`
+	TLS           tlsOptions   `json:"tls"`
+	Timeouts      smtpTimeouts `json:"timeouts"`
+}
+
+func (h *Handler) updateSMTPTransport(w http.ResponseWriter, r *http.Request) {
+	var req updateSMTPTransportRequest
+	if !h.decode(w, r, &req) {
+		return
+	}
+	ctx := r.Context()
+	transportID, projectID := r.PathValue("transportID"), r.PathValue("projectID")
+	t, err := h.svc.UpdateSMTPTransport(ctx, entity.UpdateSMTPTransportParams{
+		TransportID:   transportID,
+		ProjectID:     projectID,
+		Name:          req.Name,
+		Host:          req.Host,
+		Port:          req.Port,
+		Username:      req.Username,
+		EmailFrom:     req.EmailFrom,
+		EmailFromName: req.EmailFromName,
+		EmailReplyTo:  req.EmailReplyTo,
+		TLS:           req.TLS.entity(),
+		Timeouts:      req.Timeouts.entity(),
+	})
+	if err != nil {
+		h.writeServiceError(w, err)
+		return
+	}
+	if req.Password != "" {
+		t, err = h.svc.RotateSMTPTransportPassword(ctx, transportID, projectID, req.Password)
+		if err != nil {
+			h.writeServiceError(w, err)
+			return
+		}
+	}
+	h.writeJSON(w, http.StatusOK, smtpTransportResponse(t))
+}
+
+func (h *Handler) deleteSMTPTransport(w http.ResponseWriter, r *http.Request) {
+	force, ok := h.queryBool(w, r, "force")
+	if !ok {
+		return
+	}
+	if err := h.svc.DeleteSMTPTransport(r.Context(), entity.DeleteSMTPTransportParams{
+		TransportID: r.PathValue("transportID"),
+		ProjectID:   r.PathValue("projectID"),
+		Force:       force,
+	}); err != nil {
+		h.writeServiceError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type apiTransport struct {
+	ID            string            `json:"id"`
+	ProjectID     string            `json:"project_id"`
+	Name          string            `json:"name"`
+	Provider      string            `json:"provider"`
+	Config        map[string]string `json:"config"`
+	EmailFrom     string            `json:"email_from"`
+	EmailFromName string            `json:"email_from_name"`
+	EmailReplyTo  []string          `json:"email_reply_to"`
+	CreatedAt     entity.ISOTime    `json:"created_at"`
+	ModifiedAt    entity.ISOTime    `json:"modified_at"`
+}
+
+func apiTransportResponse(t *entity.APITransport) apiTransport {
+	config := t.Config
+	if config == nil {
+		config = map[string]string{}
+	}
+	return apiTransport{
+		ID:            t.ID,
+		ProjectID:     t.ProjectID,
+		Name:          t.Name,
+		Provider:      string(t.Provider),
+		Config:        config,
+		EmailFrom:     t.EmailFrom,
+		EmailFromName: t.EmailFromName,
+		EmailReplyTo:  nonNil(t.EmailReplyTo),
+		CreatedAt:     t.CreatedAt,
+		ModifiedAt:    t.ModifiedAt,
+	}
+}
+
+// createAPITransportRequest creates a transport for one of the built-in
+// providers or for a type registered with
+// service.RegisterTransportFactory. The config keys are those of the
+// provider's create params in snake case and secret is its credential:
+// the Mailgun API key, the Postmark server token, the webhook signing
+// secret or the SES secret access key. The smtp_oauth2 provider takes the
+// refresh token as its secret and the client secret in its config.
+type createAPITransportRequest struct {
+	ID            string            `json:"id"`
+	Name          string            `json:"name"`
+	Provider      string            `json:"provider"`
+	Config        map[string]string `json:"config"`
+	Secret        string            `json:"secret"`
+	EmailFrom     string            `json:"email_from"`
+	EmailFromName string            `json:"email_from_name"`
+	EmailReplyTo  []string          `json:"email_reply_to"`
+}
+
+func (h *Handler) createAPITransport(w http.ResponseWriter, r *http.Request) {
+	var req createAPITransportRequest
+	if !h.decode(w, r, &req) {
+		return
+	}
+
+	ctx := r.Context()
+	projectID := r.PathValue("projectID")
+	c := req.Config
+	var t *entity.APITransport
+	var err error
+	switch entity.APITransportProvider(req.Provider) {
+	case entity.APITransportProviderMailgun:
+		t, err = h.svc.CreateMailgunTransport(ctx, entity.CreateMailgunTransport{
+			ID:            req.ID,
+			ProjectID:     projectID,
+			Name:          req.Name,
+			Domain:        c["domain"],
+			APIKey:        req.Secret,
+			BaseURL:       c["base_url"],
+			EmailFrom:     req.EmailFrom,
+			EmailFromName: req.EmailFromName,
+			EmailReplyTo:  req.EmailReplyTo,
+		})
+	case entity.APITransportProviderPostmark:
+		t, err = h.svc.CreatePostmarkTransport(ctx, entity.CreatePostmarkTransport{
+			ID:            req.ID,
+			ProjectID:     projectID,
+			Name:          req.Name,
+			ServerToken:   req.Secret,
+			MessageStream: c["message_stream"],
+			BaseURL:       c["base_url"],
+			EmailFrom:     req.EmailFrom,
+			EmailFromName: req.EmailFromName,
+			EmailReplyTo:  req.EmailReplyTo,
+		})
+	case entity.APITransportProviderWebhook:
+		t, err = h.svc.CreateWebhookTransport(ctx, entity.CreateWebhookTransport{
+			ID:            req.ID,
+			ProjectID:     projectID,
+			Name:          req.Name,
+			URL:           c["url"],
+			SigningSecret: req.Secret,
+			EmailFrom:     req.EmailFrom,
+			EmailFromName: req.EmailFromName,
+			EmailReplyTo:  req.EmailReplyTo,
+		})
+	case entity.APITransportProviderSES:
+		t, err = h.svc.CreateSESTransport(ctx, entity.CreateSESTransport{
+			ID:               req.ID,
+			ProjectID:        projectID,
+			Name:             req.Name,
+			Region:           c["region"],
+			Credentials:      entity.SESCredentials(c["credentials"]),
+			AccessKeyID:      c["access_key_id"],
+			SecretAccessKey:  req.Secret,
+			Profile:          c["profile"],
+			ConfigurationSet: c["configuration_set"],
+			Endpoint:         c["endpoint"],
+			EmailFrom:        req.EmailFrom,
+			EmailFromName:    req.EmailFromName,
+			EmailReplyTo:     req.EmailReplyTo,
+		})
+	case entity.APITransportProviderFile:
+		t, err = h.svc.CreateFileTransport(ctx, entity.CreateFileTransport{
+			ID:            req.ID,
+			ProjectID:     projectID,
+			Name:          req.Name,
+			Dir:           c["dir"],
+			EmailFrom:     req.EmailFrom,
+			EmailFromName: req.EmailFromName,
+			EmailReplyTo:  req.EmailReplyTo,
+		})
+	case entity.APITransportProviderSMTPOAuth2:
+		var port int
+		if c["port"] != "" {
+			if port, err = strconv.Atoi(c["port"]); err != nil {
+				h.writeError(w, http.StatusBadRequest, string(entity.ErrInvalidTransportCode), "port must be a number")
+				return
+			}
+		}
+		t, err = h.svc.CreateSMTPOAuth2Transport(ctx, entity.CreateSMTPOAuth2Transport{
+			ID:            req.ID,
+			ProjectID:     projectID,
+			Name:          req.Name,
+			Provider:      entity.OAuth2Provider(c["provider"]),
+			Tenant:        c["tenant"],
+			Host:          c["host"],
+			Port:          port,
+			Username:      c["username"],
+			TokenURL:      c["token_url"],
+			ClientID:      c["client_id"],
+			ClientSecret:  c["client_secret"],
+			RefreshToken:  req.Secret,
+			Scope:         c["scope"],
+			TLSMode:       entity.SMTPTLSMode(c["tls_mode"]),
+			EmailFrom:     req.EmailFrom,
+			EmailFromName: req.EmailFromName,
+			EmailReplyTo:  req.EmailReplyTo,
+		})
+	default:
+		t, err = h.svc.CreateTransport(ctx, entity.CreateTransport{
+			ID:            req.ID,
+			ProjectID:     projectID,
+			Name:          req.Name,
+			Type:          req.Provider,
+			Config:        c,
+			Secret:        req.Secret,
+			EmailFrom:     req.EmailFrom,
+			EmailFromName: req.EmailFromName,
+			EmailReplyTo:  req.EmailReplyTo,
+		})
+	}
+	if err != nil {
+		h.writeServiceError(w, err)
+		return
+	}
+	h.writeJSON(w, http.StatusCreated, apiTransportResponse(t))
+}
+
+func (h *Handler) getAPITransport(w http.ResponseWriter, r *http.Request) {
+	t, err := h.svc.GetAPITransport(r.Context(), r.PathValue("transportID"), r.PathValue("projectID"))
+	if err != nil {
+		h.writeServiceError(w, err)
+		return
+	}
+	h.writeJSON(w, http.StatusOK, apiTransportResponse(t))
+}
@@ -0,0 +1,64 @@
+package httpapi
+
+import (
+	"errors"
+	htmltemplate "html/template"
+	"net/http"
+
+	"github.com/andyfusniak/squishy-mailer-lite/entity"
+)
+
+// unsubscribeHTML is the page shown to recipients who follow an unsubscribe
+// link. Following a link only shows the form so that link scanners do not
+// unsubscribe recipients; mail clients supporting RFC 8058 POST directly.
+var unsubscribeHTML = htmltemplate.Must(htmltemplate.New("unsubscribe").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head><meta charset="utf-8"><title>Unsubscribe</title></head>
+<body>
+{{- if .Done}}
+<p>{{.Email}} has been unsubscribed.</p>
+{{- else if .Invalid}}
+<p>This unsubscribe link is invalid.</p>
+{{- else}}
+<form method="post">
+<p>Unsubscribe from these emails?</p>
+<button type="submit">Unsubscribe</button>
+</form>
+{{- end}}
+</body>
+</html>
+`))
+
+type unsubscribePageData struct {
+	Done    bool
+	Invalid bool
+	Email   string
+}
+
+func (h *Handler) writeUnsubscribePage(w http.ResponseWriter, status int, data unsubscribePageData) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	if err := unsubscribeHTML.Execute(w, data); err != nil {
+		h.logger.Warn("write unsubscribe page failed", "error", err)
+	}
+}
+
+func (h *Handler) unsubscribePage(w http.ResponseWriter, r *http.Request) {
+	h.writeUnsubscribePage(w, http.StatusOK, unsubscribePageData{})
+}
+
+// unsubscribe records the unsubscribe of the recipient named by the token.
+// It serves both the confirmation form and RFC 8058 one-click requests.
+func (h *Handler) unsubscribe(w http.ResponseWriter, r *http.Request) {
+	sp, err := h.svc.Unsubscribe(r.Context(), r.PathValue("token"))
+	if err != nil {
+		var serr *entity.ServiceError
+		if errors.As(err, &serr) && serr.Code == entity.ErrInvalidUnsubscribeCode {
+			h.writeUnsubscribePage(w, http.StatusBadRequest, unsubscribePageData{Invalid: true})
+			return
+		}
+		h.writeServiceError(w, err)
+		return
+	}
+	h.writeUnsubscribePage(w, http.StatusOK, unsubscribePageData{Done: true, Email: sp.Email})
+}
@@ -0,0 +1,129 @@
+package httpapi
+
+import (
+	"net/http"
+
+	"github.com/andyfusniak/squishy-mailer-lite/entity"
+)
+
+// webhook is a webhook endpoint. The signing secret is never returned.
+type webhook struct {
+	ID         string                `json:"id"`
+	ProjectID  string                `json:"project_id"`
+	URL        string                `json:"url"`
+	Events     []entity.WebhookEvent `json:"events"`
+	CreatedAt  entity.ISOTime        `json:"created_at"`
+	ModifiedAt entity.ISOTime        `json:"modified_at"`
+}
+
+func webhookResponse(wh *entity.Webhook) webhook {
+	events := wh.Events
+	if events == nil {
+		events = []entity.WebhookEvent{}
+	}
+	return webhook{
+		ID:         wh.ID,
+		ProjectID:  wh.ProjectID,
+		URL:        wh.URL,
+		Events:     events,
+		CreatedAt:  wh.CreatedAt,
+		ModifiedAt: wh.ModifiedAt,
+	}
+}
+
+type createWebhookRequest struct {
+	ID     string                `json:"id"`
+	URL    string                `json:"url"`
+	Secret string                `json:"secret"`
+	Events []entity.WebhookEvent `json:"events"`
+}
+
+func (h *Handler) createWebhook(w http.ResponseWriter, r *http.Request) {
+	var req createWebhookRequest
+	if !h.decode(w, r, &req) {
+		return
+	}
+	wh, err := h.svc.CreateWebhook(r.Context(), entity.CreateWebhookParams{
+		ID:        req.ID,
+		ProjectID: r.PathValue("projectID"),
+		URL:       req.URL,
+		Secret:    req.Secret,
+		Events:    req.Events,
+	})
+	if err != nil {
+		h.writeServiceError(w, err)
+		return
+	}
+	h.writeJSON(w, http.StatusCreated, webhookResponse(wh))
+}
+
+func (h *Handler) listWebhooks(w http.ResponseWriter, r *http.Request) {
+	list, err := h.svc.ListWebhooks(r.Context(), r.PathValue("projectID"))
+	if err != nil {
+		h.writeServiceError(w, err)
+		return
+	}
+
+	webhooks := make([]webhook, 0, len(list))
+	for _, wh := range list {
+		webhooks = append(webhooks, webhookResponse(wh))
+	}
+	h.writeJSON(w, http.StatusOK, map[string]any{"webhooks": webhooks})
+}
+
+func (h *Handler) getWebhook(w http.ResponseWriter, r *http.Request) {
+	wh, err := h.svc.GetWebhook(r.Context(), r.PathValue("projectID"), r.PathValue("webhookID"))
+	if err != nil {
+		h.writeServiceError(w, err)
+		return
+	}
+	h.writeJSON(w, http.StatusOK, webhookResponse(wh))
+}
+
+func (h *Handler) deleteWebhook(w http.ResponseWriter, r *http.Request) {
+	if err := h.svc.DeleteWebhook(r.Context(), r.PathValue("projectID"), r.PathValue("webhookID")); err != nil {
+		h.writeServiceError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type webhookDelivery struct {
+	ID            string                      `json:"id"`
+	WebhookID     string                      `json:"webhook_id"`
+	ProjectID     string                      `json:"project_id"`
+	Event         entity.WebhookEvent         `json:"event"`
+	State         entity.WebhookDeliveryState `json:"state"`
+	Attempts      int                         `json:"attempts"`
+	ResponseCode  int                         `json:"response_code,omitempty"`
+	LastError     string                      `json:"last_error,omitempty"`
+	NextAttemptAt entity.ISOTime              `json:"next_attempt_at"`
+	CreatedAt     entity.ISOTime              `json:"created_at"`
+	ModifiedAt    entity.ISOTime              `json:"modified_at"`
+}
+
+func (h *Handler) listWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	list, err := h.svc.ListWebhookDeliveries(r.Context(), r.PathValue("projectID"), r.PathValue("webhookID"))
+	if err != nil {
+		h.writeServiceError(w, err)
+		return
+	}
+
+	deliveries := make([]webhookDelivery, 0, len(list))
+	for _, d := range list {
+		deliveries = append(deliveries, webhookDelivery{
+			ID:            d.ID,
+			WebhookID:     d.WebhookID,
+			ProjectID:     d.ProjectID,
+			Event:         d.Event,
+			State:         d.State,
+			Attempts:      d.Attempts,
+			ResponseCode:  d.ResponseCode,
+			LastError:     d.LastError,
+			NextAttemptAt: d.NextAttemptAt,
+			CreatedAt:     d.CreatedAt,
+			ModifiedAt:    d.ModifiedAt,
+		})
+	}
+	h.writeJSON(w, http.StatusOK, map[string]any{"deliveries": deliveries})
+}
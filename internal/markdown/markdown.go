@@ -0,0 +1,372 @@
+// Package markdown renders the subset of Markdown used to write email
+// templates to HTML and to plain text.
+//
+// The supported blocks are paragraphs, ATX headings (# Heading), bullet and
+// numbered lists, block quotes, fenced code blocks and thematic breaks
+// (---). The supported inline elements are **strong**, *emphasis*, `code`
+// and [links](url). Backslash escapes punctuation. Underscores are never
+// treated as emphasis so that snake_case names are left alone.
+//
+// Template actions such as {{.name}} are copied through to both outputs
+// untouched so that the output can be parsed as a template.
+package markdown
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Render returns the HTML and plain text forms of src.
+func Render(src string) (html, text string) {
+	src = strings.ReplaceAll(src, "\r\n", "\n")
+	blocks := parseBlocks(strings.Split(src, "\n"))
+
+	var h, t strings.Builder
+	renderBlocks(blocks, &h, &t)
+	return h.String(), t.String()
+}
+
+type blockKind int
+
+const (
+	paragraphBlock blockKind = iota
+	headingBlock
+	bulletListBlock
+	numberedListBlock
+	quoteBlock
+	codeBlock
+	ruleBlock
+)
+
+type block struct {
+	kind  blockKind
+	level int      // heading level
+	text  string   // paragraph, heading and code text
+	items []string // list items
+	start int      // first number of a numbered list
+	quote []block  // blocks of a block quote
+}
+
+func parseBlocks(lines []string) []block {
+	var blocks []block
+	for i := 0; i < len(lines); {
+		line := lines[i]
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case trimmed == "":
+			i++
+		case strings.HasPrefix(trimmed, "```"):
+			var code []string
+			for i++; i < len(lines) && !strings.HasPrefix(strings.TrimSpace(lines[i]), "```"); i++ {
+				code = append(code, lines[i])
+			}
+			i++ // skip the closing fence
+			blocks = append(blocks, block{kind: codeBlock, text: strings.Join(code, "\n")})
+		case headingLevel(trimmed) > 0:
+			level := headingLevel(trimmed)
+			text := strings.TrimSpace(strings.TrimRight(trimmed[level:], "#"))
+			blocks = append(blocks, block{kind: headingBlock, level: level, text: text})
+			i++
+		case isRule(trimmed):
+			blocks = append(blocks, block{kind: ruleBlock})
+			i++
+		case strings.HasPrefix(trimmed, ">"):
+			var quote []string
+			for ; i < len(lines); i++ {
+				l := strings.TrimSpace(lines[i])
+				if !strings.HasPrefix(l, ">") {
+					break
+				}
+				quote = append(quote, strings.TrimPrefix(strings.TrimPrefix(l, ">"), " "))
+			}
+			blocks = append(blocks, block{kind: quoteBlock, quote: parseBlocks(quote)})
+		case listItem(line) != "":
+			b := block{kind: bulletListBlock}
+			if n, ok := listNumber(trimmed); ok {
+				b.kind = numberedListBlock
+				b.start = n
+			}
+			for i < len(lines) {
+				item := listItem(lines[i])
+				if item == "" {
+					break
+				}
+				// indented lines continue the item
+				for i++; i < len(lines) && isContinuation(lines[i]); i++ {
+					item += "\n" + strings.TrimSpace(lines[i])
+				}
+				b.items = append(b.items, item)
+			}
+			blocks = append(blocks, b)
+		default:
+			var para []string
+			for ; i < len(lines); i++ {
+				l := strings.TrimSpace(lines[i])
+				if l == "" || (len(para) > 0 && startsBlock(lines[i])) {
+					break
+				}
+				para = append(para, l)
+			}
+			blocks = append(blocks, block{kind: paragraphBlock, text: strings.Join(para, "\n")})
+		}
+	}
+	return blocks
+}
+
+// headingLevel returns the level of an ATX heading or 0 if line is not
+// one.
+func headingLevel(line string) int {
+	n := 0
+	for n < len(line) && line[n] == '#' {
+		n++
+	}
+	if n == 0 || n > 6 || (n < len(line) && line[n] != ' ') {
+		return 0
+	}
+	return n
+}
+
+func isRule(line string) bool {
+	s := strings.ReplaceAll(line, " ", "")
+	if len(s) < 3 {
+		return false
+	}
+	for _, c := range []string{"-", "*", "_"} {
+		if strings.Trim(s, c) == "" {
+			return true
+		}
+	}
+	return false
+}
+
+// listItem returns the text of a list item or "" if line is not one.
+func listItem(line string) string {
+	s := strings.TrimSpace(line)
+	for _, marker := range []string{"- ", "* ", "+ "} {
+		if strings.HasPrefix(s, marker) {
+			return strings.TrimSpace(s[len(marker):])
+		}
+	}
+	if _, ok := listNumber(s); ok {
+		return strings.TrimSpace(s[strings.Index(s, ". ")+2:])
+	}
+	return ""
+}
+
+// listNumber returns the number of a numbered list item such as "3. item".
+func listNumber(s string) (int, bool) {
+	i := strings.Index(s, ". ")
+	if i <= 0 {
+		return 0, false
+	}
+	n, err := strconv.Atoi(s[:i])
+	if err != nil || n < 0 {
+		return 0, false
+	}
+	return n, true
+}
+
+func isContinuation(line string) bool {
+	return strings.HasPrefix(line, "  ") && strings.TrimSpace(line) != "" && listItem(line) == ""
+}
+
+// startsBlock reports whether line interrupts a paragraph.
+func startsBlock(line string) bool {
+	s := strings.TrimSpace(line)
+	return strings.HasPrefix(s, "```") || headingLevel(s) > 0 || isRule(s) ||
+		strings.HasPrefix(s, ">") || listItem(s) != ""
+}
+
+func renderBlocks(blocks []block, h, t *strings.Builder) {
+	for i, b := range blocks {
+		if i > 0 {
+			h.WriteString("\n")
+			t.WriteString("\n\n")
+		}
+		switch b.kind {
+		case paragraphBlock:
+			h.WriteString("<p>")
+			renderInline(b.text, h, t)
+			h.WriteString("</p>")
+		case headingBlock:
+			tag := "h" + strconv.Itoa(b.level)
+			h.WriteString("<" + tag + ">")
+			renderInline(b.text, h, t)
+			h.WriteString("</" + tag + ">")
+		case bulletListBlock, numberedListBlock:
+			tag := "ul"
+			if b.kind == numberedListBlock {
+				tag = "ol"
+				if b.start != 1 {
+					h.WriteString(`<ol start="` + strconv.Itoa(b.start) + `">`)
+				} else {
+					h.WriteString("<ol>")
+				}
+			} else {
+				h.WriteString("<ul>")
+			}
+			h.WriteString("\n")
+			for j, item := range b.items {
+				if j > 0 {
+					t.WriteString("\n")
+				}
+				if b.kind == numberedListBlock {
+					t.WriteString(strconv.Itoa(b.start+j) + ". ")
+				} else {
+					t.WriteString("- ")
+				}
+				h.WriteString("<li>")
+				renderInline(item, h, t)
+				h.WriteString("</li>\n")
+			}
+			h.WriteString("</" + tag + ">")
+		case quoteBlock:
+			var qt strings.Builder
+			h.WriteString("<blockquote>\n")
+			renderBlocks(b.quote, h, &qt)
+			h.WriteString("\n</blockquote>")
+			lines := strings.Split(qt.String(), "\n")
+			for j, l := range lines {
+				if j > 0 {
+					t.WriteString("\n")
+				}
+				t.WriteString(strings.TrimRight("> "+l, " "))
+			}
+		case codeBlock:
+			h.WriteString("<pre><code>")
+			writeEscaped(h, b.text)
+			h.WriteString("</code></pre>")
+			for j, l := range strings.Split(b.text, "\n") {
+				if j > 0 {
+					t.WriteString("\n")
+				}
+				t.WriteString("    " + l)
+			}
+		case ruleBlock:
+			h.WriteString("<hr>")
+			t.WriteString("---")
+		}
+	}
+}
+
+// renderInline writes the HTML and text forms of the inline elements of s.
+func renderInline(s string, h, t *strings.Builder) {
+	for i := 0; i < len(s); {
+		if n := actionLen(s[i:]); n > 0 {
+			h.WriteString(s[i : i+n])
+			t.WriteString(s[i : i+n])
+			i += n
+			continue
+		}
+
+		switch c := s[i]; {
+		case c == '\\' && i+1 < len(s) && strings.IndexByte("\\`*_[]()#+-.!>{}", s[i+1]) >= 0:
+			writeEscaped(h, s[i+1:i+2])
+			t.WriteByte(s[i+1])
+			i += 2
+			continue
+		case c == '\n':
+			h.WriteString("<br>\n")
+			t.WriteByte('\n')
+			i++
+			continue
+		case c == '`':
+			if j := strings.IndexByte(s[i+1:], '`'); j >= 0 {
+				code := s[i+1 : i+1+j]
+				h.WriteString("<code>")
+				writeEscaped(h, code)
+				h.WriteString("</code>")
+				t.WriteString(code)
+				i += j + 2
+				continue
+			}
+		case strings.HasPrefix(s[i:], "**"):
+			if j := strings.Index(s[i+2:], "**"); j > 0 {
+				h.WriteString("<strong>")
+				renderInline(s[i+2:i+2+j], h, t)
+				h.WriteString("</strong>")
+				i += j + 4
+				continue
+			}
+		case c == '*':
+			if j := strings.IndexByte(s[i+1:], '*'); j > 0 {
+				h.WriteString("<em>")
+				renderInline(s[i+1:i+1+j], h, t)
+				h.WriteString("</em>")
+				i += j + 2
+				continue
+			}
+		case c == '[':
+			if label, url, n := link(s[i:]); n > 0 {
+				h.WriteString(`<a href="`)
+				writeEscaped(h, url)
+				h.WriteString(`">`)
+				var lt strings.Builder
+				renderInline(label, h, &lt)
+				h.WriteString("</a>")
+				if lt.String() == url {
+					t.WriteString(url)
+				} else {
+					t.WriteString(lt.String() + " (" + url + ")")
+				}
+				i += n
+				continue
+			}
+		}
+		writeEscaped(h, s[i:i+1])
+		t.WriteByte(s[i])
+		i++
+	}
+}
+
+// link parses a [label](url) link at the start of s and returns its label,
+// url and length in bytes. The length is 0 if s does not start with a link.
+func link(s string) (label, url string, n int) {
+	j := strings.Index(s, "](")
+	if j < 0 || strings.IndexByte(s[1:j], '\n') >= 0 {
+		return "", "", 0
+	}
+	k := strings.IndexByte(s[j+2:], ')')
+	if k < 0 {
+		return "", "", 0
+	}
+	return s[1:j], strings.TrimSpace(s[j+2 : j+2+k]), j + 3 + k
+}
+
+// actionLen returns the length of the template action at the start of s,
+// or 0 if s does not start with one.
+func actionLen(s string) int {
+	if !strings.HasPrefix(s, "{{") {
+		return 0
+	}
+	j := strings.Index(s[2:], "}}")
+	if j < 0 {
+		return 0
+	}
+	return j + 4
+}
+
+// writeEscaped writes s to h with HTML special characters escaped, except
+// within template actions.
+func writeEscaped(h *strings.Builder, s string) {
+	for i := 0; i < len(s); {
+		if n := actionLen(s[i:]); n > 0 {
+			h.WriteString(s[i : i+n])
+			i += n
+			continue
+		}
+		switch s[i] {
+		case '&':
+			h.WriteString("&amp;")
+		case '<':
+			h.WriteString("&lt;")
+		case '>':
+			h.WriteString("&gt;")
+		case '"':
+			h.WriteString("&#34;")
+		default:
+			h.WriteByte(s[i])
+		}
+		i++
+	}
+}
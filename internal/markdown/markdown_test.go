@@ -0,0 +1,67 @@
+package markdown_test
+
+import (
+	"testing"
+
+	"github.com/andyfusniak/squishy-mailer-lite/internal/markdown"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRender(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		html string
+		text string
+	}{
+		{
+			name: "paragraphs",
+			src:  "Hello {{.name}},\n\nWelcome to **Acme** & *friends*.",
+			html: "<p>Hello {{.name}},</p>\n<p>Welcome to <strong>Acme</strong> &amp; <em>friends</em>.</p>",
+			text: "Hello {{.name}},\n\nWelcome to Acme & friends.",
+		},
+		{
+			name: "heading",
+			src:  "# Your order {{.order_id}} #\nhas shipped",
+			html: "<h1>Your order {{.order_id}}</h1>\n<p>has shipped</p>",
+			text: "Your order {{.order_id}}\n\nhas shipped",
+		},
+		{
+			name: "links",
+			src:  `[Reset your password]({{.url}}) or visit [https://example.com](https://example.com)`,
+			html: `<p><a href="{{.url}}">Reset your password</a> or visit <a href="https://example.com">https://example.com</a></p>`,
+			text: "Reset your password ({{.url}}) or visit https://example.com",
+		},
+		{
+			name: "actions are not escaped",
+			src:  `{{if eq .plan "pro"}}<b>Pro</b>{{end}}`,
+			html: `<p>{{if eq .plan "pro"}}&lt;b&gt;Pro&lt;/b&gt;{{end}}</p>`,
+			text: `{{if eq .plan "pro"}}<b>Pro</b>{{end}}`,
+		},
+		{
+			name: "lists",
+			src:  "- one\n- two\n  continued\n\n3. three\n4. four",
+			html: "<ul>\n<li>one</li>\n<li>two<br>\ncontinued</li>\n</ul>\n<ol start=\"3\">\n<li>three</li>\n<li>four</li>\n</ol>",
+			text: "- one\n- two\ncontinued\n\n3. three\n4. four",
+		},
+		{
+			name: "quote code and rule",
+			src:  "> quoted `x`\n\n```\na < b\n```\n\n---",
+			html: "<blockquote>\n<p>quoted <code>x</code></p>\n</blockquote>\n<pre><code>a &lt; b</code></pre>\n<hr>",
+			text: "> quoted x\n\n    a < b\n\n---",
+		},
+		{
+			name: "escapes and underscores",
+			src:  `\*not emphasis\* snake_case_name`,
+			html: `<p>*not emphasis* snake_case_name</p>`,
+			text: `*not emphasis* snake_case_name`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			html, text := markdown.Render(tt.src)
+			assert.Equal(t, tt.html, html)
+			assert.Equal(t, tt.text, text)
+		})
+	}
+}
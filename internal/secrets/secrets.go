@@ -7,6 +7,8 @@ import (
 	"encoding/hex"
 	"fmt"
 	"io"
+
+	"golang.org/x/crypto/chacha20poly1305"
 )
 
 // Manager secret manager.
@@ -20,17 +22,36 @@ type Mode int
 
 const (
 	// AESGCMWithRandomNonce encryption and decryption scheme.
-	AESGCMWithRandomNonce = iota
+	AESGCMWithRandomNonce Mode = iota
+
+	// XChaCha20Poly1305WithRandomNonce encryption and decryption scheme,
+	// which is faster than AES-GCM on hardware without AES instructions.
+	// It requires a 32 byte key.
+	XChaCha20Poly1305WithRandomNonce
 )
 
-// New creates a new secret manger.
-func New(m Mode, key []byte) (*Manager, error) {
-	if m != AESGCMWithRandomNonce {
-		return nil, fmt.Errorf(
-			"AESGCMWithRandomNonce is currently the only supported mode of operation")
+// NonceSize returns the length in bytes of the nonces used by the mode.
+func (m Mode) NonceSize() int {
+	if m == XChaCha20Poly1305WithRandomNonce {
+		return chacha20poly1305.NonceSizeX
 	}
-	if len(key) != 16 {
-		return nil, fmt.Errorf("secret manager key must be 16 bytes in length")
+	return 12
+}
+
+// New creates a new secret manger. The key is 16 bytes for AES-128 or 32
+// bytes for AES-256 and XChaCha20-Poly1305.
+func New(m Mode, key []byte) (*Manager, error) {
+	switch m {
+	case AESGCMWithRandomNonce:
+		if !ValidKeyLength(len(key)) {
+			return nil, fmt.Errorf("secret manager key must be 16 or 32 bytes in length")
+		}
+	case XChaCha20Poly1305WithRandomNonce:
+		if len(key) != chacha20poly1305.KeySize {
+			return nil, fmt.Errorf("secret manager key must be 32 bytes in length for XChaCha20-Poly1305")
+		}
+	default:
+		return nil, fmt.Errorf("unsupported mode of operation %d", m)
 	}
 	return &Manager{
 		mode: m,
@@ -38,34 +59,50 @@ func New(m Mode, key []byte) (*Manager, error) {
 	}, nil
 }
 
+// ValidKeyLength reports whether n is the length in bytes of a supported
+// key.
+func ValidKeyLength(n int) bool {
+	return n == 16 || n == 32
+}
+
 // Encrypt accepts the plaintext password and returns a random IV with
 // the encrypted ciphertext. The IV should be stored alongside the
 func (m *Manager) Encrypt(plaintext []byte) (nonce, ciphertext []byte, err error) {
-	// TODO: find out if it is safe to move the NewCipher and NewGCM
-	// to the Manager.
-	block, err := aes.NewCipher(m.key)
+	aead, err := m.aead(m.mode)
 	if err != nil {
 		return nil, nil, err
 	}
 
-	// nonce (96 bits) (32 bits reserved for the counter)
-	nonce = make([]byte, 12)
+	// AES-GCM nonce (96 bits) (32 bits reserved for the counter), or
+	// XChaCha20-Poly1305 nonce (192 bits)
+	nonce = make([]byte, aead.NonceSize())
 	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
 		return nil, nil, err
 	}
 
-	// GCM Mode (not constant-time)
-	aesgcm, err := cipher.NewGCM(block)
-	if err != nil {
-		return nil, nil, err
-	}
-
 	// encypt
-	ciphertext = aesgcm.Seal(nil, nonce, plaintext, nil)
+	ciphertext = aead.Seal(nil, nonce, plaintext, nil)
 
 	return nonce, ciphertext, nil
 }
 
+// aead returns the cipher of mode m.
+func (m *Manager) aead(mode Mode) (cipher.AEAD, error) {
+	if mode == XChaCha20Poly1305WithRandomNonce {
+		return chacha20poly1305.NewX(m.key)
+	}
+
+	// TODO: find out if it is safe to move the NewCipher and NewGCM
+	// to the Manager.
+	block, err := aes.NewCipher(m.key)
+	if err != nil {
+		return nil, err
+	}
+
+	// GCM Mode (not constant-time)
+	return cipher.NewGCM(block)
+}
+
 // EncryptHexEncode performs a Encrypt and hex encodes the resulting nonce and ciphertext.
 func (m *Manager) EncryptHexEncode(plaintext string) (nonce, ciphertext string, err error) {
 	n, c, err := m.Encrypt([]byte(plaintext))
@@ -82,21 +119,25 @@ func (m *Manager) EncryptHexEncode(plaintext string) (nonce, ciphertext string,
 	return string(ndst), string(cdst), nil
 }
 
-// Decrypt accepts a nonce and ciphertext pair and returns the unencrypted plaintext.
+// Decrypt accepts a nonce and ciphertext pair and returns the unencrypted
+// plaintext. The mode the ciphertext was encrypted with is chosen by the
+// length of the nonce, so ciphertexts of either mode can be decrypted
+// whatever the mode of the manager.
 func (m *Manager) Decrypt(nonce, ciphertext []byte) (plaintext []byte, err error) {
-	block, err := aes.NewCipher(m.key)
-	if err != nil {
-		return nil, err
+	mode := AESGCMWithRandomNonce
+	if len(nonce) == XChaCha20Poly1305WithRandomNonce.NonceSize() {
+		mode = XChaCha20Poly1305WithRandomNonce
 	}
-
-	// GCM Mode (not constant-time)
-	aesgcm, err := cipher.NewGCM(block)
+	aead, err := m.aead(mode)
 	if err != nil {
 		return nil, err
 	}
+	if len(nonce) != aead.NonceSize() {
+		return nil, fmt.Errorf("nonce must be %d bytes in length", aead.NonceSize())
+	}
 
 	// decrypt
-	plaintext, err = aesgcm.Open(nil, nonce, ciphertext, nil)
+	plaintext, err = aead.Open(nil, nonce, ciphertext, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -76,3 +76,48 @@ func TestEncryptHexEncode(t *testing.T) {
 		t.Logf("plaintext:\t%s", plaintext)
 	}
 }
+
+func TestKeyLengths(t *testing.T) {
+	for n, valid := range map[int]bool{16: true, 24: false, 32: true, 64: false} {
+		mgr, err := secrets.New(secrets.AESGCMWithRandomNonce, make([]byte, n))
+		if !valid {
+			assert.Error(t, err, n)
+			continue
+		}
+		assert.NoError(t, err, n)
+
+		nonce, ciphertext, err := mgr.EncryptHexEncode("secret")
+		assert.NoError(t, err)
+		plaintext, err := mgr.HexDecodeDecrypt(nonce, ciphertext)
+		assert.NoError(t, err)
+		assert.Equal(t, "secret", plaintext)
+	}
+}
+
+func TestXChaCha20Poly1305(t *testing.T) {
+	key := []byte("abcdefghijklmnopqrstuvwxyz012345")
+	_, err := secrets.New(secrets.XChaCha20Poly1305WithRandomNonce, key[:16])
+	assert.Error(t, err)
+
+	xmgr, err := secrets.New(secrets.XChaCha20Poly1305WithRandomNonce, key)
+	assert.NoError(t, err)
+	aesmgr, err := secrets.New(secrets.AESGCMWithRandomNonce, key)
+	assert.NoError(t, err)
+
+	nonce, ciphertext, err := xmgr.Encrypt([]byte("secret"))
+	assert.NoError(t, err)
+	assert.Len(t, nonce, secrets.XChaCha20Poly1305WithRandomNonce.NonceSize())
+
+	// either manager decrypts the ciphertexts of both modes
+	for _, mgr := range []*secrets.Manager{xmgr, aesmgr} {
+		plaintext, err := mgr.Decrypt(nonce, ciphertext)
+		assert.NoError(t, err)
+		assert.Equal(t, []byte("secret"), plaintext)
+	}
+	nonce, ciphertext, err = aesmgr.Encrypt([]byte("secret"))
+	assert.NoError(t, err)
+	assert.Len(t, nonce, secrets.AESGCMWithRandomNonce.NonceSize())
+	plaintext, err := xmgr.Decrypt(nonce, ciphertext)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("secret"), plaintext)
+}
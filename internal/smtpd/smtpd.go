@@ -0,0 +1,685 @@
+// Package smtpd accepts email for delivery over SMTP. It is used by sqm
+// serve so that applications that can only speak SMTP can send email: the
+// messages they submit are placed on the mail queue and relayed through the
+// project's transport.
+package smtpd
+
+import (
+	"bufio"
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/andyfusniak/squishy-mailer-lite/entity"
+	"github.com/andyfusniak/squishy-mailer-lite/service"
+)
+
+const (
+	// defaultMaxMessageBytes is the largest message accepted if
+	// Server.MaxMessageBytes is not set. It is the same as the largest
+	// request body of the REST API.
+	defaultMaxMessageBytes = 32 << 20
+
+	// maxRecipients is the most recipients of one message.
+	maxRecipients = 100
+
+	// maxLineLength is the longest command line, well above the 512
+	// octets of RFC 5321 to allow for AUTH PLAIN with long api keys.
+	maxLineLength = 4096
+
+	// commandTimeout is the time a client has to send each command, or
+	// the message of DATA, as RFC 5321 suggests.
+	commandTimeout = 5 * time.Minute
+)
+
+// ErrServerClosed is returned by Serve and ListenAndServe after Shutdown.
+var ErrServerClosed = errors.New("smtpd: server closed")
+
+// Server accepts email submitted over SMTP and relays it using
+// Service.RelayEmail.
+//
+// Clients must authenticate using AUTH PLAIN or LOGIN before sending. The
+// user name is the id of the project to send from, optionally followed by
+// a slash and the id of the transport, as in "p1/tr1". If there is no
+// transport the project's default transport is used. The password is an
+// api key: one of the static apiKeys, which may send from every project,
+// or a key minted by Service.CreateAPIKey with the sender role on the
+// project. If TLSConfig is set STARTTLS is offered and must be used before
+// authenticating.
+type Server struct {
+	// TLSConfig, if set, is used for STARTTLS.
+	TLSConfig *tls.Config
+
+	// Hostname is the name the server greets clients with. It defaults
+	// to the host name of the machine.
+	Hostname string
+
+	// MaxMessageBytes is the largest message accepted. It defaults to
+	// 32 MiB.
+	MaxMessageBytes int64
+
+	svc     *service.Service
+	apiKeys [][]byte
+	logger  *slog.Logger
+
+	mu        sync.Mutex
+	listeners map[net.Listener]struct{}
+	sessions  map[*session]struct{}
+	closed    bool
+	wg        sync.WaitGroup
+}
+
+// New returns a server that relays the email submitted to it through svc.
+// Errors that are not reported to clients are logged to logger, or
+// discarded if it is nil.
+func New(svc *service.Service, apiKeys []string, logger *slog.Logger) *Server {
+	if logger == nil {
+		logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	}
+	s := &Server{
+		svc:       svc,
+		logger:    logger,
+		listeners: make(map[net.Listener]struct{}),
+		sessions:  make(map[*session]struct{}),
+	}
+	for _, k := range apiKeys {
+		if k != "" {
+			s.apiKeys = append(s.apiKeys, []byte(k))
+		}
+	}
+	return s
+}
+
+// ListenAndServe listens on the TCP address addr and serves SMTP
+// connections. It always returns a non-nil error, ErrServerClosed after
+// Shutdown.
+func (s *Server) ListenAndServe(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(ln)
+}
+
+// Serve accepts connections on ln and serves each in its own goroutine.
+// It always returns a non-nil error, ErrServerClosed after Shutdown. ln is
+// closed on return.
+func (s *Server) Serve(ln net.Listener) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		ln.Close()
+		return ErrServerClosed
+	}
+	s.listeners[ln] = struct{}{}
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		delete(s.listeners, ln)
+		s.mu.Unlock()
+		ln.Close()
+	}()
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			s.mu.Unlock()
+			if closed {
+				return ErrServerClosed
+			}
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				time.Sleep(100 * time.Millisecond)
+				continue
+			}
+			return err
+		}
+
+		sess := newSession(s, conn)
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			conn.Close()
+			return ErrServerClosed
+		}
+		s.sessions[sess] = struct{}{}
+		s.wg.Add(1)
+		s.mu.Unlock()
+
+		go func() {
+			defer s.wg.Done()
+			defer func() {
+				s.mu.Lock()
+				delete(s.sessions, sess)
+				s.mu.Unlock()
+				conn.Close()
+			}()
+			sess.serve()
+		}()
+	}
+}
+
+// Shutdown stops accepting connections and closes the connections waiting
+// for a command. Connections in the middle of a command, such as sending
+// a message, are closed once it completes. If ctx is done first the
+// remaining connections are closed and the error of ctx is returned.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	s.closed = true
+	for ln := range s.listeners {
+		ln.Close()
+	}
+	for sess := range s.sessions {
+		if !sess.active {
+			sess.conn.Close()
+		}
+	}
+	s.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		s.mu.Lock()
+		for sess := range s.sessions {
+			sess.conn.Close()
+		}
+		s.mu.Unlock()
+		<-done
+		return ctx.Err()
+	}
+}
+
+// setActive marks sess as handling a command, or waiting for one. It
+// reports false if the server is shutting down, when the session should
+// end.
+func (s *Server) setActive(sess *session, active bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sess.active = active
+	return !s.closed
+}
+
+func (s *Server) hostname() string {
+	if s.Hostname != "" {
+		return s.Hostname
+	}
+	if h, err := os.Hostname(); err == nil {
+		return h
+	}
+	return "localhost"
+}
+
+func (s *Server) maxMessageBytes() int64 {
+	if s.MaxMessageBytes > 0 {
+		return s.MaxMessageBytes
+	}
+	return defaultMaxMessageBytes
+}
+
+// session is the state of one SMTP connection.
+type session struct {
+	srv  *Server
+	conn net.Conn // the accepted connection, not the TLS connection
+
+	r *bufio.Reader
+	w *bufio.Writer
+
+	// active is guarded by srv.mu.
+	active bool
+
+	tls         bool
+	helo        bool
+	projectID   string
+	transportID string
+	authed      bool
+
+	// the mail transaction
+	mail       bool
+	recipients []string
+}
+
+func newSession(srv *Server, conn net.Conn) *session {
+	return &session{
+		srv:  srv,
+		conn: conn,
+		r:    bufio.NewReaderSize(conn, maxLineLength),
+		w:    bufio.NewWriter(conn),
+	}
+}
+
+func (c *session) serve() {
+	c.reply(220, c.srv.hostname()+" ESMTP sqm")
+	for {
+		c.conn.SetDeadline(time.Now().Add(commandTimeout))
+		line, err := c.readLine()
+		if err != nil {
+			if errors.Is(err, bufio.ErrBufferFull) {
+				c.reply(500, "5.5.2 Line too long")
+			}
+			return
+		}
+		if !c.srv.setActive(c, true) {
+			c.reply(421, "4.3.2 Service shutting down")
+			return
+		}
+		quit := c.handle(line)
+		if !c.srv.setActive(c, false) && !quit {
+			c.reply(421, "4.3.2 Service shutting down")
+			return
+		}
+		if quit {
+			return
+		}
+	}
+}
+
+// readLine reads a line of at most maxLineLength bytes, without its line
+// ending.
+func (c *session) readLine() (string, error) {
+	b, err := c.r.ReadSlice('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(b), "\r\n"), nil
+}
+
+func (c *session) reply(code int, msg string) {
+	fmt.Fprintf(c.w, "%d %s\r\n", code, msg)
+	c.w.Flush()
+}
+
+// handle handles a command line, reporting whether the connection should
+// be closed.
+func (c *session) handle(line string) bool {
+	verb, arg, _ := strings.Cut(line, " ")
+	arg = strings.TrimSpace(arg)
+	switch strings.ToUpper(verb) {
+	case "EHLO":
+		c.ehlo(arg)
+	case "HELO":
+		if arg == "" {
+			c.reply(501, "5.5.4 Syntax: HELO hostname")
+			return false
+		}
+		c.reset()
+		c.helo = true
+		c.reply(250, c.srv.hostname())
+	case "STARTTLS":
+		return c.startTLS()
+	case "AUTH":
+		c.auth(arg)
+	case "MAIL":
+		c.mailFrom(arg)
+	case "RCPT":
+		c.rcptTo(arg)
+	case "DATA":
+		return c.data()
+	case "RSET":
+		c.reset()
+		c.reply(250, "2.0.0 Ok")
+	case "NOOP":
+		c.reply(250, "2.0.0 Ok")
+	case "VRFY":
+		c.reply(252, "2.5.2 Cannot verify the user, but will accept the message")
+	case "QUIT":
+		c.reply(221, "2.0.0 Bye")
+		return true
+	default:
+		c.reply(502, "5.5.2 Command not recognized")
+	}
+	return false
+}
+
+// reset aborts the mail transaction.
+func (c *session) reset() {
+	c.mail = false
+	c.recipients = nil
+}
+
+// authAllowed reports whether AUTH may be used: always if the server has
+// no TLS configuration, otherwise after STARTTLS.
+func (c *session) authAllowed() bool {
+	return c.srv.TLSConfig == nil || c.tls
+}
+
+func (c *session) ehlo(arg string) {
+	if arg == "" {
+		c.reply(501, "5.5.4 Syntax: EHLO hostname")
+		return
+	}
+	c.reset()
+	c.helo = true
+	lines := []string{
+		c.srv.hostname(),
+		"SIZE " + strconv.FormatInt(c.srv.maxMessageBytes(), 10),
+		"8BITMIME",
+		"ENHANCEDSTATUSCODES",
+	}
+	if c.srv.TLSConfig != nil && !c.tls {
+		lines = append(lines, "STARTTLS")
+	}
+	if c.authAllowed() {
+		lines = append(lines, "AUTH PLAIN LOGIN")
+	}
+	for i, l := range lines {
+		sep := "-"
+		if i == len(lines)-1 {
+			sep = " "
+		}
+		fmt.Fprintf(c.w, "250%s%s\r\n", sep, l)
+	}
+	c.w.Flush()
+}
+
+func (c *session) startTLS() bool {
+	if c.srv.TLSConfig == nil {
+		c.reply(502, "5.5.1 STARTTLS not supported")
+		return false
+	}
+	if c.tls {
+		c.reply(503, "5.5.1 TLS already active")
+		return false
+	}
+	c.reply(220, "2.0.0 Ready to start TLS")
+	tc := tls.Server(c.conn, c.srv.TLSConfig)
+	if err := tc.Handshake(); err != nil {
+		return true
+	}
+	// the client must start again after the handshake
+	c.r = bufio.NewReaderSize(tc, maxLineLength)
+	c.w = bufio.NewWriter(tc)
+	c.tls = true
+	c.helo = false
+	c.reset()
+	return false
+}
+
+func (c *session) auth(arg string) {
+	switch {
+	case !c.helo:
+		c.reply(503, "5.5.1 Send EHLO first")
+		return
+	case c.authed:
+		c.reply(503, "5.5.1 Already authenticated")
+		return
+	case c.mail:
+		c.reply(503, "5.5.1 AUTH not allowed during a mail transaction")
+		return
+	case !c.authAllowed():
+		c.reply(538, "5.7.11 Encryption required, use STARTTLS first")
+		return
+	}
+
+	mech, initial, _ := strings.Cut(arg, " ")
+	var user, password string
+	switch strings.ToUpper(mech) {
+	case "PLAIN":
+		resp, ok := c.authResponse(initial, "")
+		if !ok {
+			return
+		}
+		// authorization identity, user and password separated by NUL
+		parts := strings.Split(resp, "\x00")
+		if len(parts) != 3 {
+			c.reply(501, "5.5.2 Invalid AUTH PLAIN response")
+			return
+		}
+		user, password = parts[1], parts[2]
+	case "LOGIN":
+		var ok bool
+		if user, ok = c.authResponse(initial, "Username:"); !ok {
+			return
+		}
+		if password, ok = c.authResponse("", "Password:"); !ok {
+			return
+		}
+	default:
+		c.reply(504, "5.5.4 Unrecognized authentication mechanism")
+		return
+	}
+
+	ok, err := c.authenticate(user, password)
+	if err != nil {
+		c.srv.logger.Error("authenticate failed", "user", user, "error", err)
+		c.reply(454, "4.7.0 Temporary authentication failure")
+		return
+	}
+	if !ok {
+		c.reply(535, "5.7.8 Authentication credentials invalid")
+		return
+	}
+	c.authed = true
+	c.reply(235, "2.7.0 Authentication successful")
+}
+
+// authResponse returns the decoded initial response of an AUTH command if
+// there is one, or else prompts the client with challenge and returns its
+// decoded response. It replies to the client and reports false if the
+// exchange fails.
+func (c *session) authResponse(initial, challenge string) (string, bool) {
+	resp := initial
+	if resp == "" {
+		c.reply(334, base64.StdEncoding.EncodeToString([]byte(challenge)))
+		line, err := c.readLine()
+		if err != nil {
+			c.reply(501, "5.5.2 Invalid response")
+			return "", false
+		}
+		resp = line
+	}
+	if resp == "*" {
+		c.reply(501, "5.0.0 Authentication cancelled")
+		return "", false
+	}
+	if resp == "=" {
+		return "", true
+	}
+	b, err := base64.StdEncoding.DecodeString(resp)
+	if err != nil {
+		c.reply(501, "5.5.2 Invalid base64 response")
+		return "", false
+	}
+	return string(b), true
+}
+
+// authenticate checks that password is an api key that may send email from
+// the project named by user, setting the session's project and transport
+// if it is.
+func (c *session) authenticate(user, password string) (bool, error) {
+	projectID, transportID, _ := strings.Cut(user, "/")
+	if projectID == "" || password == "" {
+		return false, nil
+	}
+	if !isStaticKey(c.srv.apiKeys, password) {
+		key, err := c.srv.svc.AuthenticateAPIKey(context.Background(), password)
+		if err != nil {
+			var serr *entity.ServiceError
+			if errors.As(err, &serr) && serr.Code == entity.ErrAPIKeyNotFoundCode {
+				return false, nil
+			}
+			return false, err
+		}
+		if !key.Allows(projectID, entity.APIKeyRoleSender) {
+			return false, nil
+		}
+	}
+	c.projectID, c.transportID = projectID, transportID
+	return true, nil
+}
+
+func isStaticKey(keys [][]byte, token string) bool {
+	// compare against every key so the time taken does not reveal which
+	// key matched
+	var match int
+	for _, k := range keys {
+		match |= subtle.ConstantTimeCompare([]byte(token), k)
+	}
+	return match == 1
+}
+
+func (c *session) mailFrom(arg string) {
+	switch {
+	case !c.authed:
+		c.reply(530, "5.7.0 Authentication required")
+		return
+	case c.mail:
+		c.reply(503, "5.5.1 Sender already specified")
+		return
+	}
+	// the envelope sender is set by the transport, the From header of the
+	// message is the sender
+	_, params, ok := parsePath(arg, "FROM:")
+	if !ok {
+		c.reply(501, "5.5.4 Syntax: MAIL FROM:<address>")
+		return
+	}
+	for _, p := range params {
+		k, v, _ := strings.Cut(p, "=")
+		if strings.EqualFold(k, "SIZE") {
+			n, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				c.reply(501, "5.5.4 Invalid SIZE parameter")
+				return
+			}
+			if n > c.srv.maxMessageBytes() {
+				c.reply(552, "5.3.4 Message too big")
+				return
+			}
+		}
+	}
+	c.mail = true
+	c.reply(250, "2.1.0 Ok")
+}
+
+func (c *session) rcptTo(arg string) {
+	if !c.mail {
+		c.reply(503, "5.5.1 Send MAIL first")
+		return
+	}
+	path, _, ok := parsePath(arg, "TO:")
+	if !ok || path == "" {
+		c.reply(501, "5.5.4 Syntax: RCPT TO:<address>")
+		return
+	}
+	if _, err := mail.ParseAddress(path); err != nil {
+		c.reply(553, "5.1.3 Invalid recipient address")
+		return
+	}
+	if len(c.recipients) >= maxRecipients {
+		c.reply(452, "4.5.3 Too many recipients")
+		return
+	}
+	c.recipients = append(c.recipients, path)
+	c.reply(250, "2.1.5 Ok")
+}
+
+// parsePath parses the <path> and parameters of a MAIL or RCPT command
+// whose argument starts with prefix.
+func parsePath(arg, prefix string) (string, []string, bool) {
+	if len(arg) < len(prefix) || !strings.EqualFold(arg[:len(prefix)], prefix) {
+		return "", nil, false
+	}
+	arg = strings.TrimSpace(arg[len(prefix):])
+	if !strings.HasPrefix(arg, "<") {
+		return "", nil, false
+	}
+	end := strings.IndexByte(arg, '>')
+	if end < 0 {
+		return "", nil, false
+	}
+	return arg[1:end], strings.Fields(arg[end+1:]), true
+}
+
+func (c *session) data() bool {
+	if !c.mail || len(c.recipients) == 0 {
+		c.reply(503, "5.5.1 Send RCPT first")
+		return false
+	}
+	c.reply(354, "End data with <CR><LF>.<CR><LF>")
+
+	limit := c.srv.maxMessageBytes()
+	dr := textproto.NewReader(c.r).DotReader()
+	msg, err := io.ReadAll(io.LimitReader(dr, limit+1))
+	if err == nil && int64(len(msg)) > limit {
+		// read the rest of the message so the connection can be used
+		// for the next one
+		_, err = io.Copy(io.Discard, dr)
+		if err == nil {
+			c.reset()
+			c.reply(552, "5.3.4 Message too big")
+			return false
+		}
+	}
+	if err != nil {
+		return true
+	}
+
+	mq, err := c.srv.svc.RelayEmail(context.Background(), entity.RelayEmailParams{
+		ProjectID:   c.projectID,
+		TransportID: c.transportID,
+		Recipients:  c.recipients,
+		Message:     msg,
+	})
+	c.reset()
+	if err != nil {
+		code, msg := c.srv.replyFromError(err)
+		c.reply(code, msg)
+		return code == 421
+	}
+	c.reply(250, "2.0.0 Ok: queued as "+mq.ID)
+	return false
+}
+
+// replyFromError maps an error of Service.RelayEmail to an SMTP reply.
+// Errors other than service errors are logged.
+func (s *Server) replyFromError(err error) (int, string) {
+	var serr *entity.ServiceError
+	if !errors.As(err, &serr) {
+		s.logger.Error("relay email failed", "error", err)
+		return 451, "4.3.0 Temporary server error, try again later"
+	}
+	msg := oneLine(serr.Error())
+	c := string(serr.Code)
+	switch {
+	case c == entity.ErrServiceClosedCode:
+		return 421, "4.3.2 Service shutting down"
+	case c == entity.ErrQuotaExceededCode:
+		return 452, "4.3.1 " + msg
+	case c == entity.ErrFromNotAllowedCode:
+		return 550, "5.7.1 " + msg
+	case c == entity.ErrInvalidAddressCode:
+		return 550, "5.1.3 " + msg
+	case c == entity.ErrProjectScopeViolationCode, c == entity.ErrNoDefaultTransportCode,
+		strings.HasSuffix(c, "_not_found"):
+		return 550, "5.7.1 " + msg
+	}
+	return 554, "5.6.0 " + msg
+}
+
+// oneLine returns the first line of s, as a reply may not span lines.
+func oneLine(s string) string {
+	s, _, _ = strings.Cut(s, "\n")
+	return strings.TrimSpace(strings.ReplaceAll(s, "\r", ""))
+}
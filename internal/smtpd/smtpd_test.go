@@ -0,0 +1,168 @@
+package smtpd_test
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"net/smtp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/andyfusniak/squishy-mailer-lite/entity"
+	"github.com/andyfusniak/squishy-mailer-lite/internal/smtpd"
+	"github.com/andyfusniak/squishy-mailer-lite/service"
+	"github.com/stretchr/testify/assert"
+)
+
+const testAPIKey = "test-api-key"
+
+// syncBuffer is a bytes.Buffer safe for concurrent use.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// newTestServer starts an SMTP server relaying through project p1, whose
+// file transport tr1 writes emails to the returned buffer.
+func newTestServer(t *testing.T) (*service.Service, string, *syncBuffer) {
+	t.Helper()
+
+	out := &syncBuffer{}
+	svc, err := service.NewEmailService(
+		service.WithInMemoryStore(),
+		service.WithHexEncodedEncryptionKey("a0bf305856098eba7e4bff506021648b"),
+		service.WithFileTransportWriter(out),
+	)
+	if err != nil {
+		t.Fatalf("service.NewEmailService failed: %+v", err)
+	}
+	t.Cleanup(func() { svc.Close() })
+
+	ctx := context.Background()
+	if _, err := svc.CreateProject(ctx, "p1", "Project One", ""); err != nil {
+		t.Fatalf("svc.CreateProject failed: %+v", err)
+	}
+	if _, err := svc.CreateFileTransport(ctx, entity.CreateFileTransport{
+		ID:        "tr1",
+		ProjectID: "p1",
+		Name:      "File",
+		EmailFrom: "from@example.com",
+	}); err != nil {
+		t.Fatalf("svc.CreateFileTransport failed: %+v", err)
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen failed: %+v", err)
+	}
+	srv := smtpd.New(svc, []string{testAPIKey}, nil)
+	srv.Hostname = "sqm.test"
+	srv.MaxMessageBytes = 1024
+	done := make(chan error, 1)
+	go func() { done <- srv.Serve(ln) }()
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		if err := srv.Shutdown(ctx); err != nil {
+			t.Errorf("srv.Shutdown failed: %+v", err)
+		}
+		assert.ErrorIs(t, <-done, smtpd.ErrServerClosed)
+	})
+	return svc, ln.Addr().String(), out
+}
+
+const testMessage = "From: from@example.com\r\n" +
+	"To: to@example.com\r\n" +
+	"Subject: Hello over SMTP\r\n" +
+	"\r\n" +
+	"Hello from a legacy application.\r\n"
+
+func TestSendMail(t *testing.T) {
+	svc, addr, out := newTestServer(t)
+	ctx := context.Background()
+
+	auth := smtp.PlainAuth("", "p1/tr1", testAPIKey, "127.0.0.1")
+	if err := smtp.SendMail(addr, auth, "app@example.com",
+		[]string{"to@example.com", "bcc@example.com"}, []byte(testMessage)); err != nil {
+		t.Fatalf("smtp.SendMail failed: %+v", err)
+	}
+	if _, err := svc.ProcessMailQueue(ctx); err != nil {
+		t.Fatalf("svc.ProcessMailQueue failed: %+v", err)
+	}
+	assert.Contains(t, out.String(), "Subject: Hello over SMTP")
+	assert.Contains(t, out.String(), "Hello from a legacy application.")
+
+	// a minted key must grant the sender role on the project
+	key, err := svc.CreateAPIKey(ctx, entity.CreateAPIKeyParams{
+		Name:       "reader",
+		Role:       entity.APIKeyRoleReadOnly,
+		ProjectIDs: []string{"p1"},
+	})
+	if err != nil {
+		t.Fatalf("svc.CreateAPIKey failed: %+v", err)
+	}
+	auth = smtp.PlainAuth("", "p1/tr1", key.Key, "127.0.0.1")
+	err = smtp.SendMail(addr, auth, "app@example.com", []string{"to@example.com"}, []byte(testMessage))
+	assert.ErrorContains(t, err, "535")
+
+	key, err = svc.CreateAPIKey(ctx, entity.CreateAPIKeyParams{
+		Name:       "sender",
+		Role:       entity.APIKeyRoleSender,
+		ProjectIDs: []string{"p1"},
+	})
+	if err != nil {
+		t.Fatalf("svc.CreateAPIKey failed: %+v", err)
+	}
+	auth = smtp.PlainAuth("", "p1/tr1", key.Key, "127.0.0.1")
+	assert.NoError(t, smtp.SendMail(addr, auth, "app@example.com",
+		[]string{"to@example.com"}, []byte(testMessage)))
+
+	// the From header must be an allowed sender of the transport
+	auth = smtp.PlainAuth("", "p1/tr1", testAPIKey, "127.0.0.1")
+	err = smtp.SendMail(addr, auth, "app@example.com", []string{"to@example.com"},
+		[]byte(strings.Replace(testMessage, "from@example.com", "other@example.org", 1)))
+	assert.ErrorContains(t, err, "5.7.1 from_not_allowed")
+
+	err = smtp.SendMail(addr, auth, "app@example.com", []string{"to@example.com"},
+		[]byte(testMessage+strings.Repeat("x", 1024)+"\r\n"))
+	assert.ErrorContains(t, err, "5.3.4 Message too big")
+}
+
+func TestAuthenticationRequired(t *testing.T) {
+	_, addr, _ := newTestServer(t)
+
+	dial := func() *smtp.Client {
+		t.Helper()
+		c, err := smtp.Dial(addr)
+		if err != nil {
+			t.Fatalf("smtp.Dial failed: %+v", err)
+		}
+		t.Cleanup(func() { c.Close() })
+		return c
+	}
+
+	c := dial()
+	assert.ErrorContains(t, c.Mail("app@example.com"), "530")
+	err := c.Auth(smtp.PlainAuth("", "p1", "wrong-key", "127.0.0.1"))
+	assert.ErrorContains(t, err, "535")
+
+	c = dial()
+	assert.NoError(t, c.Auth(smtp.PlainAuth("", "p1", testAPIKey, "127.0.0.1")))
+	assert.NoError(t, c.Mail("app@example.com"))
+	assert.ErrorContains(t, c.Rcpt("not an address"), "553")
+	assert.NoError(t, c.Quit())
+}
@@ -0,0 +1,88 @@
+package memory
+
+import (
+	"context"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/andyfusniak/squishy-mailer-lite/internal/store"
+	"github.com/pkg/errors"
+)
+
+// InsertAPIKey inserts a new API key.
+func (s *Store) InsertAPIKey(ctx context.Context, params store.AddAPIKey) (*store.APIKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.apiKeys[params.APIKeyID]; ok {
+		return nil, errors.Errorf("[memory:api_keys] api key %q already exists", params.APIKeyID)
+	}
+	ts := now()
+	r := &store.APIKey{
+		APIKeyID:   params.APIKeyID,
+		Name:       params.Name,
+		KeyHash:    params.KeyHash,
+		Role:       params.Role,
+		ProjectIDs: slices.Clone(params.ProjectIDs),
+		CreatedAt:  ts,
+		ModifiedAt: ts,
+	}
+	if r.ProjectIDs == nil {
+		r.ProjectIDs = store.JSONArray{}
+	}
+	s.apiKeys[r.APIKeyID] = r
+	return cloneAPIKey(r), nil
+}
+
+// GetAPIKeyByHash gets an API key by the hash of its secret. If there is
+// no such key an error of type store.ErrAPIKeyNotFound is returned.
+func (s *Store) GetAPIKeyByHash(ctx context.Context, keyHash string) (*store.APIKey, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, r := range s.apiKeys {
+		if r.KeyHash == keyHash {
+			return cloneAPIKey(r), nil
+		}
+	}
+	return nil, store.NewStoreError(store.ErrAPIKeyNotFound, nil)
+}
+
+// ListAPIKeys lists the API keys ordered by creation time.
+func (s *Store) ListAPIKeys(ctx context.Context) ([]*store.APIKey, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	list := make([]*store.APIKey, 0, len(s.apiKeys))
+	for _, r := range s.apiKeys {
+		list = append(list, cloneAPIKey(r))
+	}
+	sort.Slice(list, func(i, j int) bool {
+		a, b := time.Time(list[i].CreatedAt), time.Time(list[j].CreatedAt)
+		if !a.Equal(b) {
+			return a.Before(b)
+		}
+		return list[i].APIKeyID < list[j].APIKeyID
+	})
+	return list, nil
+}
+
+// DeleteAPIKey deletes an API key. If there is no such key an error of
+// type store.ErrAPIKeyNotFound is returned.
+func (s *Store) DeleteAPIKey(ctx context.Context, apiKeyID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.apiKeys[apiKeyID]; !ok {
+		return store.NewStoreError(store.ErrAPIKeyNotFound, nil)
+	}
+	delete(s.apiKeys, apiKeyID)
+	return nil
+}
+
+func cloneAPIKey(r *store.APIKey) *store.APIKey {
+	c := *r
+	c.ProjectIDs = slices.Clone(r.ProjectIDs)
+	return &c
+}
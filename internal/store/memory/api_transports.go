@@ -0,0 +1,74 @@
+package memory
+
+import (
+	"context"
+	"maps"
+	"slices"
+
+	"github.com/andyfusniak/squishy-mailer-lite/internal/store"
+	"github.com/pkg/errors"
+)
+
+// InsertAPITransport inserts a new API transport into the store. If the
+// project does not exist an error of type store.ErrProjectNotFound is
+// returned.
+func (s *Store) InsertAPITransport(ctx context.Context, params store.AddAPITransport) (*store.APITransport, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.projects[params.ProjectID]; !ok {
+		return nil, store.NewStoreError(store.ErrProjectNotFound, nil)
+	}
+	k := key{params.ProjectID, params.APITransportID}
+	if _, ok := s.apiTransports[k]; ok {
+		return nil, errors.Errorf("[memory:api_transports] transport %q already exists", params.APITransportID)
+	}
+	config := params.Config
+	if config == nil {
+		config = store.JSONObject{}
+	}
+	replyTo := params.EmailReplyTo
+	if replyTo == nil {
+		replyTo = store.JSONArray{}
+	}
+	ts := now()
+	r := &store.APITransport{
+		APITransportID:  params.APITransportID,
+		ProjectID:       params.ProjectID,
+		TransportName:   params.TransportName,
+		Provider:        params.Provider,
+		Config:          maps.Clone(config),
+		EncryptedAPIKey: params.EncryptedAPIKey,
+		EmailFrom:       params.EmailFrom,
+		EmailFromName:   params.EmailFromName,
+		EmailReplyTo:    slices.Clone(replyTo),
+		CreatedAt:       ts,
+		ModifiedAt:      ts,
+	}
+	s.apiTransports[k] = r
+	return cloneAPITransport(r), nil
+}
+
+// GetAPITransport gets an API transport from the store. If the project
+// does not exist an error of type store.ErrProjectNotFound is returned. If
+// the transport does not exist store.ErrTransportNotFound is returned.
+func (s *Store) GetAPITransport(ctx context.Context, transportID, projectID string) (*store.APITransport, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if _, ok := s.projects[projectID]; !ok {
+		return nil, store.NewStoreError(store.ErrProjectNotFound, nil)
+	}
+	r, ok := s.apiTransports[key{projectID, transportID}]
+	if !ok {
+		return nil, store.ErrTransportNotFound
+	}
+	return cloneAPITransport(r), nil
+}
+
+func cloneAPITransport(r *store.APITransport) *store.APITransport {
+	c := *r
+	c.Config = maps.Clone(r.Config)
+	c.EmailReplyTo = slices.Clone(r.EmailReplyTo)
+	return &c
+}
@@ -0,0 +1,111 @@
+package memory
+
+import (
+	"context"
+
+	"github.com/andyfusniak/squishy-mailer-lite/internal/store"
+)
+
+// InsertCampaign inserts a new running campaign. If the project does not
+// exist an error of type store.ErrProjectNotFound is returned, and if the
+// campaign id is already used in the project an error of type
+// store.ErrCampaignAlreadyExists is returned.
+func (s *Store) InsertCampaign(ctx context.Context, params store.AddCampaign) (*store.Campaign, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.projects[params.ProjectID]; !ok {
+		return nil, store.NewStoreError(store.ErrProjectNotFound, nil)
+	}
+	k := key{params.ProjectID, params.CampaignID}
+	if _, ok := s.campaigns[k]; ok {
+		return nil, store.NewStoreError(store.ErrCampaignAlreadyExists, nil)
+	}
+	ts := now()
+	r := &store.Campaign{
+		CampaignID:   params.CampaignID,
+		ProjectID:    params.ProjectID,
+		CampaignName: params.CampaignName,
+		TemplateID:   params.TemplateID,
+		TransportID:  params.TransportID,
+		ListID:       params.ListID,
+		CState:       store.CampaignStateRunning,
+		Recipients:   params.Recipients,
+		CreatedAt:    ts,
+		ModifiedAt:   ts,
+	}
+	s.campaigns[k] = r
+	c := *r
+	return &c, nil
+}
+
+// GetCampaign gets a campaign. If the campaign does not exist an error of
+// type store.ErrCampaignNotFound is returned.
+func (s *Store) GetCampaign(ctx context.Context, projectID, campaignID string) (*store.Campaign, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	r, ok := s.campaigns[key{projectID, campaignID}]
+	if !ok {
+		return nil, store.NewStoreError(store.ErrCampaignNotFound, nil)
+	}
+	c := *r
+	return &c, nil
+}
+
+// ListCampaigns lists the campaigns of a project ordered by id.
+func (s *Store) ListCampaigns(ctx context.Context, projectID string) ([]*store.Campaign, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return sortedValues(s.campaigns, projectID), nil
+}
+
+// SetCampaignState sets the state of a campaign and moves its waiting
+// emails. Pausing moves queued and rate limited emails to the paused
+// state; running the campaign again returns paused emails to the queued
+// state. If the campaign does not exist an error of type
+// store.ErrCampaignNotFound is returned.
+func (s *Store) SetCampaignState(ctx context.Context, projectID, campaignID, cstate string) (*store.Campaign, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r, ok := s.campaigns[key{projectID, campaignID}]
+	if !ok {
+		return nil, store.NewStoreError(store.ErrCampaignNotFound, nil)
+	}
+	ts := now()
+	r.CState = cstate
+	r.ModifiedAt = ts
+	for _, row := range s.mailQueue {
+		if row.ProjectID != projectID || row.CampaignID != campaignID {
+			continue
+		}
+		switch {
+		case cstate == store.CampaignStatePaused &&
+			(row.MState == store.MailQueueStateQueued || row.MState == store.MailQueueStateRateLimited):
+			row.MState = store.MailQueueStatePaused
+		case cstate != store.CampaignStatePaused && row.MState == store.MailQueueStatePaused:
+			row.MState = store.MailQueueStateQueued
+		default:
+			continue
+		}
+		row.ModifiedAt = ts
+	}
+	c := *r
+	return &c, nil
+}
+
+// CountCampaignMailQueue counts the emails of a campaign by state.
+func (s *Store) CountCampaignMailQueue(ctx context.Context, projectID, campaignID string) (map[string]int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	counts := make(map[string]int)
+	for _, row := range s.mailQueue {
+		if row.ProjectID == projectID && row.CampaignID == campaignID {
+			counts[row.MState]++
+		}
+	}
+	return counts, nil
+}
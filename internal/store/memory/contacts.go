@@ -0,0 +1,175 @@
+package memory
+
+import (
+	"context"
+	"maps"
+	"sort"
+
+	"github.com/andyfusniak/squishy-mailer-lite/internal/store"
+)
+
+func cloneContact(r *store.Contact) *store.Contact {
+	c := *r
+	c.Attributes = maps.Clone(r.Attributes)
+	if c.Attributes == nil {
+		c.Attributes = store.JSONObject{}
+	}
+	return &c
+}
+
+// contactList returns a copy of the contact list with its number of
+// contacts. The caller must hold the lock.
+func (s *Store) contactList(k key) (*store.ContactList, bool) {
+	r, ok := s.contactLists[k]
+	if !ok {
+		return nil, false
+	}
+	c := *r
+	c.Contacts = len(s.contacts[k])
+	return &c, true
+}
+
+// InsertContactList inserts a new, empty contact list. If the project does
+// not exist an error of type store.ErrProjectNotFound is returned, and if
+// the list id is already used in the project an error of type
+// store.ErrContactListExists is returned.
+func (s *Store) InsertContactList(ctx context.Context, params store.AddContactList) (*store.ContactList, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.projects[params.ProjectID]; !ok {
+		return nil, store.NewStoreError(store.ErrProjectNotFound, nil)
+	}
+	k := key{params.ProjectID, params.ListID}
+	if _, ok := s.contactLists[k]; ok {
+		return nil, store.NewStoreError(store.ErrContactListExists, nil)
+	}
+	ts := now()
+	s.contactLists[k] = &store.ContactList{
+		ListID:     params.ListID,
+		ProjectID:  params.ProjectID,
+		ListName:   params.ListName,
+		CreatedAt:  ts,
+		ModifiedAt: ts,
+	}
+	r, _ := s.contactList(k)
+	return r, nil
+}
+
+// GetContactList gets a contact list along with its number of contacts. If
+// the list does not exist an error of type store.ErrContactListNotFound is
+// returned.
+func (s *Store) GetContactList(ctx context.Context, projectID, listID string) (*store.ContactList, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	r, ok := s.contactList(key{projectID, listID})
+	if !ok {
+		return nil, store.NewStoreError(store.ErrContactListNotFound, nil)
+	}
+	return r, nil
+}
+
+// ListContactLists lists the contact lists of a project ordered by id.
+func (s *Store) ListContactLists(ctx context.Context, projectID string) ([]*store.ContactList, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	list := sortedValues(s.contactLists, projectID)
+	for _, r := range list {
+		r.Contacts = len(s.contacts[key{projectID, r.ListID}])
+	}
+	return list, nil
+}
+
+// DeleteContactList deletes a contact list and its contacts. If the list
+// does not exist an error of type store.ErrContactListNotFound is
+// returned.
+func (s *Store) DeleteContactList(ctx context.Context, projectID, listID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	k := key{projectID, listID}
+	if _, ok := s.contactLists[k]; !ok {
+		return store.NewStoreError(store.ErrContactListNotFound, nil)
+	}
+	delete(s.contactLists, k)
+	delete(s.contacts, k)
+	return nil
+}
+
+// SetContacts adds contacts to a list, replacing the attributes, locale
+// and timezone of any address already on the list. If the list does not
+// exist an error of type store.ErrContactListNotFound is returned.
+func (s *Store) SetContacts(ctx context.Context, projectID, listID string, contacts []store.SetContact) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	k := key{projectID, listID}
+	l, ok := s.contactLists[k]
+	if !ok {
+		return store.NewStoreError(store.ErrContactListNotFound, nil)
+	}
+	ts := now()
+	l.ModifiedAt = ts
+	m := s.contacts[k]
+	if m == nil {
+		m = make(map[string]*store.Contact)
+		s.contacts[k] = m
+	}
+	for _, c := range contacts {
+		createdAt := ts
+		if prev, ok := m[c.Email]; ok {
+			createdAt = prev.CreatedAt
+		}
+		m[c.Email] = cloneContact(&store.Contact{
+			ProjectID:  projectID,
+			ListID:     listID,
+			Email:      c.Email,
+			Attributes: c.Attributes,
+			Locale:     c.Locale,
+			Timezone:   c.Timezone,
+			CreatedAt:  createdAt,
+			ModifiedAt: ts,
+		})
+	}
+	return nil
+}
+
+// ListContacts lists the contacts of a list ordered by address.
+func (s *Store) ListContacts(ctx context.Context, params store.ListContacts) ([]*store.Contact, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	m := s.contacts[key{params.ProjectID, params.ListID}]
+	emails := make([]string, 0, len(m))
+	for email := range m {
+		if email > params.After {
+			emails = append(emails, email)
+		}
+	}
+	sort.Strings(emails)
+	if params.Limit > 0 && len(emails) > params.Limit {
+		emails = emails[:params.Limit]
+	}
+
+	list := make([]*store.Contact, 0, len(emails))
+	for _, email := range emails {
+		list = append(list, cloneContact(m[email]))
+	}
+	return list, nil
+}
+
+// DeleteContact removes an address from a contact list. If the address is
+// not on the list an error of type store.ErrContactNotFound is returned.
+func (s *Store) DeleteContact(ctx context.Context, projectID, listID, email string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	m := s.contacts[key{projectID, listID}]
+	if _, ok := m[email]; !ok {
+		return store.NewStoreError(store.ErrContactNotFound, nil)
+	}
+	delete(m, email)
+	return nil
+}
@@ -0,0 +1,216 @@
+package memory
+
+import (
+	"context"
+	"slices"
+
+	"github.com/andyfusniak/squishy-mailer-lite/internal/store"
+	"github.com/pkg/errors"
+)
+
+//
+// attachments
+//
+
+// SetAttachment creates or replaces an attachment. If the project does not
+// exist an error of type store.ErrProjectNotFound is returned.
+func (s *Store) SetAttachment(ctx context.Context, params store.SetAttachment) (*store.Attachment, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.projects[params.ProjectID]; !ok {
+		return nil, store.NewStoreError(store.ErrProjectNotFound, nil)
+	}
+	ts := now()
+	k := key{params.ProjectID, params.AttachmentID}
+	r, ok := s.attachments[k]
+	if !ok {
+		r = &store.Attachment{
+			AttachmentID: params.AttachmentID,
+			ProjectID:    params.ProjectID,
+			CreatedAt:    ts,
+		}
+		s.attachments[k] = r
+	}
+	r.Filename = params.Filename
+	r.ContentType = params.ContentType
+	r.Content = slices.Clone(params.Content)
+	r.Size = len(params.Content)
+	r.Checksum = params.Checksum
+	r.ModifiedAt = ts
+	return cloneAttachment(r), nil
+}
+
+// GetAttachment gets an attachment including its content. If the
+// attachment is not found, an error of type store.ErrAttachmentNotFound is
+// returned.
+func (s *Store) GetAttachment(ctx context.Context, projectID, attachmentID string) (*store.Attachment, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	r, ok := s.attachments[key{projectID, attachmentID}]
+	if !ok {
+		return nil, store.NewStoreError(store.ErrAttachmentNotFound, nil)
+	}
+	return cloneAttachment(r), nil
+}
+
+// ListAttachments lists all the attachments for a project ordered by
+// attachment id. The content of the attachments is not returned.
+func (s *Store) ListAttachments(ctx context.Context, projectID string) ([]*store.Attachment, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	list := sortedValues(s.attachments, projectID)
+	for _, r := range list {
+		r.Content = nil
+	}
+	return list, nil
+}
+
+// DeleteAttachment deletes an attachment and removes it from any templates
+// that reference it. If the attachment does not exist an error of type
+// store.ErrAttachmentNotFound is returned.
+func (s *Store) DeleteAttachment(ctx context.Context, projectID, attachmentID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	k := key{projectID, attachmentID}
+	if _, ok := s.attachments[k]; !ok {
+		return store.NewStoreError(store.ErrAttachmentNotFound, nil)
+	}
+	delete(s.attachments, k)
+	for tk, ids := range s.templateAttachments {
+		if tk.projectID == projectID {
+			s.templateAttachments[tk] = slices.DeleteFunc(ids, func(id string) bool {
+				return id == attachmentID
+			})
+		}
+	}
+	return nil
+}
+
+// SetTemplateAttachments replaces the attachments referenced by a template.
+// If the template does not exist an error of type store.ErrTemplateNotFound
+// is returned. If any of the attachments do not exist an error of type
+// store.ErrAttachmentNotFound is returned and no changes are made.
+func (s *Store) SetTemplateAttachments(ctx context.Context, projectID, templateID string, attachmentIDs []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	k := key{projectID, templateID}
+	if _, ok := s.templates[k]; !ok {
+		return store.NewStoreError(store.ErrTemplateNotFound, nil)
+	}
+	seen := make(map[string]bool, len(attachmentIDs))
+	for _, id := range attachmentIDs {
+		if _, ok := s.attachments[key{projectID, id}]; !ok {
+			return store.NewStoreError(store.ErrAttachmentNotFound, nil)
+		}
+		if seen[id] {
+			return errors.Errorf("[memory:template_attachments] attachment %q listed more than once", id)
+		}
+		seen[id] = true
+	}
+	s.templateAttachments[k] = slices.Clone(attachmentIDs)
+	return nil
+}
+
+// ListTemplateAttachments lists the attachments referenced by a template,
+// including their content, in the order they were set.
+func (s *Store) ListTemplateAttachments(ctx context.Context, projectID, templateID string) ([]*store.Attachment, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	ids := s.templateAttachments[key{projectID, templateID}]
+	list := make([]*store.Attachment, 0, len(ids))
+	for _, id := range ids {
+		list = append(list, cloneAttachment(s.attachments[key{projectID, id}]))
+	}
+	return list, nil
+}
+
+func cloneAttachment(r *store.Attachment) *store.Attachment {
+	c := *r
+	c.Content = slices.Clone(r.Content)
+	return &c
+}
+
+//
+// assets
+//
+
+// SetAsset creates or replaces an asset. If the project does not exist an
+// error of type store.ErrProjectNotFound is returned.
+func (s *Store) SetAsset(ctx context.Context, params store.SetAsset) (*store.Asset, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.projects[params.ProjectID]; !ok {
+		return nil, store.NewStoreError(store.ErrProjectNotFound, nil)
+	}
+	ts := now()
+	k := key{params.ProjectID, params.AssetID}
+	r, ok := s.assets[k]
+	if !ok {
+		r = &store.Asset{
+			AssetID:   params.AssetID,
+			ProjectID: params.ProjectID,
+			CreatedAt: ts,
+		}
+		s.assets[k] = r
+	}
+	r.Filename = params.Filename
+	r.ContentType = params.ContentType
+	r.Content = slices.Clone(params.Content)
+	r.Size = len(params.Content)
+	r.Checksum = params.Checksum
+	r.ModifiedAt = ts
+	return cloneAsset(r), nil
+}
+
+// GetAsset gets an asset including its content. If the asset is not found,
+// an error of type store.ErrAssetNotFound is returned.
+func (s *Store) GetAsset(ctx context.Context, projectID, assetID string) (*store.Asset, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	r, ok := s.assets[key{projectID, assetID}]
+	if !ok {
+		return nil, store.NewStoreError(store.ErrAssetNotFound, nil)
+	}
+	return cloneAsset(r), nil
+}
+
+// ListAssets lists all the assets for a project ordered by asset id. The
+// content of the assets is not returned.
+func (s *Store) ListAssets(ctx context.Context, projectID string) ([]*store.Asset, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	list := sortedValues(s.assets, projectID)
+	for _, r := range list {
+		r.Content = nil
+	}
+	return list, nil
+}
+
+// DeleteAsset deletes an asset. If the asset does not exist an error of
+// type store.ErrAssetNotFound is returned.
+func (s *Store) DeleteAsset(ctx context.Context, projectID, assetID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	k := key{projectID, assetID}
+	if _, ok := s.assets[k]; !ok {
+		return store.NewStoreError(store.ErrAssetNotFound, nil)
+	}
+	delete(s.assets, k)
+	return nil
+}
+
+func cloneAsset(r *store.Asset) *store.Asset {
+	c := *r
+	c.Content = slices.Clone(r.Content)
+	return &c
+}
@@ -0,0 +1,514 @@
+package memory
+
+import (
+	"context"
+	"maps"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/andyfusniak/squishy-mailer-lite/internal/store"
+	"github.com/pkg/errors"
+)
+
+// mailQueueRow is an email in the mail queue along with the bookkeeping
+// the SQL stores keep in columns and tables that are not part of
+// store.MailQueue.
+type mailQueueRow struct {
+	store.MailQueue
+	seq      int64
+	attempts []*store.MailQueueAttempt
+	opens    store.MailQueueOpens
+}
+
+// InsertMailQueue inserts a new email into the mail queue. If the project
+// does not exist an error of type store.ErrProjectNotFound is returned.
+func (s *Store) InsertMailQueue(ctx context.Context, params store.AddMailQueue) (*store.MailQueue, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.projects[params.ProjectID]; !ok {
+		return nil, store.NewStoreError(store.ErrProjectNotFound, nil)
+	}
+	ts := now()
+	sendAt := ts
+	if !time.Time(params.SendAt).IsZero() {
+		sendAt = store.Datetime(time.Time(params.SendAt).UTC().Truncate(time.Microsecond))
+	}
+	s.seq++
+	row := &mailQueueRow{
+		MailQueue: store.MailQueue{
+			MailQueueID:   params.MailQueueID,
+			ProjectID:     params.ProjectID,
+			TemplateID:    params.TemplateID,
+			TransportID:   params.TransportID,
+			MState:        params.MState,
+			Metadata:      params.Metadata,
+			Body:          params.Body,
+			LastError:     params.LastError,
+			MessageID:     params.MessageID,
+			CampaignID:    params.CampaignID,
+			SendAt:        sendAt,
+			NextAttemptAt: sendAt,
+			CreatedAt:     ts,
+			ModifiedAt:    ts,
+		},
+		seq: s.seq,
+	}
+	row.MailQueue = *cloneMailQueue(&row.MailQueue)
+	s.mailQueue[row.MailQueueID] = row
+	return cloneMailQueue(&row.MailQueue), nil
+}
+
+// GetMailQueue gets an email from the mail queue. If the email is not found
+// an error of type store.ErrMailQueueNotFound is returned.
+func (s *Store) GetMailQueue(ctx context.Context, projectID, mailQueueID string) (*store.MailQueue, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	row, ok := s.mailQueue[mailQueueID]
+	if !ok || row.ProjectID != projectID {
+		return nil, store.NewStoreError(store.ErrMailQueueNotFound, nil)
+	}
+	return cloneMailQueue(&row.MailQueue), nil
+}
+
+// GetMailQueueByMessageID gets the most recent email in the mail queue of
+// a project with the given Message-ID. If none is found an error of type
+// store.ErrMailQueueNotFound is returned.
+func (s *Store) GetMailQueueByMessageID(ctx context.Context, projectID, messageID string) (*store.MailQueue, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var found *mailQueueRow
+	for _, row := range s.mailQueue {
+		if row.ProjectID != projectID || messageID == "" || row.MessageID != messageID {
+			continue
+		}
+		if found == nil || row.seq > found.seq {
+			found = row
+		}
+	}
+	if found == nil {
+		return nil, store.NewStoreError(store.ErrMailQueueNotFound, nil)
+	}
+	return cloneMailQueue(&found.MailQueue), nil
+}
+
+// ClaimMailQueue moves up to limit queued or rate limited emails whose next
+// attempt is due to the sending state and returns them, oldest first.
+// Emails that have been sending for longer than lease are reclaimed unless
+// lease is zero or less.
+func (s *Store) ClaimMailQueue(ctx context.Context, limit int, lease time.Duration) ([]*store.MailQueue, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ts := now()
+	due := make([]*mailQueueRow, 0)
+	for _, row := range s.mailQueue {
+		switch row.MState {
+		case store.MailQueueStateQueued, store.MailQueueStateRateLimited:
+			if !time.Time(row.NextAttemptAt).After(time.Time(ts)) {
+				due = append(due, row)
+			}
+		case store.MailQueueStateSending:
+			if lease > 0 && time.Time(row.ModifiedAt).Before(time.Time(ts).Add(-lease)) {
+				due = append(due, row)
+			}
+		}
+	}
+	sortMailQueueRows(due)
+	if len(due) > limit {
+		due = due[:limit]
+	}
+
+	list := make([]*store.MailQueue, 0, len(due))
+	for _, row := range due {
+		row.MState = store.MailQueueStateSending
+		row.ModifiedAt = ts
+		list = append(list, cloneMailQueue(&row.MailQueue))
+	}
+	return list, nil
+}
+
+// UpdateMailQueueState sets the state, last error and deferral reason of an
+// email in the mail queue. If params.Body, params.NextAttemptAt or
+// params.Attempts are non-nil they are also replaced. If the email is not
+// found an error of type store.ErrMailQueueNotFound is returned.
+func (s *Store) UpdateMailQueueState(ctx context.Context, params store.UpdateMailQueueState) (*store.MailQueue, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	row, ok := s.mailQueue[params.MailQueueID]
+	if !ok {
+		return nil, store.NewStoreError(store.ErrMailQueueNotFound, nil)
+	}
+	ts := now()
+	row.MState = params.MState
+	row.LastError = params.LastError
+	row.DeferralReason = params.DeferralReason
+	if params.MState == store.MailQueueStateSent {
+		row.SentAt = ts
+		row.SentTransportID = params.SentTransportID
+		if row.SentTransportID == "" {
+			row.SentTransportID = row.TransportID
+		}
+	}
+	if params.NextAttemptAt != nil {
+		row.NextAttemptAt = *params.NextAttemptAt
+	}
+	if params.Attempts != nil {
+		row.Attempts = *params.Attempts
+	}
+	if params.Body != nil {
+		row.Body = *params.Body
+		row.Body.TemplateParams = maps.Clone(params.Body.TemplateParams)
+	}
+	row.ModifiedAt = ts
+	return cloneMailQueue(&row.MailQueue), nil
+}
+
+// CountMailQueueSent counts the emails sent by a transport since the given
+// time, including those it sent as a fallback for another transport. An
+// empty transportID counts the emails sent by every transport in the
+// project.
+func (s *Store) CountMailQueueSent(ctx context.Context, projectID, transportID string, since store.Datetime) (int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var n int
+	for _, row := range s.mailQueue {
+		if row.ProjectID == projectID && (transportID == "" || row.SentTransportID == transportID) &&
+			row.MState == store.MailQueueStateSent &&
+			!time.Time(row.SentAt).Before(time.Time(since)) {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// ListMailQueue lists the emails in the mail queue of a project, newest
+// first. If params.After is set the list starts after that email.
+func (s *Store) ListMailQueue(ctx context.Context, params store.ListMailQueue) ([]*store.MailQueue, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	rows := make([]*mailQueueRow, 0)
+	for _, row := range s.mailQueue {
+		if row.ProjectID == params.ProjectID &&
+			(params.MState == "" || row.MState == params.MState) &&
+			hasLabels(row.Metadata.Labels, params.Labels) {
+			rows = append(rows, row)
+		}
+	}
+	sortMailQueueRows(rows)
+	slices.Reverse(rows)
+	if params.After != "" {
+		if after, ok := s.mailQueue[params.After]; ok {
+			i := sort.Search(len(rows), func(i int) bool {
+				return mailQueueRowBefore(rows[i], after)
+			})
+			rows = rows[i:]
+		} else {
+			rows = rows[:0]
+		}
+	}
+	if params.Limit > 0 && len(rows) > params.Limit {
+		rows = rows[:params.Limit]
+	}
+
+	list := make([]*store.MailQueue, 0, len(rows))
+	for _, row := range rows {
+		list = append(list, cloneMailQueue(&row.MailQueue))
+	}
+	return list, nil
+}
+
+// TransitionMailQueueState moves an email from one of params.From to
+// params.MState. If the email is not found an error of type
+// store.ErrMailQueueNotFound is returned, and if it is in none of the
+// states an error of type store.ErrMailQueueState.
+func (s *Store) TransitionMailQueueState(ctx context.Context, params store.TransitionMailQueueState) (*store.MailQueue, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	row, ok := s.mailQueue[params.MailQueueID]
+	if !ok || row.ProjectID != params.ProjectID {
+		return nil, store.NewStoreError(store.ErrMailQueueNotFound, nil)
+	}
+	if !slices.Contains(params.From, row.MState) {
+		return nil, store.NewStoreError(store.ErrMailQueueState,
+			errors.Errorf("mail queue entry %s is %s", row.MailQueueID, row.MState))
+	}
+	row.MState = params.MState
+	row.DeferralReason = ""
+	if params.NextAttemptAt != nil {
+		row.NextAttemptAt = *params.NextAttemptAt
+	}
+	if params.LastError != nil {
+		row.LastError = *params.LastError
+	}
+	if params.Attempts != nil {
+		row.Attempts = *params.Attempts
+	}
+	if params.TransportID != nil {
+		row.TransportID = *params.TransportID
+	}
+	row.ModifiedAt = now()
+	return cloneMailQueue(&row.MailQueue), nil
+}
+
+// InsertMailQueueAttempt records the outcome of a delivery attempt. If the
+// email does not exist an error of type store.ErrMailQueueNotFound is
+// returned.
+func (s *Store) InsertMailQueueAttempt(ctx context.Context, params store.AddMailQueueAttempt) (*store.MailQueueAttempt, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	row, ok := s.mailQueue[params.MailQueueID]
+	if !ok || row.ProjectID != params.ProjectID {
+		return nil, store.NewStoreError(store.ErrMailQueueNotFound, nil)
+	}
+	ts := now()
+	a := &store.MailQueueAttempt{
+		MailQueueID:  params.MailQueueID,
+		ProjectID:    params.ProjectID,
+		Attempt:      len(row.attempts) + 1,
+		TransportID:  params.TransportID,
+		MState:       params.MState,
+		Error:        params.Error,
+		ResponseCode: params.ResponseCode,
+		Response:     params.Response,
+		StartedAt:    params.StartedAt,
+		CreatedAt:    ts,
+	}
+	if time.Time(a.StartedAt).IsZero() {
+		a.StartedAt = ts
+	} else {
+		a.StartedAt = store.Datetime(time.Time(a.StartedAt).UTC().Truncate(time.Microsecond))
+	}
+	row.attempts = append(row.attempts, a)
+	c := *a
+	return &c, nil
+}
+
+// ListMailQueueAttempts lists the delivery attempts of an email in the
+// order they were made.
+func (s *Store) ListMailQueueAttempts(ctx context.Context, projectID, mailQueueID string) ([]*store.MailQueueAttempt, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	list := make([]*store.MailQueueAttempt, 0)
+	row, ok := s.mailQueue[mailQueueID]
+	if !ok || row.ProjectID != projectID {
+		return list, nil
+	}
+	for _, a := range row.attempts {
+		c := *a
+		list = append(list, &c)
+	}
+	sort.SliceStable(list, func(i, j int) bool { return list[i].Attempt < list[j].Attempt })
+	return list, nil
+}
+
+// RecordMailQueueOpen counts an open of an email and returns its updated
+// open counts. If the email does not exist an error of type
+// store.ErrMailQueueNotFound is returned.
+func (s *Store) RecordMailQueueOpen(ctx context.Context, projectID, mailQueueID string) (*store.MailQueueOpens, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	row, ok := s.mailQueue[mailQueueID]
+	if !ok || row.ProjectID != projectID {
+		return nil, store.NewStoreError(store.ErrMailQueueNotFound, nil)
+	}
+	ts := now()
+	if row.opens.Opens == 0 {
+		row.opens = store.MailQueueOpens{
+			MailQueueID:   mailQueueID,
+			ProjectID:     projectID,
+			FirstOpenedAt: ts,
+		}
+	}
+	row.opens.Opens++
+	row.opens.LastOpenedAt = ts
+	c := row.opens
+	return &c, nil
+}
+
+// GetMailQueueOpens gets the open counts of an email. Emails that have not
+// been opened have an Opens count of zero.
+func (s *Store) GetMailQueueOpens(ctx context.Context, projectID, mailQueueID string) (*store.MailQueueOpens, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	row, ok := s.mailQueue[mailQueueID]
+	if !ok || row.ProjectID != projectID || row.opens.Opens == 0 {
+		return &store.MailQueueOpens{MailQueueID: mailQueueID, ProjectID: projectID}, nil
+	}
+	c := row.opens
+	return &c, nil
+}
+
+// CountMailQueueByDay counts the emails of a project queued in a time range
+// by the UTC day they were queued, template, transport and current state,
+// ordered by day. Test sends are not counted.
+func (s *Store) CountMailQueueByDay(ctx context.Context, params store.CountMailQueueByDay) ([]*store.MailQueueDayCount, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	counts := make(map[store.MailQueueDayCount]int)
+	for _, row := range s.mailQueue {
+		created := time.Time(row.CreatedAt)
+		if row.ProjectID != params.ProjectID || row.Metadata.Test ||
+			created.Before(time.Time(params.From)) || !created.Before(time.Time(params.To)) {
+			continue
+		}
+		counts[store.MailQueueDayCount{
+			Day:         created.UTC().Format(time.DateOnly),
+			TemplateID:  row.TemplateID,
+			TransportID: row.TransportID,
+			MState:      row.MState,
+		}]++
+	}
+
+	list := make([]*store.MailQueueDayCount, 0, len(counts))
+	for k, n := range counts {
+		c := k
+		c.Count = n
+		list = append(list, &c)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		a, b := list[i], list[j]
+		if a.Day != b.Day {
+			return a.Day < b.Day
+		}
+		if a.TemplateID != b.TemplateID {
+			return a.TemplateID < b.TemplateID
+		}
+		if a.TransportID != b.TransportID {
+			return a.TransportID < b.TransportID
+		}
+		return a.MState < b.MState
+	})
+	return list, nil
+}
+
+// GetMailQueueStats counts the emails of a project by state, finds the
+// oldest of those waiting to be sent and counts the delivery attempts made
+// at or after since.
+func (s *Store) GetMailQueueStats(ctx context.Context, projectID string, since store.Datetime) (*store.MailQueueStats, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	stats := store.MailQueueStats{States: make(map[string]int)}
+	for _, row := range s.mailQueue {
+		if row.ProjectID != projectID {
+			continue
+		}
+		stats.States[row.MState]++
+		if slices.Contains(store.MailQueuePendingStates, row.MState) &&
+			(time.Time(stats.OldestPendingCreatedAt).IsZero() ||
+				time.Time(row.CreatedAt).Before(time.Time(stats.OldestPendingCreatedAt))) {
+			stats.OldestPendingCreatedAt = row.CreatedAt
+		}
+		for _, a := range row.attempts {
+			if time.Time(a.CreatedAt).Before(time.Time(since)) {
+				continue
+			}
+			stats.Attempts++
+			if a.MState == store.MailQueueStateFailed {
+				stats.FailedAttempts++
+			}
+		}
+	}
+	return &stats, nil
+}
+
+// sortMailQueueRows orders mail queue entries by creation time, breaking
+// ties in the order they were inserted.
+func sortMailQueueRows(list []*mailQueueRow) {
+	sort.Slice(list, func(i, j int) bool {
+		return mailQueueRowBefore(list[i], list[j])
+	})
+}
+
+// mailQueueRowBefore reports whether a was queued before b.
+func mailQueueRowBefore(a, b *mailQueueRow) bool {
+	ta, tb := time.Time(a.CreatedAt), time.Time(b.CreatedAt)
+	if !ta.Equal(tb) {
+		return ta.Before(tb)
+	}
+	return a.seq < b.seq
+}
+
+// cloneMailQueue returns a copy of a mail queue entry that shares no
+// slices or maps with the original.
+func cloneMailQueue(r *store.MailQueue) *store.MailQueue {
+	c := *r
+	c.Metadata.To = slices.Clone(r.Metadata.To)
+	c.Metadata.Cc = slices.Clone(r.Metadata.Cc)
+	c.Metadata.Bcc = slices.Clone(r.Metadata.Bcc)
+	c.Metadata.AttachmentIDs = slices.Clone(r.Metadata.AttachmentIDs)
+	c.Metadata.AssetIDs = slices.Clone(r.Metadata.AssetIDs)
+	c.Metadata.Labels = maps.Clone(r.Metadata.Labels)
+	c.Body.TemplateParams = maps.Clone(r.Body.TemplateParams)
+	c.Body.Attachments = slices.Clone(r.Body.Attachments)
+	return &c
+}
+
+// ListExpiredMailQueue lists up to limit emails of every project that have
+// finished delivery and were last modified before the given time, least
+// recently modified first.
+func (s *Store) ListExpiredMailQueue(ctx context.Context, before store.Datetime, limit int) ([]*store.MailQueue, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var rows []*mailQueueRow
+	for _, row := range s.mailQueue {
+		if expired(row, before) {
+			rows = append(rows, row)
+		}
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		ti, tj := time.Time(rows[i].ModifiedAt), time.Time(rows[j].ModifiedAt)
+		if !ti.Equal(tj) {
+			return ti.Before(tj)
+		}
+		return rows[i].seq < rows[j].seq
+	})
+	if limit > 0 && len(rows) > limit {
+		rows = rows[:limit]
+	}
+
+	list := make([]*store.MailQueue, 0, len(rows))
+	for _, row := range rows {
+		list = append(list, cloneMailQueue(&row.MailQueue))
+	}
+	return list, nil
+}
+
+// DeleteExpiredMailQueue deletes the emails with the given ids that have
+// finished delivery and were last modified before the given time, along
+// with their delivery attempts and open counts. It returns the number of
+// emails deleted.
+func (s *Store) DeleteExpiredMailQueue(ctx context.Context, before store.Datetime, mailQueueIDs []string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var n int
+	for _, id := range mailQueueIDs {
+		if row, ok := s.mailQueue[id]; ok && expired(row, before) {
+			delete(s.mailQueue, id)
+			n++
+		}
+	}
+	return n, nil
+}
+
+// expired reports whether the email has finished delivery and was last
+// modified before the given time.
+func expired(row *mailQueueRow, before store.Datetime) bool {
+	return slices.Contains(store.MailQueueFinishedStates, row.MState) &&
+		time.Time(row.ModifiedAt).Before(time.Time(before))
+}
@@ -0,0 +1,53 @@
+package memory
+
+import (
+	"context"
+
+	"github.com/andyfusniak/squishy-mailer-lite/internal/store"
+	"github.com/pkg/errors"
+)
+
+// Backup returns an error of type store.ErrBackupNotSupported as the
+// records are not held in a database file.
+func (s *Store) Backup(ctx context.Context, destPath string) error {
+	return store.NewStoreError(store.ErrBackupNotSupported,
+		errors.New("in-memory store cannot be backed up"))
+}
+
+// Checkpoint does nothing as there is no write-ahead log.
+func (s *Store) Checkpoint(ctx context.Context) error {
+	return nil
+}
+
+// Vacuum does nothing as deleted records are freed by the garbage
+// collector.
+func (s *Store) Vacuum(ctx context.Context) error {
+	return nil
+}
+
+// SchemaVersion returns an error of type store.ErrMigrationsNotSupported
+// as the store has no schema.
+func (s *Store) SchemaVersion(ctx context.Context) (*store.SchemaVersion, error) {
+	return nil, errMigrationsNotSupported()
+}
+
+// MigrateTo returns an error of type store.ErrMigrationsNotSupported.
+func (s *Store) MigrateTo(ctx context.Context, version uint) error {
+	return errMigrationsNotSupported()
+}
+
+// ForceSchemaVersion returns an error of type
+// store.ErrMigrationsNotSupported.
+func (s *Store) ForceSchemaVersion(ctx context.Context, version uint) error {
+	return errMigrationsNotSupported()
+}
+
+func errMigrationsNotSupported() error {
+	return store.NewStoreError(store.ErrMigrationsNotSupported,
+		errors.New("in-memory store has no schema to migrate"))
+}
+
+// Ping always succeeds as there is no database to reach.
+func (s *Store) Ping(ctx context.Context) error {
+	return nil
+}
@@ -0,0 +1,1258 @@
+// Package memory provides an in-memory implementation of store.Repository.
+// It is intended for tests and ephemeral use where cgo or a database file
+// are not wanted. Nothing is persisted once the store is discarded.
+package memory
+
+import (
+	"context"
+	"maps"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/andyfusniak/squishy-mailer-lite/internal/store"
+	"github.com/pkg/errors"
+)
+
+// key identifies a record that is unique within a project.
+type key struct {
+	projectID string
+	id        string
+}
+
+var _ store.Repository = (*Store)(nil)
+
+// Store is an in-memory store.Repository. It is safe for concurrent use.
+type Store struct {
+	mu sync.RWMutex
+
+	projects            map[string]*store.Project
+	transports          map[key]*store.SMTPTransport
+	apiTransports       map[key]*store.APITransport
+	groups              map[key]*store.Group
+	templates           map[key]*store.Template
+	partials            map[key]*store.Partial
+	variants            map[key]*store.TemplateVariant
+	fixtures            map[key]*store.TemplateFixture
+	mailQueue           map[string]*mailQueueRow
+	sendWindows         map[key]*store.SendWindow
+	rateLimits          map[key]*store.RateLimit
+	quotas              map[key]*store.ProjectQuota
+	quotaUsage          map[key]int
+	webhooks            map[key]*store.Webhook
+	campaigns           map[key]*store.Campaign
+	contactLists        map[key]*store.ContactList
+	contacts            map[key]map[string]*store.Contact
+	webhookDeliveries   map[string]*webhookDeliveryRow
+	suppressions        map[key]*store.Suppression
+	senderAllowLists    map[key]*store.SenderAllowList
+	apiKeys             map[string]*store.APIKey
+	settings            map[string]*store.Setting
+	catalogs            map[key]*store.MessageCatalog
+	attachments         map[key]*store.Attachment
+	templateAttachments map[key][]string
+	assets              map[key]*store.Asset
+	verifications       map[key]*store.TransportVerification
+
+	// seq orders mail queue entries created at the same time
+	seq int64
+}
+
+// New returns a new empty in-memory store.
+func New() *Store {
+	return &Store{
+		projects:            make(map[string]*store.Project),
+		transports:          make(map[key]*store.SMTPTransport),
+		apiTransports:       make(map[key]*store.APITransport),
+		groups:              make(map[key]*store.Group),
+		templates:           make(map[key]*store.Template),
+		partials:            make(map[key]*store.Partial),
+		variants:            make(map[key]*store.TemplateVariant),
+		fixtures:            make(map[key]*store.TemplateFixture),
+		mailQueue:           make(map[string]*mailQueueRow),
+		sendWindows:         make(map[key]*store.SendWindow),
+		rateLimits:          make(map[key]*store.RateLimit),
+		quotas:              make(map[key]*store.ProjectQuota),
+		quotaUsage:          make(map[key]int),
+		webhooks:            make(map[key]*store.Webhook),
+		campaigns:           make(map[key]*store.Campaign),
+		contactLists:        make(map[key]*store.ContactList),
+		contacts:            make(map[key]map[string]*store.Contact),
+		webhookDeliveries:   make(map[string]*webhookDeliveryRow),
+		suppressions:        make(map[key]*store.Suppression),
+		senderAllowLists:    make(map[key]*store.SenderAllowList),
+		apiKeys:             make(map[string]*store.APIKey),
+		settings:            make(map[string]*store.Setting),
+		catalogs:            make(map[key]*store.MessageCatalog),
+		attachments:         make(map[key]*store.Attachment),
+		templateAttachments: make(map[key][]string),
+		assets:              make(map[key]*store.Asset),
+		verifications:       make(map[key]*store.TransportVerification),
+	}
+}
+
+// Close is a no-op. The contents of the store are kept until it is
+// garbage collected.
+func (s *Store) Close() error {
+	return nil
+}
+
+// now returns the current time at the microsecond precision used by the
+// SQL stores.
+func now() store.Datetime {
+	return store.Datetime(time.Now().UTC().Truncate(time.Microsecond))
+}
+
+// sortedValues returns the values of m whose project matches projectID
+// ordered by id.
+func sortedValues[T any](m map[key]*T, projectID string) []*T {
+	keys := make([]key, 0)
+	for k := range m {
+		if k.projectID == projectID {
+			keys = append(keys, k)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].id < keys[j].id })
+
+	list := make([]*T, 0, len(keys))
+	for _, k := range keys {
+		v := *m[k]
+		list = append(list, &v)
+	}
+	return list
+}
+
+//
+// projects
+//
+
+// InsertProject inserts a new project into the store. If the project
+// already exists an error of type store.ErrProjectAlreadyExists is
+// returned.
+func (s *Store) InsertProject(ctx context.Context, params store.AddProject) (*store.Project, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.projects[params.ProjectID]; ok {
+		return nil, store.NewStoreError(store.ErrProjectAlreadyExists, nil)
+	}
+	r := &store.Project{
+		ProjectID:               params.ProjectID,
+		ProjectName:             params.ProjectName,
+		Description:             params.Description,
+		AllowedRecipientDomains: store.JSONArray{},
+		TransportChain:          store.JSONArray{},
+		Labels:                  store.JSONObject{},
+		Variables:               store.JSONObject{},
+		CreatedAt:               now(),
+	}
+	s.projects[r.ProjectID] = r
+	return cloneProject(r), nil
+}
+
+// GetProject gets a project from the store. If the project is not found,
+// an error of type store.ErrProjectNotFound is returned.
+func (s *Store) GetProject(ctx context.Context, projectID string) (*store.Project, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	r, ok := s.projects[projectID]
+	if !ok {
+		return nil, store.NewStoreError(store.ErrProjectNotFound, nil)
+	}
+	return cloneProject(r), nil
+}
+
+// ListProjects lists the projects ordered by id.
+func (s *Store) ListProjects(ctx context.Context, params store.ListProjects) ([]*store.Project, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	ids := make([]string, 0, len(s.projects))
+	for id, r := range s.projects {
+		if id > params.After && hasLabels(r.Labels, params.Labels) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	if params.Limit > 0 && len(ids) > params.Limit {
+		ids = ids[:params.Limit]
+	}
+
+	list := make([]*store.Project, 0, len(ids))
+	for _, id := range ids {
+		list = append(list, cloneProject(s.projects[id]))
+	}
+	return list, nil
+}
+
+// SetProjectAllowedRecipientDomains replaces the list of recipient domains
+// the project is allowed to send to.
+func (s *Store) SetProjectAllowedRecipientDomains(ctx context.Context, projectID string, domains store.JSONArray) (*store.Project, error) {
+	if domains == nil {
+		domains = store.JSONArray{}
+	}
+	return s.updateProject(projectID, func(r *store.Project) {
+		r.AllowedRecipientDomains = slices.Clone(domains)
+	})
+}
+
+// SetProjectDefaultTransport sets the project's default transport.
+func (s *Store) SetProjectDefaultTransport(ctx context.Context, projectID, transportID string) (*store.Project, error) {
+	return s.updateProject(projectID, func(r *store.Project) {
+		r.DefaultTransportID = transportID
+	})
+}
+
+// SetProjectTransportChain sets the ordered transports the project's
+// emails fall back through.
+func (s *Store) SetProjectTransportChain(ctx context.Context, projectID string, transportIDs store.JSONArray) (*store.Project, error) {
+	if transportIDs == nil {
+		transportIDs = store.JSONArray{}
+	}
+	return s.updateProject(projectID, func(r *store.Project) {
+		r.TransportChain = slices.Clone(transportIDs)
+	})
+}
+
+// SetProjectDefaultGroup sets the project's default group.
+func (s *Store) SetProjectDefaultGroup(ctx context.Context, projectID, groupID string) (*store.Project, error) {
+	return s.updateProject(projectID, func(r *store.Project) {
+		r.DefaultGroupID = groupID
+	})
+}
+
+// SetProjectLabels replaces the labels of a project.
+func (s *Store) SetProjectLabels(ctx context.Context, projectID string, labels store.JSONObject) (*store.Project, error) {
+	if labels == nil {
+		labels = store.JSONObject{}
+	}
+	return s.updateProject(projectID, func(r *store.Project) {
+		r.Labels = maps.Clone(labels)
+	})
+}
+
+// SetProjectVariables replaces the template variables of a project.
+func (s *Store) SetProjectVariables(ctx context.Context, projectID string, variables store.JSONObject) (*store.Project, error) {
+	if variables == nil {
+		variables = store.JSONObject{}
+	}
+	return s.updateProject(projectID, func(r *store.Project) {
+		r.Variables = maps.Clone(variables)
+	})
+}
+
+// UpdateProject sets the name and description of a project.
+func (s *Store) UpdateProject(ctx context.Context, params store.UpdateProject) (*store.Project, error) {
+	return s.updateProject(params.ProjectID, func(r *store.Project) {
+		r.ProjectName = params.ProjectName
+		r.Description = params.Description
+	})
+}
+
+func (s *Store) updateProject(projectID string, fn func(*store.Project)) (*store.Project, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r, ok := s.projects[projectID]
+	if !ok {
+		return nil, store.NewStoreError(store.ErrProjectNotFound, nil)
+	}
+	fn(r)
+	return cloneProject(r), nil
+}
+
+// DeleteProject deletes a project and everything that belongs to it. If the
+// project is not found, an error of type store.ErrProjectNotFound is
+// returned.
+func (s *Store) DeleteProject(ctx context.Context, projectID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.projects[projectID]; !ok {
+		return store.NewStoreError(store.ErrProjectNotFound, nil)
+	}
+	delete(s.projects, projectID)
+	deleteProjectKeys(s.transports, projectID)
+	deleteProjectKeys(s.apiTransports, projectID)
+	deleteProjectKeys(s.groups, projectID)
+	deleteProjectKeys(s.templates, projectID)
+	deleteProjectKeys(s.partials, projectID)
+	deleteProjectKeys(s.variants, projectID)
+	deleteProjectKeys(s.fixtures, projectID)
+	deleteProjectKeys(s.sendWindows, projectID)
+	deleteProjectKeys(s.rateLimits, projectID)
+	deleteProjectKeys(s.quotas, projectID)
+	deleteProjectKeys(s.quotaUsage, projectID)
+	deleteProjectKeys(s.webhooks, projectID)
+	deleteProjectKeys(s.campaigns, projectID)
+	deleteProjectKeys(s.contactLists, projectID)
+	deleteProjectKeys(s.contacts, projectID)
+	deleteProjectKeys(s.suppressions, projectID)
+	deleteProjectKeys(s.senderAllowLists, projectID)
+	deleteProjectKeys(s.catalogs, projectID)
+	deleteProjectKeys(s.attachments, projectID)
+	deleteProjectKeys(s.templateAttachments, projectID)
+	deleteProjectKeys(s.assets, projectID)
+	deleteProjectKeys(s.verifications, projectID)
+	for id, row := range s.mailQueue {
+		if row.ProjectID == projectID {
+			delete(s.mailQueue, id)
+		}
+	}
+	for id, row := range s.webhookDeliveries {
+		if row.ProjectID == projectID {
+			delete(s.webhookDeliveries, id)
+		}
+	}
+	return nil
+}
+
+func deleteProjectKeys[T any](m map[key]T, projectID string) {
+	for k := range m {
+		if k.projectID == projectID {
+			delete(m, k)
+		}
+	}
+}
+
+func cloneProject(r *store.Project) *store.Project {
+	c := *r
+	c.AllowedRecipientDomains = slices.Clone(r.AllowedRecipientDomains)
+	c.TransportChain = slices.Clone(r.TransportChain)
+	c.Labels = maps.Clone(r.Labels)
+	c.Variables = maps.Clone(r.Variables)
+	return &c
+}
+
+// hasLabels reports whether labels has every key/value pair of want.
+func hasLabels(labels, want map[string]string) bool {
+	for k, v := range want {
+		if lv, ok := labels[k]; !ok || lv != v {
+			return false
+		}
+	}
+	return true
+}
+
+//
+// smtp transports
+//
+
+// InsertSMTPTransport inserts a new SMTP transport into the store. If the
+// project does not exist an error of type store.ErrProjectNotFound is
+// returned.
+func (s *Store) InsertSMTPTransport(ctx context.Context, params store.AddSMTPTransport) (*store.SMTPTransport, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.projects[params.ProjectID]; !ok {
+		return nil, store.NewStoreError(store.ErrProjectNotFound, nil)
+	}
+	k := key{params.ProjectID, params.SMTPTransportID}
+	if _, ok := s.transports[k]; ok {
+		return nil, errors.Errorf("[memory:smtp_transports] transport %q already exists", params.SMTPTransportID)
+	}
+	replyTo := params.EmailReplyTo
+	if replyTo == nil {
+		replyTo = store.JSONArray{}
+	}
+	ts := now()
+	r := &store.SMTPTransport{
+		SMTPTransportID:   params.SMTPTransportID,
+		ProjectID:         params.ProjectID,
+		TransportName:     params.TransportName,
+		Host:              params.Host,
+		Port:              params.Port,
+		Username:          params.Username,
+		EncryptedPassword: params.EncryptedPassword,
+		EmailFrom:         params.EmailFrom,
+		EmailFromName:     params.EmailFromName,
+		EmailReplyTo:      slices.Clone(replyTo),
+		WarmupSchedule:    store.JSONIntArray{},
+		WarmupStartedAt:   store.Datetime(time.Unix(0, 0).UTC()),
+		CreatedAt:         ts,
+		ModifiedAt:        ts,
+
+		TLSMode:               params.TLSMode,
+		TLSInsecureSkipVerify: params.TLSInsecureSkipVerify,
+		TLSCABundle:           params.TLSCABundle,
+
+		ConnectTimeoutMS: params.ConnectTimeoutMS,
+		SendTimeoutMS:    params.SendTimeoutMS,
+	}
+	s.transports[k] = r
+	return cloneSMTPTransport(r), nil
+}
+
+// GetSMTPTransport gets an SMTP transport from the store. If the project
+// does not exist an error of type store.ErrProjectNotFound is returned. If
+// the transport does not exist store.ErrTransportNotFound is returned.
+func (s *Store) GetSMTPTransport(ctx context.Context, transportID, projectID string) (*store.SMTPTransport, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if _, ok := s.projects[projectID]; !ok {
+		return nil, store.NewStoreError(store.ErrProjectNotFound, nil)
+	}
+	r, ok := s.transports[key{projectID, transportID}]
+	if !ok {
+		return nil, store.ErrTransportNotFound
+	}
+	return cloneSMTPTransport(r), nil
+}
+
+// SetSMTPTransportWarmup sets the warm-up schedule of an SMTP transport.
+func (s *Store) SetSMTPTransportWarmup(ctx context.Context, transportID, projectID string, schedule store.JSONIntArray, startedAt store.Datetime) (*store.SMTPTransport, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r, ok := s.transports[key{projectID, transportID}]
+	if !ok {
+		return nil, store.ErrTransportNotFound
+	}
+	if schedule == nil {
+		schedule = store.JSONIntArray{}
+	}
+	r.WarmupSchedule = slices.Clone(schedule)
+	r.WarmupStartedAt = startedAt
+	r.ModifiedAt = now()
+	return cloneSMTPTransport(r), nil
+}
+
+// UpdateSMTPTransport replaces the connection and sender settings of an
+// SMTP transport.
+func (s *Store) UpdateSMTPTransport(ctx context.Context, params store.UpdateSMTPTransport) (*store.SMTPTransport, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r, ok := s.transports[key{params.ProjectID, params.SMTPTransportID}]
+	if !ok {
+		return nil, store.ErrTransportNotFound
+	}
+	replyTo := slices.Clone(params.EmailReplyTo)
+	if replyTo == nil {
+		replyTo = store.JSONArray{}
+	}
+	r.TransportName = params.TransportName
+	r.Host = params.Host
+	r.Port = params.Port
+	r.Username = params.Username
+	r.EmailFrom = params.EmailFrom
+	r.EmailFromName = params.EmailFromName
+	r.EmailReplyTo = replyTo
+	r.TLSMode = params.TLSMode
+	r.TLSInsecureSkipVerify = params.TLSInsecureSkipVerify
+	r.TLSCABundle = params.TLSCABundle
+	r.ConnectTimeoutMS = params.ConnectTimeoutMS
+	r.SendTimeoutMS = params.SendTimeoutMS
+	r.ModifiedAt = now()
+	return cloneSMTPTransport(r), nil
+}
+
+// SetSMTPTransportPassword replaces the encrypted password of an SMTP
+// transport.
+func (s *Store) SetSMTPTransportPassword(ctx context.Context, transportID, projectID, encryptedPassword string) (*store.SMTPTransport, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r, ok := s.transports[key{projectID, transportID}]
+	if !ok {
+		return nil, store.ErrTransportNotFound
+	}
+	r.EncryptedPassword = encryptedPassword
+	r.ModifiedAt = now()
+	return cloneSMTPTransport(r), nil
+}
+
+// DeleteSMTPTransport deletes an SMTP transport. If queued or sending
+// emails reference the transport an error of type store.ErrTransportInUse
+// is returned, unless force is set, in which case those emails are marked
+// as failed. The transport is cleared as the project's default transport
+// and removed from its transport chain.
+func (s *Store) DeleteSMTPTransport(ctx context.Context, transportID, projectID string, force bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	k := key{projectID, transportID}
+	if _, ok := s.transports[k]; !ok {
+		return store.ErrTransportNotFound
+	}
+
+	var pending []*mailQueueRow
+	for _, row := range s.mailQueue {
+		if row.ProjectID == projectID && row.TransportID == transportID &&
+			(row.MState == store.MailQueueStateQueued || row.MState == store.MailQueueStateRateLimited ||
+				row.MState == store.MailQueueStateSending || row.MState == store.MailQueueStatePaused) {
+			pending = append(pending, row)
+		}
+	}
+	if len(pending) > 0 && !force {
+		return store.NewStoreError(store.ErrTransportInUse,
+			errors.Errorf("%d pending emails reference transport %q", len(pending), transportID))
+	}
+	ts := now()
+	for _, row := range pending {
+		row.MState = store.MailQueueStateFailed
+		row.LastError = "transport deleted"
+		row.ModifiedAt = ts
+	}
+
+	if p, ok := s.projects[projectID]; ok {
+		if p.DefaultTransportID == transportID {
+			p.DefaultTransportID = ""
+		}
+		if i := slices.Index(p.TransportChain, transportID); i >= 0 {
+			p.TransportChain = slices.Delete(p.TransportChain, i, i+1)
+		}
+	}
+	delete(s.rateLimits, k)
+	delete(s.senderAllowLists, k)
+	delete(s.verifications, k)
+	delete(s.transports, k)
+	return nil
+}
+
+func cloneSMTPTransport(r *store.SMTPTransport) *store.SMTPTransport {
+	c := *r
+	c.EmailReplyTo = slices.Clone(r.EmailReplyTo)
+	c.WarmupSchedule = slices.Clone(r.WarmupSchedule)
+	return &c
+}
+
+//
+// groups
+//
+
+// InsertGroup inserts a new group into the store. If the project does not
+// exist an error of type store.ErrProjectNotFound is returned.
+func (s *Store) InsertGroup(ctx context.Context, params store.AddGroup) (*store.Group, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.projects[params.ProjectID]; !ok {
+		return nil, store.NewStoreError(store.ErrProjectNotFound, nil)
+	}
+	k := key{params.ProjectID, params.GroupID}
+	if _, ok := s.groups[k]; ok {
+		return nil, errors.Errorf("[memory:groups] group %q already exists", params.GroupID)
+	}
+	ts := now()
+	r := &store.Group{
+		GroupID:    params.GroupID,
+		ProjectID:  params.ProjectID,
+		GroupName:  params.GroupName,
+		CreatedAt:  ts,
+		ModifiedAt: ts,
+	}
+	s.groups[k] = r
+	c := *r
+	return &c, nil
+}
+
+// GetGroup gets a group from the store. If the project does not exist an
+// error of type store.ErrProjectNotFound is returned. If the group does not
+// exist an error of type store.ErrGroupNotFound is returned.
+func (s *Store) GetGroup(ctx context.Context, projectID, groupID string) (*store.Group, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if _, ok := s.projects[projectID]; !ok {
+		return nil, store.NewStoreError(store.ErrProjectNotFound, nil)
+	}
+	r, ok := s.groups[key{projectID, groupID}]
+	if !ok {
+		return nil, store.NewStoreError(store.ErrGroupNotFound, nil)
+	}
+	c := *r
+	return &c, nil
+}
+
+// ListGroups lists the groups of a project ordered by id.
+func (s *Store) ListGroups(ctx context.Context, params store.ListGroups) ([]*store.Group, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	list := make([]*store.Group, 0)
+	for _, r := range sortedValues(s.groups, params.ProjectID) {
+		if r.GroupID <= params.After {
+			continue
+		}
+		if params.Limit > 0 && len(list) == params.Limit {
+			break
+		}
+		list = append(list, r)
+	}
+	return list, nil
+}
+
+// UpdateGroup renames a group. If the group does not exist an error of
+// type store.ErrGroupNotFound is returned.
+func (s *Store) UpdateGroup(ctx context.Context, params store.UpdateGroup) (*store.Group, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r, ok := s.groups[key{params.ProjectID, params.GroupID}]
+	if !ok {
+		return nil, store.NewStoreError(store.ErrGroupNotFound, nil)
+	}
+	r.GroupName = params.GroupName
+	r.ModifiedAt = now()
+	c := *r
+	return &c, nil
+}
+
+// DeleteGroup deletes a group along with its partials and send window and
+// clears the project's default group if it is the group. If the group has
+// templates an error of type store.ErrGroupNotEmpty is returned unless
+// force is set, in which case the templates are deleted too. If the group
+// does not exist an error of type store.ErrGroupNotFound is returned.
+func (s *Store) DeleteGroup(ctx context.Context, projectID, groupID string, force bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	gk := key{projectID, groupID}
+	if _, ok := s.groups[gk]; !ok {
+		return store.NewStoreError(store.ErrGroupNotFound, nil)
+	}
+	var templates []key
+	for k, r := range s.templates {
+		if r.ProjectID == projectID && r.GroupID == groupID {
+			templates = append(templates, k)
+		}
+	}
+	if len(templates) > 0 && !force {
+		return store.NewStoreError(store.ErrGroupNotEmpty, nil)
+	}
+
+	for _, k := range templates {
+		delete(s.templates, k)
+		delete(s.templateAttachments, k)
+		for vk, r := range s.variants {
+			if r.ProjectID == projectID && r.TemplateID == k.id {
+				delete(s.variants, vk)
+			}
+		}
+		for fk, r := range s.fixtures {
+			if r.ProjectID == projectID && r.TemplateID == k.id {
+				delete(s.fixtures, fk)
+			}
+		}
+	}
+	for k, r := range s.partials {
+		if r.ProjectID == projectID && r.GroupID == groupID {
+			delete(s.partials, k)
+		}
+	}
+	delete(s.sendWindows, gk)
+	if p, ok := s.projects[projectID]; ok && p.DefaultGroupID == groupID {
+		p.DefaultGroupID = ""
+	}
+	delete(s.groups, gk)
+	return nil
+}
+
+//
+// templates
+//
+
+// InsertTemplate inserts a new template into the store. If the group does
+// not exist within the project an error of type store.ErrGroupNotFound is
+// returned.
+func (s *Store) InsertTemplate(ctx context.Context, params store.AddTemplate) (*store.Template, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.insertTemplate(params)
+}
+
+func (s *Store) insertTemplate(params store.AddTemplate) (*store.Template, error) {
+	if _, ok := s.groups[key{params.ProjectID, params.GroupID}]; !ok {
+		return nil, store.NewStoreError(store.ErrGroupNotFound, nil)
+	}
+	k := key{params.ProjectID, params.TemplateID}
+	if _, ok := s.templates[k]; ok {
+		return nil, errors.Errorf("[memory:templates] template %q already exists", params.TemplateID)
+	}
+	ts := now()
+	r := &store.Template{
+		TemplateID: params.TemplateID,
+		GroupID:    params.GroupID,
+		ProjectID:  params.ProjectID,
+		Txt:        params.Txt,
+		TxtDigest:  params.TxtDigest,
+		HTML:       params.HTML,
+		HTMLDigest: params.HTMLDigest,
+		Subject:    params.Subject,
+		SourceType: params.SourceType,
+		Source:     params.Source,
+		AssetMode:  store.AssetModeCID,
+		Labels:     store.JSONObject{},
+		CreatedAt:  ts,
+		ModifiedAt: ts,
+	}
+	s.templates[k] = r
+	c := *r
+	return &c, nil
+}
+
+// SetTemplate creates the template if it does not exist, otherwise updates
+// its bodies if the digests differ from the stored ones and returns the
+// stored template. If the project does not exist an error of type
+// store.ErrProjectNotFound is returned.
+func (s *Store) SetTemplate(ctx context.Context, params store.SetTemplateParams) (*store.Template, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.projects[params.ProjectID]; !ok {
+		return nil, store.NewStoreError(store.ErrProjectNotFound, nil)
+	}
+	r, ok := s.templates[key{params.ProjectID, params.TemplateID}]
+	if !ok {
+		return s.insertTemplate(store.AddTemplate{
+			TemplateID: params.TemplateID,
+			GroupID:    params.GroupID,
+			ProjectID:  params.ProjectID,
+			Txt:        params.Txt,
+			TxtDigest:  params.TxtDigest,
+			HTML:       params.HTML,
+			HTMLDigest: params.HTMLDigest,
+			Subject:    params.Subject,
+			SourceType: params.SourceType,
+			Source:     params.Source,
+		})
+	}
+	if r.TxtDigest != params.TxtDigest || r.HTMLDigest != params.HTMLDigest || r.Subject != params.Subject {
+		r.Txt = params.Txt
+		r.TxtDigest = params.TxtDigest
+		r.HTML = params.HTML
+		r.HTMLDigest = params.HTMLDigest
+		r.Subject = params.Subject
+		r.SourceType = params.SourceType
+		r.Source = params.Source
+		r.ModifiedAt = now()
+	}
+	c := *r
+	return &c, nil
+}
+
+// GetTemplate gets a template from the store. If the project does not exist
+// an error of type store.ErrProjectNotFound is returned. If the template
+// does not exist an error of type store.ErrTemplateNotFound is returned.
+func (s *Store) GetTemplate(ctx context.Context, projectID, templateID string) (*store.Template, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if _, ok := s.projects[projectID]; !ok {
+		return nil, store.NewStoreError(store.ErrProjectNotFound, nil)
+	}
+	r, ok := s.templates[key{projectID, templateID}]
+	if !ok {
+		return nil, store.NewStoreError(store.ErrTemplateNotFound, nil)
+	}
+	c := *r
+	return &c, nil
+}
+
+// ListTemplates lists the templates of a project ordered by id, filtered
+// by group and by a substring of the id.
+func (s *Store) ListTemplates(ctx context.Context, params store.ListTemplates) ([]*store.Template, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	list := make([]*store.Template, 0)
+	for _, r := range sortedValues(s.templates, params.ProjectID) {
+		if params.GroupID != "" && r.GroupID != params.GroupID {
+			continue
+		}
+		if !hasLabels(r.Labels, params.Labels) {
+			continue
+		}
+		if !strings.Contains(r.TemplateID, params.Query) || r.TemplateID <= params.After {
+			continue
+		}
+		if params.Limit > 0 && len(list) == params.Limit {
+			break
+		}
+		list = append(list, r)
+	}
+	return list, nil
+}
+
+// SearchTemplates lists the templates of a project whose subject, text or
+// HTML contain query, ignoring case, ordered by id.
+func (s *Store) SearchTemplates(ctx context.Context, projectID, query string) ([]*store.Template, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	query = strings.ToLower(query)
+	list := make([]*store.Template, 0)
+	for _, r := range sortedValues(s.templates, projectID) {
+		if strings.Contains(strings.ToLower(r.Subject), query) ||
+			strings.Contains(strings.ToLower(r.Txt), query) ||
+			strings.Contains(strings.ToLower(r.HTML), query) {
+			list = append(list, r)
+		}
+	}
+	return list, nil
+}
+
+// DeleteTemplate deletes a template along with its locale variants, its
+// fixtures and its references to attachments. If the template does not
+// exist an error of type store.ErrTemplateNotFound is returned.
+func (s *Store) DeleteTemplate(ctx context.Context, projectID, templateID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	k := key{projectID, templateID}
+	if _, ok := s.templates[k]; !ok {
+		return store.NewStoreError(store.ErrTemplateNotFound, nil)
+	}
+	delete(s.templates, k)
+	delete(s.templateAttachments, k)
+	for vk, r := range s.variants {
+		if r.ProjectID == projectID && r.TemplateID == templateID {
+			delete(s.variants, vk)
+		}
+	}
+	for fk, r := range s.fixtures {
+		if r.ProjectID == projectID && r.TemplateID == templateID {
+			delete(s.fixtures, fk)
+		}
+	}
+	return nil
+}
+
+// SetTemplateAssetMode sets how a template references its assets. If the
+// template does not exist an error of type store.ErrTemplateNotFound is
+// returned.
+func (s *Store) SetTemplateAssetMode(ctx context.Context, projectID, templateID, assetMode string) (*store.Template, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r, ok := s.templates[key{projectID, templateID}]
+	if !ok {
+		return nil, store.NewStoreError(store.ErrTemplateNotFound, nil)
+	}
+	r.AssetMode = assetMode
+	r.ModifiedAt = now()
+	c := *r
+	return &c, nil
+}
+
+// SetTemplateLabels replaces the labels of a template. If the template
+// does not exist an error of type store.ErrTemplateNotFound is returned.
+func (s *Store) SetTemplateLabels(ctx context.Context, projectID, templateID string, labels store.JSONObject) (*store.Template, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r, ok := s.templates[key{projectID, templateID}]
+	if !ok {
+		return nil, store.NewStoreError(store.ErrTemplateNotFound, nil)
+	}
+	if labels == nil {
+		labels = store.JSONObject{}
+	}
+	r.Labels = maps.Clone(labels)
+	r.ModifiedAt = now()
+	c := *r
+	return &c, nil
+}
+
+//
+// send windows
+//
+
+// SetSendWindow creates or replaces the send window for a project and
+// group. If the project does not exist an error of type
+// store.ErrProjectNotFound is returned.
+func (s *Store) SetSendWindow(ctx context.Context, params store.SetSendWindow) (*store.SendWindow, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.projects[params.ProjectID]; !ok {
+		return nil, store.NewStoreError(store.ErrProjectNotFound, nil)
+	}
+	ts := now()
+	k := key{params.ProjectID, params.GroupID}
+	r, ok := s.sendWindows[k]
+	if !ok {
+		r = &store.SendWindow{
+			ProjectID: params.ProjectID,
+			GroupID:   params.GroupID,
+			CreatedAt: ts,
+		}
+		s.sendWindows[k] = r
+	}
+	r.StartTime = params.StartTime
+	r.EndTime = params.EndTime
+	r.Timezone = params.Timezone
+	r.ModifiedAt = ts
+	c := *r
+	return &c, nil
+}
+
+// ListSendWindows lists all the send windows for a project ordered by
+// group id.
+func (s *Store) ListSendWindows(ctx context.Context, projectID string) ([]*store.SendWindow, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return sortedValues(s.sendWindows, projectID), nil
+}
+
+// DeleteSendWindow deletes the send window for a project and group. If the
+// send window does not exist an error of type store.ErrSendWindowNotFound
+// is returned.
+func (s *Store) DeleteSendWindow(ctx context.Context, projectID, groupID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	k := key{projectID, groupID}
+	if _, ok := s.sendWindows[k]; !ok {
+		return store.NewStoreError(store.ErrSendWindowNotFound, nil)
+	}
+	delete(s.sendWindows, k)
+	return nil
+}
+
+//
+// rate limits
+//
+
+// SetRateLimit creates or replaces the rate limit for a project and
+// transport. If the project does not exist an error of type
+// store.ErrProjectNotFound is returned.
+func (s *Store) SetRateLimit(ctx context.Context, params store.SetRateLimit) (*store.RateLimit, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.projects[params.ProjectID]; !ok {
+		return nil, store.NewStoreError(store.ErrProjectNotFound, nil)
+	}
+	ts := now()
+	k := key{params.ProjectID, params.TransportID}
+	r, ok := s.rateLimits[k]
+	if !ok {
+		r = &store.RateLimit{
+			ProjectID:   params.ProjectID,
+			TransportID: params.TransportID,
+			CreatedAt:   ts,
+		}
+		s.rateLimits[k] = r
+	}
+	r.PerSecond = params.PerSecond
+	r.PerMinute = params.PerMinute
+	r.PerDay = params.PerDay
+	r.ModifiedAt = ts
+	c := *r
+	return &c, nil
+}
+
+// ListRateLimits lists all the rate limits for a project ordered by
+// transport id.
+func (s *Store) ListRateLimits(ctx context.Context, projectID string) ([]*store.RateLimit, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return sortedValues(s.rateLimits, projectID), nil
+}
+
+// DeleteRateLimit deletes the rate limit for a project and transport. If
+// the rate limit does not exist an error of type store.ErrRateLimitNotFound
+// is returned.
+func (s *Store) DeleteRateLimit(ctx context.Context, projectID, transportID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	k := key{projectID, transportID}
+	if _, ok := s.rateLimits[k]; !ok {
+		return store.NewStoreError(store.ErrRateLimitNotFound, nil)
+	}
+	delete(s.rateLimits, k)
+	return nil
+}
+
+//
+// template partials
+//
+
+// partialKey returns the key of a partial. Partials sort by group and then
+// by name.
+func partialKey(projectID, groupID, name string) key {
+	return key{projectID, groupID + "\x00" + name}
+}
+
+// SetPartial creates or replaces a partial template in a group. If the
+// group does not exist an error of type store.ErrGroupNotFound is returned.
+func (s *Store) SetPartial(ctx context.Context, params store.SetPartial) (*store.Partial, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.groups[key{params.ProjectID, params.GroupID}]; !ok {
+		return nil, store.NewStoreError(store.ErrGroupNotFound, nil)
+	}
+	ts := now()
+	k := partialKey(params.ProjectID, params.GroupID, params.PartialName)
+	r, ok := s.partials[k]
+	if !ok {
+		r = &store.Partial{
+			PartialName: params.PartialName,
+			GroupID:     params.GroupID,
+			ProjectID:   params.ProjectID,
+			CreatedAt:   ts,
+		}
+		s.partials[k] = r
+	}
+	r.Txt = params.Txt
+	r.HTML = params.HTML
+	r.ModifiedAt = ts
+	c := *r
+	return &c, nil
+}
+
+// ListPartials lists the partial templates in a group ordered by name.
+func (s *Store) ListPartials(ctx context.Context, projectID, groupID string) ([]*store.Partial, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	list := make([]*store.Partial, 0)
+	for _, r := range sortedValues(s.partials, projectID) {
+		if r.GroupID == groupID {
+			list = append(list, r)
+		}
+	}
+	return list, nil
+}
+
+// DeletePartial deletes a partial template from a group. If the partial
+// does not exist an error of type store.ErrPartialNotFound is returned.
+func (s *Store) DeletePartial(ctx context.Context, projectID, groupID, name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	k := partialKey(projectID, groupID, name)
+	if _, ok := s.partials[k]; !ok {
+		return store.NewStoreError(store.ErrPartialNotFound, nil)
+	}
+	delete(s.partials, k)
+	return nil
+}
+
+//
+// template variants
+//
+
+// variantKey returns the key of a template variant. Variants sort by
+// template and then by locale.
+func variantKey(projectID, templateID, locale string) key {
+	return key{projectID, templateID + "\x00" + locale}
+}
+
+// SetTemplateVariant creates or replaces the variant of a template for a
+// locale. If the template does not exist an error of type
+// store.ErrTemplateNotFound is returned.
+func (s *Store) SetTemplateVariant(ctx context.Context, params store.SetTemplateVariant) (*store.TemplateVariant, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.templates[key{params.ProjectID, params.TemplateID}]; !ok {
+		return nil, store.NewStoreError(store.ErrTemplateNotFound, nil)
+	}
+	ts := now()
+	k := variantKey(params.ProjectID, params.TemplateID, params.Locale)
+	r, ok := s.variants[k]
+	if !ok {
+		r = &store.TemplateVariant{
+			TemplateID: params.TemplateID,
+			ProjectID:  params.ProjectID,
+			Locale:     params.Locale,
+			CreatedAt:  ts,
+		}
+		s.variants[k] = r
+	}
+	r.Txt = params.Txt
+	r.TxtDigest = params.TxtDigest
+	r.HTML = params.HTML
+	r.HTMLDigest = params.HTMLDigest
+	r.Subject = params.Subject
+	r.ModifiedAt = ts
+	c := *r
+	return &c, nil
+}
+
+// ListTemplateVariants lists the variants of a template ordered by locale.
+func (s *Store) ListTemplateVariants(ctx context.Context, projectID, templateID string) ([]*store.TemplateVariant, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	list := make([]*store.TemplateVariant, 0)
+	for _, r := range sortedValues(s.variants, projectID) {
+		if r.TemplateID == templateID {
+			list = append(list, r)
+		}
+	}
+	return list, nil
+}
+
+// DeleteTemplateVariant deletes the variant of a template for a locale. If
+// the variant does not exist an error of type store.ErrVariantNotFound is
+// returned.
+func (s *Store) DeleteTemplateVariant(ctx context.Context, projectID, templateID, locale string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	k := variantKey(projectID, templateID, locale)
+	if _, ok := s.variants[k]; !ok {
+		return store.NewStoreError(store.ErrVariantNotFound, nil)
+	}
+	delete(s.variants, k)
+	return nil
+}
+
+//
+// template fixtures
+//
+
+// fixtureKey returns the key of a template fixture. Fixtures sort by
+// template and then by name.
+func fixtureKey(projectID, templateID, name string) key {
+	return key{projectID, templateID + "\x00" + name}
+}
+
+// cloneTemplateFixture returns a copy of r that shares no params with it.
+func cloneTemplateFixture(r *store.TemplateFixture) *store.TemplateFixture {
+	c := *r
+	c.Params = maps.Clone(r.Params)
+	return &c
+}
+
+// SetTemplateFixture creates or replaces the named fixture of a template.
+// If the template does not exist an error of type store.ErrTemplateNotFound
+// is returned.
+func (s *Store) SetTemplateFixture(ctx context.Context, params store.SetTemplateFixture) (*store.TemplateFixture, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.templates[key{params.ProjectID, params.TemplateID}]; !ok {
+		return nil, store.NewStoreError(store.ErrTemplateNotFound, nil)
+	}
+	ts := now()
+	k := fixtureKey(params.ProjectID, params.TemplateID, params.Name)
+	r, ok := s.fixtures[k]
+	if !ok {
+		r = &store.TemplateFixture{
+			TemplateID: params.TemplateID,
+			ProjectID:  params.ProjectID,
+			Name:       params.Name,
+			CreatedAt:  ts,
+		}
+		s.fixtures[k] = r
+	}
+	r.Params = maps.Clone(params.Params)
+	if r.Params == nil {
+		r.Params = store.JSONParams{}
+	}
+	r.ModifiedAt = ts
+	return cloneTemplateFixture(r), nil
+}
+
+// GetTemplateFixture retrieves the named fixture of a template. If the
+// fixture does not exist an error of type store.ErrFixtureNotFound is
+// returned.
+func (s *Store) GetTemplateFixture(ctx context.Context, projectID, templateID, name string) (*store.TemplateFixture, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	r, ok := s.fixtures[fixtureKey(projectID, templateID, name)]
+	if !ok {
+		return nil, store.NewStoreError(store.ErrFixtureNotFound, nil)
+	}
+	return cloneTemplateFixture(r), nil
+}
+
+// ListTemplateFixtures lists the fixtures of a template ordered by name.
+func (s *Store) ListTemplateFixtures(ctx context.Context, projectID, templateID string) ([]*store.TemplateFixture, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	list := make([]*store.TemplateFixture, 0)
+	for _, r := range sortedValues(s.fixtures, projectID) {
+		if r.TemplateID == templateID {
+			list = append(list, cloneTemplateFixture(r))
+		}
+	}
+	return list, nil
+}
+
+// DeleteTemplateFixture deletes the named fixture of a template. If the
+// fixture does not exist an error of type store.ErrFixtureNotFound is
+// returned.
+func (s *Store) DeleteTemplateFixture(ctx context.Context, projectID, templateID, name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	k := fixtureKey(projectID, templateID, name)
+	if _, ok := s.fixtures[k]; !ok {
+		return store.NewStoreError(store.ErrFixtureNotFound, nil)
+	}
+	delete(s.fixtures, k)
+	return nil
+}
+
+//
+// message catalogs
+//
+
+// SetMessageCatalog creates or replaces the message catalog for a project
+// and locale. If the project does not exist an error of type
+// store.ErrProjectNotFound is returned.
+func (s *Store) SetMessageCatalog(ctx context.Context, params store.SetMessageCatalog) (*store.MessageCatalog, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.projects[params.ProjectID]; !ok {
+		return nil, store.NewStoreError(store.ErrProjectNotFound, nil)
+	}
+	ts := now()
+	k := key{params.ProjectID, params.Locale}
+	r, ok := s.catalogs[k]
+	if !ok {
+		r = &store.MessageCatalog{
+			ProjectID: params.ProjectID,
+			Locale:    params.Locale,
+			CreatedAt: ts,
+		}
+		s.catalogs[k] = r
+	}
+	r.Messages = params.Messages
+	r.ModifiedAt = ts
+	c := *r
+	return &c, nil
+}
+
+// ListMessageCatalogs lists all the message catalogs for a project ordered
+// by locale.
+func (s *Store) ListMessageCatalogs(ctx context.Context, projectID string) ([]*store.MessageCatalog, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return sortedValues(s.catalogs, projectID), nil
+}
+
+// DeleteMessageCatalog deletes the message catalog for a project and
+// locale. If the catalog does not exist an error of type
+// store.ErrCatalogNotFound is returned.
+func (s *Store) DeleteMessageCatalog(ctx context.Context, projectID, locale string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	k := key{projectID, locale}
+	if _, ok := s.catalogs[k]; !ok {
+		return store.NewStoreError(store.ErrCatalogNotFound, nil)
+	}
+	delete(s.catalogs, k)
+	return nil
+}
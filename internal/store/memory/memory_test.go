@@ -0,0 +1,285 @@
+package memory_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/andyfusniak/squishy-mailer-lite/internal/store"
+	"github.com/andyfusniak/squishy-mailer-lite/internal/store/memory"
+	"github.com/stretchr/testify/assert"
+)
+
+func assertStoreErrorCode(t *testing.T, err error, code store.ErrCode) {
+	t.Helper()
+
+	var serr *store.Error
+	if !errors.As(err, &serr) {
+		t.Fatalf("expected err to be of type *store.Error got %+v", err)
+	}
+	assert.Equal(t, code, serr.Code)
+}
+
+func TestInsertProject(t *testing.T) {
+	st := memory.New()
+
+	ctx := context.Background()
+	obj, err := st.InsertProject(ctx, store.AddProject{
+		ProjectID:   "test-project",
+		ProjectName: "Test Project",
+		Description: "A test project",
+	})
+	if err != nil {
+		t.Fatalf("st.InsertProject failed: %+v", err)
+	}
+	assert.Equal(t, "test-project", obj.ProjectID)
+	assert.Equal(t, "Test Project", obj.ProjectName)
+	assert.Equal(t, "A test project", obj.Description)
+	assert.Equal(t, store.JSONArray{}, obj.AllowedRecipientDomains)
+	assert.WithinDuration(t, time.Now(), time.Time(obj.CreatedAt), time.Second)
+
+	_, err = st.InsertProject(ctx, store.AddProject{ProjectID: "test-project"})
+	assertStoreErrorCode(t, err, store.ErrProjectAlreadyExists)
+
+	_, err = st.GetProject(ctx, "non-existent-project")
+	assertStoreErrorCode(t, err, store.ErrProjectNotFound)
+}
+
+func TestProjectScoping(t *testing.T) {
+	st := memory.New()
+
+	ctx := context.Background()
+	for _, id := range []string{"pa", "pb"} {
+		if _, err := st.InsertProject(ctx, store.AddProject{ProjectID: id}); err != nil {
+			t.Fatalf("st.InsertProject failed: %+v", err)
+		}
+	}
+
+	_, err := st.InsertGroup(ctx, store.AddGroup{GroupID: "g1", ProjectID: "non-existent-project"})
+	assertStoreErrorCode(t, err, store.ErrProjectNotFound)
+
+	if _, err := st.InsertGroup(ctx, store.AddGroup{GroupID: "g1", ProjectID: "pa"}); err != nil {
+		t.Fatalf("st.InsertGroup failed: %+v", err)
+	}
+	_, err = st.GetGroup(ctx, "pb", "g1")
+	assertStoreErrorCode(t, err, store.ErrGroupNotFound)
+
+	// a template cannot use a group from another project
+	_, err = st.InsertTemplate(ctx, store.AddTemplate{TemplateID: "t1", GroupID: "g1", ProjectID: "pb"})
+	assertStoreErrorCode(t, err, store.ErrGroupNotFound)
+
+	if _, err := st.InsertSMTPTransport(ctx, store.AddSMTPTransport{
+		SMTPTransportID: "tr1",
+		ProjectID:       "pa",
+	}); err != nil {
+		t.Fatalf("st.InsertSMTPTransport failed: %+v", err)
+	}
+	_, err = st.GetSMTPTransport(ctx, "tr1", "pb")
+	assert.ErrorIs(t, err, store.ErrTransportNotFound)
+}
+
+func TestSetTemplate(t *testing.T) {
+	st := memory.New()
+
+	ctx := context.Background()
+	if _, err := st.InsertProject(ctx, store.AddProject{ProjectID: "p1"}); err != nil {
+		t.Fatalf("st.InsertProject failed: %+v", err)
+	}
+	if _, err := st.InsertGroup(ctx, store.AddGroup{GroupID: "g1", ProjectID: "p1"}); err != nil {
+		t.Fatalf("st.InsertGroup failed: %+v", err)
+	}
+
+	params := store.SetTemplateParams{
+		TemplateID: "t1",
+		GroupID:    "g1",
+		ProjectID:  "p1",
+		Txt:        "text",
+		TxtDigest:  "d1",
+		HTML:       "html",
+		HTMLDigest: "d2",
+	}
+	created, err := st.SetTemplate(ctx, params)
+	if err != nil {
+		t.Fatalf("st.SetTemplate failed: %+v", err)
+	}
+	assert.Equal(t, store.AssetModeCID, created.AssetMode)
+
+	// the same digests leave the template untouched and return the stored
+	// template
+	stale := params
+	stale.Txt = "stale text"
+	same, err := st.SetTemplate(ctx, stale)
+	if err != nil {
+		t.Fatalf("st.SetTemplate failed: %+v", err)
+	}
+	assert.Equal(t, created.ModifiedAt, same.ModifiedAt)
+	assert.Equal(t, "text", same.Txt)
+
+	params.Txt, params.TxtDigest = "new text", "d3"
+	updated, err := st.SetTemplate(ctx, params)
+	if err != nil {
+		t.Fatalf("st.SetTemplate failed: %+v", err)
+	}
+	assert.Equal(t, "new text", updated.Txt)
+}
+
+func TestDeleteSMTPTransportTransportChain(t *testing.T) {
+	st := memory.New()
+
+	ctx := context.Background()
+	if _, err := st.InsertProject(ctx, store.AddProject{ProjectID: "p1"}); err != nil {
+		t.Fatalf("st.InsertProject failed: %+v", err)
+	}
+	for _, id := range []string{"tr1", "tr2", "tr3"} {
+		if _, err := st.InsertSMTPTransport(ctx, store.AddSMTPTransport{
+			SMTPTransportID: id,
+			ProjectID:       "p1",
+			Host:            "localhost",
+			Port:            25,
+			EmailFrom:       "from@example.com",
+		}); err != nil {
+			t.Fatalf("st.InsertSMTPTransport failed: %+v", err)
+		}
+	}
+	if _, err := st.SetProjectTransportChain(ctx, "p1", store.JSONArray{"tr1", "tr2", "tr3"}); err != nil {
+		t.Fatalf("st.SetProjectTransportChain failed: %+v", err)
+	}
+
+	// the deleted transport is removed from the chain keeping the order
+	if err := st.DeleteSMTPTransport(ctx, "tr2", "p1", false); err != nil {
+		t.Fatalf("st.DeleteSMTPTransport failed: %+v", err)
+	}
+	project, err := st.GetProject(ctx, "p1")
+	if err != nil {
+		t.Fatalf("st.GetProject failed: %+v", err)
+	}
+	assert.Equal(t, store.JSONArray{"tr1", "tr3"}, project.TransportChain)
+}
+
+func TestClaimMailQueue(t *testing.T) {
+	st := memory.New()
+
+	ctx := context.Background()
+	if _, err := st.InsertProject(ctx, store.AddProject{ProjectID: "p1"}); err != nil {
+		t.Fatalf("st.InsertProject failed: %+v", err)
+	}
+
+	for _, id := range []string{"mq1", "mq2", "mq3"} {
+		if _, err := st.InsertMailQueue(ctx, store.AddMailQueue{
+			MailQueueID: id,
+			ProjectID:   "p1",
+			TemplateID:  "t1",
+			TransportID: "tr1",
+			MState:      store.MailQueueStateQueued,
+			Metadata:    store.MailQueueMetadata{To: []string{"to@example.com"}, Subject: "Subject"},
+			Body:        store.MailQueueBody{Txt: "text", HTML: "<p>html</p>"},
+		}); err != nil {
+			t.Fatalf("st.InsertMailQueue failed: %+v", err)
+		}
+	}
+
+	list, err := st.ClaimMailQueue(ctx, 2, time.Hour)
+	if err != nil {
+		t.Fatalf("st.ClaimMailQueue failed: %+v", err)
+	}
+	if assert.Len(t, list, 2) {
+		assert.Equal(t, "mq1", list[0].MailQueueID)
+		assert.Equal(t, "mq2", list[1].MailQueueID)
+		assert.Equal(t, store.MailQueueStateSending, list[0].MState)
+	}
+
+	list, err = st.ClaimMailQueue(ctx, 2, time.Hour)
+	if err != nil {
+		t.Fatalf("st.ClaimMailQueue failed: %+v", err)
+	}
+	if assert.Len(t, list, 1) {
+		assert.Equal(t, "mq3", list[0].MailQueueID)
+	}
+
+	since := store.Datetime(time.Now().Add(-time.Minute))
+	if _, err := st.UpdateMailQueueState(ctx, store.UpdateMailQueueState{
+		MailQueueID: "mq3",
+		MState:      store.MailQueueStateSent,
+		Body:        &store.MailQueueBody{Redacted: true},
+	}); err != nil {
+		t.Fatalf("st.UpdateMailQueueState failed: %+v", err)
+	}
+	n, err := st.CountMailQueueSent(ctx, "p1", "tr1", since)
+	if err != nil {
+		t.Fatalf("st.CountMailQueueSent failed: %+v", err)
+	}
+	assert.Equal(t, 1, n)
+
+	// deferred emails are not claimed until they are due
+	next := store.Datetime(time.Now().Add(time.Hour))
+	if _, err := st.UpdateMailQueueState(ctx, store.UpdateMailQueueState{
+		MailQueueID:   "mq1",
+		MState:        store.MailQueueStateQueued,
+		NextAttemptAt: &next,
+	}); err != nil {
+		t.Fatalf("st.UpdateMailQueueState failed: %+v", err)
+	}
+	list, err = st.ClaimMailQueue(ctx, 2, time.Hour)
+	if err != nil {
+		t.Fatalf("st.ClaimMailQueue failed: %+v", err)
+	}
+	assert.Empty(t, list)
+
+	// mq2 is still sending so it is reclaimed once its lease expires
+	time.Sleep(time.Millisecond)
+	list, err = st.ClaimMailQueue(ctx, 2, time.Microsecond)
+	if err != nil {
+		t.Fatalf("st.ClaimMailQueue failed: %+v", err)
+	}
+	if assert.Len(t, list, 1) {
+		assert.Equal(t, "mq2", list[0].MailQueueID)
+	}
+}
+
+func TestDeleteAttachment(t *testing.T) {
+	st := memory.New()
+
+	ctx := context.Background()
+	if _, err := st.InsertProject(ctx, store.AddProject{ProjectID: "p1"}); err != nil {
+		t.Fatalf("st.InsertProject failed: %+v", err)
+	}
+	if _, err := st.InsertGroup(ctx, store.AddGroup{GroupID: "g1", ProjectID: "p1"}); err != nil {
+		t.Fatalf("st.InsertGroup failed: %+v", err)
+	}
+	if _, err := st.InsertTemplate(ctx, store.AddTemplate{TemplateID: "t1", GroupID: "g1", ProjectID: "p1"}); err != nil {
+		t.Fatalf("st.InsertTemplate failed: %+v", err)
+	}
+	for _, id := range []string{"a1", "a2"} {
+		if _, err := st.SetAttachment(ctx, store.SetAttachment{
+			AttachmentID: id,
+			ProjectID:    "p1",
+			Filename:     id + ".txt",
+			Content:      []byte(id),
+		}); err != nil {
+			t.Fatalf("st.SetAttachment failed: %+v", err)
+		}
+	}
+
+	err := st.SetTemplateAttachments(ctx, "p1", "t1", []string{"a2", "missing"})
+	assertStoreErrorCode(t, err, store.ErrAttachmentNotFound)
+
+	if err := st.SetTemplateAttachments(ctx, "p1", "t1", []string{"a2", "a1"}); err != nil {
+		t.Fatalf("st.SetTemplateAttachments failed: %+v", err)
+	}
+	if err := st.DeleteAttachment(ctx, "p1", "a2"); err != nil {
+		t.Fatalf("st.DeleteAttachment failed: %+v", err)
+	}
+
+	list, err := st.ListTemplateAttachments(ctx, "p1", "t1")
+	if err != nil {
+		t.Fatalf("st.ListTemplateAttachments failed: %+v", err)
+	}
+	if assert.Len(t, list, 1) {
+		assert.Equal(t, "a1", list[0].AttachmentID)
+		assert.Equal(t, []byte("a1"), list[0].Content)
+	}
+
+	err = st.DeleteAttachment(ctx, "p1", "a2")
+	assertStoreErrorCode(t, err, store.ErrAttachmentNotFound)
+}
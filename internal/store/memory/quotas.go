@@ -0,0 +1,113 @@
+package memory
+
+import (
+	"context"
+
+	"github.com/andyfusniak/squishy-mailer-lite/internal/store"
+)
+
+// SetProjectQuota creates or replaces the quota of a project. If the
+// project does not exist an error of type store.ErrProjectNotFound is
+// returned.
+func (s *Store) SetProjectQuota(ctx context.Context, params store.SetProjectQuota) (*store.ProjectQuota, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.projects[params.ProjectID]; !ok {
+		return nil, store.NewStoreError(store.ErrProjectNotFound, nil)
+	}
+	ts := now()
+	k := key{params.ProjectID, ""}
+	r, ok := s.quotas[k]
+	if !ok {
+		r = &store.ProjectQuota{
+			ProjectID: params.ProjectID,
+			CreatedAt: ts,
+		}
+		s.quotas[k] = r
+	}
+	r.PerDay = params.PerDay
+	r.PerMonth = params.PerMonth
+	r.Action = params.Action
+	r.ModifiedAt = ts
+	c := *r
+	return &c, nil
+}
+
+// GetProjectQuota gets the quota of a project. If the project has no
+// quota an error of type store.ErrProjectQuotaNotFound is returned.
+func (s *Store) GetProjectQuota(ctx context.Context, projectID string) (*store.ProjectQuota, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	r, ok := s.quotas[key{projectID, ""}]
+	if !ok {
+		return nil, store.NewStoreError(store.ErrProjectQuotaNotFound, nil)
+	}
+	c := *r
+	return &c, nil
+}
+
+// DeleteProjectQuota deletes the quota of a project. If the project has
+// no quota an error of type store.ErrProjectQuotaNotFound is returned.
+func (s *Store) DeleteProjectQuota(ctx context.Context, projectID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	k := key{projectID, ""}
+	if _, ok := s.quotas[k]; !ok {
+		return store.NewStoreError(store.ErrProjectQuotaNotFound, nil)
+	}
+	delete(s.quotas, k)
+	return nil
+}
+
+// GetProjectQuotaUsage returns the number of emails counted for a project
+// on a day and in a month.
+func (s *Store) GetProjectQuotaUsage(ctx context.Context, projectID, day, month string) (*store.ProjectQuotaUsage, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return &store.ProjectQuotaUsage{
+		ProjectID: projectID,
+		Day:       day,
+		Month:     month,
+		DaySent:   s.quotaUsage[key{projectID, day}],
+		MonthSent: s.quotaUsage[key{projectID, month}],
+	}, nil
+}
+
+// ReserveProjectQuota counts an email for a project on a day and in a
+// month unless either would go over its limit, and reports whether it was
+// counted.
+func (s *Store) ReserveProjectQuota(ctx context.Context, params store.ReserveProjectQuota) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.projects[params.ProjectID]; !ok {
+		return false, store.NewStoreError(store.ErrProjectNotFound, nil)
+	}
+	day := key{params.ProjectID, params.Day}
+	month := key{params.ProjectID, params.Month}
+	if (params.PerDay > 0 && s.quotaUsage[day] >= params.PerDay) ||
+		(params.PerMonth > 0 && s.quotaUsage[month] >= params.PerMonth) {
+		return false, nil
+	}
+	s.quotaUsage[day]++
+	s.quotaUsage[month]++
+	return true, nil
+}
+
+// ReleaseProjectQuota uncounts an email counted by ReserveProjectQuota
+// that was not sent after all. The usage never drops below zero.
+func (s *Store) ReleaseProjectQuota(ctx context.Context, projectID, day, month string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, k := range []key{{projectID, day}, {projectID, month}} {
+		if s.quotaUsage[k] > 0 {
+			s.quotaUsage[k]--
+		}
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package memory
+
+import (
+	"context"
+	"slices"
+
+	"github.com/andyfusniak/squishy-mailer-lite/internal/store"
+)
+
+// SetSenderAllowList creates or replaces the sender allow-list of a
+// transport. If the project does not exist an error of type
+// store.ErrProjectNotFound is returned.
+func (s *Store) SetSenderAllowList(ctx context.Context, params store.SetSenderAllowList) (*store.SenderAllowList, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.projects[params.ProjectID]; !ok {
+		return nil, store.NewStoreError(store.ErrProjectNotFound, nil)
+	}
+	ts := now()
+	k := key{params.ProjectID, params.TransportID}
+	r, ok := s.senderAllowLists[k]
+	if !ok {
+		r = &store.SenderAllowList{
+			ProjectID:   params.ProjectID,
+			TransportID: params.TransportID,
+			CreatedAt:   ts,
+		}
+		s.senderAllowLists[k] = r
+	}
+	r.Emails = slices.Clone(params.Emails)
+	r.ModifiedAt = ts
+	return cloneSenderAllowList(r), nil
+}
+
+// GetSenderAllowList gets the sender allow-list of a transport. If the
+// transport has no allow-list an error of type
+// store.ErrSenderAllowListNotFound is returned.
+func (s *Store) GetSenderAllowList(ctx context.Context, projectID, transportID string) (*store.SenderAllowList, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	r, ok := s.senderAllowLists[key{projectID, transportID}]
+	if !ok {
+		return nil, store.NewStoreError(store.ErrSenderAllowListNotFound, nil)
+	}
+	return cloneSenderAllowList(r), nil
+}
+
+// DeleteSenderAllowList deletes the sender allow-list of a transport. If
+// the transport has no allow-list an error of type
+// store.ErrSenderAllowListNotFound is returned.
+func (s *Store) DeleteSenderAllowList(ctx context.Context, projectID, transportID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	k := key{projectID, transportID}
+	if _, ok := s.senderAllowLists[k]; !ok {
+		return store.NewStoreError(store.ErrSenderAllowListNotFound, nil)
+	}
+	delete(s.senderAllowLists, k)
+	return nil
+}
+
+func cloneSenderAllowList(r *store.SenderAllowList) *store.SenderAllowList {
+	c := *r
+	c.Emails = slices.Clone(r.Emails)
+	return &c
+}
@@ -0,0 +1,42 @@
+package memory
+
+import (
+	"context"
+
+	"github.com/andyfusniak/squishy-mailer-lite/internal/store"
+)
+
+// GetSetting gets a setting by name. If there is no such setting an error
+// of type store.ErrSettingNotFound is returned.
+func (s *Store) GetSetting(ctx context.Context, name string) (*store.Setting, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	r, ok := s.settings[name]
+	if !ok {
+		return nil, store.NewStoreError(store.ErrSettingNotFound, nil)
+	}
+	c := *r
+	return &c, nil
+}
+
+// EnsureSetting inserts a setting unless one of the same name exists, and
+// returns the stored setting.
+func (s *Store) EnsureSetting(ctx context.Context, name, value string) (*store.Setting, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r, ok := s.settings[name]
+	if !ok {
+		ts := now()
+		r = &store.Setting{
+			Name:       name,
+			Value:      value,
+			CreatedAt:  ts,
+			ModifiedAt: ts,
+		}
+		s.settings[name] = r
+	}
+	c := *r
+	return &c, nil
+}
@@ -0,0 +1,210 @@
+package memory
+
+import (
+	"context"
+	"sort"
+
+	"github.com/andyfusniak/squishy-mailer-lite/internal/store"
+	"github.com/pkg/errors"
+)
+
+// ReadSnapshot returns a copy of every record in the store along with the
+// queued and sending emails.
+func (s *Store) ReadSnapshot(ctx context.Context) (*store.Snapshot, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var snap store.Snapshot
+
+	projectIDs := make([]string, 0, len(s.projects))
+	for id := range s.projects {
+		projectIDs = append(projectIDs, id)
+	}
+	sort.Strings(projectIDs)
+	for _, id := range projectIDs {
+		s.readProjectSnapshot(&snap, id)
+	}
+
+	// only the emails still waiting to be delivered are included
+	pending := make([]*mailQueueRow, 0)
+	for _, row := range s.mailQueue {
+		if row.MState == store.MailQueueStateQueued || row.MState == store.MailQueueStateRateLimited ||
+			row.MState == store.MailQueueStateSending || row.MState == store.MailQueueStatePaused {
+			pending = append(pending, row)
+		}
+	}
+	sortMailQueueRows(pending)
+	for _, row := range pending {
+		snap.MailQueue = append(snap.MailQueue, cloneMailQueue(&row.MailQueue))
+	}
+
+	return &snap, nil
+}
+
+// RestoreSnapshot writes a snapshot into an empty store keeping the ids and
+// timestamps of every record. If the store already has a project an error
+// of type store.ErrStoreNotEmpty is returned.
+func (s *Store) RestoreSnapshot(ctx context.Context, snap *store.Snapshot) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.projects) > 0 {
+		return store.NewStoreError(store.ErrStoreNotEmpty,
+			errors.Errorf("store has %d projects", len(s.projects)))
+	}
+
+	s.restoreSnapshot(snap)
+	return nil
+}
+
+// restoreSnapshot writes every record of snap keeping its ids and
+// timestamps.
+func (s *Store) restoreSnapshot(snap *store.Snapshot) {
+	for _, r := range snap.Projects {
+		s.projects[r.ProjectID] = cloneProject(r)
+	}
+	for _, r := range snap.SMTPTransports {
+		s.transports[key{r.ProjectID, r.SMTPTransportID}] = cloneSMTPTransport(r)
+	}
+	for _, r := range snap.APITransports {
+		s.apiTransports[key{r.ProjectID, r.APITransportID}] = cloneAPITransport(r)
+	}
+	for _, r := range snap.Groups {
+		c := *r
+		s.groups[key{r.ProjectID, r.GroupID}] = &c
+	}
+	for _, r := range snap.Templates {
+		c := *r
+		s.templates[key{r.ProjectID, r.TemplateID}] = &c
+	}
+	for _, r := range snap.Partials {
+		c := *r
+		s.partials[partialKey(r.ProjectID, r.GroupID, r.PartialName)] = &c
+	}
+	for _, r := range snap.TemplateVariants {
+		c := *r
+		s.variants[variantKey(r.ProjectID, r.TemplateID, r.Locale)] = &c
+	}
+	for _, r := range snap.TemplateFixtures {
+		s.fixtures[fixtureKey(r.ProjectID, r.TemplateID, r.Name)] = cloneTemplateFixture(r)
+	}
+	for _, r := range snap.SendWindows {
+		c := *r
+		s.sendWindows[key{r.ProjectID, r.GroupID}] = &c
+	}
+	for _, r := range snap.RateLimits {
+		c := *r
+		s.rateLimits[key{r.ProjectID, r.TransportID}] = &c
+	}
+	for _, r := range snap.SenderAllowLists {
+		s.senderAllowLists[key{r.ProjectID, r.TransportID}] = cloneSenderAllowList(r)
+	}
+	for _, r := range snap.MessageCatalogs {
+		c := *r
+		s.catalogs[key{r.ProjectID, r.Locale}] = &c
+	}
+	for _, r := range snap.Attachments {
+		s.attachments[key{r.ProjectID, r.AttachmentID}] = cloneAttachment(r)
+	}
+	for _, r := range snap.Assets {
+		s.assets[key{r.ProjectID, r.AssetID}] = cloneAsset(r)
+	}
+
+	refs := append([]*store.TemplateAttachment(nil), snap.TemplateAttachments...)
+	sort.SliceStable(refs, func(i, j int) bool { return refs[i].Position < refs[j].Position })
+	for _, r := range refs {
+		k := key{r.ProjectID, r.TemplateID}
+		s.templateAttachments[k] = append(s.templateAttachments[k], r.AttachmentID)
+	}
+
+	for _, r := range snap.MailQueue {
+		s.seq++
+		s.mailQueue[r.MailQueueID] = &mailQueueRow{
+			MailQueue: *cloneMailQueue(r),
+			seq:       s.seq,
+		}
+	}
+}
+
+// ReadProjectSnapshot returns a copy of a project and the records that
+// belong to it, without its mail queue. If the project does not exist an
+// error of type store.ErrProjectNotFound is returned.
+func (s *Store) ReadProjectSnapshot(ctx context.Context, projectID string) (*store.Snapshot, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if _, ok := s.projects[projectID]; !ok {
+		return nil, store.NewStoreError(store.ErrProjectNotFound,
+			errors.Errorf("project %q not found", projectID))
+	}
+	var snap store.Snapshot
+	s.readProjectSnapshot(&snap, projectID)
+	return &snap, nil
+}
+
+// readProjectSnapshot appends a copy of the project with the given id and
+// the records that belong to it to snap.
+func (s *Store) readProjectSnapshot(snap *store.Snapshot, id string) {
+	snap.Projects = append(snap.Projects, cloneProject(s.projects[id]))
+	for _, r := range sortedValues(s.transports, id) {
+		snap.SMTPTransports = append(snap.SMTPTransports, cloneSMTPTransport(r))
+	}
+	for _, r := range sortedValues(s.apiTransports, id) {
+		snap.APITransports = append(snap.APITransports, cloneAPITransport(r))
+	}
+	snap.Groups = append(snap.Groups, sortedValues(s.groups, id)...)
+	snap.Templates = append(snap.Templates, sortedValues(s.templates, id)...)
+	snap.Partials = append(snap.Partials, sortedValues(s.partials, id)...)
+	snap.TemplateVariants = append(snap.TemplateVariants, sortedValues(s.variants, id)...)
+	for _, r := range sortedValues(s.fixtures, id) {
+		snap.TemplateFixtures = append(snap.TemplateFixtures, cloneTemplateFixture(r))
+	}
+	snap.SendWindows = append(snap.SendWindows, sortedValues(s.sendWindows, id)...)
+	snap.RateLimits = append(snap.RateLimits, sortedValues(s.rateLimits, id)...)
+	for _, r := range sortedValues(s.senderAllowLists, id) {
+		snap.SenderAllowLists = append(snap.SenderAllowLists, cloneSenderAllowList(r))
+	}
+	snap.MessageCatalogs = append(snap.MessageCatalogs, sortedValues(s.catalogs, id)...)
+	for _, r := range sortedValues(s.attachments, id) {
+		snap.Attachments = append(snap.Attachments, cloneAttachment(r))
+	}
+	for _, r := range sortedValues(s.assets, id) {
+		snap.Assets = append(snap.Assets, cloneAsset(r))
+	}
+
+	templateIDs := make([]string, 0)
+	for k := range s.templateAttachments {
+		if k.projectID == id {
+			templateIDs = append(templateIDs, k.id)
+		}
+	}
+	sort.Strings(templateIDs)
+	for _, templateID := range templateIDs {
+		for i, attachmentID := range s.templateAttachments[key{id, templateID}] {
+			snap.TemplateAttachments = append(snap.TemplateAttachments, &store.TemplateAttachment{
+				ProjectID:    id,
+				TemplateID:   templateID,
+				AttachmentID: attachmentID,
+				Position:     i,
+			})
+		}
+	}
+}
+
+// ImportProjectSnapshot writes a snapshot of projects that are not yet in
+// the store keeping the ids and timestamps of every record. If one of the
+// projects already exists an error of type store.ErrProjectAlreadyExists is
+// returned.
+func (s *Store) ImportProjectSnapshot(ctx context.Context, snap *store.Snapshot) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, r := range snap.Projects {
+		if _, ok := s.projects[r.ProjectID]; ok {
+			return store.NewStoreError(store.ErrProjectAlreadyExists,
+				errors.Errorf("project %q already exists", r.ProjectID))
+		}
+	}
+	s.restoreSnapshot(snap)
+	return nil
+}
@@ -0,0 +1,91 @@
+package memory
+
+import (
+	"context"
+	"strings"
+
+	"github.com/andyfusniak/squishy-mailer-lite/internal/store"
+)
+
+// SetSuppression adds an address to the suppression list of a project or
+// replaces its reason and detail. The address is stored in lower case. If
+// the project does not exist an error of type store.ErrProjectNotFound is
+// returned.
+func (s *Store) SetSuppression(ctx context.Context, params store.SetSuppression) (*store.Suppression, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.projects[params.ProjectID]; !ok {
+		return nil, store.NewStoreError(store.ErrProjectNotFound, nil)
+	}
+	addr := strings.ToLower(params.Email)
+	k := key{params.ProjectID, addr}
+	ts := now()
+	r, ok := s.suppressions[k]
+	if !ok {
+		r = &store.Suppression{
+			ProjectID: params.ProjectID,
+			Email:     addr,
+			CreatedAt: ts,
+		}
+		s.suppressions[k] = r
+	}
+	r.Reason = params.Reason
+	r.Detail = params.Detail
+	r.ModifiedAt = ts
+	c := *r
+	return &c, nil
+}
+
+// FindSuppressions returns the suppressions of a project for any of the
+// given addresses, ordered by address. Addresses are matched without
+// regard to case.
+func (s *Store) FindSuppressions(ctx context.Context, projectID string, emails []string) ([]*store.Suppression, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	want := make(map[string]bool, len(emails))
+	for _, e := range emails {
+		want[strings.ToLower(e)] = true
+	}
+	list := make([]*store.Suppression, 0)
+	for _, r := range sortedValues(s.suppressions, projectID) {
+		if want[r.Email] {
+			list = append(list, r)
+		}
+	}
+	return list, nil
+}
+
+// ListSuppressions lists the suppressions of a project ordered by address.
+func (s *Store) ListSuppressions(ctx context.Context, params store.ListSuppressions) ([]*store.Suppression, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	list := make([]*store.Suppression, 0)
+	for _, r := range sortedValues(s.suppressions, params.ProjectID) {
+		if r.Email <= params.After {
+			continue
+		}
+		if params.Limit > 0 && len(list) == params.Limit {
+			break
+		}
+		list = append(list, r)
+	}
+	return list, nil
+}
+
+// DeleteSuppression removes an address from the suppression list of a
+// project. If the address is not on the list an error of type
+// store.ErrSuppressionNotFound is returned.
+func (s *Store) DeleteSuppression(ctx context.Context, projectID, email string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	k := key{projectID, strings.ToLower(email)}
+	if _, ok := s.suppressions[k]; !ok {
+		return store.NewStoreError(store.ErrSuppressionNotFound, nil)
+	}
+	delete(s.suppressions, k)
+	return nil
+}
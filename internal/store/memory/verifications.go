@@ -0,0 +1,49 @@
+package memory
+
+import (
+	"context"
+	"sort"
+
+	"github.com/andyfusniak/squishy-mailer-lite/internal/store"
+)
+
+// SetTransportVerification records the outcome of the latest verification
+// of a transport. If the project does not exist an error of type
+// store.ErrProjectNotFound is returned.
+func (s *Store) SetTransportVerification(ctx context.Context, params store.SetTransportVerification) (*store.TransportVerification, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.projects[params.ProjectID]; !ok {
+		return nil, store.NewStoreError(store.ErrProjectNotFound, nil)
+	}
+	r := store.TransportVerification{
+		ProjectID:   params.ProjectID,
+		TransportID: params.TransportID,
+		Error:       params.Error,
+		VerifiedAt:  now(),
+	}
+	s.verifications[key{params.ProjectID, params.TransportID}] = &r
+	c := r
+	return &c, nil
+}
+
+// ListTransportVerifications lists the latest verification of every
+// transport ordered by project id then transport id.
+func (s *Store) ListTransportVerifications(ctx context.Context) ([]*store.TransportVerification, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	list := make([]*store.TransportVerification, 0, len(s.verifications))
+	for _, r := range s.verifications {
+		c := *r
+		list = append(list, &c)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].ProjectID != list[j].ProjectID {
+			return list[i].ProjectID < list[j].ProjectID
+		}
+		return list[i].TransportID < list[j].TransportID
+	})
+	return list, nil
+}
@@ -0,0 +1,203 @@
+package memory
+
+import (
+	"context"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/andyfusniak/squishy-mailer-lite/internal/store"
+)
+
+// webhookDeliveryRow is a webhook delivery along with the sequence number
+// that orders deliveries created at the same time.
+type webhookDeliveryRow struct {
+	store.WebhookDelivery
+	seq int64
+}
+
+func cloneWebhook(r *store.Webhook) *store.Webhook {
+	c := *r
+	c.Events = slices.Clone(r.Events)
+	if c.Events == nil {
+		c.Events = store.JSONArray{}
+	}
+	return &c
+}
+
+// InsertWebhook inserts a new webhook endpoint. If the project does not
+// exist an error of type store.ErrProjectNotFound is returned, and if the
+// webhook id is already used in the project an error of type
+// store.ErrWebhookAlreadyExists is returned.
+func (s *Store) InsertWebhook(ctx context.Context, params store.AddWebhook) (*store.Webhook, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.projects[params.ProjectID]; !ok {
+		return nil, store.NewStoreError(store.ErrProjectNotFound, nil)
+	}
+	k := key{params.ProjectID, params.WebhookID}
+	if _, ok := s.webhooks[k]; ok {
+		return nil, store.NewStoreError(store.ErrWebhookAlreadyExists, nil)
+	}
+	ts := now()
+	r := cloneWebhook(&store.Webhook{
+		WebhookID:       params.WebhookID,
+		ProjectID:       params.ProjectID,
+		URL:             params.URL,
+		EncryptedSecret: params.EncryptedSecret,
+		Events:          params.Events,
+		CreatedAt:       ts,
+		ModifiedAt:      ts,
+	})
+	s.webhooks[k] = r
+	return cloneWebhook(r), nil
+}
+
+// GetWebhook gets a webhook endpoint. If the webhook does not exist an
+// error of type store.ErrWebhookNotFound is returned.
+func (s *Store) GetWebhook(ctx context.Context, projectID, webhookID string) (*store.Webhook, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	r, ok := s.webhooks[key{projectID, webhookID}]
+	if !ok {
+		return nil, store.NewStoreError(store.ErrWebhookNotFound, nil)
+	}
+	return cloneWebhook(r), nil
+}
+
+// ListWebhooks lists the webhook endpoints of a project ordered by id.
+func (s *Store) ListWebhooks(ctx context.Context, projectID string) ([]*store.Webhook, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	list := sortedValues(s.webhooks, projectID)
+	for i, r := range list {
+		list[i] = cloneWebhook(r)
+	}
+	return list, nil
+}
+
+// DeleteWebhook deletes a webhook endpoint and its deliveries. If the
+// webhook does not exist an error of type store.ErrWebhookNotFound is
+// returned.
+func (s *Store) DeleteWebhook(ctx context.Context, projectID, webhookID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	k := key{projectID, webhookID}
+	if _, ok := s.webhooks[k]; !ok {
+		return store.NewStoreError(store.ErrWebhookNotFound, nil)
+	}
+	delete(s.webhooks, k)
+	for id, row := range s.webhookDeliveries {
+		if row.ProjectID == projectID && row.WebhookID == webhookID {
+			delete(s.webhookDeliveries, id)
+		}
+	}
+	return nil
+}
+
+// InsertWebhookDelivery adds a pending delivery that is due immediately.
+// If the webhook does not exist an error of type store.ErrWebhookNotFound
+// is returned.
+func (s *Store) InsertWebhookDelivery(ctx context.Context, params store.AddWebhookDelivery) (*store.WebhookDelivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.webhooks[key{params.ProjectID, params.WebhookID}]; !ok {
+		return nil, store.NewStoreError(store.ErrWebhookNotFound, nil)
+	}
+	ts := now()
+	s.seq++
+	row := &webhookDeliveryRow{
+		WebhookDelivery: store.WebhookDelivery{
+			DeliveryID:    params.DeliveryID,
+			WebhookID:     params.WebhookID,
+			ProjectID:     params.ProjectID,
+			Event:         params.Event,
+			Payload:       params.Payload,
+			DState:        store.WebhookDeliveryStatePending,
+			NextAttemptAt: ts,
+			CreatedAt:     ts,
+			ModifiedAt:    ts,
+		},
+		seq: s.seq,
+	}
+	s.webhookDeliveries[params.DeliveryID] = row
+	c := row.WebhookDelivery
+	return &c, nil
+}
+
+// ClaimWebhookDeliveries moves up to limit pending deliveries whose next
+// attempt is due to the sending state and returns them, oldest first.
+func (s *Store) ClaimWebhookDeliveries(ctx context.Context, limit int) ([]*store.WebhookDelivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ts := now()
+	due := make([]*webhookDeliveryRow, 0)
+	for _, row := range s.webhookDeliveries {
+		if row.DState == store.WebhookDeliveryStatePending &&
+			!time.Time(row.NextAttemptAt).After(time.Time(ts)) {
+			due = append(due, row)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool { return due[i].seq < due[j].seq })
+	if len(due) > limit {
+		due = due[:limit]
+	}
+
+	list := make([]*store.WebhookDelivery, 0, len(due))
+	for _, row := range due {
+		row.DState = store.WebhookDeliveryStateSending
+		row.ModifiedAt = ts
+		c := row.WebhookDelivery
+		list = append(list, &c)
+	}
+	return list, nil
+}
+
+// UpdateWebhookDelivery records the outcome of a delivery attempt.
+func (s *Store) UpdateWebhookDelivery(ctx context.Context, params store.UpdateWebhookDelivery) (*store.WebhookDelivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	row, ok := s.webhookDeliveries[params.DeliveryID]
+	if !ok {
+		return nil, store.NewStoreError(store.ErrWebhookNotFound, nil)
+	}
+	row.DState = params.DState
+	row.Attempts = params.Attempts
+	row.ResponseCode = params.ResponseCode
+	row.LastError = params.LastError
+	if params.NextAttemptAt != nil {
+		row.NextAttemptAt = store.Datetime(time.Time(*params.NextAttemptAt).UTC().Truncate(time.Microsecond))
+	}
+	row.ModifiedAt = now()
+	c := row.WebhookDelivery
+	return &c, nil
+}
+
+// ListWebhookDeliveries lists the deliveries of a webhook endpoint, newest
+// first.
+func (s *Store) ListWebhookDeliveries(ctx context.Context, projectID, webhookID string) ([]*store.WebhookDelivery, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	rows := make([]*webhookDeliveryRow, 0)
+	for _, row := range s.webhookDeliveries {
+		if row.ProjectID == projectID && row.WebhookID == webhookID {
+			rows = append(rows, row)
+		}
+	}
+	sort.Slice(rows, func(i, j int) bool { return rows[i].seq > rows[j].seq })
+
+	list := make([]*store.WebhookDelivery, 0, len(rows))
+	for _, row := range rows {
+		c := row.WebhookDelivery
+		list = append(list, &c)
+	}
+	return list, nil
+}
@@ -0,0 +1,136 @@
+package sqlite3
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/andyfusniak/squishy-mailer-lite/internal/store"
+	"github.com/pkg/errors"
+)
+
+const apiKeyColumns = `
+  api_key_id, name, key_hash, role, project_ids, created_at, modified_at
+`
+
+func scanAPIKey(row rowScanner) (*store.APIKey, error) {
+	var r store.APIKey
+	if err := row.Scan(
+		&r.APIKeyID,
+		&r.Name,
+		&r.KeyHash,
+		&r.Role,
+		&r.ProjectIDs,
+		&r.CreatedAt,
+		&r.ModifiedAt,
+	); err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// InsertAPIKey inserts a new API key.
+func (q *Queries) InsertAPIKey(ctx context.Context, params store.AddAPIKey) (*store.APIKey, error) {
+	const query = `
+insert into api_keys
+  (api_key_id, name, key_hash, role, project_ids, created_at, modified_at)
+values
+  (:api_key_id, :name, :key_hash, :role, :project_ids, :created_at, :modified_at)
+returning` + apiKeyColumns
+	now := store.Datetime(time.Now().UTC())
+	projectIDs := params.ProjectIDs
+	if projectIDs == nil {
+		projectIDs = store.JSONArray{}
+	}
+	r, err := scanAPIKey(q.readwrite.QueryRowContext(ctx, query,
+		sql.Named("api_key_id", params.APIKeyID),
+		sql.Named("name", params.Name),
+		sql.Named("key_hash", params.KeyHash),
+		sql.Named("role", params.Role),
+		sql.Named("project_ids", projectIDs),
+		sql.Named("created_at", &now),
+		sql.Named("modified_at", &now),
+	))
+	if err != nil {
+		return nil, errors.Wrapf(err,
+			"[sqlite3:api_keys] query row scan failed query=%q", query)
+	}
+	return r, nil
+}
+
+// GetAPIKeyByHash gets an API key by the hash of its secret. If there is
+// no such key an error of type store.ErrAPIKeyNotFound is returned.
+func (q *Queries) GetAPIKeyByHash(ctx context.Context, keyHash string) (*store.APIKey, error) {
+	const query = `
+select` + apiKeyColumns + `
+from api_keys
+where
+  key_hash = :key_hash
+`
+	r, err := scanAPIKey(q.queryRow(ctx, query,
+		sql.Named("key_hash", keyHash),
+	))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, store.NewStoreError(store.ErrAPIKeyNotFound, err)
+		}
+		return nil, errors.Wrapf(err,
+			"[sqlite3:api_keys] query row scan failed query=%q", query)
+	}
+	return r, nil
+}
+
+// ListAPIKeys lists the API keys ordered by creation time.
+func (q *Queries) ListAPIKeys(ctx context.Context) ([]*store.APIKey, error) {
+	const query = `
+select` + apiKeyColumns + `
+from api_keys
+order by created_at, api_key_id
+`
+	rows, err := q.readonly.QueryContext(ctx, query)
+	if err != nil {
+		return nil, errors.Wrapf(err,
+			"[sqlite3:api_keys] query failed query=%q", query)
+	}
+	defer rows.Close()
+
+	list := make([]*store.APIKey, 0)
+	for rows.Next() {
+		r, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, errors.Wrapf(err,
+				"[sqlite3:api_keys] rows scan failed query=%q", query)
+		}
+		list = append(list, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrapf(err,
+			"[sqlite3:api_keys] rows.Err failed query=%q", query)
+	}
+	return list, nil
+}
+
+// DeleteAPIKey deletes an API key. If there is no such key an error of
+// type store.ErrAPIKeyNotFound is returned.
+func (q *Queries) DeleteAPIKey(ctx context.Context, apiKeyID string) error {
+	const query = `
+delete from api_keys
+where
+  api_key_id = :api_key_id
+`
+	res, err := q.readwrite.ExecContext(ctx, query,
+		sql.Named("api_key_id", apiKeyID),
+	)
+	if err != nil {
+		return errors.Wrapf(err,
+			"[sqlite3:api_keys] exec failed query=%q", query)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return errors.Wrapf(err, "[sqlite3:api_keys] res.RowsAffected failed")
+	}
+	if n == 0 {
+		return store.NewStoreError(store.ErrAPIKeyNotFound, nil)
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+package sqlite3
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/andyfusniak/squishy-mailer-lite/internal/store"
+	"github.com/pkg/errors"
+)
+
+const apiTransportColumns = `
+  api_transport_id, project_id, transport_name, provider, config,
+  encrypted_api_key, email_from, email_from_name, email_replyto,
+  created_at, modified_at
+`
+
+func scanAPITransport(row rowScanner) (*store.APITransport, error) {
+	var r store.APITransport
+	if err := row.Scan(
+		&r.APITransportID,
+		&r.ProjectID,
+		&r.TransportName,
+		&r.Provider,
+		&r.Config,
+		&r.EncryptedAPIKey,
+		&r.EmailFrom,
+		&r.EmailFromName,
+		&r.EmailReplyTo,
+		&r.CreatedAt,
+		&r.ModifiedAt,
+	); err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// InsertAPITransport inserts a new API transport into the store. If the
+// project does not exist an error of type store.ErrProjectNotFound is
+// returned.
+func (q *Queries) InsertAPITransport(ctx context.Context, params store.AddAPITransport) (*store.APITransport, error) {
+	const query = `
+insert into api_transports (
+  api_transport_id, project_id, transport_name, provider, config,
+  encrypted_api_key, email_from, email_from_name, email_replyto,
+  created_at, modified_at
+)
+select
+  :api_transport_id as api_transport_id,
+  p.project_id as project_id,
+  :transport_name as transport_name,
+  :provider as provider,
+  :config as config,
+  :encrypted_api_key as encrypted_api_key,
+  :email_from as email_from,
+  :email_from_name as email_from_name,
+  :email_replyto as email_replyto,
+  :created_at as created_at,
+  :modified_at as modified_at
+from projects as p
+where p.project_id = :project_id
+returning` + apiTransportColumns
+
+	replyTo := params.EmailReplyTo
+	if replyTo == nil {
+		replyTo = store.JSONArray{}
+	}
+	now := store.Datetime(time.Now().UTC())
+	r, err := scanAPITransport(q.readwrite.QueryRowContext(ctx, query,
+		sql.Named("api_transport_id", params.APITransportID),
+		sql.Named("transport_name", params.TransportName),
+		sql.Named("provider", params.Provider),
+		sql.Named("config", params.Config),
+		sql.Named("encrypted_api_key", params.EncryptedAPIKey),
+		sql.Named("email_from", params.EmailFrom),
+		sql.Named("email_from_name", params.EmailFromName),
+		sql.Named("email_replyto", replyTo),
+		sql.Named("created_at", &now),
+		sql.Named("modified_at", &now),
+		sql.Named("project_id", params.ProjectID),
+	))
+	if err != nil {
+		// the insert selects from the projects table so if no rows
+		// are returned then the project does not exist
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, store.NewStoreError(store.ErrProjectNotFound, err)
+		}
+		return nil, errors.Wrapf(err,
+			"[sqlite3:api_transports] query row scan failed query=%q", query)
+	}
+	return r, nil
+}
+
+// GetAPITransport gets an API transport from the store by composite
+// primary key (transportID, projectID). If the project does not exist an
+// error of type store.ErrProjectNotFound is returned. If the transport
+// does not exist store.ErrTransportNotFound is returned.
+func (q *Queries) GetAPITransport(ctx context.Context, transportID, projectID string) (*store.APITransport, error) {
+	const query = `
+select
+  coalesce(t.api_transport_id, '') as api_transport_id,
+  p.project_id,
+  coalesce(t.transport_name, '') as transport_name,
+  coalesce(t.provider, '') as provider,
+  coalesce(t.config, '{}') as config,
+  coalesce(t.encrypted_api_key, '') as encrypted_api_key,
+  coalesce(t.email_from, '') as email_from,
+  coalesce(t.email_from_name, '') as email_from_name,
+  coalesce(t.email_replyto, '[]') as email_replyto,
+  coalesce(t.created_at, '1970-01-01T00:00:00.000000Z') as created_at,
+  coalesce(t.modified_at, '1970-01-01T00:00:00.000000Z') as modified_at
+from projects as p
+left outer join api_transports as t
+  on p.project_id = t.project_id and t.api_transport_id = :api_transport_id
+where
+  p.project_id = :project_id
+`
+	r, err := scanAPITransport(q.queryRow(ctx, query,
+		sql.Named("project_id", projectID),
+		sql.Named("api_transport_id", transportID),
+	))
+	if err != nil {
+		// if there are no rows returned, then the project does not exist
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, store.NewStoreError(store.ErrProjectNotFound, err)
+		}
+		return nil, errors.Wrapf(err,
+			"[sqlite3:api_transports] query row scan failed query=%q", query)
+	}
+	if r.APITransportID == "" {
+		return nil, store.ErrTransportNotFound
+	}
+	return r, nil
+}
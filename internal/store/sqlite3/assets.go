@@ -0,0 +1,172 @@
+package sqlite3
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/andyfusniak/squishy-mailer-lite/internal/store"
+	"github.com/mattn/go-sqlite3"
+	"github.com/pkg/errors"
+)
+
+// SetAsset creates or replaces an asset.
+func (q *Queries) SetAsset(ctx context.Context, params store.SetAsset) (*store.Asset, error) {
+	const query = `
+insert into assets
+  (asset_id, project_id, filename, content_type, content, size, checksum,
+   created_at, modified_at)
+values
+  (:asset_id, :project_id, :filename, :content_type, :content, :size, :checksum,
+   :created_at, :modified_at)
+on conflict (asset_id, project_id) do update set
+  filename = excluded.filename,
+  content_type = excluded.content_type,
+  content = excluded.content,
+  size = excluded.size,
+  checksum = excluded.checksum,
+  modified_at = excluded.modified_at
+returning
+  asset_id, project_id, filename, content_type, size, checksum,
+  created_at, modified_at
+`
+	var r store.Asset
+	now := store.Datetime(time.Now().UTC())
+	if err := q.readwrite.QueryRowContext(ctx, query,
+		sql.Named("asset_id", params.AssetID),
+		sql.Named("project_id", params.ProjectID),
+		sql.Named("filename", params.Filename),
+		sql.Named("content_type", params.ContentType),
+		sql.Named("content", params.Content),
+		sql.Named("size", len(params.Content)),
+		sql.Named("checksum", params.Checksum),
+		sql.Named("created_at", &now),
+		sql.Named("modified_at", &now),
+	).Scan(
+		&r.AssetID,
+		&r.ProjectID,
+		&r.Filename,
+		&r.ContentType,
+		&r.Size,
+		&r.Checksum,
+		&r.CreatedAt,
+		&r.ModifiedAt,
+	); err != nil {
+		if serr, ok := err.(sqlite3.Error); ok {
+			if serr.Code == sqlite3.ErrConstraint && serr.ExtendedCode == sqlite3.ErrConstraintForeignKey {
+				return nil, store.NewStoreError(store.ErrProjectNotFound, serr)
+			}
+		}
+		return nil, errors.Wrapf(err,
+			"[sqlite3:assets] query row scan failed query=%q", query)
+	}
+	r.Content = params.Content
+	return &r, nil
+}
+
+// GetAsset gets an asset including its content. If the asset is not found,
+// an error of type store.ErrAssetNotFound is returned.
+func (q *Queries) GetAsset(ctx context.Context, projectID, assetID string) (*store.Asset, error) {
+	const query = `
+select
+  asset_id, project_id, filename, content_type, content, size, checksum,
+  created_at, modified_at
+from assets
+where
+  asset_id = :asset_id and project_id = :project_id
+`
+	var r store.Asset
+	if err := q.queryRow(ctx, query,
+		sql.Named("asset_id", assetID),
+		sql.Named("project_id", projectID),
+	).Scan(
+		&r.AssetID,
+		&r.ProjectID,
+		&r.Filename,
+		&r.ContentType,
+		&r.Content,
+		&r.Size,
+		&r.Checksum,
+		&r.CreatedAt,
+		&r.ModifiedAt,
+	); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, store.NewStoreError(store.ErrAssetNotFound, err)
+		}
+		return nil, errors.Wrapf(err,
+			"[sqlite3:assets] query row scan failed query=%q", query)
+	}
+	return &r, nil
+}
+
+// ListAssets lists all the assets for a project ordered by
+// asset id. The content of the assets is not returned.
+func (q *Queries) ListAssets(ctx context.Context, projectID string) ([]*store.Asset, error) {
+	const query = `
+select
+  asset_id, project_id, filename, content_type, size, checksum,
+  created_at, modified_at
+from assets
+where
+  project_id = :project_id
+order by asset_id
+`
+	rows, err := q.readonly.QueryContext(ctx, query,
+		sql.Named("project_id", projectID),
+	)
+	if err != nil {
+		return nil, errors.Wrapf(err,
+			"[sqlite3:assets] query failed query=%q", query)
+	}
+	defer rows.Close()
+
+	list := make([]*store.Asset, 0)
+	for rows.Next() {
+		var r store.Asset
+		if err := rows.Scan(
+			&r.AssetID,
+			&r.ProjectID,
+			&r.Filename,
+			&r.ContentType,
+			&r.Size,
+			&r.Checksum,
+			&r.CreatedAt,
+			&r.ModifiedAt,
+		); err != nil {
+			return nil, errors.Wrapf(err,
+				"[sqlite3:assets] rows scan failed query=%q", query)
+		}
+		list = append(list, &r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrapf(err,
+			"[sqlite3:assets] rows.Err failed query=%q", query)
+	}
+	return list, nil
+}
+
+// DeleteAsset deletes an asset. If the asset does not exist an error of
+// type store.ErrAssetNotFound is returned.
+func (q *Queries) DeleteAsset(ctx context.Context, projectID, assetID string) error {
+	const query = `
+delete from assets
+where
+  asset_id = :asset_id and project_id = :project_id
+`
+	res, err := q.readwrite.ExecContext(ctx, query,
+		sql.Named("asset_id", assetID),
+		sql.Named("project_id", projectID),
+	)
+	if err != nil {
+		return errors.Wrapf(err,
+			"[sqlite3:assets] exec failed query=%q", query)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return errors.Wrapf(err, "[sqlite3:assets] res.RowsAffected failed")
+	}
+	if n == 0 {
+		return store.NewStoreError(store.ErrAssetNotFound, nil)
+	}
+	return nil
+}
@@ -0,0 +1,287 @@
+package sqlite3
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/andyfusniak/squishy-mailer-lite/internal/store"
+	"github.com/mattn/go-sqlite3"
+	"github.com/pkg/errors"
+)
+
+// SetAttachment creates or replaces an attachment.
+func (q *Queries) SetAttachment(ctx context.Context, params store.SetAttachment) (*store.Attachment, error) {
+	const query = `
+insert into attachments
+  (attachment_id, project_id, filename, content_type, content, size, checksum,
+   created_at, modified_at)
+values
+  (:attachment_id, :project_id, :filename, :content_type, :content, :size, :checksum,
+   :created_at, :modified_at)
+on conflict (attachment_id, project_id) do update set
+  filename = excluded.filename,
+  content_type = excluded.content_type,
+  content = excluded.content,
+  size = excluded.size,
+  checksum = excluded.checksum,
+  modified_at = excluded.modified_at
+returning
+  attachment_id, project_id, filename, content_type, size, checksum,
+  created_at, modified_at
+`
+	var r store.Attachment
+	now := store.Datetime(time.Now().UTC())
+	if err := q.readwrite.QueryRowContext(ctx, query,
+		sql.Named("attachment_id", params.AttachmentID),
+		sql.Named("project_id", params.ProjectID),
+		sql.Named("filename", params.Filename),
+		sql.Named("content_type", params.ContentType),
+		sql.Named("content", params.Content),
+		sql.Named("size", len(params.Content)),
+		sql.Named("checksum", params.Checksum),
+		sql.Named("created_at", &now),
+		sql.Named("modified_at", &now),
+	).Scan(
+		&r.AttachmentID,
+		&r.ProjectID,
+		&r.Filename,
+		&r.ContentType,
+		&r.Size,
+		&r.Checksum,
+		&r.CreatedAt,
+		&r.ModifiedAt,
+	); err != nil {
+		if serr, ok := err.(sqlite3.Error); ok {
+			if serr.Code == sqlite3.ErrConstraint && serr.ExtendedCode == sqlite3.ErrConstraintForeignKey {
+				return nil, store.NewStoreError(store.ErrProjectNotFound, serr)
+			}
+		}
+		return nil, errors.Wrapf(err,
+			"[sqlite3:attachments] query row scan failed query=%q", query)
+	}
+	r.Content = params.Content
+	return &r, nil
+}
+
+// GetAttachment gets an attachment including its content. If the
+// attachment is not found, an error of type store.ErrAttachmentNotFound is
+// returned.
+func (q *Queries) GetAttachment(ctx context.Context, projectID, attachmentID string) (*store.Attachment, error) {
+	const query = `
+select
+  attachment_id, project_id, filename, content_type, content, size, checksum,
+  created_at, modified_at
+from attachments
+where
+  attachment_id = :attachment_id and project_id = :project_id
+`
+	var r store.Attachment
+	if err := q.queryRow(ctx, query,
+		sql.Named("attachment_id", attachmentID),
+		sql.Named("project_id", projectID),
+	).Scan(
+		&r.AttachmentID,
+		&r.ProjectID,
+		&r.Filename,
+		&r.ContentType,
+		&r.Content,
+		&r.Size,
+		&r.Checksum,
+		&r.CreatedAt,
+		&r.ModifiedAt,
+	); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, store.NewStoreError(store.ErrAttachmentNotFound, err)
+		}
+		return nil, errors.Wrapf(err,
+			"[sqlite3:attachments] query row scan failed query=%q", query)
+	}
+	return &r, nil
+}
+
+// ListAttachments lists all the attachments for a project ordered by
+// attachment id. The content of the attachments is not returned.
+func (q *Queries) ListAttachments(ctx context.Context, projectID string) ([]*store.Attachment, error) {
+	const query = `
+select
+  attachment_id, project_id, filename, content_type, size, checksum,
+  created_at, modified_at
+from attachments
+where
+  project_id = :project_id
+order by attachment_id
+`
+	rows, err := q.readonly.QueryContext(ctx, query,
+		sql.Named("project_id", projectID),
+	)
+	if err != nil {
+		return nil, errors.Wrapf(err,
+			"[sqlite3:attachments] query failed query=%q", query)
+	}
+	defer rows.Close()
+
+	list := make([]*store.Attachment, 0)
+	for rows.Next() {
+		var r store.Attachment
+		if err := rows.Scan(
+			&r.AttachmentID,
+			&r.ProjectID,
+			&r.Filename,
+			&r.ContentType,
+			&r.Size,
+			&r.Checksum,
+			&r.CreatedAt,
+			&r.ModifiedAt,
+		); err != nil {
+			return nil, errors.Wrapf(err,
+				"[sqlite3:attachments] rows scan failed query=%q", query)
+		}
+		list = append(list, &r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrapf(err,
+			"[sqlite3:attachments] rows.Err failed query=%q", query)
+	}
+	return list, nil
+}
+
+// DeleteAttachment deletes an attachment. Any references to the attachment
+// from templates are removed. If the attachment does not exist an error of
+// type store.ErrAttachmentNotFound is returned.
+func (q *Queries) DeleteAttachment(ctx context.Context, projectID, attachmentID string) error {
+	const query = `
+delete from attachments
+where
+  attachment_id = :attachment_id and project_id = :project_id
+`
+	res, err := q.readwrite.ExecContext(ctx, query,
+		sql.Named("attachment_id", attachmentID),
+		sql.Named("project_id", projectID),
+	)
+	if err != nil {
+		return errors.Wrapf(err,
+			"[sqlite3:attachments] exec failed query=%q", query)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return errors.Wrapf(err, "[sqlite3:attachments] res.RowsAffected failed")
+	}
+	if n == 0 {
+		return store.NewStoreError(store.ErrAttachmentNotFound, nil)
+	}
+	return nil
+}
+
+// SetTemplateAttachments replaces the attachments referenced by a template.
+// If the template does not exist an error of type store.ErrTemplateNotFound
+// is returned. If any of the attachments do not exist an error of type
+// store.ErrAttachmentNotFound is returned and no changes are made.
+func (s *Store) SetTemplateAttachments(ctx context.Context, projectID, templateID string, attachmentIDs []string) error {
+	const chkTemplateQuery = `
+select count(*)
+from templates
+where
+  template_id = :template_id and project_id = :project_id
+`
+	const deleteQuery = `
+delete from template_attachments
+where
+  template_id = :template_id and project_id = :project_id
+`
+	const insertQuery = `
+insert into template_attachments
+  (template_id, attachment_id, project_id, position)
+values
+  (:template_id, :attachment_id, :project_id, :position)
+`
+	return s.execTx(ctx, func(q *Queries) error {
+		var n int
+		if err := q.readwrite.QueryRowContext(ctx, chkTemplateQuery,
+			sql.Named("template_id", templateID),
+			sql.Named("project_id", projectID),
+		).Scan(&n); err != nil {
+			return errors.Wrapf(err,
+				"[sqlite3:templates] query row scan failed query=%q", chkTemplateQuery)
+		}
+		if n == 0 {
+			return store.NewStoreError(store.ErrTemplateNotFound, nil)
+		}
+
+		if _, err := q.readwrite.ExecContext(ctx, deleteQuery,
+			sql.Named("template_id", templateID),
+			sql.Named("project_id", projectID),
+		); err != nil {
+			return errors.Wrapf(err,
+				"[sqlite3:template_attachments] exec failed query=%q", deleteQuery)
+		}
+
+		for i, attachmentID := range attachmentIDs {
+			if _, err := q.readwrite.ExecContext(ctx, insertQuery,
+				sql.Named("template_id", templateID),
+				sql.Named("attachment_id", attachmentID),
+				sql.Named("project_id", projectID),
+				sql.Named("position", i),
+			); err != nil {
+				if serr, ok := err.(sqlite3.Error); ok {
+					if serr.Code == sqlite3.ErrConstraint && serr.ExtendedCode == sqlite3.ErrConstraintForeignKey {
+						return store.NewStoreError(store.ErrAttachmentNotFound, serr)
+					}
+				}
+				return errors.Wrapf(err,
+					"[sqlite3:template_attachments] exec failed query=%q", insertQuery)
+			}
+		}
+		return nil
+	})
+}
+
+// ListTemplateAttachments lists the attachments referenced by a template,
+// including their content, in the order they were set.
+func (q *Queries) ListTemplateAttachments(ctx context.Context, projectID, templateID string) ([]*store.Attachment, error) {
+	const query = `
+select
+  a.attachment_id, a.project_id, a.filename, a.content_type, a.content,
+  a.size, a.checksum, a.created_at, a.modified_at
+from template_attachments as ta
+join attachments as a
+  on a.attachment_id = ta.attachment_id and a.project_id = ta.project_id
+where
+  ta.template_id = :template_id and ta.project_id = :project_id
+order by ta.position
+`
+	rows, err := q.readonly.QueryContext(ctx, query,
+		sql.Named("template_id", templateID),
+		sql.Named("project_id", projectID),
+	)
+	if err != nil {
+		return nil, errors.Wrapf(err,
+			"[sqlite3:template_attachments] query failed query=%q", query)
+	}
+	defer rows.Close()
+
+	list := make([]*store.Attachment, 0)
+	for rows.Next() {
+		var r store.Attachment
+		if err := rows.Scan(
+			&r.AttachmentID,
+			&r.ProjectID,
+			&r.Filename,
+			&r.ContentType,
+			&r.Content,
+			&r.Size,
+			&r.Checksum,
+			&r.CreatedAt,
+			&r.ModifiedAt,
+		); err != nil {
+			return nil, errors.Wrapf(err,
+				"[sqlite3:template_attachments] rows scan failed query=%q", query)
+		}
+		list = append(list, &r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrapf(err,
+			"[sqlite3:template_attachments] rows.Err failed query=%q", query)
+	}
+	return list, nil
+}
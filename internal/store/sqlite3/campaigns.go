@@ -0,0 +1,236 @@
+package sqlite3
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/andyfusniak/squishy-mailer-lite/internal/store"
+	"github.com/mattn/go-sqlite3"
+	"github.com/pkg/errors"
+)
+
+const campaignColumns = `
+  campaign_id, project_id, campaign_name, template_id, transport_id,
+  list_id, cstate, recipients, created_at, modified_at
+`
+
+func scanCampaign(row rowScanner) (*store.Campaign, error) {
+	var r store.Campaign
+	if err := row.Scan(
+		&r.CampaignID,
+		&r.ProjectID,
+		&r.CampaignName,
+		&r.TemplateID,
+		&r.TransportID,
+		&r.ListID,
+		&r.CState,
+		&r.Recipients,
+		&r.CreatedAt,
+		&r.ModifiedAt,
+	); err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// InsertCampaign inserts a new running campaign. If the project does not
+// exist an error of type store.ErrProjectNotFound is returned, and if the
+// campaign id is already used in the project an error of type
+// store.ErrCampaignAlreadyExists is returned.
+func (q *Queries) InsertCampaign(ctx context.Context, params store.AddCampaign) (*store.Campaign, error) {
+	const query = `
+insert into campaigns
+  (campaign_id, project_id, campaign_name, template_id, transport_id,
+   list_id, cstate, recipients, created_at, modified_at)
+values
+  (:campaign_id, :project_id, :campaign_name, :template_id, :transport_id,
+   :list_id, :cstate, :recipients, :created_at, :modified_at)
+returning` + campaignColumns
+
+	now := store.Datetime(time.Now().UTC())
+	r, err := scanCampaign(q.readwrite.QueryRowContext(ctx, query,
+		sql.Named("campaign_id", params.CampaignID),
+		sql.Named("project_id", params.ProjectID),
+		sql.Named("campaign_name", params.CampaignName),
+		sql.Named("template_id", params.TemplateID),
+		sql.Named("transport_id", params.TransportID),
+		sql.Named("list_id", params.ListID),
+		sql.Named("cstate", store.CampaignStateRunning),
+		sql.Named("recipients", params.Recipients),
+		sql.Named("created_at", &now),
+		sql.Named("modified_at", &now),
+	))
+	if err != nil {
+		if serr, ok := err.(sqlite3.Error); ok && serr.Code == sqlite3.ErrConstraint {
+			switch serr.ExtendedCode {
+			case sqlite3.ErrConstraintForeignKey:
+				return nil, store.NewStoreError(store.ErrProjectNotFound, serr)
+			case sqlite3.ErrConstraintPrimaryKey:
+				return nil, store.NewStoreError(store.ErrCampaignAlreadyExists, serr)
+			}
+		}
+		return nil, errors.Wrapf(err,
+			"[sqlite3:campaigns] query row scan failed query=%q", query)
+	}
+	return r, nil
+}
+
+// GetCampaign gets a campaign. If the campaign does not exist an error of
+// type store.ErrCampaignNotFound is returned.
+func (q *Queries) GetCampaign(ctx context.Context, projectID, campaignID string) (*store.Campaign, error) {
+	const query = `
+select` + campaignColumns + `
+from campaigns
+where
+  project_id = :project_id and campaign_id = :campaign_id
+`
+	r, err := scanCampaign(q.queryRow(ctx, query,
+		sql.Named("project_id", projectID),
+		sql.Named("campaign_id", campaignID),
+	))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, store.NewStoreError(store.ErrCampaignNotFound, err)
+		}
+		return nil, errors.Wrapf(err,
+			"[sqlite3:campaigns] query row scan failed query=%q", query)
+	}
+	return r, nil
+}
+
+// ListCampaigns lists the campaigns of a project ordered by id.
+func (q *Queries) ListCampaigns(ctx context.Context, projectID string) ([]*store.Campaign, error) {
+	const query = `
+select` + campaignColumns + `
+from campaigns
+where
+  project_id = :project_id
+order by campaign_id
+`
+	rows, err := q.readonly.QueryContext(ctx, query,
+		sql.Named("project_id", projectID),
+	)
+	if err != nil {
+		return nil, errors.Wrapf(err,
+			"[sqlite3:campaigns] query failed query=%q", query)
+	}
+	defer rows.Close()
+
+	list := make([]*store.Campaign, 0)
+	for rows.Next() {
+		r, err := scanCampaign(rows)
+		if err != nil {
+			return nil, errors.Wrapf(err,
+				"[sqlite3:campaigns] rows scan failed query=%q", query)
+		}
+		list = append(list, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrapf(err,
+			"[sqlite3:campaigns] rows.Err failed query=%q", query)
+	}
+	return list, nil
+}
+
+// SetCampaignState sets the state of a campaign and moves its waiting
+// emails in a single transaction. Pausing moves queued and rate limited
+// emails to the paused state; running the campaign again returns paused
+// emails to the queued state. Emails already claimed by a worker are left
+// to finish. If the campaign does not exist an error of type
+// store.ErrCampaignNotFound is returned.
+func (s *Store) SetCampaignState(ctx context.Context, projectID, campaignID, cstate string) (*store.Campaign, error) {
+	var r *store.Campaign
+	err := s.execTx(ctx, func(q *Queries) error {
+		const query = `
+update campaigns
+set
+  cstate = :cstate,
+  modified_at = :modified_at
+where
+  project_id = :project_id and campaign_id = :campaign_id
+returning` + campaignColumns
+
+		now := store.Datetime(time.Now().UTC())
+		var err error
+		r, err = scanCampaign(q.readwrite.QueryRowContext(ctx, query,
+			sql.Named("cstate", cstate),
+			sql.Named("modified_at", &now),
+			sql.Named("project_id", projectID),
+			sql.Named("campaign_id", campaignID),
+		))
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return store.NewStoreError(store.ErrCampaignNotFound, err)
+			}
+			return errors.Wrapf(err,
+				"[sqlite3:campaigns] query row scan failed query=%q", query)
+		}
+
+		const mailQueueQuery = `
+update mail_queue
+set
+  mstate = :to,
+  modified_at = :modified_at
+where
+  project_id = :project_id and campaign_id = :campaign_id and
+  mstate in (:from, :from_rate_limited)
+`
+		from, fromRateLimited, to := store.MailQueueStatePaused, store.MailQueueStatePaused, store.MailQueueStateQueued
+		if cstate == store.CampaignStatePaused {
+			from, fromRateLimited, to = store.MailQueueStateQueued, store.MailQueueStateRateLimited, store.MailQueueStatePaused
+		}
+		if _, err := q.readwrite.ExecContext(ctx, mailQueueQuery,
+			sql.Named("to", to),
+			sql.Named("modified_at", &now),
+			sql.Named("project_id", projectID),
+			sql.Named("campaign_id", campaignID),
+			sql.Named("from", from),
+			sql.Named("from_rate_limited", fromRateLimited),
+		); err != nil {
+			return errors.Wrapf(err,
+				"[sqlite3:mail_queue] exec failed query=%q", mailQueueQuery)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// CountCampaignMailQueue counts the emails of a campaign by state.
+func (q *Queries) CountCampaignMailQueue(ctx context.Context, projectID, campaignID string) (map[string]int, error) {
+	const query = `
+select mstate, count(*)
+from mail_queue
+where
+  project_id = :project_id and campaign_id = :campaign_id
+group by mstate
+`
+	rows, err := q.readonly.QueryContext(ctx, query,
+		sql.Named("project_id", projectID),
+		sql.Named("campaign_id", campaignID),
+	)
+	if err != nil {
+		return nil, errors.Wrapf(err,
+			"[sqlite3:mail_queue] query failed query=%q", query)
+	}
+	defer rows.Close()
+
+	counts := make(map[string]int)
+	for rows.Next() {
+		var mstate string
+		var n int
+		if err := rows.Scan(&mstate, &n); err != nil {
+			return nil, errors.Wrapf(err,
+				"[sqlite3:mail_queue] rows scan failed query=%q", query)
+		}
+		counts[mstate] = n
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrapf(err,
+			"[sqlite3:mail_queue] rows.Err failed query=%q", query)
+	}
+	return counts, nil
+}
@@ -0,0 +1,120 @@
+package sqlite3
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/andyfusniak/squishy-mailer-lite/internal/store"
+	"github.com/mattn/go-sqlite3"
+	"github.com/pkg/errors"
+)
+
+// SetMessageCatalog creates or replaces the message catalog for a project
+// and locale.
+func (q *Queries) SetMessageCatalog(ctx context.Context, params store.SetMessageCatalog) (*store.MessageCatalog, error) {
+	const query = `
+insert into message_catalogs
+  (project_id, locale, messages, created_at, modified_at)
+values
+  (:project_id, :locale, :messages, :created_at, :modified_at)
+on conflict (project_id, locale) do update set
+  messages = excluded.messages,
+  modified_at = excluded.modified_at
+returning
+  project_id, locale, messages, created_at, modified_at
+`
+	var r store.MessageCatalog
+	now := store.Datetime(time.Now().UTC())
+	if err := q.readwrite.QueryRowContext(ctx, query,
+		sql.Named("project_id", params.ProjectID),
+		sql.Named("locale", params.Locale),
+		sql.Named("messages", params.Messages),
+		sql.Named("created_at", &now),
+		sql.Named("modified_at", &now),
+	).Scan(
+		&r.ProjectID,
+		&r.Locale,
+		&r.Messages,
+		&r.CreatedAt,
+		&r.ModifiedAt,
+	); err != nil {
+		if serr, ok := err.(sqlite3.Error); ok {
+			if serr.Code == sqlite3.ErrConstraint && serr.ExtendedCode == sqlite3.ErrConstraintForeignKey {
+				return nil, store.NewStoreError(store.ErrProjectNotFound, serr)
+			}
+		}
+		return nil, errors.Wrapf(err,
+			"[sqlite3:message_catalogs] query row scan failed query=%q", query)
+	}
+	return &r, nil
+}
+
+// ListMessageCatalogs lists all the message catalogs for a project ordered
+// by locale.
+func (q *Queries) ListMessageCatalogs(ctx context.Context, projectID string) ([]*store.MessageCatalog, error) {
+	const query = `
+select
+  project_id, locale, messages, created_at, modified_at
+from message_catalogs
+where
+  project_id = :project_id
+order by locale
+`
+	rows, err := q.readonly.QueryContext(ctx, query,
+		sql.Named("project_id", projectID),
+	)
+	if err != nil {
+		return nil, errors.Wrapf(err,
+			"[sqlite3:message_catalogs] query failed query=%q", query)
+	}
+	defer rows.Close()
+
+	list := make([]*store.MessageCatalog, 0)
+	for rows.Next() {
+		var r store.MessageCatalog
+		if err := rows.Scan(
+			&r.ProjectID,
+			&r.Locale,
+			&r.Messages,
+			&r.CreatedAt,
+			&r.ModifiedAt,
+		); err != nil {
+			return nil, errors.Wrapf(err,
+				"[sqlite3:message_catalogs] rows scan failed query=%q", query)
+		}
+		list = append(list, &r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrapf(err,
+			"[sqlite3:message_catalogs] rows.Err failed query=%q", query)
+	}
+	return list, nil
+}
+
+// DeleteMessageCatalog deletes the message catalog for a project and
+// locale. If the catalog does not exist an error of type
+// store.ErrCatalogNotFound is returned.
+func (q *Queries) DeleteMessageCatalog(ctx context.Context, projectID, locale string) error {
+	const query = `
+delete from message_catalogs
+where
+  project_id = :project_id and locale = :locale
+`
+	res, err := q.readwrite.ExecContext(ctx, query,
+		sql.Named("project_id", projectID),
+		sql.Named("locale", locale),
+	)
+	if err != nil {
+		return errors.Wrapf(err,
+			"[sqlite3:message_catalogs] exec failed query=%q", query)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return errors.Wrapf(err, "[sqlite3:message_catalogs] res.RowsAffected failed")
+	}
+	if n == 0 {
+		return store.NewStoreError(store.ErrCatalogNotFound, nil)
+	}
+	return nil
+}
@@ -0,0 +1,325 @@
+package sqlite3
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/andyfusniak/squishy-mailer-lite/internal/store"
+	"github.com/mattn/go-sqlite3"
+	"github.com/pkg/errors"
+)
+
+const contactListColumns = `
+  l.list_id, l.project_id, l.list_name,
+  (select count(*) from contacts c where c.project_id = l.project_id and c.list_id = l.list_id),
+  l.created_at, l.modified_at
+`
+
+const contactColumns = `
+  project_id, list_id, email, attributes, locale, timezone, created_at,
+  modified_at
+`
+
+func scanContactList(row rowScanner) (*store.ContactList, error) {
+	var r store.ContactList
+	if err := row.Scan(
+		&r.ListID,
+		&r.ProjectID,
+		&r.ListName,
+		&r.Contacts,
+		&r.CreatedAt,
+		&r.ModifiedAt,
+	); err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+func scanContact(row rowScanner) (*store.Contact, error) {
+	var r store.Contact
+	if err := row.Scan(
+		&r.ProjectID,
+		&r.ListID,
+		&r.Email,
+		&r.Attributes,
+		&r.Locale,
+		&r.Timezone,
+		&r.CreatedAt,
+		&r.ModifiedAt,
+	); err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// InsertContactList inserts a new, empty contact list. If the project does
+// not exist an error of type store.ErrProjectNotFound is returned, and if
+// the list id is already used in the project an error of type
+// store.ErrContactListExists is returned.
+func (q *Queries) InsertContactList(ctx context.Context, params store.AddContactList) (*store.ContactList, error) {
+	const query = `
+insert into contact_lists
+  (list_id, project_id, list_name, created_at, modified_at)
+values
+  (:list_id, :project_id, :list_name, :created_at, :modified_at)
+`
+	now := store.Datetime(time.Now().UTC())
+	if _, err := q.readwrite.ExecContext(ctx, query,
+		sql.Named("list_id", params.ListID),
+		sql.Named("project_id", params.ProjectID),
+		sql.Named("list_name", params.ListName),
+		sql.Named("created_at", &now),
+		sql.Named("modified_at", &now),
+	); err != nil {
+		if serr, ok := err.(sqlite3.Error); ok && serr.Code == sqlite3.ErrConstraint {
+			switch serr.ExtendedCode {
+			case sqlite3.ErrConstraintForeignKey:
+				return nil, store.NewStoreError(store.ErrProjectNotFound, serr)
+			case sqlite3.ErrConstraintPrimaryKey:
+				return nil, store.NewStoreError(store.ErrContactListExists, serr)
+			}
+		}
+		return nil, errors.Wrapf(err,
+			"[sqlite3:contact_lists] exec failed query=%q", query)
+	}
+	return &store.ContactList{
+		ListID:     params.ListID,
+		ProjectID:  params.ProjectID,
+		ListName:   params.ListName,
+		CreatedAt:  now,
+		ModifiedAt: now,
+	}, nil
+}
+
+// GetContactList gets a contact list along with its number of contacts. If
+// the list does not exist an error of type store.ErrContactListNotFound is
+// returned.
+func (q *Queries) GetContactList(ctx context.Context, projectID, listID string) (*store.ContactList, error) {
+	const query = `
+select` + contactListColumns + `
+from contact_lists l
+where
+  l.project_id = :project_id and l.list_id = :list_id
+`
+	r, err := scanContactList(q.queryRow(ctx, query,
+		sql.Named("project_id", projectID),
+		sql.Named("list_id", listID),
+	))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, store.NewStoreError(store.ErrContactListNotFound, err)
+		}
+		return nil, errors.Wrapf(err,
+			"[sqlite3:contact_lists] query row scan failed query=%q", query)
+	}
+	return r, nil
+}
+
+// ListContactLists lists the contact lists of a project ordered by id.
+func (q *Queries) ListContactLists(ctx context.Context, projectID string) ([]*store.ContactList, error) {
+	const query = `
+select` + contactListColumns + `
+from contact_lists l
+where
+  l.project_id = :project_id
+order by l.list_id
+`
+	rows, err := q.readonly.QueryContext(ctx, query,
+		sql.Named("project_id", projectID),
+	)
+	if err != nil {
+		return nil, errors.Wrapf(err,
+			"[sqlite3:contact_lists] query failed query=%q", query)
+	}
+	defer rows.Close()
+
+	list := make([]*store.ContactList, 0)
+	for rows.Next() {
+		r, err := scanContactList(rows)
+		if err != nil {
+			return nil, errors.Wrapf(err,
+				"[sqlite3:contact_lists] rows scan failed query=%q", query)
+		}
+		list = append(list, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrapf(err,
+			"[sqlite3:contact_lists] rows.Err failed query=%q", query)
+	}
+	return list, nil
+}
+
+// DeleteContactList deletes a contact list and its contacts in a single
+// transaction. If the list does not exist an error of type
+// store.ErrContactListNotFound is returned.
+func (s *Store) DeleteContactList(ctx context.Context, projectID, listID string) error {
+	return s.execTx(ctx, func(q *Queries) error {
+		const contactsQuery = `
+delete from contacts
+where
+  project_id = :project_id and list_id = :list_id
+`
+		if _, err := q.readwrite.ExecContext(ctx, contactsQuery,
+			sql.Named("project_id", projectID),
+			sql.Named("list_id", listID),
+		); err != nil {
+			return errors.Wrapf(err,
+				"[sqlite3:contacts] exec failed query=%q", contactsQuery)
+		}
+
+		const query = `
+delete from contact_lists
+where
+  project_id = :project_id and list_id = :list_id
+`
+		res, err := q.readwrite.ExecContext(ctx, query,
+			sql.Named("project_id", projectID),
+			sql.Named("list_id", listID),
+		)
+		if err != nil {
+			return errors.Wrapf(err,
+				"[sqlite3:contact_lists] exec failed query=%q", query)
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return errors.Wrapf(err, "[sqlite3:contact_lists] res.RowsAffected failed")
+		}
+		if n == 0 {
+			return store.NewStoreError(store.ErrContactListNotFound, nil)
+		}
+		return nil
+	})
+}
+
+// SetContacts adds contacts to a list in a single transaction, replacing
+// the attributes, locale and timezone of any address already on the list.
+// If the list does not exist an error of type store.ErrContactListNotFound
+// is returned.
+func (s *Store) SetContacts(ctx context.Context, projectID, listID string, contacts []store.SetContact) error {
+	return s.execTx(ctx, func(q *Queries) error {
+		const listQuery = `
+update contact_lists
+set
+  modified_at = :modified_at
+where
+  project_id = :project_id and list_id = :list_id
+`
+		now := store.Datetime(time.Now().UTC())
+		res, err := q.readwrite.ExecContext(ctx, listQuery,
+			sql.Named("modified_at", &now),
+			sql.Named("project_id", projectID),
+			sql.Named("list_id", listID),
+		)
+		if err != nil {
+			return errors.Wrapf(err,
+				"[sqlite3:contact_lists] exec failed query=%q", listQuery)
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return errors.Wrapf(err, "[sqlite3:contact_lists] res.RowsAffected failed")
+		}
+		if n == 0 {
+			return store.NewStoreError(store.ErrContactListNotFound, nil)
+		}
+
+		const query = `
+insert into contacts
+  (project_id, list_id, email, attributes, locale, timezone, created_at,
+   modified_at)
+values
+  (:project_id, :list_id, :email, :attributes, :locale, :timezone,
+   :created_at, :modified_at)
+on conflict (project_id, list_id, email) do update set
+  attributes = excluded.attributes,
+  locale = excluded.locale,
+  timezone = excluded.timezone,
+  modified_at = excluded.modified_at
+`
+		for _, c := range contacts {
+			if _, err := q.readwrite.ExecContext(ctx, query,
+				sql.Named("project_id", projectID),
+				sql.Named("list_id", listID),
+				sql.Named("email", c.Email),
+				sql.Named("attributes", c.Attributes),
+				sql.Named("locale", c.Locale),
+				sql.Named("timezone", c.Timezone),
+				sql.Named("created_at", &now),
+				sql.Named("modified_at", &now),
+			); err != nil {
+				return errors.Wrapf(err,
+					"[sqlite3:contacts] exec failed query=%q", query)
+			}
+		}
+		return nil
+	})
+}
+
+// ListContacts lists the contacts of a list ordered by address.
+func (q *Queries) ListContacts(ctx context.Context, params store.ListContacts) ([]*store.Contact, error) {
+	const query = `
+select` + contactColumns + `
+from contacts
+where
+  project_id = :project_id and list_id = :list_id and email > :after
+order by email
+limit :limit
+`
+	limit := params.Limit
+	if limit <= 0 {
+		limit = -1
+	}
+	rows, err := q.readonly.QueryContext(ctx, query,
+		sql.Named("project_id", params.ProjectID),
+		sql.Named("list_id", params.ListID),
+		sql.Named("after", params.After),
+		sql.Named("limit", limit),
+	)
+	if err != nil {
+		return nil, errors.Wrapf(err,
+			"[sqlite3:contacts] query failed query=%q", query)
+	}
+	defer rows.Close()
+
+	list := make([]*store.Contact, 0)
+	for rows.Next() {
+		r, err := scanContact(rows)
+		if err != nil {
+			return nil, errors.Wrapf(err,
+				"[sqlite3:contacts] rows scan failed query=%q", query)
+		}
+		list = append(list, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrapf(err,
+			"[sqlite3:contacts] rows.Err failed query=%q", query)
+	}
+	return list, nil
+}
+
+// DeleteContact removes an address from a contact list. If the address is
+// not on the list an error of type store.ErrContactNotFound is returned.
+func (q *Queries) DeleteContact(ctx context.Context, projectID, listID, email string) error {
+	const query = `
+delete from contacts
+where
+  project_id = :project_id and list_id = :list_id and email = :email
+`
+	res, err := q.readwrite.ExecContext(ctx, query,
+		sql.Named("project_id", projectID),
+		sql.Named("list_id", listID),
+		sql.Named("email", email),
+	)
+	if err != nil {
+		return errors.Wrapf(err,
+			"[sqlite3:contacts] exec failed query=%q", query)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return errors.Wrapf(err, "[sqlite3:contacts] res.RowsAffected failed")
+	}
+	if n == 0 {
+		return store.NewStoreError(store.ErrContactNotFound, nil)
+	}
+	return nil
+}
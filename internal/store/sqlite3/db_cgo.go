@@ -3,31 +3,97 @@
 package sqlite3
 
 import (
+	"context"
 	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
 
 	gosqlite3 "github.com/mattn/go-sqlite3"
+	"github.com/pkg/errors"
 )
 
 const DriverName = "squishy_mailer_lite_sqlite3"
 
-func init() {
-	sql.Register(DriverName,
-		&gosqlite3.SQLiteDriver{
-			ConnectHook: func(conn *gosqlite3.SQLiteConn) error {
-				_, err := conn.Exec(`
-				PRAGMA busy_timeout       = 10000;
-				PRAGMA journal_mode       = WAL;
-				PRAGMA journal_size_limit = 200000000;
-				PRAGMA synchronous        = NORMAL;
-				PRAGMA foreign_keys       = ON;
-				PRAGMA temp_store         = MEMORY;
-				PRAGMA cache_size         = -16000;
-			`, nil)
-
-				return err
-			},
+// Pragmas are the settings applied to every new connection opened by
+// OpenDBWithPragmas.
+type Pragmas struct {
+	// JournalMode is one of DELETE, TRUNCATE, PERSIST, MEMORY, WAL or OFF.
+	JournalMode string
+
+	// Synchronous is one of OFF, NORMAL, FULL or EXTRA.
+	Synchronous string
+
+	// BusyTimeout is how long a connection waits for a lock held by
+	// another connection before failing with SQLITE_BUSY.
+	BusyTimeout time.Duration
+
+	// ForeignKeys enables foreign key constraints.
+	ForeignKeys bool
+}
+
+// DefaultPragmas are the pragmas used by OpenDB.
+var DefaultPragmas = Pragmas{
+	JournalMode: "WAL",
+	Synchronous: "NORMAL",
+	BusyTimeout: 10 * time.Second,
+	ForeignKeys: true,
+}
+
+var (
+	journalModes = []string{"DELETE", "TRUNCATE", "PERSIST", "MEMORY", "WAL", "OFF"}
+	synchronous  = []string{"OFF", "NORMAL", "FULL", "EXTRA"}
+)
+
+// statements returns the PRAGMA statements that apply p. The busy timeout
+// comes first so that changing the journal mode waits for other
+// connections rather than failing.
+func (p Pragmas) statements() (string, error) {
+	journalMode := strings.ToUpper(p.JournalMode)
+	if !slices.Contains(journalModes, journalMode) {
+		return "", errors.Errorf("invalid journal_mode %q", p.JournalMode)
+	}
+	sync := strings.ToUpper(p.Synchronous)
+	if !slices.Contains(synchronous, sync) {
+		return "", errors.Errorf("invalid synchronous %q", p.Synchronous)
+	}
+	if p.BusyTimeout < 0 {
+		return "", errors.Errorf("invalid busy_timeout %s", p.BusyTimeout)
+	}
+	foreignKeys := "OFF"
+	if p.ForeignKeys {
+		foreignKeys = "ON"
+	}
+
+	return fmt.Sprintf(`
+		PRAGMA busy_timeout       = %d;
+		PRAGMA journal_mode       = %s;
+		PRAGMA journal_size_limit = 200000000;
+		PRAGMA synchronous        = %s;
+		PRAGMA foreign_keys       = %s;
+		PRAGMA temp_store         = MEMORY;
+		PRAGMA cache_size         = -16000;
+	`, p.BusyTimeout.Milliseconds(), journalMode, sync, foreignKeys), nil
+}
+
+// newDriver returns a driver that applies pragmas to each new connection.
+func newDriver(pragmas string) *gosqlite3.SQLiteDriver {
+	return &gosqlite3.SQLiteDriver{
+		ConnectHook: func(conn *gosqlite3.SQLiteConn) error {
+			_, err := conn.Exec(pragmas, nil)
+			return err
 		},
-	)
+	}
+}
+
+func init() {
+	pragmas, err := DefaultPragmas.statements()
+	if err != nil {
+		panic(err)
+	}
+	sql.Register(DriverName, newDriver(pragmas))
 }
 
 func OpenDB(dbPath string) (*sql.DB, error) {
@@ -38,3 +104,28 @@ func OpenDB(dbPath string) (*sql.DB, error) {
 
 	return db, nil
 }
+
+// OpenDBWithPragmas opens the database at dbPath, applying p to every new
+// connection. It returns an error if any of the pragmas is invalid.
+func OpenDBWithPragmas(dbPath string, p Pragmas) (*sql.DB, error) {
+	pragmas, err := p.statements()
+	if err != nil {
+		return nil, errors.Wrapf(err, "[sqlite3] invalid pragmas")
+	}
+	return sql.OpenDB(&connector{driver: newDriver(pragmas), dsn: dbPath}), nil
+}
+
+// connector opens connections with a driver that is not registered with
+// database/sql, so that each database can have its own pragmas.
+type connector struct {
+	driver *gosqlite3.SQLiteDriver
+	dsn    string
+}
+
+func (c *connector) Connect(context.Context) (driver.Conn, error) {
+	return c.driver.Open(c.dsn)
+}
+
+func (c *connector) Driver() driver.Driver {
+	return c.driver
+}
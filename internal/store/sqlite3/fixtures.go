@@ -0,0 +1,158 @@
+package sqlite3
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/andyfusniak/squishy-mailer-lite/internal/store"
+	"github.com/mattn/go-sqlite3"
+	"github.com/pkg/errors"
+)
+
+// SetTemplateFixture creates or replaces the named fixture of a template.
+// If the template does not exist an error of type store.ErrTemplateNotFound
+// is returned.
+func (q *Queries) SetTemplateFixture(ctx context.Context, params store.SetTemplateFixture) (*store.TemplateFixture, error) {
+	const query = `
+insert into template_fixtures
+  (template_id, project_id, name, params, created_at, modified_at)
+values
+  (:template_id, :project_id, :name, :params, :created_at, :modified_at)
+on conflict (template_id, project_id, name) do update set
+  params = excluded.params,
+  modified_at = excluded.modified_at
+returning
+  template_id, project_id, name, params, created_at, modified_at
+`
+	var r store.TemplateFixture
+	now := store.Datetime(time.Now().UTC())
+	if err := q.readwrite.QueryRowContext(ctx, query,
+		sql.Named("template_id", params.TemplateID),
+		sql.Named("project_id", params.ProjectID),
+		sql.Named("name", params.Name),
+		sql.Named("params", params.Params),
+		sql.Named("created_at", &now),
+		sql.Named("modified_at", &now),
+	).Scan(
+		&r.TemplateID,
+		&r.ProjectID,
+		&r.Name,
+		&r.Params,
+		&r.CreatedAt,
+		&r.ModifiedAt,
+	); err != nil {
+		if serr, ok := err.(sqlite3.Error); ok {
+			if serr.Code == sqlite3.ErrConstraint && serr.ExtendedCode == sqlite3.ErrConstraintForeignKey {
+				return nil, store.NewStoreError(store.ErrTemplateNotFound, serr)
+			}
+		}
+		return nil, errors.Wrapf(err,
+			"[sqlite3:template_fixtures] query row scan failed query=%q", query)
+	}
+	return &r, nil
+}
+
+// GetTemplateFixture retrieves the named fixture of a template. If the
+// fixture does not exist an error of type store.ErrFixtureNotFound is
+// returned.
+func (q *Queries) GetTemplateFixture(ctx context.Context, projectID, templateID, name string) (*store.TemplateFixture, error) {
+	const query = `
+select
+  template_id, project_id, name, params, created_at, modified_at
+from template_fixtures
+where
+  project_id = :project_id and template_id = :template_id and name = :name
+`
+	var r store.TemplateFixture
+	if err := q.queryRow(ctx, query,
+		sql.Named("project_id", projectID),
+		sql.Named("template_id", templateID),
+		sql.Named("name", name),
+	).Scan(
+		&r.TemplateID,
+		&r.ProjectID,
+		&r.Name,
+		&r.Params,
+		&r.CreatedAt,
+		&r.ModifiedAt,
+	); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, store.NewStoreError(store.ErrFixtureNotFound, err)
+		}
+		return nil, errors.Wrapf(err,
+			"[sqlite3:template_fixtures] query row scan failed query=%q", query)
+	}
+	return &r, nil
+}
+
+// ListTemplateFixtures lists the fixtures of a template ordered by name.
+func (q *Queries) ListTemplateFixtures(ctx context.Context, projectID, templateID string) ([]*store.TemplateFixture, error) {
+	const query = `
+select
+  template_id, project_id, name, params, created_at, modified_at
+from template_fixtures
+where
+  project_id = :project_id and template_id = :template_id
+order by name
+`
+	rows, err := q.readonly.QueryContext(ctx, query,
+		sql.Named("project_id", projectID),
+		sql.Named("template_id", templateID),
+	)
+	if err != nil {
+		return nil, errors.Wrapf(err,
+			"[sqlite3:template_fixtures] query failed query=%q", query)
+	}
+	defer rows.Close()
+
+	list := make([]*store.TemplateFixture, 0)
+	for rows.Next() {
+		var r store.TemplateFixture
+		if err := rows.Scan(
+			&r.TemplateID,
+			&r.ProjectID,
+			&r.Name,
+			&r.Params,
+			&r.CreatedAt,
+			&r.ModifiedAt,
+		); err != nil {
+			return nil, errors.Wrapf(err,
+				"[sqlite3:template_fixtures] rows scan failed query=%q", query)
+		}
+		list = append(list, &r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrapf(err,
+			"[sqlite3:template_fixtures] rows.Err failed query=%q", query)
+	}
+	return list, nil
+}
+
+// DeleteTemplateFixture deletes the named fixture of a template. If the
+// fixture does not exist an error of type store.ErrFixtureNotFound is
+// returned.
+func (q *Queries) DeleteTemplateFixture(ctx context.Context, projectID, templateID, name string) error {
+	const query = `
+delete from template_fixtures
+where
+  project_id = :project_id and template_id = :template_id and name = :name
+`
+	res, err := q.readwrite.ExecContext(ctx, query,
+		sql.Named("project_id", projectID),
+		sql.Named("template_id", templateID),
+		sql.Named("name", name),
+	)
+	if err != nil {
+		return errors.Wrapf(err,
+			"[sqlite3:template_fixtures] exec failed query=%q", query)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return errors.Wrapf(err, "[sqlite3:template_fixtures] res.RowsAffected failed")
+	}
+	if n == 0 {
+		return store.NewStoreError(store.ErrFixtureNotFound, nil)
+	}
+	return nil
+}
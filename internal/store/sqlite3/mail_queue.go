@@ -0,0 +1,819 @@
+package sqlite3
+
+import (
+	"context"
+	"database/sql"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/andyfusniak/squishy-mailer-lite/internal/store"
+	"github.com/mattn/go-sqlite3"
+	"github.com/pkg/errors"
+)
+
+const mailQueueColumns = `
+  mail_queue_id, project_id, template_id, transport_id, mstate,
+  metadata, body, last_error, attempts, send_at, next_attempt_at,
+  deferral_reason, message_id, sent_at, sent_transport_id, campaign_id,
+  created_at, modified_at
+`
+
+const mailQueueAttemptColumns = `
+  mail_queue_id, project_id, attempt, transport_id, mstate, error,
+  response_code, response, started_at, created_at
+`
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanMailQueue(row rowScanner) (*store.MailQueue, error) {
+	var r store.MailQueue
+	var sentAt string
+	if err := row.Scan(
+		&r.MailQueueID,
+		&r.ProjectID,
+		&r.TemplateID,
+		&r.TransportID,
+		&r.MState,
+		&r.Metadata,
+		&r.Body,
+		&r.LastError,
+		&r.Attempts,
+		&r.SendAt,
+		&r.NextAttemptAt,
+		&r.DeferralReason,
+		&r.MessageID,
+		&sentAt,
+		&r.SentTransportID,
+		&r.CampaignID,
+		&r.CreatedAt,
+		&r.ModifiedAt,
+	); err != nil {
+		return nil, err
+	}
+	// sent_at is empty until the email is sent
+	if sentAt != "" {
+		if err := r.SentAt.Scan(sentAt); err != nil {
+			return nil, err
+		}
+	}
+	return &r, nil
+}
+
+// InsertMailQueue inserts a new email into the mail queue.
+func (q *Queries) InsertMailQueue(ctx context.Context, params store.AddMailQueue) (*store.MailQueue, error) {
+	const query = `
+insert into mail_queue
+  (mail_queue_id, project_id, template_id, transport_id, mstate,
+   metadata, body, last_error, message_id, campaign_id, send_at,
+   next_attempt_at, created_at, modified_at)
+values
+  (:mail_queue_id, :project_id, :template_id, :transport_id, :mstate,
+   :metadata, :body, :last_error, :message_id, :campaign_id, :send_at,
+   :next_attempt_at, :created_at, :modified_at)
+returning` + mailQueueColumns
+
+	now := store.Datetime(time.Now().UTC())
+	sendAt := now
+	if !time.Time(params.SendAt).IsZero() {
+		sendAt = params.SendAt
+	}
+	r, err := scanMailQueue(q.readwrite.QueryRowContext(ctx, query,
+		sql.Named("mail_queue_id", params.MailQueueID),
+		sql.Named("project_id", params.ProjectID),
+		sql.Named("template_id", params.TemplateID),
+		sql.Named("transport_id", params.TransportID),
+		sql.Named("mstate", params.MState),
+		sql.Named("metadata", params.Metadata),
+		sql.Named("body", params.Body),
+		sql.Named("last_error", params.LastError),
+		sql.Named("message_id", params.MessageID),
+		sql.Named("campaign_id", params.CampaignID),
+		sql.Named("send_at", &sendAt),
+		sql.Named("next_attempt_at", &sendAt),
+		sql.Named("created_at", &now),
+		sql.Named("modified_at", &now),
+	))
+	if err != nil {
+		if serr, ok := err.(sqlite3.Error); ok {
+			if serr.Code == sqlite3.ErrConstraint && serr.ExtendedCode == sqlite3.ErrConstraintForeignKey {
+				return nil, store.NewStoreError(store.ErrProjectNotFound, serr)
+			}
+		}
+		return nil, errors.Wrapf(err,
+			"[sqlite3:mail_queue] query row scan failed query=%q", query)
+	}
+	return r, nil
+}
+
+// GetMailQueue gets an email from the mail queue by projectID and
+// mailQueueID. If the email is not found, an error of type
+// store.ErrMailQueueNotFound is returned.
+func (q *Queries) GetMailQueue(ctx context.Context, projectID, mailQueueID string) (*store.MailQueue, error) {
+	const query = `
+select` + mailQueueColumns + `
+from mail_queue
+where
+  project_id = :project_id and mail_queue_id = :mail_queue_id
+`
+	r, err := scanMailQueue(q.queryRow(ctx, query,
+		sql.Named("project_id", projectID),
+		sql.Named("mail_queue_id", mailQueueID),
+	))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, store.NewStoreError(store.ErrMailQueueNotFound, err)
+		}
+		return nil, errors.Wrapf(err,
+			"[sqlite3:mail_queue] query row scan failed query=%q", query)
+	}
+	return r, nil
+}
+
+// GetMailQueueByMessageID gets an email from the mail queue by projectID
+// and the Message-ID header of the email. If the email is not found, an
+// error of type store.ErrMailQueueNotFound is returned.
+func (q *Queries) GetMailQueueByMessageID(ctx context.Context, projectID, messageID string) (*store.MailQueue, error) {
+	const query = `
+select` + mailQueueColumns + `
+from mail_queue
+where
+  project_id = :project_id and message_id = :message_id and message_id != ''
+order by created_at desc
+limit 1
+`
+	r, err := scanMailQueue(q.queryRow(ctx, query,
+		sql.Named("project_id", projectID),
+		sql.Named("message_id", messageID),
+	))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, store.NewStoreError(store.ErrMailQueueNotFound, err)
+		}
+		return nil, errors.Wrapf(err,
+			"[sqlite3:mail_queue] query row scan failed query=%q", query)
+	}
+	return r, nil
+}
+
+// ClaimMailQueue moves up to limit queued or rate limited emails whose next
+// attempt is due to the sending state and returns them. Emails that have
+// been sending for longer than lease are reclaimed unless lease is zero or
+// less. The select and update happen in a single statement so two workers
+// can never claim the same email.
+func (q *Queries) ClaimMailQueue(ctx context.Context, limit int, lease time.Duration) ([]*store.MailQueue, error) {
+	const query = `
+update mail_queue
+set
+  mstate = :sending, modified_at = :modified_at
+where mail_queue_id in (
+  select mail_queue_id
+  from mail_queue
+  where (mstate in (:queued, :rate_limited) and next_attempt_at <= :now)
+    or (mstate = :sending and modified_at < :lease_expired)
+  order by created_at, rowid
+  limit :limit
+)
+returning` + mailQueueColumns
+
+	now := store.Datetime(time.Now().UTC())
+	var leaseExpired store.Datetime
+	if lease > 0 {
+		leaseExpired = store.Datetime(time.Time(now).Add(-lease))
+	}
+	rows, err := q.readwrite.QueryContext(ctx, query,
+		sql.Named("sending", store.MailQueueStateSending),
+		sql.Named("modified_at", &now),
+		sql.Named("queued", store.MailQueueStateQueued),
+		sql.Named("rate_limited", store.MailQueueStateRateLimited),
+		sql.Named("now", &now),
+		sql.Named("lease_expired", &leaseExpired),
+		sql.Named("limit", limit),
+	)
+	if err != nil {
+		return nil, errors.Wrapf(err,
+			"[sqlite3:mail_queue] query failed query=%q", query)
+	}
+	defer rows.Close()
+
+	list := make([]*store.MailQueue, 0, limit)
+	for rows.Next() {
+		r, err := scanMailQueue(rows)
+		if err != nil {
+			return nil, errors.Wrapf(err,
+				"[sqlite3:mail_queue] rows scan failed query=%q", query)
+		}
+		list = append(list, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrapf(err,
+			"[sqlite3:mail_queue] rows.Err failed query=%q", query)
+	}
+
+	// the returning clause does not guarantee any order
+	sortMailQueue(list)
+	return list, nil
+}
+
+// UpdateMailQueueState sets the state, last error and deferral reason of an
+// email in the mail queue. If params.Body, params.NextAttemptAt or
+// params.Attempts are non-nil they are also replaced.
+func (q *Queries) UpdateMailQueueState(ctx context.Context, params store.UpdateMailQueueState) (*store.MailQueue, error) {
+	const query = `
+update mail_queue
+set
+  mstate = :mstate,
+  last_error = :last_error,
+  deferral_reason = :deferral_reason,
+  sent_at = case when :mstate = :sent then :modified_at else sent_at end,
+  sent_transport_id = case
+    when :mstate = :sent then coalesce(nullif(:sent_transport_id, ''), transport_id)
+    else sent_transport_id
+  end,
+  next_attempt_at = coalesce(:next_attempt_at, next_attempt_at),
+  attempts = coalesce(:attempts, attempts),
+  body = coalesce(:body, body),
+  modified_at = :modified_at
+where
+  mail_queue_id = :mail_queue_id
+returning` + mailQueueColumns
+
+	var body, nextAttemptAt, attempts any
+	if params.Body != nil {
+		body = *params.Body
+	}
+	if params.NextAttemptAt != nil {
+		nextAttemptAt = params.NextAttemptAt
+	}
+	if params.Attempts != nil {
+		attempts = *params.Attempts
+	}
+	now := store.Datetime(time.Now().UTC())
+	r, err := scanMailQueue(q.readwrite.QueryRowContext(ctx, query,
+		sql.Named("mstate", params.MState),
+		sql.Named("last_error", params.LastError),
+		sql.Named("deferral_reason", params.DeferralReason),
+		sql.Named("sent", store.MailQueueStateSent),
+		sql.Named("sent_transport_id", params.SentTransportID),
+		sql.Named("next_attempt_at", nextAttemptAt),
+		sql.Named("attempts", attempts),
+		sql.Named("body", body),
+		sql.Named("modified_at", &now),
+		sql.Named("mail_queue_id", params.MailQueueID),
+	))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, store.NewStoreError(store.ErrMailQueueNotFound, err)
+		}
+		return nil, errors.Wrapf(err,
+			"[sqlite3:mail_queue] query row scan failed query=%q", query)
+	}
+	return r, nil
+}
+
+// CountMailQueueSent counts the emails sent by a transport since the given
+// time, including those it sent as a fallback for another transport. An
+// empty transportID counts the emails sent by every transport in the
+// project.
+func (q *Queries) CountMailQueueSent(ctx context.Context, projectID, transportID string, since store.Datetime) (int, error) {
+	const query = `
+select count(*)
+from mail_queue
+where
+  project_id = :project_id and
+  (:transport_id = '' or sent_transport_id = :transport_id) and
+  mstate = :sent and sent_at >= :since
+`
+	var n int
+	if err := q.readwrite.QueryRowContext(ctx, query,
+		sql.Named("project_id", projectID),
+		sql.Named("transport_id", transportID),
+		sql.Named("sent", store.MailQueueStateSent),
+		sql.Named("since", &since),
+	).Scan(&n); err != nil {
+		return 0, errors.Wrapf(err,
+			"[sqlite3:mail_queue] query row scan failed query=%q", query)
+	}
+	return n, nil
+}
+
+// ListMailQueue lists the emails in the mail queue of a project, newest
+// first. If params.After is set the list starts after that email.
+func (q *Queries) ListMailQueue(ctx context.Context, params store.ListMailQueue) ([]*store.MailQueue, error) {
+	const query = `
+select` + mailQueueColumns + `
+from mail_queue
+where
+  project_id = :project_id and
+  (:mstate = '' or mstate = :mstate) and
+  not exists (
+    select 1 from json_each(:labels) as l
+    where not exists (
+      select 1 from json_each(mail_queue.metadata, '$.labels') as ml
+      where ml.key = l.key and ml.value = l.value
+    )
+  ) and
+  (:after = '' or (created_at, rowid) < (
+    select created_at, rowid from mail_queue where mail_queue_id = :after
+  ))
+order by created_at desc, rowid desc
+limit :limit
+`
+	limit := params.Limit
+	if limit <= 0 {
+		limit = -1
+	}
+	rows, err := q.readonly.QueryContext(ctx, query,
+		sql.Named("project_id", params.ProjectID),
+		sql.Named("mstate", params.MState),
+		sql.Named("labels", store.JSONObject(params.Labels)),
+		sql.Named("after", params.After),
+		sql.Named("limit", limit),
+	)
+	if err != nil {
+		return nil, errors.Wrapf(err,
+			"[sqlite3:mail_queue] query failed query=%q", query)
+	}
+	defer rows.Close()
+
+	list := make([]*store.MailQueue, 0)
+	for rows.Next() {
+		r, err := scanMailQueue(rows)
+		if err != nil {
+			return nil, errors.Wrapf(err,
+				"[sqlite3:mail_queue] rows scan failed query=%q", query)
+		}
+		list = append(list, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrapf(err,
+			"[sqlite3:mail_queue] rows.Err failed query=%q", query)
+	}
+	return list, nil
+}
+
+// TransitionMailQueueState moves an email from one of params.From to
+// params.MState. The state is checked and changed in a single transaction
+// so the email cannot be claimed by a worker in between. If the email is
+// not found an error of type store.ErrMailQueueNotFound is returned, and
+// if it is in none of the states an error of type store.ErrMailQueueState.
+func (s *Store) TransitionMailQueueState(ctx context.Context, params store.TransitionMailQueueState) (*store.MailQueue, error) {
+	const query = `
+update mail_queue
+set
+  mstate = :mstate,
+  deferral_reason = '',
+  next_attempt_at = coalesce(:next_attempt_at, next_attempt_at),
+  last_error = coalesce(:last_error, last_error),
+  attempts = coalesce(:attempts, attempts),
+  transport_id = coalesce(:transport_id, transport_id),
+  modified_at = :modified_at
+where
+  mail_queue_id = :mail_queue_id
+returning` + mailQueueColumns
+
+	var r *store.MailQueue
+	err := s.execTx(ctx, func(q *Queries) error {
+		var mstate string
+		if err := q.readwrite.QueryRowContext(ctx, `
+select mstate from mail_queue
+where project_id = :project_id and mail_queue_id = :mail_queue_id
+`,
+			sql.Named("project_id", params.ProjectID),
+			sql.Named("mail_queue_id", params.MailQueueID),
+		).Scan(&mstate); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return store.NewStoreError(store.ErrMailQueueNotFound, err)
+			}
+			return errors.Wrapf(err, "[sqlite3:mail_queue] query row scan failed")
+		}
+		if !slices.Contains(params.From, mstate) {
+			return store.NewStoreError(store.ErrMailQueueState,
+				errors.Errorf("mail queue entry %s is %s", params.MailQueueID, mstate))
+		}
+
+		var nextAttemptAt, lastError, attempts, transportID any
+		if params.NextAttemptAt != nil {
+			nextAttemptAt = params.NextAttemptAt
+		}
+		if params.LastError != nil {
+			lastError = *params.LastError
+		}
+		if params.Attempts != nil {
+			attempts = *params.Attempts
+		}
+		if params.TransportID != nil {
+			transportID = *params.TransportID
+		}
+		now := store.Datetime(time.Now().UTC())
+		var err error
+		r, err = scanMailQueue(q.readwrite.QueryRowContext(ctx, query,
+			sql.Named("mstate", params.MState),
+			sql.Named("next_attempt_at", nextAttemptAt),
+			sql.Named("last_error", lastError),
+			sql.Named("attempts", attempts),
+			sql.Named("transport_id", transportID),
+			sql.Named("modified_at", &now),
+			sql.Named("mail_queue_id", params.MailQueueID),
+		))
+		if err != nil {
+			return errors.Wrapf(err,
+				"[sqlite3:mail_queue] query row scan failed query=%q", query)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// InsertMailQueueAttempt records the outcome of a delivery attempt. If the
+// email does not exist an error of type store.ErrMailQueueNotFound is
+// returned.
+func (q *Queries) InsertMailQueueAttempt(ctx context.Context, params store.AddMailQueueAttempt) (*store.MailQueueAttempt, error) {
+	const query = `
+insert into mail_queue_attempts
+  (mail_queue_id, project_id, attempt, transport_id, mstate, error,
+   response_code, response, started_at, created_at)
+select
+  :mail_queue_id, :project_id, coalesce(max(attempt), 0) + 1, :transport_id,
+  :mstate, :error, :response_code, :response, :started_at, :created_at
+from mail_queue_attempts
+where
+  mail_queue_id = :mail_queue_id
+returning` + mailQueueAttemptColumns
+	now := store.Datetime(time.Now().UTC())
+	startedAt := params.StartedAt
+	if time.Time(startedAt).IsZero() {
+		startedAt = now
+	}
+	var r store.MailQueueAttempt
+	if err := q.readwrite.QueryRowContext(ctx, query,
+		sql.Named("mail_queue_id", params.MailQueueID),
+		sql.Named("project_id", params.ProjectID),
+		sql.Named("transport_id", params.TransportID),
+		sql.Named("mstate", params.MState),
+		sql.Named("error", params.Error),
+		sql.Named("response_code", params.ResponseCode),
+		sql.Named("response", params.Response),
+		sql.Named("started_at", &startedAt),
+		sql.Named("created_at", &now),
+	).Scan(
+		&r.MailQueueID,
+		&r.ProjectID,
+		&r.Attempt,
+		&r.TransportID,
+		&r.MState,
+		&r.Error,
+		&r.ResponseCode,
+		&r.Response,
+		&r.StartedAt,
+		&r.CreatedAt,
+	); err != nil {
+		if serr, ok := err.(sqlite3.Error); ok {
+			if serr.Code == sqlite3.ErrConstraint && serr.ExtendedCode == sqlite3.ErrConstraintForeignKey {
+				return nil, store.NewStoreError(store.ErrMailQueueNotFound, serr)
+			}
+		}
+		return nil, errors.Wrapf(err,
+			"[sqlite3:mail_queue_attempts] query row scan failed query=%q", query)
+	}
+	return &r, nil
+}
+
+// ListMailQueueAttempts lists the delivery attempts of an email in the
+// order they were made.
+func (q *Queries) ListMailQueueAttempts(ctx context.Context, projectID, mailQueueID string) ([]*store.MailQueueAttempt, error) {
+	const query = `
+select` + mailQueueAttemptColumns + `
+from mail_queue_attempts
+where
+  project_id = :project_id and mail_queue_id = :mail_queue_id
+order by attempt
+`
+	rows, err := q.readonly.QueryContext(ctx, query,
+		sql.Named("project_id", projectID),
+		sql.Named("mail_queue_id", mailQueueID),
+	)
+	if err != nil {
+		return nil, errors.Wrapf(err,
+			"[sqlite3:mail_queue_attempts] query failed query=%q", query)
+	}
+	defer rows.Close()
+
+	list := make([]*store.MailQueueAttempt, 0)
+	for rows.Next() {
+		var r store.MailQueueAttempt
+		if err := rows.Scan(
+			&r.MailQueueID,
+			&r.ProjectID,
+			&r.Attempt,
+			&r.TransportID,
+			&r.MState,
+			&r.Error,
+			&r.ResponseCode,
+			&r.Response,
+			&r.StartedAt,
+			&r.CreatedAt,
+		); err != nil {
+			return nil, errors.Wrapf(err,
+				"[sqlite3:mail_queue_attempts] rows scan failed query=%q", query)
+		}
+		list = append(list, &r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrapf(err,
+			"[sqlite3:mail_queue_attempts] rows.Err failed query=%q", query)
+	}
+	return list, nil
+}
+
+// RecordMailQueueOpen counts an open of an email and returns its updated
+// open counts. If the email does not exist an error of type
+// store.ErrMailQueueNotFound is returned.
+func (q *Queries) RecordMailQueueOpen(ctx context.Context, projectID, mailQueueID string) (*store.MailQueueOpens, error) {
+	const query = `
+insert into mail_queue_opens
+  (mail_queue_id, project_id, opens, first_opened_at, last_opened_at)
+select
+  mail_queue_id, project_id, 1, :opened_at, :opened_at
+from mail_queue
+where
+  project_id = :project_id and mail_queue_id = :mail_queue_id
+on conflict (mail_queue_id) do update set
+  opens = opens + 1,
+  last_opened_at = excluded.last_opened_at
+returning
+  mail_queue_id, project_id, opens, first_opened_at, last_opened_at
+`
+	now := store.Datetime(time.Now().UTC())
+	var r store.MailQueueOpens
+	if err := q.readwrite.QueryRowContext(ctx, query,
+		sql.Named("project_id", projectID),
+		sql.Named("mail_queue_id", mailQueueID),
+		sql.Named("opened_at", &now),
+	).Scan(
+		&r.MailQueueID,
+		&r.ProjectID,
+		&r.Opens,
+		&r.FirstOpenedAt,
+		&r.LastOpenedAt,
+	); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, store.NewStoreError(store.ErrMailQueueNotFound, err)
+		}
+		return nil, errors.Wrapf(err,
+			"[sqlite3:mail_queue_opens] query row scan failed query=%q", query)
+	}
+	return &r, nil
+}
+
+// GetMailQueueOpens gets the open counts of an email. Emails that have not
+// been opened have an Opens count of zero.
+func (q *Queries) GetMailQueueOpens(ctx context.Context, projectID, mailQueueID string) (*store.MailQueueOpens, error) {
+	const query = `
+select
+  mail_queue_id, project_id, opens, first_opened_at, last_opened_at
+from mail_queue_opens
+where
+  project_id = :project_id and mail_queue_id = :mail_queue_id
+`
+	var r store.MailQueueOpens
+	if err := q.queryRow(ctx, query,
+		sql.Named("project_id", projectID),
+		sql.Named("mail_queue_id", mailQueueID),
+	).Scan(
+		&r.MailQueueID,
+		&r.ProjectID,
+		&r.Opens,
+		&r.FirstOpenedAt,
+		&r.LastOpenedAt,
+	); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return &store.MailQueueOpens{MailQueueID: mailQueueID, ProjectID: projectID}, nil
+		}
+		return nil, errors.Wrapf(err,
+			"[sqlite3:mail_queue_opens] query row scan failed query=%q", query)
+	}
+	return &r, nil
+}
+
+// CountMailQueueByDay counts the emails of a project queued in a time range
+// by the UTC day they were queued, template, transport and current state,
+// ordered by day. Test sends are not counted.
+func (q *Queries) CountMailQueueByDay(ctx context.Context, params store.CountMailQueueByDay) ([]*store.MailQueueDayCount, error) {
+	const query = `
+select
+  substr(created_at, 1, 10) as day, template_id, transport_id, mstate, count(*)
+from mail_queue
+where
+  project_id = :project_id and created_at >= :from and created_at < :to and
+  coalesce(json_extract(metadata, '$.test'), 0) = 0
+group by day, template_id, transport_id, mstate
+order by day, template_id, transport_id, mstate
+`
+	rows, err := q.readonly.QueryContext(ctx, query,
+		sql.Named("project_id", params.ProjectID),
+		sql.Named("from", &params.From),
+		sql.Named("to", &params.To),
+	)
+	if err != nil {
+		return nil, errors.Wrapf(err,
+			"[sqlite3:mail_queue] query failed query=%q", query)
+	}
+	defer rows.Close()
+
+	list := make([]*store.MailQueueDayCount, 0)
+	for rows.Next() {
+		var r store.MailQueueDayCount
+		if err := rows.Scan(
+			&r.Day,
+			&r.TemplateID,
+			&r.TransportID,
+			&r.MState,
+			&r.Count,
+		); err != nil {
+			return nil, errors.Wrapf(err,
+				"[sqlite3:mail_queue] rows scan failed query=%q", query)
+		}
+		list = append(list, &r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrapf(err,
+			"[sqlite3:mail_queue] rows.Err failed query=%q", query)
+	}
+	return list, nil
+}
+
+// GetMailQueueStats counts the emails of a project by state, finds the
+// oldest of those waiting to be sent and counts the delivery attempts made
+// at or after since.
+func (q *Queries) GetMailQueueStats(ctx context.Context, projectID string, since store.Datetime) (*store.MailQueueStats, error) {
+	const statesQuery = `
+select mstate, count(*)
+from mail_queue
+where project_id = :project_id
+group by mstate
+`
+	rows, err := q.readonly.QueryContext(ctx, statesQuery,
+		sql.Named("project_id", projectID),
+	)
+	if err != nil {
+		return nil, errors.Wrapf(err,
+			"[sqlite3:mail_queue] query failed query=%q", statesQuery)
+	}
+	defer rows.Close()
+
+	stats := store.MailQueueStats{States: make(map[string]int)}
+	for rows.Next() {
+		var (
+			mstate string
+			n      int
+		)
+		if err := rows.Scan(&mstate, &n); err != nil {
+			return nil, errors.Wrapf(err,
+				"[sqlite3:mail_queue] rows scan failed query=%q", statesQuery)
+		}
+		stats.States[mstate] = n
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrapf(err,
+			"[sqlite3:mail_queue] rows.Err failed query=%q", statesQuery)
+	}
+
+	const oldestQuery = `
+select created_at
+from mail_queue
+where
+  project_id = :project_id and
+  mstate in (select value from json_each(:mstates))
+order by created_at
+limit 1
+`
+	err = q.readonly.QueryRowContext(ctx, oldestQuery,
+		sql.Named("project_id", projectID),
+		sql.Named("mstates", store.JSONArray(store.MailQueuePendingStates)),
+	).Scan(&stats.OldestPendingCreatedAt)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, errors.Wrapf(err,
+			"[sqlite3:mail_queue] query row scan failed query=%q", oldestQuery)
+	}
+
+	const attemptsQuery = `
+select count(*), coalesce(sum(mstate = :failed), 0)
+from mail_queue_attempts
+where project_id = :project_id and created_at >= :since
+`
+	if err := q.readonly.QueryRowContext(ctx, attemptsQuery,
+		sql.Named("project_id", projectID),
+		sql.Named("failed", store.MailQueueStateFailed),
+		sql.Named("since", &since),
+	).Scan(&stats.Attempts, &stats.FailedAttempts); err != nil {
+		return nil, errors.Wrapf(err,
+			"[sqlite3:mail_queue] query row scan failed query=%q", attemptsQuery)
+	}
+	return &stats, nil
+}
+
+func sortMailQueue(list []*store.MailQueue) {
+	sort.SliceStable(list, func(i, j int) bool {
+		return time.Time(list[i].CreatedAt).Before(time.Time(list[j].CreatedAt))
+	})
+}
+
+// expiredMailQueueWhere selects the emails in :mail_queue_ids, or every
+// email if it is null, that have finished delivery and were last modified
+// before :before.
+const expiredMailQueueWhere = `
+  (:mail_queue_ids is null or
+    mail_queue_id in (select value from json_each(:mail_queue_ids))) and
+  mstate in (select value from json_each(:mstates)) and
+  modified_at < :before
+`
+
+// ListExpiredMailQueue lists up to limit emails of every project that have
+// finished delivery and were last modified before the given time, least
+// recently modified first.
+func (q *Queries) ListExpiredMailQueue(ctx context.Context, before store.Datetime, limit int) ([]*store.MailQueue, error) {
+	const query = `
+select` + mailQueueColumns + `
+from mail_queue
+where` + expiredMailQueueWhere + `
+order by modified_at, rowid
+limit :limit
+`
+	rows, err := q.readonly.QueryContext(ctx, query,
+		sql.Named("mail_queue_ids", nil),
+		sql.Named("mstates", store.JSONArray(store.MailQueueFinishedStates)),
+		sql.Named("before", &before),
+		sql.Named("limit", limit),
+	)
+	if err != nil {
+		return nil, errors.Wrapf(err,
+			"[sqlite3:mail_queue] query failed query=%q", query)
+	}
+	defer rows.Close()
+
+	list := make([]*store.MailQueue, 0)
+	for rows.Next() {
+		r, err := scanMailQueue(rows)
+		if err != nil {
+			return nil, errors.Wrapf(err,
+				"[sqlite3:mail_queue] rows scan failed query=%q", query)
+		}
+		list = append(list, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrapf(err,
+			"[sqlite3:mail_queue] rows.Err failed query=%q", query)
+	}
+	return list, nil
+}
+
+// DeleteExpiredMailQueue deletes the emails with the given ids that have
+// finished delivery and were last modified before the given time, along
+// with their delivery attempts and open counts, in a single transaction.
+// It returns the number of emails deleted.
+func (s *Store) DeleteExpiredMailQueue(ctx context.Context, before store.Datetime, mailQueueIDs []string) (int, error) {
+	var n int64
+	err := s.execTx(ctx, func(q *Queries) error {
+		args := []any{
+			sql.Named("mail_queue_ids", store.JSONArray(mailQueueIDs)),
+			sql.Named("mstates", store.JSONArray(store.MailQueueFinishedStates)),
+			sql.Named("before", &before),
+		}
+		for _, table := range []string{"mail_queue_attempts", "mail_queue_opens"} {
+			query := `
+delete from ` + table + `
+where mail_queue_id in (
+  select mail_queue_id from mail_queue
+  where` + expiredMailQueueWhere + `)
+`
+			if _, err := q.readwrite.ExecContext(ctx, query, args...); err != nil {
+				return errors.Wrapf(err,
+					"[sqlite3:%s] exec failed query=%q", table, query)
+			}
+		}
+
+		const query = `
+delete from mail_queue
+where` + expiredMailQueueWhere
+		res, err := q.readwrite.ExecContext(ctx, query, args...)
+		if err != nil {
+			return errors.Wrapf(err,
+				"[sqlite3:mail_queue] exec failed query=%q", query)
+		}
+		n, err = res.RowsAffected()
+		if err != nil {
+			return errors.Wrapf(err, "[sqlite3:mail_queue] res.RowsAffected failed")
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return int(n), nil
+}
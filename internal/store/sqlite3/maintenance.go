@@ -0,0 +1,132 @@
+package sqlite3
+
+import (
+	"context"
+	"os"
+
+	"github.com/andyfusniak/squishy-mailer-lite/internal/store"
+	"github.com/mattn/go-sqlite3"
+	"github.com/pkg/errors"
+)
+
+// Backup copies the database to destPath using the SQLite online backup
+// API. The copy is read through its own connection so, in WAL mode,
+// writes carry on while it is taken. It is written to a temporary file
+// next to destPath that is renamed over destPath once complete.
+func (s *Store) Backup(ctx context.Context, destPath string) error {
+	srcPath, err := s.databasePath(ctx)
+	if err != nil {
+		return err
+	}
+
+	tmpPath := destPath + ".tmp"
+	if err := os.Remove(tmpPath); err != nil && !os.IsNotExist(err) {
+		return errors.Wrapf(err, "[sqlite3] remove %s failed", tmpPath)
+	}
+	if err := backupDB(ctx, srcPath, tmpPath); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := os.Rename(tmpPath, destPath); err != nil {
+		os.Remove(tmpPath)
+		return errors.Wrapf(err, "[sqlite3] rename %s failed", tmpPath)
+	}
+	return nil
+}
+
+// backupDB copies every page of the database at srcPath to a new database
+// at destPath in a single step, so the copy is of one point in time.
+func backupDB(ctx context.Context, srcPath, destPath string) error {
+	src, err := OpenDB(srcPath)
+	if err != nil {
+		return errors.Wrapf(err, "[sqlite3] open %s failed", srcPath)
+	}
+	defer src.Close()
+	dest, err := OpenDB(destPath)
+	if err != nil {
+		return errors.Wrapf(err, "[sqlite3] open %s failed", destPath)
+	}
+	defer dest.Close()
+
+	srcConn, err := src.Conn(ctx)
+	if err != nil {
+		return errors.Wrapf(err, "[sqlite3] src.Conn failed")
+	}
+	defer srcConn.Close()
+	destConn, err := dest.Conn(ctx)
+	if err != nil {
+		return errors.Wrapf(err, "[sqlite3] dest.Conn failed")
+	}
+	defer destConn.Close()
+
+	err = destConn.Raw(func(destDriverConn any) error {
+		return srcConn.Raw(func(srcDriverConn any) error {
+			bk, err := destDriverConn.(*sqlite3.SQLiteConn).Backup("main",
+				srcDriverConn.(*sqlite3.SQLiteConn), "main")
+			if err != nil {
+				return err
+			}
+			if _, err := bk.Step(-1); err != nil {
+				bk.Finish()
+				return err
+			}
+			return bk.Finish()
+		})
+	})
+	if err != nil {
+		return errors.Wrapf(err, "[sqlite3] backup failed")
+	}
+
+	// closing the last connection to the copy checkpoints its log
+	if err := destConn.Close(); err != nil {
+		return errors.Wrapf(err, "[sqlite3] destConn.Close failed")
+	}
+	if err := dest.Close(); err != nil {
+		return errors.Wrapf(err, "[sqlite3] dest.Close failed")
+	}
+	return nil
+}
+
+// Checkpoint copies the write-ahead log into the database and truncates
+// it. A checkpoint that cannot complete because of a long running reader
+// is not an error; the rest of the log is copied by a later checkpoint.
+func (s *Store) Checkpoint(ctx context.Context) error {
+	if _, err := s.readwrite.ExecContext(ctx, `pragma wal_checkpoint(truncate)`); err != nil {
+		return errors.Wrapf(err, "[sqlite3] wal checkpoint failed")
+	}
+	return nil
+}
+
+// Vacuum rebuilds the database file to reclaim unused pages. Writes wait
+// for it to finish.
+func (s *Store) Vacuum(ctx context.Context) error {
+	if _, err := s.readwrite.ExecContext(ctx, `vacuum`); err != nil {
+		return errors.Wrapf(err, "[sqlite3] vacuum failed")
+	}
+	return nil
+}
+
+// databasePath returns the path of the main database file. If the
+// database is held in memory an error of type store.ErrBackupNotSupported
+// is returned.
+func (s *Store) databasePath(ctx context.Context) (string, error) {
+	var path string
+	if err := s.readwrite.QueryRowContext(ctx,
+		`select file from pragma_database_list where name = 'main'`).Scan(&path); err != nil {
+		return "", errors.Wrapf(err, "[sqlite3] query database list failed")
+	}
+	if path == "" {
+		return "", store.NewStoreError(store.ErrBackupNotSupported,
+			errors.New("database is not backed by a file"))
+	}
+	return path, nil
+}
+
+// Ping checks that the database can be reached by opening a connection
+// if none is idle.
+func (s *Store) Ping(ctx context.Context) error {
+	if err := s.readwrite.PingContext(ctx); err != nil {
+		return errors.Wrapf(err, "[sqlite3] ping failed")
+	}
+	return nil
+}
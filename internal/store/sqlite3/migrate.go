@@ -0,0 +1,118 @@
+package sqlite3
+
+import (
+	"context"
+	"io/fs"
+	"net/http"
+	"slices"
+
+	"github.com/andyfusniak/squishy-mailer-lite/internal/store"
+	"github.com/andyfusniak/squishy-mailer-lite/internal/store/sqlite3/schema"
+	"github.com/golang-migrate/migrate/v4"
+	"github.com/golang-migrate/migrate/v4/database"
+	"github.com/golang-migrate/migrate/v4/source/httpfs"
+	"github.com/pkg/errors"
+)
+
+// SchemaVersion returns the migration state of the schema.
+func (s *Store) SchemaVersion(ctx context.Context) (*store.SchemaVersion, error) {
+	mg, err := newMigrate(s.readwrite)
+	if err != nil {
+		return nil, errors.Wrapf(err, "[sqlite3:schema] newMigrate failed")
+	}
+	version, dirty, err := mg.Version()
+	if err != nil && !errors.Is(err, migrate.ErrNilVersion) {
+		return nil, errors.Wrapf(err, "[sqlite3:schema] migrate version failed")
+	}
+	versions, err := migrationVersions()
+	if err != nil {
+		return nil, err
+	}
+	return &store.SchemaVersion{
+		Version: version,
+		Dirty:   dirty,
+		Latest:  versions[len(versions)-1],
+	}, nil
+}
+
+// MigrateTo applies the migrations that take the schema to version. It
+// does nothing if the schema is already at version.
+func (s *Store) MigrateTo(ctx context.Context, version uint) error {
+	mg, err := newMigrate(s.readwrite)
+	if err != nil {
+		return errors.Wrapf(err, "[sqlite3:schema] newMigrate failed")
+	}
+	current, dirty, err := mg.Version()
+	if err != nil && !errors.Is(err, migrate.ErrNilVersion) {
+		return errors.Wrapf(err, "[sqlite3:schema] migrate version failed")
+	}
+	if dirty {
+		return store.NewStoreError(store.ErrSchemaDirty,
+			errors.Errorf("migration %d failed part way through", current))
+	}
+
+	if version == 0 {
+		err = mg.Down()
+	} else {
+		err = mg.Migrate(version)
+	}
+	if err != nil && !errors.Is(err, migrate.ErrNoChange) {
+		if errors.Is(err, fs.ErrNotExist) {
+			return store.NewStoreError(store.ErrSchemaVersionNotFound, err)
+		}
+		return errors.Wrapf(err, "[sqlite3:schema] migrate to version %d failed", version)
+	}
+	return nil
+}
+
+// ForceSchemaVersion records version as the current migration and clears
+// the dirty flag.
+func (s *Store) ForceSchemaVersion(ctx context.Context, version uint) error {
+	versions, err := migrationVersions()
+	if err != nil {
+		return err
+	}
+	if version != 0 && !slices.Contains(versions, version) {
+		return store.NewStoreError(store.ErrSchemaVersionNotFound,
+			errors.Errorf("no migration found for version %d", version))
+	}
+
+	mg, err := newMigrate(s.readwrite)
+	if err != nil {
+		return errors.Wrapf(err, "[sqlite3:schema] newMigrate failed")
+	}
+	v := int(version)
+	if version == 0 {
+		v = database.NilVersion
+	}
+	if err := mg.Force(v); err != nil {
+		return errors.Wrapf(err, "[sqlite3:schema] migrate force version %d failed", version)
+	}
+	return nil
+}
+
+// migrationVersions returns the versions of the embedded migrations in
+// ascending order.
+func migrationVersions() ([]uint, error) {
+	source, err := httpfs.New(http.FS(schema.Migrations), "migrations")
+	if err != nil {
+		return nil, errors.Wrapf(err, "[sqlite3:schema] httpfs.New failed")
+	}
+	defer source.Close()
+
+	v, err := source.First()
+	if err != nil {
+		return nil, errors.Wrapf(err, "[sqlite3:schema] first migration failed")
+	}
+	versions := []uint{v}
+	for {
+		v, err = source.Next(v)
+		if errors.Is(err, fs.ErrNotExist) {
+			return versions, nil
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "[sqlite3:schema] next migration failed")
+		}
+		versions = append(versions, v)
+	}
+}
@@ -0,0 +1,127 @@
+package sqlite3
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/andyfusniak/squishy-mailer-lite/internal/store"
+	"github.com/mattn/go-sqlite3"
+	"github.com/pkg/errors"
+)
+
+// SetPartial creates or replaces a partial template in a group. If the
+// group does not exist an error of type store.ErrGroupNotFound is returned.
+func (q *Queries) SetPartial(ctx context.Context, params store.SetPartial) (*store.Partial, error) {
+	const query = `
+insert into template_partials
+  (partial_name, group_id, project_id, txt, html, created_at, modified_at)
+values
+  (:partial_name, :group_id, :project_id, :txt, :html, :created_at, :modified_at)
+on conflict (partial_name, group_id, project_id) do update set
+  txt = excluded.txt,
+  html = excluded.html,
+  modified_at = excluded.modified_at
+returning
+  partial_name, group_id, project_id, txt, html, created_at, modified_at
+`
+	var r store.Partial
+	now := store.Datetime(time.Now().UTC())
+	if err := q.readwrite.QueryRowContext(ctx, query,
+		sql.Named("partial_name", params.PartialName),
+		sql.Named("group_id", params.GroupID),
+		sql.Named("project_id", params.ProjectID),
+		sql.Named("txt", params.Txt),
+		sql.Named("html", params.HTML),
+		sql.Named("created_at", &now),
+		sql.Named("modified_at", &now),
+	).Scan(
+		&r.PartialName,
+		&r.GroupID,
+		&r.ProjectID,
+		&r.Txt,
+		&r.HTML,
+		&r.CreatedAt,
+		&r.ModifiedAt,
+	); err != nil {
+		if serr, ok := err.(sqlite3.Error); ok {
+			if serr.Code == sqlite3.ErrConstraint && serr.ExtendedCode == sqlite3.ErrConstraintForeignKey {
+				return nil, store.NewStoreError(store.ErrGroupNotFound, serr)
+			}
+		}
+		return nil, errors.Wrapf(err,
+			"[sqlite3:template_partials] query row scan failed query=%q", query)
+	}
+	return &r, nil
+}
+
+// ListPartials lists the partial templates in a group ordered by name.
+func (q *Queries) ListPartials(ctx context.Context, projectID, groupID string) ([]*store.Partial, error) {
+	const query = `
+select
+  partial_name, group_id, project_id, txt, html, created_at, modified_at
+from template_partials
+where
+  project_id = :project_id and group_id = :group_id
+order by partial_name
+`
+	rows, err := q.readonly.QueryContext(ctx, query,
+		sql.Named("project_id", projectID),
+		sql.Named("group_id", groupID),
+	)
+	if err != nil {
+		return nil, errors.Wrapf(err,
+			"[sqlite3:template_partials] query failed query=%q", query)
+	}
+	defer rows.Close()
+
+	list := make([]*store.Partial, 0)
+	for rows.Next() {
+		var r store.Partial
+		if err := rows.Scan(
+			&r.PartialName,
+			&r.GroupID,
+			&r.ProjectID,
+			&r.Txt,
+			&r.HTML,
+			&r.CreatedAt,
+			&r.ModifiedAt,
+		); err != nil {
+			return nil, errors.Wrapf(err,
+				"[sqlite3:template_partials] rows scan failed query=%q", query)
+		}
+		list = append(list, &r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrapf(err,
+			"[sqlite3:template_partials] rows.Err failed query=%q", query)
+	}
+	return list, nil
+}
+
+// DeletePartial deletes a partial template from a group. If the partial
+// does not exist an error of type store.ErrPartialNotFound is returned.
+func (q *Queries) DeletePartial(ctx context.Context, projectID, groupID, name string) error {
+	const query = `
+delete from template_partials
+where
+  project_id = :project_id and group_id = :group_id and partial_name = :partial_name
+`
+	res, err := q.readwrite.ExecContext(ctx, query,
+		sql.Named("project_id", projectID),
+		sql.Named("group_id", groupID),
+		sql.Named("partial_name", name),
+	)
+	if err != nil {
+		return errors.Wrapf(err,
+			"[sqlite3:template_partials] exec failed query=%q", query)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return errors.Wrapf(err, "[sqlite3:template_partials] res.RowsAffected failed")
+	}
+	if n == 0 {
+		return store.NewStoreError(store.ErrPartialNotFound, nil)
+	}
+	return nil
+}
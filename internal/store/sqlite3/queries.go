@@ -25,6 +25,17 @@ func (q *Queries) withTx(tx *sql.Tx) *Queries {
 	}
 }
 
+// queryRow executes a read query that returns at most one row, such as
+// the lookup of a single record by its id. If reads go to a ReplicaDB, a
+// row that is not found on the replica is looked up on the primary so a
+// record can be read back as soon as it has been written.
+func (q *Queries) queryRow(ctx context.Context, query string, args ...any) rowScanner {
+	if r, ok := q.readonly.(*ReplicaDB); ok {
+		return r.QueryRow(ctx, query, args...)
+	}
+	return q.readonly.QueryRowContext(ctx, query, args...)
+}
+
 // NewQueries create a new comments query.
 func NewQueries(ro, rw DBTx) *Queries {
 	return &Queries{
@@ -0,0 +1,203 @@
+package sqlite3
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/andyfusniak/squishy-mailer-lite/internal/store"
+	"github.com/mattn/go-sqlite3"
+	"github.com/pkg/errors"
+)
+
+// SetProjectQuota creates or replaces the quota of a project. If the
+// project does not exist an error of type store.ErrProjectNotFound is
+// returned.
+func (q *Queries) SetProjectQuota(ctx context.Context, params store.SetProjectQuota) (*store.ProjectQuota, error) {
+	const query = `
+insert into project_quotas
+  (project_id, per_day, per_month, action, created_at, modified_at)
+values
+  (:project_id, :per_day, :per_month, :action, :created_at, :modified_at)
+on conflict (project_id) do update set
+  per_day = excluded.per_day,
+  per_month = excluded.per_month,
+  action = excluded.action,
+  modified_at = excluded.modified_at
+returning
+  project_id, per_day, per_month, action, created_at, modified_at
+`
+	var r store.ProjectQuota
+	now := store.Datetime(time.Now().UTC())
+	if err := q.readwrite.QueryRowContext(ctx, query,
+		sql.Named("project_id", params.ProjectID),
+		sql.Named("per_day", params.PerDay),
+		sql.Named("per_month", params.PerMonth),
+		sql.Named("action", params.Action),
+		sql.Named("created_at", &now),
+		sql.Named("modified_at", &now),
+	).Scan(
+		&r.ProjectID,
+		&r.PerDay,
+		&r.PerMonth,
+		&r.Action,
+		&r.CreatedAt,
+		&r.ModifiedAt,
+	); err != nil {
+		if serr, ok := err.(sqlite3.Error); ok {
+			if serr.Code == sqlite3.ErrConstraint && serr.ExtendedCode == sqlite3.ErrConstraintForeignKey {
+				return nil, store.NewStoreError(store.ErrProjectNotFound, serr)
+			}
+		}
+		return nil, errors.Wrapf(err,
+			"[sqlite3:project_quotas] query row scan failed query=%q", query)
+	}
+	return &r, nil
+}
+
+// GetProjectQuota gets the quota of a project. If the project has no
+// quota an error of type store.ErrProjectQuotaNotFound is returned.
+func (q *Queries) GetProjectQuota(ctx context.Context, projectID string) (*store.ProjectQuota, error) {
+	const query = `
+select
+  project_id, per_day, per_month, action, created_at, modified_at
+from project_quotas
+where
+  project_id = :project_id
+`
+	var r store.ProjectQuota
+	if err := q.queryRow(ctx, query,
+		sql.Named("project_id", projectID),
+	).Scan(
+		&r.ProjectID,
+		&r.PerDay,
+		&r.PerMonth,
+		&r.Action,
+		&r.CreatedAt,
+		&r.ModifiedAt,
+	); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, store.NewStoreError(store.ErrProjectQuotaNotFound, err)
+		}
+		return nil, errors.Wrapf(err,
+			"[sqlite3:project_quotas] query row scan failed query=%q", query)
+	}
+	return &r, nil
+}
+
+// DeleteProjectQuota deletes the quota of a project. If the project has
+// no quota an error of type store.ErrProjectQuotaNotFound is returned.
+func (q *Queries) DeleteProjectQuota(ctx context.Context, projectID string) error {
+	const query = `
+delete from project_quotas
+where
+  project_id = :project_id
+`
+	res, err := q.readwrite.ExecContext(ctx, query,
+		sql.Named("project_id", projectID),
+	)
+	if err != nil {
+		return errors.Wrapf(err,
+			"[sqlite3:project_quotas] exec failed query=%q", query)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return errors.Wrapf(err, "[sqlite3:project_quotas] res.RowsAffected failed")
+	}
+	if n == 0 {
+		return store.NewStoreError(store.ErrProjectQuotaNotFound, nil)
+	}
+	return nil
+}
+
+// GetProjectQuotaUsage returns the number of emails counted for a project
+// on a day and in a month. Periods without emails count zero.
+func (q *Queries) GetProjectQuotaUsage(ctx context.Context, projectID, day, month string) (*store.ProjectQuotaUsage, error) {
+	return getProjectQuotaUsage(ctx, q.readonly, projectID, day, month)
+}
+
+func getProjectQuotaUsage(ctx context.Context, db DBTx, projectID, day, month string) (*store.ProjectQuotaUsage, error) {
+	const query = `
+select
+  coalesce(sum(case when period = :day then sent end), 0),
+  coalesce(sum(case when period = :month then sent end), 0)
+from project_quota_usage
+where
+  project_id = :project_id and period in (:day, :month)
+`
+	r := store.ProjectQuotaUsage{ProjectID: projectID, Day: day, Month: month}
+	if err := db.QueryRowContext(ctx, query,
+		sql.Named("project_id", projectID),
+		sql.Named("day", day),
+		sql.Named("month", month),
+	).Scan(&r.DaySent, &r.MonthSent); err != nil {
+		return nil, errors.Wrapf(err,
+			"[sqlite3:project_quota_usage] query row scan failed query=%q", query)
+	}
+	return &r, nil
+}
+
+// ReserveProjectQuota counts an email for a project on a day and in a
+// month unless either would go over its limit, and reports whether it was
+// counted. The check and the update are made in a single transaction.
+func (s *Store) ReserveProjectQuota(ctx context.Context, params store.ReserveProjectQuota) (bool, error) {
+	const query = `
+insert into project_quota_usage
+  (project_id, period, sent)
+values
+  (:project_id, :period, 1)
+on conflict (project_id, period) do update set
+  sent = sent + 1
+`
+	var reserved bool
+	err := s.execTx(ctx, func(q *Queries) error {
+		usage, err := getProjectQuotaUsage(ctx, q.readwrite, params.ProjectID, params.Day, params.Month)
+		if err != nil {
+			return err
+		}
+		if (params.PerDay > 0 && usage.DaySent >= params.PerDay) ||
+			(params.PerMonth > 0 && usage.MonthSent >= params.PerMonth) {
+			return nil
+		}
+		for _, period := range []string{params.Day, params.Month} {
+			if _, err := q.readwrite.ExecContext(ctx, query,
+				sql.Named("project_id", params.ProjectID),
+				sql.Named("period", period),
+			); err != nil {
+				if serr, ok := err.(sqlite3.Error); ok {
+					if serr.Code == sqlite3.ErrConstraint && serr.ExtendedCode == sqlite3.ErrConstraintForeignKey {
+						return store.NewStoreError(store.ErrProjectNotFound, serr)
+					}
+				}
+				return errors.Wrapf(err,
+					"[sqlite3:project_quota_usage] exec failed query=%q", query)
+			}
+		}
+		reserved = true
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+	return reserved, nil
+}
+
+// ReleaseProjectQuota uncounts an email counted by ReserveProjectQuota
+// that was not sent after all. The usage never drops below zero.
+func (q *Queries) ReleaseProjectQuota(ctx context.Context, projectID, day, month string) error {
+	const query = `
+update project_quota_usage set
+  sent = sent - 1
+where
+  project_id = :project_id and period in (:day, :month) and sent > 0
+`
+	if _, err := q.readwrite.ExecContext(ctx, query,
+		sql.Named("project_id", projectID),
+		sql.Named("day", day),
+		sql.Named("month", month),
+	); err != nil {
+		return errors.Wrapf(err,
+			"[sqlite3:project_quota_usage] exec failed query=%q", query)
+	}
+	return nil
+}
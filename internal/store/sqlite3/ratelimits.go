@@ -0,0 +1,129 @@
+package sqlite3
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/andyfusniak/squishy-mailer-lite/internal/store"
+	"github.com/mattn/go-sqlite3"
+	"github.com/pkg/errors"
+)
+
+// SetRateLimit creates or replaces the rate limit for a project and
+// transport. If the project does not exist an error of type
+// store.ErrProjectNotFound is returned.
+func (q *Queries) SetRateLimit(ctx context.Context, params store.SetRateLimit) (*store.RateLimit, error) {
+	const query = `
+insert into rate_limits
+  (project_id, transport_id, per_second, per_minute, per_day, created_at, modified_at)
+values
+  (:project_id, :transport_id, :per_second, :per_minute, :per_day, :created_at, :modified_at)
+on conflict (project_id, transport_id) do update set
+  per_second = excluded.per_second,
+  per_minute = excluded.per_minute,
+  per_day = excluded.per_day,
+  modified_at = excluded.modified_at
+returning
+  project_id, transport_id, per_second, per_minute, per_day, created_at, modified_at
+`
+	var r store.RateLimit
+	now := store.Datetime(time.Now().UTC())
+	if err := q.readwrite.QueryRowContext(ctx, query,
+		sql.Named("project_id", params.ProjectID),
+		sql.Named("transport_id", params.TransportID),
+		sql.Named("per_second", params.PerSecond),
+		sql.Named("per_minute", params.PerMinute),
+		sql.Named("per_day", params.PerDay),
+		sql.Named("created_at", &now),
+		sql.Named("modified_at", &now),
+	).Scan(
+		&r.ProjectID,
+		&r.TransportID,
+		&r.PerSecond,
+		&r.PerMinute,
+		&r.PerDay,
+		&r.CreatedAt,
+		&r.ModifiedAt,
+	); err != nil {
+		if serr, ok := err.(sqlite3.Error); ok {
+			if serr.Code == sqlite3.ErrConstraint && serr.ExtendedCode == sqlite3.ErrConstraintForeignKey {
+				return nil, store.NewStoreError(store.ErrProjectNotFound, serr)
+			}
+		}
+		return nil, errors.Wrapf(err,
+			"[sqlite3:rate_limits] query row scan failed query=%q", query)
+	}
+	return &r, nil
+}
+
+// ListRateLimits lists all the rate limits for a project ordered by
+// transport id. The project wide limit, if any, is first.
+func (q *Queries) ListRateLimits(ctx context.Context, projectID string) ([]*store.RateLimit, error) {
+	const query = `
+select
+  project_id, transport_id, per_second, per_minute, per_day, created_at, modified_at
+from rate_limits
+where
+  project_id = :project_id
+order by transport_id
+`
+	rows, err := q.readonly.QueryContext(ctx, query,
+		sql.Named("project_id", projectID),
+	)
+	if err != nil {
+		return nil, errors.Wrapf(err,
+			"[sqlite3:rate_limits] query failed query=%q", query)
+	}
+	defer rows.Close()
+
+	list := make([]*store.RateLimit, 0)
+	for rows.Next() {
+		var r store.RateLimit
+		if err := rows.Scan(
+			&r.ProjectID,
+			&r.TransportID,
+			&r.PerSecond,
+			&r.PerMinute,
+			&r.PerDay,
+			&r.CreatedAt,
+			&r.ModifiedAt,
+		); err != nil {
+			return nil, errors.Wrapf(err,
+				"[sqlite3:rate_limits] rows scan failed query=%q", query)
+		}
+		list = append(list, &r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrapf(err,
+			"[sqlite3:rate_limits] rows.Err failed query=%q", query)
+	}
+	return list, nil
+}
+
+// DeleteRateLimit deletes the rate limit for a project and transport. If
+// the rate limit does not exist an error of type store.ErrRateLimitNotFound
+// is returned.
+func (q *Queries) DeleteRateLimit(ctx context.Context, projectID, transportID string) error {
+	const query = `
+delete from rate_limits
+where
+  project_id = :project_id and transport_id = :transport_id
+`
+	res, err := q.readwrite.ExecContext(ctx, query,
+		sql.Named("project_id", projectID),
+		sql.Named("transport_id", transportID),
+	)
+	if err != nil {
+		return errors.Wrapf(err,
+			"[sqlite3:rate_limits] exec failed query=%q", query)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return errors.Wrapf(err, "[sqlite3:rate_limits] res.RowsAffected failed")
+	}
+	if n == 0 {
+		return store.NewStoreError(store.ErrRateLimitNotFound, nil)
+	}
+	return nil
+}
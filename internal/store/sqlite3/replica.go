@@ -0,0 +1,105 @@
+package sqlite3
+
+import (
+	"context"
+	"database/sql"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+// ReplicaDB is a DBTx that routes reads to a read replica and falls back to
+// the primary if the replica fails, for example because the replica is
+// unavailable or has not yet caught up with a schema migration. Lookups
+// of a single row that the replica does not have yet are also retried on
+// the primary. Writes
+// always go to the primary. Use it as the read-only DBTx passed to
+// NewStore.
+type ReplicaDB struct {
+	primary DBTx
+	replica DBTx
+}
+
+// NewReplicaDB returns a new ReplicaDB.
+func NewReplicaDB(primary, replica DBTx) *ReplicaDB {
+	return &ReplicaDB{
+		primary: primary,
+		replica: replica,
+	}
+}
+
+// ExecContext executes a query on the primary.
+func (r *ReplicaDB) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	return r.primary.ExecContext(ctx, query, args...)
+}
+
+// QueryContext executes a query on the replica, retrying on the primary if
+// the replica fails.
+func (r *ReplicaDB) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	rows, err := r.replica.QueryContext(ctx, query, args...)
+	if err == nil || ctx.Err() != nil {
+		return rows, err
+	}
+	return r.primary.QueryContext(ctx, query, args...)
+}
+
+// QueryRowContext executes a query that returns at most one row on the
+// replica, retrying on the primary if the replica fails. A query that
+// returns no rows is not a failure; use QueryRow to also retry those.
+func (r *ReplicaDB) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+	row := r.replica.QueryRowContext(ctx, query, args...)
+	if row.Err() == nil || ctx.Err() != nil {
+		return row
+	}
+	return r.primary.QueryRowContext(ctx, query, args...)
+}
+
+// QueryRow is like QueryRowContext but if the replica has no matching row,
+// for example because it has not yet caught up with a write such as the
+// insert of a newly queued email, Scan retries the query on the primary.
+func (r *ReplicaDB) QueryRow(ctx context.Context, query string, args ...any) rowScanner {
+	return &replicaRow{
+		Row:     r.QueryRowContext(ctx, query, args...),
+		ctx:     ctx,
+		primary: r.primary,
+		query:   query,
+		args:    args,
+	}
+}
+
+// replicaRow is a row read from a replica.
+type replicaRow struct {
+	*sql.Row
+	ctx     context.Context
+	primary DBTx
+	query   string
+	args    []any
+}
+
+// Scan copies the columns of the row into dest. If the replica returned
+// no rows, the query is retried on the primary.
+func (r *replicaRow) Scan(dest ...any) error {
+	err := r.Row.Scan(dest...)
+	if !errors.Is(err, sql.ErrNoRows) || r.ctx.Err() != nil {
+		return err
+	}
+	return r.primary.QueryRowContext(r.ctx, r.query, r.args...).Scan(dest...)
+}
+
+// Close closes the replica and the primary.
+func (r *ReplicaDB) Close() error {
+	var replicaErr, primaryErr error
+	if c, ok := r.replica.(io.Closer); ok {
+		replicaErr = c.Close()
+	}
+	if c, ok := r.primary.(io.Closer); ok {
+		primaryErr = c.Close()
+	}
+	if replicaErr != nil {
+		return errors.Wrapf(replicaErr, "[sqlite3] replica close failed")
+	}
+	if primaryErr != nil {
+		return errors.Wrapf(primaryErr, "[sqlite3] primary close failed")
+	}
+	return nil
+}
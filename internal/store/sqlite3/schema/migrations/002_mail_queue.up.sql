@@ -0,0 +1,26 @@
+begin immediate;
+
+--
+-- the mail queue holds rendered emails waiting to be delivered
+-- by the queue worker along with their delivery state (mstate)
+--
+-- mstate is one of queued, sending, sent or failed
+--
+create table if not exists mail_queue (
+  mail_queue_id  text not null,
+  project_id     text not null,
+  template_id    text not null,
+  transport_id   text not null,
+  mstate         text not null,
+  metadata       text not null,
+  body           text not null,
+  last_error     text not null default '',
+  created_at     text not null,
+  modified_at    text not null,
+  primary key (mail_queue_id),
+  constraint mail_queue_project_id_fkey foreign key (project_id) references projects (project_id)
+);
+
+create index if not exists mail_queue_mstate_created_at_idx on mail_queue (mstate, created_at);
+
+commit;
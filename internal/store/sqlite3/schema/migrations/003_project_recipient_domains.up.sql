@@ -0,0 +1,9 @@
+begin immediate;
+
+--
+-- restrict outgoing recipients to a JSON array of domains
+-- an empty array means all domains are allowed
+--
+alter table projects add column allowed_recipient_domains text not null default '[]';
+
+commit;
@@ -0,0 +1,8 @@
+begin immediate;
+
+drop index if exists mail_queue_mstate_next_attempt_at_idx;
+alter table mail_queue drop column deferral_reason;
+alter table mail_queue drop column next_attempt_at;
+drop table if exists send_windows;
+
+commit;
@@ -0,0 +1,28 @@
+begin immediate;
+
+--
+-- send windows restrict delivery to a daily window in a timezone
+-- an empty group_id applies the window to every group in the project
+--
+create table if not exists send_windows (
+  project_id   text not null,
+  group_id     text not null default '',
+  start_time   text not null,
+  end_time     text not null,
+  timezone     text not null,
+  created_at   text not null,
+  modified_at  text not null,
+  primary key (project_id, group_id),
+  constraint send_windows_project_id_fkey foreign key (project_id) references projects (project_id)
+);
+
+--
+-- emails deferred by the worker are held until next_attempt_at
+--
+alter table mail_queue add column next_attempt_at text not null default '';
+alter table mail_queue add column deferral_reason text not null default '';
+update mail_queue set next_attempt_at = created_at;
+
+create index if not exists mail_queue_mstate_next_attempt_at_idx on mail_queue (mstate, next_attempt_at);
+
+commit;
@@ -0,0 +1,8 @@
+begin immediate;
+
+drop index if exists mail_queue_transport_sent_at_idx;
+alter table mail_queue drop column sent_at;
+alter table smtp_transports drop column warmup_started_at;
+alter table smtp_transports drop column warmup_schedule;
+
+commit;
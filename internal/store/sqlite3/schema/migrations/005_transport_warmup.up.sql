@@ -0,0 +1,17 @@
+begin immediate;
+
+--
+-- warm-up schedule as a JSON array of daily sending limits starting
+-- from the day warmup_started_at falls on
+--
+alter table smtp_transports add column warmup_schedule text not null default '[]';
+alter table smtp_transports add column warmup_started_at text not null default '1970-01-01T00:00:00.000000Z';
+
+--
+-- record when an email was sent so daily volumes can be counted
+--
+alter table mail_queue add column sent_at text not null default '';
+
+create index if not exists mail_queue_transport_sent_at_idx on mail_queue (project_id, transport_id, mstate, sent_at);
+
+commit;
@@ -0,0 +1,6 @@
+begin immediate;
+
+alter table projects drop column default_group_id;
+alter table projects drop column default_transport_id;
+
+commit;
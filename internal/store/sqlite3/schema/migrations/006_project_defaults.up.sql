@@ -0,0 +1,10 @@
+begin immediate;
+
+--
+-- the default transport and group are used when a caller omits them
+-- an empty string means the project has no default
+--
+alter table projects add column default_transport_id text not null default '';
+alter table projects add column default_group_id text not null default '';
+
+commit;
@@ -0,0 +1,17 @@
+begin immediate;
+
+--
+-- message catalogs hold translated strings for a project and locale
+-- messages is a JSON object in the go-i18n message file format
+--
+create table if not exists message_catalogs (
+  project_id   text not null,
+  locale       text not null,
+  messages     text not null default '{}',
+  created_at   text not null,
+  modified_at  text not null,
+  primary key (project_id, locale),
+  constraint message_catalogs_project_id_fkey foreign key (project_id) references projects (project_id)
+);
+
+commit;
@@ -0,0 +1,6 @@
+begin immediate;
+
+drop table if exists template_attachments;
+drop table if exists attachments;
+
+commit;
@@ -0,0 +1,35 @@
+begin immediate;
+
+--
+-- attachments are files stored once per project and attached to every
+-- email sent using the templates that reference them
+--
+create table if not exists attachments (
+  attachment_id  text not null,
+  project_id     text not null,
+  filename       text not null,
+  content_type   text not null,
+  content        blob not null,
+  size           integer not null,
+  checksum       text not null,
+  created_at     text not null,
+  modified_at    text not null,
+  primary key (attachment_id, project_id),
+  constraint attachments_project_id_fkey foreign key (project_id) references projects (project_id)
+);
+
+create table if not exists template_attachments (
+  template_id    text not null,
+  attachment_id  text not null,
+  project_id     text not null,
+  position       integer not null,
+  primary key (template_id, attachment_id, project_id),
+  constraint template_attachments_template_id_project_id_fkey
+    foreign key (template_id, project_id)
+    references templates (template_id, project_id) on delete cascade,
+  constraint template_attachments_attachment_id_project_id_fkey
+    foreign key (attachment_id, project_id)
+    references attachments (attachment_id, project_id) on delete cascade
+);
+
+commit;
@@ -0,0 +1,6 @@
+begin immediate;
+
+alter table templates drop column asset_mode;
+drop table if exists assets;
+
+commit;
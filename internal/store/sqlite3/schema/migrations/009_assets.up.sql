@@ -0,0 +1,26 @@
+begin immediate;
+
+--
+-- assets are images referenced from templates using {{asset "id"}}
+--
+create table if not exists assets (
+  asset_id      text not null,
+  project_id    text not null,
+  filename      text not null,
+  content_type  text not null,
+  content       blob not null,
+  size          integer not null,
+  checksum      text not null,
+  created_at    text not null,
+  modified_at   text not null,
+  primary key (asset_id, project_id),
+  constraint assets_project_id_fkey foreign key (project_id) references projects (project_id)
+);
+
+--
+-- asset_mode controls how a template references its assets
+-- cid embeds them as inline attachments, url links to the hosted copy
+--
+alter table templates add column asset_mode text not null default 'cid';
+
+commit;
@@ -0,0 +1,5 @@
+begin immediate;
+
+alter table mail_queue drop column attempts;
+
+commit;
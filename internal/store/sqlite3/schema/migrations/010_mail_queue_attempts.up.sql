@@ -0,0 +1,9 @@
+begin immediate;
+
+--
+-- attempts counts the delivery attempts made so failed emails can be
+-- retried with backoff and moved to the dead_letter state once exhausted
+--
+alter table mail_queue add column attempts integer not null default 0;
+
+commit;
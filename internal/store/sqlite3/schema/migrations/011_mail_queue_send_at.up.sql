@@ -0,0 +1,11 @@
+begin immediate;
+
+--
+-- send_at is the earliest time a scheduled email may be delivered;
+-- unscheduled emails are due as soon as they are queued
+--
+alter table mail_queue add column send_at text not null default '';
+
+update mail_queue set send_at = created_at;
+
+commit;
@@ -0,0 +1,24 @@
+begin immediate;
+
+--
+-- api transports deliver emails using an email provider's HTTP API.
+-- config holds the provider specific settings as a JSON object and
+-- encrypted_api_key the API key encrypted like the smtp passwords
+--
+create table if not exists api_transports (
+  api_transport_id     text not null,
+  project_id           text not null,
+  transport_name       text not null,
+  provider             text not null,
+  config               text not null default '{}',
+  encrypted_api_key    text not null,
+  email_from           text not null,
+  email_from_name      text not null,
+  email_replyto        text not null,
+  created_at           text not null,
+  modified_at          text not null,
+  primary key (api_transport_id, project_id),
+  constraint api_transports_project_id_fkey foreign key (project_id) references projects (project_id)
+);
+
+commit;
@@ -0,0 +1,7 @@
+begin immediate;
+
+alter table smtp_transports drop column tls_ca_bundle;
+alter table smtp_transports drop column tls_insecure_skip_verify;
+alter table smtp_transports drop column tls_mode;
+
+commit;
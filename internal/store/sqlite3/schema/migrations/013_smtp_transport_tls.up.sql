@@ -0,0 +1,12 @@
+begin immediate;
+
+--
+-- tls_mode is one of '' (STARTTLS when offered), none, starttls or tls
+-- (implicit TLS). tls_ca_bundle optionally holds PEM encoded CA
+-- certificates used to verify the server instead of the system roots
+--
+alter table smtp_transports add column tls_mode text not null default '';
+alter table smtp_transports add column tls_insecure_skip_verify integer not null default 0;
+alter table smtp_transports add column tls_ca_bundle text not null default '';
+
+commit;
@@ -0,0 +1,21 @@
+begin immediate;
+
+--
+-- template partials are named templates shared by every template in a
+-- group, for example a header or footer used with {{template "footer" .}}
+--
+create table if not exists template_partials (
+  partial_name  text not null,
+  group_id      text not null,
+  project_id    text not null,
+  txt           text not null,
+  html          text not null,
+  created_at    text not null,
+  modified_at   text not null,
+  primary key (partial_name, group_id, project_id),
+  constraint template_partials_group_id_project_id_fkey
+    foreign key (group_id, project_id)
+    references groups (group_id, project_id)
+);
+
+commit;
@@ -0,0 +1,5 @@
+begin immediate;
+
+alter table templates drop column subject;
+
+commit;
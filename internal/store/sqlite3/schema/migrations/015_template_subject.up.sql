@@ -0,0 +1,9 @@
+begin immediate;
+
+--
+-- subject is the default subject line of emails sent using the template.
+-- It is a text template executed with the template params
+--
+alter table templates add column subject text not null default '';
+
+commit;
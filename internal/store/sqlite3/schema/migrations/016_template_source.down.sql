@@ -0,0 +1,6 @@
+begin immediate;
+
+alter table templates drop column source;
+alter table templates drop column source_type;
+
+commit;
@@ -0,0 +1,11 @@
+begin immediate;
+
+--
+-- source_type is the language the template's HTML is written in. For
+-- 'mjml' templates source holds the MJML and html holds the HTML compiled
+-- from it. For 'html' templates source is empty
+--
+alter table templates add column source_type text not null default 'html';
+alter table templates add column source text not null default '';
+
+commit;
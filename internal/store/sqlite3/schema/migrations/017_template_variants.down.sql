@@ -0,0 +1,5 @@
+begin immediate;
+
+drop table if exists template_variants;
+
+commit;
@@ -0,0 +1,24 @@
+begin immediate;
+
+--
+-- template variants hold the text, html and subject of a template in a
+-- locale. Sends in a locale without a variant use the template itself
+--
+create table if not exists template_variants (
+  template_id   text not null,
+  project_id    text not null,
+  locale        text not null,
+  txt           text not null,
+  txt_digest    text not null,
+  html          text not null,
+  html_digest   text not null,
+  subject       text not null default '',
+  created_at    text not null,
+  modified_at   text not null,
+  primary key (template_id, project_id, locale),
+  constraint template_variants_template_id_project_id_fkey
+    foreign key (template_id, project_id)
+    references templates (template_id, project_id)
+);
+
+commit;
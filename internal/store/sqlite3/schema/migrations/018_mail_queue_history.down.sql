@@ -0,0 +1,6 @@
+begin immediate;
+
+drop index if exists mail_queue_project_id_created_at_idx;
+drop table if exists mail_queue_attempts;
+
+commit;
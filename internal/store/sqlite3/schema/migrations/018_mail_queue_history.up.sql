@@ -0,0 +1,22 @@
+begin immediate;
+
+--
+-- mail_queue_attempts records the outcome of every delivery attempt so the
+-- history of an email can be inspected, and the project index supports
+-- listing the mail queue of a project by state
+--
+create table if not exists mail_queue_attempts (
+  mail_queue_id  text not null,
+  project_id     text not null,
+  attempt        integer not null,
+  mstate         text not null,
+  error          text not null default '',
+  created_at     text not null,
+  primary key (mail_queue_id, attempt),
+  constraint mail_queue_attempts_mail_queue_id_fkey
+    foreign key (mail_queue_id) references mail_queue (mail_queue_id)
+);
+
+create index if not exists mail_queue_project_id_created_at_idx on mail_queue (project_id, created_at);
+
+commit;
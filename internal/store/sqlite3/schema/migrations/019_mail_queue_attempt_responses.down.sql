@@ -0,0 +1,7 @@
+begin immediate;
+
+alter table mail_queue_attempts drop column started_at;
+alter table mail_queue_attempts drop column response;
+alter table mail_queue_attempts drop column response_code;
+
+commit;
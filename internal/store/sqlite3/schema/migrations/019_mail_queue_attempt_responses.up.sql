@@ -0,0 +1,12 @@
+begin immediate;
+
+--
+-- record the response of the SMTP server or API to each delivery attempt
+-- along with the time the attempt started
+--
+alter table mail_queue_attempts add column response_code integer not null default 0;
+alter table mail_queue_attempts add column response text not null default '';
+alter table mail_queue_attempts add column started_at text not null default '';
+update mail_queue_attempts set started_at = created_at;
+
+commit;
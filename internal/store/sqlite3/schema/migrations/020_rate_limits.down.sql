@@ -0,0 +1,6 @@
+begin immediate;
+
+drop index if exists mail_queue_project_sent_at_idx;
+drop table if exists rate_limits;
+
+commit;
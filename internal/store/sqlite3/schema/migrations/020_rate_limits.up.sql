@@ -0,0 +1,23 @@
+begin immediate;
+
+--
+-- rate limits cap the number of emails the queue worker delivers per
+-- second, minute and day for a project, or for a single transport within
+-- a project. An empty transport_id applies the limit to the whole project
+-- and a limit of 0 is unlimited
+--
+create table if not exists rate_limits (
+  project_id    text not null,
+  transport_id  text not null default '',
+  per_second    integer not null default 0,
+  per_minute    integer not null default 0,
+  per_day       integer not null default 0,
+  created_at    text not null,
+  modified_at   text not null,
+  primary key (project_id, transport_id),
+  constraint rate_limits_project_id_fkey foreign key (project_id) references projects (project_id)
+);
+
+create index if not exists mail_queue_project_sent_at_idx on mail_queue (project_id, mstate, sent_at);
+
+commit;
@@ -0,0 +1,8 @@
+begin immediate;
+
+drop index if exists webhook_deliveries_webhook_id_idx;
+drop index if exists webhook_deliveries_dstate_next_attempt_at_idx;
+drop table if exists webhook_deliveries;
+drop table if exists webhooks;
+
+commit;
@@ -0,0 +1,42 @@
+begin immediate;
+
+--
+-- webhooks are per-project endpoints notified of mail lifecycle events. An
+-- empty events array subscribes to every event. Each event is written to
+-- webhook_deliveries in the same way as an email is written to the mail
+-- queue, and is POSTed by the service with retries until it is delivered
+-- or runs out of attempts
+--
+create table if not exists webhooks (
+  webhook_id        text not null,
+  project_id        text not null,
+  url               text not null,
+  encrypted_secret  text not null,
+  events            text not null default '[]',
+  created_at        text not null,
+  modified_at       text not null,
+  primary key (project_id, webhook_id),
+  constraint webhooks_project_id_fkey foreign key (project_id) references projects (project_id)
+);
+
+create table if not exists webhook_deliveries (
+  delivery_id      text primary key,
+  webhook_id       text not null,
+  project_id       text not null,
+  event            text not null,
+  payload          text not null,
+  dstate           text not null,
+  attempts         integer not null default 0,
+  response_code    integer not null default 0,
+  last_error       text not null default '',
+  next_attempt_at  text not null,
+  created_at       text not null,
+  modified_at      text not null,
+  constraint webhook_deliveries_webhook_id_fkey
+    foreign key (project_id, webhook_id) references webhooks (project_id, webhook_id)
+);
+
+create index if not exists webhook_deliveries_dstate_next_attempt_at_idx on webhook_deliveries (dstate, next_attempt_at);
+create index if not exists webhook_deliveries_webhook_id_idx on webhook_deliveries (project_id, webhook_id, created_at);
+
+commit;
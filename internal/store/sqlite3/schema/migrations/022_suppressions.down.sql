@@ -0,0 +1,7 @@
+begin immediate;
+
+drop table if exists suppressions;
+drop index if exists mail_queue_project_message_id_idx;
+alter table mail_queue drop column message_id;
+
+commit;
@@ -0,0 +1,27 @@
+begin immediate;
+
+--
+-- message_id is the Message-ID header of an email, without the angle
+-- brackets, used to match provider bounce and complaint notifications to
+-- the mail queue entry
+--
+alter table mail_queue add column message_id text not null default '';
+
+create index if not exists mail_queue_project_message_id_idx on mail_queue (project_id, message_id);
+
+--
+-- suppressions are recipient addresses a project never sends to, added
+-- after a hard bounce or complaint. Addresses are stored in lower case
+--
+create table if not exists suppressions (
+  project_id   text not null,
+  email        text not null,
+  reason       text not null,
+  detail       text not null default '',
+  created_at   text not null,
+  modified_at  text not null,
+  primary key (project_id, email),
+  constraint suppressions_project_id_fkey foreign key (project_id) references projects (project_id)
+);
+
+commit;
@@ -0,0 +1,5 @@
+begin immediate;
+
+drop table if exists sender_allow_lists;
+
+commit;
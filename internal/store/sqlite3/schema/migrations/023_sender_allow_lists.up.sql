@@ -0,0 +1,17 @@
+begin immediate;
+
+--
+-- sender allow-lists are the verified addresses a transport may send from
+-- in place of its own, stored as a JSON array of lower case addresses
+--
+create table if not exists sender_allow_lists (
+  project_id    text not null,
+  transport_id  text not null,
+  emails        text not null default '[]',
+  created_at    text not null,
+  modified_at   text not null,
+  primary key (project_id, transport_id),
+  constraint sender_allow_lists_project_id_fkey foreign key (project_id) references projects (project_id)
+);
+
+commit;
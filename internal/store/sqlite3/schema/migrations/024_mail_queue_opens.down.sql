@@ -0,0 +1,5 @@
+begin immediate;
+
+drop table if exists mail_queue_opens;
+
+commit;
@@ -0,0 +1,17 @@
+begin immediate;
+
+--
+-- mail_queue_opens counts the opens of emails sent with open tracking, as
+-- recorded by their tracking pixel
+--
+create table if not exists mail_queue_opens (
+  mail_queue_id    text not null primary key,
+  project_id       text not null,
+  opens            integer not null default 0,
+  first_opened_at  text not null,
+  last_opened_at   text not null,
+  constraint mail_queue_opens_mail_queue_id_fkey
+    foreign key (mail_queue_id) references mail_queue (mail_queue_id)
+);
+
+commit;
@@ -0,0 +1,6 @@
+begin immediate;
+
+drop index if exists api_keys_key_hash_idx;
+drop table if exists api_keys;
+
+commit;
@@ -0,0 +1,20 @@
+begin immediate;
+
+--
+-- api_keys are the keys accepted by the REST API in addition to those in
+-- the server configuration, each scoped to a JSON array of project ids with
+-- a role. Only the SHA-256 hash of a key is stored
+--
+create table if not exists api_keys (
+  api_key_id   text not null primary key,
+  name         text not null default '',
+  key_hash     text not null,
+  role         text not null,
+  project_ids  text not null default '[]',
+  created_at   text not null,
+  modified_at  text not null
+);
+
+create unique index if not exists api_keys_key_hash_idx on api_keys (key_hash);
+
+commit;
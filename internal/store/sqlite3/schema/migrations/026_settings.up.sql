@@ -0,0 +1,15 @@
+begin immediate;
+
+--
+-- settings are values the service keeps for itself rather than for a
+-- project, such as the salt the encryption key is derived with when the
+-- service is given a passphrase
+--
+create table if not exists settings (
+  name         text not null primary key,
+  value        text not null,
+  created_at   text not null,
+  modified_at  text not null
+);
+
+commit;
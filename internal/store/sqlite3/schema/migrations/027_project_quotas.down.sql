@@ -0,0 +1,6 @@
+begin immediate;
+
+drop table if exists project_quota_usage;
+drop table if exists project_quotas;
+
+commit;
@@ -0,0 +1,30 @@
+begin immediate;
+
+--
+-- project quotas cap the number of emails a project may send per UTC day
+-- and calendar month. A limit of 0 is unlimited. action is reject or
+-- defer and decides what happens to emails over the quota
+--
+create table if not exists project_quotas (
+  project_id   text not null primary key,
+  per_day      integer not null default 0,
+  per_month    integer not null default 0,
+  action       text not null default 'reject',
+  created_at   text not null,
+  modified_at  text not null,
+  constraint project_quotas_project_id_fkey foreign key (project_id) references projects (project_id)
+);
+
+--
+-- project quota usage counts the emails accepted for each project per
+-- period, which is either a day (YYYY-MM-DD) or a month (YYYY-MM)
+--
+create table if not exists project_quota_usage (
+  project_id  text not null,
+  period      text not null,
+  sent        integer not null default 0,
+  primary key (project_id, period),
+  constraint project_quota_usage_project_id_fkey foreign key (project_id) references projects (project_id)
+);
+
+commit;
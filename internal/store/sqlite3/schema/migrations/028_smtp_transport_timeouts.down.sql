@@ -0,0 +1,6 @@
+begin immediate;
+
+alter table smtp_transports drop column send_timeout_ms;
+alter table smtp_transports drop column connect_timeout_ms;
+
+commit;
@@ -0,0 +1,11 @@
+begin immediate;
+
+--
+-- connect_timeout_ms bounds connecting to the server, including TLS and
+-- authentication, and send_timeout_ms bounds sending one email over the
+-- connection. 0 uses the service defaults
+--
+alter table smtp_transports add column connect_timeout_ms integer not null default 0;
+alter table smtp_transports add column send_timeout_ms integer not null default 0;
+
+commit;
@@ -0,0 +1,10 @@
+begin immediate;
+
+drop index if exists mail_queue_sent_transport_sent_at_idx;
+create index if not exists mail_queue_transport_sent_at_idx on mail_queue (project_id, transport_id, mstate, sent_at);
+
+alter table mail_queue_attempts drop column transport_id;
+alter table mail_queue drop column sent_transport_id;
+alter table projects drop column transport_chain;
+
+commit;
@@ -0,0 +1,27 @@
+begin immediate;
+
+--
+-- transport_chain is a JSON array of transport ids. An email that fails
+-- with a transient error through one transport in the chain is retried
+-- through the transports after it
+--
+alter table projects add column transport_chain text not null default '[]';
+
+--
+-- sent_transport_id is the transport that delivered an email, which is
+-- a fallback transport if its own transport failed, and transport_id is
+-- the transport each delivery attempt was made through
+--
+alter table mail_queue add column sent_transport_id text not null default '';
+alter table mail_queue_attempts add column transport_id text not null default '';
+
+update mail_queue set sent_transport_id = transport_id where sent_at != '';
+
+--
+-- warmup counts the emails each transport sent, including those it sent
+-- as a fallback for another transport
+--
+drop index if exists mail_queue_transport_sent_at_idx;
+create index if not exists mail_queue_sent_transport_sent_at_idx on mail_queue (project_id, sent_transport_id, mstate, sent_at);
+
+commit;
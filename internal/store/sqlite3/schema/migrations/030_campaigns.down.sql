@@ -0,0 +1,7 @@
+begin immediate;
+
+drop index if exists mail_queue_project_campaign_id_idx;
+alter table mail_queue drop column campaign_id;
+drop table if exists campaigns;
+
+commit;
@@ -0,0 +1,29 @@
+begin immediate;
+
+--
+-- campaigns group the emails of a bulk send to a list of recipients. Every
+-- email of the campaign is queued when it is created and carries its
+-- campaign_id. cstate is running or paused; pausing a campaign moves its
+-- queued emails to the paused state so the worker leaves them alone.
+-- recipients is the number of recipients the campaign was created with,
+-- including those whose email could not be queued
+--
+create table if not exists campaigns (
+  campaign_id    text not null,
+  project_id     text not null,
+  campaign_name  text not null default '',
+  template_id    text not null,
+  transport_id   text not null,
+  cstate         text not null default 'running',
+  recipients     integer not null default 0,
+  created_at     text not null,
+  modified_at    text not null,
+  primary key (project_id, campaign_id),
+  constraint campaigns_project_id_fkey foreign key (project_id) references projects (project_id)
+);
+
+alter table mail_queue add column campaign_id text not null default '';
+
+create index if not exists mail_queue_project_campaign_id_idx on mail_queue (project_id, campaign_id, mstate);
+
+commit;
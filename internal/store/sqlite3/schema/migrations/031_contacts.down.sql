@@ -0,0 +1,7 @@
+begin immediate;
+
+alter table campaigns drop column list_id;
+drop table if exists contacts;
+drop table if exists contact_lists;
+
+commit;
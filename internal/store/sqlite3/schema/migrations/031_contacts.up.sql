@@ -0,0 +1,37 @@
+begin immediate;
+
+--
+-- contact_lists are per-project lists of recipients that campaigns and
+-- merge sends can reference by id instead of passing every address
+--
+create table if not exists contact_lists (
+  list_id      text not null,
+  project_id   text not null,
+  list_name    text not null default '',
+  created_at   text not null,
+  modified_at  text not null,
+  primary key (project_id, list_id),
+  constraint contact_lists_project_id_fkey foreign key (project_id) references projects (project_id)
+);
+
+--
+-- contacts are the members of a contact list. attributes is a JSON object
+-- of strings passed to the template as the contact's params
+--
+create table if not exists contacts (
+  project_id   text not null,
+  list_id      text not null,
+  email        text not null,
+  attributes   text not null default '{}',
+  locale       text not null default '',
+  timezone     text not null default '',
+  created_at   text not null,
+  modified_at  text not null,
+  primary key (project_id, list_id, email),
+  constraint contacts_list_id_fkey foreign key (project_id, list_id) references contact_lists (project_id, list_id)
+);
+
+-- the contact list a campaign was created from, if any
+alter table campaigns add column list_id text not null default '';
+
+commit;
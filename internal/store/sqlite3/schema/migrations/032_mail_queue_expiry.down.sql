@@ -0,0 +1,5 @@
+begin immediate;
+
+drop index if exists mail_queue_mstate_modified_at_idx;
+
+commit;
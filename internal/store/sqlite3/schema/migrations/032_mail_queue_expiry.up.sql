@@ -0,0 +1,9 @@
+begin immediate;
+
+--
+-- emails that finished delivery are pruned by the time they were last
+-- modified
+--
+create index if not exists mail_queue_mstate_modified_at_idx on mail_queue (mstate, modified_at);
+
+commit;
@@ -0,0 +1,17 @@
+begin immediate;
+
+--
+-- transport_verifications hold the outcome of the latest verification of
+-- each SMTP or API transport, reported by the health check. error is
+-- empty if the transport verified successfully
+--
+create table if not exists transport_verifications (
+  project_id    text not null,
+  transport_id  text not null,
+  error         text not null default '',
+  verified_at   text not null,
+  primary key (project_id, transport_id),
+  constraint transport_verifications_project_id_fkey foreign key (project_id) references projects (project_id)
+);
+
+commit;
@@ -0,0 +1,5 @@
+begin immediate;
+
+drop index if exists mail_queue_attempts_project_id_created_at_idx;
+
+commit;
@@ -0,0 +1,9 @@
+begin immediate;
+
+--
+-- mail_queue_attempts_project_id_created_at_idx serves the queue stats,
+-- which count the recent delivery attempts of a project
+--
+create index if not exists mail_queue_attempts_project_id_created_at_idx on mail_queue_attempts (project_id, created_at);
+
+commit;
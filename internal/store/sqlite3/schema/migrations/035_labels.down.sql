@@ -0,0 +1,6 @@
+begin immediate;
+
+alter table templates drop column labels;
+alter table projects drop column labels;
+
+commit;
@@ -0,0 +1,11 @@
+begin immediate;
+
+--
+-- labels are a JSON object of free-form key/value pairs used to organise
+-- and filter projects and templates. The labels of an email are kept in
+-- the mail_queue metadata
+--
+alter table projects add column labels text not null default '{}';
+alter table templates add column labels text not null default '{}';
+
+commit;
@@ -0,0 +1,5 @@
+begin immediate;
+
+alter table projects drop column variables;
+
+commit;
@@ -0,0 +1,9 @@
+begin immediate;
+
+--
+-- variables are a JSON object of key/value pairs shared by every template
+-- of the project, such as brand colours and footer addresses
+--
+alter table projects add column variables text not null default '{}';
+
+commit;
@@ -0,0 +1,20 @@
+begin immediate;
+
+--
+-- template fixtures are named sets of sample template params used to
+-- render a template without sending it and to check changes to it
+--
+create table if not exists template_fixtures (
+  template_id   text not null,
+  project_id    text not null,
+  name          text not null,
+  params        text not null default '{}',
+  created_at    text not null,
+  modified_at   text not null,
+  primary key (template_id, project_id, name),
+  constraint template_fixtures_template_id_project_id_fkey
+    foreign key (template_id, project_id)
+    references templates (template_id, project_id)
+);
+
+commit;
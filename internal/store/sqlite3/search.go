@@ -0,0 +1,175 @@
+package sqlite3
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/andyfusniak/squishy-mailer-lite/internal/store"
+	"github.com/pkg/errors"
+)
+
+// templateSearch records whether the templates_fts index is available.
+// The index needs SQLite's FTS5 extension, which go-sqlite3 only compiles
+// in with the sqlite_fts5 build tag, so it is created on first use rather
+// than by a migration. Without it templates are searched by a scan.
+type templateSearch struct {
+	mu    sync.Mutex
+	ready bool
+	fts   bool
+}
+
+// minTrigramQuery is the shortest query the trigram index can match.
+const minTrigramQuery = 3
+
+// templatesFTSSchema creates the templates_fts index, triggers that keep
+// it in step with the templates table, and fills it with the existing
+// templates. The trigram tokenizer matches any substring of three or more
+// characters, ignoring case, so that partial words and template actions
+// such as .first_name can be found.
+const templatesFTSSchema = `
+create virtual table templates_fts using fts5(
+  project_id unindexed, template_id unindexed, subject, txt, html,
+  tokenize = 'trigram'
+);
+
+create trigger templates_fts_insert after insert on templates begin
+  insert into templates_fts (project_id, template_id, subject, txt, html)
+  values (new.project_id, new.template_id, new.subject, new.txt, new.html);
+end;
+
+create trigger templates_fts_delete after delete on templates begin
+  delete from templates_fts
+  where project_id = old.project_id and template_id = old.template_id;
+end;
+
+create trigger templates_fts_update after update of subject, txt, html on templates begin
+  delete from templates_fts
+  where project_id = old.project_id and template_id = old.template_id;
+  insert into templates_fts (project_id, template_id, subject, txt, html)
+  values (new.project_id, new.template_id, new.subject, new.txt, new.html);
+end;
+
+insert into templates_fts (project_id, template_id, subject, txt, html)
+select project_id, template_id, subject, txt, html from templates;
+`
+
+// templateSearchFTS reports whether templates can be searched using the
+// templates_fts index, creating the index if FTS5 is available and it
+// does not exist yet.
+func (s *Store) templateSearchFTS(ctx context.Context) (bool, error) {
+	s.search.mu.Lock()
+	defer s.search.mu.Unlock()
+
+	if s.search.ready {
+		return s.search.fts, nil
+	}
+
+	var fts5 bool
+	if err := s.readwrite.QueryRowContext(ctx,
+		`select sqlite_compileoption_used('ENABLE_FTS5')`).Scan(&fts5); err != nil {
+		return false, errors.Wrapf(err, "[sqlite3:templates_fts] compile option query failed")
+	}
+	if fts5 {
+		if err := s.execTx(ctx, func(q *Queries) error {
+			var n int
+			if err := q.readwrite.QueryRowContext(ctx, `
+select count(*) from sqlite_master where type = 'table' and name = 'templates_fts'
+`).Scan(&n); err != nil {
+				return errors.Wrapf(err, "[sqlite3:templates_fts] sqlite_master query failed")
+			}
+			if n > 0 {
+				return nil
+			}
+			if _, err := q.readwrite.ExecContext(ctx, templatesFTSSchema); err != nil {
+				return errors.Wrapf(err, "[sqlite3:templates_fts] create index failed")
+			}
+			return nil
+		}); err != nil {
+			return false, err
+		}
+	}
+	s.search.ready = true
+	s.search.fts = fts5
+	return fts5, nil
+}
+
+// SearchTemplates lists the templates of a project whose subject, text or
+// HTML contain query, ignoring case. If the templates_fts index is
+// available the most relevant templates are listed first, otherwise they
+// are listed by id.
+func (s *Store) SearchTemplates(ctx context.Context, projectID, query string) ([]*store.Template, error) {
+	const ftsQuery = `
+select
+  t.template_id, t.group_id, t.project_id, t.txt, t.txt_digest, t.html, t.html_digest,
+  t.subject, t.source_type, t.source, t.asset_mode, t.labels, t.created_at, t.modified_at
+from templates_fts as f
+join templates as t
+  on t.project_id = f.project_id and t.template_id = f.template_id
+where
+  templates_fts match '"' || replace(:query, '"', '""') || '"' and
+  f.project_id = :project_id
+order by f.rank, t.template_id
+`
+	const scanQuery = `
+select
+  template_id, group_id, project_id, txt, txt_digest, html, html_digest,
+  subject, source_type, source, asset_mode, labels, created_at, modified_at
+from templates
+where
+  project_id = :project_id and (
+    instr(lower(subject), lower(:query)) > 0 or
+    instr(lower(txt), lower(:query)) > 0 or
+    instr(lower(html), lower(:query)) > 0
+  )
+order by template_id
+`
+	fts, err := s.templateSearchFTS(ctx)
+	if err != nil {
+		return nil, err
+	}
+	stmt := scanQuery
+	if fts && utf8.RuneCountInString(query) >= minTrigramQuery {
+		stmt = ftsQuery
+	}
+	rows, err := s.readonly.QueryContext(ctx, stmt,
+		sql.Named("project_id", projectID),
+		sql.Named("query", query),
+	)
+	if err != nil {
+		return nil, errors.Wrapf(err,
+			"[sqlite3:templates] query failed query=%q", stmt)
+	}
+	defer rows.Close()
+
+	list := make([]*store.Template, 0)
+	for rows.Next() {
+		var r store.Template
+		if err := rows.Scan(
+			&r.TemplateID,
+			&r.GroupID,
+			&r.ProjectID,
+			&r.Txt,
+			&r.TxtDigest,
+			&r.HTML,
+			&r.HTMLDigest,
+			&r.Subject,
+			&r.SourceType,
+			&r.Source,
+			&r.AssetMode,
+			&r.Labels,
+			&r.CreatedAt,
+			&r.ModifiedAt,
+		); err != nil {
+			return nil, errors.Wrapf(err,
+				"[sqlite3:templates] rows scan failed query=%q", stmt)
+		}
+		list = append(list, &r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrapf(err,
+			"[sqlite3:templates] rows.Err failed query=%q", stmt)
+	}
+	return list, nil
+}
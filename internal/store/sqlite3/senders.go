@@ -0,0 +1,110 @@
+package sqlite3
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/andyfusniak/squishy-mailer-lite/internal/store"
+	"github.com/mattn/go-sqlite3"
+	"github.com/pkg/errors"
+)
+
+// SetSenderAllowList creates or replaces the sender allow-list of a
+// transport. If the project does not exist an error of type
+// store.ErrProjectNotFound is returned.
+func (q *Queries) SetSenderAllowList(ctx context.Context, params store.SetSenderAllowList) (*store.SenderAllowList, error) {
+	const query = `
+insert into sender_allow_lists
+  (project_id, transport_id, emails, created_at, modified_at)
+values
+  (:project_id, :transport_id, :emails, :created_at, :modified_at)
+on conflict (project_id, transport_id) do update set
+  emails = excluded.emails,
+  modified_at = excluded.modified_at
+returning
+  project_id, transport_id, emails, created_at, modified_at
+`
+	var r store.SenderAllowList
+	now := store.Datetime(time.Now().UTC())
+	if err := q.readwrite.QueryRowContext(ctx, query,
+		sql.Named("project_id", params.ProjectID),
+		sql.Named("transport_id", params.TransportID),
+		sql.Named("emails", params.Emails),
+		sql.Named("created_at", &now),
+		sql.Named("modified_at", &now),
+	).Scan(
+		&r.ProjectID,
+		&r.TransportID,
+		&r.Emails,
+		&r.CreatedAt,
+		&r.ModifiedAt,
+	); err != nil {
+		if serr, ok := err.(sqlite3.Error); ok {
+			if serr.Code == sqlite3.ErrConstraint && serr.ExtendedCode == sqlite3.ErrConstraintForeignKey {
+				return nil, store.NewStoreError(store.ErrProjectNotFound, serr)
+			}
+		}
+		return nil, errors.Wrapf(err,
+			"[sqlite3:sender_allow_lists] query row scan failed query=%q", query)
+	}
+	return &r, nil
+}
+
+// GetSenderAllowList gets the sender allow-list of a transport. If the
+// transport has no allow-list an error of type
+// store.ErrSenderAllowListNotFound is returned.
+func (q *Queries) GetSenderAllowList(ctx context.Context, projectID, transportID string) (*store.SenderAllowList, error) {
+	const query = `
+select
+  project_id, transport_id, emails, created_at, modified_at
+from sender_allow_lists
+where
+  project_id = :project_id and transport_id = :transport_id
+`
+	var r store.SenderAllowList
+	if err := q.queryRow(ctx, query,
+		sql.Named("project_id", projectID),
+		sql.Named("transport_id", transportID),
+	).Scan(
+		&r.ProjectID,
+		&r.TransportID,
+		&r.Emails,
+		&r.CreatedAt,
+		&r.ModifiedAt,
+	); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, store.NewStoreError(store.ErrSenderAllowListNotFound, err)
+		}
+		return nil, errors.Wrapf(err,
+			"[sqlite3:sender_allow_lists] query row scan failed query=%q", query)
+	}
+	return &r, nil
+}
+
+// DeleteSenderAllowList deletes the sender allow-list of a transport. If
+// the transport has no allow-list an error of type
+// store.ErrSenderAllowListNotFound is returned.
+func (q *Queries) DeleteSenderAllowList(ctx context.Context, projectID, transportID string) error {
+	const query = `
+delete from sender_allow_lists
+where
+  project_id = :project_id and transport_id = :transport_id
+`
+	res, err := q.readwrite.ExecContext(ctx, query,
+		sql.Named("project_id", projectID),
+		sql.Named("transport_id", transportID),
+	)
+	if err != nil {
+		return errors.Wrapf(err,
+			"[sqlite3:sender_allow_lists] exec failed query=%q", query)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return errors.Wrapf(err, "[sqlite3:sender_allow_lists] res.RowsAffected failed")
+	}
+	if n == 0 {
+		return store.NewStoreError(store.ErrSenderAllowListNotFound, nil)
+	}
+	return nil
+}
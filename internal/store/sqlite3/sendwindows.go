@@ -0,0 +1,127 @@
+package sqlite3
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/andyfusniak/squishy-mailer-lite/internal/store"
+	"github.com/mattn/go-sqlite3"
+	"github.com/pkg/errors"
+)
+
+// SetSendWindow creates or replaces the send window for a project and group.
+func (q *Queries) SetSendWindow(ctx context.Context, params store.SetSendWindow) (*store.SendWindow, error) {
+	const query = `
+insert into send_windows
+  (project_id, group_id, start_time, end_time, timezone, created_at, modified_at)
+values
+  (:project_id, :group_id, :start_time, :end_time, :timezone, :created_at, :modified_at)
+on conflict (project_id, group_id) do update set
+  start_time = excluded.start_time,
+  end_time = excluded.end_time,
+  timezone = excluded.timezone,
+  modified_at = excluded.modified_at
+returning
+  project_id, group_id, start_time, end_time, timezone, created_at, modified_at
+`
+	var r store.SendWindow
+	now := store.Datetime(time.Now().UTC())
+	if err := q.readwrite.QueryRowContext(ctx, query,
+		sql.Named("project_id", params.ProjectID),
+		sql.Named("group_id", params.GroupID),
+		sql.Named("start_time", params.StartTime),
+		sql.Named("end_time", params.EndTime),
+		sql.Named("timezone", params.Timezone),
+		sql.Named("created_at", &now),
+		sql.Named("modified_at", &now),
+	).Scan(
+		&r.ProjectID,
+		&r.GroupID,
+		&r.StartTime,
+		&r.EndTime,
+		&r.Timezone,
+		&r.CreatedAt,
+		&r.ModifiedAt,
+	); err != nil {
+		if serr, ok := err.(sqlite3.Error); ok {
+			if serr.Code == sqlite3.ErrConstraint && serr.ExtendedCode == sqlite3.ErrConstraintForeignKey {
+				return nil, store.NewStoreError(store.ErrProjectNotFound, serr)
+			}
+		}
+		return nil, errors.Wrapf(err,
+			"[sqlite3:send_windows] query row scan failed query=%q", query)
+	}
+	return &r, nil
+}
+
+// ListSendWindows lists all the send windows for a project ordered by
+// group id. The project wide window, if any, is first.
+func (q *Queries) ListSendWindows(ctx context.Context, projectID string) ([]*store.SendWindow, error) {
+	const query = `
+select
+  project_id, group_id, start_time, end_time, timezone, created_at, modified_at
+from send_windows
+where
+  project_id = :project_id
+order by group_id
+`
+	rows, err := q.readonly.QueryContext(ctx, query,
+		sql.Named("project_id", projectID),
+	)
+	if err != nil {
+		return nil, errors.Wrapf(err,
+			"[sqlite3:send_windows] query failed query=%q", query)
+	}
+	defer rows.Close()
+
+	list := make([]*store.SendWindow, 0)
+	for rows.Next() {
+		var r store.SendWindow
+		if err := rows.Scan(
+			&r.ProjectID,
+			&r.GroupID,
+			&r.StartTime,
+			&r.EndTime,
+			&r.Timezone,
+			&r.CreatedAt,
+			&r.ModifiedAt,
+		); err != nil {
+			return nil, errors.Wrapf(err,
+				"[sqlite3:send_windows] rows scan failed query=%q", query)
+		}
+		list = append(list, &r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrapf(err,
+			"[sqlite3:send_windows] rows.Err failed query=%q", query)
+	}
+	return list, nil
+}
+
+// DeleteSendWindow deletes the send window for a project and group. If the
+// send window does not exist an error of type store.ErrSendWindowNotFound
+// is returned.
+func (q *Queries) DeleteSendWindow(ctx context.Context, projectID, groupID string) error {
+	const query = `
+delete from send_windows
+where
+  project_id = :project_id and group_id = :group_id
+`
+	res, err := q.readwrite.ExecContext(ctx, query,
+		sql.Named("project_id", projectID),
+		sql.Named("group_id", groupID),
+	)
+	if err != nil {
+		return errors.Wrapf(err,
+			"[sqlite3:send_windows] exec failed query=%q", query)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return errors.Wrapf(err, "[sqlite3:send_windows] res.RowsAffected failed")
+	}
+	if n == 0 {
+		return store.NewStoreError(store.ErrSendWindowNotFound, nil)
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+package sqlite3
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/andyfusniak/squishy-mailer-lite/internal/store"
+	"github.com/pkg/errors"
+)
+
+// GetSetting gets a setting by name. If there is no such setting an error
+// of type store.ErrSettingNotFound is returned.
+func (q *Queries) GetSetting(ctx context.Context, name string) (*store.Setting, error) {
+	const query = `
+select name, value, created_at, modified_at
+from settings
+where name = :name
+`
+	var r store.Setting
+	if err := q.queryRow(ctx, query,
+		sql.Named("name", name),
+	).Scan(
+		&r.Name,
+		&r.Value,
+		&r.CreatedAt,
+		&r.ModifiedAt,
+	); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, store.NewStoreError(store.ErrSettingNotFound, err)
+		}
+		return nil, errors.Wrapf(err,
+			"[sqlite3:settings] query row scan failed query=%q", query)
+	}
+	return &r, nil
+}
+
+// EnsureSetting inserts a setting unless one of the same name exists, and
+// returns the stored setting.
+func (q *Queries) EnsureSetting(ctx context.Context, name, value string) (*store.Setting, error) {
+	const query = `
+insert into settings
+  (name, value, created_at, modified_at)
+values
+  (:name, :value, :created_at, :modified_at)
+on conflict (name) do update set name = excluded.name
+returning name, value, created_at, modified_at
+`
+	now := store.Datetime(time.Now().UTC())
+	var r store.Setting
+	if err := q.readwrite.QueryRowContext(ctx, query,
+		sql.Named("name", name),
+		sql.Named("value", value),
+		sql.Named("created_at", &now),
+		sql.Named("modified_at", &now),
+	).Scan(
+		&r.Name,
+		&r.Value,
+		&r.CreatedAt,
+		&r.ModifiedAt,
+	); err != nil {
+		return nil, errors.Wrapf(err,
+			"[sqlite3:settings] query row scan failed query=%q", query)
+	}
+	return &r, nil
+}
@@ -0,0 +1,856 @@
+package sqlite3
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/andyfusniak/squishy-mailer-lite/internal/store"
+	"github.com/pkg/errors"
+)
+
+// ReadSnapshot reads every project and the records that belong to it,
+// along with the queued and sending emails. All the queries run inside a
+// single transaction so the snapshot is consistent.
+func (s *Store) ReadSnapshot(ctx context.Context) (*store.Snapshot, error) {
+	return s.readSnapshot(ctx, "")
+}
+
+// ReadProjectSnapshot reads a project and the records that belong to it
+// inside a single transaction. The mail queue is not included. If the
+// project does not exist an error of type store.ErrProjectNotFound is
+// returned.
+func (s *Store) ReadProjectSnapshot(ctx context.Context, projectID string) (*store.Snapshot, error) {
+	snap, err := s.readSnapshot(ctx, projectID)
+	if err != nil {
+		return nil, err
+	}
+	if len(snap.Projects) == 0 {
+		return nil, store.NewStoreError(store.ErrProjectNotFound,
+			errors.Errorf("project %q not found", projectID))
+	}
+	return snap, nil
+}
+
+// readSnapshot reads the records of the project with the given id, or of
+// every project along with the mail queue if projectID is empty.
+func (s *Store) readSnapshot(ctx context.Context, projectID string) (*store.Snapshot, error) {
+	tx, err := s.readwrite.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, errors.Wrapf(err, "[sqlite3] begin tx failed")
+	}
+	defer tx.Rollback()
+
+	projectArg := []any{sql.Named("project_id", projectID)}
+	var snap store.Snapshot
+	if snap.Projects, err = queryAll(ctx, tx, "projects", `
+select`+projectColumns+`
+from projects
+where :project_id = '' or project_id = :project_id
+order by project_id
+`, projectArg, func(row rowScanner) (*store.Project, error) {
+		var r store.Project
+		err := row.Scan(
+			&r.ProjectID,
+			&r.ProjectName,
+			&r.Description,
+			&r.AllowedRecipientDomains,
+			&r.DefaultTransportID,
+			&r.DefaultGroupID,
+			&r.TransportChain,
+			&r.Labels,
+			&r.Variables,
+			&r.CreatedAt,
+		)
+		return &r, err
+	}); err != nil {
+		return nil, err
+	}
+
+	if snap.SMTPTransports, err = queryAll(ctx, tx, "smtp_transports", `
+select
+  smtp_transport_id, project_id, transport_name, host, port, username,
+  encrypted_password, email_from, email_from_name, email_replyto,
+  warmup_schedule, warmup_started_at, created_at, modified_at,
+  tls_mode, tls_insecure_skip_verify, tls_ca_bundle,
+  connect_timeout_ms, send_timeout_ms
+from smtp_transports
+where :project_id = '' or project_id = :project_id
+order by project_id, smtp_transport_id
+`, projectArg, func(row rowScanner) (*store.SMTPTransport, error) {
+		var r store.SMTPTransport
+		err := row.Scan(
+			&r.SMTPTransportID,
+			&r.ProjectID,
+			&r.TransportName,
+			&r.Host,
+			&r.Port,
+			&r.Username,
+			&r.EncryptedPassword,
+			&r.EmailFrom,
+			&r.EmailFromName,
+			&r.EmailReplyTo,
+			&r.WarmupSchedule,
+			&r.WarmupStartedAt,
+			&r.CreatedAt,
+			&r.ModifiedAt,
+			&r.TLSMode,
+			&r.TLSInsecureSkipVerify,
+			&r.TLSCABundle,
+			&r.ConnectTimeoutMS,
+			&r.SendTimeoutMS,
+		)
+		return &r, err
+	}); err != nil {
+		return nil, err
+	}
+
+	if snap.APITransports, err = queryAll(ctx, tx, "api_transports", `
+select`+apiTransportColumns+`
+from api_transports
+where :project_id = '' or project_id = :project_id
+order by project_id, api_transport_id
+`, projectArg, scanAPITransport); err != nil {
+		return nil, err
+	}
+
+	if snap.Groups, err = queryAll(ctx, tx, "groups", `
+select
+  group_id, project_id, group_name, created_at, modified_at
+from groups
+where :project_id = '' or project_id = :project_id
+order by project_id, group_id
+`, projectArg, func(row rowScanner) (*store.Group, error) {
+		var r store.Group
+		err := row.Scan(
+			&r.GroupID,
+			&r.ProjectID,
+			&r.GroupName,
+			&r.CreatedAt,
+			&r.ModifiedAt,
+		)
+		return &r, err
+	}); err != nil {
+		return nil, err
+	}
+
+	if snap.Templates, err = queryAll(ctx, tx, "templates", `
+select
+  template_id, group_id, project_id, txt, txt_digest, html, html_digest,
+  subject, source_type, source, asset_mode, labels, created_at, modified_at
+from templates
+where :project_id = '' or project_id = :project_id
+order by project_id, template_id
+`, projectArg, func(row rowScanner) (*store.Template, error) {
+		var r store.Template
+		err := row.Scan(
+			&r.TemplateID,
+			&r.GroupID,
+			&r.ProjectID,
+			&r.Txt,
+			&r.TxtDigest,
+			&r.HTML,
+			&r.HTMLDigest,
+			&r.Subject,
+			&r.SourceType,
+			&r.Source,
+			&r.AssetMode,
+			&r.Labels,
+			&r.CreatedAt,
+			&r.ModifiedAt,
+		)
+		return &r, err
+	}); err != nil {
+		return nil, err
+	}
+
+	if snap.Partials, err = queryAll(ctx, tx, "template_partials", `
+select
+  partial_name, group_id, project_id, txt, html, created_at, modified_at
+from template_partials
+where :project_id = '' or project_id = :project_id
+order by project_id, group_id, partial_name
+`, projectArg, func(row rowScanner) (*store.Partial, error) {
+		var r store.Partial
+		err := row.Scan(
+			&r.PartialName,
+			&r.GroupID,
+			&r.ProjectID,
+			&r.Txt,
+			&r.HTML,
+			&r.CreatedAt,
+			&r.ModifiedAt,
+		)
+		return &r, err
+	}); err != nil {
+		return nil, err
+	}
+
+	if snap.TemplateVariants, err = queryAll(ctx, tx, "template_variants", `
+select
+  template_id, project_id, locale, txt, txt_digest, html, html_digest, subject,
+  created_at, modified_at
+from template_variants
+where :project_id = '' or project_id = :project_id
+order by project_id, template_id, locale
+`, projectArg, func(row rowScanner) (*store.TemplateVariant, error) {
+		var r store.TemplateVariant
+		err := row.Scan(
+			&r.TemplateID,
+			&r.ProjectID,
+			&r.Locale,
+			&r.Txt,
+			&r.TxtDigest,
+			&r.HTML,
+			&r.HTMLDigest,
+			&r.Subject,
+			&r.CreatedAt,
+			&r.ModifiedAt,
+		)
+		return &r, err
+	}); err != nil {
+		return nil, err
+	}
+
+	if snap.TemplateFixtures, err = queryAll(ctx, tx, "template_fixtures", `
+select
+  template_id, project_id, name, params, created_at, modified_at
+from template_fixtures
+where :project_id = '' or project_id = :project_id
+order by project_id, template_id, name
+`, projectArg, func(row rowScanner) (*store.TemplateFixture, error) {
+		var r store.TemplateFixture
+		err := row.Scan(
+			&r.TemplateID,
+			&r.ProjectID,
+			&r.Name,
+			&r.Params,
+			&r.CreatedAt,
+			&r.ModifiedAt,
+		)
+		return &r, err
+	}); err != nil {
+		return nil, err
+	}
+
+	if snap.SendWindows, err = queryAll(ctx, tx, "send_windows", `
+select
+  project_id, group_id, start_time, end_time, timezone,
+  created_at, modified_at
+from send_windows
+where :project_id = '' or project_id = :project_id
+order by project_id, group_id
+`, projectArg, func(row rowScanner) (*store.SendWindow, error) {
+		var r store.SendWindow
+		err := row.Scan(
+			&r.ProjectID,
+			&r.GroupID,
+			&r.StartTime,
+			&r.EndTime,
+			&r.Timezone,
+			&r.CreatedAt,
+			&r.ModifiedAt,
+		)
+		return &r, err
+	}); err != nil {
+		return nil, err
+	}
+
+	if snap.RateLimits, err = queryAll(ctx, tx, "rate_limits", `
+select
+  project_id, transport_id, per_second, per_minute, per_day,
+  created_at, modified_at
+from rate_limits
+where :project_id = '' or project_id = :project_id
+order by project_id, transport_id
+`, projectArg, func(row rowScanner) (*store.RateLimit, error) {
+		var r store.RateLimit
+		err := row.Scan(
+			&r.ProjectID,
+			&r.TransportID,
+			&r.PerSecond,
+			&r.PerMinute,
+			&r.PerDay,
+			&r.CreatedAt,
+			&r.ModifiedAt,
+		)
+		return &r, err
+	}); err != nil {
+		return nil, err
+	}
+
+	if snap.SenderAllowLists, err = queryAll(ctx, tx, "sender_allow_lists", `
+select
+  project_id, transport_id, emails, created_at, modified_at
+from sender_allow_lists
+where :project_id = '' or project_id = :project_id
+order by project_id, transport_id
+`, projectArg, func(row rowScanner) (*store.SenderAllowList, error) {
+		var r store.SenderAllowList
+		err := row.Scan(
+			&r.ProjectID,
+			&r.TransportID,
+			&r.Emails,
+			&r.CreatedAt,
+			&r.ModifiedAt,
+		)
+		return &r, err
+	}); err != nil {
+		return nil, err
+	}
+
+	if snap.MessageCatalogs, err = queryAll(ctx, tx, "message_catalogs", `
+select
+  project_id, locale, messages, created_at, modified_at
+from message_catalogs
+where :project_id = '' or project_id = :project_id
+order by project_id, locale
+`, projectArg, func(row rowScanner) (*store.MessageCatalog, error) {
+		var r store.MessageCatalog
+		err := row.Scan(
+			&r.ProjectID,
+			&r.Locale,
+			&r.Messages,
+			&r.CreatedAt,
+			&r.ModifiedAt,
+		)
+		return &r, err
+	}); err != nil {
+		return nil, err
+	}
+
+	if snap.Attachments, err = queryAll(ctx, tx, "attachments", `
+select
+  attachment_id, project_id, filename, content_type, content, size,
+  checksum, created_at, modified_at
+from attachments
+where :project_id = '' or project_id = :project_id
+order by project_id, attachment_id
+`, projectArg, func(row rowScanner) (*store.Attachment, error) {
+		var r store.Attachment
+		err := row.Scan(
+			&r.AttachmentID,
+			&r.ProjectID,
+			&r.Filename,
+			&r.ContentType,
+			&r.Content,
+			&r.Size,
+			&r.Checksum,
+			&r.CreatedAt,
+			&r.ModifiedAt,
+		)
+		return &r, err
+	}); err != nil {
+		return nil, err
+	}
+
+	if snap.TemplateAttachments, err = queryAll(ctx, tx, "template_attachments", `
+select
+  project_id, template_id, attachment_id, position
+from template_attachments
+where :project_id = '' or project_id = :project_id
+order by project_id, template_id, position
+`, projectArg, func(row rowScanner) (*store.TemplateAttachment, error) {
+		var r store.TemplateAttachment
+		err := row.Scan(
+			&r.ProjectID,
+			&r.TemplateID,
+			&r.AttachmentID,
+			&r.Position,
+		)
+		return &r, err
+	}); err != nil {
+		return nil, err
+	}
+
+	if snap.Assets, err = queryAll(ctx, tx, "assets", `
+select
+  asset_id, project_id, filename, content_type, content, size,
+  checksum, created_at, modified_at
+from assets
+where :project_id = '' or project_id = :project_id
+order by project_id, asset_id
+`, projectArg, func(row rowScanner) (*store.Asset, error) {
+		var r store.Asset
+		err := row.Scan(
+			&r.AssetID,
+			&r.ProjectID,
+			&r.Filename,
+			&r.ContentType,
+			&r.Content,
+			&r.Size,
+			&r.Checksum,
+			&r.CreatedAt,
+			&r.ModifiedAt,
+		)
+		return &r, err
+	}); err != nil {
+		return nil, err
+	}
+
+	// sent and failed emails are history rather than state so only the
+	// emails still waiting to be delivered are included. A project on its
+	// own is exported without its mail queue.
+	if projectID != "" {
+		return &snap, nil
+	}
+	if snap.MailQueue, err = queryAll(ctx, tx, "mail_queue", `
+select`+mailQueueColumns+`
+from mail_queue
+where
+  mstate in ('`+store.MailQueueStateQueued+`', '`+store.MailQueueStateRateLimited+`',
+    '`+store.MailQueueStateSending+`', '`+store.MailQueueStatePaused+`')
+order by created_at, rowid
+`, nil, scanMailQueue); err != nil {
+		return nil, err
+	}
+
+	return &snap, nil
+}
+
+// queryAll runs a query with the given args and scans every row.
+func queryAll[T any](ctx context.Context, db DBTx, table, query string, args []any, scan func(rowScanner) (*T, error)) ([]*T, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, errors.Wrapf(err,
+			"[sqlite3:%s] query failed query=%q", table, query)
+	}
+	defer rows.Close()
+
+	list := make([]*T, 0)
+	for rows.Next() {
+		r, err := scan(rows)
+		if err != nil {
+			return nil, errors.Wrapf(err,
+				"[sqlite3:%s] rows scan failed query=%q", table, query)
+		}
+		list = append(list, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrapf(err,
+			"[sqlite3:%s] rows.Err failed query=%q", table, query)
+	}
+	return list, nil
+}
+
+// RestoreSnapshot writes a snapshot into an empty store in a single
+// transaction. The ids and timestamps of every record are kept. If the
+// store already has a project an error of type store.ErrStoreNotEmpty is
+// returned.
+func (s *Store) RestoreSnapshot(ctx context.Context, snap *store.Snapshot) error {
+	return s.execTx(ctx, func(q *Queries) error {
+		var n int
+		if err := q.readwrite.QueryRowContext(ctx,
+			`select count(*) from projects`).Scan(&n); err != nil {
+			return errors.Wrapf(err, "[sqlite3:projects] query row scan failed")
+		}
+		if n > 0 {
+			return store.NewStoreError(store.ErrStoreNotEmpty,
+				errors.Errorf("store has %d projects", n))
+		}
+
+		return q.restoreSnapshot(ctx, snap)
+	})
+}
+
+// ImportProjectSnapshot writes a snapshot of projects that are not yet in
+// the store in a single transaction, keeping the ids and timestamps of
+// every record. If one of the projects already exists an error of type
+// store.ErrProjectAlreadyExists is returned.
+func (s *Store) ImportProjectSnapshot(ctx context.Context, snap *store.Snapshot) error {
+	return s.execTx(ctx, func(q *Queries) error {
+		for _, r := range snap.Projects {
+			var n int
+			if err := q.readwrite.QueryRowContext(ctx,
+				`select count(*) from projects where project_id = :project_id`,
+				sql.Named("project_id", r.ProjectID)).Scan(&n); err != nil {
+				return errors.Wrapf(err, "[sqlite3:projects] query row scan failed")
+			}
+			if n > 0 {
+				return store.NewStoreError(store.ErrProjectAlreadyExists,
+					errors.Errorf("project %q already exists", r.ProjectID))
+			}
+		}
+		return q.restoreSnapshot(ctx, snap)
+	})
+}
+
+// restoreSnapshot inserts every record of snap keeping its ids and
+// timestamps.
+func (q *Queries) restoreSnapshot(ctx context.Context, snap *store.Snapshot) error {
+	for _, r := range snap.Projects {
+		if err := q.restoreExec(ctx, "projects", `
+insert into projects
+  (project_id, project_name, description, allowed_recipient_domains,
+   default_transport_id, default_group_id, transport_chain, labels, variables,
+   created_at)
+values
+  (:project_id, :project_name, :description, :allowed_recipient_domains,
+   :default_transport_id, :default_group_id, :transport_chain, :labels, :variables,
+   :created_at)
+`,
+			sql.Named("project_id", r.ProjectID),
+			sql.Named("project_name", r.ProjectName),
+			sql.Named("description", r.Description),
+			sql.Named("allowed_recipient_domains", r.AllowedRecipientDomains),
+			sql.Named("default_transport_id", r.DefaultTransportID),
+			sql.Named("default_group_id", r.DefaultGroupID),
+			sql.Named("transport_chain", r.TransportChain),
+			sql.Named("labels", r.Labels),
+			sql.Named("variables", r.Variables),
+			sql.Named("created_at", &r.CreatedAt),
+		); err != nil {
+			return err
+		}
+	}
+
+	for _, r := range snap.SMTPTransports {
+		if err := q.restoreExec(ctx, "smtp_transports", `
+insert into smtp_transports
+  (smtp_transport_id, project_id, transport_name, host, port, username,
+   encrypted_password, email_from, email_from_name, email_replyto,
+   warmup_schedule, warmup_started_at, created_at, modified_at,
+   tls_mode, tls_insecure_skip_verify, tls_ca_bundle,
+   connect_timeout_ms, send_timeout_ms)
+values
+  (:smtp_transport_id, :project_id, :transport_name, :host, :port, :username,
+   :encrypted_password, :email_from, :email_from_name, :email_replyto,
+   :warmup_schedule, :warmup_started_at, :created_at, :modified_at,
+   :tls_mode, :tls_insecure_skip_verify, :tls_ca_bundle,
+   :connect_timeout_ms, :send_timeout_ms)
+`,
+			sql.Named("smtp_transport_id", r.SMTPTransportID),
+			sql.Named("project_id", r.ProjectID),
+			sql.Named("transport_name", r.TransportName),
+			sql.Named("host", r.Host),
+			sql.Named("port", r.Port),
+			sql.Named("username", r.Username),
+			sql.Named("encrypted_password", r.EncryptedPassword),
+			sql.Named("email_from", r.EmailFrom),
+			sql.Named("email_from_name", r.EmailFromName),
+			sql.Named("email_replyto", r.EmailReplyTo),
+			sql.Named("warmup_schedule", r.WarmupSchedule),
+			sql.Named("warmup_started_at", &r.WarmupStartedAt),
+			sql.Named("created_at", &r.CreatedAt),
+			sql.Named("modified_at", &r.ModifiedAt),
+			sql.Named("tls_mode", r.TLSMode),
+			sql.Named("tls_insecure_skip_verify", r.TLSInsecureSkipVerify),
+			sql.Named("tls_ca_bundle", r.TLSCABundle),
+			sql.Named("connect_timeout_ms", r.ConnectTimeoutMS),
+			sql.Named("send_timeout_ms", r.SendTimeoutMS),
+		); err != nil {
+			return err
+		}
+	}
+
+	for _, r := range snap.APITransports {
+		if err := q.restoreExec(ctx, "api_transports", `
+insert into api_transports
+  (api_transport_id, project_id, transport_name, provider, config,
+   encrypted_api_key, email_from, email_from_name, email_replyto,
+   created_at, modified_at)
+values
+  (:api_transport_id, :project_id, :transport_name, :provider, :config,
+   :encrypted_api_key, :email_from, :email_from_name, :email_replyto,
+   :created_at, :modified_at)
+`,
+			sql.Named("api_transport_id", r.APITransportID),
+			sql.Named("project_id", r.ProjectID),
+			sql.Named("transport_name", r.TransportName),
+			sql.Named("provider", r.Provider),
+			sql.Named("config", r.Config),
+			sql.Named("encrypted_api_key", r.EncryptedAPIKey),
+			sql.Named("email_from", r.EmailFrom),
+			sql.Named("email_from_name", r.EmailFromName),
+			sql.Named("email_replyto", r.EmailReplyTo),
+			sql.Named("created_at", &r.CreatedAt),
+			sql.Named("modified_at", &r.ModifiedAt),
+		); err != nil {
+			return err
+		}
+	}
+
+	for _, r := range snap.Groups {
+		if err := q.restoreExec(ctx, "groups", `
+insert into groups
+  (group_id, project_id, group_name, created_at, modified_at)
+values
+  (:group_id, :project_id, :group_name, :created_at, :modified_at)
+`,
+			sql.Named("group_id", r.GroupID),
+			sql.Named("project_id", r.ProjectID),
+			sql.Named("group_name", r.GroupName),
+			sql.Named("created_at", &r.CreatedAt),
+			sql.Named("modified_at", &r.ModifiedAt),
+		); err != nil {
+			return err
+		}
+	}
+
+	for _, r := range snap.Templates {
+		if err := q.restoreExec(ctx, "templates", `
+insert into templates
+  (template_id, group_id, project_id, txt, txt_digest, html, html_digest,
+   subject, source_type, source, asset_mode, labels, created_at, modified_at)
+values
+  (:template_id, :group_id, :project_id, :txt, :txt_digest, :html, :html_digest,
+   :subject, :source_type, :source, :asset_mode, :labels, :created_at, :modified_at)
+`,
+			sql.Named("template_id", r.TemplateID),
+			sql.Named("group_id", r.GroupID),
+			sql.Named("project_id", r.ProjectID),
+			sql.Named("txt", r.Txt),
+			sql.Named("txt_digest", r.TxtDigest),
+			sql.Named("html", r.HTML),
+			sql.Named("html_digest", r.HTMLDigest),
+			sql.Named("subject", r.Subject),
+			sql.Named("source_type", r.SourceType),
+			sql.Named("source", r.Source),
+			sql.Named("asset_mode", r.AssetMode),
+			sql.Named("labels", r.Labels),
+			sql.Named("created_at", &r.CreatedAt),
+			sql.Named("modified_at", &r.ModifiedAt),
+		); err != nil {
+			return err
+		}
+	}
+
+	for _, r := range snap.Partials {
+		if err := q.restoreExec(ctx, "template_partials", `
+insert into template_partials
+  (partial_name, group_id, project_id, txt, html, created_at, modified_at)
+values
+  (:partial_name, :group_id, :project_id, :txt, :html, :created_at, :modified_at)
+`,
+			sql.Named("partial_name", r.PartialName),
+			sql.Named("group_id", r.GroupID),
+			sql.Named("project_id", r.ProjectID),
+			sql.Named("txt", r.Txt),
+			sql.Named("html", r.HTML),
+			sql.Named("created_at", &r.CreatedAt),
+			sql.Named("modified_at", &r.ModifiedAt),
+		); err != nil {
+			return err
+		}
+	}
+
+	for _, r := range snap.TemplateVariants {
+		if err := q.restoreExec(ctx, "template_variants", `
+insert into template_variants
+  (template_id, project_id, locale, txt, txt_digest, html, html_digest, subject,
+   created_at, modified_at)
+values
+  (:template_id, :project_id, :locale, :txt, :txt_digest, :html, :html_digest, :subject,
+   :created_at, :modified_at)
+`,
+			sql.Named("template_id", r.TemplateID),
+			sql.Named("project_id", r.ProjectID),
+			sql.Named("locale", r.Locale),
+			sql.Named("txt", r.Txt),
+			sql.Named("txt_digest", r.TxtDigest),
+			sql.Named("html", r.HTML),
+			sql.Named("html_digest", r.HTMLDigest),
+			sql.Named("subject", r.Subject),
+			sql.Named("created_at", &r.CreatedAt),
+			sql.Named("modified_at", &r.ModifiedAt),
+		); err != nil {
+			return err
+		}
+	}
+
+	for _, r := range snap.TemplateFixtures {
+		if err := q.restoreExec(ctx, "template_fixtures", `
+insert into template_fixtures
+  (template_id, project_id, name, params, created_at, modified_at)
+values
+  (:template_id, :project_id, :name, :params, :created_at, :modified_at)
+`,
+			sql.Named("template_id", r.TemplateID),
+			sql.Named("project_id", r.ProjectID),
+			sql.Named("name", r.Name),
+			sql.Named("params", r.Params),
+			sql.Named("created_at", &r.CreatedAt),
+			sql.Named("modified_at", &r.ModifiedAt),
+		); err != nil {
+			return err
+		}
+	}
+
+	for _, r := range snap.SendWindows {
+		if err := q.restoreExec(ctx, "send_windows", `
+insert into send_windows
+  (project_id, group_id, start_time, end_time, timezone,
+   created_at, modified_at)
+values
+  (:project_id, :group_id, :start_time, :end_time, :timezone,
+   :created_at, :modified_at)
+`,
+			sql.Named("project_id", r.ProjectID),
+			sql.Named("group_id", r.GroupID),
+			sql.Named("start_time", r.StartTime),
+			sql.Named("end_time", r.EndTime),
+			sql.Named("timezone", r.Timezone),
+			sql.Named("created_at", &r.CreatedAt),
+			sql.Named("modified_at", &r.ModifiedAt),
+		); err != nil {
+			return err
+		}
+	}
+
+	for _, r := range snap.RateLimits {
+		if err := q.restoreExec(ctx, "rate_limits", `
+insert into rate_limits
+  (project_id, transport_id, per_second, per_minute, per_day,
+   created_at, modified_at)
+values
+  (:project_id, :transport_id, :per_second, :per_minute, :per_day,
+   :created_at, :modified_at)
+`,
+			sql.Named("project_id", r.ProjectID),
+			sql.Named("transport_id", r.TransportID),
+			sql.Named("per_second", r.PerSecond),
+			sql.Named("per_minute", r.PerMinute),
+			sql.Named("per_day", r.PerDay),
+			sql.Named("created_at", &r.CreatedAt),
+			sql.Named("modified_at", &r.ModifiedAt),
+		); err != nil {
+			return err
+		}
+	}
+
+	for _, r := range snap.SenderAllowLists {
+		if err := q.restoreExec(ctx, "sender_allow_lists", `
+insert into sender_allow_lists
+  (project_id, transport_id, emails, created_at, modified_at)
+values
+  (:project_id, :transport_id, :emails, :created_at, :modified_at)
+`,
+			sql.Named("project_id", r.ProjectID),
+			sql.Named("transport_id", r.TransportID),
+			sql.Named("emails", r.Emails),
+			sql.Named("created_at", &r.CreatedAt),
+			sql.Named("modified_at", &r.ModifiedAt),
+		); err != nil {
+			return err
+		}
+	}
+
+	for _, r := range snap.MessageCatalogs {
+		if err := q.restoreExec(ctx, "message_catalogs", `
+insert into message_catalogs
+  (project_id, locale, messages, created_at, modified_at)
+values
+  (:project_id, :locale, :messages, :created_at, :modified_at)
+`,
+			sql.Named("project_id", r.ProjectID),
+			sql.Named("locale", r.Locale),
+			sql.Named("messages", r.Messages),
+			sql.Named("created_at", &r.CreatedAt),
+			sql.Named("modified_at", &r.ModifiedAt),
+		); err != nil {
+			return err
+		}
+	}
+
+	for _, r := range snap.Attachments {
+		if err := q.restoreExec(ctx, "attachments", `
+insert into attachments
+  (attachment_id, project_id, filename, content_type, content, size,
+   checksum, created_at, modified_at)
+values
+  (:attachment_id, :project_id, :filename, :content_type, :content, :size,
+   :checksum, :created_at, :modified_at)
+`,
+			sql.Named("attachment_id", r.AttachmentID),
+			sql.Named("project_id", r.ProjectID),
+			sql.Named("filename", r.Filename),
+			sql.Named("content_type", r.ContentType),
+			sql.Named("content", r.Content),
+			sql.Named("size", len(r.Content)),
+			sql.Named("checksum", r.Checksum),
+			sql.Named("created_at", &r.CreatedAt),
+			sql.Named("modified_at", &r.ModifiedAt),
+		); err != nil {
+			return err
+		}
+	}
+
+	for _, r := range snap.TemplateAttachments {
+		if err := q.restoreExec(ctx, "template_attachments", `
+insert into template_attachments
+  (template_id, attachment_id, project_id, position)
+values
+  (:template_id, :attachment_id, :project_id, :position)
+`,
+			sql.Named("template_id", r.TemplateID),
+			sql.Named("attachment_id", r.AttachmentID),
+			sql.Named("project_id", r.ProjectID),
+			sql.Named("position", r.Position),
+		); err != nil {
+			return err
+		}
+	}
+
+	for _, r := range snap.Assets {
+		if err := q.restoreExec(ctx, "assets", `
+insert into assets
+  (asset_id, project_id, filename, content_type, content, size,
+   checksum, created_at, modified_at)
+values
+  (:asset_id, :project_id, :filename, :content_type, :content, :size,
+   :checksum, :created_at, :modified_at)
+`,
+			sql.Named("asset_id", r.AssetID),
+			sql.Named("project_id", r.ProjectID),
+			sql.Named("filename", r.Filename),
+			sql.Named("content_type", r.ContentType),
+			sql.Named("content", r.Content),
+			sql.Named("size", len(r.Content)),
+			sql.Named("checksum", r.Checksum),
+			sql.Named("created_at", &r.CreatedAt),
+			sql.Named("modified_at", &r.ModifiedAt),
+		); err != nil {
+			return err
+		}
+	}
+
+	for _, r := range snap.MailQueue {
+		if err := q.restoreExec(ctx, "mail_queue", `
+insert into mail_queue
+  (mail_queue_id, project_id, template_id, transport_id, mstate,
+   metadata, body, last_error, attempts, send_at, next_attempt_at,
+   deferral_reason, message_id, created_at, modified_at)
+values
+  (:mail_queue_id, :project_id, :template_id, :transport_id, :mstate,
+   :metadata, :body, :last_error, :attempts, :send_at, :next_attempt_at,
+   :deferral_reason, :message_id, :created_at, :modified_at)
+`,
+			sql.Named("mail_queue_id", r.MailQueueID),
+			sql.Named("project_id", r.ProjectID),
+			sql.Named("template_id", r.TemplateID),
+			sql.Named("transport_id", r.TransportID),
+			sql.Named("mstate", r.MState),
+			sql.Named("metadata", r.Metadata),
+			sql.Named("body", r.Body),
+			sql.Named("last_error", r.LastError),
+			sql.Named("attempts", r.Attempts),
+			sql.Named("send_at", &r.SendAt),
+			sql.Named("next_attempt_at", &r.NextAttemptAt),
+			sql.Named("deferral_reason", r.DeferralReason),
+			sql.Named("message_id", r.MessageID),
+			sql.Named("created_at", &r.CreatedAt),
+			sql.Named("modified_at", &r.ModifiedAt),
+		); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (q *Queries) restoreExec(ctx context.Context, table, query string, args ...any) error {
+	if _, err := q.readwrite.ExecContext(ctx, query, args...); err != nil {
+		return errors.Wrapf(err,
+			"[sqlite3:%s] exec failed query=%q", table, query)
+	}
+	return nil
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
+	"io"
 	"net/http"
 	"time"
 
@@ -20,10 +21,12 @@ import (
 type Store struct {
 	*Queries
 	readwrite *sql.DB
+	search    templateSearch
 }
 
-// NewStore returns a new store.
-func NewStore(ro, rw *sql.DB) *Store {
+// NewStore returns a new store. Reads use ro, which may be a *ReplicaDB, and
+// writes and transactions use rw.
+func NewStore(ro DBTx, rw *sql.DB) *Store {
 	return &Store{
 		Queries:   NewQueries(ro, rw),
 		readwrite: rw,
@@ -51,15 +54,17 @@ func (s *Store) execTx(ctx context.Context, fn func(*Queries) error) error {
 func (q *Queries) Close() error {
 	var isReadOnlyErr, isReadWriteErr bool
 
-	// convert the interface to its underlying type and check for errors
-	rw := q.readwrite.(*sql.DB)
-	if err := rw.Close(); err != nil {
-		isReadWriteErr = true
+	// the read-only connection may be a *sql.DB or a *ReplicaDB
+	if rw, ok := q.readwrite.(io.Closer); ok {
+		if err := rw.Close(); err != nil {
+			isReadWriteErr = true
+		}
 	}
 
-	ro := q.readonly.(*sql.DB)
-	if err := ro.Close(); err != nil {
-		isReadWriteErr = true
+	if ro, ok := q.readonly.(io.Closer); ok {
+		if err := ro.Close(); err != nil {
+			isReadOnlyErr = true
+		}
 	}
 
 	// report any errors
@@ -78,34 +83,52 @@ func (q *Queries) Close() error {
 
 // CreateSQLiteDBSchema creates the tables using the schema for
 // the sqlite3 database. If the tables already exist, this function
-// will not modify them.
+// applies any outstanding migrations.
 func CreateSqliteDBSchema(db *sql.DB) error {
+	mg, err := newMigrate(db)
+	if err != nil {
+		return err
+	}
+
+	// an up to date schema is not an error as this function is run
+	// against existing databases to apply any new migrations
+	if err := mg.Up(); err != nil && !errors.Is(err, migrate.ErrNoChange) {
+		return fmt.Errorf("migrate up failed: %w", err)
+	}
+
+	return nil
+}
+
+// newMigrate returns a migrate instance that applies the embedded
+// migrations to db. It must not be closed as that closes db.
+func newMigrate(db *sql.DB) (*migrate.Migrate, error) {
 	driver, err := driversqlite3.WithInstance(db, &driversqlite3.Config{NoTxWrap: true})
 	if err != nil {
-		return fmt.Errorf("failed to get new sqlite3 driver instance: %w", err)
+		return nil, fmt.Errorf("failed to get new sqlite3 driver instance: %w", err)
 	}
 
 	source, err := httpfs.New(http.FS(schema.Migrations), "migrations")
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	mg, err := migrate.NewWithInstance("https", source, "sqlite3", driver)
 	if err != nil {
-		return fmt.Errorf("failed to get new migrate instance: %w", err)
-	}
-
-	if err := mg.Up(); err != nil {
-		return fmt.Errorf("migrate up failed: %w", err)
+		return nil, fmt.Errorf("failed to get new migrate instance: %w", err)
 	}
-
-	return nil
+	return mg, nil
 }
 
 //
 // projects
 //
 
+const projectColumns = `
+  project_id, project_name, description, allowed_recipient_domains,
+  default_transport_id, default_group_id, transport_chain, labels, variables,
+  created_at
+`
+
 // InsertProject inserts a new project into the store.
 func (q *Queries) InsertProject(ctx context.Context, params store.AddProject) (*store.Project, error) {
 	const query = `
@@ -113,9 +136,8 @@ insert into projects
   (project_id, project_name, description, created_at)
 values
   (:project_id, :project_name, :description, :created_at)
-returning
-  project_id, project_name, description, created_at
-`
+returning` + projectColumns
+
 	var r store.Project
 	now := store.Datetime(time.Now().UTC())
 	if err := q.readwrite.QueryRowContext(ctx, query,
@@ -127,6 +149,12 @@ returning
 		&r.ProjectID,
 		&r.ProjectName,
 		&r.Description,
+		&r.AllowedRecipientDomains,
+		&r.DefaultTransportID,
+		&r.DefaultGroupID,
+		&r.TransportChain,
+		&r.Labels,
+		&r.Variables,
 		&r.CreatedAt,
 	); err != nil {
 		if serr, ok := err.(sqlite3.Error); ok {
@@ -145,31 +173,424 @@ returning
 // not found, an error of type store.ErrProjectNotFound is returned.
 func (q *Queries) GetProject(ctx context.Context, projectID string) (*store.Project, error) {
 	const query = `
-select
-  p.project_id, p.project_name, description, p.created_at
-from projects as p
+select` + projectColumns + `
+from projects
 where
-  p.project_id = :project_id
+  project_id = :project_id
+`
+	var r store.Project
+	if err := q.queryRow(ctx, query,
+		sql.Named("project_id", projectID),
+	).Scan(
+		&r.ProjectID,
+		&r.ProjectName,
+		&r.Description,
+		&r.AllowedRecipientDomains,
+		&r.DefaultTransportID,
+		&r.DefaultGroupID,
+		&r.TransportChain,
+		&r.Labels,
+		&r.Variables,
+		&r.CreatedAt,
+	); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, store.NewStoreError(store.ErrProjectNotFound, err)
+		}
+
+		return nil, errors.Wrapf(err,
+			"[sqlite3:projects] query row scan failed query=%q", query)
+	}
+	return &r, nil
+}
+
+// ListProjects lists the projects ordered by id.
+func (q *Queries) ListProjects(ctx context.Context, params store.ListProjects) ([]*store.Project, error) {
+	const query = `
+select` + projectColumns + `
+from projects
+where
+  project_id > :after and
+  not exists (
+    select 1 from json_each(:labels) as l
+    where not exists (
+      select 1 from json_each(projects.labels) as pl
+      where pl.key = l.key and pl.value = l.value
+    )
+  )
+order by project_id
+limit :limit
 `
+	limit := params.Limit
+	if limit <= 0 {
+		limit = -1
+	}
+	rows, err := q.readonly.QueryContext(ctx, query,
+		sql.Named("after", params.After),
+		sql.Named("labels", store.JSONObject(params.Labels)),
+		sql.Named("limit", limit),
+	)
+	if err != nil {
+		return nil, errors.Wrapf(err,
+			"[sqlite3:projects] query failed query=%q", query)
+	}
+	defer rows.Close()
+
+	list := make([]*store.Project, 0)
+	for rows.Next() {
+		var r store.Project
+		if err := rows.Scan(
+			&r.ProjectID,
+			&r.ProjectName,
+			&r.Description,
+			&r.AllowedRecipientDomains,
+			&r.DefaultTransportID,
+			&r.DefaultGroupID,
+			&r.TransportChain,
+			&r.Labels,
+			&r.Variables,
+			&r.CreatedAt,
+		); err != nil {
+			return nil, errors.Wrapf(err,
+				"[sqlite3:projects] rows scan failed query=%q", query)
+		}
+		list = append(list, &r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrapf(err,
+			"[sqlite3:projects] rows.Err failed query=%q", query)
+	}
+	return list, nil
+}
+
+// SetProjectAllowedRecipientDomains replaces the list of recipient domains
+// the project is allowed to send to. An empty list allows all domains.
+func (q *Queries) SetProjectAllowedRecipientDomains(ctx context.Context, projectID string, domains store.JSONArray) (*store.Project, error) {
+	const query = `
+update projects
+set
+  allowed_recipient_domains = :allowed_recipient_domains
+where
+  project_id = :project_id
+returning` + projectColumns
+	if domains == nil {
+		domains = store.JSONArray{}
+	}
+	var r store.Project
+	if err := q.readwrite.QueryRowContext(ctx, query,
+		sql.Named("allowed_recipient_domains", domains),
+		sql.Named("project_id", projectID),
+	).Scan(
+		&r.ProjectID,
+		&r.ProjectName,
+		&r.Description,
+		&r.AllowedRecipientDomains,
+		&r.DefaultTransportID,
+		&r.DefaultGroupID,
+		&r.TransportChain,
+		&r.Labels,
+		&r.Variables,
+		&r.CreatedAt,
+	); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, store.NewStoreError(store.ErrProjectNotFound, err)
+		}
+		return nil, errors.Wrapf(err,
+			"[sqlite3:projects] query row scan failed query=%q", query)
+	}
+	return &r, nil
+}
+
+// SetProjectDefaultTransport sets the transport used when a caller does not
+// specify one. An empty transportID clears the default.
+func (q *Queries) SetProjectDefaultTransport(ctx context.Context, projectID, transportID string) (*store.Project, error) {
+	const query = `
+update projects
+set
+  default_transport_id = :default_transport_id
+where
+  project_id = :project_id
+returning` + projectColumns
+
+	var r store.Project
+	if err := q.readwrite.QueryRowContext(ctx, query,
+		sql.Named("default_transport_id", transportID),
+		sql.Named("project_id", projectID),
+	).Scan(
+		&r.ProjectID,
+		&r.ProjectName,
+		&r.Description,
+		&r.AllowedRecipientDomains,
+		&r.DefaultTransportID,
+		&r.DefaultGroupID,
+		&r.TransportChain,
+		&r.Labels,
+		&r.Variables,
+		&r.CreatedAt,
+	); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, store.NewStoreError(store.ErrProjectNotFound, err)
+		}
+		return nil, errors.Wrapf(err,
+			"[sqlite3:projects] query row scan failed query=%q", query)
+	}
+	return &r, nil
+}
+
+// SetProjectTransportChain replaces the ordered list of transports the
+// project's emails fall back through. An empty list disables fallback.
+func (q *Queries) SetProjectTransportChain(ctx context.Context, projectID string, transportIDs store.JSONArray) (*store.Project, error) {
+	const query = `
+update projects
+set
+  transport_chain = :transport_chain
+where
+  project_id = :project_id
+returning` + projectColumns
+	if transportIDs == nil {
+		transportIDs = store.JSONArray{}
+	}
+	var r store.Project
+	if err := q.readwrite.QueryRowContext(ctx, query,
+		sql.Named("transport_chain", transportIDs),
+		sql.Named("project_id", projectID),
+	).Scan(
+		&r.ProjectID,
+		&r.ProjectName,
+		&r.Description,
+		&r.AllowedRecipientDomains,
+		&r.DefaultTransportID,
+		&r.DefaultGroupID,
+		&r.TransportChain,
+		&r.Labels,
+		&r.Variables,
+		&r.CreatedAt,
+	); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, store.NewStoreError(store.ErrProjectNotFound, err)
+		}
+		return nil, errors.Wrapf(err,
+			"[sqlite3:projects] query row scan failed query=%q", query)
+	}
+	return &r, nil
+}
+
+// SetProjectDefaultGroup sets the group used for templates created without
+// one. An empty groupID clears the default.
+func (q *Queries) SetProjectDefaultGroup(ctx context.Context, projectID, groupID string) (*store.Project, error) {
+	const query = `
+update projects
+set
+  default_group_id = :default_group_id
+where
+  project_id = :project_id
+returning` + projectColumns
+
+	var r store.Project
+	if err := q.readwrite.QueryRowContext(ctx, query,
+		sql.Named("default_group_id", groupID),
+		sql.Named("project_id", projectID),
+	).Scan(
+		&r.ProjectID,
+		&r.ProjectName,
+		&r.Description,
+		&r.AllowedRecipientDomains,
+		&r.DefaultTransportID,
+		&r.DefaultGroupID,
+		&r.TransportChain,
+		&r.Labels,
+		&r.Variables,
+		&r.CreatedAt,
+	); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, store.NewStoreError(store.ErrProjectNotFound, err)
+		}
+		return nil, errors.Wrapf(err,
+			"[sqlite3:projects] query row scan failed query=%q", query)
+	}
+	return &r, nil
+}
+
+// SetProjectLabels replaces the labels of a project. If the project is not
+// found, an error of type store.ErrProjectNotFound is returned.
+func (q *Queries) SetProjectLabels(ctx context.Context, projectID string, labels store.JSONObject) (*store.Project, error) {
+	const query = `
+update projects
+set
+  labels = :labels
+where
+  project_id = :project_id
+returning` + projectColumns
+
+	var r store.Project
+	if err := q.readwrite.QueryRowContext(ctx, query,
+		sql.Named("labels", labels),
+		sql.Named("project_id", projectID),
+	).Scan(
+		&r.ProjectID,
+		&r.ProjectName,
+		&r.Description,
+		&r.AllowedRecipientDomains,
+		&r.DefaultTransportID,
+		&r.DefaultGroupID,
+		&r.TransportChain,
+		&r.Labels,
+		&r.Variables,
+		&r.CreatedAt,
+	); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, store.NewStoreError(store.ErrProjectNotFound, err)
+		}
+		return nil, errors.Wrapf(err,
+			"[sqlite3:projects] query row scan failed query=%q", query)
+	}
+	return &r, nil
+}
+
+// SetProjectVariables replaces the template variables of a
+// project. If the project is not
+// found, an error of type store.ErrProjectNotFound is returned.
+func (q *Queries) SetProjectVariables(ctx context.Context, projectID string, variables store.JSONObject) (*store.Project, error) {
+	const query = `
+update projects
+set
+  variables = :variables
+where
+  project_id = :project_id
+returning` + projectColumns
+
 	var r store.Project
-	if err := q.readonly.QueryRowContext(ctx, query,
+	if err := q.readwrite.QueryRowContext(ctx, query,
+		sql.Named("variables", variables),
 		sql.Named("project_id", projectID),
 	).Scan(
 		&r.ProjectID,
 		&r.ProjectName,
 		&r.Description,
+		&r.AllowedRecipientDomains,
+		&r.DefaultTransportID,
+		&r.DefaultGroupID,
+		&r.TransportChain,
+		&r.Labels,
+		&r.Variables,
 		&r.CreatedAt,
 	); err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, store.NewStoreError(store.ErrProjectNotFound, err)
 		}
+		return nil, errors.Wrapf(err,
+			"[sqlite3:projects] query row scan failed query=%q", query)
+	}
+	return &r, nil
+}
+
+// UpdateProject sets the name and description of a project. If the project
+// is not found, an error of type store.ErrProjectNotFound is returned.
+func (q *Queries) UpdateProject(ctx context.Context, params store.UpdateProject) (*store.Project, error) {
+	const query = `
+update projects
+set
+  project_name = :project_name,
+  description = :description
+where
+  project_id = :project_id
+returning` + projectColumns
 
+	var r store.Project
+	if err := q.readwrite.QueryRowContext(ctx, query,
+		sql.Named("project_name", params.ProjectName),
+		sql.Named("description", params.Description),
+		sql.Named("project_id", params.ProjectID),
+	).Scan(
+		&r.ProjectID,
+		&r.ProjectName,
+		&r.Description,
+		&r.AllowedRecipientDomains,
+		&r.DefaultTransportID,
+		&r.DefaultGroupID,
+		&r.TransportChain,
+		&r.Labels,
+		&r.Variables,
+		&r.CreatedAt,
+	); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, store.NewStoreError(store.ErrProjectNotFound, err)
+		}
 		return nil, errors.Wrapf(err,
 			"[sqlite3:projects] query row scan failed query=%q", query)
 	}
 	return &r, nil
 }
 
+// projectTables lists the tables holding rows that belong to a project in
+// the order they must be deleted to satisfy the foreign key constraints.
+var projectTables = []string{
+	"template_attachments",
+	"attachments",
+	"assets",
+	"message_catalogs",
+	"send_windows",
+	"rate_limits",
+	"project_quotas",
+	"project_quota_usage",
+	"sender_allow_lists",
+	"webhook_deliveries",
+	"webhooks",
+	"campaigns",
+	"contacts",
+	"contact_lists",
+	"suppressions",
+	"transport_verifications",
+	"mail_queue_attempts",
+	"mail_queue_opens",
+	"mail_queue",
+	"template_partials",
+	"template_variants",
+	"template_fixtures",
+	"templates",
+	"groups",
+	"api_transports",
+	"smtp_transports",
+}
+
+// DeleteProject deletes a project along with its transports, groups,
+// templates and every other row that belongs to it in a single
+// transaction. If the project is not found, an error of type
+// store.ErrProjectNotFound is returned.
+func (s *Store) DeleteProject(ctx context.Context, projectID string) error {
+	return s.execTx(ctx, func(q *Queries) error {
+		for _, table := range projectTables {
+			query := "delete from " + table + " where project_id = :project_id"
+			if _, err := q.readwrite.ExecContext(ctx, query,
+				sql.Named("project_id", projectID),
+			); err != nil {
+				return errors.Wrapf(err,
+					"[sqlite3:%s] exec failed query=%q", table, query)
+			}
+		}
+
+		const query = `
+delete from projects
+where
+  project_id = :project_id
+`
+		res, err := q.readwrite.ExecContext(ctx, query,
+			sql.Named("project_id", projectID),
+		)
+		if err != nil {
+			return errors.Wrapf(err,
+				"[sqlite3:projects] exec failed query=%q", query)
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return errors.Wrapf(err, "[sqlite3:projects] res.RowsAffected failed")
+		}
+		if n == 0 {
+			return store.NewStoreError(store.ErrProjectNotFound, nil)
+		}
+		return nil
+	})
+}
+
 //
 // smtp transports
 //
@@ -180,7 +601,8 @@ func (q *Queries) InsertSMTPTransport(ctx context.Context, params store.AddSMTPT
 insert into smtp_transports as t (
   smtp_transport_id, project_id, transport_name, host, port, username,
   encrypted_password, email_from, email_from_name, email_replyto,
-  created_at, modified_at
+  tls_mode, tls_insecure_skip_verify, tls_ca_bundle, connect_timeout_ms,
+  send_timeout_ms, created_at, modified_at
 )
 select
   :smtp_transport_id as smtp_transport_id,
@@ -193,6 +615,11 @@ select
   :email_from as email_from,
   :email_from_name as email_from_name,
   :email_replyto as email_replyto,
+  :tls_mode as tls_mode,
+  :tls_insecure_skip_verify as tls_insecure_skip_verify,
+  :tls_ca_bundle as tls_ca_bundle,
+  :connect_timeout_ms as connect_timeout_ms,
+  :send_timeout_ms as send_timeout_ms,
   :created_at as created_at,
   :modified_at as modified_at
 from projects as p
@@ -200,7 +627,9 @@ where p.project_id = :project_id
 returning
   smtp_transport_id, project_id, transport_name, host, port, username,
   encrypted_password, email_from, email_from_name, email_replyto,
-  created_at, modified_at
+  warmup_schedule, warmup_started_at, created_at, modified_at,
+  tls_mode, tls_insecure_skip_verify, tls_ca_bundle,
+  connect_timeout_ms, send_timeout_ms
 `
 	var r store.SMTPTransport
 	now := store.Datetime(time.Now().UTC())
@@ -214,6 +643,11 @@ returning
 		sql.Named("email_from", params.EmailFrom),
 		sql.Named("email_from_name", params.EmailFromName),
 		sql.Named("email_replyto", params.EmailReplyTo),
+		sql.Named("tls_mode", params.TLSMode),
+		sql.Named("tls_insecure_skip_verify", params.TLSInsecureSkipVerify),
+		sql.Named("tls_ca_bundle", params.TLSCABundle),
+		sql.Named("connect_timeout_ms", params.ConnectTimeoutMS),
+		sql.Named("send_timeout_ms", params.SendTimeoutMS),
 		sql.Named("created_at", &now),
 		sql.Named("modified_at", &now),
 		sql.Named("project_id", params.ProjectID),
@@ -228,9 +662,21 @@ returning
 		&r.EmailFrom,
 		&r.EmailFromName,
 		&r.EmailReplyTo,
+		&r.WarmupSchedule,
+		&r.WarmupStartedAt,
 		&r.CreatedAt,
 		&r.ModifiedAt,
+		&r.TLSMode,
+		&r.TLSInsecureSkipVerify,
+		&r.TLSCABundle,
+		&r.ConnectTimeoutMS,
+		&r.SendTimeoutMS,
 	); err != nil {
+		// the insert selects from the projects table so if no rows
+		// are returned then the project does not exist
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, store.NewStoreError(store.ErrProjectNotFound, err)
+		}
 		return nil, errors.Wrapf(err,
 			"[sqlite3:smtp_transports] query row scan failed query=%q", query)
 	}
@@ -251,9 +697,16 @@ select
   coalesce(t.encrypted_password, '') as encrypted_password,
   coalesce(t.email_from, '') as email_from,
   coalesce(t.email_from_name, '') as email_from_name,
-  coalesce(t.email_replyto, '') as email_replyto,
+  coalesce(t.email_replyto, '[]') as email_replyto,
+  coalesce(t.warmup_schedule, '[]') as warmup_schedule,
+  coalesce(t.warmup_started_at, '1970-01-01T00:00:00.000000Z') as warmup_started_at,
   coalesce(t.created_at, '1970-01-01T00:00:00.000000Z') as created_at,
-  coalesce(t.modified_at, '1970-01-01T00:00:00.000000Z') as modified_at
+  coalesce(t.modified_at, '1970-01-01T00:00:00.000000Z') as modified_at,
+  coalesce(t.tls_mode, '') as tls_mode,
+  coalesce(t.tls_insecure_skip_verify, 0) as tls_insecure_skip_verify,
+  coalesce(t.tls_ca_bundle, '') as tls_ca_bundle,
+  coalesce(t.connect_timeout_ms, 0) as connect_timeout_ms,
+  coalesce(t.send_timeout_ms, 0) as send_timeout_ms
 from projects as p
 left outer join smtp_transports as t
   on p.project_id = t.project_id and t.smtp_transport_id = :smtp_transport_id
@@ -262,7 +715,7 @@ where
 `
 
 	var r store.SMTPTransport
-	if err := q.readonly.QueryRowContext(ctx, query,
+	if err := q.queryRow(ctx, query,
 		sql.Named("project_id", projectID),
 		sql.Named("smtp_transport_id", transportID),
 	).Scan(
@@ -276,23 +729,356 @@ where
 		&r.EmailFrom,
 		&r.EmailFromName,
 		&r.EmailReplyTo,
+		&r.WarmupSchedule,
+		&r.WarmupStartedAt,
 		&r.CreatedAt,
 		&r.ModifiedAt,
+		&r.TLSMode,
+		&r.TLSInsecureSkipVerify,
+		&r.TLSCABundle,
+		&r.ConnectTimeoutMS,
+		&r.SendTimeoutMS,
 	); err != nil {
 		// if there are no rows returned, then the project does not exist
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, store.NewStoreError(store.ErrProjectNotFound, err)
 		}
 
-		return nil, errors.Wrapf(err,
-			"[sqlite3:smtp_transports] query row scan failed query=%q", query)
-	}
-
-	if r.SMTPTransportID == "" {
-		return nil, store.ErrTransportNotFound
-	}
+		return nil, errors.Wrapf(err,
+			"[sqlite3:smtp_transports] query row scan failed query=%q", query)
+	}
+
+	if r.SMTPTransportID == "" {
+		return nil, store.ErrTransportNotFound
+	}
+
+	return &r, nil
+}
+
+// SetSMTPTransportWarmup sets the warm-up schedule of an SMTP transport.
+// An empty schedule disables warm-up throttling.
+func (q *Queries) SetSMTPTransportWarmup(ctx context.Context, transportID, projectID string, schedule store.JSONIntArray, startedAt store.Datetime) (*store.SMTPTransport, error) {
+	const query = `
+update smtp_transports
+set
+  warmup_schedule = :warmup_schedule,
+  warmup_started_at = :warmup_started_at,
+  modified_at = :modified_at
+where
+  smtp_transport_id = :smtp_transport_id and project_id = :project_id
+returning
+  smtp_transport_id, project_id, transport_name, host, port, username,
+  encrypted_password, email_from, email_from_name, email_replyto,
+  warmup_schedule, warmup_started_at, created_at, modified_at,
+  tls_mode, tls_insecure_skip_verify, tls_ca_bundle,
+  connect_timeout_ms, send_timeout_ms
+`
+	if schedule == nil {
+		schedule = store.JSONIntArray{}
+	}
+	var r store.SMTPTransport
+	now := store.Datetime(time.Now().UTC())
+	if err := q.readwrite.QueryRowContext(ctx, query,
+		sql.Named("warmup_schedule", schedule),
+		sql.Named("warmup_started_at", &startedAt),
+		sql.Named("modified_at", &now),
+		sql.Named("smtp_transport_id", transportID),
+		sql.Named("project_id", projectID),
+	).Scan(
+		&r.SMTPTransportID,
+		&r.ProjectID,
+		&r.TransportName,
+		&r.Host,
+		&r.Port,
+		&r.Username,
+		&r.EncryptedPassword,
+		&r.EmailFrom,
+		&r.EmailFromName,
+		&r.EmailReplyTo,
+		&r.WarmupSchedule,
+		&r.WarmupStartedAt,
+		&r.CreatedAt,
+		&r.ModifiedAt,
+		&r.TLSMode,
+		&r.TLSInsecureSkipVerify,
+		&r.TLSCABundle,
+		&r.ConnectTimeoutMS,
+		&r.SendTimeoutMS,
+	); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, store.ErrTransportNotFound
+		}
+		return nil, errors.Wrapf(err,
+			"[sqlite3:smtp_transports] query row scan failed query=%q", query)
+	}
+	return &r, nil
+}
+
+const smtpTransportColumns = `
+  smtp_transport_id, project_id, transport_name, host, port, username,
+  encrypted_password, email_from, email_from_name, email_replyto,
+  warmup_schedule, warmup_started_at, created_at, modified_at,
+  tls_mode, tls_insecure_skip_verify, tls_ca_bundle,
+  connect_timeout_ms, send_timeout_ms
+`
+
+func scanSMTPTransport(row rowScanner) (*store.SMTPTransport, error) {
+	var r store.SMTPTransport
+	if err := row.Scan(
+		&r.SMTPTransportID,
+		&r.ProjectID,
+		&r.TransportName,
+		&r.Host,
+		&r.Port,
+		&r.Username,
+		&r.EncryptedPassword,
+		&r.EmailFrom,
+		&r.EmailFromName,
+		&r.EmailReplyTo,
+		&r.WarmupSchedule,
+		&r.WarmupStartedAt,
+		&r.CreatedAt,
+		&r.ModifiedAt,
+		&r.TLSMode,
+		&r.TLSInsecureSkipVerify,
+		&r.TLSCABundle,
+		&r.ConnectTimeoutMS,
+		&r.SendTimeoutMS,
+	); err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// UpdateSMTPTransport replaces the connection and sender settings of an
+// SMTP transport. If the transport does not exist store.ErrTransportNotFound
+// is returned.
+func (q *Queries) UpdateSMTPTransport(ctx context.Context, params store.UpdateSMTPTransport) (*store.SMTPTransport, error) {
+	const query = `
+update smtp_transports
+set
+  transport_name = :transport_name,
+  host = :host,
+  port = :port,
+  username = :username,
+  email_from = :email_from,
+  email_from_name = :email_from_name,
+  email_replyto = :email_replyto,
+  tls_mode = :tls_mode,
+  tls_insecure_skip_verify = :tls_insecure_skip_verify,
+  tls_ca_bundle = :tls_ca_bundle,
+  connect_timeout_ms = :connect_timeout_ms,
+  send_timeout_ms = :send_timeout_ms,
+  modified_at = :modified_at
+where
+  smtp_transport_id = :smtp_transport_id and project_id = :project_id
+returning` + smtpTransportColumns
+
+	replyTo := params.EmailReplyTo
+	if replyTo == nil {
+		replyTo = store.JSONArray{}
+	}
+	now := store.Datetime(time.Now().UTC())
+	r, err := scanSMTPTransport(q.readwrite.QueryRowContext(ctx, query,
+		sql.Named("transport_name", params.TransportName),
+		sql.Named("host", params.Host),
+		sql.Named("port", params.Port),
+		sql.Named("username", params.Username),
+		sql.Named("email_from", params.EmailFrom),
+		sql.Named("email_from_name", params.EmailFromName),
+		sql.Named("email_replyto", replyTo),
+		sql.Named("tls_mode", params.TLSMode),
+		sql.Named("tls_insecure_skip_verify", params.TLSInsecureSkipVerify),
+		sql.Named("tls_ca_bundle", params.TLSCABundle),
+		sql.Named("connect_timeout_ms", params.ConnectTimeoutMS),
+		sql.Named("send_timeout_ms", params.SendTimeoutMS),
+		sql.Named("modified_at", &now),
+		sql.Named("smtp_transport_id", params.SMTPTransportID),
+		sql.Named("project_id", params.ProjectID),
+	))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, store.ErrTransportNotFound
+		}
+		return nil, errors.Wrapf(err,
+			"[sqlite3:smtp_transports] query row scan failed query=%q", query)
+	}
+	return r, nil
+}
+
+// SetSMTPTransportPassword replaces the encrypted password of an SMTP
+// transport and its modified_at time in a single statement. If the
+// transport does not exist store.ErrTransportNotFound is returned.
+func (q *Queries) SetSMTPTransportPassword(ctx context.Context, transportID, projectID, encryptedPassword string) (*store.SMTPTransport, error) {
+	const query = `
+update smtp_transports
+set
+  encrypted_password = :encrypted_password,
+  modified_at = :modified_at
+where
+  smtp_transport_id = :smtp_transport_id and project_id = :project_id
+returning` + smtpTransportColumns
+
+	now := store.Datetime(time.Now().UTC())
+	r, err := scanSMTPTransport(q.readwrite.QueryRowContext(ctx, query,
+		sql.Named("encrypted_password", encryptedPassword),
+		sql.Named("modified_at", &now),
+		sql.Named("smtp_transport_id", transportID),
+		sql.Named("project_id", projectID),
+	))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, store.ErrTransportNotFound
+		}
+		return nil, errors.Wrapf(err,
+			"[sqlite3:smtp_transports] query row scan failed query=%q", query)
+	}
+	return r, nil
+}
+
+// DeleteSMTPTransport deletes an SMTP transport. If queued or sending
+// emails reference the transport an error of type store.ErrTransportInUse
+// is returned, unless force is set, in which case those emails are marked
+// as failed. The transport is cleared as the project's default transport
+// and removed from its transport chain. If the transport does not exist
+// store.ErrTransportNotFound is returned.
+func (s *Store) DeleteSMTPTransport(ctx context.Context, transportID, projectID string, force bool) error {
+	const countQuery = `
+select count(*)
+from mail_queue
+where
+  project_id = :project_id and transport_id = :transport_id and
+  mstate in ('queued', 'rate_limited', 'sending', 'paused')
+`
+	const failQuery = `
+update mail_queue
+set
+  mstate = 'failed',
+  last_error = 'transport deleted',
+  modified_at = :modified_at
+where
+  project_id = :project_id and transport_id = :transport_id and
+  mstate in ('queued', 'rate_limited', 'sending', 'paused')
+`
+	const defaultQuery = `
+update projects
+set
+  default_transport_id = ''
+where
+  project_id = :project_id and default_transport_id = :transport_id
+`
+	const chainQuery = `
+update projects
+set
+  transport_chain = (
+    select json_group_array(c.value)
+    from json_each(projects.transport_chain) as c
+    where c.value != :transport_id
+  )
+where
+  project_id = :project_id and exists (
+    select 1 from json_each(projects.transport_chain) as c
+    where c.value = :transport_id
+  )
+`
+	const rateLimitQuery = `
+delete from rate_limits
+where
+  project_id = :project_id and transport_id = :transport_id
+`
+	const allowListQuery = `
+delete from sender_allow_lists
+where
+  project_id = :project_id and transport_id = :transport_id
+`
+	const verificationQuery = `
+delete from transport_verifications
+where
+  project_id = :project_id and transport_id = :transport_id
+`
+	const deleteQuery = `
+delete from smtp_transports
+where
+  smtp_transport_id = :smtp_transport_id and project_id = :project_id
+`
+	return s.execTx(ctx, func(q *Queries) error {
+		res, err := q.readwrite.ExecContext(ctx, deleteQuery,
+			sql.Named("smtp_transport_id", transportID),
+			sql.Named("project_id", projectID),
+		)
+		if err != nil {
+			return errors.Wrapf(err,
+				"[sqlite3:smtp_transports] exec failed query=%q", deleteQuery)
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return errors.Wrapf(err, "[sqlite3:smtp_transports] res.RowsAffected failed")
+		}
+		if n == 0 {
+			return store.ErrTransportNotFound
+		}
+
+		var pending int
+		if err := q.readwrite.QueryRowContext(ctx, countQuery,
+			sql.Named("project_id", projectID),
+			sql.Named("transport_id", transportID),
+		).Scan(&pending); err != nil {
+			return errors.Wrapf(err,
+				"[sqlite3:mail_queue] query row scan failed query=%q", countQuery)
+		}
+		if pending > 0 {
+			if !force {
+				return store.NewStoreError(store.ErrTransportInUse,
+					errors.Errorf("%d pending emails reference transport %q", pending, transportID))
+			}
+			now := store.Datetime(time.Now().UTC())
+			if _, err := q.readwrite.ExecContext(ctx, failQuery,
+				sql.Named("modified_at", &now),
+				sql.Named("project_id", projectID),
+				sql.Named("transport_id", transportID),
+			); err != nil {
+				return errors.Wrapf(err,
+					"[sqlite3:mail_queue] exec failed query=%q", failQuery)
+			}
+		}
 
-	return &r, nil
+		if _, err := q.readwrite.ExecContext(ctx, defaultQuery,
+			sql.Named("project_id", projectID),
+			sql.Named("transport_id", transportID),
+		); err != nil {
+			return errors.Wrapf(err,
+				"[sqlite3:projects] exec failed query=%q", defaultQuery)
+		}
+		if _, err := q.readwrite.ExecContext(ctx, chainQuery,
+			sql.Named("project_id", projectID),
+			sql.Named("transport_id", transportID),
+		); err != nil {
+			return errors.Wrapf(err,
+				"[sqlite3:projects] exec failed query=%q", chainQuery)
+		}
+		if _, err := q.readwrite.ExecContext(ctx, rateLimitQuery,
+			sql.Named("project_id", projectID),
+			sql.Named("transport_id", transportID),
+		); err != nil {
+			return errors.Wrapf(err,
+				"[sqlite3:rate_limits] exec failed query=%q", rateLimitQuery)
+		}
+		if _, err := q.readwrite.ExecContext(ctx, allowListQuery,
+			sql.Named("project_id", projectID),
+			sql.Named("transport_id", transportID),
+		); err != nil {
+			return errors.Wrapf(err,
+				"[sqlite3:sender_allow_lists] exec failed query=%q", allowListQuery)
+		}
+		if _, err := q.readwrite.ExecContext(ctx, verificationQuery,
+			sql.Named("project_id", projectID),
+			sql.Named("transport_id", transportID),
+		); err != nil {
+			return errors.Wrapf(err,
+				"[sqlite3:transport_verifications] exec failed query=%q", verificationQuery)
+		}
+		return nil
+	})
 }
 
 //
@@ -364,7 +1150,7 @@ where
   p.project_id = :project_id
 `
 	var r store.Group
-	if err := q.readonly.QueryRowContext(ctx, query,
+	if err := q.queryRow(ctx, query,
 		sql.Named("project_id", projectID),
 		sql.Named("group_id", groupID),
 	).Scan(
@@ -389,6 +1175,187 @@ where
 	return &r, nil
 }
 
+// ListGroups lists the groups of a project ordered by id.
+func (q *Queries) ListGroups(ctx context.Context, params store.ListGroups) ([]*store.Group, error) {
+	const query = `
+select
+  group_id, project_id, group_name, created_at, modified_at
+from groups
+where
+  project_id = :project_id and group_id > :after
+order by group_id
+limit :limit
+`
+	limit := params.Limit
+	if limit <= 0 {
+		limit = -1
+	}
+	rows, err := q.readonly.QueryContext(ctx, query,
+		sql.Named("project_id", params.ProjectID),
+		sql.Named("after", params.After),
+		sql.Named("limit", limit),
+	)
+	if err != nil {
+		return nil, errors.Wrapf(err,
+			"[sqlite3:groups] query failed query=%q", query)
+	}
+	defer rows.Close()
+
+	list := make([]*store.Group, 0)
+	for rows.Next() {
+		var r store.Group
+		if err := rows.Scan(
+			&r.GroupID,
+			&r.ProjectID,
+			&r.GroupName,
+			&r.CreatedAt,
+			&r.ModifiedAt,
+		); err != nil {
+			return nil, errors.Wrapf(err,
+				"[sqlite3:groups] rows scan failed query=%q", query)
+		}
+		list = append(list, &r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrapf(err,
+			"[sqlite3:groups] rows.Err failed query=%q", query)
+	}
+	return list, nil
+}
+
+// UpdateGroup renames a group. If the group is not found, an error of type
+// store.ErrGroupNotFound is returned.
+func (q *Queries) UpdateGroup(ctx context.Context, params store.UpdateGroup) (*store.Group, error) {
+	const query = `
+update groups
+set
+  group_name = :group_name,
+  modified_at = :modified_at
+where
+  group_id = :group_id and project_id = :project_id
+returning
+  group_id, project_id, group_name, created_at, modified_at
+`
+	var r store.Group
+	now := store.Datetime(time.Now().UTC())
+	if err := q.readwrite.QueryRowContext(ctx, query,
+		sql.Named("group_name", params.GroupName),
+		sql.Named("modified_at", &now),
+		sql.Named("group_id", params.GroupID),
+		sql.Named("project_id", params.ProjectID),
+	).Scan(
+		&r.GroupID,
+		&r.ProjectID,
+		&r.GroupName,
+		&r.CreatedAt,
+		&r.ModifiedAt,
+	); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, store.NewStoreError(store.ErrGroupNotFound, err)
+		}
+		return nil, errors.Wrapf(err,
+			"[sqlite3:groups] query row scan failed query=%q", query)
+	}
+	return &r, nil
+}
+
+// DeleteGroup deletes a group along with its partials and send window and
+// clears the project's default group if it is the group. If the group has
+// templates an error of type store.ErrGroupNotEmpty is returned unless
+// force is set, in which case the templates are deleted too. If the group
+// is not found, an error of type store.ErrGroupNotFound is returned.
+func (s *Store) DeleteGroup(ctx context.Context, projectID, groupID string, force bool) error {
+	const countQuery = `
+select
+  (select count(*) from groups
+   where project_id = :project_id and group_id = :group_id) as num_groups,
+  (select count(*) from templates
+   where project_id = :project_id and group_id = :group_id) as num_templates
+`
+	// the rows referencing the group's templates are deleted before the
+	// templates and the rows referencing the group before the group
+	deleteQueries := []struct {
+		table string
+		query string
+	}{
+		{"template_attachments", `
+delete from template_attachments
+where
+  project_id = :project_id and template_id in (
+    select template_id from templates
+    where project_id = :project_id and group_id = :group_id)
+`},
+		{"template_variants", `
+delete from template_variants
+where
+  project_id = :project_id and template_id in (
+    select template_id from templates
+    where project_id = :project_id and group_id = :group_id)
+`},
+		{"template_fixtures", `
+delete from template_fixtures
+where
+  project_id = :project_id and template_id in (
+    select template_id from templates
+    where project_id = :project_id and group_id = :group_id)
+`},
+		{"templates", `
+delete from templates
+where
+  project_id = :project_id and group_id = :group_id
+`},
+		{"template_partials", `
+delete from template_partials
+where
+  project_id = :project_id and group_id = :group_id
+`},
+		{"send_windows", `
+delete from send_windows
+where
+  project_id = :project_id and group_id = :group_id
+`},
+		{"projects", `
+update projects
+set
+  default_group_id = ''
+where
+  project_id = :project_id and default_group_id = :group_id
+`},
+		{"groups", `
+delete from groups
+where
+  project_id = :project_id and group_id = :group_id
+`},
+	}
+	return s.execTx(ctx, func(q *Queries) error {
+		var numGroups, numTemplates int
+		if err := q.readwrite.QueryRowContext(ctx, countQuery,
+			sql.Named("project_id", projectID),
+			sql.Named("group_id", groupID),
+		).Scan(&numGroups, &numTemplates); err != nil {
+			return errors.Wrapf(err,
+				"[sqlite3:groups] query row scan failed query=%q", countQuery)
+		}
+		if numGroups == 0 {
+			return store.NewStoreError(store.ErrGroupNotFound, nil)
+		}
+		if numTemplates > 0 && !force {
+			return store.NewStoreError(store.ErrGroupNotEmpty, nil)
+		}
+
+		for _, d := range deleteQueries {
+			if _, err := q.readwrite.ExecContext(ctx, d.query,
+				sql.Named("project_id", projectID),
+				sql.Named("group_id", groupID),
+			); err != nil {
+				return errors.Wrapf(err,
+					"[sqlite3:%s] exec failed query=%q", d.table, d.query)
+			}
+		}
+		return nil
+	})
+}
+
 //
 // templates
 //
@@ -397,11 +1364,14 @@ where
 func (q *Queries) InsertTemplate(ctx context.Context, params store.AddTemplate) (*store.Template, error) {
 	const query = `
 insert into templates
-  (template_id, group_id, project_id, txt, txt_digest, html, html_digest, created_at, modified_at)
+  (template_id, group_id, project_id, txt, txt_digest, html, html_digest, subject,
+   source_type, source, created_at, modified_at)
 values
-  (:template_id, :group_id, :project_id, :txt, :txt_digest, :html, :html_digest, :created_at, :modified_at)
+  (:template_id, :group_id, :project_id, :txt, :txt_digest, :html, :html_digest, :subject,
+   :source_type, :source, :created_at, :modified_at)
 returning
-  template_id, group_id, project_id, txt, txt_digest, html, html_digest, created_at, modified_at
+  template_id, group_id, project_id, txt, txt_digest, html, html_digest, subject,
+  source_type, source, asset_mode, labels, created_at, modified_at
 `
 	var r store.Template
 	now := store.Datetime(time.Now().UTC())
@@ -413,6 +1383,9 @@ returning
 		sql.Named("txt_digest", params.TxtDigest),
 		sql.Named("html", params.HTML),
 		sql.Named("html_digest", params.HTMLDigest),
+		sql.Named("subject", params.Subject),
+		sql.Named("source_type", params.SourceType),
+		sql.Named("source", params.Source),
 		sql.Named("created_at", &now),
 		sql.Named("modified_at", &now),
 	).Scan(
@@ -423,9 +1396,24 @@ returning
 		&r.TxtDigest,
 		&r.HTML,
 		&r.HTMLDigest,
+		&r.Subject,
+		&r.SourceType,
+		&r.Source,
+		&r.AssetMode,
+		&r.Labels,
 		&r.CreatedAt,
 		&r.ModifiedAt,
 	); err != nil {
+		// the only foreign key on the templates table references the
+		// composite (group_id, project_id) key of the groups table so a
+		// foreign key constraint error means the group does not exist
+		// within the project. This also prevents a template in one
+		// project being attached to a group from another project.
+		if serr, ok := err.(sqlite3.Error); ok {
+			if serr.Code == sqlite3.ErrConstraint && serr.ExtendedCode == sqlite3.ErrConstraintForeignKey {
+				return nil, store.NewStoreError(store.ErrGroupNotFound, serr)
+			}
+		}
 		return nil, errors.Wrapf(err,
 			"[sqlite3:templates] query row scan failed query=%q", query)
 	}
@@ -434,8 +1422,9 @@ returning
 
 // SetTemplate sets a template in the store. If the template does not exist
 // it will be created. If the template does exist and the digests are the same
-// as the ones provided by the caller, then the template will not be updated.
-// If the digests are different, then the template will be updated.
+// as the ones provided by the caller, then the template will not be updated
+// and the stored template is returned. If the digests are different, then the
+// template will be updated.
 func (s *Store) SetTemplate(ctx context.Context, params store.SetTemplateParams) (*store.Template, error) {
 	const chkDigestQuery = `
 select
@@ -444,6 +1433,16 @@ select
   p.project_id,
   coalesce(txt_digest == :txt_digest, FALSE) as txt_digest_eq,
   coalesce(html_digest == :html_digest, FALSE) as html_digest_eq,
+  coalesce(subject == :subject, FALSE) as subject_eq,
+  coalesce(t.txt, '') as txt,
+  coalesce(t.txt_digest, '') as txt_digest,
+  coalesce(t.html, '') as html,
+  coalesce(t.html_digest, '') as html_digest,
+  coalesce(t.subject, '') as subject,
+  coalesce(t.source_type, '') as source_type,
+  coalesce(t.source, '') as source,
+  coalesce(t.asset_mode, '') as asset_mode,
+  coalesce(t.labels, '{}') as labels,
   coalesce(t.created_at, '1970-01-01T00:00:00.000000Z') as created_at,
   coalesce(t.modified_at, '1970-01-01T00:00:00.000000Z') as modified_at
 from projects as p
@@ -465,11 +1464,15 @@ where
 		// because the readonly query will not see the uncommitted
 		// changes made by the insert query
 		var templateID, groupID, projectID string
-		var txtDigestEq, htmlDigestEq bool
+		var txtDigestEq, htmlDigestEq, subjectEq bool
+		var stored store.Template
+		var assetMode string
+		var labels store.JSONObject
 		var createdAt, modifiedAt store.Datetime
 		if err := q.readwrite.QueryRowContext(ctx, chkDigestQuery,
 			sql.Named("txt_digest", params.TxtDigest),
 			sql.Named("html_digest", params.HTMLDigest),
+			sql.Named("subject", params.Subject),
 			sql.Named("project_id", params.ProjectID),
 			sql.Named("template_id", params.TemplateID),
 		).Scan(
@@ -478,6 +1481,16 @@ where
 			&projectID,
 			&txtDigestEq,
 			&htmlDigestEq,
+			&subjectEq,
+			&stored.Txt,
+			&stored.TxtDigest,
+			&stored.HTML,
+			&stored.HTMLDigest,
+			&stored.Subject,
+			&stored.SourceType,
+			&stored.Source,
+			&assetMode,
+			&labels,
 			&createdAt,
 			&modifiedAt,
 		); err != nil {
@@ -500,6 +1513,9 @@ where
 				TxtDigest:  params.TxtDigest,
 				HTML:       params.HTML,
 				HTMLDigest: params.HTMLDigest,
+				Subject:    params.Subject,
+				SourceType: params.SourceType,
+				Source:     params.Source,
 				CreatedAt:  store.Datetime(time.Now().UTC()),
 				ModifiedAt: store.Datetime(time.Now().UTC()),
 			})
@@ -510,24 +1526,21 @@ where
 			return nil
 		}
 
-		// 2. the template exists and the digests are the same so there is no
-		// need to update the template (or 3 below)
-		if txtDigestEq && htmlDigestEq {
-			r = &store.Template{
-				TemplateID: params.TemplateID,
-				GroupID:    groupID,
-				ProjectID:  params.ProjectID,
-				Txt:        params.Txt,
-				TxtDigest:  params.TxtDigest,
-				HTML:       params.HTML,
-				HTMLDigest: params.HTMLDigest,
-				CreatedAt:  createdAt,
-				ModifiedAt: modifiedAt,
-			}
+		// 2. the template exists and the digests and subject are the same
+		// so there is no need to update the template (or 3 below)
+		if txtDigestEq && htmlDigestEq && subjectEq {
+			stored.TemplateID = templateID
+			stored.GroupID = groupID
+			stored.ProjectID = projectID
+			stored.AssetMode = assetMode
+			stored.Labels = labels
+			stored.CreatedAt = createdAt
+			stored.ModifiedAt = modifiedAt
+			r = &stored
 			return nil
 		}
 
-		// 3. the digests differ so update the template
+		// 3. the digests or subject differ so update the template
 		var err error
 		r, err = q.updateTemplate(ctx, updateTemplateParams{
 			projectID:  params.ProjectID,
@@ -536,6 +1549,9 @@ where
 			txtDigest:  params.TxtDigest,
 			html:       params.HTML,
 			htmlDigest: params.HTMLDigest,
+			subject:    params.Subject,
+			sourceType: params.SourceType,
+			source:     params.Source,
 		})
 		if err != nil {
 			return err
@@ -556,6 +1572,9 @@ type updateTemplateParams struct {
 	txtDigest  string
 	html       string
 	htmlDigest string
+	subject    string
+	sourceType string
+	source     string
 }
 
 func (q *Queries) updateTemplate(ctx context.Context, params updateTemplateParams) (*store.Template, error) {
@@ -564,11 +1583,14 @@ update templates
 set
   txt = :txt, txt_digest = :txt_digest,
   html = :html, html_digest = :html_digest,
+  subject = :subject,
+  source_type = :source_type, source = :source,
   modified_at = :modified_at
 where
   template_id = :template_id and project_id = :project_id
 returning
-  template_id, group_id, project_id, txt, txt_digest, html, html_digest, created_at, modified_at
+  template_id, group_id, project_id, txt, txt_digest, html, html_digest, subject,
+  source_type, source, asset_mode, labels, created_at, modified_at
 `
 	var r store.Template
 	now := store.Datetime(time.Now().UTC())
@@ -577,6 +1599,9 @@ returning
 		sql.Named("txt_digest", params.txtDigest),
 		sql.Named("html", params.html),
 		sql.Named("html_digest", params.htmlDigest),
+		sql.Named("subject", params.subject),
+		sql.Named("source_type", params.sourceType),
+		sql.Named("source", params.source),
 		sql.Named("modified_at", &now),
 		sql.Named("template_id", params.templateID),
 		sql.Named("project_id", params.projectID),
@@ -588,6 +1613,11 @@ returning
 		&r.TxtDigest,
 		&r.HTML,
 		&r.HTMLDigest,
+		&r.Subject,
+		&r.SourceType,
+		&r.Source,
+		&r.AssetMode,
+		&r.Labels,
 		&r.CreatedAt,
 		&r.ModifiedAt,
 	); err != nil {
@@ -608,7 +1638,14 @@ select
   coalesce(t.group_id, '') as group_id,
   p.project_id,
   coalesce(t.txt, '') as txt,
+  coalesce(t.txt_digest, '') as txt_digest,
   coalesce(t.html, '') as html,
+  coalesce(t.html_digest, '') as html_digest,
+  coalesce(t.subject, '') as subject,
+  coalesce(t.source_type, '') as source_type,
+  coalesce(t.source, '') as source,
+  coalesce(t.asset_mode, '') as asset_mode,
+  coalesce(t.labels, '{}') as labels,
   coalesce(t.created_at, '1970-01-01T00:00:00.000000Z') as created_at,
   coalesce(t.modified_at, '1970-01-01T00:00:00.000000Z') as modified_at
 from projects as p
@@ -618,7 +1655,7 @@ where
   p.project_id = :project_id
 `
 	var r store.Template
-	if err := q.readonly.QueryRowContext(ctx, query,
+	if err := q.queryRow(ctx, query,
 		sql.Named("project_id", projectID),
 		sql.Named("template_id", templateID),
 	).Scan(
@@ -626,7 +1663,14 @@ where
 		&r.GroupID,
 		&r.ProjectID,
 		&r.Txt,
+		&r.TxtDigest,
 		&r.HTML,
+		&r.HTMLDigest,
+		&r.Subject,
+		&r.SourceType,
+		&r.Source,
+		&r.AssetMode,
+		&r.Labels,
 		&r.CreatedAt,
 		&r.ModifiedAt,
 	); err != nil {
@@ -645,3 +1689,234 @@ where
 
 	return &r, nil
 }
+
+// ListTemplates lists the templates of a project ordered by id, filtered
+// by group and by a substring of the id.
+func (q *Queries) ListTemplates(ctx context.Context, params store.ListTemplates) ([]*store.Template, error) {
+	const query = `
+select
+  template_id, group_id, project_id, txt, txt_digest, html, html_digest, subject,
+  source_type, source, asset_mode, labels, created_at, modified_at
+from templates
+where
+  project_id = :project_id and
+  (:group_id = '' or group_id = :group_id) and
+  (:query = '' or instr(template_id, :query) > 0) and
+  not exists (
+    select 1 from json_each(:labels) as l
+    where not exists (
+      select 1 from json_each(templates.labels) as tl
+      where tl.key = l.key and tl.value = l.value
+    )
+  ) and
+  template_id > :after
+order by template_id
+limit :limit
+`
+	limit := params.Limit
+	if limit <= 0 {
+		limit = -1
+	}
+	rows, err := q.readonly.QueryContext(ctx, query,
+		sql.Named("project_id", params.ProjectID),
+		sql.Named("group_id", params.GroupID),
+		sql.Named("query", params.Query),
+		sql.Named("labels", store.JSONObject(params.Labels)),
+		sql.Named("after", params.After),
+		sql.Named("limit", limit),
+	)
+	if err != nil {
+		return nil, errors.Wrapf(err,
+			"[sqlite3:templates] query failed query=%q", query)
+	}
+	defer rows.Close()
+
+	list := make([]*store.Template, 0)
+	for rows.Next() {
+		var r store.Template
+		if err := rows.Scan(
+			&r.TemplateID,
+			&r.GroupID,
+			&r.ProjectID,
+			&r.Txt,
+			&r.TxtDigest,
+			&r.HTML,
+			&r.HTMLDigest,
+			&r.Subject,
+			&r.SourceType,
+			&r.Source,
+			&r.AssetMode,
+			&r.Labels,
+			&r.CreatedAt,
+			&r.ModifiedAt,
+		); err != nil {
+			return nil, errors.Wrapf(err,
+				"[sqlite3:templates] rows scan failed query=%q", query)
+		}
+		list = append(list, &r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrapf(err,
+			"[sqlite3:templates] rows.Err failed query=%q", query)
+	}
+	return list, nil
+}
+
+// DeleteTemplate deletes a template along with its locale variants, its
+// fixtures and its references to attachments. If the template does not exist an error of
+// type store.ErrTemplateNotFound is returned.
+func (s *Store) DeleteTemplate(ctx context.Context, projectID, templateID string) error {
+	const attachmentsQuery = `
+delete from template_attachments
+where
+  template_id = :template_id and project_id = :project_id
+`
+	const variantsQuery = `
+delete from template_variants
+where
+  template_id = :template_id and project_id = :project_id
+`
+	const fixturesQuery = `
+delete from template_fixtures
+where
+  template_id = :template_id and project_id = :project_id
+`
+	const query = `
+delete from templates
+where
+  template_id = :template_id and project_id = :project_id
+`
+	return s.execTx(ctx, func(q *Queries) error {
+		if _, err := q.readwrite.ExecContext(ctx, attachmentsQuery,
+			sql.Named("template_id", templateID),
+			sql.Named("project_id", projectID),
+		); err != nil {
+			return errors.Wrapf(err,
+				"[sqlite3:template_attachments] exec failed query=%q", attachmentsQuery)
+		}
+		if _, err := q.readwrite.ExecContext(ctx, variantsQuery,
+			sql.Named("template_id", templateID),
+			sql.Named("project_id", projectID),
+		); err != nil {
+			return errors.Wrapf(err,
+				"[sqlite3:template_variants] exec failed query=%q", variantsQuery)
+		}
+		if _, err := q.readwrite.ExecContext(ctx, fixturesQuery,
+			sql.Named("template_id", templateID),
+			sql.Named("project_id", projectID),
+		); err != nil {
+			return errors.Wrapf(err,
+				"[sqlite3:template_fixtures] exec failed query=%q", fixturesQuery)
+		}
+
+		res, err := q.readwrite.ExecContext(ctx, query,
+			sql.Named("template_id", templateID),
+			sql.Named("project_id", projectID),
+		)
+		if err != nil {
+			return errors.Wrapf(err,
+				"[sqlite3:templates] exec failed query=%q", query)
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return errors.Wrapf(err, "[sqlite3:templates] res.RowsAffected failed")
+		}
+		if n == 0 {
+			return store.NewStoreError(store.ErrTemplateNotFound, nil)
+		}
+		return nil
+	})
+}
+
+// SetTemplateAssetMode sets how a template references its assets. If the
+// template is not found, an error of type store.ErrTemplateNotFound is
+// returned.
+func (q *Queries) SetTemplateAssetMode(ctx context.Context, projectID, templateID, assetMode string) (*store.Template, error) {
+	const query = `
+update templates
+set
+  asset_mode = :asset_mode,
+  modified_at = :modified_at
+where
+  template_id = :template_id and project_id = :project_id
+returning
+  template_id, group_id, project_id, txt, txt_digest, html, html_digest, subject,
+  source_type, source, asset_mode, labels, created_at, modified_at
+`
+	var r store.Template
+	now := store.Datetime(time.Now().UTC())
+	if err := q.readwrite.QueryRowContext(ctx, query,
+		sql.Named("asset_mode", assetMode),
+		sql.Named("modified_at", &now),
+		sql.Named("template_id", templateID),
+		sql.Named("project_id", projectID),
+	).Scan(
+		&r.TemplateID,
+		&r.GroupID,
+		&r.ProjectID,
+		&r.Txt,
+		&r.TxtDigest,
+		&r.HTML,
+		&r.HTMLDigest,
+		&r.Subject,
+		&r.SourceType,
+		&r.Source,
+		&r.AssetMode,
+		&r.Labels,
+		&r.CreatedAt,
+		&r.ModifiedAt,
+	); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, store.NewStoreError(store.ErrTemplateNotFound, err)
+		}
+		return nil, errors.Wrapf(err,
+			"[sqlite3:templates] query row scan failed query=%q", query)
+	}
+	return &r, nil
+}
+
+// SetTemplateLabels replaces the labels of a template. If the template is
+// not found, an error of type store.ErrTemplateNotFound is returned.
+func (q *Queries) SetTemplateLabels(ctx context.Context, projectID, templateID string, labels store.JSONObject) (*store.Template, error) {
+	const query = `
+update templates
+set
+  labels = :labels,
+  modified_at = :modified_at
+where
+  template_id = :template_id and project_id = :project_id
+returning
+  template_id, group_id, project_id, txt, txt_digest, html, html_digest, subject,
+  source_type, source, asset_mode, labels, created_at, modified_at
+`
+	var r store.Template
+	now := store.Datetime(time.Now().UTC())
+	if err := q.readwrite.QueryRowContext(ctx, query,
+		sql.Named("labels", labels),
+		sql.Named("modified_at", &now),
+		sql.Named("template_id", templateID),
+		sql.Named("project_id", projectID),
+	).Scan(
+		&r.TemplateID,
+		&r.GroupID,
+		&r.ProjectID,
+		&r.Txt,
+		&r.TxtDigest,
+		&r.HTML,
+		&r.HTMLDigest,
+		&r.Subject,
+		&r.SourceType,
+		&r.Source,
+		&r.AssetMode,
+		&r.Labels,
+		&r.CreatedAt,
+		&r.ModifiedAt,
+	); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, store.NewStoreError(store.ErrTemplateNotFound, err)
+		}
+		return nil, errors.Wrapf(err,
+			"[sqlite3:templates] query row scan failed query=%q", query)
+	}
+	return &r, nil
+}
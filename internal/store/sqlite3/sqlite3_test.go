@@ -120,6 +120,112 @@ func TestInsertSMTPTransport(t *testing.T) {
 	assert.WithinDuration(t, time.Now(), time.Time(obj.ModifiedAt), 1*time.Millisecond)
 }
 
+func TestDeleteSMTPTransportTransportChain(t *testing.T) {
+	rw, err := setupInMemoryDB()
+	if err != nil {
+		t.Fatalf("rw, ro, err := openDBs() failed: %v", err)
+	}
+	defer rw.Close()
+
+	// create a new store
+	st := sqlite3.NewStore(rw, rw)
+
+	ctx := context.Background()
+	if _, err := st.InsertProject(ctx, store.AddProject{ProjectID: "p1"}); err != nil {
+		t.Fatalf("expected err to be non-nil: %+v", err)
+	}
+	for _, id := range []string{"tr1", "tr2", "tr3"} {
+		if _, err := st.InsertSMTPTransport(ctx, store.AddSMTPTransport{
+			SMTPTransportID: id,
+			ProjectID:       "p1",
+			Host:            "localhost",
+			Port:            25,
+			EmailFrom:       "from@example.com",
+		}); err != nil {
+			t.Fatalf("expected err to be non-nil: %+v", err)
+		}
+	}
+	if _, err := st.SetProjectTransportChain(ctx, "p1", store.JSONArray{"tr1", "tr2", "tr3"}); err != nil {
+		t.Fatalf("expected err to be non-nil: %+v", err)
+	}
+
+	// the deleted transport is removed from the chain keeping the order
+	if err := st.DeleteSMTPTransport(ctx, "tr2", "p1", false); err != nil {
+		t.Fatalf("expected err to be non-nil: %+v", err)
+	}
+	project, err := st.GetProject(ctx, "p1")
+	if err != nil {
+		t.Fatalf("expected err to be non-nil: %+v", err)
+	}
+	assert.Equal(t, store.JSONArray{"tr1", "tr3"}, project.TransportChain)
+
+	if err := st.DeleteSMTPTransport(ctx, "tr1", "p1", false); err != nil {
+		t.Fatalf("expected err to be non-nil: %+v", err)
+	}
+	if err := st.DeleteSMTPTransport(ctx, "tr3", "p1", false); err != nil {
+		t.Fatalf("expected err to be non-nil: %+v", err)
+	}
+	project, err = st.GetProject(ctx, "p1")
+	if err != nil {
+		t.Fatalf("expected err to be non-nil: %+v", err)
+	}
+	assert.Equal(t, store.JSONArray{}, project.TransportChain)
+}
+
+func TestInsertAPITransport(t *testing.T) {
+	rw, err := setupInMemoryDB()
+	if err != nil {
+		t.Fatalf("rw, ro, err := openDBs() failed: %v", err)
+	}
+	defer rw.Close()
+
+	// create a new store
+	st := sqlite3.NewStore(rw, rw)
+
+	ctx := context.Background()
+	if _, err := st.InsertProject(ctx, store.AddProject{ProjectID: "p1"}); err != nil {
+		t.Fatalf("expected err to be non-nil: %+v", err)
+	}
+
+	obj, err := st.InsertAPITransport(ctx, store.AddAPITransport{
+		APITransportID:  "mg",
+		ProjectID:       "p1",
+		TransportName:   "Mailgun",
+		Provider:        store.APITransportProviderMailgun,
+		Config:          store.JSONObject{"domain": "mg.examplesite.com"},
+		EncryptedAPIKey: "encryptedapikey",
+		EmailFrom:       "from@examplesite.com",
+		EmailFromName:   "Example Site",
+	})
+	if err != nil {
+		t.Fatalf("expected err to be non-nil: %+v", err)
+	}
+	assert.Equal(t, "mg", obj.APITransportID)
+	assert.Equal(t, store.APITransportProviderMailgun, obj.Provider)
+	assert.Equal(t, store.JSONObject{"domain": "mg.examplesite.com"}, obj.Config)
+	assert.Equal(t, "encryptedapikey", obj.EncryptedAPIKey)
+	assert.Equal(t, store.JSONArray{}, obj.EmailReplyTo)
+	assert.WithinDuration(t, time.Now(), time.Time(obj.CreatedAt), 10*time.Millisecond)
+
+	got, err := st.GetAPITransport(ctx, "mg", "p1")
+	if err != nil {
+		t.Fatalf("expected err to be non-nil: %+v", err)
+	}
+	assert.Equal(t, obj, got)
+
+	_, err = st.GetAPITransport(ctx, "non-existent-transport", "p1")
+	assert.ErrorIs(t, err, store.ErrTransportNotFound)
+
+	_, err = st.InsertAPITransport(ctx, store.AddAPITransport{
+		APITransportID: "mg",
+		ProjectID:      "non-existent-project",
+	})
+	var storeErr *store.Error
+	if assert.ErrorAs(t, err, &storeErr) {
+		assert.Equal(t, store.ErrCode(store.ErrProjectNotFound), storeErr.Code)
+	}
+}
+
 func TestInsertGroupIntoNonExistingProject(t *testing.T) {
 	rw, err := setupInMemoryDB()
 	if err != nil {
@@ -364,6 +470,62 @@ func TestInsertTemplate(t *testing.T) {
 	assert.WithinDuration(t, time.Now(), time.Time(obj.ModifiedAt), 1*time.Millisecond)
 }
 
+func TestSetTemplate(t *testing.T) {
+	rw, err := setupInMemoryDB()
+	if err != nil {
+		t.Fatalf("rw, ro, err := openDBs() failed: %v", err)
+	}
+	defer rw.Close()
+
+	// create a new store
+	st := sqlite3.NewStore(rw, rw)
+
+	ctx := context.Background()
+	if _, err := st.InsertProject(ctx, store.AddProject{ProjectID: "p1"}); err != nil {
+		t.Fatalf("expected err to be non-nil: %+v", err)
+	}
+	if _, err := st.InsertGroup(ctx, store.AddGroup{GroupID: "g1", ProjectID: "p1"}); err != nil {
+		t.Fatalf("expected err to be non-nil: %+v", err)
+	}
+
+	params := store.SetTemplateParams{
+		TemplateID: "t1",
+		GroupID:    "g1",
+		ProjectID:  "p1",
+		Txt:        "text",
+		TxtDigest:  "d1",
+		HTML:       "html",
+		HTMLDigest: "d2",
+		Subject:    "Subject",
+	}
+	created, err := st.SetTemplate(ctx, params)
+	if err != nil {
+		t.Fatalf("expected err to be non-nil: %+v", err)
+	}
+	assert.Equal(t, "text", created.Txt)
+
+	// the same digests leave the template untouched and return the stored
+	// template
+	stale := params
+	stale.Txt, stale.HTML = "stale text", "stale html"
+	same, err := st.SetTemplate(ctx, stale)
+	if err != nil {
+		t.Fatalf("expected err to be non-nil: %+v", err)
+	}
+	assert.Equal(t, "t1", same.TemplateID)
+	assert.Equal(t, "text", same.Txt)
+	assert.Equal(t, "html", same.HTML)
+	assert.Equal(t, "Subject", same.Subject)
+	assert.Equal(t, created.ModifiedAt, same.ModifiedAt)
+
+	params.Txt, params.TxtDigest = "new text", "d3"
+	updated, err := st.SetTemplate(ctx, params)
+	if err != nil {
+		t.Fatalf("expected err to be non-nil: %+v", err)
+	}
+	assert.Equal(t, "new text", updated.Txt)
+}
+
 func TestGetTemplate(t *testing.T) {
 	rw, err := setupInMemoryDB()
 	if err != nil {
@@ -443,3 +605,278 @@ func TestGetTemplate(t *testing.T) {
 	}
 	assert.Nil(t, obj, "expected obj to be nil")
 }
+
+func TestInsertTemplateIntoGroupFromAnotherProject(t *testing.T) {
+	rw, err := setupInMemoryDB()
+	if err != nil {
+		t.Fatalf("rw, ro, err := openDBs() failed: %v", err)
+	}
+	defer rw.Close()
+
+	// create a new store
+	st := sqlite3.NewStore(rw, rw)
+
+	ctx := context.Background()
+	for _, id := range []string{"p1", "p2"} {
+		if _, err := st.InsertProject(ctx, store.AddProject{ProjectID: id}); err != nil {
+			t.Fatalf("expected err to be non-nil: %+v", err)
+		}
+	}
+
+	// group g1 only exists in project p1
+	if _, err := st.InsertGroup(ctx, store.AddGroup{
+		GroupID:   "g1",
+		ProjectID: "p1",
+		GroupName: "Group One",
+	}); err != nil {
+		t.Fatalf("expected err to be non-nil: %+v", err)
+	}
+
+	obj, err := st.InsertTemplate(ctx, store.AddTemplate{
+		TemplateID: "tmpl1",
+		GroupID:    "g1",
+		ProjectID:  "p2",
+		Txt:        "Test Text",
+		HTML:       "<h1>Test HTML</h1>",
+	})
+	assert.Nil(t, obj, "expected obj to be nil")
+
+	// assert that error is of type *store.Error and that the code is store.ErrGroupNotFound
+	var storeErr *store.Error
+	if errors.As(err, &storeErr) {
+		if storeErr.Code != store.ErrGroupNotFound {
+			t.Fatalf("expected storeErr.Code to be store.ErrGroupNotFound")
+		}
+	} else {
+		t.Fatalf("expected err to be of type *store.Error")
+	}
+}
+
+func TestClaimMailQueue(t *testing.T) {
+	rw, err := setupInMemoryDB()
+	if err != nil {
+		t.Fatalf("rw, ro, err := openDBs() failed: %v", err)
+	}
+	defer rw.Close()
+
+	// create a new store
+	st := sqlite3.NewStore(rw, rw)
+
+	ctx := context.Background()
+	if _, err := st.InsertProject(ctx, store.AddProject{ProjectID: "p1"}); err != nil {
+		t.Fatalf("expected err to be non-nil: %+v", err)
+	}
+
+	for _, id := range []string{"mq1", "mq2", "mq3"} {
+		if _, err := st.InsertMailQueue(ctx, store.AddMailQueue{
+			MailQueueID: id,
+			ProjectID:   "p1",
+			TemplateID:  "t1",
+			TransportID: "tr1",
+			MState:      store.MailQueueStateQueued,
+			Metadata:    store.MailQueueMetadata{To: []string{"to@example.com"}, Subject: "Subject"},
+			Body:        store.MailQueueBody{Txt: "text", HTML: "<p>html</p>"},
+		}); err != nil {
+			t.Fatalf("expected err to be non-nil: %+v", err)
+		}
+	}
+
+	// claim two of the three queued emails
+	list, err := st.ClaimMailQueue(ctx, 2, time.Hour)
+	if err != nil {
+		t.Fatalf("expected err to be non-nil: %+v", err)
+	}
+	if assert.Len(t, list, 2) {
+		assert.Equal(t, "mq1", list[0].MailQueueID)
+		assert.Equal(t, "mq2", list[1].MailQueueID)
+		assert.Equal(t, store.MailQueueStateSending, list[0].MState)
+		assert.Equal(t, []string{"to@example.com"}, list[0].Metadata.To)
+		assert.Equal(t, "text", list[0].Body.Txt)
+	}
+
+	// only one email is left to claim
+	list, err = st.ClaimMailQueue(ctx, 2, time.Hour)
+	if err != nil {
+		t.Fatalf("expected err to be non-nil: %+v", err)
+	}
+	if assert.Len(t, list, 1) {
+		assert.Equal(t, "mq3", list[0].MailQueueID)
+	}
+
+	// mark as sent and replace the body
+	obj, err := st.UpdateMailQueueState(ctx, store.UpdateMailQueueState{
+		MailQueueID: "mq3",
+		MState:      store.MailQueueStateSent,
+		Body:        &store.MailQueueBody{Redacted: true},
+	})
+	if err != nil {
+		t.Fatalf("expected err to be non-nil: %+v", err)
+	}
+	assert.Equal(t, store.MailQueueStateSent, obj.MState)
+	assert.True(t, obj.Body.Redacted)
+	assert.Empty(t, obj.Body.Txt)
+
+	// a nil body leaves the stored body untouched
+	obj, err = st.UpdateMailQueueState(ctx, store.UpdateMailQueueState{
+		MailQueueID: "mq1",
+		MState:      store.MailQueueStateFailed,
+		LastError:   "connection refused",
+	})
+	if err != nil {
+		t.Fatalf("expected err to be non-nil: %+v", err)
+	}
+	assert.Equal(t, store.MailQueueStateFailed, obj.MState)
+	assert.Equal(t, "connection refused", obj.LastError)
+	assert.Equal(t, "text", obj.Body.Txt)
+
+	// scheduled emails are not claimed until send_at has passed
+	sendAt := store.Datetime(time.Now().Add(time.Hour).UTC().Truncate(time.Microsecond))
+	obj, err = st.InsertMailQueue(ctx, store.AddMailQueue{
+		MailQueueID: "mq4",
+		ProjectID:   "p1",
+		TemplateID:  "t1",
+		TransportID: "tr1",
+		MState:      store.MailQueueStateQueued,
+		SendAt:      sendAt,
+	})
+	if err != nil {
+		t.Fatalf("expected err to be non-nil: %+v", err)
+	}
+	assert.Equal(t, time.Time(sendAt), time.Time(obj.SendAt))
+	assert.Equal(t, time.Time(sendAt), time.Time(obj.NextAttemptAt))
+
+	list, err = st.ClaimMailQueue(ctx, 2, time.Hour)
+	if err != nil {
+		t.Fatalf("expected err to be non-nil: %+v", err)
+	}
+	assert.Empty(t, list)
+
+	// mq2 is still sending so it is reclaimed once its lease expires
+	time.Sleep(time.Millisecond)
+	list, err = st.ClaimMailQueue(ctx, 2, time.Microsecond)
+	if err != nil {
+		t.Fatalf("expected err to be non-nil: %+v", err)
+	}
+	if assert.Len(t, list, 1) {
+		assert.Equal(t, "mq2", list[0].MailQueueID)
+		assert.Equal(t, store.MailQueueStateSending, list[0].MState)
+	}
+}
+
+// TestReplicaDB checks that reads are routed to the replica, writes to the
+// primary and that reads fall back to the primary if the replica fails or
+// has not yet seen the row.
+func TestReplicaDB(t *testing.T) {
+	primary, err := setupInMemoryDB()
+	if err != nil {
+		t.Fatalf("setupInMemoryDB failed: %v", err)
+	}
+	defer primary.Close()
+
+	replica, err := setupInMemoryDB()
+	if err != nil {
+		t.Fatalf("setupInMemoryDB failed: %v", err)
+	}
+
+	// the project is written to the primary only
+	st := sqlite3.NewStore(sqlite3.NewReplicaDB(primary, replica), primary)
+	ctx := context.Background()
+	if _, err := st.InsertProject(ctx, store.AddProject{
+		ProjectID:   "p1",
+		ProjectName: "Project One",
+	}); err != nil {
+		t.Fatalf("st.InsertProject failed: %+v", err)
+	}
+
+	// the replica has not seen the write so the read falls back to the primary
+	obj, err := st.GetProject(ctx, "p1")
+	if err != nil {
+		t.Fatalf("st.GetProject failed: %+v", err)
+	}
+	assert.Equal(t, "p1", obj.ProjectID)
+
+	// an email can be read back as soon as it has been queued
+	if _, err := st.InsertMailQueue(ctx, store.AddMailQueue{
+		MailQueueID: "mq1",
+		ProjectID:   "p1",
+		TemplateID:  "t1",
+		TransportID: "tr1",
+		MState:      store.MailQueueStateQueued,
+	}); err != nil {
+		t.Fatalf("st.InsertMailQueue failed: %+v", err)
+	}
+	mq, err := st.GetMailQueue(ctx, "p1", "mq1")
+	if err != nil {
+		t.Fatalf("st.GetMailQueue failed: %+v", err)
+	}
+	assert.Equal(t, "mq1", mq.MailQueueID)
+
+	// a row on neither database is not found
+	_, err = st.GetProject(ctx, "p2")
+	var storeErr *store.Error
+	if errors.As(err, &storeErr) {
+		if storeErr.Code != store.ErrProjectNotFound {
+			t.Fatalf("expected storeErr.Code to be store.ErrProjectNotFound")
+		}
+	} else {
+		t.Fatalf("expected err to be of type *store.Error")
+	}
+
+	// once the replica fails reads fall back to the primary
+	replica.Close()
+	obj, err = st.GetProject(ctx, "p1")
+	if err != nil {
+		t.Fatalf("st.GetProject failed: %+v", err)
+	}
+	assert.Equal(t, "p1", obj.ProjectID)
+
+	list, err := st.ListSendWindows(ctx, "p1")
+	if err != nil {
+		t.Fatalf("st.ListSendWindows failed: %+v", err)
+	}
+	assert.Empty(t, list)
+}
+
+// TestOpenDBWithPragmas checks that the pragmas are applied to every
+// connection and that invalid pragmas are rejected.
+func TestOpenDBWithPragmas(t *testing.T) {
+	dbPath := t.TempDir() + "/pragmas.db"
+	db, err := sqlite3.OpenDBWithPragmas(dbPath, sqlite3.Pragmas{
+		JournalMode: "delete",
+		Synchronous: "full",
+		BusyTimeout: 2500 * time.Millisecond,
+		ForeignKeys: false,
+	})
+	if err != nil {
+		t.Fatalf("sqlite3.OpenDBWithPragmas failed: %+v", err)
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+	for _, tc := range []struct {
+		pragma string
+		want   string
+	}{
+		{"journal_mode", "delete"},
+		{"synchronous", "2"},
+		{"busy_timeout", "2500"},
+		{"foreign_keys", "0"},
+	} {
+		var got string
+		if err := db.QueryRowContext(ctx, "pragma "+tc.pragma).Scan(&got); err != nil {
+			t.Fatalf("pragma %s failed: %+v", tc.pragma, err)
+		}
+		assert.Equal(t, tc.want, got, tc.pragma)
+	}
+
+	_, err = sqlite3.OpenDBWithPragmas(dbPath, sqlite3.Pragmas{
+		JournalMode: "wal; drop table projects",
+		Synchronous: "normal",
+	})
+	assert.Error(t, err)
+	_, err = sqlite3.OpenDBWithPragmas(dbPath, sqlite3.Pragmas{
+		JournalMode: "wal",
+		Synchronous: "sometimes",
+	})
+	assert.Error(t, err)
+}
@@ -0,0 +1,183 @@
+package sqlite3
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/andyfusniak/squishy-mailer-lite/internal/store"
+	"github.com/mattn/go-sqlite3"
+	"github.com/pkg/errors"
+)
+
+const suppressionColumns = `
+  project_id, email, reason, detail, created_at, modified_at
+`
+
+func scanSuppression(row rowScanner) (*store.Suppression, error) {
+	var r store.Suppression
+	if err := row.Scan(
+		&r.ProjectID,
+		&r.Email,
+		&r.Reason,
+		&r.Detail,
+		&r.CreatedAt,
+		&r.ModifiedAt,
+	); err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// SetSuppression adds an address to the suppression list of a project or
+// replaces its reason and detail. The address is stored in lower case. If
+// the project does not exist an error of type store.ErrProjectNotFound is
+// returned.
+func (q *Queries) SetSuppression(ctx context.Context, params store.SetSuppression) (*store.Suppression, error) {
+	const query = `
+insert into suppressions
+  (project_id, email, reason, detail, created_at, modified_at)
+values
+  (:project_id, :email, :reason, :detail, :created_at, :modified_at)
+on conflict (project_id, email) do update set
+  reason = excluded.reason,
+  detail = excluded.detail,
+  modified_at = excluded.modified_at
+returning` + suppressionColumns
+
+	now := store.Datetime(time.Now().UTC())
+	r, err := scanSuppression(q.readwrite.QueryRowContext(ctx, query,
+		sql.Named("project_id", params.ProjectID),
+		sql.Named("email", strings.ToLower(params.Email)),
+		sql.Named("reason", params.Reason),
+		sql.Named("detail", params.Detail),
+		sql.Named("created_at", &now),
+		sql.Named("modified_at", &now),
+	))
+	if err != nil {
+		if serr, ok := err.(sqlite3.Error); ok {
+			if serr.Code == sqlite3.ErrConstraint && serr.ExtendedCode == sqlite3.ErrConstraintForeignKey {
+				return nil, store.NewStoreError(store.ErrProjectNotFound, serr)
+			}
+		}
+		return nil, errors.Wrapf(err,
+			"[sqlite3:suppressions] query row scan failed query=%q", query)
+	}
+	return r, nil
+}
+
+// FindSuppressions returns the suppressions of a project for any of the
+// given addresses, ordered by address. Addresses are matched without
+// regard to case.
+func (q *Queries) FindSuppressions(ctx context.Context, projectID string, emails []string) ([]*store.Suppression, error) {
+	list := make([]*store.Suppression, 0)
+	if len(emails) == 0 {
+		return list, nil
+	}
+
+	// the addresses are passed as a JSON array to avoid building a
+	// variable length in list
+	addrs := make(store.JSONArray, 0, len(emails))
+	for _, e := range emails {
+		addrs = append(addrs, strings.ToLower(e))
+	}
+	const query = `
+select` + suppressionColumns + `
+from suppressions
+where
+  project_id = :project_id and email in (select value from json_each(:emails))
+order by email
+`
+	rows, err := q.readonly.QueryContext(ctx, query,
+		sql.Named("project_id", projectID),
+		sql.Named("emails", addrs),
+	)
+	if err != nil {
+		return nil, errors.Wrapf(err,
+			"[sqlite3:suppressions] query failed query=%q", query)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		r, err := scanSuppression(rows)
+		if err != nil {
+			return nil, errors.Wrapf(err,
+				"[sqlite3:suppressions] rows scan failed query=%q", query)
+		}
+		list = append(list, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrapf(err,
+			"[sqlite3:suppressions] rows.Err failed query=%q", query)
+	}
+	return list, nil
+}
+
+// ListSuppressions lists the suppressions of a project ordered by address.
+func (q *Queries) ListSuppressions(ctx context.Context, params store.ListSuppressions) ([]*store.Suppression, error) {
+	const query = `
+select` + suppressionColumns + `
+from suppressions
+where
+  project_id = :project_id and email > :after
+order by email
+limit :limit
+`
+	limit := params.Limit
+	if limit <= 0 {
+		limit = -1
+	}
+	rows, err := q.readonly.QueryContext(ctx, query,
+		sql.Named("project_id", params.ProjectID),
+		sql.Named("after", params.After),
+		sql.Named("limit", limit),
+	)
+	if err != nil {
+		return nil, errors.Wrapf(err,
+			"[sqlite3:suppressions] query failed query=%q", query)
+	}
+	defer rows.Close()
+
+	list := make([]*store.Suppression, 0)
+	for rows.Next() {
+		r, err := scanSuppression(rows)
+		if err != nil {
+			return nil, errors.Wrapf(err,
+				"[sqlite3:suppressions] rows scan failed query=%q", query)
+		}
+		list = append(list, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrapf(err,
+			"[sqlite3:suppressions] rows.Err failed query=%q", query)
+	}
+	return list, nil
+}
+
+// DeleteSuppression removes an address from the suppression list of a
+// project. If the address is not on the list an error of type
+// store.ErrSuppressionNotFound is returned.
+func (q *Queries) DeleteSuppression(ctx context.Context, projectID, email string) error {
+	const query = `
+delete from suppressions
+where
+  project_id = :project_id and email = :email
+`
+	res, err := q.readwrite.ExecContext(ctx, query,
+		sql.Named("project_id", projectID),
+		sql.Named("email", strings.ToLower(email)),
+	)
+	if err != nil {
+		return errors.Wrapf(err,
+			"[sqlite3:suppressions] exec failed query=%q", query)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return errors.Wrapf(err, "[sqlite3:suppressions] res.RowsAffected failed")
+	}
+	if n == 0 {
+		return store.NewStoreError(store.ErrSuppressionNotFound, nil)
+	}
+	return nil
+}
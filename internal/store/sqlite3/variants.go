@@ -0,0 +1,145 @@
+package sqlite3
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/andyfusniak/squishy-mailer-lite/internal/store"
+	"github.com/mattn/go-sqlite3"
+	"github.com/pkg/errors"
+)
+
+// SetTemplateVariant creates or replaces the variant of a template for a
+// locale. If the template does not exist an error of type
+// store.ErrTemplateNotFound is returned.
+func (q *Queries) SetTemplateVariant(ctx context.Context, params store.SetTemplateVariant) (*store.TemplateVariant, error) {
+	const query = `
+insert into template_variants
+  (template_id, project_id, locale, txt, txt_digest, html, html_digest, subject,
+   created_at, modified_at)
+values
+  (:template_id, :project_id, :locale, :txt, :txt_digest, :html, :html_digest, :subject,
+   :created_at, :modified_at)
+on conflict (template_id, project_id, locale) do update set
+  txt = excluded.txt,
+  txt_digest = excluded.txt_digest,
+  html = excluded.html,
+  html_digest = excluded.html_digest,
+  subject = excluded.subject,
+  modified_at = excluded.modified_at
+returning
+  template_id, project_id, locale, txt, txt_digest, html, html_digest, subject,
+  created_at, modified_at
+`
+	var r store.TemplateVariant
+	now := store.Datetime(time.Now().UTC())
+	if err := q.readwrite.QueryRowContext(ctx, query,
+		sql.Named("template_id", params.TemplateID),
+		sql.Named("project_id", params.ProjectID),
+		sql.Named("locale", params.Locale),
+		sql.Named("txt", params.Txt),
+		sql.Named("txt_digest", params.TxtDigest),
+		sql.Named("html", params.HTML),
+		sql.Named("html_digest", params.HTMLDigest),
+		sql.Named("subject", params.Subject),
+		sql.Named("created_at", &now),
+		sql.Named("modified_at", &now),
+	).Scan(
+		&r.TemplateID,
+		&r.ProjectID,
+		&r.Locale,
+		&r.Txt,
+		&r.TxtDigest,
+		&r.HTML,
+		&r.HTMLDigest,
+		&r.Subject,
+		&r.CreatedAt,
+		&r.ModifiedAt,
+	); err != nil {
+		if serr, ok := err.(sqlite3.Error); ok {
+			if serr.Code == sqlite3.ErrConstraint && serr.ExtendedCode == sqlite3.ErrConstraintForeignKey {
+				return nil, store.NewStoreError(store.ErrTemplateNotFound, serr)
+			}
+		}
+		return nil, errors.Wrapf(err,
+			"[sqlite3:template_variants] query row scan failed query=%q", query)
+	}
+	return &r, nil
+}
+
+// ListTemplateVariants lists the variants of a template ordered by locale.
+func (q *Queries) ListTemplateVariants(ctx context.Context, projectID, templateID string) ([]*store.TemplateVariant, error) {
+	const query = `
+select
+  template_id, project_id, locale, txt, txt_digest, html, html_digest, subject,
+  created_at, modified_at
+from template_variants
+where
+  project_id = :project_id and template_id = :template_id
+order by locale
+`
+	rows, err := q.readonly.QueryContext(ctx, query,
+		sql.Named("project_id", projectID),
+		sql.Named("template_id", templateID),
+	)
+	if err != nil {
+		return nil, errors.Wrapf(err,
+			"[sqlite3:template_variants] query failed query=%q", query)
+	}
+	defer rows.Close()
+
+	list := make([]*store.TemplateVariant, 0)
+	for rows.Next() {
+		var r store.TemplateVariant
+		if err := rows.Scan(
+			&r.TemplateID,
+			&r.ProjectID,
+			&r.Locale,
+			&r.Txt,
+			&r.TxtDigest,
+			&r.HTML,
+			&r.HTMLDigest,
+			&r.Subject,
+			&r.CreatedAt,
+			&r.ModifiedAt,
+		); err != nil {
+			return nil, errors.Wrapf(err,
+				"[sqlite3:template_variants] rows scan failed query=%q", query)
+		}
+		list = append(list, &r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrapf(err,
+			"[sqlite3:template_variants] rows.Err failed query=%q", query)
+	}
+	return list, nil
+}
+
+// DeleteTemplateVariant deletes the variant of a template for a locale. If
+// the variant does not exist an error of type store.ErrVariantNotFound is
+// returned.
+func (q *Queries) DeleteTemplateVariant(ctx context.Context, projectID, templateID, locale string) error {
+	const query = `
+delete from template_variants
+where
+  project_id = :project_id and template_id = :template_id and locale = :locale
+`
+	res, err := q.readwrite.ExecContext(ctx, query,
+		sql.Named("project_id", projectID),
+		sql.Named("template_id", templateID),
+		sql.Named("locale", locale),
+	)
+	if err != nil {
+		return errors.Wrapf(err,
+			"[sqlite3:template_variants] exec failed query=%q", query)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return errors.Wrapf(err, "[sqlite3:template_variants] res.RowsAffected failed")
+	}
+	if n == 0 {
+		return store.NewStoreError(store.ErrVariantNotFound, nil)
+	}
+	return nil
+}
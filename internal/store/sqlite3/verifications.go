@@ -0,0 +1,87 @@
+package sqlite3
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/andyfusniak/squishy-mailer-lite/internal/store"
+	"github.com/mattn/go-sqlite3"
+	"github.com/pkg/errors"
+)
+
+// SetTransportVerification records the outcome of the latest verification
+// of a transport, replacing any earlier one. If the project does not
+// exist an error of type store.ErrProjectNotFound is returned.
+func (q *Queries) SetTransportVerification(ctx context.Context, params store.SetTransportVerification) (*store.TransportVerification, error) {
+	const query = `
+insert into transport_verifications
+  (project_id, transport_id, error, verified_at)
+values
+  (:project_id, :transport_id, :error, :verified_at)
+on conflict (project_id, transport_id) do update set
+  error = excluded.error,
+  verified_at = excluded.verified_at
+returning
+  project_id, transport_id, error, verified_at
+`
+	var r store.TransportVerification
+	now := store.Datetime(time.Now().UTC())
+	if err := q.readwrite.QueryRowContext(ctx, query,
+		sql.Named("project_id", params.ProjectID),
+		sql.Named("transport_id", params.TransportID),
+		sql.Named("error", params.Error),
+		sql.Named("verified_at", &now),
+	).Scan(
+		&r.ProjectID,
+		&r.TransportID,
+		&r.Error,
+		&r.VerifiedAt,
+	); err != nil {
+		if serr, ok := err.(sqlite3.Error); ok {
+			if serr.Code == sqlite3.ErrConstraint && serr.ExtendedCode == sqlite3.ErrConstraintForeignKey {
+				return nil, store.NewStoreError(store.ErrProjectNotFound, serr)
+			}
+		}
+		return nil, errors.Wrapf(err,
+			"[sqlite3:transport_verifications] query row scan failed query=%q", query)
+	}
+	return &r, nil
+}
+
+// ListTransportVerifications lists the latest verification of every
+// transport ordered by project id then transport id.
+func (q *Queries) ListTransportVerifications(ctx context.Context) ([]*store.TransportVerification, error) {
+	const query = `
+select
+  project_id, transport_id, error, verified_at
+from transport_verifications
+order by project_id, transport_id
+`
+	rows, err := q.readonly.QueryContext(ctx, query)
+	if err != nil {
+		return nil, errors.Wrapf(err,
+			"[sqlite3:transport_verifications] query failed query=%q", query)
+	}
+	defer rows.Close()
+
+	list := make([]*store.TransportVerification, 0)
+	for rows.Next() {
+		var r store.TransportVerification
+		if err := rows.Scan(
+			&r.ProjectID,
+			&r.TransportID,
+			&r.Error,
+			&r.VerifiedAt,
+		); err != nil {
+			return nil, errors.Wrapf(err,
+				"[sqlite3:transport_verifications] rows scan failed query=%q", query)
+		}
+		list = append(list, &r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrapf(err,
+			"[sqlite3:transport_verifications] rows.Err failed query=%q", query)
+	}
+	return list, nil
+}
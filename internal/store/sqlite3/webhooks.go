@@ -0,0 +1,362 @@
+package sqlite3
+
+import (
+	"context"
+	"database/sql"
+	"sort"
+	"time"
+
+	"github.com/andyfusniak/squishy-mailer-lite/internal/store"
+	"github.com/mattn/go-sqlite3"
+	"github.com/pkg/errors"
+)
+
+const webhookColumns = `
+  webhook_id, project_id, url, encrypted_secret, events, created_at, modified_at
+`
+
+func scanWebhook(row rowScanner) (*store.Webhook, error) {
+	var r store.Webhook
+	if err := row.Scan(
+		&r.WebhookID,
+		&r.ProjectID,
+		&r.URL,
+		&r.EncryptedSecret,
+		&r.Events,
+		&r.CreatedAt,
+		&r.ModifiedAt,
+	); err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// InsertWebhook inserts a new webhook endpoint. If the project does not
+// exist an error of type store.ErrProjectNotFound is returned, and if the
+// webhook id is already used in the project an error of type
+// store.ErrWebhookAlreadyExists is returned.
+func (q *Queries) InsertWebhook(ctx context.Context, params store.AddWebhook) (*store.Webhook, error) {
+	const query = `
+insert into webhooks
+  (webhook_id, project_id, url, encrypted_secret, events, created_at, modified_at)
+values
+  (:webhook_id, :project_id, :url, :encrypted_secret, :events, :created_at, :modified_at)
+returning` + webhookColumns
+
+	events := params.Events
+	if events == nil {
+		events = store.JSONArray{}
+	}
+	now := store.Datetime(time.Now().UTC())
+	r, err := scanWebhook(q.readwrite.QueryRowContext(ctx, query,
+		sql.Named("webhook_id", params.WebhookID),
+		sql.Named("project_id", params.ProjectID),
+		sql.Named("url", params.URL),
+		sql.Named("encrypted_secret", params.EncryptedSecret),
+		sql.Named("events", events),
+		sql.Named("created_at", &now),
+		sql.Named("modified_at", &now),
+	))
+	if err != nil {
+		if serr, ok := err.(sqlite3.Error); ok && serr.Code == sqlite3.ErrConstraint {
+			switch serr.ExtendedCode {
+			case sqlite3.ErrConstraintForeignKey:
+				return nil, store.NewStoreError(store.ErrProjectNotFound, serr)
+			case sqlite3.ErrConstraintPrimaryKey:
+				return nil, store.NewStoreError(store.ErrWebhookAlreadyExists, serr)
+			}
+		}
+		return nil, errors.Wrapf(err,
+			"[sqlite3:webhooks] query row scan failed query=%q", query)
+	}
+	return r, nil
+}
+
+// GetWebhook gets a webhook endpoint. If the webhook does not exist an
+// error of type store.ErrWebhookNotFound is returned.
+func (q *Queries) GetWebhook(ctx context.Context, projectID, webhookID string) (*store.Webhook, error) {
+	const query = `
+select` + webhookColumns + `
+from webhooks
+where
+  project_id = :project_id and webhook_id = :webhook_id
+`
+	r, err := scanWebhook(q.queryRow(ctx, query,
+		sql.Named("project_id", projectID),
+		sql.Named("webhook_id", webhookID),
+	))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, store.NewStoreError(store.ErrWebhookNotFound, err)
+		}
+		return nil, errors.Wrapf(err,
+			"[sqlite3:webhooks] query row scan failed query=%q", query)
+	}
+	return r, nil
+}
+
+// ListWebhooks lists the webhook endpoints of a project ordered by id.
+func (q *Queries) ListWebhooks(ctx context.Context, projectID string) ([]*store.Webhook, error) {
+	const query = `
+select` + webhookColumns + `
+from webhooks
+where
+  project_id = :project_id
+order by webhook_id
+`
+	rows, err := q.readonly.QueryContext(ctx, query,
+		sql.Named("project_id", projectID),
+	)
+	if err != nil {
+		return nil, errors.Wrapf(err,
+			"[sqlite3:webhooks] query failed query=%q", query)
+	}
+	defer rows.Close()
+
+	list := make([]*store.Webhook, 0)
+	for rows.Next() {
+		r, err := scanWebhook(rows)
+		if err != nil {
+			return nil, errors.Wrapf(err,
+				"[sqlite3:webhooks] rows scan failed query=%q", query)
+		}
+		list = append(list, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrapf(err,
+			"[sqlite3:webhooks] rows.Err failed query=%q", query)
+	}
+	return list, nil
+}
+
+// DeleteWebhook deletes a webhook endpoint and its deliveries in a single
+// transaction. If the webhook does not exist an error of type
+// store.ErrWebhookNotFound is returned.
+func (s *Store) DeleteWebhook(ctx context.Context, projectID, webhookID string) error {
+	return s.execTx(ctx, func(q *Queries) error {
+		const deliveriesQuery = `
+delete from webhook_deliveries
+where
+  project_id = :project_id and webhook_id = :webhook_id
+`
+		if _, err := q.readwrite.ExecContext(ctx, deliveriesQuery,
+			sql.Named("project_id", projectID),
+			sql.Named("webhook_id", webhookID),
+		); err != nil {
+			return errors.Wrapf(err,
+				"[sqlite3:webhook_deliveries] exec failed query=%q", deliveriesQuery)
+		}
+
+		const query = `
+delete from webhooks
+where
+  project_id = :project_id and webhook_id = :webhook_id
+`
+		res, err := q.readwrite.ExecContext(ctx, query,
+			sql.Named("project_id", projectID),
+			sql.Named("webhook_id", webhookID),
+		)
+		if err != nil {
+			return errors.Wrapf(err,
+				"[sqlite3:webhooks] exec failed query=%q", query)
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return errors.Wrapf(err, "[sqlite3:webhooks] res.RowsAffected failed")
+		}
+		if n == 0 {
+			return store.NewStoreError(store.ErrWebhookNotFound, nil)
+		}
+		return nil
+	})
+}
+
+const webhookDeliveryColumns = `
+  delivery_id, webhook_id, project_id, event, payload, dstate, attempts,
+  response_code, last_error, next_attempt_at, created_at, modified_at
+`
+
+func scanWebhookDelivery(row rowScanner) (*store.WebhookDelivery, error) {
+	var r store.WebhookDelivery
+	if err := row.Scan(
+		&r.DeliveryID,
+		&r.WebhookID,
+		&r.ProjectID,
+		&r.Event,
+		&r.Payload,
+		&r.DState,
+		&r.Attempts,
+		&r.ResponseCode,
+		&r.LastError,
+		&r.NextAttemptAt,
+		&r.CreatedAt,
+		&r.ModifiedAt,
+	); err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// InsertWebhookDelivery adds a pending delivery that is due immediately.
+// If the webhook does not exist an error of type store.ErrWebhookNotFound
+// is returned.
+func (q *Queries) InsertWebhookDelivery(ctx context.Context, params store.AddWebhookDelivery) (*store.WebhookDelivery, error) {
+	const query = `
+insert into webhook_deliveries
+  (delivery_id, webhook_id, project_id, event, payload, dstate,
+   next_attempt_at, created_at, modified_at)
+values
+  (:delivery_id, :webhook_id, :project_id, :event, :payload, :dstate,
+   :next_attempt_at, :created_at, :modified_at)
+returning` + webhookDeliveryColumns
+
+	now := store.Datetime(time.Now().UTC())
+	r, err := scanWebhookDelivery(q.readwrite.QueryRowContext(ctx, query,
+		sql.Named("delivery_id", params.DeliveryID),
+		sql.Named("webhook_id", params.WebhookID),
+		sql.Named("project_id", params.ProjectID),
+		sql.Named("event", params.Event),
+		sql.Named("payload", params.Payload),
+		sql.Named("dstate", store.WebhookDeliveryStatePending),
+		sql.Named("next_attempt_at", &now),
+		sql.Named("created_at", &now),
+		sql.Named("modified_at", &now),
+	))
+	if err != nil {
+		if serr, ok := err.(sqlite3.Error); ok {
+			if serr.Code == sqlite3.ErrConstraint && serr.ExtendedCode == sqlite3.ErrConstraintForeignKey {
+				return nil, store.NewStoreError(store.ErrWebhookNotFound, serr)
+			}
+		}
+		return nil, errors.Wrapf(err,
+			"[sqlite3:webhook_deliveries] query row scan failed query=%q", query)
+	}
+	return r, nil
+}
+
+// ClaimWebhookDeliveries moves up to limit pending deliveries whose next
+// attempt is due to the sending state in a single statement and returns
+// them, oldest first.
+func (q *Queries) ClaimWebhookDeliveries(ctx context.Context, limit int) ([]*store.WebhookDelivery, error) {
+	const query = `
+update webhook_deliveries
+set
+  dstate = :sending, modified_at = :modified_at
+where delivery_id in (
+  select delivery_id
+  from webhook_deliveries
+  where dstate = :pending and next_attempt_at <= :now
+  order by created_at, rowid
+  limit :limit
+)
+returning` + webhookDeliveryColumns
+
+	now := store.Datetime(time.Now().UTC())
+	rows, err := q.readwrite.QueryContext(ctx, query,
+		sql.Named("sending", store.WebhookDeliveryStateSending),
+		sql.Named("modified_at", &now),
+		sql.Named("pending", store.WebhookDeliveryStatePending),
+		sql.Named("now", &now),
+		sql.Named("limit", limit),
+	)
+	if err != nil {
+		return nil, errors.Wrapf(err,
+			"[sqlite3:webhook_deliveries] query failed query=%q", query)
+	}
+	defer rows.Close()
+
+	list := make([]*store.WebhookDelivery, 0, limit)
+	for rows.Next() {
+		r, err := scanWebhookDelivery(rows)
+		if err != nil {
+			return nil, errors.Wrapf(err,
+				"[sqlite3:webhook_deliveries] rows scan failed query=%q", query)
+		}
+		list = append(list, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrapf(err,
+			"[sqlite3:webhook_deliveries] rows.Err failed query=%q", query)
+	}
+
+	// the returning clause does not guarantee any order
+	sort.SliceStable(list, func(i, j int) bool {
+		return time.Time(list[i].CreatedAt).Before(time.Time(list[j].CreatedAt))
+	})
+	return list, nil
+}
+
+// UpdateWebhookDelivery records the outcome of a delivery attempt.
+func (q *Queries) UpdateWebhookDelivery(ctx context.Context, params store.UpdateWebhookDelivery) (*store.WebhookDelivery, error) {
+	const query = `
+update webhook_deliveries
+set
+  dstate = :dstate,
+  attempts = :attempts,
+  response_code = :response_code,
+  last_error = :last_error,
+  next_attempt_at = coalesce(:next_attempt_at, next_attempt_at),
+  modified_at = :modified_at
+where
+  delivery_id = :delivery_id
+returning` + webhookDeliveryColumns
+
+	var nextAttemptAt any
+	if params.NextAttemptAt != nil {
+		nextAttemptAt = params.NextAttemptAt
+	}
+	now := store.Datetime(time.Now().UTC())
+	r, err := scanWebhookDelivery(q.readwrite.QueryRowContext(ctx, query,
+		sql.Named("dstate", params.DState),
+		sql.Named("attempts", params.Attempts),
+		sql.Named("response_code", params.ResponseCode),
+		sql.Named("last_error", params.LastError),
+		sql.Named("next_attempt_at", nextAttemptAt),
+		sql.Named("modified_at", &now),
+		sql.Named("delivery_id", params.DeliveryID),
+	))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, store.NewStoreError(store.ErrWebhookNotFound, err)
+		}
+		return nil, errors.Wrapf(err,
+			"[sqlite3:webhook_deliveries] query row scan failed query=%q", query)
+	}
+	return r, nil
+}
+
+// ListWebhookDeliveries lists the deliveries of a webhook endpoint, newest
+// first.
+func (q *Queries) ListWebhookDeliveries(ctx context.Context, projectID, webhookID string) ([]*store.WebhookDelivery, error) {
+	const query = `
+select` + webhookDeliveryColumns + `
+from webhook_deliveries
+where
+  project_id = :project_id and webhook_id = :webhook_id
+order by created_at desc, rowid desc
+`
+	rows, err := q.readonly.QueryContext(ctx, query,
+		sql.Named("project_id", projectID),
+		sql.Named("webhook_id", webhookID),
+	)
+	if err != nil {
+		return nil, errors.Wrapf(err,
+			"[sqlite3:webhook_deliveries] query failed query=%q", query)
+	}
+	defer rows.Close()
+
+	list := make([]*store.WebhookDelivery, 0)
+	for rows.Next() {
+		r, err := scanWebhookDelivery(rows)
+		if err != nil {
+			return nil, errors.Wrapf(err,
+				"[sqlite3:webhook_deliveries] rows scan failed query=%q", query)
+		}
+		list = append(list, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrapf(err,
+			"[sqlite3:webhook_deliveries] rows.Err failed query=%q", query)
+	}
+	return list, nil
+}
@@ -12,8 +12,31 @@ import (
 type Repository interface {
 	ProjectsRepository
 	SMTPTransportsRepository
+	APITransportsRepository
 	GroupsRepository
 	TemplatesRepository
+	PartialsRepository
+	TemplateVariantsRepository
+	TemplateFixturesRepository
+	MailQueueRepository
+	SendWindowsRepository
+	RateLimitsRepository
+	ProjectQuotasRepository
+	WebhooksRepository
+	CampaignsRepository
+	ContactListsRepository
+	SuppressionsRepository
+	SenderAllowListsRepository
+	APIKeysRepository
+	SettingsRepository
+	MessageCatalogsRepository
+	AttachmentsRepository
+	AssetsRepository
+	SnapshotRepository
+	MaintenanceRepository
+	SchemaRepository
+	TransportVerificationsRepository
+	Ping(ctx context.Context) error
 	Close() error
 }
 
@@ -23,20 +46,77 @@ type Repository interface {
 
 // create a list of error codes
 const (
-	ErrProjectAlreadyExists = "project_already_exists"
-	ErrProjectNotFound      = "project_not_found"
-	ErrGroupNotFound        = "group_not_found"
-	ErrTemplateNotFound     = "template_not_found"
+	ErrProjectAlreadyExists    = "project_already_exists"
+	ErrProjectNotFound         = "project_not_found"
+	ErrGroupNotFound           = "group_not_found"
+	ErrTemplateNotFound        = "template_not_found"
+	ErrMailQueueNotFound       = "mail_queue_not_found"
+	ErrSendWindowNotFound      = "send_window_not_found"
+	ErrRateLimitNotFound       = "rate_limit_not_found"
+	ErrProjectQuotaNotFound    = "project_quota_not_found"
+	ErrWebhookNotFound         = "webhook_not_found"
+	ErrWebhookAlreadyExists    = "webhook_already_exists"
+	ErrSuppressionNotFound     = "suppression_not_found"
+	ErrSenderAllowListNotFound = "sender_allow_list_not_found"
+	ErrAPIKeyNotFound          = "api_key_not_found"
+	ErrSettingNotFound         = "setting_not_found"
+	ErrCatalogNotFound         = "catalog_not_found"
+	ErrAttachmentNotFound      = "attachment_not_found"
+	ErrAssetNotFound           = "asset_not_found"
+	ErrStoreNotEmpty           = "store_not_empty"
+	ErrTransportInUse          = "transport_in_use"
+	ErrPartialNotFound         = "partial_not_found"
+	ErrVariantNotFound         = "template_variant_not_found"
+	ErrFixtureNotFound         = "template_fixture_not_found"
+	ErrGroupNotEmpty           = "group_not_empty"
+	ErrMailQueueState          = "mail_queue_invalid_state"
+	ErrCampaignNotFound        = "campaign_not_found"
+	ErrCampaignAlreadyExists   = "campaign_already_exists"
+	ErrContactListNotFound     = "contact_list_not_found"
+	ErrContactListExists       = "contact_list_already_exists"
+	ErrContactNotFound         = "contact_not_found"
+	ErrBackupNotSupported      = "backup_not_supported"
+	ErrMigrationsNotSupported  = "migrations_not_supported"
+	ErrSchemaDirty             = "schema_dirty"
+	ErrSchemaVersionNotFound   = "schema_version_not_found"
 )
 
 // ErrCode is a custom type for error codes.
 type ErrCode string
 
 var mapErrCodeToMessage = map[ErrCode]string{
-	ErrProjectAlreadyExists: "project already exists",
-	ErrProjectNotFound:      "project not found",
-	ErrGroupNotFound:        "group not found",
-	ErrTemplateNotFound:     "template not found",
+	ErrProjectAlreadyExists:    "project already exists",
+	ErrProjectNotFound:         "project not found",
+	ErrGroupNotFound:           "group not found",
+	ErrTemplateNotFound:        "template not found",
+	ErrMailQueueNotFound:       "mail queue entry not found",
+	ErrSendWindowNotFound:      "send window not found",
+	ErrRateLimitNotFound:       "rate limit not found",
+	ErrProjectQuotaNotFound:    "project quota not found",
+	ErrWebhookNotFound:         "webhook not found",
+	ErrWebhookAlreadyExists:    "webhook already exists",
+	ErrSuppressionNotFound:     "suppression not found",
+	ErrSenderAllowListNotFound: "sender allow-list not found",
+	ErrAPIKeyNotFound:          "api key not found",
+	ErrSettingNotFound:         "setting not found",
+	ErrCatalogNotFound:         "message catalog not found",
+	ErrAttachmentNotFound:      "attachment not found",
+	ErrAssetNotFound:           "asset not found",
+	ErrStoreNotEmpty:           "store is not empty",
+	ErrPartialNotFound:         "partial not found",
+	ErrVariantNotFound:         "template variant not found",
+	ErrFixtureNotFound:         "template fixture not found",
+	ErrGroupNotEmpty:           "group has templates",
+	ErrMailQueueState:          "mail queue entry is not in a valid state for the change",
+	ErrCampaignNotFound:        "campaign not found",
+	ErrCampaignAlreadyExists:   "campaign already exists",
+	ErrContactListNotFound:     "contact list not found",
+	ErrContactListExists:       "contact list already exists",
+	ErrContactNotFound:         "contact not found",
+	ErrBackupNotSupported:      "store does not support backups",
+	ErrMigrationsNotSupported:  "store does not support schema migrations",
+	ErrSchemaDirty:             "schema is dirty",
+	ErrSchemaVersionNotFound:   "schema version not found",
 }
 
 // ServiceError is a custom error type.
@@ -72,22 +152,83 @@ type ProjectsRepository interface {
 
 	// GetProject gets a project from the store.
 	GetProject(ctx context.Context, projectID string) (*Project, error)
+
+	// ListProjects lists the projects ordered by id.
+	ListProjects(ctx context.Context, params ListProjects) ([]*Project, error)
+
+	// SetProjectAllowedRecipientDomains sets the recipient domains the
+	// project is allowed to send to. An empty list allows all domains.
+	SetProjectAllowedRecipientDomains(ctx context.Context, projectID string, domains JSONArray) (*Project, error)
+
+	// SetProjectDefaultTransport sets the project's default transport. An
+	// empty transportID clears the default.
+	SetProjectDefaultTransport(ctx context.Context, projectID, transportID string) (*Project, error)
+
+	// SetProjectTransportChain sets the ordered transports the project's
+	// emails fall back through. An empty list disables fallback.
+	SetProjectTransportChain(ctx context.Context, projectID string, transportIDs JSONArray) (*Project, error)
+
+	// SetProjectDefaultGroup sets the project's default group. An empty
+	// groupID clears the default.
+	SetProjectDefaultGroup(ctx context.Context, projectID, groupID string) (*Project, error)
+
+	// SetProjectLabels replaces the labels of a project.
+	SetProjectLabels(ctx context.Context, projectID string, labels JSONObject) (*Project, error)
+
+	// SetProjectVariables replaces the template variables of a project.
+	SetProjectVariables(ctx context.Context, projectID string, variables JSONObject) (*Project, error)
+
+	// UpdateProject sets the name and description of a project.
+	UpdateProject(ctx context.Context, params UpdateProject) (*Project, error)
+
+	// DeleteProject deletes a project and everything that belongs to it.
+	DeleteProject(ctx context.Context, projectID string) error
 }
 
 // Project represents an individual project.
 type Project struct {
+	ProjectID               string
+	ProjectName             string
+	Description             string
+	AllowedRecipientDomains JSONArray
+	DefaultTransportID      string
+	DefaultGroupID          string
+	TransportChain          JSONArray
+	Labels                  JSONObject
+
+	// Variables are the key/value pairs available to every template of
+	// the project as .Project.
+	Variables JSONObject
+	CreatedAt Datetime
+}
+
+// AddProject is the input parameters for the InsertProject method.
+type AddProject struct {
 	ProjectID   string
 	ProjectName string
 	Description string
 	CreatedAt   Datetime
 }
 
-// AddProject is the input parameters for the InsertProject method.
-type AddProject struct {
+// ListProjects is the input parameters for the ListProjects method.
+type ListProjects struct {
+	// Labels, if set, lists only the projects that have all of the
+	// labels.
+	Labels map[string]string
+
+	// After, if set, lists only the projects whose id sorts after it.
+	After string
+
+	// Limit is the maximum number of projects to list. Zero means no
+	// limit.
+	Limit int
+}
+
+// UpdateProject is the input parameters for the UpdateProject method.
+type UpdateProject struct {
 	ProjectID   string
 	ProjectName string
 	Description string
-	CreatedAt   Datetime
 }
 
 const RFC3339Micro = "2006-01-02T15:04:05.000000Z07:00" // .000000Z = keep trailing zeros
@@ -132,6 +273,68 @@ func (a JSONArray) Value() (driver.Value, error) {
 	return string(v), nil
 }
 
+// JSONObject is a string map stored as a JSON object.
+type JSONObject map[string]string
+
+// Scan unmarshals a JSON object into a JSONObject.
+func (o *JSONObject) Scan(v any) error {
+	return json.Unmarshal([]byte(v.(string)), o)
+}
+
+// Value returns the object as a JSON string.
+func (o JSONObject) Value() (driver.Value, error) {
+	if o == nil {
+		return "{}", nil
+	}
+	v, err := json.Marshal(o)
+	if err != nil {
+		return nil, err
+	}
+	return string(v), nil
+}
+
+// JSONParams is a map of template params stored as a JSON object.
+type JSONParams map[string]any
+
+// Scan unmarshals a JSON object into a JSONParams.
+func (p *JSONParams) Scan(v any) error {
+	return json.Unmarshal([]byte(v.(string)), p)
+}
+
+// Value returns the params as a JSON string.
+func (p JSONParams) Value() (driver.Value, error) {
+	if p == nil {
+		return "{}", nil
+	}
+	v, err := json.Marshal(p)
+	if err != nil {
+		return nil, err
+	}
+	return string(v), nil
+}
+
+// JSONIntArray is a JSON array of integers.
+type JSONIntArray []int
+
+// Scan unmarshals a JSON array into a JSONIntArray.
+func (a *JSONIntArray) Scan(v any) error {
+	var arr []int
+	if err := json.Unmarshal([]byte(v.(string)), &arr); err != nil {
+		return err
+	}
+	*a = arr
+	return nil
+}
+
+// Value returns the JSON array as a string.
+func (a JSONIntArray) Value() (driver.Value, error) {
+	v, err := json.Marshal(a)
+	if err != nil {
+		return nil, err
+	}
+	return string(v), nil
+}
+
 //
 // smtp transports
 //
@@ -145,6 +348,25 @@ type SMTPTransportsRepository interface {
 	// InsertSMTPTransport inserts a new SMTP transport into the store.
 	InsertSMTPTransport(ctx context.Context, params AddSMTPTransport) (*SMTPTransport, error)
 	GetSMTPTransport(ctx context.Context, transportID, projectID string) (*SMTPTransport, error)
+
+	// SetSMTPTransportWarmup sets the warm-up schedule of daily sending
+	// limits for a transport starting from the day of startedAt.
+	SetSMTPTransportWarmup(ctx context.Context, transportID, projectID string, schedule JSONIntArray, startedAt Datetime) (*SMTPTransport, error)
+
+	// UpdateSMTPTransport replaces the connection and sender settings of
+	// an SMTP transport. The password is left unchanged.
+	UpdateSMTPTransport(ctx context.Context, params UpdateSMTPTransport) (*SMTPTransport, error)
+
+	// SetSMTPTransportPassword replaces the encrypted password of an SMTP
+	// transport.
+	SetSMTPTransportPassword(ctx context.Context, transportID, projectID, encryptedPassword string) (*SMTPTransport, error)
+
+	// DeleteSMTPTransport deletes an SMTP transport. If queued or sending
+	// emails reference the transport an error of type ErrTransportInUse
+	// is returned, unless force is set, in which case those emails are
+	// marked as failed. The transport is cleared as the project's default
+	// transport and removed from its transport chain.
+	DeleteSMTPTransport(ctx context.Context, transportID, projectID string, force bool) error
 }
 
 // SMTPTransport represents an SMTP transport for a project.
@@ -159,8 +381,21 @@ type SMTPTransport struct {
 	EmailFrom         string
 	EmailFromName     string
 	EmailReplyTo      JSONArray
+	WarmupSchedule    JSONIntArray
+	WarmupStartedAt   Datetime
 	CreatedAt         Datetime
 	ModifiedAt        Datetime
+
+	// TLSMode is one of "" (STARTTLS when offered), "none", "starttls"
+	// or "tls" (implicit TLS).
+	TLSMode               string
+	TLSInsecureSkipVerify bool
+	TLSCABundle           string
+
+	// ConnectTimeoutMS and SendTimeoutMS are the connect and send
+	// timeouts in milliseconds, 0 to use the defaults.
+	ConnectTimeoutMS int
+	SendTimeoutMS    int
 }
 
 // AddSMTPTransport is the input parameters for the InsertSMTPTransport method.
@@ -177,6 +412,87 @@ type AddSMTPTransport struct {
 	EmailReplyTo      JSONArray
 	CreatedAt         Datetime
 	ModifiedAt        Datetime
+
+	TLSMode               string
+	TLSInsecureSkipVerify bool
+	TLSCABundle           string
+
+	ConnectTimeoutMS int
+	SendTimeoutMS    int
+}
+
+// UpdateSMTPTransport is the input parameters for the UpdateSMTPTransport
+// method.
+type UpdateSMTPTransport struct {
+	SMTPTransportID string
+	ProjectID       string
+	TransportName   string
+	Host            string
+	Port            int
+	Username        string
+	EmailFrom       string
+	EmailFromName   string
+	EmailReplyTo    JSONArray
+
+	TLSMode               string
+	TLSInsecureSkipVerify bool
+	TLSCABundle           string
+
+	ConnectTimeoutMS int
+	SendTimeoutMS    int
+}
+
+//
+// api transports
+//
+
+// API transport providers.
+const (
+	APITransportProviderMailgun  = "mailgun"
+	APITransportProviderSES      = "ses"
+	APITransportProviderPostmark = "postmark"
+	APITransportProviderWebhook  = "webhook"
+
+	APITransportProviderSMTPOAuth2 = "smtp_oauth2"
+	APITransportProviderFile       = "file"
+)
+
+type APITransportsRepository interface {
+	// InsertAPITransport inserts a new API transport into the store.
+	InsertAPITransport(ctx context.Context, params AddAPITransport) (*APITransport, error)
+
+	// GetAPITransport gets an API transport from the store. If the
+	// transport does not exist store.ErrTransportNotFound is returned.
+	GetAPITransport(ctx context.Context, transportID, projectID string) (*APITransport, error)
+}
+
+// APITransport represents a transport that delivers emails using an
+// email provider's HTTP API.
+type APITransport struct {
+	APITransportID  string
+	ProjectID       string
+	TransportName   string
+	Provider        string
+	Config          JSONObject
+	EncryptedAPIKey string
+	EmailFrom       string
+	EmailFromName   string
+	EmailReplyTo    JSONArray
+	CreatedAt       Datetime
+	ModifiedAt      Datetime
+}
+
+// AddAPITransport is the input parameters for the InsertAPITransport method.
+type AddAPITransport struct {
+	APITransportID  string
+	ProjectID       string
+	TransportName   string
+	Provider        string
+	Config          JSONObject
+	EncryptedAPIKey string
+	EmailFrom       string
+	EmailFromName   string
+	EmailReplyTo    JSONArray
 }
 
 //
@@ -186,6 +502,20 @@ type AddSMTPTransport struct {
 type GroupsRepository interface {
 	// InsertGroup inserts a new group into the store
 	InsertGroup(ctx context.Context, params AddGroup) (*Group, error)
+
+	// GetGroup gets a group from the store.
+	GetGroup(ctx context.Context, projectID, groupID string) (*Group, error)
+
+	// ListGroups lists the groups of a project ordered by id.
+	ListGroups(ctx context.Context, params ListGroups) ([]*Group, error)
+
+	// UpdateGroup renames a group.
+	UpdateGroup(ctx context.Context, params UpdateGroup) (*Group, error)
+
+	// DeleteGroup deletes a group along with its partials and send window.
+	// If the group has templates it is not deleted unless force is set,
+	// in which case the templates are deleted too.
+	DeleteGroup(ctx context.Context, projectID, groupID string, force bool) error
 }
 
 // Group represents a group of templates.
@@ -206,6 +536,24 @@ type AddGroup struct {
 	ModifiedAt Datetime
 }
 
+// ListGroups is the input parameters for the ListGroups method.
+type ListGroups struct {
+	ProjectID string
+
+	// After, if set, lists only the groups whose id sorts after it.
+	After string
+
+	// Limit is the maximum number of groups to list. Zero means no limit.
+	Limit int
+}
+
+// UpdateGroup is the input parameters for the UpdateGroup method.
+type UpdateGroup struct {
+	GroupID   string
+	ProjectID string
+	GroupName string
+}
+
 //
 // templates
 //
@@ -220,6 +568,45 @@ type TemplatesRepository interface {
 
 	// GetTemplate gets a template from the store.
 	GetTemplate(ctx context.Context, projectID, templateID string) (*Template, error)
+
+	// SetTemplateAssetMode sets how a template references its assets.
+	SetTemplateAssetMode(ctx context.Context, projectID, templateID, assetMode string) (*Template, error)
+
+	// SetTemplateLabels replaces the labels of a template.
+	SetTemplateLabels(ctx context.Context, projectID, templateID string, labels JSONObject) (*Template, error)
+
+	// ListTemplates lists the templates of a project ordered by id.
+	ListTemplates(ctx context.Context, params ListTemplates) ([]*Template, error)
+
+	// SearchTemplates lists the templates of a project whose subject,
+	// text or HTML contain query, ignoring case.
+	SearchTemplates(ctx context.Context, projectID, query string) ([]*Template, error)
+
+	// DeleteTemplate deletes a template and its references to
+	// attachments.
+	DeleteTemplate(ctx context.Context, projectID, templateID string) error
+}
+
+// ListTemplates is the input parameters for the ListTemplates method.
+type ListTemplates struct {
+	ProjectID string
+
+	// GroupID, if set, lists only the templates in the group.
+	GroupID string
+
+	// Query, if set, lists only the templates whose id contains it.
+	Query string
+
+	// Labels, if set, lists only the templates that have all of the
+	// labels.
+	Labels map[string]string
+
+	// After, if set, lists only the templates whose id sorts after it.
+	After string
+
+	// Limit is the maximum number of templates to list. Zero means no
+	// limit.
+	Limit int
 }
 
 // Template represents an email template based on the schema.
@@ -231,6 +618,11 @@ type Template struct {
 	TxtDigest  string
 	HTML       string
 	HTMLDigest string
+	Subject    string
+	SourceType string
+	Source     string
+	AssetMode  string
+	Labels     JSONObject
 	CreatedAt  Datetime
 	ModifiedAt Datetime
 }
@@ -244,6 +636,9 @@ type AddTemplate struct {
 	TxtDigest  string
 	HTML       string
 	HTMLDigest string
+	Subject    string
+	SourceType string
+	Source     string
 	CreatedAt  Datetime
 	ModifiedAt Datetime
 }
@@ -257,13 +652,1365 @@ type SetTemplateParams struct {
 	TxtDigest  string
 	HTML       string
 	HTMLDigest string
+	Subject    string
+	SourceType string
+	Source     string
 	CreatedAt  Datetime
 	ModifiedAt Datetime
 }
 
+// template source types
+const (
+	TemplateSourceHTML     = "html"
+	TemplateSourceMJML     = "mjml"
+	TemplateSourceMarkdown = "markdown"
+)
+
 // TemplateDigest is a digest of a template.
 type TemplateDigest struct {
 	TemplateID string
 	TxtDigest  string
 	HTMLDigest string
 }
+
+//
+// mail queue
+//
+
+// mail queue states (mstate)
+const (
+	MailQueueStateQueued     = "queued"
+	MailQueueStateSending    = "sending"
+	MailQueueStateSent       = "sent"
+	MailQueueStateFailed     = "failed"
+	MailQueueStateBlocked    = "blocked"
+	MailQueueStateDeadLetter = "dead_letter"
+	MailQueueStateCancelled  = "cancelled"
+	MailQueueStateBounced    = "bounced"
+
+	// MailQueueStateRateLimited is a claimed email returned to the queue
+	// because a rate limit was reached. It is claimed again once its
+	// next attempt is due, like a queued email.
+	MailQueueStateRateLimited = "rate_limited"
+
+	// MailQueueStatePaused is a queued email of a paused campaign. It is
+	// not claimed until the campaign is resumed.
+	MailQueueStatePaused = "paused"
+)
+
+// MailQueueFinishedStates are the states of emails whose delivery has
+// finished, one way or another. Emails in these states can be pruned.
+var MailQueueFinishedStates = []string{
+	MailQueueStateSent,
+	MailQueueStateFailed,
+	MailQueueStateBlocked,
+	MailQueueStateDeadLetter,
+	MailQueueStateCancelled,
+	MailQueueStateBounced,
+}
+
+// MailQueuePendingStates are the states of emails waiting to be sent,
+// including those being sent. Paused emails are not included as they wait
+// for their transport to be resumed.
+var MailQueuePendingStates = []string{
+	MailQueueStateQueued,
+	MailQueueStateSending,
+	MailQueueStateRateLimited,
+}
+
+type MailQueueRepository interface {
+	// InsertMailQueue inserts a new email into the mail queue.
+	InsertMailQueue(ctx context.Context, params AddMailQueue) (*MailQueue, error)
+
+	// GetMailQueue gets an email from the mail queue.
+	GetMailQueue(ctx context.Context, projectID, mailQueueID string) (*MailQueue, error)
+
+	// ClaimMailQueue atomically moves up to limit queued emails whose
+	// next attempt is due to the sending state and returns them, oldest
+	// first. Emails left in the sending state for longer than lease, by
+	// a worker that stopped without releasing them, are claimed again. A
+	// lease of zero or less never reclaims them.
+	ClaimMailQueue(ctx context.Context, limit int, lease time.Duration) ([]*MailQueue, error)
+
+	// UpdateMailQueueState sets the state of an email in the mail queue.
+	UpdateMailQueueState(ctx context.Context, params UpdateMailQueueState) (*MailQueue, error)
+
+	// CountMailQueueSent counts the emails sent by a transport since the
+	// given time, including those it sent as a fallback for another
+	// transport. An empty transportID counts the emails sent by every
+	// transport in the project.
+	CountMailQueueSent(ctx context.Context, projectID, transportID string, since Datetime) (int, error)
+
+	// GetMailQueueByMessageID gets an email from the mail queue by its
+	// Message-ID header.
+	GetMailQueueByMessageID(ctx context.Context, projectID, messageID string) (*MailQueue, error)
+
+	// ListMailQueue lists the emails in the mail queue of a project,
+	// newest first.
+	ListMailQueue(ctx context.Context, params ListMailQueue) ([]*MailQueue, error)
+
+	// TransitionMailQueueState moves an email from one of a set of states
+	// to another. If the email is in none of the states an error of type
+	// ErrMailQueueState is returned.
+	TransitionMailQueueState(ctx context.Context, params TransitionMailQueueState) (*MailQueue, error)
+
+	// InsertMailQueueAttempt records the outcome of a delivery attempt.
+	InsertMailQueueAttempt(ctx context.Context, params AddMailQueueAttempt) (*MailQueueAttempt, error)
+
+	// ListMailQueueAttempts lists the delivery attempts of an email in
+	// the order they were made.
+	ListMailQueueAttempts(ctx context.Context, projectID, mailQueueID string) ([]*MailQueueAttempt, error)
+
+	// RecordMailQueueOpen counts an open of an email and returns its
+	// updated open counts.
+	RecordMailQueueOpen(ctx context.Context, projectID, mailQueueID string) (*MailQueueOpens, error)
+
+	// GetMailQueueOpens gets the open counts of an email. Emails that have
+	// not been opened have an Opens count of zero.
+	GetMailQueueOpens(ctx context.Context, projectID, mailQueueID string) (*MailQueueOpens, error)
+
+	// CountMailQueueByDay counts the emails of a project queued in a time
+	// range by the UTC day they were queued, template, transport and
+	// current state. Test sends are not counted.
+	CountMailQueueByDay(ctx context.Context, params CountMailQueueByDay) ([]*MailQueueDayCount, error)
+
+	// GetMailQueueStats counts the emails of a project by state, finds the
+	// oldest of those waiting to be sent and counts the delivery attempts
+	// made at or after since.
+	GetMailQueueStats(ctx context.Context, projectID string, since Datetime) (*MailQueueStats, error)
+
+	// ListExpiredMailQueue lists up to limit emails of every project that
+	// are in one of MailQueueFinishedStates and were last modified before
+	// the given time, least recently modified first.
+	ListExpiredMailQueue(ctx context.Context, before Datetime, limit int) ([]*MailQueue, error)
+
+	// DeleteExpiredMailQueue deletes the emails with the given ids along
+	// with their delivery attempts and open counts, skipping any that are
+	// no longer in one of MailQueueFinishedStates or were modified since
+	// the given time. It returns the number of emails deleted.
+	DeleteExpiredMailQueue(ctx context.Context, before Datetime, mailQueueIDs []string) (int, error)
+}
+
+// MailQueue represents an email in the mail queue.
+type MailQueue struct {
+	MailQueueID    string
+	ProjectID      string
+	TemplateID     string
+	TransportID    string
+	MState         string
+	Metadata       MailQueueMetadata
+	Body           MailQueueBody
+	LastError      string
+	Attempts       int
+	SendAt         Datetime
+	NextAttemptAt  Datetime
+	DeferralReason string
+
+	// MessageID is the Message-ID header of the email without the angle
+	// brackets.
+	MessageID string
+
+	// SentAt is the time the email was delivered. It is zero for emails
+	// that have not been sent.
+	SentAt Datetime
+
+	// SentTransportID is the transport that delivered the email, which
+	// differs from TransportID if a fallback transport was used.
+	SentTransportID string
+
+	// CampaignID is the campaign the email was queued for, if any.
+	CampaignID string
+	CreatedAt  Datetime
+	ModifiedAt Datetime
+}
+
+// MailQueueMetadata is the envelope information about a queued email.
+// It is kept for the lifetime of the mail queue entry.
+type MailQueueMetadata struct {
+	To         []string `json:"to"`
+	Cc         []string `json:"cc,omitempty"`
+	Bcc        []string `json:"bcc,omitempty"`
+	Subject    string   `json:"subject"`
+	GroupID    string   `json:"group_id,omitempty"`
+	Timezone   string   `json:"timezone,omitempty"`
+	TxtDigest  string   `json:"txt_digest"`
+	HTMLDigest string   `json:"html_digest"`
+
+	// MessageStream is the provider message stream, for transports that
+	// support them.
+	MessageStream string `json:"message_stream,omitempty"`
+
+	// AttachmentIDs are the stored attachments referenced by the
+	// template when the email was queued.
+	AttachmentIDs []string `json:"attachment_ids,omitempty"`
+
+	// AssetIDs are the assets embedded in the HTML body as inline
+	// attachments.
+	AssetIDs []string `json:"asset_ids,omitempty"`
+
+	// Unsubscribe adds List-Unsubscribe headers when the email is sent.
+	Unsubscribe bool `json:"unsubscribe,omitempty"`
+
+	// TrackOpens is set if a tracking pixel was added to the HTML body.
+	TrackOpens bool `json:"track_opens,omitempty"`
+
+	// Headers are the extra headers of the email.
+	Headers map[string]string `json:"headers,omitempty"`
+
+	// InReplyTo and References thread the email with earlier emails.
+	InReplyTo  string   `json:"in_reply_to,omitempty"`
+	References []string `json:"references,omitempty"`
+
+	// EmailFrom and EmailFromName override the transport's sender.
+	EmailFrom     string `json:"email_from,omitempty"`
+	EmailFromName string `json:"email_from_name,omitempty"`
+
+	// ReplyTo overrides the transport's reply-to addresses.
+	ReplyTo []string `json:"reply_to,omitempty"`
+
+	// Test is set for test sends to a seed list, which are left out of
+	// the project's stats.
+	Test bool `json:"test,omitempty"`
+
+	// Labels are the free-form key/value labels given to the email when
+	// it was queued.
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// Scan unmarshals JSON metadata from the database.
+func (m *MailQueueMetadata) Scan(v any) error {
+	return json.Unmarshal([]byte(v.(string)), m)
+}
+
+// Value returns the metadata as a JSON string.
+func (m MailQueueMetadata) Value() (driver.Value, error) {
+	v, err := json.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+	return string(v), nil
+}
+
+// MailQueueBody is the rendered content of a queued email along with the
+// parameters used to render it. Depending on the body retention policy it
+// may be redacted once the email has been delivered.
+type MailQueueBody struct {
+	Txt            string         `json:"txt"`
+	HTML           string         `json:"html"`
+	TemplateParams map[string]any `json:"template_params,omitempty"`
+	Redacted       bool           `json:"redacted,omitempty"`
+
+	// Attachments are the files attached to this email only, as opposed
+	// to the stored attachments referenced by AttachmentIDs.
+	Attachments []MailQueueAttachment `json:"attachments,omitempty"`
+}
+
+// MailQueueAttachment is a file attached to a single queued email.
+type MailQueueAttachment struct {
+	Filename    string `json:"filename"`
+	ContentType string `json:"content_type"`
+	Content     []byte `json:"content"`
+}
+
+// Scan unmarshals a JSON body from the database.
+func (b *MailQueueBody) Scan(v any) error {
+	return json.Unmarshal([]byte(v.(string)), b)
+}
+
+// Value returns the body as a JSON string.
+func (b MailQueueBody) Value() (driver.Value, error) {
+	v, err := json.Marshal(b)
+	if err != nil {
+		return nil, err
+	}
+	return string(v), nil
+}
+
+// AddMailQueue is the input parameters for the InsertMailQueue method.
+type AddMailQueue struct {
+	MailQueueID string
+	ProjectID   string
+	TemplateID  string
+	TransportID string
+	MState      string
+	Metadata    MailQueueMetadata
+	Body        MailQueueBody
+	LastError   string
+	MessageID   string
+	CampaignID  string
+
+	// SendAt schedules the email for later delivery. If zero the email
+	// is due as soon as it is queued.
+	SendAt     Datetime
+	CreatedAt  Datetime
+	ModifiedAt Datetime
+}
+
+// UpdateMailQueueState is the input parameters for the UpdateMailQueueState
+// method. If Body is non-nil the stored body is replaced in the same update.
+// If NextAttemptAt or Attempts are non-nil they are also replaced.
+// SentTransportID is recorded when MState is MailQueueStateSent, defaulting
+// to the email's transport.
+type UpdateMailQueueState struct {
+	MailQueueID     string
+	MState          string
+	LastError       string
+	DeferralReason  string
+	NextAttemptAt   *Datetime
+	Attempts        *int
+	Body            *MailQueueBody
+	SentTransportID string
+}
+
+// ListMailQueue is the input parameters for the ListMailQueue method.
+type ListMailQueue struct {
+	ProjectID string
+
+	// MState, if set, lists only the emails in the state.
+	MState string
+
+	// Labels, if set, lists only the emails that have all of the labels.
+	Labels map[string]string
+
+	// After, if set, is the id of the last email of the previous page.
+	After string
+
+	// Limit is the maximum number of emails to list. Zero means no limit.
+	Limit int
+}
+
+// TransitionMailQueueState is the input parameters for the
+// TransitionMailQueueState method. If NextAttemptAt or LastError are
+// non-nil they are also replaced.
+type TransitionMailQueueState struct {
+	ProjectID     string
+	MailQueueID   string
+	From          []string
+	MState        string
+	NextAttemptAt *Datetime
+	LastError     *string
+
+	// Attempts, if set, replaces the number of delivery attempts.
+	Attempts *int
+
+	// TransportID, if set, replaces the transport used to deliver the
+	// email.
+	TransportID *string
+}
+
+// MailQueueAttempt is the outcome of a single delivery attempt. MState is
+// either MailQueueStateSent or MailQueueStateFailed. ResponseCode and
+// Response are the reply of the SMTP server or the HTTP status and body of
+// the API, if the transport reported one. CreatedAt is the time the attempt
+// finished.
+type MailQueueAttempt struct {
+	MailQueueID  string
+	ProjectID    string
+	Attempt      int
+	TransportID  string
+	MState       string
+	Error        string
+	ResponseCode int
+	Response     string
+	StartedAt    Datetime
+	CreatedAt    Datetime
+}
+
+// AddMailQueueAttempt is the input parameters for the
+// InsertMailQueueAttempt method. Attempts are numbered in the order they
+// are inserted, so the history continues after an email's attempt counter
+// is reset.
+type AddMailQueueAttempt struct {
+	MailQueueID  string
+	ProjectID    string
+	TransportID  string
+	MState       string
+	Error        string
+	ResponseCode int
+	Response     string
+	StartedAt    Datetime
+}
+
+// CountMailQueueByDay is the input parameters for the CountMailQueueByDay
+// method. Emails queued at or after From and before To are counted.
+type CountMailQueueByDay struct {
+	ProjectID string
+	From      Datetime
+	To        Datetime
+}
+
+// MailQueueDayCount is the number of emails queued on a UTC day, formatted
+// as 2006-01-02, with the same template, transport and state.
+type MailQueueDayCount struct {
+	Day         string
+	TemplateID  string
+	TransportID string
+	MState      string
+	Count       int
+}
+
+// MailQueueStats is the state of the mail queue of a project returned by
+// GetMailQueueStats. OldestPendingCreatedAt is the time the oldest email in
+// one of MailQueuePendingStates was queued, or zero if there are none.
+type MailQueueStats struct {
+	States                 map[string]int
+	OldestPendingCreatedAt Datetime
+	Attempts               int
+	FailedAttempts         int
+}
+
+// MailQueueOpens counts the opens of an email recorded by its tracking
+// pixel. FirstOpenedAt and LastOpenedAt are zero if it has not been opened.
+type MailQueueOpens struct {
+	MailQueueID   string
+	ProjectID     string
+	Opens         int
+	FirstOpenedAt Datetime
+	LastOpenedAt  Datetime
+}
+
+//
+// send windows
+//
+
+type SendWindowsRepository interface {
+	// SetSendWindow creates or replaces the send window for a project
+	// and group.
+	SetSendWindow(ctx context.Context, params SetSendWindow) (*SendWindow, error)
+
+	// ListSendWindows lists all the send windows for a project.
+	ListSendWindows(ctx context.Context, projectID string) ([]*SendWindow, error)
+
+	// DeleteSendWindow deletes the send window for a project and group.
+	DeleteSendWindow(ctx context.Context, projectID, groupID string) error
+}
+
+// SendWindow is a daily window during which emails can be delivered.
+// An empty GroupID applies to all groups in the project.
+type SendWindow struct {
+	ProjectID  string
+	GroupID    string
+	StartTime  string
+	EndTime    string
+	Timezone   string
+	CreatedAt  Datetime
+	ModifiedAt Datetime
+}
+
+// SetSendWindow is the input parameters for the SetSendWindow method.
+type SetSendWindow struct {
+	ProjectID string
+	GroupID   string
+	StartTime string
+	EndTime   string
+	Timezone  string
+}
+
+//
+// rate limits
+//
+
+type RateLimitsRepository interface {
+	// SetRateLimit creates or replaces the rate limit for a project and
+	// transport.
+	SetRateLimit(ctx context.Context, params SetRateLimit) (*RateLimit, error)
+
+	// ListRateLimits lists all the rate limits for a project.
+	ListRateLimits(ctx context.Context, projectID string) ([]*RateLimit, error)
+
+	// DeleteRateLimit deletes the rate limit for a project and transport.
+	DeleteRateLimit(ctx context.Context, projectID, transportID string) error
+}
+
+// RateLimit caps the number of emails delivered per second, minute and
+// day. An empty TransportID applies to all transports in the project. A
+// limit of zero is unlimited.
+type RateLimit struct {
+	ProjectID   string
+	TransportID string
+	PerSecond   int
+	PerMinute   int
+	PerDay      int
+	CreatedAt   Datetime
+	ModifiedAt  Datetime
+}
+
+// SetRateLimit is the input parameters for the SetRateLimit method.
+type SetRateLimit struct {
+	ProjectID   string
+	TransportID string
+	PerSecond   int
+	PerMinute   int
+	PerDay      int
+}
+
+//
+// project quotas
+//
+
+// Project quota actions.
+const (
+	ProjectQuotaActionReject = "reject"
+	ProjectQuotaActionDefer  = "defer"
+)
+
+type ProjectQuotasRepository interface {
+	// SetProjectQuota creates or replaces the quota of a project.
+	SetProjectQuota(ctx context.Context, params SetProjectQuota) (*ProjectQuota, error)
+
+	// GetProjectQuota gets the quota of a project.
+	GetProjectQuota(ctx context.Context, projectID string) (*ProjectQuota, error)
+
+	// DeleteProjectQuota deletes the quota of a project. Its usage is
+	// kept.
+	DeleteProjectQuota(ctx context.Context, projectID string) error
+
+	// GetProjectQuotaUsage returns the number of emails counted for a
+	// project on a day and in a month.
+	GetProjectQuotaUsage(ctx context.Context, projectID, day, month string) (*ProjectQuotaUsage, error)
+
+	// ReserveProjectQuota counts an email for a project on a day and in a
+	// month unless either would go over its limit, and reports whether it
+	// was counted.
+	ReserveProjectQuota(ctx context.Context, params ReserveProjectQuota) (bool, error)
+
+	// ReleaseProjectQuota uncounts an email counted by
+	// ReserveProjectQuota that was not sent after all.
+	ReleaseProjectQuota(ctx context.Context, projectID, day, month string) error
+}
+
+// ProjectQuota caps the number of emails a project may send per day and
+// per month. A limit of zero is unlimited.
+type ProjectQuota struct {
+	ProjectID  string
+	PerDay     int
+	PerMonth   int
+	Action     string
+	CreatedAt  Datetime
+	ModifiedAt Datetime
+}
+
+// SetProjectQuota is the input parameters for the SetProjectQuota method.
+type SetProjectQuota struct {
+	ProjectID string
+	PerDay    int
+	PerMonth  int
+	Action    string
+}
+
+// ProjectQuotaUsage is the number of emails counted against a project's
+// quota on a day (YYYY-MM-DD) and in a month (YYYY-MM).
+type ProjectQuotaUsage struct {
+	ProjectID string
+	Day       string
+	Month     string
+	DaySent   int
+	MonthSent int
+}
+
+// ReserveProjectQuota is the input parameters for the ReserveProjectQuota
+// method. A limit of zero is unlimited.
+type ReserveProjectQuota struct {
+	ProjectID string
+	Day       string
+	Month     string
+	PerDay    int
+	PerMonth  int
+}
+
+//
+// webhooks
+//
+
+// Webhook delivery states.
+const (
+	WebhookDeliveryStatePending   = "pending"
+	WebhookDeliveryStateSending   = "sending"
+	WebhookDeliveryStateDelivered = "delivered"
+	WebhookDeliveryStateFailed    = "failed"
+)
+
+type WebhooksRepository interface {
+	// InsertWebhook inserts a new webhook endpoint for a project.
+	InsertWebhook(ctx context.Context, params AddWebhook) (*Webhook, error)
+
+	// GetWebhook gets a webhook endpoint.
+	GetWebhook(ctx context.Context, projectID, webhookID string) (*Webhook, error)
+
+	// ListWebhooks lists the webhook endpoints of a project ordered by id.
+	ListWebhooks(ctx context.Context, projectID string) ([]*Webhook, error)
+
+	// DeleteWebhook deletes a webhook endpoint along with its deliveries.
+	DeleteWebhook(ctx context.Context, projectID, webhookID string) error
+
+	// InsertWebhookDelivery adds an event to be delivered to a webhook
+	// endpoint. The delivery is pending and due immediately.
+	InsertWebhookDelivery(ctx context.Context, params AddWebhookDelivery) (*WebhookDelivery, error)
+
+	// ClaimWebhookDeliveries atomically moves up to limit pending
+	// deliveries whose next attempt is due to the sending state and
+	// returns them, oldest first.
+	ClaimWebhookDeliveries(ctx context.Context, limit int) ([]*WebhookDelivery, error)
+
+	// UpdateWebhookDelivery records the outcome of a delivery attempt.
+	UpdateWebhookDelivery(ctx context.Context, params UpdateWebhookDelivery) (*WebhookDelivery, error)
+
+	// ListWebhookDeliveries lists the deliveries of a webhook endpoint,
+	// newest first.
+	ListWebhookDeliveries(ctx context.Context, projectID, webhookID string) ([]*WebhookDelivery, error)
+}
+
+// Webhook is an endpoint notified of mail lifecycle events. An empty
+// Events array subscribes to every event.
+type Webhook struct {
+	WebhookID       string
+	ProjectID       string
+	URL             string
+	EncryptedSecret string
+	Events          JSONArray
+	CreatedAt       Datetime
+	ModifiedAt      Datetime
+}
+
+// AddWebhook is the input parameters for the InsertWebhook method.
+type AddWebhook struct {
+	WebhookID       string
+	ProjectID       string
+	URL             string
+	EncryptedSecret string
+	Events          JSONArray
+}
+
+// WebhookDelivery is a single event to be POSTed to a webhook endpoint.
+// Payload is the JSON request body.
+type WebhookDelivery struct {
+	DeliveryID    string
+	WebhookID     string
+	ProjectID     string
+	Event         string
+	Payload       string
+	DState        string
+	Attempts      int
+	ResponseCode  int
+	LastError     string
+	NextAttemptAt Datetime
+	CreatedAt     Datetime
+	ModifiedAt    Datetime
+}
+
+// AddWebhookDelivery is the input parameters for the InsertWebhookDelivery
+// method.
+type AddWebhookDelivery struct {
+	DeliveryID string
+	WebhookID  string
+	ProjectID  string
+	Event      string
+	Payload    string
+}
+
+// UpdateWebhookDelivery is the input parameters for the
+// UpdateWebhookDelivery method. NextAttemptAt, if set, replaces the time
+// of the next attempt.
+type UpdateWebhookDelivery struct {
+	DeliveryID    string
+	DState        string
+	Attempts      int
+	ResponseCode  int
+	LastError     string
+	NextAttemptAt *Datetime
+}
+
+//
+// campaigns
+//
+
+// campaign states (cstate)
+const (
+	CampaignStateRunning = "running"
+	CampaignStatePaused  = "paused"
+)
+
+type CampaignsRepository interface {
+	// InsertCampaign inserts a new campaign for a project.
+	InsertCampaign(ctx context.Context, params AddCampaign) (*Campaign, error)
+
+	// GetCampaign gets a campaign.
+	GetCampaign(ctx context.Context, projectID, campaignID string) (*Campaign, error)
+
+	// ListCampaigns lists the campaigns of a project ordered by id.
+	ListCampaigns(ctx context.Context, projectID string) ([]*Campaign, error)
+
+	// SetCampaignState sets the state of a campaign. Pausing a campaign
+	// moves its queued and rate limited emails to the paused state, and
+	// running it again returns them to the queue.
+	SetCampaignState(ctx context.Context, projectID, campaignID, cstate string) (*Campaign, error)
+
+	// CountCampaignMailQueue counts the emails of a campaign by state.
+	CountCampaignMailQueue(ctx context.Context, projectID, campaignID string) (map[string]int, error)
+}
+
+// Campaign is a bulk send of a template to a list of recipients. Its
+// emails carry the campaign id in the mail queue. Recipients is the number
+// of recipients the campaign was created with.
+type Campaign struct {
+	CampaignID   string
+	ProjectID    string
+	CampaignName string
+	TemplateID   string
+	TransportID  string
+	ListID       string
+	CState       string
+	Recipients   int
+	CreatedAt    Datetime
+	ModifiedAt   Datetime
+}
+
+// AddCampaign is the input parameters for the InsertCampaign method.
+type AddCampaign struct {
+	CampaignID   string
+	ProjectID    string
+	CampaignName string
+	TemplateID   string
+	TransportID  string
+	ListID       string
+	Recipients   int
+}
+
+//
+// contact lists
+//
+
+type ContactListsRepository interface {
+	// InsertContactList inserts a new, empty contact list for a project.
+	InsertContactList(ctx context.Context, params AddContactList) (*ContactList, error)
+
+	// GetContactList gets a contact list.
+	GetContactList(ctx context.Context, projectID, listID string) (*ContactList, error)
+
+	// ListContactLists lists the contact lists of a project ordered by id.
+	ListContactLists(ctx context.Context, projectID string) ([]*ContactList, error)
+
+	// DeleteContactList deletes a contact list along with its contacts.
+	DeleteContactList(ctx context.Context, projectID, listID string) error
+
+	// SetContacts adds contacts to a list, replacing the attributes,
+	// locale and timezone of any address already on it.
+	SetContacts(ctx context.Context, projectID, listID string, contacts []SetContact) error
+
+	// ListContacts lists the contacts of a list ordered by address.
+	ListContacts(ctx context.Context, params ListContacts) ([]*Contact, error)
+
+	// DeleteContact removes an address from a contact list.
+	DeleteContact(ctx context.Context, projectID, listID, email string) error
+}
+
+// ContactList is a named list of recipients. Contacts is the number of
+// contacts on the list.
+type ContactList struct {
+	ListID     string
+	ProjectID  string
+	ListName   string
+	Contacts   int
+	CreatedAt  Datetime
+	ModifiedAt Datetime
+}
+
+// AddContactList is the input parameters for the InsertContactList method.
+type AddContactList struct {
+	ListID    string
+	ProjectID string
+	ListName  string
+}
+
+// Contact is a recipient on a contact list. Attributes are passed to the
+// template as the recipient's params.
+type Contact struct {
+	ProjectID  string
+	ListID     string
+	Email      string
+	Attributes JSONObject
+	Locale     string
+	Timezone   string
+	CreatedAt  Datetime
+	ModifiedAt Datetime
+}
+
+// SetContact is a contact added by the SetContacts method.
+type SetContact struct {
+	Email      string
+	Attributes JSONObject
+	Locale     string
+	Timezone   string
+}
+
+// ListContacts is the input parameters for the ListContacts method.
+type ListContacts struct {
+	ProjectID string
+	ListID    string
+
+	// After, if set, lists only the addresses that sort after it.
+	After string
+
+	// Limit is the maximum number of contacts to list. Zero means no
+	// limit.
+	Limit int
+}
+
+//
+// suppressions
+//
+
+// Suppression reasons.
+const (
+	SuppressionReasonBounce      = "bounce"
+	SuppressionReasonComplaint   = "complaint"
+	SuppressionReasonManual      = "manual"
+	SuppressionReasonUnsubscribe = "unsubscribe"
+)
+
+type SuppressionsRepository interface {
+	// SetSuppression adds an address to the suppression list of a
+	// project, or replaces the reason if it is already on the list.
+	SetSuppression(ctx context.Context, params SetSuppression) (*Suppression, error)
+
+	// FindSuppressions returns the suppressions of a project for any of
+	// the given addresses.
+	FindSuppressions(ctx context.Context, projectID string, emails []string) ([]*Suppression, error)
+
+	// ListSuppressions lists the suppressions of a project ordered by
+	// address.
+	ListSuppressions(ctx context.Context, params ListSuppressions) ([]*Suppression, error)
+
+	// DeleteSuppression removes an address from the suppression list of a
+	// project.
+	DeleteSuppression(ctx context.Context, projectID, email string) error
+}
+
+// Suppression is an address a project no longer sends email to. Email is
+// stored in lower case.
+type Suppression struct {
+	ProjectID  string
+	Email      string
+	Reason     string
+	Detail     string
+	CreatedAt  Datetime
+	ModifiedAt Datetime
+}
+
+// SetSuppression is the input parameters for the SetSuppression method.
+type SetSuppression struct {
+	ProjectID string
+	Email     string
+	Reason    string
+	Detail    string
+}
+
+// ListSuppressions is the input parameters for the ListSuppressions
+// method.
+type ListSuppressions struct {
+	ProjectID string
+
+	// After, if set, lists only the addresses that sort after it.
+	After string
+
+	// Limit is the maximum number of suppressions to list. Zero means no
+	// limit.
+	Limit int
+}
+
+//
+// sender allow-lists
+//
+
+type SenderAllowListsRepository interface {
+	// SetSenderAllowList creates or replaces the sender addresses a
+	// transport may send from in place of its own.
+	SetSenderAllowList(ctx context.Context, params SetSenderAllowList) (*SenderAllowList, error)
+
+	// GetSenderAllowList gets the sender allow-list of a transport.
+	GetSenderAllowList(ctx context.Context, projectID, transportID string) (*SenderAllowList, error)
+
+	// DeleteSenderAllowList deletes the sender allow-list of a transport.
+	DeleteSenderAllowList(ctx context.Context, projectID, transportID string) error
+}
+
+// SenderAllowList is the verified sender addresses a transport may send
+// from in place of its own. Emails are stored in lower case.
+type SenderAllowList struct {
+	ProjectID   string
+	TransportID string
+	Emails      JSONArray
+	CreatedAt   Datetime
+	ModifiedAt  Datetime
+}
+
+// SetSenderAllowList is the input parameters for the SetSenderAllowList
+// method.
+type SetSenderAllowList struct {
+	ProjectID   string
+	TransportID string
+	Emails      JSONArray
+}
+
+//
+// api keys
+//
+
+type APIKeysRepository interface {
+	// InsertAPIKey inserts a new API key.
+	InsertAPIKey(ctx context.Context, params AddAPIKey) (*APIKey, error)
+
+	// GetAPIKeyByHash gets an API key by the hash of its secret.
+	GetAPIKeyByHash(ctx context.Context, keyHash string) (*APIKey, error)
+
+	// ListAPIKeys lists the API keys ordered by creation time.
+	ListAPIKeys(ctx context.Context) ([]*APIKey, error)
+
+	// DeleteAPIKey deletes an API key.
+	DeleteAPIKey(ctx context.Context, apiKeyID string) error
+}
+
+// APIKey is an API key scoped to a set of projects. Only the SHA-256 hash
+// of the key is stored. API keys do not belong to a project so they are
+// not deleted with the projects they are scoped to.
+type APIKey struct {
+	APIKeyID   string
+	Name       string
+	KeyHash    string
+	Role       string
+	ProjectIDs JSONArray
+	CreatedAt  Datetime
+	ModifiedAt Datetime
+}
+
+// AddAPIKey is the input parameters for the InsertAPIKey method.
+type AddAPIKey struct {
+	APIKeyID   string
+	Name       string
+	KeyHash    string
+	Role       string
+	ProjectIDs JSONArray
+}
+
+//
+// settings
+//
+
+type SettingsRepository interface {
+	// GetSetting gets a setting by name.
+	GetSetting(ctx context.Context, name string) (*Setting, error)
+
+	// EnsureSetting inserts a setting unless one of the same name exists,
+	// and returns the stored setting.
+	EnsureSetting(ctx context.Context, name, value string) (*Setting, error)
+}
+
+// Setting is a named value kept by the service for itself rather than for
+// a project.
+type Setting struct {
+	Name       string
+	Value      string
+	CreatedAt  Datetime
+	ModifiedAt Datetime
+}
+
+//
+// template partials
+//
+
+type PartialsRepository interface {
+	// SetPartial creates or replaces a partial template in a group.
+	SetPartial(ctx context.Context, params SetPartial) (*Partial, error)
+
+	// ListPartials lists the partial templates in a group ordered by
+	// name.
+	ListPartials(ctx context.Context, projectID, groupID string) ([]*Partial, error)
+
+	// DeletePartial deletes a partial template from a group.
+	DeletePartial(ctx context.Context, projectID, groupID, name string) error
+}
+
+// Partial is a named template shared by the templates in a group.
+type Partial struct {
+	PartialName string
+	GroupID     string
+	ProjectID   string
+	Txt         string
+	HTML        string
+	CreatedAt   Datetime
+	ModifiedAt  Datetime
+}
+
+// SetPartial is the input parameters for the SetPartial method.
+type SetPartial struct {
+	PartialName string
+	GroupID     string
+	ProjectID   string
+	Txt         string
+	HTML        string
+}
+
+//
+// template variants
+//
+
+type TemplateVariantsRepository interface {
+	// SetTemplateVariant creates or replaces the variant of a template for
+	// a locale.
+	SetTemplateVariant(ctx context.Context, params SetTemplateVariant) (*TemplateVariant, error)
+
+	// ListTemplateVariants lists the variants of a template ordered by
+	// locale.
+	ListTemplateVariants(ctx context.Context, projectID, templateID string) ([]*TemplateVariant, error)
+
+	// DeleteTemplateVariant deletes the variant of a template for a
+	// locale.
+	DeleteTemplateVariant(ctx context.Context, projectID, templateID, locale string) error
+}
+
+// TemplateVariant is the text, HTML and subject of a template in a locale.
+type TemplateVariant struct {
+	TemplateID string
+	ProjectID  string
+	Locale     string
+	Txt        string
+	TxtDigest  string
+	HTML       string
+	HTMLDigest string
+	Subject    string
+	CreatedAt  Datetime
+	ModifiedAt Datetime
+}
+
+// SetTemplateVariant is the input parameters for the SetTemplateVariant
+// method.
+type SetTemplateVariant struct {
+	TemplateID string
+	ProjectID  string
+	Locale     string
+	Txt        string
+	TxtDigest  string
+	HTML       string
+	HTMLDigest string
+	Subject    string
+}
+
+//
+// template fixtures
+//
+
+type TemplateFixturesRepository interface {
+	// SetTemplateFixture creates or replaces the named fixture of a
+	// template.
+	SetTemplateFixture(ctx context.Context, params SetTemplateFixture) (*TemplateFixture, error)
+
+	// GetTemplateFixture retrieves the named fixture of a template.
+	GetTemplateFixture(ctx context.Context, projectID, templateID, name string) (*TemplateFixture, error)
+
+	// ListTemplateFixtures lists the fixtures of a template ordered by
+	// name.
+	ListTemplateFixtures(ctx context.Context, projectID, templateID string) ([]*TemplateFixture, error)
+
+	// DeleteTemplateFixture deletes the named fixture of a template.
+	DeleteTemplateFixture(ctx context.Context, projectID, templateID, name string) error
+}
+
+// TemplateFixture is a named set of sample params of a template.
+type TemplateFixture struct {
+	TemplateID string
+	ProjectID  string
+	Name       string
+	Params     JSONParams
+	CreatedAt  Datetime
+	ModifiedAt Datetime
+}
+
+// SetTemplateFixture is the input parameters for the SetTemplateFixture
+// method.
+type SetTemplateFixture struct {
+	TemplateID string
+	ProjectID  string
+	Name       string
+	Params     JSONParams
+}
+
+//
+// message catalogs
+//
+
+type MessageCatalogsRepository interface {
+	// SetMessageCatalog creates or replaces the message catalog for a
+	// project and locale.
+	SetMessageCatalog(ctx context.Context, params SetMessageCatalog) (*MessageCatalog, error)
+
+	// ListMessageCatalogs lists all the message catalogs for a project.
+	ListMessageCatalogs(ctx context.Context, projectID string) ([]*MessageCatalog, error)
+
+	// DeleteMessageCatalog deletes the message catalog for a project and
+	// locale.
+	DeleteMessageCatalog(ctx context.Context, projectID, locale string) error
+}
+
+// MessageCatalog holds the translated messages for a project and locale.
+type MessageCatalog struct {
+	ProjectID  string
+	Locale     string
+	Messages   string
+	CreatedAt  Datetime
+	ModifiedAt Datetime
+}
+
+// SetMessageCatalog is the input parameters for the SetMessageCatalog
+// method.
+type SetMessageCatalog struct {
+	ProjectID string
+	Locale    string
+	Messages  string
+}
+
+//
+// attachments
+//
+
+type AttachmentsRepository interface {
+	// SetAttachment creates or replaces an attachment.
+	SetAttachment(ctx context.Context, params SetAttachment) (*Attachment, error)
+
+	// GetAttachment gets an attachment including its content.
+	GetAttachment(ctx context.Context, projectID, attachmentID string) (*Attachment, error)
+
+	// ListAttachments lists all the attachments for a project without
+	// their content.
+	ListAttachments(ctx context.Context, projectID string) ([]*Attachment, error)
+
+	// DeleteAttachment deletes an attachment. Templates that reference
+	// the attachment no longer include it.
+	DeleteAttachment(ctx context.Context, projectID, attachmentID string) error
+
+	// SetTemplateAttachments replaces the attachments referenced by a
+	// template. The order of attachmentIDs is preserved.
+	SetTemplateAttachments(ctx context.Context, projectID, templateID string, attachmentIDs []string) error
+
+	// ListTemplateAttachments lists the attachments referenced by a
+	// template, including their content, in the order they were set.
+	ListTemplateAttachments(ctx context.Context, projectID, templateID string) ([]*Attachment, error)
+}
+
+// Attachment is a file stored in the database.
+type Attachment struct {
+	AttachmentID string
+	ProjectID    string
+	Filename     string
+	ContentType  string
+	Content      []byte
+	Size         int
+	Checksum     string
+	CreatedAt    Datetime
+	ModifiedAt   Datetime
+}
+
+// SetAttachment is the input parameters for the SetAttachment method.
+type SetAttachment struct {
+	AttachmentID string
+	ProjectID    string
+	Filename     string
+	ContentType  string
+	Content      []byte
+	Checksum     string
+}
+
+//
+// assets
+//
+
+// asset modes
+const (
+	AssetModeCID = "cid"
+	AssetModeURL = "url"
+)
+
+type AssetsRepository interface {
+	// SetAsset creates or replaces an asset.
+	SetAsset(ctx context.Context, params SetAsset) (*Asset, error)
+
+	// GetAsset gets an asset including its content.
+	GetAsset(ctx context.Context, projectID, assetID string) (*Asset, error)
+
+	// ListAssets lists all the assets for a project without their
+	// content.
+	ListAssets(ctx context.Context, projectID string) ([]*Asset, error)
+
+	// DeleteAsset deletes an asset.
+	DeleteAsset(ctx context.Context, projectID, assetID string) error
+}
+
+// Asset is an image stored in the database.
+type Asset struct {
+	AssetID     string
+	ProjectID   string
+	Filename    string
+	ContentType string
+	Content     []byte
+	Size        int
+	Checksum    string
+	CreatedAt   Datetime
+	ModifiedAt  Datetime
+}
+
+// SetAsset is the input parameters for the SetAsset method.
+type SetAsset struct {
+	AssetID     string
+	ProjectID   string
+	Filename    string
+	ContentType string
+	Content     []byte
+	Checksum    string
+}
+
+//
+// snapshots
+//
+
+type SnapshotRepository interface {
+	// ReadSnapshot reads every record needed to rebuild the store, along
+	// with the queued and sending emails, as of a single point in time.
+	ReadSnapshot(ctx context.Context) (*Snapshot, error)
+
+	// RestoreSnapshot writes a snapshot into an empty store keeping the
+	// original ids and timestamps. Either every record is written or none
+	// are. If the store already holds a project an error of type
+	// ErrStoreNotEmpty is returned.
+	RestoreSnapshot(ctx context.Context, snap *Snapshot) error
+
+	// ReadProjectSnapshot reads a project and the records that belong to
+	// it, without its mail queue, as of a single point in time. If the
+	// project does not exist an error of type ErrProjectNotFound is
+	// returned.
+	ReadProjectSnapshot(ctx context.Context, projectID string) (*Snapshot, error)
+
+	// ImportProjectSnapshot writes a snapshot of projects that are not yet
+	// in the store, keeping the original ids and timestamps. Either every
+	// record is written or none are. If one of the projects already exists
+	// an error of type ErrProjectAlreadyExists is returned.
+	ImportProjectSnapshot(ctx context.Context, snap *Snapshot) error
+}
+
+// Snapshot is the contents of a store.
+type Snapshot struct {
+	Projects            []*Project
+	SMTPTransports      []*SMTPTransport
+	APITransports       []*APITransport
+	Groups              []*Group
+	Templates           []*Template
+	Partials            []*Partial
+	TemplateVariants    []*TemplateVariant
+	TemplateFixtures    []*TemplateFixture
+	SendWindows         []*SendWindow
+	RateLimits          []*RateLimit
+	SenderAllowLists    []*SenderAllowList
+	MessageCatalogs     []*MessageCatalog
+	Attachments         []*Attachment
+	TemplateAttachments []*TemplateAttachment
+	Assets              []*Asset
+	MailQueue           []*MailQueue
+}
+
+// TemplateAttachment is a reference from a template to an attachment.
+type TemplateAttachment struct {
+	ProjectID    string
+	TemplateID   string
+	AttachmentID string
+	Position     int
+}
+
+//
+// maintenance
+//
+
+type MaintenanceRepository interface {
+	// Backup writes a consistent copy of the database to destPath while
+	// the store remains in use, replacing any file already there. If the
+	// store is not backed by a database file an error of type
+	// ErrBackupNotSupported is returned.
+	Backup(ctx context.Context, destPath string) error
+
+	// Checkpoint copies the contents of the write-ahead log, if any, into
+	// the database and truncates the log.
+	Checkpoint(ctx context.Context) error
+
+	// Vacuum rebuilds the database to reclaim the space left by deleted
+	// records.
+	Vacuum(ctx context.Context) error
+}
+
+//
+// schema
+//
+
+// SchemaVersion is the migration state of the database schema.
+type SchemaVersion struct {
+	// Version is the last migration applied, or 0 if none has been.
+	Version uint
+
+	// Dirty is set if the last migration failed part way through. The
+	// schema must be repaired by hand and the version forced before
+	// migrating again.
+	Dirty bool
+
+	// Latest is the version of the newest migration known to the store.
+	Latest uint
+}
+
+type SchemaRepository interface {
+	// SchemaVersion returns the migration state of the schema. If the
+	// store has no schema an error of type ErrMigrationsNotSupported is
+	// returned.
+	SchemaVersion(ctx context.Context) (*SchemaVersion, error)
+
+	// MigrateTo applies the up or down migrations that take the schema to
+	// version, where 0 removes every migration. If the schema is dirty an
+	// error of type ErrSchemaDirty is returned, and if there is no
+	// migration with the version an error of type
+	// ErrSchemaVersionNotFound.
+	MigrateTo(ctx context.Context, version uint) error
+
+	// ForceSchemaVersion records version as the current migration and
+	// clears the dirty flag without running any migrations. If there is
+	// no migration with the version an error of type
+	// ErrSchemaVersionNotFound is returned.
+	ForceSchemaVersion(ctx context.Context, version uint) error
+}
+
+//
+// transport verifications
+//
+
+type TransportVerificationsRepository interface {
+	// SetTransportVerification records the outcome of the latest
+	// verification of a transport, replacing any earlier one. If the
+	// project does not exist an error of type ErrProjectNotFound is
+	// returned.
+	SetTransportVerification(ctx context.Context, params SetTransportVerification) (*TransportVerification, error)
+
+	// ListTransportVerifications lists the latest verification of every
+	// transport of every project, ordered by project id then transport
+	// id.
+	ListTransportVerifications(ctx context.Context) ([]*TransportVerification, error)
+}
+
+// TransportVerification is the outcome of the latest verification of an
+// SMTP or API transport. Error is empty if the verification succeeded.
+type TransportVerification struct {
+	ProjectID   string
+	TransportID string
+	Error       string
+	VerifiedAt  Datetime
+}
+
+// SetTransportVerification is the input parameters for the
+// SetTransportVerification method.
+type SetTransportVerification struct {
+	ProjectID   string
+	TransportID string
+	Error       string
+}
@@ -0,0 +1,190 @@
+package service
+
+import (
+	"context"
+	"net"
+	"net/mail"
+	"strings"
+	"time"
+
+	"github.com/andyfusniak/squishy-mailer-lite/entity"
+	"github.com/pkg/errors"
+)
+
+// MXResolver looks up the mail servers of a domain. *net.Resolver
+// satisfies it.
+type MXResolver interface {
+	LookupMX(ctx context.Context, name string) ([]*net.MX, error)
+	LookupHost(ctx context.Context, host string) ([]string, error)
+}
+
+// WithMXCheck checks that the domain of every recipient and transport
+// address can receive email, using r or net.DefaultResolver if r is nil.
+// A domain without MX records is accepted if it has an address record,
+// as mail is then delivered to the domain itself. Lookups that fail for
+// reasons other than the domain having no records, such as timeouts, do
+// not fail the send. Results are cached for mxCacheTTL.
+func WithMXCheck(r MXResolver) Option {
+	return func(s *Service) {
+		if r == nil {
+			r = net.DefaultResolver
+		}
+		s.mxResolver = r
+	}
+}
+
+// mxCacheTTL is how long the result of an MX check is cached.
+const mxCacheTTL = 10 * time.Minute
+
+type mxResult struct {
+	reason  string
+	expires time.Time
+}
+
+// addressList is a list of addresses given in one field of an email or
+// transport.
+type addressList struct {
+	field string
+	addrs []string
+}
+
+// normaliseRecipients checks the syntax of the To, Cc and Bcc addresses
+// of an email, and their domains if WithMXCheck is used, and replaces
+// them with their normalised form. If any are invalid an error is
+// returned with a code of ErrInvalidAddressCode wrapping an
+// *entity.InvalidAddressesError listing every invalid address.
+func (s *Service) normaliseRecipients(ctx context.Context, params *entity.SendEmailParams) error {
+	lists, err := s.checkAddresses(ctx,
+		addressList{"to", params.To},
+		addressList{"cc", params.Cc},
+		addressList{"bcc", params.Bcc},
+		addressList{"reply_to", params.ReplyTo},
+	)
+	if err != nil {
+		return err
+	}
+	params.To, params.Cc, params.Bcc, params.ReplyTo = lists[0], lists[1], lists[2], lists[3]
+	return nil
+}
+
+// checkTransportAddresses checks the sender and reply-to addresses of a
+// transport in the same way as normaliseRecipients. An empty sender is
+// allowed since some providers fill in their own.
+func (s *Service) checkTransportAddresses(ctx context.Context, from string, replyTo []string) error {
+	var fromList []string
+	if from != "" {
+		fromList = []string{from}
+	}
+	_, err := s.checkAddresses(ctx,
+		addressList{"from", fromList},
+		addressList{"reply_to", replyTo},
+	)
+	return err
+}
+
+// checkAddresses checks and normalises each list of addresses, returning
+// the normalised lists in the same order.
+func (s *Service) checkAddresses(ctx context.Context, lists ...addressList) ([][]string, error) {
+	var invalid []entity.InvalidAddress
+	out := make([][]string, len(lists))
+	for i, l := range lists {
+		if l.addrs == nil {
+			continue
+		}
+		out[i] = make([]string, 0, len(l.addrs))
+		for _, a := range l.addrs {
+			norm, addr, reason := normaliseAddress(a)
+			if reason == "" && s.mxResolver != nil {
+				reason = s.checkMX(ctx, addr)
+			}
+			if reason != "" {
+				invalid = append(invalid, entity.InvalidAddress{Field: l.field, Address: a, Reason: reason})
+				continue
+			}
+			out[i] = append(out[i], norm)
+		}
+	}
+	if len(invalid) > 0 {
+		return nil, entity.NewServiceError(entity.ErrInvalidAddressCode,
+			&entity.InvalidAddressesError{Addresses: invalid})
+	}
+	return out, nil
+}
+
+// normaliseAddress parses an RFC 5322 address, which may have a display
+// name, and returns it with surrounding space removed and its domain in
+// lower case, along with the bare address. If the address is invalid the
+// reason is returned instead.
+func normaliseAddress(a string) (norm, addr, reason string) {
+	parsed, err := mail.ParseAddress(strings.TrimSpace(a))
+	if err != nil {
+		return "", "", strings.TrimPrefix(err.Error(), "mail: ")
+	}
+	local, domain, _ := strings.Cut(parsed.Address, "@")
+	if domain == "" {
+		return "", "", "missing domain"
+	}
+	parsed.Address = local + "@" + strings.ToLower(domain)
+	if parsed.Name == "" {
+		return parsed.Address, parsed.Address, ""
+	}
+	return parsed.String(), parsed.Address, ""
+}
+
+// checkMX returns the reason the domain of addr cannot receive email, or
+// the empty string if it can or the lookup failed.
+func (s *Service) checkMX(ctx context.Context, addr string) string {
+	domain := addr[strings.LastIndex(addr, "@")+1:]
+
+	s.mxMu.Lock()
+	r, ok := s.mxCache[domain]
+	s.mxMu.Unlock()
+	if ok && time.Now().Before(r.expires) {
+		return r.reason
+	}
+
+	reason, err := lookupMailDomain(ctx, s.mxResolver, domain)
+	if err != nil {
+		s.logger.Warn("mx lookup failed", "domain", domain, "error", err)
+		return ""
+	}
+	s.mxMu.Lock()
+	if s.mxCache == nil {
+		s.mxCache = make(map[string]mxResult)
+	}
+	s.mxCache[domain] = mxResult{reason: reason, expires: time.Now().Add(mxCacheTTL)}
+	s.mxMu.Unlock()
+	return reason
+}
+
+// lookupMailDomain returns the reason a domain cannot receive email, or
+// the empty string if it can. An error is returned if the lookup itself
+// failed.
+func lookupMailDomain(ctx context.Context, r MXResolver, domain string) (string, error) {
+	mxs, err := r.LookupMX(ctx, domain)
+	if err == nil && len(mxs) > 0 {
+		// a single "." host is a null MX, RFC 7505
+		if len(mxs) == 1 && (mxs[0].Host == "." || mxs[0].Host == "") {
+			return "domain does not accept email", nil
+		}
+		return "", nil
+	}
+	if err != nil && !isNotFound(err) {
+		return "", errors.Wrapf(err, "[service] LookupMX failed")
+	}
+
+	// with no MX records mail is delivered to the domain itself
+	hosts, err := r.LookupHost(ctx, domain)
+	if err == nil && len(hosts) > 0 {
+		return "", nil
+	}
+	if err != nil && !isNotFound(err) {
+		return "", errors.Wrapf(err, "[service] LookupHost failed")
+	}
+	return "domain has no mail server", nil
+}
+
+func isNotFound(err error) bool {
+	var dnsErr *net.DNSError
+	return errors.As(err, &dnsErr) && dnsErr.IsNotFound
+}
@@ -0,0 +1,118 @@
+package service_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/andyfusniak/squishy-mailer-lite/entity"
+	"github.com/andyfusniak/squishy-mailer-lite/service"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecipientAddressValidation(t *testing.T) {
+	srv := newFakeSMTPServer(t)
+	svc := newTestService(t)
+	setupQueueProject(t, svc, srv)
+	ctx := context.Background()
+
+	params := entity.SendEmailParams{
+		TemplateID:     "t1",
+		ProjectID:      "p1",
+		TransportID:    "tr1",
+		To:             []string{"not an address", " ok@Example.COM "},
+		Cc:             []string{"@example.com"},
+		Subject:        "Welcome",
+		TemplateParams: map[string]string{"name": "Andy"},
+	}
+	_, err := svc.SendEmailAsync(ctx, params)
+	assertServiceErrorCode(t, err, entity.ErrInvalidAddressCode)
+	var aerr *entity.InvalidAddressesError
+	if assert.True(t, errors.As(err, &aerr)) && assert.Len(t, aerr.Addresses, 2) {
+		assert.Equal(t, "to", aerr.Addresses[0].Field)
+		assert.Equal(t, "not an address", aerr.Addresses[0].Address)
+		assert.Equal(t, "cc", aerr.Addresses[1].Field)
+	}
+	err = svc.SendEmail(ctx, params)
+	assertServiceErrorCode(t, err, entity.ErrInvalidAddressCode)
+
+	params.To = []string{" ok@Example.COM ", "Someone <Someone@EXAMPLE.com>"}
+	params.Cc = nil
+	mq, err := svc.SendEmailAsync(ctx, params)
+	if err != nil {
+		t.Fatalf("svc.SendEmailAsync failed: %+v", err)
+	}
+	assert.Equal(t, []string{"ok@example.com", `"Someone" <Someone@example.com>`}, mq.To)
+
+	_, err = svc.CreateSMTPTransport(ctx, entity.CreateSMTPTransport{
+		ID:           "tr2",
+		ProjectID:    "p1",
+		Name:         "Bad",
+		Host:         "localhost",
+		Port:         25,
+		EmailFrom:    "from@example.com",
+		EmailReplyTo: []string{"reply at example.com"},
+	})
+	assertServiceErrorCode(t, err, entity.ErrInvalidAddressCode)
+}
+
+// fakeResolver answers lookups from maps, reporting other domains as not
+// found.
+type fakeResolver struct {
+	mx    map[string][]*net.MX
+	hosts map[string][]string
+	fail  map[string]bool
+}
+
+func (r fakeResolver) LookupMX(_ context.Context, name string) ([]*net.MX, error) {
+	if r.fail[name] {
+		return nil, &net.DNSError{Err: "i/o timeout", Name: name, IsTimeout: true}
+	}
+	if mx, ok := r.mx[name]; ok {
+		return mx, nil
+	}
+	return nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+}
+
+func (r fakeResolver) LookupHost(_ context.Context, host string) ([]string, error) {
+	if addrs, ok := r.hosts[host]; ok {
+		return addrs, nil
+	}
+	return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+}
+
+func TestWithMXCheck(t *testing.T) {
+	srv := newFakeSMTPServer(t)
+	svc := newTestService(t, service.WithMXCheck(fakeResolver{
+		mx: map[string][]*net.MX{
+			"example.com": {{Host: "mx.example.com.", Pref: 10}},
+			"null.test":   {{Host: ".", Pref: 0}},
+		},
+		hosts: map[string][]string{"hostonly.test": {"192.0.2.1"}},
+		fail:  map[string]bool{"slow.test": true},
+	}))
+	setupQueueProject(t, svc, srv)
+	ctx := context.Background()
+
+	params := entity.SendEmailParams{
+		TemplateID:     "t1",
+		ProjectID:      "p1",
+		TransportID:    "tr1",
+		To:             []string{"a@example.com", "b@hostonly.test", "c@slow.test"},
+		Subject:        "Welcome",
+		TemplateParams: map[string]string{"name": "Andy"},
+	}
+	if _, err := svc.SendEmailAsync(ctx, params); err != nil {
+		t.Fatalf("svc.SendEmailAsync failed: %+v", err)
+	}
+
+	params.To = []string{"a@example.com", "d@null.test", "e@nomail.test"}
+	_, err := svc.SendEmailAsync(ctx, params)
+	assertServiceErrorCode(t, err, entity.ErrInvalidAddressCode)
+	var aerr *entity.InvalidAddressesError
+	if assert.True(t, errors.As(err, &aerr)) && assert.Len(t, aerr.Addresses, 2) {
+		assert.Equal(t, "domain does not accept email", aerr.Addresses[0].Reason)
+		assert.Equal(t, "domain has no mail server", aerr.Addresses[1].Reason)
+	}
+}
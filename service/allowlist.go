@@ -0,0 +1,97 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"net/mail"
+	"strings"
+
+	"github.com/andyfusniak/squishy-mailer-lite/entity"
+	"github.com/andyfusniak/squishy-mailer-lite/internal/store"
+	"github.com/pkg/errors"
+)
+
+// SetRecipientDomainAllowList restricts the recipients a project can send
+// to, to addresses within the listed domains, for example only
+// mycompany.com in a staging environment. Emails sent asynchronously to
+// any other domain are placed on the mail queue in the blocked state and
+// are never delivered. Synchronous sends fail with ErrRecipientBlockedCode.
+// An empty list removes the restriction.
+func (s *Service) SetRecipientDomainAllowList(ctx context.Context, projectID string, domains []string) (*entity.Project, error) {
+	normalised := make(store.JSONArray, 0, len(domains))
+	for _, d := range domains {
+		d = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(d), "@"))
+		if d != "" {
+			normalised = append(normalised, d)
+		}
+	}
+
+	obj, err := s.store.SetProjectAllowedRecipientDomains(ctx, projectID, normalised)
+	if err != nil {
+		if serr := serviceErrorFromStore(err); serr != nil {
+			return nil, serr
+		}
+		return nil, errors.Wrapf(err, "[service] store.SetProjectAllowedRecipientDomains failed")
+	}
+	if err := checkProjectScope("project", projectID, obj.ProjectID); err != nil {
+		return nil, err
+	}
+	return projectFromStoreObject(obj), nil
+}
+
+// blockedRecipients returns the recipients whose domain is not on the
+// project's allow-list, or nil if every recipient is allowed.
+func (s *Service) blockedRecipients(ctx context.Context, projectID string, recipients ...[]string) ([]string, error) {
+	project, err := s.store.GetProject(ctx, projectID)
+	if err != nil {
+		if serr := serviceErrorFromStore(err); serr != nil {
+			return nil, serr
+		}
+		return nil, errors.Wrapf(err, "[service] store.GetProject failed")
+	}
+	if err := checkProjectScope("project", projectID, project.ProjectID); err != nil {
+		return nil, err
+	}
+	if len(project.AllowedRecipientDomains) == 0 {
+		return nil, nil
+	}
+
+	allowed := make(map[string]bool, len(project.AllowedRecipientDomains))
+	for _, d := range project.AllowedRecipientDomains {
+		allowed[d] = true
+	}
+
+	var blocked []string
+	for _, list := range recipients {
+		for _, r := range list {
+			if !allowed[recipientDomain(r)] {
+				blocked = append(blocked, r)
+			}
+		}
+	}
+	return blocked, nil
+}
+
+// recipientDomain returns the lower case domain part of an email address,
+// which may include a display name, or the empty string if the address
+// cannot be parsed.
+func recipientDomain(addr string) string {
+	if a, err := mail.ParseAddress(addr); err == nil {
+		addr = a.Address
+	}
+	i := strings.LastIndex(addr, "@")
+	if i < 0 {
+		return ""
+	}
+	return strings.ToLower(addr[i+1:])
+}
+
+// blockedReason returns a description of why the recipients were blocked.
+func blockedReason(blocked []string) string {
+	return fmt.Sprintf("recipients not on the project allow-list: %s", strings.Join(blocked, ", "))
+}
+
+// errRecipientsBlocked returns a ServiceError listing the blocked recipients.
+func errRecipientsBlocked(blocked []string) error {
+	return entity.NewServiceError(entity.ErrRecipientBlockedCode, errors.New(blockedReason(blocked)))
+}
@@ -0,0 +1,99 @@
+package service_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/andyfusniak/squishy-mailer-lite/entity"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecipientDomainAllowList(t *testing.T) {
+	srv := newFakeSMTPServer(t)
+	svc := newTestService(t)
+	setupQueueProject(t, svc, srv)
+
+	ctx := context.Background()
+	project, err := svc.SetRecipientDomainAllowList(ctx, "p1", []string{"@MyCompany.com", " "})
+	if err != nil {
+		t.Fatalf("svc.SetRecipientDomainAllowList failed: %+v", err)
+	}
+	assert.Equal(t, []string{"mycompany.com"}, project.AllowedRecipientDomains)
+
+	// allowed recipients are queued as normal
+	allowed, err := svc.SendEmailAsync(ctx, entity.SendEmailParams{
+		TemplateID:  "t1",
+		ProjectID:   "p1",
+		TransportID: "tr1",
+		To:          []string{"Staff <staff@mycompany.com>"},
+		Subject:     "Allowed",
+	})
+	if err != nil {
+		t.Fatalf("svc.SendEmailAsync failed: %+v", err)
+	}
+	assert.Equal(t, entity.MailStateQueued, allowed.State)
+
+	// any recipient outside the allow-list blocks the email
+	blocked, err := svc.SendEmailAsync(ctx, entity.SendEmailParams{
+		TemplateID:  "t1",
+		ProjectID:   "p1",
+		TransportID: "tr1",
+		To:          []string{"staff@mycompany.com", "customer@gmail.com"},
+		Subject:     "Blocked",
+	})
+	if err != nil {
+		t.Fatalf("svc.SendEmailAsync failed: %+v", err)
+	}
+	assert.Equal(t, entity.MailStateBlocked, blocked.State)
+	assert.Contains(t, blocked.LastError, "customer@gmail.com")
+
+	// only the allowed email is delivered
+	n, err := svc.ProcessMailQueue(ctx)
+	if err != nil {
+		t.Fatalf("svc.ProcessMailQueue failed: %+v", err)
+	}
+	assert.Equal(t, 1, n)
+	if msgs := srv.Messages(); assert.Len(t, msgs, 1) {
+		assert.Equal(t, []string{"staff@mycompany.com"}, msgs[0].To)
+	}
+
+	// synchronous sends fail outright
+	err = svc.SendEmail(ctx, entity.SendEmailParams{
+		TemplateID:  "t1",
+		ProjectID:   "p1",
+		TransportID: "tr1",
+		To:          []string{"customer@gmail.com"},
+		Subject:     "Blocked",
+	})
+	assertServiceErrorCode(t, err, entity.ErrRecipientBlockedCode)
+
+	// Bcc recipients are checked against the allow-list too
+	err = svc.SendEmail(ctx, entity.SendEmailParams{
+		TemplateID:  "t1",
+		ProjectID:   "p1",
+		TransportID: "tr1",
+		To:          []string{"staff@mycompany.com"},
+		Bcc:         []string{"audit@gmail.com"},
+		Subject:     "Blocked",
+	})
+	assertServiceErrorCode(t, err, entity.ErrRecipientBlockedCode)
+
+	// clearing the allow-list allows all domains
+	project, err = svc.SetRecipientDomainAllowList(ctx, "p1", nil)
+	if err != nil {
+		t.Fatalf("svc.SetRecipientDomainAllowList failed: %+v", err)
+	}
+	assert.Empty(t, project.AllowedRecipientDomains)
+	if err := svc.SendEmail(ctx, entity.SendEmailParams{
+		TemplateID:  "t1",
+		ProjectID:   "p1",
+		TransportID: "tr1",
+		To:          []string{"customer@gmail.com"},
+		Subject:     "Allowed",
+	}); err != nil {
+		t.Fatalf("svc.SendEmail failed: %+v", err)
+	}
+
+	_, err = svc.SetRecipientDomainAllowList(ctx, "non-existent-project", nil)
+	assertServiceErrorCode(t, err, entity.ErrProjectNotFoundCode)
+}
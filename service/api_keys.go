@@ -0,0 +1,147 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/andyfusniak/squishy-mailer-lite/entity"
+	"github.com/andyfusniak/squishy-mailer-lite/internal/store"
+	"github.com/pkg/errors"
+)
+
+// apiKeyPrefix starts every API key minted by CreateAPIKey so that keys
+// are easy to recognise in configuration and logs.
+const apiKeyPrefix = "sqm_"
+
+// newAPIKeyID returns a random 128 bit hex encoded API key id.
+func newAPIKeyID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// CreateAPIKey mints a new API key granting params.Role on each of
+// params.ProjectIDs, which must exist. The returned key is the only copy of
+// the secret in APIKey.Key; only its hash is stored. If the role is
+// unknown or no projects are given an error is returned with a code of
+// ErrInvalidAPIKeyCode.
+func (s *Service) CreateAPIKey(ctx context.Context, params entity.CreateAPIKeyParams) (*entity.APIKey, error) {
+	if !params.Role.Valid() {
+		return nil, entity.NewServiceError(entity.ErrInvalidAPIKeyCode,
+			fmt.Errorf("unknown role %q", params.Role))
+	}
+	if len(params.ProjectIDs) == 0 {
+		return nil, entity.NewServiceError(entity.ErrInvalidAPIKeyCode,
+			errors.New("an api key must be scoped to at least one project"))
+	}
+
+	projectIDs := make(store.JSONArray, 0, len(params.ProjectIDs))
+	seen := make(map[string]bool, len(params.ProjectIDs))
+	for _, id := range params.ProjectIDs {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		if _, err := s.GetProject(ctx, id); err != nil {
+			return nil, err
+		}
+		projectIDs = append(projectIDs, id)
+	}
+
+	id, err := newAPIKeyID()
+	if err != nil {
+		return nil, errors.Wrap(err, "[service] newAPIKeyID failed")
+	}
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, errors.Wrap(err, "[service] rand.Read failed")
+	}
+	key := apiKeyPrefix + base64.RawURLEncoding.EncodeToString(secret)
+
+	obj, err := s.store.InsertAPIKey(ctx, store.AddAPIKey{
+		APIKeyID:   id,
+		Name:       params.Name,
+		KeyHash:    hashAPIKey(key),
+		Role:       string(params.Role),
+		ProjectIDs: projectIDs,
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "[service] store.InsertAPIKey failed")
+	}
+	k := apiKeyFromStoreObject(obj)
+	k.Key = key
+	return k, nil
+}
+
+// ListAPIKeys lists the API keys ordered by creation time.
+func (s *Service) ListAPIKeys(ctx context.Context) ([]*entity.APIKey, error) {
+	objs, err := s.store.ListAPIKeys(ctx)
+	if err != nil {
+		return nil, errors.Wrapf(err, "[service] store.ListAPIKeys failed")
+	}
+	keys := make([]*entity.APIKey, 0, len(objs))
+	for _, obj := range objs {
+		keys = append(keys, apiKeyFromStoreObject(obj))
+	}
+	return keys, nil
+}
+
+// RevokeAPIKey deletes an API key so that it is no longer accepted. If
+// there is no such key an error is returned with a code of
+// ErrAPIKeyNotFoundCode.
+func (s *Service) RevokeAPIKey(ctx context.Context, id string) error {
+	if err := s.store.DeleteAPIKey(ctx, id); err != nil {
+		if serr := serviceErrorFromStore(err); serr != nil {
+			return serr
+		}
+		return errors.Wrapf(err, "[service] store.DeleteAPIKey failed")
+	}
+	return nil
+}
+
+// AuthenticateAPIKey gets the API key matching the secret key. If there is
+// no such key, or it has been revoked, an error is returned with a code of
+// ErrAPIKeyNotFoundCode.
+func (s *Service) AuthenticateAPIKey(ctx context.Context, key string) (*entity.APIKey, error) {
+	if !strings.HasPrefix(key, apiKeyPrefix) {
+		return nil, entity.NewServiceError(entity.ErrAPIKeyNotFoundCode,
+			errors.New("not a minted api key"))
+	}
+	obj, err := s.store.GetAPIKeyByHash(ctx, hashAPIKey(key))
+	if err != nil {
+		if serr := serviceErrorFromStore(err); serr != nil {
+			return nil, serr
+		}
+		return nil, errors.Wrapf(err, "[service] store.GetAPIKeyByHash failed")
+	}
+	return apiKeyFromStoreObject(obj), nil
+}
+
+// hashAPIKey returns the hex encoded SHA-256 hash of key. The keys are 256
+// bit random values so a fast unsalted hash is sufficient.
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+func apiKeyFromStoreObject(obj *store.APIKey) *entity.APIKey {
+	projectIDs := []string(obj.ProjectIDs)
+	if projectIDs == nil {
+		projectIDs = []string{}
+	}
+	return &entity.APIKey{
+		ID:         obj.APIKeyID,
+		Name:       obj.Name,
+		Role:       entity.APIKeyRole(obj.Role),
+		ProjectIDs: projectIDs,
+		CreatedAt:  entity.ISOTime(obj.CreatedAt),
+		ModifiedAt: entity.ISOTime(obj.ModifiedAt),
+	}
+}
@@ -0,0 +1,96 @@
+package service_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/andyfusniak/squishy-mailer-lite/entity"
+	"github.com/andyfusniak/squishy-mailer-lite/service"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAPIKeys(t *testing.T) {
+	stores := []struct {
+		name string
+		opts []service.Option
+	}{
+		{"sqlite3", nil},
+		{"memory", []service.Option{service.WithInMemoryStore()}},
+	}
+	for _, tc := range stores {
+		t.Run(tc.name, func(t *testing.T) {
+			svc := newTestService(t, tc.opts...)
+			ctx := context.Background()
+
+			for _, id := range []string{"p1", "p2"} {
+				if _, err := svc.CreateProject(ctx, id, id, ""); err != nil {
+					t.Fatalf("svc.CreateProject failed: %+v", err)
+				}
+			}
+
+			k, err := svc.CreateAPIKey(ctx, entity.CreateAPIKeyParams{
+				Name:       "billing",
+				Role:       entity.APIKeyRoleSender,
+				ProjectIDs: []string{"p1", "p2", "p1"},
+			})
+			if err != nil {
+				t.Fatalf("svc.CreateAPIKey failed: %+v", err)
+			}
+			assert.True(t, strings.HasPrefix(k.Key, "sqm_"))
+			assert.Equal(t, []string{"p1", "p2"}, k.ProjectIDs)
+
+			got, err := svc.AuthenticateAPIKey(ctx, k.Key)
+			if err != nil {
+				t.Fatalf("svc.AuthenticateAPIKey failed: %+v", err)
+			}
+			assert.Equal(t, k.ID, got.ID)
+			assert.Empty(t, got.Key)
+			assert.True(t, got.Allows("p2", entity.APIKeyRoleSender))
+			assert.True(t, got.Allows("p1", entity.APIKeyRoleReadOnly))
+			assert.False(t, got.Allows("p1", entity.APIKeyRoleAdmin))
+			assert.False(t, got.Allows("p3", entity.APIKeyRoleReadOnly))
+
+			list, err := svc.ListAPIKeys(ctx)
+			if err != nil {
+				t.Fatalf("svc.ListAPIKeys failed: %+v", err)
+			}
+			if assert.Len(t, list, 1) {
+				assert.Equal(t, "billing", list[0].Name)
+				assert.Empty(t, list[0].Key)
+			}
+
+			for _, bad := range []string{"", "sqm_wrong", k.Key + "x", strings.TrimPrefix(k.Key, "sqm_")} {
+				_, err := svc.AuthenticateAPIKey(ctx, bad)
+				assertServiceErrorCode(t, err, entity.ErrAPIKeyNotFoundCode)
+			}
+
+			if err := svc.RevokeAPIKey(ctx, k.ID); err != nil {
+				t.Fatalf("svc.RevokeAPIKey failed: %+v", err)
+			}
+			_, err = svc.AuthenticateAPIKey(ctx, k.Key)
+			assertServiceErrorCode(t, err, entity.ErrAPIKeyNotFoundCode)
+			assertServiceErrorCode(t, svc.RevokeAPIKey(ctx, k.ID), entity.ErrAPIKeyNotFoundCode)
+		})
+	}
+}
+
+func TestCreateAPIKeyValidation(t *testing.T) {
+	svc := newTestService(t)
+	ctx := context.Background()
+	if _, err := svc.CreateProject(ctx, "p1", "p1", ""); err != nil {
+		t.Fatalf("svc.CreateProject failed: %+v", err)
+	}
+
+	_, err := svc.CreateAPIKey(ctx, entity.CreateAPIKeyParams{Role: "owner", ProjectIDs: []string{"p1"}})
+	assertServiceErrorCode(t, err, entity.ErrInvalidAPIKeyCode)
+
+	_, err = svc.CreateAPIKey(ctx, entity.CreateAPIKeyParams{Role: entity.APIKeyRoleAdmin})
+	assertServiceErrorCode(t, err, entity.ErrInvalidAPIKeyCode)
+
+	_, err = svc.CreateAPIKey(ctx, entity.CreateAPIKeyParams{
+		Role:       entity.APIKeyRoleAdmin,
+		ProjectIDs: []string{"p1", "missing"},
+	})
+	assertServiceErrorCode(t, err, entity.ErrProjectNotFoundCode)
+}
@@ -0,0 +1,68 @@
+package service_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+// fakeAPIServer is an HTTP server that records the requests it receives
+// and replies with a fixed status code, standing in for an email
+// provider's API.
+type fakeAPIServer struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	status   int
+	requests []fakeAPIRequest
+}
+
+// fakeAPIRequest is a single request received by the fakeAPIServer.
+type fakeAPIRequest struct {
+	Method string
+	Path   string
+	Header http.Header
+	Body   []byte
+}
+
+// newFakeAPIServer starts a fake API server that replies 200 OK until
+// told otherwise. The server is stopped when the test completes.
+func newFakeAPIServer(t *testing.T) *fakeAPIServer {
+	t.Helper()
+
+	s := &fakeAPIServer{status: http.StatusOK}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		s.mu.Lock()
+		s.requests = append(s.requests, fakeAPIRequest{
+			Method: r.Method,
+			Path:   r.URL.Path,
+			Header: r.Header.Clone(),
+			Body:   body,
+		})
+		status := s.status
+		s.mu.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte(`{"message":"fake"}`))
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// SetStatus sets the status code of subsequent replies.
+func (s *fakeAPIServer) SetStatus(status int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.status = status
+}
+
+// Requests returns a copy of the requests received so far.
+func (s *fakeAPIServer) Requests() []fakeAPIRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]fakeAPIRequest(nil), s.requests...)
+}
@@ -0,0 +1,364 @@
+package service
+
+import (
+	"context"
+	"crypto/hmac"
+	"net"
+	"net/url"
+	"strings"
+
+	"github.com/andyfusniak/squishy-mailer-lite/entity"
+	"github.com/andyfusniak/squishy-mailer-lite/internal/email"
+	"github.com/andyfusniak/squishy-mailer-lite/internal/store"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/sesv2"
+	"github.com/pkg/errors"
+)
+
+// Mailgun API transport config keys.
+const (
+	mailgunConfigDomain  = "domain"
+	mailgunConfigBaseURL = "base_url"
+)
+
+// Postmark API transport config keys.
+const (
+	postmarkConfigMessageStream = "message_stream"
+	postmarkConfigBaseURL       = "base_url"
+)
+
+// webhookConfigURL is the webhook API transport config key of the
+// endpoint URL.
+const webhookConfigURL = "url"
+
+// SES API transport config keys. Message tags are stored with the
+// sesConfigTagPrefix followed by the tag name.
+const (
+	sesConfigRegion           = "region"
+	sesConfigCredentials      = "credentials"
+	sesConfigAccessKeyID      = "access_key_id"
+	sesConfigProfile          = "profile"
+	sesConfigConfigurationSet = "configuration_set"
+	sesConfigEndpoint         = "endpoint"
+	sesConfigTagPrefix        = "tag:"
+)
+
+// CreateMailgunTransport creates a transport that sends emails using the
+// Mailgun HTTP API. The API key is encrypted before it is stored in the
+// same way as SMTP transport passwords. Transport ids are unique within a
+// project across every kind of transport.
+func (s *Service) CreateMailgunTransport(ctx context.Context, params entity.CreateMailgunTransport) (*entity.APITransport, error) {
+	cfg := store.JSONObject{mailgunConfigDomain: params.Domain}
+	if params.BaseURL != "" {
+		cfg[mailgunConfigBaseURL] = params.BaseURL
+	}
+	return s.createAPITransport(ctx, store.AddAPITransport{
+		APITransportID: params.ID,
+		ProjectID:      params.ProjectID,
+		TransportName:  params.Name,
+		Provider:       store.APITransportProviderMailgun,
+		Config:         cfg,
+		EmailFrom:      params.EmailFrom,
+		EmailFromName:  params.EmailFromName,
+		EmailReplyTo:   store.JSONArray(params.EmailReplyTo),
+	}, params.APIKey)
+}
+
+// CreatePostmarkTransport creates a transport that sends emails using the
+// Postmark HTTP API. The server token is encrypted before it is stored.
+// Emails are sent on the transport's message stream unless
+// SendEmailParams.MessageStream selects another.
+func (s *Service) CreatePostmarkTransport(ctx context.Context, params entity.CreatePostmarkTransport) (*entity.APITransport, error) {
+	stream := params.MessageStream
+	if stream == "" {
+		stream = email.PostmarkDefaultMessageStream
+	}
+	cfg := store.JSONObject{postmarkConfigMessageStream: stream}
+	if params.BaseURL != "" {
+		cfg[postmarkConfigBaseURL] = params.BaseURL
+	}
+	return s.createAPITransport(ctx, store.AddAPITransport{
+		APITransportID: params.ID,
+		ProjectID:      params.ProjectID,
+		TransportName:  params.Name,
+		Provider:       store.APITransportProviderPostmark,
+		Config:         cfg,
+		EmailFrom:      params.EmailFrom,
+		EmailFromName:  params.EmailFromName,
+		EmailReplyTo:   store.JSONArray(params.EmailReplyTo),
+	}, params.ServerToken)
+}
+
+// CreateWebhookTransport creates a transport that POSTs each rendered
+// email as JSON to an HTTP endpoint, handing off delivery to a relay.
+// Requests are signed with the signing secret, which is encrypted before
+// it is stored. The URL must use https unless its host is a loopback
+// address.
+func (s *Service) CreateWebhookTransport(ctx context.Context, params entity.CreateWebhookTransport) (*entity.APITransport, error) {
+	u, err := url.Parse(params.URL)
+	if err != nil {
+		return nil, entity.NewServiceError(entity.ErrInvalidTransportCode, err)
+	}
+	if u.Scheme != "https" && !(u.Scheme == "http" && isLoopbackHost(u.Hostname())) {
+		return nil, entity.NewServiceError(entity.ErrInvalidTransportCode,
+			errors.Errorf("webhook url %q must use https", params.URL))
+	}
+	if params.SigningSecret == "" {
+		return nil, entity.NewServiceError(entity.ErrInvalidTransportCode,
+			errors.New("webhook signing secret is required"))
+	}
+	return s.createAPITransport(ctx, store.AddAPITransport{
+		APITransportID: params.ID,
+		ProjectID:      params.ProjectID,
+		TransportName:  params.Name,
+		Provider:       store.APITransportProviderWebhook,
+		Config:         store.JSONObject{webhookConfigURL: params.URL},
+		EmailFrom:      params.EmailFrom,
+		EmailFromName:  params.EmailFromName,
+		EmailReplyTo:   store.JSONArray(params.EmailReplyTo),
+	}, params.SigningSecret)
+}
+
+func isLoopbackHost(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// Webhook request headers set by webhook transports and webhook endpoints.
+const (
+	WebhookSignatureHeader = email.WebhookSignatureHeader
+	WebhookTimestampHeader = email.WebhookTimestampHeader
+)
+
+// VerifyWebhookSignature reports whether a request received from a
+// webhook transport, or sent to a webhook endpoint created with
+// CreateWebhook, was signed with the signing secret. timestamp and
+// signature are the values of the WebhookTimestampHeader and
+// WebhookSignatureHeader headers. Receivers should also reject requests
+// whose timestamp is too old.
+func VerifyWebhookSignature(signingSecret, timestamp, signature string, body []byte) bool {
+	sig, ok := strings.CutPrefix(signature, "sha256=")
+	if !ok {
+		return false
+	}
+	expected := email.WebhookSignature([]byte(signingSecret), timestamp, body)
+	return hmac.Equal([]byte(sig), []byte(expected))
+}
+
+// CreateSESTransport creates a transport that sends emails using the AWS
+// SES v2 API. Credentials come from the default AWS credential chain, a
+// named profile, or a static access key whose secret is encrypted before
+// it is stored.
+func (s *Service) CreateSESTransport(ctx context.Context, params entity.CreateSESTransport) (*entity.APITransport, error) {
+	creds := params.Credentials
+	if creds == "" {
+		creds = entity.SESCredentialsDefault
+	}
+	var secret string
+	switch creds {
+	case entity.SESCredentialsDefault:
+	case entity.SESCredentialsStatic:
+		if params.AccessKeyID == "" || params.SecretAccessKey == "" {
+			return nil, entity.NewServiceError(entity.ErrInvalidTransportCode,
+				errors.New("static credentials require an access key id and secret access key"))
+		}
+		secret = params.SecretAccessKey
+	case entity.SESCredentialsProfile:
+		if params.Profile == "" {
+			return nil, entity.NewServiceError(entity.ErrInvalidTransportCode,
+				errors.New("profile credentials require a profile name"))
+		}
+	default:
+		return nil, entity.NewServiceError(entity.ErrInvalidTransportCode,
+			errors.Errorf("unknown ses credentials %q", creds))
+	}
+	if params.Region == "" {
+		return nil, entity.NewServiceError(entity.ErrInvalidTransportCode,
+			errors.New("region is required"))
+	}
+
+	cfg := store.JSONObject{
+		sesConfigRegion:      params.Region,
+		sesConfigCredentials: string(creds),
+	}
+	optional := map[string]string{
+		sesConfigAccessKeyID:      params.AccessKeyID,
+		sesConfigProfile:          params.Profile,
+		sesConfigConfigurationSet: params.ConfigurationSet,
+		sesConfigEndpoint:         params.Endpoint,
+	}
+	for k, v := range optional {
+		if v != "" {
+			cfg[k] = v
+		}
+	}
+	for name, value := range params.Tags {
+		cfg[sesConfigTagPrefix+name] = value
+	}
+
+	return s.createAPITransport(ctx, store.AddAPITransport{
+		APITransportID: params.ID,
+		ProjectID:      params.ProjectID,
+		TransportName:  params.Name,
+		Provider:       store.APITransportProviderSES,
+		Config:         cfg,
+		EmailFrom:      params.EmailFrom,
+		EmailFromName:  params.EmailFromName,
+		EmailReplyTo:   store.JSONArray(params.EmailReplyTo),
+	}, secret)
+}
+
+// createAPITransport encrypts the API key and inserts the transport.
+func (s *Service) createAPITransport(ctx context.Context, params store.AddAPITransport, apiKey string) (*entity.APITransport, error) {
+	if err := s.idPolicy.validate("transport", params.APITransportID); err != nil {
+		return nil, err
+	}
+	if err := s.checkTransportAddresses(ctx, params.EmailFrom, params.EmailReplyTo); err != nil {
+		return nil, err
+	}
+	if err := s.checkTransportIDFree(ctx, params.ProjectID, params.APITransportID); err != nil {
+		return nil, err
+	}
+
+	encryptedAPIKey, err := s.encryptSecret(apiKey)
+	if err != nil {
+		return nil, err
+	}
+	params.EncryptedAPIKey = encryptedAPIKey
+
+	obj, err := s.store.InsertAPITransport(ctx, params)
+	if err != nil {
+		if serr := serviceErrorFromStore(err); serr != nil {
+			return nil, serr
+		}
+		return nil, errors.Wrapf(err, "[service] store.InsertAPITransport failed")
+	}
+	if err := checkProjectScope("transport", params.ProjectID, obj.ProjectID); err != nil {
+		return nil, err
+	}
+	return apiTransportFromStoreObject(obj), nil
+}
+
+// GetAPITransport retrieves an API transport by its id and project id. If
+// the transport is not found an error is returned with a code of
+// ErrTransportNotFoundCode.
+func (s *Service) GetAPITransport(ctx context.Context, transportID, projectID string) (*entity.APITransport, error) {
+	obj, err := s.store.GetAPITransport(ctx, transportID, projectID)
+	if err != nil {
+		if serr := serviceErrorFromStore(err); serr != nil {
+			return nil, serr
+		}
+		return nil, errors.Wrapf(err, "[service] store.GetAPITransport failed")
+	}
+	if err := checkProjectScope("transport", projectID, obj.ProjectID); err != nil {
+		return nil, err
+	}
+	return apiTransportFromStoreObject(obj), nil
+}
+
+// checkTransport returns an error with a code of ErrTransportNotFoundCode
+// if the project has no transport of any kind with the given id.
+func (s *Service) checkTransport(ctx context.Context, transportID, projectID string) error {
+	_, err := s.GetSMTPTransport(ctx, transportID, projectID)
+	if errors.Is(err, store.ErrTransportNotFound) {
+		_, err = s.GetAPITransport(ctx, transportID, projectID)
+	}
+	return err
+}
+
+// transportFrom returns the sender address of an SMTP or API transport. If
+// neither exists an error is returned with a code of
+// ErrTransportNotFoundCode.
+func (s *Service) transportFrom(ctx context.Context, transportID, projectID string) (string, error) {
+	if cfg, ok := s.cache.transport(projectID, transportID); ok {
+		return cfg.EmailFrom, nil
+	}
+	t, err := s.GetSMTPTransport(ctx, transportID, projectID)
+	if err == nil {
+		return t.EmailFrom, nil
+	}
+	if !errors.Is(err, store.ErrTransportNotFound) {
+		return "", err
+	}
+	at, err := s.GetAPITransport(ctx, transportID, projectID)
+	if err != nil {
+		return "", err
+	}
+	return at.EmailFrom, nil
+}
+
+// checkTransportIDFree returns an error with a code of
+// ErrTransportIDInUseCode if the transport id is already used by a
+// transport of a different kind in the project.
+func (s *Service) checkTransportIDFree(ctx context.Context, projectID, transportID string) error {
+	err := s.checkTransport(ctx, transportID, projectID)
+	if err == nil {
+		return entity.NewServiceError(entity.ErrTransportIDInUseCode,
+			errors.Errorf("transport %q already exists", transportID))
+	}
+	if errors.Is(err, store.ErrTransportNotFound) {
+		return nil
+	}
+	return err
+}
+
+// sesSender loads the AWS config for an SES transport and returns an SES
+// v2 sender. The secret of a transport using static credentials is the
+// secret access key.
+func sesSender(ctx context.Context, cfg TransportConfig) (email.Sender, error) {
+	opts := []func(*config.LoadOptions) error{
+		config.WithRegion(cfg.Config[sesConfigRegion]),
+	}
+	switch entity.SESCredentials(cfg.Config[sesConfigCredentials]) {
+	case entity.SESCredentialsStatic:
+		opts = append(opts, config.WithCredentialsProvider(
+			credentials.NewStaticCredentialsProvider(cfg.Config[sesConfigAccessKeyID], cfg.Secret, "")))
+	case entity.SESCredentialsProfile:
+		opts = append(opts, config.WithSharedConfigProfile(cfg.Config[sesConfigProfile]))
+	}
+	awsCfg, err := config.LoadDefaultConfig(ctx, opts...)
+	if err != nil {
+		return nil, errors.Wrapf(err, "[service] config.LoadDefaultConfig failed")
+	}
+
+	client := sesv2.NewFromConfig(awsCfg, func(o *sesv2.Options) {
+		if endpoint := cfg.Config[sesConfigEndpoint]; endpoint != "" {
+			o.BaseEndpoint = aws.String(endpoint)
+		}
+	})
+
+	tags := make(map[string]string)
+	for k, v := range cfg.Config {
+		if name, ok := strings.CutPrefix(k, sesConfigTagPrefix); ok {
+			tags[name] = v
+		}
+	}
+	return email.NewSESv2Transport(email.SESv2Config{
+		Client:           client,
+		From:             cfg.EmailFrom,
+		FromName:         cfg.EmailFromName,
+		ReplyTo:          cfg.EmailReplyTo,
+		ConfigurationSet: cfg.Config[sesConfigConfigurationSet],
+		Tags:             tags,
+	}), nil
+}
+
+func apiTransportFromStoreObject(obj *store.APITransport) *entity.APITransport {
+	return &entity.APITransport{
+		ID:            obj.APITransportID,
+		ProjectID:     obj.ProjectID,
+		Name:          obj.TransportName,
+		Provider:      entity.APITransportProvider(obj.Provider),
+		Config:        obj.Config,
+		EmailFrom:     obj.EmailFrom,
+		EmailFromName: obj.EmailFromName,
+		EmailReplyTo:  obj.EmailReplyTo,
+		CreatedAt:     entity.ISOTime(obj.CreatedAt),
+		ModifiedAt:    entity.ISOTime(obj.ModifiedAt),
+	}
+}
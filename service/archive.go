@@ -0,0 +1,248 @@
+package service
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/andyfusniak/squishy-mailer-lite/internal/store"
+	"github.com/pkg/errors"
+)
+
+// pruneBatchSize is the number of emails archived and deleted together by
+// PruneMailQueue.
+const pruneBatchSize = 500
+
+// MailArchiver keeps the emails pruned from the mail queue outside the
+// database. ArchiveMail is called once for each batch of emails with a
+// name such as mail-queue-20260102T030405.000Z-0001.ndjson.gz and a gzip
+// compressed stream of newline delimited JSON, one object per email. The
+// emails of a batch are only deleted once it returns nil. An uploader for
+// S3 or a similar object store can implement it by putting r under name.
+type MailArchiver interface {
+	ArchiveMail(ctx context.Context, name string, r io.Reader) error
+}
+
+// WithMailArchiver accepts a MailArchiver that PruneMailQueue writes the
+// emails to before deleting them. By default pruned emails are not kept.
+func WithMailArchiver(a MailArchiver) Option {
+	return func(s *Service) {
+		s.archiver = a
+	}
+}
+
+// NewDirArchiver returns a MailArchiver that writes each batch to a file
+// of the same name in dir, creating dir if it does not exist. Files are
+// only readable by their owner as they hold personal data.
+func NewDirArchiver(dir string) MailArchiver {
+	return dirArchiver(dir)
+}
+
+type dirArchiver string
+
+func (d dirArchiver) ArchiveMail(_ context.Context, name string, r io.Reader) error {
+	dir := string(d)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return errors.Wrapf(err, "[service] create directory %s failed", dir)
+	}
+	path := filepath.Join(dir, name)
+	f, err := os.OpenFile(path+".tmp", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return errors.Wrapf(err, "[service] create %s failed", path)
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		os.Remove(path + ".tmp")
+		return errors.Wrapf(err, "[service] write %s failed", path)
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(path + ".tmp")
+		return errors.Wrapf(err, "[service] close %s failed", path)
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return errors.Wrapf(err, "[service] rename %s failed", path)
+	}
+	return nil
+}
+
+// NewWriterArchiver returns a MailArchiver that writes every batch to w.
+// Each batch is a complete gzip member, and a sequence of members is
+// itself a valid gzip stream, so w can be a single file.
+func NewWriterArchiver(w io.Writer) MailArchiver {
+	return &writerArchiver{w: w}
+}
+
+type writerArchiver struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (a *writerArchiver) ArchiveMail(_ context.Context, _ string, r io.Reader) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if _, err := io.Copy(a.w, r); err != nil {
+		return errors.Wrapf(err, "[service] write mail archive failed")
+	}
+	return nil
+}
+
+// archivedMail is the JSON object written to the archive for each email.
+type archivedMail struct {
+	ID              string                  `json:"id"`
+	ProjectID       string                  `json:"project_id"`
+	TemplateID      string                  `json:"template_id"`
+	TransportID     string                  `json:"transport_id"`
+	State           string                  `json:"state"`
+	Metadata        store.MailQueueMetadata `json:"metadata"`
+	Body            store.MailQueueBody     `json:"body"`
+	LastError       string                  `json:"last_error,omitempty"`
+	Attempts        int                     `json:"attempts"`
+	MessageID       string                  `json:"message_id,omitempty"`
+	SentAt          *time.Time              `json:"sent_at,omitempty"`
+	SentTransportID string                  `json:"sent_transport_id,omitempty"`
+	CampaignID      string                  `json:"campaign_id,omitempty"`
+	SendAt          time.Time               `json:"send_at"`
+	CreatedAt       time.Time               `json:"created_at"`
+	ModifiedAt      time.Time               `json:"modified_at"`
+	History         []archivedAttempt       `json:"history,omitempty"`
+}
+
+type archivedAttempt struct {
+	Attempt      int       `json:"attempt"`
+	TransportID  string    `json:"transport_id"`
+	State        string    `json:"state"`
+	Error        string    `json:"error,omitempty"`
+	ResponseCode int       `json:"response_code,omitempty"`
+	Response     string    `json:"response,omitempty"`
+	StartedAt    time.Time `json:"started_at"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// PruneMailQueue deletes the emails that finished delivery, whether sent,
+// failed, blocked, dead lettered, cancelled or bounced, and were last
+// modified before the given time, along with their delivery attempts and
+// open counts. If the service has a MailArchiver the emails are first
+// streamed to it in batches, and a batch the archiver fails to store is
+// not deleted. It returns the number of emails deleted.
+func (s *Service) PruneMailQueue(ctx context.Context, before time.Time) (int, error) {
+	cutoff := store.Datetime(before.UTC())
+	started := time.Now().UTC()
+	var total int
+	for batch := 1; ; batch++ {
+		list, err := s.store.ListExpiredMailQueue(ctx, cutoff, pruneBatchSize)
+		if err != nil {
+			return total, errors.Wrapf(err, "[service] store.ListExpiredMailQueue failed")
+		}
+		if len(list) == 0 {
+			break
+		}
+
+		if s.archiver != nil {
+			name := fmt.Sprintf("mail-queue-%s-%04d.ndjson.gz",
+				started.Format("20060102T150405.000Z"), batch)
+			if err := s.archiveMail(ctx, name, list); err != nil {
+				return total, err
+			}
+		}
+
+		ids := make([]string, 0, len(list))
+		for _, mq := range list {
+			ids = append(ids, mq.MailQueueID)
+		}
+		n, err := s.store.DeleteExpiredMailQueue(ctx, cutoff, ids)
+		if err != nil {
+			return total, errors.Wrapf(err, "[service] store.DeleteExpiredMailQueue failed")
+		}
+		total += n
+		if len(list) < pruneBatchSize {
+			break
+		}
+	}
+	if total > 0 {
+		s.logger.Info("pruned mail queue", "emails", total, "before", before)
+	}
+	return total, nil
+}
+
+// archiveMail streams a batch of emails to the archiver as gzip compressed
+// NDJSON.
+func (s *Service) archiveMail(ctx context.Context, name string, list []*store.MailQueue) error {
+	pr, pw := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		err := s.writeMailArchive(ctx, pw, list)
+		pw.CloseWithError(err)
+		done <- err
+	}()
+
+	err := s.archiver.ArchiveMail(ctx, name, pr)
+	// unblock the writer if the archiver stopped reading early
+	pr.CloseWithError(io.ErrClosedPipe)
+	if werr := <-done; werr != nil && err == nil {
+		err = werr
+	}
+	if err != nil {
+		return errors.Wrapf(err, "[service] archive %s failed", name)
+	}
+	return nil
+}
+
+func (s *Service) writeMailArchive(ctx context.Context, w io.Writer, list []*store.MailQueue) error {
+	zw := gzip.NewWriter(w)
+	enc := json.NewEncoder(zw)
+	for _, mq := range list {
+		attempts, err := s.store.ListMailQueueAttempts(ctx, mq.ProjectID, mq.MailQueueID)
+		if err != nil {
+			return errors.Wrapf(err, "[service] store.ListMailQueueAttempts failed")
+		}
+		if err := enc.Encode(archivedMailFromStoreObject(mq, attempts)); err != nil {
+			return errors.Wrapf(err, "[service] json encode archived mail failed")
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return errors.Wrapf(err, "[service] gzip close failed")
+	}
+	return nil
+}
+
+func archivedMailFromStoreObject(mq *store.MailQueue, attempts []*store.MailQueueAttempt) *archivedMail {
+	a := archivedMail{
+		ID:              mq.MailQueueID,
+		ProjectID:       mq.ProjectID,
+		TemplateID:      mq.TemplateID,
+		TransportID:     mq.TransportID,
+		State:           mq.MState,
+		Metadata:        mq.Metadata,
+		Body:            mq.Body,
+		LastError:       mq.LastError,
+		Attempts:        mq.Attempts,
+		MessageID:       mq.MessageID,
+		SentTransportID: mq.SentTransportID,
+		CampaignID:      mq.CampaignID,
+		SendAt:          time.Time(mq.SendAt),
+		CreatedAt:       time.Time(mq.CreatedAt),
+		ModifiedAt:      time.Time(mq.ModifiedAt),
+	}
+	if sentAt := time.Time(mq.SentAt); !sentAt.IsZero() {
+		a.SentAt = &sentAt
+	}
+	for _, r := range attempts {
+		a.History = append(a.History, archivedAttempt{
+			Attempt:      r.Attempt,
+			TransportID:  r.TransportID,
+			State:        r.MState,
+			Error:        r.Error,
+			ResponseCode: r.ResponseCode,
+			Response:     r.Response,
+			StartedAt:    time.Time(r.StartedAt),
+			CreatedAt:    time.Time(r.CreatedAt),
+		})
+	}
+	return &a
+}
@@ -0,0 +1,118 @@
+package service_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/andyfusniak/squishy-mailer-lite/entity"
+	"github.com/andyfusniak/squishy-mailer-lite/service"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPruneMailQueue(t *testing.T) {
+	stores := []struct {
+		name string
+		opts []service.Option
+	}{
+		{"sqlite3", nil},
+		{"memory", []service.Option{service.WithInMemoryStore()}},
+	}
+	for _, tc := range stores {
+		t.Run(tc.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			srv := newFakeSMTPServer(t)
+			svc := newTestService(t, append(tc.opts,
+				service.WithMailArchiver(service.NewWriterArchiver(&buf)))...)
+			setupQueueProject(t, svc, srv)
+
+			ctx := context.Background()
+			sent := queueTestEmail(t, svc)
+			if _, err := svc.ProcessMailQueue(ctx); err != nil {
+				t.Fatalf("svc.ProcessMailQueue failed: %+v", err)
+			}
+			queued := queueTestEmail(t, svc)
+
+			// nothing finished before the cutoff
+			n, err := svc.PruneMailQueue(ctx, time.Now().Add(-time.Hour))
+			if err != nil {
+				t.Fatalf("svc.PruneMailQueue failed: %+v", err)
+			}
+			assert.Equal(t, 0, n)
+			assert.Zero(t, buf.Len())
+
+			n, err = svc.PruneMailQueue(ctx, time.Now().Add(time.Hour))
+			if err != nil {
+				t.Fatalf("svc.PruneMailQueue failed: %+v", err)
+			}
+			assert.Equal(t, 1, n)
+
+			zr, err := gzip.NewReader(&buf)
+			if err != nil {
+				t.Fatalf("gzip.NewReader failed: %+v", err)
+			}
+			dec := json.NewDecoder(zr)
+			var archived struct {
+				ID      string           `json:"id"`
+				State   entity.MailState `json:"state"`
+				History []struct {
+					State entity.MailState `json:"state"`
+				} `json:"history"`
+			}
+			if err := dec.Decode(&archived); err != nil {
+				t.Fatalf("decode archived mail failed: %+v", err)
+			}
+			assert.Equal(t, sent.ID, archived.ID)
+			assert.Equal(t, entity.MailStateSent, archived.State)
+			if assert.Len(t, archived.History, 1) {
+				assert.Equal(t, entity.MailStateSent, archived.History[0].State)
+			}
+			assert.ErrorIs(t, dec.Decode(&archived), io.EOF)
+
+			_, err = svc.GetMailQueue(ctx, "p1", sent.ID)
+			assertServiceErrorCode(t, err, entity.ErrMailQueueNotFoundCode)
+
+			mq, err := svc.GetMailQueue(ctx, "p1", queued.ID)
+			if err != nil {
+				t.Fatalf("svc.GetMailQueue failed: %+v", err)
+			}
+			assert.Equal(t, entity.MailStateQueued, mq.State)
+		})
+	}
+}
+
+func TestPruneMailQueueDirArchiver(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "archive")
+	srv := newFakeSMTPServer(t)
+	svc := newTestService(t, service.WithMailArchiver(service.NewDirArchiver(dir)))
+	setupQueueProject(t, svc, srv)
+
+	ctx := context.Background()
+	queueTestEmail(t, svc)
+	if _, err := svc.ProcessMailQueue(ctx); err != nil {
+		t.Fatalf("svc.ProcessMailQueue failed: %+v", err)
+	}
+	n, err := svc.PruneMailQueue(ctx, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("svc.PruneMailQueue failed: %+v", err)
+	}
+	assert.Equal(t, 1, n)
+
+	files, err := filepath.Glob(filepath.Join(dir, "mail-queue-*-0001.ndjson.gz"))
+	if err != nil {
+		t.Fatalf("filepath.Glob failed: %+v", err)
+	}
+	if assert.Len(t, files, 1) {
+		fi, err := os.Stat(files[0])
+		if err != nil {
+			t.Fatalf("os.Stat failed: %+v", err)
+		}
+		assert.Equal(t, os.FileMode(0o600), fi.Mode().Perm())
+	}
+}
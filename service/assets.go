@@ -0,0 +1,280 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	htmltemplate "html/template"
+	"mime"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/andyfusniak/squishy-mailer-lite/entity"
+	"github.com/andyfusniak/squishy-mailer-lite/internal/email"
+	"github.com/andyfusniak/squishy-mailer-lite/internal/store"
+	"github.com/pkg/errors"
+)
+
+// SetAsset creates or replaces an image asset. Templates reference assets
+// by id using {{asset "id"}}, or in HTML directly as cid:id, for example
+// <img src="cid:logo">, so an asset can be replaced without changing the
+// templates that use it.
+func (s *Service) SetAsset(ctx context.Context, params entity.SetAssetParams) (*entity.Asset, error) {
+	if err := s.idPolicy.validate("asset", params.ID); err != nil {
+		return nil, err
+	}
+
+	contentType := params.ContentType
+	if contentType == "" {
+		contentType = mime.TypeByExtension(filepath.Ext(params.Filename))
+	}
+	if contentType == "" {
+		contentType = http.DetectContentType(params.Content)
+	}
+
+	obj, err := s.store.SetAsset(ctx, store.SetAsset{
+		AssetID:     params.ID,
+		ProjectID:   params.ProjectID,
+		Filename:    params.Filename,
+		ContentType: contentType,
+		Content:     params.Content,
+		Checksum:    contentDigest(params.Content),
+	})
+	if err != nil {
+		if serr := serviceErrorFromStore(err); serr != nil {
+			return nil, serr
+		}
+		return nil, errors.Wrapf(err, "[service] store.SetAsset failed")
+	}
+	if err := checkProjectScope("asset", params.ProjectID, obj.ProjectID); err != nil {
+		return nil, err
+	}
+	return s.assetFromStoreObject(obj), nil
+}
+
+// GetAsset retrieves an asset including its content.
+func (s *Service) GetAsset(ctx context.Context, projectID, assetID string) (*entity.Asset, error) {
+	obj, err := s.store.GetAsset(ctx, projectID, assetID)
+	if err != nil {
+		if serr := serviceErrorFromStore(err); serr != nil {
+			return nil, serr
+		}
+		return nil, errors.Wrapf(err, "[service] store.GetAsset failed")
+	}
+	if err := checkProjectScope("asset", projectID, obj.ProjectID); err != nil {
+		return nil, err
+	}
+	return s.assetFromStoreObject(obj), nil
+}
+
+// ListAssets lists the assets for a project. The content of the assets is
+// not included.
+func (s *Service) ListAssets(ctx context.Context, projectID string) ([]*entity.Asset, error) {
+	list, err := s.store.ListAssets(ctx, projectID)
+	if err != nil {
+		return nil, errors.Wrapf(err, "[service] store.ListAssets failed")
+	}
+
+	assets := make([]*entity.Asset, 0, len(list))
+	for _, obj := range list {
+		if err := checkProjectScope("asset", projectID, obj.ProjectID); err != nil {
+			return nil, err
+		}
+		assets = append(assets, s.assetFromStoreObject(obj))
+	}
+	return assets, nil
+}
+
+// DeleteAsset deletes an asset. Templates that still reference the asset
+// fail to render.
+func (s *Service) DeleteAsset(ctx context.Context, projectID, assetID string) error {
+	if err := s.store.DeleteAsset(ctx, projectID, assetID); err != nil {
+		if serr := serviceErrorFromStore(err); serr != nil {
+			return serr
+		}
+		return errors.Wrapf(err, "[service] store.DeleteAsset failed")
+	}
+	return nil
+}
+
+// SetTemplateAssetMode sets how the template renders {{asset "id"}} in its
+// HTML. AssetModeCID, the default, embeds each asset as an inline
+// attachment. AssetModeURL links to the hosted copy of the asset and
+// requires the service to be configured using WithAssetBaseURL.
+func (s *Service) SetTemplateAssetMode(ctx context.Context, projectID, templateID string, mode entity.AssetMode) (*entity.Template, error) {
+	switch mode {
+	case entity.AssetModeCID:
+	case entity.AssetModeURL:
+		if s.assetBaseURL == "" {
+			return nil, entity.NewServiceError(entity.ErrInvalidAssetModeCode,
+				errors.New("url asset mode requires an asset base URL use the WithAssetBaseURL option"))
+		}
+	default:
+		return nil, entity.NewServiceError(entity.ErrInvalidAssetModeCode,
+			fmt.Errorf("unknown asset mode %q", mode))
+	}
+
+	obj, err := s.store.SetTemplateAssetMode(ctx, projectID, templateID, string(mode))
+	if err != nil {
+		if serr := serviceErrorFromStore(err); serr != nil {
+			return nil, serr
+		}
+		return nil, errors.Wrapf(err, "[service] store.SetTemplateAssetMode failed")
+	}
+	if err := checkProjectScope("template", projectID, obj.ProjectID); err != nil {
+		return nil, err
+	}
+	s.cache.invalidateTemplate(projectID, templateID, "")
+	return templateFromStoreObject(obj), nil
+}
+
+func (s *Service) assetFromStoreObject(obj *store.Asset) *entity.Asset {
+	var u string
+	if s.assetBaseURL != "" {
+		u = s.assetURL(obj)
+	}
+	return &entity.Asset{
+		ID:          obj.AssetID,
+		ProjectID:   obj.ProjectID,
+		Filename:    obj.Filename,
+		ContentType: obj.ContentType,
+		Content:     obj.Content,
+		Size:        obj.Size,
+		Checksum:    obj.Checksum,
+		ContentID:   assetContentID(obj),
+		URL:         u,
+		CreatedAt:   entity.ISOTime(obj.CreatedAt),
+		ModifiedAt:  entity.ISOTime(obj.ModifiedAt),
+	}
+}
+
+// assetRenderer implements the asset template function for a single
+// render, collecting the assets that must be embedded in the email.
+type assetRenderer struct {
+	s         *Service
+	ctx       context.Context
+	projectID string
+	mode      string
+
+	inline []*store.Asset
+	seen   map[string]bool
+}
+
+func (s *Service) newAssetRenderer(ctx context.Context, projectID, mode string) *assetRenderer {
+	return &assetRenderer{
+		s:         s,
+		ctx:       ctx,
+		projectID: projectID,
+		mode:      mode,
+		seen:      make(map[string]bool),
+	}
+}
+
+func (r *assetRenderer) get(assetID string) (*store.Asset, error) {
+	a, err := r.s.store.GetAsset(r.ctx, r.projectID, assetID)
+	if err != nil {
+		return nil, errors.Wrapf(err, "[service] store.GetAsset failed")
+	}
+	if err := checkProjectScope("asset", r.projectID, a.ProjectID); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// html returns the URL to use for an asset in the HTML body. In cid mode
+// the asset is recorded so that it can be embedded in the email.
+func (r *assetRenderer) html(assetID string) (htmltemplate.URL, error) {
+	a, err := r.get(assetID)
+	if err != nil {
+		return "", err
+	}
+	if r.mode == store.AssetModeURL {
+		return htmltemplate.URL(r.s.assetURL(a)), nil
+	}
+
+	if !r.seen[a.AssetID] {
+		r.seen[a.AssetID] = true
+		r.inline = append(r.inline, a)
+	}
+	return htmltemplate.URL("cid:" + url.PathEscape(assetContentID(a))), nil
+}
+
+// cidRefRe matches a cid: URL naming an asset by id in an attribute or CSS
+// url(), for example src="cid:logo". Content-IDs that already have a
+// domain part, such as those written by the asset function, are matched
+// so that they can be skipped.
+var cidRefRe = regexp.MustCompile(`(["'(]\s*)cid:([a-zA-Z0-9][a-zA-Z0-9._-]*)(@[^"'\s)]*)?`)
+
+// replaceCIDs rewrites the cid:{asset id} references written directly in
+// the rendered HTML, for example <img src="cid:logo">, in the same way as
+// the asset function so that the assets are embedded automatically. If a
+// referenced asset does not exist an error is returned with a code of
+// ErrAssetNotFoundCode. HTML without references is returned as is rather
+// than copied, as a rendered body can run to megabytes.
+func (r *assetRenderer) replaceCIDs(html string) (string, error) {
+	if !strings.Contains(html, "cid:") {
+		return html, nil
+	}
+	var err error
+	out := cidRefRe.ReplaceAllStringFunc(html, func(m string) string {
+		sub := cidRefRe.FindStringSubmatch(m)
+		if err != nil || sub[3] != "" {
+			return m
+		}
+		u, e := r.html(sub[2])
+		if e != nil {
+			var storeErr *store.Error
+			if errors.As(e, &storeErr) && storeErr.Code == store.ErrAssetNotFound {
+				e = entity.NewServiceError(entity.ErrAssetNotFoundCode,
+					fmt.Errorf("asset %q referenced by cid:%s not found", sub[2], sub[2]))
+			}
+			err = e
+			return m
+		}
+		return sub[1] + string(u)
+	})
+	if err != nil {
+		return "", err
+	}
+	return out, nil
+}
+
+// text returns the hosted URL of an asset for the text body, or the
+// asset's filename if no asset base URL is configured since text emails
+// cannot display inline images.
+func (r *assetRenderer) text(assetID string) (string, error) {
+	a, err := r.get(assetID)
+	if err != nil {
+		return "", err
+	}
+	if r.s.assetBaseURL == "" {
+		return a.Filename, nil
+	}
+	return r.s.assetURL(a), nil
+}
+
+// assetURL returns the hosted URL of an asset. The checksum is included so
+// that caches see a new URL when the asset is replaced.
+func (s *Service) assetURL(a *store.Asset) string {
+	return fmt.Sprintf("%s/%s/%s?v=%s", s.assetBaseURL,
+		url.PathEscape(a.ProjectID), url.PathEscape(a.AssetID), url.QueryEscape(a.Checksum))
+}
+
+func assetContentID(a *store.Asset) string {
+	return a.AssetID + "@" + a.ProjectID
+}
+
+func inlineAttachments(list []*store.Asset) []email.Attachment {
+	attachments := make([]email.Attachment, 0, len(list))
+	for _, a := range list {
+		attachments = append(attachments, email.Attachment{
+			Filename:    a.Filename,
+			ContentType: a.ContentType,
+			Content:     a.Content,
+			ContentID:   assetContentID(a),
+		})
+	}
+	return attachments
+}
@@ -0,0 +1,191 @@
+package service_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/andyfusniak/squishy-mailer-lite/entity"
+	"github.com/andyfusniak/squishy-mailer-lite/service"
+	"github.com/stretchr/testify/assert"
+)
+
+// setupAssetTemplate adds asset logo and template t2, which references
+// it, to the project created by setupQueueProject.
+func setupAssetTemplate(t *testing.T, svc *service.Service) {
+	t.Helper()
+
+	ctx := context.Background()
+	if _, err := svc.SetAsset(ctx, entity.SetAssetParams{
+		ID:        "logo",
+		ProjectID: "p1",
+		Filename:  "logo.png",
+		Content:   []byte("\x89PNG\r\n\x1a\n logo"),
+	}); err != nil {
+		t.Fatalf("svc.SetAsset failed: %+v", err)
+	}
+	if _, err := svc.CreateTemplate(ctx, entity.CreateTemplate{
+		ID:        "t2",
+		ProjectID: "p1",
+		GroupID:   "g1",
+		Text:      `{{define "layout"}}Logo: {{asset "logo"}}{{end}}`,
+		HTML:      `{{define "layout"}}<img src="{{asset "logo"}}"><img src="{{asset "logo"}}">{{end}}`,
+	}); err != nil {
+		t.Fatalf("svc.CreateTemplate failed: %+v", err)
+	}
+}
+
+func TestAssetCIDEmbedding(t *testing.T) {
+	srv := newFakeSMTPServer(t)
+	svc := newTestService(t)
+	setupQueueProject(t, svc, srv)
+	setupAssetTemplate(t, svc)
+
+	ctx := context.Background()
+	mq, err := svc.SendEmailAsync(ctx, entity.SendEmailParams{
+		TemplateID:  "t2",
+		ProjectID:   "p1",
+		TransportID: "tr1",
+		To:          []string{"to@example.com"},
+		Subject:     "Logo",
+	})
+	if err != nil {
+		t.Fatalf("svc.SendEmailAsync failed: %+v", err)
+	}
+	assert.Equal(t, `<img src="cid:logo@p1"><img src="cid:logo@p1">`, mq.HTML)
+	assert.Equal(t, "Logo: logo.png", mq.Text)
+
+	n, err := svc.ProcessMailQueue(ctx)
+	if err != nil {
+		t.Fatalf("svc.ProcessMailQueue failed: %+v", err)
+	}
+	assert.Equal(t, 1, n)
+
+	msgs := srv.Messages()
+	if assert.Len(t, msgs, 1) {
+		// the asset is embedded once even though it is used twice
+		assert.Equal(t, 1, strings.Count(msgs[0].Data, "Content-Id: <logo@p1>"))
+		assert.Contains(t, msgs[0].Data, "inline")
+	}
+}
+
+func TestAssetURLMode(t *testing.T) {
+	srv := newFakeSMTPServer(t)
+	svc := newTestService(t, service.WithAssetBaseURL("https://cdn.example.com/assets/"))
+	setupQueueProject(t, svc, srv)
+	setupAssetTemplate(t, svc)
+
+	ctx := context.Background()
+	tmpl, err := svc.SetTemplateAssetMode(ctx, "p1", "t2", entity.AssetModeURL)
+	if err != nil {
+		t.Fatalf("svc.SetTemplateAssetMode failed: %+v", err)
+	}
+	assert.Equal(t, entity.AssetModeURL, tmpl.AssetMode)
+
+	a, err := svc.GetAsset(ctx, "p1", "logo")
+	if err != nil {
+		t.Fatalf("svc.GetAsset failed: %+v", err)
+	}
+	url := "https://cdn.example.com/assets/p1/logo?v=" + a.Checksum
+
+	mq, err := svc.SendEmailAsync(ctx, entity.SendEmailParams{
+		TemplateID:  "t2",
+		ProjectID:   "p1",
+		TransportID: "tr1",
+		To:          []string{"to@example.com"},
+		Subject:     "Logo",
+	})
+	if err != nil {
+		t.Fatalf("svc.SendEmailAsync failed: %+v", err)
+	}
+	assert.Equal(t, `<img src="`+url+`"><img src="`+url+`">`, mq.HTML)
+	assert.Equal(t, "Logo: "+url, mq.Text)
+
+	if _, err := svc.ProcessMailQueue(ctx); err != nil {
+		t.Fatalf("svc.ProcessMailQueue failed: %+v", err)
+	}
+	msgs := srv.Messages()
+	if assert.Len(t, msgs, 1) {
+		assert.NotContains(t, msgs[0].Data, "Content-Id: <logo@p1>")
+	}
+}
+
+func TestSetTemplateAssetModeValidation(t *testing.T) {
+	svc := newTestService(t)
+	setupTwoProjects(t, svc)
+
+	ctx := context.Background()
+	_, err := svc.SetTemplateAssetMode(ctx, "pa", "t1", entity.AssetModeURL)
+	assertServiceErrorCode(t, err, entity.ErrInvalidAssetModeCode)
+
+	_, err = svc.SetTemplateAssetMode(ctx, "pa", "t1", "inline")
+	assertServiceErrorCode(t, err, entity.ErrInvalidAssetModeCode)
+
+	_, err = svc.SetTemplateAssetMode(ctx, "pb", "t1", entity.AssetModeCID)
+	assertServiceErrorCode(t, err, entity.ErrTemplateNotFoundCode)
+}
+
+func TestAssetCIDReferences(t *testing.T) {
+	srv := newFakeSMTPServer(t)
+	svc := newTestService(t, service.WithAssetBaseURL("https://cdn.example.com/assets"))
+	setupQueueProject(t, svc, srv)
+	setupAssetTemplate(t, svc)
+
+	ctx := context.Background()
+	if _, err := svc.CreateTemplate(ctx, entity.CreateTemplate{
+		ID:        "t3",
+		ProjectID: "p1",
+		GroupID:   "g1",
+		Text:      `{{define "layout"}}cid:logo stays as is in text{{end}}`,
+		HTML: `{{define "layout"}}<img src="cid:logo"><div style="background: url('cid:logo')"></div>` +
+			`<img src="{{asset "logo"}}"><img src="cid:other@example.com">{{end}}`,
+	}); err != nil {
+		t.Fatalf("svc.CreateTemplate failed: %+v", err)
+	}
+
+	params := entity.SendEmailParams{
+		TemplateID:  "t3",
+		ProjectID:   "p1",
+		TransportID: "tr1",
+		To:          []string{"to@example.com"},
+		Subject:     "Logo",
+	}
+	mq, err := svc.SendEmailAsync(ctx, params)
+	if err != nil {
+		t.Fatalf("svc.SendEmailAsync failed: %+v", err)
+	}
+	assert.Equal(t, `<img src="cid:logo@p1"><div style="background: url('cid:logo@p1')"></div>`+
+		`<img src="cid:logo@p1"><img src="cid:other@example.com">`, mq.HTML)
+	assert.Equal(t, "cid:logo stays as is in text", mq.Text)
+
+	if _, err := svc.ProcessMailQueue(ctx); err != nil {
+		t.Fatalf("svc.ProcessMailQueue failed: %+v", err)
+	}
+	msgs := srv.Messages()
+	if assert.Len(t, msgs, 1) {
+		assert.Equal(t, 1, strings.Count(msgs[0].Data, "Content-Id: <logo@p1>"))
+	}
+
+	// templates using hosted assets link to them instead
+	if _, err := svc.SetTemplateAssetMode(ctx, "p1", "t3", entity.AssetModeURL); err != nil {
+		t.Fatalf("svc.SetTemplateAssetMode failed: %+v", err)
+	}
+	mq, err = svc.SendEmailAsync(ctx, params)
+	if err != nil {
+		t.Fatalf("svc.SendEmailAsync failed: %+v", err)
+	}
+	assert.Contains(t, mq.HTML, `<img src="https://cdn.example.com/assets/p1/logo?v=`)
+
+	if _, err := svc.CreateTemplate(ctx, entity.CreateTemplate{
+		ID:        "t4",
+		ProjectID: "p1",
+		GroupID:   "g1",
+		Text:      `{{define "layout"}}text{{end}}`,
+		HTML:      `{{define "layout"}}<img src="cid:missing">{{end}}`,
+	}); err != nil {
+		t.Fatalf("svc.CreateTemplate failed: %+v", err)
+	}
+	params.TemplateID = "t4"
+	err = svc.SendEmail(ctx, params)
+	assertServiceErrorCode(t, err, entity.ErrAssetNotFoundCode)
+}
@@ -0,0 +1,223 @@
+package service
+
+import (
+	"context"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/andyfusniak/squishy-mailer-lite/entity"
+	"github.com/andyfusniak/squishy-mailer-lite/internal/email"
+	"github.com/andyfusniak/squishy-mailer-lite/internal/store"
+	"github.com/pkg/errors"
+)
+
+// SetAttachment creates or replaces a stored attachment. Attachments are
+// stored once per project and can be referenced by any number of
+// templates using SetTemplateAttachments.
+func (s *Service) SetAttachment(ctx context.Context, params entity.SetAttachmentParams) (*entity.Attachment, error) {
+	if err := s.idPolicy.validate("attachment", params.ID); err != nil {
+		return nil, err
+	}
+
+	contentType := params.ContentType
+	if contentType == "" {
+		contentType = detectContentType(params.Filename, params.Content)
+	}
+
+	obj, err := s.store.SetAttachment(ctx, store.SetAttachment{
+		AttachmentID: params.ID,
+		ProjectID:    params.ProjectID,
+		Filename:     params.Filename,
+		ContentType:  contentType,
+		Content:      params.Content,
+		Checksum:     contentDigest(params.Content),
+	})
+	if err != nil {
+		if serr := serviceErrorFromStore(err); serr != nil {
+			return nil, serr
+		}
+		return nil, errors.Wrapf(err, "[service] store.SetAttachment failed")
+	}
+	if err := checkProjectScope("attachment", params.ProjectID, obj.ProjectID); err != nil {
+		return nil, err
+	}
+	return attachmentFromStoreObject(obj), nil
+}
+
+// GetAttachment retrieves a stored attachment including its content.
+func (s *Service) GetAttachment(ctx context.Context, projectID, attachmentID string) (*entity.Attachment, error) {
+	obj, err := s.store.GetAttachment(ctx, projectID, attachmentID)
+	if err != nil {
+		if serr := serviceErrorFromStore(err); serr != nil {
+			return nil, serr
+		}
+		return nil, errors.Wrapf(err, "[service] store.GetAttachment failed")
+	}
+	if err := checkProjectScope("attachment", projectID, obj.ProjectID); err != nil {
+		return nil, err
+	}
+	return attachmentFromStoreObject(obj), nil
+}
+
+// ListAttachments lists the stored attachments for a project. The content
+// of the attachments is not included.
+func (s *Service) ListAttachments(ctx context.Context, projectID string) ([]*entity.Attachment, error) {
+	list, err := s.store.ListAttachments(ctx, projectID)
+	if err != nil {
+		return nil, errors.Wrapf(err, "[service] store.ListAttachments failed")
+	}
+
+	attachments := make([]*entity.Attachment, 0, len(list))
+	for _, obj := range list {
+		if err := checkProjectScope("attachment", projectID, obj.ProjectID); err != nil {
+			return nil, err
+		}
+		attachments = append(attachments, attachmentFromStoreObject(obj))
+	}
+	return attachments, nil
+}
+
+// DeleteAttachment deletes a stored attachment. Templates that reference
+// the attachment no longer include it. Emails already on the mail queue
+// that reference it fail to deliver.
+func (s *Service) DeleteAttachment(ctx context.Context, projectID, attachmentID string) error {
+	if err := s.store.DeleteAttachment(ctx, projectID, attachmentID); err != nil {
+		if serr := serviceErrorFromStore(err); serr != nil {
+			return serr
+		}
+		return errors.Wrapf(err, "[service] store.DeleteAttachment failed")
+	}
+	return nil
+}
+
+// SetTemplateAttachments replaces the stored attachments that are always
+// included when sending an email using the template. An empty list
+// removes all of the template's attachments.
+func (s *Service) SetTemplateAttachments(ctx context.Context, projectID, templateID string, attachmentIDs []string) error {
+	if err := s.store.SetTemplateAttachments(ctx, projectID, templateID, attachmentIDs); err != nil {
+		if serr := serviceErrorFromStore(err); serr != nil {
+			return serr
+		}
+		return errors.Wrapf(err, "[service] store.SetTemplateAttachments failed")
+	}
+	return nil
+}
+
+// ListTemplateAttachments lists the stored attachments referenced by a
+// template in the order they are attached.
+func (s *Service) ListTemplateAttachments(ctx context.Context, projectID, templateID string) ([]*entity.Attachment, error) {
+	list, err := s.templateAttachments(ctx, projectID, templateID)
+	if err != nil {
+		return nil, err
+	}
+
+	attachments := make([]*entity.Attachment, 0, len(list))
+	for _, obj := range list {
+		attachments = append(attachments, attachmentFromStoreObject(obj))
+	}
+	return attachments, nil
+}
+
+// templateAttachments returns the stored attachments, including their
+// content, referenced by a template.
+func (s *Service) templateAttachments(ctx context.Context, projectID, templateID string) ([]*store.Attachment, error) {
+	list, err := s.store.ListTemplateAttachments(ctx, projectID, templateID)
+	if err != nil {
+		return nil, errors.Wrapf(err, "[service] store.ListTemplateAttachments failed")
+	}
+	for _, obj := range list {
+		if err := checkProjectScope("attachment", projectID, obj.ProjectID); err != nil {
+			return nil, err
+		}
+	}
+	return list, nil
+}
+
+// detectContentType derives a MIME type from the filename extension, or
+// by sniffing the content if the extension is not recognised.
+func detectContentType(filename string, content []byte) string {
+	if ct := mime.TypeByExtension(filepath.Ext(filename)); ct != "" {
+		return ct
+	}
+	return http.DetectContentType(content)
+}
+
+// loadEmailAttachments reads any attachments given by path and returns
+// the attachments of a single email ready to be sent or queued.
+func loadEmailAttachments(list []entity.EmailAttachment) ([]store.MailQueueAttachment, error) {
+	attachments := make([]store.MailQueueAttachment, 0, len(list))
+	for _, a := range list {
+		filename, content := a.Filename, a.Content
+		if a.Path != "" {
+			if content != nil {
+				return nil, entity.NewServiceError(entity.ErrInvalidAttachmentCode,
+					errors.Errorf("attachment %q has both a path and content", a.Path))
+			}
+			b, err := os.ReadFile(a.Path)
+			if err != nil {
+				return nil, entity.NewServiceError(entity.ErrInvalidAttachmentCode, err)
+			}
+			content = b
+			if filename == "" {
+				filename = filepath.Base(a.Path)
+			}
+		}
+		if filename == "" {
+			return nil, entity.NewServiceError(entity.ErrInvalidAttachmentCode,
+				errors.New("attachment filename is required"))
+		}
+
+		contentType := a.ContentType
+		if contentType == "" {
+			contentType = detectContentType(filename, content)
+		}
+		attachments = append(attachments, store.MailQueueAttachment{
+			Filename:    filename,
+			ContentType: contentType,
+			Content:     content,
+		})
+	}
+	return attachments, nil
+}
+
+// queuedAttachments converts the attachments of a single email to
+// attachments for the email package.
+func queuedAttachments(list []store.MailQueueAttachment) []email.Attachment {
+	attachments := make([]email.Attachment, 0, len(list))
+	for _, a := range list {
+		attachments = append(attachments, email.Attachment{
+			Filename:    a.Filename,
+			ContentType: a.ContentType,
+			Content:     a.Content,
+		})
+	}
+	return attachments
+}
+
+func emailAttachments(list []*store.Attachment) []email.Attachment {
+	attachments := make([]email.Attachment, 0, len(list))
+	for _, a := range list {
+		attachments = append(attachments, email.Attachment{
+			Filename:    a.Filename,
+			ContentType: a.ContentType,
+			Content:     a.Content,
+		})
+	}
+	return attachments
+}
+
+func attachmentFromStoreObject(obj *store.Attachment) *entity.Attachment {
+	return &entity.Attachment{
+		ID:          obj.AttachmentID,
+		ProjectID:   obj.ProjectID,
+		Filename:    obj.Filename,
+		ContentType: obj.ContentType,
+		Content:     obj.Content,
+		Size:        obj.Size,
+		Checksum:    obj.Checksum,
+		CreatedAt:   entity.ISOTime(obj.CreatedAt),
+		ModifiedAt:  entity.ISOTime(obj.ModifiedAt),
+	}
+}
@@ -0,0 +1,151 @@
+package service_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/andyfusniak/squishy-mailer-lite/entity"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTemplateAttachments(t *testing.T) {
+	srv := newFakeSMTPServer(t)
+	svc := newTestService(t)
+	setupQueueProject(t, svc, srv)
+
+	ctx := context.Background()
+	a, err := svc.SetAttachment(ctx, entity.SetAttachmentParams{
+		ID:        "terms",
+		ProjectID: "p1",
+		Filename:  "terms.pdf",
+		Content:   []byte("%PDF-1.4 terms and conditions"),
+	})
+	if err != nil {
+		t.Fatalf("svc.SetAttachment failed: %+v", err)
+	}
+	assert.Equal(t, "application/pdf", a.ContentType)
+	assert.Equal(t, 29, a.Size)
+	assert.NotEmpty(t, a.Checksum)
+
+	if err := svc.SetTemplateAttachments(ctx, "p1", "t1", []string{"terms"}); err != nil {
+		t.Fatalf("svc.SetTemplateAttachments failed: %+v", err)
+	}
+
+	queueTestEmail(t, svc)
+	n, err := svc.ProcessMailQueue(ctx)
+	if err != nil {
+		t.Fatalf("svc.ProcessMailQueue failed: %+v", err)
+	}
+	assert.Equal(t, 1, n)
+
+	msgs := srv.Messages()
+	if assert.Len(t, msgs, 1) {
+		assert.Contains(t, msgs[0].Data, `filename="terms.pdf"`)
+		assert.Contains(t, msgs[0].Data, "Content-Type: application/pdf")
+	}
+
+	// deleting the attachment removes it from the template
+	if err := svc.DeleteAttachment(ctx, "p1", "terms"); err != nil {
+		t.Fatalf("svc.DeleteAttachment failed: %+v", err)
+	}
+	list, err := svc.ListTemplateAttachments(ctx, "p1", "t1")
+	if err != nil {
+		t.Fatalf("svc.ListTemplateAttachments failed: %+v", err)
+	}
+	assert.Empty(t, list)
+}
+
+func TestSetTemplateAttachmentsNotFound(t *testing.T) {
+	svc := newTestService(t)
+	setupTwoProjects(t, svc)
+
+	ctx := context.Background()
+	if _, err := svc.SetAttachment(ctx, entity.SetAttachmentParams{
+		ID:        "logo",
+		ProjectID: "pa",
+		Filename:  "logo.png",
+		Content:   []byte("png"),
+	}); err != nil {
+		t.Fatalf("svc.SetAttachment failed: %+v", err)
+	}
+
+	err := svc.SetTemplateAttachments(ctx, "pa", "t1", []string{"logo", "missing"})
+	assertServiceErrorCode(t, err, entity.ErrAttachmentNotFoundCode)
+
+	// no changes are made when any attachment is missing
+	list, err := svc.ListTemplateAttachments(ctx, "pa", "t1")
+	if err != nil {
+		t.Fatalf("svc.ListTemplateAttachments failed: %+v", err)
+	}
+	assert.Empty(t, list)
+
+	// attachments from another project cannot be referenced
+	err = svc.SetTemplateAttachments(ctx, "pb", "t1", []string{"logo"})
+	assertServiceErrorCode(t, err, entity.ErrTemplateNotFoundCode)
+
+	_, err = svc.GetAttachment(ctx, "pb", "logo")
+	assertServiceErrorCode(t, err, entity.ErrAttachmentNotFoundCode)
+}
+
+func TestSendEmailAttachments(t *testing.T) {
+	srv := newFakeSMTPServer(t)
+	svc := newTestService(t)
+	setupQueueProject(t, svc, srv)
+
+	path := filepath.Join(t.TempDir(), "invoice.pdf")
+	if err := os.WriteFile(path, []byte("%PDF-1.4 invoice"), 0o600); err != nil {
+		t.Fatalf("os.WriteFile failed: %+v", err)
+	}
+
+	ctx := context.Background()
+	params := entity.SendEmailParams{
+		TemplateID:     "t1",
+		ProjectID:      "p1",
+		TransportID:    "tr1",
+		To:             []string{"to@example.com"},
+		Subject:        "Your invoice",
+		TemplateParams: map[string]string{"name": "Andy"},
+		Attachments: []entity.EmailAttachment{
+			{Path: path},
+			{Filename: "notes.txt", Content: []byte("some notes")},
+		},
+	}
+	if _, err := svc.SendEmailAsync(ctx, params); err != nil {
+		t.Fatalf("svc.SendEmailAsync failed: %+v", err)
+	}
+
+	// the file is read when the email is queued
+	if err := os.Remove(path); err != nil {
+		t.Fatalf("os.Remove failed: %+v", err)
+	}
+	n, err := svc.ProcessMailQueue(ctx)
+	if err != nil {
+		t.Fatalf("svc.ProcessMailQueue failed: %+v", err)
+	}
+	assert.Equal(t, 1, n)
+
+	params.Attachments = params.Attachments[1:]
+	if err := svc.SendEmail(ctx, params); err != nil {
+		t.Fatalf("svc.SendEmail failed: %+v", err)
+	}
+
+	msgs := srv.Messages()
+	if assert.Len(t, msgs, 2) {
+		assert.Contains(t, msgs[0].Data, `filename="invoice.pdf"`)
+		assert.Contains(t, msgs[0].Data, "Content-Type: application/pdf")
+		assert.Contains(t, msgs[0].Data, `filename="notes.txt"`)
+		assert.Contains(t, msgs[1].Data, `filename="notes.txt"`)
+		assert.NotContains(t, msgs[1].Data, `filename="invoice.pdf"`)
+	}
+
+	// the file no longer exists
+	params.Attachments = []entity.EmailAttachment{{Path: path}}
+	_, err = svc.SendEmailAsync(ctx, params)
+	assertServiceErrorCode(t, err, entity.ErrInvalidAttachmentCode)
+
+	params.Attachments = []entity.EmailAttachment{{Content: []byte("no filename")}}
+	err = svc.SendEmail(ctx, params)
+	assertServiceErrorCode(t, err, entity.ErrInvalidAttachmentCode)
+}
@@ -0,0 +1,322 @@
+package service
+
+import (
+	"context"
+	"io"
+	"slices"
+
+	"github.com/andyfusniak/squishy-mailer-lite/entity"
+	"github.com/andyfusniak/squishy-mailer-lite/internal/email"
+	"github.com/andyfusniak/squishy-mailer-lite/internal/store"
+	"github.com/nicksnyder/go-i18n/v2/i18n"
+	"github.com/pkg/errors"
+)
+
+// SendEmailBatch sends a batch of emails immediately without using the
+// mail queue. Each template is loaded and parsed once per batch and
+// transports that support it send all of their emails over a single
+// connection. It returns one result per email in the same order as the
+// batch. A failed email does not stop the rest of the batch, but if the
+// context is done the remaining emails fail with the context's error.
+func (s *Service) SendEmailBatch(ctx context.Context, batch []entity.SendEmailParams) []entity.SendEmailBatchResult {
+	c := newSendCache(s, true)
+	defer c.close()
+
+	results := make([]entity.SendEmailBatchResult, len(batch))
+	for i, params := range batch {
+		if err := ctx.Err(); err != nil {
+			results[i].Err = err
+			continue
+		}
+		results[i].Err = s.sendEmail(ctx, params, c)
+	}
+	return results
+}
+
+// SendEmailBatchAsync places a batch of emails on the mail queue. Each
+// template is loaded and parsed once per batch. It returns one result per
+// email in the same order as the batch with the queued email or the
+// reason it could not be queued.
+func (s *Service) SendEmailBatchAsync(ctx context.Context, batch []entity.SendEmailParams) []entity.SendEmailBatchResult {
+	return s.queueBatch(ctx, batch, newSendCache(s, false))
+}
+
+// queueBatch places a batch of emails on the mail queue through c.
+func (s *Service) queueBatch(ctx context.Context, batch []entity.SendEmailParams, c *sendCache) []entity.SendEmailBatchResult {
+	results := make([]entity.SendEmailBatchResult, len(batch))
+	for i, params := range batch {
+		if err := ctx.Err(); err != nil {
+			results[i].Err = err
+			continue
+		}
+		results[i].MailQueue, results[i].Err = s.queueEmail(ctx, params, c)
+	}
+	return results
+}
+
+// SendEmailToMany renders the template separately for each recipient and
+// sends each their own email immediately, so that recipients never see
+// each other's addresses. It is the merge send form of SendEmailBatch and
+// returns one result per recipient in the same order, with the contacts
+// of params.ListID, if set, after the recipients given explicitly. If the
+// contact list cannot be loaded, each explicit recipient's result fails
+// with the error, or a single result does if there are none.
+func (s *Service) SendEmailToMany(ctx context.Context, params entity.SendEmailToManyParams) []entity.SendEmailBatchResult {
+	batch, err := s.personaliseList(ctx, params)
+	if err != nil {
+		return failedBatch(max(len(params.Recipients), 1), err)
+	}
+	return s.SendEmailBatch(ctx, batch)
+}
+
+// SendEmailToManyAsync is like SendEmailToMany but places the emails on
+// the mail queue.
+func (s *Service) SendEmailToManyAsync(ctx context.Context, params entity.SendEmailToManyParams) []entity.SendEmailBatchResult {
+	batch, err := s.personaliseList(ctx, params)
+	if err != nil {
+		return failedBatch(max(len(params.Recipients), 1), err)
+	}
+	return s.SendEmailBatchAsync(ctx, batch)
+}
+
+// SendEmailToManyCSVAsync is like SendEmailToManyAsync but also reads
+// recipients from CSV, one per row after any in params. The first row is
+// a header naming the columns. The email column gives the recipient's
+// address, the optional locale and timezone columns are used as for an
+// EmailRecipient, and every other column is a template param of the same
+// name taking precedence over params.TemplateParams. A row whose email
+// cannot be queued fails on its own. If the CSV is malformed nothing is
+// queued and an error is returned with a code of ErrInvalidContactsCode.
+func (s *Service) SendEmailToManyCSVAsync(ctx context.Context, params entity.SendEmailToManyParams, r io.Reader) ([]entity.SendEmailBatchResult, error) {
+	rows, err := parseContactsCSV(r)
+	if err != nil {
+		return nil, entity.NewServiceError(entity.ErrInvalidContactsCode, err)
+	}
+	params.Recipients = slices.Clip(params.Recipients)
+	for _, c := range rows {
+		params.Recipients = append(params.Recipients, contactRecipient(c))
+	}
+	return s.SendEmailToManyAsync(ctx, params), nil
+}
+
+// TestSend renders the template for each address of a seed list, the
+// contact list params.SeedListID, and places the emails on the mail queue
+// so that the rendering can be checked in each mailbox provider before
+// the template is sent to real recipients. The emails are marked as tests
+// and left out of GetProjectStats. It returns one result per seed address
+// ordered by address. If the seed list does not exist an error is returned
+// with a code of ErrContactListNotFoundCode, and if it has no addresses
+// with a code of ErrInvalidContactsCode.
+func (s *Service) TestSend(ctx context.Context, params entity.TestSendParams) ([]entity.SendEmailBatchResult, error) {
+	seeds, err := s.listRecipients(ctx, params.ProjectID, params.SeedListID)
+	if err != nil {
+		return nil, err
+	}
+	if len(seeds) == 0 {
+		return nil, entity.NewServiceError(entity.ErrInvalidContactsCode,
+			errors.Errorf("seed list %q has no addresses", params.SeedListID))
+	}
+	batch, err := personalise(entity.SendEmailToManyParams{
+		TemplateID:     params.TemplateID,
+		ProjectID:      params.ProjectID,
+		TransportID:    params.TransportID,
+		TemplateParams: params.TemplateParams,
+		Recipients:     seeds,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	c := newSendCache(s, false)
+	c.test = true
+	return s.queueBatch(ctx, batch, c), nil
+}
+
+// personaliseList is personalise with the contacts of params.ListID added
+// to the recipients.
+func (s *Service) personaliseList(ctx context.Context, params entity.SendEmailToManyParams) ([]entity.SendEmailParams, error) {
+	if params.ListID != "" {
+		contacts, err := s.listRecipients(ctx, params.ProjectID, params.ListID)
+		if err != nil {
+			return nil, err
+		}
+		params.Recipients = append(slices.Clip(params.Recipients), contacts...)
+	}
+	return personalise(params)
+}
+
+// failedBatch returns n results that all failed with err.
+func failedBatch(n int, err error) []entity.SendEmailBatchResult {
+	results := make([]entity.SendEmailBatchResult, n)
+	for i := range results {
+		results[i].Err = err
+	}
+	return results
+}
+
+// personalise returns the batch of emails for a SendEmailToMany call, one
+// per recipient.
+func personalise(params entity.SendEmailToManyParams) ([]entity.SendEmailParams, error) {
+	batch := make([]entity.SendEmailParams, 0, len(params.Recipients))
+	for _, r := range params.Recipients {
+		templateParams, err := mergeTemplateParams(params.TemplateParams, r.TemplateParams)
+		if err != nil {
+			return nil, err
+		}
+
+		batch = append(batch, entity.SendEmailParams{
+			TemplateID:     params.TemplateID,
+			ProjectID:      params.ProjectID,
+			TransportID:    params.TransportID,
+			To:             []string{r.Email},
+			Subject:        params.Subject,
+			TemplateParams: templateParams,
+			Timezone:       r.Timezone,
+			Locale:         r.Locale,
+			MessageStream:  params.MessageStream,
+			SendAt:         params.SendAt,
+			Attachments:    params.Attachments,
+			Labels:         params.Labels,
+		})
+	}
+	return batch, nil
+}
+
+type sendCacheKey struct {
+	projectID string
+	id        string
+}
+
+type templateCacheKey struct {
+	projectID  string
+	templateID string
+	locale     string
+}
+
+// sendCache caches the compiled templates, localizers, template
+// attachments and senders used to send emails so that a batch loads each
+// of them once. If reuseConnections is set, senders that support sessions
+// keep their connection open until the cache is closed. Emails queued
+// through the cache are tagged with campaignID, if set, and marked as
+// test sends if test is set.
+type sendCache struct {
+	s                *Service
+	reuseConnections bool
+	campaignID       string
+	test             bool
+
+	templates   map[templateCacheKey]*compiledTemplate
+	localizers  map[sendCacheKey]*i18n.Localizer
+	attachments map[sendCacheKey][]*store.Attachment
+	senders     map[sendCacheKey]email.Sender
+	from        map[sendCacheKey]string
+	variables   map[string]map[string]string
+	sessions    []email.Session
+}
+
+func newSendCache(s *Service, reuseConnections bool) *sendCache {
+	return &sendCache{
+		s:                s,
+		reuseConnections: reuseConnections,
+		templates:        make(map[templateCacheKey]*compiledTemplate),
+		localizers:       make(map[sendCacheKey]*i18n.Localizer),
+		attachments:      make(map[sendCacheKey][]*store.Attachment),
+		senders:          make(map[sendCacheKey]email.Sender),
+		from:             make(map[sendCacheKey]string),
+		variables:        make(map[string]map[string]string),
+	}
+}
+
+// render executes the template using the template params, compiling the
+// template's variant for the locale and loading the localizer for the
+// locale and the project's variables on first use.
+func (c *sendCache) render(ctx context.Context, projectID, templateID, locale string, templateParams any, strict, plain, stream bool) (*renderedEmail, error) {
+	tk := templateCacheKey{projectID, templateID, locale}
+	tmpl, ok := c.templates[tk]
+	if !ok {
+		var err error
+		if tmpl, err = c.s.compileTemplate(ctx, projectID, templateID, locale); err != nil {
+			return nil, err
+		}
+		c.templates[tk] = tmpl
+	}
+
+	lk := sendCacheKey{projectID, locale}
+	localizer, ok := c.localizers[lk]
+	if !ok {
+		var err error
+		if localizer, err = c.s.localizer(ctx, projectID, locale); err != nil {
+			return nil, err
+		}
+		c.localizers[lk] = localizer
+	}
+
+	variables, ok := c.variables[projectID]
+	if !ok {
+		var err error
+		if variables, err = c.s.projectVariables(ctx, projectID); err != nil {
+			return nil, err
+		}
+		c.variables[projectID] = variables
+	}
+	return c.s.executeTemplate(ctx, tmpl, localizer, variables, templateParams, strict || c.s.strictParams,
+		plain || c.s.plainParams, stream)
+}
+
+// templateAttachments returns the attachments referenced by the template.
+func (c *sendCache) templateAttachments(ctx context.Context, projectID, templateID string) ([]*store.Attachment, error) {
+	k := sendCacheKey{projectID, templateID}
+	if list, ok := c.attachments[k]; ok {
+		return list, nil
+	}
+	list, err := c.s.templateAttachments(ctx, projectID, templateID)
+	if err != nil {
+		return nil, err
+	}
+	c.attachments[k] = list
+	return list, nil
+}
+
+// transportFrom returns the from address of the transport.
+func (c *sendCache) transportFrom(ctx context.Context, transportID, projectID string) (string, error) {
+	k := sendCacheKey{projectID, transportID}
+	if from, ok := c.from[k]; ok {
+		return from, nil
+	}
+	from, err := c.s.transportFrom(ctx, transportID, projectID)
+	if err != nil {
+		return "", err
+	}
+	c.from[k] = from
+	return from, nil
+}
+
+// sender returns the sender for the transport.
+func (c *sendCache) sender(ctx context.Context, transportID, projectID string) (email.Sender, error) {
+	k := sendCacheKey{projectID, transportID}
+	if sender, ok := c.senders[k]; ok {
+		return sender, nil
+	}
+	sender, err := c.s.sender(ctx, transportID, projectID)
+	if err != nil {
+		return nil, err
+	}
+	if ss, ok := sender.(email.SessionSender); ok && c.reuseConnections {
+		session, err := ss.OpenSession()
+		if err != nil {
+			return nil, err
+		}
+		c.sessions = append(c.sessions, session)
+		sender = session
+	}
+	c.senders[k] = sender
+	return sender, nil
+}
+
+// close closes the sessions opened by the cache.
+func (c *sendCache) close() {
+	for _, session := range c.sessions {
+		session.Close()
+	}
+	c.sessions = nil
+}
@@ -0,0 +1,245 @@
+package service_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andyfusniak/squishy-mailer-lite/entity"
+	"github.com/andyfusniak/squishy-mailer-lite/service"
+	"github.com/stretchr/testify/assert"
+)
+
+func batchTestParams(to ...string) []entity.SendEmailParams {
+	batch := make([]entity.SendEmailParams, 0, len(to))
+	for _, addr := range to {
+		batch = append(batch, entity.SendEmailParams{
+			TemplateID:     "t1",
+			ProjectID:      "p1",
+			TransportID:    "tr1",
+			To:             []string{addr},
+			Subject:        "Newsletter",
+			TemplateParams: map[string]string{"name": addr},
+		})
+	}
+	return batch
+}
+
+func TestSendEmailBatch(t *testing.T) {
+	srv := newFakeSMTPServer(t)
+	svc := newTestService(t)
+	setupQueueProject(t, svc, srv)
+
+	batch := batchTestParams("a@example.com", "reject@example.com", "b@example.com")
+	batch = append(batch, entity.SendEmailParams{
+		TemplateID:  "missing",
+		ProjectID:   "p1",
+		TransportID: "tr1",
+		To:          []string{"c@example.com"},
+	})
+
+	results := svc.SendEmailBatch(context.Background(), batch)
+	if !assert.Len(t, results, 4) {
+		return
+	}
+	assert.NoError(t, results[0].Err)
+	assert.Error(t, results[1].Err)
+	assert.NoError(t, results[2].Err)
+	assertServiceErrorCode(t, results[3].Err, entity.ErrTemplateNotFoundCode)
+
+	// the rejected recipient does not stop the rest of the batch and all
+	// of the emails are sent over a single connection
+	msgs := srv.Messages()
+	if assert.Len(t, msgs, 2) {
+		assert.Equal(t, []string{"a@example.com"}, msgs[0].To)
+		assert.Contains(t, msgs[0].Data, "Hello a@example.com")
+		assert.Equal(t, []string{"b@example.com"}, msgs[1].To)
+		assert.Contains(t, msgs[1].Data, "Hello b@example.com")
+	}
+	assert.Equal(t, 1, srv.Connections())
+
+	// a cancelled context fails the remaining emails
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	results = svc.SendEmailBatch(ctx, batchTestParams("d@example.com"))
+	assert.ErrorIs(t, results[0].Err, context.Canceled)
+}
+
+func TestSendEmailBatchAsync(t *testing.T) {
+	srv := newFakeSMTPServer(t)
+	svc := newTestService(t)
+	setupQueueProject(t, svc, srv)
+
+	ctx := context.Background()
+	batch := batchTestParams("a@example.com", "b@example.com")
+	batch[1].TransportID = "missing"
+	results := svc.SendEmailBatchAsync(ctx, batch)
+	if !assert.Len(t, results, 2) {
+		return
+	}
+	if assert.NoError(t, results[0].Err) {
+		assert.Equal(t, entity.MailStateQueued, results[0].MailQueue.State)
+	}
+	assertServiceErrorCode(t, results[1].Err, entity.ErrTransportNotFoundCode)
+	assert.Nil(t, results[1].MailQueue)
+
+	n, err := svc.ProcessMailQueue(ctx)
+	if err != nil {
+		t.Fatalf("svc.ProcessMailQueue failed: %+v", err)
+	}
+	assert.Equal(t, 1, n)
+	assert.Len(t, srv.Messages(), 1)
+}
+
+func TestSendEmailToMany(t *testing.T) {
+	srv := newFakeSMTPServer(t)
+	svc := newTestService(t)
+	setupQueueProject(t, svc, srv)
+
+	ctx := context.Background()
+	params := entity.SendEmailToManyParams{
+		TemplateID:     "t1",
+		ProjectID:      "p1",
+		TransportID:    "tr1",
+		Subject:        "Hello",
+		TemplateParams: map[string]string{"name": "friend"},
+		Recipients: []entity.EmailRecipient{
+			{Email: "ann@example.com", TemplateParams: map[string]string{"name": "Ann"}},
+			{Email: "bob@example.com"},
+		},
+	}
+	results := svc.SendEmailToMany(ctx, params)
+	if assert.Len(t, results, 2) {
+		assert.NoError(t, results[0].Err)
+		assert.NoError(t, results[1].Err)
+	}
+
+	// each recipient gets their own email with their own params
+	msgs := srv.Messages()
+	if assert.Len(t, msgs, 2) {
+		assert.Equal(t, []string{"ann@example.com"}, msgs[0].To)
+		assert.Contains(t, msgs[0].Data, "Hello Ann")
+		assert.NotContains(t, msgs[0].Data, "bob@example.com")
+		assert.Equal(t, []string{"bob@example.com"}, msgs[1].To)
+		assert.Contains(t, msgs[1].Data, "Hello friend")
+		assert.NotContains(t, msgs[1].Data, "ann@example.com")
+	}
+
+	results = svc.SendEmailToManyAsync(ctx, params)
+	if assert.Len(t, results, 2) && assert.NoError(t, results[0].Err) {
+		assert.Equal(t, []string{"ann@example.com"}, results[0].MailQueue.To)
+		assert.Equal(t, "Ann", results[0].MailQueue.TemplateParams["name"])
+		assert.Equal(t, "friend", results[1].MailQueue.TemplateParams["name"])
+	}
+}
+
+func TestSendEmailToManyCSVAsync(t *testing.T) {
+	svc := newTestService(t)
+	setupQueueProject(t, svc, newFakeSMTPServer(t))
+
+	ctx := context.Background()
+	params := entity.SendEmailToManyParams{
+		TemplateID:     "t1",
+		ProjectID:      "p1",
+		TransportID:    "tr1",
+		TemplateParams: map[string]string{"name": "friend", "plan": "basic"},
+	}
+	_, err := svc.SendEmailToManyCSVAsync(ctx, params, strings.NewReader("name\nAnn\n"))
+	assertServiceErrorCode(t, err, entity.ErrInvalidContactsCode)
+
+	results, err := svc.SendEmailToManyCSVAsync(ctx, params, strings.NewReader(
+		"email,name,plan\n"+
+			"ann@example.com,Ann,pro\n"+
+			"not-an-address,Bob,basic\n"+
+			"cat@example.com,Cat,\n"))
+	if err != nil {
+		t.Fatalf("svc.SendEmailToManyCSVAsync failed: %+v", err)
+	}
+	if assert.Len(t, results, 3) {
+		if assert.NoError(t, results[0].Err) {
+			assert.Equal(t, []string{"ann@example.com"}, results[0].MailQueue.To)
+			assert.Equal(t, "Ann", results[0].MailQueue.TemplateParams["name"])
+			assert.Equal(t, "pro", results[0].MailQueue.TemplateParams["plan"])
+		}
+		assertServiceErrorCode(t, results[1].Err, entity.ErrInvalidAddressCode)
+		if assert.NoError(t, results[2].Err) {
+			assert.Equal(t, "Cat", results[2].MailQueue.TemplateParams["name"])
+			assert.Equal(t, "", results[2].MailQueue.TemplateParams["plan"])
+		}
+	}
+}
+
+func TestTestSend(t *testing.T) {
+	stores := []struct {
+		name string
+		opts []service.Option
+	}{
+		{"sqlite3", nil},
+		{"memory", []service.Option{service.WithInMemoryStore()}},
+	}
+	for _, st := range stores {
+		t.Run(st.name, func(t *testing.T) {
+			svc := newTestService(t, st.opts...)
+			setupQueueProject(t, svc, newFakeSMTPServer(t))
+			ctx := context.Background()
+
+			if _, err := svc.CreateContactList(ctx, entity.CreateContactListParams{
+				ID:        "seeds",
+				ProjectID: "p1",
+				Name:      "Seeds",
+			}); err != nil {
+				t.Fatalf("svc.CreateContactList failed: %+v", err)
+			}
+			params := entity.TestSendParams{
+				TemplateID:     "t1",
+				ProjectID:      "p1",
+				TransportID:    "tr1",
+				TemplateParams: map[string]string{"name": "friend"},
+				SeedListID:     "seeds",
+			}
+			_, err := svc.TestSend(ctx, params)
+			assertServiceErrorCode(t, err, entity.ErrInvalidContactsCode)
+
+			if _, err := svc.AddContacts(ctx, "p1", "seeds", []entity.Contact{
+				{Email: "gmail-seed@example.com"},
+				{Email: "outlook-seed@example.com", Attributes: map[string]string{"name": "Outlook"}},
+			}); err != nil {
+				t.Fatalf("svc.AddContacts failed: %+v", err)
+			}
+
+			start := time.Now().Add(-time.Minute)
+			queueTestEmail(t, svc)
+			results, err := svc.TestSend(ctx, params)
+			if err != nil {
+				t.Fatalf("svc.TestSend failed: %+v", err)
+			}
+			if assert.Len(t, results, 2) {
+				for _, r := range results {
+					if assert.NoError(t, r.Err) {
+						assert.True(t, r.MailQueue.Test)
+					}
+				}
+				assert.Equal(t, []string{"gmail-seed@example.com"}, results[0].MailQueue.To)
+				assert.Equal(t, "Outlook", results[1].MailQueue.TemplateParams["name"])
+
+				mq, err := svc.GetMailQueue(ctx, "p1", results[0].MailQueue.ID)
+				if err != nil {
+					t.Fatalf("svc.GetMailQueue failed: %+v", err)
+				}
+				assert.True(t, mq.Test)
+			}
+
+			// test sends are left out of the project stats
+			stats, err := svc.GetProjectStats(ctx, "p1", start, time.Time{})
+			if err != nil {
+				t.Fatalf("svc.GetProjectStats failed: %+v", err)
+			}
+			assert.Equal(t, entity.MailCounts{Queued: 1}, stats.Total)
+
+			params.SeedListID = "missing"
+			_, err = svc.TestSend(ctx, params)
+			assertServiceErrorCode(t, err, entity.ErrContactListNotFoundCode)
+		})
+	}
+}
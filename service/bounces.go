@@ -0,0 +1,213 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/andyfusniak/squishy-mailer-lite/entity"
+	"github.com/andyfusniak/squishy-mailer-lite/internal/store"
+	"github.com/pkg/errors"
+)
+
+// snsMessage is the envelope of an Amazon SNS HTTP(S) notification.
+type snsMessage struct {
+	Type         string `json:"Type"`
+	MessageID    string `json:"MessageId"`
+	TopicArn     string `json:"TopicArn"`
+	Message      string `json:"Message"`
+	SubscribeURL string `json:"SubscribeURL"`
+}
+
+// sesNotification is an Amazon SES bounce or complaint notification. SES
+// notifications set NotificationType and SES event publishing sets
+// EventType.
+type sesNotification struct {
+	NotificationType string        `json:"notificationType"`
+	EventType        string        `json:"eventType"`
+	Bounce           *sesBounce    `json:"bounce"`
+	Complaint        *sesComplaint `json:"complaint"`
+	Mail             sesMail       `json:"mail"`
+}
+
+type sesBounce struct {
+	BounceType        string `json:"bounceType"`
+	BounceSubType     string `json:"bounceSubType"`
+	BouncedRecipients []struct {
+		EmailAddress   string `json:"emailAddress"`
+		DiagnosticCode string `json:"diagnosticCode"`
+	} `json:"bouncedRecipients"`
+}
+
+type sesComplaint struct {
+	ComplaintFeedbackType string `json:"complaintFeedbackType"`
+	ComplainedRecipients  []struct {
+		EmailAddress string `json:"emailAddress"`
+	} `json:"complainedRecipients"`
+}
+
+type sesMail struct {
+	CommonHeaders struct {
+		MessageID string `json:"messageId"`
+	} `json:"commonHeaders"`
+	Headers []struct {
+		Name  string `json:"name"`
+		Value string `json:"value"`
+	} `json:"headers"`
+}
+
+// messageID returns the Message-ID header of the original email without
+// the angle brackets, or the empty string if the notification does not
+// include the headers.
+func (m sesMail) messageID() string {
+	id := m.CommonHeaders.MessageID
+	if id == "" {
+		for _, h := range m.Headers {
+			if strings.EqualFold(h.Name, "Message-ID") {
+				id = h.Value
+				break
+			}
+		}
+	}
+	return strings.Trim(strings.TrimSpace(id), "<>")
+}
+
+// HandleSESNotification processes an Amazon SES bounce or complaint
+// notification delivered by Amazon SNS to a project. Both the SNS
+// envelope and raw message delivery are accepted, and SNS subscription
+// confirmations are confirmed.
+//
+// A permanent bounce adds each bounced recipient to the project's
+// suppression list and moves the sent email to the bounced state. A
+// complaint adds each complaining recipient to the suppression list.
+// Transient bounces and other notification types are ignored. Emails are
+// matched using their Message-ID, so the SES identity must be configured
+// to include the original headers in notifications. If the payload cannot
+// be parsed an error is returned with a code of ErrInvalidNotificationCode.
+func (s *Service) HandleSESNotification(ctx context.Context, projectID string, payload []byte) error {
+	var msg snsMessage
+	if err := json.Unmarshal(payload, &msg); err != nil {
+		return entity.NewServiceError(entity.ErrInvalidNotificationCode, err)
+	}
+
+	switch msg.Type {
+	case "SubscriptionConfirmation":
+		return s.confirmSNSSubscription(ctx, msg.SubscribeURL)
+	case "UnsubscribeConfirmation":
+		return nil
+	case "Notification":
+		payload = []byte(msg.Message)
+	case "":
+		// raw message delivery sends the SES notification on its own
+	default:
+		return entity.NewServiceError(entity.ErrInvalidNotificationCode,
+			fmt.Errorf("unknown SNS message type %q", msg.Type))
+	}
+
+	var n sesNotification
+	if err := json.Unmarshal(payload, &n); err != nil {
+		return entity.NewServiceError(entity.ErrInvalidNotificationCode, err)
+	}
+	kind := n.NotificationType
+	if kind == "" {
+		kind = n.EventType
+	}
+	switch kind {
+	case "Bounce":
+		if n.Bounce == nil {
+			return entity.NewServiceError(entity.ErrInvalidNotificationCode,
+				errors.New("bounce notification has no bounce object"))
+		}
+		return s.handleSESBounce(ctx, projectID, n.Mail.messageID(), n.Bounce)
+	case "Complaint":
+		if n.Complaint == nil {
+			return entity.NewServiceError(entity.ErrInvalidNotificationCode,
+				errors.New("complaint notification has no complaint object"))
+		}
+		for _, r := range n.Complaint.ComplainedRecipients {
+			if _, err := s.suppress(ctx, projectID, r.EmailAddress,
+				entity.SuppressionReasonComplaint, n.Complaint.ComplaintFeedbackType); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (s *Service) handleSESBounce(ctx context.Context, projectID, messageID string, b *sesBounce) error {
+	if b.BounceType != "Permanent" {
+		s.logger.Info("ignoring non-permanent bounce",
+			"project_id", projectID, "message_id", messageID, "bounce_type", b.BounceType)
+		return nil
+	}
+
+	var reasons []string
+	for _, r := range b.BouncedRecipients {
+		detail := r.DiagnosticCode
+		if detail == "" {
+			detail = b.BounceSubType
+		}
+		if _, err := s.suppress(ctx, projectID, r.EmailAddress, entity.SuppressionReasonBounce, detail); err != nil {
+			return err
+		}
+		reasons = append(reasons, fmt.Sprintf("%s: %s", r.EmailAddress, detail))
+	}
+	if messageID == "" {
+		return nil
+	}
+
+	mq, err := s.store.GetMailQueueByMessageID(ctx, projectID, messageID)
+	if err != nil {
+		var storeErr *store.Error
+		if errors.As(err, &storeErr) && storeErr.Code == store.ErrMailQueueNotFound {
+			s.logger.Info("bounce does not match a queued email",
+				"project_id", projectID, "message_id", messageID)
+			return nil
+		}
+		return errors.Wrapf(err, "[service] store.GetMailQueueByMessageID failed")
+	}
+	if err := checkProjectScope("mail queue entry", projectID, mq.ProjectID); err != nil {
+		return err
+	}
+
+	// an email that has already been marked as bounced is left alone
+	reason := "permanent bounce: " + strings.Join(reasons, "; ")
+	if _, err := s.MarkMailBounced(ctx, projectID, mq.MailQueueID, reason); err != nil {
+		var serr *entity.ServiceError
+		if errors.As(err, &serr) && serr.Code == entity.ErrMailQueueStateCode {
+			return nil
+		}
+		return err
+	}
+	return nil
+}
+
+// confirmSNSSubscription visits the subscribe URL of an SNS subscription
+// confirmation. Only https URLs on an amazonaws.com host are visited.
+func (s *Service) confirmSNSSubscription(ctx context.Context, subscribeURL string) error {
+	u, err := url.Parse(subscribeURL)
+	if err != nil || u.Scheme != "https" ||
+		!(strings.HasSuffix(u.Hostname(), ".amazonaws.com") || strings.HasSuffix(u.Hostname(), ".amazonaws.com.cn")) {
+		return entity.NewServiceError(entity.ErrInvalidNotificationCode,
+			fmt.Errorf("subscribe URL %q is not an Amazon SNS URL", subscribeURL))
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return errors.Wrapf(err, "[service] http.NewRequestWithContext failed")
+	}
+	resp, err := s.webhookClient.Do(req)
+	if err != nil {
+		return errors.Wrapf(err, "[service] confirm SNS subscription failed")
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return errors.Errorf("[service] confirm SNS subscription returned status %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,191 @@
+package service_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/andyfusniak/squishy-mailer-lite/entity"
+	"github.com/andyfusniak/squishy-mailer-lite/service"
+	"github.com/stretchr/testify/assert"
+)
+
+// snsNotification wraps an SES notification in an SNS envelope.
+func snsNotification(t *testing.T, notification string) []byte {
+	t.Helper()
+
+	b, err := json.Marshal(map[string]string{
+		"Type":      "Notification",
+		"MessageId": "f3a4c0c1-0000-4000-8000-000000000000",
+		"TopicArn":  "arn:aws:sns:eu-west-1:123456789012:ses-bounces",
+		"Message":   notification,
+	})
+	if err != nil {
+		t.Fatalf("json.Marshal failed: %+v", err)
+	}
+	return b
+}
+
+func TestHandleSESNotification(t *testing.T) {
+	stores := []struct {
+		name string
+		opts []service.Option
+	}{
+		{"sqlite3", nil},
+		{"memory", []service.Option{service.WithInMemoryStore()}},
+	}
+	for _, tc := range stores {
+		t.Run(tc.name, func(t *testing.T) {
+			srv := newFakeSMTPServer(t)
+			svc := newTestService(t, tc.opts...)
+			setupQueueProject(t, svc, srv)
+			ctx := context.Background()
+
+			queued := queueTestEmail(t, svc)
+			assert.Equal(t, queued.ID+"@example.com", queued.MessageID)
+			if _, err := svc.ProcessMailQueue(ctx); err != nil {
+				t.Fatalf("svc.ProcessMailQueue failed: %+v", err)
+			}
+			if msgs := srv.Messages(); assert.Len(t, msgs, 1) {
+				assert.Contains(t, msgs[0].Data, "Message-Id: <"+queued.MessageID+">")
+			}
+
+			// transient bounces are ignored
+			err := svc.HandleSESNotification(ctx, "p1", snsNotification(t, fmt.Sprintf(`{
+  "notificationType": "Bounce",
+  "bounce": {"bounceType": "Transient", "bouncedRecipients": [{"emailAddress": "to@example.com"}]},
+  "mail": {"commonHeaders": {"messageId": "<%s>"}}
+}`, queued.MessageID)))
+			if err != nil {
+				t.Fatalf("svc.HandleSESNotification failed: %+v", err)
+			}
+			mq, err := svc.GetMailQueue(ctx, "p1", queued.ID)
+			if err != nil {
+				t.Fatalf("svc.GetMailQueue failed: %+v", err)
+			}
+			assert.Equal(t, entity.MailStateSent, mq.State)
+
+			err = svc.HandleSESNotification(ctx, "p1", snsNotification(t, fmt.Sprintf(`{
+  "notificationType": "Bounce",
+  "bounce": {
+    "bounceType": "Permanent",
+    "bounceSubType": "General",
+    "bouncedRecipients": [{"emailAddress": "To@Example.com", "diagnosticCode": "smtp; 550 5.1.1 user unknown"}]
+  },
+  "mail": {"headers": [{"name": "Message-ID", "value": "<%s>"}]}
+}`, queued.MessageID)))
+			if err != nil {
+				t.Fatalf("svc.HandleSESNotification failed: %+v", err)
+			}
+			mq, err = svc.GetMailQueue(ctx, "p1", queued.ID)
+			if err != nil {
+				t.Fatalf("svc.GetMailQueue failed: %+v", err)
+			}
+			assert.Equal(t, entity.MailStateBounced, mq.State)
+			assert.Contains(t, mq.LastError, "550 5.1.1 user unknown")
+
+			// raw message delivery of an SES event publishing complaint
+			err = svc.HandleSESNotification(ctx, "p1", []byte(`{
+  "eventType": "Complaint",
+  "complaint": {"complaintFeedbackType": "abuse", "complainedRecipients": [{"emailAddress": "other@example.com"}]},
+  "mail": {}
+}`))
+			if err != nil {
+				t.Fatalf("svc.HandleSESNotification failed: %+v", err)
+			}
+
+			list, err := svc.ListSuppressions(ctx, entity.ListSuppressionsParams{ProjectID: "p1"})
+			if err != nil {
+				t.Fatalf("svc.ListSuppressions failed: %+v", err)
+			}
+			if assert.Len(t, list, 2) {
+				assert.Equal(t, "other@example.com", list[0].Email)
+				assert.Equal(t, entity.SuppressionReasonComplaint, list[0].Reason)
+				assert.Equal(t, "abuse", list[0].Detail)
+				assert.Equal(t, "to@example.com", list[1].Email)
+				assert.Equal(t, entity.SuppressionReasonBounce, list[1].Reason)
+			}
+
+			// suppressed recipients are blocked
+			blocked := queueTestEmail(t, svc)
+			assert.Equal(t, entity.MailStateBlocked, blocked.State)
+			assert.Contains(t, blocked.LastError, "suppression list: to@example.com")
+			err = svc.SendEmail(ctx, entity.SendEmailParams{
+				TemplateID:     "t1",
+				ProjectID:      "p1",
+				TransportID:    "tr1",
+				To:             []string{"Someone <TO@example.com>"},
+				TemplateParams: map[string]string{"name": "Andy"},
+			})
+			assertServiceErrorCode(t, err, entity.ErrRecipientSuppressedCode)
+
+			if err := svc.DeleteSuppression(ctx, "p1", "TO@example.com"); err != nil {
+				t.Fatalf("svc.DeleteSuppression failed: %+v", err)
+			}
+			assert.Equal(t, entity.MailStateQueued, queueTestEmail(t, svc).State)
+			err = svc.DeleteSuppression(ctx, "p1", "to@example.com")
+			assertServiceErrorCode(t, err, entity.ErrSuppressionNotFoundCode)
+		})
+	}
+}
+
+func TestSuppressionAppliesToQueuedEmails(t *testing.T) {
+	srv := newFakeSMTPServer(t)
+	svc := newTestService(t)
+	setupQueueProject(t, svc, srv)
+	ctx := context.Background()
+
+	queued := queueTestEmail(t, svc)
+	if _, err := svc.AddSuppression(ctx, entity.AddSuppressionParams{
+		ProjectID: "p1",
+		Email:     "to@example.com",
+	}); err != nil {
+		t.Fatalf("svc.AddSuppression failed: %+v", err)
+	}
+	n, err := svc.ProcessMailQueue(ctx)
+	if err != nil {
+		t.Fatalf("svc.ProcessMailQueue failed: %+v", err)
+	}
+	assert.Equal(t, 0, n)
+	assert.Empty(t, srv.Messages())
+
+	mq, err := svc.GetMailQueue(ctx, "p1", queued.ID)
+	if err != nil {
+		t.Fatalf("svc.GetMailQueue failed: %+v", err)
+	}
+	assert.Equal(t, entity.MailStateBlocked, mq.State)
+}
+
+func TestHandleSESNotificationInvalid(t *testing.T) {
+	srv := newFakeSMTPServer(t)
+	svc := newTestService(t)
+	setupQueueProject(t, svc, srv)
+	ctx := context.Background()
+
+	tests := []struct {
+		name    string
+		payload string
+	}{
+		{"not json", `not json`},
+		{"unknown type", `{"Type": "Unknown"}`},
+		{"bad message", `{"Type": "Notification", "Message": "not json"}`},
+		{"bounce without bounce", `{"notificationType": "Bounce"}`},
+		{"foreign subscribe url", `{"Type": "SubscriptionConfirmation", "SubscribeURL": "https://example.com/confirm"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := svc.HandleSESNotification(ctx, "p1", []byte(tt.payload))
+			assertServiceErrorCode(t, err, entity.ErrInvalidNotificationCode)
+		})
+	}
+
+	_, err := svc.AddSuppression(ctx, entity.AddSuppressionParams{ProjectID: "p1", Email: "not an address"})
+	assertServiceErrorCode(t, err, entity.ErrInvalidSuppressionCode)
+	_, err = svc.AddSuppression(ctx, entity.AddSuppressionParams{
+		ProjectID: "p1",
+		Email:     "a@example.com",
+		Reason:    "unknown",
+	})
+	assertServiceErrorCode(t, err, entity.ErrInvalidSuppressionCode)
+}
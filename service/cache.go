@@ -0,0 +1,279 @@
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"maps"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/andyfusniak/squishy-mailer-lite/internal/store"
+)
+
+// WithCache keeps the compiled templates and transport configs read when
+// sending emails in memory, so that each send does not load them from the
+// store. Entries are dropped when the service changes the template or
+// transport, and SetTemplate keeps them if the template's digests and
+// subject are unchanged. Changes made by another process using the same
+// database, such as sqm, are only seen once an entry is older than ttl. A
+// ttl of zero keeps entries until they are dropped.
+func WithCache(ttl time.Duration) Option {
+	return func(s *Service) {
+		s.cache = &readCache{
+			ttl:        ttl,
+			templates:  make(map[templateCacheKey]cachedTemplate),
+			transports: make(map[sendCacheKey]cachedTransport),
+		}
+	}
+}
+
+// readCache is the cache enabled by WithCache. Its methods may be called
+// on a nil cache, which holds nothing.
+type readCache struct {
+	ttl time.Duration
+
+	mu         sync.Mutex
+	templates  map[templateCacheKey]cachedTemplate
+	transports map[sendCacheKey]cachedTransport
+}
+
+type cachedTemplate struct {
+	tmpl    *compiledTemplate
+	expires time.Time
+}
+
+type cachedTransport struct {
+	cfg     *TransportConfig
+	expires time.Time
+}
+
+// templateVersion identifies the content of a stored template. The store
+// only updates a template if its digests or subject change.
+func templateVersion(t *store.Template) string {
+	return t.TxtDigest + ":" + t.HTMLDigest + ":" + t.Subject
+}
+
+func (c *readCache) expiry() time.Time {
+	if c.ttl <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(c.ttl)
+}
+
+func expired(expires time.Time) bool {
+	return !expires.IsZero() && !time.Now().Before(expires)
+}
+
+// template returns the compiled template of the locale.
+func (c *readCache) template(k templateCacheKey) (*compiledTemplate, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.templates[k]
+	if !ok || expired(e.expires) {
+		return nil, false
+	}
+	return e.tmpl, true
+}
+
+func (c *readCache) putTemplate(k templateCacheKey, tmpl *compiledTemplate) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.templates[k] = cachedTemplate{tmpl: tmpl, expires: c.expiry()}
+}
+
+// transport returns a copy of the transport config.
+func (c *readCache) transport(projectID, transportID string) (*TransportConfig, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.transports[sendCacheKey{projectID, transportID}]
+	if !ok || expired(e.expires) {
+		return nil, false
+	}
+	cfg := *e.cfg
+	cfg.Config = maps.Clone(cfg.Config)
+	return &cfg, true
+}
+
+func (c *readCache) putTransport(cfg *TransportConfig) {
+	if c == nil {
+		return
+	}
+	cp := *cfg
+	cp.Config = maps.Clone(cp.Config)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.transports[sendCacheKey{cfg.ProjectID, cfg.ID}] = cachedTransport{cfg: &cp, expires: c.expiry()}
+}
+
+// invalidateTemplate drops the compiled template in every locale unless it
+// was compiled from a stored template of the given version. An empty
+// version drops them all.
+func (c *readCache) invalidateTemplate(projectID, templateID, version string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for k, e := range c.templates {
+		if k.projectID == projectID && k.templateID == templateID &&
+			(version == "" || e.tmpl.version != version) {
+			delete(c.templates, k)
+		}
+	}
+}
+
+// invalidateTemplates drops the compiled templates of a project, for
+// example when one of its partials changes.
+func (c *readCache) invalidateTemplates(projectID string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for k := range c.templates {
+		if k.projectID == projectID {
+			delete(c.templates, k)
+		}
+	}
+}
+
+func (c *readCache) invalidateTransport(projectID, transportID string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.transports, sendCacheKey{projectID, transportID})
+}
+
+// invalidateProject drops every entry of a project.
+func (c *readCache) invalidateProject(projectID string) {
+	if c == nil {
+		return
+	}
+	c.invalidateTemplates(projectID)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for k := range c.transports {
+		if k.projectID == projectID {
+			delete(c.transports, k)
+		}
+	}
+}
+
+// purge drops every entry.
+func (c *readCache) purge() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	clear(c.templates)
+	clear(c.transports)
+}
+
+// maxParsedVersions is the number of parsed versions of a template kept by
+// parseCache, enough for the template and a few of its locale variants.
+const maxParsedVersions = 8
+
+type parseCacheKey struct {
+	projectID  string
+	templateID string
+	digest     string
+}
+
+type parsedVersion struct {
+	digest string
+	tmpl   *parsedTemplate
+}
+
+// parseCache holds the parsed templates of recent sends so that a template
+// is only parsed again once its content, or that of its partials,
+// changes. Unlike readCache it is always used, as the template is still
+// read from the store to compute its digest. Entries are dropped when the
+// service changes a partial or deletes the template, its group, a partial
+// or the project. The zero value is ready to use.
+type parseCache struct {
+	mu       sync.Mutex
+	versions map[sendCacheKey][]parsedVersion
+}
+
+func (c *parseCache) get(k parseCacheKey) (*parsedTemplate, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, v := range c.versions[sendCacheKey{k.projectID, k.templateID}] {
+		if v.digest == k.digest {
+			return v.tmpl, true
+		}
+	}
+	return nil, false
+}
+
+// put adds a parsed template, dropping the least recently added version
+// of the template if it already has maxParsedVersions.
+func (c *parseCache) put(k parseCacheKey, tmpl *parsedTemplate) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.versions == nil {
+		c.versions = make(map[sendCacheKey][]parsedVersion)
+	}
+	tk := sendCacheKey{k.projectID, k.templateID}
+	list := slices.DeleteFunc(c.versions[tk], func(v parsedVersion) bool {
+		return v.digest == k.digest
+	})
+	if len(list) >= maxParsedVersions {
+		list = list[len(list)-maxParsedVersions+1:]
+	}
+	c.versions[tk] = append(slices.Clip(list), parsedVersion{digest: k.digest, tmpl: tmpl})
+}
+
+// invalidateTemplate drops the parsed versions of a template.
+func (c *parseCache) invalidateTemplate(projectID, templateID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.versions, sendCacheKey{projectID, templateID})
+}
+
+// invalidateTemplates drops the parsed templates of a project, for example
+// when one of its partials changes or the project is deleted.
+func (c *parseCache) invalidateTemplates(projectID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for k := range c.versions {
+		if k.projectID == projectID {
+			delete(c.versions, k)
+		}
+	}
+}
+
+// parseDigest returns a digest of the strings parseTemplate parses. The
+// stored digests are not used as callers of SetTemplate may leave them
+// empty.
+func parseDigest(t *store.Template, partials []*store.Partial) string {
+	h := sha256.New()
+	write := func(v string) {
+		h.Write([]byte(strconv.Itoa(len(v))))
+		h.Write([]byte{':'})
+		h.Write([]byte(v))
+	}
+	write(t.Txt)
+	write(t.HTML)
+	write(t.Subject)
+	for _, p := range partials {
+		write(p.PartialName)
+		write(p.Txt)
+		write(p.HTML)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,299 @@
+package service_test
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/andyfusniak/squishy-mailer-lite/entity"
+	"github.com/andyfusniak/squishy-mailer-lite/internal/store"
+	"github.com/andyfusniak/squishy-mailer-lite/internal/store/memory"
+	"github.com/andyfusniak/squishy-mailer-lite/service"
+	"github.com/stretchr/testify/assert"
+)
+
+// countingStore counts the templates and SMTP transports read from the
+// store.
+type countingStore struct {
+	store.Repository
+	templates  atomic.Int32
+	transports atomic.Int32
+}
+
+func (s *countingStore) GetTemplate(ctx context.Context, projectID, templateID string) (*store.Template, error) {
+	s.templates.Add(1)
+	return s.Repository.GetTemplate(ctx, projectID, templateID)
+}
+
+func (s *countingStore) GetSMTPTransport(ctx context.Context, transportID, projectID string) (*store.SMTPTransport, error) {
+	s.transports.Add(1)
+	return s.Repository.GetSMTPTransport(ctx, transportID, projectID)
+}
+
+func TestWithCache(t *testing.T) {
+	srv := newFakeSMTPServer(t)
+	st := &countingStore{Repository: memory.New()}
+	svc := newTestService(t, service.WithStore(st), service.WithCache(0))
+	setupQueueProject(t, svc, srv)
+
+	ctx := context.Background()
+	send := func() {
+		t.Helper()
+		if err := svc.SendEmail(ctx, entity.SendEmailParams{
+			TemplateID:     "t1",
+			ProjectID:      "p1",
+			TransportID:    "tr1",
+			To:             []string{"to@example.com"},
+			Subject:        "Welcome",
+			TemplateParams: map[string]string{"name": "Andy"},
+		}); err != nil {
+			t.Fatalf("svc.SendEmail failed: %+v", err)
+		}
+	}
+
+	send()
+	templates, transports := st.templates.Load(), st.transports.Load()
+	send()
+	send()
+	assert.Equal(t, templates, st.templates.Load())
+	assert.Equal(t, transports, st.transports.Load())
+
+	setTemplate := func(text string) {
+		t.Helper()
+		if _, err := svc.SetTemplate(ctx, entity.SetTemplateParams{
+			ID:        "t1",
+			ProjectID: "p1",
+			GroupID:   "g1",
+			Text:      text,
+			HTML:      `{{define "layout"}}<p>Hello {{.name}}, this is the HTML body of the email</p>{{end}}`,
+		}); err != nil {
+			t.Fatalf("svc.SetTemplate failed: %+v", err)
+		}
+	}
+	setTemplate(`{{define "layout"}}Hello {{.name}}, this is the text body of the email{{end}}`)
+	send()
+	templates = st.templates.Load()
+
+	// setting the template to its current content keeps it cached
+	setTemplate(`{{define "layout"}}Hello {{.name}}, this is the text body of the email{{end}}`)
+	send()
+	assert.Equal(t, templates, st.templates.Load())
+
+	// changes are seen by the next send
+	setTemplate(`{{define "layout"}}Goodbye {{.name}}{{end}}`)
+	if _, err := svc.UpdateSMTPTransport(ctx, entity.UpdateSMTPTransportParams{
+		TransportID: "tr1",
+		ProjectID:   "p1",
+		Name:        "Transport One",
+		Host:        srv.Host(),
+		Port:        srv.Port(),
+		Username:    "user",
+		EmailFrom:   "updated@example.com",
+	}); err != nil {
+		t.Fatalf("svc.UpdateSMTPTransport failed: %+v", err)
+	}
+	send()
+	assert.Greater(t, st.templates.Load(), templates)
+	msgs := srv.Messages()
+	if assert.Len(t, msgs, 6) {
+		assert.Equal(t, "updated@example.com", msgs[5].From)
+		assert.Contains(t, msgs[5].Data, "Goodbye Andy")
+	}
+
+	if err := svc.DeleteSMTPTransport(ctx, entity.DeleteSMTPTransportParams{
+		TransportID: "tr1",
+		ProjectID:   "p1",
+	}); err != nil {
+		t.Fatalf("svc.DeleteSMTPTransport failed: %+v", err)
+	}
+	err := svc.SendEmail(ctx, entity.SendEmailParams{
+		TemplateID:  "t1",
+		ProjectID:   "p1",
+		TransportID: "tr1",
+		To:          []string{"to@example.com"},
+	})
+	assertServiceErrorCode(t, err, entity.ErrTransportNotFoundCode)
+}
+
+// TestParseCacheDroppedOnDelete checks that the parsed versions of a
+// template are released once the template, a partial of its group or its
+// project is deleted.
+func TestParseCacheDroppedOnDelete(t *testing.T) {
+	const size = 32 << 20
+
+	tests := []struct {
+		name   string
+		delete func(ctx context.Context, svc *service.Service) error
+	}{
+		{"template", func(ctx context.Context, svc *service.Service) error {
+			return svc.DeleteTemplate(ctx, "p1", "t1")
+		}},
+		{"partial", func(ctx context.Context, svc *service.Service) error {
+			return svc.DeletePartial(ctx, "p1", "g1", "footer")
+		}},
+		{"project", func(ctx context.Context, svc *service.Service) error {
+			return svc.DeleteProject(ctx, "p1")
+		}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			svc := newTestService(t, service.WithFileTransportWriter(io.Discard))
+			if _, err := svc.CreateProject(ctx, "p1", "Project One", ""); err != nil {
+				t.Fatalf("svc.CreateProject failed: %+v", err)
+			}
+			if _, err := svc.CreateFileTransport(ctx, entity.CreateFileTransport{
+				ID:        "file",
+				ProjectID: "p1",
+				Name:      "File",
+				EmailFrom: "from@example.com",
+			}); err != nil {
+				t.Fatalf("svc.CreateFileTransport failed: %+v", err)
+			}
+			if _, err := svc.CreateGroup(ctx, "g1", "p1", "Group One"); err != nil {
+				t.Fatalf("svc.CreateGroup failed: %+v", err)
+			}
+			if _, err := svc.SetPartial(ctx, entity.SetPartialParams{
+				Name:      "footer",
+				ProjectID: "p1",
+				GroupID:   "g1",
+				Text:      "The Team",
+			}); err != nil {
+				t.Fatalf("svc.SetPartial failed: %+v", err)
+			}
+
+			before := heapAlloc()
+			setLargeTemplate(t, svc, size)
+			if err := svc.SendEmail(ctx, entity.SendEmailParams{
+				TemplateID:  "t1",
+				ProjectID:   "p1",
+				TransportID: "file",
+				To:          []string{"to@example.com"},
+			}); err != nil {
+				t.Fatalf("svc.SendEmail failed: %+v", err)
+			}
+			if err := tc.delete(ctx, svc); err != nil {
+				t.Fatalf("delete failed: %+v", err)
+			}
+			assert.Less(t, int64(heapAlloc())-int64(before), int64(size/2))
+		})
+	}
+}
+
+// setLargeTemplate sets template t1 to a text body of size bytes. The body
+// is built here so that the test holds no reference to it.
+func setLargeTemplate(t *testing.T, svc *service.Service, size int) {
+	t.Helper()
+	if _, err := svc.SetTemplate(context.Background(), entity.SetTemplateParams{
+		ID:        "t1",
+		ProjectID: "p1",
+		GroupID:   "g1",
+		Text:      strings.Repeat("x", size) + `{{template "footer"}}`,
+		Subject:   "Hello",
+	}); err != nil {
+		t.Fatalf("svc.SetTemplate failed: %+v", err)
+	}
+}
+
+func heapAlloc() uint64 {
+	runtime.GC()
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	return m.HeapAlloc
+}
+
+// BenchmarkSendEmail sends emails through a file transport with a
+// template that is parsed once, and with one whose content changes before
+// every send so that it is parsed each time.
+func BenchmarkSendEmail(b *testing.B) {
+	ctx := context.Background()
+	svc, err := service.NewEmailService(
+		service.WithInMemoryStore(),
+		service.WithHexEncodedEncryptionKey(testEncryptionKey),
+		service.WithFileTransportWriter(io.Discard),
+	)
+	if err != nil {
+		b.Fatalf("service.NewEmailService failed: %+v", err)
+	}
+	b.Cleanup(func() { svc.Close() })
+
+	if _, err := svc.CreateProject(ctx, "p1", "Project One", ""); err != nil {
+		b.Fatalf("svc.CreateProject failed: %+v", err)
+	}
+	if _, err := svc.CreateFileTransport(ctx, entity.CreateFileTransport{
+		ID:        "file",
+		ProjectID: "p1",
+		Name:      "File",
+		EmailFrom: "from@example.com",
+	}); err != nil {
+		b.Fatalf("svc.CreateFileTransport failed: %+v", err)
+	}
+	if _, err := svc.CreateGroup(ctx, "g1", "p1", "Group One"); err != nil {
+		b.Fatalf("svc.CreateGroup failed: %+v", err)
+	}
+	if _, err := svc.SetPartial(ctx, entity.SetPartialParams{
+		Name:      "footer",
+		ProjectID: "p1",
+		GroupID:   "g1",
+		Text:      "The Team\nexample.com",
+		HTML:      `<footer><p>The Team</p><p><a href="https://example.com">example.com</a></p></footer>`,
+	}); err != nil {
+		b.Fatalf("svc.SetPartial failed: %+v", err)
+	}
+
+	text := `{{define "layout"}}Hello {{.name}},
+{{range .items}}- {{.}}
+{{end}}
+{{template "footer"}}%s{{end}}`
+	html := `{{define "layout"}}<html><body><h1>Hello {{.name}}</h1><ul>
+{{range .items}}<li>{{.}}</li>
+{{end}}</ul>{{template "footer"}}</body></html>%s{{end}}`
+	setTemplate := func(b *testing.B, comment string) {
+		if _, err := svc.SetTemplate(ctx, entity.SetTemplateParams{
+			ID:        "t1",
+			ProjectID: "p1",
+			GroupID:   "g1",
+			Text:      fmt.Sprintf(text, comment),
+			HTML:      fmt.Sprintf(html, comment),
+			Subject:   "Hello {{.name}}",
+		}); err != nil {
+			b.Fatalf("svc.SetTemplate failed: %+v", err)
+		}
+	}
+	params := entity.SendEmailParams{
+		TemplateID:  "t1",
+		ProjectID:   "p1",
+		TransportID: "file",
+		To:          []string{"to@example.com"},
+		TemplateParams: map[string]any{
+			"name":  "Andy",
+			"items": []string{"one", "two", "three"},
+		},
+	}
+
+	b.Run("parsed once", func(b *testing.B) {
+		setTemplate(b, "")
+		b.ReportAllocs()
+		for range b.N {
+			if err := svc.SendEmail(ctx, params); err != nil {
+				b.Fatalf("svc.SendEmail failed: %+v", err)
+			}
+		}
+	})
+	b.Run("parsed every send", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := range b.N {
+			b.StopTimer()
+			setTemplate(b, strconv.Itoa(i))
+			b.StartTimer()
+			if err := svc.SendEmail(ctx, params); err != nil {
+				b.Fatalf("svc.SendEmail failed: %+v", err)
+			}
+		}
+	})
+}
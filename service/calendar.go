@@ -0,0 +1,182 @@
+package service
+
+import (
+	"fmt"
+	"net/mail"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/andyfusniak/squishy-mailer-lite/entity"
+	"github.com/andyfusniak/squishy-mailer-lite/internal/store"
+	"github.com/pkg/errors"
+)
+
+const (
+	// calendarFilename is the filename of the invitation part, used by
+	// transports that send it as an attachment.
+	calendarFilename = "invite.ics"
+
+	// calendarContentType is the content type of the invitation part.
+	// The method parameter is what makes mail clients treat the part as
+	// an invitation rather than an ordinary attachment.
+	calendarContentType = "text/calendar; charset=UTF-8; method=REQUEST"
+
+	// calendarLineOctets is the longest line of an iCalendar object,
+	// excluding the line break, see RFC 5545 section 3.1.
+	calendarLineOctets = 75
+
+	icsTimeLayout = "20060102T150405Z"
+)
+
+// checkCalendarEvent returns an ErrInvalidCalendarEventCode service error
+// if event, when set, is not a meeting that can be sent.
+func checkCalendarEvent(event *entity.CalendarEvent) error {
+	if event == nil {
+		return nil
+	}
+	invalid := func(format string, args ...any) error {
+		return entity.NewServiceError(entity.ErrInvalidCalendarEventCode, errors.Errorf(format, args...))
+	}
+	if strings.TrimSpace(event.Summary) == "" {
+		return invalid("calendar event summary is required")
+	}
+	if event.Start.IsZero() {
+		return invalid("calendar event start is required")
+	}
+	if !event.End.After(event.Start) {
+		return invalid("calendar event end must be after its start")
+	}
+	if event.Sequence < 0 {
+		return invalid("calendar event sequence must not be negative")
+	}
+	if event.Organizer != "" {
+		if _, err := mail.ParseAddress(event.Organizer); err != nil {
+			return invalid("calendar event organizer %q is not a valid address", event.Organizer)
+		}
+	}
+	for _, a := range event.Attendees {
+		if _, err := mail.ParseAddress(a); err != nil {
+			return invalid("calendar event attendee %q is not a valid address", a)
+		}
+	}
+	return nil
+}
+
+// calendarAttachments returns the invitation of event, if set, as the
+// attachment of an email with the given Message-ID, sender address and
+// recipients. The event must have been checked with checkCalendarEvent.
+func calendarAttachments(event *entity.CalendarEvent, messageID, from string, recipients []string, now time.Time) []store.MailQueueAttachment {
+	if event == nil {
+		return nil
+	}
+	return []store.MailQueueAttachment{{
+		Filename:    calendarFilename,
+		ContentType: calendarContentType,
+		Content:     calendarInvite(event, messageID, from, recipients, now),
+	}}
+}
+
+// calendarInvite returns event as an iCalendar METHOD:REQUEST object. The
+// UID, organizer and attendees default to messageID, from and recipients.
+func calendarInvite(event *entity.CalendarEvent, messageID, from string, recipients []string, now time.Time) []byte {
+	uid := event.UID
+	if uid == "" {
+		uid = messageID
+	}
+	organizer := event.Organizer
+	if organizer == "" {
+		organizer = from
+	}
+	attendees := event.Attendees
+	if len(attendees) == 0 {
+		attendees = recipients
+	}
+
+	var b strings.Builder
+	line := func(format string, args ...any) {
+		writeCalendarLine(&b, fmt.Sprintf(format, args...))
+	}
+	line("BEGIN:VCALENDAR")
+	line("VERSION:2.0")
+	line("PRODID:-//squishy-mailer-lite//EN")
+	line("CALSCALE:GREGORIAN")
+	line("METHOD:REQUEST")
+	line("BEGIN:VEVENT")
+	line("UID:%s", icsText(uid))
+	line("SEQUENCE:%d", event.Sequence)
+	line("DTSTAMP:%s", now.UTC().Format(icsTimeLayout))
+	line("DTSTART:%s", event.Start.UTC().Format(icsTimeLayout))
+	line("DTEND:%s", event.End.UTC().Format(icsTimeLayout))
+	line("SUMMARY:%s", icsText(event.Summary))
+	if event.Description != "" {
+		line("DESCRIPTION:%s", icsText(event.Description))
+	}
+	if event.Location != "" {
+		line("LOCATION:%s", icsText(event.Location))
+	}
+	line("ORGANIZER%s:mailto:%s", icsCommonName(event.OrganizerName), calendarAddress(organizer))
+	for _, a := range attendees {
+		addr, err := mail.ParseAddress(a)
+		if err != nil {
+			continue
+		}
+		line("ATTENDEE%s;ROLE=REQ-PARTICIPANT;PARTSTAT=NEEDS-ACTION;RSVP=TRUE:mailto:%s",
+			icsCommonName(addr.Name), addr.Address)
+	}
+	line("STATUS:CONFIRMED")
+	line("END:VEVENT")
+	line("END:VCALENDAR")
+	return []byte(b.String())
+}
+
+// calendarAddress returns the bare address of addr, which may include a
+// display name.
+func calendarAddress(addr string) string {
+	if a, err := mail.ParseAddress(addr); err == nil {
+		return a.Address
+	}
+	return addr
+}
+
+// icsText escapes s as an iCalendar TEXT value.
+func icsText(s string) string {
+	return strings.NewReplacer(
+		`\`, `\\`,
+		";", `\;`,
+		",", `\,`,
+		"\r\n", `\n`,
+		"\n", `\n`,
+		"\r", `\n`,
+	).Replace(s)
+}
+
+// icsCommonName returns the CN parameter for name, or an empty string if
+// name is empty. The value is quoted as it may contain separators, and
+// double quotes, which cannot be escaped, are removed.
+func icsCommonName(name string) string {
+	name = strings.TrimSpace(strings.ReplaceAll(name, `"`, ""))
+	if name == "" {
+		return ""
+	}
+	return `;CN="` + icsText(name) + `"`
+}
+
+// writeCalendarLine writes s to b as a content line, folding it after
+// every 75 octets without splitting a UTF-8 sequence.
+func writeCalendarLine(b *strings.Builder, s string) {
+	limit := calendarLineOctets
+	for len(s) > limit {
+		n := limit
+		for n > 0 && !utf8.RuneStart(s[n]) {
+			n--
+		}
+		b.WriteString(s[:n])
+		b.WriteString("\r\n ")
+		s = s[n:]
+		// the leading space of a continuation line counts towards it
+		limit = calendarLineOctets - 1
+	}
+	b.WriteString(s)
+	b.WriteString("\r\n")
+}
@@ -0,0 +1,145 @@
+package service_test
+
+import (
+	"context"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andyfusniak/squishy-mailer-lite/entity"
+	"github.com/stretchr/testify/assert"
+)
+
+// mimePart is a leaf part of a MIME message and the media type of the
+// multipart it is in.
+type mimePart struct {
+	parent      string
+	contentType string
+	body        string
+}
+
+// mimeParts returns the leaf parts of the multipart body r with the given
+// Content-Type, descending into nested multiparts.
+func mimeParts(t *testing.T, contentType string, r io.Reader) []mimePart {
+	t.Helper()
+
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		t.Fatalf("mime.ParseMediaType failed: %+v", err)
+	}
+	var parts []mimePart
+	mr := multipart.NewReader(r, params["boundary"])
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			return parts
+		}
+		if err != nil {
+			t.Fatalf("mr.NextPart failed: %+v", err)
+		}
+		ct := part.Header.Get("Content-Type")
+		if strings.HasPrefix(ct, "multipart/") {
+			parts = append(parts, mimeParts(t, ct, part)...)
+			continue
+		}
+		b, err := io.ReadAll(part)
+		if err != nil {
+			t.Fatalf("io.ReadAll failed: %+v", err)
+		}
+		parts = append(parts, mimePart{mediaType, ct, string(b)})
+	}
+}
+
+func TestSendEmailAsyncCalendarEvent(t *testing.T) {
+	srv := newFakeSMTPServer(t)
+	svc := newTestService(t)
+	setupQueueProject(t, svc, srv)
+
+	ctx := context.Background()
+	start := time.Date(2026, 11, 3, 14, 30, 0, 0, time.UTC)
+	mq, err := svc.SendEmailAsync(ctx, entity.SendEmailParams{
+		TemplateID:     "t1",
+		ProjectID:      "p1",
+		TransportID:    "tr1",
+		To:             []string{"to@example.com"},
+		Cc:             []string{"cc@example.com"},
+		Subject:        "Planning meeting",
+		TemplateParams: map[string]string{"name": "Andy"},
+		Attachments: []entity.EmailAttachment{
+			{Filename: "agenda.txt", Content: []byte("1. Budget")},
+		},
+		CalendarEvent: &entity.CalendarEvent{
+			Summary:       "Planning; Q4, budget",
+			Description:   "Agenda attached.\nBring numbers.",
+			Location:      "Room 1",
+			Start:         start,
+			End:           start.Add(time.Hour),
+			OrganizerName: "Example",
+		},
+	})
+	if err != nil {
+		t.Fatalf("svc.SendEmailAsync failed: %+v", err)
+	}
+	if _, err := svc.ProcessMailQueue(ctx); err != nil {
+		t.Fatalf("svc.ProcessMailQueue failed: %+v", err)
+	}
+
+	msgs := srv.Messages()
+	if !assert.Len(t, msgs, 1) {
+		return
+	}
+	msg, err := mail.ReadMessage(strings.NewReader(msgs[0].Data))
+	if err != nil {
+		t.Fatalf("mail.ReadMessage failed: %+v", err)
+	}
+	parts := mimeParts(t, msg.Header.Get("Content-Type"), msg.Body)
+	if !assert.Len(t, parts, 4) {
+		return
+	}
+	assert.Equal(t, "multipart/alternative", parts[2].parent)
+	assert.Equal(t, "text/calendar; charset=UTF-8; method=REQUEST", parts[2].contentType)
+	assert.Equal(t, "multipart/mixed", parts[3].parent)
+	assert.True(t, strings.HasPrefix(parts[3].contentType, "text/plain"), parts[3].contentType)
+
+	// long lines are folded after 75 octets
+	ics := parts[2].body
+	for _, line := range strings.Split(ics, "\r\n") {
+		assert.LessOrEqual(t, len(line), 75)
+	}
+	ics = strings.ReplaceAll(ics, "\r\n ", "")
+	for _, line := range []string{
+		"METHOD:REQUEST",
+		"UID:" + mq.MessageID,
+		"SEQUENCE:0",
+		"DTSTART:20261103T143000Z",
+		"DTEND:20261103T153000Z",
+		`SUMMARY:Planning\; Q4\, budget`,
+		`DESCRIPTION:Agenda attached.\nBring numbers.`,
+		"LOCATION:Room 1",
+		`ORGANIZER;CN="Example":mailto:from@example.com`,
+		"ATTENDEE;ROLE=REQ-PARTICIPANT;PARTSTAT=NEEDS-ACTION;RSVP=TRUE:mailto:to@example.com",
+		"ATTENDEE;ROLE=REQ-PARTICIPANT;PARTSTAT=NEEDS-ACTION;RSVP=TRUE:mailto:cc@example.com",
+	} {
+		assert.Contains(t, ics, line+"\r\n")
+	}
+
+	for _, event := range []entity.CalendarEvent{
+		{Start: start, End: start.Add(time.Hour)},
+		{Summary: "No end", Start: start},
+		{Summary: "Bad attendee", Start: start, End: start.Add(time.Hour), Attendees: []string{"not an address"}},
+	} {
+		_, err := svc.SendEmailAsync(ctx, entity.SendEmailParams{
+			TemplateID:     "t1",
+			ProjectID:      "p1",
+			TransportID:    "tr1",
+			To:             []string{"to@example.com"},
+			TemplateParams: map[string]string{"name": "Andy"},
+			CalendarEvent:  &event,
+		})
+		assertServiceErrorCode(t, err, entity.ErrInvalidCalendarEventCode)
+	}
+}
@@ -0,0 +1,214 @@
+package service
+
+import (
+	"context"
+	"slices"
+
+	"github.com/andyfusniak/squishy-mailer-lite/entity"
+	"github.com/andyfusniak/squishy-mailer-lite/internal/store"
+	"github.com/pkg/errors"
+)
+
+// CreateCampaign creates a campaign and places one email per recipient on
+// the mail queue, each rendered separately as with SendEmailToManyAsync and
+// tagged with the campaign's ID. The contacts of params.ListID, if set,
+// are added after the recipients given explicitly. It returns the campaign
+// along with one result per recipient in the same order. A recipient whose
+// email cannot be queued does not stop the rest of the campaign. The
+// template and transport are checked before anything is queued; if either
+// is missing the campaign is not created. If the ID is already used in the
+// project an error is returned with a code of
+// ErrCampaignAlreadyExistsCode.
+func (s *Service) CreateCampaign(ctx context.Context, params entity.CreateCampaignParams) (*entity.Campaign, []entity.SendEmailBatchResult, error) {
+	if err := s.idPolicy.validate("campaign", params.ID); err != nil {
+		return nil, nil, err
+	}
+	if params.ListID != "" {
+		contacts, err := s.listRecipients(ctx, params.ProjectID, params.ListID)
+		if err != nil {
+			return nil, nil, err
+		}
+		params.Recipients = append(slices.Clip(params.Recipients), contacts...)
+	}
+	if len(params.Recipients) == 0 {
+		return nil, nil, entity.NewServiceError(entity.ErrInvalidCampaignCode,
+			errors.New("campaign has no recipients"))
+	}
+	if _, err := s.store.GetTemplate(ctx, params.ProjectID, params.TemplateID); err != nil {
+		if serr := serviceErrorFromStore(err); serr != nil {
+			return nil, nil, serr
+		}
+		return nil, nil, errors.Wrapf(err, "[service] store.GetTemplate failed")
+	}
+	transportID, err := s.resolveTransportID(ctx, params.ProjectID, params.TransportID)
+	if err != nil {
+		return nil, nil, err
+	}
+	batch, err := personalise(entity.SendEmailToManyParams{
+		TemplateID:     params.TemplateID,
+		ProjectID:      params.ProjectID,
+		TransportID:    transportID,
+		Subject:        params.Subject,
+		TemplateParams: params.TemplateParams,
+		Recipients:     params.Recipients,
+		MessageStream:  params.MessageStream,
+		SendAt:         params.SendAt,
+	})
+	if err != nil {
+		return nil, nil, entity.NewServiceError(entity.ErrInvalidCampaignCode, err)
+	}
+
+	obj, err := s.store.InsertCampaign(ctx, store.AddCampaign{
+		CampaignID:   params.ID,
+		ProjectID:    params.ProjectID,
+		CampaignName: params.Name,
+		TemplateID:   params.TemplateID,
+		TransportID:  transportID,
+		ListID:       params.ListID,
+		Recipients:   len(params.Recipients),
+	})
+	if err != nil {
+		if serr := serviceErrorFromStore(err); serr != nil {
+			return nil, nil, serr
+		}
+		return nil, nil, errors.Wrapf(err, "[service] store.InsertCampaign failed")
+	}
+	if err := checkProjectScope("campaign", params.ProjectID, obj.ProjectID); err != nil {
+		return nil, nil, err
+	}
+
+	c := newSendCache(s, false)
+	c.campaignID = obj.CampaignID
+	results := make([]entity.SendEmailBatchResult, len(batch))
+	for i, p := range batch {
+		if err := ctx.Err(); err != nil {
+			results[i].Err = err
+			continue
+		}
+		p.Unsubscribe = params.Unsubscribe
+		p.TrackOpens = params.TrackOpens
+		results[i].MailQueue, results[i].Err = s.queueEmail(ctx, p, c)
+	}
+	s.logger.Info("campaign created", "project_id", obj.ProjectID, "campaign_id", obj.CampaignID,
+		"recipients", obj.Recipients)
+	return campaignFromStoreObject(obj), results, nil
+}
+
+// GetCampaign retrieves a campaign. If the campaign is not found an error
+// is returned with a code of ErrCampaignNotFoundCode.
+func (s *Service) GetCampaign(ctx context.Context, projectID, campaignID string) (*entity.Campaign, error) {
+	obj, err := s.store.GetCampaign(ctx, projectID, campaignID)
+	if err != nil {
+		if serr := serviceErrorFromStore(err); serr != nil {
+			return nil, serr
+		}
+		return nil, errors.Wrapf(err, "[service] store.GetCampaign failed")
+	}
+	if err := checkProjectScope("campaign", projectID, obj.ProjectID); err != nil {
+		return nil, err
+	}
+	return campaignFromStoreObject(obj), nil
+}
+
+// ListCampaigns lists the campaigns of a project ordered by id.
+func (s *Service) ListCampaigns(ctx context.Context, projectID string) ([]*entity.Campaign, error) {
+	objs, err := s.store.ListCampaigns(ctx, projectID)
+	if err != nil {
+		return nil, errors.Wrapf(err, "[service] store.ListCampaigns failed")
+	}
+	list := make([]*entity.Campaign, 0, len(objs))
+	for _, obj := range objs {
+		if err := checkProjectScope("campaign", projectID, obj.ProjectID); err != nil {
+			return nil, err
+		}
+		list = append(list, campaignFromStoreObject(obj))
+	}
+	return list, nil
+}
+
+// PauseCampaign pauses a campaign. Its queued and rate limited emails
+// move to the paused state and are not delivered until the campaign is
+// resumed. Emails a worker has already claimed are still delivered.
+// Pausing a paused campaign has no effect. If the campaign is not found an
+// error is returned with a code of ErrCampaignNotFoundCode.
+func (s *Service) PauseCampaign(ctx context.Context, projectID, campaignID string) (*entity.Campaign, error) {
+	return s.setCampaignState(ctx, projectID, campaignID, store.CampaignStatePaused)
+}
+
+// ResumeCampaign resumes a paused campaign, returning its paused emails to
+// the mail queue. Resuming a running campaign has no effect. If the
+// campaign is not found an error is returned with a code of
+// ErrCampaignNotFoundCode.
+func (s *Service) ResumeCampaign(ctx context.Context, projectID, campaignID string) (*entity.Campaign, error) {
+	return s.setCampaignState(ctx, projectID, campaignID, store.CampaignStateRunning)
+}
+
+func (s *Service) setCampaignState(ctx context.Context, projectID, campaignID, cstate string) (*entity.Campaign, error) {
+	obj, err := s.store.SetCampaignState(ctx, projectID, campaignID, cstate)
+	if err != nil {
+		if serr := serviceErrorFromStore(err); serr != nil {
+			return nil, serr
+		}
+		return nil, errors.Wrapf(err, "[service] store.SetCampaignState failed")
+	}
+	if err := checkProjectScope("campaign", projectID, obj.ProjectID); err != nil {
+		return nil, err
+	}
+	s.logger.Info("campaign state changed", "project_id", obj.ProjectID, "campaign_id", obj.CampaignID,
+		"state", obj.CState)
+	return campaignFromStoreObject(obj), nil
+}
+
+// GetCampaignStats returns the progress of a campaign, counting its emails
+// by state. If the campaign is not found an error is returned with a code
+// of ErrCampaignNotFoundCode.
+func (s *Service) GetCampaignStats(ctx context.Context, projectID, campaignID string) (*entity.CampaignStats, error) {
+	c, err := s.GetCampaign(ctx, projectID, campaignID)
+	if err != nil {
+		return nil, err
+	}
+	counts, err := s.store.CountCampaignMailQueue(ctx, projectID, campaignID)
+	if err != nil {
+		return nil, errors.Wrapf(err, "[service] store.CountCampaignMailQueue failed")
+	}
+
+	stats := &entity.CampaignStats{
+		CampaignID: c.ID,
+		ProjectID:  c.ProjectID,
+		State:      c.State,
+		Recipients: c.Recipients,
+	}
+	queued := 0
+	for mstate, n := range counts {
+		queued += n
+		switch mstate {
+		case store.MailQueueStateSent:
+			stats.Sent += n
+		case store.MailQueueStateFailed, store.MailQueueStateDeadLetter, store.MailQueueStateBlocked:
+			stats.Failed += n
+		case store.MailQueueStateBounced:
+			stats.Bounced += n
+		case store.MailQueueStateCancelled:
+			stats.Cancelled += n
+		default:
+			stats.Remaining += n
+		}
+	}
+	stats.Rejected = max(c.Recipients-queued, 0)
+	return stats, nil
+}
+
+func campaignFromStoreObject(obj *store.Campaign) *entity.Campaign {
+	return &entity.Campaign{
+		ID:          obj.CampaignID,
+		ProjectID:   obj.ProjectID,
+		Name:        obj.CampaignName,
+		TemplateID:  obj.TemplateID,
+		TransportID: obj.TransportID,
+		ListID:      obj.ListID,
+		State:       entity.CampaignState(obj.CState),
+		Recipients:  obj.Recipients,
+		CreatedAt:   entity.ISOTime(obj.CreatedAt),
+		ModifiedAt:  entity.ISOTime(obj.ModifiedAt),
+	}
+}
@@ -0,0 +1,115 @@
+package service_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/andyfusniak/squishy-mailer-lite/entity"
+	"github.com/andyfusniak/squishy-mailer-lite/service"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCampaign(t *testing.T) {
+	stores := []struct {
+		name string
+		opts []service.Option
+	}{
+		{"sqlite3", nil},
+		{"memory", []service.Option{service.WithInMemoryStore()}},
+	}
+	for _, tc := range stores {
+		t.Run(tc.name, func(t *testing.T) {
+			srv := newFakeSMTPServer(t)
+			svc := newTestService(t, tc.opts...)
+			setupQueueProject(t, svc, srv)
+			ctx := context.Background()
+
+			params := entity.CreateCampaignParams{
+				ID:             "spring",
+				ProjectID:      "p1",
+				Name:           "Spring Sale",
+				TemplateID:     "t1",
+				TransportID:    "tr1",
+				Subject:        "Spring Sale",
+				TemplateParams: map[string]any{"name": "customer"},
+				Recipients: []entity.EmailRecipient{
+					{Email: "a@example.com", TemplateParams: map[string]any{"name": "Ann"}},
+					{Email: "b@example.com"},
+					{Email: "reject@example.com"},
+					{Email: "not-an-address"},
+				},
+			}
+			c, results, err := svc.CreateCampaign(ctx, params)
+			if err != nil {
+				t.Fatalf("svc.CreateCampaign failed: %+v", err)
+			}
+			assert.Equal(t, entity.CampaignStateRunning, c.State)
+			assert.Equal(t, 4, c.Recipients)
+			if assert.Len(t, results, 4) {
+				assert.Equal(t, "spring", results[0].MailQueue.CampaignID)
+				assertServiceErrorCode(t, results[3].Err, entity.ErrInvalidAddressCode)
+			}
+
+			_, _, err = svc.CreateCampaign(ctx, params)
+			assertServiceErrorCode(t, err, entity.ErrCampaignAlreadyExistsCode)
+			_, err = svc.PauseCampaign(ctx, "p1", "nope")
+			assertServiceErrorCode(t, err, entity.ErrCampaignNotFoundCode)
+
+			// a paused campaign's emails stay in the queue
+			c, err = svc.PauseCampaign(ctx, "p1", "spring")
+			if err != nil {
+				t.Fatalf("svc.PauseCampaign failed: %+v", err)
+			}
+			assert.Equal(t, entity.CampaignStatePaused, c.State)
+			n, err := svc.ProcessMailQueue(ctx)
+			if err != nil {
+				t.Fatalf("svc.ProcessMailQueue failed: %+v", err)
+			}
+			assert.Equal(t, 0, n)
+			mq, err := svc.GetMailQueue(ctx, "p1", results[0].MailQueue.ID)
+			if err != nil {
+				t.Fatalf("svc.GetMailQueue failed: %+v", err)
+			}
+			assert.Equal(t, entity.MailStatePaused, mq.State)
+
+			st, err := svc.GetCampaignStats(ctx, "p1", "spring")
+			if err != nil {
+				t.Fatalf("svc.GetCampaignStats failed: %+v", err)
+			}
+			assert.Equal(t, entity.CampaignStats{
+				CampaignID: "spring",
+				ProjectID:  "p1",
+				State:      entity.CampaignStatePaused,
+				Recipients: 4,
+				Rejected:   1,
+				Remaining:  3,
+			}, *st)
+
+			if _, err := svc.ResumeCampaign(ctx, "p1", "spring"); err != nil {
+				t.Fatalf("svc.ResumeCampaign failed: %+v", err)
+			}
+			n, err = svc.ProcessMailQueue(ctx)
+			if err != nil {
+				t.Fatalf("svc.ProcessMailQueue failed: %+v", err)
+			}
+			assert.Equal(t, 2, n)
+			assert.Len(t, srv.Messages(), 2)
+
+			st, err = svc.GetCampaignStats(ctx, "p1", "spring")
+			if err != nil {
+				t.Fatalf("svc.GetCampaignStats failed: %+v", err)
+			}
+			assert.Equal(t, entity.CampaignStateRunning, st.State)
+			assert.Equal(t, 2, st.Sent)
+			assert.Equal(t, 1, st.Failed)
+			assert.Equal(t, 1, st.Rejected)
+			assert.Equal(t, 0, st.Remaining)
+
+			list, err := svc.ListCampaigns(ctx, "p1")
+			if err != nil {
+				t.Fatalf("svc.ListCampaigns failed: %+v", err)
+			}
+			assert.Len(t, list, 1)
+		})
+	}
+}
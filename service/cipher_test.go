@@ -0,0 +1,55 @@
+package service_test
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/andyfusniak/squishy-mailer-lite/service"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWithCipher(t *testing.T) {
+	srv := newFakeSMTPServer(t)
+	ctx := context.Background()
+	db := filepath.Join(t.TempDir(), "mailer.db")
+	key := strings.Repeat(testEncryptionKey, 2)
+	open := func(opts ...service.Option) *service.Service {
+		t.Helper()
+		svc, err := service.NewEmailService(append([]service.Option{
+			service.WithSqlite3DBFilepath(db),
+			service.WithHexEncodedEncryptionKey(key),
+		}, opts...)...)
+		if err != nil {
+			t.Fatalf("service.NewEmailService failed: %+v", err)
+		}
+		return svc
+	}
+
+	// the transport password is encrypted with XChaCha20-Poly1305
+	svc := open(service.WithCipher(service.CipherXChaCha20Poly1305))
+	setupQueueProject(t, svc, srv)
+	queueTestEmail(t, svc)
+	if _, err := svc.ProcessMailQueue(ctx); err != nil {
+		t.Fatalf("svc.ProcessMailQueue failed: %+v", err)
+	}
+	svc.Close()
+
+	// and still decrypted once the cipher is changed back
+	svc = open()
+	defer svc.Close()
+	queueTestEmail(t, svc)
+	if _, err := svc.ProcessMailQueue(ctx); err != nil {
+		t.Fatalf("svc.ProcessMailQueue failed: %+v", err)
+	}
+	assert.Len(t, srv.Messages(), 2)
+
+	for _, opts := range [][]service.Option{
+		{service.WithHexEncodedEncryptionKey(testEncryptionKey), service.WithCipher(service.CipherXChaCha20Poly1305)},
+		{service.WithHexEncodedEncryptionKey(key), service.WithCipher("rot13")},
+	} {
+		_, err := service.NewEmailService(append([]service.Option{service.WithInMemoryStore()}, opts...)...)
+		assert.Error(t, err)
+	}
+}
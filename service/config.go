@@ -0,0 +1,468 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/andyfusniak/squishy-mailer-lite/entity"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// Config is the service configuration read from a YAML or TOML file by
+// LoadConfig and NewEmailServiceFromConfig. For example, in YAML:
+//
+//	db: /var/lib/mailer/mailer.db
+//	sqlite3:
+//	  synchronous: full
+//	  busy_timeout: 30s
+//	encryption_key_file: /run/secrets/mailer-key
+//	log_level: info
+//	worker:
+//	  concurrency: 4
+//	  transport_concurrency: 2
+//	  poll_interval: 5s
+//	retry:
+//	  max_attempts: 5
+//	  initial_backoff: 1m
+//	  max_backoff: 1h
+//	transport:
+//	  port: 587
+//	  tls_mode: starttls
+//	maintenance:
+//	  checkpoint_interval: 10m
+//	  vacuum_interval: 24h
+//	  mail_retention: 720h
+//	  archive_dir: /var/lib/mailer/archive
+type Config struct {
+	// DB is the path of the SQLite3 database. Defaults to mailer.db in
+	// the current working directory.
+	DB string `yaml:"db" toml:"db"`
+
+	// ReadReplicaDSN is the optional DSN of a read replica, see
+	// WithSqlite3ReadReplicaDSN.
+	ReadReplicaDSN string `yaml:"read_replica_dsn" toml:"read_replica_dsn"`
+
+	// Sqlite3 holds the pragmas applied to every database connection, see
+	// WithSqlite3Pragmas.
+	Sqlite3 Sqlite3Pragmas `yaml:"sqlite3" toml:"sqlite3"`
+
+	// The encryption key is read from exactly one of three sources: the
+	// hex encoded key itself, a file holding the hex encoded key, or the
+	// name of an environment variable holding it. Keeping the key out of
+	// the config file means the file can be checked in.
+	EncryptionKey     string `yaml:"encryption_key" toml:"encryption_key"`
+	EncryptionKeyFile string `yaml:"encryption_key_file" toml:"encryption_key_file"`
+	EncryptionKeyEnv  string `yaml:"encryption_key_env" toml:"encryption_key_env"`
+
+	// In place of a key, a passphrase the key is derived from can be read
+	// from a file or an environment variable, see WithPassphrase.
+	EncryptionPassphraseFile string `yaml:"encryption_passphrase_file" toml:"encryption_passphrase_file"`
+	EncryptionPassphraseEnv  string `yaml:"encryption_passphrase_env" toml:"encryption_passphrase_env"`
+
+	// Cipher is aes-gcm, the default, or xchacha20-poly1305, see
+	// WithCipher.
+	Cipher string `yaml:"cipher" toml:"cipher"`
+
+	// KMS names an external key management service that wraps the data
+	// encryption key in place of the settings above, see WithKeyWrapper.
+	KMS KMSConfig `yaml:"kms" toml:"kms"`
+
+	// LogLevel is one of debug, info, warn or error. Log events are
+	// written to stderr. If empty nothing is logged.
+	LogLevel string `yaml:"log_level" toml:"log_level"`
+
+	// UnsubscribeURL is the base of the links in List-Unsubscribe
+	// headers, see WithUnsubscribeURL.
+	UnsubscribeURL string `yaml:"unsubscribe_url" toml:"unsubscribe_url"`
+
+	// TrackingURL is the base of the open tracking pixel URLs, see
+	// WithTrackingURL.
+	TrackingURL string `yaml:"tracking_url" toml:"tracking_url"`
+
+	// SandboxRecipients, if set, receive every email in place of its
+	// recipients, see WithSandboxRecipients.
+	SandboxRecipients []string `yaml:"sandbox_recipients" toml:"sandbox_recipients"`
+
+	// MXCheck checks that recipient domains can receive email, see
+	// WithMXCheck.
+	MXCheck bool `yaml:"mx_check" toml:"mx_check"`
+
+	// CacheTTL, if set, caches the templates and transports used to send
+	// emails for up to this long, see WithCache.
+	CacheTTL Duration `yaml:"cache_ttl" toml:"cache_ttl"`
+
+	// Events names a message bus that every mail event is published to,
+	// see WithEventPublisher.
+	Events EventsConfig `yaml:"events" toml:"events"`
+
+	Worker      WorkerConfig          `yaml:"worker" toml:"worker"`
+	Retry       RetryConfig           `yaml:"retry" toml:"retry"`
+	Transport   SMTPTransportDefaults `yaml:"transport" toml:"transport"`
+	Maintenance MaintenanceConfig     `yaml:"maintenance" toml:"maintenance"`
+}
+
+// EventsConfig configures the message bus mail events are published to.
+type EventsConfig struct {
+	// Provider is nats or kafka. Empty means events are not published.
+	Provider string `yaml:"provider" toml:"provider"`
+
+	// URL is the NATS server URL, or the URL of the Kafka REST Proxy.
+	URL string `yaml:"url" toml:"url"`
+
+	// Topic is the NATS subject or Kafka topic, by default mail.events.
+	Topic string `yaml:"topic" toml:"topic"`
+}
+
+// KMSConfig configures the key management service used to wrap the data
+// encryption key.
+type KMSConfig struct {
+	// Provider is aws or vault. Empty means no key management service.
+	Provider string `yaml:"provider" toml:"provider"`
+
+	// KeyID is the AWS KMS key id, ARN or alias, or the name of the Vault
+	// transit key.
+	KeyID string `yaml:"key_id" toml:"key_id"`
+
+	// Region is the AWS region. It defaults to that of the AWS
+	// environment.
+	Region string `yaml:"region" toml:"region"`
+
+	// Address is the URL of the Vault server and Mount the path of its
+	// transit secrets engine, by default transit. The Vault token is read
+	// from the environment variable named by TokenEnv, by default
+	// VAULT_TOKEN.
+	Address  string `yaml:"address" toml:"address"`
+	Mount    string `yaml:"mount" toml:"mount"`
+	TokenEnv string `yaml:"token_env" toml:"token_env"`
+}
+
+// WorkerConfig configures the processing of the mail queue.
+type WorkerConfig struct {
+	// Concurrency is the number of emails ProcessMailQueue delivers at
+	// the same time, see WithWorkerConcurrency.
+	Concurrency int `yaml:"concurrency" toml:"concurrency"`
+
+	// TransportConcurrency is the number of emails delivered at the same
+	// time through each transport, see WithTransportConcurrency.
+	TransportConcurrency int `yaml:"transport_concurrency" toml:"transport_concurrency"`
+
+	// PollInterval is how often a long running worker, such as sqm
+	// serve, calls ProcessMailQueue. The service itself does not poll.
+	PollInterval Duration `yaml:"poll_interval" toml:"poll_interval"`
+
+	// ClaimLease is how long an email may stay in the sending state
+	// before it is claimed again, see WithClaimLease.
+	ClaimLease Duration `yaml:"claim_lease" toml:"claim_lease"`
+}
+
+// RetryConfig is the file form of RetryPolicy.
+type RetryConfig struct {
+	MaxAttempts    int      `yaml:"max_attempts" toml:"max_attempts"`
+	InitialBackoff Duration `yaml:"initial_backoff" toml:"initial_backoff"`
+	MaxBackoff     Duration `yaml:"max_backoff" toml:"max_backoff"`
+}
+
+// MaintenanceConfig is the file form of MaintenancePolicy.
+type MaintenanceConfig struct {
+	CheckpointInterval Duration `yaml:"checkpoint_interval" toml:"checkpoint_interval"`
+	VacuumInterval     Duration `yaml:"vacuum_interval" toml:"vacuum_interval"`
+	MailRetention      Duration `yaml:"mail_retention" toml:"mail_retention"`
+
+	// ArchiveDir, if set, is the directory pruned emails are archived to,
+	// see NewDirArchiver.
+	ArchiveDir string `yaml:"archive_dir" toml:"archive_dir"`
+}
+
+// Duration is a time.Duration written in config files in the form
+// accepted by time.ParseDuration, for example 90s or 1h30m.
+type Duration time.Duration
+
+// UnmarshalText parses a duration such as 5s.
+func (d *Duration) UnmarshalText(text []byte) error {
+	v, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// MarshalText formats the duration as time.Duration.String does.
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+// LoadConfig reads a config file. Files ending in .toml are read as TOML
+// and all others as YAML. Unknown settings are rejected so that typos do
+// not go unnoticed.
+func LoadConfig(path string) (*Config, error) {
+	var cfg Config
+	if err := DecodeConfigFile(path, &cfg); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+// DecodeConfigFile decodes a YAML or TOML config file into v in the same
+// way as LoadConfig. Applications that keep their own settings alongside
+// the service's can decode into a struct that embeds Config, inline in
+// YAML.
+func DecodeConfigFile(path string, v any) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return errors.Wrapf(err, "[service] read config file failed")
+	}
+
+	if strings.EqualFold(filepath.Ext(path), ".toml") {
+		md, err := toml.Decode(string(b), v)
+		if err != nil {
+			return errors.Wrapf(err, "[service] parse config file %s failed", path)
+		}
+		if undecoded := md.Undecoded(); len(undecoded) > 0 {
+			return errors.Errorf("[service] parse config file %s failed: unknown setting %q",
+				path, undecoded[0].String())
+		}
+		return nil
+	}
+
+	dec := yaml.NewDecoder(bytes.NewReader(b))
+	dec.KnownFields(true)
+	if err := dec.Decode(v); err != nil && err != io.EOF {
+		return errors.Wrapf(err, "[service] parse config file %s failed", path)
+	}
+	return nil
+}
+
+// NewEmailServiceFromConfig creates a new email service configured by the
+// config file at path. Further options are applied after those from the
+// file, so they take precedence.
+func NewEmailServiceFromConfig(path string, opts ...Option) (*Service, error) {
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		return nil, err
+	}
+	cfgOpts, err := cfg.Options()
+	if err != nil {
+		return nil, err
+	}
+	return NewEmailService(append(cfgOpts, opts...)...)
+}
+
+// Options returns the service options described by the config. It returns
+// an error if the encryption key cannot be read or a setting is invalid.
+func (c *Config) Options() ([]Option, error) {
+	key, passphrase, err := c.encryptionKey()
+	if err != nil {
+		return nil, err
+	}
+	var opts []Option
+	if key != "" {
+		opts = append(opts, WithHexEncodedEncryptionKey(key))
+	}
+	if passphrase != "" {
+		opts = append(opts, WithPassphrase(passphrase))
+	}
+	if c.Cipher != "" {
+		opts = append(opts, WithCipher(Cipher(c.Cipher)))
+	}
+	if c.KMS.Provider != "" {
+		w, err := c.KMS.keyWrapper()
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, WithKeyWrapper(w))
+	}
+
+	if c.DB != "" {
+		opts = append(opts, WithSqlite3DBFilepath(c.DB))
+	}
+	if c.ReadReplicaDSN != "" {
+		opts = append(opts, WithSqlite3ReadReplicaDSN(c.ReadReplicaDSN))
+	}
+	if c.Sqlite3 != (Sqlite3Pragmas{}) {
+		opts = append(opts, WithSqlite3Pragmas(c.Sqlite3))
+	}
+
+	if c.LogLevel != "" {
+		var level slog.Level
+		if err := level.UnmarshalText([]byte(c.LogLevel)); err != nil {
+			return nil, errors.Errorf("[service] invalid log_level %q", c.LogLevel)
+		}
+		opts = append(opts, WithLogger(slog.New(slog.NewTextHandler(os.Stderr,
+			&slog.HandlerOptions{Level: level}))))
+	}
+
+	if c.UnsubscribeURL != "" {
+		opts = append(opts, WithUnsubscribeURL(c.UnsubscribeURL))
+	}
+	if c.TrackingURL != "" {
+		opts = append(opts, WithTrackingURL(c.TrackingURL))
+	}
+	if len(c.SandboxRecipients) > 0 {
+		opts = append(opts, WithSandboxRecipients(c.SandboxRecipients))
+	}
+	if c.MXCheck {
+		opts = append(opts, WithMXCheck(nil))
+	}
+	if c.CacheTTL < 0 {
+		return nil, errors.Errorf("[service] invalid cache_ttl %s", time.Duration(c.CacheTTL))
+	}
+	if c.CacheTTL > 0 {
+		opts = append(opts, WithCache(time.Duration(c.CacheTTL)))
+	}
+	if c.Events.Provider != "" {
+		p, err := c.Events.publisher()
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, WithEventPublisher(p))
+	}
+
+	if c.Worker.Concurrency < 0 {
+		return nil, errors.Errorf("[service] invalid worker concurrency %d", c.Worker.Concurrency)
+	}
+	if c.Worker.Concurrency > 0 {
+		opts = append(opts, WithWorkerConcurrency(c.Worker.Concurrency))
+	}
+	if c.Worker.TransportConcurrency < 0 {
+		return nil, errors.Errorf("[service] invalid worker transport_concurrency %d",
+			c.Worker.TransportConcurrency)
+	}
+	if c.Worker.TransportConcurrency > 0 {
+		opts = append(opts, WithTransportConcurrency(c.Worker.TransportConcurrency))
+	}
+	if c.Worker.ClaimLease != 0 {
+		opts = append(opts, WithClaimLease(time.Duration(c.Worker.ClaimLease)))
+	}
+
+	if c.Retry.MaxAttempts > 0 {
+		opts = append(opts, WithRetryPolicy(RetryPolicy{
+			MaxAttempts:    c.Retry.MaxAttempts,
+			InitialBackoff: time.Duration(c.Retry.InitialBackoff),
+			MaxBackoff:     time.Duration(c.Retry.MaxBackoff),
+		}))
+	}
+
+	if c.Transport != (SMTPTransportDefaults{}) {
+		if err := validateSMTPTLS(entity.SMTPTLSOptions{Mode: c.Transport.TLSMode}); err != nil {
+			return nil, errors.Wrapf(err, "[service] invalid transport tls_mode")
+		}
+		opts = append(opts, WithSMTPTransportDefaults(c.Transport))
+	}
+
+	if c.Maintenance != (MaintenanceConfig{}) {
+		opts = append(opts, WithMaintenancePolicy(MaintenancePolicy{
+			CheckpointInterval: time.Duration(c.Maintenance.CheckpointInterval),
+			VacuumInterval:     time.Duration(c.Maintenance.VacuumInterval),
+			MailRetention:      time.Duration(c.Maintenance.MailRetention),
+		}))
+	}
+	if c.Maintenance.ArchiveDir != "" {
+		opts = append(opts, WithMailArchiver(NewDirArchiver(c.Maintenance.ArchiveDir)))
+	}
+	return opts, nil
+}
+
+// encryptionKey returns the hex encoded encryption key, or the passphrase
+// it is derived from, from whichever source the config names.
+func (c *Config) encryptionKey() (key, passphrase string, err error) {
+	var n int
+	for _, v := range []string{
+		c.EncryptionKey, c.EncryptionKeyFile, c.EncryptionKeyEnv,
+		c.EncryptionPassphraseFile, c.EncryptionPassphraseEnv,
+	} {
+		if v != "" {
+			n++
+		}
+	}
+	if n > 1 {
+		return "", "", errors.New("[service] only one of encryption_key, encryption_key_file, " +
+			"encryption_key_env, encryption_passphrase_file and encryption_passphrase_env may be set")
+	}
+
+	switch {
+	case c.EncryptionKeyFile != "":
+		b, err := os.ReadFile(c.EncryptionKeyFile)
+		if err != nil {
+			return "", "", errors.Wrapf(err, "[service] read encryption key file failed")
+		}
+		return strings.TrimSpace(string(b)), "", nil
+	case c.EncryptionKeyEnv != "":
+		key := os.Getenv(c.EncryptionKeyEnv)
+		if key == "" {
+			return "", "", errors.Errorf("[service] environment variable %s is not set", c.EncryptionKeyEnv)
+		}
+		return key, "", nil
+	case c.EncryptionPassphraseFile != "":
+		b, err := os.ReadFile(c.EncryptionPassphraseFile)
+		if err != nil {
+			return "", "", errors.Wrapf(err, "[service] read encryption passphrase file failed")
+		}
+		passphrase := strings.TrimRight(string(b), "\r\n")
+		if passphrase == "" {
+			return "", "", errors.New("[service] encryption passphrase file is empty")
+		}
+		return "", passphrase, nil
+	case c.EncryptionPassphraseEnv != "":
+		passphrase := os.Getenv(c.EncryptionPassphraseEnv)
+		if passphrase == "" {
+			return "", "", errors.Errorf("[service] environment variable %s is not set", c.EncryptionPassphraseEnv)
+		}
+		return "", passphrase, nil
+	}
+	return c.EncryptionKey, "", nil
+}
+
+// publisher returns the event publisher of the configured provider.
+func (c *EventsConfig) publisher() (EventPublisher, error) {
+	if c.URL == "" {
+		return nil, errors.New("[service] events url is not set")
+	}
+	topic := c.Topic
+	if topic == "" {
+		topic = "mail.events"
+	}
+	switch c.Provider {
+	case "nats":
+		return NewNATSEventPublisher(c.URL, topic)
+	case "kafka":
+		return NewKafkaEventPublisher(nil, c.URL, topic)
+	}
+	return nil, errors.Errorf("[service] unknown events provider %q", c.Provider)
+}
+
+// keyWrapper returns the key wrapper of the configured provider.
+func (c *KMSConfig) keyWrapper() (KeyWrapper, error) {
+	if c.KeyID == "" {
+		return nil, errors.New("[service] kms key_id is not set")
+	}
+	switch c.Provider {
+	case "aws":
+		return NewAWSKMSKeyWrapper(context.Background(), c.KeyID, c.Region)
+	case "vault":
+		if c.Address == "" {
+			return nil, errors.New("[service] kms address is not set")
+		}
+		mount, tokenEnv := c.Mount, c.TokenEnv
+		if mount == "" {
+			mount = "transit"
+		}
+		if tokenEnv == "" {
+			tokenEnv = "VAULT_TOKEN"
+		}
+		token := os.Getenv(tokenEnv)
+		if token == "" {
+			return nil, errors.Errorf("[service] environment variable %s is not set", tokenEnv)
+		}
+		return NewVaultKeyWrapper(c.Address, token, mount, c.KeyID), nil
+	}
+	return nil, errors.Errorf("[service] unknown kms provider %q", c.Provider)
+}
@@ -0,0 +1,195 @@
+package service_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/andyfusniak/squishy-mailer-lite/entity"
+	"github.com/andyfusniak/squishy-mailer-lite/service"
+	"github.com/stretchr/testify/assert"
+)
+
+func writeConfigFile(t *testing.T, name, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("os.WriteFile failed: %+v", err)
+	}
+	return path
+}
+
+func TestLoadConfig(t *testing.T) {
+	yamlPath := writeConfigFile(t, "mailer.yaml", `
+db: /var/lib/mailer/mailer.db
+sqlite3:
+  synchronous: full
+  busy_timeout: 30s
+encryption_key_env: MAILER_KEY
+log_level: debug
+cache_ttl: 1m
+events:
+  provider: kafka
+  url: http://rest-proxy:8082
+worker:
+  concurrency: 4
+  poll_interval: 10s
+  claim_lease: 30m
+retry:
+  max_attempts: 5
+  initial_backoff: 30s
+  max_backoff: 1h
+transport:
+  port: 465
+  tls_mode: tls
+maintenance:
+  checkpoint_interval: 10m
+  vacuum_interval: 24h
+  mail_retention: 720h
+  archive_dir: /var/lib/mailer/archive
+`)
+	tomlPath := writeConfigFile(t, "mailer.toml", `
+db = "/var/lib/mailer/mailer.db"
+encryption_key_env = "MAILER_KEY"
+log_level = "debug"
+cache_ttl = "1m"
+
+[sqlite3]
+synchronous = "full"
+busy_timeout = "30s"
+
+[events]
+provider = "kafka"
+url = "http://rest-proxy:8082"
+
+[worker]
+concurrency = 4
+poll_interval = "10s"
+claim_lease = "30m"
+
+[retry]
+max_attempts = 5
+initial_backoff = "30s"
+max_backoff = "1h"
+
+[transport]
+port = 465
+tls_mode = "tls"
+
+[maintenance]
+checkpoint_interval = "10m"
+vacuum_interval = "24h"
+mail_retention = "720h"
+archive_dir = "/var/lib/mailer/archive"
+`)
+
+	want := &service.Config{
+		DB: "/var/lib/mailer/mailer.db",
+		Sqlite3: service.Sqlite3Pragmas{
+			Synchronous: "full",
+			BusyTimeout: service.Duration(30 * time.Second),
+		},
+		EncryptionKeyEnv: "MAILER_KEY",
+		LogLevel:         "debug",
+		CacheTTL:         service.Duration(time.Minute),
+		Events: service.EventsConfig{
+			Provider: "kafka",
+			URL:      "http://rest-proxy:8082",
+		},
+		Worker: service.WorkerConfig{
+			Concurrency:  4,
+			PollInterval: service.Duration(10 * time.Second),
+			ClaimLease:   service.Duration(30 * time.Minute),
+		},
+		Retry: service.RetryConfig{
+			MaxAttempts:    5,
+			InitialBackoff: service.Duration(30 * time.Second),
+			MaxBackoff:     service.Duration(time.Hour),
+		},
+		Transport: service.SMTPTransportDefaults{
+			Port:    465,
+			TLSMode: entity.SMTPTLSModeTLS,
+		},
+		Maintenance: service.MaintenanceConfig{
+			CheckpointInterval: service.Duration(10 * time.Minute),
+			VacuumInterval:     service.Duration(24 * time.Hour),
+			MailRetention:      service.Duration(720 * time.Hour),
+			ArchiveDir:         "/var/lib/mailer/archive",
+		},
+	}
+	for _, path := range []string{yamlPath, tomlPath} {
+		cfg, err := service.LoadConfig(path)
+		if err != nil {
+			t.Fatalf("service.LoadConfig(%s) failed: %+v", filepath.Base(path), err)
+		}
+		assert.Equal(t, want, cfg, filepath.Base(path))
+	}
+
+	for _, name := range []string{"typo.yaml", "typo.toml"} {
+		path := writeConfigFile(t, name, `log_levle = "info"`)
+		if filepath.Ext(name) == ".yaml" {
+			path = writeConfigFile(t, name, `log_levle: info`)
+		}
+		_, err := service.LoadConfig(path)
+		assert.Error(t, err, name)
+	}
+}
+
+func TestNewEmailServiceFromConfig(t *testing.T) {
+	srv := newFakeSMTPServer(t)
+	dir := t.TempDir()
+	keyFile := filepath.Join(dir, "key")
+	if err := os.WriteFile(keyFile, []byte(testEncryptionKey+"\n"), 0o600); err != nil {
+		t.Fatalf("os.WriteFile failed: %+v", err)
+	}
+	path := writeConfigFile(t, "mailer.yaml", `
+db: `+filepath.Join(dir, "mailer.db")+`
+encryption_key_file: `+keyFile+`
+worker:
+  concurrency: 3
+transport:
+  port: 2525
+`)
+
+	svc, err := service.NewEmailServiceFromConfig(path)
+	if err != nil {
+		t.Fatalf("service.NewEmailServiceFromConfig failed: %+v", err)
+	}
+	defer svc.Close()
+	setupQueueProject(t, svc, srv)
+
+	ctx := context.Background()
+	tr, err := svc.CreateSMTPTransport(ctx, entity.CreateSMTPTransport{
+		ID:        "tr2",
+		ProjectID: "p1",
+		Name:      "Transport Two",
+		Host:      "smtp.example.com",
+		EmailFrom: "from@example.com",
+	})
+	if err != nil {
+		t.Fatalf("svc.CreateSMTPTransport failed: %+v", err)
+	}
+	assert.Equal(t, 2525, tr.Port)
+
+	// the emails are delivered by three workers
+	for i := 0; i < 5; i++ {
+		queueTestEmail(t, svc)
+	}
+	n, err := svc.ProcessMailQueue(ctx)
+	if err != nil {
+		t.Fatalf("svc.ProcessMailQueue failed: %+v", err)
+	}
+	assert.Equal(t, 5, n)
+	assert.Len(t, srv.Messages(), 5)
+
+	// only one source of the encryption key may be given
+	path = writeConfigFile(t, "both.yaml", `
+encryption_key: `+testEncryptionKey+`
+encryption_key_env: MAILER_KEY
+`)
+	_, err = service.NewEmailServiceFromConfig(path)
+	assert.Error(t, err)
+}
@@ -0,0 +1,289 @@
+package service
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/andyfusniak/squishy-mailer-lite/entity"
+	"github.com/andyfusniak/squishy-mailer-lite/internal/store"
+	"github.com/pkg/errors"
+)
+
+// CreateContactList creates an empty contact list for a project. If the ID
+// is already used in the project an error is returned with a code of
+// ErrContactListExistsCode.
+func (s *Service) CreateContactList(ctx context.Context, params entity.CreateContactListParams) (*entity.ContactList, error) {
+	if err := s.idPolicy.validate("contact list", params.ID); err != nil {
+		return nil, err
+	}
+	obj, err := s.store.InsertContactList(ctx, store.AddContactList{
+		ListID:    params.ID,
+		ProjectID: params.ProjectID,
+		ListName:  params.Name,
+	})
+	if err != nil {
+		if serr := serviceErrorFromStore(err); serr != nil {
+			return nil, serr
+		}
+		return nil, errors.Wrapf(err, "[service] store.InsertContactList failed")
+	}
+	if err := checkProjectScope("contact list", params.ProjectID, obj.ProjectID); err != nil {
+		return nil, err
+	}
+	return contactListFromStoreObject(obj), nil
+}
+
+// GetContactList retrieves a contact list along with its number of
+// contacts. If the list is not found an error is returned with a code of
+// ErrContactListNotFoundCode.
+func (s *Service) GetContactList(ctx context.Context, projectID, listID string) (*entity.ContactList, error) {
+	obj, err := s.store.GetContactList(ctx, projectID, listID)
+	if err != nil {
+		if serr := serviceErrorFromStore(err); serr != nil {
+			return nil, serr
+		}
+		return nil, errors.Wrapf(err, "[service] store.GetContactList failed")
+	}
+	if err := checkProjectScope("contact list", projectID, obj.ProjectID); err != nil {
+		return nil, err
+	}
+	return contactListFromStoreObject(obj), nil
+}
+
+// ListContactLists lists the contact lists of a project ordered by id.
+func (s *Service) ListContactLists(ctx context.Context, projectID string) ([]*entity.ContactList, error) {
+	objs, err := s.store.ListContactLists(ctx, projectID)
+	if err != nil {
+		return nil, errors.Wrapf(err, "[service] store.ListContactLists failed")
+	}
+	list := make([]*entity.ContactList, 0, len(objs))
+	for _, obj := range objs {
+		if err := checkProjectScope("contact list", projectID, obj.ProjectID); err != nil {
+			return nil, err
+		}
+		list = append(list, contactListFromStoreObject(obj))
+	}
+	return list, nil
+}
+
+// DeleteContactList deletes a contact list along with its contacts.
+// Emails already queued for its contacts are not affected. If the list is
+// not found an error is returned with a code of ErrContactListNotFoundCode.
+func (s *Service) DeleteContactList(ctx context.Context, projectID, listID string) error {
+	if err := s.store.DeleteContactList(ctx, projectID, listID); err != nil {
+		if serr := serviceErrorFromStore(err); serr != nil {
+			return serr
+		}
+		return errors.Wrapf(err, "[service] store.DeleteContactList failed")
+	}
+	return nil
+}
+
+// AddContacts adds contacts to a list, replacing the attributes, locale
+// and timezone of any address already on it. Addresses are checked and
+// normalised in the same way as recipients and stored without a display
+// name. If any address is invalid no contacts are added and an error is
+// returned with a code of ErrInvalidAddressCode. It returns the number of
+// contacts added or replaced.
+func (s *Service) AddContacts(ctx context.Context, projectID, listID string, contacts []entity.Contact) (int, error) {
+	emails := make([]string, 0, len(contacts))
+	for _, c := range contacts {
+		emails = append(emails, c.Email)
+	}
+	if _, err := s.checkAddresses(ctx, addressList{"email", emails}); err != nil {
+		return 0, err
+	}
+
+	set := make([]store.SetContact, 0, len(contacts))
+	for _, c := range contacts {
+		_, addr, _ := normaliseAddress(c.Email)
+		set = append(set, store.SetContact{
+			Email:      addr,
+			Attributes: c.Attributes,
+			Locale:     c.Locale,
+			Timezone:   c.Timezone,
+		})
+	}
+	if err := s.store.SetContacts(ctx, projectID, listID, set); err != nil {
+		if serr := serviceErrorFromStore(err); serr != nil {
+			return 0, serr
+		}
+		return 0, errors.Wrapf(err, "[service] store.SetContacts failed")
+	}
+	return len(set), nil
+}
+
+// ImportContactsCSV adds the contacts read from CSV to a list as with
+// AddContacts. The first row is a header naming the columns, one of which
+// must be email. The locale and timezone columns are optional, and every
+// other column becomes an attribute of the same name. If the CSV is
+// malformed an error is returned with a code of ErrInvalidContactsCode.
+func (s *Service) ImportContactsCSV(ctx context.Context, projectID, listID string, r io.Reader) (int, error) {
+	contacts, err := parseContactsCSV(r)
+	if err != nil {
+		return 0, entity.NewServiceError(entity.ErrInvalidContactsCode, err)
+	}
+	return s.AddContacts(ctx, projectID, listID, contacts)
+}
+
+// parseContactsCSV reads contacts from CSV with a header row.
+func parseContactsCSV(r io.Reader) ([]entity.Contact, error) {
+	cr := csv.NewReader(r)
+	cr.TrimLeadingSpace = true
+	header, err := cr.Read()
+	if err == io.EOF {
+		return nil, errors.New("missing header row")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	emailCol := -1
+	seen := make(map[string]bool, len(header))
+	for i, h := range header {
+		// spreadsheets often save CSV with a byte order mark
+		if i == 0 {
+			h = strings.TrimPrefix(h, "\ufeff")
+		}
+		h = strings.TrimSpace(h)
+		if h == "" {
+			return nil, fmt.Errorf("column %d has no name", i+1)
+		}
+		if seen[strings.ToLower(h)] {
+			return nil, fmt.Errorf("column %q appears more than once", h)
+		}
+		seen[strings.ToLower(h)] = true
+		if strings.EqualFold(h, "email") {
+			emailCol = i
+		}
+		header[i] = h
+	}
+	if emailCol < 0 {
+		return nil, errors.New("missing email column")
+	}
+
+	var contacts []entity.Contact
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		line, _ := cr.FieldPos(0)
+		c := entity.Contact{Attributes: make(map[string]string)}
+		for i, v := range record {
+			switch strings.ToLower(header[i]) {
+			case "email":
+				c.Email = strings.TrimSpace(v)
+			case "locale":
+				c.Locale = strings.TrimSpace(v)
+			case "timezone":
+				c.Timezone = strings.TrimSpace(v)
+			default:
+				c.Attributes[header[i]] = v
+			}
+		}
+		if c.Email == "" {
+			return nil, fmt.Errorf("line %d has no email", line)
+		}
+		contacts = append(contacts, c)
+	}
+	return contacts, nil
+}
+
+// ListContacts lists the contacts of a list ordered by address. If the
+// list is not found an error is returned with a code of
+// ErrContactListNotFoundCode.
+func (s *Service) ListContacts(ctx context.Context, params entity.ListContactsParams) ([]*entity.Contact, error) {
+	if _, err := s.GetContactList(ctx, params.ProjectID, params.ListID); err != nil {
+		return nil, err
+	}
+	objs, err := s.store.ListContacts(ctx, store.ListContacts{
+		ProjectID: params.ProjectID,
+		ListID:    params.ListID,
+		After:     params.After,
+		Limit:     params.Limit,
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "[service] store.ListContacts failed")
+	}
+	list := make([]*entity.Contact, 0, len(objs))
+	for _, obj := range objs {
+		if err := checkProjectScope("contact", params.ProjectID, obj.ProjectID); err != nil {
+			return nil, err
+		}
+		list = append(list, contactFromStoreObject(obj))
+	}
+	return list, nil
+}
+
+// DeleteContact removes an address from a contact list. If the address is
+// not on the list an error is returned with a code of
+// ErrContactNotFoundCode.
+func (s *Service) DeleteContact(ctx context.Context, projectID, listID, email string) error {
+	if _, addr, reason := normaliseAddress(email); reason == "" {
+		email = addr
+	}
+	if err := s.store.DeleteContact(ctx, projectID, listID, email); err != nil {
+		if serr := serviceErrorFromStore(err); serr != nil {
+			return serr
+		}
+		return errors.Wrapf(err, "[service] store.DeleteContact failed")
+	}
+	return nil
+}
+
+// listRecipients returns the contacts of a list as recipients, ordered by
+// address.
+func (s *Service) listRecipients(ctx context.Context, projectID, listID string) ([]entity.EmailRecipient, error) {
+	contacts, err := s.ListContacts(ctx, entity.ListContactsParams{ProjectID: projectID, ListID: listID})
+	if err != nil {
+		return nil, err
+	}
+	recipients := make([]entity.EmailRecipient, 0, len(contacts))
+	for _, c := range contacts {
+		recipients = append(recipients, contactRecipient(*c))
+	}
+	return recipients, nil
+}
+
+// contactRecipient returns a contact as a recipient with its attributes as
+// the recipient's params.
+func contactRecipient(c entity.Contact) entity.EmailRecipient {
+	r := entity.EmailRecipient{
+		Email:    c.Email,
+		Locale:   c.Locale,
+		Timezone: c.Timezone,
+	}
+	if len(c.Attributes) > 0 {
+		r.TemplateParams = c.Attributes
+	}
+	return r
+}
+
+func contactListFromStoreObject(obj *store.ContactList) *entity.ContactList {
+	return &entity.ContactList{
+		ID:         obj.ListID,
+		ProjectID:  obj.ProjectID,
+		Name:       obj.ListName,
+		Contacts:   obj.Contacts,
+		CreatedAt:  entity.ISOTime(obj.CreatedAt),
+		ModifiedAt: entity.ISOTime(obj.ModifiedAt),
+	}
+}
+
+func contactFromStoreObject(obj *store.Contact) *entity.Contact {
+	return &entity.Contact{
+		Email:      obj.Email,
+		Attributes: obj.Attributes,
+		Locale:     obj.Locale,
+		Timezone:   obj.Timezone,
+		CreatedAt:  entity.ISOTime(obj.CreatedAt),
+		ModifiedAt: entity.ISOTime(obj.ModifiedAt),
+	}
+}
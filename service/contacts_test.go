@@ -0,0 +1,131 @@
+package service_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/andyfusniak/squishy-mailer-lite/entity"
+	"github.com/andyfusniak/squishy-mailer-lite/service"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestContactLists(t *testing.T) {
+	stores := []struct {
+		name string
+		opts []service.Option
+	}{
+		{"sqlite3", nil},
+		{"memory", []service.Option{service.WithInMemoryStore()}},
+	}
+	for _, tc := range stores {
+		t.Run(tc.name, func(t *testing.T) {
+			srv := newFakeSMTPServer(t)
+			svc := newTestService(t, tc.opts...)
+			setupQueueProject(t, svc, srv)
+			ctx := context.Background()
+
+			l, err := svc.CreateContactList(ctx, entity.CreateContactListParams{
+				ID:        "customers",
+				ProjectID: "p1",
+				Name:      "Customers",
+			})
+			if err != nil {
+				t.Fatalf("svc.CreateContactList failed: %+v", err)
+			}
+			assert.Equal(t, 0, l.Contacts)
+			_, err = svc.CreateContactList(ctx, entity.CreateContactListParams{ID: "customers", ProjectID: "p1"})
+			assertServiceErrorCode(t, err, entity.ErrContactListExistsCode)
+
+			_, err = svc.ImportContactsCSV(ctx, "p1", "customers", strings.NewReader("name,locale\nAnn,en\n"))
+			assertServiceErrorCode(t, err, entity.ErrInvalidContactsCode)
+			_, err = svc.ImportContactsCSV(ctx, "p1", "customers", strings.NewReader("email,name\nnot-an-address,Ann\n"))
+			assertServiceErrorCode(t, err, entity.ErrInvalidAddressCode)
+			_, err = svc.ImportContactsCSV(ctx, "p1", "nope", strings.NewReader("email\nann@example.com\n"))
+			assertServiceErrorCode(t, err, entity.ErrContactListNotFoundCode)
+
+			n, err := svc.ImportContactsCSV(ctx, "p1", "customers", strings.NewReader(
+				"Email,name,timezone\n"+
+					"ann@EXAMPLE.com,Ann,Europe/London\n"+
+					"bob@example.com,Bob,\n"))
+			if err != nil {
+				t.Fatalf("svc.ImportContactsCSV failed: %+v", err)
+			}
+			assert.Equal(t, 2, n)
+
+			// adding an address already on the list replaces it
+			if _, err := svc.AddContacts(ctx, "p1", "customers", []entity.Contact{
+				{Email: "bob@example.com", Attributes: map[string]string{"name": "Robert"}},
+			}); err != nil {
+				t.Fatalf("svc.AddContacts failed: %+v", err)
+			}
+			contacts, err := svc.ListContacts(ctx, entity.ListContactsParams{ProjectID: "p1", ListID: "customers"})
+			if err != nil {
+				t.Fatalf("svc.ListContacts failed: %+v", err)
+			}
+			if assert.Len(t, contacts, 2) {
+				assert.Equal(t, "ann@example.com", contacts[0].Email)
+				assert.Equal(t, "Europe/London", contacts[0].Timezone)
+				assert.Equal(t, map[string]string{"name": "Ann"}, contacts[0].Attributes)
+				assert.Equal(t, map[string]string{"name": "Robert"}, contacts[1].Attributes)
+			}
+			l, err = svc.GetContactList(ctx, "p1", "customers")
+			if err != nil {
+				t.Fatalf("svc.GetContactList failed: %+v", err)
+			}
+			assert.Equal(t, 2, l.Contacts)
+
+			// a merge send to the list renders each contact's attributes
+			results := svc.SendEmailToManyAsync(ctx, entity.SendEmailToManyParams{
+				TemplateID:     "t1",
+				ProjectID:      "p1",
+				TransportID:    "tr1",
+				TemplateParams: map[string]any{"name": "customer"},
+				Recipients:     []entity.EmailRecipient{{Email: "cat@example.com"}},
+				ListID:         "customers",
+			})
+			if assert.Len(t, results, 3) {
+				for _, res := range results {
+					assert.NoError(t, res.Err)
+				}
+				assert.Equal(t, []string{"cat@example.com"}, results[0].MailQueue.To)
+				assert.Contains(t, results[1].MailQueue.Text, "Hello Ann")
+				assert.Contains(t, results[2].MailQueue.Text, "Hello Robert")
+			}
+			results = svc.SendEmailToManyAsync(ctx, entity.SendEmailToManyParams{
+				TemplateID: "t1",
+				ProjectID:  "p1",
+				ListID:     "nope",
+			})
+			if assert.Len(t, results, 1) {
+				assertServiceErrorCode(t, results[0].Err, entity.ErrContactListNotFoundCode)
+			}
+
+			c, _, err := svc.CreateCampaign(ctx, entity.CreateCampaignParams{
+				ID:          "launch",
+				ProjectID:   "p1",
+				TemplateID:  "t1",
+				TransportID: "tr1",
+				ListID:      "customers",
+			})
+			if err != nil {
+				t.Fatalf("svc.CreateCampaign failed: %+v", err)
+			}
+			assert.Equal(t, "customers", c.ListID)
+			assert.Equal(t, 2, c.Recipients)
+
+			err = svc.DeleteContact(ctx, "p1", "customers", "ann@example.com")
+			if err != nil {
+				t.Fatalf("svc.DeleteContact failed: %+v", err)
+			}
+			err = svc.DeleteContact(ctx, "p1", "customers", "ann@example.com")
+			assertServiceErrorCode(t, err, entity.ErrContactNotFoundCode)
+
+			if err := svc.DeleteContactList(ctx, "p1", "customers"); err != nil {
+				t.Fatalf("svc.DeleteContactList failed: %+v", err)
+			}
+			_, err = svc.ListContacts(ctx, entity.ListContactsParams{ProjectID: "p1", ListID: "customers"})
+			assertServiceErrorCode(t, err, entity.ErrContactListNotFoundCode)
+		})
+	}
+}
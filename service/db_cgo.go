@@ -0,0 +1,120 @@
+//go:build cgo
+
+package service
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/andyfusniak/squishy-mailer-lite/internal/store"
+	"github.com/andyfusniak/squishy-mailer-lite/internal/store/sqlite3"
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultMaxOpenConns int    = 120
+	defaultMaxIdleConns int    = 20
+	defaultDBFilepath   string = "mailer.db"
+)
+
+// defaultStore returns the default SQLite3 store using the database file
+// path and read replica options.
+func (s *Service) defaultStore() (store.Repository, error) {
+	// if no database file path was specified use the default
+	dbfilepath := s.dbfilepath
+	if dbfilepath == "" {
+		dbfilepath = defaultDBFilepath
+	}
+	pragmas, err := s.sqlite3Pragmas()
+	if err != nil {
+		return nil, err
+	}
+	ro, rw, created, err := defaultSqlite3DBs(dbfilepath, pragmas)
+	if err != nil {
+		return nil, errors.Wrapf(err, "[service] defaultSqlite3DBs failed")
+	}
+	if created {
+		s.logger.Info("created database", "path", dbfilepath)
+	}
+
+	// reads go to the replica, if any, falling back to the primary
+	var reader sqlite3.DBTx = ro
+	if s.replicaDSN != "" {
+		replica, err := sqlite3.OpenDBWithPragmas(s.replicaDSN, pragmas)
+		if err != nil {
+			return nil, errors.Wrapf(err, "[service] sqlite3.OpenDBWithPragmas replica failed")
+		}
+		replica.SetMaxOpenConns(defaultMaxOpenConns)
+		replica.SetMaxIdleConns(defaultMaxIdleConns)
+		replica.SetConnMaxIdleTime(5 * time.Minute)
+		reader = sqlite3.NewReplicaDB(ro, replica)
+	}
+	return sqlite3.NewStore(reader, rw), nil
+}
+
+// defaultSqlite3DBs opens the read-only and read-write connections to the
+// database file, creating the schema if the file does not exist. It
+// reports whether the database was created.
+func defaultSqlite3DBs(dbfilepath string, pragmas sqlite3.Pragmas) (ro, rw *sql.DB, created bool, err error) {
+	// check if the database file exists
+	var shouldCreateDB bool
+	if _, err := os.Stat(dbfilepath); os.IsNotExist(err) {
+		shouldCreateDB = true
+	}
+
+	// set up two database connections; one read-only with high concurrency
+	// and one read-write for non-concurrent queries
+	ro, err = sqlite3.OpenDBWithPragmas(dbfilepath, pragmas)
+	if err != nil {
+		return nil, nil, false, err
+	}
+	ro.SetMaxOpenConns(defaultMaxOpenConns)
+	ro.SetMaxIdleConns(defaultMaxIdleConns)
+	ro.SetConnMaxIdleTime(5 * time.Minute)
+
+	rw, err = sqlite3.OpenDBWithPragmas(dbfilepath, pragmas)
+	if err != nil {
+		return nil, nil, false, err
+	}
+	rw.SetMaxOpenConns(1)
+	rw.SetMaxIdleConns(1)
+	rw.SetConnMaxIdleTime(5 * time.Minute)
+
+	// if the database file did not exist, create the schema
+	if shouldCreateDB {
+		if err := sqlite3.CreateSqliteDBSchema(rw); err != nil {
+			return nil, nil, false, fmt.Errorf("[service] failed to create database schema: %w", err)
+		}
+	}
+
+	return ro, rw, shouldCreateDB, nil
+}
+
+// sqlite3Pragmas returns the pragmas given by WithSqlite3Pragmas, with
+// the defaults in place of empty fields.
+func (s *Service) sqlite3Pragmas() (sqlite3.Pragmas, error) {
+	p := sqlite3.DefaultPragmas
+	if s.pragmas.JournalMode != "" {
+		p.JournalMode = s.pragmas.JournalMode
+	}
+	if s.pragmas.Synchronous != "" {
+		p.Synchronous = s.pragmas.Synchronous
+	}
+	if s.pragmas.BusyTimeout != 0 {
+		p.BusyTimeout = time.Duration(s.pragmas.BusyTimeout)
+	}
+	switch strings.ToLower(s.pragmas.ForeignKeys) {
+	case "":
+	case "on":
+		p.ForeignKeys = true
+	case "off":
+		p.ForeignKeys = false
+	default:
+		return p, errors.Errorf("[service] invalid sqlite3 foreign_keys %q - must be on or off",
+			s.pragmas.ForeignKeys)
+	}
+	return p, nil
+}
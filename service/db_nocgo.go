@@ -0,0 +1,15 @@
+//go:build !cgo
+
+package service
+
+import (
+	"github.com/andyfusniak/squishy-mailer-lite/internal/store"
+	"github.com/pkg/errors"
+)
+
+// defaultStore returns an error because the default SQLite3 store
+// requires cgo. Use WithInMemoryStore or WithStore instead.
+func (s *Service) defaultStore() (store.Repository, error) {
+	return nil, errors.New(
+		"[service] the default sqlite3 store requires cgo use WithInMemoryStore or WithStore options")
+}
@@ -0,0 +1,110 @@
+package service
+
+import (
+	"context"
+
+	"github.com/andyfusniak/squishy-mailer-lite/entity"
+	"github.com/andyfusniak/squishy-mailer-lite/internal/store"
+	"github.com/pkg/errors"
+)
+
+// SetDefaultTransport marks a transport as the project's default. Emails
+// sent without a transport id are sent using the default transport. An
+// empty transportID clears the default.
+func (s *Service) SetDefaultTransport(ctx context.Context, projectID, transportID string) (*entity.Project, error) {
+	if transportID != "" {
+		if err := s.checkTransport(ctx, transportID, projectID); err != nil {
+			return nil, err
+		}
+	}
+
+	obj, err := s.store.SetProjectDefaultTransport(ctx, projectID, transportID)
+	if err != nil {
+		if serr := serviceErrorFromStore(err); serr != nil {
+			return nil, serr
+		}
+		return nil, errors.Wrapf(err, "[service] store.SetProjectDefaultTransport failed")
+	}
+	if err := checkProjectScope("project", projectID, obj.ProjectID); err != nil {
+		return nil, err
+	}
+	return projectFromStoreObject(obj), nil
+}
+
+// SetDefaultGroup marks a group as the project's default. Templates
+// created without a group id are placed in the default group. An empty
+// groupID clears the default.
+func (s *Service) SetDefaultGroup(ctx context.Context, projectID, groupID string) (*entity.Project, error) {
+	if groupID != "" {
+		g, err := s.store.GetGroup(ctx, projectID, groupID)
+		if err != nil {
+			if serr := serviceErrorFromStore(err); serr != nil {
+				return nil, serr
+			}
+			return nil, errors.Wrapf(err, "[service] store.GetGroup failed")
+		}
+		if err := checkProjectScope("group", projectID, g.ProjectID); err != nil {
+			return nil, err
+		}
+	}
+
+	obj, err := s.store.SetProjectDefaultGroup(ctx, projectID, groupID)
+	if err != nil {
+		if serr := serviceErrorFromStore(err); serr != nil {
+			return nil, serr
+		}
+		return nil, errors.Wrapf(err, "[service] store.SetProjectDefaultGroup failed")
+	}
+	if err := checkProjectScope("project", projectID, obj.ProjectID); err != nil {
+		return nil, err
+	}
+	return projectFromStoreObject(obj), nil
+}
+
+// resolveTransportID returns transportID, or the project's default
+// transport if transportID is empty.
+func (s *Service) resolveTransportID(ctx context.Context, projectID, transportID string) (string, error) {
+	if transportID != "" {
+		return transportID, nil
+	}
+	project, err := s.defaultsProject(ctx, projectID)
+	if err != nil {
+		return "", err
+	}
+	if project.DefaultTransportID == "" {
+		return "", entity.NewServiceError(entity.ErrNoDefaultTransportCode,
+			errors.Errorf("project %q has no default transport", projectID))
+	}
+	return project.DefaultTransportID, nil
+}
+
+// resolveGroupID returns groupID, or the project's default group if
+// groupID is empty.
+func (s *Service) resolveGroupID(ctx context.Context, projectID, groupID string) (string, error) {
+	if groupID != "" {
+		return groupID, nil
+	}
+	project, err := s.defaultsProject(ctx, projectID)
+	if err != nil {
+		return "", err
+	}
+	if project.DefaultGroupID == "" {
+		return "", entity.NewServiceError(entity.ErrNoDefaultGroupCode,
+			errors.Errorf("project %q has no default group", projectID))
+	}
+	return project.DefaultGroupID, nil
+}
+
+func (s *Service) defaultsProject(ctx context.Context, projectID string) (*store.Project, error) {
+	project, err := s.store.GetProject(ctx, projectID)
+	if err != nil {
+		if serr := serviceErrorFromStore(err); serr != nil {
+			return nil, serr
+		}
+		return nil, errors.Wrapf(err, "[service] store.GetProject failed")
+	}
+	if err := checkProjectScope("project", projectID, project.ProjectID); err != nil {
+		return nil, err
+	}
+	return project, nil
+}
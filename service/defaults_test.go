@@ -0,0 +1,95 @@
+package service_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/andyfusniak/squishy-mailer-lite/entity"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDefaultTransport(t *testing.T) {
+	srv := newFakeSMTPServer(t)
+	svc := newTestService(t)
+	setupQueueProject(t, svc, srv)
+
+	ctx := context.Background()
+	params := entity.SendEmailParams{
+		TemplateID:     "t1",
+		ProjectID:      "p1",
+		To:             []string{"to@example.com"},
+		Subject:        "Welcome",
+		TemplateParams: map[string]string{"name": "Andy"},
+	}
+
+	// without a default the transport id is required
+	err := svc.SendEmail(ctx, params)
+	assertServiceErrorCode(t, err, entity.ErrNoDefaultTransportCode)
+
+	p, err := svc.SetDefaultTransport(ctx, "p1", "tr1")
+	if err != nil {
+		t.Fatalf("svc.SetDefaultTransport failed: %+v", err)
+	}
+	assert.Equal(t, "tr1", p.DefaultTransportID)
+
+	if err := svc.SendEmail(ctx, params); err != nil {
+		t.Fatalf("svc.SendEmail failed: %+v", err)
+	}
+	assert.Len(t, srv.Messages(), 1)
+
+	mq, err := svc.SendEmailAsync(ctx, params)
+	if err != nil {
+		t.Fatalf("svc.SendEmailAsync failed: %+v", err)
+	}
+	assert.Equal(t, "tr1", mq.TransportID)
+
+	// clearing the default makes the transport id required again
+	if _, err := svc.SetDefaultTransport(ctx, "p1", ""); err != nil {
+		t.Fatalf("svc.SetDefaultTransport failed: %+v", err)
+	}
+	_, err = svc.SendEmailAsync(ctx, params)
+	assertServiceErrorCode(t, err, entity.ErrNoDefaultTransportCode)
+}
+
+func TestDefaultGroup(t *testing.T) {
+	svc := newTestService(t)
+	setupTwoProjects(t, svc)
+
+	ctx := context.Background()
+	tmpl := entity.CreateTemplate{
+		ID:        "t2",
+		ProjectID: "pa",
+		Text:      `{{define "layout"}}text{{end}}`,
+		HTML:      `{{define "layout"}}html{{end}}`,
+	}
+	_, err := svc.CreateTemplate(ctx, tmpl)
+	assertServiceErrorCode(t, err, entity.ErrNoDefaultGroupCode)
+
+	if _, err := svc.SetDefaultGroup(ctx, "pa", "g1"); err != nil {
+		t.Fatalf("svc.SetDefaultGroup failed: %+v", err)
+	}
+	created, err := svc.CreateTemplate(ctx, tmpl)
+	if err != nil {
+		t.Fatalf("svc.CreateTemplate failed: %+v", err)
+	}
+	assert.Equal(t, "g1", created.GroupID)
+
+	p, err := svc.GetProject(ctx, "pa")
+	if err != nil {
+		t.Fatalf("svc.GetProject failed: %+v", err)
+	}
+	assert.Equal(t, "g1", p.DefaultGroupID)
+}
+
+func TestSetDefaultsCrossProject(t *testing.T) {
+	svc := newTestService(t)
+	setupTwoProjects(t, svc)
+
+	// transport tr1 and group g1 belong to project pa
+	ctx := context.Background()
+	_, err := svc.SetDefaultTransport(ctx, "pb", "tr1")
+	assertServiceErrorCode(t, err, entity.ErrTransportNotFoundCode)
+
+	_, err = svc.SetDefaultGroup(ctx, "pb", "g1")
+	assertServiceErrorCode(t, err, entity.ErrGroupNotFoundCode)
+}
@@ -0,0 +1,179 @@
+package service
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/andyfusniak/squishy-mailer-lite/entity"
+	"github.com/andyfusniak/squishy-mailer-lite/internal/store"
+	"github.com/pkg/errors"
+)
+
+// defaultEventBuffer is the number of events held for a subscriber when
+// the filter does not set a Buffer.
+const defaultEventBuffer = 64
+
+// mailStates are the states an email in the mail queue can be in.
+var mailStates = []entity.MailState{
+	entity.MailStateQueued,
+	entity.MailStateSending,
+	entity.MailStateSent,
+	entity.MailStateFailed,
+	entity.MailStateBlocked,
+	entity.MailStateCancelled,
+	entity.MailStateRateLimited,
+	entity.MailStateBounced,
+	entity.MailStateDeadLetter,
+	entity.MailStatePaused,
+}
+
+// Subscribe returns a channel that receives the changes in state of the
+// emails in the mail queue matching filter, so that an application can
+// follow its emails without polling. Events are sent when an email is
+// queued or blocked, claimed for sending, sent, deferred, fails an attempt
+// and when it is bounced, retried, cancelled or requeued. Emails paused or
+// resumed with their campaign are not reported, nor are changes made by
+// other processes using the same database.
+//
+// Events are sent without waiting for the subscriber, so a subscriber that
+// falls more than filter.Buffer events behind misses events. The channel is
+// closed once ctx is done or the service is shut down, after the sends in
+// flight have finished. If filter.ProjectID does not exist an error is
+// returned with a code of ErrProjectNotFoundCode, and if filter.States or
+// filter.Buffer is invalid one with a code of ErrInvalidEventFilterCode.
+func (s *Service) Subscribe(ctx context.Context, filter entity.MailEventFilter) (<-chan entity.MailEvent, error) {
+	for _, state := range filter.States {
+		if !slices.Contains(mailStates, state) {
+			return nil, entity.NewServiceError(entity.ErrInvalidEventFilterCode,
+				errors.Errorf("unknown mail state %q", state))
+		}
+	}
+	if filter.Buffer < 0 {
+		return nil, entity.NewServiceError(entity.ErrInvalidEventFilterCode,
+			errors.Errorf("buffer %d is negative", filter.Buffer))
+	}
+	if filter.ProjectID != "" {
+		project, err := s.store.GetProject(ctx, filter.ProjectID)
+		if err != nil {
+			if serr := serviceErrorFromStore(err); serr != nil {
+				return nil, serr
+			}
+			return nil, errors.Wrapf(err, "[service] store.GetProject failed")
+		}
+		if err := checkProjectScope("project", filter.ProjectID, project.ProjectID); err != nil {
+			return nil, err
+		}
+	}
+
+	buffer := filter.Buffer
+	if buffer == 0 {
+		buffer = defaultEventBuffer
+	}
+	sub := &subscriber{
+		filter: filter,
+		ch:     make(chan entity.MailEvent, buffer),
+	}
+	if !s.events.subscribe(ctx, sub) {
+		return nil, entity.NewServiceError(entity.ErrServiceClosedCode,
+			errors.New("[service] service is shutting down"))
+	}
+	return sub.ch, nil
+}
+
+// subscriber is a channel returned by Subscribe.
+type subscriber struct {
+	filter entity.MailEventFilter
+	ch     chan entity.MailEvent
+
+	// stop stops unsubscribing when the subscriber's context is done.
+	stop func() bool
+
+	// dropped counts the events dropped as the channel was full.
+	dropped atomic.Int64
+}
+
+func (sub *subscriber) matches(e entity.MailEvent) bool {
+	return (sub.filter.ProjectID == "" || sub.filter.ProjectID == e.ProjectID) &&
+		(len(sub.filter.States) == 0 || slices.Contains(sub.filter.States, e.State))
+}
+
+// eventBroker sends mail events to the subscribers of Subscribe. The zero
+// value is ready to use.
+type eventBroker struct {
+	mu     sync.Mutex
+	subs   map[*subscriber]struct{}
+	closed bool
+}
+
+// subscribe adds sub until ctx is done, reporting false if the broker is
+// closed.
+func (b *eventBroker) subscribe(ctx context.Context, sub *subscriber) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return false
+	}
+	if b.subs == nil {
+		b.subs = make(map[*subscriber]struct{})
+	}
+	b.subs[sub] = struct{}{}
+	sub.stop = context.AfterFunc(ctx, func() { b.unsubscribe(sub) })
+	return true
+}
+
+// unsubscribe removes sub and closes its channel.
+func (b *eventBroker) unsubscribe(sub *subscriber) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.subs[sub]; ok {
+		delete(b.subs, sub)
+		close(sub.ch)
+	}
+}
+
+func (b *eventBroker) publish(e entity.MailEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for sub := range b.subs {
+		if !sub.matches(e) {
+			continue
+		}
+		select {
+		case sub.ch <- e:
+		default:
+			sub.dropped.Add(1)
+		}
+	}
+}
+
+// close closes the channels of every subscriber. Later calls to subscribe
+// fail.
+func (b *eventBroker) close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	for sub := range b.subs {
+		sub.stop()
+		close(sub.ch)
+	}
+	clear(b.subs)
+}
+
+// publishMailEvent sends the current state of an email to the subscribers
+// of Subscribe. errMsg is the error of a failed delivery attempt or the
+// reason the email was blocked.
+func (s *Service) publishMailEvent(mq *store.MailQueue, errMsg string) {
+	s.events.publish(entity.MailEvent{
+		MailQueueID: mq.MailQueueID,
+		ProjectID:   mq.ProjectID,
+		TemplateID:  mq.TemplateID,
+		TransportID: mq.TransportID,
+		CampaignID:  mq.CampaignID,
+		State:       entity.MailState(mq.MState),
+		Error:       errMsg,
+		Time:        entity.ISOTime(time.Now().UTC()),
+	})
+}
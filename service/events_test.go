@@ -0,0 +1,141 @@
+package service_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/andyfusniak/squishy-mailer-lite/entity"
+	"github.com/andyfusniak/squishy-mailer-lite/service"
+	"github.com/stretchr/testify/assert"
+)
+
+// receiveEvents receives n events from ch, failing the test if they do not
+// arrive within a second.
+func receiveEvents(t *testing.T, ch <-chan entity.MailEvent, n int) []entity.MailEvent {
+	t.Helper()
+
+	events := make([]entity.MailEvent, 0, n)
+	timeout := time.After(time.Second)
+	for len(events) < n {
+		select {
+		case e, ok := <-ch:
+			if !ok {
+				t.Fatalf("channel closed after %d events, expected %d", len(events), n)
+			}
+			events = append(events, e)
+		case <-timeout:
+			t.Fatalf("received %d events, expected %d", len(events), n)
+		}
+	}
+	return events
+}
+
+func TestSubscribe(t *testing.T) {
+	stores := []struct {
+		name string
+		opts []service.Option
+	}{
+		{"sqlite3", nil},
+		{"memory", []service.Option{service.WithInMemoryStore()}},
+	}
+	for _, st := range stores {
+		t.Run(st.name, func(t *testing.T) {
+			srv := newFakeSMTPServer(t)
+			svc := newTestService(t, st.opts...)
+			setupQueueProject(t, svc, srv)
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			all, err := svc.Subscribe(ctx, entity.MailEventFilter{ProjectID: "p1"})
+			if err != nil {
+				t.Fatalf("svc.Subscribe failed: %+v", err)
+			}
+			failed, err := svc.Subscribe(ctx, entity.MailEventFilter{
+				States: []entity.MailState{entity.MailStateFailed},
+			})
+			if err != nil {
+				t.Fatalf("svc.Subscribe failed: %+v", err)
+			}
+
+			mq := queueTestEmail(t, svc)
+			if _, err := svc.ProcessMailQueue(ctx); err != nil {
+				t.Fatalf("svc.ProcessMailQueue failed: %+v", err)
+			}
+			events := receiveEvents(t, all, 3)
+			var states []entity.MailState
+			for _, e := range events {
+				assert.Equal(t, mq.ID, e.MailQueueID)
+				assert.Equal(t, "p1", e.ProjectID)
+				assert.Equal(t, "t1", e.TemplateID)
+				assert.Equal(t, "tr1", e.TransportID)
+				assert.Empty(t, e.Error)
+				states = append(states, e.State)
+			}
+			assert.Equal(t, []entity.MailState{
+				entity.MailStateQueued,
+				entity.MailStateSending,
+				entity.MailStateSent,
+			}, states)
+
+			rejected, err := svc.SendEmailAsync(ctx, entity.SendEmailParams{
+				TemplateID:     "t1",
+				ProjectID:      "p1",
+				TransportID:    "tr1",
+				To:             []string{"reject@example.com"},
+				TemplateParams: map[string]string{"name": "Andy"},
+			})
+			if err != nil {
+				t.Fatalf("svc.SendEmailAsync failed: %+v", err)
+			}
+			if _, err := svc.ProcessMailQueue(ctx); err != nil {
+				t.Fatalf("svc.ProcessMailQueue failed: %+v", err)
+			}
+			e := receiveEvents(t, failed, 1)[0]
+			assert.Equal(t, rejected.ID, e.MailQueueID)
+			assert.Equal(t, entity.MailStateFailed, e.State)
+			assert.NotEmpty(t, e.Error)
+			select {
+			case e := <-failed:
+				t.Fatalf("unexpected event %+v", e)
+			default:
+			}
+
+			// the channels are closed once ctx is done
+			cancel()
+			for e := range all {
+				assert.Equal(t, rejected.ID, e.MailQueueID)
+			}
+			_, ok := <-failed
+			assert.False(t, ok)
+		})
+	}
+}
+
+func TestSubscribeErrors(t *testing.T) {
+	svc := newTestService(t)
+	ctx := context.Background()
+
+	_, err := svc.Subscribe(ctx, entity.MailEventFilter{ProjectID: "missing"})
+	assertServiceErrorCode(t, err, entity.ErrProjectNotFoundCode)
+
+	_, err = svc.Subscribe(ctx, entity.MailEventFilter{States: []entity.MailState{"unknown"}})
+	assertServiceErrorCode(t, err, entity.ErrInvalidEventFilterCode)
+
+	_, err = svc.Subscribe(ctx, entity.MailEventFilter{Buffer: -1})
+	assertServiceErrorCode(t, err, entity.ErrInvalidEventFilterCode)
+
+	// shutting the service down closes the channels of its subscribers
+	ch, err := svc.Subscribe(ctx, entity.MailEventFilter{})
+	if err != nil {
+		t.Fatalf("svc.Subscribe failed: %+v", err)
+	}
+	if err := svc.Close(); err != nil {
+		t.Fatalf("svc.Close failed: %+v", err)
+	}
+	_, ok := <-ch
+	assert.False(t, ok)
+
+	_, err = svc.Subscribe(ctx, entity.MailEventFilter{})
+	assertServiceErrorCode(t, err, entity.ErrServiceClosedCode)
+}
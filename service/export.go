@@ -0,0 +1,237 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/andyfusniak/squishy-mailer-lite/entity"
+	"github.com/andyfusniak/squishy-mailer-lite/internal/store"
+	"github.com/pkg/errors"
+)
+
+// projectExportVersion is the version of the project export format.
+// ImportProject rejects exports with a version it does not understand.
+const projectExportVersion = 1
+
+// projectExport is the JSON document written by ExportProject. Records
+// use the snapshot archive format, except transport secrets which are
+// held in plain text so they can be encrypted with the key of the service
+// the project is imported into.
+type projectExport struct {
+	Version    int       `json:"version"`
+	ExportedAt time.Time `json:"exported_at"`
+
+	Project             snapshotProject              `json:"project"`
+	SMTPTransports      []exportSMTPTransport        `json:"smtp_transports"`
+	APITransports       []exportAPITransport         `json:"api_transports"`
+	Groups              []snapshotGroup              `json:"groups"`
+	Templates           []snapshotTemplate           `json:"templates"`
+	Partials            []snapshotPartial            `json:"partials"`
+	TemplateVariants    []snapshotTemplateVariant    `json:"template_variants"`
+	TemplateFixtures    []snapshotTemplateFixture    `json:"template_fixtures,omitempty"`
+	SendWindows         []snapshotSendWindow         `json:"send_windows"`
+	RateLimits          []snapshotRateLimit          `json:"rate_limits"`
+	SenderAllowLists    []snapshotSenderAllowList    `json:"sender_allow_lists,omitempty"`
+	MessageCatalogs     []snapshotMessageCatalog     `json:"message_catalogs"`
+	Attachments         []snapshotFile               `json:"attachments"`
+	TemplateAttachments []snapshotTemplateAttachment `json:"template_attachments"`
+	Assets              []snapshotFile               `json:"assets"`
+}
+
+type exportSMTPTransport struct {
+	snapshotSMTPTransport
+	Password string `json:"password"`
+}
+
+type exportAPITransport struct {
+	snapshotAPITransport
+	APIKey string `json:"api_key"`
+}
+
+// ExportProject writes a versioned JSON export of a project to w, with its
+// transports, groups, templates, partials, template variants and
+// fixtures, send windows, rate limits, sender allow-lists, message
+// catalogs, attachments and assets. Transport passwords and API keys are decrypted so the
+// export can be imported by a service using a different encryption key;
+// it must be stored as securely as the secrets themselves. The mail queue,
+// campaigns, contact lists, webhooks, API keys and suppression lists are
+// not included.
+func (s *Service) ExportProject(ctx context.Context, projectID string, w io.Writer) error {
+	snap, err := s.store.ReadProjectSnapshot(ctx, projectID)
+	if err != nil {
+		if serr := serviceErrorFromStore(err); serr != nil {
+			return serr
+		}
+		return errors.Wrapf(err, "[service] store.ReadProjectSnapshot failed")
+	}
+	archive := archiveFromSnapshot(snap)
+
+	export := projectExport{
+		Version:             projectExportVersion,
+		ExportedAt:          time.Now().UTC(),
+		Project:             archive.Projects[0],
+		Groups:              archive.Groups,
+		Templates:           archive.Templates,
+		Partials:            archive.Partials,
+		TemplateVariants:    archive.TemplateVariants,
+		TemplateFixtures:    archive.TemplateFixtures,
+		SendWindows:         archive.SendWindows,
+		RateLimits:          archive.RateLimits,
+		SenderAllowLists:    archive.SenderAllowLists,
+		MessageCatalogs:     archive.MessageCatalogs,
+		Attachments:         archive.Attachments,
+		TemplateAttachments: archive.TemplateAttachments,
+		Assets:              archive.Assets,
+	}
+	for _, r := range archive.SMTPTransports {
+		password, err := s.decryptSecret(r.EncryptedPassword)
+		if err != nil {
+			return errors.Wrapf(err, "[service] decrypt password of transport %q failed", r.ID)
+		}
+		r.EncryptedPassword = ""
+		export.SMTPTransports = append(export.SMTPTransports, exportSMTPTransport{
+			snapshotSMTPTransport: r,
+			Password:              password,
+		})
+	}
+	for _, r := range archive.APITransports {
+		apiKey, err := s.decryptSecret(r.EncryptedAPIKey)
+		if err != nil {
+			return errors.Wrapf(err, "[service] decrypt api key of transport %q failed", r.ID)
+		}
+		r.EncryptedAPIKey = ""
+		export.APITransports = append(export.APITransports, exportAPITransport{
+			snapshotAPITransport: r,
+			APIKey:               apiKey,
+		})
+	}
+
+	if err := json.NewEncoder(w).Encode(&export); err != nil {
+		return errors.Wrapf(err, "[service] json encode project export failed")
+	}
+	return nil
+}
+
+// ImportProject reads an export written by ExportProject and creates the
+// project and its records, keeping the original ids and timestamps.
+// Transport secrets are encrypted with the service's encryption key. If
+// the export is malformed an error is returned with a code of
+// ErrInvalidProjectExportCode. If the project already exists an error is
+// returned with a code of ErrProjectAlreadyExistsCode and nothing is
+// written.
+func (s *Service) ImportProject(ctx context.Context, r io.Reader) (*entity.Project, error) {
+	var export projectExport
+	if err := json.NewDecoder(r).Decode(&export); err != nil {
+		return nil, entity.NewServiceError(entity.ErrInvalidProjectExportCode, err)
+	}
+	if export.Version != projectExportVersion {
+		return nil, entity.NewServiceError(entity.ErrInvalidProjectExportCode,
+			fmt.Errorf("unsupported project export version %d", export.Version))
+	}
+	if err := s.idPolicy.validate("project", export.Project.ID); err != nil {
+		return nil, err
+	}
+
+	archive := snapshotArchive{
+		Projects:            []snapshotProject{export.Project},
+		Groups:              export.Groups,
+		Templates:           export.Templates,
+		Partials:            export.Partials,
+		TemplateVariants:    export.TemplateVariants,
+		TemplateFixtures:    export.TemplateFixtures,
+		SendWindows:         export.SendWindows,
+		RateLimits:          export.RateLimits,
+		SenderAllowLists:    export.SenderAllowLists,
+		MessageCatalogs:     export.MessageCatalogs,
+		Attachments:         export.Attachments,
+		TemplateAttachments: export.TemplateAttachments,
+		Assets:              export.Assets,
+	}
+	for _, r := range export.SMTPTransports {
+		encrypted, err := s.encryptSecret(r.Password)
+		if err != nil {
+			return nil, err
+		}
+		r.EncryptedPassword = encrypted
+		archive.SMTPTransports = append(archive.SMTPTransports, r.snapshotSMTPTransport)
+	}
+	for _, r := range export.APITransports {
+		encrypted, err := s.encryptSecret(r.APIKey)
+		if err != nil {
+			return nil, err
+		}
+		r.EncryptedAPIKey = encrypted
+		archive.APITransports = append(archive.APITransports, r.snapshotAPITransport)
+	}
+
+	snap, err := snapshotFromArchive(&archive)
+	if err != nil {
+		return nil, entity.NewServiceError(entity.ErrInvalidProjectExportCode, err)
+	}
+	for _, id := range recordProjectIDs(snap) {
+		if id != export.Project.ID {
+			return nil, entity.NewServiceError(entity.ErrInvalidProjectExportCode,
+				fmt.Errorf("export of project %q has a record of project %q", export.Project.ID, id))
+		}
+	}
+
+	if err := s.store.ImportProjectSnapshot(ctx, snap); err != nil {
+		if serr := serviceErrorFromStore(err); serr != nil {
+			return nil, serr
+		}
+		return nil, errors.Wrapf(err, "[service] store.ImportProjectSnapshot failed")
+	}
+	return projectFromStoreObject(snap.Projects[0]), nil
+}
+
+// recordProjectIDs returns the project id of every record in snap other
+// than the projects themselves.
+func recordProjectIDs(snap *store.Snapshot) []string {
+	var ids []string
+	for _, r := range snap.SMTPTransports {
+		ids = append(ids, r.ProjectID)
+	}
+	for _, r := range snap.APITransports {
+		ids = append(ids, r.ProjectID)
+	}
+	for _, r := range snap.Groups {
+		ids = append(ids, r.ProjectID)
+	}
+	for _, r := range snap.Templates {
+		ids = append(ids, r.ProjectID)
+	}
+	for _, r := range snap.Partials {
+		ids = append(ids, r.ProjectID)
+	}
+	for _, r := range snap.TemplateVariants {
+		ids = append(ids, r.ProjectID)
+	}
+	for _, r := range snap.TemplateFixtures {
+		ids = append(ids, r.ProjectID)
+	}
+	for _, r := range snap.SendWindows {
+		ids = append(ids, r.ProjectID)
+	}
+	for _, r := range snap.RateLimits {
+		ids = append(ids, r.ProjectID)
+	}
+	for _, r := range snap.SenderAllowLists {
+		ids = append(ids, r.ProjectID)
+	}
+	for _, r := range snap.MessageCatalogs {
+		ids = append(ids, r.ProjectID)
+	}
+	for _, r := range snap.Attachments {
+		ids = append(ids, r.ProjectID)
+	}
+	for _, r := range snap.TemplateAttachments {
+		ids = append(ids, r.ProjectID)
+	}
+	for _, r := range snap.Assets {
+		ids = append(ids, r.ProjectID)
+	}
+	return ids
+}
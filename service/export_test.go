@@ -0,0 +1,135 @@
+package service_test
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/andyfusniak/squishy-mailer-lite/entity"
+	"github.com/andyfusniak/squishy-mailer-lite/service"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExportAndImportProject(t *testing.T) {
+	stores := []struct {
+		name string
+		opts []service.Option
+	}{
+		{"sqlite3", nil},
+		{"memory", []service.Option{service.WithInMemoryStore()}},
+	}
+	for _, tc := range stores {
+		t.Run(tc.name, func(t *testing.T) {
+			srv := newFakeSMTPServer(t)
+			svc := newTestService(t, tc.opts...)
+			setupQueueProject(t, svc, srv)
+
+			ctx := context.Background()
+			if _, err := svc.CreateMailgunTransport(ctx, entity.CreateMailgunTransport{
+				ID:        "mg",
+				ProjectID: "p1",
+				Domain:    "mg.example.com",
+				APIKey:    "secret-api-key",
+				EmailFrom: "noreply@example.com",
+			}); err != nil {
+				t.Fatalf("svc.CreateMailgunTransport failed: %+v", err)
+			}
+			if _, err := svc.SetDefaultTransport(ctx, "p1", "tr1"); err != nil {
+				t.Fatalf("svc.SetDefaultTransport failed: %+v", err)
+			}
+			if _, err := svc.SetPartial(ctx, entity.SetPartialParams{
+				Name:      "footer",
+				ProjectID: "p1",
+				GroupID:   "g1",
+				Text:      "The Team",
+			}); err != nil {
+				t.Fatalf("svc.SetPartial failed: %+v", err)
+			}
+			queueTestEmail(t, svc)
+
+			err := svc.ExportProject(ctx, "nope", &bytes.Buffer{})
+			assertServiceErrorCode(t, err, entity.ErrProjectNotFoundCode)
+
+			var buf bytes.Buffer
+			if err := svc.ExportProject(ctx, "p1", &buf); err != nil {
+				t.Fatalf("svc.ExportProject failed: %+v", err)
+			}
+			assert.Contains(t, buf.String(), "secret-api-key")
+
+			// the target uses a different encryption key and already has
+			// a project of its own
+			target := newTestService(t, append(tc.opts,
+				service.WithHexEncodedEncryptionKey("00112233445566778899aabbccddeeff"))...)
+			if _, err := target.CreateProject(ctx, "other", "Other", ""); err != nil {
+				t.Fatalf("target.CreateProject failed: %+v", err)
+			}
+			p, err := target.ImportProject(ctx, bytes.NewReader(buf.Bytes()))
+			if err != nil {
+				t.Fatalf("target.ImportProject failed: %+v", err)
+			}
+			assert.Equal(t, "p1", p.ID)
+			assert.Equal(t, "tr1", p.DefaultTransportID)
+
+			tr, err := target.GetAPITransport(ctx, "mg", "p1")
+			if err != nil {
+				t.Fatalf("target.GetAPITransport failed: %+v", err)
+			}
+			assert.Equal(t, "mg.example.com", tr.Config["domain"])
+
+			partials, err := target.ListPartials(ctx, "p1", "g1")
+			if err != nil {
+				t.Fatalf("target.ListPartials failed: %+v", err)
+			}
+			if assert.Len(t, partials, 1) {
+				assert.Equal(t, "The Team", partials[0].Text)
+			}
+
+			// the mail queue is not exported
+			emails, err := target.ListMailQueue(ctx, entity.ListMailQueueParams{ProjectID: "p1"})
+			if err != nil {
+				t.Fatalf("target.ListMailQueue failed: %+v", err)
+			}
+			assert.Empty(t, emails)
+
+			// the transport password was encrypted with the target's key
+			queueTestEmail(t, target)
+			n, err := target.ProcessMailQueue(ctx)
+			if err != nil {
+				t.Fatalf("target.ProcessMailQueue failed: %+v", err)
+			}
+			assert.Equal(t, 1, n)
+			assert.Len(t, srv.Messages(), 1)
+
+			_, err = target.ImportProject(ctx, bytes.NewReader(buf.Bytes()))
+			assertServiceErrorCode(t, err, entity.ErrProjectAlreadyExistsCode)
+		})
+	}
+}
+
+func TestImportProjectValidation(t *testing.T) {
+	svc := newTestService(t)
+	setupTwoProjects(t, svc)
+
+	ctx := context.Background()
+	var buf bytes.Buffer
+	if err := svc.ExportProject(ctx, "pa", &buf); err != nil {
+		t.Fatalf("svc.ExportProject failed: %+v", err)
+	}
+
+	target := newTestService(t)
+	_, err := target.ImportProject(ctx, strings.NewReader(`{"version":99}`))
+	assertServiceErrorCode(t, err, entity.ErrInvalidProjectExportCode)
+
+	_, err = target.ImportProject(ctx, strings.NewReader(`not json`))
+	assertServiceErrorCode(t, err, entity.ErrInvalidProjectExportCode)
+
+	// every record must belong to the exported project
+	forged := strings.Replace(buf.String(), `"project_id":"pa"`, `"project_id":"pb"`, 1)
+	_, err = target.ImportProject(ctx, strings.NewReader(forged))
+	assertServiceErrorCode(t, err, entity.ErrInvalidProjectExportCode)
+
+	// nothing is written if the import fails
+	_, err = target.GetProject(ctx, "pa")
+	assertServiceErrorCode(t, err, entity.ErrProjectNotFoundCode)
+}
@@ -0,0 +1,75 @@
+package service
+
+import (
+	"context"
+	"io"
+
+	"github.com/andyfusniak/squishy-mailer-lite/entity"
+	"github.com/andyfusniak/squishy-mailer-lite/internal/email"
+	"github.com/andyfusniak/squishy-mailer-lite/internal/store"
+	"github.com/pkg/errors"
+)
+
+// fileConfigDir is the file transport config key of the directory the
+// emails are written to.
+const fileConfigDir = "dir"
+
+// WithFileTransportWriter writes the emails of file transports that have
+// no directory to w, one message after another, for example os.Stdout
+// during development.
+func WithFileTransportWriter(w io.Writer) Option {
+	return func(s *Service) {
+		s.fileWriter = w
+	}
+}
+
+// CreateFileTransport creates a transport that writes each email as a
+// complete RFC 5322 message to a .eml file in a directory instead of
+// sending it, so development and CI environments can exercise the whole
+// pipeline, including the mail queue, without network access. If the
+// directory is empty the emails are written to the writer given by
+// WithFileTransportWriter.
+func (s *Service) CreateFileTransport(ctx context.Context, params entity.CreateFileTransport) (*entity.APITransport, error) {
+	if params.Dir == "" && s.fileWriter == nil {
+		return nil, entity.NewServiceError(entity.ErrInvalidTransportCode,
+			errors.New("dir is required unless the service has a file transport writer"))
+	}
+	cfg := store.JSONObject{}
+	if params.Dir != "" {
+		cfg[fileConfigDir] = params.Dir
+	}
+	return s.createAPITransport(ctx, store.AddAPITransport{
+		APITransportID: params.ID,
+		ProjectID:      params.ProjectID,
+		TransportName:  params.Name,
+		Provider:       store.APITransportProviderFile,
+		Config:         cfg,
+		EmailFrom:      params.EmailFrom,
+		EmailFromName:  params.EmailFromName,
+		EmailReplyTo:   store.JSONArray(params.EmailReplyTo),
+	}, "")
+}
+
+func fileSender(_ context.Context, cfg TransportConfig) (email.Sender, error) {
+	return email.NewFileTransport(email.FileConfig{
+		Dir:      cfg.Config[fileConfigDir],
+		From:     cfg.EmailFrom,
+		FromName: cfg.EmailFromName,
+		ReplyTo:  cfg.EmailReplyTo,
+	}), nil
+}
+
+// fileWriterSender returns a sender for a file transport without a
+// directory, writing to the service's file transport writer.
+func (s *Service) fileWriterSender(cfg TransportConfig) (email.Sender, error) {
+	if s.fileWriter == nil {
+		return nil, errors.Errorf("[service] file transport %q has no directory and no writer is set", cfg.ID)
+	}
+	return email.NewFileTransport(email.FileConfig{
+		Writer:   s.fileWriter,
+		WriterMu: &s.fileWriterMu,
+		From:     cfg.EmailFrom,
+		FromName: cfg.EmailFromName,
+		ReplyTo:  cfg.EmailReplyTo,
+	}), nil
+}
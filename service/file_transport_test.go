@@ -0,0 +1,91 @@
+package service_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/andyfusniak/squishy-mailer-lite/entity"
+	"github.com/andyfusniak/squishy-mailer-lite/service"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileTransport(t *testing.T) {
+	srv := newFakeSMTPServer(t)
+	var buf bytes.Buffer
+	svc := newTestService(t, service.WithFileTransportWriter(&buf))
+	setupQueueProject(t, svc, srv)
+
+	ctx := context.Background()
+	dir := filepath.Join(t.TempDir(), "outbox")
+	for _, params := range []entity.CreateFileTransport{
+		{ID: "file", ProjectID: "p1", Name: "Outbox", Dir: dir, EmailFrom: "from@example.com", EmailFromName: "Example"},
+		{ID: "stdout", ProjectID: "p1", Name: "Writer", EmailFrom: "from@example.com"},
+	} {
+		tr, err := svc.CreateFileTransport(ctx, params)
+		if err != nil {
+			t.Fatalf("svc.CreateFileTransport failed: %+v", err)
+		}
+		assert.Equal(t, entity.APITransportProviderFile, tr.Provider)
+	}
+
+	params := entity.SendEmailParams{
+		TemplateID:     "t1",
+		ProjectID:      "p1",
+		TransportID:    "file",
+		To:             []string{"to@example.com"},
+		Subject:        "Welcome",
+		TemplateParams: map[string]string{"name": "Andy"},
+	}
+	if _, err := svc.SendEmailAsync(ctx, params); err != nil {
+		t.Fatalf("svc.SendEmailAsync failed: %+v", err)
+	}
+	n, err := svc.ProcessMailQueue(ctx)
+	if err != nil {
+		t.Fatalf("svc.ProcessMailQueue failed: %+v", err)
+	}
+	assert.Equal(t, 1, n)
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("os.ReadDir failed: %+v", err)
+	}
+	if assert.Len(t, entries, 1) {
+		assert.Equal(t, ".eml", filepath.Ext(entries[0].Name()))
+		f, err := os.Open(filepath.Join(dir, entries[0].Name()))
+		if err != nil {
+			t.Fatalf("os.Open failed: %+v", err)
+		}
+		defer f.Close()
+		msg, err := mail.ReadMessage(f)
+		if err != nil {
+			t.Fatalf("mail.ReadMessage failed: %+v", err)
+		}
+		assert.Equal(t, "Welcome", msg.Header.Get("Subject"))
+		assert.Equal(t, "<to@example.com>", msg.Header.Get("To"))
+		assert.Equal(t, `"Example" <from@example.com>`, msg.Header.Get("From"))
+		body, _ := io.ReadAll(msg.Body)
+		assert.Contains(t, string(body), "Hello Andy")
+	}
+	assert.Empty(t, srv.Messages())
+
+	params.TransportID = "stdout"
+	if err := svc.SendEmail(ctx, params); err != nil {
+		t.Fatalf("svc.SendEmail failed: %+v", err)
+	}
+	msg, err := mail.ReadMessage(&buf)
+	if err != nil {
+		t.Fatalf("mail.ReadMessage failed: %+v", err)
+	}
+	assert.Equal(t, "Welcome", msg.Header.Get("Subject"))
+
+	// without a writer a directory is required
+	_, err = newTestService(t).CreateFileTransport(ctx, entity.CreateFileTransport{
+		ID: "file", ProjectID: "p1", Name: "Outbox",
+	})
+	assertServiceErrorCode(t, err, entity.ErrInvalidTransportCode)
+}
@@ -0,0 +1,184 @@
+package service
+
+import (
+	"context"
+
+	"github.com/andyfusniak/squishy-mailer-lite/entity"
+	"github.com/andyfusniak/squishy-mailer-lite/internal/store"
+	"github.com/pkg/errors"
+)
+
+// SetTemplateFixture creates or replaces a named set of sample params of a
+// template. The fixture can be rendered with RenderTemplate and is checked
+// by SetTemplate when SetTemplateParams.ValidateFixtures is set.
+func (s *Service) SetTemplateFixture(ctx context.Context, params entity.SetTemplateFixtureParams) (*entity.TemplateFixture, error) {
+	if err := s.idPolicy.validate("fixture", params.Name); err != nil {
+		return nil, err
+	}
+
+	obj, err := s.store.SetTemplateFixture(ctx, store.SetTemplateFixture{
+		TemplateID: params.TemplateID,
+		ProjectID:  params.ProjectID,
+		Name:       params.Name,
+		Params:     store.JSONParams(params.Params),
+	})
+	if err != nil {
+		if serr := serviceErrorFromStore(err); serr != nil {
+			return nil, serr
+		}
+		return nil, errors.Wrapf(err, "[service] store.SetTemplateFixture failed")
+	}
+	if err := checkProjectScope("template fixture", params.ProjectID, obj.ProjectID); err != nil {
+		return nil, err
+	}
+	return templateFixtureFromStoreObject(obj), nil
+}
+
+// GetTemplateFixture retrieves a fixture of a template by name.
+func (s *Service) GetTemplateFixture(ctx context.Context, projectID, templateID, name string) (*entity.TemplateFixture, error) {
+	obj, err := s.getTemplateFixture(ctx, projectID, templateID, name)
+	if err != nil {
+		return nil, err
+	}
+	return templateFixtureFromStoreObject(obj), nil
+}
+
+// ListTemplateFixtures lists the fixtures of a template ordered by name.
+func (s *Service) ListTemplateFixtures(ctx context.Context, projectID, templateID string) ([]*entity.TemplateFixture, error) {
+	list, err := s.listTemplateFixtures(ctx, projectID, templateID)
+	if err != nil {
+		return nil, err
+	}
+
+	fixtures := make([]*entity.TemplateFixture, 0, len(list))
+	for _, obj := range list {
+		fixtures = append(fixtures, templateFixtureFromStoreObject(obj))
+	}
+	return fixtures, nil
+}
+
+// DeleteTemplateFixture deletes a fixture of a template by name.
+func (s *Service) DeleteTemplateFixture(ctx context.Context, projectID, templateID, name string) error {
+	if err := s.store.DeleteTemplateFixture(ctx, projectID, templateID, name); err != nil {
+		if serr := serviceErrorFromStore(err); serr != nil {
+			return serr
+		}
+		return errors.Wrapf(err, "[service] store.DeleteTemplateFixture failed")
+	}
+	return nil
+}
+
+// RenderTemplate renders a template without sending it, using either the
+// given template params or those of a named fixture. The project's
+// variables, message catalogs and assets are used as they would be when
+// the template is sent.
+func (s *Service) RenderTemplate(ctx context.Context, params entity.RenderTemplateParams) (*entity.RenderedTemplate, error) {
+	templateParams := params.TemplateParams
+	if params.Fixture != "" {
+		f, err := s.getTemplateFixture(ctx, params.ProjectID, params.TemplateID, params.Fixture)
+		if err != nil {
+			return nil, err
+		}
+		templateParams = map[string]any(f.Params)
+	}
+
+	c := newSendCache(s, false)
+	defer c.close()
+	r, err := c.render(ctx, params.ProjectID, params.TemplateID, params.Locale, templateParams,
+		params.StrictParams, params.PlainTextParams, false)
+	if err != nil {
+		return nil, err
+	}
+	return &entity.RenderedTemplate{
+		Subject: r.subject,
+		Text:    r.txt,
+		HTML:    r.html,
+	}, nil
+}
+
+// checkFixtures renders the template with the given group, subject and
+// bodies using the params of each of its fixtures, with strict params. It
+// returns an ErrInvalidTemplateCode service error naming the first fixture
+// that fails to render. A template with no fixtures always passes.
+func (s *Service) checkFixtures(ctx context.Context, projectID, templateID, groupID, subject string, body templateBody) error {
+	fixtures, err := s.listTemplateFixtures(ctx, projectID, templateID)
+	if err != nil || len(fixtures) == 0 {
+		return err
+	}
+
+	// the stored template has fixtures so it exists, and its asset mode is
+	// kept by SetTemplate
+	prev, err := s.store.GetTemplate(ctx, projectID, templateID)
+	if err != nil {
+		return errors.Wrapf(err, "[service] store.GetTemplate failed")
+	}
+	t := *prev
+	t.GroupID = groupID
+	t.Txt, t.TxtDigest = body.text, body.textDigest
+	t.HTML, t.HTMLDigest = body.html, body.htmlDigest
+	t.Subject = subject
+
+	partials, err := s.listPartials(ctx, projectID, groupID)
+	if err != nil {
+		return err
+	}
+	p, err := parseTemplate(&t, partials)
+	if err != nil {
+		return entity.NewServiceError(entity.ErrInvalidTemplateCode, err)
+	}
+	localizer, err := s.localizer(ctx, projectID, "")
+	if err != nil {
+		return err
+	}
+	variables, err := s.projectVariables(ctx, projectID)
+	if err != nil {
+		return err
+	}
+
+	c := &compiledTemplate{tmpl: &t, parsedTemplate: p}
+	for _, f := range fixtures {
+		if _, err := s.executeTemplate(ctx, c, localizer, variables, map[string]any(f.Params), true, s.plainParams, false); err != nil {
+			return entity.NewServiceError(entity.ErrInvalidTemplateCode,
+				errors.Wrapf(err, "fixture %q failed to render", f.Name))
+		}
+	}
+	return nil
+}
+
+func (s *Service) getTemplateFixture(ctx context.Context, projectID, templateID, name string) (*store.TemplateFixture, error) {
+	obj, err := s.store.GetTemplateFixture(ctx, projectID, templateID, name)
+	if err != nil {
+		if serr := serviceErrorFromStore(err); serr != nil {
+			return nil, serr
+		}
+		return nil, errors.Wrapf(err, "[service] store.GetTemplateFixture failed")
+	}
+	if err := checkProjectScope("template fixture", projectID, obj.ProjectID); err != nil {
+		return nil, err
+	}
+	return obj, nil
+}
+
+func (s *Service) listTemplateFixtures(ctx context.Context, projectID, templateID string) ([]*store.TemplateFixture, error) {
+	list, err := s.store.ListTemplateFixtures(ctx, projectID, templateID)
+	if err != nil {
+		return nil, errors.Wrapf(err, "[service] store.ListTemplateFixtures failed")
+	}
+	for _, obj := range list {
+		if err := checkProjectScope("template fixture", projectID, obj.ProjectID); err != nil {
+			return nil, err
+		}
+	}
+	return list, nil
+}
+
+func templateFixtureFromStoreObject(obj *store.TemplateFixture) *entity.TemplateFixture {
+	return &entity.TemplateFixture{
+		TemplateID: obj.TemplateID,
+		ProjectID:  obj.ProjectID,
+		Name:       obj.Name,
+		Params:     obj.Params,
+		CreatedAt:  entity.ISOTime(obj.CreatedAt),
+		ModifiedAt: entity.ISOTime(obj.ModifiedAt),
+	}
+}
@@ -0,0 +1,99 @@
+package service_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/andyfusniak/squishy-mailer-lite/entity"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTemplateFixtures(t *testing.T) {
+	srv := newFakeSMTPServer(t)
+	svc := newTestService(t)
+	setupQueueProject(t, svc, srv)
+
+	ctx := context.Background()
+	for name, params := range map[string]map[string]any{
+		"andy": {"name": "Andy"},
+		"jane": {"name": "Jane", "plan": "pro"},
+	} {
+		if _, err := svc.SetTemplateFixture(ctx, entity.SetTemplateFixtureParams{
+			TemplateID: "t1",
+			ProjectID:  "p1",
+			Name:       name,
+			Params:     params,
+		}); err != nil {
+			t.Fatalf("svc.SetTemplateFixture failed: %+v", err)
+		}
+	}
+
+	fixtures, err := svc.ListTemplateFixtures(ctx, "p1", "t1")
+	if err != nil {
+		t.Fatalf("svc.ListTemplateFixtures failed: %+v", err)
+	}
+	if assert.Len(t, fixtures, 2) {
+		assert.Equal(t, "andy", fixtures[0].Name)
+		assert.Equal(t, "pro", fixtures[1].Params["plan"])
+	}
+
+	rt, err := svc.RenderTemplate(ctx, entity.RenderTemplateParams{
+		ProjectID:  "p1",
+		TemplateID: "t1",
+		Fixture:    "jane",
+	})
+	if err != nil {
+		t.Fatalf("svc.RenderTemplate failed: %+v", err)
+	}
+	assert.Equal(t, "Hello Jane, this is the text body of the email", rt.Text)
+	assert.Equal(t, "<p>Hello Jane, this is the HTML body of the email</p>", rt.HTML)
+
+	rt, err = svc.RenderTemplate(ctx, entity.RenderTemplateParams{
+		ProjectID:      "p1",
+		TemplateID:     "t1",
+		TemplateParams: map[string]any{"name": "Sam"},
+	})
+	if err != nil {
+		t.Fatalf("svc.RenderTemplate failed: %+v", err)
+	}
+	assert.Equal(t, "Hello Sam, this is the text body of the email", rt.Text)
+
+	_, err = svc.RenderTemplate(ctx, entity.RenderTemplateParams{
+		ProjectID:  "p1",
+		TemplateID: "t1",
+		Fixture:    "missing",
+	})
+	assertServiceErrorCode(t, err, entity.ErrFixtureNotFoundCode)
+
+	// the andy fixture has no plan so the change is rejected and the
+	// stored template is unchanged
+	params := entity.SetTemplateParams{
+		ID:               "t1",
+		ProjectID:        "p1",
+		GroupID:          "g1",
+		Text:             `{{define "layout"}}Hello {{.name}}, you are on {{.plan}}{{end}}`,
+		HTML:             `{{define "layout"}}<p>Hello {{.name}}</p>{{end}}`,
+		ValidateFixtures: true,
+	}
+	_, err = svc.SetTemplate(ctx, params)
+	assertServiceErrorCode(t, err, entity.ErrInvalidTemplateCode)
+	tmpl, err := svc.GetTemplate(ctx, "p1", "t1")
+	if err != nil {
+		t.Fatalf("svc.GetTemplate failed: %+v", err)
+	}
+	assert.Contains(t, tmpl.Text, "this is the text body")
+
+	if err := svc.DeleteTemplateFixture(ctx, "p1", "t1", "andy"); err != nil {
+		t.Fatalf("svc.DeleteTemplateFixture failed: %+v", err)
+	}
+	if _, err := svc.SetTemplate(ctx, params); err != nil {
+		t.Fatalf("svc.SetTemplate failed: %+v", err)
+	}
+
+	_, err = svc.SetTemplateFixture(ctx, entity.SetTemplateFixtureParams{
+		TemplateID: "t1",
+		ProjectID:  "p1",
+		Name:       "no spaces",
+	})
+	assertServiceErrorCode(t, err, entity.ErrInvalidIDCode)
+}
@@ -0,0 +1,151 @@
+package service
+
+import (
+	"fmt"
+	"net/textproto"
+	"strings"
+
+	"github.com/andyfusniak/squishy-mailer-lite/entity"
+)
+
+// reservedHeaders are set by the service or the transports and cannot be
+// given as custom headers.
+var reservedHeaders = map[string]bool{
+	"Bcc":                       true,
+	"Cc":                        true,
+	"Content-Transfer-Encoding": true,
+	"Content-Type":              true,
+	"Date":                      true,
+	"From":                      true,
+	"In-Reply-To":               true,
+	"Message-Id":                true,
+	"Mime-Version":              true,
+	"References":                true,
+	"Reply-To":                  true,
+	"Return-Path":               true,
+	"Sender":                    true,
+	"Subject":                   true,
+	"To":                        true,
+}
+
+// checkHeaders validates the custom headers of an email and returns them
+// with canonical names. It returns nil if there are none.
+func checkHeaders(headers map[string]string) (map[string]string, error) {
+	if len(headers) == 0 {
+		return nil, nil
+	}
+
+	checked := make(map[string]string, len(headers))
+	for name, value := range headers {
+		if name == "" || strings.IndexFunc(name, func(r rune) bool {
+			// RFC 5322 field names are printable ASCII except colon
+			return r < '!' || r > '~' || r == ':'
+		}) >= 0 {
+			return nil, entity.NewServiceError(entity.ErrInvalidHeadersCode,
+				fmt.Errorf("invalid header name %q", name))
+		}
+		if strings.ContainsAny(value, "\r\n") {
+			return nil, entity.NewServiceError(entity.ErrInvalidHeadersCode,
+				fmt.Errorf("header %s contains a line break", name))
+		}
+
+		canonical := textproto.CanonicalMIMEHeaderKey(name)
+		if reservedHeaders[canonical] {
+			return nil, entity.NewServiceError(entity.ErrInvalidHeadersCode,
+				fmt.Errorf("header %s is set by the service", name))
+		}
+		if _, ok := checked[canonical]; ok {
+			return nil, entity.NewServiceError(entity.ErrInvalidHeadersCode,
+				fmt.Errorf("header %s is given more than once", name))
+		}
+		checked[canonical] = value
+	}
+	return checked, nil
+}
+
+// mergeHeaders returns the custom headers of an email with the headers
+// added by the service, which take precedence.
+func mergeHeaders(headers map[string]string, service ...map[string]string) map[string]string {
+	var n int
+	for _, h := range service {
+		n += len(h)
+	}
+	if n == 0 {
+		return headers
+	}
+	merged := make(map[string]string, len(headers)+n)
+	for name, value := range headers {
+		merged[name] = value
+	}
+	for _, h := range service {
+		for name, value := range h {
+			merged[textproto.CanonicalMIMEHeaderKey(name)] = value
+		}
+	}
+	return merged
+}
+
+// threading is the validated Message-ID, In-Reply-To and References of an
+// email, all without angle brackets.
+type threading struct {
+	messageID  string
+	inReplyTo  string
+	references []string
+}
+
+// checkThreading validates the Message-ID and threading params of an
+// email. The Message-ID is empty if the caller did not set one.
+func checkThreading(params entity.SendEmailParams) (threading, error) {
+	var th threading
+	var err error
+	if params.MessageID != "" {
+		if th.messageID, err = checkMessageID(params.MessageID); err != nil {
+			return threading{}, err
+		}
+	}
+	if params.InReplyTo != "" {
+		if th.inReplyTo, err = checkMessageID(params.InReplyTo); err != nil {
+			return threading{}, err
+		}
+	}
+	for _, ref := range params.References {
+		id, err := checkMessageID(ref)
+		if err != nil {
+			return threading{}, err
+		}
+		th.references = append(th.references, id)
+	}
+	if len(th.references) == 0 && th.inReplyTo != "" {
+		th.references = []string{th.inReplyTo}
+	}
+	return th, nil
+}
+
+// checkMessageID validates a Message-ID of the form left@right, with or
+// without angle brackets, and returns it without them.
+func checkMessageID(id string) (string, error) {
+	trimmed := strings.TrimSuffix(strings.TrimPrefix(strings.TrimSpace(id), "<"), ">")
+	left, right, ok := strings.Cut(trimmed, "@")
+	if !ok || left == "" || right == "" || strings.IndexFunc(trimmed, func(r rune) bool {
+		return r <= ' ' || r > '~' || r == '<' || r == '>'
+	}) >= 0 || strings.Contains(right, "@") {
+		return "", entity.NewServiceError(entity.ErrInvalidMessageIDCode,
+			fmt.Errorf("invalid message id %q", id))
+	}
+	return trimmed, nil
+}
+
+// threadHeaders returns the In-Reply-To and References headers of an email.
+func threadHeaders(inReplyTo string, references []string) map[string]string {
+	if inReplyTo == "" && len(references) == 0 {
+		return nil
+	}
+	h := make(map[string]string, 2)
+	if inReplyTo != "" {
+		h["In-Reply-To"] = "<" + inReplyTo + ">"
+	}
+	if len(references) > 0 {
+		h["References"] = "<" + strings.Join(references, "> <") + ">"
+	}
+	return h
+}
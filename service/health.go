@@ -0,0 +1,92 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/andyfusniak/squishy-mailer-lite/entity"
+	"github.com/pkg/errors"
+)
+
+// healthProbe is encrypted and decrypted to check the encryption key.
+const healthProbe = "squishy-mailer-lite health check"
+
+// Health checks that the database can be reached, that its schema is up
+// to date and not dirty, and that the encryption key can encrypt and
+// decrypt secrets. It also reports the latest verification of each
+// transport, without verifying them again, as SMTP verification connects
+// to the server. Failed checks are reported in the result rather than as
+// an error, so it is cheap enough to serve to a load balancer.
+func (s *Service) Health(ctx context.Context) *entity.Health {
+	h := entity.Health{
+		CheckedAt: entity.ISOTime(time.Now().UTC()),
+	}
+
+	h.Database = healthCheck(s.store.Ping(ctx))
+	if h.Database.OK {
+		h.Schema, h.SchemaVersion = s.schemaHealth(ctx)
+	} else {
+		h.Schema = healthCheck(errors.New("database cannot be reached"))
+	}
+	h.EncryptionKey = healthCheck(s.checkEncryptionKey())
+
+	list, err := s.store.ListTransportVerifications(ctx)
+	if err != nil {
+		s.logger.Error("list transport verifications failed", "error", err)
+	}
+	for _, r := range list {
+		h.Transports = append(h.Transports, &entity.TransportHealth{
+			ProjectID:   r.ProjectID,
+			TransportID: r.TransportID,
+			OK:          r.Error == "",
+			Error:       r.Error,
+			VerifiedAt:  entity.ISOTime(r.VerifiedAt),
+		})
+	}
+
+	h.Healthy = h.Database.OK && h.Schema.OK && h.EncryptionKey.OK
+	return &h
+}
+
+func healthCheck(err error) entity.HealthCheck {
+	if err != nil {
+		return entity.HealthCheck{Error: err.Error()}
+	}
+	return entity.HealthCheck{OK: true}
+}
+
+// schemaHealth checks that the schema is neither dirty nor behind the
+// latest migration. Stores without a schema pass.
+func (s *Service) schemaHealth(ctx context.Context) (entity.HealthCheck, *entity.SchemaVersion) {
+	v, err := s.SchemaVersion(ctx)
+	if err != nil {
+		var serr *entity.ServiceError
+		if errors.As(err, &serr) && serr.Code == entity.ErrMigrationsNotSupportedCode {
+			return healthCheck(nil), nil
+		}
+		return healthCheck(err), nil
+	}
+	switch {
+	case v.Dirty:
+		err = fmt.Errorf("migration %d failed part way through", v.Version)
+	case v.Version < v.Latest:
+		err = fmt.Errorf("%d migrations pending", v.Latest-v.Version)
+	}
+	return healthCheck(err), v
+}
+
+func (s *Service) checkEncryptionKey() error {
+	encrypted, err := s.encryptSecret(healthProbe)
+	if err != nil {
+		return err
+	}
+	plaintext, err := s.decryptSecret(encrypted)
+	if err != nil {
+		return err
+	}
+	if plaintext != healthProbe {
+		return errors.New("decrypted secret does not match")
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+package service_test
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/andyfusniak/squishy-mailer-lite/entity"
+	"github.com/andyfusniak/squishy-mailer-lite/internal/store/sqlite3"
+	"github.com/andyfusniak/squishy-mailer-lite/service"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHealth(t *testing.T) {
+	stores := []struct {
+		name string
+		opts []service.Option
+	}{
+		{"sqlite3", nil},
+		{"memory", []service.Option{service.WithInMemoryStore()}},
+	}
+	for _, tc := range stores {
+		t.Run(tc.name, func(t *testing.T) {
+			srv := newFakeSMTPServer(t)
+			svc := newTestService(t, tc.opts...)
+			setupQueueProject(t, svc, srv)
+
+			ctx := context.Background()
+			h := svc.Health(ctx)
+			assert.True(t, h.Healthy)
+			assert.True(t, h.Database.OK)
+			assert.True(t, h.Schema.OK)
+			assert.True(t, h.EncryptionKey.OK)
+			assert.Empty(t, h.Transports)
+			if tc.name == "sqlite3" && assert.NotNil(t, h.SchemaVersion) {
+				assert.Equal(t, h.SchemaVersion.Latest, h.SchemaVersion.Version)
+			}
+
+			if err := svc.VerifyTransport(ctx, "p1", "tr1"); err != nil {
+				t.Fatalf("svc.VerifyTransport failed: %+v", err)
+			}
+			h = svc.Health(ctx)
+			if assert.Len(t, h.Transports, 1) {
+				assert.Equal(t, "tr1", h.Transports[0].TransportID)
+				assert.True(t, h.Transports[0].OK)
+			}
+
+			// a failed verification is reported without affecting health
+			srv.ln.Close()
+			_ = svc.VerifyTransport(ctx, "p1", "tr1")
+			_ = svc.VerifyTransport(ctx, "p1", "missing")
+			h = svc.Health(ctx)
+			assert.True(t, h.Healthy)
+			if assert.Len(t, h.Transports, 1) {
+				assert.False(t, h.Transports[0].OK)
+				assert.NotEmpty(t, h.Transports[0].Error)
+			}
+
+			// verifications are removed with their transport
+			if err := svc.DeleteSMTPTransport(ctx, entity.DeleteSMTPTransportParams{
+				TransportID: "tr1",
+				ProjectID:   "p1",
+			}); err != nil {
+				t.Fatalf("svc.DeleteSMTPTransport failed: %+v", err)
+			}
+			assert.Empty(t, svc.Health(ctx).Transports)
+		})
+	}
+}
+
+func TestHealthDirtySchema(t *testing.T) {
+	db, err := sqlite3.OpenDB(filepath.Join(t.TempDir(), "mailer.db"))
+	if err != nil {
+		t.Fatalf("sqlite3.OpenDB failed: %+v", err)
+	}
+	db.SetMaxOpenConns(1)
+	if err := sqlite3.CreateSqliteDBSchema(db); err != nil {
+		t.Fatalf("sqlite3.CreateSqliteDBSchema failed: %+v", err)
+	}
+	svc, err := service.NewEmailService(
+		service.WithStore(sqlite3.NewStore(db, db)),
+		service.WithHexEncodedEncryptionKey(testEncryptionKey),
+	)
+	if err != nil {
+		t.Fatalf("service.NewEmailService failed: %+v", err)
+	}
+	defer svc.Close()
+
+	ctx := context.Background()
+	if err := svc.MigrateTo(ctx, 1); err != nil {
+		t.Fatalf("svc.MigrateTo failed: %+v", err)
+	}
+	h := svc.Health(ctx)
+	assert.False(t, h.Healthy)
+	assert.Contains(t, h.Schema.Error, "migrations pending")
+
+	if _, err := db.Exec(`update schema_migrations set dirty = 1`); err != nil {
+		t.Fatalf("db.Exec failed: %+v", err)
+	}
+	h = svc.Health(ctx)
+	assert.False(t, h.Healthy)
+	assert.Contains(t, h.Schema.Error, "failed part way through")
+	assert.True(t, h.Database.OK)
+}
@@ -0,0 +1,120 @@
+package service
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/andyfusniak/squishy-mailer-lite/entity"
+	"github.com/andyfusniak/squishy-mailer-lite/internal/store"
+	"github.com/nicksnyder/go-i18n/v2/i18n"
+	"github.com/pkg/errors"
+	"golang.org/x/text/language"
+)
+
+// SetMessageCatalog creates or replaces the message catalog for a project
+// and locale. Messages must be a JSON object in the go-i18n message file
+// format. Nested objects are flattened into dot separated message ids, so
+// {"welcome": {"heading": "Welcome"}} defines the message welcome.heading.
+// A message with one, other etc. keys defines plural forms which are
+// chosen using the plural rules of the locale.
+func (s *Service) SetMessageCatalog(ctx context.Context, params entity.SetMessageCatalogParams) (*entity.MessageCatalog, error) {
+	tag, err := language.Parse(params.Locale)
+	if err != nil {
+		return nil, entity.NewServiceError(entity.ErrInvalidCatalogCode,
+			fmt.Errorf("invalid locale %q: %w", params.Locale, err))
+	}
+	if _, err := parseMessageCatalog(tag.String(), params.Messages); err != nil {
+		return nil, entity.NewServiceError(entity.ErrInvalidCatalogCode, err)
+	}
+
+	obj, err := s.store.SetMessageCatalog(ctx, store.SetMessageCatalog{
+		ProjectID: params.ProjectID,
+		Locale:    tag.String(),
+		Messages:  string(params.Messages),
+	})
+	if err != nil {
+		if serr := serviceErrorFromStore(err); serr != nil {
+			return nil, serr
+		}
+		return nil, errors.Wrapf(err, "[service] store.SetMessageCatalog failed")
+	}
+	if err := checkProjectScope("message catalog", params.ProjectID, obj.ProjectID); err != nil {
+		return nil, err
+	}
+	return messageCatalogFromStoreObject(obj), nil
+}
+
+// ListMessageCatalogs lists the message catalogs for a project.
+func (s *Service) ListMessageCatalogs(ctx context.Context, projectID string) ([]*entity.MessageCatalog, error) {
+	list, err := s.store.ListMessageCatalogs(ctx, projectID)
+	if err != nil {
+		return nil, errors.Wrapf(err, "[service] store.ListMessageCatalogs failed")
+	}
+
+	catalogs := make([]*entity.MessageCatalog, 0, len(list))
+	for _, obj := range list {
+		if err := checkProjectScope("message catalog", projectID, obj.ProjectID); err != nil {
+			return nil, err
+		}
+		catalogs = append(catalogs, messageCatalogFromStoreObject(obj))
+	}
+	return catalogs, nil
+}
+
+// DeleteMessageCatalog deletes the message catalog for a project and
+// locale.
+func (s *Service) DeleteMessageCatalog(ctx context.Context, projectID, locale string) error {
+	if tag, err := language.Parse(locale); err == nil {
+		locale = tag.String()
+	}
+	if err := s.store.DeleteMessageCatalog(ctx, projectID, locale); err != nil {
+		if serr := serviceErrorFromStore(err); serr != nil {
+			return serr
+		}
+		return errors.Wrapf(err, "[service] store.DeleteMessageCatalog failed")
+	}
+	return nil
+}
+
+func messageCatalogFromStoreObject(obj *store.MessageCatalog) *entity.MessageCatalog {
+	return &entity.MessageCatalog{
+		ProjectID:  obj.ProjectID,
+		Locale:     obj.Locale,
+		Messages:   []byte(obj.Messages),
+		CreatedAt:  entity.ISOTime(obj.CreatedAt),
+		ModifiedAt: entity.ISOTime(obj.ModifiedAt),
+	}
+}
+
+func parseMessageCatalog(locale string, messages []byte) (*i18n.MessageFile, error) {
+	mf, err := i18n.ParseMessageFileBytes(messages, locale+".json", nil)
+	if err != nil {
+		return nil, errors.Wrapf(err, "[service] i18n.ParseMessageFileBytes failed")
+	}
+	return mf, nil
+}
+
+// localizer returns a localizer for the given locale using all of the
+// project's message catalogs. If the project has no catalog for the
+// locale, the closest matching catalog is used falling back to English.
+func (s *Service) localizer(ctx context.Context, projectID, locale string) (*i18n.Localizer, error) {
+	list, err := s.store.ListMessageCatalogs(ctx, projectID)
+	if err != nil {
+		return nil, errors.Wrapf(err, "[service] store.ListMessageCatalogs failed")
+	}
+
+	bundle := i18n.NewBundle(language.English)
+	for _, c := range list {
+		if err := checkProjectScope("message catalog", projectID, c.ProjectID); err != nil {
+			return nil, err
+		}
+		mf, err := parseMessageCatalog(c.Locale, []byte(c.Messages))
+		if err != nil {
+			return nil, err
+		}
+		if err := bundle.AddMessages(mf.Tag, mf.Messages...); err != nil {
+			return nil, errors.Wrapf(err, "[service] bundle.AddMessages failed")
+		}
+	}
+	return i18n.NewLocalizer(bundle, locale), nil
+}
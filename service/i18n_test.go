@@ -0,0 +1,122 @@
+package service_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/andyfusniak/squishy-mailer-lite/entity"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSendEmailAsyncTranslatesMessages(t *testing.T) {
+	srv := newFakeSMTPServer(t)
+	svc := newTestService(t)
+	setupQueueProject(t, svc, srv)
+
+	ctx := context.Background()
+	catalogs := map[string]string{
+		"en": `{"welcome": {"heading": "Welcome {{.name}}"},
+			"inbox": {"one": "{{.PluralCount}} new message", "other": "{{.PluralCount}} new messages"}}`,
+		"fr": `{"welcome": {"heading": "Bienvenue {{.name}}"},
+			"inbox": {"one": "{{.PluralCount}} nouveau message", "other": "{{.PluralCount}} nouveaux messages"}}`,
+	}
+	for locale, messages := range catalogs {
+		if _, err := svc.SetMessageCatalog(ctx, entity.SetMessageCatalogParams{
+			ProjectID: "p1",
+			Locale:    locale,
+			Messages:  []byte(messages),
+		}); err != nil {
+			t.Fatalf("svc.SetMessageCatalog failed: %+v", err)
+		}
+	}
+
+	if _, err := svc.CreateTemplate(ctx, entity.CreateTemplate{
+		ID:        "t2",
+		ProjectID: "p1",
+		GroupID:   "g1",
+		Text:      `{{define "layout"}}{{t "welcome.heading"}}, {{t "inbox" .count}}{{end}}`,
+		HTML:      `{{define "layout"}}<h1>{{t "welcome.heading"}}</h1>{{end}}`,
+	}); err != nil {
+		t.Fatalf("svc.CreateTemplate failed: %+v", err)
+	}
+
+	tests := []struct {
+		locale string
+		count  string
+		text   string
+	}{
+		{"fr", "1", "Bienvenue Andy, 1 nouveau message"},
+		{"fr-CA", "3", "Bienvenue Andy, 3 nouveaux messages"},
+		{"en", "1", "Welcome Andy, 1 new message"},
+		{"", "2", "Welcome Andy, 2 new messages"},
+	}
+	for _, tc := range tests {
+		mq, err := svc.SendEmailAsync(ctx, entity.SendEmailParams{
+			TemplateID:     "t2",
+			ProjectID:      "p1",
+			TransportID:    "tr1",
+			To:             []string{"to@example.com"},
+			Subject:        "Welcome",
+			TemplateParams: map[string]string{"name": "Andy", "count": tc.count},
+			Locale:         tc.locale,
+		})
+		if err != nil {
+			t.Fatalf("svc.SendEmailAsync locale=%q failed: %+v", tc.locale, err)
+		}
+		assert.Equal(t, tc.text, mq.Text, "locale=%q", tc.locale)
+	}
+
+	list, err := svc.ListMessageCatalogs(ctx, "p1")
+	if err != nil {
+		t.Fatalf("svc.ListMessageCatalogs failed: %+v", err)
+	}
+	if assert.Len(t, list, 2) {
+		assert.Equal(t, "en", list[0].Locale)
+		assert.Equal(t, "fr", list[1].Locale)
+	}
+
+	// a message missing from every catalog fails the render
+	if err := svc.DeleteMessageCatalog(ctx, "p1", "en"); err != nil {
+		t.Fatalf("svc.DeleteMessageCatalog failed: %+v", err)
+	}
+	_, err = svc.SendEmailAsync(ctx, entity.SendEmailParams{
+		TemplateID:     "t2",
+		ProjectID:      "p1",
+		TransportID:    "tr1",
+		To:             []string{"to@example.com"},
+		Subject:        "Welcome",
+		TemplateParams: map[string]string{"name": "Andy", "count": "1"},
+		Locale:         "de",
+	})
+	assert.Error(t, err)
+}
+
+func TestSetMessageCatalogValidation(t *testing.T) {
+	svc := newTestService(t)
+	setupTwoProjects(t, svc)
+
+	ctx := context.Background()
+	_, err := svc.SetMessageCatalog(ctx, entity.SetMessageCatalogParams{
+		ProjectID: "pa",
+		Locale:    "not a locale",
+		Messages:  []byte(`{}`),
+	})
+	assertServiceErrorCode(t, err, entity.ErrInvalidCatalogCode)
+
+	_, err = svc.SetMessageCatalog(ctx, entity.SetMessageCatalogParams{
+		ProjectID: "pa",
+		Locale:    "en",
+		Messages:  []byte(`{"welcome": `),
+	})
+	assertServiceErrorCode(t, err, entity.ErrInvalidCatalogCode)
+
+	_, err = svc.SetMessageCatalog(ctx, entity.SetMessageCatalogParams{
+		ProjectID: "non-existent-project",
+		Locale:    "en",
+		Messages:  []byte(`{}`),
+	})
+	assertServiceErrorCode(t, err, entity.ErrProjectNotFoundCode)
+
+	err = svc.DeleteMessageCatalog(ctx, "pa", "en")
+	assertServiceErrorCode(t, err, entity.ErrCatalogNotFoundCode)
+}
@@ -0,0 +1,61 @@
+package service
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/andyfusniak/squishy-mailer-lite/entity"
+)
+
+// IDPolicy describes the rules that caller supplied ids must follow
+// when creating projects, transports, groups and templates. Ids often
+// end up in URLs such as /projects/{id}, so the default policy only
+// allows URL safe slugs.
+type IDPolicy struct {
+	// Pattern is a regular expression every id must match. If nil any
+	// pattern is accepted.
+	Pattern *regexp.Regexp
+
+	// MaxLength is the maximum length of an id in characters. Zero
+	// means there is no maximum length.
+	MaxLength int
+
+	// Reserved is a list of ids that cannot be used, compared case
+	// insensitively. Useful for words that clash with URL routes such
+	// as "new" or "all".
+	Reserved []string
+}
+
+// DefaultIDPolicy is used when no policy is specified with WithIDPolicy.
+// Ids must start with a letter or digit, followed by letters, digits,
+// dashes, underscores or dots and be no longer than 64 characters.
+var DefaultIDPolicy = IDPolicy{
+	Pattern:   regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9._-]*$`),
+	MaxLength: 64,
+}
+
+// validate checks the id against the policy. It returns a ServiceError
+// with code ErrInvalidIDCode if the id breaks any of the rules.
+func (p IDPolicy) validate(kind, id string) error {
+	if id == "" {
+		return entity.NewServiceError(entity.ErrInvalidIDCode,
+			fmt.Errorf("%s id must not be empty", kind))
+	}
+	if p.MaxLength > 0 && utf8.RuneCountInString(id) > p.MaxLength {
+		return entity.NewServiceError(entity.ErrInvalidIDCode,
+			fmt.Errorf("%s id %q exceeds the maximum length of %d", kind, id, p.MaxLength))
+	}
+	if p.Pattern != nil && !p.Pattern.MatchString(id) {
+		return entity.NewServiceError(entity.ErrInvalidIDCode,
+			fmt.Errorf("%s id %q does not match pattern %s", kind, id, p.Pattern))
+	}
+	for _, r := range p.Reserved {
+		if strings.EqualFold(id, r) {
+			return entity.NewServiceError(entity.ErrInvalidIDCode,
+				fmt.Errorf("%s id %q is reserved", kind, id))
+		}
+	}
+	return nil
+}
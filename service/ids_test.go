@@ -0,0 +1,80 @@
+package service_test
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/andyfusniak/squishy-mailer-lite/entity"
+	"github.com/andyfusniak/squishy-mailer-lite/service"
+)
+
+func TestDefaultIDPolicy(t *testing.T) {
+	svc := newTestService(t)
+
+	ctx := context.Background()
+	tests := []struct {
+		id    string
+		valid bool
+	}{
+		{id: "the-cloud-project", valid: true},
+		{id: "p1", valid: true},
+		{id: "my_project.v2", valid: true},
+		{id: "", valid: false},
+		{id: "-leading-dash", valid: false},
+		{id: "has space", valid: false},
+		{id: "slash/in/id", valid: false},
+		{id: strings.Repeat("a", 65), valid: false},
+	}
+	for _, tt := range tests {
+		_, err := svc.CreateProject(ctx, tt.id, "name", "")
+		if tt.valid {
+			if err != nil {
+				t.Errorf("expected id %q to be valid: %+v", tt.id, err)
+			}
+			continue
+		}
+		assertServiceErrorCode(t, err, entity.ErrInvalidIDCode)
+	}
+}
+
+func TestCustomIDPolicy(t *testing.T) {
+	svc := newTestService(t, service.WithIDPolicy(service.IDPolicy{
+		Pattern:   regexp.MustCompile(`^[a-z]+$`),
+		MaxLength: 8,
+		Reserved:  []string{"new", "all"},
+	}))
+
+	ctx := context.Background()
+	if _, err := svc.CreateProject(ctx, "project", "Project", ""); err != nil {
+		t.Fatalf("svc.CreateProject failed: %+v", err)
+	}
+
+	_, err := svc.CreateProject(ctx, "NEW", "Project", "")
+	assertServiceErrorCode(t, err, entity.ErrInvalidIDCode)
+
+	_, err = svc.CreateProject(ctx, "p1", "Project", "")
+	assertServiceErrorCode(t, err, entity.ErrInvalidIDCode)
+
+	_, err = svc.CreateGroup(ctx, "all", "project", "Group")
+	assertServiceErrorCode(t, err, entity.ErrInvalidIDCode)
+
+	_, err = svc.CreateSMTPTransport(ctx, entity.CreateSMTPTransport{
+		ID:        "toolongid",
+		ProjectID: "project",
+	})
+	assertServiceErrorCode(t, err, entity.ErrInvalidIDCode)
+
+	_, err = svc.CreateTemplate(ctx, entity.CreateTemplate{
+		ID:        "t-1",
+		ProjectID: "project",
+	})
+	assertServiceErrorCode(t, err, entity.ErrInvalidIDCode)
+
+	_, err = svc.SetTemplate(ctx, entity.SetTemplateParams{
+		ID:        "t-1",
+		ProjectID: "project",
+	})
+	assertServiceErrorCode(t, err, entity.ErrInvalidIDCode)
+}
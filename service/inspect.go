@@ -0,0 +1,109 @@
+package service
+
+import (
+	"context"
+	htmltemplate "html/template"
+	"slices"
+	txttemplate "text/template"
+	"text/template/parse"
+
+	"github.com/andyfusniak/squishy-mailer-lite/entity"
+)
+
+// InspectTemplate parses the stored text and HTML templates and returns
+// the template params they reference, so that callers can check the
+// params of an email using Validate before sending it.
+//
+// A param is a field of the template data such as {{.name}} or
+// {{$.name}}. Fields used inside {{range}} and {{with}} refer to a
+// different value of dot and are not params. Every {{define}} block is
+// inspected as though it were executed with the template data.
+func (s *Service) InspectTemplate(ctx context.Context, projectID, templateID string) (*entity.TemplateInspection, error) {
+	c, err := s.compileTemplate(ctx, projectID, templateID, "")
+	if err != nil {
+		return nil, err
+	}
+	return &entity.TemplateInspection{
+		TemplateID: c.tmpl.TemplateID,
+		ProjectID:  c.tmpl.ProjectID,
+		Params:     c.params,
+	}, nil
+}
+
+// referencedParams returns the sorted names of the template params
+// referenced by the text, HTML and subject templates. subject may be nil.
+func referencedParams(text *txttemplate.Template, html *htmltemplate.Template, subject *txttemplate.Template) []string {
+	params := make(map[string]struct{})
+	for _, tmpl := range text.Templates() {
+		if tmpl.Tree != nil {
+			collectParams(tmpl.Tree.Root, true, params)
+		}
+	}
+	for _, tmpl := range html.Templates() {
+		if tmpl.Tree != nil {
+			collectParams(tmpl.Tree.Root, true, params)
+		}
+	}
+	if subject != nil && subject.Tree != nil {
+		collectParams(subject.Tree.Root, true, params)
+	}
+
+	names := make([]string, 0, len(params))
+	for name := range params {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// collectParams adds the names of the template params referenced by node
+// to params. atRoot reports whether dot is the template data.
+func collectParams(node parse.Node, atRoot bool, params map[string]struct{}) {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, c := range n.Nodes {
+			collectParams(c, atRoot, params)
+		}
+	case *parse.ActionNode:
+		collectParams(n.Pipe, atRoot, params)
+	case *parse.PipeNode:
+		if n == nil {
+			return
+		}
+		for _, c := range n.Cmds {
+			collectParams(c, atRoot, params)
+		}
+	case *parse.CommandNode:
+		for _, arg := range n.Args {
+			collectParams(arg, atRoot, params)
+		}
+	case *parse.ChainNode:
+		collectParams(n.Node, atRoot, params)
+	case *parse.FieldNode:
+		if atRoot && len(n.Ident) > 0 {
+			params[n.Ident[0]] = struct{}{}
+		}
+	case *parse.VariableNode:
+		// $ is always the template data
+		if len(n.Ident) > 1 && n.Ident[0] == "$" {
+			params[n.Ident[1]] = struct{}{}
+		}
+	case *parse.IfNode:
+		collectParams(n.Pipe, atRoot, params)
+		collectParams(n.List, atRoot, params)
+		collectParams(n.ElseList, atRoot, params)
+	case *parse.RangeNode:
+		collectParams(n.Pipe, atRoot, params)
+		collectParams(n.List, false, params)
+		collectParams(n.ElseList, atRoot, params)
+	case *parse.WithNode:
+		collectParams(n.Pipe, atRoot, params)
+		collectParams(n.List, false, params)
+		collectParams(n.ElseList, atRoot, params)
+	case *parse.TemplateNode:
+		collectParams(n.Pipe, atRoot, params)
+	}
+}
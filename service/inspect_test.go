@@ -0,0 +1,98 @@
+package service_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/andyfusniak/squishy-mailer-lite/entity"
+	"github.com/andyfusniak/squishy-mailer-lite/service"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInspectTemplate(t *testing.T) {
+	srv := newFakeSMTPServer(t)
+	svc := newTestService(t)
+	setupQueueProject(t, svc, srv)
+
+	ctx := context.Background()
+	if _, err := svc.CreateTemplate(ctx, entity.CreateTemplate{
+		ID:        "t2",
+		ProjectID: "p1",
+		GroupID:   "g1",
+		Text: `{{define "layout"}}Hi {{.first_name}}{{if .coupon}} use {{.coupon}}{{end}}` +
+			`{{range .items}}{{.title}} {{$.currency}}{{end}}{{template "footer" .}}{{end}}` +
+			`{{define "footer"}}{{t "footer"}} {{.company}}{{end}}`,
+		HTML: `{{define "layout"}}<p>{{with .order}}{{.id}}{{else}}{{.fallback}}{{end}}</p>{{end}}`,
+	}); err != nil {
+		t.Fatalf("svc.CreateTemplate failed: %+v", err)
+	}
+
+	ti, err := svc.InspectTemplate(ctx, "p1", "t2")
+	if err != nil {
+		t.Fatalf("svc.InspectTemplate failed: %+v", err)
+	}
+	assert.Equal(t, []string{"company", "coupon", "currency", "fallback", "first_name", "items", "order"}, ti.Params)
+
+	ti, err = svc.InspectTemplate(ctx, "p1", "t1")
+	if err != nil {
+		t.Fatalf("svc.InspectTemplate failed: %+v", err)
+	}
+	assert.Equal(t, []string{"name"}, ti.Params)
+	assert.NoError(t, ti.Validate(map[string]string{"name": "Andy"}))
+
+	err = ti.Validate(map[string]string{"nmae": "Andy"})
+	assertServiceErrorCode(t, err, entity.ErrMissingTemplateParamsCode)
+	var merr *entity.MissingParamsError
+	if assert.True(t, errors.As(err, &merr)) {
+		assert.Equal(t, "t1", merr.TemplateID)
+		assert.Equal(t, []string{"name"}, merr.Params)
+	}
+
+	_, err = svc.InspectTemplate(ctx, "p1", "missing")
+	assertServiceErrorCode(t, err, entity.ErrTemplateNotFoundCode)
+}
+
+func TestStrictTemplateParams(t *testing.T) {
+	srv := newFakeSMTPServer(t)
+	svc := newTestService(t)
+	setupQueueProject(t, svc, srv)
+
+	ctx := context.Background()
+	params := entity.SendEmailParams{
+		TemplateID:     "t1",
+		ProjectID:      "p1",
+		TransportID:    "tr1",
+		To:             []string{"to@example.com"},
+		Subject:        "Welcome",
+		TemplateParams: map[string]string{"nmae": "Andy"},
+	}
+	if err := svc.SendEmail(ctx, params); err != nil {
+		t.Fatalf("svc.SendEmail failed: %+v", err)
+	}
+	msgs := srv.Messages()
+	if assert.Len(t, msgs, 1) {
+		assert.Contains(t, msgs[0].Data, "Hello <no value>")
+	}
+
+	params.StrictParams = true
+	err := svc.SendEmail(ctx, params)
+	assertServiceErrorCode(t, err, entity.ErrMissingTemplateParamsCode)
+	var merr *entity.MissingParamsError
+	if assert.True(t, errors.As(err, &merr)) {
+		assert.Equal(t, []string{"name"}, merr.Params)
+	}
+	assert.Len(t, srv.Messages(), 1)
+
+	// the service option applies to every send
+	strictSvc := newTestService(t, service.WithStrictTemplateParams())
+	setupQueueProject(t, strictSvc, srv)
+	params.StrictParams = false
+	_, err = strictSvc.SendEmailAsync(ctx, params)
+	assertServiceErrorCode(t, err, entity.ErrMissingTemplateParamsCode)
+
+	params.TemplateParams = map[string]string{"name": "Andy"}
+	if _, err := strictSvc.SendEmailAsync(ctx, params); err != nil {
+		t.Fatalf("strictSvc.SendEmailAsync failed: %+v", err)
+	}
+}
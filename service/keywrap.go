@@ -0,0 +1,276 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/andyfusniak/squishy-mailer-lite/internal/store"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	"github.com/pkg/errors"
+)
+
+// KeyWrapper wraps and unwraps the data encryption key with a key held by
+// an external key management service, so that the plaintext key is never
+// supplied to the process. Transport passwords and other secrets are
+// encrypted with the data encryption key, which is stored in the database
+// only in its wrapped form.
+type KeyWrapper interface {
+	// WrapKey encrypts the data encryption key.
+	WrapKey(ctx context.Context, key []byte) ([]byte, error)
+
+	// UnwrapKey decrypts a data encryption key encrypted by WrapKey.
+	UnwrapKey(ctx context.Context, wrapped []byte) ([]byte, error)
+}
+
+// WithKeyWrapper uses a KeyWrapper to unwrap the data encryption key kept
+// in the store. On first use a random 256 bit key is generated, wrapped
+// and stored. To move an existing database to a key management service,
+// create the service once with both the current key, using
+// WithEncryptionKey or WithHexEncodedEncryptionKey, and the key wrapper:
+// the current key is wrapped and stored so it need not be given again.
+func WithKeyWrapper(w KeyWrapper) Option {
+	return func(s *Service) {
+		s.keyWrapper = w
+	}
+}
+
+// wrappedKeySetting is the name of the store setting holding the base64
+// encoded wrapped data encryption key.
+const wrappedKeySetting = "wrapped_encryption_key"
+
+// unwrapEncryptionKey returns the data encryption key kept in the store,
+// wrapping and storing key, or a new random key if key is nil, on first
+// use.
+func (s *Service) unwrapEncryptionKey(ctx context.Context, key []byte) ([]byte, error) {
+	setting, err := s.store.GetSetting(ctx, wrappedKeySetting)
+	if err != nil {
+		var storeErr *store.Error
+		if !errors.As(err, &storeErr) || storeErr.Code != store.ErrSettingNotFound {
+			return nil, errors.Wrapf(err, "[service] store.GetSetting failed")
+		}
+
+		if key == nil {
+			key = make([]byte, 32)
+			if _, err := rand.Read(key); err != nil {
+				return nil, errors.Wrap(err, "[service] rand.Read failed")
+			}
+		}
+		wrapped, err := s.keyWrapper.WrapKey(ctx, key)
+		if err != nil {
+			return nil, errors.Wrapf(err, "[service] wrap encryption key failed")
+		}
+		value := base64.StdEncoding.EncodeToString(wrapped)
+		setting, err = s.store.EnsureSetting(ctx, wrappedKeySetting, value)
+		if err != nil {
+			return nil, errors.Wrapf(err, "[service] store.EnsureSetting failed")
+		}
+		if setting.Value == value {
+			s.logger.Info("stored wrapped encryption key")
+			return key, nil
+		}
+		// another process stored its key first
+	}
+
+	wrapped, err := base64.StdEncoding.DecodeString(setting.Value)
+	if err != nil {
+		return nil, errors.Wrapf(err, "[service] stored %s is invalid", wrappedKeySetting)
+	}
+	unwrapped, err := s.keyWrapper.UnwrapKey(ctx, wrapped)
+	if err != nil {
+		return nil, errors.Wrapf(err, "[service] unwrap encryption key failed")
+	}
+	if key != nil && subtle.ConstantTimeCompare(key, unwrapped) != 1 {
+		return nil, errors.New(
+			"[service] encryption key does not match the wrapped key in the store")
+	}
+	return unwrapped, nil
+}
+
+//
+// AWS KMS
+//
+
+type awsKMSKeyWrapper struct {
+	client *kms.Client
+	keyID  string
+}
+
+// NewAWSKMSKeyWrapper returns a KeyWrapper using the AWS KMS key keyID,
+// which may be a key id, key ARN, alias name or alias ARN. The AWS
+// credentials and region are loaded from the environment in the same way
+// as the AWS CLI unless region is given.
+func NewAWSKMSKeyWrapper(ctx context.Context, keyID, region string) (KeyWrapper, error) {
+	var opts []func(*config.LoadOptions) error
+	if region != "" {
+		opts = append(opts, config.WithRegion(region))
+	}
+	awsCfg, err := config.LoadDefaultConfig(ctx, opts...)
+	if err != nil {
+		return nil, errors.Wrapf(err, "[service] config.LoadDefaultConfig failed")
+	}
+	return &awsKMSKeyWrapper{client: kms.NewFromConfig(awsCfg), keyID: keyID}, nil
+}
+
+func (w *awsKMSKeyWrapper) WrapKey(ctx context.Context, key []byte) ([]byte, error) {
+	out, err := w.client.Encrypt(ctx, &kms.EncryptInput{
+		KeyId:     aws.String(w.keyID),
+		Plaintext: key,
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "[service] kms.Encrypt failed")
+	}
+	return out.CiphertextBlob, nil
+}
+
+func (w *awsKMSKeyWrapper) UnwrapKey(ctx context.Context, wrapped []byte) ([]byte, error) {
+	out, err := w.client.Decrypt(ctx, &kms.DecryptInput{
+		KeyId:          aws.String(w.keyID),
+		CiphertextBlob: wrapped,
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "[service] kms.Decrypt failed")
+	}
+	return out.Plaintext, nil
+}
+
+//
+// GCP Cloud KMS
+//
+
+type gcpKMSKeyWrapper struct {
+	client  *http.Client
+	keyName string
+}
+
+// NewGCPKMSKeyWrapper returns a KeyWrapper using the Cloud KMS REST API
+// and the symmetric key keyName, of the form
+// projects/{project}/locations/{location}/keyRings/{keyRing}/cryptoKeys/{key}.
+// client must authenticate its requests, for example one returned by
+// google.DefaultClient of golang.org/x/oauth2/google with the
+// https://www.googleapis.com/auth/cloudkms scope.
+func NewGCPKMSKeyWrapper(client *http.Client, keyName string) KeyWrapper {
+	return &gcpKMSKeyWrapper{client: client, keyName: keyName}
+}
+
+func (w *gcpKMSKeyWrapper) WrapKey(ctx context.Context, key []byte) ([]byte, error) {
+	var resp struct {
+		Ciphertext []byte `json:"ciphertext"`
+	}
+	if err := w.call(ctx, "encrypt", map[string][]byte{"plaintext": key}, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Ciphertext, nil
+}
+
+func (w *gcpKMSKeyWrapper) UnwrapKey(ctx context.Context, wrapped []byte) ([]byte, error) {
+	var resp struct {
+		Plaintext []byte `json:"plaintext"`
+	}
+	if err := w.call(ctx, "decrypt", map[string][]byte{"ciphertext": wrapped}, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Plaintext, nil
+}
+
+func (w *gcpKMSKeyWrapper) call(ctx context.Context, method string, body, v any) error {
+	u := "https://cloudkms.googleapis.com/v1/" + w.keyName + ":" + method
+	return postKeyJSON(ctx, w.client, u, nil, body, v)
+}
+
+//
+// HashiCorp Vault
+//
+
+type vaultKeyWrapper struct {
+	client  *http.Client
+	baseURL string
+	token   string
+	keyName string
+}
+
+// NewVaultKeyWrapper returns a KeyWrapper using the key keyName of the
+// HashiCorp Vault transit secrets engine mounted at mount, usually
+// "transit", on the Vault server at addr authenticated with token.
+func NewVaultKeyWrapper(addr, token, mount, keyName string) KeyWrapper {
+	return &vaultKeyWrapper{
+		client:  &http.Client{Timeout: 30 * time.Second},
+		baseURL: strings.TrimSuffix(addr, "/") + "/v1/" + strings.Trim(mount, "/"),
+		token:   token,
+		keyName: keyName,
+	}
+}
+
+func (w *vaultKeyWrapper) WrapKey(ctx context.Context, key []byte) ([]byte, error) {
+	var resp struct {
+		Data struct {
+			Ciphertext string `json:"ciphertext"`
+		} `json:"data"`
+	}
+	if err := w.call(ctx, "encrypt", map[string]any{"plaintext": key}, &resp); err != nil {
+		return nil, err
+	}
+	return []byte(resp.Data.Ciphertext), nil
+}
+
+func (w *vaultKeyWrapper) UnwrapKey(ctx context.Context, wrapped []byte) ([]byte, error) {
+	var resp struct {
+		Data struct {
+			Plaintext []byte `json:"plaintext"`
+		} `json:"data"`
+	}
+	if err := w.call(ctx, "decrypt", map[string]any{"ciphertext": string(wrapped)}, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Data.Plaintext, nil
+}
+
+func (w *vaultKeyWrapper) call(ctx context.Context, op string, body, v any) error {
+	u := w.baseURL + "/" + op + "/" + url.PathEscape(w.keyName)
+	return postKeyJSON(ctx, w.client, u, map[string]string{"X-Vault-Token": w.token}, body, v)
+}
+
+// postKeyJSON posts body as JSON to a key management service and decodes
+// the JSON response into v.
+func postKeyJSON(ctx context.Context, client *http.Client, u string, header map[string]string, body, v any) error {
+	b, err := json.Marshal(body)
+	if err != nil {
+		return errors.Wrapf(err, "[service] json.Marshal failed")
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, bytes.NewReader(b))
+	if err != nil {
+		return errors.Wrapf(err, "[service] http.NewRequest failed")
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return errors.Wrapf(err, "[service] key management request failed")
+	}
+	defer resp.Body.Close()
+
+	rb, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return errors.Wrapf(err, "[service] read key management response failed")
+	}
+	if resp.StatusCode != http.StatusOK {
+		return errors.Errorf("[service] key management request failed with status %d: %s",
+			resp.StatusCode, truncate(string(rb), 200))
+	}
+	if err := json.Unmarshal(rb, v); err != nil {
+		return errors.Wrapf(err, "[service] decode key management response failed")
+	}
+	return nil
+}
@@ -0,0 +1,137 @@
+package service_test
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/andyfusniak/squishy-mailer-lite/service"
+	"github.com/stretchr/testify/assert"
+)
+
+// xorKeyWrapper is a KeyWrapper standing in for a key management service.
+type xorKeyWrapper struct {
+	wraps, unwraps int
+}
+
+func (w *xorKeyWrapper) WrapKey(ctx context.Context, key []byte) ([]byte, error) {
+	w.wraps++
+	return xor(key), nil
+}
+
+func (w *xorKeyWrapper) UnwrapKey(ctx context.Context, wrapped []byte) ([]byte, error) {
+	w.unwraps++
+	return xor(wrapped), nil
+}
+
+func xor(b []byte) []byte {
+	out := make([]byte, len(b))
+	for i := range b {
+		out[i] = b[i] ^ 0x5a
+	}
+	return out
+}
+
+func TestWithKeyWrapper(t *testing.T) {
+	dir := t.TempDir()
+	open := func(db string, opts ...service.Option) (*service.Service, error) {
+		svc, err := service.NewEmailService(append([]service.Option{
+			service.WithSqlite3DBFilepath(filepath.Join(dir, db)),
+		}, opts...)...)
+		if err == nil {
+			t.Cleanup(func() { svc.Close() })
+		}
+		return svc, err
+	}
+	token := func(svc *service.Service) string {
+		return svc.UnsubscribeToken("p1", "to@example.com")
+	}
+
+	// a new key is generated and wrapped on first use
+	w := &xorKeyWrapper{}
+	svc, err := open("a.db", service.WithKeyWrapper(w))
+	if err != nil {
+		t.Fatalf("service.NewEmailService failed: %+v", err)
+	}
+	want := token(svc)
+	assert.Equal(t, 1, w.wraps)
+
+	svc, err = open("a.db", service.WithKeyWrapper(w))
+	if err != nil {
+		t.Fatalf("service.NewEmailService failed: %+v", err)
+	}
+	assert.Equal(t, want, token(svc))
+	assert.Equal(t, 1, w.wraps)
+	assert.Equal(t, 1, w.unwraps)
+
+	// an existing key is wrapped so that it need not be given again
+	plain, err := open("b.db", service.WithHexEncodedEncryptionKey(testEncryptionKey))
+	if err != nil {
+		t.Fatalf("service.NewEmailService failed: %+v", err)
+	}
+	want = token(plain)
+	for _, opts := range [][]service.Option{
+		{service.WithHexEncodedEncryptionKey(testEncryptionKey), service.WithKeyWrapper(w)},
+		{service.WithKeyWrapper(w)},
+	} {
+		svc, err := open("b.db", opts...)
+		if err != nil {
+			t.Fatalf("service.NewEmailService failed: %+v", err)
+		}
+		assert.Equal(t, want, token(svc))
+	}
+
+	_, err = open("b.db", service.WithHexEncodedEncryptionKey("00112233445566778899aabbccddeeff"),
+		service.WithKeyWrapper(w))
+	assert.Error(t, err)
+	_, err = open("b.db", service.WithPassphrase("correct horse"), service.WithKeyWrapper(w))
+	assert.Error(t, err)
+}
+
+func TestVaultKeyWrapper(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "s.token" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		var req map[string]string
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		var data map[string]string
+		switch r.URL.Path {
+		case "/v1/transit/encrypt/sqm":
+			data = map[string]string{"ciphertext": "vault:v1:" + req["plaintext"]}
+		case "/v1/transit/decrypt/sqm":
+			data = map[string]string{"plaintext": req["ciphertext"][len("vault:v1:"):]}
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]any{"data": data})
+	}))
+	defer ts.Close()
+
+	ctx := context.Background()
+	w := service.NewVaultKeyWrapper(ts.URL+"/", "s.token", "transit", "sqm")
+	key := []byte("0123456789abcdef0123456789abcdef")
+	wrapped, err := w.WrapKey(ctx, key)
+	if err != nil {
+		t.Fatalf("w.WrapKey failed: %+v", err)
+	}
+	assert.Equal(t, "vault:v1:"+base64.StdEncoding.EncodeToString(key), string(wrapped))
+
+	unwrapped, err := w.UnwrapKey(ctx, wrapped)
+	if err != nil {
+		t.Fatalf("w.UnwrapKey failed: %+v", err)
+	}
+	assert.Equal(t, key, unwrapped)
+
+	_, err = service.NewVaultKeyWrapper(ts.URL, "wrong", "transit", "sqm").WrapKey(ctx, key)
+	assert.Error(t, err)
+}
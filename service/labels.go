@@ -0,0 +1,81 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"unicode/utf8"
+
+	"github.com/andyfusniak/squishy-mailer-lite/entity"
+	"github.com/andyfusniak/squishy-mailer-lite/internal/store"
+	"github.com/pkg/errors"
+)
+
+// label limits keep labels small enough to be stored with every email.
+const (
+	maxLabels           = 32
+	maxLabelKeyLength   = 64
+	maxLabelValueLength = 256
+)
+
+// checkLabels validates the labels of a project, template or email.
+func checkLabels(labels map[string]string) error {
+	if len(labels) > maxLabels {
+		return entity.NewServiceError(entity.ErrInvalidLabelsCode,
+			fmt.Errorf("%d labels given, at most %d are allowed", len(labels), maxLabels))
+	}
+	for k, v := range labels {
+		if k == "" {
+			return entity.NewServiceError(entity.ErrInvalidLabelsCode,
+				fmt.Errorf("label key is empty"))
+		}
+		if utf8.RuneCountInString(k) > maxLabelKeyLength {
+			return entity.NewServiceError(entity.ErrInvalidLabelsCode,
+				fmt.Errorf("label key %q is longer than %d characters", k, maxLabelKeyLength))
+		}
+		if utf8.RuneCountInString(v) > maxLabelValueLength {
+			return entity.NewServiceError(entity.ErrInvalidLabelsCode,
+				fmt.Errorf("label %q value is longer than %d characters", k, maxLabelValueLength))
+		}
+	}
+	return nil
+}
+
+// SetProjectLabels replaces the labels of a project. An empty map removes
+// them. Projects can be filtered by label with ListProjects.
+func (s *Service) SetProjectLabels(ctx context.Context, projectID string, labels map[string]string) (*entity.Project, error) {
+	if err := checkLabels(labels); err != nil {
+		return nil, err
+	}
+
+	obj, err := s.store.SetProjectLabels(ctx, projectID, store.JSONObject(labels))
+	if err != nil {
+		if serr := serviceErrorFromStore(err); serr != nil {
+			return nil, serr
+		}
+		return nil, errors.Wrapf(err, "[service] store.SetProjectLabels failed")
+	}
+	if err := checkProjectScope("project", projectID, obj.ProjectID); err != nil {
+		return nil, err
+	}
+	return projectFromStoreObject(obj), nil
+}
+
+// SetTemplateLabels replaces the labels of a template. An empty map
+// removes them. Templates can be filtered by label with ListTemplates.
+func (s *Service) SetTemplateLabels(ctx context.Context, projectID, templateID string, labels map[string]string) (*entity.Template, error) {
+	if err := checkLabels(labels); err != nil {
+		return nil, err
+	}
+
+	obj, err := s.store.SetTemplateLabels(ctx, projectID, templateID, store.JSONObject(labels))
+	if err != nil {
+		if serr := serviceErrorFromStore(err); serr != nil {
+			return nil, serr
+		}
+		return nil, errors.Wrapf(err, "[service] store.SetTemplateLabels failed")
+	}
+	if err := checkProjectScope("template", projectID, obj.ProjectID); err != nil {
+		return nil, err
+	}
+	return templateFromStoreObject(obj), nil
+}
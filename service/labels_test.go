@@ -0,0 +1,134 @@
+package service_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/andyfusniak/squishy-mailer-lite/entity"
+	"github.com/andyfusniak/squishy-mailer-lite/service"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLabels(t *testing.T) {
+	stores := []struct {
+		name string
+		opts []service.Option
+	}{
+		{"sqlite3", nil},
+		{"memory", []service.Option{service.WithInMemoryStore()}},
+	}
+	for _, st := range stores {
+		t.Run(st.name, func(t *testing.T) {
+			svc := newTestService(t, st.opts...)
+			setupQueueProject(t, svc, newFakeSMTPServer(t))
+			ctx := context.Background()
+
+			if _, err := svc.CreateProject(ctx, "p2", "Project Two", ""); err != nil {
+				t.Fatalf("svc.CreateProject failed: %+v", err)
+			}
+			p, err := svc.SetProjectLabels(ctx, "p1", map[string]string{"team": "billing", "env": "prod"})
+			if err != nil {
+				t.Fatalf("svc.SetProjectLabels failed: %+v", err)
+			}
+			assert.Equal(t, map[string]string{"team": "billing", "env": "prod"}, p.Labels)
+			if _, err := svc.SetProjectLabels(ctx, "p2", map[string]string{"team": "growth"}); err != nil {
+				t.Fatalf("svc.SetProjectLabels failed: %+v", err)
+			}
+
+			projects, err := svc.ListProjects(ctx, entity.ListProjectsParams{
+				Labels: map[string]string{"team": "billing"},
+			})
+			if err != nil {
+				t.Fatalf("svc.ListProjects failed: %+v", err)
+			}
+			if assert.Len(t, projects, 1) {
+				assert.Equal(t, "p1", projects[0].ID)
+			}
+			projects, err = svc.ListProjects(ctx, entity.ListProjectsParams{
+				Labels: map[string]string{"team": "billing", "env": "staging"},
+			})
+			if err != nil {
+				t.Fatalf("svc.ListProjects failed: %+v", err)
+			}
+			assert.Empty(t, projects)
+
+			if _, err := svc.CreateTemplate(ctx, entity.CreateTemplate{
+				ID:        "t2",
+				ProjectID: "p1",
+				GroupID:   "g1",
+				Text:      `{{define "layout"}}Receipt{{end}}`,
+			}); err != nil {
+				t.Fatalf("svc.CreateTemplate failed: %+v", err)
+			}
+			tmpl, err := svc.SetTemplateLabels(ctx, "p1", "t2", map[string]string{"team": "billing"})
+			if err != nil {
+				t.Fatalf("svc.SetTemplateLabels failed: %+v", err)
+			}
+			assert.Equal(t, map[string]string{"team": "billing"}, tmpl.Labels)
+			tmpl, err = svc.GetTemplate(ctx, "p1", "t2")
+			if err != nil {
+				t.Fatalf("svc.GetTemplate failed: %+v", err)
+			}
+			assert.Equal(t, map[string]string{"team": "billing"}, tmpl.Labels)
+
+			templates, err := svc.ListTemplates(ctx, entity.ListTemplatesParams{
+				ProjectID: "p1",
+				Labels:    map[string]string{"team": "billing"},
+			})
+			if err != nil {
+				t.Fatalf("svc.ListTemplates failed: %+v", err)
+			}
+			if assert.Len(t, templates, 1) {
+				assert.Equal(t, "t2", templates[0].ID)
+			}
+			_, err = svc.SetTemplateLabels(ctx, "p1", "missing", map[string]string{"team": "billing"})
+			assertServiceErrorCode(t, err, entity.ErrTemplateNotFoundCode)
+
+			// sends are labelled individually
+			send := entity.SendEmailParams{
+				TemplateID:     "t1",
+				ProjectID:      "p1",
+				TransportID:    "tr1",
+				To:             []string{"to@example.com"},
+				TemplateParams: map[string]string{"name": "Andy"},
+				Labels:         map[string]string{"feature": "signup"},
+			}
+			mq, err := svc.SendEmailAsync(ctx, send)
+			if err != nil {
+				t.Fatalf("svc.SendEmailAsync failed: %+v", err)
+			}
+			assert.Equal(t, map[string]string{"feature": "signup"}, mq.Labels)
+			queueTestEmail(t, svc)
+
+			list, err := svc.ListMailQueue(ctx, entity.ListMailQueueParams{
+				ProjectID: "p1",
+				Labels:    map[string]string{"feature": "signup"},
+			})
+			if err != nil {
+				t.Fatalf("svc.ListMailQueue failed: %+v", err)
+			}
+			if assert.Len(t, list, 1) {
+				assert.Equal(t, mq.ID, list[0].ID)
+			}
+			list, err = svc.ListMailQueue(ctx, entity.ListMailQueueParams{ProjectID: "p1"})
+			if err != nil {
+				t.Fatalf("svc.ListMailQueue failed: %+v", err)
+			}
+			assert.Len(t, list, 2)
+
+			send.Labels = map[string]string{"": "empty"}
+			_, err = svc.SendEmailAsync(ctx, send)
+			assertServiceErrorCode(t, err, entity.ErrInvalidLabelsCode)
+			_, err = svc.SetProjectLabels(ctx, "p1", map[string]string{strings.Repeat("k", 65): "v"})
+			assertServiceErrorCode(t, err, entity.ErrInvalidLabelsCode)
+
+			// an empty map removes the labels
+			p, err = svc.SetProjectLabels(ctx, "p1", nil)
+			if err != nil {
+				t.Fatalf("svc.SetProjectLabels failed: %+v", err)
+			}
+			assert.Empty(t, p.Labels)
+		})
+	}
+}
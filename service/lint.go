@@ -0,0 +1,163 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/andyfusniak/squishy-mailer-lite/entity"
+)
+
+// maxImageWidth is the widest image, in pixels, that fits the 600 pixel
+// layouts most email clients are designed for.
+const maxImageWidth = 600
+
+// unsupportedTags are the elements email clients remove or do not render.
+var unsupportedTags = map[string]bool{
+	"applet":   true,
+	"audio":    true,
+	"button":   true,
+	"canvas":   true,
+	"embed":    true,
+	"form":     true,
+	"frame":    true,
+	"frameset": true,
+	"iframe":   true,
+	"input":    true,
+	"object":   true,
+	"script":   true,
+	"select":   true,
+	"svg":      true,
+	"textarea": true,
+	"video":    true,
+}
+
+var (
+	// lintTagRe matches a start tag, its name and its attributes.
+	lintTagRe = regexp.MustCompile(`<([a-zA-Z][a-zA-Z0-9-]*)((?:\s+[^>]*)?)/?>`)
+
+	// lintAttrRe matches an attribute, with or without a value.
+	lintAttrRe = regexp.MustCompile(`([a-zA-Z_:][-a-zA-Z0-9_:.]*)(?:\s*=\s*("[^"]*"|'[^']*'|[^\s"'>]+))?`)
+
+	lintCommentRe = regexp.MustCompile(`(?s)<!--.*?-->`)
+	lintStyleRe   = regexp.MustCompile(`(?is)<style[^>]*>(.*?)</style>`)
+	lintImportRe  = regexp.MustCompile(`(?i)@import\b`)
+	lintWidthRe   = regexp.MustCompile(`(?i)(?:^|;)\s*(?:max-)?width\s*:\s*(\d+)px`)
+)
+
+// LintTemplate checks the HTML of a template, before it is saved, for
+// constructs that are known to break in email clients: external
+// stylesheets, unsupported elements, images without alt text and images
+// wider than 600 pixels. MJML and Markdown sources are compiled to HTML
+// first. The warnings are returned in the order they appear in the HTML.
+// The template actions are not executed, so only the constructs written
+// in the template are checked.
+func (s *Service) LintTemplate(ctx context.Context, params entity.LintTemplateParams) ([]entity.TemplateLintWarning, error) {
+	_, body, err := s.compileSource(ctx, params.SourceType, params.Source, templateBody{
+		html: params.HTML,
+	}, nil)
+	if err != nil {
+		return nil, err
+	}
+	return lintHTML(body.html), nil
+}
+
+// lintHTML returns the warnings for html.
+func lintHTML(html string) []entity.TemplateLintWarning {
+	// blank out comments, keeping their line breaks, so that Outlook
+	// conditional comments are not checked
+	html = lintCommentRe.ReplaceAllStringFunc(html, func(c string) string {
+		return strings.Repeat("\n", strings.Count(c, "\n"))
+	})
+
+	var warnings []entity.TemplateLintWarning
+	warn := func(offset int, rule entity.TemplateLintRule, element, format string, args ...any) {
+		warnings = append(warnings, entity.TemplateLintWarning{
+			Rule:    rule,
+			Line:    strings.Count(html[:offset], "\n") + 1,
+			Element: element,
+			Message: fmt.Sprintf(format, args...),
+		})
+	}
+
+	styles := lintStyleRe.FindAllStringSubmatchIndex(html, -1)
+	inStyle := func(offset int) bool {
+		for _, m := range styles {
+			if offset > m[0] && offset < m[1] {
+				return true
+			}
+		}
+		return false
+	}
+	for _, m := range styles {
+		css := html[m[2]:m[3]]
+		for _, im := range lintImportRe.FindAllStringIndex(css, -1) {
+			warn(m[2]+im[0], entity.TemplateLintExternalCSS, "style",
+				"@import is removed by most email clients, inline the styles instead")
+		}
+	}
+
+	for _, m := range lintTagRe.FindAllStringSubmatchIndex(html, -1) {
+		if inStyle(m[0]) {
+			continue
+		}
+		name := strings.ToLower(html[m[2]:m[3]])
+		attrs := lintAttrs(html[m[4]:m[5]])
+		switch {
+		case unsupportedTags[name]:
+			warn(m[0], entity.TemplateLintUnsupportedTag, name,
+				"<%s> is removed or not rendered by most email clients", name)
+		case name == "link" && strings.EqualFold(strings.TrimSpace(attrs["rel"]), "stylesheet"):
+			warn(m[0], entity.TemplateLintExternalCSS, name,
+				"linked stylesheets are removed by most email clients, inline the styles instead")
+		case name == "img":
+			if _, ok := attrs["alt"]; !ok {
+				warn(m[0], entity.TemplateLintMissingAlt, name,
+					"image %q has no alt text to show when images are blocked", attrs["src"])
+			}
+			if w := imageWidth(attrs); w > maxImageWidth {
+				warn(m[0], entity.TemplateLintWideImage, name,
+					"image %q is %d pixels wide, wider than the %d pixels of most email layouts",
+					attrs["src"], w, maxImageWidth)
+			}
+		}
+	}
+	slices.SortStableFunc(warnings, func(a, b entity.TemplateLintWarning) int {
+		return a.Line - b.Line
+	})
+	return warnings
+}
+
+// lintAttrs returns the attributes of a tag by lower case name. The values
+// are unquoted.
+func lintAttrs(s string) map[string]string {
+	attrs := make(map[string]string)
+	for _, m := range lintAttrRe.FindAllStringSubmatch(s, -1) {
+		name := strings.ToLower(m[1])
+		if _, ok := attrs[name]; ok {
+			continue
+		}
+		attrs[name] = strings.Trim(m[2], `"'`)
+	}
+	return attrs
+}
+
+// imageWidth returns the width of an image in pixels taken from its width
+// attribute or style, or 0 if it is not fixed in pixels.
+func imageWidth(attrs map[string]string) int {
+	width := 0
+	if v, ok := attrs["width"]; ok {
+		if n, err := strconv.Atoi(strings.TrimSuffix(strings.TrimSpace(v), "px")); err == nil {
+			width = n
+		}
+	}
+	for _, m := range lintWidthRe.FindAllStringSubmatch(attrs["style"], -1) {
+		if n, err := strconv.Atoi(m[1]); err == nil && n > width {
+			width = n
+		}
+	}
+	return width
+}
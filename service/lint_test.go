@@ -0,0 +1,78 @@
+package service_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/andyfusniak/squishy-mailer-lite/entity"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLintTemplate(t *testing.T) {
+	svc := newTestService(t)
+	ctx := context.Background()
+
+	html := `{{define "layout"}}<html>
+<head>
+<link rel="stylesheet" href="https://example.com/email.css">
+<style>@import url("https://fonts.example.com/font.css"); p { color: #333; }</style>
+</head>
+<body>
+<!--[if mso]><script>ignored()</script><![endif]-->
+<img src="{{.logo}}" alt="Example" width="200">
+<img src="banner.png" width="800">
+<img src="hero.png" alt="" style="display:block; width: 640px">
+<form action="https://example.com/subscribe"><input type="email" name="email"></form>
+<SCRIPT>track()</SCRIPT>
+</body>
+</html>{{end}}`
+	warnings, err := svc.LintTemplate(ctx, entity.LintTemplateParams{HTML: html})
+	if err != nil {
+		t.Fatalf("svc.LintTemplate failed: %+v", err)
+	}
+	type warning struct {
+		Rule    entity.TemplateLintRule
+		Line    int
+		Element string
+	}
+	var got []warning
+	for _, w := range warnings {
+		assert.NotEmpty(t, w.Message)
+		got = append(got, warning{w.Rule, w.Line, w.Element})
+	}
+	assert.Equal(t, []warning{
+		{entity.TemplateLintExternalCSS, 3, "link"},
+		{entity.TemplateLintExternalCSS, 4, "style"},
+		{entity.TemplateLintMissingAlt, 9, "img"},
+		{entity.TemplateLintWideImage, 9, "img"},
+		{entity.TemplateLintWideImage, 10, "img"},
+		{entity.TemplateLintUnsupportedTag, 11, "form"},
+		{entity.TemplateLintUnsupportedTag, 11, "input"},
+		{entity.TemplateLintUnsupportedTag, 12, "script"},
+	}, got)
+
+	// markdown sources are linted as the HTML they render
+	warnings, err = svc.LintTemplate(ctx, entity.LintTemplateParams{
+		SourceType: entity.TemplateSourceMarkdown,
+		Source:     "# Welcome\n\n![](https://example.com/logo.png)\n",
+	})
+	if err != nil {
+		t.Fatalf("svc.LintTemplate failed: %+v", err)
+	}
+	assert.Empty(t, warnings)
+
+	warnings, err = svc.LintTemplate(ctx, entity.LintTemplateParams{
+		HTML: `<p>Hello {{.name}}</p><img src="logo.png" alt="Logo" style="max-width:100%">`,
+	})
+	if err != nil {
+		t.Fatalf("svc.LintTemplate failed: %+v", err)
+	}
+	assert.Empty(t, warnings)
+
+	_, err = svc.LintTemplate(ctx, entity.LintTemplateParams{
+		SourceType: entity.TemplateSourceMarkdown,
+		HTML:       "<p>Hello</p>",
+		Source:     "Hello",
+	})
+	assertServiceErrorCode(t, err, entity.ErrInvalidTemplateCode)
+}
@@ -0,0 +1,9 @@
+package service
+
+import "log/slog"
+
+// sendLogger returns the logger for the events of a single email, see
+// WithLogger.
+func (s *Service) sendLogger(projectID, transportID, sendID string) *slog.Logger {
+	return s.logger.With("project_id", projectID, "transport_id", transportID, "send_id", sendID)
+}
@@ -0,0 +1,88 @@
+package service_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/andyfusniak/squishy-mailer-lite/entity"
+	"github.com/andyfusniak/squishy-mailer-lite/service"
+	"github.com/stretchr/testify/assert"
+)
+
+// logBuffer collects JSON log events.
+type logBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *logBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+// events returns the decoded log events with the message msg.
+func (b *logBuffer) events(t *testing.T, msg string) []map[string]any {
+	t.Helper()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var events []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(b.buf.String()), "\n") {
+		var e map[string]any
+		if err := json.Unmarshal([]byte(line), &e); err != nil {
+			t.Fatalf("json.Unmarshal log event failed: %+v", err)
+		}
+		if e["msg"] == msg {
+			events = append(events, e)
+		}
+	}
+	return events
+}
+
+func TestWithLogger(t *testing.T) {
+	var logs logBuffer
+	srv := newFakeSMTPServer(t)
+	svc := newTestService(t, service.WithLogger(slog.New(slog.NewJSONHandler(&logs,
+		&slog.HandlerOptions{Level: slog.LevelDebug}))))
+	setupQueueProject(t, svc, srv)
+	ctx := context.Background()
+
+	mq := queueTestEmail(t, svc)
+	if _, err := svc.ProcessMailQueue(ctx); err != nil {
+		t.Fatalf("svc.ProcessMailQueue failed: %+v", err)
+	}
+
+	// the events of a queued email are correlated by its mail queue id
+	for _, msg := range []string{"email queued", "email sent"} {
+		events := logs.events(t, msg)
+		if assert.Len(t, events, 1, msg) {
+			assert.Equal(t, mq.ID, events[0]["send_id"], msg)
+			assert.Equal(t, "p1", events[0]["project_id"], msg)
+			assert.Equal(t, "tr1", events[0]["transport_id"], msg)
+		}
+	}
+
+	// emails sent directly are given a send id used in their Message-ID
+	if err := svc.SendEmail(ctx, entity.SendEmailParams{
+		TemplateID:     "t1",
+		ProjectID:      "p1",
+		TransportID:    "tr1",
+		To:             []string{"to@example.com"},
+		TemplateParams: map[string]string{"name": "Andy"},
+	}); err != nil {
+		t.Fatalf("svc.SendEmail failed: %+v", err)
+	}
+	events := logs.events(t, "email sent")
+	if assert.Len(t, events, 2) {
+		sendID, _ := events[1]["send_id"].(string)
+		assert.NotEmpty(t, sendID)
+		assert.NotEqual(t, mq.ID, sendID)
+		assert.Equal(t, sendID+"@example.com", events[1]["message_id"])
+	}
+}
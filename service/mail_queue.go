@@ -0,0 +1,1059 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/textproto"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/andyfusniak/squishy-mailer-lite/entity"
+	"github.com/andyfusniak/squishy-mailer-lite/internal/email"
+	"github.com/andyfusniak/squishy-mailer-lite/internal/store"
+	"github.com/pkg/errors"
+)
+
+// BodyRetention determines what happens to the rendered body and template
+// params of an email in the mail queue after it has been delivered.
+type BodyRetention int
+
+const (
+	// RetainBody keeps the rendered body and template params.
+	RetainBody BodyRetention = iota
+
+	// TruncateBody truncates the rendered text and HTML bodies to
+	// RetentionPolicy.TruncateLength bytes and drops the template params.
+	TruncateBody
+
+	// DropBody drops the rendered text and HTML bodies and the template
+	// params, keeping only the metadata and digests.
+	DropBody
+)
+
+// defaultTruncateLength is used when a TruncateBody retention policy
+// does not specify a truncate length.
+const defaultTruncateLength = 256
+
+// RetentionPolicy controls how much personal data is kept at rest in the
+// mail queue after successful delivery. The envelope metadata and the
+// digests of the rendered bodies are always kept so that it is still
+// possible to prove what was sent.
+type RetentionPolicy struct {
+	Body BodyRetention
+
+	// TruncateLength is the number of bytes of the text and HTML bodies
+	// to keep when Body is TruncateBody. Defaults to 256.
+	TruncateLength int
+}
+
+// redact returns the body to store after delivery according to the policy,
+// or nil if the body should be left untouched.
+func (p RetentionPolicy) redact(body store.MailQueueBody) *store.MailQueueBody {
+	switch p.Body {
+	case TruncateBody:
+		n := p.TruncateLength
+		if n <= 0 {
+			n = defaultTruncateLength
+		}
+		return &store.MailQueueBody{
+			Txt:      truncate(body.Txt, n),
+			HTML:     truncate(body.HTML, n),
+			Redacted: true,
+		}
+	case DropBody:
+		return &store.MailQueueBody{Redacted: true}
+	}
+	return nil
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n]
+}
+
+// defaultClaimLimit is the maximum number of emails claimed from the
+// mail queue by a single call to ProcessMailQueue.
+const defaultClaimLimit = 50
+
+// defaultClaimLease is how long a claimed email may stay in the sending
+// state before it is claimed again.
+const defaultClaimLease = 15 * time.Minute
+
+// newMailQueueID returns a random 128 bit hex encoded mail queue id.
+func newMailQueueID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// newMessageID returns a Message-ID, without the angle brackets, using id
+// and the domain of the sender's address.
+func newMessageID(id, from string) string {
+	domain := recipientDomain(from)
+	if domain == "" {
+		domain = "localhost"
+	}
+	return id + "@" + domain
+}
+
+// SendEmailAsync renders the template and places the email on the mail
+// queue for delivery by ProcessMailQueue, no earlier than params.SendAt if
+// it is set. The transport is checked to exist at the time the email is
+// queued. If any recipient is outside the project's recipient domain
+// allow-list or on the project's suppression list the email is queued in
+// the blocked state instead. Every queued email is given a Message-ID so
+// that bounce notifications can be matched to it. The returned email holds
+// the rendered subject, text and HTML exactly as they will be sent, so
+// callers can archive them without rendering the template again.
+func (s *Service) SendEmailAsync(ctx context.Context, params entity.SendEmailParams) (*entity.MailQueue, error) {
+	return s.queueEmail(ctx, params, newSendCache(s, false))
+}
+
+func (s *Service) queueEmail(ctx context.Context, params entity.SendEmailParams, c *sendCache) (*entity.MailQueue, error) {
+	return s.insertMailQueue(ctx, params, c, func() (*renderedEmail, error) {
+		return c.render(ctx, params.ProjectID, params.TemplateID, params.Locale, params.TemplateParams,
+			params.StrictParams, params.PlainTextParams, false)
+	})
+}
+
+// insertMailQueue places an email on the mail queue with the bodies
+// returned by render, which is called once the params have been checked.
+func (s *Service) insertMailQueue(ctx context.Context, params entity.SendEmailParams, c *sendCache, render func() (*renderedEmail, error)) (*entity.MailQueue, error) {
+	if err := s.normaliseRecipients(ctx, &params); err != nil {
+		return nil, err
+	}
+	if err := s.checkUnsubscribe(params.Unsubscribe); err != nil {
+		return nil, err
+	}
+	if err := s.checkTrackOpens(params.TrackOpens); err != nil {
+		return nil, err
+	}
+	headers, err := checkHeaders(params.Headers)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkLabels(params.Labels); err != nil {
+		return nil, err
+	}
+	if err := checkCalendarEvent(params.CalendarEvent); err != nil {
+		return nil, err
+	}
+	th, err := checkThreading(params)
+	if err != nil {
+		return nil, err
+	}
+	r, err := render()
+	if err != nil {
+		return nil, err
+	}
+	templateParams, err := templateParamsMap(params.TemplateParams)
+	if err != nil {
+		return nil, err
+	}
+
+	// fail early if the transport does not exist
+	transportID, err := s.resolveTransportID(ctx, params.ProjectID, params.TransportID)
+	if err != nil {
+		return nil, err
+	}
+	from, err := c.transportFrom(ctx, transportID, params.ProjectID)
+	if err != nil {
+		return nil, err
+	}
+	emailFrom, err := s.checkFrom(ctx, params, transportID, from)
+	if err != nil {
+		return nil, err
+	}
+	if emailFrom != "" {
+		from = emailFrom
+	}
+
+	// emails to recipients outside of the project's allow-list are
+	// kept in the mail queue in the blocked state and never delivered
+	mstate := store.MailQueueStateQueued
+	var lastError string
+	blocked, err := s.blockedRecipients(ctx, params.ProjectID, params.To, params.Cc, params.Bcc)
+	if err != nil {
+		return nil, err
+	}
+	if len(blocked) > 0 {
+		mstate = store.MailQueueStateBlocked
+		lastError = blockedReason(blocked)
+	} else {
+		suppressed, err := s.suppressedRecipients(ctx, params.ProjectID, params.To, params.Cc, params.Bcc)
+		if err != nil {
+			return nil, err
+		}
+		if len(suppressed) > 0 {
+			mstate = store.MailQueueStateBlocked
+			lastError = suppressedReason(suppressed)
+		}
+	}
+
+	attachments, err := c.templateAttachments(ctx, params.ProjectID, params.TemplateID)
+	if err != nil {
+		return nil, err
+	}
+	attachmentIDs := make([]string, 0, len(attachments))
+	for _, a := range attachments {
+		attachmentIDs = append(attachmentIDs, a.AttachmentID)
+	}
+	extra, err := loadEmailAttachments(params.Attachments)
+	if err != nil {
+		return nil, err
+	}
+
+	assetIDs := make([]string, 0, len(r.inline))
+	for _, a := range r.inline {
+		assetIDs = append(assetIDs, a.AssetID)
+	}
+
+	// emails over the project's quota are rejected, or deferred to the
+	// first day with room if the quota's action is defer
+	var reservation *quotaReservation
+	if mstate != store.MailQueueStateBlocked {
+		sendAt, r, err := s.reserveQuota(ctx, params.ProjectID, params.SendAt, true)
+		if err != nil {
+			return nil, err
+		}
+		params.SendAt, reservation = sendAt, r
+	}
+
+	id, err := newMailQueueID()
+	if err != nil {
+		s.releaseQuota(ctx, reservation)
+		return nil, errors.Wrapf(err, "[service] newMailQueueID failed")
+	}
+	if th.messageID == "" {
+		th.messageID = newMessageID(id, from)
+	}
+	extra = append(extra, calendarAttachments(params.CalendarEvent, th.messageID, from,
+		slices.Concat(params.To, params.Cc), time.Now())...)
+
+	// the digest is of the rendered template so that it does not vary
+	// with the tracking pixel
+	htmlBody := r.html
+	trackOpens := params.TrackOpens && htmlBody != ""
+	if trackOpens {
+		htmlBody = s.addTrackingPixel(htmlBody, params.ProjectID, id)
+	}
+
+	now := store.Datetime(time.Now().UTC())
+	obj, err := s.store.InsertMailQueue(ctx, store.AddMailQueue{
+		MailQueueID: id,
+		ProjectID:   params.ProjectID,
+		TemplateID:  params.TemplateID,
+		TransportID: transportID,
+		MState:      mstate,
+		LastError:   lastError,
+		MessageID:   th.messageID,
+		CampaignID:  c.campaignID,
+		SendAt:      store.Datetime(params.SendAt.UTC()),
+		Metadata: store.MailQueueMetadata{
+			To:         params.To,
+			Cc:         params.Cc,
+			Bcc:        params.Bcc,
+			Subject:    r.subjectOr(params.Subject),
+			GroupID:    r.tmpl.GroupID,
+			Timezone:   params.Timezone,
+			TxtDigest:  contentDigest([]byte(r.txt)),
+			HTMLDigest: contentDigest([]byte(r.html)),
+
+			MessageStream: params.MessageStream,
+			AttachmentIDs: attachmentIDs,
+			AssetIDs:      assetIDs,
+			Unsubscribe:   params.Unsubscribe,
+			TrackOpens:    trackOpens,
+			Headers:       headers,
+			InReplyTo:     th.inReplyTo,
+			References:    th.references,
+			EmailFrom:     emailFrom,
+			EmailFromName: params.EmailFromName,
+			ReplyTo:       params.ReplyTo,
+			Test:          c.test,
+			Labels:        params.Labels,
+		},
+		Body: store.MailQueueBody{
+			Txt:            r.txt,
+			HTML:           htmlBody,
+			TemplateParams: templateParams,
+			Attachments:    extra,
+		},
+		CreatedAt:  now,
+		ModifiedAt: now,
+	})
+	if err != nil {
+		s.releaseQuota(ctx, reservation)
+		if serr := serviceErrorFromStore(err); serr != nil {
+			return nil, serr
+		}
+		return nil, errors.Wrapf(err, "[service] store.InsertMailQueue failed")
+	}
+	if err := checkProjectScope("mail queue entry", params.ProjectID, obj.ProjectID); err != nil {
+		return nil, err
+	}
+	if obj.MState == store.MailQueueStateBlocked {
+		s.publishMailEvent(obj, obj.LastError)
+	} else {
+		s.publishMailEvent(obj, "")
+	}
+	if obj.MState == store.MailQueueStateQueued {
+		if err := s.emitWebhookEvent(ctx, entity.WebhookEventQueued, obj, nil); err != nil {
+			return nil, err
+		}
+	}
+	log := s.sendLogger(obj.ProjectID, obj.TransportID, obj.MailQueueID)
+	if obj.MState == store.MailQueueStateBlocked {
+		log.Info("email blocked", "reason", obj.LastError)
+	} else {
+		log.Debug("email queued", "template_id", obj.TemplateID, "send_at", time.Time(obj.SendAt))
+	}
+	return mailQueueFromStoreObject(obj), nil
+}
+
+// GetMailQueue retrieves an email from the mail queue by its id and
+// project id. If the email is not found an error is returned with a code
+// of ErrMailQueueNotFoundCode.
+func (s *Service) GetMailQueue(ctx context.Context, projectID, mailQueueID string) (*entity.MailQueue, error) {
+	obj, err := s.store.GetMailQueue(ctx, projectID, mailQueueID)
+	if err != nil {
+		if serr := serviceErrorFromStore(err); serr != nil {
+			return nil, serr
+		}
+		return nil, errors.Wrapf(err, "[service] store.GetMailQueue failed")
+	}
+	if err := checkProjectScope("mail queue entry", projectID, obj.ProjectID); err != nil {
+		return nil, err
+	}
+	return mailQueueFromStoreObject(obj), nil
+}
+
+// ListMailQueue lists the emails in the mail queue of a project, newest
+// first, optionally only those in the given state or with the given
+// labels.
+func (s *Service) ListMailQueue(ctx context.Context, params entity.ListMailQueueParams) ([]*entity.MailQueue, error) {
+	list, err := s.store.ListMailQueue(ctx, store.ListMailQueue{
+		ProjectID: params.ProjectID,
+		MState:    string(params.State),
+		Labels:    params.Labels,
+		After:     params.After,
+		Limit:     params.Limit,
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "[service] store.ListMailQueue failed")
+	}
+
+	mailQueue := make([]*entity.MailQueue, 0, len(list))
+	for _, obj := range list {
+		if err := checkProjectScope("mail queue entry", params.ProjectID, obj.ProjectID); err != nil {
+			return nil, err
+		}
+		mailQueue = append(mailQueue, mailQueueFromStoreObject(obj))
+	}
+	return mailQueue, nil
+}
+
+// ListMailQueueAttempts lists the delivery attempts made for an email in
+// the order they were made. If the email is not found an error is
+// returned with a code of ErrMailQueueNotFoundCode.
+func (s *Service) ListMailQueueAttempts(ctx context.Context, projectID, mailQueueID string) ([]*entity.MailQueueAttempt, error) {
+	if _, err := s.GetMailQueue(ctx, projectID, mailQueueID); err != nil {
+		return nil, err
+	}
+	list, err := s.store.ListMailQueueAttempts(ctx, projectID, mailQueueID)
+	if err != nil {
+		return nil, errors.Wrapf(err, "[service] store.ListMailQueueAttempts failed")
+	}
+
+	attempts := make([]*entity.MailQueueAttempt, 0, len(list))
+	for _, obj := range list {
+		attempts = append(attempts, mailQueueAttemptFromStoreObject(obj))
+	}
+	return attempts, nil
+}
+
+// GetMailStatus returns the delivery status and attempt history of an
+// email. If the email is not found an error is returned with a code of
+// ErrMailQueueNotFoundCode.
+func (s *Service) GetMailStatus(ctx context.Context, projectID, mailQueueID string) (*entity.MailStatus, error) {
+	obj, err := s.store.GetMailQueue(ctx, projectID, mailQueueID)
+	if err != nil {
+		if serr := serviceErrorFromStore(err); serr != nil {
+			return nil, serr
+		}
+		return nil, errors.Wrapf(err, "[service] store.GetMailQueue failed")
+	}
+	if err := checkProjectScope("mail queue entry", projectID, obj.ProjectID); err != nil {
+		return nil, err
+	}
+	list, err := s.store.ListMailQueueAttempts(ctx, projectID, mailQueueID)
+	if err != nil {
+		return nil, errors.Wrapf(err, "[service] store.ListMailQueueAttempts failed")
+	}
+
+	opens, err := s.store.GetMailQueueOpens(ctx, projectID, mailQueueID)
+	if err != nil {
+		return nil, errors.Wrapf(err, "[service] store.GetMailQueueOpens failed")
+	}
+
+	status := mailStatusFromStoreObject(obj)
+	status.History = make([]*entity.MailQueueAttempt, 0, len(list))
+	for _, a := range list {
+		status.History = append(status.History, mailQueueAttemptFromStoreObject(a))
+	}
+	status.Opens = opens.Opens
+	status.FirstOpenedAt = entity.ISOTime(opens.FirstOpenedAt)
+	status.LastOpenedAt = entity.ISOTime(opens.LastOpenedAt)
+	return status, nil
+}
+
+// ListMail lists the delivery status of the emails of a project, newest
+// first, optionally only those in the given state. Unlike ListMailQueue
+// the bodies are not returned. Use GetMailStatus for the attempt history
+// of an email.
+func (s *Service) ListMail(ctx context.Context, params entity.ListMailQueueParams) ([]*entity.MailStatus, error) {
+	list, err := s.store.ListMailQueue(ctx, store.ListMailQueue{
+		ProjectID: params.ProjectID,
+		MState:    string(params.State),
+		Labels:    params.Labels,
+		After:     params.After,
+		Limit:     params.Limit,
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "[service] store.ListMailQueue failed")
+	}
+
+	statuses := make([]*entity.MailStatus, 0, len(list))
+	for _, obj := range list {
+		if err := checkProjectScope("mail queue entry", params.ProjectID, obj.ProjectID); err != nil {
+			return nil, err
+		}
+		statuses = append(statuses, mailStatusFromStoreObject(obj))
+	}
+	return statuses, nil
+}
+
+// MarkMailBounced moves a sent email to the bounced state, recording the
+// reason as its last error. It is used when the recipient's mail server
+// reports a bounce after accepting the email. If the email has not been
+// sent an error is returned with a code of ErrMailQueueStateCode.
+func (s *Service) MarkMailBounced(ctx context.Context, projectID, mailQueueID, reason string) (*entity.MailQueue, error) {
+	obj, err := s.transitionMailQueueObject(ctx, store.TransitionMailQueueState{
+		ProjectID:   projectID,
+		MailQueueID: mailQueueID,
+		From:        []string{store.MailQueueStateSent},
+		MState:      store.MailQueueStateBounced,
+		LastError:   &reason,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := s.emitWebhookEvent(ctx, entity.WebhookEventBounced, obj, nil); err != nil {
+		return nil, err
+	}
+	s.sendLogger(obj.ProjectID, obj.TransportID, obj.MailQueueID).Info("email bounced",
+		"reason", reason)
+	return mailQueueFromStoreObject(obj), nil
+}
+
+// RetryMailQueue returns a failed, dead_letter, blocked or bounced email to
+// the queue to be delivered on the next run of ProcessMailQueue. If the email
+// is in any other state an error is returned with a code of
+// ErrMailQueueStateCode.
+func (s *Service) RetryMailQueue(ctx context.Context, projectID, mailQueueID string) (*entity.MailQueue, error) {
+	next := store.Datetime(time.Now().UTC())
+	return s.transitionMailQueue(ctx, store.TransitionMailQueueState{
+		ProjectID:   projectID,
+		MailQueueID: mailQueueID,
+		From: []string{
+			store.MailQueueStateFailed,
+			store.MailQueueStateDeadLetter,
+			store.MailQueueStateBlocked,
+			store.MailQueueStateBounced,
+		},
+		MState:        store.MailQueueStateQueued,
+		NextAttemptAt: &next,
+	})
+}
+
+// CancelMailQueue cancels a queued, rate limited or paused email so that
+// it is never delivered.
+// Emails that are being sent or have left the queue cannot be cancelled
+// and an error is returned with a code of ErrMailQueueStateCode.
+func (s *Service) CancelMailQueue(ctx context.Context, projectID, mailQueueID string) (*entity.MailQueue, error) {
+	return s.transitionMailQueue(ctx, store.TransitionMailQueueState{
+		ProjectID:   projectID,
+		MailQueueID: mailQueueID,
+		From: []string{store.MailQueueStateQueued, store.MailQueueStateRateLimited,
+			store.MailQueueStatePaused},
+		MState: store.MailQueueStateCancelled,
+	})
+}
+
+// ListDeadLetters lists the emails of a project in the dead_letter state,
+// newest first. These are the emails that failed permanently or ran out
+// of delivery attempts.
+func (s *Service) ListDeadLetters(ctx context.Context, params entity.ListDeadLettersParams) ([]*entity.MailQueue, error) {
+	return s.ListMailQueue(ctx, entity.ListMailQueueParams{
+		ProjectID: params.ProjectID,
+		State:     entity.MailStateDeadLetter,
+		After:     params.After,
+		Limit:     params.Limit,
+	})
+}
+
+// RequeueDeadLetter returns an email in the dead_letter state to the queue
+// with its attempt counter reset, so that it is retried in full according
+// to the retry policy. If params.TransportID is set the email is delivered
+// using that transport instead, for example when the original transport's
+// provider is down. If the email is not a dead letter an error is returned
+// with a code of ErrMailQueueStateCode.
+func (s *Service) RequeueDeadLetter(ctx context.Context, params entity.RequeueDeadLetterParams) (*entity.MailQueue, error) {
+	var transportID *string
+	if params.TransportID != "" {
+		if err := s.checkTransport(ctx, params.TransportID, params.ProjectID); err != nil {
+			return nil, err
+		}
+		transportID = &params.TransportID
+	}
+
+	next := store.Datetime(time.Now().UTC())
+	attempts := 0
+	return s.transitionMailQueue(ctx, store.TransitionMailQueueState{
+		ProjectID:     params.ProjectID,
+		MailQueueID:   params.MailQueueID,
+		From:          []string{store.MailQueueStateDeadLetter},
+		MState:        store.MailQueueStateQueued,
+		NextAttemptAt: &next,
+		Attempts:      &attempts,
+		TransportID:   transportID,
+	})
+}
+
+func (s *Service) transitionMailQueue(ctx context.Context, params store.TransitionMailQueueState) (*entity.MailQueue, error) {
+	obj, err := s.transitionMailQueueObject(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+	return mailQueueFromStoreObject(obj), nil
+}
+
+func (s *Service) transitionMailQueueObject(ctx context.Context, params store.TransitionMailQueueState) (*store.MailQueue, error) {
+	obj, err := s.store.TransitionMailQueueState(ctx, params)
+	if err != nil {
+		if serr := serviceErrorFromStore(err); serr != nil {
+			return nil, serr
+		}
+		return nil, errors.Wrapf(err, "[service] store.TransitionMailQueueState failed")
+	}
+	if err := checkProjectScope("mail queue entry", params.ProjectID, obj.ProjectID); err != nil {
+		return nil, err
+	}
+	s.publishMailEvent(obj, "")
+	return obj, nil
+}
+
+// recordAttempt adds the outcome of a delivery attempt that started at
+// startedAt to the history of an email.
+func (s *Service) recordAttempt(ctx context.Context, mq *store.MailQueue, mstate string, startedAt time.Time, sendErr error) error {
+	params := store.AddMailQueueAttempt{
+		MailQueueID: mq.MailQueueID,
+		ProjectID:   mq.ProjectID,
+		TransportID: mq.TransportID,
+		MState:      mstate,
+		StartedAt:   store.Datetime(startedAt.UTC()),
+	}
+	if sendErr != nil {
+		params.Error = sendErr.Error()
+		params.ResponseCode, params.Response = attemptResponse(sendErr)
+	}
+	if _, err := s.store.InsertMailQueueAttempt(ctx, params); err != nil {
+		return errors.Wrapf(err, "[service] store.InsertMailQueueAttempt failed")
+	}
+	return nil
+}
+
+// attemptResponse returns the SMTP reply or API response carried by a
+// delivery error, if any.
+func attemptResponse(err error) (int, string) {
+	var terr *textproto.Error
+	if errors.As(err, &terr) {
+		return terr.Code, terr.Msg
+	}
+	var aerr *email.APIError
+	if errors.As(err, &aerr) {
+		return aerr.StatusCode, aerr.Body
+	}
+	return 0, ""
+}
+
+// ProcessMailQueue claims queued emails and delivers them using their
+// transports. Emails that are delivered are marked as sent and have the
+// retention policy applied to their body. Emails that fail are retried or
+// moved to the dead_letter state according to the retry policy, or marked
+// as failed if retries are disabled, with the error recorded. Emails that
+// fail with a transient error through a transport in the project's
+// transport chain are first tried through the transports after it. Emails
+// outside of their send window are deferred until the window opens, and
+// emails over a warming up transport's daily limit are deferred until the
+// next day. Emails over a rate limit are moved to the rate_limited state
+// until the limit allows them to be sent. Emails are delivered one at a
+// time unless the service was created with WithWorkerConcurrency or
+// WithTransportConcurrency. It returns the number of emails successfully
+// delivered. If ctx is done while emails are being delivered, the emails
+// not yet sent are returned to the queue and ctx.Err() is returned, and
+// likewise after a store error. Emails left sending by a worker that
+// stopped are claimed again once their lease expires, see WithClaimLease.
+// Delivery is therefore at least once: an email whose delivery cannot be
+// recorded, even after retrying, stays sending and is delivered again.
+// See Shutdown for how the emails being delivered are drained.
+func (s *Service) ProcessMailQueue(ctx context.Context) (int, error) {
+	ctx, done, err := s.startWork(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer done()
+
+	list, err := s.store.ClaimMailQueue(ctx, defaultClaimLimit, s.claimLease)
+	if err != nil {
+		return 0, errors.Wrapf(err, "[service] store.ClaimMailQueue failed")
+	}
+	if len(list) > 0 {
+		s.logger.Debug("claimed emails", "count", len(list))
+	}
+	for _, mq := range list {
+		s.publishMailEvent(mq, "")
+	}
+
+	if s.concurrency <= 1 && s.perTransport <= 0 {
+		var sent int
+		for i, mq := range list {
+			ok, err := s.processMailQueueEntry(ctx, mq)
+			if ok {
+				sent++
+			}
+			if err != nil {
+				for _, mq := range list[i+1:] {
+					s.abandonAfterError(ctx, mq, err)
+				}
+				return sent, err
+			}
+		}
+		return sent, stopped(ctx)
+	}
+
+	// deliver up to s.concurrency emails at a time and up to
+	// s.perTransport emails at a time through each transport. After the
+	// first store error the emails not yet started are returned to the
+	// queue. Claiming is a single statement so the workers never contend
+	// for the SQLite writer to pick up emails.
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		sent     int
+		firstErr error
+	)
+	var sem chan struct{}
+	if s.concurrency > 1 {
+		sem = make(chan struct{}, s.concurrency)
+	}
+	transportSems := make(map[string]chan struct{})
+	for _, mq := range list {
+		var tsem chan struct{}
+		if s.perTransport > 0 {
+			k := mq.ProjectID + "/" + mq.TransportID
+			if tsem = transportSems[k]; tsem == nil {
+				tsem = make(chan struct{}, s.perTransport)
+				transportSems[k] = tsem
+			}
+		}
+
+		wg.Add(1)
+		go func(mq *store.MailQueue) {
+			defer wg.Done()
+			if tsem != nil {
+				tsem <- struct{}{}
+				defer func() { <-tsem }()
+			}
+			if sem != nil {
+				sem <- struct{}{}
+				defer func() { <-sem }()
+			}
+
+			mu.Lock()
+			failed := firstErr
+			mu.Unlock()
+			if failed != nil {
+				s.abandonAfterError(ctx, mq, failed)
+				return
+			}
+			ok, err := s.processMailQueueEntry(ctx, mq)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil && firstErr == nil {
+				firstErr = err
+			}
+			if ok {
+				sent++
+			}
+		}(mq)
+	}
+	wg.Wait()
+	if firstErr != nil {
+		return sent, firstErr
+	}
+	return sent, stopped(ctx)
+}
+
+// processMailQueueEntry delivers or defers a single claimed email. It
+// reports whether the email was delivered. Delivery failures are recorded
+// against the email rather than returned. If ctx is done before the email
+// is delivered, or the service is shutting down before it starts sending,
+// it is returned to the queue without counting an attempt.
+func (s *Service) processMailQueueEntry(ctx context.Context, mq *store.MailQueue) (bool, error) {
+	if s.isClosing() {
+		return false, s.abandonMailQueue(ctx, mq, "service shutting down")
+	}
+	if cause := stopped(ctx); cause != nil {
+		return false, s.abandonMailQueue(ctx, mq, cause.Error())
+	}
+	ok, err := s.processClaimedEmail(ctx, mq)
+	if ok || err == nil {
+		return ok, err
+	}
+	if cause := interruption(ctx, err); cause != nil {
+		return false, s.abandonMailQueue(ctx, mq, cause.Error())
+	}
+	return false, err
+}
+
+// stopped returns ctx.Err(), or context.DeadlineExceeded if the deadline
+// of ctx has passed but ctx does not report that it is done yet.
+func stopped(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if d, ok := ctx.Deadline(); ok && !time.Now().Before(d) {
+		return context.DeadlineExceeded
+	}
+	return nil
+}
+
+// interruption returns why err was caused by the worker stopping, or nil
+// if it was not. The I/O deadline of a delivery is taken from ctx, so it
+// can expire a moment before ctx reports that it is done.
+func interruption(ctx context.Context, err error) error {
+	if cerr := ctx.Err(); cerr != nil {
+		return cerr
+	}
+	if stopped(ctx) != nil && errors.Is(err, os.ErrDeadlineExceeded) {
+		return context.DeadlineExceeded
+	}
+	return nil
+}
+
+// abandonMailQueue returns a claimed email that the worker stopped
+// processing to the queue to be sent straight away by the next worker.
+// The store is updated even though ctx is done.
+func (s *Service) abandonMailQueue(ctx context.Context, mq *store.MailQueue, cause string) error {
+	return s.deferMailQueue(context.WithoutCancel(ctx), mq, store.MailQueueStateQueued,
+		time.Now().UTC(), "delivery abandoned: "+cause)
+}
+
+// abandonAfterError returns a claimed email that was not processed
+// because of an earlier store error to the queue, rather than leaving it
+// in the sending state until its claim lease expires.
+func (s *Service) abandonAfterError(ctx context.Context, mq *store.MailQueue, cause error) {
+	if err := s.abandonMailQueue(ctx, mq, "batch stopped: "+cause.Error()); err != nil {
+		s.logger.Error("return email to queue failed",
+			"mail_queue_id", mq.MailQueueID, "error", err)
+	}
+}
+
+// processClaimedEmail is processMailQueueEntry for a worker that has not
+// been stopped. It reports true once the email has been delivered, even
+// if recording the delivery fails.
+func (s *Service) processClaimedEmail(ctx context.Context, mq *store.MailQueue) (bool, error) {
+	// recipients may have bounced or complained since the email was
+	// queued
+	suppressed, err := s.suppressedRecipients(ctx, mq.ProjectID,
+		mq.Metadata.To, mq.Metadata.Cc, mq.Metadata.Bcc)
+	if err != nil {
+		return false, err
+	}
+	if len(suppressed) > 0 {
+		reason := suppressedReason(suppressed)
+		blocked, err := s.store.UpdateMailQueueState(ctx, store.UpdateMailQueueState{
+			MailQueueID: mq.MailQueueID,
+			MState:      store.MailQueueStateBlocked,
+			LastError:   reason,
+		})
+		if err != nil {
+			return false, errors.Wrapf(err, "[service] store.UpdateMailQueueState failed")
+		}
+		s.publishMailEvent(blocked, reason)
+		s.sendLogger(mq.ProjectID, mq.TransportID, mq.MailQueueID).Info("email blocked",
+			"reason", reason)
+		return false, nil
+	}
+
+	// emails outside of their send window are returned to the queue
+	// until the window next opens
+	until, reason, err := s.sendWindowDeferral(ctx, mq, time.Now())
+	if err != nil {
+		return false, err
+	}
+	if !until.IsZero() {
+		return false, s.deferMailQueue(ctx, mq, store.MailQueueStateQueued, until, reason)
+	}
+
+	// transports that are warming up are limited to a daily volume
+	until, reason, err = s.warmupDeferral(ctx, mq, time.Now())
+	if err != nil {
+		return false, err
+	}
+	if !until.IsZero() {
+		return false, s.deferMailQueue(ctx, mq, store.MailQueueStateQueued, until, reason)
+	}
+
+	// emails over a project or transport rate limit are held back until
+	// the limit's window has passed
+	until, reason, err = s.rateLimitDeferral(ctx, mq, time.Now())
+	if err != nil {
+		return false, err
+	}
+	if !until.IsZero() {
+		return false, s.deferMailQueue(ctx, mq, store.MailQueueStateRateLimited, until, reason)
+	}
+
+	fallbacks, err := s.fallbackTransports(ctx, mq)
+	if err != nil {
+		return false, err
+	}
+
+	// via is the email as sent through the transport being tried, which
+	// moves along the project's transport chain after transient failures
+	via := mq
+	startedAt := time.Now()
+	for {
+		err := s.deliver(ctx, via)
+		if err == nil {
+			break
+		}
+		if interruption(ctx, err) != nil {
+			// the send was interrupted by the worker stopping rather
+			// than failing
+			return false, err
+		}
+		if len(fallbacks) == 0 || isPermanentFailure(err) {
+			return false, s.failMailQueue(ctx, via, startedAt, err)
+		}
+		if err := s.recordAttempt(ctx, via, store.MailQueueStateFailed, startedAt, err); err != nil {
+			return false, err
+		}
+		s.sendLogger(via.ProjectID, via.TransportID, via.MailQueueID).Warn("email delivery failed, falling back",
+			"fallback_transport_id", fallbacks[0], "error", err)
+		next := *mq
+		next.TransportID, fallbacks = fallbacks[0], fallbacks[1:]
+		via, startedAt = &next, time.Now()
+	}
+
+	// the email has gone so the delivery is recorded even if the worker
+	// is stopping, otherwise it would be sent again
+	ctx = context.WithoutCancel(ctx)
+	attempts := mq.Attempts + 1
+	sent, err := s.markSent(ctx, store.UpdateMailQueueState{
+		MailQueueID:     mq.MailQueueID,
+		MState:          store.MailQueueStateSent,
+		Attempts:        &attempts,
+		Body:            s.retention.redact(mq.Body),
+		SentTransportID: via.TransportID,
+	})
+	if err != nil {
+		return true, errors.Wrapf(err, "[service] store.UpdateMailQueueState failed")
+	}
+	s.publishMailEvent(sent, "")
+	if err := s.recordAttempt(ctx, via, store.MailQueueStateSent, startedAt, nil); err != nil {
+		return true, err
+	}
+	if err := s.emitWebhookEvent(ctx, entity.WebhookEventSent, sent, nil); err != nil {
+		return true, err
+	}
+	s.sendLogger(via.ProjectID, via.TransportID, via.MailQueueID).Debug("email sent",
+		"attempt", attempts, "duration", time.Since(startedAt))
+	return true, nil
+}
+
+// markSentBackoff is how long markSent waits before each retry.
+var markSentBackoff = []time.Duration{50 * time.Millisecond, 200 * time.Millisecond, 500 * time.Millisecond}
+
+// markSent records a delivered email as sent, retrying the store write
+// since an email left in the sending state is delivered again once its
+// claim lease expires.
+func (s *Service) markSent(ctx context.Context, params store.UpdateMailQueueState) (*store.MailQueue, error) {
+	for i := 0; ; i++ {
+		obj, err := s.store.UpdateMailQueueState(ctx, params)
+		if err == nil || i == len(markSentBackoff) {
+			return obj, err
+		}
+		s.logger.Warn("record email sent failed, retrying",
+			"mail_queue_id", params.MailQueueID, "error", err)
+		time.Sleep(markSentBackoff[i])
+	}
+}
+
+// deferMailQueue returns a claimed email to the queue in mstate to be
+// retried no earlier than until, recording the reason for the deferral.
+func (s *Service) deferMailQueue(ctx context.Context, mq *store.MailQueue, mstate string, until time.Time, reason string) error {
+	next := store.Datetime(until)
+	obj, err := s.store.UpdateMailQueueState(ctx, store.UpdateMailQueueState{
+		MailQueueID:    mq.MailQueueID,
+		MState:         mstate,
+		LastError:      mq.LastError,
+		DeferralReason: reason,
+		NextAttemptAt:  &next,
+	})
+	if err != nil {
+		return errors.Wrapf(err, "[service] store.UpdateMailQueueState failed")
+	}
+	s.publishMailEvent(obj, "")
+	s.sendLogger(mq.ProjectID, mq.TransportID, mq.MailQueueID).Info("email deferred",
+		"state", mstate, "until", until, "reason", reason)
+	return nil
+}
+
+// deliver sends a single claimed email using its transport.
+func (s *Service) deliver(ctx context.Context, mq *store.MailQueue) error {
+	sender, err := s.sender(ctx, mq.TransportID, mq.ProjectID)
+	if err != nil {
+		return err
+	}
+
+	attachments := make([]*store.Attachment, 0, len(mq.Metadata.AttachmentIDs))
+	for _, attachmentID := range mq.Metadata.AttachmentIDs {
+		a, err := s.store.GetAttachment(ctx, mq.ProjectID, attachmentID)
+		if err != nil {
+			return errors.Wrapf(err, "[service] store.GetAttachment failed")
+		}
+		if err := checkProjectScope("attachment", mq.ProjectID, a.ProjectID); err != nil {
+			return err
+		}
+		attachments = append(attachments, a)
+	}
+
+	inline := make([]*store.Asset, 0, len(mq.Metadata.AssetIDs))
+	for _, assetID := range mq.Metadata.AssetIDs {
+		a, err := s.store.GetAsset(ctx, mq.ProjectID, assetID)
+		if err != nil {
+			return errors.Wrapf(err, "[service] store.GetAsset failed")
+		}
+		if err := checkProjectScope("asset", mq.ProjectID, a.ProjectID); err != nil {
+			return err
+		}
+		inline = append(inline, a)
+	}
+
+	all := emailAttachments(attachments)
+	all = append(all, queuedAttachments(mq.Body.Attachments)...)
+	all = append(all, inlineAttachments(inline)...)
+	return sender.SendEmail(ctx, s.sandbox(email.EmailParams{
+		Subject:     mq.Metadata.Subject,
+		Text:        mq.Body.Txt,
+		HTML:        mq.Body.HTML,
+		To:          mq.Metadata.To,
+		Cc:          mq.Metadata.Cc,
+		Bcc:         mq.Metadata.Bcc,
+		Attachments: all,
+
+		From:          mq.Metadata.EmailFrom,
+		FromName:      mq.Metadata.EmailFromName,
+		ReplyTo:       mq.Metadata.ReplyTo,
+		MessageStream: mq.Metadata.MessageStream,
+		MessageID:     mq.MessageID,
+		Headers: mergeHeaders(mq.Metadata.Headers,
+			threadHeaders(mq.Metadata.InReplyTo, mq.Metadata.References),
+			s.unsubscribeHeaders(mq.Metadata.Unsubscribe, mq.ProjectID, mq.Metadata.To)),
+	}))
+}
+
+func mailQueueFromStoreObject(obj *store.MailQueue) *entity.MailQueue {
+	return &entity.MailQueue{
+		ID:              obj.MailQueueID,
+		ProjectID:       obj.ProjectID,
+		TemplateID:      obj.TemplateID,
+		TransportID:     obj.TransportID,
+		State:           entity.MailState(obj.MState),
+		To:              obj.Metadata.To,
+		Cc:              obj.Metadata.Cc,
+		Bcc:             obj.Metadata.Bcc,
+		Subject:         obj.Metadata.Subject,
+		EmailFrom:       obj.Metadata.EmailFrom,
+		EmailFromName:   obj.Metadata.EmailFromName,
+		ReplyTo:         obj.Metadata.ReplyTo,
+		MessageStream:   obj.Metadata.MessageStream,
+		Headers:         obj.Metadata.Headers,
+		Text:            obj.Body.Txt,
+		TextDigest:      obj.Metadata.TxtDigest,
+		HTML:            obj.Body.HTML,
+		HTMLDigest:      obj.Metadata.HTMLDigest,
+		TemplateParams:  obj.Body.TemplateParams,
+		Redacted:        obj.Body.Redacted,
+		LastError:       obj.LastError,
+		TrackOpens:      obj.Metadata.TrackOpens,
+		Attempts:        obj.Attempts,
+		SentAt:          entity.ISOTime(obj.SentAt),
+		SentTransportID: obj.SentTransportID,
+		CampaignID:      obj.CampaignID,
+		Test:            obj.Metadata.Test,
+		Labels:          obj.Metadata.Labels,
+		SendAt:          entity.ISOTime(obj.SendAt),
+		NextAttemptAt:   entity.ISOTime(obj.NextAttemptAt),
+		DeferralReason:  obj.DeferralReason,
+		MessageID:       obj.MessageID,
+		InReplyTo:       obj.Metadata.InReplyTo,
+		References:      obj.Metadata.References,
+		CreatedAt:       entity.ISOTime(obj.CreatedAt),
+		ModifiedAt:      entity.ISOTime(obj.ModifiedAt),
+	}
+}
+
+func mailStatusFromStoreObject(obj *store.MailQueue) *entity.MailStatus {
+	return &entity.MailStatus{
+		ID:              obj.MailQueueID,
+		ProjectID:       obj.ProjectID,
+		TemplateID:      obj.TemplateID,
+		TransportID:     obj.TransportID,
+		State:           entity.MailState(obj.MState),
+		To:              obj.Metadata.To,
+		Subject:         obj.Metadata.Subject,
+		LastError:       obj.LastError,
+		Attempts:        obj.Attempts,
+		QueuedAt:        entity.ISOTime(obj.CreatedAt),
+		SendAt:          entity.ISOTime(obj.SendAt),
+		NextAttemptAt:   entity.ISOTime(obj.NextAttemptAt),
+		DeferralReason:  obj.DeferralReason,
+		SentAt:          entity.ISOTime(obj.SentAt),
+		SentTransportID: obj.SentTransportID,
+		UpdatedAt:       entity.ISOTime(obj.ModifiedAt),
+	}
+}
+
+func mailQueueAttemptFromStoreObject(obj *store.MailQueueAttempt) *entity.MailQueueAttempt {
+	return &entity.MailQueueAttempt{
+		Attempt:      obj.Attempt,
+		TransportID:  obj.TransportID,
+		State:        entity.MailState(obj.MState),
+		Error:        obj.Error,
+		ResponseCode: obj.ResponseCode,
+		Response:     obj.Response,
+		StartedAt:    entity.ISOTime(obj.StartedAt),
+		CreatedAt:    entity.ISOTime(obj.CreatedAt),
+	}
+}
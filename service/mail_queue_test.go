@@ -0,0 +1,1018 @@
+package service_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/andyfusniak/squishy-mailer-lite/entity"
+	"github.com/andyfusniak/squishy-mailer-lite/internal/store"
+	"github.com/andyfusniak/squishy-mailer-lite/internal/store/memory"
+	"github.com/andyfusniak/squishy-mailer-lite/service"
+	"github.com/stretchr/testify/assert"
+)
+
+// setupQueueProject creates project p1 with a transport pointing at the
+// fake SMTP server, group g1 and template t1.
+func setupQueueProject(t *testing.T, svc *service.Service, srv *fakeSMTPServer) {
+	t.Helper()
+
+	ctx := context.Background()
+	if _, err := svc.CreateProject(ctx, "p1", "Project One", ""); err != nil {
+		t.Fatalf("svc.CreateProject failed: %+v", err)
+	}
+	if _, err := svc.CreateSMTPTransport(ctx, entity.CreateSMTPTransport{
+		ID:            "tr1",
+		ProjectID:     "p1",
+		Name:          "Transport One",
+		Host:          srv.Host(),
+		Port:          srv.Port(),
+		Username:      "user",
+		Password:      "secret",
+		EmailFrom:     "from@example.com",
+		EmailFromName: "Example",
+		EmailReplyTo:  []string{"reply@example.com"},
+	}); err != nil {
+		t.Fatalf("svc.CreateSMTPTransport failed: %+v", err)
+	}
+	if _, err := svc.CreateGroup(ctx, "g1", "p1", "Group One"); err != nil {
+		t.Fatalf("svc.CreateGroup failed: %+v", err)
+	}
+	if _, err := svc.CreateTemplate(ctx, entity.CreateTemplate{
+		ID:        "t1",
+		ProjectID: "p1",
+		GroupID:   "g1",
+		Text:      `{{define "layout"}}Hello {{.name}}, this is the text body of the email{{end}}`,
+		HTML:      `{{define "layout"}}<p>Hello {{.name}}, this is the HTML body of the email</p>{{end}}`,
+	}); err != nil {
+		t.Fatalf("svc.CreateTemplate failed: %+v", err)
+	}
+}
+
+func queueTestEmail(t *testing.T, svc *service.Service) *entity.MailQueue {
+	t.Helper()
+
+	mq, err := svc.SendEmailAsync(context.Background(), entity.SendEmailParams{
+		TemplateID:     "t1",
+		ProjectID:      "p1",
+		TransportID:    "tr1",
+		To:             []string{"to@example.com"},
+		Subject:        "Welcome",
+		TemplateParams: map[string]string{"name": "Andy"},
+	})
+	if err != nil {
+		t.Fatalf("svc.SendEmailAsync failed: %+v", err)
+	}
+	return mq
+}
+
+func TestSendEmailAsyncAndProcessMailQueue(t *testing.T) {
+	srv := newFakeSMTPServer(t)
+	svc := newTestService(t)
+	setupQueueProject(t, svc, srv)
+
+	queued := queueTestEmail(t, svc)
+	assert.Equal(t, entity.MailStateQueued, queued.State)
+	assert.Equal(t, []string{"to@example.com"}, queued.To)
+	// the rendered email is returned so it can be archived as sent
+	assert.Equal(t, "Welcome", queued.Subject)
+	assert.Equal(t, "Hello Andy, this is the text body of the email", queued.Text)
+	assert.Equal(t, "<p>Hello Andy, this is the HTML body of the email</p>", queued.HTML)
+
+	ctx := context.Background()
+	n, err := svc.ProcessMailQueue(ctx)
+	if err != nil {
+		t.Fatalf("svc.ProcessMailQueue failed: %+v", err)
+	}
+	assert.Equal(t, 1, n)
+
+	msgs := srv.Messages()
+	if assert.Len(t, msgs, 1) {
+		assert.Equal(t, []string{"to@example.com"}, msgs[0].To)
+		assert.True(t, strings.Contains(msgs[0].Data, "Subject: Welcome"))
+	}
+
+	// the default retention policy keeps everything
+	sent, err := svc.GetMailQueue(ctx, "p1", queued.ID)
+	if err != nil {
+		t.Fatalf("svc.GetMailQueue failed: %+v", err)
+	}
+	assert.Equal(t, entity.MailStateSent, sent.State)
+	assert.False(t, sent.Redacted)
+	assert.Equal(t, queued.Text, sent.Text)
+	assert.Equal(t, queued.HTML, sent.HTML)
+	assert.Equal(t, map[string]any{"name": "Andy"}, sent.TemplateParams)
+
+	// nothing left to send
+	n, err = svc.ProcessMailQueue(ctx)
+	if err != nil {
+		t.Fatalf("svc.ProcessMailQueue failed: %+v", err)
+	}
+	assert.Equal(t, 0, n)
+}
+
+func TestRetentionPolicyDropBody(t *testing.T) {
+	srv := newFakeSMTPServer(t)
+	svc := newTestService(t, service.WithRetentionPolicy(service.RetentionPolicy{
+		Body: service.DropBody,
+	}))
+	setupQueueProject(t, svc, srv)
+
+	queued := queueTestEmail(t, svc)
+
+	ctx := context.Background()
+	if _, err := svc.ProcessMailQueue(ctx); err != nil {
+		t.Fatalf("svc.ProcessMailQueue failed: %+v", err)
+	}
+
+	sent, err := svc.GetMailQueue(ctx, "p1", queued.ID)
+	if err != nil {
+		t.Fatalf("svc.GetMailQueue failed: %+v", err)
+	}
+	assert.Equal(t, entity.MailStateSent, sent.State)
+	assert.True(t, sent.Redacted)
+	assert.Empty(t, sent.Text)
+	assert.Empty(t, sent.HTML)
+	assert.Empty(t, sent.TemplateParams)
+
+	// metadata and digests are kept
+	assert.Equal(t, queued.To, sent.To)
+	assert.Equal(t, queued.Subject, sent.Subject)
+	assert.Equal(t, queued.TextDigest, sent.TextDigest)
+	assert.Equal(t, queued.HTMLDigest, sent.HTMLDigest)
+}
+
+func TestRetentionPolicyTruncateBody(t *testing.T) {
+	srv := newFakeSMTPServer(t)
+	svc := newTestService(t, service.WithRetentionPolicy(service.RetentionPolicy{
+		Body:           service.TruncateBody,
+		TruncateLength: 10,
+	}))
+	setupQueueProject(t, svc, srv)
+
+	queued := queueTestEmail(t, svc)
+
+	ctx := context.Background()
+	if _, err := svc.ProcessMailQueue(ctx); err != nil {
+		t.Fatalf("svc.ProcessMailQueue failed: %+v", err)
+	}
+
+	sent, err := svc.GetMailQueue(ctx, "p1", queued.ID)
+	if err != nil {
+		t.Fatalf("svc.GetMailQueue failed: %+v", err)
+	}
+	assert.True(t, sent.Redacted)
+	assert.Equal(t, queued.Text[:10], sent.Text)
+	assert.Equal(t, queued.HTML[:10], sent.HTML)
+	assert.Empty(t, sent.TemplateParams)
+	assert.Equal(t, queued.TextDigest, sent.TextDigest)
+}
+
+func TestRetentionPolicyKeepsFailedBody(t *testing.T) {
+	srv := newFakeSMTPServer(t)
+	svc := newTestService(t, service.WithRetentionPolicy(service.RetentionPolicy{
+		Body: service.DropBody,
+	}))
+	setupQueueProject(t, svc, srv)
+
+	queued := queueTestEmail(t, svc)
+
+	// stop the server so that delivery fails
+	srv.ln.Close()
+
+	ctx := context.Background()
+	n, err := svc.ProcessMailQueue(ctx)
+	if err != nil {
+		t.Fatalf("svc.ProcessMailQueue failed: %+v", err)
+	}
+	assert.Equal(t, 0, n)
+
+	failed, err := svc.GetMailQueue(ctx, "p1", queued.ID)
+	if err != nil {
+		t.Fatalf("svc.GetMailQueue failed: %+v", err)
+	}
+	assert.Equal(t, entity.MailStateFailed, failed.State)
+	assert.NotEmpty(t, failed.LastError)
+	assert.False(t, failed.Redacted)
+	assert.Equal(t, queued.Text, failed.Text)
+}
+
+func TestProcessMailQueueInMemoryStore(t *testing.T) {
+	srv := newFakeSMTPServer(t)
+	svc, err := service.NewEmailService(
+		service.WithInMemoryStore(),
+		service.WithHexEncodedEncryptionKey(testEncryptionKey),
+	)
+	if err != nil {
+		t.Fatalf("service.NewEmailService failed: %+v", err)
+	}
+	defer svc.Close()
+	setupQueueProject(t, svc, srv)
+
+	queued := queueTestEmail(t, svc)
+
+	ctx := context.Background()
+	n, err := svc.ProcessMailQueue(ctx)
+	if err != nil {
+		t.Fatalf("svc.ProcessMailQueue failed: %+v", err)
+	}
+	assert.Equal(t, 1, n)
+	assert.Len(t, srv.Messages(), 1)
+
+	sent, err := svc.GetMailQueue(ctx, "p1", queued.ID)
+	if err != nil {
+		t.Fatalf("svc.GetMailQueue failed: %+v", err)
+	}
+	assert.Equal(t, entity.MailStateSent, sent.State)
+	assert.Equal(t, 1, sent.Attempts)
+}
+
+func TestSendEmailAsyncCcBcc(t *testing.T) {
+	srv := newFakeSMTPServer(t)
+	svc := newTestService(t)
+	setupQueueProject(t, svc, srv)
+
+	ctx := context.Background()
+	queued, err := svc.SendEmailAsync(ctx, entity.SendEmailParams{
+		TemplateID:  "t1",
+		ProjectID:   "p1",
+		TransportID: "tr1",
+		To:          []string{"to@example.com"},
+		Cc:          []string{"cc@example.com"},
+		Bcc:         []string{"audit@example.com"},
+		Subject:     "Welcome",
+	})
+	if err != nil {
+		t.Fatalf("svc.SendEmailAsync failed: %+v", err)
+	}
+	assert.Equal(t, []string{"cc@example.com"}, queued.Cc)
+	assert.Equal(t, []string{"audit@example.com"}, queued.Bcc)
+
+	n, err := svc.ProcessMailQueue(ctx)
+	if err != nil {
+		t.Fatalf("svc.ProcessMailQueue failed: %+v", err)
+	}
+	assert.Equal(t, 1, n)
+
+	msgs := srv.Messages()
+	if assert.Len(t, msgs, 1) {
+		assert.ElementsMatch(t,
+			[]string{"to@example.com", "cc@example.com", "audit@example.com"}, msgs[0].To)
+		assert.Contains(t, msgs[0].Data, "Cc: <cc@example.com>")
+		assert.NotContains(t, msgs[0].Data, "audit@example.com")
+	}
+}
+
+func TestSendEmailAsyncScheduled(t *testing.T) {
+	srv := newFakeSMTPServer(t)
+	svc := newTestService(t)
+	setupQueueProject(t, svc, srv)
+
+	ctx := context.Background()
+	params := entity.SendEmailParams{
+		TemplateID:  "t1",
+		ProjectID:   "p1",
+		TransportID: "tr1",
+		To:          []string{"to@example.com"},
+		Subject:     "Reminder",
+		SendAt:      time.Now().Add(time.Hour),
+	}
+	later, err := svc.SendEmailAsync(ctx, params)
+	if err != nil {
+		t.Fatalf("svc.SendEmailAsync failed: %+v", err)
+	}
+	assert.WithinDuration(t, params.SendAt, time.Time(later.SendAt), time.Millisecond)
+	assert.Equal(t, later.SendAt, later.NextAttemptAt)
+
+	// a send time in the past is due immediately
+	params.SendAt = time.Now().Add(-time.Minute)
+	due, err := svc.SendEmailAsync(ctx, params)
+	if err != nil {
+		t.Fatalf("svc.SendEmailAsync failed: %+v", err)
+	}
+
+	n, err := svc.ProcessMailQueue(ctx)
+	if err != nil {
+		t.Fatalf("svc.ProcessMailQueue failed: %+v", err)
+	}
+	assert.Equal(t, 1, n)
+
+	mq, err := svc.GetMailQueue(ctx, "p1", due.ID)
+	if err != nil {
+		t.Fatalf("svc.GetMailQueue failed: %+v", err)
+	}
+	assert.Equal(t, entity.MailStateSent, mq.State)
+
+	mq, err = svc.GetMailQueue(ctx, "p1", later.ID)
+	if err != nil {
+		t.Fatalf("svc.GetMailQueue failed: %+v", err)
+	}
+	assert.Equal(t, entity.MailStateQueued, mq.State)
+}
+
+func TestMailQueueListRetryCancel(t *testing.T) {
+	stores := []struct {
+		name string
+		opts []service.Option
+	}{
+		{"sqlite3", nil},
+		{"memory", []service.Option{service.WithInMemoryStore()}},
+	}
+	for _, tc := range stores {
+		t.Run(tc.name, func(t *testing.T) {
+			srv := newFakeSMTPServer(t)
+			svc := newTestService(t, tc.opts...)
+			setupQueueProject(t, svc, srv)
+			ctx := context.Background()
+
+			a := queueTestEmail(t, svc)
+			if _, err := svc.ProcessMailQueue(ctx); err != nil {
+				t.Fatalf("svc.ProcessMailQueue failed: %+v", err)
+			}
+
+			// stop the server so that delivery of b fails
+			b := queueTestEmail(t, svc)
+			srv.ln.Close()
+			if _, err := svc.ProcessMailQueue(ctx); err != nil {
+				t.Fatalf("svc.ProcessMailQueue failed: %+v", err)
+			}
+			c := queueTestEmail(t, svc)
+
+			list, err := svc.ListMailQueue(ctx, entity.ListMailQueueParams{ProjectID: "p1"})
+			if err != nil {
+				t.Fatalf("svc.ListMailQueue failed: %+v", err)
+			}
+			if assert.Len(t, list, 3) {
+				assert.Equal(t, []string{c.ID, b.ID, a.ID}, []string{list[0].ID, list[1].ID, list[2].ID})
+			}
+
+			list, err = svc.ListMailQueue(ctx, entity.ListMailQueueParams{
+				ProjectID: "p1",
+				After:     c.ID,
+				Limit:     1,
+			})
+			if err != nil {
+				t.Fatalf("svc.ListMailQueue failed: %+v", err)
+			}
+			if assert.Len(t, list, 1) {
+				assert.Equal(t, b.ID, list[0].ID)
+			}
+
+			list, err = svc.ListMailQueue(ctx, entity.ListMailQueueParams{
+				ProjectID: "p1",
+				State:     entity.MailStateFailed,
+			})
+			if err != nil {
+				t.Fatalf("svc.ListMailQueue failed: %+v", err)
+			}
+			if assert.Len(t, list, 1) {
+				assert.Equal(t, b.ID, list[0].ID)
+			}
+
+			attempts, err := svc.ListMailQueueAttempts(ctx, "p1", a.ID)
+			if err != nil {
+				t.Fatalf("svc.ListMailQueueAttempts failed: %+v", err)
+			}
+			if assert.Len(t, attempts, 1) {
+				assert.Equal(t, 1, attempts[0].Attempt)
+				assert.Equal(t, entity.MailStateSent, attempts[0].State)
+				assert.Empty(t, attempts[0].Error)
+			}
+			attempts, err = svc.ListMailQueueAttempts(ctx, "p1", b.ID)
+			if err != nil {
+				t.Fatalf("svc.ListMailQueueAttempts failed: %+v", err)
+			}
+			if assert.Len(t, attempts, 1) {
+				assert.Equal(t, entity.MailStateFailed, attempts[0].State)
+				assert.NotEmpty(t, attempts[0].Error)
+			}
+
+			retried, err := svc.RetryMailQueue(ctx, "p1", b.ID)
+			if err != nil {
+				t.Fatalf("svc.RetryMailQueue failed: %+v", err)
+			}
+			assert.Equal(t, entity.MailStateQueued, retried.State)
+			_, err = svc.RetryMailQueue(ctx, "p1", a.ID)
+			assertServiceErrorCode(t, err, entity.ErrMailQueueStateCode)
+
+			cancelled, err := svc.CancelMailQueue(ctx, "p1", c.ID)
+			if err != nil {
+				t.Fatalf("svc.CancelMailQueue failed: %+v", err)
+			}
+			assert.Equal(t, entity.MailStateCancelled, cancelled.State)
+			_, err = svc.CancelMailQueue(ctx, "p1", c.ID)
+			assertServiceErrorCode(t, err, entity.ErrMailQueueStateCode)
+			_, err = svc.CancelMailQueue(ctx, "p1", "missing")
+			assertServiceErrorCode(t, err, entity.ErrMailQueueNotFoundCode)
+		})
+	}
+}
+
+func TestGetMailStatus(t *testing.T) {
+	stores := []struct {
+		name string
+		opts []service.Option
+	}{
+		{"sqlite3", nil},
+		{"memory", []service.Option{service.WithInMemoryStore()}},
+	}
+	for _, tc := range stores {
+		t.Run(tc.name, func(t *testing.T) {
+			srv := newFakeSMTPServer(t)
+			svc := newTestService(t, tc.opts...)
+			setupQueueProject(t, svc, srv)
+			ctx := context.Background()
+
+			sent := queueTestEmail(t, svc)
+			rejected, err := svc.SendEmailAsync(ctx, entity.SendEmailParams{
+				TemplateID:     "t1",
+				ProjectID:      "p1",
+				TransportID:    "tr1",
+				To:             []string{"reject@example.com"},
+				TemplateParams: map[string]string{"name": "Andy"},
+			})
+			if err != nil {
+				t.Fatalf("svc.SendEmailAsync failed: %+v", err)
+			}
+			if _, err := svc.ProcessMailQueue(ctx); err != nil {
+				t.Fatalf("svc.ProcessMailQueue failed: %+v", err)
+			}
+
+			st, err := svc.GetMailStatus(ctx, "p1", sent.ID)
+			if err != nil {
+				t.Fatalf("svc.GetMailStatus failed: %+v", err)
+			}
+			assert.Equal(t, entity.MailStateSent, st.State)
+			assert.False(t, time.Time(st.SentAt).IsZero())
+			if assert.Len(t, st.History, 1) {
+				a := st.History[0]
+				assert.Equal(t, entity.MailStateSent, a.State)
+				assert.False(t, time.Time(a.CreatedAt).Before(time.Time(a.StartedAt)))
+			}
+
+			st, err = svc.GetMailStatus(ctx, "p1", rejected.ID)
+			if err != nil {
+				t.Fatalf("svc.GetMailStatus failed: %+v", err)
+			}
+			assert.Equal(t, entity.MailStateFailed, st.State)
+			assert.True(t, time.Time(st.SentAt).IsZero())
+			if assert.Len(t, st.History, 1) {
+				assert.Equal(t, 550, st.History[0].ResponseCode)
+				assert.Equal(t, "mailbox unavailable", st.History[0].Response)
+			}
+
+			// a bounce reported after delivery
+			_, err = svc.MarkMailBounced(ctx, "p1", rejected.ID, "mailbox full")
+			assertServiceErrorCode(t, err, entity.ErrMailQueueStateCode)
+			bounced, err := svc.MarkMailBounced(ctx, "p1", sent.ID, "mailbox full")
+			if err != nil {
+				t.Fatalf("svc.MarkMailBounced failed: %+v", err)
+			}
+			assert.Equal(t, entity.MailStateBounced, bounced.State)
+			assert.Equal(t, "mailbox full", bounced.LastError)
+
+			list, err := svc.ListMail(ctx, entity.ListMailQueueParams{
+				ProjectID: "p1",
+				State:     entity.MailStateBounced,
+			})
+			if err != nil {
+				t.Fatalf("svc.ListMail failed: %+v", err)
+			}
+			if assert.Len(t, list, 1) {
+				assert.Equal(t, sent.ID, list[0].ID)
+				assert.Nil(t, list[0].History)
+			}
+
+			_, err = svc.GetMailStatus(ctx, "p2", sent.ID)
+			assertServiceErrorCode(t, err, entity.ErrMailQueueNotFoundCode)
+		})
+	}
+}
+
+func TestProcessMailQueueTransportConcurrency(t *testing.T) {
+	srv := newFakeSMTPServer(t)
+	svc := newTestService(t, service.WithTransportConcurrency(2))
+	setupQueueProject(t, svc, srv)
+	ctx := context.Background()
+
+	for i := 0; i < 8; i++ {
+		queueTestEmail(t, svc)
+	}
+	n, err := svc.ProcessMailQueue(ctx)
+	if err != nil {
+		t.Fatalf("svc.ProcessMailQueue failed: %+v", err)
+	}
+	assert.Equal(t, 8, n)
+	assert.Len(t, srv.Messages(), 8)
+	assert.LessOrEqual(t, srv.PeakConnections(), 2)
+
+	list, err := svc.ListMailQueue(ctx, entity.ListMailQueueParams{ProjectID: "p1"})
+	if err != nil {
+		t.Fatalf("svc.ListMailQueue failed: %+v", err)
+	}
+	for _, mq := range list {
+		assert.Equal(t, entity.MailStateSent, mq.State)
+	}
+}
+
+func TestSendEmailHeaders(t *testing.T) {
+	srv := newFakeSMTPServer(t)
+	svc := newTestService(t)
+	setupQueueProject(t, svc, srv)
+	ctx := context.Background()
+
+	params := entity.SendEmailParams{
+		TemplateID:     "t1",
+		ProjectID:      "p1",
+		TransportID:    "tr1",
+		To:             []string{"to@example.com"},
+		TemplateParams: map[string]string{"name": "Andy"},
+		Headers: map[string]string{
+			"x-campaign-id": "spring-sale",
+			"Precedence":    "bulk",
+		},
+	}
+	mq, err := svc.SendEmailAsync(ctx, params)
+	if err != nil {
+		t.Fatalf("svc.SendEmailAsync failed: %+v", err)
+	}
+	assert.Equal(t, map[string]string{"X-Campaign-Id": "spring-sale", "Precedence": "bulk"}, mq.Headers)
+	if _, err := svc.ProcessMailQueue(ctx); err != nil {
+		t.Fatalf("svc.ProcessMailQueue failed: %+v", err)
+	}
+	if err := svc.SendEmail(ctx, params); err != nil {
+		t.Fatalf("svc.SendEmail failed: %+v", err)
+	}
+
+	msgs := srv.Messages()
+	if assert.Len(t, msgs, 2) {
+		for _, msg := range msgs {
+			assert.Contains(t, msg.Data, "X-Campaign-Id: spring-sale\r\n")
+			assert.Contains(t, msg.Data, "Precedence: bulk\r\n")
+		}
+	}
+
+	tests := []struct {
+		name    string
+		headers map[string]string
+	}{
+		{"reserved", map[string]string{"from": "evil@example.com"}},
+		{"line break in value", map[string]string{"X-Tag": "a\r\nBcc: evil@example.com"}},
+		{"invalid name", map[string]string{"X Tag": "a"}},
+		{"duplicate", map[string]string{"X-Tag": "a", "x-tag": "b"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := params
+			p.Headers = tt.headers
+			_, err := svc.SendEmailAsync(ctx, p)
+			assertServiceErrorCode(t, err, entity.ErrInvalidHeadersCode)
+			err = svc.SendEmail(ctx, p)
+			assertServiceErrorCode(t, err, entity.ErrInvalidHeadersCode)
+		})
+	}
+}
+
+func TestMessageIDThreading(t *testing.T) {
+	srv := newFakeSMTPServer(t)
+	svc := newTestService(t)
+	setupQueueProject(t, svc, srv)
+	ctx := context.Background()
+
+	// synchronous sends are given a Message-ID too
+	params := entity.SendEmailParams{
+		TemplateID:     "t1",
+		ProjectID:      "p1",
+		TransportID:    "tr1",
+		To:             []string{"to@example.com"},
+		TemplateParams: map[string]string{"name": "Andy"},
+	}
+	if err := svc.SendEmail(ctx, params); err != nil {
+		t.Fatalf("svc.SendEmail failed: %+v", err)
+	}
+
+	first := queueTestEmail(t, svc)
+	assert.True(t, strings.HasSuffix(first.MessageID, "@example.com"), first.MessageID)
+
+	reply := params
+	reply.MessageID = "<reply-1@example.com>"
+	reply.InReplyTo = first.MessageID
+	mq, err := svc.SendEmailAsync(ctx, reply)
+	if err != nil {
+		t.Fatalf("svc.SendEmailAsync failed: %+v", err)
+	}
+	assert.Equal(t, "reply-1@example.com", mq.MessageID)
+	assert.Equal(t, first.MessageID, mq.InReplyTo)
+	assert.Equal(t, []string{first.MessageID}, mq.References)
+	if _, err := svc.ProcessMailQueue(ctx); err != nil {
+		t.Fatalf("svc.ProcessMailQueue failed: %+v", err)
+	}
+
+	reply.MessageID = ""
+	reply.InReplyTo = "reply-1@example.com"
+	reply.References = []string{first.MessageID, "reply-1@example.com"}
+	if err := svc.SendEmail(ctx, reply); err != nil {
+		t.Fatalf("svc.SendEmail failed: %+v", err)
+	}
+
+	msgs := srv.Messages()
+	if assert.Len(t, msgs, 4) {
+		assert.Regexp(t, `Message-Id: <[0-9a-f]{32}@example\.com>\r\n`, msgs[0].Data)
+		assert.NotContains(t, msgs[0].Data, "In-Reply-To")
+		assert.Contains(t, msgs[1].Data, "Message-Id: <"+first.MessageID+">\r\n")
+
+		assert.Contains(t, msgs[2].Data, "Message-Id: <reply-1@example.com>\r\n")
+		assert.Contains(t, msgs[2].Data, "In-Reply-To: <"+first.MessageID+">\r\n")
+		assert.Contains(t, msgs[2].Data, "References: <"+first.MessageID+">\r\n")
+
+		assert.Contains(t, msgs[3].Data, "In-Reply-To: <reply-1@example.com>\r\n")
+		data := strings.ReplaceAll(msgs[3].Data, "\r\n ", " ")
+		assert.Contains(t, data, "References: <"+first.MessageID+"> <reply-1@example.com>\r\n")
+	}
+
+	for _, id := range []string{"no-at-sign", "a@b@c", "has space@example.com", "@example.com"} {
+		p := params
+		p.InReplyTo = id
+		_, err := svc.SendEmailAsync(ctx, p)
+		assertServiceErrorCode(t, err, entity.ErrInvalidMessageIDCode)
+	}
+	p := params
+	p.Headers = map[string]string{"References": "<x@example.com>"}
+	err = svc.SendEmail(ctx, p)
+	assertServiceErrorCode(t, err, entity.ErrInvalidHeadersCode)
+}
+
+func TestProcessMailQueueLargeHTML(t *testing.T) {
+	srv := newFakeSMTPServer(t)
+	svc := newTestService(t)
+	setupQueueProject(t, svc, srv)
+
+	ctx := context.Background()
+	if _, err := svc.SetTemplate(ctx, entity.SetTemplateParams{
+		ID:        "invoice",
+		ProjectID: "p1",
+		GroupID:   "g1",
+		Text:      `{{define "layout"}}Invoice for {{.name}}{{end}}`,
+		HTML: `{{define "layout"}}<table>{{range .items}}
+<tr><td>{{.}}</td><td>Line item description that is long enough to wrap</td></tr>{{end}}
+</table>{{end}}`,
+	}); err != nil {
+		t.Fatalf("svc.SetTemplate failed: %+v", err)
+	}
+	items := make([]int, 40000)
+	for i := range items {
+		items[i] = i
+	}
+	if _, err := svc.SendEmailAsync(ctx, entity.SendEmailParams{
+		TemplateID:     "invoice",
+		ProjectID:      "p1",
+		TransportID:    "tr1",
+		To:             []string{"to@example.com"},
+		Subject:        "Invoice",
+		TemplateParams: map[string]any{"name": "Andy", "items": items},
+	}); err != nil {
+		t.Fatalf("svc.SendEmailAsync failed: %+v", err)
+	}
+	n, err := svc.ProcessMailQueue(ctx)
+	if err != nil {
+		t.Fatalf("svc.ProcessMailQueue failed: %+v", err)
+	}
+	assert.Equal(t, 1, n)
+
+	msgs := srv.Messages()
+	if !assert.Len(t, msgs, 1) {
+		return
+	}
+	msg, err := mail.ReadMessage(strings.NewReader(msgs[0].Data))
+	if err != nil {
+		t.Fatalf("mail.ReadMessage failed: %+v", err)
+	}
+	assert.Equal(t, "Invoice", msg.Header.Get("Subject"))
+	_, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil {
+		t.Fatalf("mime.ParseMediaType failed: %+v", err)
+	}
+	var html string
+	r := multipart.NewReader(msg.Body, params["boundary"])
+	for {
+		part, err := r.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("r.NextPart failed: %+v", err)
+		}
+		b, err := io.ReadAll(part)
+		if err != nil {
+			t.Fatalf("io.ReadAll failed: %+v", err)
+		}
+		if strings.HasPrefix(part.Header.Get("Content-Type"), "text/html") {
+			html = string(b)
+		}
+	}
+	assert.Equal(t, len(items), strings.Count(html, "<tr>"))
+	assert.Contains(t, html, "<tr><td>39999</td>")
+}
+
+// setupLargeHTML creates a service with project p1 and template invoice,
+// which renders a row of HTML for each of its items followed by footer.
+// Emails sent using transport file are written to w.
+func setupLargeHTML(tb testing.TB, w io.Writer, footer string) *service.Service {
+	tb.Helper()
+
+	svc, err := service.NewEmailService(
+		service.WithInMemoryStore(),
+		service.WithHexEncodedEncryptionKey(testEncryptionKey),
+		service.WithFileTransportWriter(w),
+	)
+	if err != nil {
+		tb.Fatalf("service.NewEmailService failed: %+v", err)
+	}
+	tb.Cleanup(func() { svc.Close() })
+
+	ctx := context.Background()
+	if _, err := svc.CreateProject(ctx, "p1", "Project One", ""); err != nil {
+		tb.Fatalf("svc.CreateProject failed: %+v", err)
+	}
+	if _, err := svc.CreateFileTransport(ctx, entity.CreateFileTransport{
+		ID:        "file",
+		ProjectID: "p1",
+		Name:      "File",
+		EmailFrom: "from@example.com",
+	}); err != nil {
+		tb.Fatalf("svc.CreateFileTransport failed: %+v", err)
+	}
+	if _, err := svc.CreateGroup(ctx, "g1", "p1", "Group One"); err != nil {
+		tb.Fatalf("svc.CreateGroup failed: %+v", err)
+	}
+	if _, err := svc.SetAsset(ctx, entity.SetAssetParams{
+		ID:        "logo",
+		ProjectID: "p1",
+		Filename:  "logo.png",
+		Content:   []byte("\x89PNG\r\n\x1a\n logo"),
+	}); err != nil {
+		tb.Fatalf("svc.SetAsset failed: %+v", err)
+	}
+	if _, err := svc.SetTemplate(ctx, entity.SetTemplateParams{
+		ID:        "invoice",
+		ProjectID: "p1",
+		GroupID:   "g1",
+		Text:      `{{define "layout"}}Invoice for {{.name}}{{end}}`,
+		HTML: `{{define "layout"}}<table>{{range .items}}
+<tr><td>{{.}}</td><td>Line item description that is long enough to wrap</td></tr>{{end}}
+</table>` + footer + `{{end}}`,
+	}); err != nil {
+		tb.Fatalf("svc.SetTemplate failed: %+v", err)
+	}
+	return svc
+}
+
+// largeHTMLParams returns the params of an invoice with n items.
+func largeHTMLParams(n int) entity.SendEmailParams {
+	items := make([]int, n)
+	for i := range items {
+		items[i] = i
+	}
+	return entity.SendEmailParams{
+		TemplateID:     "invoice",
+		ProjectID:      "p1",
+		TransportID:    "file",
+		To:             []string{"to@example.com"},
+		Subject:        "Invoice",
+		TemplateParams: map[string]any{"name": "Andy", "items": items},
+	}
+}
+
+func TestSendEmailLargeHTML(t *testing.T) {
+	const items = 40000
+	tests := []struct {
+		name   string
+		footer string
+		want   string
+		inline int
+	}{
+		// rendered straight into the message
+		{name: "streamed"},
+		// rendered in full so that the asset is known before the message
+		// is written
+		{name: "asset", footer: `<img src="{{asset "logo"}}">`, want: `<img src="cid:logo@p1">`, inline: 1},
+		{name: "cid reference", footer: `<img src="cid:logo">`, want: `<img src="cid:logo@p1">`, inline: 1},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			svc := setupLargeHTML(t, &buf, tc.footer)
+			if err := svc.SendEmail(context.Background(), largeHTMLParams(items)); err != nil {
+				t.Fatalf("svc.SendEmail failed: %+v", err)
+			}
+
+			msg, err := mail.ReadMessage(&buf)
+			if err != nil {
+				t.Fatalf("mail.ReadMessage failed: %+v", err)
+			}
+			var html string
+			var inline int
+			for _, part := range mimeParts(t, msg.Header.Get("Content-Type"), msg.Body) {
+				switch {
+				case strings.HasPrefix(part.contentType, "text/html"):
+					html = part.body
+				case part.parent == "multipart/related":
+					inline++
+				}
+			}
+			assert.Equal(t, items, strings.Count(html, "<tr>"))
+			assert.Contains(t, html, "<tr><td>39999</td>")
+			assert.True(t, strings.HasSuffix(html, "</table>"+tc.want))
+			assert.Equal(t, tc.inline, inline)
+		})
+	}
+}
+
+func TestSendEmailLargeHTMLRenderError(t *testing.T) {
+	srv := newFakeSMTPServer(t)
+	svc := newTestService(t)
+	setupQueueProject(t, svc, srv)
+
+	// the template fails once most of the body has been written to the
+	// server
+	ctx := context.Background()
+	if _, err := svc.SetTemplate(ctx, entity.SetTemplateParams{
+		ID:        "invoice",
+		ProjectID: "p1",
+		GroupID:   "g1",
+		Text:      `{{define "layout"}}Invoice{{end}}`,
+		HTML:      `{{define "layout"}}<table>{{range .items}}<tr><td>{{.}}</td></tr>{{end}}</table>{{index .items 40000}}{{end}}`,
+	}); err != nil {
+		t.Fatalf("svc.SetTemplate failed: %+v", err)
+	}
+	params := largeHTMLParams(40000)
+	params.TransportID = "tr1"
+	err := svc.SendEmail(ctx, params)
+	assert.ErrorContains(t, err, "index out of range")
+
+	// the message is never completed
+	assert.Empty(t, srv.Messages())
+
+	// later emails are unaffected
+	params.TemplateID = "t1"
+	if err := svc.SendEmail(ctx, params); err != nil {
+		t.Fatalf("svc.SendEmail failed: %+v", err)
+	}
+	assert.Len(t, srv.Messages(), 1)
+}
+
+func TestSendEmailLargeHTMLAllocations(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping allocation test in short mode")
+	}
+	allocs := func(footer string) int64 {
+		svc := setupLargeHTML(t, io.Discard, footer)
+		params := largeHTMLParams(40000)
+		res := testing.Benchmark(func(b *testing.B) {
+			b.ReportAllocs()
+			for range b.N {
+				if err := svc.SendEmail(context.Background(), params); err != nil {
+					b.Fatalf("svc.SendEmail failed: %+v", err)
+				}
+			}
+		})
+		return res.AllocedBytesPerOp()
+	}
+
+	streamed, kept := allocs(""), allocs(`<img src="{{asset "logo"}}">`)
+	t.Logf("allocated %d bytes streamed, %d bytes kept", streamed, kept)
+	assert.Less(t, streamed, kept/2)
+}
+
+func BenchmarkSendEmailLargeHTML(b *testing.B) {
+	for _, bc := range []struct {
+		name   string
+		footer string
+	}{
+		{name: "streamed"},
+		{name: "asset", footer: `<img src="{{asset "logo"}}">`},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			svc := setupLargeHTML(b, io.Discard, bc.footer)
+			params := largeHTMLParams(40000)
+			b.ReportAllocs()
+			for range b.N {
+				if err := svc.SendEmail(context.Background(), params); err != nil {
+					b.Fatalf("svc.SendEmail failed: %+v", err)
+				}
+			}
+		})
+	}
+}
+
+// failingSentStore fails to record the first email delivered as sent
+// the given number of times.
+type failingSentStore struct {
+	store.Repository
+	failures int
+
+	mu     sync.Mutex
+	failed string
+}
+
+func (s *failingSentStore) UpdateMailQueueState(ctx context.Context, params store.UpdateMailQueueState) (*store.MailQueue, error) {
+	if params.MState == store.MailQueueStateSent {
+		s.mu.Lock()
+		if s.failed == "" {
+			s.failed = params.MailQueueID
+		}
+		fail := s.failed == params.MailQueueID && s.failures > 0
+		if fail {
+			s.failures--
+		}
+		s.mu.Unlock()
+		if fail {
+			return nil, errors.New("disk I/O error")
+		}
+	}
+	return s.Repository.UpdateMailQueueState(ctx, params)
+}
+
+func TestProcessMailQueueStoreErrorMidBatch(t *testing.T) {
+	tests := []struct {
+		name string
+		opts []service.Option
+	}{
+		{"sequential", nil},
+		{"concurrent", []service.Option{service.WithTransportConcurrency(1)}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			srv := newFakeSMTPServer(t)
+			st := &failingSentStore{Repository: memory.New(), failures: 100}
+			opts := append(tc.opts, service.WithStore(st))
+			svc := newTestService(t, opts...)
+			setupQueueProject(t, svc, srv)
+			ctx := context.Background()
+
+			for i := 0; i < 4; i++ {
+				queueTestEmail(t, svc)
+			}
+			n, err := svc.ProcessMailQueue(ctx)
+			assert.ErrorContains(t, err, "disk I/O error")
+			assert.Equal(t, 1, n)
+			assert.Len(t, srv.Messages(), 1)
+
+			// the emails not yet started are returned to the queue
+			list, err := svc.ListMailQueue(ctx, entity.ListMailQueueParams{ProjectID: "p1"})
+			if err != nil {
+				t.Fatalf("svc.ListMailQueue failed: %+v", err)
+			}
+			var queued int
+			for _, mq := range list {
+				if mq.State == entity.MailStateQueued {
+					queued++
+					assert.Equal(t, 0, mq.Attempts)
+					assert.Contains(t, mq.DeferralReason, "delivery abandoned")
+				}
+			}
+			assert.Equal(t, 3, queued)
+
+			// the delivered email could not be recorded so it stays
+			// sending until its claim lease expires
+			delivered, err := svc.GetMailQueue(ctx, "p1", st.failed)
+			if err != nil {
+				t.Fatalf("svc.GetMailQueue failed: %+v", err)
+			}
+			assert.Equal(t, entity.MailStateSending, delivered.State)
+		})
+	}
+}
+
+func TestProcessMailQueueRetriesMarkSent(t *testing.T) {
+	srv := newFakeSMTPServer(t)
+	st := &failingSentStore{Repository: memory.New(), failures: 2}
+	svc := newTestService(t, service.WithStore(st))
+	setupQueueProject(t, svc, srv)
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		queueTestEmail(t, svc)
+	}
+	n, err := svc.ProcessMailQueue(ctx)
+	if err != nil {
+		t.Fatalf("svc.ProcessMailQueue failed: %+v", err)
+	}
+	assert.Equal(t, 2, n)
+	assert.Len(t, srv.Messages(), 2)
+
+	delivered, err := svc.GetMailQueue(ctx, "p1", st.failed)
+	if err != nil {
+		t.Fatalf("svc.GetMailQueue failed: %+v", err)
+	}
+	assert.Equal(t, entity.MailStateSent, delivered.State)
+	assert.Equal(t, 1, delivered.Attempts)
+}
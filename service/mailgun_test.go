@@ -0,0 +1,202 @@
+package service_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/andyfusniak/squishy-mailer-lite/entity"
+	"github.com/andyfusniak/squishy-mailer-lite/service"
+	"github.com/stretchr/testify/assert"
+)
+
+// parseMultipartForm returns the fields and file parts of a multipart
+// request body keyed by form field name.
+func parseMultipartForm(t *testing.T, req fakeAPIRequest) (map[string][]string, map[string][]string) {
+	t.Helper()
+
+	_, params, err := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	if err != nil {
+		t.Fatalf("mime.ParseMediaType failed: %+v", err)
+	}
+	fields := make(map[string][]string)
+	files := make(map[string][]string)
+	r := multipart.NewReader(bytes.NewReader(req.Body), params["boundary"])
+	for {
+		part, err := r.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("r.NextPart failed: %+v", err)
+		}
+		b, _ := io.ReadAll(part)
+		if part.FileName() != "" {
+			files[part.FormName()] = append(files[part.FormName()], part.FileName())
+			continue
+		}
+		fields[part.FormName()] = append(fields[part.FormName()], string(b))
+	}
+	return fields, files
+}
+
+func TestMailgunTransport(t *testing.T) {
+	srv := newFakeSMTPServer(t)
+	api := newFakeAPIServer(t)
+	svc := newTestService(t)
+	setupQueueProject(t, svc, srv)
+
+	ctx := context.Background()
+	tr, err := svc.CreateMailgunTransport(ctx, entity.CreateMailgunTransport{
+		ID:            "mg",
+		ProjectID:     "p1",
+		Name:          "Mailgun",
+		Domain:        "mg.example.com",
+		APIKey:        "key-123",
+		BaseURL:       api.URL,
+		EmailFrom:     "noreply@example.com",
+		EmailFromName: "Example",
+		EmailReplyTo:  []string{"support@example.com"},
+	})
+	if err != nil {
+		t.Fatalf("svc.CreateMailgunTransport failed: %+v", err)
+	}
+	assert.Equal(t, entity.APITransportProviderMailgun, tr.Provider)
+	assert.Equal(t, "mg.example.com", tr.Config["domain"])
+
+	got, err := svc.GetAPITransport(ctx, "mg", "p1")
+	if err != nil {
+		t.Fatalf("svc.GetAPITransport failed: %+v", err)
+	}
+	assert.Equal(t, tr, got)
+
+	if _, err := svc.SendEmailAsync(ctx, entity.SendEmailParams{
+		TemplateID:     "t1",
+		ProjectID:      "p1",
+		TransportID:    "mg",
+		To:             []string{"to@example.com"},
+		Cc:             []string{"cc@example.com"},
+		Subject:        "Welcome",
+		TemplateParams: map[string]string{"name": "Andy"},
+		Attachments: []entity.EmailAttachment{
+			{Filename: "notes.txt", Content: []byte("some notes")},
+		},
+	}); err != nil {
+		t.Fatalf("svc.SendEmailAsync failed: %+v", err)
+	}
+	n, err := svc.ProcessMailQueue(ctx)
+	if err != nil {
+		t.Fatalf("svc.ProcessMailQueue failed: %+v", err)
+	}
+	assert.Equal(t, 1, n)
+	assert.Empty(t, srv.Messages())
+
+	reqs := api.Requests()
+	if assert.Len(t, reqs, 1) {
+		assert.Equal(t, http.MethodPost, reqs[0].Method)
+		assert.Equal(t, "/v3/mg.example.com/messages", reqs[0].Path)
+
+		r := &http.Request{Header: reqs[0].Header}
+		user, pass, ok := r.BasicAuth()
+		assert.True(t, ok)
+		assert.Equal(t, "api", user)
+		assert.Equal(t, "key-123", pass)
+
+		fields, files := parseMultipartForm(t, reqs[0])
+		assert.Equal(t, []string{"Example <noreply@example.com>"}, fields["from"])
+		assert.Equal(t, []string{"to@example.com"}, fields["to"])
+		assert.Equal(t, []string{"cc@example.com"}, fields["cc"])
+		assert.Equal(t, []string{"Welcome"}, fields["subject"])
+		assert.Equal(t, []string{"support@example.com"}, fields["h:Reply-To"])
+		if assert.Len(t, fields["text"], 1) {
+			assert.Contains(t, fields["text"][0], "Hello Andy")
+		}
+		assert.Equal(t, []string{"notes.txt"}, files["attachment"])
+	}
+
+	// transport ids are unique across SMTP and API transports
+	_, err = svc.CreateSMTPTransport(ctx, entity.CreateSMTPTransport{
+		ID:        "mg",
+		ProjectID: "p1",
+	})
+	assertServiceErrorCode(t, err, entity.ErrTransportIDInUseCode)
+	_, err = svc.CreateMailgunTransport(ctx, entity.CreateMailgunTransport{
+		ID:        "tr1",
+		ProjectID: "p1",
+	})
+	assertServiceErrorCode(t, err, entity.ErrTransportIDInUseCode)
+
+	_, err = svc.GetAPITransport(ctx, "tr1", "p1")
+	assertServiceErrorCode(t, err, entity.ErrTransportNotFoundCode)
+}
+
+func TestMailgunClientErrorMovesToDeadLetter(t *testing.T) {
+	srv := newFakeSMTPServer(t)
+	api := newFakeAPIServer(t)
+	svc := newTestService(t, service.WithRetryPolicy(service.RetryPolicy{
+		MaxAttempts:    3,
+		InitialBackoff: time.Hour,
+	}))
+	setupQueueProject(t, svc, srv)
+
+	ctx := context.Background()
+	if _, err := svc.CreateMailgunTransport(ctx, entity.CreateMailgunTransport{
+		ID:        "mg",
+		ProjectID: "p1",
+		Domain:    "mg.example.com",
+		APIKey:    "key-123",
+		BaseURL:   api.URL,
+		EmailFrom: "noreply@example.com",
+	}); err != nil {
+		t.Fatalf("svc.CreateMailgunTransport failed: %+v", err)
+	}
+	if _, err := svc.SetDefaultTransport(ctx, "p1", "mg"); err != nil {
+		t.Fatalf("svc.SetDefaultTransport failed: %+v", err)
+	}
+
+	// rate limiting is retried but a bad request is not
+	api.SetStatus(http.StatusTooManyRequests)
+	queued, err := svc.SendEmailAsync(ctx, entity.SendEmailParams{
+		TemplateID: "t1",
+		ProjectID:  "p1",
+		To:         []string{"to@example.com"},
+		Subject:    "Welcome",
+	})
+	if err != nil {
+		t.Fatalf("svc.SendEmailAsync failed: %+v", err)
+	}
+	if _, err := svc.ProcessMailQueue(ctx); err != nil {
+		t.Fatalf("svc.ProcessMailQueue failed: %+v", err)
+	}
+	mq, err := svc.GetMailQueue(ctx, "p1", queued.ID)
+	if err != nil {
+		t.Fatalf("svc.GetMailQueue failed: %+v", err)
+	}
+	assert.Equal(t, entity.MailStateQueued, mq.State)
+	assert.Contains(t, mq.LastError, "429")
+
+	api.SetStatus(http.StatusBadRequest)
+	queued, err = svc.SendEmailAsync(ctx, entity.SendEmailParams{
+		TemplateID: "t1",
+		ProjectID:  "p1",
+		To:         []string{"to@example.com"},
+		Subject:    "Welcome",
+	})
+	if err != nil {
+		t.Fatalf("svc.SendEmailAsync failed: %+v", err)
+	}
+	if _, err := svc.ProcessMailQueue(ctx); err != nil {
+		t.Fatalf("svc.ProcessMailQueue failed: %+v", err)
+	}
+	mq, err = svc.GetMailQueue(ctx, "p1", queued.ID)
+	if err != nil {
+		t.Fatalf("svc.GetMailQueue failed: %+v", err)
+	}
+	assert.Equal(t, entity.MailStateDeadLetter, mq.State)
+	assert.Equal(t, 1, mq.Attempts)
+}
@@ -0,0 +1,110 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// MaintenancePolicy controls the database upkeep done by RunMaintenance.
+// A zero interval disables the task.
+type MaintenancePolicy struct {
+	// CheckpointInterval is how often the SQLite3 write-ahead log is
+	// copied into the database file and truncated, which stops the log
+	// growing while the worker is busy.
+	CheckpointInterval time.Duration
+
+	// VacuumInterval is how often the database file is rebuilt to reclaim
+	// the space left by deleted records. Writes wait while the database
+	// is vacuumed so the interval should be long, such as a day.
+	VacuumInterval time.Duration
+
+	// MailRetention is how long emails are kept after their delivery
+	// finishes. Older emails are pruned once an hour, see PruneMailQueue.
+	MailRetention time.Duration
+}
+
+// mailPruneInterval is how often RunMaintenance prunes the mail queue
+// when the maintenance policy has a MailRetention.
+const mailPruneInterval = time.Hour
+
+// WithMaintenancePolicy accepts a MaintenancePolicy that controls how
+// often RunMaintenance checkpoints and vacuums the database. By default
+// it does neither.
+func WithMaintenancePolicy(policy MaintenancePolicy) Option {
+	return func(s *Service) {
+		s.maintenance = policy
+	}
+}
+
+// Backup writes a consistent copy of the SQLite3 database to destPath
+// using the online backup API, replacing any file already there. It is
+// safe to call while emails are being queued and delivered. If the
+// service uses a store that is not backed by a database file, such as the
+// in-memory store, an error is returned with a code of
+// ErrBackupNotSupportedCode.
+func (s *Service) Backup(ctx context.Context, destPath string) error {
+	start := time.Now()
+	if err := s.store.Backup(ctx, destPath); err != nil {
+		if serr := serviceErrorFromStore(err); serr != nil {
+			return serr
+		}
+		return errors.Wrapf(err, "[service] store.Backup failed")
+	}
+	s.logger.Info("backed up database", "path", destPath, "duration", time.Since(start))
+	return nil
+}
+
+// RunMaintenance checkpoints and vacuums the database and prunes the mail
+// queue when the intervals of the maintenance policy have passed since
+// they were last done. The
+// intervals are counted from the first call, so a long running worker
+// such as sqm serve can call it every time it polls the mail queue.
+func (s *Service) RunMaintenance(ctx context.Context) error {
+	ctx, done, err := s.startWork(ctx)
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	s.maintenanceMu.Lock()
+	defer s.maintenanceMu.Unlock()
+
+	now := time.Now()
+	if s.maintenance.MailRetention > 0 && due(&s.lastPrune, mailPruneInterval, now) {
+		if _, err := s.PruneMailQueue(ctx, now.Add(-s.maintenance.MailRetention)); err != nil {
+			return err
+		}
+	}
+	if due(&s.lastCheckpoint, s.maintenance.CheckpointInterval, now) {
+		if err := s.store.Checkpoint(ctx); err != nil {
+			return errors.Wrapf(err, "[service] store.Checkpoint failed")
+		}
+		s.logger.Debug("checkpointed database")
+	}
+	if due(&s.lastVacuum, s.maintenance.VacuumInterval, now) {
+		if err := s.store.Vacuum(ctx); err != nil {
+			return errors.Wrapf(err, "[service] store.Vacuum failed")
+		}
+		s.logger.Info("vacuumed database", "duration", time.Since(now))
+	}
+	return nil
+}
+
+// due reports whether interval has passed since *last, setting *last to
+// now if it has. A zero *last starts the interval at now.
+func due(last *time.Time, interval time.Duration, now time.Time) bool {
+	if interval <= 0 {
+		return false
+	}
+	if last.IsZero() {
+		*last = now
+		return false
+	}
+	if now.Sub(*last) < interval {
+		return false
+	}
+	*last = now
+	return true
+}
@@ -0,0 +1,82 @@
+package service_test
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/andyfusniak/squishy-mailer-lite/entity"
+	"github.com/andyfusniak/squishy-mailer-lite/service"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBackup(t *testing.T) {
+	srv := newFakeSMTPServer(t)
+	svc := newTestService(t)
+	setupQueueProject(t, svc, srv)
+	queued := queueTestEmail(t, svc)
+
+	ctx := context.Background()
+	dest := filepath.Join(t.TempDir(), "backup.db")
+	if err := svc.Backup(ctx, dest); err != nil {
+		t.Fatalf("svc.Backup failed: %+v", err)
+	}
+
+	// a later backup replaces the earlier one
+	second := queueTestEmail(t, svc)
+	if err := svc.Backup(ctx, dest); err != nil {
+		t.Fatalf("svc.Backup failed: %+v", err)
+	}
+	assert.NoFileExists(t, dest+".tmp")
+	assert.NoFileExists(t, dest+".tmp-wal")
+
+	restored := newTestService(t, service.WithSqlite3DBFilepath(dest))
+	for _, id := range []string{queued.ID, second.ID} {
+		mq, err := restored.GetMailQueue(ctx, "p1", id)
+		if err != nil {
+			t.Fatalf("restored.GetMailQueue failed: %+v", err)
+		}
+		assert.Equal(t, entity.MailStateQueued, mq.State)
+	}
+	n, err := restored.ProcessMailQueue(ctx)
+	if err != nil {
+		t.Fatalf("restored.ProcessMailQueue failed: %+v", err)
+	}
+	assert.Equal(t, 2, n)
+
+	mem := newTestService(t, service.WithInMemoryStore())
+	err = mem.Backup(ctx, dest)
+	assertServiceErrorCode(t, err, entity.ErrBackupNotSupportedCode)
+}
+
+func TestRunMaintenance(t *testing.T) {
+	stores := []struct {
+		name string
+		opts []service.Option
+	}{
+		{"sqlite3", nil},
+		{"memory", []service.Option{service.WithInMemoryStore()}},
+	}
+	for _, tc := range stores {
+		t.Run(tc.name, func(t *testing.T) {
+			svc := newTestService(t, append(tc.opts, service.WithMaintenancePolicy(service.MaintenancePolicy{
+				CheckpointInterval: time.Nanosecond,
+				VacuumInterval:     time.Nanosecond,
+			}))...)
+			setupQueueProject(t, svc, newFakeSMTPServer(t))
+			ctx := context.Background()
+			if err := svc.DeleteProject(ctx, "p1"); err != nil {
+				t.Fatalf("svc.DeleteProject failed: %+v", err)
+			}
+
+			// the first call starts the intervals and the second is due
+			for range 2 {
+				if err := svc.RunMaintenance(ctx); err != nil {
+					t.Fatalf("svc.RunMaintenance failed: %+v", err)
+				}
+				time.Sleep(time.Millisecond)
+			}
+		})
+	}
+}
@@ -0,0 +1,44 @@
+package service
+
+import (
+	"github.com/andyfusniak/squishy-mailer-lite/internal/markdown"
+)
+
+// defaultMarkdownLayout is the HTML document that the HTML rendered from a
+// Markdown template is placed in.
+const defaultMarkdownLayout = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+</head>
+<body style="margin:0;padding:0;">
+<div style="max-width:600px;margin:0 auto;padding:16px;font-family:sans-serif;line-height:1.5;">
+{{template "content" .}}
+</div>
+</body>
+</html>
+`
+
+// WithMarkdownLayout accepts the HTML layout that the HTML rendered from
+// Markdown templates is placed in. The layout is an HTML template that
+// includes the rendered Markdown using {{template "content" .}} and may
+// reference the template params and the group's partials. Templates
+// already stored are not affected until they are next set.
+func WithMarkdownLayout(layout string) Option {
+	return func(s *Service) {
+		s.markdownLayout = layout
+	}
+}
+
+// renderMarkdown returns the text and HTML bodies of a Markdown template.
+// The HTML is the layout with the rendered Markdown defined as its
+// "content" template.
+func (s *Service) renderMarkdown(source string) (text, html string) {
+	layout := s.markdownLayout
+	if layout == "" {
+		layout = defaultMarkdownLayout
+	}
+	html, text = markdown.Render(source)
+	return text, layout + `{{define "content"}}` + html + `{{end}}`
+}
@@ -0,0 +1,62 @@
+package service_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/andyfusniak/squishy-mailer-lite/entity"
+	"github.com/andyfusniak/squishy-mailer-lite/service"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMarkdownTemplates(t *testing.T) {
+	srv := newFakeSMTPServer(t)
+	svc := newTestService(t, service.WithMarkdownLayout(
+		`<html><body>{{template "content" .}}</body></html>`))
+	setupQueueProject(t, svc, srv)
+
+	ctx := context.Background()
+	tmpl, err := svc.CreateTemplate(ctx, entity.CreateTemplate{
+		ID:         "welcome",
+		ProjectID:  "p1",
+		GroupID:    "g1",
+		SourceType: entity.TemplateSourceMarkdown,
+		Source:     "# Welcome {{.name}}\n\n[Verify your email]({{.url}})",
+	})
+	if err != nil {
+		t.Fatalf("svc.CreateTemplate failed: %+v", err)
+	}
+	assert.Equal(t, entity.TemplateSourceMarkdown, tmpl.SourceType)
+	assert.Equal(t, "Welcome {{.name}}\n\nVerify your email ({{.url}})", tmpl.Text)
+	assert.NotEmpty(t, tmpl.TextDigest)
+	assert.NotEmpty(t, tmpl.HTMLDigest)
+
+	mq, err := svc.SendEmailAsync(ctx, entity.SendEmailParams{
+		TemplateID:  "welcome",
+		ProjectID:   "p1",
+		TransportID: "tr1",
+		To:          []string{"to@example.com"},
+		Subject:     "Welcome",
+		TemplateParams: map[string]string{
+			"name": "Andy & co",
+			"url":  "https://example.com/verify?token=abc",
+		},
+	})
+	if err != nil {
+		t.Fatalf("svc.SendEmailAsync failed: %+v", err)
+	}
+	assert.Equal(t, "Welcome Andy & co\n\nVerify your email (https://example.com/verify?token=abc)", mq.Text)
+	assert.Equal(t, `<html><body><h1>Welcome Andy &amp; co</h1>
+<p><a href="https://example.com/verify?token=abc">Verify your email</a></p></body></html>`, mq.HTML)
+
+	// markdown templates take both bodies from the source
+	_, err = svc.SetTemplate(ctx, entity.SetTemplateParams{
+		ID:         "welcome",
+		ProjectID:  "p1",
+		GroupID:    "g1",
+		Text:       "Welcome",
+		SourceType: entity.TemplateSourceMarkdown,
+		Source:     "# Welcome",
+	})
+	assertServiceErrorCode(t, err, entity.ErrInvalidTemplateCode)
+}
@@ -0,0 +1,70 @@
+package service
+
+import (
+	"context"
+
+	"github.com/andyfusniak/squishy-mailer-lite/entity"
+	"github.com/pkg/errors"
+)
+
+// SchemaVersion returns the migration state of the database schema. If
+// the service uses a store without a schema, such as the in-memory store,
+// an error is returned with a code of ErrMigrationsNotSupportedCode.
+func (s *Service) SchemaVersion(ctx context.Context) (*entity.SchemaVersion, error) {
+	v, err := s.store.SchemaVersion(ctx)
+	if err != nil {
+		if serr := serviceErrorFromStore(err); serr != nil {
+			return nil, serr
+		}
+		return nil, errors.Wrapf(err, "[service] store.SchemaVersion failed")
+	}
+	return &entity.SchemaVersion{
+		Version: v.Version,
+		Dirty:   v.Dirty,
+		Latest:  v.Latest,
+	}, nil
+}
+
+// Migrate applies any outstanding migrations to bring the database schema
+// up to date. Databases created by the service start with the latest
+// schema, but existing databases are only upgraded by Migrate or MigrateTo.
+func (s *Service) Migrate(ctx context.Context) error {
+	v, err := s.SchemaVersion(ctx)
+	if err != nil {
+		return err
+	}
+	return s.MigrateTo(ctx, v.Latest)
+}
+
+// MigrateTo applies the up or down migrations that take the database
+// schema to version, where 0 removes every migration. A failed migration
+// leaves the schema dirty, after which MigrateTo returns an error with a
+// code of ErrSchemaDirtyCode until the schema has been repaired and its
+// version set with ForceSchemaVersion. If there is no migration with the
+// version an error is returned with a code of
+// ErrSchemaVersionNotFoundCode.
+func (s *Service) MigrateTo(ctx context.Context, version uint) error {
+	if err := s.store.MigrateTo(ctx, version); err != nil {
+		if serr := serviceErrorFromStore(err); serr != nil {
+			return serr
+		}
+		return errors.Wrapf(err, "[service] store.MigrateTo failed")
+	}
+	s.logger.Info("migrated database schema", "version", version)
+	return nil
+}
+
+// ForceSchemaVersion records version as the current migration of the
+// database schema and clears the dirty flag without running any
+// migrations. It is used to recover once a failed migration has been
+// repaired by hand.
+func (s *Service) ForceSchemaVersion(ctx context.Context, version uint) error {
+	if err := s.store.ForceSchemaVersion(ctx, version); err != nil {
+		if serr := serviceErrorFromStore(err); serr != nil {
+			return serr
+		}
+		return errors.Wrapf(err, "[service] store.ForceSchemaVersion failed")
+	}
+	s.logger.Warn("forced database schema version", "version", version)
+	return nil
+}
@@ -0,0 +1,97 @@
+package service_test
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/andyfusniak/squishy-mailer-lite/entity"
+	"github.com/andyfusniak/squishy-mailer-lite/internal/store/sqlite3"
+	"github.com/andyfusniak/squishy-mailer-lite/service"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMigrateTo(t *testing.T) {
+	db, err := sqlite3.OpenDB(filepath.Join(t.TempDir(), "mailer.db"))
+	if err != nil {
+		t.Fatalf("sqlite3.OpenDB failed: %+v", err)
+	}
+	db.SetMaxOpenConns(1)
+	if err := sqlite3.CreateSqliteDBSchema(db); err != nil {
+		t.Fatalf("sqlite3.CreateSqliteDBSchema failed: %+v", err)
+	}
+	svc, err := service.NewEmailService(
+		service.WithStore(sqlite3.NewStore(db, db)),
+		service.WithHexEncodedEncryptionKey(testEncryptionKey),
+	)
+	if err != nil {
+		t.Fatalf("service.NewEmailService failed: %+v", err)
+	}
+	defer svc.Close()
+
+	ctx := context.Background()
+	v, err := svc.SchemaVersion(ctx)
+	if err != nil {
+		t.Fatalf("svc.SchemaVersion failed: %+v", err)
+	}
+	assert.False(t, v.Dirty)
+	assert.Equal(t, v.Latest, v.Version)
+	latest := v.Latest
+
+	if err := svc.MigrateTo(ctx, latest-1); err != nil {
+		t.Fatalf("svc.MigrateTo failed: %+v", err)
+	}
+	v, err = svc.SchemaVersion(ctx)
+	if err != nil {
+		t.Fatalf("svc.SchemaVersion failed: %+v", err)
+	}
+	assert.Equal(t, latest-1, v.Version)
+
+	// every down migration runs, then every up migration
+	if err := svc.MigrateTo(ctx, 0); err != nil {
+		t.Fatalf("svc.MigrateTo(0) failed: %+v", err)
+	}
+	if err := svc.Migrate(ctx); err != nil {
+		t.Fatalf("svc.Migrate failed: %+v", err)
+	}
+	if err := svc.Migrate(ctx); err != nil {
+		t.Fatalf("svc.Migrate of an up to date schema failed: %+v", err)
+	}
+	if _, err := svc.CreateProject(ctx, "p1", "Project One", ""); err != nil {
+		t.Fatalf("svc.CreateProject failed: %+v", err)
+	}
+
+	err = svc.MigrateTo(ctx, latest+1)
+	assertServiceErrorCode(t, err, entity.ErrSchemaVersionNotFoundCode)
+	err = svc.ForceSchemaVersion(ctx, latest+1)
+	assertServiceErrorCode(t, err, entity.ErrSchemaVersionNotFoundCode)
+
+	// a dirty schema must be forced before migrating again
+	if _, err := db.Exec(`update schema_migrations set dirty = 1`); err != nil {
+		t.Fatalf("db.Exec failed: %+v", err)
+	}
+	v, err = svc.SchemaVersion(ctx)
+	if err != nil {
+		t.Fatalf("svc.SchemaVersion failed: %+v", err)
+	}
+	assert.True(t, v.Dirty)
+	err = svc.MigrateTo(ctx, latest-1)
+	assertServiceErrorCode(t, err, entity.ErrSchemaDirtyCode)
+
+	if err := svc.ForceSchemaVersion(ctx, latest); err != nil {
+		t.Fatalf("svc.ForceSchemaVersion failed: %+v", err)
+	}
+	v, err = svc.SchemaVersion(ctx)
+	if err != nil {
+		t.Fatalf("svc.SchemaVersion failed: %+v", err)
+	}
+	assert.Equal(t, &entity.SchemaVersion{Version: latest, Latest: latest}, v)
+}
+
+func TestMigrateToInMemoryStore(t *testing.T) {
+	svc := newTestService(t, service.WithInMemoryStore())
+	_, err := svc.SchemaVersion(context.Background())
+	assertServiceErrorCode(t, err, entity.ErrMigrationsNotSupportedCode)
+	err = svc.MigrateTo(context.Background(), 1)
+	assertServiceErrorCode(t, err, entity.ErrMigrationsNotSupportedCode)
+}
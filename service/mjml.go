@@ -0,0 +1,57 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"os/exec"
+	"strings"
+
+	"github.com/andyfusniak/squishy-mailer-lite/entity"
+	"github.com/pkg/errors"
+)
+
+// MJMLCompiler compiles an MJML document to responsive HTML. Template
+// actions such as {{.name}} are passed through to the HTML untouched.
+type MJMLCompiler func(ctx context.Context, mjml string) (string, error)
+
+// WithMJMLCompiler accepts the compiler used to compile templates whose
+// source type is entity.TemplateSourceMJML. Without one, creating an MJML
+// template fails with ErrInvalidTemplateCode. MJMLCommand returns a
+// compiler that runs the mjml command line tool.
+func WithMJMLCompiler(compiler MJMLCompiler) Option {
+	return func(s *Service) {
+		s.mjmlCompiler = compiler
+	}
+}
+
+// MJMLCommand returns an MJMLCompiler that runs an external command, such
+// as MJMLCommand("mjml", "-i", "-s"), writing the MJML to its standard
+// input and reading the HTML from its standard output.
+func MJMLCommand(name string, args ...string) MJMLCompiler {
+	return func(ctx context.Context, mjml string) (string, error) {
+		var stdout, stderr bytes.Buffer
+		cmd := exec.CommandContext(ctx, name, args...)
+		cmd.Stdin = strings.NewReader(mjml)
+		cmd.Stdout = &stdout
+		cmd.Stderr = &stderr
+		if err := cmd.Run(); err != nil {
+			return "", errors.Wrapf(err, "[service] %s failed: %s",
+				name, strings.TrimSpace(stderr.String()))
+		}
+		return stdout.String(), nil
+	}
+}
+
+// compileMJML compiles MJML to HTML using the service's compiler.
+func (s *Service) compileMJML(ctx context.Context, mjml string) (string, error) {
+	if s.mjmlCompiler == nil {
+		return "", entity.NewServiceError(entity.ErrInvalidTemplateCode,
+			errors.New("no mjml compiler is configured"))
+	}
+	html, err := s.mjmlCompiler(ctx, mjml)
+	if err != nil {
+		return "", entity.NewServiceError(entity.ErrInvalidTemplateCode,
+			errors.Wrapf(err, "[service] mjml compile failed"))
+	}
+	return html, nil
+}
@@ -0,0 +1,139 @@
+package service_test
+
+import (
+	"context"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/andyfusniak/squishy-mailer-lite/entity"
+	"github.com/andyfusniak/squishy-mailer-lite/service"
+	"github.com/stretchr/testify/assert"
+)
+
+const testMJML = `<mjml><mj-body><mj-text>Hello {{.name}}</mj-text></mj-body></mjml>`
+
+// fakeMJMLCompiler compiles mj-text elements to paragraphs and counts the
+// number of times it is called.
+func fakeMJMLCompiler(calls *int) service.MJMLCompiler {
+	r := strings.NewReplacer(
+		"<mjml><mj-body>", "<html><body>",
+		"</mj-body></mjml>", "</body></html>",
+		"<mj-text>", "<p>",
+		"</mj-text>", "</p>",
+	)
+	return func(_ context.Context, mjml string) (string, error) {
+		*calls++
+		return r.Replace(mjml), nil
+	}
+}
+
+func TestMJMLTemplates(t *testing.T) {
+	stores := []struct {
+		name string
+		opts []service.Option
+	}{
+		{"sqlite3", nil},
+		{"memory", []service.Option{service.WithInMemoryStore()}},
+	}
+	for _, st := range stores {
+		t.Run(st.name, func(t *testing.T) {
+			var calls int
+			srv := newFakeSMTPServer(t)
+			svc := newTestService(t, append(st.opts, service.WithMJMLCompiler(fakeMJMLCompiler(&calls)))...)
+			setupQueueProject(t, svc, srv)
+
+			ctx := context.Background()
+			params := entity.SetTemplateParams{
+				ID:         "welcome",
+				ProjectID:  "p1",
+				GroupID:    "g1",
+				Text:       "Hello {{.name}}",
+				SourceType: entity.TemplateSourceMJML,
+				Source:     testMJML,
+			}
+			tmpl, err := svc.SetTemplate(ctx, params)
+			if err != nil {
+				t.Fatalf("svc.SetTemplate failed: %+v", err)
+			}
+			assert.Equal(t, entity.TemplateSourceMJML, tmpl.SourceType)
+			assert.Equal(t, testMJML, tmpl.Source)
+			assert.Equal(t, "<html><body><p>Hello {{.name}}</p></body></html>", tmpl.HTML)
+			assert.NotEmpty(t, tmpl.HTMLDigest)
+
+			// the unchanged source is not compiled again
+			if _, err := svc.SetTemplate(ctx, params); err != nil {
+				t.Fatalf("svc.SetTemplate failed: %+v", err)
+			}
+			assert.Equal(t, 1, calls)
+
+			mq, err := svc.SendEmailAsync(ctx, entity.SendEmailParams{
+				TemplateID:     "welcome",
+				ProjectID:      "p1",
+				TransportID:    "tr1",
+				To:             []string{"to@example.com"},
+				Subject:        "Welcome",
+				TemplateParams: map[string]string{"name": "Andy"},
+			})
+			if err != nil {
+				t.Fatalf("svc.SendEmailAsync failed: %+v", err)
+			}
+			assert.Equal(t, "<html><body><p>Hello Andy</p></body></html>", mq.HTML)
+
+			// html templates are unaffected by the compiler
+			tmpl, err = svc.CreateTemplate(ctx, entity.CreateTemplate{
+				ID:        "plain",
+				ProjectID: "p1",
+				GroupID:   "g1",
+				Text:      "Hello",
+				HTML:      "<p>Hello</p>",
+			})
+			if err != nil {
+				t.Fatalf("svc.CreateTemplate failed: %+v", err)
+			}
+			assert.Equal(t, entity.TemplateSourceHTML, tmpl.SourceType)
+			assert.Equal(t, 1, calls)
+
+			// mjml templates take their HTML from the source
+			_, err = svc.CreateTemplate(ctx, entity.CreateTemplate{
+				ID:         "both",
+				ProjectID:  "p1",
+				GroupID:    "g1",
+				HTML:       "<p>Hello</p>",
+				SourceType: entity.TemplateSourceMJML,
+				Source:     testMJML,
+			})
+			assertServiceErrorCode(t, err, entity.ErrInvalidTemplateCode)
+		})
+	}
+}
+
+func TestMJMLTemplatesWithoutCompiler(t *testing.T) {
+	srv := newFakeSMTPServer(t)
+	svc := newTestService(t)
+	setupQueueProject(t, svc, srv)
+
+	_, err := svc.CreateTemplate(context.Background(), entity.CreateTemplate{
+		ID:         "welcome",
+		ProjectID:  "p1",
+		GroupID:    "g1",
+		SourceType: entity.TemplateSourceMJML,
+		Source:     testMJML,
+	})
+	assertServiceErrorCode(t, err, entity.ErrInvalidTemplateCode)
+}
+
+func TestMJMLCommand(t *testing.T) {
+	if _, err := exec.LookPath("cat"); err != nil {
+		t.Skip("cat is not available")
+	}
+
+	html, err := service.MJMLCommand("cat")(context.Background(), testMJML)
+	if err != nil {
+		t.Fatalf("MJMLCommand failed: %+v", err)
+	}
+	assert.Equal(t, testMJML, html)
+
+	_, err = service.MJMLCommand("false")(context.Background(), testMJML)
+	assert.Error(t, err)
+}
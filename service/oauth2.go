@@ -0,0 +1,280 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/andyfusniak/squishy-mailer-lite/entity"
+	"github.com/andyfusniak/squishy-mailer-lite/internal/email"
+	"github.com/andyfusniak/squishy-mailer-lite/internal/store"
+	"github.com/pkg/errors"
+)
+
+// SMTP OAuth2 transport config keys. The client secret and refresh token
+// are kept in the encrypted secret as an oauth2Secret.
+const (
+	oauth2ConfigHost     = "host"
+	oauth2ConfigPort     = "port"
+	oauth2ConfigUsername = "username"
+	oauth2ConfigTokenURL = "token_url"
+	oauth2ConfigClientID = "client_id"
+	oauth2ConfigScope    = "scope"
+	oauth2ConfigTLSMode  = "tls_mode"
+)
+
+// oauth2Secret is the encrypted secret of an SMTP OAuth2 transport.
+type oauth2Secret struct {
+	ClientSecret string `json:"client_secret"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+// oauth2Preset is the SMTP server, token URL and scope of an OAuth2
+// provider. The Microsoft token URL has a {tenant} placeholder.
+type oauth2Preset struct {
+	host     string
+	port     int
+	tokenURL string
+	scope    string
+}
+
+var oauth2Presets = map[entity.OAuth2Provider]oauth2Preset{
+	entity.OAuth2ProviderGoogle: {
+		host:     "smtp.gmail.com",
+		port:     587,
+		tokenURL: "https://oauth2.googleapis.com/token",
+		scope:    "https://mail.google.com/",
+	},
+	entity.OAuth2ProviderMicrosoft: {
+		host:     "smtp.office365.com",
+		port:     587,
+		tokenURL: "https://login.microsoftonline.com/{tenant}/oauth2/v2.0/token",
+		scope:    "https://outlook.office.com/SMTP.Send offline_access",
+	},
+}
+
+// CreateSMTPOAuth2Transport creates a transport that sends over SMTP and
+// authenticates using XOAUTH2 instead of a password, as required by Gmail
+// and Office 365 once app passwords are withdrawn. The client secret and
+// refresh token are encrypted before they are stored, and an access token
+// is obtained from the token URL and refreshed shortly before it expires.
+func (s *Service) CreateSMTPOAuth2Transport(ctx context.Context, params entity.CreateSMTPOAuth2Transport) (*entity.APITransport, error) {
+	if params.Provider != "" {
+		preset, ok := oauth2Presets[params.Provider]
+		if !ok {
+			return nil, entity.NewServiceError(entity.ErrInvalidTransportCode,
+				errors.Errorf("unknown oauth2 provider %q", params.Provider))
+		}
+		tenant := params.Tenant
+		if tenant == "" {
+			tenant = "common"
+		}
+		if params.Host == "" {
+			params.Host = preset.host
+		}
+		if params.Port == 0 {
+			params.Port = preset.port
+		}
+		if params.TokenURL == "" {
+			params.TokenURL = strings.ReplaceAll(preset.tokenURL, "{tenant}", url.PathEscape(tenant))
+		}
+		if params.Scope == "" {
+			params.Scope = preset.scope
+		}
+		if params.TLSMode == entity.SMTPTLSModeOpportunistic {
+			params.TLSMode = entity.SMTPTLSModeStartTLS
+		}
+	}
+	if params.Port == 0 {
+		params.Port = s.smtpDefaults.Port
+	}
+	if err := validateSMTPTLS(entity.SMTPTLSOptions{Mode: params.TLSMode}); err != nil {
+		return nil, err
+	}
+	if err := validateSMTPTimeouts(params.Timeouts); err != nil {
+		return nil, err
+	}
+	required := []struct{ name, value string }{
+		{"host", params.Host},
+		{"username", params.Username},
+		{"token url", params.TokenURL},
+		{"client id", params.ClientID},
+		{"refresh token", params.RefreshToken},
+	}
+	for _, r := range required {
+		if r.value == "" {
+			return nil, entity.NewServiceError(entity.ErrInvalidTransportCode,
+				errors.Errorf("%s is required", r.name))
+		}
+	}
+
+	secret, err := json.Marshal(oauth2Secret{
+		ClientSecret: params.ClientSecret,
+		RefreshToken: params.RefreshToken,
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "[service] json.Marshal failed")
+	}
+	cfg := store.JSONObject{
+		oauth2ConfigHost:     params.Host,
+		oauth2ConfigPort:     strconv.Itoa(params.Port),
+		oauth2ConfigUsername: params.Username,
+		oauth2ConfigTokenURL: params.TokenURL,
+		oauth2ConfigClientID: params.ClientID,
+		oauth2ConfigTLSMode:  string(params.TLSMode),
+	}
+	if params.Scope != "" {
+		cfg[oauth2ConfigScope] = params.Scope
+	}
+	if params.Timeouts.Connect > 0 {
+		cfg[smtpConfigConnectTimeout] = params.Timeouts.Connect.String()
+	}
+	if params.Timeouts.Send > 0 {
+		cfg[smtpConfigSendTimeout] = params.Timeouts.Send.String()
+	}
+	return s.createAPITransport(ctx, store.AddAPITransport{
+		APITransportID: params.ID,
+		ProjectID:      params.ProjectID,
+		TransportName:  params.Name,
+		Provider:       store.APITransportProviderSMTPOAuth2,
+		Config:         cfg,
+		EmailFrom:      params.EmailFrom,
+		EmailFromName:  params.EmailFromName,
+		EmailReplyTo:   store.JSONArray(params.EmailReplyTo),
+	}, string(secret))
+}
+
+func smtpOAuth2Sender(_ context.Context, cfg TransportConfig) (email.Sender, error) {
+	port, err := strconv.Atoi(cfg.Config[oauth2ConfigPort])
+	if err != nil {
+		return nil, errors.Wrapf(err, "[service] strconv.Atoi failed")
+	}
+	var secret oauth2Secret
+	if err := json.Unmarshal([]byte(cfg.Secret), &secret); err != nil {
+		return nil, errors.Wrapf(err, "[service] json.Unmarshal oauth2 secret failed")
+	}
+	src := oauth2TokenSource{
+		tokenURL:     cfg.Config[oauth2ConfigTokenURL],
+		clientID:     cfg.Config[oauth2ConfigClientID],
+		clientSecret: secret.ClientSecret,
+		refreshToken: secret.RefreshToken,
+		scope:        cfg.Config[oauth2ConfigScope],
+	}
+	username := cfg.Config[oauth2ConfigUsername]
+	return email.NewAWSSMTPTransport(email.AWSConfig{
+		Host:     cfg.Config[oauth2ConfigHost],
+		Port:     port,
+		Username: username,
+		From:     cfg.EmailFrom,
+		FromName: cfg.EmailFromName,
+		ReplyTo:  cfg.EmailReplyTo,
+		TLS:      email.TLSOptions{Mode: cfg.Config[oauth2ConfigTLSMode]},
+		Auth:     email.XOAuth2Auth(username, src.token),
+		Timeouts: smtpTimeoutsConfig(cfg.Config),
+	}), nil
+}
+
+// oauth2TokenSource refreshes access tokens for an SMTP OAuth2 transport.
+type oauth2TokenSource struct {
+	tokenURL     string
+	clientID     string
+	clientSecret string
+	refreshToken string
+	scope        string
+}
+
+// oauth2Token is a cached access token.
+type oauth2Token struct {
+	accessToken  string
+	refreshToken string
+	expiry       time.Time
+}
+
+// Access tokens are cached across senders, which are created for every
+// email, keyed by the token URL, client id and stored refresh token. A
+// refresh token rotated by the provider replaces the stored one in the
+// cache only, since the stored one remains valid until it expires.
+var (
+	oauth2TokensMu sync.Mutex
+	oauth2Tokens   = make(map[string]*oauth2Token)
+)
+
+// oauth2ExpiryDelta is how long before it expires an access token is
+// refreshed.
+const oauth2ExpiryDelta = time.Minute
+
+var oauth2Client = &http.Client{Timeout: 30 * time.Second}
+
+// token returns a valid access token, refreshing it if needed.
+func (src oauth2TokenSource) token() (string, error) {
+	key := src.tokenURL + "\x00" + src.clientID + "\x00" + src.refreshToken
+
+	oauth2TokensMu.Lock()
+	defer oauth2TokensMu.Unlock()
+	tok := oauth2Tokens[key]
+	if tok != nil && time.Now().Add(oauth2ExpiryDelta).Before(tok.expiry) {
+		return tok.accessToken, nil
+	}
+
+	refreshToken := src.refreshToken
+	if tok != nil && tok.refreshToken != "" {
+		refreshToken = tok.refreshToken
+	}
+	tok, err := src.refresh(refreshToken)
+	if err != nil {
+		return "", err
+	}
+	oauth2Tokens[key] = tok
+	return tok.accessToken, nil
+}
+
+// refresh exchanges the refresh token for a new access token.
+func (src oauth2TokenSource) refresh(refreshToken string) (*oauth2Token, error) {
+	form := url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {refreshToken},
+		"client_id":     {src.clientID},
+	}
+	if src.clientSecret != "" {
+		form.Set("client_secret", src.clientSecret)
+	}
+	if src.scope != "" {
+		form.Set("scope", src.scope)
+	}
+	resp, err := oauth2Client.PostForm(src.tokenURL, form)
+	if err != nil {
+		return nil, errors.Wrapf(err, "[service] oauth2 token request failed")
+	}
+	defer resp.Body.Close()
+
+	b, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, errors.Wrapf(err, "[service] read oauth2 token response failed")
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("[service] oauth2 token request failed with status %d: %s",
+			resp.StatusCode, truncate(string(b), 200))
+	}
+	var body struct {
+		AccessToken  string `json:"access_token"`
+		RefreshToken string `json:"refresh_token"`
+		ExpiresIn    int64  `json:"expires_in"`
+	}
+	if err := json.Unmarshal(b, &body); err != nil {
+		return nil, errors.Wrapf(err, "[service] decode oauth2 token response failed")
+	}
+	if body.AccessToken == "" {
+		return nil, errors.New("[service] oauth2 token response has no access token")
+	}
+	return &oauth2Token{
+		accessToken:  body.AccessToken,
+		refreshToken: body.RefreshToken,
+		expiry:       time.Now().Add(time.Duration(body.ExpiresIn) * time.Second),
+	}, nil
+}
@@ -0,0 +1,102 @@
+package service_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/andyfusniak/squishy-mailer-lite/entity"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSMTPOAuth2Transport(t *testing.T) {
+	var refreshes int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("grant_type") != "refresh_token" ||
+			r.FormValue("refresh_token") != "refresh-token" ||
+			r.FormValue("client_id") != "client-id" ||
+			r.FormValue("client_secret") != "client-secret" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		refreshes++
+		json.NewEncoder(w).Encode(map[string]any{
+			"access_token": "access-token",
+			"token_type":   "Bearer",
+			"expires_in":   3600,
+		})
+	}))
+	defer ts.Close()
+
+	srv := newFakeSMTPServer(t)
+	svc := newTestService(t)
+	setupQueueProject(t, svc, srv)
+
+	ctx := context.Background()
+	tr, err := svc.CreateSMTPOAuth2Transport(ctx, entity.CreateSMTPOAuth2Transport{
+		ID:           "oauth",
+		ProjectID:    "p1",
+		Name:         "OAuth2",
+		Host:         srv.Host(),
+		Port:         srv.Port(),
+		Username:     "user@example.com",
+		TokenURL:     ts.URL,
+		ClientID:     "client-id",
+		ClientSecret: "client-secret",
+		RefreshToken: "refresh-token",
+		EmailFrom:    "from@example.com",
+	})
+	if err != nil {
+		t.Fatalf("svc.CreateSMTPOAuth2Transport failed: %+v", err)
+	}
+	assert.Equal(t, entity.APITransportProviderSMTPOAuth2, tr.Provider)
+	assert.NotContains(t, tr.Config, "refresh_token")
+
+	// the access token is refreshed once and reused for the second email
+	for range 2 {
+		if err := svc.SendEmail(ctx, entity.SendEmailParams{
+			TemplateID:     "t1",
+			ProjectID:      "p1",
+			TransportID:    "oauth",
+			To:             []string{"to@example.com"},
+			Subject:        "Welcome",
+			TemplateParams: map[string]string{"name": "Andy"},
+		}); err != nil {
+			t.Fatalf("svc.SendEmail failed: %+v", err)
+		}
+	}
+	assert.Equal(t, 1, refreshes)
+	msgs := srv.Messages()
+	if assert.Len(t, msgs, 2) {
+		assert.Equal(t, "access-token", msgs[0].Password)
+	}
+
+	// presets fill in the server and token endpoint
+	tr, err = svc.CreateSMTPOAuth2Transport(ctx, entity.CreateSMTPOAuth2Transport{
+		ID:           "o365",
+		ProjectID:    "p1",
+		Provider:     entity.OAuth2ProviderMicrosoft,
+		Tenant:       "contoso",
+		Username:     "user@contoso.com",
+		ClientID:     "client-id",
+		RefreshToken: "refresh-token",
+		EmailFrom:    "user@contoso.com",
+	})
+	if err != nil {
+		t.Fatalf("svc.CreateSMTPOAuth2Transport failed: %+v", err)
+	}
+	assert.Equal(t, "smtp.office365.com", tr.Config["host"])
+	assert.Equal(t, "starttls", tr.Config["tls_mode"])
+	assert.Equal(t, "https://login.microsoftonline.com/contoso/oauth2/v2.0/token", tr.Config["token_url"])
+
+	_, err = svc.CreateSMTPOAuth2Transport(ctx, entity.CreateSMTPOAuth2Transport{
+		ID:        "bad",
+		ProjectID: "p1",
+		Provider:  entity.OAuth2ProviderGoogle,
+		Username:  "user@gmail.com",
+		ClientID:  "client-id",
+	})
+	assertServiceErrorCode(t, err, entity.ErrInvalidTransportCode)
+}
@@ -0,0 +1,170 @@
+package service
+
+import (
+	"encoding/json"
+	"html"
+	"maps"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"github.com/andyfusniak/squishy-mailer-lite/entity"
+	"github.com/pkg/errors"
+)
+
+// templateParamsMap returns the template params as a map. String maps are
+// copied and other values, such as structs, are converted through JSON.
+// A nil value returns a nil map.
+func templateParamsMap(params any) (map[string]any, error) {
+	switch p := params.(type) {
+	case nil:
+		return nil, nil
+	case map[string]any:
+		return p, nil
+	case map[string]string:
+		m := make(map[string]any, len(p))
+		for k, v := range p {
+			m[k] = v
+		}
+		return m, nil
+	}
+
+	v := reflect.ValueOf(params)
+	if v.Kind() == reflect.Map && v.Type().Key().Kind() == reflect.String {
+		m := make(map[string]any, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			m[iter.Key().String()] = iter.Value().Interface()
+		}
+		return m, nil
+	}
+
+	b, err := json.Marshal(params)
+	if err != nil {
+		return nil, entity.NewServiceError(entity.ErrInvalidTemplateParamsCode,
+			errors.Wrapf(err, "[service] json.Marshal failed"))
+	}
+	var m map[string]any
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, entity.NewServiceError(entity.ErrInvalidTemplateParamsCode,
+			errors.Wrapf(err, "[service] json.Unmarshal failed"))
+	}
+	return m, nil
+}
+
+// mergeTemplateParams returns the shared template params overlaid with a
+// recipient's own params.
+func mergeTemplateParams(shared, own any) (any, error) {
+	if own == nil {
+		return shared, nil
+	}
+	if shared == nil {
+		return own, nil
+	}
+
+	sm, err := templateParamsMap(shared)
+	if err != nil {
+		return nil, err
+	}
+	om, err := templateParamsMap(own)
+	if err != nil {
+		return nil, err
+	}
+	m := make(map[string]any, len(sm)+len(om))
+	maps.Copy(m, sm)
+	maps.Copy(m, om)
+	return m, nil
+}
+
+var (
+	// plainTextDropRe matches comments and the elements whose content is
+	// not text.
+	plainTextDropRe = regexp.MustCompile(`(?is)<!--.*?-->|<script\b.*?</script\s*>|<style\b.*?</style\s*>`)
+
+	// plainTextBreakRe matches the tags of elements that separate words.
+	plainTextBreakRe = regexp.MustCompile(`(?i)</?(?:br|p|div|li|ul|ol|tr|td|th|table|h[1-6]|blockquote|pre|hr)\b[^>]*>`)
+
+	plainTextTagRe = regexp.MustCompile(`</?[a-zA-Z!][^>]*>`)
+)
+
+// plainTextParams returns a copy of params in which every string, however
+// deeply nested in maps, slices and structs, is converted by plainText.
+// Structs are converted through JSON first, as by templateParamsMap,
+// unless they have their own JSON encoding.
+func plainTextParams(params map[string]any) (map[string]any, error) {
+	m := make(map[string]any, len(params))
+	for k, v := range params {
+		pv, err := plainTextValue(v)
+		if err != nil {
+			return nil, err
+		}
+		m[k] = pv
+	}
+	return m, nil
+}
+
+func plainTextValue(v any) (any, error) {
+	switch p := v.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		return plainText(p), nil
+	case map[string]any:
+		return plainTextParams(p)
+	}
+
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Map:
+		if rv.Type().Key().Kind() != reflect.String {
+			return v, nil
+		}
+		m, err := templateParamsMap(v)
+		if err != nil {
+			return nil, err
+		}
+		return plainTextParams(m)
+	case reflect.Slice, reflect.Array:
+		if rv.Type().Elem().Kind() == reflect.Uint8 {
+			return v, nil
+		}
+		list := make([]any, rv.Len())
+		for i := range list {
+			pv, err := plainTextValue(rv.Index(i).Interface())
+			if err != nil {
+				return nil, err
+			}
+			list[i] = pv
+		}
+		return list, nil
+	case reflect.Struct, reflect.Pointer:
+		// values with their own encoding, such as time.Time, are kept
+		// so that their methods can still be used
+		if _, ok := v.(json.Marshaler); ok {
+			return v, nil
+		}
+		if rv.Kind() == reflect.Pointer && (rv.IsNil() || rv.Elem().Kind() != reflect.Struct) {
+			return v, nil
+		}
+		m, err := templateParamsMap(v)
+		if err != nil {
+			return nil, err
+		}
+		return plainTextParams(m)
+	case reflect.String:
+		return plainText(rv.String()), nil
+	}
+	return v, nil
+}
+
+// plainText strips the HTML tags from s, decodes its character references
+// and collapses runs of whitespace to a single space.
+func plainText(s string) string {
+	if strings.ContainsAny(s, "<&") {
+		s = plainTextDropRe.ReplaceAllString(s, " ")
+		s = plainTextBreakRe.ReplaceAllString(s, " ")
+		s = plainTextTagRe.ReplaceAllString(s, "")
+		s = html.UnescapeString(s)
+	}
+	return strings.Join(strings.Fields(s), " ")
+}
@@ -0,0 +1,134 @@
+package service_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/andyfusniak/squishy-mailer-lite/entity"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRichTemplateParams(t *testing.T) {
+	srv := newFakeSMTPServer(t)
+	svc := newTestService(t)
+	setupQueueProject(t, svc, srv)
+
+	ctx := context.Background()
+	if _, err := svc.CreateTemplate(ctx, entity.CreateTemplate{
+		ID:        "order",
+		ProjectID: "p1",
+		GroupID:   "g1",
+		Text: `{{define "layout"}}Order for {{.customer.name}}:` +
+			`{{range .items}} {{.qty}}x{{.sku}}{{end}}` +
+			`{{if .gift}} (gift){{end}}{{end}}`,
+		HTML: `{{define "layout"}}<p>{{len .items}} items</p>{{end}}`,
+	}); err != nil {
+		t.Fatalf("svc.CreateTemplate failed: %+v", err)
+	}
+
+	params := entity.SendEmailParams{
+		TemplateID:  "order",
+		ProjectID:   "p1",
+		TransportID: "tr1",
+		To:          []string{"to@example.com"},
+		Subject:     "Your order",
+		TemplateParams: map[string]any{
+			"customer": map[string]any{"name": "Andy"},
+			"items": []map[string]any{
+				{"sku": "A1", "qty": 2},
+				{"sku": "B2", "qty": 1},
+			},
+			"gift": true,
+		},
+		StrictParams: true,
+	}
+	mq, err := svc.SendEmailAsync(ctx, params)
+	if err != nil {
+		t.Fatalf("svc.SendEmailAsync failed: %+v", err)
+	}
+	assert.Equal(t, "Order for Andy: 2xA1 1xB2 (gift)", mq.Text)
+	assert.Equal(t, "<p>2 items</p>", mq.HTML)
+
+	got, err := svc.GetMailQueue(ctx, "p1", mq.ID)
+	if err != nil {
+		t.Fatalf("svc.GetMailQueue failed: %+v", err)
+	}
+	assert.Equal(t, true, got.TemplateParams["gift"])
+	assert.Len(t, got.TemplateParams["items"], 2)
+
+	// structs are accepted and their fields are the params
+	type order struct {
+		Name  string
+		Items []string
+	}
+	if _, err := svc.CreateTemplate(ctx, entity.CreateTemplate{
+		ID:        "order-summary",
+		ProjectID: "p1",
+		GroupID:   "g1",
+		Text:      `{{define "layout"}}{{.Name}}{{range .Items}} {{.}}{{end}}{{end}}`,
+		HTML:      `{{define "layout"}}{{.Name}}{{end}}`,
+	}); err != nil {
+		t.Fatalf("svc.CreateTemplate failed: %+v", err)
+	}
+	params.TemplateID = "order-summary"
+	params.TemplateParams = order{Name: "Andy", Items: []string{"A1", "B2"}}
+	mq, err = svc.SendEmailAsync(ctx, params)
+	if err != nil {
+		t.Fatalf("svc.SendEmailAsync failed: %+v", err)
+	}
+	assert.Equal(t, "Andy A1 B2", mq.Text)
+	assert.Equal(t, "Andy", mq.TemplateParams["Name"])
+
+	params.TemplateParams = []string{"not", "a", "map"}
+	_, err = svc.SendEmailAsync(ctx, params)
+	assertServiceErrorCode(t, err, entity.ErrInvalidTemplateParamsCode)
+}
+
+func TestPlainTextParams(t *testing.T) {
+	srv := newFakeSMTPServer(t)
+	svc := newTestService(t)
+	setupQueueProject(t, svc, srv)
+
+	ctx := context.Background()
+	if _, err := svc.CreateTemplate(ctx, entity.CreateTemplate{
+		ID:        "comment",
+		ProjectID: "p1",
+		GroupID:   "g1",
+		Subject:   "New comment from {{.author}}",
+		Text: `{{define "layout"}}{{.author}} wrote: {{.comment}}` +
+			`{{range .tags}} [{{.}}]{{end}} ({{.count}}){{end}}`,
+		HTML: `{{define "layout"}}<p>{{.author}} wrote: {{.comment}}</p>{{end}}`,
+	}); err != nil {
+		t.Fatalf("svc.CreateTemplate failed: %+v", err)
+	}
+
+	params := entity.SendEmailParams{
+		TemplateID:  "comment",
+		ProjectID:   "p1",
+		TransportID: "tr1",
+		To:          []string{"to@example.com"},
+		TemplateParams: map[string]any{
+			"author": "<b>Andy</b>\n\t Smith",
+			"comment": "Looks good<br>but see <a href=\"https://example.com\">the   docs</a> &amp; " +
+				"<script>alert(1)</script>tests",
+			"tags":  []string{"<i>ui</i>", "fish &amp; chips"},
+			"count": 3,
+		},
+	}
+	mq, err := svc.SendEmailAsync(ctx, params)
+	if err != nil {
+		t.Fatalf("svc.SendEmailAsync failed: %+v", err)
+	}
+	assert.Contains(t, mq.Text, "<b>Andy</b>")
+
+	params.PlainTextParams = true
+	mq, err = svc.SendEmailAsync(ctx, params)
+	if err != nil {
+		t.Fatalf("svc.SendEmailAsync failed: %+v", err)
+	}
+	assert.Equal(t, "New comment from Andy Smith", mq.Subject)
+	assert.Equal(t, "Andy Smith wrote: Looks good but see the docs & tests [ui] [fish & chips] (3)", mq.Text)
+
+	// the HTML body is rendered with the params unchanged, escaped
+	assert.Contains(t, mq.HTML, "&lt;b&gt;Andy&lt;/b&gt;")
+}
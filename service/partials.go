@@ -0,0 +1,131 @@
+package service
+
+import (
+	"context"
+	htmltemplate "html/template"
+	txttemplate "text/template"
+
+	"github.com/andyfusniak/squishy-mailer-lite/entity"
+	"github.com/andyfusniak/squishy-mailer-lite/internal/store"
+	"github.com/pkg/errors"
+)
+
+// SetPartial creates or replaces a partial template in a group. Every
+// template in the group can include the partial by name, for example
+// {{template "footer" .}}, so that shared headers, footers and buttons are
+// stored once. Partials are loaded each time a template is rendered, so a
+// change applies to the next email sent.
+func (s *Service) SetPartial(ctx context.Context, params entity.SetPartialParams) (*entity.Partial, error) {
+	if err := s.idPolicy.validate("partial", params.Name); err != nil {
+		return nil, err
+	}
+	if params.Name == "layout" {
+		return nil, entity.NewServiceError(entity.ErrInvalidPartialCode,
+			errors.New("partial name layout is reserved for the template itself"))
+	}
+	funcs := templateFuncs(nil, nil)
+	if _, err := txttemplate.New(params.Name).Funcs(funcs).Parse(params.Text); err != nil {
+		return nil, entity.NewServiceError(entity.ErrInvalidPartialCode,
+			errors.Wrapf(err, "[service] txt template.New.Parse failed"))
+	}
+	if _, err := htmltemplate.New(params.Name).Funcs(funcs).Parse(params.HTML); err != nil {
+		return nil, entity.NewServiceError(entity.ErrInvalidPartialCode,
+			errors.Wrapf(err, "[service] html template.New.Parse failed"))
+	}
+	groupID, err := s.resolveGroupID(ctx, params.ProjectID, params.GroupID)
+	if err != nil {
+		return nil, err
+	}
+
+	obj, err := s.store.SetPartial(ctx, store.SetPartial{
+		PartialName: params.Name,
+		GroupID:     groupID,
+		ProjectID:   params.ProjectID,
+		Txt:         params.Text,
+		HTML:        params.HTML,
+	})
+	if err != nil {
+		if serr := serviceErrorFromStore(err); serr != nil {
+			return nil, serr
+		}
+		return nil, errors.Wrapf(err, "[service] store.SetPartial failed")
+	}
+	if err := checkProjectScope("partial", params.ProjectID, obj.ProjectID); err != nil {
+		return nil, err
+	}
+	s.cache.invalidateTemplates(params.ProjectID)
+	s.parsed.invalidateTemplates(params.ProjectID)
+	return partialFromStoreObject(obj), nil
+}
+
+// ListPartials lists the partial templates in a group ordered by name.
+func (s *Service) ListPartials(ctx context.Context, projectID, groupID string) ([]*entity.Partial, error) {
+	list, err := s.listPartials(ctx, projectID, groupID)
+	if err != nil {
+		return nil, err
+	}
+
+	partials := make([]*entity.Partial, 0, len(list))
+	for _, obj := range list {
+		partials = append(partials, partialFromStoreObject(obj))
+	}
+	return partials, nil
+}
+
+// DeletePartial deletes a partial template from a group. Templates that
+// still include the partial fail to render.
+func (s *Service) DeletePartial(ctx context.Context, projectID, groupID, name string) error {
+	if err := s.store.DeletePartial(ctx, projectID, groupID, name); err != nil {
+		if serr := serviceErrorFromStore(err); serr != nil {
+			return serr
+		}
+		return errors.Wrapf(err, "[service] store.DeletePartial failed")
+	}
+	s.cache.invalidateTemplates(projectID)
+	s.parsed.invalidateTemplates(projectID)
+	return nil
+}
+
+func (s *Service) listPartials(ctx context.Context, projectID, groupID string) ([]*store.Partial, error) {
+	list, err := s.store.ListPartials(ctx, projectID, groupID)
+	if err != nil {
+		return nil, errors.Wrapf(err, "[service] store.ListPartials failed")
+	}
+	for _, obj := range list {
+		if err := checkProjectScope("partial", projectID, obj.ProjectID); err != nil {
+			return nil, err
+		}
+	}
+	return list, nil
+}
+
+// addPartials parses the partials of a group into the text and HTML
+// templates. A partial with an empty body is left undefined for that
+// format.
+func addPartials(text *txttemplate.Template, html *htmltemplate.Template, partials []*store.Partial) error {
+	for _, p := range partials {
+		if p.Txt != "" {
+			if _, err := text.New(p.PartialName).Parse(p.Txt); err != nil {
+				return errors.Wrapf(err, "[service] txt partial %q Parse failed", p.PartialName)
+			}
+		}
+		if p.HTML != "" {
+			if _, err := html.New(p.PartialName).Parse(p.HTML); err != nil {
+				return errors.Wrapf(err, "[service] html partial %q Parse failed", p.PartialName)
+			}
+		}
+	}
+	return nil
+}
+
+func partialFromStoreObject(obj *store.Partial) *entity.Partial {
+	return &entity.Partial{
+		Name:       obj.PartialName,
+		GroupID:    obj.GroupID,
+		ProjectID:  obj.ProjectID,
+		Text:       obj.Txt,
+		HTML:       obj.HTML,
+		CreatedAt:  entity.ISOTime(obj.CreatedAt),
+		ModifiedAt: entity.ISOTime(obj.ModifiedAt),
+	}
+}
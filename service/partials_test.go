@@ -0,0 +1,124 @@
+package service_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/andyfusniak/squishy-mailer-lite/entity"
+	"github.com/andyfusniak/squishy-mailer-lite/service"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPartials(t *testing.T) {
+	stores := []struct {
+		name string
+		opts []service.Option
+	}{
+		{"sqlite3", nil},
+		{"memory", []service.Option{service.WithInMemoryStore()}},
+	}
+	for _, st := range stores {
+		t.Run(st.name, func(t *testing.T) {
+			srv := newFakeSMTPServer(t)
+			svc := newTestService(t, st.opts...)
+			setupQueueProject(t, svc, srv)
+
+			ctx := context.Background()
+			for _, p := range []entity.SetPartialParams{
+				{Name: "footer", Text: "-- {{.company}}", HTML: "<footer>{{.company}}</footer>"},
+				{Name: "button", HTML: `<a href="{{.url}}">Open</a>`},
+			} {
+				p.ProjectID = "p1"
+				p.GroupID = "g1"
+				if _, err := svc.SetPartial(ctx, p); err != nil {
+					t.Fatalf("svc.SetPartial failed: %+v", err)
+				}
+			}
+			for _, tc := range []struct{ id, text string }{
+				{"welcome", `{{define "layout"}}Hi{{template "footer" .}}{{end}}`},
+				{"custom", `{{define "layout"}}Hi{{template "footer" .}}{{end}}{{define "footer"}} bye{{end}}`},
+			} {
+				if _, err := svc.CreateTemplate(ctx, entity.CreateTemplate{
+					ID:        tc.id,
+					ProjectID: "p1",
+					GroupID:   "g1",
+					Text:      tc.text,
+					HTML:      `{{define "layout"}}{{template "button" .}}{{template "footer" .}}{{end}}`,
+				}); err != nil {
+					t.Fatalf("svc.CreateTemplate failed: %+v", err)
+				}
+			}
+
+			params := entity.SendEmailParams{
+				TemplateID:     "welcome",
+				ProjectID:      "p1",
+				TransportID:    "tr1",
+				To:             []string{"to@example.com"},
+				Subject:        "Welcome",
+				TemplateParams: map[string]string{"company": "Acme", "url": "https://example.com"},
+			}
+			mq, err := svc.SendEmailAsync(ctx, params)
+			if err != nil {
+				t.Fatalf("svc.SendEmailAsync failed: %+v", err)
+			}
+			assert.Equal(t, "Hi-- Acme", mq.Text)
+			assert.Equal(t, `<a href="https://example.com">Open</a><footer>Acme</footer>`, mq.HTML)
+
+			// a template's own definition overrides the partial
+			params.TemplateID = "custom"
+			mq, err = svc.SendEmailAsync(ctx, params)
+			if err != nil {
+				t.Fatalf("svc.SendEmailAsync failed: %+v", err)
+			}
+			assert.Equal(t, "Hi bye", mq.Text)
+
+			// params referenced by partials are reported by InspectTemplate
+			ti, err := svc.InspectTemplate(ctx, "p1", "welcome")
+			if err != nil {
+				t.Fatalf("svc.InspectTemplate failed: %+v", err)
+			}
+			assert.Equal(t, []string{"company", "url"}, ti.Params)
+
+			list, err := svc.ListPartials(ctx, "p1", "g1")
+			if err != nil {
+				t.Fatalf("svc.ListPartials failed: %+v", err)
+			}
+			if assert.Len(t, list, 2) {
+				assert.Equal(t, "button", list[0].Name)
+				assert.Equal(t, "footer", list[1].Name)
+			}
+
+			if err := svc.DeletePartial(ctx, "p1", "g1", "footer"); err != nil {
+				t.Fatalf("svc.DeletePartial failed: %+v", err)
+			}
+			params.TemplateID = "welcome"
+			_, err = svc.SendEmailAsync(ctx, params)
+			assert.Error(t, err)
+
+			err = svc.DeletePartial(ctx, "p1", "g1", "footer")
+			assertServiceErrorCode(t, err, entity.ErrPartialNotFoundCode)
+		})
+	}
+}
+
+func TestSetPartialValidation(t *testing.T) {
+	srv := newFakeSMTPServer(t)
+	svc := newTestService(t)
+	setupQueueProject(t, svc, srv)
+
+	ctx := context.Background()
+	_, err := svc.SetPartial(ctx, entity.SetPartialParams{
+		Name: "footer", ProjectID: "p1", GroupID: "g1", Text: "{{.company",
+	})
+	assertServiceErrorCode(t, err, entity.ErrInvalidPartialCode)
+
+	_, err = svc.SetPartial(ctx, entity.SetPartialParams{
+		Name: "layout", ProjectID: "p1", GroupID: "g1", Text: "layout",
+	})
+	assertServiceErrorCode(t, err, entity.ErrInvalidPartialCode)
+
+	_, err = svc.SetPartial(ctx, entity.SetPartialParams{
+		Name: "footer", ProjectID: "p1", GroupID: "g2", Text: "footer",
+	})
+	assertServiceErrorCode(t, err, entity.ErrGroupNotFoundCode)
+}
@@ -0,0 +1,41 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/argon2"
+)
+
+// passphraseSaltSetting is the name of the store setting holding the hex
+// encoded salt the encryption key is derived with.
+const passphraseSaltSetting = "encryption_key_salt"
+
+// Argon2id parameters, following the second recommended option of RFC
+// 9106. Changing them changes the derived key, so existing databases could
+// no longer be decrypted.
+const (
+	argon2Time    = 3
+	argon2Memory  = 64 * 1024
+	argon2Threads = 4
+)
+
+// deriveEncryptionKey derives a 32 byte encryption key from passphrase
+// using the salt kept in the store, creating the salt on first use.
+func (s *Service) deriveEncryptionKey(ctx context.Context, passphrase string) ([]byte, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, errors.Wrap(err, "[service] rand.Read failed")
+	}
+	setting, err := s.store.EnsureSetting(ctx, passphraseSaltSetting, hex.EncodeToString(salt))
+	if err != nil {
+		return nil, errors.Wrapf(err, "[service] store.EnsureSetting failed")
+	}
+	salt, err = hex.DecodeString(setting.Value)
+	if err != nil {
+		return nil, errors.Wrapf(err, "[service] stored %s is invalid", passphraseSaltSetting)
+	}
+	return argon2.IDKey([]byte(passphrase), salt, argon2Time, argon2Memory, argon2Threads, 32), nil
+}
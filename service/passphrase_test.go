@@ -0,0 +1,66 @@
+package service_test
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/andyfusniak/squishy-mailer-lite/service"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAES256EncryptionKey(t *testing.T) {
+	srv := newFakeSMTPServer(t)
+	svc := newTestService(t, service.WithHexEncodedEncryptionKey(strings.Repeat(testEncryptionKey, 2)))
+	setupQueueProject(t, svc, srv)
+
+	// the transport password is decrypted to deliver the email
+	queueTestEmail(t, svc)
+	n, err := svc.ProcessMailQueue(context.Background())
+	if err != nil {
+		t.Fatalf("svc.ProcessMailQueue failed: %+v", err)
+	}
+	assert.Equal(t, 1, n)
+	assert.Len(t, srv.Messages(), 1)
+
+	for _, key := range []string{testEncryptionKey[:30], testEncryptionKey + "0011223344556677"} {
+		_, err := service.NewEmailService(
+			service.WithInMemoryStore(),
+			service.WithHexEncodedEncryptionKey(key),
+		)
+		assert.Error(t, err, key)
+	}
+}
+
+func TestWithPassphrase(t *testing.T) {
+	dir := t.TempDir()
+	open := func(db, passphrase string) *service.Service {
+		t.Helper()
+		svc, err := service.NewEmailService(
+			service.WithSqlite3DBFilepath(filepath.Join(dir, db)),
+			service.WithPassphrase(passphrase),
+		)
+		if err != nil {
+			t.Fatalf("service.NewEmailService failed: %+v", err)
+		}
+		t.Cleanup(func() { svc.Close() })
+		return svc
+	}
+
+	// unsubscribe tokens are signed with the encryption key, so they show
+	// whether two services derived the same key
+	token := open("a.db", "correct horse").UnsubscribeToken("p1", "to@example.com")
+	assert.Equal(t, token, open("a.db", "correct horse").UnsubscribeToken("p1", "to@example.com"))
+	assert.NotEqual(t, token, open("a.db", "battery staple").UnsubscribeToken("p1", "to@example.com"))
+
+	// each database has its own salt
+	assert.NotEqual(t, token, open("b.db", "correct horse").UnsubscribeToken("p1", "to@example.com"))
+
+	_, err := service.NewEmailService(
+		service.WithInMemoryStore(),
+		service.WithPassphrase("correct horse"),
+		service.WithHexEncodedEncryptionKey(testEncryptionKey),
+	)
+	assert.Error(t, err)
+}
@@ -0,0 +1,84 @@
+package service_test
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/andyfusniak/squishy-mailer-lite/entity"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPostmarkTransport(t *testing.T) {
+	srv := newFakeSMTPServer(t)
+	api := newFakeAPIServer(t)
+	svc := newTestService(t)
+	setupQueueProject(t, svc, srv)
+
+	ctx := context.Background()
+	tr, err := svc.CreatePostmarkTransport(ctx, entity.CreatePostmarkTransport{
+		ID:            "pm",
+		ProjectID:     "p1",
+		Name:          "Postmark",
+		ServerToken:   "server-token",
+		BaseURL:       api.URL,
+		EmailFrom:     "noreply@example.com",
+		EmailFromName: "Example",
+	})
+	if err != nil {
+		t.Fatalf("svc.CreatePostmarkTransport failed: %+v", err)
+	}
+	assert.Equal(t, entity.APITransportProviderPostmark, tr.Provider)
+	assert.Equal(t, "outbound", tr.Config["message_stream"])
+
+	// the first email uses the transport's default stream and the second
+	// selects the broadcast stream
+	params := entity.SendEmailParams{
+		TemplateID:     "t1",
+		ProjectID:      "p1",
+		TransportID:    "pm",
+		To:             []string{"to@example.com", "other@example.com"},
+		Subject:        "Welcome",
+		TemplateParams: map[string]string{"name": "Andy"},
+	}
+	if err := svc.SendEmail(ctx, params); err != nil {
+		t.Fatalf("svc.SendEmail failed: %+v", err)
+	}
+	params.MessageStream = "broadcast"
+	queued, err := svc.SendEmailAsync(ctx, params)
+	if err != nil {
+		t.Fatalf("svc.SendEmailAsync failed: %+v", err)
+	}
+	assert.Equal(t, "broadcast", queued.MessageStream)
+	n, err := svc.ProcessMailQueue(ctx)
+	if err != nil {
+		t.Fatalf("svc.ProcessMailQueue failed: %+v", err)
+	}
+	assert.Equal(t, 1, n)
+
+	reqs := api.Requests()
+	if assert.Len(t, reqs, 2) {
+		var streams []string
+		for _, req := range reqs {
+			assert.Equal(t, "/email", req.Path)
+			assert.Equal(t, "server-token", req.Header.Get("X-Postmark-Server-Token"))
+
+			var body struct {
+				From          string
+				To            string
+				Subject       string
+				TextBody      string
+				MessageStream string
+			}
+			if err := json.Unmarshal(req.Body, &body); err != nil {
+				t.Fatalf("json.Unmarshal failed: %+v", err)
+			}
+			assert.Equal(t, "Example <noreply@example.com>", body.From)
+			assert.Equal(t, "to@example.com,other@example.com", body.To)
+			assert.Equal(t, "Welcome", body.Subject)
+			assert.Contains(t, body.TextBody, "Hello Andy")
+			streams = append(streams, body.MessageStream)
+		}
+		assert.Equal(t, []string{"outbound", "broadcast"}, streams)
+	}
+}
@@ -0,0 +1,275 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/andyfusniak/squishy-mailer-lite/entity"
+	"github.com/nats-io/nats.go"
+	"github.com/pkg/errors"
+)
+
+// EventPublisher publishes mail events to a message bus, such as NATS or
+// Kafka, for consumers outside of the process like analytics pipelines.
+type EventPublisher interface {
+	// Publish publishes a single event.
+	Publish(ctx context.Context, e entity.MailEvent) error
+
+	// Close flushes the events not yet published and releases the
+	// publisher's connections.
+	Close() error
+}
+
+// WithEventPublisher publishes every change in state of an email in the
+// mail queue, the events received by the subscribers of Subscribe, using
+// p. Events are published in the background in the order they happen so
+// that sending emails never waits for the message bus. Events that cannot
+// be published, or that arrive while more than 1024 are waiting to be
+// published, are logged and dropped. Shutdown publishes the events
+// waiting, dropping those left if its context is done first, and then
+// closes p.
+func WithEventPublisher(p EventPublisher) Option {
+	return func(s *Service) {
+		s.publisher = p
+	}
+}
+
+const (
+	// publisherBuffer is the number of events waiting to be published
+	// before events are dropped.
+	publisherBuffer = 1024
+
+	// publishTimeout limits the time taken to publish one event.
+	publishTimeout = 10 * time.Second
+)
+
+// startPublisher subscribes the event publisher, if there is one, to the
+// mail events of every project. The events are published until Shutdown
+// closes the subscription.
+func (s *Service) startPublisher() {
+	if s.publisher == nil {
+		return
+	}
+	sub := &subscriber{ch: make(chan entity.MailEvent, publisherBuffer)}
+	s.events.subscribe(context.Background(), sub)
+	s.publisherDone = make(chan struct{})
+	stopped, stop := context.WithCancel(context.Background())
+	s.stopPublisher = stop
+	go func() {
+		defer close(s.publisherDone)
+		var unpublished int
+		for e := range sub.ch {
+			if stopped.Err() != nil {
+				unpublished++
+				continue
+			}
+			ctx, cancel := context.WithTimeout(stopped, publishTimeout)
+			err := s.publisher.Publish(ctx, e)
+			cancel()
+			if err != nil {
+				if stopped.Err() != nil {
+					unpublished++
+					continue
+				}
+				s.logger.Warn("mail event publish failed", "project_id", e.ProjectID,
+					"mail_queue_id", e.MailQueueID, "state", e.State, "error", err)
+			}
+			if n := sub.dropped.Swap(0); n > 0 {
+				s.logger.Warn("mail events dropped", "count", n)
+			}
+		}
+		if unpublished > 0 {
+			s.logger.Warn("mail events dropped at shutdown", "count", unpublished)
+		}
+	}()
+}
+
+// closePublisher waits for the events already received by the event
+// publisher to be published, then closes it. If ctx is done first the
+// publishes in progress are cancelled and the events left are dropped.
+func (s *Service) closePublisher(ctx context.Context) error {
+	if s.publisher == nil {
+		return nil
+	}
+	select {
+	case <-s.publisherDone:
+	case <-ctx.Done():
+		s.stopPublisher()
+		<-s.publisherDone
+	}
+	s.stopPublisher()
+	if err := s.publisher.Close(); err != nil {
+		return errors.Wrapf(err, "[service] close event publisher failed")
+	}
+	return nil
+}
+
+// mailEventMessage is the JSON encoding of a published mail event.
+type mailEventMessage struct {
+	MailQueueID string           `json:"mail_queue_id"`
+	ProjectID   string           `json:"project_id"`
+	TemplateID  string           `json:"template_id"`
+	TransportID string           `json:"transport_id"`
+	CampaignID  string           `json:"campaign_id,omitempty"`
+	State       entity.MailState `json:"state"`
+	Error       string           `json:"error,omitempty"`
+	Time        entity.ISOTime   `json:"time"`
+}
+
+func newMailEventMessage(e entity.MailEvent) mailEventMessage {
+	return mailEventMessage{
+		MailQueueID: e.MailQueueID,
+		ProjectID:   e.ProjectID,
+		TemplateID:  e.TemplateID,
+		TransportID: e.TransportID,
+		CampaignID:  e.CampaignID,
+		State:       e.State,
+		Error:       e.Error,
+		Time:        e.Time,
+	}
+}
+
+//
+// NATS
+//
+
+type natsEventPublisher struct {
+	conn    *nats.Conn
+	subject string
+}
+
+// NewNATSEventPublisher connects to the NATS server at serverURL and returns
+// an EventPublisher that publishes each event as a JSON message to subject.
+// The URL may hold a user and password or a token, and opts configure the
+// connection further, for example with nats.UserCredentials. The
+// connection reconnects to the server if it is lost.
+func NewNATSEventPublisher(serverURL, subject string, opts ...nats.Option) (EventPublisher, error) {
+	if subject == "" {
+		return nil, errors.New("[service] nats subject is not set")
+	}
+	conn, err := nats.Connect(serverURL, append([]nats.Option{
+		nats.Name("squishy-mailer-lite"),
+		nats.MaxReconnects(-1),
+	}, opts...)...)
+	if err != nil {
+		return nil, errors.Wrapf(err, "[service] nats.Connect failed")
+	}
+	return &natsEventPublisher{conn: conn, subject: subject}, nil
+}
+
+func (p *natsEventPublisher) Publish(ctx context.Context, e entity.MailEvent) error {
+	b, err := json.Marshal(newMailEventMessage(e))
+	if err != nil {
+		return errors.Wrapf(err, "[service] json.Marshal failed")
+	}
+	if err := p.conn.Publish(p.subject, b); err != nil {
+		return errors.Wrapf(err, "[service] nats publish failed")
+	}
+	return nil
+}
+
+func (p *natsEventPublisher) Close() error {
+	defer p.conn.Close()
+	if err := p.conn.FlushTimeout(publishTimeout); err != nil {
+		return errors.Wrapf(err, "[service] nats flush failed")
+	}
+	return nil
+}
+
+//
+// Kafka
+//
+
+type kafkaEventPublisher struct {
+	client *http.Client
+	url    string
+	user   *url.Userinfo
+}
+
+// NewKafkaEventPublisher returns an EventPublisher that produces each event
+// as a JSON record to a Kafka topic through the Kafka REST Proxy v2 API at
+// baseURL, for example http://rest-proxy:8082. Records are keyed by the
+// email's mail queue id so that the events of an email stay in order. A
+// user and password in baseURL are sent using basic authentication. If
+// client is nil http.DefaultClient is used.
+func NewKafkaEventPublisher(client *http.Client, baseURL, topic string) (EventPublisher, error) {
+	if topic == "" {
+		return nil, errors.New("[service] kafka topic is not set")
+	}
+	u, err := url.Parse(baseURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, errors.Errorf("[service] invalid kafka rest proxy url %q", baseURL)
+	}
+	if client == nil {
+		client = http.DefaultClient
+	}
+	user := u.User
+	u.User = nil
+	u = u.JoinPath("topics", topic)
+	return &kafkaEventPublisher{client: client, url: u.String(), user: user}, nil
+}
+
+func (p *kafkaEventPublisher) Publish(ctx context.Context, e entity.MailEvent) error {
+	type record struct {
+		Key   string           `json:"key"`
+		Value mailEventMessage `json:"value"`
+	}
+	b, err := json.Marshal(struct {
+		Records []record `json:"records"`
+	}{[]record{{Key: e.MailQueueID, Value: newMailEventMessage(e)}}})
+	if err != nil {
+		return errors.Wrapf(err, "[service] json.Marshal failed")
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.url, bytes.NewReader(b))
+	if err != nil {
+		return errors.Wrapf(err, "[service] http.NewRequest failed")
+	}
+	req.Header.Set("Content-Type", "application/vnd.kafka.json.v2+json")
+	req.Header.Set("Accept", "application/vnd.kafka.v2+json")
+	if p.user != nil {
+		password, _ := p.user.Password()
+		req.SetBasicAuth(p.user.Username(), password)
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return errors.Wrapf(err, "[service] kafka rest proxy request failed")
+	}
+	defer resp.Body.Close()
+
+	rb, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return errors.Wrapf(err, "[service] read kafka rest proxy response failed")
+	}
+	if resp.StatusCode != http.StatusOK {
+		return errors.Errorf("[service] kafka rest proxy request failed with status %d: %s",
+			resp.StatusCode, truncate(strings.TrimSpace(string(rb)), 200))
+	}
+
+	// records the proxy accepted may still fail to be produced
+	var out struct {
+		Offsets []struct {
+			ErrorCode *int   `json:"error_code"`
+			Error     string `json:"error"`
+		} `json:"offsets"`
+	}
+	if err := json.Unmarshal(rb, &out); err != nil {
+		return errors.Wrapf(err, "[service] decode kafka rest proxy response failed")
+	}
+	for _, o := range out.Offsets {
+		if o.ErrorCode != nil && *o.ErrorCode != 0 {
+			return errors.Errorf("[service] kafka produce failed with error code %d: %s",
+				*o.ErrorCode, o.Error)
+		}
+	}
+	return nil
+}
+
+func (p *kafkaEventPublisher) Close() error {
+	return nil
+}
@@ -0,0 +1,268 @@
+package service_test
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/andyfusniak/squishy-mailer-lite/entity"
+	"github.com/andyfusniak/squishy-mailer-lite/service"
+	"github.com/stretchr/testify/assert"
+)
+
+// recordingPublisher records the events it publishes.
+type recordingPublisher struct {
+	mu     sync.Mutex
+	events []entity.MailEvent
+	closed bool
+}
+
+func (p *recordingPublisher) Publish(ctx context.Context, e entity.MailEvent) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.events = append(p.events, e)
+	return nil
+}
+
+func (p *recordingPublisher) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closed = true
+	return nil
+}
+
+func TestWithEventPublisher(t *testing.T) {
+	srv := newFakeSMTPServer(t)
+	p := &recordingPublisher{}
+	svc := newTestService(t, service.WithEventPublisher(p))
+	setupQueueProject(t, svc, srv)
+
+	mq := queueTestEmail(t, svc)
+	if _, err := svc.ProcessMailQueue(context.Background()); err != nil {
+		t.Fatalf("svc.ProcessMailQueue failed: %+v", err)
+	}
+
+	// the events waiting are published before the publisher is closed
+	if err := svc.Close(); err != nil {
+		t.Fatalf("svc.Close failed: %+v", err)
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	assert.True(t, p.closed)
+	var states []entity.MailState
+	for _, e := range p.events {
+		assert.Equal(t, mq.ID, e.MailQueueID)
+		states = append(states, e.State)
+	}
+	assert.Equal(t, []entity.MailState{
+		entity.MailStateQueued,
+		entity.MailStateSending,
+		entity.MailStateSent,
+	}, states)
+}
+
+// blockingPublisher never publishes an event, as if the message bus is
+// unreachable, until ctx is done.
+type blockingPublisher struct {
+	recordingPublisher
+}
+
+func (p *blockingPublisher) Publish(ctx context.Context, e entity.MailEvent) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestShutdownUnreachablePublisher(t *testing.T) {
+	var buf bytes.Buffer
+	p := &blockingPublisher{}
+	svc := newTestService(t,
+		service.WithEventPublisher(p),
+		service.WithLogger(slog.New(slog.NewTextHandler(&buf, nil))))
+	setupQueueProject(t, svc, newFakeSMTPServer(t))
+	for i := 0; i < 3; i++ {
+		queueTestEmail(t, svc)
+	}
+
+	// Shutdown gives up on the events waiting once ctx is done
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := svc.Shutdown(ctx); err != nil {
+		t.Fatalf("svc.Shutdown failed: %+v", err)
+	}
+	assert.Less(t, time.Since(start), 5*time.Second)
+	p.mu.Lock()
+	assert.True(t, p.closed)
+	p.mu.Unlock()
+	assert.Contains(t, buf.String(), "mail events dropped at shutdown")
+	assert.Contains(t, buf.String(), "count=3")
+}
+
+func TestKafkaEventPublisher(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		records []map[string]any
+	)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, password, _ := r.BasicAuth(); user != "sqm" || password != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.URL.Path != "/topics/mail.events" ||
+			r.Header.Get("Content-Type") != "application/vnd.kafka.json.v2+json" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		var req struct {
+			Records []map[string]any `json:"records"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		mu.Lock()
+		records = append(records, req.Records...)
+		mu.Unlock()
+		if req.Records[0]["key"] == "fail" {
+			fmt.Fprint(w, `{"offsets":[{"partition":null,"offset":null,"error_code":50002,"error":"broker unavailable"}]}`)
+			return
+		}
+		fmt.Fprint(w, `{"offsets":[{"partition":0,"offset":1,"error_code":null,"error":null}]}`)
+	}))
+	defer ts.Close()
+
+	ctx := context.Background()
+	u := strings.Replace(ts.URL, "http://", "http://sqm:secret@", 1)
+	p, err := service.NewKafkaEventPublisher(nil, u, "mail.events")
+	if err != nil {
+		t.Fatalf("service.NewKafkaEventPublisher failed: %+v", err)
+	}
+	at := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	if err := p.Publish(ctx, entity.MailEvent{
+		MailQueueID: "mq1",
+		ProjectID:   "p1",
+		TemplateID:  "t1",
+		TransportID: "tr1",
+		State:       entity.MailStateSent,
+		Time:        entity.ISOTime(at),
+	}); err != nil {
+		t.Fatalf("p.Publish failed: %+v", err)
+	}
+	err = p.Publish(ctx, entity.MailEvent{MailQueueID: "fail", State: entity.MailStateQueued})
+	assert.ErrorContains(t, err, "broker unavailable")
+
+	mu.Lock()
+	defer mu.Unlock()
+	if assert.Len(t, records, 2) {
+		assert.Equal(t, map[string]any{
+			"key": "mq1",
+			"value": map[string]any{
+				"mail_queue_id": "mq1",
+				"project_id":    "p1",
+				"template_id":   "t1",
+				"transport_id":  "tr1",
+				"state":         "sent",
+				"time":          "2024-06-01T12:00:00.000Z",
+			},
+		}, records[0])
+	}
+
+	_, err = service.NewKafkaEventPublisher(nil, "rest-proxy:8082", "mail.events")
+	assert.Error(t, err)
+}
+
+// newFakeNATSServer starts a NATS server that speaks enough of the
+// protocol for a client to connect and publish, sending the payload of
+// every message published to its subject on the returned channel.
+func newFakeNATSServer(t *testing.T, subject string) (string, <-chan []byte) {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen failed: %+v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	msgs := make(chan []byte, 16)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				fmt.Fprintf(conn, "INFO {\"server_id\":\"fake\",\"version\":\"2.10.0\",\"proto\":1,\"max_payload\":1048576}\r\n")
+				r := bufio.NewReader(conn)
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					fields := strings.Fields(line)
+					if len(fields) == 0 {
+						continue
+					}
+					switch strings.ToUpper(fields[0]) {
+					case "PING":
+						fmt.Fprint(conn, "PONG\r\n")
+					case "PUB":
+						n, _ := strconv.Atoi(fields[len(fields)-1])
+						payload := make([]byte, n+2)
+						if _, err := io.ReadFull(r, payload); err != nil {
+							return
+						}
+						if fields[1] == subject {
+							msgs <- payload[:n]
+						}
+					}
+				}
+			}()
+		}
+	}()
+	return "nats://" + ln.Addr().String(), msgs
+}
+
+func TestNATSEventPublisher(t *testing.T) {
+	url, msgs := newFakeNATSServer(t, "mail.events")
+	p, err := service.NewNATSEventPublisher(url, "mail.events")
+	if err != nil {
+		t.Fatalf("service.NewNATSEventPublisher failed: %+v", err)
+	}
+	if err := p.Publish(context.Background(), entity.MailEvent{
+		MailQueueID: "mq1",
+		ProjectID:   "p1",
+		State:       entity.MailStateFailed,
+		Error:       "550 mailbox unavailable",
+	}); err != nil {
+		t.Fatalf("p.Publish failed: %+v", err)
+	}
+	if err := p.Close(); err != nil {
+		t.Fatalf("p.Close failed: %+v", err)
+	}
+
+	select {
+	case b := <-msgs:
+		var msg map[string]any
+		if err := json.Unmarshal(b, &msg); err != nil {
+			t.Fatalf("json.Unmarshal failed: %+v", err)
+		}
+		assert.Equal(t, "mq1", msg["mail_queue_id"])
+		assert.Equal(t, "failed", msg["state"])
+		assert.Equal(t, "550 mailbox unavailable", msg["error"])
+	case <-time.After(time.Second):
+		t.Fatal("no message published")
+	}
+}
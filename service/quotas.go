@@ -0,0 +1,227 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/andyfusniak/squishy-mailer-lite/entity"
+	"github.com/andyfusniak/squishy-mailer-lite/internal/store"
+	"github.com/pkg/errors"
+)
+
+// maxQuotaDeferralDays is how far ahead an email over a project quota with
+// the defer action may be scheduled.
+const maxQuotaDeferralDays = 400
+
+// SetProjectQuota creates or replaces the send quota of a project. Once
+// the emails accepted on the current UTC day or in the current month
+// reach a limit, further emails are rejected or deferred according to the
+// quota's action. A limit of zero is unlimited. Emails blocked by the
+// allow-list or suppression list are not counted.
+func (s *Service) SetProjectQuota(ctx context.Context, params entity.SetProjectQuotaParams) (*entity.ProjectQuota, error) {
+	if params.PerDay < 0 || params.PerMonth < 0 {
+		return nil, entity.NewServiceError(entity.ErrInvalidQuotaCode,
+			fmt.Errorf("quota limits must not be negative"))
+	}
+	if params.Action == "" {
+		params.Action = entity.QuotaActionReject
+	}
+	if params.Action != entity.QuotaActionReject && params.Action != entity.QuotaActionDefer {
+		return nil, entity.NewServiceError(entity.ErrInvalidQuotaCode,
+			fmt.Errorf("unknown quota action %q", params.Action))
+	}
+
+	obj, err := s.store.SetProjectQuota(ctx, store.SetProjectQuota{
+		ProjectID: params.ProjectID,
+		PerDay:    params.PerDay,
+		PerMonth:  params.PerMonth,
+		Action:    string(params.Action),
+	})
+	if err != nil {
+		if serr := serviceErrorFromStore(err); serr != nil {
+			return nil, serr
+		}
+		return nil, errors.Wrapf(err, "[service] store.SetProjectQuota failed")
+	}
+	if err := checkProjectScope("quota", params.ProjectID, obj.ProjectID); err != nil {
+		return nil, err
+	}
+	return projectQuotaFromStoreObject(obj), nil
+}
+
+// GetProjectQuota retrieves the send quota of a project. If the project
+// has no quota an error is returned with a code of ErrQuotaNotFoundCode.
+func (s *Service) GetProjectQuota(ctx context.Context, projectID string) (*entity.ProjectQuota, error) {
+	obj, err := s.store.GetProjectQuota(ctx, projectID)
+	if err != nil {
+		if serr := serviceErrorFromStore(err); serr != nil {
+			return nil, serr
+		}
+		return nil, errors.Wrapf(err, "[service] store.GetProjectQuota failed")
+	}
+	if err := checkProjectScope("quota", projectID, obj.ProjectID); err != nil {
+		return nil, err
+	}
+	return projectQuotaFromStoreObject(obj), nil
+}
+
+// DeleteProjectQuota deletes the send quota of a project. The emails it
+// has sent are still counted if a quota is set again.
+func (s *Service) DeleteProjectQuota(ctx context.Context, projectID string) error {
+	if err := s.store.DeleteProjectQuota(ctx, projectID); err != nil {
+		if serr := serviceErrorFromStore(err); serr != nil {
+			return serr
+		}
+		return errors.Wrapf(err, "[service] store.DeleteProjectQuota failed")
+	}
+	return nil
+}
+
+// GetProjectQuotaUsage reports the number of emails a project has sent on
+// the current UTC day and in the current month, and the limits of its
+// quota if it has one. If the project does not exist an error is returned
+// with a code of ErrProjectNotFoundCode.
+func (s *Service) GetProjectQuotaUsage(ctx context.Context, projectID string) (*entity.ProjectQuotaUsage, error) {
+	if _, err := s.GetProject(ctx, projectID); err != nil {
+		return nil, err
+	}
+	quota, err := s.store.GetProjectQuota(ctx, projectID)
+	if err != nil {
+		var storeErr *store.Error
+		if !errors.As(err, &storeErr) || storeErr.Code != store.ErrProjectQuotaNotFound {
+			return nil, errors.Wrapf(err, "[service] store.GetProjectQuota failed")
+		}
+	}
+
+	day, month := quotaPeriods(time.Now())
+	usage, err := s.store.GetProjectQuotaUsage(ctx, projectID, day, month)
+	if err != nil {
+		return nil, errors.Wrapf(err, "[service] store.GetProjectQuotaUsage failed")
+	}
+	u := &entity.ProjectQuotaUsage{
+		ProjectID: projectID,
+		Day:       usage.Day,
+		Month:     usage.Month,
+		DaySent:   usage.DaySent,
+		MonthSent: usage.MonthSent,
+	}
+	if quota != nil {
+		u.PerDay = quota.PerDay
+		u.PerMonth = quota.PerMonth
+	}
+	return u, nil
+}
+
+// reserveQuota counts an email to be sent at sendAt, or now if sendAt is
+// zero, against the project's quota. If the quota has been reached and
+// canDefer is set and the quota's action is defer, the email is counted
+// on the first later day with room and the start of that day is returned
+// as the time to send it. Otherwise sendAt is returned unchanged, or an
+// error with a code of ErrQuotaExceededCode. The reservation, nil if the
+// project has no quota, is released using releaseQuota if the email is
+// not queued after all.
+func (s *Service) reserveQuota(ctx context.Context, projectID string, sendAt time.Time, canDefer bool) (time.Time, *quotaReservation, error) {
+	quota, err := s.store.GetProjectQuota(ctx, projectID)
+	if err != nil {
+		var storeErr *store.Error
+		if errors.As(err, &storeErr) && storeErr.Code == store.ErrProjectQuotaNotFound {
+			return sendAt, nil, nil
+		}
+		return time.Time{}, nil, errors.Wrapf(err, "[service] store.GetProjectQuota failed")
+	}
+
+	at := sendAt
+	if at.IsZero() {
+		at = time.Now()
+	}
+	for i := 0; i < maxQuotaDeferralDays; i++ {
+		day, month := quotaPeriods(at)
+		ok, err := s.store.ReserveProjectQuota(ctx, store.ReserveProjectQuota{
+			ProjectID: projectID,
+			Day:       day,
+			Month:     month,
+			PerDay:    quota.PerDay,
+			PerMonth:  quota.PerMonth,
+		})
+		if err != nil {
+			if serr := serviceErrorFromStore(err); serr != nil {
+				return time.Time{}, nil, serr
+			}
+			return time.Time{}, nil, errors.Wrapf(err, "[service] store.ReserveProjectQuota failed")
+		}
+		if ok {
+			r := &quotaReservation{projectID: projectID, day: day, month: month}
+			if i == 0 {
+				return sendAt, r, nil
+			}
+			return at, r, nil
+		}
+		if !canDefer || quota.Action != store.ProjectQuotaActionDefer {
+			return time.Time{}, nil, entity.NewServiceError(entity.ErrQuotaExceededCode,
+				fmt.Errorf("project %q has reached its quota of %s", projectID, quotaLimits(quota)))
+		}
+
+		// move to the next day, or the next month if the month is full
+		usage, err := s.store.GetProjectQuotaUsage(ctx, projectID, day, month)
+		if err != nil {
+			return time.Time{}, nil, errors.Wrapf(err, "[service] store.GetProjectQuotaUsage failed")
+		}
+		at = truncateDay(at).AddDate(0, 0, 1)
+		if quota.PerMonth > 0 && usage.MonthSent >= quota.PerMonth {
+			y, m, _ := at.Date()
+			if at.Day() != 1 {
+				at = time.Date(y, m+1, 1, 0, 0, 0, 0, time.UTC)
+			}
+		}
+	}
+	return time.Time{}, nil, entity.NewServiceError(entity.ErrQuotaExceededCode,
+		fmt.Errorf("project %q has no room under its quota of %s in the next %d days",
+			projectID, quotaLimits(quota), maxQuotaDeferralDays))
+}
+
+// quotaReservation is an email counted against a project's quota by
+// reserveQuota.
+type quotaReservation struct {
+	projectID  string
+	day, month string
+}
+
+// releaseQuota uncounts an email counted by reserveQuota that was not
+// queued after all. Failures are logged rather than returned as the
+// caller is already returning the error that stopped the email.
+func (s *Service) releaseQuota(ctx context.Context, r *quotaReservation) {
+	if r == nil {
+		return
+	}
+	if err := s.store.ReleaseProjectQuota(context.WithoutCancel(ctx), r.projectID, r.day, r.month); err != nil {
+		s.logger.Warn("release quota failed", "project_id", r.projectID, "day", r.day, "error", err)
+	}
+}
+
+// quotaPeriods returns the UTC day (YYYY-MM-DD) and month (YYYY-MM) of t.
+func quotaPeriods(t time.Time) (string, string) {
+	t = t.UTC()
+	return t.Format(time.DateOnly), t.Format("2006-01")
+}
+
+func quotaLimits(q *store.ProjectQuota) string {
+	switch {
+	case q.PerDay > 0 && q.PerMonth > 0:
+		return fmt.Sprintf("%d emails per day and %d per month", q.PerDay, q.PerMonth)
+	case q.PerDay > 0:
+		return fmt.Sprintf("%d emails per day", q.PerDay)
+	}
+	return fmt.Sprintf("%d emails per month", q.PerMonth)
+}
+
+func projectQuotaFromStoreObject(obj *store.ProjectQuota) *entity.ProjectQuota {
+	return &entity.ProjectQuota{
+		ProjectID:  obj.ProjectID,
+		PerDay:     obj.PerDay,
+		PerMonth:   obj.PerMonth,
+		Action:     entity.QuotaAction(obj.Action),
+		CreatedAt:  entity.ISOTime(obj.CreatedAt),
+		ModifiedAt: entity.ISOTime(obj.ModifiedAt),
+	}
+}
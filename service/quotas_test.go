@@ -0,0 +1,173 @@
+package service_test
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/andyfusniak/squishy-mailer-lite/entity"
+	"github.com/andyfusniak/squishy-mailer-lite/internal/store"
+	"github.com/andyfusniak/squishy-mailer-lite/internal/store/memory"
+	"github.com/andyfusniak/squishy-mailer-lite/internal/store/sqlite3"
+	"github.com/andyfusniak/squishy-mailer-lite/service"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProjectQuotas(t *testing.T) {
+	stores := []struct {
+		name string
+		opts []service.Option
+	}{
+		{"sqlite3", nil},
+		{"memory", []service.Option{service.WithInMemoryStore()}},
+	}
+	for _, tc := range stores {
+		t.Run(tc.name, func(t *testing.T) {
+			srv := newFakeSMTPServer(t)
+			svc := newTestService(t, tc.opts...)
+			setupQueueProject(t, svc, srv)
+			ctx := context.Background()
+
+			_, err := svc.GetProjectQuota(ctx, "p1")
+			assertServiceErrorCode(t, err, entity.ErrQuotaNotFoundCode)
+			_, err = svc.SetProjectQuota(ctx, entity.SetProjectQuotaParams{ProjectID: "p1", PerDay: -1})
+			assertServiceErrorCode(t, err, entity.ErrInvalidQuotaCode)
+			_, err = svc.SetProjectQuota(ctx, entity.SetProjectQuotaParams{ProjectID: "p1", Action: "drop"})
+			assertServiceErrorCode(t, err, entity.ErrInvalidQuotaCode)
+			_, err = svc.SetProjectQuota(ctx, entity.SetProjectQuotaParams{ProjectID: "nope", PerDay: 1})
+			assertServiceErrorCode(t, err, entity.ErrProjectNotFoundCode)
+
+			q, err := svc.SetProjectQuota(ctx, entity.SetProjectQuotaParams{ProjectID: "p1", PerDay: 2})
+			if err != nil {
+				t.Fatalf("svc.SetProjectQuota failed: %+v", err)
+			}
+			assert.Equal(t, entity.QuotaActionReject, q.Action)
+
+			params := entity.SendEmailParams{
+				TemplateID:     "t1",
+				ProjectID:      "p1",
+				TransportID:    "tr1",
+				To:             []string{"to@example.com"},
+				Subject:        "Welcome",
+				TemplateParams: map[string]string{"name": "Andy"},
+			}
+			queueTestEmail(t, svc)
+			if err := svc.SendEmail(ctx, params); err != nil {
+				t.Fatalf("svc.SendEmail failed: %+v", err)
+			}
+			_, err = svc.SendEmailAsync(ctx, params)
+			assertServiceErrorCode(t, err, entity.ErrQuotaExceededCode)
+			err = svc.SendEmail(ctx, params)
+			assertServiceErrorCode(t, err, entity.ErrQuotaExceededCode)
+
+			// blocked emails are not counted
+			blocked := params
+			blocked.To = []string{"blocked@example.com"}
+			if _, err := svc.AddSuppression(ctx, entity.AddSuppressionParams{
+				ProjectID: "p1",
+				Email:     "blocked@example.com",
+				Reason:    entity.SuppressionReasonManual,
+			}); err != nil {
+				t.Fatalf("svc.AddSuppression failed: %+v", err)
+			}
+			mq, err := svc.SendEmailAsync(ctx, blocked)
+			if err != nil {
+				t.Fatalf("svc.SendEmailAsync failed: %+v", err)
+			}
+			assert.Equal(t, entity.MailStateBlocked, mq.State)
+
+			u, err := svc.GetProjectQuotaUsage(ctx, "p1")
+			if err != nil {
+				t.Fatalf("svc.GetProjectQuotaUsage failed: %+v", err)
+			}
+			assert.Equal(t, 2, u.DaySent)
+			assert.Equal(t, 2, u.MonthSent)
+			assert.Equal(t, 2, u.PerDay)
+			assert.Equal(t, time.Now().UTC().Format(time.DateOnly), u.Day)
+
+			// deferred emails are counted on the day they are sent
+			if _, err := svc.SetProjectQuota(ctx, entity.SetProjectQuotaParams{
+				ProjectID: "p1",
+				PerDay:    2,
+				Action:    entity.QuotaActionDefer,
+			}); err != nil {
+				t.Fatalf("svc.SetProjectQuota failed: %+v", err)
+			}
+			deferred, err := svc.SendEmailAsync(ctx, params)
+			if err != nil {
+				t.Fatalf("svc.SendEmailAsync failed: %+v", err)
+			}
+			y, m, d := time.Now().UTC().Date()
+			tomorrow := time.Date(y, m, d+1, 0, 0, 0, 0, time.UTC)
+			assert.True(t, tomorrow.Equal(time.Time(deferred.SendAt)),
+				"send at %v, want %v", time.Time(deferred.SendAt), tomorrow)
+			err = svc.SendEmail(ctx, params)
+			assertServiceErrorCode(t, err, entity.ErrQuotaExceededCode)
+
+			if err := svc.DeleteProjectQuota(ctx, "p1"); err != nil {
+				t.Fatalf("svc.DeleteProjectQuota failed: %+v", err)
+			}
+			err = svc.DeleteProjectQuota(ctx, "p1")
+			assertServiceErrorCode(t, err, entity.ErrQuotaNotFoundCode)
+			if err := svc.SendEmail(ctx, params); err != nil {
+				t.Fatalf("svc.SendEmail failed: %+v", err)
+			}
+		})
+	}
+}
+
+// failingInsertStore fails to insert emails into the mail queue.
+type failingInsertStore struct {
+	store.Repository
+}
+
+func (s *failingInsertStore) InsertMailQueue(ctx context.Context, params store.AddMailQueue) (*store.MailQueue, error) {
+	return nil, errors.New("disk I/O error")
+}
+
+func TestProjectQuotaReleasedOnInsertFailure(t *testing.T) {
+	db, err := sqlite3.OpenDB(filepath.Join(t.TempDir(), "mailer.db"))
+	if err != nil {
+		t.Fatalf("sqlite3.OpenDB failed: %+v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if err := sqlite3.CreateSqliteDBSchema(db); err != nil {
+		t.Fatalf("sqlite3.CreateSqliteDBSchema failed: %+v", err)
+	}
+	stores := []struct {
+		name string
+		repo store.Repository
+	}{
+		{"sqlite3", sqlite3.NewStore(db, db)},
+		{"memory", memory.New()},
+	}
+	for _, tc := range stores {
+		t.Run(tc.name, func(t *testing.T) {
+			srv := newFakeSMTPServer(t)
+			svc := newTestService(t, service.WithStore(&failingInsertStore{Repository: tc.repo}))
+			setupQueueProject(t, svc, srv)
+			ctx := context.Background()
+
+			if _, err := svc.SetProjectQuota(ctx, entity.SetProjectQuotaParams{ProjectID: "p1", PerDay: 1}); err != nil {
+				t.Fatalf("svc.SetProjectQuota failed: %+v", err)
+			}
+			_, err := svc.SendEmailAsync(ctx, entity.SendEmailParams{
+				TemplateID:     "t1",
+				ProjectID:      "p1",
+				TransportID:    "tr1",
+				To:             []string{"to@example.com"},
+				TemplateParams: map[string]string{"name": "Andy"},
+			})
+			assert.ErrorContains(t, err, "disk I/O error")
+
+			u, err := svc.GetProjectQuotaUsage(ctx, "p1")
+			if err != nil {
+				t.Fatalf("svc.GetProjectQuotaUsage failed: %+v", err)
+			}
+			assert.Equal(t, 0, u.DaySent)
+			assert.Equal(t, 0, u.MonthSent)
+		})
+	}
+}
@@ -0,0 +1,138 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/andyfusniak/squishy-mailer-lite/entity"
+	"github.com/andyfusniak/squishy-mailer-lite/internal/store"
+	"github.com/pkg/errors"
+)
+
+// SetRateLimit creates or replaces the rate limit for a project, or for a
+// single transport within a project. Emails over any limit that applies to
+// them are moved to the rate_limited state by ProcessMailQueue and
+// delivered once the limit's window has passed. A limit of zero is
+// unlimited. Emails still being delivered are not counted, so with
+// WithWorkerConcurrency a limit may be briefly exceeded.
+func (s *Service) SetRateLimit(ctx context.Context, params entity.SetRateLimitParams) (*entity.RateLimit, error) {
+	if params.PerSecond < 0 || params.PerMinute < 0 || params.PerDay < 0 {
+		return nil, entity.NewServiceError(entity.ErrInvalidRateLimitCode,
+			fmt.Errorf("rate limits must not be negative"))
+	}
+	if params.TransportID != "" {
+		if err := s.checkTransport(ctx, params.TransportID, params.ProjectID); err != nil {
+			return nil, err
+		}
+	}
+
+	obj, err := s.store.SetRateLimit(ctx, store.SetRateLimit{
+		ProjectID:   params.ProjectID,
+		TransportID: params.TransportID,
+		PerSecond:   params.PerSecond,
+		PerMinute:   params.PerMinute,
+		PerDay:      params.PerDay,
+	})
+	if err != nil {
+		if serr := serviceErrorFromStore(err); serr != nil {
+			return nil, serr
+		}
+		return nil, errors.Wrapf(err, "[service] store.SetRateLimit failed")
+	}
+	if err := checkProjectScope("rate limit", params.ProjectID, obj.ProjectID); err != nil {
+		return nil, err
+	}
+	return rateLimitFromStoreObject(obj), nil
+}
+
+// ListRateLimits lists the rate limits for a project.
+func (s *Service) ListRateLimits(ctx context.Context, projectID string) ([]*entity.RateLimit, error) {
+	list, err := s.store.ListRateLimits(ctx, projectID)
+	if err != nil {
+		return nil, errors.Wrapf(err, "[service] store.ListRateLimits failed")
+	}
+
+	limits := make([]*entity.RateLimit, 0, len(list))
+	for _, obj := range list {
+		if err := checkProjectScope("rate limit", projectID, obj.ProjectID); err != nil {
+			return nil, err
+		}
+		limits = append(limits, rateLimitFromStoreObject(obj))
+	}
+	return limits, nil
+}
+
+// DeleteRateLimit deletes the rate limit for a project and transport. Use
+// an empty transportID to delete the project wide limit.
+func (s *Service) DeleteRateLimit(ctx context.Context, projectID, transportID string) error {
+	if err := s.store.DeleteRateLimit(ctx, projectID, transportID); err != nil {
+		if serr := serviceErrorFromStore(err); serr != nil {
+			return serr
+		}
+		return errors.Wrapf(err, "[service] store.DeleteRateLimit failed")
+	}
+	return nil
+}
+
+func rateLimitFromStoreObject(obj *store.RateLimit) *entity.RateLimit {
+	return &entity.RateLimit{
+		ProjectID:   obj.ProjectID,
+		TransportID: obj.TransportID,
+		PerSecond:   obj.PerSecond,
+		PerMinute:   obj.PerMinute,
+		PerDay:      obj.PerDay,
+		CreatedAt:   entity.ISOTime(obj.CreatedAt),
+		ModifiedAt:  entity.ISOTime(obj.ModifiedAt),
+	}
+}
+
+// rateLimitDeferral checks the project wide rate limit and the rate limit
+// of the email's transport. If either has been reached it returns the end
+// of the latest window that is full and the reason for the deferral. A
+// zero time means the email may be delivered now.
+func (s *Service) rateLimitDeferral(ctx context.Context, mq *store.MailQueue, now time.Time) (time.Time, string, error) {
+	limits, err := s.store.ListRateLimits(ctx, mq.ProjectID)
+	if err != nil {
+		return time.Time{}, "", errors.Wrapf(err, "[service] store.ListRateLimits failed")
+	}
+
+	var until time.Time
+	var reason string
+	for _, l := range limits {
+		if l.TransportID != "" && l.TransportID != mq.TransportID {
+			continue
+		}
+		now := now.UTC()
+		windows := []struct {
+			limit int
+			start time.Time
+			end   time.Time
+			name  string
+		}{
+			{l.PerSecond, now.Truncate(time.Second), now.Truncate(time.Second).Add(time.Second), "second"},
+			{l.PerMinute, now.Truncate(time.Minute), now.Truncate(time.Minute).Add(time.Minute), "minute"},
+			{l.PerDay, truncateDay(now), truncateDay(now).AddDate(0, 0, 1), "day"},
+		}
+		for _, w := range windows {
+			if w.limit <= 0 || !w.end.After(until) {
+				continue
+			}
+			n, err := s.store.CountMailQueueSent(ctx, mq.ProjectID, l.TransportID, store.Datetime(w.start))
+			if err != nil {
+				return time.Time{}, "", errors.Wrapf(err, "[service] store.CountMailQueueSent failed")
+			}
+			if n < w.limit {
+				continue
+			}
+			until = w.end
+			if l.TransportID == "" {
+				reason = fmt.Sprintf("project rate limit of %d emails per %s reached", w.limit, w.name)
+			} else {
+				reason = fmt.Sprintf("transport %s rate limit of %d emails per %s reached",
+					l.TransportID, w.limit, w.name)
+			}
+		}
+	}
+	return until, reason, nil
+}
@@ -0,0 +1,111 @@
+package service_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/andyfusniak/squishy-mailer-lite/entity"
+	"github.com/andyfusniak/squishy-mailer-lite/service"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimitDefersOverLimit(t *testing.T) {
+	stores := []struct {
+		name string
+		opts []service.Option
+	}{
+		{"sqlite3", nil},
+		{"memory", []service.Option{service.WithInMemoryStore()}},
+	}
+	for _, tc := range stores {
+		t.Run(tc.name, func(t *testing.T) {
+			srv := newFakeSMTPServer(t)
+			svc := newTestService(t, tc.opts...)
+			setupQueueProject(t, svc, srv)
+			ctx := context.Background()
+
+			if _, err := svc.SetRateLimit(ctx, entity.SetRateLimitParams{
+				ProjectID: "p1",
+				PerDay:    10,
+			}); err != nil {
+				t.Fatalf("svc.SetRateLimit failed: %+v", err)
+			}
+			rl, err := svc.SetRateLimit(ctx, entity.SetRateLimitParams{
+				ProjectID:   "p1",
+				TransportID: "tr1",
+				PerDay:      2,
+			})
+			if err != nil {
+				t.Fatalf("svc.SetRateLimit failed: %+v", err)
+			}
+			assert.Equal(t, "tr1", rl.TransportID)
+			assert.Equal(t, 2, rl.PerDay)
+
+			limits, err := svc.ListRateLimits(ctx, "p1")
+			if err != nil {
+				t.Fatalf("svc.ListRateLimits failed: %+v", err)
+			}
+			if assert.Len(t, limits, 2) {
+				assert.Equal(t, "", limits[0].TransportID)
+				assert.Equal(t, "tr1", limits[1].TransportID)
+			}
+
+			var queued []*entity.MailQueue
+			for i := 0; i < 3; i++ {
+				queued = append(queued, queueTestEmail(t, svc))
+			}
+			n, err := svc.ProcessMailQueue(ctx)
+			if err != nil {
+				t.Fatalf("svc.ProcessMailQueue failed: %+v", err)
+			}
+			assert.Equal(t, 2, n)
+			assert.Len(t, srv.Messages(), 2)
+
+			// the transport limit holds the third email until tomorrow
+			y, m, d := time.Now().UTC().Date()
+			tomorrow := time.Date(y, m, d+1, 0, 0, 0, 0, time.UTC)
+			limited, err := svc.GetMailQueue(ctx, "p1", queued[2].ID)
+			if err != nil {
+				t.Fatalf("svc.GetMailQueue failed: %+v", err)
+			}
+			assert.Equal(t, entity.MailStateRateLimited, limited.State)
+			assert.Contains(t, limited.DeferralReason, "transport tr1 rate limit of 2 emails per day reached")
+			assert.WithinDuration(t, tomorrow, time.Time(limited.NextAttemptAt), time.Second)
+
+			// a rate limited email can be cancelled but not retried
+			if _, err := svc.RetryMailQueue(ctx, "p1", queued[2].ID); err == nil {
+				t.Fatal("svc.RetryMailQueue of a rate limited email succeeded")
+			}
+			if _, err := svc.CancelMailQueue(ctx, "p1", queued[2].ID); err != nil {
+				t.Fatalf("svc.CancelMailQueue failed: %+v", err)
+			}
+
+			if err := svc.DeleteRateLimit(ctx, "p1", "tr1"); err != nil {
+				t.Fatalf("svc.DeleteRateLimit failed: %+v", err)
+			}
+			err = svc.DeleteRateLimit(ctx, "p1", "tr1")
+			assertServiceErrorCode(t, err, entity.ErrRateLimitNotFoundCode)
+		})
+	}
+}
+
+func TestSetRateLimitValidation(t *testing.T) {
+	srv := newFakeSMTPServer(t)
+	svc := newTestService(t)
+	setupQueueProject(t, svc, srv)
+	ctx := context.Background()
+
+	_, err := svc.SetRateLimit(ctx, entity.SetRateLimitParams{ProjectID: "p1", PerMinute: -1})
+	assertServiceErrorCode(t, err, entity.ErrInvalidRateLimitCode)
+
+	_, err = svc.SetRateLimit(ctx, entity.SetRateLimitParams{
+		ProjectID:   "p1",
+		TransportID: "missing",
+		PerMinute:   1,
+	})
+	assertServiceErrorCode(t, err, entity.ErrTransportNotFoundCode)
+
+	_, err = svc.SetRateLimit(ctx, entity.SetRateLimitParams{ProjectID: "missing", PerMinute: 1})
+	assertServiceErrorCode(t, err, entity.ErrProjectNotFoundCode)
+}
@@ -0,0 +1,163 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"net/mail"
+	"net/textproto"
+	"strings"
+
+	"github.com/andyfusniak/squishy-mailer-lite/entity"
+	"github.com/andyfusniak/squishy-mailer-lite/internal/store"
+	jemail "github.com/jordan-wright/email"
+	"github.com/pkg/errors"
+)
+
+// relayDroppedHeaders are the headers of a relayed message that are not
+// passed on, in addition to reservedHeaders, as the transport sets its own.
+var relayDroppedHeaders = map[string]bool{
+	"Content-Disposition": true,
+	"Content-Id":          true,
+	"Dkim-Signature":      true,
+	"Received":            true,
+	"X-Original-To":       true,
+}
+
+// RelayEmail places an email composed by the caller, rather than rendered
+// from a template, on the mail queue for delivery by ProcessMailQueue. It
+// is used to relay messages submitted over SMTP. The subject, bodies,
+// attachments, threading headers, Reply-To and custom headers of
+// params.Message are kept, and its From header is used as the sender,
+// subject to the transport's sender allow-list as for
+// SendEmailParams.EmailFrom. The email is delivered to params.Recipients
+// only. Otherwise it is queued as SendEmailAsync queues an email, and is
+// blocked if a recipient is outside the project's allow-list or
+// suppressed. If the message cannot be parsed an error is returned with a
+// code of ErrInvalidMessageCode.
+func (s *Service) RelayEmail(ctx context.Context, params entity.RelayEmailParams) (*entity.MailQueue, error) {
+	if len(params.Recipients) == 0 {
+		return nil, entity.NewServiceError(entity.ErrInvalidMessageCode,
+			errors.New("the email has no recipients"))
+	}
+	m, err := parseRelayMessage(params.Message)
+	if err != nil {
+		return nil, err
+	}
+
+	send := entity.SendEmailParams{
+		ProjectID:   params.ProjectID,
+		TransportID: params.TransportID,
+		Subject:     m.Subject,
+	}
+	to, cc := addressSet(m.To), addressSet(m.Cc)
+	for _, r := range params.Recipients {
+		addr := strings.ToLower(r)
+		if a, err := mail.ParseAddress(r); err == nil {
+			addr = strings.ToLower(a.Address)
+		}
+		switch {
+		case to[addr]:
+			send.To = append(send.To, r)
+		case cc[addr]:
+			send.Cc = append(send.Cc, r)
+		default:
+			send.Bcc = append(send.Bcc, r)
+		}
+	}
+	if m.From != "" {
+		from, err := mail.ParseAddress(m.From)
+		if err != nil {
+			return nil, entity.NewServiceError(entity.ErrInvalidMessageCode,
+				errors.Errorf("invalid from header %q", m.From))
+		}
+		send.EmailFrom, send.EmailFromName = from.Address, from.Name
+	}
+	send.ReplyTo = m.ReplyTo
+
+	for name, values := range m.Headers {
+		if len(values) == 0 {
+			continue
+		}
+		// threading headers that are not valid are dropped rather than
+		// failing the relay, a new Message-ID being generated
+		switch name {
+		case "Message-Id":
+			if id, err := checkMessageID(values[0]); err == nil {
+				send.MessageID = id
+			}
+		case "In-Reply-To":
+			if id, err := checkMessageID(values[0]); err == nil {
+				send.InReplyTo = id
+			}
+		case "References":
+			for _, ref := range strings.Fields(strings.Join(values, " ")) {
+				if id, err := checkMessageID(ref); err == nil {
+					send.References = append(send.References, id)
+				}
+			}
+		default:
+			if reservedHeaders[name] || relayDroppedHeaders[name] {
+				continue
+			}
+			if send.Headers == nil {
+				send.Headers = make(map[string]string)
+			}
+			send.Headers[name] = values[0]
+		}
+	}
+
+	for _, a := range m.Attachments {
+		filename := a.Filename
+		if filename == "" {
+			filename = "attachment"
+		}
+		send.Attachments = append(send.Attachments, entity.EmailAttachment{
+			Filename:    filename,
+			ContentType: a.ContentType,
+			Content:     a.Content,
+		})
+	}
+
+	return s.insertMailQueue(ctx, send, newSendCache(s, false), func() (*renderedEmail, error) {
+		return &renderedEmail{
+			tmpl:    &store.Template{},
+			subject: m.Subject,
+			txt:     string(m.Text),
+			html:    string(m.HTML),
+		}, nil
+	})
+}
+
+// parseRelayMessage parses an RFC 5322 message into its parts. Messages
+// without a Content-Type header are plain text.
+func parseRelayMessage(b []byte) (*jemail.Email, error) {
+	msg, err := mail.ReadMessage(bytes.NewReader(b))
+	if err != nil {
+		return nil, entity.NewServiceError(entity.ErrInvalidMessageCode, err)
+	}
+	if msg.Header.Get("Content-Type") == "" {
+		b = append([]byte("Content-Type: text/plain; charset=UTF-8\r\n"), b...)
+	}
+	m, err := jemail.NewEmailFromReader(bytes.NewReader(b))
+	if err != nil {
+		return nil, entity.NewServiceError(entity.ErrInvalidMessageCode, err)
+	}
+	headers := make(textproto.MIMEHeader, len(m.Headers))
+	for name, values := range m.Headers {
+		headers[textproto.CanonicalMIMEHeaderKey(name)] = values
+	}
+	m.Headers = headers
+	return m, nil
+}
+
+// addressSet returns the lower case addresses of a list of header
+// addresses.
+func addressSet(list []string) map[string]bool {
+	set := make(map[string]bool, len(list))
+	for _, v := range list {
+		if a, err := mail.ParseAddress(v); err == nil {
+			set[strings.ToLower(a.Address)] = true
+		}
+	}
+	return set
+}
@@ -0,0 +1,114 @@
+package service_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/andyfusniak/squishy-mailer-lite/entity"
+	"github.com/andyfusniak/squishy-mailer-lite/service"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRelayEmail(t *testing.T) {
+	stores := []struct {
+		name string
+		opts []service.Option
+	}{
+		{"sqlite3", nil},
+		{"memory", []service.Option{service.WithInMemoryStore()}},
+	}
+	for _, st := range stores {
+		t.Run(st.name, func(t *testing.T) {
+			srv := newFakeSMTPServer(t)
+			svc := newTestService(t, st.opts...)
+			setupQueueProject(t, svc, srv)
+			ctx := context.Background()
+
+			msg := strings.Join([]string{
+				"From: Example <from@example.com>",
+				"To: Someone <to@example.com>",
+				"Cc: cc@example.com",
+				"Subject: Your invoice",
+				"Message-ID: <invoice-1@example.com>",
+				"X-Invoice: 1001",
+				"Received: from app.internal",
+				"MIME-Version: 1.0",
+				`Content-Type: multipart/mixed; boundary="b1"`,
+				"",
+				"--b1",
+				"Content-Type: text/plain; charset=UTF-8",
+				"",
+				"Please find your invoice attached.",
+				"--b1",
+				`Content-Type: text/csv; name="invoice.csv"`,
+				`Content-Disposition: attachment; filename="invoice.csv"`,
+				"",
+				"item,amount",
+				"--b1--",
+				"",
+			}, "\r\n")
+			mq, err := svc.RelayEmail(ctx, entity.RelayEmailParams{
+				ProjectID:   "p1",
+				TransportID: "tr1",
+				Recipients:  []string{"to@example.com", "CC@example.com", "hidden@example.com"},
+				Message:     []byte(msg),
+			})
+			if err != nil {
+				t.Fatalf("svc.RelayEmail failed: %+v", err)
+			}
+			assert.Equal(t, entity.MailStateQueued, mq.State)
+			assert.Equal(t, "Your invoice", mq.Subject)
+			assert.Equal(t, "invoice-1@example.com", mq.MessageID)
+
+			if _, err := svc.ProcessMailQueue(ctx); err != nil {
+				t.Fatalf("svc.ProcessMailQueue failed: %+v", err)
+			}
+			msgs := srv.Messages()
+			if assert.Len(t, msgs, 1) {
+				m := msgs[0]
+				assert.Equal(t, "from@example.com", m.From)
+				assert.ElementsMatch(t, []string{"to@example.com", "CC@example.com", "hidden@example.com"}, m.To)
+				assert.Contains(t, m.Data, "Subject: Your invoice")
+				assert.Contains(t, m.Data, "X-Invoice: 1001")
+				assert.Contains(t, m.Data, "Please find your invoice attached.")
+				assert.Contains(t, m.Data, `filename="invoice.csv"`)
+				assert.NotContains(t, m.Data, "hidden@example.com")
+				assert.NotContains(t, m.Data, "app.internal")
+			}
+
+			// a message without a Content-Type is plain text
+			_, err = svc.RelayEmail(ctx, entity.RelayEmailParams{
+				ProjectID:   "p1",
+				TransportID: "tr1",
+				Recipients:  []string{"to@example.com"},
+				Message:     []byte("Subject: Plain\r\n\r\nJust text.\r\n"),
+			})
+			assert.NoError(t, err)
+
+			// the sender must be allowed
+			_, err = svc.RelayEmail(ctx, entity.RelayEmailParams{
+				ProjectID:   "p1",
+				TransportID: "tr1",
+				Recipients:  []string{"to@example.com"},
+				Message:     []byte("From: other@example.org\r\nSubject: Hi\r\n\r\nHi\r\n"),
+			})
+			assertServiceErrorCode(t, err, entity.ErrFromNotAllowedCode)
+
+			_, err = svc.RelayEmail(ctx, entity.RelayEmailParams{
+				ProjectID:   "p1",
+				TransportID: "tr1",
+				Message:     []byte("Subject: Hi\r\n\r\nHi\r\n"),
+			})
+			assertServiceErrorCode(t, err, entity.ErrInvalidMessageCode)
+
+			_, err = svc.RelayEmail(ctx, entity.RelayEmailParams{
+				ProjectID:   "p1",
+				TransportID: "tr1",
+				Recipients:  []string{"to@example.com"},
+				Message:     []byte("not a message"),
+			})
+			assertServiceErrorCode(t, err, entity.ErrInvalidMessageCode)
+		})
+	}
+}
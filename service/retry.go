@@ -0,0 +1,113 @@
+package service
+
+import (
+	"context"
+	"net/textproto"
+	"time"
+
+	"github.com/andyfusniak/squishy-mailer-lite/entity"
+	"github.com/andyfusniak/squishy-mailer-lite/internal/email"
+	"github.com/andyfusniak/squishy-mailer-lite/internal/store"
+	"github.com/pkg/errors"
+)
+
+// RetryPolicy controls how ProcessMailQueue retries emails whose delivery
+// fails. The zero value disables retries, so an email that fails is
+// marked as failed after its first attempt.
+type RetryPolicy struct {
+	// MaxAttempts is the total number of delivery attempts, including
+	// the first, before an email is moved to the dead_letter state.
+	// Values of 1 or less disable retries.
+	MaxAttempts int
+
+	// InitialBackoff is the delay before the first retry. Each further
+	// retry doubles the delay up to MaxBackoff. Defaults to one minute.
+	InitialBackoff time.Duration
+
+	// MaxBackoff caps the delay between retries. Defaults to one hour.
+	MaxBackoff time.Duration
+}
+
+const (
+	defaultInitialBackoff = time.Minute
+	defaultMaxBackoff     = time.Hour
+)
+
+// enabled reports whether the policy retries failed deliveries.
+func (p RetryPolicy) enabled() bool {
+	return p.MaxAttempts > 1
+}
+
+// backoff returns the delay before the next attempt after the given number
+// of failed attempts.
+func (p RetryPolicy) backoff(attempts int) time.Duration {
+	d := p.InitialBackoff
+	if d <= 0 {
+		d = defaultInitialBackoff
+	}
+	max := p.MaxBackoff
+	if max <= 0 {
+		max = defaultMaxBackoff
+	}
+	for i := 1; i < attempts && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		d = max
+	}
+	return d
+}
+
+// isPermanentFailure reports whether err is a permanent failure that
+// retrying will not fix, either an SMTP 5xx reply or an API client error.
+func isPermanentFailure(err error) bool {
+	var terr *textproto.Error
+	if errors.As(err, &terr) {
+		return terr.Code >= 500 && terr.Code < 600
+	}
+	var aerr *email.APIError
+	if errors.As(err, &aerr) {
+		return aerr.Permanent()
+	}
+	return false
+}
+
+// failMailQueue records a failed delivery attempt. Depending on the retry
+// policy the email is returned to the queue to be retried after a backoff,
+// or moved to the dead_letter or failed state and a failed event sent to
+// the project's webhooks.
+func (s *Service) failMailQueue(ctx context.Context, mq *store.MailQueue, startedAt time.Time, sendErr error) error {
+	attempts := mq.Attempts + 1
+	params := store.UpdateMailQueueState{
+		MailQueueID: mq.MailQueueID,
+		MState:      store.MailQueueStateFailed,
+		LastError:   sendErr.Error(),
+		Attempts:    &attempts,
+	}
+	if s.retry.enabled() {
+		switch {
+		case isPermanentFailure(sendErr), attempts >= s.retry.MaxAttempts:
+			params.MState = store.MailQueueStateDeadLetter
+		default:
+			next := store.Datetime(time.Now().UTC().Add(s.retry.backoff(attempts)))
+			params.MState = store.MailQueueStateQueued
+			params.NextAttemptAt = &next
+		}
+	}
+
+	obj, err := s.store.UpdateMailQueueState(ctx, params)
+	if err != nil {
+		return errors.Wrapf(err, "[service] store.UpdateMailQueueState failed")
+	}
+	s.publishMailEvent(obj, sendErr.Error())
+	if err := s.recordAttempt(ctx, mq, store.MailQueueStateFailed, startedAt, sendErr); err != nil {
+		return err
+	}
+	s.sendLogger(mq.ProjectID, mq.TransportID, mq.MailQueueID).Warn("email delivery failed",
+		"attempt", attempts, "duration", time.Since(startedAt), "state", params.MState,
+		"error", sendErr)
+	if params.MState == store.MailQueueStateQueued {
+		return nil
+	}
+	return s.emitWebhookEvent(ctx, entity.WebhookEventFailed, obj, sendErr)
+}
@@ -0,0 +1,211 @@
+package service_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/andyfusniak/squishy-mailer-lite/entity"
+	"github.com/andyfusniak/squishy-mailer-lite/service"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetryWithBackoff(t *testing.T) {
+	srv := newFakeSMTPServer(t)
+	svc := newTestService(t, service.WithRetryPolicy(service.RetryPolicy{
+		MaxAttempts:    3,
+		InitialBackoff: time.Hour,
+	}))
+	setupQueueProject(t, svc, srv)
+
+	queued := queueTestEmail(t, svc)
+
+	// stop the server so that delivery fails
+	srv.ln.Close()
+
+	ctx := context.Background()
+	start := time.Now()
+	n, err := svc.ProcessMailQueue(ctx)
+	if err != nil {
+		t.Fatalf("svc.ProcessMailQueue failed: %+v", err)
+	}
+	assert.Equal(t, 0, n)
+
+	mq, err := svc.GetMailQueue(ctx, "p1", queued.ID)
+	if err != nil {
+		t.Fatalf("svc.GetMailQueue failed: %+v", err)
+	}
+	assert.Equal(t, entity.MailStateQueued, mq.State)
+	assert.Equal(t, 1, mq.Attempts)
+	assert.NotEmpty(t, mq.LastError)
+	assert.WithinDuration(t, start.Add(time.Hour), time.Time(mq.NextAttemptAt), time.Minute)
+
+	// the email is not retried before its backoff has elapsed
+	n, err = svc.ProcessMailQueue(ctx)
+	if err != nil {
+		t.Fatalf("svc.ProcessMailQueue failed: %+v", err)
+	}
+	assert.Equal(t, 0, n)
+	mq, err = svc.GetMailQueue(ctx, "p1", queued.ID)
+	if err != nil {
+		t.Fatalf("svc.GetMailQueue failed: %+v", err)
+	}
+	assert.Equal(t, 1, mq.Attempts)
+}
+
+func TestRetryExhaustedMovesToDeadLetter(t *testing.T) {
+	srv := newFakeSMTPServer(t)
+	svc := newTestService(t, service.WithRetryPolicy(service.RetryPolicy{
+		MaxAttempts:    2,
+		InitialBackoff: time.Millisecond,
+	}))
+	setupQueueProject(t, svc, srv)
+
+	queued := queueTestEmail(t, svc)
+	srv.ln.Close()
+
+	ctx := context.Background()
+	for i := 0; i < 2; i++ {
+		time.Sleep(5 * time.Millisecond)
+		if _, err := svc.ProcessMailQueue(ctx); err != nil {
+			t.Fatalf("svc.ProcessMailQueue failed: %+v", err)
+		}
+	}
+
+	mq, err := svc.GetMailQueue(ctx, "p1", queued.ID)
+	if err != nil {
+		t.Fatalf("svc.GetMailQueue failed: %+v", err)
+	}
+	assert.Equal(t, entity.MailStateDeadLetter, mq.State)
+	assert.Equal(t, 2, mq.Attempts)
+}
+
+func TestPermanentFailureMovesToDeadLetter(t *testing.T) {
+	srv := newFakeSMTPServer(t)
+	svc := newTestService(t, service.WithRetryPolicy(service.RetryPolicy{
+		MaxAttempts: 5,
+	}))
+	setupQueueProject(t, svc, srv)
+
+	ctx := context.Background()
+	queued, err := svc.SendEmailAsync(ctx, entity.SendEmailParams{
+		TemplateID:     "t1",
+		ProjectID:      "p1",
+		TransportID:    "tr1",
+		To:             []string{"reject@example.com"},
+		Subject:        "Welcome",
+		TemplateParams: map[string]string{"name": "Andy"},
+	})
+	if err != nil {
+		t.Fatalf("svc.SendEmailAsync failed: %+v", err)
+	}
+
+	if _, err := svc.ProcessMailQueue(ctx); err != nil {
+		t.Fatalf("svc.ProcessMailQueue failed: %+v", err)
+	}
+
+	mq, err := svc.GetMailQueue(ctx, "p1", queued.ID)
+	if err != nil {
+		t.Fatalf("svc.GetMailQueue failed: %+v", err)
+	}
+	assert.Equal(t, entity.MailStateDeadLetter, mq.State)
+	assert.Equal(t, 1, mq.Attempts)
+	assert.Contains(t, mq.LastError, "550")
+}
+
+func TestRequeueDeadLetter(t *testing.T) {
+	stores := []struct {
+		name string
+		opts []service.Option
+	}{
+		{"sqlite3", nil},
+		{"memory", []service.Option{service.WithInMemoryStore()}},
+	}
+	for _, tc := range stores {
+		t.Run(tc.name, func(t *testing.T) {
+			srv := newFakeSMTPServer(t)
+			svc := newTestService(t, append(tc.opts, service.WithRetryPolicy(service.RetryPolicy{
+				MaxAttempts:    2,
+				InitialBackoff: time.Millisecond,
+			}))...)
+			setupQueueProject(t, svc, srv)
+			ctx := context.Background()
+
+			queued := queueTestEmail(t, svc)
+			pending := queueTestEmail(t, svc)
+			if _, err := svc.CancelMailQueue(ctx, "p1", pending.ID); err != nil {
+				t.Fatalf("svc.CancelMailQueue failed: %+v", err)
+			}
+			srv.ln.Close()
+			for i := 0; i < 2; i++ {
+				time.Sleep(5 * time.Millisecond)
+				if _, err := svc.ProcessMailQueue(ctx); err != nil {
+					t.Fatalf("svc.ProcessMailQueue failed: %+v", err)
+				}
+			}
+
+			list, err := svc.ListDeadLetters(ctx, entity.ListDeadLettersParams{ProjectID: "p1"})
+			if err != nil {
+				t.Fatalf("svc.ListDeadLetters failed: %+v", err)
+			}
+			if assert.Len(t, list, 1) {
+				assert.Equal(t, queued.ID, list[0].ID)
+				assert.Equal(t, 2, list[0].Attempts)
+			}
+
+			// requeue using a backup transport
+			backup := newFakeSMTPServer(t)
+			if _, err := svc.CreateSMTPTransport(ctx, entity.CreateSMTPTransport{
+				ID:        "tr2",
+				ProjectID: "p1",
+				Name:      "Backup",
+				Host:      backup.Host(),
+				Port:      backup.Port(),
+				EmailFrom: "from@example.com",
+			}); err != nil {
+				t.Fatalf("svc.CreateSMTPTransport failed: %+v", err)
+			}
+
+			_, err = svc.RequeueDeadLetter(ctx, entity.RequeueDeadLetterParams{
+				ProjectID:   "p1",
+				MailQueueID: queued.ID,
+				TransportID: "missing",
+			})
+			assertServiceErrorCode(t, err, entity.ErrTransportNotFoundCode)
+			_, err = svc.RequeueDeadLetter(ctx, entity.RequeueDeadLetterParams{
+				ProjectID:   "p1",
+				MailQueueID: pending.ID,
+			})
+			assertServiceErrorCode(t, err, entity.ErrMailQueueStateCode)
+
+			mq, err := svc.RequeueDeadLetter(ctx, entity.RequeueDeadLetterParams{
+				ProjectID:   "p1",
+				MailQueueID: queued.ID,
+				TransportID: "tr2",
+			})
+			if err != nil {
+				t.Fatalf("svc.RequeueDeadLetter failed: %+v", err)
+			}
+			assert.Equal(t, entity.MailStateQueued, mq.State)
+			assert.Equal(t, 0, mq.Attempts)
+			assert.Equal(t, "tr2", mq.TransportID)
+
+			n, err := svc.ProcessMailQueue(ctx)
+			if err != nil {
+				t.Fatalf("svc.ProcessMailQueue failed: %+v", err)
+			}
+			assert.Equal(t, 1, n)
+			assert.Len(t, backup.Messages(), 1)
+
+			// the attempt history continues across the requeue
+			attempts, err := svc.ListMailQueueAttempts(ctx, "p1", queued.ID)
+			if err != nil {
+				t.Fatalf("svc.ListMailQueueAttempts failed: %+v", err)
+			}
+			if assert.Len(t, attempts, 3) {
+				assert.Equal(t, 3, attempts[2].Attempt)
+				assert.Equal(t, entity.MailStateSent, attempts[2].State)
+			}
+		})
+	}
+}
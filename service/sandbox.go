@@ -0,0 +1,64 @@
+package service
+
+import (
+	"net/mail"
+	"slices"
+	"strings"
+
+	"github.com/andyfusniak/squishy-mailer-lite/internal/email"
+	"github.com/pkg/errors"
+)
+
+// Sandbox headers record the original recipients of an email redirected
+// to the sandbox recipients.
+const (
+	SandboxOriginalToHeader  = "X-Sandbox-Original-To"
+	SandboxOriginalCcHeader  = "X-Sandbox-Original-Cc"
+	SandboxOriginalBccHeader = "X-Sandbox-Original-Bcc"
+)
+
+// WithSandboxRecipients redirects every outgoing email to the given
+// addresses instead of its recipients, for staging environments that must
+// never email real customers. The original To, Cc and Bcc recipients are
+// recorded in the SandboxOriginalToHeader, SandboxOriginalCcHeader and
+// SandboxOriginalBccHeader headers. Suppression lists, allowlists and
+// rate limits still apply to the original recipients.
+func WithSandboxRecipients(addresses []string) Option {
+	return func(s *Service) {
+		s.sandboxRecipients = slices.Clone(addresses)
+	}
+}
+
+// checkSandboxRecipients validates the sandbox recipients.
+func (s *Service) checkSandboxRecipients() error {
+	for _, addr := range s.sandboxRecipients {
+		if _, err := mail.ParseAddress(addr); err != nil {
+			return errors.Wrapf(err, "[service] invalid sandbox recipient %q", addr)
+		}
+	}
+	return nil
+}
+
+// sandbox returns params with its recipients replaced by the sandbox
+// recipients if sandbox mode is enabled.
+func (s *Service) sandbox(params email.EmailParams) email.EmailParams {
+	if len(s.sandboxRecipients) == 0 {
+		return params
+	}
+	original := map[string][]string{
+		SandboxOriginalToHeader:  params.To,
+		SandboxOriginalCcHeader:  params.Cc,
+		SandboxOriginalBccHeader: params.Bcc,
+	}
+	h := make(map[string]string, len(original))
+	for name, addrs := range original {
+		if len(addrs) > 0 {
+			h[name] = strings.Join(addrs, ", ")
+		}
+	}
+	params.Headers = mergeHeaders(params.Headers, h)
+	params.To = slices.Clone(s.sandboxRecipients)
+	params.Cc = nil
+	params.Bcc = nil
+	return params
+}
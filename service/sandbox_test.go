@@ -0,0 +1,58 @@
+package service_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/andyfusniak/squishy-mailer-lite/entity"
+	"github.com/andyfusniak/squishy-mailer-lite/service"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWithSandboxRecipients(t *testing.T) {
+	srv := newFakeSMTPServer(t)
+	svc := newTestService(t, service.WithSandboxRecipients([]string{"qa@example.com", "dev@example.com"}))
+	setupQueueProject(t, svc, srv)
+
+	ctx := context.Background()
+	params := entity.SendEmailParams{
+		TemplateID:     "t1",
+		ProjectID:      "p1",
+		TransportID:    "tr1",
+		To:             []string{"customer@example.com"},
+		Cc:             []string{"manager@example.com"},
+		Bcc:            []string{"audit@example.com"},
+		Subject:        "Welcome",
+		TemplateParams: map[string]string{"name": "Andy"},
+	}
+	if err := svc.SendEmail(ctx, params); err != nil {
+		t.Fatalf("svc.SendEmail failed: %+v", err)
+	}
+
+	// queued emails keep their original recipients until they are sent
+	queued, err := svc.SendEmailAsync(ctx, params)
+	if err != nil {
+		t.Fatalf("svc.SendEmailAsync failed: %+v", err)
+	}
+	assert.Equal(t, []string{"customer@example.com"}, queued.To)
+	if _, err := svc.ProcessMailQueue(ctx); err != nil {
+		t.Fatalf("svc.ProcessMailQueue failed: %+v", err)
+	}
+
+	msgs := srv.Messages()
+	if assert.Len(t, msgs, 2) {
+		for _, msg := range msgs {
+			assert.Equal(t, []string{"qa@example.com", "dev@example.com"}, msg.To)
+			assert.Contains(t, msg.Data, "X-Sandbox-Original-To: customer@example.com")
+			assert.Contains(t, msg.Data, "X-Sandbox-Original-Cc: manager@example.com")
+			assert.Contains(t, msg.Data, "X-Sandbox-Original-Bcc: audit@example.com")
+		}
+	}
+
+	_, err = service.NewEmailService(
+		service.WithInMemoryStore(),
+		service.WithHexEncodedEncryptionKey(testEncryptionKey),
+		service.WithSandboxRecipients([]string{"not an address"}),
+	)
+	assert.Error(t, err)
+}
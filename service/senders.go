@@ -0,0 +1,137 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"net/mail"
+	"strings"
+
+	"github.com/andyfusniak/squishy-mailer-lite/entity"
+	"github.com/andyfusniak/squishy-mailer-lite/internal/store"
+	"github.com/pkg/errors"
+)
+
+// SetSenderAllowList creates or replaces the verified sender addresses a
+// transport may send from in place of its own, using
+// SendEmailParams.EmailFrom. The addresses must already be verified with
+// the transport's provider; the service only checks that sends stay
+// within the list. If an address cannot be parsed an error is returned
+// with a code of ErrInvalidSenderAllowListCode.
+func (s *Service) SetSenderAllowList(ctx context.Context, params entity.SetSenderAllowListParams) (*entity.SenderAllowList, error) {
+	if err := s.checkTransport(ctx, params.TransportID, params.ProjectID); err != nil {
+		return nil, err
+	}
+
+	emails := make(store.JSONArray, 0, len(params.Emails))
+	seen := make(map[string]bool, len(params.Emails))
+	for _, e := range params.Emails {
+		addr, err := mail.ParseAddress(e)
+		if err != nil {
+			return nil, entity.NewServiceError(entity.ErrInvalidSenderAllowListCode,
+				fmt.Errorf("invalid email address %q: %w", e, err))
+		}
+		a := strings.ToLower(addr.Address)
+		if !seen[a] {
+			seen[a] = true
+			emails = append(emails, a)
+		}
+	}
+
+	obj, err := s.store.SetSenderAllowList(ctx, store.SetSenderAllowList{
+		ProjectID:   params.ProjectID,
+		TransportID: params.TransportID,
+		Emails:      emails,
+	})
+	if err != nil {
+		if serr := serviceErrorFromStore(err); serr != nil {
+			return nil, serr
+		}
+		return nil, errors.Wrapf(err, "[service] store.SetSenderAllowList failed")
+	}
+	if err := checkProjectScope("sender allow-list", params.ProjectID, obj.ProjectID); err != nil {
+		return nil, err
+	}
+	return senderAllowListFromStoreObject(obj), nil
+}
+
+// GetSenderAllowList gets the sender allow-list of a transport. If the
+// transport has none an error is returned with a code of
+// ErrSenderAllowListNotFoundCode.
+func (s *Service) GetSenderAllowList(ctx context.Context, projectID, transportID string) (*entity.SenderAllowList, error) {
+	obj, err := s.store.GetSenderAllowList(ctx, projectID, transportID)
+	if err != nil {
+		if serr := serviceErrorFromStore(err); serr != nil {
+			return nil, serr
+		}
+		return nil, errors.Wrapf(err, "[service] store.GetSenderAllowList failed")
+	}
+	if err := checkProjectScope("sender allow-list", projectID, obj.ProjectID); err != nil {
+		return nil, err
+	}
+	return senderAllowListFromStoreObject(obj), nil
+}
+
+// DeleteSenderAllowList deletes the sender allow-list of a transport so
+// that it only sends from its own address.
+func (s *Service) DeleteSenderAllowList(ctx context.Context, projectID, transportID string) error {
+	if err := s.store.DeleteSenderAllowList(ctx, projectID, transportID); err != nil {
+		if serr := serviceErrorFromStore(err); serr != nil {
+			return serr
+		}
+		return errors.Wrapf(err, "[service] store.DeleteSenderAllowList failed")
+	}
+	return nil
+}
+
+// checkFrom checks the sender override of an email against the
+// transport's own address and its sender allow-list. It returns the
+// sender address to use in place of the transport's, or the empty string
+// if there is no override.
+func (s *Service) checkFrom(ctx context.Context, params entity.SendEmailParams, transportID, transportFrom string) (string, error) {
+	if strings.ContainsAny(params.EmailFromName, "\r\n") {
+		return "", entity.NewServiceError(entity.ErrFromNotAllowedCode,
+			errors.New("from name contains a line break"))
+	}
+	if params.EmailFrom == "" {
+		return "", nil
+	}
+	addr, err := mail.ParseAddress(params.EmailFrom)
+	if err != nil {
+		return "", entity.NewServiceError(entity.ErrFromNotAllowedCode,
+			fmt.Errorf("invalid from address %q: %w", params.EmailFrom, err))
+	}
+	if strings.EqualFold(addr.Address, transportFrom) {
+		return addr.Address, nil
+	}
+
+	notAllowed := entity.NewServiceError(entity.ErrFromNotAllowedCode,
+		fmt.Errorf("transport %q may not send from %q", transportID, addr.Address))
+	list, err := s.store.GetSenderAllowList(ctx, params.ProjectID, transportID)
+	if err != nil {
+		var storeErr *store.Error
+		if errors.As(err, &storeErr) && storeErr.Code == store.ErrSenderAllowListNotFound {
+			return "", notAllowed
+		}
+		return "", errors.Wrapf(err, "[service] store.GetSenderAllowList failed")
+	}
+	for _, e := range list.Emails {
+		if strings.EqualFold(e, addr.Address) {
+			return addr.Address, nil
+		}
+	}
+	return "", notAllowed
+}
+
+func senderAllowListFromStoreObject(obj *store.SenderAllowList) *entity.SenderAllowList {
+	emails := []string(obj.Emails)
+	if emails == nil {
+		emails = []string{}
+	}
+	return &entity.SenderAllowList{
+		ProjectID:   obj.ProjectID,
+		TransportID: obj.TransportID,
+		Emails:      emails,
+		CreatedAt:   entity.ISOTime(obj.CreatedAt),
+		ModifiedAt:  entity.ISOTime(obj.ModifiedAt),
+	}
+}
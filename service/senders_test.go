@@ -0,0 +1,163 @@
+package service_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/andyfusniak/squishy-mailer-lite/entity"
+	"github.com/andyfusniak/squishy-mailer-lite/service"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSenderAllowList(t *testing.T) {
+	stores := []struct {
+		name string
+		opts []service.Option
+	}{
+		{"sqlite3", nil},
+		{"memory", []service.Option{service.WithInMemoryStore()}},
+	}
+	for _, tc := range stores {
+		t.Run(tc.name, func(t *testing.T) {
+			srv := newFakeSMTPServer(t)
+			svc := newTestService(t, tc.opts...)
+			setupQueueProject(t, svc, srv)
+			ctx := context.Background()
+
+			params := entity.SendEmailParams{
+				TemplateID:     "t1",
+				ProjectID:      "p1",
+				TransportID:    "tr1",
+				To:             []string{"to@example.com"},
+				TemplateParams: map[string]string{"name": "Andy"},
+				EmailFrom:      "support@example.com",
+				EmailFromName:  "Support",
+			}
+			_, err := svc.SendEmailAsync(ctx, params)
+			assertServiceErrorCode(t, err, entity.ErrFromNotAllowedCode)
+
+			list, err := svc.SetSenderAllowList(ctx, entity.SetSenderAllowListParams{
+				ProjectID:   "p1",
+				TransportID: "tr1",
+				Emails:      []string{"Support@Example.com", "Billing <billing@example.com>", "support@example.com"},
+			})
+			if err != nil {
+				t.Fatalf("svc.SetSenderAllowList failed: %+v", err)
+			}
+			assert.Equal(t, []string{"support@example.com", "billing@example.com"}, list.Emails)
+
+			mq, err := svc.SendEmailAsync(ctx, params)
+			if err != nil {
+				t.Fatalf("svc.SendEmailAsync failed: %+v", err)
+			}
+			assert.Equal(t, "support@example.com", mq.EmailFrom)
+			assert.Equal(t, "Support", mq.EmailFromName)
+			if _, err := svc.ProcessMailQueue(ctx); err != nil {
+				t.Fatalf("svc.ProcessMailQueue failed: %+v", err)
+			}
+
+			// the transport's own address and a name on its own are allowed
+			own := params
+			own.EmailFrom = "FROM@example.com"
+			if err := svc.SendEmail(ctx, own); err != nil {
+				t.Fatalf("svc.SendEmail failed: %+v", err)
+			}
+			nameOnly := params
+			nameOnly.EmailFrom = ""
+			if err := svc.SendEmail(ctx, nameOnly); err != nil {
+				t.Fatalf("svc.SendEmail failed: %+v", err)
+			}
+
+			msgs := srv.Messages()
+			if assert.Len(t, msgs, 3) {
+				assert.Equal(t, "support@example.com", msgs[0].From)
+				assert.Contains(t, msgs[0].Data, "From: \"Support\" <support@example.com>\r\n")
+				assert.Equal(t, "FROM@example.com", msgs[1].From)
+				assert.Equal(t, "from@example.com", msgs[2].From)
+				assert.Contains(t, msgs[2].Data, "From: \"Support\" <from@example.com>\r\n")
+			}
+
+			for _, from := range []string{"other@example.com", "not an address"} {
+				p := params
+				p.EmailFrom = from
+				err := svc.SendEmail(ctx, p)
+				assertServiceErrorCode(t, err, entity.ErrFromNotAllowedCode)
+			}
+			p := params
+			p.EmailFromName = "Support\r\nBcc: evil@example.com"
+			_, err = svc.SendEmailAsync(ctx, p)
+			assertServiceErrorCode(t, err, entity.ErrFromNotAllowedCode)
+
+			_, err = svc.SetSenderAllowList(ctx, entity.SetSenderAllowListParams{
+				ProjectID:   "p1",
+				TransportID: "tr1",
+				Emails:      []string{"not an address"},
+			})
+			assertServiceErrorCode(t, err, entity.ErrInvalidSenderAllowListCode)
+			_, err = svc.SetSenderAllowList(ctx, entity.SetSenderAllowListParams{
+				ProjectID:   "p1",
+				TransportID: "missing",
+			})
+			assertServiceErrorCode(t, err, entity.ErrTransportNotFoundCode)
+
+			got, err := svc.GetSenderAllowList(ctx, "p1", "tr1")
+			if err != nil {
+				t.Fatalf("svc.GetSenderAllowList failed: %+v", err)
+			}
+			assert.Equal(t, list.Emails, got.Emails)
+
+			if err := svc.DeleteSenderAllowList(ctx, "p1", "tr1"); err != nil {
+				t.Fatalf("svc.DeleteSenderAllowList failed: %+v", err)
+			}
+			_, err = svc.GetSenderAllowList(ctx, "p1", "tr1")
+			assertServiceErrorCode(t, err, entity.ErrSenderAllowListNotFoundCode)
+			_, err = svc.SendEmailAsync(ctx, params)
+			assertServiceErrorCode(t, err, entity.ErrFromNotAllowedCode)
+		})
+	}
+}
+
+func TestReplyTo(t *testing.T) {
+	srv := newFakeSMTPServer(t)
+	svc := newTestService(t)
+	setupQueueProject(t, svc, srv)
+	ctx := context.Background()
+
+	params := entity.SendEmailParams{
+		TemplateID:     "t1",
+		ProjectID:      "p1",
+		TransportID:    "tr1",
+		To:             []string{"to@example.com"},
+		TemplateParams: map[string]string{"name": "Andy"},
+	}
+	if _, err := svc.SendEmailAsync(ctx, params); err != nil {
+		t.Fatalf("svc.SendEmailAsync failed: %+v", err)
+	}
+	override := params
+	override.ReplyTo = []string{"Ticket 42 <ticket-42@Support.Example.com>"}
+	mq, err := svc.SendEmailAsync(ctx, override)
+	if err != nil {
+		t.Fatalf("svc.SendEmailAsync failed: %+v", err)
+	}
+	assert.Equal(t, []string{`"Ticket 42" <ticket-42@support.example.com>`}, mq.ReplyTo)
+	if _, err := svc.ProcessMailQueue(ctx); err != nil {
+		t.Fatalf("svc.ProcessMailQueue failed: %+v", err)
+	}
+	if err := svc.SendEmail(ctx, override); err != nil {
+		t.Fatalf("svc.SendEmail failed: %+v", err)
+	}
+
+	// the transport's reply-to address is used unless overridden
+	msgs := srv.Messages()
+	if assert.Len(t, msgs, 3) {
+		assert.Contains(t, msgs[0].Data, "Reply-To: reply@example.com\r\n")
+		for _, msg := range msgs[1:] {
+			assert.Contains(t, msg.Data, "Reply-To: \"Ticket 42\" <ticket-42@support.example.com>\r\n")
+			assert.NotContains(t, msg.Data, "reply@example.com")
+		}
+	}
+
+	override.ReplyTo = []string{"not an address"}
+	_, err = svc.SendEmailAsync(ctx, override)
+	assertServiceErrorCode(t, err, entity.ErrInvalidAddressCode)
+}
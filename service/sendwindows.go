@@ -0,0 +1,191 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/andyfusniak/squishy-mailer-lite/entity"
+	"github.com/andyfusniak/squishy-mailer-lite/internal/store"
+	"github.com/pkg/errors"
+)
+
+// SetSendWindow creates or replaces a daily delivery window for a project,
+// or for a single group within a project. For example, a window from
+// 08:00 to 22:00 in Europe/London stops marketing emails being delivered
+// overnight. Emails outside of their window remain queued until it opens.
+func (s *Service) SetSendWindow(ctx context.Context, params entity.SetSendWindowParams) (*entity.SendWindow, error) {
+	if _, err := parseSendWindow(params.StartTime, params.EndTime, params.Timezone); err != nil {
+		return nil, err
+	}
+
+	obj, err := s.store.SetSendWindow(ctx, store.SetSendWindow{
+		ProjectID: params.ProjectID,
+		GroupID:   params.GroupID,
+		StartTime: params.StartTime,
+		EndTime:   params.EndTime,
+		Timezone:  params.Timezone,
+	})
+	if err != nil {
+		if serr := serviceErrorFromStore(err); serr != nil {
+			return nil, serr
+		}
+		return nil, errors.Wrapf(err, "[service] store.SetSendWindow failed")
+	}
+	if err := checkProjectScope("send window", params.ProjectID, obj.ProjectID); err != nil {
+		return nil, err
+	}
+	return sendWindowFromStoreObject(obj), nil
+}
+
+// ListSendWindows lists the send windows for a project.
+func (s *Service) ListSendWindows(ctx context.Context, projectID string) ([]*entity.SendWindow, error) {
+	list, err := s.store.ListSendWindows(ctx, projectID)
+	if err != nil {
+		return nil, errors.Wrapf(err, "[service] store.ListSendWindows failed")
+	}
+
+	windows := make([]*entity.SendWindow, 0, len(list))
+	for _, obj := range list {
+		if err := checkProjectScope("send window", projectID, obj.ProjectID); err != nil {
+			return nil, err
+		}
+		windows = append(windows, sendWindowFromStoreObject(obj))
+	}
+	return windows, nil
+}
+
+// DeleteSendWindow deletes the send window for a project and group. Use an
+// empty groupID to delete the project wide window.
+func (s *Service) DeleteSendWindow(ctx context.Context, projectID, groupID string) error {
+	if err := s.store.DeleteSendWindow(ctx, projectID, groupID); err != nil {
+		if serr := serviceErrorFromStore(err); serr != nil {
+			return serr
+		}
+		return errors.Wrapf(err, "[service] store.DeleteSendWindow failed")
+	}
+	return nil
+}
+
+func sendWindowFromStoreObject(obj *store.SendWindow) *entity.SendWindow {
+	return &entity.SendWindow{
+		ProjectID:  obj.ProjectID,
+		GroupID:    obj.GroupID,
+		StartTime:  obj.StartTime,
+		EndTime:    obj.EndTime,
+		Timezone:   obj.Timezone,
+		CreatedAt:  entity.ISOTime(obj.CreatedAt),
+		ModifiedAt: entity.ISOTime(obj.ModifiedAt),
+	}
+}
+
+// sendWindow is a parsed send window with start and end times in minutes
+// after midnight.
+type sendWindow struct {
+	start, end int
+	loc        *time.Location
+}
+
+func parseSendWindow(start, end, timezone string) (*sendWindow, error) {
+	var w sendWindow
+	var err error
+	if w.start, err = parseClock(start); err != nil {
+		return nil, entity.NewServiceError(entity.ErrInvalidSendWindowCode, err)
+	}
+	if w.end, err = parseClock(end); err != nil {
+		return nil, entity.NewServiceError(entity.ErrInvalidSendWindowCode, err)
+	}
+	if w.loc, err = time.LoadLocation(timezone); err != nil {
+		return nil, entity.NewServiceError(entity.ErrInvalidSendWindowCode, err)
+	}
+	return &w, nil
+}
+
+// parseClock parses a 24 hour HH:MM time into minutes after midnight.
+func parseClock(hhmm string) (int, error) {
+	t, err := time.Parse("15:04", hhmm)
+	if err != nil {
+		return 0, fmt.Errorf("time %q must be in 24 hour HH:MM format", hhmm)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// nextOpen reports whether the window is open at now. If it is closed it
+// also returns the time the window next opens.
+func (w *sendWindow) nextOpen(now time.Time, loc *time.Location) (bool, time.Time) {
+	if loc == nil {
+		loc = w.loc
+	}
+	local := now.In(loc)
+	mins := local.Hour()*60 + local.Minute()
+	startToday := time.Date(local.Year(), local.Month(), local.Day(), w.start/60, w.start%60, 0, 0, loc)
+
+	switch {
+	case w.start == w.end:
+		// the window is open all day
+		return true, time.Time{}
+	case w.start < w.end:
+		if mins >= w.start && mins < w.end {
+			return true, time.Time{}
+		}
+		if mins < w.start {
+			return false, startToday
+		}
+		return false, startToday.AddDate(0, 0, 1)
+	default:
+		// the window spans midnight so it is closed between end and start
+		if mins >= w.start || mins < w.end {
+			return true, time.Time{}
+		}
+		return false, startToday
+	}
+}
+
+// sendWindowDeferral checks the send window that applies to a queued email.
+// If the window is closed it returns the time the window next opens and the
+// reason for the deferral. A zero time means the email can be sent now.
+func (s *Service) sendWindowDeferral(ctx context.Context, mq *store.MailQueue, now time.Time) (time.Time, string, error) {
+	windows, err := s.store.ListSendWindows(ctx, mq.ProjectID)
+	if err != nil {
+		return time.Time{}, "", errors.Wrapf(err, "[service] store.ListSendWindows failed")
+	}
+
+	// a group window takes precedence over the project wide window
+	var match *store.SendWindow
+	for _, w := range windows {
+		if w.GroupID == mq.Metadata.GroupID {
+			match = w
+			break
+		}
+		if w.GroupID == "" {
+			match = w
+		}
+	}
+	if match == nil {
+		return time.Time{}, "", nil
+	}
+
+	w, err := parseSendWindow(match.StartTime, match.EndTime, match.Timezone)
+	if err != nil {
+		return time.Time{}, "", err
+	}
+
+	// prefer the recipient's local time if it is known
+	var loc *time.Location
+	if mq.Metadata.Timezone != "" {
+		if l, err := time.LoadLocation(mq.Metadata.Timezone); err == nil {
+			loc = l
+		}
+	}
+
+	open, next := w.nextOpen(now, loc)
+	if open {
+		return time.Time{}, "", nil
+	}
+	tz := match.Timezone
+	if loc != nil {
+		tz = loc.String()
+	}
+	reason := fmt.Sprintf("outside send window %s-%s %s", match.StartTime, match.EndTime, tz)
+	return next.UTC(), reason, nil
+}
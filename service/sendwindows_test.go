@@ -0,0 +1,156 @@
+package service_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/andyfusniak/squishy-mailer-lite/entity"
+	"github.com/stretchr/testify/assert"
+)
+
+func clock(t time.Time) string {
+	return t.Format("15:04")
+}
+
+func TestSendWindowDefersDelivery(t *testing.T) {
+	srv := newFakeSMTPServer(t)
+	svc := newTestService(t)
+	setupQueueProject(t, svc, srv)
+
+	// a project wide window that opens in two hours time
+	ctx := context.Background()
+	now := time.Now().UTC().Truncate(time.Minute)
+	if _, err := svc.SetSendWindow(ctx, entity.SetSendWindowParams{
+		ProjectID: "p1",
+		StartTime: clock(now.Add(2 * time.Hour)),
+		EndTime:   clock(now.Add(3 * time.Hour)),
+		Timezone:  "UTC",
+	}); err != nil {
+		t.Fatalf("svc.SetSendWindow failed: %+v", err)
+	}
+
+	queued := queueTestEmail(t, svc)
+	n, err := svc.ProcessMailQueue(ctx)
+	if err != nil {
+		t.Fatalf("svc.ProcessMailQueue failed: %+v", err)
+	}
+	assert.Equal(t, 0, n)
+	assert.Empty(t, srv.Messages())
+
+	deferred, err := svc.GetMailQueue(ctx, "p1", queued.ID)
+	if err != nil {
+		t.Fatalf("svc.GetMailQueue failed: %+v", err)
+	}
+	assert.Equal(t, entity.MailStateQueued, deferred.State)
+	assert.Contains(t, deferred.DeferralReason, "outside send window")
+	assert.WithinDuration(t, now.Add(2*time.Hour), time.Time(deferred.NextAttemptAt), time.Minute)
+
+	// the deferred email is not claimed again before the window opens
+	n, err = svc.ProcessMailQueue(ctx)
+	if err != nil {
+		t.Fatalf("svc.ProcessMailQueue failed: %+v", err)
+	}
+	assert.Equal(t, 0, n)
+}
+
+func TestSendWindowGroupAndRecipientTimezone(t *testing.T) {
+	srv := newFakeSMTPServer(t)
+	svc := newTestService(t)
+	setupQueueProject(t, svc, srv)
+
+	ctx := context.Background()
+	now := time.Now().UTC().Truncate(time.Minute)
+
+	// the project wide window is closed but the group window is open
+	if _, err := svc.SetSendWindow(ctx, entity.SetSendWindowParams{
+		ProjectID: "p1",
+		StartTime: clock(now.Add(2 * time.Hour)),
+		EndTime:   clock(now.Add(3 * time.Hour)),
+		Timezone:  "UTC",
+	}); err != nil {
+		t.Fatalf("svc.SetSendWindow failed: %+v", err)
+	}
+	if _, err := svc.SetSendWindow(ctx, entity.SetSendWindowParams{
+		ProjectID: "p1",
+		GroupID:   "g1",
+		StartTime: clock(now.Add(-1 * time.Hour)),
+		EndTime:   clock(now.Add(1 * time.Hour)),
+		Timezone:  "UTC",
+	}); err != nil {
+		t.Fatalf("svc.SetSendWindow failed: %+v", err)
+	}
+
+	queueTestEmail(t, svc)
+	n, err := svc.ProcessMailQueue(ctx)
+	if err != nil {
+		t.Fatalf("svc.ProcessMailQueue failed: %+v", err)
+	}
+	assert.Equal(t, 1, n)
+
+	// a recipient 12 hours ahead of UTC is outside of the group window
+	mq, err := svc.SendEmailAsync(ctx, entity.SendEmailParams{
+		TemplateID:  "t1",
+		ProjectID:   "p1",
+		TransportID: "tr1",
+		To:          []string{"to@example.com"},
+		Subject:     "Welcome",
+		Timezone:    "Etc/GMT-12",
+	})
+	if err != nil {
+		t.Fatalf("svc.SendEmailAsync failed: %+v", err)
+	}
+	n, err = svc.ProcessMailQueue(ctx)
+	if err != nil {
+		t.Fatalf("svc.ProcessMailQueue failed: %+v", err)
+	}
+	assert.Equal(t, 0, n)
+
+	deferred, err := svc.GetMailQueue(ctx, "p1", mq.ID)
+	if err != nil {
+		t.Fatalf("svc.GetMailQueue failed: %+v", err)
+	}
+	assert.Contains(t, deferred.DeferralReason, "Etc/GMT-12")
+
+	windows, err := svc.ListSendWindows(ctx, "p1")
+	if err != nil {
+		t.Fatalf("svc.ListSendWindows failed: %+v", err)
+	}
+	if assert.Len(t, windows, 2) {
+		assert.Equal(t, "", windows[0].GroupID)
+		assert.Equal(t, "g1", windows[1].GroupID)
+	}
+
+	if err := svc.DeleteSendWindow(ctx, "p1", "g1"); err != nil {
+		t.Fatalf("svc.DeleteSendWindow failed: %+v", err)
+	}
+	err = svc.DeleteSendWindow(ctx, "p1", "g1")
+	assertServiceErrorCode(t, err, entity.ErrSendWindowNotFoundCode)
+}
+
+func TestSetSendWindowValidation(t *testing.T) {
+	svc := newTestService(t)
+
+	ctx := context.Background()
+	if _, err := svc.CreateProject(ctx, "p1", "Project One", ""); err != nil {
+		t.Fatalf("svc.CreateProject failed: %+v", err)
+	}
+
+	tests := []entity.SetSendWindowParams{
+		{ProjectID: "p1", StartTime: "8am", EndTime: "22:00", Timezone: "UTC"},
+		{ProjectID: "p1", StartTime: "08:00", EndTime: "25:00", Timezone: "UTC"},
+		{ProjectID: "p1", StartTime: "08:00", EndTime: "22:00", Timezone: "Mars/Olympus"},
+	}
+	for _, tt := range tests {
+		_, err := svc.SetSendWindow(ctx, tt)
+		assertServiceErrorCode(t, err, entity.ErrInvalidSendWindowCode)
+	}
+
+	_, err := svc.SetSendWindow(ctx, entity.SetSendWindowParams{
+		ProjectID: "non-existent-project",
+		StartTime: "08:00",
+		EndTime:   "22:00",
+		Timezone:  "UTC",
+	})
+	assertServiceErrorCode(t, err, entity.ErrProjectNotFoundCode)
+}
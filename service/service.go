@@ -16,6 +16,9 @@ package service
 // mailer.db in the current working directory as its data store by default.
 // The default store is a SQLite3 database that uses some sensible defaults
 // for the database connection pool.
+//
+// Alternatively the options can be read from a YAML or TOML config file
+// using NewEmailServiceFromConfig, see Config.
 
 // You can substitute the default store with your own store by implementing
 // the store.Repository interface. The service will use the store to persist
@@ -26,12 +29,17 @@ import (
 	"bytes"
 	"context"
 	"crypto/sha512"
-	"database/sql"
 	"encoding/hex"
 	"fmt"
 	"io"
+	"io/fs"
+	"log/slog"
+	"maps"
+	"net/http"
 	"os"
+	"slices"
 	"strings"
+	"sync"
 	"time"
 
 	htmltemplate "html/template"
@@ -40,20 +48,77 @@ import (
 	"github.com/andyfusniak/squishy-mailer-lite/entity"
 	"github.com/andyfusniak/squishy-mailer-lite/internal/email"
 	"github.com/andyfusniak/squishy-mailer-lite/internal/secrets"
-	"github.com/andyfusniak/squishy-mailer-lite/internal/store/sqlite3"
+	"github.com/andyfusniak/squishy-mailer-lite/internal/store/memory"
 
 	"github.com/andyfusniak/squishy-mailer-lite/internal/store"
 
+	"github.com/nicksnyder/go-i18n/v2/i18n"
 	"github.com/pkg/errors"
 )
 
 // Service is the email service.
 type Service struct {
-	store         store.Repository
-	encryptionKey []byte
-	isHexInvalid  bool
+	store          store.Repository
+	encryptionKey  []byte
+	isHexInvalid   bool
+	passphrase     string
+	keyWrapper     KeyWrapper
+	cipher         Cipher
+	retention      RetentionPolicy
+	retry          RetryPolicy
+	webhookRetry   RetryPolicy
+	webhookClient  *http.Client
+	idPolicy       *IDPolicy
+	assetBaseURL   string
+	unsubscribeURL string
+	trackingURL    string
+	strictParams   bool
+	plainParams    bool
+	mjmlCompiler   MJMLCompiler
+	concurrency    int
+	perTransport   int
+	claimLease     time.Duration
+	smtpDefaults   SMTPTransportDefaults
+	logger         *slog.Logger
+
+	fileWriter   io.Writer
+	fileWriterMu sync.Mutex
+
+	sandboxRecipients []string
+
+	mxResolver MXResolver
+	mxMu       sync.Mutex
+	mxCache    map[string]mxResult
+
+	cache  *readCache
+	parsed parseCache
+	events eventBroker
+
+	publisher     EventPublisher
+	publisherDone chan struct{}
+	stopPublisher context.CancelFunc
+
+	markdownLayout string
+
+	maintenance    MaintenancePolicy
+	maintenanceMu  sync.Mutex
+	lastCheckpoint time.Time
+	lastVacuum     time.Time
+	lastPrune      time.Time
+
+	archiver MailArchiver
+
+	// workMu guards closing and the registration of in-flight work with
+	// work. abort cancels the work once Shutdown gives up waiting.
+	workMu    sync.Mutex
+	closing   bool
+	work      sync.WaitGroup
+	abort     context.Context
+	abortWork context.CancelFunc
 
 	dbfilepath string
+	replicaDSN string
+	pragmas    Sqlite3Pragmas
 }
 
 // options
@@ -74,7 +139,7 @@ func WithStore(store store.Repository) Option {
 // WithEncryptionKey accepts a byte slice encryption key and sets the
 // encryption key to the specified value. The encryption key is used to
 // encrypt and decrypt sensitive data such as passwords. It must be 16 bytes
-// in length (128 bits).
+// (128 bits) for AES-128 or 32 bytes (256 bits) for AES-256.
 func WithEncryptionKey(encKey []byte) Option {
 	return func(s *Service) {
 		s.encryptionKey = encKey
@@ -84,7 +149,8 @@ func WithEncryptionKey(encKey []byte) Option {
 // WithHexEncodedEncryptionKey accepts a hex encoded encryption key as a
 // string. The encryption key is used to encrypt and decrypt sensitive data
 // such as passwords. It must be 32 characters in length, representing
-// 16 bytes (or 128 bits).
+// 16 bytes (or 128 bits), or 64 characters representing 32 bytes (or 256
+// bits).
 func WithHexEncodedEncryptionKey(encKey string) Option {
 	return func(s *Service) {
 		var err error
@@ -95,6 +161,51 @@ func WithHexEncodedEncryptionKey(encKey string) Option {
 	}
 }
 
+// WithPassphrase derives a 256 bit encryption key from passphrase, for
+// users who cannot generate a raw key. The key is derived using Argon2id
+// with a random salt generated when the store is first used and kept in
+// the store, so the same passphrase only gives the same key for the same
+// database. It cannot be combined with WithEncryptionKey or
+// WithHexEncodedEncryptionKey.
+func WithPassphrase(passphrase string) Option {
+	return func(s *Service) {
+		s.passphrase = passphrase
+	}
+}
+
+// Cipher is the cipher secrets such as transport passwords are encrypted
+// with.
+type Cipher string
+
+const (
+	// CipherAESGCM is AES-GCM, using AES-128 or AES-256 depending on the
+	// length of the encryption key. It is the default.
+	CipherAESGCM Cipher = "aes-gcm"
+
+	// CipherXChaCha20Poly1305 is XChaCha20-Poly1305, which is faster on
+	// hardware without AES instructions. It requires a 256 bit key.
+	CipherXChaCha20Poly1305 Cipher = "xchacha20-poly1305"
+)
+
+// WithCipher sets the cipher new secrets are encrypted with. Secrets
+// encrypted with either cipher are decrypted whatever the setting, so the
+// cipher can be changed without re-encrypting existing secrets.
+func WithCipher(c Cipher) Option {
+	return func(s *Service) {
+		s.cipher = c
+	}
+}
+
+// WithInMemoryStore uses an empty in-memory store in place of the default
+// SQLite3 store. Nothing is written to disk and the data is lost when the
+// service is closed. It is intended for tests and ephemeral use, and does
+// not require cgo.
+func WithInMemoryStore() Option {
+	return func(s *Service) {
+		s.store = memory.New()
+	}
+}
+
 // WithSqlite3DBFilepath accepts a string database file path and sets the
 // database file path to the specified value. The database file path is used
 // to persist and retrieve data from a database. If no database file path is
@@ -106,6 +217,209 @@ func WithSqlite3DBFilepath(dbfilepath string) Option {
 	}
 }
 
+// WithSqlite3ReadReplicaDSN accepts the DSN of a read replica of the
+// database, for example file:/replicas/mailer.db?mode=ro for a copy
+// maintained by a replication tool such as Litestream. Get and List
+// queries are routed to the replica and fall back to the primary database
+// if the replica fails. Writes always go to the primary. This option is
+// only used if no store is specified.
+func WithSqlite3ReadReplicaDSN(dsn string) Option {
+	return func(s *Service) {
+		s.replicaDSN = dsn
+	}
+}
+
+// Sqlite3Pragmas are the SQLite3 settings applied to every connection of
+// the default store. Empty fields keep the defaults of WAL journaling,
+// NORMAL synchronisation, a 10 second busy timeout and foreign keys on.
+type Sqlite3Pragmas struct {
+	// JournalMode is one of DELETE, TRUNCATE, PERSIST, MEMORY, WAL or OFF.
+	JournalMode string `yaml:"journal_mode" toml:"journal_mode"`
+
+	// Synchronous is one of OFF, NORMAL, FULL or EXTRA.
+	Synchronous string `yaml:"synchronous" toml:"synchronous"`
+
+	// BusyTimeout is how long a query waits for a lock held by another
+	// connection, such as the writer, before failing with SQLITE_BUSY.
+	BusyTimeout Duration `yaml:"busy_timeout" toml:"busy_timeout"`
+
+	// ForeignKeys is on or off.
+	ForeignKeys string `yaml:"foreign_keys" toml:"foreign_keys"`
+}
+
+// WithSqlite3Pragmas accepts the pragmas applied to every connection of
+// the default store, including the read replica. NewEmailService returns
+// an error if any of them is invalid. This option is only used if no
+// store is specified.
+func WithSqlite3Pragmas(p Sqlite3Pragmas) Option {
+	return func(s *Service) {
+		s.pragmas = p
+	}
+}
+
+// WithRetentionPolicy accepts a RetentionPolicy that controls how much of
+// a queued email's rendered body and template params are kept once the
+// email has been successfully delivered. By default everything is kept.
+func WithRetentionPolicy(policy RetentionPolicy) Option {
+	return func(s *Service) {
+		s.retention = policy
+	}
+}
+
+// WithRetryPolicy accepts a RetryPolicy that controls how emails whose
+// delivery fails are retried by ProcessMailQueue. By default failed emails
+// are not retried.
+func WithRetryPolicy(policy RetryPolicy) Option {
+	return func(s *Service) {
+		s.retry = policy
+	}
+}
+
+// WithWebhookRetryPolicy accepts a RetryPolicy that controls how webhook
+// deliveries that fail are retried by ProcessWebhookDeliveries. By default
+// a delivery is attempted up to 8 times with a backoff starting at one
+// minute and capped at one hour.
+func WithWebhookRetryPolicy(policy RetryPolicy) Option {
+	return func(s *Service) {
+		s.webhookRetry = policy
+	}
+}
+
+// WithIDPolicy accepts an IDPolicy that all ids passed to the Create and
+// Set methods must satisfy. If no policy is specified DefaultIDPolicy is
+// used. Pass the zero value IDPolicy{} to accept any non-empty id.
+func WithIDPolicy(policy IDPolicy) Option {
+	return func(s *Service) {
+		s.idPolicy = &policy
+	}
+}
+
+// WithAssetBaseURL accepts the base URL that assets are hosted below, for
+// example https://cdn.example.com/assets. Templates using AssetModeURL
+// link to {base}/{project id}/{asset id}. Hosting the assets is left to the
+// caller.
+func WithAssetBaseURL(baseURL string) Option {
+	return func(s *Service) {
+		s.assetBaseURL = strings.TrimSuffix(baseURL, "/")
+	}
+}
+
+// WithUnsubscribeURL accepts the URL that unsubscribe tokens are appended
+// to, for example https://mail.example.com/v1/unsubscribe when using the
+// route served by sqm serve. Emails sent with SendEmailParams.Unsubscribe
+// link to {url}/{token} in their List-Unsubscribe header.
+func WithUnsubscribeURL(url string) Option {
+	return func(s *Service) {
+		s.unsubscribeURL = strings.TrimSuffix(url, "/")
+	}
+}
+
+// WithTrackingURL accepts the URL that open tracking tokens are appended
+// to, for example https://mail.example.com/v1/open when using the route
+// served by sqm serve. Emails sent with SendEmailParams.TrackOpens load a
+// tracking pixel from {url}/{token}.
+func WithTrackingURL(url string) Option {
+	return func(s *Service) {
+		s.trackingURL = strings.TrimSuffix(url, "/")
+	}
+}
+
+// WithStrictTemplateParams makes every send fail if the template
+// references params that are missing from its TemplateParams, rather than
+// rendering them as "<no value>". Sends can opt in individually using
+// SendEmailParams.StrictParams.
+func WithStrictTemplateParams() Option {
+	return func(s *Service) {
+		s.strictParams = true
+	}
+}
+
+// WithPlainTextParams makes every send render the text body and subject
+// with the template params stripped of HTML and with their whitespace
+// collapsed, so that params written for the HTML body, or supplied by
+// users, do not put markup in the text part. Sends can opt in
+// individually using SendEmailParams.PlainTextParams.
+func WithPlainTextParams() Option {
+	return func(s *Service) {
+		s.plainParams = true
+	}
+}
+
+// WithWorkerConcurrency sets the number of emails ProcessMailQueue
+// delivers at the same time. By default emails are delivered one at a
+// time.
+func WithWorkerConcurrency(n int) Option {
+	return func(s *Service) {
+		s.concurrency = n
+	}
+}
+
+// WithTransportConcurrency sets the number of emails ProcessMailQueue
+// delivers at the same time through each transport, so that a large
+// backlog for one transport is drained in parallel without opening too
+// many connections to a single server. It may be combined with
+// WithWorkerConcurrency, which then caps the total across all transports.
+func WithTransportConcurrency(n int) Option {
+	return func(s *Service) {
+		s.perTransport = n
+	}
+}
+
+// WithClaimLease sets how long an email may stay in the sending state
+// before ProcessMailQueue claims it again, so that emails claimed by a
+// worker that crashed are not stuck. It should be longer than the longest
+// delivery. Emails that were delivered but could not be recorded as sent
+// are also claimed again, so they are delivered at least once. A negative lease never reclaims emails. The default is 15
+// minutes.
+func WithClaimLease(d time.Duration) Option {
+	return func(s *Service) {
+		s.claimLease = d
+	}
+}
+
+// SMTPTransportDefaults are applied by CreateSMTPTransport to the settings
+// left unset by the caller.
+type SMTPTransportDefaults struct {
+	// Port is used if no port is given.
+	Port int `yaml:"port" toml:"port"`
+
+	// TLSMode is used if no TLS mode is given. When set, callers cannot
+	// choose opportunistic STARTTLS.
+	TLSMode entity.SMTPTLSMode `yaml:"tls_mode" toml:"tls_mode"`
+
+	// ConnectTimeout and SendTimeout are used when sending through a
+	// transport without its own timeouts, see entity.SMTPTimeouts. They
+	// default to defaultConnectTimeout and defaultSendTimeout.
+	ConnectTimeout Duration `yaml:"connect_timeout" toml:"connect_timeout"`
+	SendTimeout    Duration `yaml:"send_timeout" toml:"send_timeout"`
+}
+
+// Default SMTP timeouts, see SMTPTransportDefaults.
+const (
+	defaultConnectTimeout = 30 * time.Second
+	defaultSendTimeout    = 2 * time.Minute
+)
+
+// WithSMTPTransportDefaults accepts the defaults applied to new SMTP
+// transports.
+func WithSMTPTransportDefaults(defaults SMTPTransportDefaults) Option {
+	return func(s *Service) {
+		s.smtpDefaults = defaults
+	}
+}
+
+// WithLogger sets the structured logger the service writes log events to.
+// The events of an email carry its project_id, transport_id and a send_id
+// that correlates them: the mail queue id for queued emails, or for emails
+// sent with SendEmail a random id that is also the local part of the
+// generated Message-ID. If the option is not given, or logger is nil,
+// nothing is logged.
+func WithLogger(logger *slog.Logger) Option {
+	return func(s *Service) {
+		s.logger = logger
+	}
+}
+
 // NewEmailService creates a new email service. The service is used to
 // create, retrieve and send emails using templates and transports.
 // The service uses a store to persist and retrieve data from a database.
@@ -121,80 +435,190 @@ func NewEmailService(opts ...Option) (*Service, error) {
 	for _, opt := range opts {
 		opt(s)
 	}
+	s.abort, s.abortWork = context.WithCancel(context.Background())
+	if s.logger == nil {
+		s.logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	}
 
 	// if no store was specified, use the default store
 	if s.store == nil {
-		rw, ro, err := defaultSqlite3DBs(s.dbfilepath)
+		var err error
+		s.store, err = s.defaultStore()
 		if err != nil {
-			return nil, errors.Wrapf(err, "[service] defaultSqlite3DBs failed")
+			return nil, err
 		}
-		s.store = sqlite3.NewStore(ro, rw)
 	}
 
-	// if no encryption key was specified we cannot continue
-	if s.encryptionKey == nil {
-		return nil, errors.New(
-			"[service] no encryption key specified use WithEncryptionKey or WithHexEncodedEncryptionKey options")
+	if s.webhookRetry.MaxAttempts == 0 {
+		s.webhookRetry = defaultWebhookRetryPolicy
+	}
+	if s.claimLease == 0 {
+		s.claimLease = defaultClaimLease
+	}
+	if s.webhookClient == nil {
+		s.webhookClient = &http.Client{Timeout: 30 * time.Second}
+	}
+
+	// if no id policy was specified, use the default policy
+	if s.idPolicy == nil {
+		s.idPolicy = &DefaultIDPolicy
 	}
 
 	// if the hex encoded encryption key is invalid we cannot continue
 	if s.isHexInvalid {
 		return nil, errors.New(
-			"[service] hex encoded encryption key is invalid - must be 32 characters [0-9a-f]")
+			"[service] hex encoded encryption key is invalid - must be 32 or 64 characters [0-9a-f]")
+	}
+	if s.encryptionKey != nil && !secrets.ValidKeyLength(len(s.encryptionKey)) {
+		return nil, errors.Errorf(
+			"[service] encryption key is %d bytes - must be 16 or 32 bytes", len(s.encryptionKey))
 	}
 
-	return s, nil
-}
-
-// Close closes the service and releases any resources.
-func (s *Service) Close() error {
-	return s.store.Close()
-}
-
-const (
-	defaultMaxOpenConns int    = 120
-	defaultMaxIdleConns int    = 20
-	defaultDBFilepath   string = "mailer.db"
-)
+	ctx := context.Background()
+	switch {
+	case s.passphrase != "":
+		// derive the encryption key from the passphrase
+		if s.encryptionKey != nil || s.keyWrapper != nil {
+			return nil, errors.New(
+				"[service] WithPassphrase cannot be combined with an encryption key or key wrapper")
+		}
+		var err error
+		s.encryptionKey, err = s.deriveEncryptionKey(ctx, s.passphrase)
+		if err != nil {
+			return nil, err
+		}
+	case s.keyWrapper != nil:
+		// unwrap the encryption key kept in the store, wrapping the given
+		// key on first use
+		var err error
+		s.encryptionKey, err = s.unwrapEncryptionKey(ctx, s.encryptionKey)
+		if err != nil {
+			return nil, err
+		}
+		if !secrets.ValidKeyLength(len(s.encryptionKey)) {
+			return nil, errors.Errorf(
+				"[service] unwrapped encryption key is %d bytes - must be 16 or 32 bytes", len(s.encryptionKey))
+		}
+	}
 
-func defaultSqlite3DBs(dbfilepath string) (ro, rw *sql.DB, err error) {
-	// if no database file path was specified use the default
-	if dbfilepath == "" {
-		dbfilepath = defaultDBFilepath
+	// if no encryption key was specified we cannot continue
+	if s.encryptionKey == nil {
+		return nil, errors.New(
+			"[service] no encryption key specified use WithEncryptionKey, WithHexEncodedEncryptionKey, WithPassphrase or WithKeyWrapper options")
 	}
 
-	// check if the database file exists
-	var shouldCreateDB bool
-	if _, err := os.Stat(dbfilepath); os.IsNotExist(err) {
-		shouldCreateDB = true
+	switch s.cipher {
+	case "":
+		s.cipher = CipherAESGCM
+	case CipherAESGCM:
+	case CipherXChaCha20Poly1305:
+		if len(s.encryptionKey) != 32 {
+			return nil, errors.Errorf("[service] cipher %s requires a 32 byte encryption key", s.cipher)
+		}
+	default:
+		return nil, errors.Errorf("[service] unknown cipher %q", s.cipher)
 	}
 
-	// set up two database connections; one read-only with high concurrency
-	// and one read-write for non-concurrent queries
-	ro, err = sqlite3.OpenDB(dbfilepath)
-	if err != nil {
-		return nil, nil, err
+	if err := s.checkSandboxRecipients(); err != nil {
+		return nil, err
 	}
-	ro.SetMaxOpenConns(defaultMaxOpenConns)
-	ro.SetMaxIdleConns(defaultMaxIdleConns)
-	ro.SetConnMaxIdleTime(5 * time.Minute)
 
-	rw, err = sqlite3.OpenDB(dbfilepath)
-	if err != nil {
-		return nil, nil, err
+	s.startPublisher()
+	return s, nil
+}
+
+// serviceErrorFromStore maps well known store errors to their service error
+// equivalents. It returns nil if the error has no service error mapping in
+// which case the caller should wrap and return the original error.
+func serviceErrorFromStore(err error) error {
+	if errors.Is(err, store.ErrTransportNotFound) {
+		return entity.NewServiceError(entity.ErrTransportNotFoundCode, err)
 	}
-	rw.SetMaxOpenConns(1)
-	rw.SetMaxIdleConns(1)
-	rw.SetConnMaxIdleTime(5 * time.Minute)
 
-	// if the database file did not exist, create the schema
-	if shouldCreateDB {
-		if err := sqlite3.CreateSqliteDBSchema(rw); err != nil {
-			return nil, nil, fmt.Errorf("[service] failed to create database schema: %w", err)
-		}
+	var storeErr *store.Error
+	if !errors.As(err, &storeErr) {
+		return nil
 	}
+	switch storeErr.Code {
+	case store.ErrProjectAlreadyExists:
+		return entity.NewServiceError(entity.ErrProjectAlreadyExistsCode, storeErr)
+	case store.ErrProjectNotFound:
+		return entity.NewServiceError(entity.ErrProjectNotFoundCode, storeErr)
+	case store.ErrGroupNotFound:
+		return entity.NewServiceError(entity.ErrGroupNotFoundCode, storeErr)
+	case store.ErrTemplateNotFound:
+		return entity.NewServiceError(entity.ErrTemplateNotFoundCode, storeErr)
+	case store.ErrMailQueueNotFound:
+		return entity.NewServiceError(entity.ErrMailQueueNotFoundCode, storeErr)
+	case store.ErrSendWindowNotFound:
+		return entity.NewServiceError(entity.ErrSendWindowNotFoundCode, storeErr)
+	case store.ErrRateLimitNotFound:
+		return entity.NewServiceError(entity.ErrRateLimitNotFoundCode, storeErr)
+	case store.ErrProjectQuotaNotFound:
+		return entity.NewServiceError(entity.ErrQuotaNotFoundCode, storeErr)
+	case store.ErrWebhookNotFound:
+		return entity.NewServiceError(entity.ErrWebhookNotFoundCode, storeErr)
+	case store.ErrWebhookAlreadyExists:
+		return entity.NewServiceError(entity.ErrWebhookAlreadyExistsCode, storeErr)
+	case store.ErrCampaignNotFound:
+		return entity.NewServiceError(entity.ErrCampaignNotFoundCode, storeErr)
+	case store.ErrCampaignAlreadyExists:
+		return entity.NewServiceError(entity.ErrCampaignAlreadyExistsCode, storeErr)
+	case store.ErrContactListNotFound:
+		return entity.NewServiceError(entity.ErrContactListNotFoundCode, storeErr)
+	case store.ErrContactListExists:
+		return entity.NewServiceError(entity.ErrContactListExistsCode, storeErr)
+	case store.ErrContactNotFound:
+		return entity.NewServiceError(entity.ErrContactNotFoundCode, storeErr)
+	case store.ErrBackupNotSupported:
+		return entity.NewServiceError(entity.ErrBackupNotSupportedCode, storeErr)
+	case store.ErrMigrationsNotSupported:
+		return entity.NewServiceError(entity.ErrMigrationsNotSupportedCode, storeErr)
+	case store.ErrSchemaDirty:
+		return entity.NewServiceError(entity.ErrSchemaDirtyCode, storeErr)
+	case store.ErrSchemaVersionNotFound:
+		return entity.NewServiceError(entity.ErrSchemaVersionNotFoundCode, storeErr)
+	case store.ErrSuppressionNotFound:
+		return entity.NewServiceError(entity.ErrSuppressionNotFoundCode, storeErr)
+	case store.ErrSenderAllowListNotFound:
+		return entity.NewServiceError(entity.ErrSenderAllowListNotFoundCode, storeErr)
+	case store.ErrCatalogNotFound:
+		return entity.NewServiceError(entity.ErrCatalogNotFoundCode, storeErr)
+	case store.ErrAPIKeyNotFound:
+		return entity.NewServiceError(entity.ErrAPIKeyNotFoundCode, storeErr)
+	case store.ErrAttachmentNotFound:
+		return entity.NewServiceError(entity.ErrAttachmentNotFoundCode, storeErr)
+	case store.ErrAssetNotFound:
+		return entity.NewServiceError(entity.ErrAssetNotFoundCode, storeErr)
+	case store.ErrStoreNotEmpty:
+		return entity.NewServiceError(entity.ErrStoreNotEmptyCode, storeErr)
+	case store.ErrTransportInUse:
+		return entity.NewServiceError(entity.ErrTransportInUseCode, storeErr)
+	case store.ErrPartialNotFound:
+		return entity.NewServiceError(entity.ErrPartialNotFoundCode, storeErr)
+	case store.ErrVariantNotFound:
+		return entity.NewServiceError(entity.ErrVariantNotFoundCode, storeErr)
+	case store.ErrFixtureNotFound:
+		return entity.NewServiceError(entity.ErrFixtureNotFoundCode, storeErr)
+	case store.ErrGroupNotEmpty:
+		return entity.NewServiceError(entity.ErrGroupNotEmptyCode, storeErr)
+	case store.ErrMailQueueState:
+		return entity.NewServiceError(entity.ErrMailQueueStateCode, storeErr)
+	}
+	return nil
+}
 
-	return ro, rw, nil
+// checkProjectScope asserts that an object returned by the store belongs to
+// the project the caller asked for. The store queries already join on the
+// project id, so this is a defense-in-depth check that guarantees an id
+// from one project can never be used to read or send using the resources
+// of another, even if a store implementation gets its scoping wrong.
+func checkProjectScope(kind, projectID, objProjectID string) error {
+	if projectID != objProjectID {
+		return entity.NewServiceError(entity.ErrProjectScopeViolationCode,
+			fmt.Errorf("%s belongs to project %q not %q", kind, objProjectID, projectID))
+	}
+	return nil
 }
 
 //
@@ -203,21 +627,24 @@ func defaultSqlite3DBs(dbfilepath string) (ro, rw *sql.DB, err error) {
 
 // CreateProject creates a new project.
 func (s *Service) CreateProject(ctx context.Context, id, name, description string) (*entity.Project, error) {
+	if err := s.idPolicy.validate("project", id); err != nil {
+		return nil, err
+	}
+
 	obj, err := s.store.InsertProject(ctx, store.AddProject{
 		ProjectID:   id,
 		ProjectName: name,
 		Description: description,
 	})
 	if err != nil {
-		var storeErr *store.Error
-		if errors.As(err, &storeErr) {
-			if storeErr.Code == store.ErrProjectAlreadyExists {
-				return nil, entity.NewServiceError(entity.ErrProjectAlreadyExistsCode, storeErr)
-			}
+		if serr := serviceErrorFromStore(err); serr != nil {
+			return nil, serr
 		}
-
 		return nil, errors.Wrapf(err, "[service] store.InsertProject failed")
 	}
+	if err := checkProjectScope("project", id, obj.ProjectID); err != nil {
+		return nil, err
+	}
 	return projectFromStoreObject(obj), nil
 }
 
@@ -225,24 +652,82 @@ func (s *Service) CreateProject(ctx context.Context, id, name, description strin
 func (s *Service) GetProject(ctx context.Context, id string) (*entity.Project, error) {
 	obj, err := s.store.GetProject(ctx, id)
 	if err != nil {
-		var storeErr *store.Error
-		if errors.As(err, &storeErr) {
-			if storeErr.Code == store.ErrProjectNotFound {
-				return nil, entity.NewServiceError(entity.ErrProjectNotFoundCode, storeErr)
-			}
+		if serr := serviceErrorFromStore(err); serr != nil {
+			return nil, serr
 		}
-
 		return nil, errors.Wrapf(err, "[service] store.GetProject failed")
 	}
+	if err := checkProjectScope("project", id, obj.ProjectID); err != nil {
+		return nil, err
+	}
+	return projectFromStoreObject(obj), nil
+}
+
+// ListProjects lists the projects ordered by id. Pages are fetched by
+// passing the id of the last project of the previous page as After.
+func (s *Service) ListProjects(ctx context.Context, params entity.ListProjectsParams) ([]*entity.Project, error) {
+	list, err := s.store.ListProjects(ctx, store.ListProjects{
+		Labels: params.Labels,
+		After:  params.After,
+		Limit:  params.Limit,
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "[service] store.ListProjects failed")
+	}
+
+	projects := make([]*entity.Project, 0, len(list))
+	for _, obj := range list {
+		projects = append(projects, projectFromStoreObject(obj))
+	}
+	return projects, nil
+}
+
+// UpdateProject sets the name and description of a project.
+func (s *Service) UpdateProject(ctx context.Context, id, name, description string) (*entity.Project, error) {
+	obj, err := s.store.UpdateProject(ctx, store.UpdateProject{
+		ProjectID:   id,
+		ProjectName: name,
+		Description: description,
+	})
+	if err != nil {
+		if serr := serviceErrorFromStore(err); serr != nil {
+			return nil, serr
+		}
+		return nil, errors.Wrapf(err, "[service] store.UpdateProject failed")
+	}
+	if err := checkProjectScope("project", id, obj.ProjectID); err != nil {
+		return nil, err
+	}
 	return projectFromStoreObject(obj), nil
 }
 
+// DeleteProject deletes a project along with its transports, groups,
+// templates, attachments, assets, send windows, message catalogs and
+// mail queue. The project id can then be reused.
+func (s *Service) DeleteProject(ctx context.Context, id string) error {
+	if err := s.store.DeleteProject(ctx, id); err != nil {
+		if serr := serviceErrorFromStore(err); serr != nil {
+			return serr
+		}
+		return errors.Wrapf(err, "[service] store.DeleteProject failed")
+	}
+	s.cache.invalidateProject(id)
+	s.parsed.invalidateTemplates(id)
+	return nil
+}
+
 func projectFromStoreObject(obj *store.Project) *entity.Project {
 	return &entity.Project{
-		ID:          obj.ProjectID,
-		Name:        obj.ProjectName,
-		Description: obj.Description,
-		CreatedAt:   entity.ISOTime(obj.CreatedAt),
+		ID:                      obj.ProjectID,
+		Name:                    obj.ProjectName,
+		Description:             obj.Description,
+		AllowedRecipientDomains: obj.AllowedRecipientDomains,
+		DefaultTransportID:      obj.DefaultTransportID,
+		DefaultGroupID:          obj.DefaultGroupID,
+		TransportChain:          obj.TransportChain,
+		Labels:                  obj.Labels,
+		Variables:               obj.Variables,
+		CreatedAt:               entity.ISOTime(obj.CreatedAt),
 	}
 }
 
@@ -254,18 +739,34 @@ func projectFromStoreObject(obj *store.Project) *entity.Project {
 // send emails. Transports are project specific. A project can have many
 // transports. Transport id's are unique within a project.
 func (s *Service) CreateSMTPTransport(ctx context.Context, params entity.CreateSMTPTransport) (*entity.SMTPTransport, error) {
-	// encrypt the plaintext password to a hex encoded ciphertext representation.
-	// The plaintext password is never stored in the store and the ciphertext
-	// is stored in its place.
-	mgr, err := secrets.New(secrets.AESGCMWithRandomNonce, s.encryptionKey)
-	if err != nil {
-		return nil, errors.Wrapf(err, "[service] secrets.New failed")
+	if err := s.idPolicy.validate("transport", params.ID); err != nil {
+		return nil, err
+	}
+	if params.Port == 0 {
+		params.Port = s.smtpDefaults.Port
 	}
-	nonce, ciphertext, err := mgr.EncryptHexEncode(params.Password)
+	if params.TLS.Mode == entity.SMTPTLSModeOpportunistic {
+		params.TLS.Mode = s.smtpDefaults.TLSMode
+	}
+	if err := validateSMTPTLS(params.TLS); err != nil {
+		return nil, err
+	}
+	if err := validateSMTPTimeouts(params.Timeouts); err != nil {
+		return nil, err
+	}
+	if err := s.checkTransportAddresses(ctx, params.EmailFrom, params.EmailReplyTo); err != nil {
+		return nil, err
+	}
+	if err := s.checkTransportIDFree(ctx, params.ProjectID, params.ID); err != nil {
+		return nil, err
+	}
+
+	// The plaintext password is never stored in the store and the
+	// ciphertext is stored in its place.
+	encryptedPassword, err := s.encryptSecret(params.Password)
 	if err != nil {
-		return nil, errors.Wrapf(err, "[service] mgr.EncryptHexEncode failed")
+		return nil, err
 	}
-	encryptedPassword := nonce + ciphertext
 
 	obj, err := s.store.InsertSMTPTransport(ctx, store.AddSMTPTransport{
 		SMTPTransportID: params.ID,
@@ -279,10 +780,23 @@ func (s *Service) CreateSMTPTransport(ctx context.Context, params entity.CreateS
 		EmailFrom:         params.EmailFrom,
 		EmailFromName:     params.EmailFromName,
 		EmailReplyTo:      store.JSONArray(params.EmailReplyTo),
+
+		TLSMode:               string(params.TLS.Mode),
+		TLSInsecureSkipVerify: params.TLS.InsecureSkipVerify,
+		TLSCABundle:           params.TLS.CABundle,
+
+		ConnectTimeoutMS: int(params.Timeouts.Connect.Milliseconds()),
+		SendTimeoutMS:    int(params.Timeouts.Send.Milliseconds()),
 	})
 	if err != nil {
+		if serr := serviceErrorFromStore(err); serr != nil {
+			return nil, serr
+		}
 		return nil, errors.Wrapf(err, "[service] store.InsertSMTPTransport failed")
 	}
+	if err := checkProjectScope("transport", params.ProjectID, obj.ProjectID); err != nil {
+		return nil, err
+	}
 	return smtpTransportFromStoreObject(obj), nil
 }
 
@@ -290,28 +804,180 @@ func (s *Service) CreateSMTPTransport(ctx context.Context, params entity.CreateS
 // Each transport is unique within a project so every transport must be
 // uniquely identified by its id and project id combination. If the
 // transport is not found an error is return with a code
-// of ErrSMTPTransportNotFound.
+// of ErrTransportNotFoundCode.
 func (s *Service) GetSMTPTransport(ctx context.Context, transportID, projectID string) (*entity.SMTPTransport, error) {
 	obj, err := s.store.GetSMTPTransport(ctx, transportID, projectID)
 	if err != nil {
+		if serr := serviceErrorFromStore(err); serr != nil {
+			return nil, serr
+		}
 		return nil, errors.Wrapf(err, "[service] store.GetSMTPTransport failed")
 	}
+	if err := checkProjectScope("transport", projectID, obj.ProjectID); err != nil {
+		return nil, err
+	}
+	return smtpTransportFromStoreObject(obj), nil
+}
+
+// UpdateSMTPTransport replaces the name, connection and sender settings of
+// an SMTP transport. The password is left unchanged; use
+// RotateSMTPTransportPassword to change it. If the transport is not found
+// an error is returned with a code of ErrTransportNotFoundCode.
+func (s *Service) UpdateSMTPTransport(ctx context.Context, params entity.UpdateSMTPTransportParams) (*entity.SMTPTransport, error) {
+	if params.Host == "" {
+		return nil, entity.NewServiceError(entity.ErrInvalidTransportCode,
+			errors.New("smtp host is required"))
+	}
+	if params.Port < 1 || params.Port > 65535 {
+		return nil, entity.NewServiceError(entity.ErrInvalidTransportCode,
+			errors.Errorf("smtp port %d is out of range", params.Port))
+	}
+	if err := validateSMTPTLS(params.TLS); err != nil {
+		return nil, err
+	}
+	if err := validateSMTPTimeouts(params.Timeouts); err != nil {
+		return nil, err
+	}
+	if err := s.checkTransportAddresses(ctx, params.EmailFrom, params.EmailReplyTo); err != nil {
+		return nil, err
+	}
+
+	obj, err := s.store.UpdateSMTPTransport(ctx, store.UpdateSMTPTransport{
+		SMTPTransportID: params.TransportID,
+		ProjectID:       params.ProjectID,
+		TransportName:   params.Name,
+		Host:            params.Host,
+		Port:            params.Port,
+		Username:        params.Username,
+		EmailFrom:       params.EmailFrom,
+		EmailFromName:   params.EmailFromName,
+		EmailReplyTo:    store.JSONArray(params.EmailReplyTo),
+
+		TLSMode:               string(params.TLS.Mode),
+		TLSInsecureSkipVerify: params.TLS.InsecureSkipVerify,
+		TLSCABundle:           params.TLS.CABundle,
+
+		ConnectTimeoutMS: int(params.Timeouts.Connect.Milliseconds()),
+		SendTimeoutMS:    int(params.Timeouts.Send.Milliseconds()),
+	})
+	if err != nil {
+		if serr := serviceErrorFromStore(err); serr != nil {
+			return nil, serr
+		}
+		return nil, errors.Wrapf(err, "[service] store.UpdateSMTPTransport failed")
+	}
+	if err := checkProjectScope("transport", params.ProjectID, obj.ProjectID); err != nil {
+		return nil, err
+	}
+	s.cache.invalidateTransport(params.ProjectID, params.TransportID)
+	return smtpTransportFromStoreObject(obj), nil
+}
+
+// RotateSMTPTransportPassword encrypts the new password and replaces the
+// transport's stored password. The password and modified time are updated
+// in a single store operation so a failed rotation leaves the old password
+// in place. If the transport is not found an error is returned with a code
+// of ErrTransportNotFoundCode.
+func (s *Service) RotateSMTPTransportPassword(ctx context.Context, transportID, projectID, password string) (*entity.SMTPTransport, error) {
+	encryptedPassword, err := s.encryptSecret(password)
+	if err != nil {
+		return nil, err
+	}
+
+	obj, err := s.store.SetSMTPTransportPassword(ctx, transportID, projectID, encryptedPassword)
+	if err != nil {
+		if serr := serviceErrorFromStore(err); serr != nil {
+			return nil, serr
+		}
+		return nil, errors.Wrapf(err, "[service] store.SetSMTPTransportPassword failed")
+	}
+	if err := checkProjectScope("transport", projectID, obj.ProjectID); err != nil {
+		return nil, err
+	}
+	s.cache.invalidateTransport(projectID, transportID)
 	return smtpTransportFromStoreObject(obj), nil
 }
 
+// DeleteSMTPTransport deletes an SMTP transport. If queued emails still
+// reference the transport an error is returned with a code of
+// ErrTransportInUseCode so the caller can confirm before retrying with
+// Force set, which marks those emails as failed. If the transport is the
+// project's default transport the default is cleared, and it is removed
+// from the project's transport chain. If the transport is not found an
+// error is returned with a code of ErrTransportNotFoundCode.
+func (s *Service) DeleteSMTPTransport(ctx context.Context, params entity.DeleteSMTPTransportParams) error {
+	if err := s.store.DeleteSMTPTransport(ctx, params.TransportID, params.ProjectID, params.Force); err != nil {
+		if serr := serviceErrorFromStore(err); serr != nil {
+			return serr
+		}
+		return errors.Wrapf(err, "[service] store.DeleteSMTPTransport failed")
+	}
+	s.cache.invalidateTransport(params.ProjectID, params.TransportID)
+	return nil
+}
+
+// validateSMTPTLS checks the TLS mode is known and the CA bundle, if any,
+// contains at least one certificate.
+func validateSMTPTLS(opts entity.SMTPTLSOptions) error {
+	if err := email.ValidateTLSOptions(email.TLSOptions{
+		Mode:     string(opts.Mode),
+		CABundle: opts.CABundle,
+	}); err != nil {
+		return entity.NewServiceError(entity.ErrInvalidTransportCode, err)
+	}
+	return nil
+}
+
+func validateSMTPTimeouts(t entity.SMTPTimeouts) error {
+	if t.Connect < 0 || t.Send < 0 {
+		return entity.NewServiceError(entity.ErrInvalidTransportCode,
+			errors.New("smtp timeouts must not be negative"))
+	}
+	return nil
+}
+
+// smtpTimeouts returns the timeouts of a transport, using the defaults in
+// place of those it does not set.
+func (s *Service) smtpTimeouts(t entity.SMTPTimeouts) entity.SMTPTimeouts {
+	if t.Connect == 0 {
+		t.Connect = time.Duration(s.smtpDefaults.ConnectTimeout)
+	}
+	if t.Connect == 0 {
+		t.Connect = defaultConnectTimeout
+	}
+	if t.Send == 0 {
+		t.Send = time.Duration(s.smtpDefaults.SendTimeout)
+	}
+	if t.Send == 0 {
+		t.Send = defaultSendTimeout
+	}
+	return t
+}
+
 func smtpTransportFromStoreObject(obj *store.SMTPTransport) *entity.SMTPTransport {
 	return &entity.SMTPTransport{
-		ID:            obj.SMTPTransportID,
-		ProjectID:     obj.ProjectID,
-		Name:          obj.TransportName,
-		Host:          obj.Host,
-		Port:          obj.Port,
-		Username:      obj.Username,
-		EmailFrom:     obj.EmailFrom,
-		EmailFromName: obj.EmailFromName,
-		EmailReplyTo:  obj.EmailReplyTo,
-		CreatedAt:     entity.ISOTime(obj.CreatedAt),
-		ModifiedAt:    entity.ISOTime(obj.ModifiedAt),
+		ID:              obj.SMTPTransportID,
+		ProjectID:       obj.ProjectID,
+		Name:            obj.TransportName,
+		Host:            obj.Host,
+		Port:            obj.Port,
+		Username:        obj.Username,
+		EmailFrom:       obj.EmailFrom,
+		EmailFromName:   obj.EmailFromName,
+		EmailReplyTo:    obj.EmailReplyTo,
+		WarmupSchedule:  obj.WarmupSchedule,
+		WarmupStartedAt: entity.ISOTime(obj.WarmupStartedAt),
+		CreatedAt:       entity.ISOTime(obj.CreatedAt),
+		ModifiedAt:      entity.ISOTime(obj.ModifiedAt),
+		TLS: entity.SMTPTLSOptions{
+			Mode:               entity.SMTPTLSMode(obj.TLSMode),
+			InsecureSkipVerify: obj.TLSInsecureSkipVerify,
+			CABundle:           obj.TLSCABundle,
+		},
+		Timeouts: entity.SMTPTimeouts{
+			Connect: time.Duration(obj.ConnectTimeoutMS) * time.Millisecond,
+			Send:    time.Duration(obj.SendTimeoutMS) * time.Millisecond,
+		},
 	}
 }
 
@@ -322,6 +988,10 @@ func smtpTransportFromStoreObject(obj *store.SMTPTransport) *entity.SMTPTranspor
 // CreateGroup creates a new group. A group is a collection of templates.
 // Group id's are unique within a project. A project can have many groups.
 func (s *Service) CreateGroup(ctx context.Context, id, projectID, name string) (*entity.Group, error) {
+	if err := s.idPolicy.validate("group", id); err != nil {
+		return nil, err
+	}
+
 	now := store.Datetime(time.Now().UTC())
 	obj, err := s.store.InsertGroup(ctx, store.AddGroup{
 		GroupID:    id,
@@ -331,68 +1001,312 @@ func (s *Service) CreateGroup(ctx context.Context, id, projectID, name string) (
 		ModifiedAt: now,
 	})
 	if err != nil {
+		if serr := serviceErrorFromStore(err); serr != nil {
+			return nil, serr
+		}
 		return nil, errors.Wrapf(err, "[service] store.InsertGroup failed")
 	}
+	if err := checkProjectScope("group", projectID, obj.ProjectID); err != nil {
+		return nil, err
+	}
 	return groupFromStoreObject(obj), nil
 }
 
-func groupFromStoreObject(obj *store.Group) *entity.Group {
-	return &entity.Group{
-		ID:         obj.GroupID,
-		ProjectID:  obj.ProjectID,
-		Name:       obj.GroupName,
-		CreatedAt:  entity.ISOTime(obj.CreatedAt),
-		ModifiedAt: entity.ISOTime(obj.ModifiedAt),
+// GetGroup retrieves a group by id.
+func (s *Service) GetGroup(ctx context.Context, projectID, groupID string) (*entity.Group, error) {
+	obj, err := s.store.GetGroup(ctx, projectID, groupID)
+	if err != nil {
+		if serr := serviceErrorFromStore(err); serr != nil {
+			return nil, serr
+		}
+		return nil, errors.Wrapf(err, "[service] store.GetGroup failed")
 	}
+	if err := checkProjectScope("group", projectID, obj.ProjectID); err != nil {
+		return nil, err
+	}
+	return groupFromStoreObject(obj), nil
 }
 
-//
-// templates
-//
+// ListGroups lists the groups of a project ordered by id. Pages are
+// fetched by passing the id of the last group of the previous page as
+// After.
+func (s *Service) ListGroups(ctx context.Context, params entity.ListGroupsParams) ([]*entity.Group, error) {
+	list, err := s.store.ListGroups(ctx, store.ListGroups{
+		ProjectID: params.ProjectID,
+		After:     params.After,
+		Limit:     params.Limit,
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "[service] store.ListGroups failed")
+	}
 
-// CreateTemplate creates a new template using text and HTML strings.
-// Template id's are unique within a project. A project can have many templates.
-// A template belongs to a group. A group can have many templates.
-func (s *Service) CreateTemplate(ctx context.Context, params entity.CreateTemplate) (*entity.Template, error) {
-	now := store.Datetime(time.Now().UTC())
-	obj, err := s.store.InsertTemplate(ctx, store.AddTemplate{
+	groups := make([]*entity.Group, 0, len(list))
+	for _, obj := range list {
+		if err := checkProjectScope("group", params.ProjectID, obj.ProjectID); err != nil {
+			return nil, err
+		}
+		groups = append(groups, groupFromStoreObject(obj))
+	}
+	return groups, nil
+}
+
+// UpdateGroup renames a group.
+func (s *Service) UpdateGroup(ctx context.Context, projectID, groupID, name string) (*entity.Group, error) {
+	obj, err := s.store.UpdateGroup(ctx, store.UpdateGroup{
+		GroupID:   groupID,
+		ProjectID: projectID,
+		GroupName: name,
+	})
+	if err != nil {
+		if serr := serviceErrorFromStore(err); serr != nil {
+			return nil, serr
+		}
+		return nil, errors.Wrapf(err, "[service] store.UpdateGroup failed")
+	}
+	if err := checkProjectScope("group", projectID, obj.ProjectID); err != nil {
+		return nil, err
+	}
+	return groupFromStoreObject(obj), nil
+}
+
+// DeleteGroup deletes a group along with its partials and send window. If
+// the group is the project's default group the default is cleared. A group
+// that still has templates is not deleted and an error with a code of
+// ErrGroupNotEmptyCode is returned, unless force is set in which case its
+// templates are deleted too. Queued emails that use the deleted templates
+// fail when they are sent.
+func (s *Service) DeleteGroup(ctx context.Context, projectID, groupID string, force bool) error {
+	if err := s.store.DeleteGroup(ctx, projectID, groupID, force); err != nil {
+		if serr := serviceErrorFromStore(err); serr != nil {
+			return serr
+		}
+		return errors.Wrapf(err, "[service] store.DeleteGroup failed")
+	}
+	s.cache.invalidateTemplates(projectID)
+	s.parsed.invalidateTemplates(projectID)
+	return nil
+}
+
+func groupFromStoreObject(obj *store.Group) *entity.Group {
+	return &entity.Group{
+		ID:         obj.GroupID,
+		ProjectID:  obj.ProjectID,
+		Name:       obj.GroupName,
+		CreatedAt:  entity.ISOTime(obj.CreatedAt),
+		ModifiedAt: entity.ISOTime(obj.ModifiedAt),
+	}
+}
+
+//
+// templates
+//
+
+// CreateTemplate creates a new template using text and HTML strings.
+// Template id's are unique within a project. A project can have many templates.
+// A template belongs to a group. A group can have many templates.
+// The SHA-512/224 digests of the bodies are computed if they are not given,
+// and digests that are given must match the computed ones.
+func (s *Service) CreateTemplate(ctx context.Context, params entity.CreateTemplate) (*entity.Template, error) {
+	if err := s.idPolicy.validate("template", params.ID); err != nil {
+		return nil, err
+	}
+	groupID, err := s.resolveGroupID(ctx, params.ProjectID, params.GroupID)
+	if err != nil {
+		return nil, err
+	}
+	sourceType, body, err := s.compileTemplateBody(ctx, params.SourceType, params.Source, templateBody{
+		text:       params.Text,
+		textDigest: params.TextDigest,
+		html:       params.HTML,
+		htmlDigest: params.HTMLDigest,
+	}, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	now := store.Datetime(time.Now().UTC())
+	obj, err := s.store.InsertTemplate(ctx, store.AddTemplate{
 		TemplateID: params.ID,
 		ProjectID:  params.ProjectID,
-		GroupID:    params.GroupID,
-		Txt:        params.Text,
-		TxtDigest:  params.TextDigest,
-		HTML:       params.HTML,
-		HTMLDigest: params.HTMLDigest,
+		GroupID:    groupID,
+		Txt:        body.text,
+		TxtDigest:  body.textDigest,
+		HTML:       body.html,
+		HTMLDigest: body.htmlDigest,
+		Subject:    params.Subject,
+		SourceType: string(sourceType),
+		Source:     params.Source,
 		CreatedAt:  now,
 		ModifiedAt: now,
 	})
 	if err != nil {
+		if serr := serviceErrorFromStore(err); serr != nil {
+			return nil, serr
+		}
 		return nil, errors.Wrapf(err, "[service] store.InsertTemplate failed")
 	}
+	if err := checkProjectScope("template", params.ProjectID, obj.ProjectID); err != nil {
+		return nil, err
+	}
 	return templateFromStoreObject(obj), nil
 }
 
-// the following function makes a template or updates the existing template if the digest has changed
+// SetTemplate creates a template from text and HTML strings, or updates
+// the existing template if its digests have changed, so templates can be
+// synced from code without writing them to files first. The digests are
+// computed from the bodies as by CreateTemplate and any given must match.
+// If params.ValidateFixtures is set the template must render with every
+// one of its fixtures.
 func (s *Service) SetTemplate(ctx context.Context, params entity.SetTemplateParams) (*entity.Template, error) {
+	if err := s.idPolicy.validate("template", params.ID); err != nil {
+		return nil, err
+	}
+	groupID, err := s.resolveGroupID(ctx, params.ProjectID, params.GroupID)
+	if err != nil {
+		return nil, err
+	}
+
+	// MJML is only compiled if the stored template's digest differs
+	var prev *store.Template
+	if params.SourceType == entity.TemplateSourceMJML {
+		prev, err = s.store.GetTemplate(ctx, params.ProjectID, params.ID)
+		var storeErr *store.Error
+		if errors.As(err, &storeErr) && storeErr.Code == store.ErrTemplateNotFound {
+			prev, err = nil, nil
+		}
+		if err != nil {
+			if serr := serviceErrorFromStore(err); serr != nil {
+				return nil, serr
+			}
+			return nil, errors.Wrapf(err, "[service] store.GetTemplate failed")
+		}
+	}
+	sourceType, body, err := s.compileTemplateBody(ctx, params.SourceType, params.Source, templateBody{
+		text:       params.Text,
+		textDigest: params.TextDigest,
+		html:       params.HTML,
+		htmlDigest: params.HTMLDigest,
+	}, prev)
+	if err != nil {
+		return nil, err
+	}
+	if params.ValidateFixtures {
+		if err := s.checkFixtures(ctx, params.ProjectID, params.ID, groupID, params.Subject, body); err != nil {
+			return nil, err
+		}
+	}
+
 	now := store.Datetime(time.Now().UTC())
 	tmplObj, err := s.store.SetTemplate(ctx, store.SetTemplateParams{
 		TemplateID: params.ID,
-		GroupID:    params.GroupID,
+		GroupID:    groupID,
 		ProjectID:  params.ProjectID,
-		Txt:        params.Text,
-		TxtDigest:  params.TextDigest,
-		HTML:       params.HTML,
-		HTMLDigest: params.HTMLDigest,
+		Txt:        body.text,
+		TxtDigest:  body.textDigest,
+		HTML:       body.html,
+		HTMLDigest: body.htmlDigest,
+		Subject:    params.Subject,
+		SourceType: string(sourceType),
+		Source:     params.Source,
 		CreatedAt:  now,
 		ModifiedAt: now,
 	})
 	if err != nil {
+		if serr := serviceErrorFromStore(err); serr != nil {
+			return nil, serr
+		}
 		return nil, errors.Wrapf(err, "[service] store.SetTemplate failed")
 	}
+	if err := checkProjectScope("template", params.ProjectID, tmplObj.ProjectID); err != nil {
+		return nil, err
+	}
+	s.cache.invalidateTemplate(params.ProjectID, params.ID, templateVersion(tmplObj))
 
 	return templateFromStoreObject(tmplObj), nil
 }
 
+// GetTemplate retrieves a template by id.
+func (s *Service) GetTemplate(ctx context.Context, projectID, templateID string) (*entity.Template, error) {
+	obj, err := s.store.GetTemplate(ctx, projectID, templateID)
+	if err != nil {
+		if serr := serviceErrorFromStore(err); serr != nil {
+			return nil, serr
+		}
+		return nil, errors.Wrapf(err, "[service] store.GetTemplate failed")
+	}
+	if err := checkProjectScope("template", projectID, obj.ProjectID); err != nil {
+		return nil, err
+	}
+	return templateFromStoreObject(obj), nil
+}
+
+// ListTemplates lists the templates of a project, or of one of its groups,
+// ordered by id. Pages are fetched by passing the id of the last template
+// of the previous page as After.
+func (s *Service) ListTemplates(ctx context.Context, params entity.ListTemplatesParams) ([]*entity.Template, error) {
+	list, err := s.store.ListTemplates(ctx, store.ListTemplates{
+		ProjectID: params.ProjectID,
+		GroupID:   params.GroupID,
+		Query:     params.Query,
+		Labels:    params.Labels,
+		After:     params.After,
+		Limit:     params.Limit,
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "[service] store.ListTemplates failed")
+	}
+
+	templates := make([]*entity.Template, 0, len(list))
+	for _, obj := range list {
+		if err := checkProjectScope("template", params.ProjectID, obj.ProjectID); err != nil {
+			return nil, err
+		}
+		templates = append(templates, templateFromStoreObject(obj))
+	}
+	return templates, nil
+}
+
+// SearchTemplates lists the templates of a project whose subject, text or
+// HTML contain query, ignoring case, for example a phrase or a template
+// param such as .first_name. The SQLite store uses an FTS5 index, and
+// lists the most relevant templates first, if it is built with the
+// sqlite_fts5 tag. Otherwise, and for queries shorter than three
+// characters, the templates are scanned and listed by id.
+func (s *Service) SearchTemplates(ctx context.Context, projectID, query string) ([]*entity.Template, error) {
+	if strings.TrimSpace(query) == "" {
+		return nil, entity.NewServiceError(entity.ErrInvalidSearchQueryCode,
+			fmt.Errorf("search query is empty"))
+	}
+
+	list, err := s.store.SearchTemplates(ctx, projectID, query)
+	if err != nil {
+		return nil, errors.Wrapf(err, "[service] store.SearchTemplates failed")
+	}
+
+	templates := make([]*entity.Template, 0, len(list))
+	for _, obj := range list {
+		if err := checkProjectScope("template", projectID, obj.ProjectID); err != nil {
+			return nil, err
+		}
+		templates = append(templates, templateFromStoreObject(obj))
+	}
+	return templates, nil
+}
+
+// DeleteTemplate deletes a template. Queued emails that use the template
+// fail when they are sent.
+func (s *Service) DeleteTemplate(ctx context.Context, projectID, templateID string) error {
+	if err := s.store.DeleteTemplate(ctx, projectID, templateID); err != nil {
+		if serr := serviceErrorFromStore(err); serr != nil {
+			return serr
+		}
+		return errors.Wrapf(err, "[service] store.DeleteTemplate failed")
+	}
+	s.cache.invalidateTemplate(projectID, templateID, "")
+	s.parsed.invalidateTemplate(projectID, templateID)
+	return nil
+}
+
 func templateFromStoreObject(obj *store.Template) *entity.Template {
 	return &entity.Template{
 		ID:         obj.TemplateID,
@@ -402,6 +1316,11 @@ func templateFromStoreObject(obj *store.Template) *entity.Template {
 		TextDigest: obj.TxtDigest,
 		HTML:       obj.HTML,
 		HTMLDigest: obj.HTMLDigest,
+		Subject:    obj.Subject,
+		SourceType: entity.TemplateSource(obj.SourceType),
+		Source:     obj.Source,
+		AssetMode:  entity.AssetMode(obj.AssetMode),
+		Labels:     obj.Labels,
 		CreatedAt:  entity.ISOTime(obj.CreatedAt),
 		ModifiedAt: entity.ISOTime(obj.ModifiedAt),
 	}
@@ -416,7 +1335,7 @@ const (
 
 func checkTemplates(mode templateType, filenames ...string) error {
 	if mode == txtTemplate {
-		tmpl, err := txttemplate.ParseFiles(filenames...)
+		tmpl, err := txttemplate.New("").Funcs(templateFuncs(nil, nil)).ParseFiles(filenames...)
 		if err != nil {
 			return errors.Wrapf(err, "[service] txt template.ParseFiles failed")
 		}
@@ -426,7 +1345,7 @@ func checkTemplates(mode templateType, filenames ...string) error {
 			return errors.Wrapf(err, "[service] txt tmpl.ExecuteTemplate failed")
 		}
 	} else {
-		tmpl, err := htmltemplate.ParseFiles(filenames...)
+		tmpl, err := htmltemplate.New("").Funcs(templateFuncs(nil, nil)).ParseFiles(filenames...)
 		if err != nil {
 			return errors.Wrapf(err, "[service] html template.ParseFiles failed")
 		}
@@ -440,6 +1359,15 @@ func checkTemplates(mode templateType, filenames ...string) error {
 	return nil
 }
 
+// contentDigest returns the hex encoded first 16 bytes of the SHA512 (224 bit)
+// hash of b. It is used for template and rendered email body digests.
+func contentDigest(b []byte) string {
+	hash := sha512.New512_224()
+	hash.Write(b)
+	sum := hash.Sum(nil)
+	return hex.EncodeToString(sum[0:16])
+}
+
 func amalgalateTemplates(filenames []string) ([]byte, error) {
 	// concat the filenames into a buffer
 	var buf bytes.Buffer
@@ -476,10 +1404,7 @@ func (s *Service) SetTemplateFromFiles(ctx context.Context, params entity.Create
 	}
 
 	// create a SHA512 (224 bit) hash of the text template amalgalated string
-	hash := sha512.New512_224()
-	hash.Write(txt)
-	sum := hash.Sum(nil)
-	txtCS := hex.EncodeToString(sum[0:16])
+	txtCS := contentDigest(txt)
 
 	// html templates
 	if err := checkTemplates(htmlTemplate, params.HTMLFilenames...); err != nil {
@@ -491,10 +1416,7 @@ func (s *Service) SetTemplateFromFiles(ctx context.Context, params entity.Create
 		return nil, errors.Wrapf(err, "[service] amalgalateTemplates html failed")
 	}
 	// create a SHA512 (224 bit) hash of the html template amalgalated string
-	hash = sha512.New512_224()
-	hash.Write(html)
-	sum = hash.Sum(nil)
-	htmlCS := hex.EncodeToString(sum[0:16])
+	htmlCS := contentDigest(html)
 
 	return s.SetTemplate(ctx, entity.SetTemplateParams{
 		ID:         params.ID,
@@ -507,6 +1429,109 @@ func (s *Service) SetTemplateFromFiles(ctx context.Context, params entity.Create
 	})
 }
 
+// SetTemplateFromFS is like SetTemplateFromFiles but reads the template
+// files from fsys, for example an embed.FS, so that templates compiled
+// into the binary can be synced at startup.
+func (s *Service) SetTemplateFromFS(ctx context.Context, fsys fs.FS, params entity.SetTemplateFromFSParams) (*entity.Template, error) {
+	// txt templates
+	txtFilenames, err := globFS(fsys, params.TxtPatterns)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkTemplatesFS(txtTemplate, fsys, txtFilenames...); err != nil {
+		return nil, errors.Wrapf(err, "[service] checkTemplatesFS txt failed")
+	}
+	txt, err := amalgalateTemplatesFS(fsys, txtFilenames)
+	if err != nil {
+		return nil, errors.Wrapf(err, "[service] amalgalateTemplatesFS txt failed")
+	}
+
+	// html templates
+	htmlFilenames, err := globFS(fsys, params.HTMLPatterns)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkTemplatesFS(htmlTemplate, fsys, htmlFilenames...); err != nil {
+		return nil, errors.Wrapf(err, "[service] checkTemplatesFS html failed")
+	}
+	html, err := amalgalateTemplatesFS(fsys, htmlFilenames)
+	if err != nil {
+		return nil, errors.Wrapf(err, "[service] amalgalateTemplatesFS html failed")
+	}
+
+	return s.SetTemplate(ctx, entity.SetTemplateParams{
+		ID:         params.ID,
+		ProjectID:  params.ProjectID,
+		GroupID:    params.GroupID,
+		Text:       string(txt),
+		TextDigest: contentDigest(txt),
+		HTML:       string(html),
+		HTMLDigest: contentDigest(html),
+	})
+}
+
+// globFS returns the files in fsys matching the patterns in the order of
+// the patterns. A file matched by more than one pattern is only included
+// once.
+func globFS(fsys fs.FS, patterns []string) ([]string, error) {
+	seen := make(map[string]bool)
+	var filenames []string
+	for _, pattern := range patterns {
+		matches, err := fs.Glob(fsys, pattern)
+		if err != nil {
+			return nil, errors.Wrapf(err, "[service] fs.Glob failed")
+		}
+		if len(matches) == 0 {
+			return nil, errors.Errorf("[service] pattern %q matches no files", pattern)
+		}
+		for _, name := range matches {
+			if !seen[name] {
+				seen[name] = true
+				filenames = append(filenames, name)
+			}
+		}
+	}
+	return filenames, nil
+}
+
+func checkTemplatesFS(mode templateType, fsys fs.FS, filenames ...string) error {
+	if mode == txtTemplate {
+		tmpl, err := txttemplate.New("").Funcs(templateFuncs(nil, nil)).ParseFS(fsys, filenames...)
+		if err != nil {
+			return errors.Wrapf(err, "[service] txt template.ParseFS failed")
+		}
+
+		// write the template to /dev/null to check for errors
+		if err := tmpl.ExecuteTemplate(io.Discard, "layout", nil); err != nil {
+			return errors.Wrapf(err, "[service] txt tmpl.ExecuteTemplate failed")
+		}
+	} else {
+		tmpl, err := htmltemplate.New("").Funcs(templateFuncs(nil, nil)).ParseFS(fsys, filenames...)
+		if err != nil {
+			return errors.Wrapf(err, "[service] html template.ParseFS failed")
+		}
+
+		// write the template to /dev/null to check for errors
+		if err := tmpl.ExecuteTemplate(io.Discard, "layout", nil); err != nil {
+			return errors.Wrapf(err, "[service] html tmpl.ExecuteTemplate failed")
+		}
+	}
+
+	return nil
+}
+
+func amalgalateTemplatesFS(fsys fs.FS, filenames []string) ([]byte, error) {
+	var buf bytes.Buffer
+	for _, f := range filenames {
+		content, err := fs.ReadFile(fsys, f)
+		if err != nil {
+			return nil, errors.Wrapf(err, "[service] fs.ReadFile failed")
+		}
+		buf.Write(content)
+	}
+	return buf.Bytes(), nil
+}
+
 // CreateTemplateFromFiles creates a new template from the specified files.
 func (s *Service) CreateTemplateFromFiles(ctx context.Context, params entity.CreateTemplateFromFiles) (*entity.Template, error) {
 	// txt templates
@@ -519,10 +1544,7 @@ func (s *Service) CreateTemplateFromFiles(ctx context.Context, params entity.Cre
 		return nil, errors.Wrapf(err, "[service] amalgalateTemplates txt failed")
 	}
 	// create a SHA512 (224 bit) hash of the text template amalgalated string
-	hash := sha512.New512_224()
-	hash.Write(txt)
-	sum := hash.Sum(nil)
-	txtCS := hex.EncodeToString(sum[0:16])
+	txtCS := contentDigest(txt)
 
 	// html templates
 	if err := checkTemplates(htmlTemplate, params.HTMLFilenames...); err != nil {
@@ -534,10 +1556,7 @@ func (s *Service) CreateTemplateFromFiles(ctx context.Context, params entity.Cre
 		return nil, errors.Wrapf(err, "[service] amalgalateTemplates html failed")
 	}
 	// create a SHA512 (224 bit) hash of the html template amalgalated string
-	hash = sha512.New512_224()
-	hash.Write(html)
-	sum = hash.Sum(nil)
-	htmlCS := hex.EncodeToString(sum[0:16])
+	htmlCS := contentDigest(html)
 
 	return s.CreateTemplate(ctx, entity.CreateTemplate{
 		ID:         params.ID,
@@ -550,64 +1569,471 @@ func (s *Service) CreateTemplateFromFiles(ctx context.Context, params entity.Cre
 	})
 }
 
-// SendEmail sends an email using the specified template.
+// SendEmail sends an email using the specified template. The email is
+// delivered immediately without using the mail queue, so send windows
+// are not applied. Unless params.MessageID is set the email is given a
+// generated Message-ID.
 func (s *Service) SendEmail(ctx context.Context, params entity.SendEmailParams) error {
-	// retrieve the template from the store
-	t, err := s.store.GetTemplate(ctx, params.ProjectID, params.TemplateID)
+	return s.sendEmail(ctx, params, newSendCache(s, false))
+}
+
+func (s *Service) sendEmail(ctx context.Context, params entity.SendEmailParams, c *sendCache) error {
+	if err := s.normaliseRecipients(ctx, &params); err != nil {
+		return err
+	}
+	blocked, err := s.blockedRecipients(ctx, params.ProjectID, params.To, params.Cc, params.Bcc)
+	if err != nil {
+		return err
+	}
+	if len(blocked) > 0 {
+		return errRecipientsBlocked(blocked)
+	}
+	suppressed, err := s.suppressedRecipients(ctx, params.ProjectID, params.To, params.Cc, params.Bcc)
 	if err != nil {
-		return errors.Wrapf(err, "[service] store.GetTemplate failed")
+		return err
+	}
+	if len(suppressed) > 0 {
+		return entity.NewServiceError(entity.ErrRecipientSuppressedCode,
+			errors.New(suppressedReason(suppressed)))
 	}
 
-	// parse the template string using go text/template
-	// and execute the template to produce the final email body
-	// and subject
-	textTmpl, err := txttemplate.New("layout").Parse(t.Txt)
+	r, err := c.render(ctx, params.ProjectID, params.TemplateID, params.Locale, params.TemplateParams,
+		params.StrictParams, params.PlainTextParams, true)
 	if err != nil {
-		return errors.Wrapf(err, "[service] txt template.New.Parse failed")
+		return err
 	}
-	var txt strings.Builder
-	if err := textTmpl.ExecuteTemplate(&txt, "layout", params.TemplateParams); err != nil {
-		return errors.Wrapf(err, "[service] txt tmpl.ExecuteTemplate failed")
+
+	transportID, err := s.resolveTransportID(ctx, params.ProjectID, params.TransportID)
+	if err != nil {
+		return err
 	}
 
-	htmlTmpl, err := htmltemplate.New("layout").Parse(t.HTML)
+	attachments, err := c.templateAttachments(ctx, params.ProjectID, params.TemplateID)
 	if err != nil {
-		return errors.Wrapf(err, "[service] html template.New.Parse failed")
+		return err
 	}
-	var html strings.Builder
-	if err := htmlTmpl.ExecuteTemplate(&html, "layout", params.TemplateParams); err != nil {
-		return errors.Wrapf(err, "[service] html tmpl.ExecuteTemplate failed")
+	extra, err := loadEmailAttachments(params.Attachments)
+	if err != nil {
+		return err
 	}
 
-	trObj, err := s.store.GetSMTPTransport(ctx, params.TransportID, params.ProjectID)
+	if err := s.checkUnsubscribe(params.Unsubscribe); err != nil {
+		return err
+	}
+	if params.TrackOpens {
+		return entity.NewServiceError(entity.ErrTrackOpensNotQueuedCode,
+			errors.New("use SendEmailAsync to track opens"))
+	}
+	headers, err := checkHeaders(params.Headers)
 	if err != nil {
-		return errors.Wrapf(err, "[service] store.GetSMTPTransport failed")
+		return err
+	}
+	if err := checkCalendarEvent(params.CalendarEvent); err != nil {
+		return err
+	}
+	th, err := checkThreading(params)
+	if err != nil {
+		return err
+	}
+	from, err := c.transportFrom(ctx, transportID, params.ProjectID)
+	if err != nil {
+		return err
+	}
+	emailFrom, err := s.checkFrom(ctx, params, transportID, from)
+	if err != nil {
+		return err
+	}
+	if emailFrom != "" {
+		from = emailFrom
+	}
+
+	// emails sent immediately cannot be deferred, so they are rejected
+	// once the project's quota is reached whatever its action
+	if _, _, err := s.reserveQuota(ctx, params.ProjectID, time.Time{}, false); err != nil {
+		return err
 	}
 
-	// decrypt the password
-	mgr, err := secrets.New(secrets.AESGCMWithRandomNonce, s.encryptionKey)
+	// sendID correlates the log events of the email, see WithLogger
+	sendID, err := newMailQueueID()
+	if err != nil {
+		return errors.Wrapf(err, "[service] newMailQueueID failed")
+	}
+	if th.messageID == "" {
+		th.messageID = newMessageID(sendID, from)
+	}
+	extra = append(extra, calendarAttachments(params.CalendarEvent, th.messageID, from,
+		slices.Concat(params.To, params.Cc), time.Now())...)
+	sender, err := c.sender(ctx, transportID, params.ProjectID)
 	if err != nil {
 		return err
 	}
-	pwPlaintext, err := mgr.HexDecodeDecrypt(trObj.EncryptedPassword[:24], trObj.EncryptedPassword[24:])
+
+	all := emailAttachments(attachments)
+	all = append(all, queuedAttachments(extra)...)
+	all = append(all, inlineAttachments(r.inline)...)
+	log := s.sendLogger(params.ProjectID, transportID, sendID)
+	startedAt := time.Now()
+	err = sender.SendEmail(ctx, s.sandbox(email.EmailParams{
+		Subject:     r.subjectOr(params.Subject),
+		Text:        r.txt,
+		HTML:        r.html,
+		WriteHTML:   r.writeHTML,
+		To:          params.To,
+		Cc:          params.Cc,
+		Bcc:         params.Bcc,
+		Attachments: all,
+
+		From:          emailFrom,
+		FromName:      params.EmailFromName,
+		ReplyTo:       params.ReplyTo,
+		MessageStream: params.MessageStream,
+		MessageID:     th.messageID,
+		Headers: mergeHeaders(headers, threadHeaders(th.inReplyTo, th.references),
+			s.unsubscribeHeaders(params.Unsubscribe, params.ProjectID, params.To)),
+	}))
 	if err != nil {
+		log.Warn("email send failed", "duration", time.Since(startedAt), "error", err)
 		return err
 	}
+	log.Debug("email sent", "template_id", params.TemplateID, "message_id", th.messageID,
+		"duration", time.Since(startedAt))
+	return nil
+}
 
-	awsTransport := email.NewAWSSMTPTransport(email.AWSConfig{
-		Host:     trObj.Host,
-		Port:     trObj.Port,
-		Username: trObj.Username,
-		Password: pwPlaintext,
-		From:     trObj.EmailFrom,
-		FromName: trObj.EmailFromName,
-		ReplyTo:  trObj.EmailReplyTo,
-	})
+// renderedEmail is the result of rendering a template.
+type renderedEmail struct {
+	tmpl    *store.Template
+	subject string
+	txt     string
+	html    string
 
-	return awsTransport.SendEmail(email.EmailParams{
-		Subject: params.Subject,
-		Text:    txt.String(),
-		HTML:    html.String(),
-		To:      params.To,
-	})
+	// writeHTML, if set, renders the HTML body in place of html
+	writeHTML func(w io.Writer) error
+
+	// inline are the assets to embed as inline attachments
+	inline []*store.Asset
+}
+
+// subjectOr returns subject, or the rendered default subject of the
+// template if subject is empty.
+func (r *renderedEmail) subjectOr(subject string) string {
+	if subject != "" {
+		return subject
+	}
+	return r.subject
+}
+
+// compiledTemplate is a template parsed ready to be executed. The parsed
+// templates are never executed directly so that executeTemplate can clone
+// them for each email.
+type compiledTemplate struct {
+	tmpl *store.Template
+
+	// version is the templateVersion of the stored template, before it
+	// was localized
+	version string
+
+	*parsedTemplate
+}
+
+// parsedTemplate holds the parsed text, HTML and subject templates. It is
+// shared by every compiledTemplate with the same content, see
+// parseCache.
+type parsedTemplate struct {
+	text *txttemplate.Template
+	html *htmltemplate.Template
+
+	// subject is nil if the template has no default subject
+	subject *txttemplate.Template
+
+	// params are the template params referenced by the templates
+	params []string
+
+	// streamHTML is set if the HTML cannot embed assets, so that it can
+	// be rendered straight into the message. Embedded assets must be
+	// known before the message is written.
+	streamHTML bool
+}
+
+// compileTemplate retrieves the template from the store and parses its
+// text and HTML templates, unless it is held by the cache. Parsing is
+// skipped if a template with the same content was parsed before.
+func (s *Service) compileTemplate(ctx context.Context, projectID, templateID, locale string) (*compiledTemplate, error) {
+	k := templateCacheKey{projectID, templateID, locale}
+	if c, ok := s.cache.template(k); ok {
+		return c, nil
+	}
+
+	// retrieve the template from the store
+	t, err := s.store.GetTemplate(ctx, projectID, templateID)
+	if err != nil {
+		if serr := serviceErrorFromStore(err); serr != nil {
+			return nil, serr
+		}
+		return nil, errors.Wrapf(err, "[service] store.GetTemplate failed")
+	}
+	if err := checkProjectScope("template", projectID, t.ProjectID); err != nil {
+		return nil, err
+	}
+	version := templateVersion(t)
+	if t, err = s.localizeTemplate(ctx, t, locale); err != nil {
+		return nil, err
+	}
+
+	partials, err := s.listPartials(ctx, projectID, t.GroupID)
+	if err != nil {
+		return nil, err
+	}
+
+	pk := parseCacheKey{projectID, templateID, parseDigest(t, partials)}
+	p, ok := s.parsed.get(pk)
+	if !ok {
+		if p, err = parseTemplate(t, partials); err != nil {
+			return nil, err
+		}
+		s.parsed.put(pk, p)
+	}
+	c := &compiledTemplate{
+		tmpl:           t,
+		version:        version,
+		parsedTemplate: p,
+	}
+	s.cache.putTemplate(k, c)
+	return c, nil
+}
+
+// parseTemplate parses the template strings using placeholder functions
+// which are replaced when each email is rendered. The group's partials
+// are parsed first so that the template can override them.
+func parseTemplate(t *store.Template, partials []*store.Partial) (*parsedTemplate, error) {
+	funcs := templateFuncs(nil, nil)
+	textTmpl := txttemplate.New("layout").Funcs(funcs)
+	htmlTmpl := htmltemplate.New("layout").Funcs(funcs)
+	if err := addPartials(textTmpl, htmlTmpl, partials); err != nil {
+		return nil, err
+	}
+	if _, err := textTmpl.Parse(t.Txt); err != nil {
+		return nil, errors.Wrapf(err, "[service] txt template.New.Parse failed")
+	}
+	if _, err := htmlTmpl.Parse(t.HTML); err != nil {
+		return nil, errors.Wrapf(err, "[service] html template.New.Parse failed")
+	}
+
+	// the subject is kept apart from the text templates so that it cannot
+	// clash with a template the text defines
+	var subjectTmpl *txttemplate.Template
+	if t.Subject != "" {
+		var err error
+		if subjectTmpl, err = txttemplate.New("subject").Funcs(funcs).Parse(t.Subject); err != nil {
+			return nil, errors.Wrapf(err, "[service] subject template.New.Parse failed")
+		}
+	}
+	streamHTML := t.HTML != "" && !embedsAssets(t.HTML)
+	for _, p := range partials {
+		if embedsAssets(p.HTML) {
+			streamHTML = false
+		}
+	}
+	return &parsedTemplate{
+		text:       textTmpl,
+		html:       htmlTmpl,
+		subject:    subjectTmpl,
+		params:     referencedParams(textTmpl, htmlTmpl, subjectTmpl),
+		streamHTML: streamHTML,
+	}, nil
+}
+
+// embedsAssets reports whether an HTML template may embed assets, using
+// the asset function or a cid: reference.
+func embedsAssets(html string) bool {
+	return strings.Contains(html, "asset") || strings.Contains(html, "cid:")
+}
+
+// executeTemplate executes a compiled template using the template params
+// to produce the final email bodies. If strict is set, params referenced
+// by the template must be present in the template params. If plain is set,
+// the text body and subject are executed with plainTextParams. If stream
+// is set and the template cannot embed assets, the HTML body is not
+// rendered here but as the email is written, so that a large body is
+// never held in memory. The project's variables are added to the params
+// as Project.
+func (s *Service) executeTemplate(ctx context.Context, c *compiledTemplate, localizer *i18n.Localizer, variables map[string]string, templateParams any, strict, plain, stream bool) (*renderedEmail, error) {
+	data, err := templateParamsMap(templateParams)
+	if err != nil {
+		return nil, err
+	}
+
+	if m, ok := withProjectVariables(data, variables); ok {
+		data, templateParams = m, m
+	}
+
+	// the text body and subject are executed with plain text params,
+	// including in translated messages, if plain is set
+	textParams, textData := templateParams, data
+	if plain && data != nil {
+		if textData, err = plainTextParams(data); err != nil {
+			return nil, err
+		}
+		textParams = textData
+	}
+
+	missingkey := "missingkey=default"
+	if strict {
+		ti := entity.TemplateInspection{
+			TemplateID: c.tmpl.TemplateID,
+			ProjectID:  c.tmpl.ProjectID,
+			Params:     c.params,
+		}
+		if err := ti.Validate(templateParams); err != nil {
+			return nil, err
+		}
+		missingkey = "missingkey=error"
+	}
+
+	funcs := templateFuncs(localizer, data)
+	textFuncs := templateFuncs(localizer, textData)
+	assets := s.newAssetRenderer(ctx, c.tmpl.ProjectID, c.tmpl.AssetMode)
+
+	textTmpl, err := c.text.Clone()
+	if err != nil {
+		return nil, errors.Wrapf(err, "[service] txt tmpl.Clone failed")
+	}
+	textTmpl.Funcs(textFuncs).Funcs(txttemplate.FuncMap{"asset": assets.text}).Option(missingkey)
+	var txt strings.Builder
+	if err := textTmpl.ExecuteTemplate(&txt, "layout", textParams); err != nil {
+		return nil, errors.Wrapf(err, "[service] txt tmpl.ExecuteTemplate failed")
+	}
+
+	htmlTmpl, err := c.html.Clone()
+	if err != nil {
+		return nil, errors.Wrapf(err, "[service] html tmpl.Clone failed")
+	}
+	htmlTmpl.Funcs(funcs).Funcs(htmltemplate.FuncMap{"asset": assets.html}).Option(missingkey)
+	writeHTML := func(w io.Writer) error {
+		if err := htmlTmpl.ExecuteTemplate(w, "layout", templateParams); err != nil {
+			return errors.Wrapf(err, "[service] html tmpl.ExecuteTemplate failed")
+		}
+		return nil
+	}
+	var htmlBody string
+	if !stream || !c.streamHTML {
+		var html strings.Builder
+		if err := writeHTML(&html); err != nil {
+			return nil, err
+		}
+		if htmlBody, err = assets.replaceCIDs(html.String()); err != nil {
+			return nil, err
+		}
+		writeHTML = nil
+	}
+
+	var subject strings.Builder
+	if c.subject != nil {
+		subjectTmpl, err := c.subject.Clone()
+		if err != nil {
+			return nil, errors.Wrapf(err, "[service] subject tmpl.Clone failed")
+		}
+		subjectTmpl.Funcs(textFuncs).Option(missingkey)
+		if err := subjectTmpl.Execute(&subject, textParams); err != nil {
+			return nil, errors.Wrapf(err, "[service] subject tmpl.Execute failed")
+		}
+	}
+
+	return &renderedEmail{
+		tmpl:      c.tmpl,
+		subject:   subject.String(),
+		txt:       txt.String(),
+		html:      htmlBody,
+		writeHTML: writeHTML,
+		inline:    assets.inline,
+	}, nil
+}
+
+// templateFuncs returns the functions available to email templates. The
+// t function translates a message id using the localizer. An optional
+// second argument is the plural count, available to the message as
+// {{.PluralCount}}. The template parameters are also available to the
+// message. If the localizer is nil, t returns the message id unchanged so
+// that templates can be checked without a catalog. The asset function is
+// a placeholder that is replaced by an assetRenderer when an email is
+// rendered.
+func templateFuncs(localizer *i18n.Localizer, params map[string]any) map[string]any {
+	return map[string]any{
+		"asset": func(id string) string { return id },
+		"t": func(id string, count ...any) (string, error) {
+			if localizer == nil {
+				return id, nil
+			}
+
+			data := make(map[string]any, len(params)+1)
+			maps.Copy(data, params)
+			lc := &i18n.LocalizeConfig{
+				MessageID:    id,
+				TemplateData: data,
+			}
+			if len(count) > 0 {
+				lc.PluralCount = count[0]
+				data["PluralCount"] = count[0]
+			}
+
+			msg, err := localizer.Localize(lc)
+			if err != nil {
+				// a message found in a fallback language is still used
+				var nf *i18n.MessageNotFoundErr
+				if errors.As(err, &nf) && msg != "" {
+					return msg, nil
+				}
+				return "", err
+			}
+			return msg, nil
+		},
+	}
+}
+
+// xchachaSecretPrefix starts the secrets encrypted with
+// XChaCha20-Poly1305. Those encrypted with AES-GCM are hex encoded without
+// a prefix.
+const xchachaSecretPrefix = "xc:"
+
+// encryptSecret encrypts a plaintext secret such as a transport password
+// or API key to its hex encoded nonce (12 bytes) followed by the hex
+// encoded AES GCM ciphertext. With CipherXChaCha20Poly1305 it is
+// xchachaSecretPrefix, the hex encoded nonce (24 bytes) and the hex encoded
+// XChaCha20-Poly1305 ciphertext.
+func (s *Service) encryptSecret(plaintext string) (string, error) {
+	mode, prefix := secrets.AESGCMWithRandomNonce, ""
+	if s.cipher == CipherXChaCha20Poly1305 {
+		mode, prefix = secrets.XChaCha20Poly1305WithRandomNonce, xchachaSecretPrefix
+	}
+	mgr, err := secrets.New(mode, s.encryptionKey)
+	if err != nil {
+		return "", errors.Wrapf(err, "[service] secrets.New failed")
+	}
+	nonce, ciphertext, err := mgr.EncryptHexEncode(plaintext)
+	if err != nil {
+		return "", errors.Wrapf(err, "[service] mgr.EncryptHexEncode failed")
+	}
+	return prefix + nonce + ciphertext, nil
+}
+
+// decryptSecret decrypts a secret encrypted by encryptSecret with either
+// cipher.
+func (s *Service) decryptSecret(encrypted string) (string, error) {
+	mode := secrets.AESGCMWithRandomNonce
+	if rest, ok := strings.CutPrefix(encrypted, xchachaSecretPrefix); ok {
+		mode, encrypted = secrets.XChaCha20Poly1305WithRandomNonce, rest
+	}
+	n := hex.EncodedLen(mode.NonceSize())
+	if len(encrypted) < n {
+		return "", errors.New("[service] encrypted secret is too short")
+	}
+	mgr, err := secrets.New(mode, s.encryptionKey)
+	if err != nil {
+		return "", errors.Wrapf(err, "[service] secrets.New failed")
+	}
+	plaintext, err := mgr.HexDecodeDecrypt(encrypted[:n], encrypted[n:])
+	if err != nil {
+		return "", errors.Wrapf(err, "[service] mgr.HexDecodeDecrypt failed")
+	}
+	return plaintext, nil
 }
@@ -0,0 +1,1016 @@
+package service_test
+
+import (
+	"context"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/andyfusniak/squishy-mailer-lite/entity"
+	"github.com/andyfusniak/squishy-mailer-lite/internal/store"
+	"github.com/andyfusniak/squishy-mailer-lite/internal/store/sqlite3"
+	"github.com/andyfusniak/squishy-mailer-lite/service"
+	"github.com/stretchr/testify/assert"
+)
+
+const testEncryptionKey = "a0bf305856098eba7e4bff506021648b"
+
+// newTestService creates a service backed by a sqlite3 database in a
+// temporary directory that is removed when the test completes.
+func newTestService(t *testing.T, opts ...service.Option) *service.Service {
+	t.Helper()
+
+	opts = append([]service.Option{
+		service.WithSqlite3DBFilepath(filepath.Join(t.TempDir(), "mailer.db")),
+		service.WithHexEncodedEncryptionKey(testEncryptionKey),
+	}, opts...)
+	svc, err := service.NewEmailService(opts...)
+	if err != nil {
+		t.Fatalf("service.NewEmailService failed: %+v", err)
+	}
+	t.Cleanup(func() { svc.Close() })
+	return svc
+}
+
+// assertServiceErrorCode asserts that err is a *entity.ServiceError with
+// the given code.
+func assertServiceErrorCode(t *testing.T, err error, code entity.ErrCode) {
+	t.Helper()
+
+	var serr *entity.ServiceError
+	if !errors.As(err, &serr) {
+		t.Fatalf("expected err to be of type *entity.ServiceError got %+v", err)
+	}
+	assert.Equal(t, code, serr.Code)
+}
+
+// setupTwoProjects creates projects pa and pb. Project pa is given a
+// transport, a group and a template. Project pb is left empty.
+func setupTwoProjects(t *testing.T, svc *service.Service) {
+	t.Helper()
+
+	ctx := context.Background()
+	for _, id := range []string{"pa", "pb"} {
+		if _, err := svc.CreateProject(ctx, id, "Project "+id, ""); err != nil {
+			t.Fatalf("svc.CreateProject failed: %+v", err)
+		}
+	}
+
+	if _, err := svc.CreateSMTPTransport(ctx, entity.CreateSMTPTransport{
+		ID:            "tr1",
+		ProjectID:     "pa",
+		Name:          "Transport One",
+		Host:          "localhost",
+		Port:          587,
+		Username:      "user",
+		Password:      "secret",
+		EmailFrom:     "from@example.com",
+		EmailFromName: "Example",
+		EmailReplyTo:  []string{"reply@example.com"},
+	}); err != nil {
+		t.Fatalf("svc.CreateSMTPTransport failed: %+v", err)
+	}
+
+	if _, err := svc.CreateGroup(ctx, "g1", "pa", "Group One"); err != nil {
+		t.Fatalf("svc.CreateGroup failed: %+v", err)
+	}
+
+	if _, err := svc.CreateTemplate(ctx, entity.CreateTemplate{
+		ID:        "t1",
+		ProjectID: "pa",
+		GroupID:   "g1",
+		Text:      `{{define "layout"}}Hello {{.name}}{{end}}`,
+		HTML:      `{{define "layout"}}<p>Hello {{.name}}</p>{{end}}`,
+	}); err != nil {
+		t.Fatalf("svc.CreateTemplate failed: %+v", err)
+	}
+}
+
+func TestCrossProjectGetSMTPTransport(t *testing.T) {
+	svc := newTestService(t)
+	setupTwoProjects(t, svc)
+
+	ctx := context.Background()
+	tr, err := svc.GetSMTPTransport(ctx, "tr1", "pb")
+	assert.Nil(t, tr)
+	assertServiceErrorCode(t, err, entity.ErrTransportNotFoundCode)
+}
+
+func TestCrossProjectCreateTemplate(t *testing.T) {
+	svc := newTestService(t)
+	setupTwoProjects(t, svc)
+
+	// group g1 belongs to project pa so it cannot be used in project pb
+	ctx := context.Background()
+	tmpl, err := svc.CreateTemplate(ctx, entity.CreateTemplate{
+		ID:        "t2",
+		ProjectID: "pb",
+		GroupID:   "g1",
+		Text:      `{{define "layout"}}text{{end}}`,
+		HTML:      `{{define "layout"}}html{{end}}`,
+	})
+	assert.Nil(t, tmpl)
+	assertServiceErrorCode(t, err, entity.ErrGroupNotFoundCode)
+}
+
+func TestCrossProjectSetTemplate(t *testing.T) {
+	svc := newTestService(t)
+	setupTwoProjects(t, svc)
+
+	ctx := context.Background()
+	tmpl, err := svc.SetTemplate(ctx, entity.SetTemplateParams{
+		ID:        "t1",
+		ProjectID: "pb",
+		GroupID:   "g1",
+		Text:      `{{define "layout"}}text{{end}}`,
+		HTML:      `{{define "layout"}}html{{end}}`,
+	})
+	assert.Nil(t, tmpl)
+	assertServiceErrorCode(t, err, entity.ErrGroupNotFoundCode)
+}
+
+func TestCrossProjectCreateGroup(t *testing.T) {
+	svc := newTestService(t)
+	setupTwoProjects(t, svc)
+
+	ctx := context.Background()
+	g, err := svc.CreateGroup(ctx, "g1", "non-existent-project", "Group One")
+	assert.Nil(t, g)
+	assertServiceErrorCode(t, err, entity.ErrProjectNotFoundCode)
+}
+
+func TestCrossProjectCreateSMTPTransport(t *testing.T) {
+	svc := newTestService(t)
+	setupTwoProjects(t, svc)
+
+	ctx := context.Background()
+	tr, err := svc.CreateSMTPTransport(ctx, entity.CreateSMTPTransport{
+		ID:        "tr1",
+		ProjectID: "non-existent-project",
+		Name:      "Transport One",
+		Host:      "localhost",
+		Port:      587,
+	})
+	assert.Nil(t, tr)
+	assertServiceErrorCode(t, err, entity.ErrProjectNotFoundCode)
+}
+
+func TestCrossProjectSendEmail(t *testing.T) {
+	svc := newTestService(t)
+	setupTwoProjects(t, svc)
+
+	ctx := context.Background()
+
+	// template t1 belongs to project pa
+	err := svc.SendEmail(ctx, entity.SendEmailParams{
+		TemplateID:  "t1",
+		ProjectID:   "pb",
+		TransportID: "tr1",
+		To:          []string{"to@example.com"},
+		Subject:     "subject",
+	})
+	assertServiceErrorCode(t, err, entity.ErrTemplateNotFoundCode)
+
+	// give project pb its own template so that only the transport
+	// lookup crosses the project boundary
+	if _, err := svc.CreateGroup(ctx, "g1", "pb", "Group One"); err != nil {
+		t.Fatalf("svc.CreateGroup failed: %+v", err)
+	}
+	if _, err := svc.CreateTemplate(ctx, entity.CreateTemplate{
+		ID:        "t1",
+		ProjectID: "pb",
+		GroupID:   "g1",
+		Text:      `{{define "layout"}}text{{end}}`,
+		HTML:      `{{define "layout"}}html{{end}}`,
+	}); err != nil {
+		t.Fatalf("svc.CreateTemplate failed: %+v", err)
+	}
+
+	err = svc.SendEmail(ctx, entity.SendEmailParams{
+		TemplateID:  "t1",
+		ProjectID:   "pb",
+		TransportID: "tr1",
+		To:          []string{"to@example.com"},
+		Subject:     "subject",
+	})
+	assertServiceErrorCode(t, err, entity.ErrTransportNotFoundCode)
+}
+
+// leakyStore is a faulty store.Repository that ignores the project id
+// scoping and returns objects from project pa regardless of the project
+// requested. It is used to check the service's defense-in-depth checks.
+type leakyStore struct {
+	store.Repository
+}
+
+func (s *leakyStore) GetProject(ctx context.Context, projectID string) (*store.Project, error) {
+	return s.Repository.GetProject(ctx, "pa")
+}
+
+func (s *leakyStore) GetSMTPTransport(ctx context.Context, transportID, projectID string) (*store.SMTPTransport, error) {
+	return s.Repository.GetSMTPTransport(ctx, transportID, "pa")
+}
+
+func (s *leakyStore) GetTemplate(ctx context.Context, projectID, templateID string) (*store.Template, error) {
+	return s.Repository.GetTemplate(ctx, "pa", templateID)
+}
+
+func TestProjectScopeViolation(t *testing.T) {
+	db, err := sqlite3.OpenDB(filepath.Join(t.TempDir(), "mailer.db"))
+	if err != nil {
+		t.Fatalf("sqlite3.OpenDB failed: %+v", err)
+	}
+	db.SetMaxOpenConns(1)
+	if err := sqlite3.CreateSqliteDBSchema(db); err != nil {
+		t.Fatalf("sqlite3.CreateSqliteDBSchema failed: %+v", err)
+	}
+	st := sqlite3.NewStore(db, db)
+	defer st.Close()
+
+	// populate the store using a well behaved service
+	base, err := service.NewEmailService(
+		service.WithStore(st),
+		service.WithHexEncodedEncryptionKey(testEncryptionKey),
+	)
+	if err != nil {
+		t.Fatalf("service.NewEmailService failed: %+v", err)
+	}
+	setupTwoProjects(t, base)
+
+	svc, err := service.NewEmailService(
+		service.WithStore(&leakyStore{Repository: st}),
+		service.WithHexEncodedEncryptionKey(testEncryptionKey),
+	)
+	if err != nil {
+		t.Fatalf("service.NewEmailService failed: %+v", err)
+	}
+
+	ctx := context.Background()
+	_, err = svc.GetProject(ctx, "pb")
+	assertServiceErrorCode(t, err, entity.ErrProjectScopeViolationCode)
+
+	_, err = svc.GetSMTPTransport(ctx, "tr1", "pb")
+	assertServiceErrorCode(t, err, entity.ErrProjectScopeViolationCode)
+
+	err = svc.SendEmail(ctx, entity.SendEmailParams{
+		TemplateID:  "t1",
+		ProjectID:   "pb",
+		TransportID: "tr1",
+		To:          []string{"to@example.com"},
+		Subject:     "subject",
+	})
+	assertServiceErrorCode(t, err, entity.ErrProjectScopeViolationCode)
+}
+
+func TestUpdateProject(t *testing.T) {
+	svc := newTestService(t)
+	setupTwoProjects(t, svc)
+
+	ctx := context.Background()
+	p, err := svc.UpdateProject(ctx, "pa", "Renamed", "New description")
+	if err != nil {
+		t.Fatalf("svc.UpdateProject failed: %+v", err)
+	}
+	assert.Equal(t, "Renamed", p.Name)
+	assert.Equal(t, "New description", p.Description)
+
+	p, err = svc.GetProject(ctx, "pa")
+	if err != nil {
+		t.Fatalf("svc.GetProject failed: %+v", err)
+	}
+	assert.Equal(t, "Renamed", p.Name)
+
+	_, err = svc.UpdateProject(ctx, "non-existent-project", "Name", "")
+	assertServiceErrorCode(t, err, entity.ErrProjectNotFoundCode)
+}
+
+func TestListProjects(t *testing.T) {
+	stores := []struct {
+		name string
+		opts []service.Option
+	}{
+		{"sqlite3", nil},
+		{"memory", []service.Option{service.WithInMemoryStore()}},
+	}
+	for _, st := range stores {
+		t.Run(st.name, func(t *testing.T) {
+			svc := newTestService(t, st.opts...)
+			setupTwoProjects(t, svc)
+
+			ctx := context.Background()
+			projects, err := svc.ListProjects(ctx, entity.ListProjectsParams{})
+			if err != nil {
+				t.Fatalf("svc.ListProjects failed: %+v", err)
+			}
+			if assert.Len(t, projects, 2) {
+				assert.Equal(t, "pa", projects[0].ID)
+				assert.Equal(t, "pb", projects[1].ID)
+			}
+
+			projects, err = svc.ListProjects(ctx, entity.ListProjectsParams{After: "pa", Limit: 1})
+			if err != nil {
+				t.Fatalf("svc.ListProjects failed: %+v", err)
+			}
+			if assert.Len(t, projects, 1) {
+				assert.Equal(t, "pb", projects[0].ID)
+			}
+		})
+	}
+}
+
+func TestDeleteProject(t *testing.T) {
+	svc := newTestService(t)
+	setupTwoProjects(t, svc)
+
+	ctx := context.Background()
+	if _, err := svc.SetAttachment(ctx, entity.SetAttachmentParams{
+		ID:        "terms",
+		ProjectID: "pa",
+		Filename:  "terms.txt",
+		Content:   []byte("terms"),
+	}); err != nil {
+		t.Fatalf("svc.SetAttachment failed: %+v", err)
+	}
+	if err := svc.SetTemplateAttachments(ctx, "pa", "t1", []string{"terms"}); err != nil {
+		t.Fatalf("svc.SetTemplateAttachments failed: %+v", err)
+	}
+	mq, err := svc.SendEmailAsync(ctx, entity.SendEmailParams{
+		TemplateID:     "t1",
+		ProjectID:      "pa",
+		TransportID:    "tr1",
+		To:             []string{"to@example.com"},
+		Subject:        "subject",
+		TemplateParams: map[string]string{"name": "Andy"},
+	})
+	if err != nil {
+		t.Fatalf("svc.SendEmailAsync failed: %+v", err)
+	}
+
+	if err := svc.DeleteProject(ctx, "pa"); err != nil {
+		t.Fatalf("svc.DeleteProject failed: %+v", err)
+	}
+
+	_, err = svc.GetProject(ctx, "pa")
+	assertServiceErrorCode(t, err, entity.ErrProjectNotFoundCode)
+	_, err = svc.GetMailQueue(ctx, "pa", mq.ID)
+	assertServiceErrorCode(t, err, entity.ErrMailQueueNotFoundCode)
+
+	// project pb is untouched
+	if _, err := svc.GetProject(ctx, "pb"); err != nil {
+		t.Fatalf("svc.GetProject failed: %+v", err)
+	}
+
+	// the project and group ids can be reused
+	if _, err := svc.CreateProject(ctx, "pa", "Project pa", ""); err != nil {
+		t.Fatalf("svc.CreateProject failed: %+v", err)
+	}
+	if _, err := svc.CreateGroup(ctx, "g1", "pa", "Group One"); err != nil {
+		t.Fatalf("svc.CreateGroup failed: %+v", err)
+	}
+
+	err = svc.DeleteProject(ctx, "non-existent-project")
+	assertServiceErrorCode(t, err, entity.ErrProjectNotFoundCode)
+}
+
+func TestUpdateSMTPTransport(t *testing.T) {
+	srv := newFakeSMTPServer(t)
+	svc := newTestService(t)
+	setupQueueProject(t, svc, srv)
+
+	ctx := context.Background()
+	before, err := svc.GetSMTPTransport(ctx, "tr1", "p1")
+	if err != nil {
+		t.Fatalf("svc.GetSMTPTransport failed: %+v", err)
+	}
+
+	tr, err := svc.UpdateSMTPTransport(ctx, entity.UpdateSMTPTransportParams{
+		TransportID:   "tr1",
+		ProjectID:     "p1",
+		Name:          "Renamed",
+		Host:          srv.Host(),
+		Port:          srv.Port(),
+		Username:      "user",
+		EmailFrom:     "updated@example.com",
+		EmailFromName: "Updated",
+		EmailReplyTo:  []string{"support@example.com"},
+	})
+	if err != nil {
+		t.Fatalf("svc.UpdateSMTPTransport failed: %+v", err)
+	}
+	assert.Equal(t, "Renamed", tr.Name)
+	assert.Equal(t, "updated@example.com", tr.EmailFrom)
+	assert.Equal(t, []string{"support@example.com"}, tr.EmailReplyTo)
+	assert.Equal(t, before.CreatedAt, tr.CreatedAt)
+
+	// the password is unchanged and the new sender is used
+	queueTestEmail(t, svc)
+	if _, err := svc.ProcessMailQueue(ctx); err != nil {
+		t.Fatalf("svc.ProcessMailQueue failed: %+v", err)
+	}
+	msgs := srv.Messages()
+	if assert.Len(t, msgs, 1) {
+		assert.Equal(t, "updated@example.com", msgs[0].From)
+		assert.Equal(t, "secret", msgs[0].Password)
+	}
+
+	tests := []struct {
+		name        string
+		transportID string
+		host        string
+		port        int
+		code        entity.ErrCode
+	}{
+		{"missing host", "tr1", "", 25, entity.ErrInvalidTransportCode},
+		{"bad port", "tr1", "smtp.example.com", 0, entity.ErrInvalidTransportCode},
+		{"not found", "tr2", "smtp.example.com", 25, entity.ErrTransportNotFoundCode},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := svc.UpdateSMTPTransport(ctx, entity.UpdateSMTPTransportParams{
+				TransportID: tc.transportID,
+				ProjectID:   "p1",
+				Host:        tc.host,
+				Port:        tc.port,
+			})
+			assertServiceErrorCode(t, err, tc.code)
+		})
+	}
+}
+
+func TestRotateSMTPTransportPassword(t *testing.T) {
+	srv := newFakeSMTPServer(t)
+	svc := newTestService(t)
+	setupQueueProject(t, svc, srv)
+
+	ctx := context.Background()
+	before, err := svc.GetSMTPTransport(ctx, "tr1", "p1")
+	if err != nil {
+		t.Fatalf("svc.GetSMTPTransport failed: %+v", err)
+	}
+
+	tr, err := svc.RotateSMTPTransportPassword(ctx, "tr1", "p1", "rotated")
+	if err != nil {
+		t.Fatalf("svc.RotateSMTPTransportPassword failed: %+v", err)
+	}
+	assert.True(t, time.Time(tr.ModifiedAt).After(time.Time(before.ModifiedAt)))
+	assert.Equal(t, before.Host, tr.Host)
+
+	queueTestEmail(t, svc)
+	if _, err := svc.ProcessMailQueue(ctx); err != nil {
+		t.Fatalf("svc.ProcessMailQueue failed: %+v", err)
+	}
+	msgs := srv.Messages()
+	if assert.Len(t, msgs, 1) {
+		assert.Equal(t, "rotated", msgs[0].Password)
+	}
+
+	_, err = svc.RotateSMTPTransportPassword(ctx, "tr2", "p1", "rotated")
+	assertServiceErrorCode(t, err, entity.ErrTransportNotFoundCode)
+}
+
+func TestDeleteSMTPTransport(t *testing.T) {
+	stores := []struct {
+		name string
+		opts []service.Option
+	}{
+		{"sqlite3", nil},
+		{"memory", []service.Option{service.WithInMemoryStore()}},
+	}
+	for _, st := range stores {
+		t.Run(st.name, func(t *testing.T) {
+			srv := newFakeSMTPServer(t)
+			svc := newTestService(t, st.opts...)
+			setupQueueProject(t, svc, srv)
+
+			ctx := context.Background()
+			if _, err := svc.SetDefaultTransport(ctx, "p1", "tr1"); err != nil {
+				t.Fatalf("svc.SetDefaultTransport failed: %+v", err)
+			}
+			mq := queueTestEmail(t, svc)
+
+			// a queued email references the transport
+			params := entity.DeleteSMTPTransportParams{TransportID: "tr1", ProjectID: "p1"}
+			err := svc.DeleteSMTPTransport(ctx, params)
+			assertServiceErrorCode(t, err, entity.ErrTransportInUseCode)
+			if _, err := svc.GetSMTPTransport(ctx, "tr1", "p1"); err != nil {
+				t.Fatalf("svc.GetSMTPTransport failed: %+v", err)
+			}
+
+			params.Force = true
+			if err := svc.DeleteSMTPTransport(ctx, params); err != nil {
+				t.Fatalf("svc.DeleteSMTPTransport failed: %+v", err)
+			}
+			_, err = svc.GetSMTPTransport(ctx, "tr1", "p1")
+			assertServiceErrorCode(t, err, entity.ErrTransportNotFoundCode)
+
+			got, err := svc.GetMailQueue(ctx, "p1", mq.ID)
+			if err != nil {
+				t.Fatalf("svc.GetMailQueue failed: %+v", err)
+			}
+			assert.Equal(t, entity.MailStateFailed, got.State)
+			assert.Equal(t, "transport deleted", got.LastError)
+
+			p, err := svc.GetProject(ctx, "p1")
+			if err != nil {
+				t.Fatalf("svc.GetProject failed: %+v", err)
+			}
+			assert.Empty(t, p.DefaultTransportID)
+
+			err = svc.DeleteSMTPTransport(ctx, params)
+			assertServiceErrorCode(t, err, entity.ErrTransportNotFoundCode)
+		})
+	}
+}
+
+func TestSMTPTransportTLS(t *testing.T) {
+	implicitSrv, caPEM := newFakeSMTPServerTLS(t, true)
+	startTLSSrv, _ := newFakeSMTPServerTLS(t, false)
+	plainSrv := newFakeSMTPServer(t)
+	svc := newTestService(t)
+	setupQueueProject(t, svc, plainSrv)
+
+	tests := []struct {
+		name    string
+		srv     *fakeSMTPServer
+		tls     entity.SMTPTLSOptions
+		wantErr bool
+	}{
+		{"implicit tls with ca bundle", implicitSrv,
+			entity.SMTPTLSOptions{Mode: entity.SMTPTLSModeTLS, CABundle: caPEM}, false},
+		{"implicit tls with untrusted certificate", implicitSrv,
+			entity.SMTPTLSOptions{Mode: entity.SMTPTLSModeTLS}, true},
+		{"implicit tls skipping verification", implicitSrv,
+			entity.SMTPTLSOptions{Mode: entity.SMTPTLSModeTLS, InsecureSkipVerify: true}, false},
+		{"starttls with ca bundle", startTLSSrv,
+			entity.SMTPTLSOptions{Mode: entity.SMTPTLSModeStartTLS, CABundle: caPEM}, false},
+		{"opportunistic upgrades when offered", startTLSSrv,
+			entity.SMTPTLSOptions{CABundle: caPEM}, false},
+		{"starttls required but not offered", plainSrv,
+			entity.SMTPTLSOptions{Mode: entity.SMTPTLSModeStartTLS}, true},
+		{"none never upgrades", startTLSSrv,
+			entity.SMTPTLSOptions{Mode: entity.SMTPTLSModeNone}, false},
+	}
+	ctx := context.Background()
+	for i, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			id := fmt.Sprintf("tls%d", i)
+			if _, err := svc.CreateSMTPTransport(ctx, entity.CreateSMTPTransport{
+				ID:        id,
+				ProjectID: "p1",
+				Name:      tc.name,
+				Host:      tc.srv.Host(),
+				Port:      tc.srv.Port(),
+				Username:  "user",
+				Password:  "secret",
+				EmailFrom: "from@example.com",
+				TLS:       tc.tls,
+			}); err != nil {
+				t.Fatalf("svc.CreateSMTPTransport failed: %+v", err)
+			}
+			tr, err := svc.GetSMTPTransport(ctx, id, "p1")
+			if err != nil {
+				t.Fatalf("svc.GetSMTPTransport failed: %+v", err)
+			}
+			assert.Equal(t, tc.tls, tr.TLS)
+
+			before := len(tc.srv.Messages())
+			err = svc.SendEmail(ctx, entity.SendEmailParams{
+				TemplateID:     "t1",
+				ProjectID:      "p1",
+				TransportID:    id,
+				To:             []string{"to@example.com"},
+				Subject:        "Welcome",
+				TemplateParams: map[string]string{"name": "Andy"},
+			})
+			if tc.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			if err != nil {
+				t.Fatalf("svc.SendEmail failed: %+v", err)
+			}
+			assert.Len(t, tc.srv.Messages(), before+1)
+		})
+	}
+
+	invalid := []entity.SMTPTLSOptions{
+		{Mode: "ssl"},
+		{Mode: entity.SMTPTLSModeTLS, CABundle: "not a certificate"},
+	}
+	for _, opts := range invalid {
+		_, err := svc.CreateSMTPTransport(ctx, entity.CreateSMTPTransport{
+			ID:        "invalid",
+			ProjectID: "p1",
+			Name:      "Invalid",
+			Host:      "smtp.example.com",
+			Port:      465,
+			TLS:       opts,
+		})
+		assertServiceErrorCode(t, err, entity.ErrInvalidTransportCode)
+	}
+}
+
+func TestGroups(t *testing.T) {
+	stores := []struct {
+		name string
+		opts []service.Option
+	}{
+		{"sqlite3", nil},
+		{"memory", []service.Option{service.WithInMemoryStore()}},
+	}
+	for _, st := range stores {
+		t.Run(st.name, func(t *testing.T) {
+			srv := newFakeSMTPServer(t)
+			svc := newTestService(t, st.opts...)
+			setupQueueProject(t, svc, srv)
+
+			ctx := context.Background()
+			for _, id := range []string{"g2", "g3"} {
+				if _, err := svc.CreateGroup(ctx, id, "p1", "Group "+id); err != nil {
+					t.Fatalf("svc.CreateGroup failed: %+v", err)
+				}
+			}
+
+			ids := func(params entity.ListGroupsParams) []string {
+				t.Helper()
+				params.ProjectID = "p1"
+				list, err := svc.ListGroups(ctx, params)
+				if err != nil {
+					t.Fatalf("svc.ListGroups failed: %+v", err)
+				}
+				var ids []string
+				for _, g := range list {
+					ids = append(ids, g.ID)
+				}
+				return ids
+			}
+			assert.Equal(t, []string{"g1", "g2", "g3"}, ids(entity.ListGroupsParams{}))
+			assert.Equal(t, []string{"g2"}, ids(entity.ListGroupsParams{After: "g1", Limit: 1}))
+
+			g, err := svc.UpdateGroup(ctx, "p1", "g2", "Renamed")
+			if err != nil {
+				t.Fatalf("svc.UpdateGroup failed: %+v", err)
+			}
+			assert.Equal(t, "Renamed", g.Name)
+			g, err = svc.GetGroup(ctx, "p1", "g2")
+			if err != nil {
+				t.Fatalf("svc.GetGroup failed: %+v", err)
+			}
+			assert.Equal(t, "Renamed", g.Name)
+			_, err = svc.UpdateGroup(ctx, "p1", "missing", "Renamed")
+			assertServiceErrorCode(t, err, entity.ErrGroupNotFoundCode)
+
+			// an empty group is deleted along with its partials
+			if _, err := svc.SetPartial(ctx, entity.SetPartialParams{
+				Name:      "footer",
+				ProjectID: "p1",
+				GroupID:   "g2",
+				Text:      "The Team",
+			}); err != nil {
+				t.Fatalf("svc.SetPartial failed: %+v", err)
+			}
+			if err := svc.DeleteGroup(ctx, "p1", "g2", false); err != nil {
+				t.Fatalf("svc.DeleteGroup failed: %+v", err)
+			}
+			_, err = svc.GetGroup(ctx, "p1", "g2")
+			assertServiceErrorCode(t, err, entity.ErrGroupNotFoundCode)
+			partials, err := svc.ListPartials(ctx, "p1", "g2")
+			if err != nil {
+				t.Fatalf("svc.ListPartials failed: %+v", err)
+			}
+			assert.Empty(t, partials)
+
+			// a group with templates needs force
+			if _, err := svc.SetDefaultGroup(ctx, "p1", "g1"); err != nil {
+				t.Fatalf("svc.SetDefaultGroup failed: %+v", err)
+			}
+			err = svc.DeleteGroup(ctx, "p1", "g1", false)
+			assertServiceErrorCode(t, err, entity.ErrGroupNotEmptyCode)
+			if err := svc.DeleteGroup(ctx, "p1", "g1", true); err != nil {
+				t.Fatalf("svc.DeleteGroup failed: %+v", err)
+			}
+			list, err := svc.ListTemplates(ctx, entity.ListTemplatesParams{ProjectID: "p1"})
+			if err != nil {
+				t.Fatalf("svc.ListTemplates failed: %+v", err)
+			}
+			assert.Empty(t, list)
+			p, err := svc.GetProject(ctx, "p1")
+			if err != nil {
+				t.Fatalf("svc.GetProject failed: %+v", err)
+			}
+			assert.Empty(t, p.DefaultGroupID)
+
+			err = svc.DeleteGroup(ctx, "p1", "g1", true)
+			assertServiceErrorCode(t, err, entity.ErrGroupNotFoundCode)
+		})
+	}
+}
+
+func TestListAndDeleteTemplates(t *testing.T) {
+	stores := []struct {
+		name string
+		opts []service.Option
+	}{
+		{"sqlite3", nil},
+		{"memory", []service.Option{service.WithInMemoryStore()}},
+	}
+	for _, st := range stores {
+		t.Run(st.name, func(t *testing.T) {
+			srv := newFakeSMTPServer(t)
+			svc := newTestService(t, st.opts...)
+			setupQueueProject(t, svc, srv)
+
+			ctx := context.Background()
+			if _, err := svc.CreateGroup(ctx, "g2", "p1", "Group Two"); err != nil {
+				t.Fatalf("svc.CreateGroup failed: %+v", err)
+			}
+			for _, tc := range []struct{ id, groupID string }{
+				{"welcome", "g2"},
+				{"welcome-back", "g2"},
+				{"receipt", "g1"},
+			} {
+				if _, err := svc.CreateTemplate(ctx, entity.CreateTemplate{
+					ID:        tc.id,
+					ProjectID: "p1",
+					GroupID:   tc.groupID,
+					Text:      `{{define "layout"}}text{{end}}`,
+					HTML:      `{{define "layout"}}html{{end}}`,
+				}); err != nil {
+					t.Fatalf("svc.CreateTemplate failed: %+v", err)
+				}
+			}
+
+			ids := func(params entity.ListTemplatesParams) []string {
+				t.Helper()
+				params.ProjectID = "p1"
+				list, err := svc.ListTemplates(ctx, params)
+				if err != nil {
+					t.Fatalf("svc.ListTemplates failed: %+v", err)
+				}
+				var ids []string
+				for _, tmpl := range list {
+					ids = append(ids, tmpl.ID)
+				}
+				return ids
+			}
+			assert.Equal(t, []string{"receipt", "t1", "welcome", "welcome-back"},
+				ids(entity.ListTemplatesParams{}))
+			assert.Equal(t, []string{"welcome", "welcome-back"},
+				ids(entity.ListTemplatesParams{GroupID: "g2"}))
+			assert.Equal(t, []string{"welcome-back"},
+				ids(entity.ListTemplatesParams{Query: "back"}))
+			assert.Equal(t, []string{"receipt", "t1"},
+				ids(entity.ListTemplatesParams{Limit: 2}))
+			assert.Equal(t, []string{"welcome", "welcome-back"},
+				ids(entity.ListTemplatesParams{After: "t1", Limit: 2}))
+
+			if err := svc.DeleteTemplate(ctx, "p1", "welcome"); err != nil {
+				t.Fatalf("svc.DeleteTemplate failed: %+v", err)
+			}
+			assert.Equal(t, []string{"welcome-back"},
+				ids(entity.ListTemplatesParams{GroupID: "g2"}))
+
+			err := svc.DeleteTemplate(ctx, "p1", "welcome")
+			assertServiceErrorCode(t, err, entity.ErrTemplateNotFoundCode)
+		})
+	}
+}
+
+func TestSearchTemplates(t *testing.T) {
+	stores := []struct {
+		name string
+		opts []service.Option
+	}{
+		{"sqlite3", nil},
+		{"memory", []service.Option{service.WithInMemoryStore()}},
+	}
+	for _, st := range stores {
+		t.Run(st.name, func(t *testing.T) {
+			svc := newTestService(t, st.opts...)
+			setupQueueProject(t, svc, newFakeSMTPServer(t))
+
+			ctx := context.Background()
+			for _, tc := range []struct{ id, subject, text string }{
+				{"welcome", "Welcome aboard", `{{define "layout"}}Hi {{.first_name}}, welcome{{end}}`},
+				{"receipt", "Your receipt", `{{define "layout"}}Order number {{.order}}{{end}}`},
+			} {
+				if _, err := svc.CreateTemplate(ctx, entity.CreateTemplate{
+					ID:        tc.id,
+					ProjectID: "p1",
+					GroupID:   "g1",
+					Subject:   tc.subject,
+					Text:      tc.text,
+				}); err != nil {
+					t.Fatalf("svc.CreateTemplate failed: %+v", err)
+				}
+			}
+
+			search := func(query string) []string {
+				t.Helper()
+				list, err := svc.SearchTemplates(ctx, "p1", query)
+				if err != nil {
+					t.Fatalf("svc.SearchTemplates failed: %+v", err)
+				}
+				ids := []string{}
+				for _, tmpl := range list {
+					ids = append(ids, tmpl.ID)
+				}
+				return ids
+			}
+			assert.Equal(t, []string{"welcome"}, search(".first_name"))
+			assert.Equal(t, []string{"receipt"}, search("ORDER NUMBER"))
+			assert.Equal(t, []string{"receipt"}, search("receipt"))
+			assert.Equal(t, []string{"t1"}, search("hello {{.name}}, this is the text"))
+			assert.ElementsMatch(t, []string{"t1", "welcome"}, search("Hi"))
+			assert.Empty(t, search("invoice"))
+
+			// the search sees changes to templates
+			if _, err := svc.SetTemplate(ctx, entity.SetTemplateParams{
+				ID:        "receipt",
+				ProjectID: "p1",
+				GroupID:   "g1",
+				Subject:   "Your invoice",
+				Text:      `{{define "layout"}}Invoice {{.order}}{{end}}`,
+			}); err != nil {
+				t.Fatalf("svc.SetTemplate failed: %+v", err)
+			}
+			assert.Equal(t, []string{"receipt"}, search("invoice"))
+			assert.Empty(t, search("order number"))
+			if err := svc.DeleteTemplate(ctx, "p1", "receipt"); err != nil {
+				t.Fatalf("svc.DeleteTemplate failed: %+v", err)
+			}
+			assert.Empty(t, search("invoice"))
+
+			list, err := svc.SearchTemplates(ctx, "p2", "welcome")
+			if err != nil {
+				t.Fatalf("svc.SearchTemplates failed: %+v", err)
+			}
+			assert.Empty(t, list)
+
+			_, err = svc.SearchTemplates(ctx, "p1", " ")
+			assertServiceErrorCode(t, err, entity.ErrInvalidSearchQueryCode)
+		})
+	}
+}
+
+func TestCreateTemplateDigests(t *testing.T) {
+	srv := newFakeSMTPServer(t)
+	svc := newTestService(t)
+	setupQueueProject(t, svc, srv)
+
+	text := `{{define "layout"}}Hello {{.name}}{{end}}`
+	html := `{{define "layout"}}<p>Hello {{.name}}</p>{{end}}`
+	digest := func(s string) string {
+		sum := sha512.Sum512_224([]byte(s))
+		return hex.EncodeToString(sum[:16])
+	}
+
+	ctx := context.Background()
+	tmpl, err := svc.CreateTemplate(ctx, entity.CreateTemplate{
+		ID:        "welcome",
+		ProjectID: "p1",
+		GroupID:   "g1",
+		Text:      text,
+		HTML:      html,
+	})
+	if err != nil {
+		t.Fatalf("svc.CreateTemplate failed: %+v", err)
+	}
+	assert.Equal(t, digest(text), tmpl.TextDigest)
+	assert.Equal(t, digest(html), tmpl.HTMLDigest)
+
+	// matching digests are accepted and mismatched ones rejected
+	if _, err := svc.CreateTemplate(ctx, entity.CreateTemplate{
+		ID:         "welcome2",
+		ProjectID:  "p1",
+		GroupID:    "g1",
+		Text:       text,
+		TextDigest: digest(text),
+		HTML:       html,
+		HTMLDigest: digest(html),
+	}); err != nil {
+		t.Fatalf("svc.CreateTemplate failed: %+v", err)
+	}
+	_, err = svc.CreateTemplate(ctx, entity.CreateTemplate{
+		ID:         "welcome3",
+		ProjectID:  "p1",
+		GroupID:    "g1",
+		Text:       text,
+		HTML:       html,
+		HTMLDigest: digest(text),
+	})
+	assertServiceErrorCode(t, err, entity.ErrInvalidTemplateCode)
+}
+
+func TestSetTemplate(t *testing.T) {
+	srv := newFakeSMTPServer(t)
+	svc := newTestService(t)
+	setupQueueProject(t, svc, srv)
+
+	params := entity.SetTemplateParams{
+		ID:        "welcome",
+		ProjectID: "p1",
+		GroupID:   "g1",
+		Text:      `{{define "layout"}}Hello {{.name}}{{end}}`,
+		HTML:      `{{define "layout"}}<p>Hello {{.name}}</p>{{end}}`,
+	}
+	ctx := context.Background()
+	tmpl, err := svc.SetTemplate(ctx, params)
+	if err != nil {
+		t.Fatalf("svc.SetTemplate failed: %+v", err)
+	}
+	assert.NotEmpty(t, tmpl.TextDigest)
+
+	// setting the same strings again leaves the template untouched
+	again, err := svc.SetTemplate(ctx, params)
+	if err != nil {
+		t.Fatalf("svc.SetTemplate failed: %+v", err)
+	}
+	assert.Equal(t, tmpl.ModifiedAt, again.ModifiedAt)
+
+	// a stale digest is rejected rather than leaving the old body
+	params.Text = `{{define "layout"}}Hi {{.name}}{{end}}`
+	params.TextDigest = tmpl.TextDigest
+	_, err = svc.SetTemplate(ctx, params)
+	assertServiceErrorCode(t, err, entity.ErrInvalidTemplateCode)
+
+	params.TextDigest = ""
+	changed, err := svc.SetTemplate(ctx, params)
+	if err != nil {
+		t.Fatalf("svc.SetTemplate failed: %+v", err)
+	}
+	assert.Equal(t, params.Text, changed.Text)
+	assert.NotEqual(t, tmpl.TextDigest, changed.TextDigest)
+
+	got, err := svc.GetTemplate(ctx, "p1", "welcome")
+	if err != nil {
+		t.Fatalf("svc.GetTemplate failed: %+v", err)
+	}
+	assert.Equal(t, params.Text, got.Text)
+}
+
+func TestSetTemplateFromFS(t *testing.T) {
+	srv := newFakeSMTPServer(t)
+	svc := newTestService(t)
+	setupQueueProject(t, svc, srv)
+
+	fsys := fstest.MapFS{
+		"templates/welcome/layout.txt":  {Data: []byte(`{{define "layout"}}Hello {{template "body" .}}{{end}}`)},
+		"templates/welcome/body.txt":    {Data: []byte(`{{define "body"}}{{.name}}{{end}}`)},
+		"templates/welcome/layout.html": {Data: []byte(`{{define "layout"}}<p>Hello {{.name}}</p>{{end}}`)},
+	}
+	params := entity.SetTemplateFromFSParams{
+		ID:           "welcome",
+		ProjectID:    "p1",
+		GroupID:      "g1",
+		TxtPatterns:  []string{"templates/welcome/layout.txt", "templates/welcome/*.txt"},
+		HTMLPatterns: []string{"templates/welcome/*.html"},
+	}
+	ctx := context.Background()
+	tmpl, err := svc.SetTemplateFromFS(ctx, fsys, params)
+	if err != nil {
+		t.Fatalf("svc.SetTemplateFromFS failed: %+v", err)
+	}
+	assert.Equal(t,
+		`{{define "layout"}}Hello {{template "body" .}}{{end}}{{define "body"}}{{.name}}{{end}}`,
+		tmpl.Text)
+
+	// syncing unchanged files leaves the template untouched
+	again, err := svc.SetTemplateFromFS(ctx, fsys, params)
+	if err != nil {
+		t.Fatalf("svc.SetTemplateFromFS failed: %+v", err)
+	}
+	assert.Equal(t, tmpl.ModifiedAt, again.ModifiedAt)
+
+	params.HTMLPatterns = []string{"templates/missing/*.html"}
+	_, err = svc.SetTemplateFromFS(ctx, fsys, params)
+	assert.Error(t, err)
+}
+
+func TestWithSqlite3Pragmas(t *testing.T) {
+	svc := newTestService(t, service.WithSqlite3Pragmas(service.Sqlite3Pragmas{
+		Synchronous: "full",
+		BusyTimeout: service.Duration(30 * time.Second),
+	}))
+	if _, err := svc.CreateProject(context.Background(), "p1", "Project One", ""); err != nil {
+		t.Fatalf("svc.CreateProject failed: %+v", err)
+	}
+
+	for _, p := range []service.Sqlite3Pragmas{
+		{JournalMode: "sideways"},
+		{Synchronous: "sometimes"},
+		{ForeignKeys: "maybe"},
+	} {
+		_, err := service.NewEmailService(
+			service.WithSqlite3DBFilepath(filepath.Join(t.TempDir(), "mailer.db")),
+			service.WithHexEncodedEncryptionKey(testEncryptionKey),
+			service.WithSqlite3Pragmas(p),
+		)
+		assert.Error(t, err, "%+v", p)
+	}
+}
@@ -0,0 +1,164 @@
+package service_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/andyfusniak/squishy-mailer-lite/entity"
+	"github.com/andyfusniak/squishy-mailer-lite/service"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSESTransport(t *testing.T) {
+	srv := newFakeSMTPServer(t)
+	api := newFakeAPIServer(t)
+	svc := newTestService(t, service.WithRetryPolicy(service.RetryPolicy{
+		MaxAttempts:    3,
+		InitialBackoff: time.Hour,
+	}))
+	setupQueueProject(t, svc, srv)
+
+	ctx := context.Background()
+	tr, err := svc.CreateSESTransport(ctx, entity.CreateSESTransport{
+		ID:               "ses",
+		ProjectID:        "p1",
+		Name:             "SES",
+		Region:           "eu-west-1",
+		Credentials:      entity.SESCredentialsStatic,
+		AccessKeyID:      "AKIDEXAMPLE",
+		SecretAccessKey:  "secret-access-key",
+		ConfigurationSet: "transactional",
+		Tags:             map[string]string{"app": "billing"},
+		Endpoint:         api.URL,
+		EmailFrom:        "noreply@example.com",
+		EmailFromName:    "Example",
+	})
+	if err != nil {
+		t.Fatalf("svc.CreateSESTransport failed: %+v", err)
+	}
+	assert.Equal(t, entity.APITransportProviderSES, tr.Provider)
+	assert.Equal(t, "eu-west-1", tr.Config["region"])
+	for _, v := range tr.Config {
+		assert.NotEqual(t, "secret-access-key", v)
+	}
+
+	if _, err := svc.SendEmailAsync(ctx, entity.SendEmailParams{
+		TemplateID:     "t1",
+		ProjectID:      "p1",
+		TransportID:    "ses",
+		To:             []string{"to@example.com"},
+		Bcc:            []string{"audit@example.com"},
+		Subject:        "Welcome",
+		TemplateParams: map[string]string{"name": "Andy"},
+	}); err != nil {
+		t.Fatalf("svc.SendEmailAsync failed: %+v", err)
+	}
+	n, err := svc.ProcessMailQueue(ctx)
+	if err != nil {
+		t.Fatalf("svc.ProcessMailQueue failed: %+v", err)
+	}
+	assert.Equal(t, 1, n)
+
+	reqs := api.Requests()
+	if assert.Len(t, reqs, 1) {
+		assert.Equal(t, http.MethodPost, reqs[0].Method)
+		assert.Equal(t, "/v2/email/outbound-emails", reqs[0].Path)
+		assert.Contains(t, reqs[0].Header.Get("Authorization"), "Credential=AKIDEXAMPLE/")
+		assert.Contains(t, reqs[0].Header.Get("Authorization"), "/eu-west-1/ses/")
+
+		var body struct {
+			FromEmailAddress     string
+			ConfigurationSetName string
+			Destination          struct {
+				ToAddresses  []string
+				BccAddresses []string
+			}
+			Content struct {
+				Raw struct {
+					Data []byte
+				}
+			}
+			EmailTags []struct {
+				Name  string
+				Value string
+			}
+		}
+		if err := json.Unmarshal(reqs[0].Body, &body); err != nil {
+			t.Fatalf("json.Unmarshal failed: %+v", err)
+		}
+		assert.Equal(t, "Example <noreply@example.com>", body.FromEmailAddress)
+		assert.Equal(t, "transactional", body.ConfigurationSetName)
+		assert.Equal(t, []string{"to@example.com"}, body.Destination.ToAddresses)
+		assert.Equal(t, []string{"audit@example.com"}, body.Destination.BccAddresses)
+		assert.Contains(t, string(body.Content.Raw.Data), "Subject: Welcome")
+		assert.NotContains(t, string(body.Content.Raw.Data), "audit@example.com")
+		if assert.Len(t, body.EmailTags, 1) {
+			assert.Equal(t, "app", body.EmailTags[0].Name)
+			assert.Equal(t, "billing", body.EmailTags[0].Value)
+		}
+	}
+
+	// a rejected message is not retried
+	api.SetStatus(http.StatusBadRequest)
+	queued, err := svc.SendEmailAsync(ctx, entity.SendEmailParams{
+		TemplateID:  "t1",
+		ProjectID:   "p1",
+		TransportID: "ses",
+		To:          []string{"to@example.com"},
+		Subject:     "Welcome",
+	})
+	if err != nil {
+		t.Fatalf("svc.SendEmailAsync failed: %+v", err)
+	}
+	if _, err := svc.ProcessMailQueue(ctx); err != nil {
+		t.Fatalf("svc.ProcessMailQueue failed: %+v", err)
+	}
+	mq, err := svc.GetMailQueue(ctx, "p1", queued.ID)
+	if err != nil {
+		t.Fatalf("svc.GetMailQueue failed: %+v", err)
+	}
+	assert.Equal(t, entity.MailStateDeadLetter, mq.State)
+}
+
+func TestCreateSESTransportValidation(t *testing.T) {
+	svc := newTestService(t)
+	setupTwoProjects(t, svc)
+
+	ctx := context.Background()
+	_, err := svc.CreateSESTransport(ctx, entity.CreateSESTransport{
+		ID:          "ses",
+		ProjectID:   "pa",
+		Region:      "eu-west-1",
+		Credentials: entity.SESCredentialsStatic,
+		AccessKeyID: "AKIDEXAMPLE",
+	})
+	assertServiceErrorCode(t, err, entity.ErrInvalidTransportCode)
+
+	_, err = svc.CreateSESTransport(ctx, entity.CreateSESTransport{
+		ID:          "ses",
+		ProjectID:   "pa",
+		Region:      "eu-west-1",
+		Credentials: entity.SESCredentialsProfile,
+	})
+	assertServiceErrorCode(t, err, entity.ErrInvalidTransportCode)
+
+	_, err = svc.CreateSESTransport(ctx, entity.CreateSESTransport{
+		ID:        "ses",
+		ProjectID: "pa",
+	})
+	assertServiceErrorCode(t, err, entity.ErrInvalidTransportCode)
+
+	// the default credential chain needs no stored secret
+	tr, err := svc.CreateSESTransport(ctx, entity.CreateSESTransport{
+		ID:        "ses",
+		ProjectID: "pa",
+		Region:    "eu-west-1",
+	})
+	if err != nil {
+		t.Fatalf("svc.CreateSESTransport failed: %+v", err)
+	}
+	assert.Equal(t, "default", tr.Config["credentials"])
+}
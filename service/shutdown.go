@@ -0,0 +1,92 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"github.com/andyfusniak/squishy-mailer-lite/entity"
+	"github.com/pkg/errors"
+)
+
+// defaultDrainTimeout is how long Close waits for in-flight work.
+const defaultDrainTimeout = 30 * time.Second
+
+// Close shuts the service down as Shutdown does, waiting up to 30 seconds
+// for in-flight deliveries.
+func (s *Service) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultDrainTimeout)
+	defer cancel()
+	return s.Shutdown(ctx)
+}
+
+// Shutdown stops the queue worker and closes the store. Calls to
+// ProcessMailQueue, ProcessWebhookDeliveries and RunMaintenance made once
+// Shutdown has begun return an error with a code of ErrServiceClosedCode.
+// Emails already claimed by a running ProcessMailQueue that have not
+// started sending are returned to the queue, and Shutdown waits for the
+// sends in flight to finish and their outcome to be recorded. If ctx is
+// done first the sends are interrupted and their emails returned to the
+// queue to be sent again. The channels returned by Subscribe are then
+// closed, the events waiting for the event publisher published, or
+// dropped if ctx is done first, and the store closed once nothing is
+// using it.
+// Calling Shutdown or Close again does nothing.
+func (s *Service) Shutdown(ctx context.Context) error {
+	s.workMu.Lock()
+	if s.closing {
+		s.workMu.Unlock()
+		return nil
+	}
+	s.closing = true
+	s.workMu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		s.work.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		s.logger.Warn("shutdown timed out, interrupting in-flight deliveries")
+		s.abortWork()
+		// interrupted sends return their emails to the queue before the
+		// store is closed
+		<-done
+	}
+	s.abortWork()
+	s.events.close()
+	if err := s.closePublisher(ctx); err != nil {
+		s.logger.Warn("event publisher close failed", "error", err)
+	}
+	return s.store.Close()
+}
+
+// startWork registers a call that must finish before Shutdown closes the
+// store. The returned context is cancelled if Shutdown times out, and
+// done must be called when the work finishes. Once Shutdown has begun an
+// error with a code of ErrServiceClosedCode is returned.
+func (s *Service) startWork(ctx context.Context) (_ context.Context, done func(), _ error) {
+	s.workMu.Lock()
+	defer s.workMu.Unlock()
+	if s.closing {
+		return nil, nil, entity.NewServiceError(entity.ErrServiceClosedCode,
+			errors.New("[service] service is shutting down"))
+	}
+	s.work.Add(1)
+
+	ctx, cancel := context.WithCancel(ctx)
+	stop := context.AfterFunc(s.abort, cancel)
+	return ctx, func() {
+		stop()
+		cancel()
+		s.work.Done()
+	}, nil
+}
+
+// isClosing reports whether Shutdown has begun.
+func (s *Service) isClosing() bool {
+	s.workMu.Lock()
+	defer s.workMu.Unlock()
+	return s.closing
+}
@@ -0,0 +1,152 @@
+package service_test
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/andyfusniak/squishy-mailer-lite/entity"
+	"github.com/andyfusniak/squishy-mailer-lite/service"
+	"github.com/stretchr/testify/assert"
+)
+
+// blockingSender holds each email until it is released or its context
+// is done.
+type blockingSender struct {
+	started chan struct{}
+	release chan struct{}
+}
+
+func (b *blockingSender) SendEmail(ctx context.Context, _ *entity.OutgoingEmail) error {
+	b.started <- struct{}{}
+	select {
+	case <-b.release:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// setupShutdownTest returns a service backed by the database file at
+// dbPath with two emails queued through a transport that blocks.
+func setupShutdownTest(t *testing.T, transportType, dbPath string) (*service.Service, *blockingSender, []*entity.MailQueue) {
+	t.Helper()
+
+	blocking := &blockingSender{
+		started: make(chan struct{}, 2),
+		release: make(chan struct{}),
+	}
+	service.RegisterTransportFactory(transportType, func(context.Context, service.TransportConfig) (service.Sender, error) {
+		return blocking, nil
+	})
+
+	srv := newFakeSMTPServer(t)
+	svc := newTestService(t, service.WithSqlite3DBFilepath(dbPath))
+	setupQueueProject(t, svc, srv)
+
+	ctx := context.Background()
+	if _, err := svc.CreateTransport(ctx, entity.CreateTransport{
+		ID:        "blocking",
+		ProjectID: "p1",
+		Name:      "Blocking",
+		Type:      transportType,
+		EmailFrom: "noreply@example.com",
+	}); err != nil {
+		t.Fatalf("svc.CreateTransport failed: %+v", err)
+	}
+	var queued []*entity.MailQueue
+	for range 2 {
+		mq, err := svc.SendEmailAsync(ctx, entity.SendEmailParams{
+			TemplateID:     "t1",
+			ProjectID:      "p1",
+			TransportID:    "blocking",
+			To:             []string{"to@example.com"},
+			Subject:        "Welcome",
+			TemplateParams: map[string]string{"name": "Andy"},
+		})
+		if err != nil {
+			t.Fatalf("svc.SendEmailAsync failed: %+v", err)
+		}
+		queued = append(queued, mq)
+	}
+	return svc, blocking, queued
+}
+
+func TestShutdownDrainsInFlightSends(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "mailer.db")
+	svc, blocking, queued := setupShutdownTest(t, "shutdown-drain", dbPath)
+
+	ctx := context.Background()
+	processed := make(chan int, 1)
+	go func() {
+		n, _ := svc.ProcessMailQueue(ctx)
+		processed <- n
+	}()
+	<-blocking.started
+
+	shutdown := make(chan error, 1)
+	go func() {
+		shutdown <- svc.Shutdown(ctx)
+	}()
+
+	// no more emails are claimed once shutdown begins
+	assert.Eventually(t, func() bool {
+		_, err := svc.ProcessMailQueue(ctx)
+		var serr *entity.ServiceError
+		return errors.As(err, &serr) && serr.Code == entity.ErrServiceClosedCode
+	}, time.Second, 5*time.Millisecond)
+	select {
+	case <-shutdown:
+		t.Fatal("shutdown returned before the in-flight send finished")
+	default:
+	}
+
+	close(blocking.release)
+	assert.Equal(t, 1, <-processed)
+	if err := <-shutdown; err != nil {
+		t.Fatalf("svc.Shutdown failed: %+v", err)
+	}
+
+	// the in-flight email was sent and the other returned to the queue
+	reopened := newTestService(t, service.WithSqlite3DBFilepath(dbPath))
+	mq, err := reopened.GetMailQueue(ctx, "p1", queued[0].ID)
+	if err != nil {
+		t.Fatalf("reopened.GetMailQueue failed: %+v", err)
+	}
+	assert.Equal(t, entity.MailStateSent, mq.State)
+	mq, err = reopened.GetMailQueue(ctx, "p1", queued[1].ID)
+	if err != nil {
+		t.Fatalf("reopened.GetMailQueue failed: %+v", err)
+	}
+	assert.Equal(t, entity.MailStateQueued, mq.State)
+	assert.Equal(t, "delivery abandoned: service shutting down", mq.DeferralReason)
+}
+
+func TestShutdownTimeoutInterruptsSends(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "mailer.db")
+	svc, blocking, queued := setupShutdownTest(t, "shutdown-timeout", dbPath)
+
+	ctx := context.Background()
+	go svc.ProcessMailQueue(ctx)
+	<-blocking.started
+
+	shutdownCtx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	if err := svc.Shutdown(shutdownCtx); err != nil {
+		t.Fatalf("svc.Shutdown failed: %+v", err)
+	}
+	// closing again does nothing
+	assert.NoError(t, svc.Close())
+
+	reopened := newTestService(t, service.WithSqlite3DBFilepath(dbPath))
+	for _, q := range queued {
+		mq, err := reopened.GetMailQueue(ctx, "p1", q.ID)
+		if err != nil {
+			t.Fatalf("reopened.GetMailQueue failed: %+v", err)
+		}
+		assert.Equal(t, entity.MailStateQueued, mq.State)
+		assert.Equal(t, 0, mq.Attempts)
+	}
+}
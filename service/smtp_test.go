@@ -0,0 +1,231 @@
+package service_test
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/pem"
+	"net"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeSMTPServer is a minimal SMTP server used to receive emails sent by
+// the service during tests. It does not advertise STARTTLS and accepts any
+// AUTH PLAIN or XOAUTH2 credentials, which are sent over plaintext when
+// connecting to 127.0.0.1. Recipients whose address starts with "reject"
+// are refused with a permanent 550 reply.
+type fakeSMTPServer struct {
+	ln net.Listener
+
+	// startTLS, if set, is advertised and used to upgrade connections
+	// that issue STARTTLS.
+	startTLS *tls.Config
+
+	mu       sync.Mutex
+	messages []fakeSMTPMessage
+	conns    int
+	active   int
+	peak     int
+}
+
+// fakeSMTPMessage is a single email received by the fakeSMTPServer.
+type fakeSMTPMessage struct {
+	From string
+	To   []string
+	Data string
+
+	// Password is the AUTH PLAIN password, or the XOAUTH2 access token,
+	// of the connection.
+	Password string
+}
+
+// newFakeSMTPServer starts a fake SMTP server listening on a random local
+// port. The server is stopped when the test completes.
+func newFakeSMTPServer(t *testing.T) *fakeSMTPServer {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen failed: %+v", err)
+	}
+	srv := &fakeSMTPServer{ln: ln}
+	go srv.serve()
+	t.Cleanup(func() { ln.Close() })
+	return srv
+}
+
+// newFakeSMTPServerTLS starts a fake SMTP server that either accepts
+// implicit TLS connections or offers STARTTLS. It returns the server and
+// the PEM encoded certificate of the CA that signed the server's
+// certificate, which is valid for 127.0.0.1.
+func newFakeSMTPServerTLS(t *testing.T, implicit bool) (*fakeSMTPServer, string) {
+	t.Helper()
+
+	// borrow the certificate of an httptest TLS server
+	hs := httptest.NewUnstartedServer(nil)
+	hs.StartTLS()
+	cfg := &tls.Config{Certificates: hs.TLS.Certificates}
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: hs.Certificate().Raw})
+	hs.Close()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen failed: %+v", err)
+	}
+	srv := &fakeSMTPServer{ln: ln}
+	if implicit {
+		srv.ln = tls.NewListener(ln, cfg)
+	} else {
+		srv.startTLS = cfg
+	}
+	go srv.serve()
+	t.Cleanup(func() { ln.Close() })
+	return srv, string(caPEM)
+}
+
+// Host returns the host the server is listening on.
+func (s *fakeSMTPServer) Host() string {
+	return s.ln.Addr().(*net.TCPAddr).IP.String()
+}
+
+// Port returns the port the server is listening on.
+func (s *fakeSMTPServer) Port() int {
+	return s.ln.Addr().(*net.TCPAddr).Port
+}
+
+// Messages returns a copy of the messages received so far.
+func (s *fakeSMTPServer) Messages() []fakeSMTPMessage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]fakeSMTPMessage(nil), s.messages...)
+}
+
+// PeakConnections returns the largest number of connections that were
+// open at the same time.
+func (s *fakeSMTPServer) PeakConnections() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.peak
+}
+
+// Connections returns the number of connections accepted so far.
+func (s *fakeSMTPServer) Connections() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.conns
+}
+
+func (s *fakeSMTPServer) serve() {
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.conns++
+		s.mu.Unlock()
+		go s.handle(conn)
+	}
+}
+
+func (s *fakeSMTPServer) handle(conn net.Conn) {
+	defer conn.Close()
+	s.mu.Lock()
+	s.active++
+	s.peak = max(s.peak, s.active)
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		s.active--
+		s.mu.Unlock()
+	}()
+
+	r := bufio.NewReader(conn)
+	reply := func(code int, msg string) {
+		conn.Write([]byte(strconv.Itoa(code) + " " + msg + "\r\n"))
+	}
+
+	reply(220, "localhost fake smtp")
+	var msg fakeSMTPMessage
+	var password string
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		cmd := strings.ToUpper(line)
+		switch {
+		case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+			conn.Write([]byte("250-localhost\r\n"))
+			if s.startTLS != nil {
+				conn.Write([]byte("250-STARTTLS\r\n"))
+			}
+			reply(250, "AUTH PLAIN XOAUTH2")
+		case cmd == "STARTTLS" && s.startTLS != nil:
+			reply(220, "ready to start TLS")
+			tlsConn := tls.Server(conn, s.startTLS)
+			if err := tlsConn.Handshake(); err != nil {
+				return
+			}
+			conn = tlsConn
+			r = bufio.NewReader(conn)
+		case strings.HasPrefix(cmd, "AUTH"):
+			if fields := strings.Fields(line); len(fields) == 3 {
+				resp, _ := base64.StdEncoding.DecodeString(fields[2])
+				if parts := strings.Split(string(resp), "\x00"); len(parts) == 3 {
+					password = parts[2]
+				}
+				for _, kv := range strings.Split(string(resp), "\x01") {
+					if token, ok := strings.CutPrefix(kv, "auth=Bearer "); ok {
+						password = token
+					}
+				}
+			}
+			reply(235, "authentication successful")
+		case strings.HasPrefix(cmd, "MAIL FROM:"):
+			msg = fakeSMTPMessage{
+				From:     strings.Trim(line[len("MAIL FROM:"):], "<> "),
+				Password: password,
+			}
+			reply(250, "OK")
+		case strings.HasPrefix(cmd, "RCPT TO:"):
+			to := strings.Trim(line[len("RCPT TO:"):], "<> ")
+			if strings.HasPrefix(to, "reject") {
+				reply(550, "mailbox unavailable")
+				continue
+			}
+			msg.To = append(msg.To, to)
+			reply(250, "OK")
+		case cmd == "DATA":
+			reply(354, "end data with <CR><LF>.<CR><LF>")
+			var sb strings.Builder
+			for {
+				l, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if l == ".\r\n" {
+					break
+				}
+				sb.WriteString(l)
+			}
+			msg.Data = sb.String()
+			s.mu.Lock()
+			s.messages = append(s.messages, msg)
+			s.mu.Unlock()
+			reply(250, "OK")
+		case cmd == "RSET", cmd == "NOOP":
+			reply(250, "OK")
+		case cmd == "QUIT":
+			reply(221, "bye")
+			return
+		default:
+			reply(502, "command not implemented")
+		}
+	}
+}
@@ -0,0 +1,772 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/andyfusniak/squishy-mailer-lite/entity"
+	"github.com/andyfusniak/squishy-mailer-lite/internal/store"
+	"github.com/pkg/errors"
+)
+
+// snapshotVersion is the version of the snapshot archive format. Restore
+// rejects archives with a version it does not understand.
+const snapshotVersion = 1
+
+// snapshotArchive is the JSON document written by Snapshot. It does not
+// depend on the store implementation so a snapshot taken from one store
+// can be restored into another.
+type snapshotArchive struct {
+	Version   int       `json:"version"`
+	CreatedAt time.Time `json:"created_at"`
+
+	// KeyID identifies the encryption key the transport passwords are
+	// encrypted with. The key itself is never written to the archive.
+	KeyID string `json:"key_id"`
+
+	Projects            []snapshotProject            `json:"projects"`
+	SMTPTransports      []snapshotSMTPTransport      `json:"smtp_transports"`
+	APITransports       []snapshotAPITransport       `json:"api_transports"`
+	Groups              []snapshotGroup              `json:"groups"`
+	Templates           []snapshotTemplate           `json:"templates"`
+	Partials            []snapshotPartial            `json:"partials"`
+	TemplateVariants    []snapshotTemplateVariant    `json:"template_variants"`
+	TemplateFixtures    []snapshotTemplateFixture    `json:"template_fixtures,omitempty"`
+	SendWindows         []snapshotSendWindow         `json:"send_windows"`
+	RateLimits          []snapshotRateLimit          `json:"rate_limits"`
+	SenderAllowLists    []snapshotSenderAllowList    `json:"sender_allow_lists,omitempty"`
+	MessageCatalogs     []snapshotMessageCatalog     `json:"message_catalogs"`
+	Attachments         []snapshotFile               `json:"attachments"`
+	TemplateAttachments []snapshotTemplateAttachment `json:"template_attachments"`
+	Assets              []snapshotFile               `json:"assets"`
+	MailQueue           []snapshotMailQueue          `json:"mail_queue"`
+}
+
+type snapshotProject struct {
+	ID                      string            `json:"id"`
+	Name                    string            `json:"name"`
+	Description             string            `json:"description"`
+	AllowedRecipientDomains []string          `json:"allowed_recipient_domains"`
+	DefaultTransportID      string            `json:"default_transport_id"`
+	DefaultGroupID          string            `json:"default_group_id"`
+	TransportChain          []string          `json:"transport_chain,omitempty"`
+	Labels                  map[string]string `json:"labels,omitempty"`
+	Variables               map[string]string `json:"variables,omitempty"`
+	CreatedAt               time.Time         `json:"created_at"`
+}
+
+type snapshotSMTPTransport struct {
+	ID                string    `json:"id"`
+	ProjectID         string    `json:"project_id"`
+	Name              string    `json:"name"`
+	Host              string    `json:"host"`
+	Port              int       `json:"port"`
+	Username          string    `json:"username"`
+	EncryptedPassword string    `json:"encrypted_password,omitempty"`
+	EmailFrom         string    `json:"email_from"`
+	EmailFromName     string    `json:"email_from_name"`
+	EmailReplyTo      []string  `json:"email_replyto"`
+	WarmupSchedule    []int     `json:"warmup_schedule"`
+	WarmupStartedAt   time.Time `json:"warmup_started_at"`
+	CreatedAt         time.Time `json:"created_at"`
+	ModifiedAt        time.Time `json:"modified_at"`
+
+	TLSMode               string `json:"tls_mode,omitempty"`
+	TLSInsecureSkipVerify bool   `json:"tls_insecure_skip_verify,omitempty"`
+	TLSCABundle           string `json:"tls_ca_bundle,omitempty"`
+
+	ConnectTimeoutMS int `json:"connect_timeout_ms,omitempty"`
+	SendTimeoutMS    int `json:"send_timeout_ms,omitempty"`
+}
+
+type snapshotAPITransport struct {
+	ID              string            `json:"id"`
+	ProjectID       string            `json:"project_id"`
+	Name            string            `json:"name"`
+	Provider        string            `json:"provider"`
+	Config          map[string]string `json:"config"`
+	EncryptedAPIKey string            `json:"encrypted_api_key,omitempty"`
+	EmailFrom       string            `json:"email_from"`
+	EmailFromName   string            `json:"email_from_name"`
+	EmailReplyTo    []string          `json:"email_replyto"`
+	CreatedAt       time.Time         `json:"created_at"`
+	ModifiedAt      time.Time         `json:"modified_at"`
+}
+
+type snapshotGroup struct {
+	ID         string    `json:"id"`
+	ProjectID  string    `json:"project_id"`
+	Name       string    `json:"name"`
+	CreatedAt  time.Time `json:"created_at"`
+	ModifiedAt time.Time `json:"modified_at"`
+}
+
+type snapshotTemplate struct {
+	ID         string            `json:"id"`
+	ProjectID  string            `json:"project_id"`
+	GroupID    string            `json:"group_id"`
+	Text       string            `json:"text"`
+	TextDigest string            `json:"text_digest"`
+	HTML       string            `json:"html"`
+	HTMLDigest string            `json:"html_digest"`
+	Subject    string            `json:"subject,omitempty"`
+	SourceType string            `json:"source_type,omitempty"`
+	Source     string            `json:"source,omitempty"`
+	AssetMode  string            `json:"asset_mode"`
+	Labels     map[string]string `json:"labels,omitempty"`
+	CreatedAt  time.Time         `json:"created_at"`
+	ModifiedAt time.Time         `json:"modified_at"`
+}
+
+type snapshotPartial struct {
+	Name       string    `json:"name"`
+	ProjectID  string    `json:"project_id"`
+	GroupID    string    `json:"group_id"`
+	Text       string    `json:"text"`
+	HTML       string    `json:"html"`
+	CreatedAt  time.Time `json:"created_at"`
+	ModifiedAt time.Time `json:"modified_at"`
+}
+
+type snapshotTemplateVariant struct {
+	TemplateID string    `json:"template_id"`
+	ProjectID  string    `json:"project_id"`
+	Locale     string    `json:"locale"`
+	Text       string    `json:"text"`
+	TextDigest string    `json:"text_digest"`
+	HTML       string    `json:"html"`
+	HTMLDigest string    `json:"html_digest"`
+	Subject    string    `json:"subject,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+	ModifiedAt time.Time `json:"modified_at"`
+}
+
+type snapshotTemplateFixture struct {
+	TemplateID string         `json:"template_id"`
+	ProjectID  string         `json:"project_id"`
+	Name       string         `json:"name"`
+	Params     map[string]any `json:"params"`
+	CreatedAt  time.Time      `json:"created_at"`
+	ModifiedAt time.Time      `json:"modified_at"`
+}
+
+type snapshotSendWindow struct {
+	ProjectID  string    `json:"project_id"`
+	GroupID    string    `json:"group_id"`
+	StartTime  string    `json:"start_time"`
+	EndTime    string    `json:"end_time"`
+	Timezone   string    `json:"timezone"`
+	CreatedAt  time.Time `json:"created_at"`
+	ModifiedAt time.Time `json:"modified_at"`
+}
+
+type snapshotRateLimit struct {
+	ProjectID   string    `json:"project_id"`
+	TransportID string    `json:"transport_id"`
+	PerSecond   int       `json:"per_second"`
+	PerMinute   int       `json:"per_minute"`
+	PerDay      int       `json:"per_day"`
+	CreatedAt   time.Time `json:"created_at"`
+	ModifiedAt  time.Time `json:"modified_at"`
+}
+
+type snapshotSenderAllowList struct {
+	ProjectID   string    `json:"project_id"`
+	TransportID string    `json:"transport_id"`
+	Emails      []string  `json:"emails"`
+	CreatedAt   time.Time `json:"created_at"`
+	ModifiedAt  time.Time `json:"modified_at"`
+}
+
+type snapshotMessageCatalog struct {
+	ProjectID  string          `json:"project_id"`
+	Locale     string          `json:"locale"`
+	Messages   json.RawMessage `json:"messages"`
+	CreatedAt  time.Time       `json:"created_at"`
+	ModifiedAt time.Time       `json:"modified_at"`
+}
+
+// snapshotFile is an attachment or an asset.
+type snapshotFile struct {
+	ID          string    `json:"id"`
+	ProjectID   string    `json:"project_id"`
+	Filename    string    `json:"filename"`
+	ContentType string    `json:"content_type"`
+	Content     []byte    `json:"content"`
+	Checksum    string    `json:"checksum"`
+	CreatedAt   time.Time `json:"created_at"`
+	ModifiedAt  time.Time `json:"modified_at"`
+}
+
+type snapshotTemplateAttachment struct {
+	ProjectID    string `json:"project_id"`
+	TemplateID   string `json:"template_id"`
+	AttachmentID string `json:"attachment_id"`
+	Position     int    `json:"position"`
+}
+
+type snapshotMailQueue struct {
+	ID             string                  `json:"id"`
+	ProjectID      string                  `json:"project_id"`
+	TemplateID     string                  `json:"template_id"`
+	TransportID    string                  `json:"transport_id"`
+	State          string                  `json:"state"`
+	Metadata       store.MailQueueMetadata `json:"metadata"`
+	Body           store.MailQueueBody     `json:"body"`
+	LastError      string                  `json:"last_error"`
+	Attempts       int                     `json:"attempts"`
+	SendAt         time.Time               `json:"send_at"`
+	NextAttemptAt  time.Time               `json:"next_attempt_at"`
+	DeferralReason string                  `json:"deferral_reason"`
+	CreatedAt      time.Time               `json:"created_at"`
+	ModifiedAt     time.Time               `json:"modified_at"`
+}
+
+// Snapshot writes a versioned JSON archive of every project to w, along
+// with the transports, groups, templates, send windows, rate limits,
+// sender allow-lists, message catalogs, attachments, assets and the emails
+// still waiting in the mail queue. Transport passwords stay encrypted, so
+// the archive can only be restored by a service using the same encryption
+// key. Sent and failed emails, the delivery attempt history of queued
+// emails, webhooks and suppression lists are not included.
+func (s *Service) Snapshot(ctx context.Context, w io.Writer) error {
+	snap, err := s.store.ReadSnapshot(ctx)
+	if err != nil {
+		return errors.Wrapf(err, "[service] store.ReadSnapshot failed")
+	}
+
+	archive := archiveFromSnapshot(snap)
+	archive.Version = snapshotVersion
+	archive.CreatedAt = time.Now().UTC()
+	archive.KeyID = s.encryptionKeyID()
+	if err := json.NewEncoder(w).Encode(archive); err != nil {
+		return errors.Wrapf(err, "[service] json encode snapshot failed")
+	}
+	return nil
+}
+
+// Restore reads an archive written by Snapshot and writes its contents to
+// the store, keeping the original ids and timestamps. The store must be
+// empty and the service must use the encryption key the snapshot was
+// taken with. Emails that were being sent when the snapshot was taken are
+// queued again, so they may be delivered twice.
+func (s *Service) Restore(ctx context.Context, r io.Reader) error {
+	var archive snapshotArchive
+	if err := json.NewDecoder(r).Decode(&archive); err != nil {
+		return entity.NewServiceError(entity.ErrInvalidSnapshotCode, err)
+	}
+	if archive.Version != snapshotVersion {
+		return entity.NewServiceError(entity.ErrInvalidSnapshotCode,
+			fmt.Errorf("unsupported snapshot version %d", archive.Version))
+	}
+	if archive.KeyID != s.encryptionKeyID() {
+		return entity.NewServiceError(entity.ErrSnapshotKeyMismatchCode,
+			fmt.Errorf("snapshot key id %q does not match %q", archive.KeyID, s.encryptionKeyID()))
+	}
+
+	snap, err := snapshotFromArchive(&archive)
+	if err != nil {
+		return err
+	}
+	if err := s.store.RestoreSnapshot(ctx, snap); err != nil {
+		if serr := serviceErrorFromStore(err); serr != nil {
+			return serr
+		}
+		return errors.Wrapf(err, "[service] store.RestoreSnapshot failed")
+	}
+	s.cache.purge()
+	return nil
+}
+
+// archiveFromSnapshot converts the records of snap to their archive
+// format.
+func archiveFromSnapshot(snap *store.Snapshot) *snapshotArchive {
+	var archive snapshotArchive
+	for _, r := range snap.Projects {
+		archive.Projects = append(archive.Projects, snapshotProject{
+			ID:                      r.ProjectID,
+			Name:                    r.ProjectName,
+			Description:             r.Description,
+			AllowedRecipientDomains: r.AllowedRecipientDomains,
+			DefaultTransportID:      r.DefaultTransportID,
+			DefaultGroupID:          r.DefaultGroupID,
+			TransportChain:          r.TransportChain,
+			Labels:                  r.Labels,
+			Variables:               r.Variables,
+			CreatedAt:               time.Time(r.CreatedAt),
+		})
+	}
+	for _, r := range snap.SMTPTransports {
+		archive.SMTPTransports = append(archive.SMTPTransports, snapshotSMTPTransport{
+			ID:                r.SMTPTransportID,
+			ProjectID:         r.ProjectID,
+			Name:              r.TransportName,
+			Host:              r.Host,
+			Port:              r.Port,
+			Username:          r.Username,
+			EncryptedPassword: r.EncryptedPassword,
+			EmailFrom:         r.EmailFrom,
+			EmailFromName:     r.EmailFromName,
+			EmailReplyTo:      r.EmailReplyTo,
+			WarmupSchedule:    r.WarmupSchedule,
+			WarmupStartedAt:   time.Time(r.WarmupStartedAt),
+			CreatedAt:         time.Time(r.CreatedAt),
+			ModifiedAt:        time.Time(r.ModifiedAt),
+
+			TLSMode:               r.TLSMode,
+			TLSInsecureSkipVerify: r.TLSInsecureSkipVerify,
+			TLSCABundle:           r.TLSCABundle,
+
+			ConnectTimeoutMS: r.ConnectTimeoutMS,
+			SendTimeoutMS:    r.SendTimeoutMS,
+		})
+	}
+	for _, r := range snap.APITransports {
+		archive.APITransports = append(archive.APITransports, snapshotAPITransport{
+			ID:              r.APITransportID,
+			ProjectID:       r.ProjectID,
+			Name:            r.TransportName,
+			Provider:        r.Provider,
+			Config:          r.Config,
+			EncryptedAPIKey: r.EncryptedAPIKey,
+			EmailFrom:       r.EmailFrom,
+			EmailFromName:   r.EmailFromName,
+			EmailReplyTo:    r.EmailReplyTo,
+			CreatedAt:       time.Time(r.CreatedAt),
+			ModifiedAt:      time.Time(r.ModifiedAt),
+		})
+	}
+	for _, r := range snap.Groups {
+		archive.Groups = append(archive.Groups, snapshotGroup{
+			ID:         r.GroupID,
+			ProjectID:  r.ProjectID,
+			Name:       r.GroupName,
+			CreatedAt:  time.Time(r.CreatedAt),
+			ModifiedAt: time.Time(r.ModifiedAt),
+		})
+	}
+	for _, r := range snap.Templates {
+		archive.Templates = append(archive.Templates, snapshotTemplate{
+			ID:         r.TemplateID,
+			ProjectID:  r.ProjectID,
+			GroupID:    r.GroupID,
+			Text:       r.Txt,
+			TextDigest: r.TxtDigest,
+			HTML:       r.HTML,
+			HTMLDigest: r.HTMLDigest,
+			Subject:    r.Subject,
+			SourceType: r.SourceType,
+			Source:     r.Source,
+			AssetMode:  r.AssetMode,
+			Labels:     r.Labels,
+			CreatedAt:  time.Time(r.CreatedAt),
+			ModifiedAt: time.Time(r.ModifiedAt),
+		})
+	}
+	for _, r := range snap.Partials {
+		archive.Partials = append(archive.Partials, snapshotPartial{
+			Name:       r.PartialName,
+			ProjectID:  r.ProjectID,
+			GroupID:    r.GroupID,
+			Text:       r.Txt,
+			HTML:       r.HTML,
+			CreatedAt:  time.Time(r.CreatedAt),
+			ModifiedAt: time.Time(r.ModifiedAt),
+		})
+	}
+	for _, r := range snap.TemplateVariants {
+		archive.TemplateVariants = append(archive.TemplateVariants, snapshotTemplateVariant{
+			TemplateID: r.TemplateID,
+			ProjectID:  r.ProjectID,
+			Locale:     r.Locale,
+			Text:       r.Txt,
+			TextDigest: r.TxtDigest,
+			HTML:       r.HTML,
+			HTMLDigest: r.HTMLDigest,
+			Subject:    r.Subject,
+			CreatedAt:  time.Time(r.CreatedAt),
+			ModifiedAt: time.Time(r.ModifiedAt),
+		})
+	}
+	for _, r := range snap.TemplateFixtures {
+		archive.TemplateFixtures = append(archive.TemplateFixtures, snapshotTemplateFixture{
+			TemplateID: r.TemplateID,
+			ProjectID:  r.ProjectID,
+			Name:       r.Name,
+			Params:     r.Params,
+			CreatedAt:  time.Time(r.CreatedAt),
+			ModifiedAt: time.Time(r.ModifiedAt),
+		})
+	}
+	for _, r := range snap.SendWindows {
+		archive.SendWindows = append(archive.SendWindows, snapshotSendWindow{
+			ProjectID:  r.ProjectID,
+			GroupID:    r.GroupID,
+			StartTime:  r.StartTime,
+			EndTime:    r.EndTime,
+			Timezone:   r.Timezone,
+			CreatedAt:  time.Time(r.CreatedAt),
+			ModifiedAt: time.Time(r.ModifiedAt),
+		})
+	}
+	for _, r := range snap.RateLimits {
+		archive.RateLimits = append(archive.RateLimits, snapshotRateLimit{
+			ProjectID:   r.ProjectID,
+			TransportID: r.TransportID,
+			PerSecond:   r.PerSecond,
+			PerMinute:   r.PerMinute,
+			PerDay:      r.PerDay,
+			CreatedAt:   time.Time(r.CreatedAt),
+			ModifiedAt:  time.Time(r.ModifiedAt),
+		})
+	}
+	for _, r := range snap.SenderAllowLists {
+		archive.SenderAllowLists = append(archive.SenderAllowLists, snapshotSenderAllowList{
+			ProjectID:   r.ProjectID,
+			TransportID: r.TransportID,
+			Emails:      r.Emails,
+			CreatedAt:   time.Time(r.CreatedAt),
+			ModifiedAt:  time.Time(r.ModifiedAt),
+		})
+	}
+	for _, r := range snap.MessageCatalogs {
+		archive.MessageCatalogs = append(archive.MessageCatalogs, snapshotMessageCatalog{
+			ProjectID:  r.ProjectID,
+			Locale:     r.Locale,
+			Messages:   json.RawMessage(r.Messages),
+			CreatedAt:  time.Time(r.CreatedAt),
+			ModifiedAt: time.Time(r.ModifiedAt),
+		})
+	}
+	for _, r := range snap.Attachments {
+		archive.Attachments = append(archive.Attachments, snapshotFile{
+			ID:          r.AttachmentID,
+			ProjectID:   r.ProjectID,
+			Filename:    r.Filename,
+			ContentType: r.ContentType,
+			Content:     r.Content,
+			Checksum:    r.Checksum,
+			CreatedAt:   time.Time(r.CreatedAt),
+			ModifiedAt:  time.Time(r.ModifiedAt),
+		})
+	}
+	for _, r := range snap.TemplateAttachments {
+		archive.TemplateAttachments = append(archive.TemplateAttachments, snapshotTemplateAttachment{
+			ProjectID:    r.ProjectID,
+			TemplateID:   r.TemplateID,
+			AttachmentID: r.AttachmentID,
+			Position:     r.Position,
+		})
+	}
+	for _, r := range snap.Assets {
+		archive.Assets = append(archive.Assets, snapshotFile{
+			ID:          r.AssetID,
+			ProjectID:   r.ProjectID,
+			Filename:    r.Filename,
+			ContentType: r.ContentType,
+			Content:     r.Content,
+			Checksum:    r.Checksum,
+			CreatedAt:   time.Time(r.CreatedAt),
+			ModifiedAt:  time.Time(r.ModifiedAt),
+		})
+	}
+	for _, r := range snap.MailQueue {
+		archive.MailQueue = append(archive.MailQueue, snapshotMailQueue{
+			ID:             r.MailQueueID,
+			ProjectID:      r.ProjectID,
+			TemplateID:     r.TemplateID,
+			TransportID:    r.TransportID,
+			State:          r.MState,
+			Metadata:       r.Metadata,
+			Body:           r.Body,
+			LastError:      r.LastError,
+			Attempts:       r.Attempts,
+			SendAt:         time.Time(r.SendAt),
+			NextAttemptAt:  time.Time(r.NextAttemptAt),
+			DeferralReason: r.DeferralReason,
+			CreatedAt:      time.Time(r.CreatedAt),
+			ModifiedAt:     time.Time(r.ModifiedAt),
+		})
+	}
+	return &archive
+}
+
+// snapshotFromArchive converts the records of an archive to store records,
+// checking the message catalogs and file checksums.
+func snapshotFromArchive(archive *snapshotArchive) (*store.Snapshot, error) {
+	var snap store.Snapshot
+	for _, r := range archive.Projects {
+		snap.Projects = append(snap.Projects, &store.Project{
+			ProjectID:               r.ID,
+			ProjectName:             r.Name,
+			Description:             r.Description,
+			AllowedRecipientDomains: store.JSONArray(nonNilStrings(r.AllowedRecipientDomains)),
+			DefaultTransportID:      r.DefaultTransportID,
+			DefaultGroupID:          r.DefaultGroupID,
+			TransportChain:          store.JSONArray(nonNilStrings(r.TransportChain)),
+			Labels:                  store.JSONObject(r.Labels),
+			Variables:               store.JSONObject(r.Variables),
+			CreatedAt:               store.Datetime(r.CreatedAt),
+		})
+	}
+	for _, r := range archive.SMTPTransports {
+		schedule := r.WarmupSchedule
+		if schedule == nil {
+			schedule = []int{}
+		}
+		snap.SMTPTransports = append(snap.SMTPTransports, &store.SMTPTransport{
+			SMTPTransportID:   r.ID,
+			ProjectID:         r.ProjectID,
+			TransportName:     r.Name,
+			Host:              r.Host,
+			Port:              r.Port,
+			Username:          r.Username,
+			EncryptedPassword: r.EncryptedPassword,
+			EmailFrom:         r.EmailFrom,
+			EmailFromName:     r.EmailFromName,
+			EmailReplyTo:      store.JSONArray(nonNilStrings(r.EmailReplyTo)),
+			WarmupSchedule:    store.JSONIntArray(schedule),
+			WarmupStartedAt:   store.Datetime(r.WarmupStartedAt),
+			CreatedAt:         store.Datetime(r.CreatedAt),
+			ModifiedAt:        store.Datetime(r.ModifiedAt),
+
+			TLSMode:               r.TLSMode,
+			TLSInsecureSkipVerify: r.TLSInsecureSkipVerify,
+			TLSCABundle:           r.TLSCABundle,
+
+			ConnectTimeoutMS: r.ConnectTimeoutMS,
+			SendTimeoutMS:    r.SendTimeoutMS,
+		})
+	}
+	for _, r := range archive.APITransports {
+		config := r.Config
+		if config == nil {
+			config = map[string]string{}
+		}
+		snap.APITransports = append(snap.APITransports, &store.APITransport{
+			APITransportID:  r.ID,
+			ProjectID:       r.ProjectID,
+			TransportName:   r.Name,
+			Provider:        r.Provider,
+			Config:          store.JSONObject(config),
+			EncryptedAPIKey: r.EncryptedAPIKey,
+			EmailFrom:       r.EmailFrom,
+			EmailFromName:   r.EmailFromName,
+			EmailReplyTo:    store.JSONArray(nonNilStrings(r.EmailReplyTo)),
+			CreatedAt:       store.Datetime(r.CreatedAt),
+			ModifiedAt:      store.Datetime(r.ModifiedAt),
+		})
+	}
+	for _, r := range archive.Groups {
+		snap.Groups = append(snap.Groups, &store.Group{
+			GroupID:    r.ID,
+			ProjectID:  r.ProjectID,
+			GroupName:  r.Name,
+			CreatedAt:  store.Datetime(r.CreatedAt),
+			ModifiedAt: store.Datetime(r.ModifiedAt),
+		})
+	}
+	for _, r := range archive.Templates {
+		assetMode := r.AssetMode
+		if assetMode == "" {
+			assetMode = store.AssetModeCID
+		}
+		sourceType := r.SourceType
+		if sourceType == "" {
+			sourceType = store.TemplateSourceHTML
+		}
+		snap.Templates = append(snap.Templates, &store.Template{
+			TemplateID: r.ID,
+			GroupID:    r.GroupID,
+			ProjectID:  r.ProjectID,
+			Txt:        r.Text,
+			TxtDigest:  r.TextDigest,
+			HTML:       r.HTML,
+			HTMLDigest: r.HTMLDigest,
+			Subject:    r.Subject,
+			SourceType: sourceType,
+			Source:     r.Source,
+			AssetMode:  assetMode,
+			Labels:     store.JSONObject(r.Labels),
+			CreatedAt:  store.Datetime(r.CreatedAt),
+			ModifiedAt: store.Datetime(r.ModifiedAt),
+		})
+	}
+	for _, r := range archive.Partials {
+		snap.Partials = append(snap.Partials, &store.Partial{
+			PartialName: r.Name,
+			GroupID:     r.GroupID,
+			ProjectID:   r.ProjectID,
+			Txt:         r.Text,
+			HTML:        r.HTML,
+			CreatedAt:   store.Datetime(r.CreatedAt),
+			ModifiedAt:  store.Datetime(r.ModifiedAt),
+		})
+	}
+	for _, r := range archive.TemplateVariants {
+		snap.TemplateVariants = append(snap.TemplateVariants, &store.TemplateVariant{
+			TemplateID: r.TemplateID,
+			ProjectID:  r.ProjectID,
+			Locale:     r.Locale,
+			Txt:        r.Text,
+			TxtDigest:  r.TextDigest,
+			HTML:       r.HTML,
+			HTMLDigest: r.HTMLDigest,
+			Subject:    r.Subject,
+			CreatedAt:  store.Datetime(r.CreatedAt),
+			ModifiedAt: store.Datetime(r.ModifiedAt),
+		})
+	}
+	for _, r := range archive.TemplateFixtures {
+		snap.TemplateFixtures = append(snap.TemplateFixtures, &store.TemplateFixture{
+			TemplateID: r.TemplateID,
+			ProjectID:  r.ProjectID,
+			Name:       r.Name,
+			Params:     r.Params,
+			CreatedAt:  store.Datetime(r.CreatedAt),
+			ModifiedAt: store.Datetime(r.ModifiedAt),
+		})
+	}
+	for _, r := range archive.SendWindows {
+		snap.SendWindows = append(snap.SendWindows, &store.SendWindow{
+			ProjectID:  r.ProjectID,
+			GroupID:    r.GroupID,
+			StartTime:  r.StartTime,
+			EndTime:    r.EndTime,
+			Timezone:   r.Timezone,
+			CreatedAt:  store.Datetime(r.CreatedAt),
+			ModifiedAt: store.Datetime(r.ModifiedAt),
+		})
+	}
+	for _, r := range archive.RateLimits {
+		snap.RateLimits = append(snap.RateLimits, &store.RateLimit{
+			ProjectID:   r.ProjectID,
+			TransportID: r.TransportID,
+			PerSecond:   r.PerSecond,
+			PerMinute:   r.PerMinute,
+			PerDay:      r.PerDay,
+			CreatedAt:   store.Datetime(r.CreatedAt),
+			ModifiedAt:  store.Datetime(r.ModifiedAt),
+		})
+	}
+	for _, r := range archive.SenderAllowLists {
+		snap.SenderAllowLists = append(snap.SenderAllowLists, &store.SenderAllowList{
+			ProjectID:   r.ProjectID,
+			TransportID: r.TransportID,
+			Emails:      store.JSONArray(r.Emails),
+			CreatedAt:   store.Datetime(r.CreatedAt),
+			ModifiedAt:  store.Datetime(r.ModifiedAt),
+		})
+	}
+	for _, r := range archive.MessageCatalogs {
+		if _, err := parseMessageCatalog(r.Locale, r.Messages); err != nil {
+			return nil, entity.NewServiceError(entity.ErrInvalidSnapshotCode, err)
+		}
+		snap.MessageCatalogs = append(snap.MessageCatalogs, &store.MessageCatalog{
+			ProjectID:  r.ProjectID,
+			Locale:     r.Locale,
+			Messages:   string(r.Messages),
+			CreatedAt:  store.Datetime(r.CreatedAt),
+			ModifiedAt: store.Datetime(r.ModifiedAt),
+		})
+	}
+	for _, r := range archive.Attachments {
+		if err := checkSnapshotFile("attachment", r); err != nil {
+			return nil, err
+		}
+		snap.Attachments = append(snap.Attachments, &store.Attachment{
+			AttachmentID: r.ID,
+			ProjectID:    r.ProjectID,
+			Filename:     r.Filename,
+			ContentType:  r.ContentType,
+			Content:      r.Content,
+			Size:         len(r.Content),
+			Checksum:     r.Checksum,
+			CreatedAt:    store.Datetime(r.CreatedAt),
+			ModifiedAt:   store.Datetime(r.ModifiedAt),
+		})
+	}
+	for _, r := range archive.TemplateAttachments {
+		snap.TemplateAttachments = append(snap.TemplateAttachments, &store.TemplateAttachment{
+			ProjectID:    r.ProjectID,
+			TemplateID:   r.TemplateID,
+			AttachmentID: r.AttachmentID,
+			Position:     r.Position,
+		})
+	}
+	for _, r := range archive.Assets {
+		if err := checkSnapshotFile("asset", r); err != nil {
+			return nil, err
+		}
+		snap.Assets = append(snap.Assets, &store.Asset{
+			AssetID:     r.ID,
+			ProjectID:   r.ProjectID,
+			Filename:    r.Filename,
+			ContentType: r.ContentType,
+			Content:     r.Content,
+			Size:        len(r.Content),
+			Checksum:    r.Checksum,
+			CreatedAt:   store.Datetime(r.CreatedAt),
+			ModifiedAt:  store.Datetime(r.ModifiedAt),
+		})
+	}
+	for _, r := range archive.MailQueue {
+		// a send that was interrupted by the loss of the original
+		// instance has to be tried again. Campaigns are not part of the
+		// snapshot so the emails of a paused campaign are queued as well.
+		state := r.State
+		if state == store.MailQueueStateSending || state == store.MailQueueStatePaused {
+			state = store.MailQueueStateQueued
+		}
+		sendAt := r.SendAt
+		if sendAt.IsZero() {
+			sendAt = r.CreatedAt
+		}
+		snap.MailQueue = append(snap.MailQueue, &store.MailQueue{
+			MailQueueID:    r.ID,
+			ProjectID:      r.ProjectID,
+			TemplateID:     r.TemplateID,
+			TransportID:    r.TransportID,
+			MState:         state,
+			Metadata:       r.Metadata,
+			Body:           r.Body,
+			LastError:      r.LastError,
+			Attempts:       r.Attempts,
+			SendAt:         store.Datetime(sendAt),
+			NextAttemptAt:  store.Datetime(r.NextAttemptAt),
+			DeferralReason: r.DeferralReason,
+			CreatedAt:      store.Datetime(r.CreatedAt),
+			ModifiedAt:     store.Datetime(r.ModifiedAt),
+		})
+	}
+	return &snap, nil
+}
+
+// encryptionKeyID returns a short fingerprint of the encryption key that
+// identifies it without revealing it.
+func (s *Service) encryptionKeyID() string {
+	sum := sha256.Sum256(s.encryptionKey)
+	return hex.EncodeToString(sum[:8])
+}
+
+// checkSnapshotFile checks the content of an attachment or asset has not
+// been altered since the snapshot was taken.
+func checkSnapshotFile(kind string, f snapshotFile) error {
+	if contentDigest(f.Content) != f.Checksum {
+		return entity.NewServiceError(entity.ErrInvalidSnapshotCode,
+			fmt.Errorf("%s %q in project %q does not match its checksum", kind, f.ID, f.ProjectID))
+	}
+	return nil
+}
+
+func nonNilStrings(a []string) []string {
+	if a == nil {
+		return []string{}
+	}
+	return a
+}
@@ -0,0 +1,160 @@
+package service_test
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/andyfusniak/squishy-mailer-lite/entity"
+	"github.com/andyfusniak/squishy-mailer-lite/service"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSnapshotAndRestore(t *testing.T) {
+	srv := newFakeSMTPServer(t)
+	svc := newTestService(t)
+	setupQueueProject(t, svc, srv)
+
+	ctx := context.Background()
+	if _, err := svc.SetAttachment(ctx, entity.SetAttachmentParams{
+		ID:        "terms",
+		ProjectID: "p1",
+		Filename:  "terms.pdf",
+		Content:   []byte("%PDF-1.4 terms and conditions"),
+	}); err != nil {
+		t.Fatalf("svc.SetAttachment failed: %+v", err)
+	}
+	if err := svc.SetTemplateAttachments(ctx, "p1", "t1", []string{"terms"}); err != nil {
+		t.Fatalf("svc.SetTemplateAttachments failed: %+v", err)
+	}
+	if _, err := svc.CreateMailgunTransport(ctx, entity.CreateMailgunTransport{
+		ID:        "mg",
+		ProjectID: "p1",
+		Domain:    "mg.example.com",
+		APIKey:    "secret-api-key",
+		EmailFrom: "noreply@example.com",
+	}); err != nil {
+		t.Fatalf("svc.CreateMailgunTransport failed: %+v", err)
+	}
+	if _, err := svc.SetDefaultTransport(ctx, "p1", "tr1"); err != nil {
+		t.Fatalf("svc.SetDefaultTransport failed: %+v", err)
+	}
+	if _, err := svc.SetPartial(ctx, entity.SetPartialParams{
+		Name:      "footer",
+		ProjectID: "p1",
+		GroupID:   "g1",
+		Text:      "The Team",
+	}); err != nil {
+		t.Fatalf("svc.SetPartial failed: %+v", err)
+	}
+	if _, err := svc.SetTemplateVariant(ctx, entity.SetTemplateVariantParams{
+		TemplateID: "t1",
+		ProjectID:  "p1",
+		Locale:     "fr",
+		Text:       "Bonjour",
+		HTML:       "<p>Bonjour</p>",
+	}); err != nil {
+		t.Fatalf("svc.SetTemplateVariant failed: %+v", err)
+	}
+	queued := queueTestEmail(t, svc)
+
+	var buf bytes.Buffer
+	if err := svc.Snapshot(ctx, &buf); err != nil {
+		t.Fatalf("svc.Snapshot failed: %+v", err)
+	}
+	assert.NotContains(t, buf.String(), "secret")
+
+	// rebuild a fresh instance from the snapshot
+	restored := newTestService(t)
+	if err := restored.Restore(ctx, bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatalf("restored.Restore failed: %+v", err)
+	}
+
+	p, err := restored.GetProject(ctx, "p1")
+	if err != nil {
+		t.Fatalf("restored.GetProject failed: %+v", err)
+	}
+	assert.Equal(t, "tr1", p.DefaultTransportID)
+
+	tr, err := restored.GetAPITransport(ctx, "mg", "p1")
+	if err != nil {
+		t.Fatalf("restored.GetAPITransport failed: %+v", err)
+	}
+	assert.Equal(t, "mg.example.com", tr.Config["domain"])
+
+	list, err := restored.ListTemplateAttachments(ctx, "p1", "t1")
+	if err != nil {
+		t.Fatalf("restored.ListTemplateAttachments failed: %+v", err)
+	}
+	if assert.Len(t, list, 1) {
+		assert.Equal(t, "terms", list[0].ID)
+	}
+
+	partials, err := restored.ListPartials(ctx, "p1", "g1")
+	if err != nil {
+		t.Fatalf("restored.ListPartials failed: %+v", err)
+	}
+	if assert.Len(t, partials, 1) {
+		assert.Equal(t, "The Team", partials[0].Text)
+	}
+
+	variants, err := restored.ListTemplateVariants(ctx, "p1", "t1")
+	if err != nil {
+		t.Fatalf("restored.ListTemplateVariants failed: %+v", err)
+	}
+	if assert.Len(t, variants, 1) {
+		assert.Equal(t, "Bonjour", variants[0].Text)
+	}
+
+	mq, err := restored.GetMailQueue(ctx, "p1", queued.ID)
+	if err != nil {
+		t.Fatalf("restored.GetMailQueue failed: %+v", err)
+	}
+	assert.Equal(t, entity.MailStateQueued, mq.State)
+	assert.Equal(t, queued.CreatedAt, mq.CreatedAt)
+
+	// the restored instance can decrypt the transport password and
+	// deliver the pending email
+	n, err := restored.ProcessMailQueue(ctx)
+	if err != nil {
+		t.Fatalf("restored.ProcessMailQueue failed: %+v", err)
+	}
+	assert.Equal(t, 1, n)
+	msgs := srv.Messages()
+	if assert.Len(t, msgs, 1) {
+		assert.Contains(t, msgs[0].Data, `filename="terms.pdf"`)
+	}
+
+	// a store can only be restored into once
+	err = restored.Restore(ctx, bytes.NewReader(buf.Bytes()))
+	assertServiceErrorCode(t, err, entity.ErrStoreNotEmptyCode)
+}
+
+func TestRestoreValidation(t *testing.T) {
+	svc := newTestService(t)
+	setupTwoProjects(t, svc)
+
+	ctx := context.Background()
+	var buf bytes.Buffer
+	if err := svc.Snapshot(ctx, &buf); err != nil {
+		t.Fatalf("svc.Snapshot failed: %+v", err)
+	}
+
+	// a different encryption key cannot decrypt the transport passwords
+	other := newTestService(t,
+		service.WithHexEncodedEncryptionKey("00112233445566778899aabbccddeeff"))
+	err := other.Restore(ctx, bytes.NewReader(buf.Bytes()))
+	assertServiceErrorCode(t, err, entity.ErrSnapshotKeyMismatchCode)
+
+	empty := newTestService(t)
+	err = empty.Restore(ctx, strings.NewReader(`{"version":99}`))
+	assertServiceErrorCode(t, err, entity.ErrInvalidSnapshotCode)
+
+	err = empty.Restore(ctx, strings.NewReader(`not json`))
+	assertServiceErrorCode(t, err, entity.ErrInvalidSnapshotCode)
+
+	// nothing is written if the restore fails
+	_, err = empty.GetProject(ctx, "pa")
+	assertServiceErrorCode(t, err, entity.ErrProjectNotFoundCode)
+}
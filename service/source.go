@@ -0,0 +1,104 @@
+package service
+
+import (
+	"context"
+
+	"github.com/andyfusniak/squishy-mailer-lite/entity"
+	"github.com/andyfusniak/squishy-mailer-lite/internal/store"
+	"github.com/pkg/errors"
+)
+
+// templateBody is the text and HTML of a template and their digests.
+type templateBody struct {
+	text       string
+	textDigest string
+	html       string
+	htmlDigest string
+}
+
+// fillDigests sets the empty digests of b to the digests of its bodies.
+func (b *templateBody) fillDigests() {
+	if b.textDigest == "" {
+		b.textDigest = contentDigest([]byte(b.text))
+	}
+	if b.htmlDigest == "" {
+		b.htmlDigest = contentDigest([]byte(b.html))
+	}
+}
+
+// checkDigest returns an ErrInvalidTemplateCode service error if the
+// given digest is set and differs from the computed one.
+func checkDigest(name, given, computed string) error {
+	if given != "" && given != computed {
+		return entity.NewServiceError(entity.ErrInvalidTemplateCode,
+			errors.Errorf("%s digest %q does not match the %s digest %q", name, given, name, computed))
+	}
+	return nil
+}
+
+// compileTemplateBody is compileSource for a template being saved. The digests
+// are computed from the bodies, and any set in body must match them so
+// that a stale digest never hides a changed body.
+func (s *Service) compileTemplateBody(ctx context.Context, sourceType entity.TemplateSource, source string, body templateBody, prev *store.Template) (entity.TemplateSource, templateBody, error) {
+	given := body
+	body.textDigest, body.htmlDigest = "", ""
+	sourceType, body, err := s.compileSource(ctx, sourceType, source, body, prev)
+	if err != nil {
+		return "", templateBody{}, err
+	}
+	if err := checkDigest("text", given.textDigest, body.textDigest); err != nil {
+		return "", templateBody{}, err
+	}
+	if err := checkDigest("html", given.htmlDigest, body.htmlDigest); err != nil {
+		return "", templateBody{}, err
+	}
+	return sourceType, body, nil
+}
+
+// compileSource returns the bodies of a template of the given source type.
+// HTML templates are returned as given. MJML templates must not set the
+// HTML, which is compiled from the source unless prev is an MJML template
+// with the same HTML digest in which case its HTML is reused. Markdown
+// templates must set neither body since both are rendered from the source.
+// Empty digests are computed from the bodies, or for the HTML of MJML
+// templates from the source.
+func (s *Service) compileSource(ctx context.Context, sourceType entity.TemplateSource, source string, body templateBody, prev *store.Template) (entity.TemplateSource, templateBody, error) {
+	switch sourceType {
+	case "", entity.TemplateSourceHTML:
+		if source != "" {
+			return "", templateBody{}, entity.NewServiceError(entity.ErrInvalidTemplateCode,
+				errors.New("source is only used by mjml and markdown templates"))
+		}
+		body.fillDigests()
+		return entity.TemplateSourceHTML, body, nil
+	case entity.TemplateSourceMJML:
+		if body.html != "" {
+			return "", templateBody{}, entity.NewServiceError(entity.ErrInvalidTemplateCode,
+				errors.New("mjml templates take their html from the compiled source"))
+		}
+		if body.htmlDigest == "" {
+			body.htmlDigest = contentDigest([]byte(source))
+		}
+		body.fillDigests()
+		if prev != nil && prev.SourceType == store.TemplateSourceMJML && prev.HTMLDigest == body.htmlDigest {
+			body.html = prev.HTML
+			return sourceType, body, nil
+		}
+		html, err := s.compileMJML(ctx, source)
+		if err != nil {
+			return "", templateBody{}, err
+		}
+		body.html = html
+		return sourceType, body, nil
+	case entity.TemplateSourceMarkdown:
+		if body.text != "" || body.html != "" {
+			return "", templateBody{}, entity.NewServiceError(entity.ErrInvalidTemplateCode,
+				errors.New("markdown templates take their text and html from the source"))
+		}
+		body.text, body.html = s.renderMarkdown(source)
+		body.fillDigests()
+		return sourceType, body, nil
+	}
+	return "", templateBody{}, entity.NewServiceError(entity.ErrInvalidTemplateCode,
+		errors.Errorf("unknown template source type %q", sourceType))
+}
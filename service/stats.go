@@ -0,0 +1,155 @@
+package service
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/andyfusniak/squishy-mailer-lite/entity"
+	"github.com/andyfusniak/squishy-mailer-lite/internal/store"
+	"github.com/pkg/errors"
+)
+
+// GetProjectStats reports the emails of a project queued at or after from
+// and before to, in total and by day, template and transport. Emails
+// queued by TestSend are left out. A zero to means now. If from is not before to an error is returned with a code of
+// ErrInvalidStatsRangeCode. If the project does not exist an error is
+// returned with a code of ErrProjectNotFoundCode.
+func (s *Service) GetProjectStats(ctx context.Context, projectID string, from, to time.Time) (*entity.ProjectStats, error) {
+	if to.IsZero() {
+		to = time.Now()
+	}
+	if !from.Before(to) {
+		return nil, entity.NewServiceError(entity.ErrInvalidStatsRangeCode,
+			errors.Errorf("from %s is not before to %s",
+				from.UTC().Format(time.RFC3339), to.UTC().Format(time.RFC3339)))
+	}
+
+	project, err := s.store.GetProject(ctx, projectID)
+	if err != nil {
+		if serr := serviceErrorFromStore(err); serr != nil {
+			return nil, serr
+		}
+		return nil, errors.Wrapf(err, "[service] store.GetProject failed")
+	}
+	if err := checkProjectScope("project", projectID, project.ProjectID); err != nil {
+		return nil, err
+	}
+
+	list, err := s.store.CountMailQueueByDay(ctx, store.CountMailQueueByDay{
+		ProjectID: projectID,
+		From:      store.Datetime(from.UTC()),
+		To:        store.Datetime(to.UTC()),
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "[service] store.CountMailQueueByDay failed")
+	}
+
+	stats := &entity.ProjectStats{
+		ProjectID:  projectID,
+		From:       entity.ISOTime(from.UTC()),
+		To:         entity.ISOTime(to.UTC()),
+		Days:       make([]*entity.DayMailCounts, 0),
+		Templates:  make([]*entity.TemplateMailCounts, 0),
+		Transports: make([]*entity.TransportMailCounts, 0),
+	}
+	days := make(map[string]*entity.DayMailCounts)
+	templates := make(map[string]*entity.TemplateMailCounts)
+	transports := make(map[string]*entity.TransportMailCounts)
+	for _, c := range list {
+		d, ok := days[c.Day]
+		if !ok {
+			d = &entity.DayMailCounts{Day: c.Day}
+			days[c.Day] = d
+			stats.Days = append(stats.Days, d)
+		}
+		t, ok := templates[c.TemplateID]
+		if !ok {
+			t = &entity.TemplateMailCounts{TemplateID: c.TemplateID}
+			templates[c.TemplateID] = t
+			stats.Templates = append(stats.Templates, t)
+		}
+		tr, ok := transports[c.TransportID]
+		if !ok {
+			tr = &entity.TransportMailCounts{TransportID: c.TransportID}
+			transports[c.TransportID] = tr
+			stats.Transports = append(stats.Transports, tr)
+		}
+		for _, mc := range []*entity.MailCounts{&stats.Total, &d.MailCounts, &t.MailCounts, &tr.MailCounts} {
+			addMailCounts(mc, c.MState, c.Count)
+		}
+	}
+	sort.Slice(stats.Templates, func(i, j int) bool {
+		return stats.Templates[i].TemplateID < stats.Templates[j].TemplateID
+	})
+	sort.Slice(stats.Transports, func(i, j int) bool {
+		return stats.Transports[i].TransportID < stats.Transports[j].TransportID
+	})
+	return stats, nil
+}
+
+// queueStatsWindow is how far back GetQueueStats counts delivery attempts.
+const queueStatsWindow = time.Hour
+
+// GetQueueStats reports the emails in the mail queue of a project by state,
+// the age of the oldest email waiting to be sent and the fraction of
+// delivery attempts that failed in the last hour, so that callers can hold
+// back new emails while the queue is backed up. If the project does not
+// exist an error is returned with a code of ErrProjectNotFoundCode.
+func (s *Service) GetQueueStats(ctx context.Context, projectID string) (*entity.QueueStats, error) {
+	project, err := s.store.GetProject(ctx, projectID)
+	if err != nil {
+		if serr := serviceErrorFromStore(err); serr != nil {
+			return nil, serr
+		}
+		return nil, errors.Wrapf(err, "[service] store.GetProject failed")
+	}
+	if err := checkProjectScope("project", projectID, project.ProjectID); err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	ms, err := s.store.GetMailQueueStats(ctx, projectID, store.Datetime(now.Add(-queueStatsWindow)))
+	if err != nil {
+		return nil, errors.Wrapf(err, "[service] store.GetMailQueueStats failed")
+	}
+
+	stats := &entity.QueueStats{
+		ProjectID:      projectID,
+		States:         make(map[entity.MailState]int, len(ms.States)),
+		Attempts:       ms.Attempts,
+		FailedAttempts: ms.FailedAttempts,
+		CheckedAt:      entity.ISOTime(now),
+	}
+	for mstate, n := range ms.States {
+		stats.States[entity.MailState(mstate)] = n
+	}
+	for _, mstate := range store.MailQueuePendingStates {
+		stats.Pending += ms.States[mstate]
+	}
+	if oldest := time.Time(ms.OldestPendingCreatedAt); !oldest.IsZero() {
+		stats.OldestPendingAt = entity.ISOTime(oldest)
+		stats.OldestPendingAge = max(now.Sub(oldest), 0)
+	}
+	if ms.Attempts > 0 {
+		stats.FailureRate = float64(ms.FailedAttempts) / float64(ms.Attempts)
+	}
+	return stats, nil
+}
+
+// addMailCounts adds n emails in state mstate to c.
+func addMailCounts(c *entity.MailCounts, mstate string, n int) {
+	c.Queued += n
+	switch mstate {
+	case store.MailQueueStateSent:
+		c.Sent += n
+	case store.MailQueueStateFailed:
+		c.Failed += n
+	case store.MailQueueStateBounced:
+		c.Bounced += n
+	case store.MailQueueStateBlocked:
+		c.Blocked += n
+	case store.MailQueueStateCancelled:
+		c.Cancelled += n
+	}
+}
@@ -0,0 +1,132 @@
+package service_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/andyfusniak/squishy-mailer-lite/entity"
+	"github.com/andyfusniak/squishy-mailer-lite/service"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetProjectStats(t *testing.T) {
+	stores := []struct {
+		name string
+		opts []service.Option
+	}{
+		{"sqlite3", nil},
+		{"memory", []service.Option{service.WithInMemoryStore()}},
+	}
+	for _, st := range stores {
+		t.Run(st.name, func(t *testing.T) {
+			srv := newFakeSMTPServer(t)
+			svc := newTestService(t, st.opts...)
+			setupQueueProject(t, svc, srv)
+			ctx := context.Background()
+
+			start := time.Now().Add(-time.Minute)
+			queueTestEmail(t, svc)
+			queueTestEmail(t, svc)
+			if _, err := svc.ProcessMailQueue(ctx); err != nil {
+				t.Fatalf("svc.ProcessMailQueue failed: %+v", err)
+			}
+			mq := queueTestEmail(t, svc)
+			if _, err := svc.CancelMailQueue(ctx, "p1", mq.ID); err != nil {
+				t.Fatalf("svc.CancelMailQueue failed: %+v", err)
+			}
+
+			stats, err := svc.GetProjectStats(ctx, "p1", start, time.Time{})
+			if err != nil {
+				t.Fatalf("svc.GetProjectStats failed: %+v", err)
+			}
+			want := entity.MailCounts{Queued: 3, Sent: 2, Cancelled: 1}
+			assert.Equal(t, want, stats.Total)
+			if assert.Len(t, stats.Days, 1) {
+				assert.Equal(t, time.Time(mq.CreatedAt).UTC().Format(time.DateOnly), stats.Days[0].Day)
+				assert.Equal(t, want, stats.Days[0].MailCounts)
+			}
+			assert.Equal(t, []*entity.TemplateMailCounts{{TemplateID: "t1", MailCounts: want}}, stats.Templates)
+			assert.Equal(t, []*entity.TransportMailCounts{{TransportID: "tr1", MailCounts: want}}, stats.Transports)
+
+			// emails outside of the range are not counted
+			stats, err = svc.GetProjectStats(ctx, "p1", start.Add(-time.Hour), start)
+			if err != nil {
+				t.Fatalf("svc.GetProjectStats failed: %+v", err)
+			}
+			assert.Equal(t, entity.MailCounts{}, stats.Total)
+			assert.Empty(t, stats.Days)
+
+			_, err = svc.GetProjectStats(ctx, "p1", time.Now().Add(time.Hour), time.Time{})
+			assertServiceErrorCode(t, err, entity.ErrInvalidStatsRangeCode)
+
+			_, err = svc.GetProjectStats(ctx, "missing", start, time.Time{})
+			assertServiceErrorCode(t, err, entity.ErrProjectNotFoundCode)
+		})
+	}
+}
+
+func TestGetQueueStats(t *testing.T) {
+	stores := []struct {
+		name string
+		opts []service.Option
+	}{
+		{"sqlite3", nil},
+		{"memory", []service.Option{service.WithInMemoryStore()}},
+	}
+	for _, st := range stores {
+		t.Run(st.name, func(t *testing.T) {
+			srv := newFakeSMTPServer(t)
+			svc := newTestService(t, st.opts...)
+			setupQueueProject(t, svc, srv)
+			ctx := context.Background()
+
+			stats, err := svc.GetQueueStats(ctx, "p1")
+			if err != nil {
+				t.Fatalf("svc.GetQueueStats failed: %+v", err)
+			}
+			assert.Empty(t, stats.States)
+			assert.Zero(t, stats.Pending)
+			assert.True(t, time.Time(stats.OldestPendingAt).IsZero())
+			assert.Zero(t, stats.FailureRate)
+
+			queueTestEmail(t, svc)
+			queueTestEmail(t, svc)
+			if _, err := svc.SendEmailAsync(ctx, entity.SendEmailParams{
+				TemplateID:     "t1",
+				ProjectID:      "p1",
+				TransportID:    "tr1",
+				To:             []string{"reject@example.com"},
+				TemplateParams: map[string]string{"name": "Andy"},
+			}); err != nil {
+				t.Fatalf("svc.SendEmailAsync failed: %+v", err)
+			}
+			if _, err := svc.ProcessMailQueue(ctx); err != nil {
+				t.Fatalf("svc.ProcessMailQueue failed: %+v", err)
+			}
+			oldest := queueTestEmail(t, svc)
+			queueTestEmail(t, svc)
+
+			stats, err = svc.GetQueueStats(ctx, "p1")
+			if err != nil {
+				t.Fatalf("svc.GetQueueStats failed: %+v", err)
+			}
+			assert.Equal(t, "p1", stats.ProjectID)
+			assert.Equal(t, map[entity.MailState]int{
+				entity.MailStateQueued: 2,
+				entity.MailStateSent:   2,
+				entity.MailStateFailed: 1,
+			}, stats.States)
+			assert.Equal(t, 2, stats.Pending)
+			assert.WithinDuration(t, time.Time(oldest.CreatedAt), time.Time(stats.OldestPendingAt), time.Millisecond)
+			assert.GreaterOrEqual(t, stats.OldestPendingAge, time.Duration(0))
+			assert.Less(t, stats.OldestPendingAge, time.Minute)
+			assert.Equal(t, 3, stats.Attempts)
+			assert.Equal(t, 1, stats.FailedAttempts)
+			assert.InDelta(t, 1.0/3, stats.FailureRate, 1e-9)
+
+			_, err = svc.GetQueueStats(ctx, "missing")
+			assertServiceErrorCode(t, err, entity.ErrProjectNotFoundCode)
+		})
+	}
+}
@@ -0,0 +1,139 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"net/mail"
+	"strings"
+
+	"github.com/andyfusniak/squishy-mailer-lite/entity"
+	"github.com/andyfusniak/squishy-mailer-lite/internal/store"
+	"github.com/pkg/errors"
+)
+
+// AddSuppression adds an address to the suppression list of a project, or
+// replaces the reason of an address already on the list. Emails queued to
+// a suppressed address are placed in the blocked state and synchronous
+// sends fail with ErrRecipientSuppressedCode.
+func (s *Service) AddSuppression(ctx context.Context, params entity.AddSuppressionParams) (*entity.Suppression, error) {
+	addr, err := mail.ParseAddress(params.Email)
+	if err != nil {
+		return nil, entity.NewServiceError(entity.ErrInvalidSuppressionCode,
+			fmt.Errorf("invalid email address %q: %w", params.Email, err))
+	}
+	reason := params.Reason
+	switch reason {
+	case "":
+		reason = entity.SuppressionReasonManual
+	case entity.SuppressionReasonBounce, entity.SuppressionReasonComplaint, entity.SuppressionReasonManual,
+		entity.SuppressionReasonUnsubscribe:
+	default:
+		return nil, entity.NewServiceError(entity.ErrInvalidSuppressionCode,
+			fmt.Errorf("unknown suppression reason %q", reason))
+	}
+	return s.suppress(ctx, params.ProjectID, addr.Address, reason, params.Detail)
+}
+
+func (s *Service) suppress(ctx context.Context, projectID, addr string, reason entity.SuppressionReason, detail string) (*entity.Suppression, error) {
+	obj, err := s.store.SetSuppression(ctx, store.SetSuppression{
+		ProjectID: projectID,
+		Email:     addr,
+		Reason:    string(reason),
+		Detail:    detail,
+	})
+	if err != nil {
+		if serr := serviceErrorFromStore(err); serr != nil {
+			return nil, serr
+		}
+		return nil, errors.Wrapf(err, "[service] store.SetSuppression failed")
+	}
+	if err := checkProjectScope("suppression", projectID, obj.ProjectID); err != nil {
+		return nil, err
+	}
+	return suppressionFromStoreObject(obj), nil
+}
+
+// ListSuppressions lists the suppression list of a project ordered by
+// address.
+func (s *Service) ListSuppressions(ctx context.Context, params entity.ListSuppressionsParams) ([]*entity.Suppression, error) {
+	list, err := s.store.ListSuppressions(ctx, store.ListSuppressions{
+		ProjectID: params.ProjectID,
+		After:     strings.ToLower(params.After),
+		Limit:     params.Limit,
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "[service] store.ListSuppressions failed")
+	}
+
+	suppressions := make([]*entity.Suppression, 0, len(list))
+	for _, obj := range list {
+		if err := checkProjectScope("suppression", params.ProjectID, obj.ProjectID); err != nil {
+			return nil, err
+		}
+		suppressions = append(suppressions, suppressionFromStoreObject(obj))
+	}
+	return suppressions, nil
+}
+
+// DeleteSuppression removes an address from the suppression list of a
+// project so that it can be sent to again. If the address is not on the
+// list an error is returned with a code of ErrSuppressionNotFoundCode.
+func (s *Service) DeleteSuppression(ctx context.Context, projectID, email string) error {
+	if err := s.store.DeleteSuppression(ctx, projectID, email); err != nil {
+		if serr := serviceErrorFromStore(err); serr != nil {
+			return serr
+		}
+		return errors.Wrapf(err, "[service] store.DeleteSuppression failed")
+	}
+	return nil
+}
+
+// suppressedRecipients returns the recipients that are on the project's
+// suppression list, or nil if there are none.
+func (s *Service) suppressedRecipients(ctx context.Context, projectID string, recipients ...[]string) ([]string, error) {
+	var addrs []string
+	for _, list := range recipients {
+		for _, r := range list {
+			addrs = append(addrs, recipientAddress(r))
+		}
+	}
+	if len(addrs) == 0 {
+		return nil, nil
+	}
+
+	found, err := s.store.FindSuppressions(ctx, projectID, addrs)
+	if err != nil {
+		return nil, errors.Wrapf(err, "[service] store.FindSuppressions failed")
+	}
+	var suppressed []string
+	for _, obj := range found {
+		suppressed = append(suppressed, obj.Email)
+	}
+	return suppressed, nil
+}
+
+// recipientAddress returns the address part of a recipient, which may
+// include a display name.
+func recipientAddress(addr string) string {
+	if a, err := mail.ParseAddress(addr); err == nil {
+		return a.Address
+	}
+	return strings.TrimSpace(addr)
+}
+
+// suppressedReason is the error recorded against an email that was not
+// sent because of the suppression list.
+func suppressedReason(suppressed []string) string {
+	return fmt.Sprintf("recipients on the project suppression list: %s", strings.Join(suppressed, ", "))
+}
+
+func suppressionFromStoreObject(obj *store.Suppression) *entity.Suppression {
+	return &entity.Suppression{
+		ProjectID:  obj.ProjectID,
+		Email:      obj.Email,
+		Reason:     entity.SuppressionReason(obj.Reason),
+		Detail:     obj.Detail,
+		CreatedAt:  entity.ISOTime(obj.CreatedAt),
+		ModifiedAt: entity.ISOTime(obj.ModifiedAt),
+	}
+}
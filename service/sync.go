@@ -0,0 +1,220 @@
+package service
+
+import (
+	"context"
+	htmltemplate "html/template"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	txttemplate "text/template"
+
+	"github.com/andyfusniak/squishy-mailer-lite/entity"
+	"github.com/andyfusniak/squishy-mailer-lite/internal/store"
+	"github.com/pkg/errors"
+)
+
+// template file extensions read by SyncTemplatesFromDir
+const (
+	syncExtText    = ".txt"
+	syncExtHTML    = ".html"
+	syncExtSubject = ".subject"
+)
+
+// SyncTemplatesFromDir creates or updates the groups and templates of a
+// project from a directory tree laid out as
+//
+//	dir/{group id}/{template id}.txt
+//	dir/{group id}/{template id}.html
+//	dir/{group id}/{template id}.subject
+//
+// Every template must have a .txt and a .html file. The .subject file is
+// optional and sets the template's default subject. Groups that do not
+// exist are created using the group id as the name. Templates are
+// compared using their digests, so syncing an unchanged tree changes
+// nothing. Other files, and templates in the project missing from the
+// tree, are left alone. A template cannot be moved to a different group
+// by a sync.
+func (s *Service) SyncTemplatesFromDir(ctx context.Context, projectID, dir string) (*entity.SyncTemplatesReport, error) {
+	return s.syncTemplatesFromFS(ctx, projectID, os.DirFS(dir))
+}
+
+// syncTemplate is a template read from a template tree.
+type syncTemplate struct {
+	id, groupID        string
+	txt, html, subject []byte
+
+	// txtName and htmlName are the paths of the files read
+	txtName, htmlName string
+}
+
+func (s *Service) syncTemplatesFromFS(ctx context.Context, projectID string, fsys fs.FS) (*entity.SyncTemplatesReport, error) {
+	templates, err := readTemplateTree(fsys)
+	if err != nil {
+		return nil, err
+	}
+
+	existing, err := s.store.ListTemplates(ctx, store.ListTemplates{ProjectID: projectID})
+	if err != nil {
+		return nil, errors.Wrapf(err, "[service] store.ListTemplates failed")
+	}
+	current := make(map[string]*store.Template, len(existing))
+	for _, t := range existing {
+		current[t.TemplateID] = t
+	}
+
+	report := entity.SyncTemplatesReport{
+		GroupsCreated:      []string{},
+		TemplatesCreated:   []string{},
+		TemplatesUpdated:   []string{},
+		TemplatesUnchanged: []string{},
+	}
+	groups := make(map[string]bool)
+	for _, t := range templates {
+		if cur, ok := current[t.id]; ok && cur.GroupID != t.groupID {
+			return nil, errors.Errorf("[service] template %q belongs to group %q not %q",
+				t.id, cur.GroupID, t.groupID)
+		}
+
+		if !groups[t.groupID] {
+			created, err := s.ensureGroup(ctx, projectID, t.groupID)
+			if err != nil {
+				return nil, err
+			}
+			if created {
+				report.GroupsCreated = append(report.GroupsCreated, t.groupID)
+			}
+			groups[t.groupID] = true
+		}
+
+		params := entity.SetTemplateParams{
+			ID:         t.id,
+			ProjectID:  projectID,
+			GroupID:    t.groupID,
+			Text:       string(t.txt),
+			TextDigest: contentDigest(t.txt),
+			HTML:       string(t.html),
+			HTMLDigest: contentDigest(t.html),
+			Subject:    strings.TrimSpace(string(t.subject)),
+		}
+		if _, err := s.SetTemplate(ctx, params); err != nil {
+			return nil, err
+		}
+
+		cur, ok := current[t.id]
+		switch {
+		case !ok:
+			report.TemplatesCreated = append(report.TemplatesCreated, t.id)
+		case cur.TxtDigest != params.TextDigest || cur.HTMLDigest != params.HTMLDigest ||
+			cur.Subject != params.Subject:
+			report.TemplatesUpdated = append(report.TemplatesUpdated, t.id)
+		default:
+			report.TemplatesUnchanged = append(report.TemplatesUnchanged, t.id)
+		}
+	}
+	return &report, nil
+}
+
+// ensureGroup creates the group if it does not exist. It reports whether
+// the group was created.
+func (s *Service) ensureGroup(ctx context.Context, projectID, groupID string) (bool, error) {
+	_, err := s.store.GetGroup(ctx, projectID, groupID)
+	if err == nil {
+		return false, nil
+	}
+	var storeErr *store.Error
+	if !errors.As(err, &storeErr) || storeErr.Code != store.ErrGroupNotFound {
+		if serr := serviceErrorFromStore(err); serr != nil {
+			return false, serr
+		}
+		return false, errors.Wrapf(err, "[service] store.GetGroup failed")
+	}
+	if _, err := s.CreateGroup(ctx, groupID, projectID, groupID); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// readTemplateTree reads the templates of a template tree ordered by group
+// and template id. The text and HTML of each template are checked to
+// parse.
+func readTemplateTree(fsys fs.FS) ([]*syncTemplate, error) {
+	groupDirs, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, errors.Wrapf(err, "[service] fs.ReadDir failed")
+	}
+
+	var templates []*syncTemplate
+	for _, gd := range groupDirs {
+		if !gd.IsDir() {
+			continue
+		}
+		files, err := fs.ReadDir(fsys, gd.Name())
+		if err != nil {
+			return nil, errors.Wrapf(err, "[service] fs.ReadDir failed")
+		}
+
+		byID := make(map[string]*syncTemplate)
+		for _, f := range files {
+			ext := path.Ext(f.Name())
+			if f.IsDir() || (ext != syncExtText && ext != syncExtHTML && ext != syncExtSubject) {
+				continue
+			}
+			id := strings.TrimSuffix(f.Name(), ext)
+			t, ok := byID[id]
+			if !ok {
+				t = &syncTemplate{id: id, groupID: gd.Name()}
+				byID[id] = t
+			}
+
+			name := path.Join(gd.Name(), f.Name())
+			content, err := fs.ReadFile(fsys, name)
+			if err != nil {
+				return nil, errors.Wrapf(err, "[service] fs.ReadFile failed")
+			}
+			switch ext {
+			case syncExtText:
+				t.txt, t.txtName = content, name
+			case syncExtHTML:
+				t.html, t.htmlName = content, name
+			case syncExtSubject:
+				t.subject = content
+			}
+		}
+
+		ids := make([]string, 0, len(byID))
+		for id := range byID {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+		for _, id := range ids {
+			t := byID[id]
+			if t.txtName == "" || t.htmlName == "" {
+				return nil, errors.Errorf("[service] template %s/%s needs both a %s and a %s file",
+					t.groupID, t.id, syncExtText, syncExtHTML)
+			}
+			if err := checkTemplateSource(t); err != nil {
+				return nil, err
+			}
+			templates = append(templates, t)
+		}
+	}
+	return templates, nil
+}
+
+// checkTemplateSource checks the text, HTML and subject of a template
+// parse.
+func checkTemplateSource(t *syncTemplate) error {
+	funcs := templateFuncs(nil, nil)
+	if _, err := txttemplate.New(t.txtName).Funcs(funcs).Parse(string(t.txt)); err != nil {
+		return errors.Wrapf(err, "[service] txt template.New.Parse failed")
+	}
+	if _, err := htmltemplate.New(t.htmlName).Funcs(funcs).Parse(string(t.html)); err != nil {
+		return errors.Wrapf(err, "[service] html template.New.Parse failed")
+	}
+	if _, err := txttemplate.New("subject").Funcs(funcs).Parse(string(t.subject)); err != nil {
+		return errors.Wrapf(err, "[service] subject template.New.Parse failed")
+	}
+	return nil
+}
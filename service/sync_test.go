@@ -0,0 +1,90 @@
+package service_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/andyfusniak/squishy-mailer-lite/entity"
+	"github.com/stretchr/testify/assert"
+)
+
+// writeTemplateTree writes files, keyed by their slash separated path, below
+// dir.
+func writeTemplateTree(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+
+	for name, content := range files {
+		fp := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(fp), 0o755); err != nil {
+			t.Fatalf("os.MkdirAll failed: %+v", err)
+		}
+		if err := os.WriteFile(fp, []byte(content), 0o644); err != nil {
+			t.Fatalf("os.WriteFile failed: %+v", err)
+		}
+	}
+}
+
+func TestSyncTemplatesFromDir(t *testing.T) {
+	srv := newFakeSMTPServer(t)
+	svc := newTestService(t)
+	setupQueueProject(t, svc, srv)
+
+	dir := t.TempDir()
+	writeTemplateTree(t, dir, map[string]string{
+		"g1/t1.txt":          `{{define "layout"}}Hello {{.name}}, this is the text body of the email{{end}}`,
+		"g1/t1.html":         `{{define "layout"}}<p>Hello {{.name}}, this is the HTML body of the email</p>{{end}}`,
+		"auth/reset.txt":     `{{define "layout"}}Reset at {{.url}}{{end}}`,
+		"auth/reset.html":    `{{define "layout"}}<a href="{{.url}}">Reset</a>{{end}}`,
+		"auth/reset.subject": "Reset your password, {{.name}}\n",
+		"auth/README.md":     "ignored",
+	})
+
+	ctx := context.Background()
+	report, err := svc.SyncTemplatesFromDir(ctx, "p1", dir)
+	if err != nil {
+		t.Fatalf("svc.SyncTemplatesFromDir failed: %+v", err)
+	}
+	// t1 was created with the same bodies and CreateTemplate computed
+	// their digests, so it is unchanged
+	assert.Equal(t, &entity.SyncTemplatesReport{
+		GroupsCreated:      []string{"auth"},
+		TemplatesCreated:   []string{"reset"},
+		TemplatesUpdated:   []string{},
+		TemplatesUnchanged: []string{"t1"},
+	}, report)
+
+	// the template's subject is used when a send has none
+	mq, err := svc.SendEmailAsync(ctx, entity.SendEmailParams{
+		TemplateID:     "reset",
+		ProjectID:      "p1",
+		TransportID:    "tr1",
+		To:             []string{"to@example.com"},
+		TemplateParams: map[string]string{"name": "Andy", "url": "https://example.com/reset"},
+	})
+	if err != nil {
+		t.Fatalf("svc.SendEmailAsync failed: %+v", err)
+	}
+	assert.Equal(t, "Reset your password, Andy", mq.Subject)
+
+	// a second sync only reports the changes
+	writeTemplateTree(t, dir, map[string]string{
+		"auth/reset.subject": "Reset your password",
+	})
+	report, err = svc.SyncTemplatesFromDir(ctx, "p1", dir)
+	if err != nil {
+		t.Fatalf("svc.SyncTemplatesFromDir failed: %+v", err)
+	}
+	assert.Empty(t, report.GroupsCreated)
+	assert.Empty(t, report.TemplatesCreated)
+	assert.Equal(t, []string{"reset"}, report.TemplatesUpdated)
+	assert.Equal(t, []string{"t1"}, report.TemplatesUnchanged)
+
+	// every template needs a text and an HTML body
+	writeTemplateTree(t, dir, map[string]string{
+		"auth/verify.txt": `{{define "layout"}}Verify{{end}}`,
+	})
+	_, err = svc.SyncTemplatesFromDir(ctx, "p1", dir)
+	assert.Error(t, err)
+}
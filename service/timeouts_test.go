@@ -0,0 +1,171 @@
+package service_test
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/andyfusniak/squishy-mailer-lite/entity"
+	"github.com/andyfusniak/squishy-mailer-lite/service"
+	"github.com/stretchr/testify/assert"
+)
+
+// newSilentSMTPServer starts a server that accepts connections but never
+// replies, returning its host and port and a channel that receives a value
+// each time a connection is accepted. The server is stopped when the test
+// completes.
+func newSilentSMTPServer(t *testing.T) (string, int, <-chan struct{}) {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen failed: %+v", err)
+	}
+	accepted := make(chan struct{}, 16)
+	go func() {
+		var conns []net.Conn
+		defer func() {
+			for _, c := range conns {
+				c.Close()
+			}
+		}()
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conns = append(conns, c)
+			select {
+			case accepted <- struct{}{}:
+			default:
+			}
+		}
+	}()
+	t.Cleanup(func() { ln.Close() })
+	addr := ln.Addr().(*net.TCPAddr)
+	return addr.IP.String(), addr.Port, accepted
+}
+
+// useSilentSMTPServer points transport tr1 of the queue test project at a
+// server that never replies. It returns a channel that receives a value
+// each time the server accepts a connection.
+func useSilentSMTPServer(t *testing.T, svc *service.Service, timeouts entity.SMTPTimeouts) <-chan struct{} {
+	t.Helper()
+
+	host, port, accepted := newSilentSMTPServer(t)
+	if _, err := svc.UpdateSMTPTransport(context.Background(), entity.UpdateSMTPTransportParams{
+		TransportID:   "tr1",
+		ProjectID:     "p1",
+		Name:          "Transport One",
+		Host:          host,
+		Port:          port,
+		Username:      "user",
+		EmailFrom:     "from@example.com",
+		EmailFromName: "Example",
+		Timeouts:      timeouts,
+	}); err != nil {
+		t.Fatalf("svc.UpdateSMTPTransport failed: %+v", err)
+	}
+	return accepted
+}
+
+func TestSMTPTransportTimeouts(t *testing.T) {
+	svc := newTestService(t)
+	setupQueueProject(t, svc, newFakeSMTPServer(t))
+	useSilentSMTPServer(t, svc, entity.SMTPTimeouts{Connect: 5 * time.Second, Send: time.Minute})
+
+	tr, err := svc.GetSMTPTransport(context.Background(), "tr1", "p1")
+	if err != nil {
+		t.Fatalf("svc.GetSMTPTransport failed: %+v", err)
+	}
+	assert.Equal(t, 5*time.Second, tr.Timeouts.Connect)
+	assert.Equal(t, time.Minute, tr.Timeouts.Send)
+
+	_, err = svc.UpdateSMTPTransport(context.Background(), entity.UpdateSMTPTransportParams{
+		TransportID: "tr1",
+		ProjectID:   "p1",
+		Name:        "Transport One",
+		Host:        "127.0.0.1",
+		Port:        25,
+		EmailFrom:   "from@example.com",
+		Timeouts:    entity.SMTPTimeouts{Connect: -time.Second},
+	})
+	assertServiceErrorCode(t, err, entity.ErrInvalidTransportCode)
+}
+
+func TestSMTPConnectTimeout(t *testing.T) {
+	svc := newTestService(t)
+	setupQueueProject(t, svc, newFakeSMTPServer(t))
+	useSilentSMTPServer(t, svc, entity.SMTPTimeouts{Connect: 100 * time.Millisecond})
+
+	mq := queueTestEmail(t, svc)
+
+	ctx := context.Background()
+	start := time.Now()
+	n, err := svc.ProcessMailQueue(ctx)
+	if err != nil {
+		t.Fatalf("svc.ProcessMailQueue failed: %+v", err)
+	}
+	assert.Equal(t, 0, n)
+	assert.Less(t, time.Since(start), 5*time.Second)
+
+	got, err := svc.GetMailQueue(ctx, "p1", mq.ID)
+	if err != nil {
+		t.Fatalf("svc.GetMailQueue failed: %+v", err)
+	}
+	assert.Equal(t, entity.MailStateFailed, got.State)
+	assert.Equal(t, 1, got.Attempts)
+	assert.Contains(t, got.LastError, "timed out after 100ms")
+}
+
+func TestProcessMailQueueCancelled(t *testing.T) {
+	svc := newTestService(t)
+	setupQueueProject(t, svc, newFakeSMTPServer(t))
+	accepted := useSilentSMTPServer(t, svc, entity.SMTPTimeouts{})
+
+	mq := queueTestEmail(t, svc)
+
+	// the worker is stopped while it waits on the server
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-accepted
+		cancel()
+	}()
+	n, err := svc.ProcessMailQueue(ctx)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, 0, n)
+
+	got, err := svc.GetMailQueue(context.Background(), "p1", mq.ID)
+	if err != nil {
+		t.Fatalf("svc.GetMailQueue failed: %+v", err)
+	}
+	assert.Equal(t, entity.MailStateQueued, got.State)
+	assert.Equal(t, 0, got.Attempts)
+	assert.Contains(t, got.DeferralReason, "delivery abandoned")
+}
+
+func TestProcessMailQueueDeadline(t *testing.T) {
+	svc := newTestService(t)
+	setupQueueProject(t, svc, newFakeSMTPServer(t))
+	useSilentSMTPServer(t, svc, entity.SMTPTimeouts{})
+
+	mq := queueTestEmail(t, svc)
+
+	// the I/O deadline taken from ctx interrupts the delivery rather
+	// than failing it
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	n, err := svc.ProcessMailQueue(ctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Equal(t, 0, n)
+
+	got, err := svc.GetMailQueue(context.Background(), "p1", mq.ID)
+	if err != nil {
+		t.Fatalf("svc.GetMailQueue failed: %+v", err)
+	}
+	assert.Equal(t, entity.MailStateQueued, got.State)
+	assert.Equal(t, 0, got.Attempts)
+	assert.Contains(t, got.DeferralReason, "delivery abandoned")
+}
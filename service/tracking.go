@@ -0,0 +1,65 @@
+package service
+
+import (
+	"context"
+	"html"
+	"strings"
+
+	"github.com/andyfusniak/squishy-mailer-lite/entity"
+	"github.com/pkg/errors"
+)
+
+// openTrackingKeyLabel derives the tracking pixel signing key from the
+// encryption key, see unsubscribeKeyLabel.
+const openTrackingKeyLabel = "squishy-mailer-lite open tracking"
+
+// RecordOpen verifies a token from the tracking pixel of an email sent
+// with SendEmailParams.TrackOpens and counts an open of the email. If the
+// token is malformed or its signature does not match an error is returned
+// with a code of ErrInvalidTrackingTokenCode. If the email has since been
+// deleted an error is returned with a code of ErrMailQueueNotFoundCode.
+func (s *Service) RecordOpen(ctx context.Context, token string) error {
+	payload, ok := s.verifyToken(openTrackingKeyLabel, token)
+	if !ok {
+		return entity.NewServiceError(entity.ErrInvalidTrackingTokenCode,
+			errors.New("tracking token is malformed or has an invalid signature"))
+	}
+	projectID, mailQueueID, ok := strings.Cut(string(payload), "\n")
+	if !ok || projectID == "" || mailQueueID == "" {
+		return entity.NewServiceError(entity.ErrInvalidTrackingTokenCode,
+			errors.New("tracking token is malformed"))
+	}
+	if _, err := s.store.RecordMailQueueOpen(ctx, projectID, mailQueueID); err != nil {
+		if serr := serviceErrorFromStore(err); serr != nil {
+			return serr
+		}
+		return errors.Wrapf(err, "[service] store.RecordMailQueueOpen failed")
+	}
+	return nil
+}
+
+// checkTrackOpens returns an error with a code of ErrNoTrackingURLCode if
+// open tracking is requested but the service has no tracking URL.
+func (s *Service) checkTrackOpens(trackOpens bool) error {
+	if trackOpens && s.trackingURL == "" {
+		return entity.NewServiceError(entity.ErrNoTrackingURLCode,
+			errors.New("configure the service using WithTrackingURL"))
+	}
+	return nil
+}
+
+// trackingPixelURL returns the URL of the tracking pixel of an email.
+func (s *Service) trackingPixelURL(projectID, mailQueueID string) string {
+	return s.trackingURL + "/" + s.signToken(openTrackingKeyLabel, []byte(projectID+"\n"+mailQueueID))
+}
+
+// addTrackingPixel adds the tracking pixel of an email to the end of its
+// HTML body, inside the body element if it has one.
+func (s *Service) addTrackingPixel(htmlBody, projectID, mailQueueID string) string {
+	img := `<img src="` + html.EscapeString(s.trackingPixelURL(projectID, mailQueueID)) +
+		`" width="1" height="1" alt="" style="border:0;width:1px;height:1px">`
+	if i := strings.LastIndex(strings.ToLower(htmlBody), "</body>"); i >= 0 {
+		return htmlBody[:i] + img + htmlBody[i:]
+	}
+	return htmlBody + img
+}
@@ -0,0 +1,101 @@
+package service_test
+
+import (
+	"context"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/andyfusniak/squishy-mailer-lite/entity"
+	"github.com/andyfusniak/squishy-mailer-lite/service"
+	"github.com/stretchr/testify/assert"
+)
+
+var pixelRe = regexp.MustCompile(`<img src="https://mail\.example\.com/v1/open/([^"]+)" width="1" height="1"`)
+
+func TestOpenTracking(t *testing.T) {
+	stores := []struct {
+		name string
+		opts []service.Option
+	}{
+		{"sqlite3", nil},
+		{"memory", []service.Option{service.WithInMemoryStore()}},
+	}
+	for _, st := range stores {
+		t.Run(st.name, func(t *testing.T) {
+			srv := newFakeSMTPServer(t)
+			svc := newTestService(t, append(st.opts,
+				service.WithTrackingURL("https://mail.example.com/v1/open/"))...)
+			setupQueueProject(t, svc, srv)
+			ctx := context.Background()
+
+			params := entity.SendEmailParams{
+				TemplateID:     "t1",
+				ProjectID:      "p1",
+				TransportID:    "tr1",
+				To:             []string{"to@example.com"},
+				TemplateParams: map[string]string{"name": "Andy"},
+				TrackOpens:     true,
+			}
+			mq, err := svc.SendEmailAsync(ctx, params)
+			if err != nil {
+				t.Fatalf("svc.SendEmailAsync failed: %+v", err)
+			}
+			assert.True(t, mq.TrackOpens)
+			m := pixelRe.FindStringSubmatch(mq.HTML)
+			if !assert.NotNil(t, m, mq.HTML) {
+				return
+			}
+			token := m[1]
+
+			status, err := svc.GetMailStatus(ctx, "p1", mq.ID)
+			if err != nil {
+				t.Fatalf("svc.GetMailStatus failed: %+v", err)
+			}
+			assert.Equal(t, 0, status.Opens)
+			assert.True(t, time.Time(status.FirstOpenedAt).IsZero())
+
+			for range 2 {
+				if err := svc.RecordOpen(ctx, token); err != nil {
+					t.Fatalf("svc.RecordOpen failed: %+v", err)
+				}
+			}
+			status, err = svc.GetMailStatus(ctx, "p1", mq.ID)
+			if err != nil {
+				t.Fatalf("svc.GetMailStatus failed: %+v", err)
+			}
+			assert.Equal(t, 2, status.Opens)
+			assert.False(t, time.Time(status.FirstOpenedAt).IsZero())
+			assert.False(t, time.Time(status.LastOpenedAt).Before(time.Time(status.FirstOpenedAt)))
+
+			// emails without open tracking have no pixel
+			untracked := queueTestEmail(t, svc)
+			assert.False(t, untracked.TrackOpens)
+			assert.NotRegexp(t, pixelRe, untracked.HTML)
+
+			err = svc.RecordOpen(ctx, token+"x")
+			assertServiceErrorCode(t, err, entity.ErrInvalidTrackingTokenCode)
+
+			// opens are recorded against the mail queue so sync sends
+			// cannot be tracked
+			err = svc.SendEmail(ctx, params)
+			assertServiceErrorCode(t, err, entity.ErrTrackOpensNotQueuedCode)
+		})
+	}
+}
+
+func TestOpenTrackingRequiresURL(t *testing.T) {
+	srv := newFakeSMTPServer(t)
+	svc := newTestService(t)
+	setupQueueProject(t, svc, srv)
+
+	_, err := svc.SendEmailAsync(context.Background(), entity.SendEmailParams{
+		TemplateID:     "t1",
+		ProjectID:      "p1",
+		TransportID:    "tr1",
+		To:             []string{"to@example.com"},
+		TemplateParams: map[string]string{"name": "Andy"},
+		TrackOpens:     true,
+	})
+	assertServiceErrorCode(t, err, entity.ErrNoTrackingURLCode)
+}
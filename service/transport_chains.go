@@ -0,0 +1,64 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	"github.com/andyfusniak/squishy-mailer-lite/entity"
+	"github.com/andyfusniak/squishy-mailer-lite/internal/store"
+	"github.com/pkg/errors"
+)
+
+// SetTransportChain sets the ordered list of transports the project's
+// emails fall back through. When the worker fails to deliver an email
+// through a transport in the chain with a transient error, it tries the
+// transports after it in turn, within the same delivery attempt. Permanent
+// failures, such as a recipient rejected with an SMTP 5xx reply, are not
+// retried through the chain. An empty list disables fallback. Each
+// transport must exist in the project and appear only once, otherwise an
+// error is returned with a code of ErrInvalidTransportChainCode.
+func (s *Service) SetTransportChain(ctx context.Context, projectID string, transportIDs []string) (*entity.Project, error) {
+	seen := make(map[string]bool, len(transportIDs))
+	for _, id := range transportIDs {
+		if seen[id] {
+			return nil, entity.NewServiceError(entity.ErrInvalidTransportChainCode,
+				fmt.Errorf("transport %q appears more than once", id))
+		}
+		seen[id] = true
+		if err := s.checkTransport(ctx, id, projectID); err != nil {
+			if errors.Is(err, store.ErrTransportNotFound) {
+				return nil, entity.NewServiceError(entity.ErrInvalidTransportChainCode,
+					fmt.Errorf("transport %q not found", id))
+			}
+			return nil, err
+		}
+	}
+
+	obj, err := s.store.SetProjectTransportChain(ctx, projectID, store.JSONArray(transportIDs))
+	if err != nil {
+		if serr := serviceErrorFromStore(err); serr != nil {
+			return nil, serr
+		}
+		return nil, errors.Wrapf(err, "[service] store.SetProjectTransportChain failed")
+	}
+	if err := checkProjectScope("project", projectID, obj.ProjectID); err != nil {
+		return nil, err
+	}
+	return projectFromStoreObject(obj), nil
+}
+
+// fallbackTransports returns the transports after the email's transport
+// in its project's transport chain, or nil if the transport is not in the
+// chain.
+func (s *Service) fallbackTransports(ctx context.Context, mq *store.MailQueue) ([]string, error) {
+	project, err := s.store.GetProject(ctx, mq.ProjectID)
+	if err != nil {
+		return nil, errors.Wrapf(err, "[service] store.GetProject failed")
+	}
+	i := slices.Index(project.TransportChain, mq.TransportID)
+	if i < 0 {
+		return nil, nil
+	}
+	return project.TransportChain[i+1:], nil
+}
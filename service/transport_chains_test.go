@@ -0,0 +1,141 @@
+package service_test
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/andyfusniak/squishy-mailer-lite/entity"
+	"github.com/andyfusniak/squishy-mailer-lite/service"
+	"github.com/stretchr/testify/assert"
+)
+
+// closedPort returns a local port with nothing listening on it.
+func closedPort(t *testing.T) int {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen failed: %+v", err)
+	}
+	port := ln.Addr().(*net.TCPAddr).Port
+	ln.Close()
+	return port
+}
+
+func TestTransportChain(t *testing.T) {
+	stores := []struct {
+		name string
+		opts []service.Option
+	}{
+		{"sqlite3", nil},
+		{"memory", []service.Option{service.WithInMemoryStore()}},
+	}
+	for _, tc := range stores {
+		t.Run(tc.name, func(t *testing.T) {
+			srv := newFakeSMTPServer(t)
+			backup := newFakeSMTPServer(t)
+			svc := newTestService(t, tc.opts...)
+			setupQueueProject(t, svc, srv)
+			ctx := context.Background()
+
+			if _, err := svc.CreateSMTPTransport(ctx, entity.CreateSMTPTransport{
+				ID:        "tr2",
+				ProjectID: "p1",
+				Name:      "Transport Two",
+				Host:      backup.Host(),
+				Port:      backup.Port(),
+				Username:  "user",
+				Password:  "secret",
+				EmailFrom: "from@example.com",
+			}); err != nil {
+				t.Fatalf("svc.CreateSMTPTransport failed: %+v", err)
+			}
+
+			_, err := svc.SetTransportChain(ctx, "p1", []string{"tr1", "nope"})
+			assertServiceErrorCode(t, err, entity.ErrInvalidTransportChainCode)
+			_, err = svc.SetTransportChain(ctx, "p1", []string{"tr1", "tr1"})
+			assertServiceErrorCode(t, err, entity.ErrInvalidTransportChainCode)
+
+			p, err := svc.SetTransportChain(ctx, "p1", []string{"tr1", "tr2"})
+			if err != nil {
+				t.Fatalf("svc.SetTransportChain failed: %+v", err)
+			}
+			assert.Equal(t, []string{"tr1", "tr2"}, p.TransportChain)
+
+			// a permanent failure is not retried through the chain
+			mq, err := svc.SendEmailAsync(ctx, entity.SendEmailParams{
+				TemplateID:     "t1",
+				ProjectID:      "p1",
+				TransportID:    "tr1",
+				To:             []string{"reject@example.com"},
+				TemplateParams: map[string]string{"name": "Andy"},
+			})
+			if err != nil {
+				t.Fatalf("svc.SendEmailAsync failed: %+v", err)
+			}
+			if _, err := svc.ProcessMailQueue(ctx); err != nil {
+				t.Fatalf("svc.ProcessMailQueue failed: %+v", err)
+			}
+			got, err := svc.GetMailQueue(ctx, "p1", mq.ID)
+			if err != nil {
+				t.Fatalf("svc.GetMailQueue failed: %+v", err)
+			}
+			assert.Equal(t, entity.MailStateFailed, got.State)
+			assert.Empty(t, backup.Messages())
+
+			// the primary transport stops accepting connections
+			if _, err := svc.UpdateSMTPTransport(ctx, entity.UpdateSMTPTransportParams{
+				TransportID:   "tr1",
+				ProjectID:     "p1",
+				Name:          "Transport One",
+				Host:          "127.0.0.1",
+				Port:          closedPort(t),
+				Username:      "user",
+				EmailFrom:     "from@example.com",
+				EmailFromName: "Example",
+			}); err != nil {
+				t.Fatalf("svc.UpdateSMTPTransport failed: %+v", err)
+			}
+			mq = queueTestEmail(t, svc)
+			n, err := svc.ProcessMailQueue(ctx)
+			if err != nil {
+				t.Fatalf("svc.ProcessMailQueue failed: %+v", err)
+			}
+			assert.Equal(t, 1, n)
+			assert.Len(t, backup.Messages(), 1)
+
+			st, err := svc.GetMailStatus(ctx, "p1", mq.ID)
+			if err != nil {
+				t.Fatalf("svc.GetMailStatus failed: %+v", err)
+			}
+			assert.Equal(t, entity.MailStateSent, st.State)
+			assert.Equal(t, "tr1", st.TransportID)
+			assert.Equal(t, "tr2", st.SentTransportID)
+			assert.Equal(t, 1, st.Attempts)
+			if assert.Len(t, st.History, 2) {
+				assert.Equal(t, "tr1", st.History[0].TransportID)
+				assert.Equal(t, entity.MailStateFailed, st.History[0].State)
+				assert.Equal(t, "tr2", st.History[1].TransportID)
+				assert.Equal(t, entity.MailStateSent, st.History[1].State)
+			}
+
+			// an empty chain disables fallback
+			p, err = svc.SetTransportChain(ctx, "p1", nil)
+			if err != nil {
+				t.Fatalf("svc.SetTransportChain failed: %+v", err)
+			}
+			assert.Empty(t, p.TransportChain)
+			mq = queueTestEmail(t, svc)
+			if _, err := svc.ProcessMailQueue(ctx); err != nil {
+				t.Fatalf("svc.ProcessMailQueue failed: %+v", err)
+			}
+			got, err = svc.GetMailQueue(ctx, "p1", mq.ID)
+			if err != nil {
+				t.Fatalf("svc.GetMailQueue failed: %+v", err)
+			}
+			assert.Equal(t, entity.MailStateFailed, got.State)
+			assert.Len(t, backup.Messages(), 1)
+		})
+	}
+}
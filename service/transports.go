@@ -0,0 +1,410 @@
+package service
+
+import (
+	"context"
+	"maps"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/andyfusniak/squishy-mailer-lite/entity"
+	"github.com/andyfusniak/squishy-mailer-lite/internal/email"
+	"github.com/andyfusniak/squishy-mailer-lite/internal/store"
+	"github.com/pkg/errors"
+)
+
+// TransportTypeSMTP is the transport type of SMTP transports.
+const TransportTypeSMTP = "smtp"
+
+// SMTP transport config keys passed to the smtp transport factory.
+const (
+	smtpConfigHost     = "host"
+	smtpConfigPort     = "port"
+	smtpConfigUsername = "username"
+
+	smtpConfigTLSMode               = "tls_mode"
+	smtpConfigTLSInsecureSkipVerify = "tls_insecure_skip_verify"
+	smtpConfigTLSCABundle           = "tls_ca_bundle"
+
+	smtpConfigConnectTimeout = "connect_timeout"
+	smtpConfigSendTimeout    = "send_timeout"
+)
+
+// Sender delivers rendered emails. Custom transports implement Sender and
+// are plugged in using RegisterTransportFactory. SendEmail should return
+// promptly once ctx is done, with an error wrapping ctx.Err() unless the
+// email was sent.
+type Sender interface {
+	SendEmail(ctx context.Context, msg *entity.OutgoingEmail) error
+}
+
+// TransportConfig is the stored configuration of a transport passed to a
+// TransportFactory when an email is sent through it.
+type TransportConfig struct {
+	ID        string
+	ProjectID string
+	Name      string
+	Type      string
+
+	// Config holds the type specific settings. SMTP transports have the
+	// keys host, port, username, tls_mode, tls_insecure_skip_verify,
+	// tls_ca_bundle, and connect_timeout and send_timeout in the form
+	// accepted by time.ParseDuration.
+	Config map[string]string
+
+	// Secret is the decrypted password or API key of the transport.
+	Secret string
+
+	EmailFrom     string
+	EmailFromName string
+	EmailReplyTo  []string
+}
+
+// TransportFactory returns a Sender for a transport.
+type TransportFactory func(ctx context.Context, cfg TransportConfig) (Sender, error)
+
+// transportFactory is the internal form of a TransportFactory.
+type transportFactory func(ctx context.Context, cfg TransportConfig) (email.Sender, error)
+
+var (
+	transportFactoriesMu sync.RWMutex
+	transportFactories   = map[string]transportFactory{
+		TransportTypeSMTP:                  smtpSender,
+		store.APITransportProviderMailgun:  mailgunSender,
+		store.APITransportProviderSES:      sesSender,
+		store.APITransportProviderPostmark: postmarkSender,
+		store.APITransportProviderWebhook:  webhookSender,
+
+		store.APITransportProviderSMTPOAuth2: smtpOAuth2Sender,
+		store.APITransportProviderFile:       fileSender,
+	}
+)
+
+// builtinTransportTypes are the transport types that have their own
+// Create method.
+var builtinTransportTypes = map[string]bool{
+	TransportTypeSMTP:                  true,
+	store.APITransportProviderMailgun:  true,
+	store.APITransportProviderSES:      true,
+	store.APITransportProviderPostmark: true,
+	store.APITransportProviderWebhook:  true,
+
+	store.APITransportProviderSMTPOAuth2: true,
+	store.APITransportProviderFile:       true,
+}
+
+// RegisterTransportFactory makes a transport type available to all
+// services. Registering a built-in type such as "smtp" or "mailgun"
+// replaces the built-in sender, for example to deliver to a test double.
+// Transports of a new type are created using Service.CreateTransport.
+// It panics if factory is nil.
+func RegisterTransportFactory(transportType string, factory TransportFactory) {
+	if factory == nil {
+		panic("service: RegisterTransportFactory factory is nil")
+	}
+	transportFactoriesMu.Lock()
+	defer transportFactoriesMu.Unlock()
+	transportFactories[transportType] = func(ctx context.Context, cfg TransportConfig) (email.Sender, error) {
+		sender, err := factory(ctx, cfg)
+		if err != nil {
+			return nil, err
+		}
+		return senderAdapter{sender}, nil
+	}
+}
+
+func lookupTransportFactory(transportType string) (transportFactory, bool) {
+	transportFactoriesMu.RLock()
+	defer transportFactoriesMu.RUnlock()
+	factory, ok := transportFactories[transportType]
+	return factory, ok
+}
+
+// senderAdapter adapts a Sender to an email.Sender.
+type senderAdapter struct {
+	sender Sender
+}
+
+func (a senderAdapter) SendEmail(ctx context.Context, params email.EmailParams) error {
+	html, err := params.HTMLBody()
+	if err != nil {
+		return err
+	}
+	msg := entity.OutgoingEmail{
+		Subject:       params.Subject,
+		Text:          params.Text,
+		HTML:          html,
+		From:          params.From,
+		FromName:      params.FromName,
+		ReplyTo:       strings.Join(params.ReplyTo, ", "),
+		To:            params.To,
+		Cc:            params.Cc,
+		Bcc:           params.Bcc,
+		MessageStream: params.MessageStream,
+		MessageID:     params.MessageID,
+		Headers:       params.Headers,
+	}
+	for _, at := range params.Attachments {
+		msg.Attachments = append(msg.Attachments, entity.OutgoingAttachment{
+			Filename:    at.Filename,
+			ContentType: at.ContentType,
+			Content:     at.Content,
+			ContentID:   at.ContentID,
+		})
+	}
+	return a.sender.SendEmail(ctx, &msg)
+}
+
+// CreateTransport creates a transport of a custom type registered using
+// RegisterTransportFactory. Built-in types are created using their own
+// Create methods, such as CreateMailgunTransport. The secret, if any, is
+// encrypted before it is stored.
+func (s *Service) CreateTransport(ctx context.Context, params entity.CreateTransport) (*entity.APITransport, error) {
+	if builtinTransportTypes[params.Type] {
+		return nil, entity.NewServiceError(entity.ErrInvalidTransportCode,
+			errors.Errorf("transport type %q has its own create method", params.Type))
+	}
+	if _, ok := lookupTransportFactory(params.Type); !ok {
+		return nil, entity.NewServiceError(entity.ErrInvalidTransportCode,
+			errors.Errorf("transport type %q is not registered", params.Type))
+	}
+	return s.createAPITransport(ctx, store.AddAPITransport{
+		APITransportID: params.ID,
+		ProjectID:      params.ProjectID,
+		TransportName:  params.Name,
+		Provider:       params.Type,
+		Config:         store.JSONObject(params.Config),
+		EmailFrom:      params.EmailFrom,
+		EmailFromName:  params.EmailFromName,
+		EmailReplyTo:   store.JSONArray(params.EmailReplyTo),
+	}, params.Secret)
+}
+
+// sender returns an email.Sender for the transport with the given id,
+// which may be either an SMTP or an API transport. The sender is created
+// by the factory registered for the transport's type.
+// VerifyTransport checks that a transport can be used to send email. SMTP
+// transports connect to the server and authenticate without sending an
+// email. Other transports only check that their settings can be loaded
+// and decrypted, as their providers offer no way to verify credentials
+// without sending. The outcome is recorded and reported by Health.
+func (s *Service) VerifyTransport(ctx context.Context, projectID, transportID string) error {
+	err := s.verifyTransport(ctx, projectID, transportID)
+	var serr *entity.ServiceError
+	if errors.As(err, &serr) && (serr.Code == entity.ErrTransportNotFoundCode ||
+		serr.Code == entity.ErrProjectScopeViolationCode) {
+		return err
+	}
+
+	var msg string
+	if err != nil {
+		msg = err.Error()
+	}
+	if _, rerr := s.store.SetTransportVerification(ctx, store.SetTransportVerification{
+		ProjectID:   projectID,
+		TransportID: transportID,
+		Error:       msg,
+	}); rerr != nil {
+		s.logger.Error("record transport verification failed",
+			"project_id", projectID, "transport_id", transportID, "error", rerr)
+	}
+	return err
+}
+
+func (s *Service) verifyTransport(ctx context.Context, projectID, transportID string) error {
+	sender, err := s.sender(ctx, transportID, projectID)
+	if err != nil {
+		return err
+	}
+	if v, ok := sender.(email.Verifier); ok {
+		if err := v.Verify(ctx); err != nil {
+			return entity.NewServiceError(entity.ErrInvalidTransportCode,
+				errors.Wrapf(err, "[service] verify transport %q failed", transportID))
+		}
+	}
+	return nil
+}
+
+func (s *Service) sender(ctx context.Context, transportID, projectID string) (email.Sender, error) {
+	cfg, err := s.transportConfig(ctx, transportID, projectID)
+	if err != nil {
+		return nil, err
+	}
+
+	factory, ok := lookupTransportFactory(cfg.Type)
+	if !ok {
+		return nil, errors.Errorf("[service] unknown transport type %q", cfg.Type)
+	}
+	if cfg.Type == store.APITransportProviderFile && cfg.Config[fileConfigDir] == "" {
+		return s.fileWriterSender(*cfg)
+	}
+	return factory(ctx, *cfg)
+}
+
+// transportConfig returns the config of an SMTP or API transport, from
+// the cache if it holds it.
+func (s *Service) transportConfig(ctx context.Context, transportID, projectID string) (*TransportConfig, error) {
+	if cfg, ok := s.cache.transport(projectID, transportID); ok {
+		return cfg, nil
+	}
+	cfg, err := s.smtpTransportConfig(ctx, transportID, projectID)
+	if errors.Is(err, store.ErrTransportNotFound) {
+		cfg, err = s.apiTransportConfig(ctx, transportID, projectID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	s.cache.putTransport(cfg)
+	return cfg, nil
+}
+
+// smtpTransportConfig retrieves the SMTP transport from the store and
+// decrypts its password.
+func (s *Service) smtpTransportConfig(ctx context.Context, transportID, projectID string) (*TransportConfig, error) {
+	trObj, err := s.store.GetSMTPTransport(ctx, transportID, projectID)
+	if err != nil {
+		if serr := serviceErrorFromStore(err); serr != nil {
+			return nil, serr
+		}
+		return nil, errors.Wrapf(err, "[service] store.GetSMTPTransport failed")
+	}
+	if err := checkProjectScope("transport", projectID, trObj.ProjectID); err != nil {
+		return nil, err
+	}
+
+	pwPlaintext, err := s.decryptSecret(trObj.EncryptedPassword)
+	if err != nil {
+		return nil, err
+	}
+	timeouts := s.smtpTimeouts(entity.SMTPTimeouts{
+		Connect: time.Duration(trObj.ConnectTimeoutMS) * time.Millisecond,
+		Send:    time.Duration(trObj.SendTimeoutMS) * time.Millisecond,
+	})
+
+	return &TransportConfig{
+		ID:        trObj.SMTPTransportID,
+		ProjectID: trObj.ProjectID,
+		Name:      trObj.TransportName,
+		Type:      TransportTypeSMTP,
+		Config: map[string]string{
+			smtpConfigHost:     trObj.Host,
+			smtpConfigPort:     strconv.Itoa(trObj.Port),
+			smtpConfigUsername: trObj.Username,
+
+			smtpConfigTLSMode:               trObj.TLSMode,
+			smtpConfigTLSInsecureSkipVerify: strconv.FormatBool(trObj.TLSInsecureSkipVerify),
+			smtpConfigTLSCABundle:           trObj.TLSCABundle,
+
+			smtpConfigConnectTimeout: timeouts.Connect.String(),
+			smtpConfigSendTimeout:    timeouts.Send.String(),
+		},
+		Secret:        pwPlaintext,
+		EmailFrom:     trObj.EmailFrom,
+		EmailFromName: trObj.EmailFromName,
+		EmailReplyTo:  trObj.EmailReplyTo,
+	}, nil
+}
+
+// apiTransportConfig retrieves the API transport from the store and
+// decrypts its API key.
+func (s *Service) apiTransportConfig(ctx context.Context, transportID, projectID string) (*TransportConfig, error) {
+	trObj, err := s.store.GetAPITransport(ctx, transportID, projectID)
+	if err != nil {
+		if serr := serviceErrorFromStore(err); serr != nil {
+			return nil, serr
+		}
+		return nil, errors.Wrapf(err, "[service] store.GetAPITransport failed")
+	}
+	if err := checkProjectScope("transport", projectID, trObj.ProjectID); err != nil {
+		return nil, err
+	}
+
+	apiKey, err := s.decryptSecret(trObj.EncryptedAPIKey)
+	if err != nil {
+		return nil, err
+	}
+
+	config := map[string]string(trObj.Config)
+	if trObj.Provider == store.APITransportProviderSMTPOAuth2 {
+		config = maps.Clone(config)
+		stored := smtpTimeoutsConfig(config)
+		timeouts := s.smtpTimeouts(entity.SMTPTimeouts{Connect: stored.Connect, Send: stored.Send})
+		config[smtpConfigConnectTimeout] = timeouts.Connect.String()
+		config[smtpConfigSendTimeout] = timeouts.Send.String()
+	}
+
+	return &TransportConfig{
+		ID:            trObj.APITransportID,
+		ProjectID:     trObj.ProjectID,
+		Name:          trObj.TransportName,
+		Type:          trObj.Provider,
+		Config:        config,
+		Secret:        apiKey,
+		EmailFrom:     trObj.EmailFrom,
+		EmailFromName: trObj.EmailFromName,
+		EmailReplyTo:  trObj.EmailReplyTo,
+	}, nil
+}
+
+func smtpSender(_ context.Context, cfg TransportConfig) (email.Sender, error) {
+	port, err := strconv.Atoi(cfg.Config[smtpConfigPort])
+	if err != nil {
+		return nil, errors.Wrapf(err, "[service] strconv.Atoi failed")
+	}
+	return email.NewAWSSMTPTransport(email.AWSConfig{
+		Host:     cfg.Config[smtpConfigHost],
+		Port:     port,
+		Username: cfg.Config[smtpConfigUsername],
+		Password: cfg.Secret,
+		From:     cfg.EmailFrom,
+		FromName: cfg.EmailFromName,
+		ReplyTo:  cfg.EmailReplyTo,
+		TLS: email.TLSOptions{
+			Mode:               cfg.Config[smtpConfigTLSMode],
+			InsecureSkipVerify: cfg.Config[smtpConfigTLSInsecureSkipVerify] == "true",
+			CABundle:           cfg.Config[smtpConfigTLSCABundle],
+		},
+		Timeouts: smtpTimeoutsConfig(cfg.Config),
+	}), nil
+}
+
+// smtpTimeoutsConfig parses the connect and send timeouts of a transport
+// config. Missing or invalid timeouts are left unbounded.
+func smtpTimeoutsConfig(cfg map[string]string) email.Timeouts {
+	connect, _ := time.ParseDuration(cfg[smtpConfigConnectTimeout])
+	send, _ := time.ParseDuration(cfg[smtpConfigSendTimeout])
+	return email.Timeouts{Connect: connect, Send: send}
+}
+
+func mailgunSender(_ context.Context, cfg TransportConfig) (email.Sender, error) {
+	return email.NewMailgunTransport(email.MailgunConfig{
+		Domain:   cfg.Config[mailgunConfigDomain],
+		APIKey:   cfg.Secret,
+		BaseURL:  cfg.Config[mailgunConfigBaseURL],
+		From:     cfg.EmailFrom,
+		FromName: cfg.EmailFromName,
+		ReplyTo:  cfg.EmailReplyTo,
+	}), nil
+}
+
+func postmarkSender(_ context.Context, cfg TransportConfig) (email.Sender, error) {
+	return email.NewPostmarkTransport(email.PostmarkConfig{
+		ServerToken:   cfg.Secret,
+		BaseURL:       cfg.Config[postmarkConfigBaseURL],
+		MessageStream: cfg.Config[postmarkConfigMessageStream],
+		From:          cfg.EmailFrom,
+		FromName:      cfg.EmailFromName,
+		ReplyTo:       cfg.EmailReplyTo,
+	}), nil
+}
+
+func webhookSender(_ context.Context, cfg TransportConfig) (email.Sender, error) {
+	return email.NewWebhookTransport(email.WebhookConfig{
+		URL:           cfg.Config[webhookConfigURL],
+		SigningSecret: cfg.Secret,
+		From:          cfg.EmailFrom,
+		FromName:      cfg.EmailFromName,
+		ReplyTo:       cfg.EmailReplyTo,
+	}), nil
+}
@@ -0,0 +1,125 @@
+package service_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/andyfusniak/squishy-mailer-lite/entity"
+	"github.com/andyfusniak/squishy-mailer-lite/service"
+	"github.com/stretchr/testify/assert"
+)
+
+type captureSender struct {
+	mu   sync.Mutex
+	cfg  service.TransportConfig
+	msgs []*entity.OutgoingEmail
+}
+
+func (c *captureSender) SendEmail(_ context.Context, msg *entity.OutgoingEmail) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.msgs = append(c.msgs, msg)
+	return nil
+}
+
+func TestRegisterTransportFactory(t *testing.T) {
+	capture := &captureSender{}
+	service.RegisterTransportFactory("capture", func(_ context.Context, cfg service.TransportConfig) (service.Sender, error) {
+		capture.cfg = cfg
+		return capture, nil
+	})
+
+	srv := newFakeSMTPServer(t)
+	svc := newTestService(t)
+	setupQueueProject(t, svc, srv)
+
+	ctx := context.Background()
+	tr, err := svc.CreateTransport(ctx, entity.CreateTransport{
+		ID:        "custom",
+		ProjectID: "p1",
+		Name:      "Custom",
+		Type:      "capture",
+		Config:    map[string]string{"region": "eu"},
+		Secret:    "s3cret",
+		EmailFrom: "noreply@example.com",
+	})
+	if err != nil {
+		t.Fatalf("svc.CreateTransport failed: %+v", err)
+	}
+	assert.Equal(t, entity.APITransportProvider("capture"), tr.Provider)
+
+	err = svc.SendEmail(ctx, entity.SendEmailParams{
+		TemplateID:     "t1",
+		ProjectID:      "p1",
+		TransportID:    "custom",
+		To:             []string{"to@example.com"},
+		Subject:        "Welcome",
+		TemplateParams: map[string]string{"name": "Andy"},
+		Attachments: []entity.EmailAttachment{
+			{Filename: "a.txt", Content: []byte("hello")},
+		},
+	})
+	if err != nil {
+		t.Fatalf("svc.SendEmail failed: %+v", err)
+	}
+
+	// the factory receives the stored config and the decrypted secret
+	assert.Equal(t, "custom", capture.cfg.ID)
+	assert.Equal(t, "capture", capture.cfg.Type)
+	assert.Equal(t, "eu", capture.cfg.Config["region"])
+	assert.Equal(t, "s3cret", capture.cfg.Secret)
+	assert.Equal(t, "noreply@example.com", capture.cfg.EmailFrom)
+
+	if assert.Len(t, capture.msgs, 1) {
+		msg := capture.msgs[0]
+		assert.Equal(t, []string{"to@example.com"}, msg.To)
+		assert.Equal(t, "Welcome", msg.Subject)
+		assert.Contains(t, msg.Text, "Hello Andy")
+		if assert.Len(t, msg.Attachments, 1) {
+			assert.Equal(t, "a.txt", msg.Attachments[0].Filename)
+			assert.Equal(t, []byte("hello"), msg.Attachments[0].Content)
+		}
+	}
+	assert.Empty(t, srv.Messages())
+
+	tests := []struct {
+		name string
+		typ  string
+	}{
+		{"unregistered", "unknown"},
+		{"built-in", "mailgun"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := svc.CreateTransport(ctx, entity.CreateTransport{
+				ID:        "custom2",
+				ProjectID: "p1",
+				Name:      "Custom",
+				Type:      tc.typ,
+			})
+			assertServiceErrorCode(t, err, entity.ErrInvalidTransportCode)
+		})
+	}
+}
+
+func TestVerifyTransport(t *testing.T) {
+	srv := newFakeSMTPServer(t)
+	svc := newTestService(t)
+	setupQueueProject(t, svc, srv)
+
+	ctx := context.Background()
+	if err := svc.VerifyTransport(ctx, "p1", "tr1"); err != nil {
+		t.Fatalf("svc.VerifyTransport failed: %+v", err)
+	}
+	assert.Equal(t, 1, srv.Connections())
+	assert.Empty(t, srv.Messages())
+
+	err := svc.VerifyTransport(ctx, "p1", "missing")
+	assertServiceErrorCode(t, err, entity.ErrTransportNotFoundCode)
+
+	// nothing is listening on the transport's port once the server closes
+	srv.ln.Close()
+	err = svc.VerifyTransport(ctx, "p1", "tr1")
+	assertServiceErrorCode(t, err, entity.ErrInvalidTransportCode)
+}
@@ -0,0 +1,116 @@
+package service
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"strings"
+
+	"github.com/andyfusniak/squishy-mailer-lite/entity"
+	"github.com/pkg/errors"
+)
+
+// unsubscribeKeyLabel derives the unsubscribe signing key from the
+// encryption key so that the encryption key itself is never used as a MAC
+// key.
+const unsubscribeKeyLabel = "squishy-mailer-lite unsubscribe"
+
+// signingMAC returns the HMAC-SHA256 of payload using the key derived
+// from the encryption key for label.
+func (s *Service) signingMAC(label string, payload []byte) []byte {
+	k := hmac.New(sha256.New, s.encryptionKey)
+	k.Write([]byte(label))
+	m := hmac.New(sha256.New, k.Sum(nil))
+	m.Write(payload)
+	return m.Sum(nil)
+}
+
+// signToken returns the URL safe token {payload}.{mac} for payload.
+func (s *Service) signToken(label string, payload []byte) string {
+	return base64.RawURLEncoding.EncodeToString(payload) + "." +
+		base64.RawURLEncoding.EncodeToString(s.signingMAC(label, payload))
+}
+
+// verifyToken returns the payload of a token returned by signToken, or
+// false if the token is malformed or its signature does not match.
+func (s *Service) verifyToken(label, token string) ([]byte, bool) {
+	p, sig, ok := strings.Cut(token, ".")
+	if !ok {
+		return nil, false
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(p)
+	if err != nil {
+		return nil, false
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil || !hmac.Equal(mac, s.signingMAC(label, payload)) {
+		return nil, false
+	}
+	return payload, true
+}
+
+// UnsubscribeToken returns a signed token for a recipient of a project.
+// The token is URL safe and does not expire. Passing it to Unsubscribe adds
+// the recipient to the project's suppression list. Tokens are signed with
+// a key derived from the encryption key, so rotating the encryption key
+// invalidates them.
+func (s *Service) UnsubscribeToken(projectID, email string) string {
+	payload := []byte(projectID + "\n" + strings.ToLower(recipientAddress(email)))
+	return s.signToken(unsubscribeKeyLabel, payload)
+}
+
+// UnsubscribeURL returns the unsubscribe link for a recipient of a
+// project, or the empty string if the service has no unsubscribe URL. It
+// can be passed to a template as a param to link to from the body.
+func (s *Service) UnsubscribeURL(projectID, email string) string {
+	if s.unsubscribeURL == "" {
+		return ""
+	}
+	return s.unsubscribeURL + "/" + s.UnsubscribeToken(projectID, email)
+}
+
+// Unsubscribe verifies a token returned by UnsubscribeToken and adds the
+// recipient to the project's suppression list with a reason of
+// SuppressionReasonUnsubscribe. If the token is malformed or its signature
+// does not match an error is returned with a code of
+// ErrInvalidUnsubscribeCode.
+func (s *Service) Unsubscribe(ctx context.Context, token string) (*entity.Suppression, error) {
+	invalid := entity.NewServiceError(entity.ErrInvalidUnsubscribeCode,
+		errors.New("unsubscribe token is malformed or has an invalid signature"))
+
+	payload, ok := s.verifyToken(unsubscribeKeyLabel, token)
+	if !ok {
+		return nil, invalid
+	}
+	projectID, addr, ok := strings.Cut(string(payload), "\n")
+	if !ok || projectID == "" || addr == "" {
+		return nil, invalid
+	}
+	return s.suppress(ctx, projectID, addr, entity.SuppressionReasonUnsubscribe, "")
+}
+
+// checkUnsubscribe returns an error with a code of ErrNoUnsubscribeURLCode
+// if unsubscribe headers are requested but the service has no unsubscribe
+// URL.
+func (s *Service) checkUnsubscribe(unsubscribe bool) error {
+	if unsubscribe && s.unsubscribeURL == "" {
+		return entity.NewServiceError(entity.ErrNoUnsubscribeURLCode,
+			errors.New("configure the service using WithUnsubscribeURL"))
+	}
+	return nil
+}
+
+// unsubscribeHeaders returns the RFC 8058 one-click unsubscribe headers for
+// the To recipient, or nil if they are not wanted. The headers are left out
+// if the email has more than one To recipient, as every recipient would
+// otherwise unsubscribe the first.
+func (s *Service) unsubscribeHeaders(unsubscribe bool, projectID string, to []string) map[string]string {
+	if !unsubscribe || s.unsubscribeURL == "" || len(to) != 1 {
+		return nil
+	}
+	return map[string]string{
+		"List-Unsubscribe":      "<" + s.UnsubscribeURL(projectID, to[0]) + ">",
+		"List-Unsubscribe-Post": "List-Unsubscribe=One-Click",
+	}
+}
@@ -0,0 +1,109 @@
+package service_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/andyfusniak/squishy-mailer-lite/entity"
+	"github.com/andyfusniak/squishy-mailer-lite/service"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUnsubscribe(t *testing.T) {
+	srv := newFakeSMTPServer(t)
+	svc := newTestService(t, service.WithUnsubscribeURL("https://mail.example.com/v1/unsubscribe/"))
+	setupQueueProject(t, svc, srv)
+	ctx := context.Background()
+
+	token := svc.UnsubscribeToken("p1", "Andy <To@Example.com>")
+	assert.Equal(t, token, svc.UnsubscribeToken("p1", "to@example.com"))
+	assert.Equal(t, "https://mail.example.com/v1/unsubscribe/"+token, svc.UnsubscribeURL("p1", "to@example.com"))
+
+	mq, err := svc.SendEmailAsync(ctx, entity.SendEmailParams{
+		TemplateID:     "t1",
+		ProjectID:      "p1",
+		TransportID:    "tr1",
+		To:             []string{"to@example.com"},
+		Subject:        "News",
+		TemplateParams: map[string]string{"name": "Andy"},
+		Unsubscribe:    true,
+	})
+	if err != nil {
+		t.Fatalf("svc.SendEmailAsync failed: %+v", err)
+	}
+	if _, err := svc.ProcessMailQueue(ctx); err != nil {
+		t.Fatalf("svc.ProcessMailQueue failed: %+v", err)
+	}
+	msgs := srv.Messages()
+	if assert.Len(t, msgs, 1) {
+		data := strings.ReplaceAll(msgs[0].Data, "\r\n ", " ")
+		assert.Contains(t, data, "List-Unsubscribe: <https://mail.example.com/v1/unsubscribe/"+token+">")
+		assert.Contains(t, data, "List-Unsubscribe-Post: List-Unsubscribe=One-Click")
+	}
+
+	// a single link cannot unsubscribe several To recipients so the
+	// headers are left out
+	if _, err := svc.SendEmailAsync(ctx, entity.SendEmailParams{
+		TemplateID:     "t1",
+		ProjectID:      "p1",
+		TransportID:    "tr1",
+		To:             []string{"to@example.com", "other@example.com"},
+		Subject:        "News",
+		TemplateParams: map[string]string{"name": "Andy"},
+		Unsubscribe:    true,
+	}); err != nil {
+		t.Fatalf("svc.SendEmailAsync failed: %+v", err)
+	}
+	if _, err := svc.ProcessMailQueue(ctx); err != nil {
+		t.Fatalf("svc.ProcessMailQueue failed: %+v", err)
+	}
+	msgs = srv.Messages()
+	if assert.Len(t, msgs, 2) {
+		assert.NotContains(t, msgs[1].Data, "List-Unsubscribe")
+	}
+
+	sp, err := svc.Unsubscribe(ctx, token)
+	if err != nil {
+		t.Fatalf("svc.Unsubscribe failed: %+v", err)
+	}
+	assert.Equal(t, "p1", sp.ProjectID)
+	assert.Equal(t, "to@example.com", sp.Email)
+	assert.Equal(t, entity.SuppressionReasonUnsubscribe, sp.Reason)
+
+	// later emails to the recipient are blocked
+	mq = queueTestEmail(t, svc)
+	assert.Equal(t, entity.MailStateBlocked, mq.State)
+
+	for _, bad := range []string{
+		"",
+		"no-dot",
+		token + "x",
+		svc.UnsubscribeToken("p2", "to@example.com")[:4] + token[4:],
+	} {
+		_, err := svc.Unsubscribe(ctx, bad)
+		assertServiceErrorCode(t, err, entity.ErrInvalidUnsubscribeCode)
+	}
+
+	// a token signed with another key is rejected
+	other := newTestService(t, service.WithHexEncodedEncryptionKey("00112233445566778899aabbccddeeff"))
+	_, err = svc.Unsubscribe(ctx, other.UnsubscribeToken("p1", "to@example.com"))
+	assertServiceErrorCode(t, err, entity.ErrInvalidUnsubscribeCode)
+}
+
+func TestUnsubscribeRequiresURL(t *testing.T) {
+	srv := newFakeSMTPServer(t)
+	svc := newTestService(t)
+	setupQueueProject(t, svc, srv)
+
+	assert.Empty(t, svc.UnsubscribeURL("p1", "to@example.com"))
+	_, err := svc.SendEmailAsync(context.Background(), entity.SendEmailParams{
+		TemplateID:     "t1",
+		ProjectID:      "p1",
+		TransportID:    "tr1",
+		To:             []string{"to@example.com"},
+		TemplateParams: map[string]string{"name": "Andy"},
+		Unsubscribe:    true,
+	})
+	assertServiceErrorCode(t, err, entity.ErrNoUnsubscribeURLCode)
+}
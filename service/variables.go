@@ -0,0 +1,106 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"regexp"
+	"unicode/utf8"
+
+	"github.com/andyfusniak/squishy-mailer-lite/entity"
+	"github.com/andyfusniak/squishy-mailer-lite/internal/store"
+	"github.com/pkg/errors"
+)
+
+// projectParam is the template param holding the project's variables.
+const projectParam = "Project"
+
+// project variable limits keep the variables small enough to be read for
+// every email.
+const (
+	maxProjectVariables           = 64
+	maxProjectVariableNameLength  = 64
+	maxProjectVariableValueLength = 4096
+)
+
+// projectVariableNameRe matches the names that can be used in a template
+// as {{.Project.name}}.
+var projectVariableNameRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// checkProjectVariables validates the variables of a project.
+func checkProjectVariables(variables map[string]string) error {
+	if len(variables) > maxProjectVariables {
+		return entity.NewServiceError(entity.ErrInvalidProjectVariablesCode,
+			fmt.Errorf("%d variables given, at most %d are allowed", len(variables), maxProjectVariables))
+	}
+	for k, v := range variables {
+		if !projectVariableNameRe.MatchString(k) {
+			return entity.NewServiceError(entity.ErrInvalidProjectVariablesCode,
+				fmt.Errorf("variable name %q must be letters, digits and underscores, not starting with a digit", k))
+		}
+		if len(k) > maxProjectVariableNameLength {
+			return entity.NewServiceError(entity.ErrInvalidProjectVariablesCode,
+				fmt.Errorf("variable name %q is longer than %d characters", k, maxProjectVariableNameLength))
+		}
+		if utf8.RuneCountInString(v) > maxProjectVariableValueLength {
+			return entity.NewServiceError(entity.ErrInvalidProjectVariablesCode,
+				fmt.Errorf("variable %q value is longer than %d characters", k, maxProjectVariableValueLength))
+		}
+	}
+	return nil
+}
+
+// SetProjectVariables replaces the variables of a project. An empty map
+// removes them. Every template of the project can use the variables as
+// {{.Project.name}}, unless the template params of a send have their own
+// Project param. Template params given as a struct are converted to a map
+// through JSON, as they are recorded in the mail queue, when the project
+// has variables.
+func (s *Service) SetProjectVariables(ctx context.Context, projectID string, variables map[string]string) (*entity.Project, error) {
+	if err := checkProjectVariables(variables); err != nil {
+		return nil, err
+	}
+
+	obj, err := s.store.SetProjectVariables(ctx, projectID, store.JSONObject(variables))
+	if err != nil {
+		if serr := serviceErrorFromStore(err); serr != nil {
+			return nil, serr
+		}
+		return nil, errors.Wrapf(err, "[service] store.SetProjectVariables failed")
+	}
+	if err := checkProjectScope("project", projectID, obj.ProjectID); err != nil {
+		return nil, err
+	}
+	return projectFromStoreObject(obj), nil
+}
+
+// projectVariables returns the variables of a project.
+func (s *Service) projectVariables(ctx context.Context, projectID string) (map[string]string, error) {
+	p, err := s.store.GetProject(ctx, projectID)
+	if err != nil {
+		if serr := serviceErrorFromStore(err); serr != nil {
+			return nil, serr
+		}
+		return nil, errors.Wrapf(err, "[service] store.GetProject failed")
+	}
+	if err := checkProjectScope("project", projectID, p.ProjectID); err != nil {
+		return nil, err
+	}
+	return p.Variables, nil
+}
+
+// withProjectVariables returns a copy of the template params with the
+// project's variables added as the Project param. It returns false if
+// there are no variables or the params have their own Project param.
+func withProjectVariables(params map[string]any, variables map[string]string) (map[string]any, bool) {
+	if len(variables) == 0 {
+		return nil, false
+	}
+	if _, ok := params[projectParam]; ok {
+		return nil, false
+	}
+	m := make(map[string]any, len(params)+1)
+	maps.Copy(m, params)
+	m[projectParam] = variables
+	return m, true
+}
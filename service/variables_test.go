@@ -0,0 +1,83 @@
+package service_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/andyfusniak/squishy-mailer-lite/entity"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProjectVariables(t *testing.T) {
+	srv := newFakeSMTPServer(t)
+	svc := newTestService(t)
+	setupQueueProject(t, svc, srv)
+
+	ctx := context.Background()
+	p, err := svc.SetProjectVariables(ctx, "p1", map[string]string{
+		"brand":   "Acme",
+		"support": "help@acme.example",
+	})
+	if err != nil {
+		t.Fatalf("svc.SetProjectVariables failed: %+v", err)
+	}
+	assert.Equal(t, "Acme", p.Variables["brand"])
+
+	if _, err := svc.CreateTemplate(ctx, entity.CreateTemplate{
+		ID:        "welcome",
+		ProjectID: "p1",
+		GroupID:   "g1",
+		Subject:   "Welcome to {{.Project.brand}}",
+		Text:      `{{define "layout"}}Hello {{.name}}, contact {{.Project.support}}{{end}}`,
+		HTML:      `{{define "layout"}}<p>{{.Project.brand}}</p>{{end}}`,
+	}); err != nil {
+		t.Fatalf("svc.CreateTemplate failed: %+v", err)
+	}
+
+	params := entity.SendEmailParams{
+		TemplateID:     "welcome",
+		ProjectID:      "p1",
+		TransportID:    "tr1",
+		To:             []string{"to@example.com"},
+		TemplateParams: map[string]string{"name": "Andy"},
+		StrictParams:   true,
+	}
+	mq, err := svc.SendEmailAsync(ctx, params)
+	if err != nil {
+		t.Fatalf("svc.SendEmailAsync failed: %+v", err)
+	}
+	assert.Equal(t, "Welcome to Acme", mq.Subject)
+	assert.Equal(t, "Hello Andy, contact help@acme.example", mq.Text)
+	assert.Equal(t, "<p>Acme</p>", mq.HTML)
+
+	// a Project param of the send takes precedence
+	params.TemplateParams = map[string]any{
+		"name":    "Andy",
+		"Project": map[string]string{"brand": "Other", "support": "other@example.com"},
+	}
+	mq, err = svc.SendEmailAsync(ctx, params)
+	if err != nil {
+		t.Fatalf("svc.SendEmailAsync failed: %+v", err)
+	}
+	assert.Equal(t, "Welcome to Other", mq.Subject)
+
+	got, err := svc.GetProject(ctx, "p1")
+	if err != nil {
+		t.Fatalf("svc.GetProject failed: %+v", err)
+	}
+	assert.Equal(t, p.Variables, got.Variables)
+
+	for _, vars := range []map[string]string{
+		{"1st": "x"},
+		{"brand-name": "x"},
+	} {
+		_, err := svc.SetProjectVariables(ctx, "p1", vars)
+		assertServiceErrorCode(t, err, entity.ErrInvalidProjectVariablesCode)
+	}
+
+	p, err = svc.SetProjectVariables(ctx, "p1", map[string]string{})
+	if err != nil {
+		t.Fatalf("svc.SetProjectVariables failed: %+v", err)
+	}
+	assert.Empty(t, p.Variables)
+}
@@ -0,0 +1,158 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	htmltemplate "html/template"
+	txttemplate "text/template"
+
+	"github.com/andyfusniak/squishy-mailer-lite/entity"
+	"github.com/andyfusniak/squishy-mailer-lite/internal/store"
+	"github.com/pkg/errors"
+	"golang.org/x/text/language"
+)
+
+// SetTemplateVariant creates or replaces the translation of a template into
+// a locale. Emails sent with SendEmailParams.Locale set use the variant
+// that best matches the locale. The template's own bodies are the default
+// locale and are used when no variant matches.
+func (s *Service) SetTemplateVariant(ctx context.Context, params entity.SetTemplateVariantParams) (*entity.TemplateVariant, error) {
+	tag, err := language.Parse(params.Locale)
+	if err != nil {
+		return nil, entity.NewServiceError(entity.ErrInvalidTemplateCode,
+			fmt.Errorf("invalid locale %q: %w", params.Locale, err))
+	}
+	funcs := templateFuncs(nil, nil)
+	if _, err := txttemplate.New("layout").Funcs(funcs).Parse(params.Text); err != nil {
+		return nil, entity.NewServiceError(entity.ErrInvalidTemplateCode,
+			errors.Wrapf(err, "[service] txt template.New.Parse failed"))
+	}
+	if _, err := htmltemplate.New("layout").Funcs(funcs).Parse(params.HTML); err != nil {
+		return nil, entity.NewServiceError(entity.ErrInvalidTemplateCode,
+			errors.Wrapf(err, "[service] html template.New.Parse failed"))
+	}
+	if _, err := txttemplate.New("subject").Funcs(funcs).Parse(params.Subject); err != nil {
+		return nil, entity.NewServiceError(entity.ErrInvalidTemplateCode,
+			errors.Wrapf(err, "[service] subject template.New.Parse failed"))
+	}
+
+	obj, err := s.store.SetTemplateVariant(ctx, store.SetTemplateVariant{
+		TemplateID: params.TemplateID,
+		ProjectID:  params.ProjectID,
+		Locale:     tag.String(),
+		Txt:        params.Text,
+		TxtDigest:  contentDigest([]byte(params.Text)),
+		HTML:       params.HTML,
+		HTMLDigest: contentDigest([]byte(params.HTML)),
+		Subject:    params.Subject,
+	})
+	if err != nil {
+		if serr := serviceErrorFromStore(err); serr != nil {
+			return nil, serr
+		}
+		return nil, errors.Wrapf(err, "[service] store.SetTemplateVariant failed")
+	}
+	if err := checkProjectScope("template variant", params.ProjectID, obj.ProjectID); err != nil {
+		return nil, err
+	}
+	s.cache.invalidateTemplate(params.ProjectID, params.TemplateID, "")
+	return templateVariantFromStoreObject(obj), nil
+}
+
+// ListTemplateVariants lists the locale variants of a template ordered by
+// locale.
+func (s *Service) ListTemplateVariants(ctx context.Context, projectID, templateID string) ([]*entity.TemplateVariant, error) {
+	list, err := s.listTemplateVariants(ctx, projectID, templateID)
+	if err != nil {
+		return nil, err
+	}
+
+	variants := make([]*entity.TemplateVariant, 0, len(list))
+	for _, obj := range list {
+		variants = append(variants, templateVariantFromStoreObject(obj))
+	}
+	return variants, nil
+}
+
+// DeleteTemplateVariant deletes the variant of a template for a locale.
+// Emails sent in the locale then use the next best match.
+func (s *Service) DeleteTemplateVariant(ctx context.Context, projectID, templateID, locale string) error {
+	if tag, err := language.Parse(locale); err == nil {
+		locale = tag.String()
+	}
+	if err := s.store.DeleteTemplateVariant(ctx, projectID, templateID, locale); err != nil {
+		if serr := serviceErrorFromStore(err); serr != nil {
+			return serr
+		}
+		return errors.Wrapf(err, "[service] store.DeleteTemplateVariant failed")
+	}
+	s.cache.invalidateTemplate(projectID, templateID, "")
+	s.parsed.invalidateTemplate(projectID, templateID)
+	return nil
+}
+
+func (s *Service) listTemplateVariants(ctx context.Context, projectID, templateID string) ([]*store.TemplateVariant, error) {
+	list, err := s.store.ListTemplateVariants(ctx, projectID, templateID)
+	if err != nil {
+		return nil, errors.Wrapf(err, "[service] store.ListTemplateVariants failed")
+	}
+	for _, obj := range list {
+		if err := checkProjectScope("template variant", projectID, obj.ProjectID); err != nil {
+			return nil, err
+		}
+	}
+	return list, nil
+}
+
+// localizeTemplate returns the template with its bodies and subject
+// replaced by those of the variant that best matches the locale. The
+// template is returned unchanged if the locale is empty or no variant
+// matches.
+func (s *Service) localizeTemplate(ctx context.Context, t *store.Template, locale string) (*store.Template, error) {
+	if locale == "" {
+		return t, nil
+	}
+	want, err := language.Parse(locale)
+	if err != nil {
+		return t, nil
+	}
+	variants, err := s.listTemplateVariants(ctx, t.ProjectID, t.TemplateID)
+	if err != nil || len(variants) == 0 {
+		return t, err
+	}
+
+	// the template itself is the first supported tag so that it is chosen
+	// when no variant matches
+	tags := []language.Tag{language.Und}
+	for _, v := range variants {
+		tags = append(tags, language.Make(v.Locale))
+	}
+	_, i, confidence := language.NewMatcher(tags).Match(want)
+	if i == 0 || confidence == language.No {
+		return t, nil
+	}
+
+	v := variants[i-1]
+	c := *t
+	c.Txt, c.TxtDigest = v.Txt, v.TxtDigest
+	c.HTML, c.HTMLDigest = v.HTML, v.HTMLDigest
+	if v.Subject != "" {
+		c.Subject = v.Subject
+	}
+	return &c, nil
+}
+
+func templateVariantFromStoreObject(obj *store.TemplateVariant) *entity.TemplateVariant {
+	return &entity.TemplateVariant{
+		TemplateID: obj.TemplateID,
+		ProjectID:  obj.ProjectID,
+		Locale:     obj.Locale,
+		Text:       obj.Txt,
+		TextDigest: obj.TxtDigest,
+		HTML:       obj.HTML,
+		HTMLDigest: obj.HTMLDigest,
+		Subject:    obj.Subject,
+		CreatedAt:  entity.ISOTime(obj.CreatedAt),
+		ModifiedAt: entity.ISOTime(obj.ModifiedAt),
+	}
+}
@@ -0,0 +1,122 @@
+package service_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/andyfusniak/squishy-mailer-lite/entity"
+	"github.com/andyfusniak/squishy-mailer-lite/service"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTemplateVariants(t *testing.T) {
+	stores := []struct {
+		name string
+		opts []service.Option
+	}{
+		{"sqlite3", nil},
+		{"memory", []service.Option{service.WithInMemoryStore()}},
+	}
+	for _, st := range stores {
+		t.Run(st.name, func(t *testing.T) {
+			srv := newFakeSMTPServer(t)
+			svc := newTestService(t, st.opts...)
+			setupQueueProject(t, svc, srv)
+
+			ctx := context.Background()
+			for _, v := range []entity.SetTemplateVariantParams{
+				{
+					TemplateID: "t1",
+					ProjectID:  "p1",
+					Locale:     "fr",
+					Text:       `{{define "layout"}}Bonjour {{.name}}{{end}}`,
+					HTML:       `{{define "layout"}}<p>Bonjour {{.name}}</p>{{end}}`,
+					Subject:    "Bienvenue {{.name}}",
+				},
+				{
+					TemplateID: "t1",
+					ProjectID:  "p1",
+					Locale:     "de",
+					Text:       `{{define "layout"}}Hallo {{.name}}{{end}}`,
+					HTML:       `{{define "layout"}}<p>Hallo {{.name}}</p>{{end}}`,
+				},
+			} {
+				if _, err := svc.SetTemplateVariant(ctx, v); err != nil {
+					t.Fatalf("svc.SetTemplateVariant failed: %+v", err)
+				}
+			}
+
+			variants, err := svc.ListTemplateVariants(ctx, "p1", "t1")
+			if err != nil {
+				t.Fatalf("svc.ListTemplateVariants failed: %+v", err)
+			}
+			if assert.Len(t, variants, 2) {
+				assert.Equal(t, "de", variants[0].Locale)
+				assert.Equal(t, "fr", variants[1].Locale)
+				assert.NotEmpty(t, variants[1].TextDigest)
+			}
+
+			send := func(locale, subject string) *entity.MailQueue {
+				t.Helper()
+
+				mq, err := svc.SendEmailAsync(ctx, entity.SendEmailParams{
+					TemplateID:     "t1",
+					ProjectID:      "p1",
+					TransportID:    "tr1",
+					To:             []string{"to@example.com"},
+					Subject:        subject,
+					TemplateParams: map[string]string{"name": "Andy"},
+					Locale:         locale,
+				})
+				if err != nil {
+					t.Fatalf("svc.SendEmailAsync failed: %+v", err)
+				}
+				return mq
+			}
+
+			// fr-CA falls back to the fr variant and its subject
+			mq := send("fr-CA", "")
+			assert.Equal(t, "Bonjour Andy", mq.Text)
+			assert.Equal(t, "Bienvenue Andy", mq.Subject)
+
+			mq = send("de", "Willkommen")
+			assert.Equal(t, "Hallo Andy", mq.Text)
+			assert.Equal(t, "Willkommen", mq.Subject)
+
+			// locales without a variant use the template itself
+			for _, locale := range []string{"es", ""} {
+				mq = send(locale, "Welcome")
+				assert.Equal(t, "Hello Andy, this is the text body of the email", mq.Text)
+			}
+
+			err = svc.DeleteTemplateVariant(ctx, "p1", "t1", "fr")
+			assert.NoError(t, err)
+			err = svc.DeleteTemplateVariant(ctx, "p1", "t1", "fr")
+			assertServiceErrorCode(t, err, entity.ErrVariantNotFoundCode)
+
+			_, err = svc.SetTemplateVariant(ctx, entity.SetTemplateVariantParams{
+				TemplateID: "t1",
+				ProjectID:  "p1",
+				Locale:     "not a locale",
+			})
+			assertServiceErrorCode(t, err, entity.ErrInvalidTemplateCode)
+
+			_, err = svc.SetTemplateVariant(ctx, entity.SetTemplateVariantParams{
+				TemplateID: "missing",
+				ProjectID:  "p1",
+				Locale:     "fr",
+			})
+			assertServiceErrorCode(t, err, entity.ErrTemplateNotFoundCode)
+
+			// deleting the template deletes its variants
+			if err := svc.DeleteTemplate(ctx, "p1", "t1"); err != nil {
+				t.Fatalf("svc.DeleteTemplate failed: %+v", err)
+			}
+			variants, err = svc.ListTemplateVariants(ctx, "p1", "t1")
+			if err != nil {
+				t.Fatalf("svc.ListTemplateVariants failed: %+v", err)
+			}
+			assert.Empty(t, variants)
+		})
+	}
+}
@@ -0,0 +1,93 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/andyfusniak/squishy-mailer-lite/entity"
+	"github.com/andyfusniak/squishy-mailer-lite/internal/store"
+	"github.com/pkg/errors"
+)
+
+// SetSMTPTransportWarmup puts a transport into warm-up mode. Each entry of
+// the schedule is the maximum number of emails the transport may send on
+// that day of the warm-up, for example []int{50, 100, 200}. Queued emails
+// over the daily limit are deferred to the next day. Once the schedule is
+// exhausted the transport is no longer throttled. An empty schedule takes
+// the transport out of warm-up mode.
+func (s *Service) SetSMTPTransportWarmup(ctx context.Context, params entity.SetSMTPTransportWarmupParams) (*entity.SMTPTransport, error) {
+	for i, n := range params.Schedule {
+		if n < 0 {
+			return nil, entity.NewServiceError(entity.ErrInvalidWarmupScheduleCode,
+				fmt.Errorf("day %d has a negative limit of %d", i+1, n))
+		}
+	}
+
+	startedAt := params.StartedAt
+	if startedAt.IsZero() {
+		startedAt = time.Now()
+	}
+
+	obj, err := s.store.SetSMTPTransportWarmup(ctx, params.TransportID, params.ProjectID,
+		store.JSONIntArray(params.Schedule), store.Datetime(startedAt.UTC()))
+	if err != nil {
+		if serr := serviceErrorFromStore(err); serr != nil {
+			return nil, serr
+		}
+		return nil, errors.Wrapf(err, "[service] store.SetSMTPTransportWarmup failed")
+	}
+	if err := checkProjectScope("transport", params.ProjectID, obj.ProjectID); err != nil {
+		return nil, err
+	}
+	return smtpTransportFromStoreObject(obj), nil
+}
+
+// warmupDeferral reports whether a claimed email should be deferred because
+// its transport has reached its warm-up limit for the day. If so it
+// returns the start of the next day (UTC) and the reason for the deferral.
+// A zero time means the email may be delivered now.
+func (s *Service) warmupDeferral(ctx context.Context, mq *store.MailQueue, now time.Time) (time.Time, string, error) {
+	tr, err := s.store.GetSMTPTransport(ctx, mq.TransportID, mq.ProjectID)
+	if errors.Is(err, store.ErrTransportNotFound) {
+		// warm-up schedules only apply to SMTP transports
+		return time.Time{}, "", nil
+	}
+	if err != nil {
+		if serr := serviceErrorFromStore(err); serr != nil {
+			return time.Time{}, "", serr
+		}
+		return time.Time{}, "", errors.Wrapf(err, "[service] store.GetSMTPTransport failed")
+	}
+	if len(tr.WarmupSchedule) == 0 {
+		return time.Time{}, "", nil
+	}
+
+	today := truncateDay(now)
+	day := int(today.Sub(truncateDay(time.Time(tr.WarmupStartedAt))).Hours() / 24)
+	if day < 0 {
+		// a warm-up scheduled to start in the future uses the first
+		// day's limit until it begins
+		day = 0
+	}
+	if day >= len(tr.WarmupSchedule) {
+		return time.Time{}, "", nil
+	}
+	limit := tr.WarmupSchedule[day]
+
+	n, err := s.store.CountMailQueueSent(ctx, mq.ProjectID, mq.TransportID, store.Datetime(today))
+	if err != nil {
+		return time.Time{}, "", errors.Wrapf(err, "[service] store.CountMailQueueSent failed")
+	}
+	if n < limit {
+		return time.Time{}, "", nil
+	}
+	return today.AddDate(0, 0, 1),
+		fmt.Sprintf("transport warm-up limit of %d emails reached for day %d", limit, day+1), nil
+}
+
+// truncateDay returns the start of the UTC day containing t.
+func truncateDay(t time.Time) time.Time {
+	y, m, d := t.UTC().Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
@@ -0,0 +1,98 @@
+package service_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/andyfusniak/squishy-mailer-lite/entity"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWarmupDefersOverDailyLimit(t *testing.T) {
+	srv := newFakeSMTPServer(t)
+	svc := newTestService(t)
+	setupQueueProject(t, svc, srv)
+
+	ctx := context.Background()
+	tr, err := svc.SetSMTPTransportWarmup(ctx, entity.SetSMTPTransportWarmupParams{
+		TransportID: "tr1",
+		ProjectID:   "p1",
+		Schedule:    []int{3, 10},
+	})
+	if err != nil {
+		t.Fatalf("svc.SetSMTPTransportWarmup failed: %+v", err)
+	}
+	assert.Equal(t, []int{3, 10}, tr.WarmupSchedule)
+
+	var queued []*entity.MailQueue
+	for i := 0; i < 5; i++ {
+		queued = append(queued, queueTestEmail(t, svc))
+	}
+
+	n, err := svc.ProcessMailQueue(ctx)
+	if err != nil {
+		t.Fatalf("svc.ProcessMailQueue failed: %+v", err)
+	}
+	assert.Equal(t, 3, n)
+	assert.Len(t, srv.Messages(), 3)
+
+	// the remainder is deferred to the start of the next day
+	y, m, d := time.Now().UTC().Date()
+	tomorrow := time.Date(y, m, d+1, 0, 0, 0, 0, time.UTC)
+	for _, q := range queued[3:] {
+		deferred, err := svc.GetMailQueue(ctx, "p1", q.ID)
+		if err != nil {
+			t.Fatalf("svc.GetMailQueue failed: %+v", err)
+		}
+		assert.Equal(t, entity.MailStateQueued, deferred.State)
+		assert.Contains(t, deferred.DeferralReason, "warm-up limit of 3 emails reached for day 1")
+		assert.WithinDuration(t, tomorrow, time.Time(deferred.NextAttemptAt), time.Second)
+	}
+}
+
+func TestWarmupScheduleExhausted(t *testing.T) {
+	srv := newFakeSMTPServer(t)
+	svc := newTestService(t)
+	setupQueueProject(t, svc, srv)
+
+	// a two day schedule that started three days ago no longer applies
+	ctx := context.Background()
+	if _, err := svc.SetSMTPTransportWarmup(ctx, entity.SetSMTPTransportWarmupParams{
+		TransportID: "tr1",
+		ProjectID:   "p1",
+		Schedule:    []int{1, 1},
+		StartedAt:   time.Now().AddDate(0, 0, -3),
+	}); err != nil {
+		t.Fatalf("svc.SetSMTPTransportWarmup failed: %+v", err)
+	}
+
+	for i := 0; i < 3; i++ {
+		queueTestEmail(t, svc)
+	}
+	n, err := svc.ProcessMailQueue(ctx)
+	if err != nil {
+		t.Fatalf("svc.ProcessMailQueue failed: %+v", err)
+	}
+	assert.Equal(t, 3, n)
+}
+
+func TestSetSMTPTransportWarmupValidation(t *testing.T) {
+	svc := newTestService(t)
+	setupTwoProjects(t, svc)
+
+	ctx := context.Background()
+	_, err := svc.SetSMTPTransportWarmup(ctx, entity.SetSMTPTransportWarmupParams{
+		TransportID: "tr1",
+		ProjectID:   "pa",
+		Schedule:    []int{50, -1},
+	})
+	assertServiceErrorCode(t, err, entity.ErrInvalidWarmupScheduleCode)
+
+	_, err = svc.SetSMTPTransportWarmup(ctx, entity.SetSMTPTransportWarmupParams{
+		TransportID: "tr1",
+		ProjectID:   "pb",
+		Schedule:    []int{50},
+	})
+	assertServiceErrorCode(t, err, entity.ErrTransportNotFoundCode)
+}
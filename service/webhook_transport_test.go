@@ -0,0 +1,110 @@
+package service_test
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/andyfusniak/squishy-mailer-lite/entity"
+	"github.com/andyfusniak/squishy-mailer-lite/service"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWebhookTransport(t *testing.T) {
+	srv := newFakeSMTPServer(t)
+	api := newFakeAPIServer(t)
+	svc := newTestService(t)
+	setupQueueProject(t, svc, srv)
+
+	ctx := context.Background()
+	tr, err := svc.CreateWebhookTransport(ctx, entity.CreateWebhookTransport{
+		ID:            "wh",
+		ProjectID:     "p1",
+		Name:          "Relay",
+		URL:           api.URL + "/relay",
+		SigningSecret: "signing-secret",
+		EmailFrom:     "noreply@example.com",
+		EmailFromName: "Example",
+	})
+	if err != nil {
+		t.Fatalf("svc.CreateWebhookTransport failed: %+v", err)
+	}
+	assert.Equal(t, entity.APITransportProviderWebhook, tr.Provider)
+	assert.Equal(t, api.URL+"/relay", tr.Config["url"])
+
+	err = svc.SendEmail(ctx, entity.SendEmailParams{
+		TemplateID:     "t1",
+		ProjectID:      "p1",
+		TransportID:    "wh",
+		To:             []string{"to@example.com"},
+		Subject:        "Welcome",
+		TemplateParams: map[string]string{"name": "Andy"},
+	})
+	if err != nil {
+		t.Fatalf("svc.SendEmail failed: %+v", err)
+	}
+
+	reqs := api.Requests()
+	if assert.Len(t, reqs, 1) {
+		req := reqs[0]
+		assert.Equal(t, "/relay", req.Path)
+		assert.Equal(t, "application/json", req.Header.Get("Content-Type"))
+		assert.True(t, service.VerifyWebhookSignature("signing-secret",
+			req.Header.Get(service.WebhookTimestampHeader),
+			req.Header.Get(service.WebhookSignatureHeader), req.Body))
+		assert.False(t, service.VerifyWebhookSignature("wrong-secret",
+			req.Header.Get(service.WebhookTimestampHeader),
+			req.Header.Get(service.WebhookSignatureHeader), req.Body))
+
+		var body struct {
+			From     string   `json:"from"`
+			FromName string   `json:"from_name"`
+			To       []string `json:"to"`
+			Subject  string   `json:"subject"`
+			Text     string   `json:"text"`
+		}
+		if err := json.Unmarshal(req.Body, &body); err != nil {
+			t.Fatalf("json.Unmarshal failed: %+v", err)
+		}
+		assert.Equal(t, "noreply@example.com", body.From)
+		assert.Equal(t, "Example", body.FromName)
+		assert.Equal(t, []string{"to@example.com"}, body.To)
+		assert.Equal(t, "Welcome", body.Subject)
+		assert.Contains(t, body.Text, "Hello Andy")
+	}
+
+	// a 4xx response fails the delivery permanently
+	api.SetStatus(400)
+	err = svc.SendEmail(ctx, entity.SendEmailParams{
+		TemplateID:     "t1",
+		ProjectID:      "p1",
+		TransportID:    "wh",
+		To:             []string{"to@example.com"},
+		Subject:        "Welcome",
+		TemplateParams: map[string]string{"name": "Andy"},
+	})
+	assert.Error(t, err)
+
+	tests := []struct {
+		name   string
+		url    string
+		secret string
+	}{
+		{"plain http", "http://relay.example.com/send", "secret"},
+		{"bad scheme", "ftp://relay.example.com/send", "secret"},
+		{"missing secret", "https://relay.example.com/send", ""},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := svc.CreateWebhookTransport(ctx, entity.CreateWebhookTransport{
+				ID:            "wh2",
+				ProjectID:     "p1",
+				Name:          "Relay",
+				URL:           tc.url,
+				SigningSecret: tc.secret,
+				EmailFrom:     "noreply@example.com",
+			})
+			assertServiceErrorCode(t, err, entity.ErrInvalidTransportCode)
+		})
+	}
+}
@@ -0,0 +1,344 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/andyfusniak/squishy-mailer-lite/entity"
+	"github.com/andyfusniak/squishy-mailer-lite/internal/email"
+	"github.com/andyfusniak/squishy-mailer-lite/internal/store"
+	"github.com/pkg/errors"
+)
+
+// defaultWebhookRetryPolicy is used when no policy is specified with
+// WithWebhookRetryPolicy.
+var defaultWebhookRetryPolicy = RetryPolicy{MaxAttempts: 8}
+
+// webhookEvents lists the events a webhook can subscribe to.
+var webhookEvents = []entity.WebhookEvent{
+	entity.WebhookEventQueued,
+	entity.WebhookEventSent,
+	entity.WebhookEventFailed,
+	entity.WebhookEventBounced,
+}
+
+// WebhookPayload is the JSON body POSTed to a webhook for each event. The
+// request carries the WebhookTimestampHeader and WebhookSignatureHeader
+// headers and can be checked with VerifyWebhookSignature. ID is unique to
+// the event and is the same for every attempt to deliver it, so receivers
+// can ignore duplicates.
+type WebhookPayload struct {
+	ID        string              `json:"id"`
+	Event     entity.WebhookEvent `json:"event"`
+	CreatedAt time.Time           `json:"created_at"`
+	ProjectID string              `json:"project_id"`
+	Mail      WebhookMail         `json:"mail"`
+}
+
+// WebhookMail describes the email an event is about.
+type WebhookMail struct {
+	ID           string           `json:"id"`
+	TemplateID   string           `json:"template_id"`
+	TransportID  string           `json:"transport_id"`
+	To           []string         `json:"to"`
+	Subject      string           `json:"subject"`
+	State        entity.MailState `json:"state"`
+	Attempts     int              `json:"attempts"`
+	Error        string           `json:"error,omitempty"`
+	ResponseCode int              `json:"response_code,omitempty"`
+}
+
+// CreateWebhook creates a webhook endpoint for a project. The endpoint
+// receives a signed WebhookPayload for each of the events it subscribes
+// to. Events are delivered by ProcessWebhookDeliveries and retried
+// according to the webhook retry policy until the endpoint returns a 2xx
+// response.
+func (s *Service) CreateWebhook(ctx context.Context, params entity.CreateWebhookParams) (*entity.Webhook, error) {
+	if err := s.idPolicy.validate("webhook", params.ID); err != nil {
+		return nil, err
+	}
+	u, err := url.Parse(params.URL)
+	if err != nil {
+		return nil, entity.NewServiceError(entity.ErrInvalidWebhookCode, err)
+	}
+	if u.Scheme != "https" && !(u.Scheme == "http" && isLoopbackHost(u.Hostname())) {
+		return nil, entity.NewServiceError(entity.ErrInvalidWebhookCode,
+			errors.Errorf("webhook url %q must use https", params.URL))
+	}
+	if params.Secret == "" {
+		return nil, entity.NewServiceError(entity.ErrInvalidWebhookCode,
+			errors.New("webhook secret is required"))
+	}
+	events := make([]string, 0, len(params.Events))
+	for _, e := range params.Events {
+		if !slices.Contains(webhookEvents, e) {
+			return nil, entity.NewServiceError(entity.ErrInvalidWebhookCode,
+				errors.Errorf("unknown webhook event %q", e))
+		}
+		events = append(events, string(e))
+	}
+
+	encryptedSecret, err := s.encryptSecret(params.Secret)
+	if err != nil {
+		return nil, err
+	}
+	obj, err := s.store.InsertWebhook(ctx, store.AddWebhook{
+		WebhookID:       params.ID,
+		ProjectID:       params.ProjectID,
+		URL:             params.URL,
+		EncryptedSecret: encryptedSecret,
+		Events:          events,
+	})
+	if err != nil {
+		if serr := serviceErrorFromStore(err); serr != nil {
+			return nil, serr
+		}
+		return nil, errors.Wrapf(err, "[service] store.InsertWebhook failed")
+	}
+	if err := checkProjectScope("webhook", params.ProjectID, obj.ProjectID); err != nil {
+		return nil, err
+	}
+	return webhookFromStoreObject(obj), nil
+}
+
+// GetWebhook retrieves a webhook endpoint. If the webhook is not found an
+// error is returned with a code of ErrWebhookNotFoundCode.
+func (s *Service) GetWebhook(ctx context.Context, projectID, webhookID string) (*entity.Webhook, error) {
+	obj, err := s.store.GetWebhook(ctx, projectID, webhookID)
+	if err != nil {
+		if serr := serviceErrorFromStore(err); serr != nil {
+			return nil, serr
+		}
+		return nil, errors.Wrapf(err, "[service] store.GetWebhook failed")
+	}
+	if err := checkProjectScope("webhook", projectID, obj.ProjectID); err != nil {
+		return nil, err
+	}
+	return webhookFromStoreObject(obj), nil
+}
+
+// ListWebhooks lists the webhook endpoints of a project.
+func (s *Service) ListWebhooks(ctx context.Context, projectID string) ([]*entity.Webhook, error) {
+	list, err := s.store.ListWebhooks(ctx, projectID)
+	if err != nil {
+		return nil, errors.Wrapf(err, "[service] store.ListWebhooks failed")
+	}
+
+	webhooks := make([]*entity.Webhook, 0, len(list))
+	for _, obj := range list {
+		if err := checkProjectScope("webhook", projectID, obj.ProjectID); err != nil {
+			return nil, err
+		}
+		webhooks = append(webhooks, webhookFromStoreObject(obj))
+	}
+	return webhooks, nil
+}
+
+// DeleteWebhook deletes a webhook endpoint. Events not yet delivered to it
+// are discarded.
+func (s *Service) DeleteWebhook(ctx context.Context, projectID, webhookID string) error {
+	if err := s.store.DeleteWebhook(ctx, projectID, webhookID); err != nil {
+		if serr := serviceErrorFromStore(err); serr != nil {
+			return serr
+		}
+		return errors.Wrapf(err, "[service] store.DeleteWebhook failed")
+	}
+	return nil
+}
+
+// ListWebhookDeliveries lists the events sent, or waiting to be sent, to a
+// webhook endpoint, newest first.
+func (s *Service) ListWebhookDeliveries(ctx context.Context, projectID, webhookID string) ([]*entity.WebhookDelivery, error) {
+	if _, err := s.GetWebhook(ctx, projectID, webhookID); err != nil {
+		return nil, err
+	}
+	list, err := s.store.ListWebhookDeliveries(ctx, projectID, webhookID)
+	if err != nil {
+		return nil, errors.Wrapf(err, "[service] store.ListWebhookDeliveries failed")
+	}
+
+	deliveries := make([]*entity.WebhookDelivery, 0, len(list))
+	for _, obj := range list {
+		if err := checkProjectScope("webhook delivery", projectID, obj.ProjectID); err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, webhookDeliveryFromStoreObject(obj))
+	}
+	return deliveries, nil
+}
+
+func webhookFromStoreObject(obj *store.Webhook) *entity.Webhook {
+	events := make([]entity.WebhookEvent, 0, len(obj.Events))
+	for _, e := range obj.Events {
+		events = append(events, entity.WebhookEvent(e))
+	}
+	return &entity.Webhook{
+		ID:         obj.WebhookID,
+		ProjectID:  obj.ProjectID,
+		URL:        obj.URL,
+		Events:     events,
+		CreatedAt:  entity.ISOTime(obj.CreatedAt),
+		ModifiedAt: entity.ISOTime(obj.ModifiedAt),
+	}
+}
+
+func webhookDeliveryFromStoreObject(obj *store.WebhookDelivery) *entity.WebhookDelivery {
+	return &entity.WebhookDelivery{
+		ID:            obj.DeliveryID,
+		WebhookID:     obj.WebhookID,
+		ProjectID:     obj.ProjectID,
+		Event:         entity.WebhookEvent(obj.Event),
+		State:         entity.WebhookDeliveryState(obj.DState),
+		Attempts:      obj.Attempts,
+		ResponseCode:  obj.ResponseCode,
+		LastError:     obj.LastError,
+		NextAttemptAt: entity.ISOTime(obj.NextAttemptAt),
+		CreatedAt:     entity.ISOTime(obj.CreatedAt),
+		ModifiedAt:    entity.ISOTime(obj.ModifiedAt),
+	}
+}
+
+// emitWebhookEvent adds an event about mq to the deliveries of every
+// webhook of its project that subscribes to the event. sendErr is the
+// delivery error of a failed email, if any.
+func (s *Service) emitWebhookEvent(ctx context.Context, event entity.WebhookEvent, mq *store.MailQueue, sendErr error) error {
+	webhooks, err := s.store.ListWebhooks(ctx, mq.ProjectID)
+	if err != nil {
+		return errors.Wrapf(err, "[service] store.ListWebhooks failed")
+	}
+
+	for _, wh := range webhooks {
+		if len(wh.Events) > 0 && !slices.Contains(wh.Events, string(event)) {
+			continue
+		}
+		id, err := newMailQueueID()
+		if err != nil {
+			return errors.Wrapf(err, "[service] newMailQueueID failed")
+		}
+		payload := WebhookPayload{
+			ID:        id,
+			Event:     event,
+			CreatedAt: time.Now().UTC(),
+			ProjectID: mq.ProjectID,
+			Mail: WebhookMail{
+				ID:          mq.MailQueueID,
+				TemplateID:  mq.TemplateID,
+				TransportID: mq.TransportID,
+				To:          mq.Metadata.To,
+				Subject:     mq.Metadata.Subject,
+				State:       entity.MailState(mq.MState),
+				Attempts:    mq.Attempts,
+				Error:       mq.LastError,
+			},
+		}
+		if sendErr != nil {
+			payload.Mail.Error = sendErr.Error()
+			payload.Mail.ResponseCode, _ = attemptResponse(sendErr)
+		}
+		b, err := json.Marshal(&payload)
+		if err != nil {
+			return errors.Wrapf(err, "[service] json.Marshal failed")
+		}
+		if _, err := s.store.InsertWebhookDelivery(ctx, store.AddWebhookDelivery{
+			DeliveryID: id,
+			WebhookID:  wh.WebhookID,
+			ProjectID:  wh.ProjectID,
+			Event:      string(event),
+			Payload:    string(b),
+		}); err != nil {
+			return errors.Wrapf(err, "[service] store.InsertWebhookDelivery failed")
+		}
+	}
+	return nil
+}
+
+// ProcessWebhookDeliveries claims pending webhook deliveries and POSTs
+// them to their endpoints. A delivery that does not receive a 2xx
+// response is retried after a backoff, and marked as failed once it has
+// used all the attempts of the webhook retry policy. It returns the number
+// of deliveries that succeeded. Long running workers should call it
+// alongside ProcessMailQueue.
+func (s *Service) ProcessWebhookDeliveries(ctx context.Context) (int, error) {
+	ctx, done, err := s.startWork(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer done()
+
+	list, err := s.store.ClaimWebhookDeliveries(ctx, defaultClaimLimit)
+	if err != nil {
+		return 0, errors.Wrapf(err, "[service] store.ClaimWebhookDeliveries failed")
+	}
+
+	var delivered int
+	for _, d := range list {
+		code, sendErr := s.postWebhookDelivery(ctx, d)
+		params := store.UpdateWebhookDelivery{
+			DeliveryID:   d.DeliveryID,
+			DState:       store.WebhookDeliveryStateDelivered,
+			Attempts:     d.Attempts + 1,
+			ResponseCode: code,
+		}
+		if sendErr != nil {
+			s.logger.Warn("webhook delivery failed",
+				"project_id", d.ProjectID, "webhook_id", d.WebhookID,
+				"delivery_id", d.DeliveryID, "error", sendErr)
+			params.LastError = sendErr.Error()
+			params.DState = store.WebhookDeliveryStateFailed
+			if params.Attempts < s.webhookRetry.MaxAttempts {
+				next := store.Datetime(time.Now().UTC().Add(s.webhookRetry.backoff(params.Attempts)))
+				params.DState = store.WebhookDeliveryStatePending
+				params.NextAttemptAt = &next
+			}
+		}
+		if _, err := s.store.UpdateWebhookDelivery(ctx, params); err != nil {
+			return delivered, errors.Wrapf(err, "[service] store.UpdateWebhookDelivery failed")
+		}
+		if sendErr == nil {
+			delivered++
+		}
+	}
+	return delivered, nil
+}
+
+// postWebhookDelivery POSTs a delivery to its webhook endpoint and returns
+// the HTTP status, if there was a response.
+func (s *Service) postWebhookDelivery(ctx context.Context, d *store.WebhookDelivery) (int, error) {
+	wh, err := s.store.GetWebhook(ctx, d.ProjectID, d.WebhookID)
+	if err != nil {
+		return 0, errors.Wrapf(err, "[service] store.GetWebhook failed")
+	}
+	secret, err := s.decryptSecret(wh.EncryptedSecret)
+	if err != nil {
+		return 0, err
+	}
+
+	body := []byte(d.Payload)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, wh.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WebhookTimestampHeader, ts)
+	req.Header.Set(WebhookSignatureHeader, "sha256="+email.WebhookSignature([]byte(secret), ts, body))
+
+	resp, err := s.webhookClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("webhook endpoint responded %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
@@ -0,0 +1,224 @@
+package service_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/andyfusniak/squishy-mailer-lite/entity"
+	"github.com/andyfusniak/squishy-mailer-lite/service"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWebhookEvents(t *testing.T) {
+	stores := []struct {
+		name string
+		opts []service.Option
+	}{
+		{"sqlite3", nil},
+		{"memory", []service.Option{service.WithInMemoryStore()}},
+	}
+	for _, tc := range stores {
+		t.Run(tc.name, func(t *testing.T) {
+			srv := newFakeSMTPServer(t)
+			all := newFakeAPIServer(t)
+			failures := newFakeAPIServer(t)
+			svc := newTestService(t, tc.opts...)
+			setupQueueProject(t, svc, srv)
+			ctx := context.Background()
+
+			wh, err := svc.CreateWebhook(ctx, entity.CreateWebhookParams{
+				ID:        "all",
+				ProjectID: "p1",
+				URL:       all.URL + "/events",
+				Secret:    "all-secret",
+			})
+			if err != nil {
+				t.Fatalf("svc.CreateWebhook failed: %+v", err)
+			}
+			assert.Equal(t, "all", wh.ID)
+			assert.Empty(t, wh.Events)
+			if _, err := svc.CreateWebhook(ctx, entity.CreateWebhookParams{
+				ID:        "failures",
+				ProjectID: "p1",
+				URL:       failures.URL,
+				Secret:    "failures-secret",
+				Events:    []entity.WebhookEvent{entity.WebhookEventFailed},
+			}); err != nil {
+				t.Fatalf("svc.CreateWebhook failed: %+v", err)
+			}
+
+			sent := queueTestEmail(t, svc)
+			failed, err := svc.SendEmailAsync(ctx, entity.SendEmailParams{
+				TemplateID:     "t1",
+				ProjectID:      "p1",
+				TransportID:    "tr1",
+				To:             []string{"reject@example.com"},
+				TemplateParams: map[string]string{"name": "Andy"},
+			})
+			if err != nil {
+				t.Fatalf("svc.SendEmailAsync failed: %+v", err)
+			}
+			if _, err := svc.ProcessMailQueue(ctx); err != nil {
+				t.Fatalf("svc.ProcessMailQueue failed: %+v", err)
+			}
+			if _, err := svc.MarkMailBounced(ctx, "p1", sent.ID, "mailbox full"); err != nil {
+				t.Fatalf("svc.MarkMailBounced failed: %+v", err)
+			}
+
+			n, err := svc.ProcessWebhookDeliveries(ctx)
+			if err != nil {
+				t.Fatalf("svc.ProcessWebhookDeliveries failed: %+v", err)
+			}
+			assert.Equal(t, 6, n)
+
+			var events []string
+			for _, req := range all.Requests() {
+				assert.Equal(t, "/events", req.Path)
+				assert.True(t, service.VerifyWebhookSignature("all-secret",
+					req.Header.Get(service.WebhookTimestampHeader),
+					req.Header.Get(service.WebhookSignatureHeader), req.Body))
+
+				var p service.WebhookPayload
+				if err := json.Unmarshal(req.Body, &p); err != nil {
+					t.Fatalf("json.Unmarshal failed: %+v", err)
+				}
+				assert.Equal(t, "p1", p.ProjectID)
+				events = append(events, string(p.Event)+" "+p.Mail.ID)
+			}
+			assert.ElementsMatch(t, []string{
+				"mail.queued " + sent.ID,
+				"mail.queued " + failed.ID,
+				"mail.sent " + sent.ID,
+				"mail.failed " + failed.ID,
+				"mail.bounced " + sent.ID,
+			}, events)
+
+			reqs := failures.Requests()
+			if assert.Len(t, reqs, 1) {
+				var p service.WebhookPayload
+				if err := json.Unmarshal(reqs[0].Body, &p); err != nil {
+					t.Fatalf("json.Unmarshal failed: %+v", err)
+				}
+				assert.Equal(t, entity.WebhookEventFailed, p.Event)
+				assert.Equal(t, entity.MailStateFailed, p.Mail.State)
+				assert.Equal(t, 550, p.Mail.ResponseCode)
+				assert.Contains(t, p.Mail.Error, "mailbox unavailable")
+			}
+
+			deliveries, err := svc.ListWebhookDeliveries(ctx, "p1", "failures")
+			if err != nil {
+				t.Fatalf("svc.ListWebhookDeliveries failed: %+v", err)
+			}
+			if assert.Len(t, deliveries, 1) {
+				assert.Equal(t, entity.WebhookDeliveryStateDelivered, deliveries[0].State)
+				assert.Equal(t, 1, deliveries[0].Attempts)
+				assert.Equal(t, http.StatusOK, deliveries[0].ResponseCode)
+			}
+
+			if err := svc.DeleteWebhook(ctx, "p1", "failures"); err != nil {
+				t.Fatalf("svc.DeleteWebhook failed: %+v", err)
+			}
+			err = svc.DeleteWebhook(ctx, "p1", "failures")
+			assertServiceErrorCode(t, err, entity.ErrWebhookNotFoundCode)
+		})
+	}
+}
+
+func TestWebhookDeliveryRetry(t *testing.T) {
+	srv := newFakeSMTPServer(t)
+	api := newFakeAPIServer(t)
+	api.SetStatus(http.StatusInternalServerError)
+	svc := newTestService(t, service.WithWebhookRetryPolicy(service.RetryPolicy{
+		MaxAttempts:    2,
+		InitialBackoff: time.Millisecond,
+	}))
+	setupQueueProject(t, svc, srv)
+	ctx := context.Background()
+
+	if _, err := svc.CreateWebhook(ctx, entity.CreateWebhookParams{
+		ID:        "wh",
+		ProjectID: "p1",
+		URL:       api.URL,
+		Secret:    "secret",
+		Events:    []entity.WebhookEvent{entity.WebhookEventQueued},
+	}); err != nil {
+		t.Fatalf("svc.CreateWebhook failed: %+v", err)
+	}
+	queueTestEmail(t, svc)
+
+	for i := 0; i < 2; i++ {
+		time.Sleep(5 * time.Millisecond)
+		n, err := svc.ProcessWebhookDeliveries(ctx)
+		if err != nil {
+			t.Fatalf("svc.ProcessWebhookDeliveries failed: %+v", err)
+		}
+		assert.Equal(t, 0, n)
+
+		deliveries, err := svc.ListWebhookDeliveries(ctx, "p1", "wh")
+		if err != nil {
+			t.Fatalf("svc.ListWebhookDeliveries failed: %+v", err)
+		}
+		if assert.Len(t, deliveries, 1) {
+			d := deliveries[0]
+			assert.Equal(t, i+1, d.Attempts)
+			assert.Equal(t, http.StatusInternalServerError, d.ResponseCode)
+			assert.Contains(t, d.LastError, "500")
+			if i == 0 {
+				assert.Equal(t, entity.WebhookDeliveryStatePending, d.State)
+			} else {
+				assert.Equal(t, entity.WebhookDeliveryStateFailed, d.State)
+			}
+		}
+	}
+
+	// both attempts carry the same event id
+	reqs := api.Requests()
+	if assert.Len(t, reqs, 2) {
+		assert.Equal(t, reqs[0].Body, reqs[1].Body)
+	}
+}
+
+func TestCreateWebhookValidation(t *testing.T) {
+	srv := newFakeSMTPServer(t)
+	svc := newTestService(t)
+	setupQueueProject(t, svc, srv)
+	ctx := context.Background()
+
+	params := entity.CreateWebhookParams{
+		ID:        "wh",
+		ProjectID: "p1",
+		URL:       "https://hooks.example.com/mail",
+		Secret:    "secret",
+	}
+	if _, err := svc.CreateWebhook(ctx, params); err != nil {
+		t.Fatalf("svc.CreateWebhook failed: %+v", err)
+	}
+	_, err := svc.CreateWebhook(ctx, params)
+	assertServiceErrorCode(t, err, entity.ErrWebhookAlreadyExistsCode)
+
+	tests := []struct {
+		name   string
+		modify func(p *entity.CreateWebhookParams)
+		code   entity.ErrCode
+	}{
+		{"plain http", func(p *entity.CreateWebhookParams) { p.URL = "http://hooks.example.com" }, entity.ErrInvalidWebhookCode},
+		{"no secret", func(p *entity.CreateWebhookParams) { p.Secret = "" }, entity.ErrInvalidWebhookCode},
+		{"unknown event", func(p *entity.CreateWebhookParams) {
+			p.Events = []entity.WebhookEvent{"mail.opened"}
+		}, entity.ErrInvalidWebhookCode},
+		{"invalid id", func(p *entity.CreateWebhookParams) { p.ID = "has space" }, entity.ErrInvalidIDCode},
+		{"no project", func(p *entity.CreateWebhookParams) { p.ProjectID = "missing" }, entity.ErrProjectNotFoundCode},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := params
+			p.ID = "wh2"
+			tt.modify(&p)
+			_, err := svc.CreateWebhook(ctx, p)
+			assertServiceErrorCode(t, err, tt.code)
+		})
+	}
+}